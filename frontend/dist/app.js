@@ -17,6 +17,15 @@
     var urlProductName = ''; // product name from URL query string, e.g. ?askflow
     var maxUploadSizeMB = 500; // default, will be fetched from server
     var cachedProducts = null; // shared product list cache to avoid duplicate fetches
+    var BASE_PATH = window.ASKFLOW_BASE_PATH || ''; // URL prefix when served under a sub-path
+
+    // Prefix a root-relative URL ("/api/...", "/chat") with BASE_PATH.
+    // Absolute, protocol-relative, data: and already-prefixed URLs are returned unchanged.
+    function appURL(url) {
+        if (!BASE_PATH || typeof url !== 'string' || url.charAt(0) !== '/' || url.charAt(1) === '/') return url;
+        if (url === BASE_PATH || url.indexOf(BASE_PATH + '/') === 0) return url;
+        return BASE_PATH + url;
+    }
 
     // Parse URL query string for product name: ?productName (bare key, no value)
     (function () {
@@ -55,6 +64,9 @@
 
     function getRoute() {
         var path = window.location.pathname || '/';
+        if (BASE_PATH && path.indexOf(BASE_PATH) === 0) {
+            path = path.slice(BASE_PATH.length) || '/';
+        }
         // Normalize: remove trailing slash (except root)
         if (path.length > 1 && path.charAt(path.length - 1) === '/') {
             path = path.slice(0, -1);
@@ -63,13 +75,14 @@
     }
 
     function navigate(route) {
-        window.history.pushState({}, '', route);
+        window.history.pushState({}, '', appURL(route));
         handleRoute();
     }
 
     function showPage(pageId) {
         // Stop document polling when navigating away from admin page
         if (_docPollTimer) { clearTimeout(_docPollTimer); _docPollTimer = null; }
+        stopDocProgressStreams();
         var pages = document.querySelectorAll('.page');
         pages.forEach(function (p) { p.classList.add('hidden'); });
         var target = document.getElementById('page-' + pageId);
@@ -156,6 +169,52 @@
 
     window.addEventListener('popstate', handleRoute);
 
+    // --- CSRF (cookie session mode) ---
+    // In cookie session mode the server sets an httpOnly session cookie plus a
+    // readable askflow_csrf cookie; every mutating request must echo the latter
+    // in the X-CSRF-Token header. Patch fetch/XHR once so all call sites send it.
+
+    function getCSRFToken() {
+        var m = document.cookie.match(/(?:^|;\s*)askflow_csrf=([^;]*)/);
+        return m ? decodeURIComponent(m[1]) : '';
+    }
+
+    function isMutatingMethod(method) {
+        method = (method || 'GET').toUpperCase();
+        return method !== 'GET' && method !== 'HEAD' && method !== 'OPTIONS';
+    }
+
+    (function () {
+        var origFetch = window.fetch;
+        window.fetch = function (url, options) {
+            if (typeof url === 'string') arguments[0] = appURL(url);
+            var csrf = getCSRFToken();
+            if (csrf && options && isMutatingMethod(options.method)) {
+                if (typeof Headers !== 'undefined' && options.headers instanceof Headers) {
+                    options.headers.set('X-CSRF-Token', csrf);
+                } else {
+                    options.headers = options.headers || {};
+                    options.headers['X-CSRF-Token'] = csrf;
+                }
+            }
+            return origFetch.apply(this, arguments);
+        };
+        var origOpen = XMLHttpRequest.prototype.open;
+        var origSend = XMLHttpRequest.prototype.send;
+        XMLHttpRequest.prototype.open = function (method, url) {
+            this._askflowMethod = method;
+            if (typeof url === 'string') arguments[1] = appURL(url);
+            return origOpen.apply(this, arguments);
+        };
+        XMLHttpRequest.prototype.send = function () {
+            var csrf = getCSRFToken();
+            if (csrf && isMutatingMethod(this._askflowMethod)) {
+                this.setRequestHeader('X-CSRF-Token', csrf);
+            }
+            return origSend.apply(this, arguments);
+        };
+    })();
+
     // --- Session Management ---
 
     function getSession() {
@@ -163,7 +222,8 @@
             var data = localStorage.getItem(SESSION_KEY);
             if (!data) return null;
             var session = JSON.parse(data);
-            if (session.expires_at && new Date(session.expires_at) < new Date()) {
+            var end = sessionLifetimeEnd(session);
+            if (end && new Date(end) < new Date()) {
                 clearSession();
                 return null;
             }
@@ -178,6 +238,7 @@
         if (user) {
             localStorage.setItem(USER_KEY, JSON.stringify(user));
         }
+        scheduleRefresh(false);
     }
 
     function clearSession() {
@@ -202,7 +263,8 @@
             var data = localStorage.getItem(ADMIN_SESSION_KEY);
             if (!data) return null;
             var session = JSON.parse(data);
-            if (session.expires_at && new Date(session.expires_at) < new Date()) {
+            var end = sessionLifetimeEnd(session);
+            if (end && new Date(end) < new Date()) {
                 clearAdminSession();
                 return null;
             }
@@ -217,6 +279,7 @@
         if (user) {
             localStorage.setItem(ADMIN_USER_KEY, JSON.stringify(user));
         }
+        scheduleRefresh(true);
     }
 
     function clearAdminSession() {
@@ -234,6 +297,87 @@
         }
     }
 
+    // --- Token Refresh ---
+    // Access tokens are short-lived. Sessions carry a refresh token (in cookie
+    // mode an httpOnly refresh cookie) that is rotated shortly before the access
+    // token expires. Each refresh token works once, so refreshes are serialized.
+
+    var refreshTimers = {};
+    var refreshInFlight = {};
+
+    function sessionLifetimeEnd(session) {
+        return session.refresh_expires_at || session.expires_at;
+    }
+
+    function refreshSession(admin) {
+        var key = admin ? 'admin' : 'user';
+        if (refreshInFlight[key]) return refreshInFlight[key];
+        var session = admin ? getAdminSession() : getSession();
+        if (!session || !session.refresh_expires_at) return Promise.resolve(null);
+        refreshInFlight[key] = fetch('/api/auth/refresh', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ refresh_token: session.refresh_token || '', admin: !!admin })
+        }).then(function (res) {
+            if (res.status === 401) {
+                // Refresh token expired or revoked — the login is over
+                if (admin) clearAdminSession(); else clearSession();
+                return null;
+            }
+            return res.json().then(function (data) {
+                if (!res.ok || !data.session) return null;
+                if (admin) saveAdminSession(data.session); else saveSession(data.session);
+                return data.session;
+            });
+        }).catch(function () {
+            return null;
+        }).then(function (result) {
+            refreshInFlight[key] = null;
+            return result;
+        });
+        return refreshInFlight[key];
+    }
+
+    function scheduleRefresh(admin) {
+        var key = admin ? 'admin' : 'user';
+        clearTimeout(refreshTimers[key]);
+        var session = admin ? getAdminSession() : getSession();
+        if (!session || !session.refresh_expires_at) return;
+        // Refresh about a minute early; jitter keeps several open tabs from
+        // presenting the same refresh token at once.
+        var delay = new Date(session.expires_at) - new Date() - 60000 - Math.random() * 20000;
+        refreshTimers[key] = setTimeout(function () {
+            var current = admin ? getAdminSession() : getSession();
+            if (current && new Date(current.expires_at) - new Date() > 90000) {
+                // Another tab already refreshed
+                scheduleRefresh(admin);
+                return;
+            }
+            refreshSession(admin);
+        }, Math.max(delay, 0));
+    }
+
+    // renewExpiredSessions refreshes sessions whose access token has already
+    // expired (e.g. after the page was closed) before any request uses them.
+    function renewExpiredSessions() {
+        var tasks = [];
+        [false, true].forEach(function (admin) {
+            var session = admin ? getAdminSession() : getSession();
+            if (!session) return;
+            if (session.refresh_expires_at && new Date(session.expires_at) < new Date()) {
+                tasks.push(refreshSession(admin));
+            } else {
+                scheduleRefresh(admin);
+            }
+        });
+        return Promise.all(tasks);
+    }
+
+    window.addEventListener('storage', function (e) {
+        if (e.key === SESSION_KEY) scheduleRefresh(false);
+        if (e.key === ADMIN_SESSION_KEY) scheduleRefresh(true);
+    });
+
     // --- Toast Notifications ---
 
     var toastTimer = null;
@@ -575,7 +719,7 @@
             .then(function (data) {
                 if (statusEl) {
                     statusEl.innerHTML = '<p class="success-text">' + escapeHtml(data.message || i18n.t('verify_success')) + '</p>' +
-                        '<p style="margin-top:1rem;"><a href="/login">' + i18n.t('verify_go_login') + '</a></p>';
+                        '<p style="margin-top:1rem;"><a href="' + appURL('/login') + '">' + i18n.t('verify_go_login') + '</a></p>';
                 }
             })
             .catch(function (err) {
@@ -1290,7 +1434,11 @@
         if (msg.isPending) {
             html += '<span class="pending-icon">⏳</span>';
         }
-        html += renderMarkdown(msg.content);
+        // answer_html is rendered and sanitized by the server
+        html += (msg.html && !msg.isPending) ? msg.html : renderMarkdown(msg.content);
+        if (msg.unverified) {
+            html += '<div class="chat-msg-unverified"><span class="pending-icon">⏳</span>' + escapeHtml(msg.notice || i18n.t('chat_unverified_message')) + '</div>';
+        }
 
         // Display images as photo wall gallery, video/audio as play buttons
         var _mediaTypes = { video:1, mp4:1, avi:1, mkv:1, mov:1, webm:1, mp3:1, wav:1, ogg:1, flac:1 };
@@ -1319,7 +1467,7 @@
                 html += '<div class="chat-gallery-viewport">';
                 for (var gi = 0; gi < images.length; gi++) {
                     html += '<div class="chat-gallery-slide' + (gi === 0 ? ' active' : '') + '">';
-                    html += '<img src="' + escapeHtml(images[gi].url) + '" alt="' + escapeHtml(images[gi].name) + '" loading="lazy" onclick="window.openGalleryFull(this.src)" />';
+                    html += '<img src="' + escapeHtml(appURL(images[gi].url)) + '" alt="' + escapeHtml(images[gi].name) + '" loading="lazy" onclick="window.openGalleryFull(this.src)" />';
                     html += '</div>';
                 }
                 html += '</div>';
@@ -1340,12 +1488,13 @@
             for (var vi = 0; vi < docIds.length; vi++) {
                 var vDocId = docIds[vi];
                 var seg = videoSegments[vDocId];
-                var mediaUrl = '/api/media/' + encodeURIComponent(vDocId) + '?token=' + encodeURIComponent(getChatToken());
+                var mediaUrl = appURL('/api/media/') + encodeURIComponent(vDocId) + '?token=' + encodeURIComponent(getChatToken());
                 var firstStart = seg.times.length > 0 ? seg.times[0].start : 0;
                 var vExt = (seg.name || '').split('.').pop().toLowerCase();
                 var isAudio = (vExt === 'mp3' || vExt === 'wav' || vExt === 'ogg' || vExt === 'flac');
                 var mediaIdx = window._mediaRegistry.length;
-                window._mediaRegistry.push({ url: mediaUrl, isAudio: isAudio, startTime: firstStart, name: seg.name || 'media', segments: seg.times });
+                var trackUrl = appURL('/api/documents/') + encodeURIComponent(vDocId) + '/transcript?token=' + encodeURIComponent(getChatToken());
+                window._mediaRegistry.push({ url: mediaUrl, trackUrl: trackUrl, isAudio: isAudio, startTime: firstStart, name: seg.name || 'media', segments: seg.times });
                 html += '<div class="chat-media-compact">';
                 html += '<button class="chat-media-play-btn" onclick="window.openMediaModal(' + mediaIdx + ')">';
                 html += '<span class="chat-media-play-icon">' + (isAudio ? '🎵' : '▶') + '</span>';
@@ -1376,13 +1525,13 @@
                 var canDownload = msg.allowDownload && src.document_id && src.document_type && downloadableTypes[(src.document_type || '').toLowerCase()];
                 if (canDownload) {
                     var dlToken = getChatToken();
-                    html += '<a class="chat-source-name chat-source-download" href="/api/documents/public-download/' + encodeURIComponent(src.document_id) + '?product_id=' + encodeURIComponent(productId) + '&token=' + encodeURIComponent(dlToken) + '" title="' + i18n.t('chat_source_download') + '">📥 ' + docName + '</a>';
+                    html += '<a class="chat-source-name chat-source-download" href="' + appURL('/api/documents/') + encodeURIComponent(src.document_id) + '/download?product_id=' + encodeURIComponent(productId) + '&token=' + encodeURIComponent(dlToken) + '" title="' + i18n.t('chat_source_download') + '">📥 ' + docName + '</a>';
                 } else {
                     html += '<span class="chat-source-name">' + docName + '</span>';
                 }
                 var srcType = (src.document_type || '').toLowerCase();
                 if (_mediaTypes[srcType] && src.document_id) {
-                    var srcMediaUrl = '/api/media/' + encodeURIComponent(src.document_id) + '?token=' + encodeURIComponent(getChatToken());
+                    var srcMediaUrl = appURL('/api/media/') + encodeURIComponent(src.document_id) + '?token=' + encodeURIComponent(getChatToken());
                     var srcExt = (src.document_name || '').split('.').pop().toLowerCase();
                     var srcIsAudio = (srcExt === 'mp3' || srcExt === 'wav' || srcExt === 'ogg' || srcExt === 'flac');
                     var srcStart = src.start_time || 0;
@@ -1391,7 +1540,8 @@
                         srcSegs.push({ start: src.start_time || 0, end: src.end_time || 0 });
                     }
                     var srcMediaIdx = window._mediaRegistry.length;
-                    window._mediaRegistry.push({ url: srcMediaUrl, isAudio: srcIsAudio, startTime: srcStart, name: src.document_name || 'media', segments: srcSegs });
+                    var srcTrackUrl = appURL('/api/documents/') + encodeURIComponent(src.document_id) + '/transcript?token=' + encodeURIComponent(getChatToken());
+                    window._mediaRegistry.push({ url: srcMediaUrl, trackUrl: srcTrackUrl, isAudio: srcIsAudio, startTime: srcStart, name: src.document_name || 'media', segments: srcSegs });
                     html += '<button class="chat-source-play-btn" onclick="event.stopPropagation();window.openMediaModal(' + srcMediaIdx + ')" title="' + (srcIsAudio ? i18n.t('chat_play_audio') : i18n.t('chat_play_video')) + '">' + (srcIsAudio ? '🎵' : '▶️') + '</button>';
                 }
                 if (src.start_time > 0 || src.end_time > 0) {
@@ -1401,6 +1551,24 @@
                     }
                     html += '<span class="chat-source-time">🕐 ' + timeLabel + '</span>';
                 }
+                var srcLocation = '';
+                if (src.page > 0) {
+                    srcLocation = i18n.t('chat_source_page', { n: src.page });
+                } else if (src.slide > 0) {
+                    srcLocation = i18n.t('chat_source_slide', { n: src.slide });
+                }
+                if (srcLocation && canDownload && src.page > 0 && srcType === 'pdf') {
+                    // Open the PDF in the browser's viewer at the cited page
+                    var pageUrl = appURL('/api/documents/') + encodeURIComponent(src.document_id) + '/download?product_id=' + encodeURIComponent(productId) + '&token=' + encodeURIComponent(getChatToken()) + '&inline=1#page=' + encodeURIComponent(src.page);
+                    html += '<a class="chat-source-location" href="' + pageUrl + '" target="_blank" rel="noopener" title="' + i18n.t('chat_source_open_page') + '">📄 ' + srcLocation + '</a>';
+                } else if (srcLocation) {
+                    html += '<span class="chat-source-location">📄 ' + srcLocation + '</span>';
+                }
+                if (src.document_version) {
+                    // Git commit SHAs are shown abbreviated like git does
+                    var srcVersion = /^[0-9a-f]{40}$/.test(src.document_version) ? src.document_version.substring(0, 7) : src.document_version;
+                    html += '<span class="chat-source-location" title="' + escapeHtml(src.document_version) + '">🔖 ' + escapeHtml(i18n.t('chat_source_version', { v: srcVersion })) + '</span>';
+                }
                 if (src.snippet) {
                     html += '<span class="chat-source-snippet">' + escapeHtml(src.snippet) + '</span>';
                 }
@@ -1461,6 +1629,12 @@
 
         // Add "Not Satisfied" button for non-pending, non-welcome, non-error system answers
         if (!msg.isPending && !msg.isWelcome && !msg.isError && msg.content) {
+            if (msg.queryId && !msg.feedbackSent) {
+                html += '<button class="chat-not-satisfied-btn chat-helpful-btn" onclick="window.handleHelpful(this, ' + i + ')">👍 ' + i18n.t('chat_helpful') + '</button>';
+            }
+            if (msg.queryId && !msg.unverified && msg.sources && msg.sources.length > 0) {
+                html += '<button class="chat-not-satisfied-btn chat-helpful-btn chat-export-btn" onclick="window.handleExportAnswer(this, ' + i + ')">📄 ' + i18n.t('chat_export') + '</button>';
+            }
             html += '<button class="chat-not-satisfied-btn" onclick="window.handleNotSatisfied(this, ' + i + ')">👎 ' + i18n.t('chat_not_satisfied') + '</button>';
         }
 
@@ -1580,7 +1754,12 @@
         if (isAudio) {
             content += '<audio id="' + modalPlayerId + '" controls autoplay preload="auto" class="media-modal-audio"' + (startTime > 0 ? ' onloadedmetadata="this.currentTime=' + startTime + '"' : '') + '><source src="' + escapeHtml(url) + '"></audio>';
         } else {
-            content += '<video id="' + modalPlayerId + '" controls autoplay playsinline preload="auto" class="media-modal-video"' + (startTime > 0 ? ' onloadedmetadata="this.currentTime=' + startTime + '"' : '') + '><source src="' + escapeHtml(url) + '"></video>';
+            content += '<video id="' + modalPlayerId + '" controls autoplay playsinline preload="auto" class="media-modal-video"' + (startTime > 0 ? ' onloadedmetadata="this.currentTime=' + startTime + '"' : '') + '><source src="' + escapeHtml(url) + '">';
+            // Transcript as subtitles; a document without one just has no track
+            if (info.trackUrl) {
+                content += '<track kind="subtitles" default label="' + escapeHtml(i18n.t('chat_media_transcript')) + '" src="' + escapeHtml(info.trackUrl) + '">';
+            }
+            content += '</video>';
         }
         if (segments.length > 0) {
             content += '<div class="media-modal-segments">';
@@ -1801,6 +1980,52 @@
         if (btn) btn.classList.toggle('open');
     };
 
+    // Record answer feedback for retrieval experiments (best effort)
+    function sendQueryFeedback(msg, helpful) {
+        if (!msg || !msg.queryId || msg.feedbackSent) return;
+        msg.feedbackSent = true;
+        fetch('/api/query/feedback', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'Authorization': 'Bearer ' + getChatToken()
+            },
+            body: JSON.stringify({ query_id: msg.queryId, helpful: helpful })
+        }).catch(function () {});
+    }
+
+    window.handleHelpful = function (btn, msgIndex) {
+        sendQueryFeedback(chatMessages[msgIndex], true);
+        btn.disabled = true;
+        btn.textContent = '👍 ' + i18n.t('chat_feedback_thanks');
+    };
+
+    // Download the answer with its sources as a printable HTML document
+    window.handleExportAnswer = function (btn, msgIndex) {
+        var msg = chatMessages[msgIndex];
+        if (!msg || !msg.queryId) return;
+        btn.disabled = true;
+        fetch('/api/query/' + encodeURIComponent(msg.queryId) + '/export', {
+            headers: { 'Authorization': 'Bearer ' + getChatToken() }
+        }).then(function (res) {
+            if (!res.ok) throw new Error('export failed');
+            return res.blob();
+        }).then(function (blob) {
+            var url = URL.createObjectURL(blob);
+            var a = document.createElement('a');
+            a.href = url;
+            a.download = 'answer-' + msg.queryId.slice(0, 8) + '.html';
+            document.body.appendChild(a);
+            a.click();
+            document.body.removeChild(a);
+            URL.revokeObjectURL(url);
+            btn.disabled = false;
+        }).catch(function () {
+            btn.disabled = false;
+            btn.textContent = '📄 ' + i18n.t('chat_export_failed');
+        });
+    };
+
     window.handleNotSatisfied = function (btn, msgIndex) {
         // Find the corresponding user question (the message before this system answer)
         var userMsg = null;
@@ -1832,6 +2057,7 @@
             document.body.removeChild(overlay);
             btn.disabled = true;
             btn.textContent = '...';
+            sendQueryFeedback(chatMessages[msgIndex], false);
 
             var token = getChatToken();
             var reqBody = {
@@ -2015,10 +2241,14 @@
             var msg = {
                 role: 'system',
                 content: data.answer || data.message || i18n.t('chat_no_answer'),
+                html: data.answer_html || '',
                 sources: data.sources || [],
                 isPending: !!data.is_pending,
+                unverified: !!data.unverified,
+                notice: data.unverified ? (data.message || '') : '',
                 allowDownload: !!data.allow_download,
                 debugInfo: data.debug_info || null,
+                queryId: data.query_id || '',
                 timestamp: Date.now()
             };
             if (data.is_pending) {
@@ -2070,12 +2300,15 @@
         options.headers = options.headers || {};
         options.headers['Authorization'] = 'Bearer ' + getAdminToken();
         return fetch(url, options).then(function (res) {
-            if (res.status === 401) {
-                // Session expired or invalid — redirect to login
-                clearAdminSession();
-                showAdminToast(i18n.t('admin_session_expired') || '会话已过期，请重新登录', 'error');
-                setTimeout(function () { navigate(adminLoginRoute || '/admin'); }, 1500);
+            if (res.status === 401 && !options._refreshed) {
+                // Access token expired — rotate it once and retry
+                return refreshSession(true).then(function (session) {
+                    if (!session) return adminFetchExpired(res);
+                    options._refreshed = true;
+                    return adminFetch(url, options);
+                });
             }
+            if (res.status === 401) return adminFetchExpired(res);
             if (res.status === 403 && adminRole === 'anonymous_viewer') {
                 showAdminToast(i18n.t('anonymous_readonly_banner'), 'info');
             }
@@ -2083,6 +2316,14 @@
         });
     }
 
+    function adminFetchExpired(res) {
+        // Session expired or invalid — redirect to login
+        clearAdminSession();
+        showAdminToast(i18n.t('admin_session_expired') || '会话已过期，请重新登录', 'error');
+        setTimeout(function () { navigate(adminLoginRoute || '/admin'); }, 1500);
+        return res;
+    }
+
     function downloadDocument(docId, fileName) {
         adminFetch('/api/documents/' + encodeURIComponent(docId) + '/download')
             .then(function(resp) {
@@ -2574,18 +2815,7 @@
             zone.style.pointerEvents = 'none';
         }
 
-        var token = getAdminToken();
-        var xhr = new XMLHttpRequest();
-        xhr.open('POST', '/api/documents/upload', true);
-        xhr.setRequestHeader('Authorization', 'Bearer ' + token);
-
-        xhr.upload.onprogress = function (e) {
-            if (e.lengthComputable && progressBar) {
-                setProgressBar(progressBar, (e.loaded / e.total) * 90);
-            }
-        };
-
-        xhr.onload = function () {
+        function finishUpload(status, responseText) {
             if (progressBar) setProgressBar(progressBar, 100);
             setTimeout(function () {
                 if (zone) {
@@ -2594,10 +2824,17 @@
                     zone.style.pointerEvents = '';
                 }
             }, 400);
-            if (xhr.status >= 200 && xhr.status < 300) {
+            if (status >= 200 && status < 300) {
                 try {
-                    var resp = JSON.parse(xhr.responseText);
-                    if (resp && resp.status === 'failed') {
+                    var resp = JSON.parse(responseText);
+                    if (resp && resp.batch_id) {
+                        // ZIP archive: one result per file
+                        var summary = i18n.t('admin_doc_archive_result', { total: resp.total, success: resp.success, failed: resp.failed });
+                        var firstFailure = (resp.files || []).filter(function (f) { return f.error; })[0];
+                        if (firstFailure) summary += ' - ' + firstFailure.path + ': ' + firstFailure.error;
+                        if (resp.stopped) summary += ' - ' + resp.stopped;
+                        showAdminToast(summary, resp.failed > 0 || resp.stopped ? 'error' : 'success');
+                    } else if (resp && resp.status === 'failed') {
                         showAdminToast(i18n.t('admin_doc_upload_failed') + (resp.error ? ' - ' + resp.error : ''), 'error');
                     } else if (resp && resp.status === 'processing') {
                         showAdminToast(i18n.t('admin_doc_upload_success') + ' - ' + (i18n.t('admin_doc_status_processing') || '处理中...'), 'info');
@@ -2615,11 +2852,35 @@
             } else {
                 var errMsg = '';
                 try {
-                    var errResp = JSON.parse(xhr.responseText);
+                    var errResp = JSON.parse(responseText);
                     errMsg = errResp.error || errResp.message || '';
                 } catch (e) {}
                 showAdminToast(i18n.t('admin_doc_upload_failed') + (errMsg ? ' - ' + errMsg : ''), 'error');
             }
+        }
+
+        // Large files go through a resumable upload so a dropped connection
+        // only costs the chunk in flight
+        if (file.size > CHUNKED_UPLOAD_THRESHOLD) {
+            uploadFileChunked(file, getDocProductID(), function (fraction) {
+                if (progressBar) setProgressBar(progressBar, fraction * 90);
+            }, finishUpload);
+            return;
+        }
+
+        var token = getAdminToken();
+        var xhr = new XMLHttpRequest();
+        xhr.open('POST', '/api/documents/upload', true);
+        xhr.setRequestHeader('Authorization', 'Bearer ' + token);
+
+        xhr.upload.onprogress = function (e) {
+            if (e.lengthComputable && progressBar) {
+                setProgressBar(progressBar, (e.loaded / e.total) * 90);
+            }
+        };
+
+        xhr.onload = function () {
+            finishUpload(xhr.status, xhr.responseText);
         };
 
         xhr.onerror = function () {
@@ -2634,6 +2895,83 @@
         xhr.send(formData);
     }
 
+    // Files above this size are uploaded in chunks via /api/documents/uploads
+    var CHUNKED_UPLOAD_THRESHOLD = 32 * 1024 * 1024;
+    var CHUNKED_UPLOAD_RETRIES = 5;
+
+    // uploadFileChunked sends file through a resumable upload session. After
+    // a network error it asks the server for the received offset and resumes
+    // from there, up to CHUNKED_UPLOAD_RETRIES times in a row. done receives
+    // the status and body of the final response.
+    function uploadFileChunked(file, productID, progress, done) {
+        var token = getAdminToken();
+        var session = null;
+        var failures = 0;
+
+        function request(method, url, headers, body, onload) {
+            var xhr = new XMLHttpRequest();
+            xhr.open(method, url, true);
+            xhr.setRequestHeader('Authorization', 'Bearer ' + token);
+            Object.keys(headers).forEach(function (k) { xhr.setRequestHeader(k, headers[k]); });
+            if (xhr.upload && method === 'PATCH') {
+                xhr.upload.onprogress = function (e) {
+                    if (e.lengthComputable) progress((session.offset + e.loaded) / file.size);
+                };
+            }
+            xhr.onload = function () { onload(xhr); };
+            xhr.onerror = function () {
+                if (!session || ++failures > CHUNKED_UPLOAD_RETRIES) {
+                    done(0, '');
+                    return;
+                }
+                setTimeout(resume, 1000 * failures);
+            };
+            xhr.send(body);
+        }
+
+        function resume() {
+            request('GET', '/api/documents/uploads/' + session.id, {}, null, function (xhr) {
+                if (xhr.status !== 200) { done(xhr.status, xhr.responseText); return; }
+                session = JSON.parse(xhr.responseText);
+                sendNext();
+            });
+        }
+
+        function sendNext() {
+            progress(session.offset / file.size);
+            if (session.offset >= file.size) {
+                request('POST', '/api/documents/uploads/' + session.id + '/complete', {}, null, function (xhr) {
+                    done(xhr.status, xhr.responseText);
+                });
+                return;
+            }
+            var chunk = file.slice(session.offset, session.offset + session.chunk_size);
+            request('PATCH', '/api/documents/uploads/' + session.id, {
+                'Upload-Offset': String(session.offset),
+                'Content-Type': 'application/offset+octet-stream'
+            }, chunk, function (xhr) {
+                var offset = parseInt(xhr.getResponseHeader('Upload-Offset'), 10);
+                if (xhr.status === 200) {
+                    failures = 0;
+                    session = JSON.parse(xhr.responseText);
+                    sendNext();
+                } else if ((xhr.status === 409 || xhr.status === 400) && !isNaN(offset) && ++failures <= CHUNKED_UPLOAD_RETRIES) {
+                    session.offset = offset;
+                    setTimeout(sendNext, 1000 * failures);
+                } else {
+                    done(xhr.status, xhr.responseText);
+                }
+            });
+        }
+
+        request('POST', '/api/documents/uploads', { 'Content-Type': 'application/json' },
+            JSON.stringify({ file_name: file.name, size: file.size, product_id: productID }), function (xhr) {
+                if (xhr.status !== 201) { done(xhr.status, xhr.responseText); return; }
+                session = JSON.parse(xhr.responseText);
+                sendNext();
+            });
+    }
+
     window.handleAdminURLPreview = function () {
         var input = document.getElementById('admin-url-field');
         var btn = document.getElementById('admin-url-preview-btn');
@@ -2777,6 +3115,8 @@
 
     var _docPollTimer = null;
     var _docListLoading = false;
+    var _docProgressStreams = {}; // doc ID -> AbortController of its progress stream
+    var _docProgress = {}; // doc ID -> latest progress event
 
     function loadDocumentList() {
         if (_docListLoading) return;
@@ -2806,11 +3146,85 @@
     function scheduleDocPoll(docs) {
         if (_docPollTimer) { clearTimeout(_docPollTimer); _docPollTimer = null; }
         var hasProcessing = false;
+        var streaming = !!(window.TextDecoder && window.AbortController);
         for (var i = 0; i < docs.length; i++) {
-            if (docs[i].status === 'processing') { hasProcessing = true; break; }
+            if (docs[i].status !== 'processing') continue;
+            hasProcessing = true;
+            if (streaming) watchDocProgress(docs[i].id);
         }
         if (hasProcessing) {
-            _docPollTimer = setTimeout(function () { loadDocumentList(); }, 2000);
+            // Progress streams reload the list when a document finishes; keep
+            // polling slowly in case a stream is interrupted
+            _docPollTimer = setTimeout(function () { loadDocumentList(); }, streaming ? 10000 : 2000);
+        }
+    }
+
+    // watchDocProgress follows GET /api/documents/{id}/progress and updates the
+    // status badge of the document until it is processed.
+    function watchDocProgress(docId) {
+        if (_docProgressStreams[docId]) return;
+        var ctrl = new AbortController();
+        _docProgressStreams[docId] = ctrl;
+        adminFetch('/api/documents/' + encodeURIComponent(docId) + '/progress', { signal: ctrl.signal })
+            .then(function (res) {
+                if (!res.ok || !res.body) return;
+                var reader = res.body.getReader();
+                var decoder = new TextDecoder();
+                var buffer = '';
+                var currentEvent = '';
+
+                function processChunk(result) {
+                    if (result.done) return;
+                    buffer += decoder.decode(result.value, { stream: true });
+                    var lines = buffer.split('\n');
+                    buffer = lines.pop();
+                    for (var i = 0; i < lines.length; i++) {
+                        var line = lines[i];
+                        if (line.indexOf('event: ') === 0) {
+                            currentEvent = line.substring(7);
+                        } else if (line.indexOf('data: ') === 0) {
+                            var data;
+                            try { data = JSON.parse(line.substring(6)); } catch (e) { continue; }
+                            if (currentEvent === 'done') {
+                                delete _docProgress[docId];
+                                ctrl.abort();
+                                loadDocumentList();
+                                return;
+                            }
+                            _docProgress[docId] = data;
+                            updateDocProgressBadge(docId);
+                        }
+                    }
+                    return reader.read().then(processChunk);
+                }
+                return reader.read().then(processChunk);
+            })
+            .catch(function () {})
+            .finally(function () {
+                if (_docProgressStreams[docId] === ctrl) delete _docProgressStreams[docId];
+            });
+    }
+
+    function stopDocProgressStreams() {
+        for (var id in _docProgressStreams) {
+            if (_docProgressStreams.hasOwnProperty(id)) _docProgressStreams[id].abort();
+        }
+        _docProgressStreams = {};
+    }
+
+    function docProgressText(docId) {
+        var text = i18n.t('admin_doc_status_processing');
+        var p = _docProgress[docId];
+        if (p && p.stage) {
+            text += ' · ' + i18n.t('admin_doc_stage_' + p.stage) + ' ' + (p.percent || 0) + '%';
+        }
+        return text;
+    }
+
+    function updateDocProgressBadge(docId) {
+        var badges = document.querySelectorAll('[data-progress-doc]');
+        for (var i = 0; i < badges.length; i++) {
+            if (badges[i].getAttribute('data-progress-doc') === docId) badges[i].textContent = docProgressText(docId);
         }
     }
 
@@ -2829,6 +3243,11 @@
             var statusClass = 'admin-badge-' + (doc.status || 'processing');
             var statusMap = { processing: i18n.t('admin_doc_status_processing'), success: i18n.t('admin_doc_status_success'), failed: i18n.t('admin_doc_status_failed') };
             var statusText = statusMap[doc.status] || doc.status;
+            var progressAttr = '';
+            if (doc.status === 'processing') {
+                statusText = docProgressText(doc.id);
+                progressAttr = ' data-progress-doc="' + escapeHtml(doc.id) + '"';
+            }
             var timeStr = doc.created_at ? new Date(doc.created_at).toLocaleString(i18n.getLang()) : '-';
             var productName = getProductNameByID(doc.product_id || '');
 
@@ -2845,7 +3264,7 @@
                 '<td>' + nameCell + '</td>' +
                 '<td>' + escapeHtml(productName) + '</td>' +
                 '<td>' + escapeHtml(doc.type || '-') + '</td>' +
-                '<td><span class="admin-badge ' + statusClass + '">' + escapeHtml(statusText) + '</span></td>' +
+                '<td><span class="admin-badge ' + statusClass + '"' + progressAttr + '>' + escapeHtml(statusText) + '</span></td>' +
                 '<td>' + escapeHtml(timeStr) + '</td>' +
                 '<td>';
 
@@ -2854,6 +3273,10 @@
                 html += '<button class="btn-primary btn-sm" style="margin-right:0.25rem" data-doc-id="' + escapeHtml(doc.id) + '" data-doc-name="' + escapeHtml(doc.name || '') + '" onclick="showReviewDialog(this.dataset.docId, this.dataset.docName)">' + i18n.t('admin_doc_review_btn') + '</button>';
             }
 
+            if (doc.status === 'processing') {
+                html += '<button class="btn-secondary btn-sm" style="margin-right:0.25rem" data-doc-id="' + escapeHtml(doc.id) + '" onclick="cancelDocumentProcessing(this.dataset.docId)">' + i18n.t('admin_doc_cancel_btn') + '</button>';
+            }
+
             html += '<button class="btn-danger btn-sm" onclick="showDeleteDialog(\'' + escapeHtml(doc.id) + '\', \'' + escapeHtml(doc.name || '') + '\')">' + i18n.t('admin_doc_delete_btn') + '</button>' +
                 '</td>' +
             '</tr>';
@@ -2861,6 +3284,24 @@
         tbody.innerHTML = html;
     }
 
+    // --- Cancel Processing ---
+
+    window.cancelDocumentProcessing = function (docId) {
+        adminFetch('/api/documents/' + encodeURIComponent(docId) + '/cancel', { method: 'DELETE' })
+            .then(function (res) {
+                return res.json().then(function (data) {
+                    if (!res.ok) throw new Error(data.error || i18n.t('admin_doc_cancel_failed'));
+                    showAdminToast(i18n.t('admin_doc_cancel_success'), 'success');
+                });
+            })
+            .catch(function (err) {
+                showAdminToast(err.message, 'error');
+            })
+            .finally(function () {
+                loadDocumentList();
+            });
+    };
+
     // --- Delete Document ---
 
     window.showDeleteDialog = function (docId, docName) {
@@ -3009,7 +3450,7 @@
             html += '</div>';
 
             if ((seg.type === 'keyframe' || seg.type === 'slide' || seg.type === 'image') && seg.image_url) {
-                html += '<img class="review-keyframe-img" src="' + escapeHtml(seg.image_url) + '" alt="' + escapeHtml(badgeText) + '" loading="lazy" onclick="window.open(this.src, \'_blank\')">';
+                html += '<img class="review-keyframe-img" src="' + escapeHtml(appURL(seg.image_url)) + '" alt="' + escapeHtml(badgeText) + '" loading="lazy" onclick="window.open(this.src, \'_blank\')">';
             }
 
             if (seg.content) {
@@ -3079,21 +3520,36 @@
             } else {
                 html += '<span style="background:#F3F4F6;color:#6B7280;padding:2px 8px;border-radius:4px;font-size:0.8rem;">' + i18n.t('admin_doc_product_public') + '</span>';
             }
+            if (q.status !== 'answered' && q.escalation_level > 0) {
+                html += '<span style="background:#FEF2F2;color:#DC2626;padding:2px 8px;border-radius:4px;font-size:0.8rem;">' + escapeHtml(i18n.t('admin_pending_overdue', { level: q.escalation_level })) + '</span>';
+            }
             html += '</div>';
             html += '<span class="admin-badge ' + statusClass + '">' + escapeHtml(statusText) + '</span>';
             html += '</div>';
             html += '<div class="admin-pending-question">' + escapeHtml(q.question || '') + '</div>';
 
             if (q.image_data) {
-                html += '<div class="admin-pending-image" style="margin:8px 0"><img src="' + escapeHtml(q.image_data) + '" style="max-width:300px;max-height:200px;border-radius:6px;border:1px solid #e0e0e0;cursor:pointer" onclick="window.open(this.src)" alt="' + i18n.t('chat_user_image_alt') + '" /></div>';
+                html += '<div class="admin-pending-image" style="margin:8px 0"><img src="' + escapeHtml(appURL(q.image_data)) + '" style="max-width:300px;max-height:200px;border-radius:6px;border:1px solid #e0e0e0;cursor:pointer" onclick="window.open(this.src)" alt="' + i18n.t('chat_user_image_alt') + '" /></div>';
             }
 
             if (q.answer) {
                 html += '<div class="admin-pending-answer-preview">' + i18n.t('admin_pending_answer_prefix') + ': ' + escapeHtml(q.answer) + '</div>';
             }
 
+            if (q.status !== 'answered' && q.draft_answer) {
+                var draftSources = (q.draft_sources || []).map(function(src) { return src.admin_answer ? i18n.t('admin_pending_draft_admin_answer') : src.document_name; });
+                html += '<div class="admin-pending-answer-preview">' + i18n.t('admin_pending_draft_prefix') + ': ' + escapeHtml(q.draft_answer);
+                if (draftSources.length > 0) {
+                    html += '<div style="color:#6B7280;font-size:0.8rem;margin-top:4px">' + i18n.t('admin_pending_draft_sources') + ': ' + escapeHtml(draftSources.join('、')) + '</div>';
+                }
+                html += '</div>';
+            } else if (q.status !== 'answered' && q.draft_status === 'drafting') {
+                html += '<div class="admin-pending-answer-preview">' + i18n.t('admin_pending_draft_drafting') + '</div>';
+            }
+
             if (q.status !== 'answered') {
-                html += '<button class="btn-primary btn-sm admin-answer-btn" data-id="' + escapeHtml(q.id) + '" data-question="' + escapeHtml(q.question || '') + '" data-image="' + escapeHtml(q.image_data || '') + '">' + i18n.t('admin_pending_answer_btn') + '</button>';
+                html += '<button class="btn-primary btn-sm admin-answer-btn" data-id="' + escapeHtml(q.id) + '" data-question="' + escapeHtml(q.question || '') + '" data-image="' + escapeHtml(q.image_data || '') + '" data-draft="' + escapeHtml(q.draft_answer || '') + '">' + i18n.t('admin_pending_answer_btn') + '</button>';
+                html += ' <button class="btn-secondary btn-sm admin-draft-pending-btn" data-id="' + escapeHtml(q.id) + '">' + i18n.t('admin_pending_draft_btn') + '</button>';
             } else {
                 html += '<button class="btn-secondary btn-sm admin-edit-answer-btn" data-id="' + escapeHtml(q.id) + '" data-question="' + escapeHtml(q.question || '') + '" data-answer="' + escapeHtml(q.answer || '') + '" data-image="' + escapeHtml(q.image_data || '') + '">' + i18n.t('admin_pending_edit_btn') + '</button>';
             }
@@ -3109,11 +3565,30 @@
         for (var j = 0; j < answerBtns.length; j++) {
             (function(btn) {
                 btn.addEventListener('click', function() {
-                    showAnswerDialog(btn.getAttribute('data-id'), btn.getAttribute('data-question'), null, btn.getAttribute('data-image'));
+                    showAnswerDialog(btn.getAttribute('data-id'), btn.getAttribute('data-question'), null, btn.getAttribute('data-image'), btn.getAttribute('data-draft'));
                 });
             })(answerBtns[j]);
         }
 
+        // Bind regenerate draft button clicks
+        var draftBtns = container.querySelectorAll('.admin-draft-pending-btn');
+        for (var d = 0; d < draftBtns.length; d++) {
+            (function(btn) {
+                btn.addEventListener('click', function() {
+                    btn.disabled = true;
+                    adminFetch('/api/pending/' + encodeURIComponent(btn.getAttribute('data-id')) + '/draft', { method: 'POST' })
+                        .then(function(res) {
+                            if (!res.ok) throw new Error(i18n.t('admin_pending_draft_failed'));
+                            loadPendingQuestions();
+                        })
+                        .catch(function(err) {
+                            btn.disabled = false;
+                            showAdminToast(err.message || i18n.t('admin_pending_draft_failed'), 'error');
+                        });
+                });
+            })(draftBtns[d]);
+        }
+
         // Bind delete button clicks
         var deleteBtns = container.querySelectorAll('.admin-delete-pending-btn');
         for (var k = 0; k < deleteBtns.length; k++) {
@@ -3268,7 +3743,7 @@
         xhr.send(formData);
     }
 
-    window.showAnswerDialog = function (questionId, questionText, existingAnswer, imageData) {
+    window.showAnswerDialog = function (questionId, questionText, existingAnswer, imageData, draftAnswer) {
         adminAnswerTargetId = questionId;
         answerIsEdit = !!existingAnswer;
         var textEl = document.getElementById('admin-answer-question-text');
@@ -3294,7 +3769,7 @@
         }
 
         var answerInput = document.getElementById('admin-answer-text');
-        if (answerInput) answerInput.value = existingAnswer || '';
+        if (answerInput) answerInput.value = existingAnswer || draftAnswer || '';
         var urlInput = document.getElementById('admin-answer-url');
         if (urlInput) urlInput.value = '';
         answerImageURLs = [];
@@ -3371,6 +3846,7 @@
                 var admin = cfg.admin || {};
 
                 setVal('cfg-server-port', server.port);
+                setVal('cfg-server-session-mode', server.session_mode || 'bearer');
 
                 setVal('cfg-llm-endpoint', llm.endpoint);
                 setVal('cfg-llm-model', llm.model_name);
@@ -3378,6 +3854,8 @@
                 setPlaceholder('cfg-llm-apikey', llm.api_key ? '***' : i18n.t('admin_settings_not_set'));
                 setVal('cfg-llm-temperature', llm.temperature);
                 setVal('cfg-llm-maxtokens', llm.max_tokens);
+                var icSelect = document.getElementById('cfg-llm-injection-check');
+                if (icSelect) icSelect.value = llm.injection_check ? 'true' : 'false';
 
                 setVal('cfg-emb-endpoint', emb.endpoint);
                 setVal('cfg-emb-model', emb.model_name);
@@ -3394,6 +3872,14 @@
                 if (cpSelect) cpSelect.value = vec.content_priority || 'image_text';
                 var tmSelect = document.getElementById('cfg-vec-text-match');
                 if (tmSelect) tmSelect.value = vec.text_match_enabled === false ? 'false' : 'true';
+                var scSelect = document.getElementById('cfg-vec-semantic-cache');
+                if (scSelect) scSelect.value = vec.semantic_cache_enabled ? 'true' : 'false';
+                setVal('cfg-vec-semantic-cache-threshold', vec.semantic_cache_threshold);
+                var mmrSelect = document.getElementById('cfg-vec-mmr');
+                if (mmrSelect) mmrSelect.value = vec.mmr_enabled ? 'true' : 'false';
+                setVal('cfg-vec-mmr-lambda', vec.mmr_lambda);
+                setVal('cfg-vec-context-window', vec.context_window);
+                setVal('cfg-vec-context-token-budget', vec.context_token_budget);
                 var dbgSelect = document.getElementById('cfg-vec-debug-mode');
                 if (dbgSelect) dbgSelect.value = vec.debug_mode ? 'true' : 'false';
 
@@ -3597,6 +4083,7 @@
         var updates = {};
 
         var serverPort = getVal('cfg-server-port');
+        var serverSessionMode = getVal('cfg-server-session-mode');
 
         var llmEndpoint = getVal('cfg-llm-endpoint');
         var llmModel = getVal('cfg-llm-model');
@@ -3615,10 +4102,13 @@
 
         if (llmEndpoint) updates['llm.endpoint'] = llmEndpoint;
         if (serverPort !== '') updates['server.port'] = parseInt(serverPort, 10);
+        if (serverSessionMode) updates['server.session_mode'] = serverSessionMode;
         if (llmModel) updates['llm.model_name'] = llmModel;
         if (llmApiKey) updates['llm.api_key'] = llmApiKey;
         if (llmTemp !== '') updates['llm.temperature'] = parseFloat(llmTemp);
         if (llmMaxTokens !== '') updates['llm.max_tokens'] = parseInt(llmMaxTokens, 10);
+        var llmInjectionCheck = getVal('cfg-llm-injection-check');
+        updates['llm.injection_check'] = llmInjectionCheck === 'true';
 
         if (embEndpoint) updates['embedding.endpoint'] = embEndpoint;
         if (embModel) updates['embedding.model_name'] = embModel;
@@ -3634,6 +4124,18 @@
         if (vecContentPriority) updates['vector.content_priority'] = vecContentPriority;
         var vecTextMatch = getVal('cfg-vec-text-match');
         updates['vector.text_match_enabled'] = vecTextMatch === 'true';
+        var vecSemanticCache = getVal('cfg-vec-semantic-cache');
+        updates['vector.semantic_cache_enabled'] = vecSemanticCache === 'true';
+        var vecSemanticCacheThreshold = getVal('cfg-vec-semantic-cache-threshold');
+        if (vecSemanticCacheThreshold !== '') updates['vector.semantic_cache_threshold'] = parseFloat(vecSemanticCacheThreshold);
+        var vecMMR = getVal('cfg-vec-mmr');
+        updates['vector.mmr_enabled'] = vecMMR === 'true';
+        var vecMMRLambda = getVal('cfg-vec-mmr-lambda');
+        if (vecMMRLambda !== '') updates['vector.mmr_lambda'] = parseFloat(vecMMRLambda);
+        var vecContextWindow = getVal('cfg-vec-context-window');
+        if (vecContextWindow !== '') updates['vector.context_window'] = parseInt(vecContextWindow, 10);
+        var vecContextTokenBudget = getVal('cfg-vec-context-token-budget');
+        if (vecContextTokenBudget !== '') updates['vector.context_token_budget'] = parseInt(vecContextTokenBudget, 10);
         var vecDebugMode = getVal('cfg-vec-debug-mode');
         updates['vector.debug_mode'] = vecDebugMode === 'true';
 
@@ -4220,7 +4722,7 @@
                     '<div class="admin-form-row"><label>Client Secret</label><input type="password" id="oauth-' + name + '-client-secret" value="" placeholder="' + (p.client_secret ? '***' : 'Client Secret') + '"></div>' +
                     '<div class="admin-form-row"><label>Auth URL</label><input type="text" id="oauth-' + name + '-auth-url" value="' + escapeAttr(p.auth_url || '') + '" placeholder="Authorization URL"></div>' +
                     '<div class="admin-form-row"><label>Token URL</label><input type="text" id="oauth-' + name + '-token-url" value="' + escapeAttr(p.token_url || '') + '" placeholder="Token URL"></div>' +
-                    '<div class="admin-form-row"><label>Redirect URL</label><input type="text" id="oauth-' + name + '-redirect-url" value="' + escapeAttr(p.redirect_url || '') + '" placeholder="' + window.location.origin + '/oauth/callback"></div>' +
+                    '<div class="admin-form-row"><label>Redirect URL</label><input type="text" id="oauth-' + name + '-redirect-url" value="' + escapeAttr(p.redirect_url || '') + '" placeholder="' + window.location.origin + appURL('/oauth/callback') + '"></div>' +
                     '<div class="admin-form-row"><label>Scopes</label><input type="text" id="oauth-' + name + '-scopes" value="' + escapeAttr((p.scopes || []).join(',')) + '" placeholder="openid,email,profile"></div>' +
                 '</div>';
             container.appendChild(card);
@@ -4247,7 +4749,7 @@
             client_secret: '',
             auth_url: defaults.auth_url || '',
             token_url: defaults.token_url || '',
-            redirect_url: window.location.origin + '/oauth/callback',
+            redirect_url: window.location.origin + appURL('/oauth/callback'),
             scopes: (defaults.scopes || '').split(',')
         };
         // Append to existing
@@ -4272,7 +4774,7 @@
                 '<div class="admin-form-row"><label>Client Secret</label><input type="password" id="oauth-' + name + '-client-secret" value="" placeholder="Client Secret"></div>' +
                 '<div class="admin-form-row"><label>Auth URL</label><input type="text" id="oauth-' + name + '-auth-url" value="' + escapeAttr(p.auth_url || '') + '" placeholder="Authorization URL"></div>' +
                 '<div class="admin-form-row"><label>Token URL</label><input type="text" id="oauth-' + name + '-token-url" value="' + escapeAttr(p.token_url || '') + '" placeholder="Token URL"></div>' +
-                '<div class="admin-form-row"><label>Redirect URL</label><input type="text" id="oauth-' + name + '-redirect-url" value="' + escapeAttr(p.redirect_url || '') + '" placeholder="' + window.location.origin + '/oauth/callback"></div>' +
+                '<div class="admin-form-row"><label>Redirect URL</label><input type="text" id="oauth-' + name + '-redirect-url" value="' + escapeAttr(p.redirect_url || '') + '" placeholder="' + window.location.origin + appURL('/oauth/callback') + '"></div>' +
                 '<div class="admin-form-row"><label>Scopes</label><input type="text" id="oauth-' + name + '-scopes" value="' + escapeAttr((p.scopes || []).join(',')) + '" placeholder="openid,email,profile"></div>' +
             '</div>';
         container.appendChild(card);
@@ -4367,13 +4869,71 @@
         .then(function (data) {
             if (data.session && data.user) {
                 saveSession(data.session, { id: data.user.id, email: data.user.email, name: data.user.name, provider: data.user.provider });
-                window.history.replaceState({}, '', '/chat');
+                window.history.replaceState({}, '', appURL('/chat'));
                 handleRoute();
             }
         })
         .catch(function (err) {
             showToast(err.message || i18n.t('login_oauth_failed'), 'error');
-            window.history.replaceState({}, '', '/login');
+            window.history.replaceState({}, '', appURL('/login'));
+            handleRoute();
+        });
+        return true;
+    }
+
+    // --- Enterprise SSO (OIDC / SAML) ---
+
+    function renderSSOLoginButtons(providers) {
+        var container = document.getElementById('oauth-login-buttons');
+        var divider = document.getElementById('oauth-login-divider');
+        if (!container || !providers || providers.length === 0) return;
+        providers.forEach(function (p) {
+            var btn = document.createElement('button');
+            btn.className = 'oauth-btn oauth-sso';
+            btn.innerHTML = '<span>' + i18n.t('login_oauth_with') + ' ' + escapeHtml(p.display_name || p.name) + '</span>';
+            btn.onclick = function () {
+                window.location.href = appURL('/api/sso/login') + '?type=' + encodeURIComponent(p.type) + '&provider=' + encodeURIComponent(p.name);
+            };
+            container.appendChild(btn);
+        });
+        container.classList.remove('hidden');
+        if (divider) divider.classList.remove('hidden');
+    }
+
+    // Handle SSO callback: the backend redirects to /?sso_code=xxx after the
+    // IdP login; exchange the one-time code for a user or admin session.
+    function handleSSOCallbackFromURL() {
+        var params = new URLSearchParams(window.location.search);
+        var code = params.get('sso_code');
+        if (!code) return false;
+
+        window.history.replaceState({}, '', appURL('/'));
+
+        fetch('/api/sso/exchange', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ code: code })
+        })
+        .then(function (res) {
+            if (!res.ok) return res.json().then(function (d) { throw new Error(d.error || 'SSO login failed'); });
+            return res.json();
+        })
+        .then(function (data) {
+            if (!data.session || !data.user) throw new Error('SSO login failed');
+            if (data.admin) {
+                saveAdminSession(data.session, { username: data.user.email || data.user.name, provider: 'admin' });
+                if (data.role) localStorage.setItem('admin_role', data.role);
+                window.history.replaceState({}, '', appURL('/admin-panel'));
+            } else {
+                saveSession(data.session, { id: data.session.user_id, email: data.user.email, name: data.user.name, provider: data.user.type });
+                fetchProducts();
+                window.history.replaceState({}, '', appURL('/chat'));
+            }
+            handleRoute();
+        })
+        .catch(function (err) {
+            showToast(err.message || i18n.t('login_oauth_failed'), 'error');
+            window.history.replaceState({}, '', appURL('/login'));
             handleRoute();
         });
         return true;
@@ -4388,7 +4948,7 @@
         if (!ticket) return false;
 
         // Clean the URL immediately so the ticket isn't visible/reusable
-        window.history.replaceState({}, '', '/');
+        window.history.replaceState({}, '', appURL('/'));
 
         fetch('/api/auth/ticket-exchange', {
             method: 'POST',
@@ -4403,12 +4963,12 @@
             if (data.session && data.user) {
                 saveSession(data.session, { id: data.user.id, email: data.user.email, name: data.user.name, provider: data.user.provider });
                 fetchProducts();
-                window.history.replaceState({}, '', '/chat');
+                window.history.replaceState({}, '', appURL('/chat'));
                 handleRoute();
             }
         })
         .catch(function (err) {
-            window.history.replaceState({}, '', '/login?error=ticket_failed');
+            window.history.replaceState({}, '', appURL('/login?error=ticket_failed'));
             handleRoute();
         });
         return true;
@@ -5189,6 +5749,13 @@
     // --- Logout ---
 
     window.logout = function () {
+        var session = getSession();
+        var token = session ? session.id || session.session_id || '' : '';
+        // Revoke the session server-side (and clear the cookie in cookie mode)
+        fetch('/api/auth/logout', {
+            method: 'POST',
+            headers: { 'Authorization': 'Bearer ' + token }
+        }).catch(function () {});
         chatMessages = [];
         chatLoading = false;
         localStorage.removeItem('askflow_product_id');
@@ -5236,6 +5803,10 @@
     };
 
     window.adminLogout = function () {
+        fetch('/api/admin/logout', {
+            method: 'POST',
+            headers: { 'Authorization': 'Bearer ' + getAdminToken() }
+        }).catch(function () {});
         adminRole = '';
         adminPermissions = [];
         localStorage.removeItem('admin_role');
@@ -5252,6 +5823,9 @@
         // Check for ticket-login callback (SN login via desktop app)
         if (handleTicketLoginFromURL()) return;
 
+        // Check for enterprise SSO callback
+        if (handleSSOCallbackFromURL()) return;
+
         // Pre-warm product cache early (used by login page and chat page)
         fetchProducts();
 
@@ -5262,6 +5836,9 @@
                 if (data.oauth_providers) {
                     renderOAuthLoginButtons(data.oauth_providers);
                 }
+                if (data.sso_providers) {
+                    renderSSOLoginButtons(data.sso_providers);
+                }
                 if (data.max_upload_size_mb) {
                     maxUploadSizeMB = data.max_upload_size_mb;
                 }
@@ -5290,7 +5867,10 @@
             })
             .catch(function () { /* use default */ });
 
-        Promise.all([p1, p2]).then(function () {
+        // Renew sessions whose access token expired while the page was closed
+        var p3 = renewExpiredSessions();
+
+        Promise.all([p1, p2, p3]).then(function () {
             handleRoute();
             // Fetch translated product name in background (LLM call, can be slow)
             fetchProductName();
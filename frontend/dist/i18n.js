@@ -127,6 +127,7 @@
             'chat_request_failed': '请求失败',
             'chat_no_answer': '暂无回答',
             'chat_pending_message': '该问题已转交人工处理，请稍后查看回复',
+            'chat_unverified_message': '该回答未经人工核实，问题已同时转交人工处理',
             'chat_error_prefix': '抱歉，请求出错：',
             'chat_error_suffix': '。请稍后重试。',
             'chat_error_unknown': '未知错误',
@@ -137,9 +138,18 @@
             'chat_source_unknown': '未知文档',
             'chat_source_image': '📷 图片来源',
             'chat_source_download': '点击下载文档',
+            'chat_media_transcript': '转录',
+            'chat_source_page': '第 {n} 页',
+            'chat_source_slide': '第 {n} 张幻灯片',
+            'chat_source_version': '版本 {v}',
+            'chat_source_open_page': '在原文中打开此页',
             'chat_media_seek_hint': '点击跳转到该时间点',
             'chat_play_audio': '播放音频',
             'chat_play_video': '播放视频',
+            'chat_helpful': '有帮助',
+            'chat_feedback_thanks': '感谢反馈',
+            'chat_export': '导出文档',
+            'chat_export_failed': '导出失败，请重试',
             'chat_not_satisfied': '建议补充资料',
             'chat_not_satisfied_confirm': '确认将此问题转为待回答问题？',
             'chat_not_satisfied_confirm_yes': '确认',
@@ -189,7 +199,7 @@
             // Admin - documents
             'admin_doc_title': '文档管理',
             'admin_doc_drop_text': '拖拽文件到此处，或点击选择文件',
-            'admin_doc_drop_hint': '支持 PDF、Word、Excel、PPT、Markdown、视频格式',
+            'admin_doc_drop_hint': '支持 PDF、Word、Excel、PPT、Markdown、视频格式及 ZIP 压缩包',
             'admin_doc_url_placeholder': '输入文档URL地址',
             'admin_doc_url_submit': '提交URL',
             'admin_doc_url_preview': '预览内容',
@@ -210,9 +220,16 @@
             'admin_doc_product_public': '公共区',
             'admin_doc_empty': '暂无文档',
             'admin_doc_status_processing': '处理中',
+            'admin_doc_stage_parse': '解析',
+            'admin_doc_stage_chunk': '分块',
+            'admin_doc_stage_embed': '向量化',
+            'admin_doc_stage_store': '存储',
             'admin_doc_status_success': '成功',
             'admin_doc_status_failed': '失败',
             'admin_doc_delete_btn': '删除',
+            'admin_doc_cancel_btn': '取消处理',
+            'admin_doc_cancel_success': '已取消处理',
+            'admin_doc_cancel_failed': '取消处理失败',
             'admin_doc_review_btn': '审看',
             'admin_doc_review_title': '文档分析审看',
             'admin_doc_review_loading': '正在加载分析结果...',
@@ -228,6 +245,7 @@
             'admin_doc_uploading': '正在上传 {name}...',
             'admin_doc_upload_success': '文件上传成功',
             'admin_doc_upload_stats': '导入完成：{chars} 字，{images} 张图片',
+            'admin_doc_archive_result': '压缩包导入完成：共 {total} 个文件，成功 {success} 个，失败 {failed} 个',
             'admin_doc_upload_failed': '上传失败',
             'admin_doc_url_empty': '请输入URL地址',
             'admin_doc_url_submitting': '正在提交URL...',
@@ -249,10 +267,17 @@
             'admin_pending_title': '问题管理',
             'admin_pending_filter_all': '全部',
             'admin_pending_filter_pending': '待回答',
+            'admin_pending_overdue': '已超时（第 {level} 次提醒）',
             'admin_pending_filter_answered': '已回答',
             'admin_pending_empty': '暂无问题',
             'admin_pending_user': '用户',
             'admin_pending_answer_prefix': '回答',
+            'admin_pending_draft_prefix': '回答草稿',
+            'admin_pending_draft_sources': '参考',
+            'admin_pending_draft_admin_answer': '管理员历史回答',
+            'admin_pending_draft_drafting': '正在生成回答草稿…',
+            'admin_pending_draft_btn': '重新生成草稿',
+            'admin_pending_draft_failed': '生成回答草稿失败',
             'admin_pending_answer_btn': '回答',
             'admin_pending_edit_btn': '编辑',
             'admin_pending_delete_btn': '删除',
@@ -278,6 +303,10 @@
             'admin_settings_http_port': 'HTTP 端口',
             'admin_settings_port_hint': '修改端口后需重启服务才能生效',
             'admin_settings_restart': '重启服务',
+            'admin_settings_session_mode': '会话模式',
+            'admin_settings_session_mode_bearer': 'Bearer 令牌（localStorage）',
+            'admin_settings_session_mode_cookie': 'httpOnly Cookie + CSRF 令牌',
+            'admin_settings_session_mode_hint': 'Cookie 模式下会话令牌不暴露给页面脚本；新模式对之后的登录生效',
             'admin_settings_restart_confirm': '确定要重启服务吗？重启期间服务将短暂不可用。',
             'admin_settings_restarting': '服务正在重启，请稍候刷新页面...',
             'admin_settings_restart_failed': '重启失败',
@@ -314,6 +343,24 @@
             'admin_settings_text_match_on': '开启（优先文本匹配，节省 API 费用）',
             'admin_settings_text_match_off': '关闭（始终使用完整 RAG 流程）',
             'admin_settings_text_match_hint': '开启后查询三级处理：1级纯文本匹配（免费）→ 2级向量确认缓存复用（仅嵌入费用）→ 3级完整RAG（嵌入+LLM费用）',
+            'admin_settings_injection_check': '提示词注入检测',
+            'admin_settings_injection_check_off': '仅规则匹配',
+            'admin_settings_injection_check_on': '规则匹配 + LLM 检测',
+            'admin_settings_injection_check_hint': '文档入库时检查是否含有试图操纵问答机器人的内容，可疑文档进入内容审核队列；LLM 检测会逐段调用 LLM，增加导入耗时与费用',
+            'admin_settings_semantic_cache': '语义缓存',
+            'admin_settings_semantic_cache_off': '关闭',
+            'admin_settings_semantic_cache_on': '开启（相似问题直接返回已有回答）',
+            'admin_settings_semantic_cache_hint': '与近期已回答问题的向量相似度达到阈值时直接返回该回答及引用来源，不调用 LLM；文档变更后缓存自动失效',
+            'admin_settings_semantic_cache_threshold': '语义缓存相似度阈值',
+            'admin_settings_mmr': '结果多样化（MMR）',
+            'admin_settings_mmr_off': '关闭（仅按相似度排序）',
+            'admin_settings_mmr_on': '开启（避免近似重复的片段）',
+            'admin_settings_mmr_hint': '按最大边际相关性挑选检索片段，让交给大模型的上下文覆盖更多互补内容',
+            'admin_settings_mmr_lambda': 'MMR 相关性权重',
+            'admin_settings_mmr_lambda_hint': '0–1 之间，越小越偏向多样性，1 等同于仅按相似度排序',
+            'admin_settings_context_window': '上下文扩展片段数',
+            'admin_settings_context_window_hint': '每个命中片段前后各附带的相邻片段数，0 为关闭',
+            'admin_settings_context_token_budget': '上下文 Token 上限',
             'admin_settings_debug_mode': '调试模式',
             'admin_settings_debug_off': '关闭',
             'admin_settings_debug_on': '开启（查询结果附带诊断信息）',
@@ -771,6 +818,7 @@
             'chat_request_failed': 'Request failed',
             'chat_no_answer': 'No answer available',
             'chat_pending_message': 'This question has been forwarded to support staff, please check back later',
+            'chat_unverified_message': 'This answer has not been verified; the question has also been forwarded to support staff',
             'chat_error_prefix': 'Sorry, an error occurred: ',
             'chat_error_suffix': '. Please try again later.',
             'chat_error_unknown': 'Unknown error',
@@ -781,9 +829,18 @@
             'chat_source_unknown': 'Unknown document',
             'chat_source_image': '📷 Image source',
             'chat_source_download': 'Click to download document',
+            'chat_media_transcript': 'Transcript',
+            'chat_source_page': 'Page {n}',
+            'chat_source_slide': 'Slide {n}',
+            'chat_source_version': 'Version {v}',
+            'chat_source_open_page': 'Open the document at this page',
             'chat_media_seek_hint': 'Click to seek to this time',
             'chat_play_audio': 'Play audio',
             'chat_play_video': 'Play video',
+            'chat_helpful': 'Helpful',
+            'chat_feedback_thanks': 'Thanks for your feedback',
+            'chat_export': 'Export',
+            'chat_export_failed': 'Export failed, try again',
             'chat_not_satisfied': 'Not Satisfied',
             'chat_not_satisfied_confirm': 'Convert this question to a pending question for manual review?',
             'chat_not_satisfied_confirm_yes': 'Confirm',
@@ -833,7 +890,7 @@
             // Admin - documents
             'admin_doc_title': 'Document Management',
             'admin_doc_drop_text': 'Drag files here, or click to select',
-            'admin_doc_drop_hint': 'Supports PDF, Word, Excel, PPT, Markdown, Video',
+            'admin_doc_drop_hint': 'Supports PDF, Word, Excel, PPT, Markdown, Video and ZIP archives',
             'admin_doc_url_placeholder': 'Enter document URL',
             'admin_doc_url_submit': 'Submit URL',
             'admin_doc_url_preview': 'Preview Content',
@@ -854,9 +911,16 @@
             'admin_doc_product_public': 'Public Library',
             'admin_doc_empty': 'No documents',
             'admin_doc_status_processing': 'Processing',
+            'admin_doc_stage_parse': 'parsing',
+            'admin_doc_stage_chunk': 'chunking',
+            'admin_doc_stage_embed': 'embedding',
+            'admin_doc_stage_store': 'storing',
             'admin_doc_status_success': 'Success',
             'admin_doc_status_failed': 'Failed',
             'admin_doc_delete_btn': 'Delete',
+            'admin_doc_cancel_btn': 'Cancel',
+            'admin_doc_cancel_success': 'Processing canceled',
+            'admin_doc_cancel_failed': 'Failed to cancel processing',
             'admin_doc_review_btn': 'Review',
             'admin_doc_review_title': 'Document Analysis Review',
             'admin_doc_review_loading': 'Loading analysis results...',
@@ -872,6 +936,7 @@
             'admin_doc_uploading': 'Uploading {name}...',
             'admin_doc_upload_success': 'File uploaded successfully',
             'admin_doc_upload_stats': 'Import complete: {chars} chars, {images} images',
+            'admin_doc_archive_result': 'Archive imported: {total} files, {success} succeeded, {failed} failed',
             'admin_doc_upload_failed': 'Upload failed',
             'admin_doc_url_empty': 'Please enter a URL',
             'admin_doc_url_submitting': 'Submitting URL...',
@@ -893,10 +958,17 @@
             'admin_pending_title': 'Question Management',
             'admin_pending_filter_all': 'All',
             'admin_pending_filter_pending': 'Pending',
+            'admin_pending_overdue': 'Overdue (reminder {level})',
             'admin_pending_filter_answered': 'Answered',
             'admin_pending_empty': 'No questions',
             'admin_pending_user': 'User',
             'admin_pending_answer_prefix': 'Answer',
+            'admin_pending_draft_prefix': 'Suggested answer',
+            'admin_pending_draft_sources': 'Based on',
+            'admin_pending_draft_admin_answer': 'Earlier admin answer',
+            'admin_pending_draft_drafting': 'Drafting a suggested answer…',
+            'admin_pending_draft_btn': 'Regenerate draft',
+            'admin_pending_draft_failed': 'Failed to draft an answer',
             'admin_pending_answer_btn': 'Answer',
             'admin_pending_edit_btn': 'Edit',
             'admin_pending_delete_btn': 'Delete',
@@ -922,6 +994,10 @@
             'admin_settings_http_port': 'HTTP Port',
             'admin_settings_port_hint': 'Server restart required after changing port',
             'admin_settings_restart': 'Restart Server',
+            'admin_settings_session_mode': 'Session Mode',
+            'admin_settings_session_mode_bearer': 'Bearer token (localStorage)',
+            'admin_settings_session_mode_cookie': 'httpOnly cookie + CSRF token',
+            'admin_settings_session_mode_hint': 'In cookie mode the session token is not readable by page scripts; applies to subsequent logins',
             'admin_settings_restart_confirm': 'Are you sure you want to restart the server? Service will be briefly unavailable.',
             'admin_settings_restarting': 'Server is restarting, please refresh shortly...',
             'admin_settings_restart_failed': 'Restart failed',
@@ -958,6 +1034,24 @@
             'admin_settings_text_match_on': 'On (prefer text matching, save API costs)',
             'admin_settings_text_match_off': 'Off (always use full RAG pipeline)',
             'admin_settings_text_match_hint': 'When enabled, queries go through 3 levels: L1 text match (free) → L2 vector confirm + cached answer (embedding only) → L3 full RAG (embedding + LLM)',
+            'admin_settings_injection_check': 'Prompt Injection Check',
+            'admin_settings_injection_check_off': 'Pattern matching only',
+            'admin_settings_injection_check_on': 'Pattern matching + LLM check',
+            'admin_settings_injection_check_hint': 'Imported documents are checked for content that tries to manipulate the assistant, and suspicious ones are added to the moderation queue. The LLM check calls the LLM for every few chunks, which makes imports slower and costs more',
+            'admin_settings_semantic_cache': 'Semantic Cache',
+            'admin_settings_semantic_cache_off': 'Off',
+            'admin_settings_semantic_cache_on': 'On (answer similar questions from earlier answers)',
+            'admin_settings_semantic_cache_hint': 'When a question is at least this similar to a recently answered one, its answer and sources are returned without calling the LLM; the cache is cleared when documents change',
+            'admin_settings_semantic_cache_threshold': 'Semantic Cache Similarity Threshold',
+            'admin_settings_mmr': 'Result Diversification (MMR)',
+            'admin_settings_mmr_off': 'Off (rank by similarity only)',
+            'admin_settings_mmr_on': 'On (avoid near-duplicate chunks)',
+            'admin_settings_mmr_hint': 'Pick retrieved chunks by Maximal Marginal Relevance so the context given to the LLM covers more complementary content',
+            'admin_settings_mmr_lambda': 'MMR Relevance Weight',
+            'admin_settings_mmr_lambda_hint': 'Between 0 and 1; lower favors diversity, 1 is plain similarity ranking',
+            'admin_settings_context_window': 'Context Expansion Chunks',
+            'admin_settings_context_window_hint': 'Neighboring chunks added on each side of a matched chunk; 0 disables',
+            'admin_settings_context_token_budget': 'Context Token Budget',
             'admin_settings_debug_mode': 'Debug Mode',
             'admin_settings_debug_off': 'Off',
             'admin_settings_debug_on': 'On (query results include diagnostics)',
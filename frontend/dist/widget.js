@@ -0,0 +1,142 @@
+/**
+ * Askflow embeddable chat widget.
+ *
+ * Usage:
+ *   <script src="https://helpdesk.example.com/api/widget.js" data-product-id="<product id>" async></script>
+ *
+ * The embedding page's origin must be added to the product's widget origin
+ * allowlist (PUT /api/products/{id}/widget-origins) before the widget can talk
+ * to the server.
+ */
+(function () {
+  'use strict';
+
+  var script = document.currentScript;
+  if (!script) return;
+  var productId = script.getAttribute('data-product-id') || '';
+  var title = script.getAttribute('data-title') || '在线客服';
+  // Keep any sub-path the server is mounted under (server.base_path)
+  var scriptURL = new URL(script.src);
+  var baseURL = scriptURL.origin + scriptURL.pathname.replace(/\/api\/widget\.js$/, '');
+  var storageKey = 'askflow_widget_token_' + productId;
+
+  function getToken() {
+    try { return localStorage.getItem(storageKey) || ''; } catch (e) { return ''; }
+  }
+
+  function setToken(token) {
+    try { localStorage.setItem(storageKey, token); } catch (e) { /* storage disabled */ }
+  }
+
+  function api(path, body, token) {
+    var headers = { 'Content-Type': 'application/json' };
+    if (token) headers['Authorization'] = 'Bearer ' + token;
+    return fetch(baseURL + path + '?product_id=' + encodeURIComponent(productId), {
+      method: 'POST',
+      headers: headers,
+      body: JSON.stringify(body || {})
+    }).then(function (res) {
+      return res.json().catch(function () { return {}; }).then(function (data) {
+        return { status: res.status, data: data };
+      });
+    });
+  }
+
+  function ensureToken(forceNew) {
+    var token = forceNew ? '' : getToken();
+    if (token) return Promise.resolve(token);
+    return api('/api/widget/session').then(function (r) {
+      if (r.status !== 200 || !r.data.token) throw new Error(r.data.error || '无法连接客服');
+      setToken(r.data.token);
+      return r.data.token;
+    });
+  }
+
+  function ask(question, retried) {
+    return ensureToken(retried).then(function (token) {
+      return api('/api/widget/query', { question: question }, token);
+    }).then(function (r) {
+      if (r.status === 401 && !retried) return ask(question, true);
+      if (r.status !== 200) throw new Error(r.data.error || '请求失败');
+      return r.data;
+    });
+  }
+
+  // ── UI ──
+  var root = document.createElement('div');
+  root.style.cssText = 'position:fixed;right:20px;bottom:20px;z-index:2147483000;font:14px/1.5 sans-serif;';
+
+  var button = document.createElement('button');
+  button.type = 'button';
+  button.textContent = title;
+  button.style.cssText = 'padding:10px 16px;border:none;border-radius:20px;background:#2563eb;color:#fff;cursor:pointer;box-shadow:0 2px 8px rgba(0,0,0,.2);';
+
+  var panel = document.createElement('div');
+  panel.style.cssText = 'display:none;flex-direction:column;width:340px;height:460px;margin-bottom:10px;background:#fff;border-radius:10px;box-shadow:0 4px 16px rgba(0,0,0,.2);overflow:hidden;';
+
+  var header = document.createElement('div');
+  header.textContent = title;
+  header.style.cssText = 'padding:10px 14px;background:#2563eb;color:#fff;font-weight:bold;';
+
+  var messages = document.createElement('div');
+  messages.style.cssText = 'flex:1;padding:10px;overflow-y:auto;background:#f8fafc;';
+
+  var form = document.createElement('form');
+  form.style.cssText = 'display:flex;border-top:1px solid #e5e7eb;';
+  var input = document.createElement('input');
+  input.type = 'text';
+  input.maxLength = 2000;
+  input.placeholder = '请输入您的问题';
+  input.style.cssText = 'flex:1;padding:10px;border:none;outline:none;';
+  var send = document.createElement('button');
+  send.type = 'submit';
+  send.textContent = '发送';
+  send.style.cssText = 'padding:0 14px;border:none;background:#2563eb;color:#fff;cursor:pointer;';
+  form.appendChild(input);
+  form.appendChild(send);
+
+  panel.appendChild(header);
+  panel.appendChild(messages);
+  panel.appendChild(form);
+  root.appendChild(panel);
+  root.appendChild(button);
+
+  function addMessage(text, fromUser) {
+    var item = document.createElement('div');
+    item.textContent = text;
+    item.style.cssText = 'margin:6px 0;padding:8px 10px;border-radius:8px;white-space:pre-wrap;word-break:break-word;max-width:85%;' +
+      (fromUser ? 'margin-left:auto;background:#2563eb;color:#fff;' : 'background:#fff;border:1px solid #e5e7eb;color:#111827;');
+    messages.appendChild(item);
+    messages.scrollTop = messages.scrollHeight;
+    return item;
+  }
+
+  button.addEventListener('click', function () {
+    var open = panel.style.display === 'flex';
+    panel.style.display = open ? 'none' : 'flex';
+    if (!open) input.focus();
+  });
+
+  form.addEventListener('submit', function (e) {
+    e.preventDefault();
+    var question = input.value.trim();
+    if (!question) return;
+    input.value = '';
+    addMessage(question, true);
+    var reply = addMessage('…', false);
+    send.disabled = true;
+    ask(question, false).then(function (data) {
+      reply.textContent = data.answer || data.message || '';
+    }).catch(function (err) {
+      reply.textContent = err.message || '请求失败';
+    }).then(function () {
+      send.disabled = false;
+    });
+  });
+
+  if (document.body) {
+    document.body.appendChild(root);
+  } else {
+    document.addEventListener('DOMContentLoaded', function () { document.body.appendChild(root); });
+  }
+})();
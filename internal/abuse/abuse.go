@@ -0,0 +1,507 @@
+// Package abuse blocks abusive networks from the API. Besides the per-user
+// and per-IP rate limits and the login lockouts, it applies CIDR range bans
+// managed by admins, AS number deny lists and GeoIP country allow/deny
+// lists, and reports the networks with the most failed logins and
+// rate-limited requests so whole ranges can be blocked at once.
+package abuse
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"askflow/internal/config"
+)
+
+// Networks are grouped by these prefix lengths in offender reports: a /24
+// is what an attacker typically rotates addresses in, and a /48 is the
+// usual IPv6 site allocation.
+const (
+	ipv4ReportPrefix = 24
+	ipv6ReportPrefix = 48
+)
+
+// Widest ranges that can be banned, to keep a typo from blocking a large
+// part of the internet.
+const (
+	minIPv4BanPrefix = 8
+	minIPv6BanPrefix = 24
+)
+
+const (
+	banReloadInterval = time.Minute
+	offenseWindow     = 24 * time.Hour
+	maxOffenseEntries = 10000
+	maxOffenseAddrs   = 256
+)
+
+var (
+	// ErrBanNotFound is returned by RemoveBan when the ban does not exist.
+	ErrBanNotFound = errors.New("network ban not found")
+	// ErrInvalidNetwork is returned for a malformed address or CIDR range.
+	ErrInvalidNetwork = errors.New("invalid IP address or CIDR range")
+	// ErrNetworkTooLarge is returned for ranges wider than /8 or /24 (IPv6).
+	ErrNetworkTooLarge = errors.New("network range too large")
+	// ErrNoASNDatabase is returned by TopNetworks when grouping by AS
+	// without a loaded ASN database.
+	ErrNoASNDatabase = errors.New("ASN database not configured or still loading")
+)
+
+// BlockedError reports that a client address is blocked.
+type BlockedError struct {
+	Reason string
+}
+
+func (e *BlockedError) Error() string {
+	return e.Reason
+}
+
+// Ban is a CIDR range banned from the API.
+type Ban struct {
+	ID        int64     `json:"id"`
+	CIDR      string    `json:"cidr"`
+	Reason    string    `json:"reason"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	UnlocksAt time.Time `json:"unlocks_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NetworkStat is a network (or AS) in the offender report.
+type NetworkStat struct {
+	Network       string `json:"network"` // CIDR, or "AS<n>" when grouped by AS
+	Addresses     int    `json:"addresses"`
+	LoginFailures int    `json:"login_failures"`
+	RateLimited   int    `json:"rate_limited"`
+	Country       string `json:"country,omitempty"`
+	ASN           string `json:"asn,omitempty"`
+	ASOrg         string `json:"as_org,omitempty"`
+	Blocked       bool   `json:"blocked"`
+}
+
+// cachedBan is a parsed active ban.
+type cachedBan struct {
+	prefix    netip.Prefix
+	reason    string
+	unlocksAt time.Time
+}
+
+// dbState is a lazily loaded IP database.
+type dbState struct {
+	path    string
+	db      *ipDB
+	loading bool
+}
+
+// offense counts rate-limited requests of one address in the current window.
+type offense struct {
+	count int
+	last  time.Time
+}
+
+// Guard checks client addresses against bans and network rules.
+type Guard struct {
+	readDB   *sql.DB
+	writeDB  *sql.DB
+	settings func() config.AbuseConfig
+
+	mu       sync.RWMutex
+	bans     []cachedBan
+	loadedAt time.Time
+
+	dbMu sync.Mutex
+	geo  dbState
+	asn  dbState
+
+	offMu    sync.Mutex
+	offenses map[netip.Addr]*offense
+}
+
+// NewGuard creates a Guard. settings returns the current abuse.* config.
+func NewGuard(readDB, writeDB *sql.DB, settings func() config.AbuseConfig) *Guard {
+	return &Guard{
+		readDB:   readDB,
+		writeDB:  writeDB,
+		settings: settings,
+		offenses: make(map[netip.Addr]*offense),
+	}
+}
+
+// Check returns a *BlockedError when ip is in a banned range, belongs to a
+// denied AS or is located in a country that is denied or not allowed.
+// Addresses that cannot be parsed, private addresses and addresses missing
+// from the IP databases are only checked against bans.
+func (g *Guard) Check(ip string) error {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil
+	}
+	addr = addr.Unmap()
+
+	now := time.Now()
+	for _, b := range g.activeBans() {
+		if b.prefix.Contains(addr) && now.Before(b.unlocksAt) {
+			reason := "您所在的网络已被禁止访问"
+			if b.reason != "" {
+				reason += "：" + b.reason
+			}
+			return &BlockedError{Reason: reason}
+		}
+	}
+
+	if addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+		return nil
+	}
+	cfg := g.settings()
+	if len(cfg.DenyASNs) > 0 {
+		if r, ok := g.database(&g.asn, cfg.ASNDatabase).lookup(addr); ok {
+			if slices.Contains(cfg.DenyASNs, normalizeASN(r.value)) {
+				return &BlockedError{Reason: "您所在的网络已被禁止访问"}
+			}
+		}
+	}
+	if len(cfg.DenyCountries) > 0 || len(cfg.AllowCountries) > 0 {
+		if r, ok := g.database(&g.geo, cfg.GeoIPDatabase).lookup(addr); ok && r.value != "" {
+			if slices.Contains(cfg.DenyCountries, r.value) ||
+				(len(cfg.AllowCountries) > 0 && !slices.Contains(cfg.AllowCountries, r.value)) {
+				return &BlockedError{Reason: "您所在的地区暂不提供服务"}
+			}
+		}
+	}
+	return nil
+}
+
+// Country returns the ISO country code of ip from the GeoIP database, or ""
+// when there is no database or the address is not in it.
+func (g *Guard) Country(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	if r, ok := g.database(&g.geo, g.settings().GeoIPDatabase).lookup(addr.Unmap()); ok {
+		return r.value
+	}
+	return ""
+}
+
+// activeBans returns the cached active bans, reloading them when stale.
+func (g *Guard) activeBans() []cachedBan {
+	g.mu.RLock()
+	bans, fresh := g.bans, time.Since(g.loadedAt) < banReloadInterval
+	g.mu.RUnlock()
+	if fresh {
+		return bans
+	}
+	if err := g.reloadBans(); err != nil {
+		log.Printf("[Abuse] failed to load network bans: %v", err)
+		// Keep the previous list and retry after the interval
+		g.mu.Lock()
+		g.loadedAt = time.Now()
+		g.mu.Unlock()
+		return bans
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.bans
+}
+
+// reloadBans loads the active bans into the cache.
+func (g *Guard) reloadBans() error {
+	active, err := g.ListBans()
+	if err != nil {
+		return err
+	}
+	bans := make([]cachedBan, 0, len(active))
+	for _, b := range active {
+		prefix, err := netip.ParsePrefix(b.CIDR)
+		if err != nil {
+			continue
+		}
+		bans = append(bans, cachedBan{prefix: prefix, reason: b.Reason, unlocksAt: b.UnlocksAt})
+	}
+	g.mu.Lock()
+	g.bans, g.loadedAt = bans, time.Now()
+	g.mu.Unlock()
+	return nil
+}
+
+// database returns the IP database at path, starting to load it in the
+// background when the path changed. It returns nil while loading and when
+// the file cannot be read; the file is read again only when the configured
+// path changes.
+func (g *Guard) database(st *dbState, path string) *ipDB {
+	g.dbMu.Lock()
+	defer g.dbMu.Unlock()
+	if path == "" {
+		st.path, st.db = "", nil
+		return nil
+	}
+	if st.path == path {
+		return st.db
+	}
+	if !st.loading {
+		st.loading = true
+		go func() {
+			db, err := loadIPDB(path)
+			if err != nil {
+				log.Printf("[Abuse] %v", err)
+			} else {
+				log.Printf("[Abuse] loaded %d ranges from %s", len(db.ranges), path)
+			}
+			g.dbMu.Lock()
+			st.path, st.db, st.loading = path, db, false
+			g.dbMu.Unlock()
+		}()
+	}
+	return nil
+}
+
+// ParseNetwork parses a CIDR range or a single address (as /32 or /128)
+// and returns it in canonical form. Ranges wider than /8 (IPv4) or /24
+// (IPv6) are rejected.
+func ParseNetwork(s string) (netip.Prefix, error) {
+	var prefix netip.Prefix
+	if addr, err := netip.ParseAddr(s); err == nil {
+		addr = addr.Unmap()
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	} else if prefix, err = netip.ParsePrefix(s); err != nil {
+		return netip.Prefix{}, ErrInvalidNetwork
+	}
+	if prefix.Addr().Is4In6() {
+		if prefix.Bits() < 96 {
+			return netip.Prefix{}, ErrInvalidNetwork
+		}
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	prefix = prefix.Masked()
+	if (prefix.Addr().Is4() && prefix.Bits() < minIPv4BanPrefix) || (prefix.Addr().Is6() && prefix.Bits() < minIPv6BanPrefix) {
+		return netip.Prefix{}, ErrNetworkTooLarge
+	}
+	return prefix, nil
+}
+
+// AddBan bans a network, given as a CIDR range or single address, for
+// duration.
+func (g *Guard) AddBan(network, reason, createdBy string, duration time.Duration) (*Ban, error) {
+	prefix, err := ParseNetwork(network)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	b := &Ban{
+		CIDR:      prefix.String(),
+		Reason:    reason,
+		CreatedBy: createdBy,
+		UnlocksAt: now.Add(duration),
+		CreatedAt: now,
+	}
+	res, err := g.writeDB.Exec(
+		`INSERT INTO network_bans (cidr, reason, created_by, unlocks_at, created_at) VALUES (?, ?, ?, ?, ?)`,
+		b.CIDR, b.Reason, b.CreatedBy, b.UnlocksAt.Format(time.RFC3339), b.CreatedAt.Format(time.RFC3339),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add network ban: %w", err)
+	}
+	b.ID, _ = res.LastInsertId()
+	if err := g.reloadBans(); err != nil {
+		log.Printf("[Abuse] failed to reload network bans: %v", err)
+	}
+	return b, nil
+}
+
+// RemoveBan lifts a network ban.
+func (g *Guard) RemoveBan(id int64) error {
+	res, err := g.writeDB.Exec(`DELETE FROM network_bans WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to remove network ban: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrBanNotFound
+	}
+	if err := g.reloadBans(); err != nil {
+		log.Printf("[Abuse] failed to reload network bans: %v", err)
+	}
+	return nil
+}
+
+// ListBans returns the active network bans, newest first.
+func (g *Guard) ListBans() ([]Ban, error) {
+	rows, err := g.readDB.Query(
+		`SELECT id, cidr, reason, created_by, unlocks_at, created_at FROM network_bans
+		WHERE unlocks_at > ? ORDER BY id DESC`,
+		time.Now().UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list network bans: %w", err)
+	}
+	defer rows.Close()
+	var bans []Ban
+	for rows.Next() {
+		var b Ban
+		var unlocks, created string
+		if err := rows.Scan(&b.ID, &b.CIDR, &b.Reason, &b.CreatedBy, &unlocks, &created); err != nil {
+			return nil, fmt.Errorf("failed to scan network ban: %w", err)
+		}
+		b.UnlocksAt, _ = time.Parse(time.RFC3339, unlocks)
+		b.CreatedAt, _ = time.Parse(time.RFC3339, created)
+		bans = append(bans, b)
+	}
+	return bans, rows.Err()
+}
+
+// RecordRateLimited counts a rate-limited request from ip for the offender
+// report. Counts are kept in memory for 24 hours.
+func (g *Guard) RecordRateLimited(ip string) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return
+	}
+	addr = addr.Unmap()
+	now := time.Now()
+	g.offMu.Lock()
+	defer g.offMu.Unlock()
+	o := g.offenses[addr]
+	if o == nil {
+		if len(g.offenses) >= maxOffenseEntries {
+			g.pruneOffensesLocked(now)
+			if len(g.offenses) >= maxOffenseEntries {
+				return
+			}
+		}
+		o = &offense{}
+		g.offenses[addr] = o
+	}
+	o.count++
+	o.last = now
+}
+
+// pruneOffensesLocked drops addresses not rate limited within the window.
+func (g *Guard) pruneOffensesLocked(now time.Time) {
+	for addr, o := range g.offenses {
+		if now.Sub(o.last) > offenseWindow {
+			delete(g.offenses, addr)
+		}
+	}
+}
+
+// reportNetwork returns the network addr is grouped under in reports.
+func reportNetwork(addr netip.Addr) netip.Prefix {
+	bits := ipv6ReportPrefix
+	if addr.Is4() {
+		bits = ipv4ReportPrefix
+	}
+	p, _ := addr.Prefix(bits)
+	return p
+}
+
+// TopNetworks reports the networks with the most failed logins since
+// since plus rate-limited requests in the last 24 hours, worst first. With
+// byASN set, networks are grouped by AS number instead of address range,
+// which needs abuse.asn_database; addresses missing from it are left out.
+func (g *Guard) TopNetworks(since time.Time, byASN bool, limit int) ([]NetworkStat, error) {
+	type tally struct {
+		stat  NetworkStat
+		addrs map[netip.Addr]struct{}
+		probe netip.Addr
+	}
+	cfg := g.settings()
+	asnDB := g.database(&g.asn, cfg.ASNDatabase)
+	geoDB := g.database(&g.geo, cfg.GeoIPDatabase)
+	if byASN && asnDB == nil {
+		return nil, ErrNoASNDatabase
+	}
+
+	tallies := make(map[string]*tally)
+	add := func(addr netip.Addr, failures, limited int) {
+		key := reportNetwork(addr).String()
+		if byASN {
+			r, ok := asnDB.lookup(addr)
+			if !ok {
+				return
+			}
+			key = normalizeASN(r.value)
+		}
+		t := tallies[key]
+		if t == nil {
+			t = &tally{stat: NetworkStat{Network: key}, addrs: make(map[netip.Addr]struct{}), probe: addr}
+			tallies[key] = t
+		}
+		if len(t.addrs) < maxOffenseAddrs {
+			t.addrs[addr] = struct{}{}
+		}
+		t.stat.LoginFailures += failures
+		t.stat.RateLimited += limited
+	}
+
+	rows, err := g.readDB.Query(
+		`SELECT ip, COUNT(*) FROM login_attempts WHERE success = 0 AND ip != '' AND created_at >= ? GROUP BY ip`,
+		since.UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query login attempts: %w", err)
+	}
+	for rows.Next() {
+		var ip string
+		var n int
+		if err := rows.Scan(&ip, &n); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan login attempts: %w", err)
+		}
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			add(addr.Unmap(), n, 0)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query login attempts: %w", err)
+	}
+
+	now := time.Now()
+	g.offMu.Lock()
+	g.pruneOffensesLocked(now)
+	for addr, o := range g.offenses {
+		add(addr, 0, o.count)
+	}
+	g.offMu.Unlock()
+
+	bans := g.activeBans()
+	stats := make([]NetworkStat, 0, len(tallies))
+	for _, t := range tallies {
+		s := t.stat
+		s.Addresses = len(t.addrs)
+		if r, ok := geoDB.lookup(t.probe); ok {
+			s.Country = r.value
+		}
+		if r, ok := asnDB.lookup(t.probe); ok {
+			s.ASN, s.ASOrg = normalizeASN(r.value), r.org
+		}
+		if byASN {
+			s.Blocked = slices.Contains(cfg.DenyASNs, s.Network)
+		} else {
+			network := reportNetwork(t.probe)
+			for _, b := range bans {
+				if b.prefix.Bits() <= network.Bits() && b.prefix.Contains(network.Addr()) {
+					s.Blocked = true
+					break
+				}
+			}
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i].LoginFailures+stats[i].RateLimited, stats[j].LoginFailures+stats[j].RateLimited
+		if a != b {
+			return a > b
+		}
+		return stats[i].Network < stats[j].Network
+	})
+	if limit > 0 && len(stats) > limit {
+		stats = stats[:limit]
+	}
+	return stats, nil
+}
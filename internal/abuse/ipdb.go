@@ -0,0 +1,97 @@
+package abuse
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ipRange is one row of an IP database: the addresses start..end map to
+// value (a country code or AS number) and, for ASN databases, org.
+type ipRange struct {
+	start, end netip.Addr
+	value, org string
+}
+
+// ipDB is an IP range database loaded from a CSV file of
+// start_ip,end_ip,value[,org] rows, sorted by start address.
+type ipDB struct {
+	ranges []ipRange
+}
+
+// loadIPDB reads an IP range CSV file. Rows that do not start with an IP
+// address, such as a header line, are skipped.
+func loadIPDB(path string) (*ipDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open IP database: %w", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+	db := &ipDB{}
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read IP database: %w", err)
+		}
+		if len(rec) < 3 {
+			continue
+		}
+		start, err1 := netip.ParseAddr(strings.TrimSpace(rec[0]))
+		end, err2 := netip.ParseAddr(strings.TrimSpace(rec[1]))
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		start, end = start.Unmap(), end.Unmap()
+		if start.BitLen() != end.BitLen() || end.Less(start) {
+			continue
+		}
+		row := ipRange{start: start, end: end, value: strings.TrimSpace(rec[2])}
+		if len(rec) > 3 {
+			row.org = strings.TrimSpace(rec[3])
+		}
+		db.ranges = append(db.ranges, row)
+	}
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return db.ranges[i].start.Less(db.ranges[j].start)
+	})
+	return db, nil
+}
+
+// lookup returns the range containing addr.
+func (db *ipDB) lookup(addr netip.Addr) (ipRange, bool) {
+	if db == nil {
+		return ipRange{}, false
+	}
+	addr = addr.Unmap()
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return addr.Less(db.ranges[i].start)
+	}) - 1
+	if i < 0 {
+		return ipRange{}, false
+	}
+	r := db.ranges[i]
+	if r.start.BitLen() != addr.BitLen() || r.end.Less(addr) {
+		return ipRange{}, false
+	}
+	return r, true
+}
+
+// normalizeASN returns an AS number as "AS<n>", accepting "13335" or "AS13335".
+func normalizeASN(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+	return "AS" + strings.TrimPrefix(strings.ToUpper(s), "AS")
+}
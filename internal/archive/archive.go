@@ -0,0 +1,176 @@
+// Package archive expands uploaded ZIP archives into the files they contain,
+// including the files of ZIP archives nested in them. Archives are read in
+// memory and nothing is written to disk; entry paths are still checked so a
+// crafted name (absolute, or escaping with "..") never reaches a document
+// name or storage key. Count and size limits guard against archive bombs.
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// MaxDepth is how deep nested archives are expanded.
+const MaxDepth = 3
+
+var (
+	// ErrInvalid is returned for data that is not a readable ZIP archive.
+	ErrInvalid = errors.New("invalid zip archive")
+	// ErrTooManyFiles is returned when an archive holds more files than
+	// allowed.
+	ErrTooManyFiles = errors.New("zip archive contains too many files")
+	// ErrTooLarge is returned when the expanded files exceed the total size
+	// limit.
+	ErrTooLarge = errors.New("zip archive expands beyond the size limit")
+
+	// errFileTooLarge is returned for a file whose header understates its
+	// size.
+	errFileTooLarge = errors.New("file exceeds the size limit")
+)
+
+// Limits bound the expansion of an archive.
+type Limits struct {
+	MaxFiles     int   // files expanded, nested archives included
+	MaxFileSize  int64 // uncompressed size of one file
+	MaxTotalSize int64 // uncompressed size of all files
+}
+
+// Entry is a file found in an archive. Path is its slash-separated path,
+// prefixed by the paths of the archives it is nested in. Skipped explains
+// why the file was not expanded, in which case Data is nil.
+type Entry struct {
+	Path    string
+	Data    []byte
+	Skipped string
+}
+
+// IsZip reports whether fileName has the .zip extension.
+func IsZip(fileName string) bool {
+	return strings.HasSuffix(strings.ToLower(fileName), ".zip")
+}
+
+// Walk calls fn for every file in the archive data, in archive order, with
+// nested archives expanded in place. Directories and operating system
+// metadata (__MACOSX/, .DS_Store and other hidden files) are left out. Walk
+// stops with ErrTooManyFiles or ErrTooLarge when a limit is exceeded, after
+// calling fn for the files within it.
+func Walk(data []byte, limits Limits, fn func(Entry)) error {
+	w := &walker{limits: limits, fn: fn}
+	return w.walk(data, "", 0)
+}
+
+type walker struct {
+	limits Limits
+	fn     func(Entry)
+	files  int
+	total  int64
+}
+
+func (w *walker) walk(data []byte, prefix string, depth int) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		name, ok := cleanName(f.Name)
+		if !ok {
+			w.fn(Entry{Path: prefix + f.Name, Skipped: "文件路径不安全"})
+			continue
+		}
+		if hidden(name) {
+			continue
+		}
+		entryPath := prefix + name
+		if w.files++; w.files > w.limits.MaxFiles {
+			return ErrTooManyFiles
+		}
+		if f.UncompressedSize64 > uint64(w.limits.MaxFileSize) {
+			w.fn(Entry{Path: entryPath, Skipped: "文件过大"})
+			continue
+		}
+		content, err := w.read(f)
+		if errors.Is(err, ErrTooLarge) {
+			return err
+		}
+		if errors.Is(err, errFileTooLarge) {
+			w.fn(Entry{Path: entryPath, Skipped: "文件过大"})
+			continue
+		}
+		if err != nil {
+			w.fn(Entry{Path: entryPath, Skipped: "无法解压该文件"})
+			continue
+		}
+		if IsZip(name) {
+			if depth+1 >= MaxDepth {
+				w.fn(Entry{Path: entryPath, Skipped: "压缩包嵌套层级过深"})
+				continue
+			}
+			// The nested archive counts as a file, its contents count as well
+			if err := w.walk(content, entryPath+"/", depth+1); err != nil {
+				if errors.Is(err, ErrInvalid) {
+					w.fn(Entry{Path: entryPath, Skipped: "无法解压该文件"})
+					continue
+				}
+				return err
+			}
+			continue
+		}
+		w.fn(Entry{Path: entryPath, Data: content})
+	}
+	return nil
+}
+
+// read decompresses f without trusting the sizes in its header.
+func (w *walker) read(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	limit := min(w.limits.MaxFileSize, w.limits.MaxTotalSize-w.total)
+	data, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		if limit < w.limits.MaxFileSize {
+			return nil, ErrTooLarge
+		}
+		return nil, errFileTooLarge
+	}
+	w.total += int64(len(data))
+	return data, nil
+}
+
+// cleanName normalizes an entry name and rejects names that are absolute or
+// escape the archive root.
+func cleanName(name string) (string, bool) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || (len(name) > 1 && name[1] == ':') {
+		return "", false
+	}
+	clean := path.Clean(name)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", false
+	}
+	return clean, true
+}
+
+// hidden reports whether a path is operating system metadata, such as the
+// __MACOSX folder macOS adds to archives, rather than a user's file.
+func hidden(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if part == "__MACOSX" || strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+// Package atrest encrypts confidential data kept on disk or in a bucket —
+// chunk text in the database and original files and images in blob
+// storage — with AES-256-GCM, so that a copied data directory or bucket
+// does not expose the documents. Reading always decrypts what was
+// encrypted and passes plain data through, so encryption can be turned on
+// or off while older data is rewritten in the background.
+package atrest
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// textPrefix marks encrypted chunk text: textPrefix + base64(nonce||ciphertext).
+const textPrefix = "enc1:"
+
+// blobMagic starts an encrypted object: blobMagic, nonce, ciphertext.
+var blobMagic = []byte("AFENC\x00\x01\x00")
+
+// ErrDecrypt is returned for encrypted data that the key cannot open, e.g.
+// data encrypted with another key or tampered with.
+var ErrDecrypt = errors.New("cannot decrypt data encrypted at rest")
+
+// Cipher encrypts and decrypts data at rest with one key, and decrypts
+// with the previous key during a key rotation (see Accept). It is safe for
+// concurrent use.
+type Cipher struct {
+	aead     cipher.AEAD
+	nonceKey []byte
+	seal     bool
+	previous *Cipher // decrypts data encrypted before a key rotation
+}
+
+// New returns a Cipher for a 32-byte key. With seal false it only decrypts:
+// Encode and Seal return their input unchanged.
+func New(key []byte, seal bool) (*Cipher, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("at-rest key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("atrest:nonce"))
+	return &Cipher{aead: aead, nonceKey: mac.Sum(nil), seal: seal}, nil
+}
+
+// Accept makes c also decrypt data encrypted with the 32-byte key that a
+// key rotation replaced. It must be called before c is used.
+func (c *Cipher) Accept(previous []byte) error {
+	p, err := New(previous, false)
+	if err != nil {
+		return err
+	}
+	c.previous = p
+	return nil
+}
+
+// Sealing reports whether the Cipher encrypts new data.
+func (c *Cipher) Sealing() bool {
+	return c.seal
+}
+
+// Encode encrypts chunk text. The nonce is derived from the text, so the
+// same text always encrypts to the same value and stored chunks can still
+// be looked up by their text; this reveals which chunks are identical, but
+// nothing of their content.
+func (c *Cipher) Encode(text string) (string, error) {
+	if !c.seal || text == "" {
+		return text, nil
+	}
+	mac := hmac.New(sha256.New, c.nonceKey)
+	mac.Write([]byte(text))
+	nonce := mac.Sum(nil)[:c.aead.NonceSize()]
+	sealed := c.aead.Seal(nonce, nonce, []byte(text), nil)
+	return textPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decode returns the text of a value returned by Encode, or stored as is.
+func (c *Cipher) Decode(stored string) (string, error) {
+	if !strings.HasPrefix(stored, textPrefix) {
+		return stored, nil
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(stored[len(textPrefix):])
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	plain, err := c.open(sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// Seal encrypts an object for blob storage with a random nonce.
+func (c *Cipher) Seal(data []byte) ([]byte, error) {
+	if !c.seal {
+		return data, nil
+	}
+	n := c.aead.NonceSize()
+	out := make([]byte, len(blobMagic)+n, len(blobMagic)+n+len(data)+c.aead.Overhead())
+	copy(out, blobMagic)
+	nonce := out[len(blobMagic):]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(out, nonce, data, nil), nil
+}
+
+// Open returns the content of an object returned by Seal, or stored as is.
+func (c *Cipher) Open(data []byte) ([]byte, error) {
+	if !Sealed(data) {
+		return data, nil
+	}
+	return c.open(data[len(blobMagic):])
+}
+
+// Sealed reports whether an object was encrypted by Seal.
+func Sealed(data []byte) bool {
+	return bytes.HasPrefix(data, blobMagic)
+}
+
+// Stale reports whether a stored object is not stored as Seal would store
+// it now: plain while sealing, sealed while not, or sealed with the
+// previous key.
+func (c *Cipher) Stale(data []byte) bool {
+	if !Sealed(data) {
+		return c.seal
+	}
+	if !c.seal {
+		return true
+	}
+	_, err := c.openWith(data[len(blobMagic):])
+	return err != nil
+}
+
+func (c *Cipher) open(sealed []byte) ([]byte, error) {
+	plain, err := c.openWith(sealed)
+	if err != nil && c.previous != nil {
+		return c.previous.open(sealed)
+	}
+	return plain, err
+}
+
+// openWith decrypts with c's own key only.
+func (c *Cipher) openWith(sealed []byte) ([]byte, error) {
+	n := c.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, ErrDecrypt
+	}
+	plain, err := c.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
+
+// A marker file records the form all data at rest was last rewritten to,
+// so that it is only rewritten again after encryption is turned on or off
+// or the key changes.
+
+// state describes how c stores data: "plain", or "sealed" and an ID of the
+// key.
+func (c *Cipher) state() string {
+	if !c.seal {
+		return "plain"
+	}
+	mac := hmac.New(sha256.New, c.nonceKey)
+	mac.Write([]byte("atrest:key-id"))
+	return "sealed " + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// Current reports whether the marker file says all data is stored as c
+// stores it. Without a marker nothing was ever encrypted.
+func (c *Cipher) Current(marker string) bool {
+	b, err := os.ReadFile(marker)
+	if errors.Is(err, os.ErrNotExist) {
+		return !c.seal
+	}
+	return err == nil && strings.TrimSpace(string(b)) == c.state()
+}
+
+// Mark records in the marker file that data is being rewritten, or with
+// done that all data is stored as c stores it.
+func (c *Cipher) Mark(marker string, done bool) error {
+	state := "rewriting"
+	if done {
+		state = c.state()
+	}
+	return os.WriteFile(marker, []byte(state+"\n"), 0600)
+}
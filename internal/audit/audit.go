@@ -0,0 +1,235 @@
+// Package audit records admin mutations (who changed what, from where, and
+// the before/after state of the affected resource) and original document
+// downloads for compliance review.
+package audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Entry is a single audit log record.
+type Entry struct {
+	ID         int64     `json:"id"`
+	ActorID    string    `json:"actor_id"`
+	ActorName  string    `json:"actor_name"`
+	ActorRole  string    `json:"actor_role"`
+	IP         string    `json:"ip"`
+	Action     string    `json:"action"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	ResourceID string    `json:"resource_id,omitempty"`
+	Status     int       `json:"status"`
+	Before     string    `json:"before,omitempty"` // JSON of changed fields before the mutation
+	After      string    `json:"after,omitempty"`  // JSON of changed fields after the mutation
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Filter selects audit entries. Zero values are ignored.
+type Filter struct {
+	ActorID    string
+	Action     string // prefix match, e.g. "product" matches "product.update"
+	ResourceID string
+	From       time.Time
+	To         time.Time
+	Page       int
+	PageSize   int
+}
+
+// ListResult is a page of audit entries.
+type ListResult struct {
+	Entries  []Entry `json:"entries"`
+	Total    int     `json:"total"`
+	Page     int     `json:"page"`
+	PageSize int     `json:"page_size"`
+}
+
+// Service stores and queries audit entries.
+type Service struct {
+	readDB  *sql.DB
+	writeDB *sql.DB
+}
+
+// NewService creates a new audit Service with separate read and write database connections.
+func NewService(readDB, writeDB *sql.DB) *Service {
+	return &Service{readDB: readDB, writeDB: writeDB}
+}
+
+// Record inserts an audit entry. CreatedAt defaults to now.
+func (s *Service) Record(e Entry) error {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now().UTC()
+	}
+	_, err := s.writeDB.Exec(
+		`INSERT INTO audit_log (actor_id, actor_name, actor_role, ip, action, method, path, resource_id, status, before_data, after_data, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ActorID, e.ActorName, e.ActorRole, e.IP, e.Action, e.Method, e.Path, e.ResourceID, e.Status, e.Before, e.After, e.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return nil
+}
+
+// List returns audit entries matching f, newest first.
+func (s *Service) List(f Filter) (*ListResult, error) {
+	if f.Page < 1 {
+		f.Page = 1
+	}
+	if f.PageSize < 1 || f.PageSize > 200 {
+		f.PageSize = 50
+	}
+
+	var conds []string
+	var args []interface{}
+	if f.ActorID != "" {
+		conds = append(conds, "actor_id = ?")
+		args = append(args, f.ActorID)
+	}
+	if f.Action != "" {
+		conds = append(conds, `(action = ? OR action LIKE ? ESCAPE '\')`)
+		args = append(args, f.Action, escapeLike(f.Action)+".%")
+	}
+	if f.ResourceID != "" {
+		conds = append(conds, "resource_id = ?")
+		args = append(args, f.ResourceID)
+	}
+	if !f.From.IsZero() {
+		conds = append(conds, "created_at >= ?")
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		conds = append(conds, "created_at < ?")
+		args = append(args, f.To.UTC())
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	var total int
+	if err := s.readDB.QueryRow(`SELECT COUNT(*) FROM audit_log`+where, args...).Scan(&total); err != nil {
+		return nil, fmt.Errorf("failed to count audit entries: %w", err)
+	}
+
+	queryArgs := append(args, f.PageSize, (f.Page-1)*f.PageSize)
+	rows, err := s.readDB.Query(
+		`SELECT id, actor_id, COALESCE(actor_name, ''), COALESCE(actor_role, ''), COALESCE(ip, ''), action, method, path,
+			COALESCE(resource_id, ''), status, COALESCE(before_data, ''), COALESCE(after_data, ''), created_at
+		 FROM audit_log`+where+` ORDER BY id DESC LIMIT ? OFFSET ?`, queryArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.ID, &e.ActorID, &e.ActorName, &e.ActorRole, &e.IP, &e.Action, &e.Method, &e.Path,
+			&e.ResourceID, &e.Status, &e.Before, &e.After, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &ListResult{Entries: entries, Total: total, Page: f.Page, PageSize: f.PageSize}, nil
+}
+
+// Diff compares two snapshots and returns JSON objects holding only the
+// fields that differ, keyed by dotted path. A nil snapshot (resource did not
+// exist) yields "" on that side and the full other side.
+func Diff(before, after interface{}) (string, string) {
+	b := flatten(before)
+	a := flatten(after)
+	changedBefore := make(map[string]interface{})
+	changedAfter := make(map[string]interface{})
+	for k, bv := range b {
+		av, ok := a[k]
+		if !ok || !reflect.DeepEqual(av, bv) {
+			changedBefore[k] = bv
+		}
+	}
+	for k, av := range a {
+		bv, ok := b[k]
+		if !ok || !reflect.DeepEqual(av, bv) {
+			changedAfter[k] = av
+		}
+	}
+	return encode(changedBefore, isNil(before)), encode(changedAfter, isNil(after))
+}
+
+// isNil reports whether v is nil or a nil pointer/map/slice.
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// flatten converts v to a map of dotted paths to JSON scalar values.
+// Arrays are kept as whole values.
+func flatten(v interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	if isNil(v) {
+		return out
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return out
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return out
+	}
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			if prefix == "" {
+				prefix = "value"
+			}
+			out[prefix] = v
+			return
+		}
+		for k, child := range m {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			walk(key, child)
+		}
+	}
+	walk("", generic)
+	return out
+}
+
+// encode marshals m (json sorts map keys); empty or missing snapshots encode as "".
+func encode(m map[string]interface{}, missing bool) string {
+	if missing || len(m) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// escapeLike prepares s for a LIKE pattern using ESCAPE '\': wildcards and
+// the escape character are removed or escaped.
+func escapeLike(s string) string {
+	s = strings.ReplaceAll(s, "\\", "")
+	s = strings.ReplaceAll(s, "%", "")
+	return strings.ReplaceAll(s, "_", "\\_")
+}
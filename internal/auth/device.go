@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxUserAgentLen bounds the user agent stored for a device.
+const maxUserAgentLen = 512
+
+// ErrDeviceNotFound is returned for a device that is not an active login of
+// the user.
+var ErrDeviceNotFound = errors.New("device not found")
+
+// Device is an active login of a user: a refresh token family together with
+// the browser and address it is used from. Its ID is the family ID, which
+// cannot be used to authenticate.
+type Device struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"` // last login or refresh
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"` // the login of the requesting session
+}
+
+// TrackDevice records the user agent and address of the login that the
+// session was issued for, on login and again on every refresh so that the
+// address stays current. Sessions without a refresh token are not logins
+// and are ignored.
+func (sm *SessionManager) TrackDevice(sessionID, userAgent, ip string) error {
+	if len(userAgent) > maxUserAgentLen {
+		userAgent = userAgent[:maxUserAgentLen]
+	}
+	_, err := sm.writeDB.Exec(
+		`INSERT INTO login_devices (family_id, user_id, user_agent, ip_address, created_at)
+		 SELECT family_id, user_id, ?, ?, ? FROM refresh_tokens WHERE session_id = ?
+		 ON CONFLICT(family_id) DO UPDATE SET user_agent = excluded.user_agent, ip_address = excluded.ip_address`,
+		userAgent, ip, time.Now().UTC().Format(time.RFC3339), sessionID,
+	)
+	if err != nil {
+		return fmt.Errorf("track device: %w", err)
+	}
+	return nil
+}
+
+// ListDevices returns the active logins of userID, most recently used
+// first. currentSessionID marks the login it belongs to as Current. Logins
+// made before devices were tracked are listed without user agent and
+// address.
+func (sm *SessionManager) ListDevices(userID, currentSessionID string) ([]Device, error) {
+	rows, err := sm.readDB.Query(
+		`SELECT t.family_id, COALESCE(d.user_agent, ''), COALESCE(d.ip_address, ''),
+		        MIN(t.created_at), MAX(t.created_at), MAX(t.expires_at), MAX(t.session_id = ?)
+		 FROM refresh_tokens t LEFT JOIN login_devices d ON d.family_id = t.family_id
+		 WHERE t.user_id = ? AND t.expires_at > ?
+		 GROUP BY t.family_id ORDER BY MAX(t.created_at) DESC`,
+		currentSessionID, userID, time.Now().UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query devices: %w", err)
+	}
+	defer rows.Close()
+
+	devices := []Device{}
+	for rows.Next() {
+		var d Device
+		var createdAt, lastSeenAt, expiresAt string
+		if err := rows.Scan(&d.ID, &d.UserAgent, &d.IPAddress, &createdAt, &lastSeenAt, &expiresAt, &d.Current); err != nil {
+			return nil, fmt.Errorf("scan device: %w", err)
+		}
+		d.CreatedAt, _ = parseSessionTime(createdAt)
+		d.LastSeenAt, _ = parseSessionTime(lastSeenAt)
+		d.ExpiresAt, _ = parseSessionTime(expiresAt)
+		devices = append(devices, d)
+	}
+	return devices, rows.Err()
+}
+
+// RevokeDevice signs out one login of userID: its refresh tokens and access
+// session are deleted. It returns ErrDeviceNotFound if deviceID is not a
+// login of userID.
+func (sm *SessionManager) RevokeDevice(userID, deviceID string) error {
+	tx, err := sm.writeDB.Begin()
+	if err != nil {
+		return fmt.Errorf("begin revoke tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var owner string
+	err = tx.QueryRow("SELECT user_id FROM refresh_tokens WHERE family_id = ? LIMIT 1", deviceID).Scan(&owner)
+	if err == sql.ErrNoRows || (err == nil && owner != userID) {
+		return ErrDeviceNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("query device: %w", err)
+	}
+	if err := revokeFamily(tx, deviceID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit revocation: %w", err)
+	}
+	// The revoked access session may be cached
+	sm.cacheFlush()
+	return nil
+}
+
+// LimitDevices signs out the oldest logins of userID beyond the newest max
+// and returns how many it signed out. Logins are ordered by when they were
+// made, so refreshing does not keep an old login in.
+func (sm *SessionManager) LimitDevices(userID string, max int) (int, error) {
+	if max <= 0 {
+		return 0, nil
+	}
+	tx, err := sm.writeDB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("begin limit tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`SELECT family_id FROM refresh_tokens WHERE user_id = ? AND expires_at > ?
+		 GROUP BY family_id ORDER BY MIN(created_at) DESC LIMIT -1 OFFSET ?`,
+		userID, time.Now().UTC().Format(time.RFC3339), max,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("query logins: %w", err)
+	}
+	var excess []string
+	for rows.Next() {
+		var familyID string
+		if err := rows.Scan(&familyID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan login: %w", err)
+		}
+		excess = append(excess, familyID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("query logins: %w", err)
+	}
+	if len(excess) == 0 {
+		return 0, nil
+	}
+	for _, familyID := range excess {
+		if err := revokeFamily(tx, familyID); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit revocation: %w", err)
+	}
+	sm.cacheFlush()
+	return len(excess), nil
+}
@@ -3,317 +3,551 @@ package auth
 import (
 	"database/sql"
 	"fmt"
+	"log"
 	"sync"
 	"time"
 )
 
+// Lockout policy.
+const (
+	userConsecutiveLimit = 10
+	userLockDuration     = time.Hour
+	userDailyLimit       = 50
+	ipConsecutiveLimit   = 100
+	ipLockDuration       = 10 * 24 * time.Hour
+)
+
+const (
+	// attemptQueueSize bounds the attempts waiting to be written. When the
+	// writer falls behind, further attempts are still counted in memory but
+	// not persisted.
+	attemptQueueSize = 10000
+	// attemptBatchSize is the most attempts written in one transaction.
+	attemptBatchSize = 500
+	// idleStreakTTL is how long the counters of a username without recent
+	// failures are kept in memory.
+	idleStreakTTL = 24 * time.Hour
+)
+
 // LoginLimiter tracks failed admin login attempts and enforces lockout policies:
 //   - 10 consecutive failures → lock for 1 hour
 //   - 50 failures in a day → lock for the rest of the day
 //   - 100 consecutive failures → lock IP for 10 days
+//
+// Checks are answered from per-username and per-IP counters kept in memory,
+// so a credential stuffing attack does not turn every login into table
+// scans. The counters are rebuilt from login_attempts on startup; attempts
+// are written to the database in the background.
 type LoginLimiter struct {
 	readDB  *sql.DB
 	writeDB *sql.DB
-	mu      sync.Mutex
+
+	mu    sync.Mutex
+	users map[string]*userStreak
+	ips   map[string]*ipStreak
+	bans  []manualBan
+
+	queue    chan loginAttempt
+	dropped  int
+	stopOnce sync.Once
+	done     chan struct{} // closed by Stop
+	stopped  chan struct{} // closed by the writer when it has exited
+}
+
+// userStreak holds the failure counters of one username.
+type userStreak struct {
+	consecutive int       // failures since the last success
+	lockedUntil time.Time // end of the current consecutive-failure lock
+	day         string    // UTC day of dailyFails, YYYY-MM-DD
+	dailyFails  int
+	lastFail    time.Time
+}
+
+// ipStreak holds the failure counters of one client IP.
+type ipStreak struct {
+	consecutive int
+	lockedUntil time.Time
+	lastFail    time.Time
+}
+
+// manualBan is an admin-created ban from login_bans.
+type manualBan struct {
+	username, ip, reason string
+	unlocksAt            time.Time
+}
+
+// loginAttempt is an attempt waiting to be persisted, or with forget set,
+// a request to delete the recorded attempts of username.
+type loginAttempt struct {
+	username, ip string
+	success      bool
+	forget       bool
+	at           time.Time
 }
 
 // NewLoginLimiter creates a LoginLimiter backed by the given database.
 // For backward compatibility, if only one DB is provided, it is used for both reads and writes.
 func NewLoginLimiter(db *sql.DB) *LoginLimiter {
-	return &LoginLimiter{readDB: db, writeDB: db}
+	return NewLoginLimiterRW(db, db)
 }
 
-// NewLoginLimiterRW creates a LoginLimiter with separate read and write database pools.
+// NewLoginLimiterRW creates a LoginLimiter with separate read and write
+// database pools. It replays the attempts of the last 10 days to rebuild the
+// counters and starts the background writer; call Stop on shutdown.
 func NewLoginLimiterRW(readDB, writeDB *sql.DB) *LoginLimiter {
-	return &LoginLimiter{readDB: readDB, writeDB: writeDB}
+	ll := &LoginLimiter{
+		readDB:  readDB,
+		writeDB: writeDB,
+		users:   make(map[string]*userStreak),
+		ips:     make(map[string]*ipStreak),
+		queue:   make(chan loginAttempt, attemptQueueSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if err := ll.load(); err != nil {
+		log.Printf("[LoginLimiter] failed to rebuild counters: %v", err)
+	}
+	go ll.writer()
+	return ll
+}
+
+// load rebuilds the counters from the attempts recorded within the longest
+// lock period and reads the active manual bans.
+func (ll *LoginLimiter) load() error {
+	now := time.Now().UTC()
+	rows, err := ll.readDB.Query(
+		`SELECT username, ip, success, created_at FROM login_attempts WHERE created_at >= ? ORDER BY created_at, id`,
+		now.Add(-ipLockDuration).Format(time.RFC3339),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to read login attempts: %w", err)
+	}
+	defer rows.Close()
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+	n := 0
+	for rows.Next() {
+		var a loginAttempt
+		var created string
+		if err := rows.Scan(&a.username, &a.ip, &a.success, &created); err != nil {
+			return fmt.Errorf("failed to scan login attempt: %w", err)
+		}
+		if a.at, err = time.Parse(time.RFC3339, created); err != nil {
+			continue
+		}
+		ll.countLocked(a)
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read login attempts: %w", err)
+	}
+	ll.pruneLocked(now)
+	if n > 0 {
+		log.Printf("[LoginLimiter] rebuilt counters from %d login attempts", n)
+	}
+	return ll.loadBansLocked()
+}
+
+// loadBansLocked reads the active manual bans. Caller must hold ll.mu.
+func (ll *LoginLimiter) loadBansLocked() error {
+	rows, err := ll.readDB.Query(
+		`SELECT username, ip, reason, unlocks_at FROM login_bans WHERE unlocks_at > ?`,
+		time.Now().UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to read login bans: %w", err)
+	}
+	defer rows.Close()
+	var bans []manualBan
+	for rows.Next() {
+		var b manualBan
+		var unlocks string
+		if err := rows.Scan(&b.username, &b.ip, &b.reason, &unlocks); err != nil {
+			return fmt.Errorf("failed to scan login ban: %w", err)
+		}
+		if b.unlocksAt, err = time.Parse(time.RFC3339, unlocks); err != nil {
+			continue
+		}
+		bans = append(bans, b)
+	}
+	ll.bans = bans
+	return rows.Err()
+}
+
+// countLocked applies an attempt to the counters. Caller must hold ll.mu.
+func (ll *LoginLimiter) countLocked(a loginAttempt) {
+	if a.username != "" {
+		u := ll.users[a.username]
+		if a.success {
+			if u != nil {
+				u.consecutive = 0
+				u.lockedUntil = time.Time{}
+				// A success without an IP is an unlock record written by
+				// Unban or UnlockUser, which also lifts the daily limit
+				if a.ip == "" {
+					u.dailyFails = 0
+				}
+			}
+		} else {
+			if u == nil {
+				u = &userStreak{}
+				ll.users[a.username] = u
+			}
+			u.consecutive++
+			if u.consecutive%userConsecutiveLimit == 0 {
+				u.lockedUntil = a.at.Add(userLockDuration)
+			}
+			day := a.at.UTC().Format("2006-01-02")
+			if u.day != day {
+				u.day, u.dailyFails = day, 0
+			}
+			u.dailyFails++
+			u.lastFail = a.at
+		}
+	}
+	if a.ip != "" {
+		s := ll.ips[a.ip]
+		if a.success {
+			if s != nil {
+				s.consecutive = 0
+				s.lockedUntil = time.Time{}
+			}
+		} else {
+			if s == nil {
+				s = &ipStreak{}
+				ll.ips[a.ip] = s
+			}
+			s.consecutive++
+			if s.consecutive%ipConsecutiveLimit == 0 {
+				s.lockedUntil = a.at.Add(ipLockDuration)
+			}
+			s.lastFail = a.at
+		}
+	}
+}
+
+// pruneLocked drops expired manual bans and counters that can no longer
+// lead to a lock: usernames without failures today or within a day and IPs
+// without failures in the last 10 days, once their locks have expired.
+// Caller must hold ll.mu.
+func (ll *LoginLimiter) pruneLocked(now time.Time) {
+	bans := ll.bans[:0]
+	for _, b := range ll.bans {
+		if now.Before(b.unlocksAt) {
+			bans = append(bans, b)
+		}
+	}
+	ll.bans = bans
+	today := now.UTC().Format("2006-01-02")
+	for name, u := range ll.users {
+		if now.After(u.lockedUntil) && u.day != today && now.Sub(u.lastFail) > idleStreakTTL {
+			delete(ll.users, name)
+		}
+	}
+	for ip, s := range ll.ips {
+		if now.After(s.lockedUntil) && now.Sub(s.lastFail) > ipLockDuration {
+			delete(ll.ips, ip)
+		}
+	}
 }
 
 // CheckAllowed returns nil if the login attempt is allowed, or an error describing the lockout.
 func (ll *LoginLimiter) CheckAllowed(username, ip string) error {
-	// No mutex needed: all operations are read-only DB queries,
-	// and SQLite WAL mode + database/sql handle concurrency safely.
-
 	now := time.Now().UTC()
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
 
 	// Check manual bans first
-	var manualBanReason string
-	err := ll.readDB.QueryRow(
-		`SELECT reason FROM login_bans WHERE (username = ? OR ip = ?) AND unlocks_at > ? LIMIT 1`,
-		username, ip, now.Format(time.RFC3339),
-	).Scan(&manualBanReason)
-	if err == nil && manualBanReason != "" {
-		return fmt.Errorf("%s", manualBanReason)
+	for _, b := range ll.bans {
+		if now.Before(b.unlocksAt) && b.reason != "" &&
+			((b.username != "" && b.username == username) || (b.ip != "" && b.ip == ip)) {
+			return fmt.Errorf("%s", b.reason)
+		}
 	}
 
 	// Rule 3: IP locked for 10 days after 100 consecutive failures
-	var ipConsec int
-	err = ll.readDB.QueryRow(
-		`SELECT COUNT(*) FROM login_attempts WHERE ip = ? AND success = 0 AND created_at > (
-			SELECT COALESCE(MAX(created_at), '1970-01-01') FROM login_attempts WHERE ip = ? AND success = 1
-		)`, ip, ip,
-	).Scan(&ipConsec)
-	if err != nil {
-		return fmt.Errorf("查询登录记录失败: %w", err)
-	}
-	if ipConsec >= 100 {
-		// Check if the 100th failure was within the last 10 days
-		var lastFailStr sql.NullString
-		ll.readDB.QueryRow(
-			`SELECT created_at FROM login_attempts WHERE ip = ? AND success = 0 ORDER BY created_at DESC LIMIT 1 OFFSET 99`, ip,
-		).Scan(&lastFailStr)
-		if lastFailStr.Valid {
-			if t, e := time.Parse(time.RFC3339, lastFailStr.String); e == nil {
-				if now.Before(t.Add(10 * 24 * time.Hour)) {
-					remaining := time.Until(t.Add(10 * 24 * time.Hour))
-					days := int(remaining.Hours() / 24)
-					if days < 1 {
-						return fmt.Errorf("该IP已被锁定，剩余不到1天")
-					}
-					return fmt.Errorf("该IP已被锁定，剩余%d天", days)
-				}
-			}
+	if s := ll.ips[ip]; s != nil && now.Before(s.lockedUntil) {
+		days := int(s.lockedUntil.Sub(now).Hours() / 24)
+		if days < 1 {
+			return fmt.Errorf("该IP已被锁定，剩余不到1天")
 		}
+		return fmt.Errorf("该IP已被锁定，剩余%d天", days)
 	}
 
-	// Rule 2: 50 failures today → locked for the rest of the day
-	todayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).Format(time.RFC3339)
-	tomorrowStart := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339)
-	var dailyFails int
-	err = ll.readDB.QueryRow(
-		`SELECT COUNT(*) FROM login_attempts WHERE username = ? AND success = 0 AND created_at >= ? AND created_at < ?`,
-		username, todayStart, tomorrowStart,
-	).Scan(&dailyFails)
-	if err != nil {
-		return fmt.Errorf("查询登录记录失败: %w", err)
+	u := ll.users[username]
+	if u == nil {
+		return nil
 	}
-	if dailyFails >= 50 {
+	// Rule 2: 50 failures today → locked for the rest of the day
+	if u.day == now.Format("2006-01-02") && u.dailyFails >= userDailyLimit {
 		return fmt.Errorf("今日密码错误次数过多，当天禁止登录")
 	}
-
 	// Rule 1: 10 consecutive failures → lock for 1 hour
-	var consecFails int
-	err = ll.readDB.QueryRow(
-		`SELECT COUNT(*) FROM login_attempts WHERE username = ? AND success = 0 AND created_at > (
-			SELECT COALESCE(MAX(created_at), '1970-01-01') FROM login_attempts WHERE username = ? AND success = 1
-		)`, username, username,
-	).Scan(&consecFails)
-	if err != nil {
-		return fmt.Errorf("查询登录记录失败: %w", err)
-	}
-	if consecFails >= 10 {
-		// Check if the 10th consecutive failure was within the last hour
-		var tenthFailStr sql.NullString
-		ll.readDB.QueryRow(
-			`SELECT created_at FROM login_attempts WHERE username = ? AND success = 0 AND created_at > (
-				SELECT COALESCE(MAX(created_at), '1970-01-01') FROM login_attempts WHERE username = ? AND success = 1
-			) ORDER BY created_at ASC LIMIT 1`,
-			username, username,
-		).Scan(&tenthFailStr)
-		if tenthFailStr.Valid {
-			if t, e := time.Parse(time.RFC3339, tenthFailStr.String); e == nil {
-				if now.Before(t.Add(1 * time.Hour)) {
-					remaining := time.Until(t.Add(1 * time.Hour))
-					mins := int(remaining.Minutes())
-					if mins < 1 {
-						return fmt.Errorf("连续密码错误过多，请稍后再试")
-					}
-					return fmt.Errorf("连续密码错误过多，请%d分钟后再试", mins)
-				}
-			}
+	if now.Before(u.lockedUntil) {
+		mins := int(u.lockedUntil.Sub(now).Minutes())
+		if mins < 1 {
+			return fmt.Errorf("连续密码错误过多，请稍后再试")
 		}
+		return fmt.Errorf("连续密码错误过多，请%d分钟后再试", mins)
 	}
-
 	return nil
 }
 
-// RecordAttempt records a login attempt (success or failure).
+// RecordAttempt records a login attempt (success or failure). The counters
+// are updated immediately; the attempt is written to login_attempts in the
+// background.
 func (ll *LoginLimiter) RecordAttempt(username, ip string, success bool) {
+	a := loginAttempt{username: username, ip: ip, success: success, at: time.Now().UTC()}
 	ll.mu.Lock()
-	defer ll.mu.Unlock()
+	ll.countLocked(a)
+	ll.mu.Unlock()
+	ll.enqueue(a)
+}
 
-	successInt := 0
-	if success {
-		successInt = 1
+// enqueue hands an attempt to the background writer, dropping it when the
+// queue is full or the limiter has been stopped.
+func (ll *LoginLimiter) enqueue(a loginAttempt) {
+	select {
+	case <-ll.done:
+		return
+	default:
+	}
+	select {
+	case ll.queue <- a:
+	default:
+		ll.mu.Lock()
+		ll.dropped++
+		if ll.dropped == 1 || ll.dropped%1000 == 0 {
+			log.Printf("[LoginLimiter] write queue full, %d login attempts not persisted", ll.dropped)
+		}
+		ll.mu.Unlock()
+	}
+}
+
+// writer persists queued attempts in batches until Stop is called, then
+// writes what is left in the queue.
+func (ll *LoginLimiter) writer() {
+	defer close(ll.stopped)
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[LoginLimiter] panic in writer goroutine: %v", r)
+		}
+	}()
+	batch := make([]loginAttempt, 0, attemptBatchSize)
+	for {
+		select {
+		case a := <-ll.queue:
+			batch = append(batch, a)
+		case <-ll.done:
+			for {
+				select {
+				case a := <-ll.queue:
+					batch = append(batch, a)
+					if len(batch) >= attemptBatchSize {
+						ll.writeBatch(batch)
+						batch = batch[:0]
+					}
+				default:
+					ll.writeBatch(batch)
+					return
+				}
+			}
+		}
+		// Collect whatever else is already waiting
+	collect:
+		for len(batch) < attemptBatchSize {
+			select {
+			case a := <-ll.queue:
+				batch = append(batch, a)
+			default:
+				break collect
+			}
+		}
+		ll.writeBatch(batch)
+		batch = batch[:0]
+	}
+}
+
+// writeBatch inserts attempts in one transaction.
+func (ll *LoginLimiter) writeBatch(batch []loginAttempt) {
+	if len(batch) == 0 {
+		return
+	}
+	tx, err := ll.writeDB.Begin()
+	if err != nil {
+		log.Printf("[LoginLimiter] failed to persist login attempts: %v", err)
+		return
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO login_attempts (username, ip, success, created_at) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		log.Printf("[LoginLimiter] failed to persist login attempts: %v", err)
+		return
+	}
+	defer stmt.Close()
+	for _, a := range batch {
+		if a.forget {
+			if _, err := tx.Exec(`DELETE FROM login_attempts WHERE username = ?`, a.username); err != nil {
+				log.Printf("[LoginLimiter] failed to persist login attempts: %v", err)
+				return
+			}
+			continue
+		}
+		successInt := 0
+		if a.success {
+			successInt = 1
+		}
+		if _, err := stmt.Exec(a.username, a.ip, successInt, a.at.Format(time.RFC3339)); err != nil {
+			log.Printf("[LoginLimiter] failed to persist login attempts: %v", err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("[LoginLimiter] failed to persist login attempts: %v", err)
 	}
-	ll.writeDB.Exec(
-		`INSERT INTO login_attempts (username, ip, success, created_at) VALUES (?, ?, ?, ?)`,
-		username, ip, successInt, time.Now().UTC().Format(time.RFC3339),
-	)
 }
 
-// CleanOld removes login attempt records older than 30 days.
+// Stop writes the queued attempts and stops the background writer. It must
+// be called before the database is closed.
+func (ll *LoginLimiter) Stop() {
+	ll.stopOnce.Do(func() {
+		close(ll.done)
+	})
+	<-ll.stopped
+}
+
+// CleanOld removes login attempt records older than 30 days and drops
+// in-memory counters that can no longer lead to a lock.
 func (ll *LoginLimiter) CleanOld() {
 	cutoff := time.Now().UTC().Add(-30 * 24 * time.Hour).Format(time.RFC3339)
 	ll.writeDB.Exec(`DELETE FROM login_attempts WHERE created_at < ?`, cutoff)
+	ll.mu.Lock()
+	ll.pruneLocked(time.Now().UTC())
+	ll.mu.Unlock()
+}
+
+// SuccessfulIPs returns the addresses username signed in from successfully
+// before the given time, as far back as login attempts are kept (30 days).
+// Attempts are written in the background, so the last few may be missing.
+func (ll *LoginLimiter) SuccessfulIPs(username string, before time.Time) ([]string, error) {
+	rows, err := ll.readDB.Query(
+		`SELECT DISTINCT ip FROM login_attempts WHERE username = ? AND success = 1 AND ip != '' AND created_at < ?`,
+		username, before.UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query login attempts: %w", err)
+	}
+	defer rows.Close()
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, fmt.Errorf("scan login attempt: %w", err)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, rows.Err()
 }
 
 // BanEntry represents a banned username or IP for display in the admin UI.
 type BanEntry struct {
-	Type       string `json:"type"`        // "user_consecutive", "user_daily", "ip"
-	Username   string `json:"username"`
-	IP         string `json:"ip"`
-	FailCount  int    `json:"fail_count"`
-	Reason     string `json:"reason"`
-	UnlocksAt  string `json:"unlocks_at"`
-	IsManual   bool   `json:"is_manual"`
+	Type      string `json:"type"` // "user_consecutive", "user_daily", "ip"
+	Username  string `json:"username"`
+	IP        string `json:"ip"`
+	FailCount int    `json:"fail_count"`
+	Reason    string `json:"reason"`
+	UnlocksAt string `json:"unlocks_at"`
+	IsManual  bool   `json:"is_manual"`
 }
 
 // ListBans returns all currently active login bans (user-level and IP-level).
 func (ll *LoginLimiter) ListBans() []BanEntry {
-	// No mutex needed: all operations are read-only DB queries.
-
 	now := time.Now().UTC()
+	today := now.Format("2006-01-02")
+	tomorrowStart := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339)
 	var bans []BanEntry
 
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
 	// Manual bans
-	rows, err := ll.readDB.Query(`SELECT username, ip, reason, unlocks_at FROM login_bans WHERE unlocks_at > ?`, now.Format(time.RFC3339))
-	if err == nil {
-		for rows.Next() {
-			var b BanEntry
-			var unlocks string
-			if err := rows.Scan(&b.Username, &b.IP, &b.Reason, &unlocks); err != nil {
-				continue
-			}
-			b.Type = "manual"
-			b.UnlocksAt = unlocks
-			b.IsManual = true
-			if b.Username != "" {
-				b.Type = "manual_user"
-			} else {
-				b.Type = "manual_ip"
-			}
-			bans = append(bans, b)
+	for _, b := range ll.bans {
+		if !now.Before(b.unlocksAt) {
+			continue
 		}
-		rows.Close()
-	}
-
-	// Rule 1: users with >=10 consecutive failures (locked 1 hour)
-	userRows, err := ll.readDB.Query(`
-		SELECT username, COUNT(*) as cnt FROM login_attempts
-		WHERE success = 0 AND created_at > (
-			SELECT COALESCE(MAX(la2.created_at), '1970-01-01') FROM login_attempts la2 WHERE la2.username = login_attempts.username AND la2.success = 1
-		)
-		GROUP BY username HAVING cnt >= 10
-	`)
-	if err == nil {
-		type userFail struct {
-			username string
-			cnt      int
+		entry := BanEntry{
+			Type:      "manual_ip",
+			Username:  b.username,
+			IP:        b.ip,
+			Reason:    b.reason,
+			UnlocksAt: b.unlocksAt.Format(time.RFC3339),
+			IsManual:  true,
 		}
-		var userFails []userFail
-		for userRows.Next() {
-			var uf userFail
-			if err := userRows.Scan(&uf.username, &uf.cnt); err != nil {
-				continue
-			}
-			userFails = append(userFails, uf)
-		}
-		userRows.Close()
-
-		for _, uf := range userFails {
-			// Find the first failure in the consecutive streak
-			var firstFailStr sql.NullString
-			ll.readDB.QueryRow(`
-				SELECT created_at FROM login_attempts WHERE username = ? AND success = 0 AND created_at > (
-					SELECT COALESCE(MAX(created_at), '1970-01-01') FROM login_attempts WHERE username = ? AND success = 1
-				) ORDER BY created_at ASC LIMIT 1
-			`, uf.username, uf.username).Scan(&firstFailStr)
-			if firstFailStr.Valid {
-				if t, e := time.Parse(time.RFC3339, firstFailStr.String); e == nil {
-					unlocks := t.Add(1 * time.Hour)
-					if now.Before(unlocks) {
-						bans = append(bans, BanEntry{
-							Type:      "user_consecutive",
-							Username:  uf.username,
-							FailCount: uf.cnt,
-							Reason:    fmt.Sprintf("连续%d次密码错误，锁定1小时", uf.cnt),
-							UnlocksAt: unlocks.Format(time.RFC3339),
-						})
-					}
-				}
-			}
+		if b.username != "" {
+			entry.Type = "manual_user"
 		}
+		bans = append(bans, entry)
 	}
 
-	// Rule 2: users with >=50 daily failures
-	todayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).Format(time.RFC3339)
-	tomorrowStart := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339)
-	dailyRows, err := ll.readDB.Query(`
-		SELECT username, COUNT(*) as cnt FROM login_attempts
-		WHERE success = 0 AND created_at >= ? AND created_at < ?
-		GROUP BY username HAVING cnt >= 50
-	`, todayStart, tomorrowStart)
-	if err == nil {
-		for dailyRows.Next() {
-			var username string
-			var cnt int
-			if err := dailyRows.Scan(&username, &cnt); err != nil {
-				continue
-			}
+	for name, u := range ll.users {
+		// Rule 1: users with >=10 consecutive failures (locked 1 hour)
+		if now.Before(u.lockedUntil) {
+			bans = append(bans, BanEntry{
+				Type:      "user_consecutive",
+				Username:  name,
+				FailCount: u.consecutive,
+				Reason:    fmt.Sprintf("连续%d次密码错误，锁定1小时", u.consecutive),
+				UnlocksAt: u.lockedUntil.Format(time.RFC3339),
+			})
+		}
+		// Rule 2: users with >=50 daily failures
+		if u.day == today && u.dailyFails >= userDailyLimit {
 			bans = append(bans, BanEntry{
 				Type:      "user_daily",
-				Username:  username,
-				FailCount: cnt,
-				Reason:    fmt.Sprintf("今日%d次密码错误，当天禁止登录", cnt),
+				Username:  name,
+				FailCount: u.dailyFails,
+				Reason:    fmt.Sprintf("今日%d次密码错误，当天禁止登录", u.dailyFails),
 				UnlocksAt: tomorrowStart,
 			})
 		}
-		dailyRows.Close()
 	}
 
 	// Rule 3: IPs with >=100 consecutive failures (locked 10 days)
-	ipRows, err := ll.readDB.Query(`
-		SELECT ip, COUNT(*) as cnt FROM login_attempts
-		WHERE success = 0 AND created_at > (
-			SELECT COALESCE(MAX(la2.created_at), '1970-01-01') FROM login_attempts la2 WHERE la2.ip = login_attempts.ip AND la2.success = 1
-		)
-		GROUP BY ip HAVING cnt >= 100
-	`)
-	if err == nil {
-		type ipFail struct {
-			ip  string
-			cnt int
-		}
-		var ipFails []ipFail
-		for ipRows.Next() {
-			var f ipFail
-			if err := ipRows.Scan(&f.ip, &f.cnt); err != nil {
-				continue
-			}
-			ipFails = append(ipFails, f)
-		}
-		ipRows.Close()
-
-		for _, f := range ipFails {
-			var hundredthFailStr sql.NullString
-			ll.readDB.QueryRow(
-				`SELECT created_at FROM login_attempts WHERE ip = ? AND success = 0 ORDER BY created_at DESC LIMIT 1 OFFSET 99`, f.ip,
-			).Scan(&hundredthFailStr)
-			if hundredthFailStr.Valid {
-				if t, e := time.Parse(time.RFC3339, hundredthFailStr.String); e == nil {
-					unlocks := t.Add(10 * 24 * time.Hour)
-					if now.Before(unlocks) {
-						bans = append(bans, BanEntry{
-							Type:      "ip",
-							IP:        f.ip,
-							FailCount: f.cnt,
-							Reason:    fmt.Sprintf("IP连续%d次密码错误，锁定10天", f.cnt),
-							UnlocksAt: unlocks.Format(time.RFC3339),
-						})
-					}
-				}
-			}
+	for ip, s := range ll.ips {
+		if now.Before(s.lockedUntil) {
+			bans = append(bans, BanEntry{
+				Type:      "ip",
+				IP:        ip,
+				FailCount: s.consecutive,
+				Reason:    fmt.Sprintf("IP连续%d次密码错误，锁定10天", s.consecutive),
+				UnlocksAt: s.lockedUntil.Format(time.RFC3339),
+			})
 		}
 	}
 
 	return bans
 }
 
-// Unban removes the ban for a given username or IP by inserting a synthetic success record.
-// For manual bans, it deletes the ban record.
+// Unban removes the bans and lockouts of a given username or IP. A
+// synthetic success record is written so the consecutive failure counters
+// stay reset when they are rebuilt after a restart.
 func (ll *LoginLimiter) Unban(username, ip string) {
 	ll.mu.Lock()
-	defer ll.mu.Unlock()
+	if username != "" {
+		delete(ll.users, username)
+	}
+	if ip != "" {
+		delete(ll.ips, ip)
+	}
+	bans := ll.bans[:0]
+	for _, b := range ll.bans {
+		if (username != "" && b.username == username) || (ip != "" && b.ip == ip) {
+			continue
+		}
+		bans = append(bans, b)
+	}
+	ll.bans = bans
+	ll.mu.Unlock()
 
 	// Remove manual bans
 	if username != "" {
@@ -324,23 +558,91 @@ func (ll *LoginLimiter) Unban(username, ip string) {
 	}
 
 	// Insert a synthetic success to reset consecutive counters
-	now := time.Now().UTC().Format(time.RFC3339)
+	now := time.Now().UTC()
 	if username != "" {
-		ll.writeDB.Exec(`INSERT INTO login_attempts (username, ip, success, created_at) VALUES (?, '', 1, ?)`, username, now)
+		ll.enqueue(loginAttempt{username: username, success: true, at: now})
 	}
 	if ip != "" {
-		ll.writeDB.Exec(`INSERT INTO login_attempts (username, ip, success, created_at) VALUES ('', ?, 1, ?)`, ip, now)
+		ll.enqueue(loginAttempt{ip: ip, success: true, at: now})
 	}
 }
 
-// AddManualBan adds a manual ban for a username or IP until the specified time.
-func (ll *LoginLimiter) AddManualBan(username, ip, reason string, duration time.Duration) {
+// LockedUntil reports whether username is locked out by too many failed
+// logins (manual bans aside) and when the lockout ends.
+func (ll *LoginLimiter) LockedUntil(username string) (time.Time, bool) {
+	now := time.Now().UTC()
 	ll.mu.Lock()
 	defer ll.mu.Unlock()
+	u := ll.users[username]
+	if u == nil {
+		return time.Time{}, false
+	}
+	var until time.Time
+	if now.Before(u.lockedUntil) {
+		until = u.lockedUntil
+	}
+	if u.day == now.Format("2006-01-02") && u.dailyFails >= userDailyLimit {
+		until = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	}
+	return until, !until.IsZero()
+}
+
+// UnlockUser lifts the failed-login lockout of username. Unlike Unban it
+// keeps manual bans and the lockout of the IPs the failures came from, so
+// it is safe to offer to the account owner.
+func (ll *LoginLimiter) UnlockUser(username string) {
+	if username == "" {
+		return
+	}
+	ll.mu.Lock()
+	delete(ll.users, username)
+	ll.mu.Unlock()
+	ll.enqueue(loginAttempt{username: username, success: true, at: time.Now().UTC()})
+}
+
+// Forget deletes the login attempts, bans and counters of username, e.g.
+// when the account is erased. Unlike Unban it leaves no record behind. The
+// attempts are deleted by the background writer after the attempts still
+// queued, which would otherwise be written back after the delete.
+func (ll *LoginLimiter) Forget(username string) {
+	if username == "" {
+		return
+	}
+	ll.mu.Lock()
+	delete(ll.users, username)
+	bans := ll.bans[:0]
+	for _, b := range ll.bans {
+		if b.username != username {
+			bans = append(bans, b)
+		}
+	}
+	ll.bans = bans
+	ll.mu.Unlock()
 
-	unlocks := time.Now().UTC().Add(duration).Format(time.RFC3339)
+	ll.writeDB.Exec(`DELETE FROM login_bans WHERE username = ?`, username)
+	// Unlike attempts, the delete is never dropped: wait for room in the
+	// queue, or delete directly once the writer is stopping
+	select {
+	case <-ll.done:
+	default:
+		select {
+		case ll.queue <- loginAttempt{username: username, forget: true}:
+			return
+		case <-ll.done:
+		}
+	}
+	ll.writeDB.Exec(`DELETE FROM login_attempts WHERE username = ?`, username)
+}
+
+// AddManualBan adds a manual ban for a username or IP until the specified time.
+func (ll *LoginLimiter) AddManualBan(username, ip, reason string, duration time.Duration) {
+	now := time.Now().UTC()
+	b := manualBan{username: username, ip: ip, reason: reason, unlocksAt: now.Add(duration)}
+	ll.mu.Lock()
+	ll.bans = append(ll.bans, b)
+	ll.mu.Unlock()
 	ll.writeDB.Exec(
 		`INSERT INTO login_bans (username, ip, reason, unlocks_at, created_at) VALUES (?, ?, ?, ?, ?)`,
-		username, ip, reason, unlocks, time.Now().UTC().Format(time.RFC3339),
+		username, ip, reason, b.unlocksAt.Format(time.RFC3339), now.Format(time.RFC3339),
 	)
 }
@@ -7,10 +7,12 @@ import (
 	cryptorand "crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
 	"time"
@@ -343,3 +345,54 @@ func (oc *OAuthClient) getHTTPClient() *http.Client {
 	}
 	return &http.Client{Timeout: 15 * time.Second}
 }
+
+// ProbeOAuthProvider checks a provider config before it is saved: the URLs
+// must be absolute, and the token endpoint is called with a dummy
+// authorization code. A well-configured provider rejects the code itself
+// (invalid_grant); rejecting the client means the ID or secret is wrong.
+// On failure it returns the config field at fault (e.g. "client_secret").
+func ProbeOAuthProvider(ctx context.Context, p config.OAuthProviderConfig) (string, error) {
+	if strings.TrimSpace(p.ClientID) == "" {
+		return "client_id", fmt.Errorf("client_id is required")
+	}
+	for _, f := range []struct{ name, value string }{
+		{"auth_url", p.AuthURL},
+		{"token_url", p.TokenURL},
+		{"redirect_url", p.RedirectURL},
+	} {
+		u, err := url.Parse(f.value)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return f.name, fmt.Errorf("%s must be an absolute http(s) URL", f.name)
+		}
+	}
+
+	cfg := &oauth2.Config{
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		Endpoint:     oauth2.Endpoint{AuthURL: p.AuthURL, TokenURL: p.TokenURL},
+		RedirectURL:  p.RedirectURL,
+		Scopes:       p.Scopes,
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Timeout: 15 * time.Second})
+	_, err := cfg.Exchange(ctx, "askflow-config-probe")
+	if err == nil {
+		return "", nil
+	}
+	var re *oauth2.RetrieveError
+	if !errors.As(err, &re) {
+		return "token_url", fmt.Errorf("token endpoint unreachable: %w", err)
+	}
+	switch re.ErrorCode {
+	case "invalid_grant", "bad_verification_code", "invalid_request":
+		return "", nil
+	case "invalid_client", "unauthorized_client":
+		return "client_secret", fmt.Errorf("client credentials rejected by the provider (%s)", re.ErrorCode)
+	}
+	if re.Response != nil && re.Response.StatusCode == http.StatusUnauthorized {
+		return "client_secret", fmt.Errorf("client credentials rejected by the provider (HTTP 401)")
+	}
+	if re.ErrorCode == "" && re.Response != nil {
+		return "token_url", fmt.Errorf("token endpoint returned HTTP %d", re.Response.StatusCode)
+	}
+	return "", nil
+}
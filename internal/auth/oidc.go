@@ -0,0 +1,453 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"askflow/internal/config"
+
+	"golang.org/x/oauth2"
+)
+
+// oidcClockSkew is the tolerance applied to ID token exp/iat/nbf checks.
+const oidcClockSkew = 2 * time.Minute
+
+// oidcDiscovery is the subset of the OpenID provider metadata used here.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcProviderCache holds a provider's discovery document and signing keys.
+type oidcProviderCache struct {
+	discovery     *oidcDiscovery
+	discoveredAt  time.Time
+	keys          map[string]crypto.PublicKey // kid -> key
+	keysFetchedAt time.Time
+}
+
+// OIDCAuthURL starts an OIDC authorization code flow (with PKCE and a nonce)
+// and returns the IdP authorization URL to redirect the browser to.
+func (sc *SSOClient) OIDCAuthURL(ctx context.Context, provider string) (string, error) {
+	p, ok := sc.oidcProvider(provider)
+	if !ok {
+		return "", fmt.Errorf("unknown OIDC provider: %s", provider)
+	}
+	disc, err := sc.discover(ctx, provider, p)
+	if err != nil {
+		return "", err
+	}
+	state, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomHex(16)
+	if err != nil {
+		return "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+	sc.startLogin(state, ssoPending{provider: provider, kind: SSOTypeOIDC, nonce: nonce, verifier: verifier})
+
+	return oidcOAuthConfig(p, disc).AuthCodeURL(state,
+		oauth2.SetAuthURLParam("nonce", nonce),
+		oauth2.S256ChallengeOption(verifier),
+	), nil
+}
+
+// OIDCCallback completes an OIDC login: it exchanges code for tokens and
+// validates the ID token's signature, issuer, audience, expiry and nonce.
+func (sc *SSOClient) OIDCCallback(ctx context.Context, state, code string) (*SSOUser, error) {
+	pending, err := sc.finishLogin(state, SSOTypeOIDC)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := sc.oidcProvider(pending.provider)
+	if !ok {
+		return nil, fmt.Errorf("unknown OIDC provider: %s", pending.provider)
+	}
+	disc, err := sc.discover(ctx, pending.provider, p)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, sc.getHTTPClient())
+	token, err := oidcOAuthConfig(p, disc).Exchange(ctx, code, oauth2.VerifierOption(pending.verifier))
+	if err != nil {
+		return nil, fmt.Errorf("OIDC token exchange failed: %w", err)
+	}
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return nil, fmt.Errorf("OIDC token response has no id_token")
+	}
+	claims, err := sc.verifyIDToken(ctx, pending.provider, p, disc, rawIDToken, pending.nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &SSOUser{
+		Provider: pending.provider,
+		Type:     SSOTypeOIDC,
+		Subject:  stringVal(claims, "sub"),
+		Email:    stringVal(claims, "email"),
+		Name:     stringVal(claims, "name"),
+	}
+	if user.Subject == "" {
+		return nil, fmt.Errorf("ID token has no sub claim")
+	}
+	// Azure AD puts the sign-in address in preferred_username/upn when email is absent
+	if user.Email == "" {
+		for _, k := range []string{"preferred_username", "upn"} {
+			if v := stringVal(claims, k); strings.Contains(v, "@") {
+				user.Email = v
+				break
+			}
+		}
+	}
+	if user.Name == "" {
+		user.Name = user.Email
+	}
+	if p.RoleClaim != "" {
+		user.Roles = claimStrings(claims[p.RoleClaim])
+	}
+	return user, nil
+}
+
+func (sc *SSOClient) oidcProvider(name string) (config.OIDCProviderConfig, bool) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	p, ok := sc.oidc[name]
+	if !ok || p.Issuer == "" || p.ClientID == "" {
+		return config.OIDCProviderConfig{}, false
+	}
+	return p, true
+}
+
+func oidcOAuthConfig(p config.OIDCProviderConfig, disc *oidcDiscovery) *oauth2.Config {
+	scopes := p.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	return &oauth2.Config{
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  disc.AuthorizationEndpoint,
+			TokenURL: disc.TokenEndpoint,
+		},
+		RedirectURL: p.RedirectURL,
+		Scopes:      scopes,
+	}
+}
+
+// discover returns the provider's discovery document, fetching it from
+// <issuer>/.well-known/openid-configuration at most once an hour.
+func (sc *SSOClient) discover(ctx context.Context, provider string, p config.OIDCProviderConfig) (*oidcDiscovery, error) {
+	sc.cacheMu.Lock()
+	c := sc.oidcCache[provider]
+	if c != nil && c.discovery != nil && time.Since(c.discoveredAt) < time.Hour {
+		d := c.discovery
+		sc.cacheMu.Unlock()
+		return d, nil
+	}
+	sc.cacheMu.Unlock()
+
+	issuer := strings.TrimRight(p.Issuer, "/")
+	var d oidcDiscovery
+	if err := sc.getJSON(ctx, issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if strings.TrimRight(d.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("OIDC discovery issuer mismatch: %q", d.Issuer)
+	}
+	for _, u := range []string{d.AuthorizationEndpoint, d.TokenEndpoint, d.JWKSURI} {
+		if !strings.HasPrefix(u, "https://") {
+			return nil, fmt.Errorf("OIDC discovery document has a missing or non-HTTPS endpoint")
+		}
+	}
+
+	sc.cacheMu.Lock()
+	defer sc.cacheMu.Unlock()
+	c = sc.oidcCache[provider]
+	if c == nil {
+		c = &oidcProviderCache{}
+		sc.oidcCache[provider] = c
+	}
+	c.discovery = &d
+	c.discoveredAt = time.Now()
+	return &d, nil
+}
+
+// signingKey returns the JWKS key with the given kid. Keys are refetched when
+// the kid is unknown (key rotation), at most once a minute.
+func (sc *SSOClient) signingKey(ctx context.Context, provider string, disc *oidcDiscovery, kid string) (crypto.PublicKey, error) {
+	sc.cacheMu.Lock()
+	c := sc.oidcCache[provider]
+	if c == nil {
+		c = &oidcProviderCache{}
+		sc.oidcCache[provider] = c
+	}
+	if key, ok := c.keys[kid]; ok {
+		sc.cacheMu.Unlock()
+		return key, nil
+	}
+	if time.Since(c.keysFetchedAt) < time.Minute {
+		sc.cacheMu.Unlock()
+		return nil, fmt.Errorf("unknown ID token signing key %q", kid)
+	}
+	c.keysFetchedAt = time.Now()
+	sc.cacheMu.Unlock()
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := sc.getJSON(ctx, disc.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("fetch JWKS: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+
+	sc.cacheMu.Lock()
+	defer sc.cacheMu.Unlock()
+	c.keys = keys
+	key, ok := keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown ID token signing key %q", kid)
+	}
+	return key, nil
+}
+
+// verifyIDToken checks the ID token signature against the provider's JWKS
+// and validates iss, aud, azp, exp, iat and nonce. It returns the claims.
+func (sc *SSOClient) verifyIDToken(ctx context.Context, provider string, p config.OIDCProviderConfig, disc *oidcDiscovery, raw, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed ID token")
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token header")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("malformed ID token header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token signature")
+	}
+	key, err := sc.signingKey(ctx, provider, disc, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWS(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	claims, err := decodeJWTClaims(raw)
+	if err != nil {
+		return nil, err
+	}
+	if stringVal(claims, "iss") != disc.Issuer {
+		return nil, fmt.Errorf("ID token issuer mismatch")
+	}
+	aud := claimStrings(claims["aud"])
+	if !containsString(aud, p.ClientID) {
+		return nil, fmt.Errorf("ID token audience mismatch")
+	}
+	if azp := stringVal(claims, "azp"); azp != "" && azp != p.ClientID {
+		return nil, fmt.Errorf("ID token authorized party mismatch")
+	}
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return nil, fmt.Errorf("ID token expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(oidcClockSkew)) {
+		return nil, fmt.Errorf("ID token issued in the future")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && time.Unix(int64(nbf), 0).After(now.Add(oidcClockSkew)) {
+		return nil, fmt.Errorf("ID token not yet valid")
+	}
+	if stringVal(claims, "nonce") != nonce {
+		return nil, fmt.Errorf("ID token nonce mismatch")
+	}
+	return claims, nil
+}
+
+// verifyJWS verifies a JWS signature for the RS*, PS* and ES* algorithms.
+func verifyJWS(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported ID token algorithm %q", alg)
+	}
+	var h hash.Hash
+	var ch crypto.Hash
+	switch alg[2:] {
+	case "256":
+		h, ch = sha256.New(), crypto.SHA256
+	case "384":
+		h, ch = sha512.New384(), crypto.SHA384
+	case "512":
+		h, ch = sha512.New(), crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported ID token algorithm %q", alg)
+	}
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("ID token key type does not match algorithm %q", alg)
+		}
+		var err error
+		if alg[0] == 'R' {
+			err = rsa.VerifyPKCS1v15(pub, ch, digest, sig)
+		} else {
+			err = rsa.VerifyPSS(pub, ch, digest, sig, nil)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid ID token signature")
+		}
+	case strings.HasPrefix(alg, "ES"):
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("ID token key type does not match algorithm %q", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return fmt.Errorf("invalid ID token signature")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("invalid ID token signature")
+		}
+	default:
+		return fmt.Errorf("unsupported ID token algorithm %q", alg)
+	}
+	return nil
+}
+
+// jsonWebKey is an RSA or EC public key from a JWKS document.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("EC key is not on curve")
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// getJSON fetches url and decodes the JSON response into v.
+func (sc *SSOClient) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := sc.getHTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// claimStrings converts a string or array claim to a string slice.
+func claimStrings(v interface{}) []string {
+	switch t := v.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		out := make([]string, 0, len(t))
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,692 @@
+package auth
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"askflow/internal/config"
+)
+
+// SAML and XML-DSig namespaces and algorithm identifiers.
+const (
+	samlProtocolNS  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	samlAssertionNS = "urn:oasis:names:tc:SAML:2.0:assertion"
+	samlMetadataNS  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	samlStatusOK    = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	samlBearer      = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	samlPOSTBinding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	samlNameIDEmail = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+
+	xmlNS           = "http://www.w3.org/XML/1998/namespace"
+	dsigNS          = "http://www.w3.org/2000/09/xmldsig#"
+	excC14NAlg      = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	envelopedAlg    = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	rsaSHA256Alg    = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	rsaSHA512Alg    = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	sha256DigestAlg = "http://www.w3.org/2001/04/xmlenc#sha256"
+	sha512DigestAlg = "http://www.w3.org/2001/04/xmlenc#sha512"
+)
+
+// samlClockSkew is the tolerance applied to assertion validity windows.
+const samlClockSkew = 2 * time.Minute
+
+// SAMLAuthURL starts an SP-initiated SAML login and returns the IdP URL
+// carrying a deflated AuthnRequest (HTTP-Redirect binding).
+func (sc *SSOClient) SAMLAuthURL(provider string) (string, error) {
+	p, ok := sc.samlProvider(provider)
+	if !ok {
+		return "", fmt.Errorf("unknown SAML provider: %s", provider)
+	}
+	id, err := randomHex(20)
+	if err != nil {
+		return "", err
+	}
+	id = "_" + id
+
+	var req bytes.Buffer
+	fmt.Fprintf(&req, `<samlp:AuthnRequest xmlns:samlp="%s" xmlns:saml="%s" ID="%s" Version="2.0" IssueInstant="%s" Destination="%s" AssertionConsumerServiceURL="%s" ProtocolBinding="%s">`,
+		samlProtocolNS, samlAssertionNS, id, time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		xmlEscape(p.IdPSSOURL), xmlEscape(p.ACSURL), samlPOSTBinding)
+	fmt.Fprintf(&req, `<saml:Issuer>%s</saml:Issuer>`, xmlEscape(p.SPEntityID))
+	req.WriteString(`<samlp:NameIDPolicy AllowCreate="true"/></samlp:AuthnRequest>`)
+
+	var deflated bytes.Buffer
+	fw, err := flate.NewWriter(&deflated, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	fw.Write(req.Bytes())
+	fw.Close()
+
+	sc.startLogin(id, ssoPending{provider: provider, kind: SSOTypeSAML})
+
+	sep := "?"
+	if strings.Contains(p.IdPSSOURL, "?") {
+		sep = "&"
+	}
+	return p.IdPSSOURL + sep + "SAMLRequest=" + url.QueryEscape(base64.StdEncoding.EncodeToString(deflated.Bytes())), nil
+}
+
+// SAMLMetadata returns the SP metadata document for a provider, for import
+// into the IdP (Azure AD enterprise app, Okta SAML app, etc.).
+func (sc *SSOClient) SAMLMetadata(provider string) ([]byte, error) {
+	p, ok := sc.samlProvider(provider)
+	if !ok {
+		return nil, fmt.Errorf("unknown SAML provider: %s", provider)
+	}
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	fmt.Fprintf(&buf, `<md:EntityDescriptor xmlns:md="%s" entityID="%s">`, samlMetadataNS, xmlEscape(p.SPEntityID))
+	fmt.Fprintf(&buf, `<md:SPSSODescriptor AuthnRequestsSigned="false" WantAssertionsSigned="true" protocolSupportEnumeration="%s">`, samlProtocolNS)
+	fmt.Fprintf(&buf, `<md:NameIDFormat>%s</md:NameIDFormat>`, samlNameIDEmail)
+	fmt.Fprintf(&buf, `<md:AssertionConsumerService Binding="%s" Location="%s" index="0" isDefault="true"/>`, samlPOSTBinding, xmlEscape(p.ACSURL))
+	buf.WriteString(`</md:SPSSODescriptor></md:EntityDescriptor>`)
+	return buf.Bytes(), nil
+}
+
+// SAMLConsume validates a base64 SAMLResponse posted to the provider's ACS
+// URL and returns the asserted user. The response must answer a request
+// started by SAMLAuthURL (IdP-initiated login is not supported), and the
+// assertion or the response must carry an enveloped RSA-SHA256/512
+// signature made with the configured IdP certificate. Encrypted assertions
+// are not supported.
+func (sc *SSOClient) SAMLConsume(provider, encoded string) (*SSOUser, error) {
+	p, ok := sc.samlProvider(provider)
+	if !ok {
+		return nil, fmt.Errorf("unknown SAML provider: %s", provider)
+	}
+	cert, err := parseCertificate(p.IdPCertificate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IdP certificate: %w", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAMLResponse encoding")
+	}
+	root, err := parseXMLTree(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAMLResponse: %w", err)
+	}
+	if !root.is(samlProtocolNS, "Response") {
+		return nil, fmt.Errorf("SAMLResponse is not a Response")
+	}
+	if code := root.child(samlProtocolNS, "Status").child(samlProtocolNS, "StatusCode"); code.attr("Value") != samlStatusOK {
+		return nil, fmt.Errorf("IdP returned status %q", code.attr("Value"))
+	}
+	if d := root.attr("Destination"); d != "" && d != p.ACSURL {
+		return nil, fmt.Errorf("SAMLResponse destination mismatch")
+	}
+	if len(root.children(samlAssertionNS, "EncryptedAssertion")) > 0 {
+		return nil, fmt.Errorf("encrypted assertions are not supported")
+	}
+	assertions := root.children(samlAssertionNS, "Assertion")
+	if len(assertions) != 1 {
+		return nil, fmt.Errorf("SAMLResponse must contain exactly one assertion")
+	}
+	assertion := assertions[0]
+	if err := checkUniqueIDs(root); err != nil {
+		return nil, err
+	}
+
+	// Only data inside a verified element is trusted from here on.
+	responseSigned := root.child(dsigNS, "Signature") != nil
+	assertionSigned := assertion.child(dsigNS, "Signature") != nil
+	if !responseSigned && !assertionSigned {
+		return nil, fmt.Errorf("SAMLResponse is not signed")
+	}
+	if responseSigned {
+		if err := verifyEnvelopedSignature(root, cert); err != nil {
+			return nil, err
+		}
+	}
+	if assertionSigned {
+		if err := verifyEnvelopedSignature(assertion, cert); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.IdPEntityID != "" && assertion.child(samlAssertionNS, "Issuer").text() != p.IdPEntityID {
+		return nil, fmt.Errorf("assertion issuer mismatch")
+	}
+	now := time.Now()
+	if err := checkSAMLConditions(assertion.child(samlAssertionNS, "Conditions"), p.SPEntityID, now); err != nil {
+		return nil, err
+	}
+
+	subject := assertion.child(samlAssertionNS, "Subject")
+	nameID := strings.TrimSpace(subject.child(samlAssertionNS, "NameID").text())
+	if nameID == "" {
+		return nil, fmt.Errorf("assertion has no NameID")
+	}
+	var requestID string
+	for _, conf := range subject.children(samlAssertionNS, "SubjectConfirmation") {
+		if conf.attr("Method") != samlBearer {
+			continue
+		}
+		data := conf.child(samlAssertionNS, "SubjectConfirmationData")
+		if data == nil || data.attr("Recipient") != p.ACSURL {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, data.attr("NotOnOrAfter")); err != nil || !now.Before(t.Add(samlClockSkew)) {
+			continue
+		}
+		requestID = data.attr("InResponseTo")
+		break
+	}
+	if requestID == "" {
+		return nil, fmt.Errorf("assertion has no valid bearer subject confirmation")
+	}
+	if rt := root.attr("InResponseTo"); rt != "" && rt != requestID {
+		return nil, fmt.Errorf("SAMLResponse InResponseTo mismatch")
+	}
+	pending, err := sc.finishLogin(requestID, SSOTypeSAML)
+	if err != nil {
+		return nil, err
+	}
+	if pending.provider != provider {
+		return nil, fmt.Errorf("SAMLResponse was sent to the wrong provider")
+	}
+
+	attrs := samlAttributes(assertion)
+	user := &SSOUser{
+		Provider: provider,
+		Type:     SSOTypeSAML,
+		Subject:  nameID,
+		Email:    nameID,
+	}
+	if p.EmailAttribute != "" {
+		if v := attrs[p.EmailAttribute]; len(v) > 0 {
+			user.Email = v[0]
+		}
+	}
+	if !strings.Contains(user.Email, "@") {
+		user.Email = ""
+	}
+	if p.NameAttribute != "" {
+		if v := attrs[p.NameAttribute]; len(v) > 0 {
+			user.Name = v[0]
+		}
+	}
+	if user.Name == "" {
+		user.Name = displayName(user.Email, nameID)
+	}
+	if p.RoleAttribute != "" {
+		user.Roles = attrs[p.RoleAttribute]
+	}
+	return user, nil
+}
+
+func (sc *SSOClient) samlProvider(name string) (config.SAMLProviderConfig, bool) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	p, ok := sc.saml[name]
+	if !ok || p.IdPSSOURL == "" || p.ACSURL == "" || p.SPEntityID == "" {
+		return config.SAMLProviderConfig{}, false
+	}
+	return p, true
+}
+
+// checkSAMLConditions validates the assertion validity window and audience.
+func checkSAMLConditions(cond *xmlNode, audience string, now time.Time) error {
+	if cond == nil {
+		return nil
+	}
+	if v := cond.attr("NotBefore"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil || now.Add(samlClockSkew).Before(t) {
+			return fmt.Errorf("assertion is not yet valid")
+		}
+	}
+	if v := cond.attr("NotOnOrAfter"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil || !now.Before(t.Add(samlClockSkew)) {
+			return fmt.Errorf("assertion has expired")
+		}
+	}
+	for _, ar := range cond.children(samlAssertionNS, "AudienceRestriction") {
+		ok := false
+		for _, a := range ar.children(samlAssertionNS, "Audience") {
+			if strings.TrimSpace(a.text()) == audience {
+				ok = true
+			}
+		}
+		if !ok {
+			return fmt.Errorf("assertion audience mismatch")
+		}
+	}
+	return nil
+}
+
+// samlAttributes returns attribute values keyed by both Name and FriendlyName.
+func samlAttributes(assertion *xmlNode) map[string][]string {
+	out := make(map[string][]string)
+	for _, st := range assertion.children(samlAssertionNS, "AttributeStatement") {
+		for _, a := range st.children(samlAssertionNS, "Attribute") {
+			var values []string
+			for _, v := range a.children(samlAssertionNS, "AttributeValue") {
+				values = append(values, strings.TrimSpace(v.text()))
+			}
+			for _, key := range []string{a.attr("Name"), a.attr("FriendlyName")} {
+				if key != "" {
+					out[key] = append(out[key], values...)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// parseCertificate accepts a PEM certificate or bare base64 DER (as shown in
+// IdP metadata).
+func parseCertificate(s string) (*x509.Certificate, error) {
+	s = strings.TrimSpace(s)
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		return x509.ParseCertificate(block.Bytes)
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// --- XML-DSig verification ---
+
+// verifyEnvelopedSignature verifies the ds:Signature child of el, which must
+// sign el itself (Reference URI "#<ID>") using exclusive canonicalization.
+func verifyEnvelopedSignature(el *xmlNode, cert *x509.Certificate) error {
+	sigs := el.children(dsigNS, "Signature")
+	if len(sigs) != 1 {
+		return fmt.Errorf("expected exactly one signature")
+	}
+	sig := sigs[0]
+	signedInfo := sig.child(dsigNS, "SignedInfo")
+	if signedInfo == nil {
+		return fmt.Errorf("signature has no SignedInfo")
+	}
+	c14n := signedInfo.child(dsigNS, "CanonicalizationMethod")
+	if c14n.attr("Algorithm") != excC14NAlg {
+		return fmt.Errorf("unsupported canonicalization method %q", c14n.attr("Algorithm"))
+	}
+
+	var sigHash crypto.Hash
+	switch signedInfo.child(dsigNS, "SignatureMethod").attr("Algorithm") {
+	case rsaSHA256Alg:
+		sigHash = crypto.SHA256
+	case rsaSHA512Alg:
+		sigHash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signature method")
+	}
+
+	refs := signedInfo.children(dsigNS, "Reference")
+	if len(refs) != 1 {
+		return fmt.Errorf("expected exactly one signature reference")
+	}
+	ref := refs[0]
+	id := el.attr("ID")
+	if id == "" || ref.attr("URI") != "#"+id {
+		return fmt.Errorf("signature does not reference the signed element")
+	}
+
+	var prefixes []string
+	excC14N := false
+	for _, t := range ref.child(dsigNS, "Transforms").children(dsigNS, "Transform") {
+		switch t.attr("Algorithm") {
+		case envelopedAlg:
+		case excC14NAlg:
+			excC14N = true
+			prefixes = inclusivePrefixes(t)
+		default:
+			return fmt.Errorf("unsupported signature transform %q", t.attr("Algorithm"))
+		}
+	}
+	if !excC14N {
+		return fmt.Errorf("signature reference must use exclusive canonicalization")
+	}
+
+	var h hash.Hash
+	switch ref.child(dsigNS, "DigestMethod").attr("Algorithm") {
+	case sha256DigestAlg:
+		h = sha256.New()
+	case sha512DigestAlg:
+		h = sha512.New()
+	default:
+		return fmt.Errorf("unsupported digest method")
+	}
+	h.Write(canonicalize(el, sig, prefixes))
+	want, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(ref.child(dsigNS, "DigestValue").text()), ""))
+	if err != nil || subtle.ConstantTimeCompare(h.Sum(nil), want) != 1 {
+		return fmt.Errorf("signature digest mismatch")
+	}
+
+	sigValue, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(sig.child(dsigNS, "SignatureValue").text()), ""))
+	if err != nil {
+		return fmt.Errorf("invalid signature value")
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("IdP certificate must hold an RSA key")
+	}
+	sh := sigHash.New()
+	sh.Write(canonicalize(signedInfo, nil, inclusivePrefixes(c14n)))
+	if err := rsa.VerifyPKCS1v15(pub, sigHash, sh.Sum(nil), sigValue); err != nil {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// inclusivePrefixes returns the ec:InclusiveNamespaces PrefixList of an
+// exclusive canonicalization method or transform.
+func inclusivePrefixes(n *xmlNode) []string {
+	for _, c := range n.elems() {
+		if c.is(excC14NAlg, "InclusiveNamespaces") {
+			return strings.Fields(c.attr("PrefixList"))
+		}
+	}
+	return nil
+}
+
+// checkUniqueIDs rejects documents with duplicate ID attributes, which
+// signature wrapping attacks rely on.
+func checkUniqueIDs(root *xmlNode) error {
+	seen := make(map[string]bool)
+	var walk func(n *xmlNode) error
+	walk = func(n *xmlNode) error {
+		if id := n.attr("ID"); id != "" {
+			if seen[id] {
+				return fmt.Errorf("duplicate ID %q in SAMLResponse", id)
+			}
+			seen[id] = true
+		}
+		for _, c := range n.elems() {
+			if err := walk(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(root)
+}
+
+// --- Minimal XML tree with exclusive canonicalization ---
+
+// xmlNode is an element parsed with its raw prefixes preserved, as required
+// for canonicalization. Nodes are *xmlNode or string (character data).
+type xmlNode struct {
+	parent *xmlNode
+	prefix string
+	local  string
+	attrs  []xml.Attr // raw attributes including xmlns declarations
+	nodes  []interface{}
+}
+
+// parseXMLTree parses data into a tree. Comments and processing instructions
+// are dropped; DTDs are rejected.
+func parseXMLTree(data []byte) (*xmlNode, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	var root, cur *xmlNode
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{parent: cur, prefix: t.Name.Space, local: t.Name.Local, attrs: append([]xml.Attr(nil), t.Attr...)}
+			if cur == nil {
+				if root != nil {
+					return nil, fmt.Errorf("multiple root elements")
+				}
+				root = n
+			} else {
+				cur.nodes = append(cur.nodes, n)
+			}
+			cur = n
+		case xml.EndElement:
+			if cur == nil || cur.prefix != t.Name.Space || cur.local != t.Name.Local {
+				return nil, fmt.Errorf("mismatched end element")
+			}
+			cur = cur.parent
+		case xml.CharData:
+			if cur != nil {
+				cur.nodes = append(cur.nodes, string(t))
+			}
+		case xml.Directive:
+			return nil, fmt.Errorf("DTDs are not allowed")
+		}
+	}
+	if root == nil || cur != nil {
+		return nil, fmt.Errorf("incomplete document")
+	}
+	return root, nil
+}
+
+// lookupNS returns the namespace URI bound to prefix in n's scope.
+func (n *xmlNode) lookupNS(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return xmlNS, true
+	}
+	for e := n; e != nil; e = e.parent {
+		for _, a := range e.attrs {
+			if (prefix == "" && a.Name.Space == "" && a.Name.Local == "xmlns") ||
+				(prefix != "" && a.Name.Space == "xmlns" && a.Name.Local == prefix) {
+				return a.Value, true
+			}
+		}
+	}
+	return "", prefix == ""
+}
+
+// is reports whether n is the element {ns}local.
+func (n *xmlNode) is(ns, local string) bool {
+	if n == nil || n.local != local {
+		return false
+	}
+	uri, _ := n.lookupNS(n.prefix)
+	return uri == ns
+}
+
+func (n *xmlNode) elems() []*xmlNode {
+	if n == nil {
+		return nil
+	}
+	var out []*xmlNode
+	for _, c := range n.nodes {
+		if e, ok := c.(*xmlNode); ok {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// children returns the child elements named {ns}local.
+func (n *xmlNode) children(ns, local string) []*xmlNode {
+	var out []*xmlNode
+	for _, c := range n.elems() {
+		if c.is(ns, local) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// child returns the first child element named {ns}local, or nil. Calling
+// methods on a nil result is safe.
+func (n *xmlNode) child(ns, local string) *xmlNode {
+	if c := n.children(ns, local); len(c) > 0 {
+		return c[0]
+	}
+	return nil
+}
+
+// attr returns the value of an unprefixed attribute.
+func (n *xmlNode) attr(name string) string {
+	if n == nil {
+		return ""
+	}
+	for _, a := range n.attrs {
+		if a.Name.Space == "" && a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// text returns the concatenated character data of n's direct children.
+func (n *xmlNode) text() string {
+	if n == nil {
+		return ""
+	}
+	var sb strings.Builder
+	for _, c := range n.nodes {
+		if s, ok := c.(string); ok {
+			sb.WriteString(s)
+		}
+	}
+	return sb.String()
+}
+
+// canonicalize serializes n with Exclusive XML Canonicalization (without
+// comments), omitting the subtree exclude (the enveloped signature).
+// inclusive lists prefixes ("#default" for the default namespace) that are
+// rendered whenever in scope, per the InclusiveNamespaces PrefixList.
+func canonicalize(n *xmlNode, exclude *xmlNode, inclusive []string) []byte {
+	var buf bytes.Buffer
+	incl := make(map[string]bool, len(inclusive))
+	for _, p := range inclusive {
+		if p == "#default" {
+			p = ""
+		}
+		incl[p] = true
+	}
+	writeCanonical(&buf, n, exclude, incl, map[string]string{})
+	return buf.Bytes()
+}
+
+func writeCanonical(buf *bytes.Buffer, n *xmlNode, exclude *xmlNode, incl map[string]bool, rendered map[string]string) {
+	// Namespaces visibly utilized by the element and its attributes
+	used := map[string]bool{n.prefix: true}
+	for _, a := range n.attrs {
+		if a.Name.Space != "" && a.Name.Space != "xmlns" && a.Name.Space != "xml" {
+			used[a.Name.Space] = true
+		}
+	}
+	for p := range incl {
+		if _, ok := n.lookupNS(p); ok {
+			used[p] = true
+		}
+	}
+
+	type nsDecl struct{ prefix, uri string }
+	var decls []nsDecl
+	scope := rendered
+	for p := range used {
+		uri, _ := n.lookupNS(p)
+		prev, seen := rendered[p]
+		if (p == "" && uri == "" && !seen) || (seen && prev == uri) || (p != "" && uri == "") {
+			continue
+		}
+		decls = append(decls, nsDecl{p, uri})
+	}
+	if len(decls) > 0 {
+		scope = make(map[string]string, len(rendered)+len(decls))
+		for k, v := range rendered {
+			scope[k] = v
+		}
+		for _, d := range decls {
+			scope[d.prefix] = d.uri
+		}
+	}
+	sort.Slice(decls, func(i, j int) bool { return decls[i].prefix < decls[j].prefix })
+
+	type attr struct{ uri, name, value string }
+	var attrs []attr
+	for _, a := range n.attrs {
+		if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+			continue
+		}
+		name, uri := a.Name.Local, ""
+		if a.Name.Space != "" {
+			name = a.Name.Space + ":" + a.Name.Local
+			uri, _ = n.lookupNS(a.Name.Space)
+		}
+		attrs = append(attrs, attr{uri, name, a.Value})
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].uri != attrs[j].uri {
+			return attrs[i].uri < attrs[j].uri
+		}
+		return localName(attrs[i].name) < localName(attrs[j].name)
+	})
+
+	name := n.local
+	if n.prefix != "" {
+		name = n.prefix + ":" + n.local
+	}
+	buf.WriteString("<" + name)
+	for _, d := range decls {
+		if d.prefix == "" {
+			buf.WriteString(` xmlns="`)
+		} else {
+			buf.WriteString(` xmlns:` + d.prefix + `="`)
+		}
+		buf.WriteString(c14nAttrEscape(d.uri) + `"`)
+	}
+	for _, a := range attrs {
+		buf.WriteString(" " + a.name + `="` + c14nAttrEscape(a.value) + `"`)
+	}
+	buf.WriteString(">")
+	for _, c := range n.nodes {
+		switch t := c.(type) {
+		case string:
+			buf.WriteString(c14nTextEscape(t))
+		case *xmlNode:
+			if t != exclude {
+				writeCanonical(buf, t, exclude, incl, scope)
+			}
+		}
+	}
+	buf.WriteString("</" + name + ">")
+}
+
+func localName(qname string) string {
+	if i := strings.IndexByte(qname, ':'); i >= 0 {
+		return qname[i+1:]
+	}
+	return qname
+}
+
+var c14nTextReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+
+var c14nAttrReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+
+func c14nTextEscape(s string) string { return c14nTextReplacer.Replace(s) }
+
+func c14nAttrEscape(s string) string { return c14nAttrReplacer.Replace(s) }
+
+// xmlEscape escapes s for use in XML text or attribute values.
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
@@ -2,17 +2,32 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
+	"askflow/internal/sharedcache"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
-// DefaultSessionExpiry is the default session duration (24 hours).
-const DefaultSessionExpiry = 24 * time.Hour
+// DefaultSessionExpiry is the default lifetime of an access token (15 minutes).
+// Clients renew it with the refresh token before it runs out.
+const DefaultSessionExpiry = 15 * time.Minute
+
+// DefaultRefreshExpiry is how long a login can be renewed with refresh tokens
+// (7 days from the original login; rotation does not extend it).
+const DefaultRefreshExpiry = 7 * 24 * time.Hour
+
+// ErrRefreshTokenReused is returned when an already rotated refresh token is
+// presented again. The whole login (token family) is revoked when this happens.
+var ErrRefreshTokenReused = errors.New("refresh token reuse detected")
 
 // sessionCacheSize is the maximum number of sessions to cache in memory.
 const sessionCacheSize = 1024
@@ -23,6 +38,11 @@ type Session struct {
 	UserID    string    `json:"user_id"`
 	ExpiresAt time.Time `json:"expires_at"`
 	CreatedAt time.Time `json:"created_at"`
+
+	// RefreshToken is only set on sessions returned by CreateSession and
+	// Refresh; the database stores its SHA-256 hash.
+	RefreshToken     string    `json:"refresh_token,omitempty"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at,omitzero"`
 }
 
 // sessionCacheEntry wraps a cached session with a fetch timestamp for TTL.
@@ -35,7 +55,9 @@ type sessionCacheEntry struct {
 type SessionManager struct {
 	readDB  *sql.DB
 	writeDB *sql.DB
-	expiry  time.Duration
+	expiry  time.Duration // access token lifetime
+
+	refreshExpiry time.Duration
 
 	// In-memory LRU-like cache for ValidateSession hot path.
 	// Key: session ID, Value: sessionCacheEntry.
@@ -43,8 +65,24 @@ type SessionManager struct {
 	cache   map[string]sessionCacheEntry
 	// cacheTTL controls how long a cached session is considered fresh.
 	cacheTTL time.Duration
+	// shared replaces the in-memory cache when set (see SetSharedCache).
+	shared *sharedcache.Cache
+}
+
+// sharedSessionEntry is a session in the shared cache. Epoch is the value
+// of the flush counter when it was cached; flushing bumps the counter, which
+// invalidates all entries at once.
+type sharedSessionEntry struct {
+	Epoch   int64   `json:"epoch"`
+	Session Session `json:"session"`
 }
 
+// Keys of the shared session cache.
+const (
+	sharedSessionPrefix = "session:"
+	sharedSessionEpoch  = "session-epoch"
+)
+
 // NewSessionManager creates a SessionManager with the given database and expiry duration.
 // If expiry is zero, DefaultSessionExpiry is used.
 func NewSessionManager(readDB, writeDB *sql.DB, expiry time.Duration) *SessionManager {
@@ -52,23 +90,59 @@ func NewSessionManager(readDB, writeDB *sql.DB, expiry time.Duration) *SessionMa
 		expiry = DefaultSessionExpiry
 	}
 	return &SessionManager{
-		readDB:   readDB,
-		writeDB:  writeDB,
-		expiry:   expiry,
-		cache:    make(map[string]sessionCacheEntry, sessionCacheSize),
-		cacheTTL: 2 * time.Minute,
+		readDB:        readDB,
+		writeDB:       writeDB,
+		expiry:        expiry,
+		refreshExpiry: DefaultRefreshExpiry,
+		cache:         make(map[string]sessionCacheEntry, sessionCacheSize),
+		cacheTTL:      2 * time.Minute,
 	}
 }
 
-// CreateSession creates a new session for the given user ID and stores it in the database.
+// SetSharedCache makes the session cache live in c, shared with the other
+// instances using it, so that a logout on one instance takes effect on all
+// of them. Call it before serving.
+func (sm *SessionManager) SetSharedCache(c *sharedcache.Cache) {
+	sm.shared = c
+}
+
+// CreateSession starts a new login for userID: it creates a short-lived
+// access session together with a refresh token in a new token family.
 func (sm *SessionManager) CreateSession(userID string) (*Session, error) {
+	familyID, err := generateSessionID()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := sm.writeDB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin session tx: %w", err)
+	}
+	defer tx.Rollback()
+	s, err := sm.issue(tx, userID, familyID, time.Now().UTC().Add(sm.refreshExpiry))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit session: %w", err)
+	}
+	sm.cacheSet(s.ID, s)
+	return s, nil
+}
+
+// CreateAccessSession creates a session without a refresh token that lives
+// for ttl (the access token lifetime if ttl is zero). It is meant for
+// sessions that are re-created rather than renewed, such as widget visitors.
+func (sm *SessionManager) CreateAccessSession(userID string, ttl time.Duration) (*Session, error) {
+	if ttl <= 0 {
+		ttl = sm.expiry
+	}
 	id, err := generateSessionID()
 	if err != nil {
 		return nil, err
 	}
 
 	now := time.Now().UTC()
-	expiresAt := now.Add(sm.expiry)
+	expiresAt := now.Add(ttl)
 
 	_, err = sm.writeDB.Exec(
 		"INSERT INTO sessions (id, user_id, expires_at, created_at) VALUES (?, ?, ?, ?)",
@@ -91,6 +165,129 @@ func (sm *SessionManager) CreateSession(userID string) (*Session, error) {
 	return s, nil
 }
 
+// Refresh rotates a refresh token: the presented token is marked used, the
+// access session issued with it is revoked, and a new access session and
+// refresh token in the same family are returned. Presenting a used token
+// again means it was copied, so the whole family is revoked and
+// ErrRefreshTokenReused is returned.
+func (sm *SessionManager) Refresh(refreshToken string) (*Session, error) {
+	tx, err := sm.writeDB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin refresh tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var familyID, userID, sessionID, expiresAtStr string
+	var usedAt sql.NullString
+	err = tx.QueryRow(
+		"SELECT family_id, user_id, session_id, expires_at, used_at FROM refresh_tokens WHERE id = ?",
+		hashRefreshToken(refreshToken),
+	).Scan(&familyID, &userID, &sessionID, &expiresAtStr, &usedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("refresh token not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query refresh token: %w", err)
+	}
+
+	if usedAt.Valid {
+		if err := revokeFamily(tx, familyID); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("commit revocation: %w", err)
+		}
+		sm.cacheFlush()
+		return nil, ErrRefreshTokenReused
+	}
+
+	expiresAt, err := parseSessionTime(expiresAtStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse expires_at: %w", err)
+	}
+	now := time.Now().UTC()
+	if now.After(expiresAt) {
+		return nil, fmt.Errorf("refresh token expired")
+	}
+
+	if _, err := tx.Exec("UPDATE refresh_tokens SET used_at = ? WHERE id = ?",
+		now.Format(time.RFC3339), hashRefreshToken(refreshToken)); err != nil {
+		return nil, fmt.Errorf("mark refresh token used: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM sessions WHERE id = ?", sessionID); err != nil {
+		return nil, fmt.Errorf("delete rotated session: %w", err)
+	}
+	s, err := sm.issue(tx, userID, familyID, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit refresh: %w", err)
+	}
+	sm.cacheDelete(sessionID)
+	sm.cacheSet(s.ID, s)
+	return s, nil
+}
+
+// issue inserts an access session and a refresh token belonging to familyID.
+func (sm *SessionManager) issue(tx *sql.Tx, userID, familyID string, refreshExpiresAt time.Time) (*Session, error) {
+	id, err := generateSessionID()
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := generateSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(sm.expiry)
+	if expiresAt.After(refreshExpiresAt) {
+		expiresAt = refreshExpiresAt
+	}
+
+	if _, err := tx.Exec(
+		"INSERT INTO sessions (id, user_id, expires_at, created_at) VALUES (?, ?, ?, ?)",
+		id, userID, expiresAt.Format(time.RFC3339), now.Format(time.RFC3339),
+	); err != nil {
+		return nil, fmt.Errorf("insert session: %w", err)
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO refresh_tokens (id, family_id, session_id, user_id, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		hashRefreshToken(refreshToken), familyID, id, userID,
+		refreshExpiresAt.Format(time.RFC3339), now.Format(time.RFC3339),
+	); err != nil {
+		return nil, fmt.Errorf("insert refresh token: %w", err)
+	}
+
+	return &Session{
+		ID:               id,
+		UserID:           userID,
+		ExpiresAt:        expiresAt,
+		CreatedAt:        now,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refreshExpiresAt,
+	}, nil
+}
+
+// revokeFamily deletes every refresh token of a login, its access session
+// and its device.
+func revokeFamily(tx *sql.Tx, familyID string) error {
+	if _, err := tx.Exec(
+		"DELETE FROM sessions WHERE id IN (SELECT session_id FROM refresh_tokens WHERE family_id = ?)",
+		familyID,
+	); err != nil {
+		return fmt.Errorf("revoke family sessions: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM refresh_tokens WHERE family_id = ?", familyID); err != nil {
+		return fmt.Errorf("revoke family tokens: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM login_devices WHERE family_id = ?", familyID); err != nil {
+		return fmt.Errorf("revoke family device: %w", err)
+	}
+	return nil
+}
+
 // ValidateSession checks if a session exists and has not expired.
 // Returns the session if valid, or an error if not found or expired.
 // Uses an in-memory cache to avoid DB hits on every authenticated request.
@@ -108,15 +305,6 @@ func (sm *SessionManager) ValidateSession(sessionID string) (*Session, error) {
 			sm.writeDB.Exec("DELETE FROM sessions WHERE id = ?", sessionID)
 			return nil, fmt.Errorf("session expired (max age)")
 		}
-		// Sliding window: extend session expiry on each successful validation
-		remaining := time.Until(s.ExpiresAt)
-		if remaining < sm.expiry/2 {
-			newExpiry := time.Now().UTC().Add(sm.expiry)
-			sm.writeDB.Exec("UPDATE sessions SET expires_at = ? WHERE id = ?",
-				newExpiry.Format(time.RFC3339), sessionID)
-			s.ExpiresAt = newExpiry
-			sm.cacheSet(sessionID, s)
-		}
 		return s, nil
 	}
 
@@ -135,21 +323,15 @@ func (sm *SessionManager) ValidateSession(sessionID string) (*Session, error) {
 		return nil, fmt.Errorf("query session: %w", err)
 	}
 
-	expiresAt, err := time.Parse(time.RFC3339, expiresAtStr)
+	expiresAt, err := parseSessionTime(expiresAtStr)
 	if err != nil {
-		expiresAt, err = time.Parse("2006-01-02T15:04:05Z", expiresAtStr)
-		if err != nil {
-			return nil, fmt.Errorf("parse expires_at: %w", err)
-		}
+		return nil, fmt.Errorf("parse expires_at: %w", err)
 	}
 	s.ExpiresAt = expiresAt
 
-	createdAt, err := time.Parse(time.RFC3339, createdAtStr)
+	createdAt, err := parseSessionTime(createdAtStr)
 	if err != nil {
-		createdAt, err = time.Parse("2006-01-02T15:04:05Z", createdAtStr)
-		if err != nil {
-			return nil, fmt.Errorf("parse created_at: %w", err)
-		}
+		return nil, fmt.Errorf("parse created_at: %w", err)
 	}
 	s.CreatedAt = createdAt
 
@@ -163,43 +345,55 @@ func (sm *SessionManager) ValidateSession(sessionID string) (*Session, error) {
 		return nil, fmt.Errorf("session expired (max age)")
 	}
 
-	// Sliding window: extend session expiry on each successful validation
-	remaining := time.Until(s.ExpiresAt)
-	if remaining < sm.expiry/2 {
-		newExpiry := time.Now().UTC().Add(sm.expiry)
-		sm.writeDB.Exec("UPDATE sessions SET expires_at = ? WHERE id = ?",
-			newExpiry.Format(time.RFC3339), sessionID)
-		s.ExpiresAt = newExpiry
-	}
-
 	// Cache the valid session
 	sm.cacheSet(sessionID, &s)
 
 	return &s, nil
 }
 
-// CleanExpired removes all expired sessions from the database.
+// CleanExpired removes all expired sessions and refresh tokens from the database.
 // Returns the number of sessions removed.
 func (sm *SessionManager) CleanExpired() (int64, error) {
-	result, err := sm.writeDB.Exec(
-		"DELETE FROM sessions WHERE expires_at <= ?",
-		time.Now().UTC().Format(time.RFC3339),
-	)
+	now := time.Now().UTC().Format(time.RFC3339)
+	result, err := sm.writeDB.Exec("DELETE FROM sessions WHERE expires_at <= ?", now)
 	if err != nil {
 		return 0, fmt.Errorf("delete expired sessions: %w", err)
 	}
+	if _, err := sm.writeDB.Exec("DELETE FROM refresh_tokens WHERE expires_at <= ?", now); err != nil {
+		return 0, fmt.Errorf("delete expired refresh tokens: %w", err)
+	}
+	if _, err := sm.writeDB.Exec(
+		"DELETE FROM login_devices WHERE family_id NOT IN (SELECT family_id FROM refresh_tokens)",
+	); err != nil {
+		return 0, fmt.Errorf("delete expired devices: %w", err)
+	}
 	// Flush cache on bulk cleanup since we can't know which entries were deleted
 	sm.cacheFlush()
 	return result.RowsAffected()
 }
 
-// DeleteSession removes a specific session by ID.
+// DeleteSession removes a specific session by ID and revokes the refresh
+// tokens of the login it belongs to.
 func (sm *SessionManager) DeleteSession(sessionID string) error {
 	sm.cacheDelete(sessionID)
 	_, err := sm.writeDB.Exec("DELETE FROM sessions WHERE id = ?", sessionID)
 	if err != nil {
 		return fmt.Errorf("delete session: %w", err)
 	}
+	_, err = sm.writeDB.Exec(
+		"DELETE FROM login_devices WHERE family_id IN (SELECT family_id FROM refresh_tokens WHERE session_id = ?)",
+		sessionID,
+	)
+	if err != nil {
+		return fmt.Errorf("delete device: %w", err)
+	}
+	_, err = sm.writeDB.Exec(
+		"DELETE FROM refresh_tokens WHERE family_id IN (SELECT family_id FROM refresh_tokens WHERE session_id = ?)",
+		sessionID,
+	)
+	if err != nil {
+		return fmt.Errorf("delete refresh tokens: %w", err)
+	}
 	return nil
 }
 
@@ -210,6 +404,12 @@ func (sm *SessionManager) DeleteSessionsByUserID(userID string) error {
 	if err != nil {
 		return fmt.Errorf("delete sessions by user ID: %w", err)
 	}
+	if _, err := sm.writeDB.Exec("DELETE FROM refresh_tokens WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("delete refresh tokens by user ID: %w", err)
+	}
+	if _, err := sm.writeDB.Exec("DELETE FROM login_devices WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("delete devices by user ID: %w", err)
+	}
 	// Flush cache since we can't efficiently find all sessions for a user
 	sm.cacheFlush()
 	return nil
@@ -247,10 +447,29 @@ func generateSessionID() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
+// hashRefreshToken returns the hex SHA-256 of a refresh token, the form in
+// which refresh tokens are stored.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseSessionTime parses a timestamp stored in the sessions tables.
+func parseSessionTime(v string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		t, err = time.Parse("2006-01-02T15:04:05Z", v)
+	}
+	return t, err
+}
+
 // --- Session cache helpers ---
 
 // cacheGet returns a cached session if it exists and hasn't expired the cache TTL.
 func (sm *SessionManager) cacheGet(sessionID string) (*Session, bool) {
+	if sm.shared != nil {
+		return sm.sharedGet(sessionID)
+	}
 	sm.cacheMu.RLock()
 	entry, ok := sm.cache[sessionID]
 	sm.cacheMu.RUnlock()
@@ -271,8 +490,15 @@ func (sm *SessionManager) cacheGet(sessionID string) (*Session, bool) {
 // a random entry (simple probabilistic eviction, good enough for a bounded cache).
 // Stores a copy of the session to prevent external mutation of cached data.
 func (sm *SessionManager) cacheSet(sessionID string, s *Session) {
-	// Make a copy so the caller can't mutate the cached entry
+	// Make a copy so the caller can't mutate the cached entry; the refresh
+	// token is only handed out once and never cached.
 	sCopy := *s
+	sCopy.RefreshToken = ""
+	sCopy.RefreshExpiresAt = time.Time{}
+	if sm.shared != nil {
+		sm.sharedSet(sessionID, &sCopy)
+		return
+	}
 	sm.cacheMu.Lock()
 	defer sm.cacheMu.Unlock()
 	// Simple eviction: if at capacity, delete one random entry
@@ -287,6 +513,10 @@ func (sm *SessionManager) cacheSet(sessionID string, s *Session) {
 
 // cacheDelete removes a session from the cache.
 func (sm *SessionManager) cacheDelete(sessionID string) {
+	if sm.shared != nil {
+		sm.shared.Delete(sharedSessionKey(sessionID))
+		return
+	}
 	sm.cacheMu.Lock()
 	delete(sm.cache, sessionID)
 	sm.cacheMu.Unlock()
@@ -294,7 +524,46 @@ func (sm *SessionManager) cacheDelete(sessionID string) {
 
 // cacheFlush clears the entire session cache.
 func (sm *SessionManager) cacheFlush() {
+	if sm.shared != nil {
+		sm.shared.Incr(sharedSessionEpoch, 1, 0)
+		return
+	}
 	sm.cacheMu.Lock()
 	sm.cache = make(map[string]sessionCacheEntry, sessionCacheSize)
 	sm.cacheMu.Unlock()
 }
+
+// sharedGet returns a session from the shared cache if it was cached since
+// the last flush.
+func (sm *SessionManager) sharedGet(sessionID string) (*Session, bool) {
+	values := sm.shared.GetMulti(sharedSessionEpoch, sharedSessionKey(sessionID))
+	if values[1] == nil {
+		return nil, false
+	}
+	epoch, _ := strconv.ParseInt(string(values[0]), 10, 64)
+	var entry sharedSessionEntry
+	if err := json.Unmarshal(values[1], &entry); err != nil || entry.Epoch != epoch {
+		return nil, false
+	}
+	return &entry.Session, true
+}
+
+// sharedSet stores a session in the shared cache for the cache TTL.
+func (sm *SessionManager) sharedSet(sessionID string, s *Session) {
+	epoch := int64(0)
+	if v, ok := sm.shared.Get(sharedSessionEpoch); ok {
+		epoch, _ = strconv.ParseInt(string(v), 10, 64)
+	}
+	data, err := json.Marshal(sharedSessionEntry{Epoch: epoch, Session: *s})
+	if err != nil {
+		return
+	}
+	sm.shared.Set(sharedSessionKey(sessionID), data, sm.cacheTTL)
+}
+
+// sharedSessionKey returns the shared cache key of a session. Session IDs
+// are bearer tokens, so only their hash is stored.
+func sharedSessionKey(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return sharedSessionPrefix + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,271 @@
+package auth
+
+import (
+	cryptorand "crypto/rand"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"askflow/internal/config"
+)
+
+// SSO provider types.
+const (
+	SSOTypeOIDC = "oidc"
+	SSOTypeSAML = "saml"
+)
+
+// ssoLoginTTL bounds how long a started SSO login (state/request ID) stays valid.
+const ssoLoginTTL = 10 * time.Minute
+
+// ssoHandoffTTL bounds how long the SPA has to redeem a handoff code.
+const ssoHandoffTTL = 2 * time.Minute
+
+// SSOUser is an identity asserted by a verified OIDC ID token or SAML assertion.
+type SSOUser struct {
+	Provider string   `json:"provider"`
+	Type     string   `json:"type"`
+	Subject  string   `json:"subject"`
+	Email    string   `json:"email"`
+	Name     string   `json:"name"`
+	Roles    []string `json:"-"` // values of the configured role claim/attribute
+}
+
+// SSOProviderInfo describes a configured SSO provider for the login page.
+type SSOProviderInfo struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	DisplayName string `json:"display_name"`
+}
+
+// ssoPending is an SSO login started by this server and awaiting the IdP response.
+type ssoPending struct {
+	provider string
+	kind     string
+	nonce    string // OIDC nonce
+	verifier string // OIDC PKCE code verifier
+	expires  time.Time
+}
+
+// ssoHandoff is a one-time code handed to the SPA in exchange for a session.
+type ssoHandoff struct {
+	value   string
+	expires time.Time
+}
+
+// SSOClient runs OIDC and SAML login flows for enterprise identity providers.
+type SSOClient struct {
+	mu   sync.RWMutex
+	oidc map[string]config.OIDCProviderConfig
+	saml map[string]config.SAMLProviderConfig
+
+	// httpClient is used for discovery, JWKS and token requests. If nil, a
+	// client with a 15s timeout is used.
+	httpClient *http.Client
+
+	// pending maps OIDC state / SAML request ID -> started login.
+	pendingMu sync.Mutex
+	pending   map[string]ssoPending
+	handoffs  map[string]ssoHandoff
+
+	// oidcCache holds discovery documents and signing keys per provider.
+	cacheMu   sync.Mutex
+	oidcCache map[string]*oidcProviderCache
+
+	stopCh chan struct{}
+}
+
+// NewSSOClient creates an SSOClient for the given providers.
+func NewSSOClient(cfg config.SSOConfig) *SSOClient {
+	sc := &SSOClient{
+		pending:   make(map[string]ssoPending),
+		handoffs:  make(map[string]ssoHandoff),
+		oidcCache: make(map[string]*oidcProviderCache),
+		stopCh:    make(chan struct{}),
+	}
+	sc.SetProviders(cfg)
+	// Background cleanup of expired logins and handoff codes
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[SSO] panic in cleanup goroutine: %v", r)
+			}
+		}()
+		ticker := time.NewTicker(5 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sc.cleanExpired()
+			case <-sc.stopCh:
+				return
+			}
+		}
+	}()
+	return sc
+}
+
+// SetProviders replaces the provider configuration. Logins already in
+// progress are kept; cached discovery documents are dropped.
+func (sc *SSOClient) SetProviders(cfg config.SSOConfig) {
+	sc.mu.Lock()
+	sc.oidc = cfg.OIDC
+	sc.saml = cfg.SAML
+	sc.mu.Unlock()
+
+	sc.cacheMu.Lock()
+	sc.oidcCache = make(map[string]*oidcProviderCache)
+	sc.cacheMu.Unlock()
+}
+
+// Stop terminates the background cleanup goroutine.
+func (sc *SSOClient) Stop() {
+	select {
+	case <-sc.stopCh:
+	default:
+		close(sc.stopCh)
+	}
+}
+
+// Providers returns the fully configured providers, sorted by name.
+func (sc *SSOClient) Providers() []SSOProviderInfo {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	var out []SSOProviderInfo
+	for name, p := range sc.oidc {
+		if p.Issuer != "" && p.ClientID != "" && p.RedirectURL != "" {
+			out = append(out, SSOProviderInfo{Name: name, Type: SSOTypeOIDC, DisplayName: displayName(p.DisplayName, name)})
+		}
+	}
+	for name, p := range sc.saml {
+		if p.IdPSSOURL != "" && p.IdPCertificate != "" && p.SPEntityID != "" && p.ACSURL != "" {
+			out = append(out, SSOProviderInfo{Name: name, Type: SSOTypeSAML, DisplayName: displayName(p.DisplayName, name)})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// RoleMapping returns the claim-value-to-role mapping of a provider.
+func (sc *SSOClient) RoleMapping(kind, provider string) map[string]string {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	if kind == SSOTypeOIDC {
+		return sc.oidc[provider].RoleMapping
+	}
+	return sc.saml[provider].RoleMapping
+}
+
+// MapRole returns the admin role for the given claim values, or "" if none
+// is mapped. "super_admin" wins over any other mapped role; otherwise the
+// first mapped value in claim order is used.
+func MapRole(mapping map[string]string, values []string) string {
+	role := ""
+	for _, v := range values {
+		r, ok := mapping[v]
+		if !ok || r == "" {
+			continue
+		}
+		if r == "super_admin" {
+			return r
+		}
+		if role == "" {
+			role = r
+		}
+	}
+	return role
+}
+
+// IssueHandoff stores value behind a random one-time code that expires shortly.
+func (sc *SSOClient) IssueHandoff(value string) (string, error) {
+	code, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+	sc.pendingMu.Lock()
+	sc.handoffs[code] = ssoHandoff{value: value, expires: time.Now().Add(ssoHandoffTTL)}
+	sc.pendingMu.Unlock()
+	return code, nil
+}
+
+// RedeemHandoff returns the value stored for code and invalidates the code.
+func (sc *SSOClient) RedeemHandoff(code string) (string, bool) {
+	sc.pendingMu.Lock()
+	defer sc.pendingMu.Unlock()
+	h, ok := sc.handoffs[code]
+	if !ok {
+		return "", false
+	}
+	delete(sc.handoffs, code)
+	if time.Now().After(h.expires) {
+		return "", false
+	}
+	return h.value, true
+}
+
+// startLogin records a login in progress under key.
+func (sc *SSOClient) startLogin(key string, p ssoPending) {
+	p.expires = time.Now().Add(ssoLoginTTL)
+	sc.pendingMu.Lock()
+	sc.pending[key] = p
+	sc.pendingMu.Unlock()
+}
+
+// finishLogin consumes the login recorded under key.
+func (sc *SSOClient) finishLogin(key, kind string) (ssoPending, error) {
+	sc.pendingMu.Lock()
+	defer sc.pendingMu.Unlock()
+	p, ok := sc.pending[key]
+	if !ok || p.kind != kind {
+		return ssoPending{}, fmt.Errorf("unknown or expired SSO login")
+	}
+	delete(sc.pending, key)
+	if time.Now().After(p.expires) {
+		return ssoPending{}, fmt.Errorf("unknown or expired SSO login")
+	}
+	return p, nil
+}
+
+// cleanExpired removes expired logins and handoff codes.
+func (sc *SSOClient) cleanExpired() {
+	sc.pendingMu.Lock()
+	defer sc.pendingMu.Unlock()
+	now := time.Now()
+	for k, p := range sc.pending {
+		if now.After(p.expires) {
+			delete(sc.pending, k)
+		}
+	}
+	for k, h := range sc.handoffs {
+		if now.After(h.expires) {
+			delete(sc.handoffs, k)
+		}
+	}
+}
+
+func (sc *SSOClient) getHTTPClient() *http.Client {
+	if sc.httpClient != nil {
+		return sc.httpClient
+	}
+	return &http.Client{Timeout: 15 * time.Second}
+}
+
+func displayName(name, fallback string) string {
+	if name != "" {
+		return name
+	}
+	return fallback
+}
+
+// randomHex returns n random bytes, hex-encoded.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(cryptorand.Reader, b); err != nil {
+		return "", fmt.Errorf("failed to generate random value: %w", err)
+	}
+	return fmt.Sprintf("%x", b), nil
+}
@@ -3,16 +3,17 @@
 // Backup strategy (data-level, not file-level):
 //
 //	Full mode:
-//	  - SQLite online backup API for a consistent DB snapshot
+//	  - VACUUM INTO snapshot for a consistent DB copy while the server is running
 //	  - All upload files
 //	  - Config + encryption key
 //
 //	Incremental mode:
-//	  - Insert-only tables (documents, chunks, video_segments, admin_users):
+//	  - Insert-only tables (documents, chunks, video_segments, chunk_locations,
+//	    chunk_image_embeddings, admin_users):
 //	    export only rows with created_at > last backup time
 //	  - Mutable tables (pending_questions, users, products, admin_user_products):
 //	    full table dump (rows may be updated)
-//	  - Ephemeral tables (sessions, email_tokens): skipped
+//	  - Ephemeral tables (sessions, refresh_tokens, email_tokens): skipped
 //	  - Upload files: only new directories since last backup
 //	  - Config + encryption key: always included
 //
@@ -23,6 +24,10 @@
 //	uploads/<hash>/file      — uploaded document files
 //	config.json              — system configuration
 //	encryption.key           — AES encryption key
+//
+// With Options.Remote set, the finished archive and manifest are also
+// uploaded to S3-compatible object storage (see s3.go), and RestoreFromS3
+// restores straight from the bucket.
 package backup
 
 import (
@@ -54,19 +59,24 @@ type Options struct {
 	OutputDir  string // output directory for archive (default ".")
 	Mode       string // "full" or "incremental"
 	ManifestIn string // previous manifest path (required for incremental)
+	// Remote, when set, receives a copy of the archive and manifest. An
+	// upload failure is returned as an error but keeps the local archive.
+	Remote *S3Target
 }
 
 // Result holds backup results.
 type Result struct {
-	ArchivePath  string
-	ManifestPath string
-	FilesWritten int
-	DBRows       int
-	BytesWritten int64
+	ArchivePath    string
+	ManifestPath   string
+	FilesWritten   int
+	DBRows         int
+	BytesWritten   int64
+	RemoteArchive  string // s3:// URL of the uploaded archive (Options.Remote)
+	RemoteManifest string
 }
 
 // insertOnlyTables are append-only; incremental exports rows by created_at.
-var insertOnlyTables = []string{"documents", "chunks", "video_segments", "admin_users"}
+var insertOnlyTables = []string{"documents", "chunks", "video_segments", "chunk_locations", "chunk_image_embeddings", "admin_users"}
 
 // mutableTables may have row updates; incremental does full dump of these.
 var mutableTables = []string{"pending_questions", "users", "products", "admin_user_products"}
@@ -80,8 +90,8 @@ var allDataTables = func() []string {
 	return all
 }()
 
-// Run executes a backup.
-func Run(db *sql.DB, opts Options) (*Result, error) {
+// Run executes a backup. A failed run removes its partial archive.
+func Run(db *sql.DB, opts Options) (result *Result, err error) {
 	if opts.DataDir == "" {
 		opts.DataDir = "./data"
 	}
@@ -141,6 +151,13 @@ func Run(db *sql.DB, opts Options) (*Result, error) {
 	if err != nil {
 		return nil, fmt.Errorf("创建归档文件失败: %w", err)
 	}
+	// Registered before the writer closes so it runs after them
+	written := false
+	defer func() {
+		if err != nil && !written {
+			os.Remove(archivePath)
+		}
+	}()
 	defer out.Close()
 
 	gw := gzip.NewWriter(out)
@@ -148,7 +165,7 @@ func Run(db *sql.DB, opts Options) (*Result, error) {
 	tw := tar.NewWriter(gw)
 	defer tw.Close()
 
-	result := &Result{ArchivePath: archivePath, ManifestPath: manifestPath}
+	result = &Result{ArchivePath: archivePath, ManifestPath: manifestPath}
 
 	// 1. Config + encryption key (always)
 	for _, name := range []string{"config.json", "encryption.key"} {
@@ -168,7 +185,18 @@ func Run(db *sql.DB, opts Options) (*Result, error) {
 		// Full: copy the entire DB file
 		dbPath := filepath.Join(opts.DataDir, "askflow.db")
 		if _, err := os.Stat(dbPath); err == nil {
-			n, err := addFileToTar(tw, dbPath, "askflow.db")
+			// Archive a snapshot rather than the live file, which the server
+			// may be writing to while the archive is built
+			src := dbPath
+			if db != nil {
+				if snap, err := snapshotDB(db, opts.OutputDir); err == nil {
+					src = snap
+					defer os.Remove(snap)
+				} else {
+					fmt.Printf("警告: 数据库快照失败，直接复制数据库文件: %v\n", err)
+				}
+			}
+			n, err := addFileToTar(tw, src, "askflow.db")
 			if err != nil {
 				return nil, fmt.Errorf("添加数据库失败: %w", err)
 			}
@@ -270,9 +298,37 @@ func Run(db *sql.DB, opts Options) (*Result, error) {
 		return nil, fmt.Errorf("保存 manifest 失败: %w", err)
 	}
 
+	// 6. Ship to remote storage once the archive is complete
+	if opts.Remote != nil {
+		if err := tw.Close(); err != nil {
+			return nil, fmt.Errorf("写入归档失败: %w", err)
+		}
+		if err := gw.Close(); err != nil {
+			return nil, fmt.Errorf("写入归档失败: %w", err)
+		}
+		if err := out.Close(); err != nil {
+			return nil, fmt.Errorf("写入归档失败: %w", err)
+		}
+		written = true
+		if err := uploadResult(opts.Remote, result); err != nil {
+			return result, err
+		}
+	}
+
 	return result, nil
 }
 
+// snapshotDB writes a consistent copy of the database into dir with VACUUM INTO
+// and returns its path. The caller removes the file.
+func snapshotDB(db *sql.DB, dir string) (string, error) {
+	path := filepath.Join(dir, fmt.Sprintf(".askflow-snapshot-%d.db", time.Now().UnixNano()))
+	if _, err := db.Exec("VACUUM INTO ?", path); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
 // generateDeltaSQL produces INSERT OR REPLACE statements for incremental backup.
 func generateDeltaSQL(db *sql.DB, sinceTime string) ([]byte, map[string]int, error) {
 	var buf strings.Builder
@@ -300,10 +356,10 @@ func generateDeltaSQL(db *sql.DB, sinceTime string) ([]byte, map[string]int, err
 			query = fmt.Sprintf("SELECT * FROM %s WHERE created_at > ?", table)
 		} else {
 			// No timestamp (e.g. video_segments) — export by joining to parent
-			// For video_segments, export those whose document was created after sinceTime
-			if table == "video_segments" {
+			// For per-document tables, export rows whose document was created after sinceTime
+			if table == "video_segments" || table == "chunk_locations" || table == "chunk_image_embeddings" {
 				query = fmt.Sprintf(
-					"SELECT vs.* FROM video_segments vs JOIN documents d ON vs.document_id = d.id WHERE d.created_at > ?")
+					"SELECT t.* FROM %s t JOIN documents d ON t.document_id = d.id WHERE d.created_at > ?", table)
 			} else {
 				continue
 			}
@@ -396,7 +452,7 @@ func sqlQuote(v interface{}) string {
 
 // validBackupTables is a whitelist of tables allowed in backup operations.
 var validBackupTables = map[string]bool{
-	"documents": true, "chunks": true, "video_segments": true, "admin_users": true,
+	"documents": true, "chunks": true, "video_segments": true, "chunk_locations": true, "chunk_image_embeddings": true, "admin_users": true,
 	"pending_questions": true, "users": true, "products": true, "admin_user_products": true,
 	"login_attempts": true, "login_bans": true,
 }
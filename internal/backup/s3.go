@@ -0,0 +1,70 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"askflow/internal/config"
+	"askflow/internal/s3"
+)
+
+// S3Target is an S3-compatible bucket that archives are shipped to.
+type S3Target = s3.Client
+
+// S3Object is an object returned by S3Target.List.
+type S3Object = s3.Object
+
+// S3TargetFromConfig returns the configured S3 target, or nil when it is disabled.
+func S3TargetFromConfig(c config.BackupS3Config) *S3Target {
+	if !c.Enabled {
+		return nil
+	}
+	return &S3Target{
+		Endpoint:  c.Endpoint,
+		Region:    c.Region,
+		Bucket:    c.Bucket,
+		Prefix:    c.Prefix,
+		AccessKey: c.AccessKey,
+		SecretKey: c.SecretKey,
+		PathStyle: c.PathStyle,
+	}
+}
+
+// ParseS3URL splits "s3://bucket/key" into bucket and key.
+func ParseS3URL(s string) (bucket, key string, ok bool) {
+	return s3.ParseURL(s)
+}
+
+// uploadResult ships a finished backup's archive and manifest to t. The
+// manifest goes last so a listed manifest always has its archive.
+func uploadResult(t *S3Target, res *Result) error {
+	ctx := context.Background()
+	for _, p := range []string{res.ArchivePath, res.ManifestPath} {
+		key := t.Key(filepath.Base(p))
+		if err := t.Upload(ctx, p, key); err != nil {
+			return fmt.Errorf("上传 %s 失败: %w", key, err)
+		}
+	}
+	res.RemoteArchive = t.URL(t.Key(filepath.Base(res.ArchivePath)))
+	res.RemoteManifest = t.URL(t.Key(filepath.Base(res.ManifestPath)))
+	return nil
+}
+
+// RestoreFromS3 downloads the archive at key from t and restores it into
+// targetDir like Restore.
+func RestoreFromS3(t *S3Target, key, targetDir string) error {
+	tmp, err := os.CreateTemp("", "askflow-restore-*.tar.gz")
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	fmt.Printf("正在下载 %s ...\n", t.URL(key))
+	if err := t.Download(context.Background(), key, tmp.Name()); err != nil {
+		return fmt.Errorf("下载备份失败: %w", err)
+	}
+	return Restore(tmp.Name(), targetDir)
+}
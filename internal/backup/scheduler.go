@@ -0,0 +1,457 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"askflow/internal/config"
+	"askflow/internal/cron"
+	"askflow/internal/jobs"
+	"askflow/internal/lease"
+)
+
+// ErrBackupRunning is returned by Trigger while another backup is in progress.
+var ErrBackupRunning = errors.New("backup already running")
+
+// Archive describes a backup archive found in the output directory.
+type Archive struct {
+	Name      string `json:"name"`               // archive file name
+	Manifest  string `json:"manifest"`           // manifest file name
+	Mode      string `json:"mode"`               // "full" or "incremental"
+	Timestamp string `json:"timestamp"`          // RFC3339
+	BasedOn   string `json:"based_on,omitempty"` // base manifest file name (incremental)
+	Size      int64  `json:"size"`
+
+	time time.Time
+}
+
+// RunInfo records the outcome of one scheduled or on-demand backup.
+type RunInfo struct {
+	Trigger    string    `json:"trigger"` // "schedule" or "manual"
+	Mode       string    `json:"mode"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+	Archive    string    `json:"archive,omitempty"`
+	Remote     string    `json:"remote,omitempty"` // s3:// URL of the uploaded archive
+	Bytes      int64     `json:"bytes"`
+	Pruned     []string  `json:"pruned,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Status is the scheduler state reported by /api/admin/backup.
+type Status struct {
+	Enabled   bool      `json:"enabled"`
+	Schedule  string    `json:"schedule"`
+	Mode      string    `json:"mode"`
+	OutputDir string    `json:"output_dir"`
+	Remote    string    `json:"remote,omitempty"` // s3://bucket/prefix when the S3 target is enabled
+	Running   bool      `json:"running"`
+	NextRun   time.Time `json:"next_run,omitzero"`
+	LastRun   *RunInfo  `json:"last_run,omitempty"`
+	Archives  []Archive `json:"archives"`
+}
+
+// Scheduler runs backups on the configured cron schedule and prunes old
+// archives. The config is re-read every minute, so schedule changes take
+// effect without a restart. At most one backup runs at a time.
+type Scheduler struct {
+	db      *sql.DB
+	dataDir string
+	cfg     func() config.BackupConfig
+	locks   *lease.Manager
+	jobs    *jobs.Registry
+
+	mu      sync.Mutex
+	running bool
+	last    *RunInfo
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewScheduler creates a backup scheduler. cfg returns the current backup settings.
+func NewScheduler(db *sql.DB, dataDir string, cfg func() config.BackupConfig) *Scheduler {
+	return &Scheduler{db: db, dataDir: dataDir, cfg: cfg, stop: make(chan struct{})}
+}
+
+// SetLocks makes backups take the "backup" lease first, so that instances
+// sharing the database do not back it up at the same time or twice for one
+// scheduled time. Call it before Start.
+func (s *Scheduler) SetLocks(m *lease.Manager) {
+	s.locks = m
+}
+
+// jobParams are the params of backup jobs.
+type jobParams struct {
+	Mode string `json:"mode"`
+}
+
+// SetJobs records backups as jobs in r, retried as manual backups of the
+// same mode. Call it before Start.
+func (s *Scheduler) SetJobs(r *jobs.Registry) {
+	s.jobs = r
+	r.OnRetry(jobs.KindBackup, func(params json.RawMessage) error {
+		var p jobParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return fmt.Errorf("invalid job params: %w", err)
+		}
+		return s.Trigger(p.Mode, "manual")
+	})
+}
+
+// Start launches the scheduling loop.
+func (s *Scheduler) Start() {
+	s.wg.Add(1)
+	go s.loop()
+}
+
+// Stop ends the scheduling loop and waits until ctx is done for a running
+// backup to finish.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Scheduler) loop() {
+	defer s.wg.Done()
+	lastErr := ""
+	for {
+		next := time.Now().Truncate(time.Minute).Add(time.Minute)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		cfg := s.cfg()
+		if !cfg.Enabled {
+			continue
+		}
+		sched, err := cron.Parse(cfg.Schedule)
+		if err != nil {
+			if err.Error() != lastErr {
+				log.Printf("[Backup] invalid schedule %q: %v", cfg.Schedule, err)
+				lastErr = err.Error()
+			}
+			continue
+		}
+		lastErr = ""
+		if !sched.Match(next) {
+			continue
+		}
+		if err := s.trigger(cfg.Mode, "schedule", next); err != nil {
+			log.Printf("[Backup] scheduled backup skipped: %v", err)
+		}
+	}
+}
+
+// Trigger starts a backup in the background. mode is "full", "incremental"
+// or "" for the configured mode; trigger labels the run ("schedule" or "manual").
+func (s *Scheduler) Trigger(mode, trigger string) error {
+	return s.trigger(mode, trigger, time.Time{})
+}
+
+// trigger is Trigger for the run scheduled at slot, zero for manual runs.
+func (s *Scheduler) trigger(mode, trigger string, slot time.Time) error {
+	if mode == "" {
+		mode = s.cfg().Mode
+	}
+	if mode != "full" && mode != "incremental" {
+		return fmt.Errorf("invalid backup mode %q", mode)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return ErrBackupRunning
+	}
+	select {
+	case <-s.stop:
+		return errors.New("backup scheduler stopped")
+	default:
+	}
+	l, err := s.locks.Acquire("backup", slot)
+	if err != nil {
+		return err
+	}
+	s.running = true
+	s.wg.Add(1)
+	go s.run(mode, trigger, l)
+	return nil
+}
+
+// outputDir resolves the configured output directory against the data directory.
+func (s *Scheduler) outputDir(cfg config.BackupConfig) string {
+	dir := cfg.OutputDir
+	if dir == "" {
+		dir = "backups"
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(s.dataDir, dir)
+	}
+	return dir
+}
+
+func (s *Scheduler) run(mode, trigger string, l *lease.Lease) {
+	defer s.wg.Done()
+	defer l.Release()
+	cfg := s.cfg()
+	info := &RunInfo{Trigger: trigger, Mode: "full", StartedAt: time.Now()}
+	job := s.jobs.Begin(jobs.KindBackup, fmt.Sprintf("%s backup (%s)", mode, trigger), jobParams{Mode: mode}, nil)
+	defer func() {
+		if r := recover(); r != nil {
+			info.Error = fmt.Sprintf("panic: %v", r)
+			log.Printf("[Backup] panic: %v", r)
+		}
+		info.FinishedAt = time.Now()
+		s.mu.Lock()
+		s.running = false
+		s.last = info
+		s.mu.Unlock()
+		var jobErr error
+		if info.Error != "" {
+			jobErr = errors.New(info.Error)
+		}
+		job.Finish(jobErr)
+	}()
+
+	dir := s.outputDir(cfg)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		info.Error = err.Error()
+		log.Printf("[Backup] create output directory %s: %v", dir, err)
+		return
+	}
+	opts := Options{DataDir: s.dataDir, OutputDir: dir, Mode: "full", Remote: S3TargetFromConfig(cfg.S3)}
+	if mode == "incremental" {
+		// Incrementals are taken against the latest full backup, so restoring
+		// needs only that full archive plus the newest incremental
+		archives, _ := List(dir)
+		if base := latestFull(archives); base != nil &&
+			time.Since(base.time) < time.Duration(cfg.FullIntervalDays)*24*time.Hour {
+			opts.Mode = "incremental"
+			opts.ManifestIn = filepath.Join(dir, base.Manifest)
+		}
+	}
+	info.Mode = opts.Mode
+
+	log.Printf("[Backup] starting %s backup (%s) to %s", opts.Mode, trigger, dir)
+	job.Progress(0, "writing "+opts.Mode+" archive")
+	res, err := Run(s.db, opts)
+	if res == nil {
+		info.Error = err.Error()
+		log.Printf("[Backup] %s backup failed: %v", opts.Mode, err)
+		return
+	}
+	info.Archive = filepath.Base(res.ArchivePath)
+	info.Remote = res.RemoteArchive
+	info.Bytes = res.BytesWritten
+	log.Printf("[Backup] %s backup written: %s (%.2f MB)", opts.Mode, info.Archive, float64(res.BytesWritten)/(1024*1024))
+	if err != nil {
+		// The local archive is intact; only the upload failed
+		info.Error = err.Error()
+		log.Printf("[Backup] upload to S3 failed: %v", err)
+	} else if res.RemoteArchive != "" {
+		log.Printf("[Backup] uploaded to %s", res.RemoteArchive)
+	}
+
+	job.Progress(90, "pruning old archives")
+	pruned, err := Prune(dir, cfg.KeepFull, cfg.MaxAgeDays, time.Now())
+	info.Pruned = pruned
+	if err != nil {
+		log.Printf("[Backup] prune failed: %v", err)
+	} else if len(pruned) > 0 {
+		log.Printf("[Backup] pruned %d old archive(s)", len(pruned))
+	}
+	if opts.Remote != nil && len(pruned) > 0 {
+		if err := pruneRemote(opts.Remote, pruned); err != nil {
+			log.Printf("[Backup] remote prune failed: %v", err)
+		}
+	}
+}
+
+// pruneRemote deletes the bucket copies of archives pruned locally, so the
+// bucket follows the same retention as the output directory.
+func pruneRemote(t *S3Target, archives []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	var errs []error
+	for _, name := range archives {
+		manifest := strings.TrimSuffix(name, ".tar.gz") + ".manifest.json"
+		for _, n := range []string{manifest, name} {
+			if err := t.Delete(ctx, t.Key(n)); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Status returns the scheduler state and the archives in the output directory.
+func (s *Scheduler) Status() Status {
+	cfg := s.cfg()
+	st := Status{
+		Enabled:   cfg.Enabled,
+		Schedule:  cfg.Schedule,
+		Mode:      cfg.Mode,
+		OutputDir: s.outputDir(cfg),
+	}
+	if t := S3TargetFromConfig(cfg.S3); t != nil {
+		st.Remote = t.URL(t.Key(""))
+	}
+	if cfg.Enabled {
+		if sched, err := cron.Parse(cfg.Schedule); err == nil {
+			st.NextRun = sched.Next(time.Now())
+		}
+	}
+	s.mu.Lock()
+	st.Running = s.running
+	if s.last != nil {
+		last := *s.last
+		st.LastRun = &last
+	}
+	s.mu.Unlock()
+
+	st.Archives, _ = List(st.OutputDir)
+	if st.Archives == nil {
+		st.Archives = []Archive{}
+	}
+	return st
+}
+
+// List returns the backup archives in dir that have a manifest, newest first.
+func List(dir string) ([]Archive, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var archives []Archive
+	for _, e := range entries {
+		name := e.Name()
+		base, ok := strings.CutSuffix(name, ".manifest.json")
+		if e.IsDir() || !ok || !strings.HasPrefix(name, "askflow_") {
+			continue
+		}
+		m, err := loadManifest(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, m.Timestamp)
+		if err != nil {
+			continue
+		}
+		a := Archive{
+			Name:      base + ".tar.gz",
+			Manifest:  name,
+			Mode:      m.Mode,
+			Timestamp: m.Timestamp,
+			time:      t,
+		}
+		if m.BasedOn != "" {
+			a.BasedOn = filepath.Base(m.BasedOn)
+		}
+		if fi, err := os.Stat(filepath.Join(dir, a.Name)); err == nil {
+			a.Size = fi.Size()
+		}
+		archives = append(archives, a)
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].time.After(archives[j].time) })
+	return archives, nil
+}
+
+// latestFull returns the newest full backup in archives (sorted newest first).
+func latestFull(archives []Archive) *Archive {
+	for i := range archives {
+		if archives[i].Mode == "full" {
+			return &archives[i]
+		}
+	}
+	return nil
+}
+
+// Prune deletes old backup sets in dir. A set is a full backup plus the
+// incrementals based on it. The newest keepFull sets are kept, minus any
+// set whose full backup is older than maxAgeDays (0 disables the age limit);
+// the newest set is always kept. Incrementals whose base manifest no longer
+// exists are deleted once they are older than the oldest kept full backup.
+// Returns the names of the deleted archives.
+func Prune(dir string, keepFull, maxAgeDays int, now time.Time) ([]string, error) {
+	archives, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+	if keepFull < 1 {
+		keepFull = 1
+	}
+
+	var fulls []Archive
+	for _, a := range archives {
+		if a.Mode == "full" {
+			fulls = append(fulls, a)
+		}
+	}
+	keep := make(map[string]bool) // kept full manifests
+	var oldestKept time.Time
+	for i, f := range fulls {
+		if i >= keepFull {
+			break
+		}
+		if i > 0 && maxAgeDays > 0 && now.Sub(f.time) > time.Duration(maxAgeDays)*24*time.Hour {
+			break
+		}
+		keep[f.Manifest] = true
+		oldestKept = f.time
+	}
+
+	fullNames := make(map[string]bool, len(fulls))
+	for _, f := range fulls {
+		fullNames[f.Manifest] = true
+	}
+	var pruned []string
+	var errs []error
+	for _, a := range archives {
+		var remove bool
+		switch {
+		case a.Mode == "full":
+			remove = !keep[a.Manifest]
+		case fullNames[a.BasedOn]:
+			remove = !keep[a.BasedOn]
+		default:
+			remove = !oldestKept.IsZero() && a.time.Before(oldestKept)
+		}
+		if !remove {
+			continue
+		}
+		for _, name := range []string{a.Name, a.Manifest} {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+		}
+		pruned = append(pruned, a.Name)
+	}
+	return pruned, errors.Join(errs...)
+}
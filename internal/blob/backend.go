@@ -0,0 +1,345 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"askflow/internal/config"
+	"askflow/internal/s3"
+)
+
+// Backend persists files by key. Keys are slash-separated relative paths
+// such as "uploads/<docID>/manual.pdf" or "images/<id>.png".
+type Backend interface {
+	// Put stores data at key, replacing any existing object.
+	Put(key string, data []byte) error
+	// Get returns the object at key, or ErrNotFound.
+	Get(key string) ([]byte, error)
+	// Delete removes the object at key. Deleting a missing object is not an error.
+	Delete(key string) error
+	// DeletePrefix removes all objects under the directory prefix.
+	DeletePrefix(prefix string) error
+	// List returns the size of every object under the directory prefix,
+	// keyed by the object's key.
+	List(prefix string) (map[string]int64, error)
+	// Serve writes the object at key to w, honouring Range requests, or
+	// answers 404 if it does not exist. Headers set by the caller are kept.
+	Serve(w http.ResponseWriter, r *http.Request, key string)
+}
+
+// Storage backends for config.StorageConfig.Backend.
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// ValidKey reports whether key is a relative path without empty, "." or
+// ".." segments, so it cannot escape the storage root.
+func ValidKey(key string) bool {
+	if key == "" || strings.ContainsAny(key, "\\\x00") {
+		return false
+	}
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// NewBackend returns the backend selected by cfg. Local storage keeps files
+// under dataDir, where uploads and images have always been stored.
+func NewBackend(cfg config.StorageConfig, dataDir string) (Backend, error) {
+	switch cfg.Backend {
+	case "", BackendLocal:
+		return NewLocal(dataDir), nil
+	case BackendS3:
+		if cfg.S3.Bucket == "" {
+			return nil, errors.New("storage.s3.bucket is not configured")
+		}
+		if cfg.S3.AccessKey == "" || cfg.S3.SecretKey == "" {
+			return nil, errors.New("storage.s3 credentials are not configured")
+		}
+		return &S3{client: &s3.Client{
+			Endpoint:  cfg.S3.Endpoint,
+			Region:    cfg.S3.Region,
+			Bucket:    cfg.S3.Bucket,
+			Prefix:    cfg.S3.Prefix,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+			PathStyle: cfg.S3.PathStyle,
+		}}, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
+
+// LocalFile returns a path on local disk holding the object at key, for
+// tools such as ffmpeg that need a file. Local backends return the stored
+// file itself; otherwise data is written to a temporary file that cleanup
+// removes.
+func LocalFile(b Backend, key string, data []byte) (path string, cleanup func(), err error) {
+	if e, ok := b.(*Encrypted); ok && e.plain.Load() {
+		b = e.Backend
+	}
+	if l, ok := b.(*Local); ok {
+		if path, err = l.path(key); err != nil {
+			return "", nil, err
+		}
+		if _, err = os.Stat(path); err == nil {
+			return path, func() {}, nil
+		}
+	}
+	dir, err := os.MkdirTemp("", "askflow-blob-*")
+	if err != nil {
+		return "", nil, err
+	}
+	path = filepath.Join(dir, filepath.Base(filepath.FromSlash(key)))
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, err
+	}
+	return path, func() { os.RemoveAll(dir) }, nil
+}
+
+// Local stores objects as files under a root directory.
+type Local struct {
+	root string
+}
+
+// NewLocal creates a Local backend rooted at dir.
+func NewLocal(dir string) *Local {
+	return &Local{root: dir}
+}
+
+func (l *Local) path(key string) (string, error) {
+	if !ValidKey(key) {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return filepath.Join(l.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes data to the file for key, creating parent directories.
+func (l *Local) Put(key string, data []byte) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0644)
+}
+
+// Get reads the file for key.
+func (l *Local) Get(key string) ([]byte, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// Delete removes the file for key.
+func (l *Local) Delete(key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// DeletePrefix removes the directory for prefix.
+func (l *Local) DeletePrefix(prefix string) error {
+	p, err := l.path(strings.TrimSuffix(prefix, "/"))
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(p)
+}
+
+// List walks the directory for prefix. A missing directory has no objects.
+func (l *Local) List(prefix string) (map[string]int64, error) {
+	dir, err := l.path(strings.TrimSuffix(prefix, "/"))
+	if err != nil {
+		return nil, err
+	}
+	objects := make(map[string]int64)
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == dir && errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(l.root, p)
+		if err != nil {
+			return err
+		}
+		objects[filepath.ToSlash(rel)] = info.Size()
+		return nil
+	})
+	return objects, err
+}
+
+// Serve serves the file for key. Only regular files are served.
+func (l *Local) Serve(w http.ResponseWriter, r *http.Request, key string) {
+	p, err := l.path(key)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	info, err := os.Lstat(p)
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, p)
+}
+
+// s3Timeout bounds S3 requests other than streamed reads.
+const s3Timeout = 2 * time.Minute
+
+// S3 stores objects in an S3-compatible bucket, under the configured prefix.
+type S3 struct {
+	client *s3.Client
+}
+
+// Put uploads data to key.
+func (b *S3) Put(key string, data []byte) error {
+	if !ValidKey(key) {
+		return fmt.Errorf("invalid storage key %q", key)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	return b.client.PutBytes(ctx, b.client.Key(key), data)
+}
+
+// Get downloads the object at key.
+func (b *S3) Get(key string) ([]byte, error) {
+	if !ValidKey(key) {
+		return nil, ErrNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	resp, err := b.client.Open(ctx, b.client.Key(key), "")
+	if errors.Is(err, s3.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// Delete removes the object at key.
+func (b *S3) Delete(key string) error {
+	if !ValidKey(key) {
+		return fmt.Errorf("invalid storage key %q", key)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	return b.client.Delete(ctx, b.client.Key(key))
+}
+
+// DeletePrefix removes every object under prefix.
+func (b *S3) DeletePrefix(prefix string) error {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if !ValidKey(prefix) {
+		return fmt.Errorf("invalid storage key %q", prefix)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	objects, err := b.client.List(ctx, b.client.Key(prefix+"/"))
+	if err != nil {
+		return err
+	}
+	for _, o := range objects {
+		if err := b.client.Delete(ctx, o.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List lists the objects under prefix.
+func (b *S3) List(prefix string) (map[string]int64, error) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if !ValidKey(prefix) {
+		return nil, fmt.Errorf("invalid storage key %q", prefix)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s3Timeout)
+	defer cancel()
+	list, err := b.client.List(ctx, b.client.Key(prefix+"/"))
+	if err != nil {
+		return nil, err
+	}
+	root := b.client.Key("")
+	objects := make(map[string]int64, len(list))
+	for _, o := range list {
+		objects[strings.TrimPrefix(o.Key, root)] = o.Size
+	}
+	return objects, nil
+}
+
+// Serve streams the object through the server rather than redirecting to
+// the bucket, so the bucket can stay private and pages need no extra CSP
+// sources. Range requests are passed on for video seeking.
+func (b *S3) Serve(w http.ResponseWriter, r *http.Request, key string) {
+	if !ValidKey(key) {
+		http.NotFound(w, r)
+		return
+	}
+	resp, err := b.client.Open(r.Context(), b.client.Key(key), r.Header.Get("Range"))
+	if errors.Is(err, s3.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "storage unavailable", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	h := w.Header()
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", resp.Header.Get("Content-Type"))
+	}
+	for _, name := range []string{"Content-Range", "Last-Modified", "ETag"} {
+		if v := resp.Header.Get(name); v != "" {
+			h.Set(name, v)
+		}
+	}
+	h.Set("Accept-Ranges", "bytes")
+	if resp.ContentLength >= 0 {
+		h.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	w.WriteHeader(resp.StatusCode)
+	if r.Method != http.MethodHead {
+		io.Copy(w, resp.Body)
+	}
+}
@@ -0,0 +1,128 @@
+package blob
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sync/atomic"
+	"time"
+
+	"askflow/internal/atrest"
+)
+
+// Encrypted stores objects in another backend encrypted with an
+// atrest.Cipher. Objects stored unencrypted are read as they are, so
+// turning encryption on does not break existing files.
+type Encrypted struct {
+	Backend
+	cipher *atrest.Cipher
+	// plain is set once Recode has decrypted every object while encryption
+	// is off, so that Serve can pass requests straight on.
+	plain atomic.Bool
+}
+
+// NewEncrypted wraps b so that objects are encrypted at rest with c.
+func NewEncrypted(b Backend, c *atrest.Cipher) *Encrypted {
+	return &Encrypted{Backend: b, cipher: c}
+}
+
+// Wrap returns b encrypted with the same cipher, e.g. for files kept
+// outside the configured backend.
+func (e *Encrypted) Wrap(b Backend) *Encrypted {
+	return NewEncrypted(b, e.cipher)
+}
+
+// Put encrypts data and stores it at key.
+func (e *Encrypted) Put(key string, data []byte) error {
+	sealed, err := e.cipher.Seal(data)
+	if err != nil {
+		return err
+	}
+	return e.Backend.Put(key, sealed)
+}
+
+// Get returns the decrypted object at key.
+func (e *Encrypted) Get(key string) ([]byte, error) {
+	data, err := e.Backend.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return e.cipher.Open(data)
+}
+
+// Serve decrypts the object at key and serves it from memory, since the
+// stored bytes cannot be served in ranges. Unencrypted objects are served
+// by the wrapped backend.
+func (e *Encrypted) Serve(w http.ResponseWriter, r *http.Request, key string) {
+	if !e.cipher.Sealing() {
+		if e.plain.Load() {
+			e.Backend.Serve(w, r, key)
+			return
+		}
+		// Objects are mostly plain; sealed ones are decrypted below
+		if data, err := e.Backend.Get(key); err == nil && !atrest.Sealed(data) {
+			e.Backend.Serve(w, r, key)
+			return
+		}
+	}
+	data, err := e.Get(key)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "storage unavailable", http.StatusBadGateway)
+		return
+	}
+	http.ServeContent(w, r, path.Base(key), time.Time{}, bytes.NewReader(data))
+}
+
+// Recode rewrites the objects under the directory prefixes that are stored
+// otherwise than the cipher would store them now: plain objects when
+// encryption is on, encrypted ones when it is off and ones encrypted with a
+// rotated key. It returns how many objects it rewrote.
+func (e *Encrypted) Recode(prefixes ...string) (int, error) {
+	recoded := 0
+	for _, prefix := range prefixes {
+		n, err := e.recode(prefix)
+		recoded += n
+		if err != nil {
+			return recoded, err
+		}
+	}
+	if !e.cipher.Sealing() {
+		e.plain.Store(true)
+	}
+	return recoded, nil
+}
+
+func (e *Encrypted) recode(prefix string) (int, error) {
+	objects, err := e.Backend.List(prefix)
+	if err != nil {
+		return 0, err
+	}
+	recoded := 0
+	for key := range objects {
+		data, err := e.Backend.Get(key)
+		if errors.Is(err, ErrNotFound) {
+			continue // deleted meanwhile
+		}
+		if err != nil {
+			return recoded, err
+		}
+		if !e.cipher.Stale(data) {
+			continue
+		}
+		plain, err := e.cipher.Open(data)
+		if err != nil {
+			return recoded, fmt.Errorf("%s: %w", key, err)
+		}
+		if err := e.Put(key, plain); err != nil {
+			return recoded, fmt.Errorf("%s: %w", key, err)
+		}
+		recoded++
+	}
+	return recoded, nil
+}
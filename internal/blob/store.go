@@ -0,0 +1,234 @@
+// Package blob persists original documents, extracted images and uploaded
+// media in a storage Backend (local disk or S3), and records which product
+// and document each image belongs to so images can be served with product
+// access checks.
+package blob
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned for a missing object or an unknown or malformed
+// image ID.
+var ErrNotFound = errors.New("not found")
+
+// URLPrefix is the path images are served under; an image's URL is
+// URLPrefix + ID.
+const URLPrefix = "/api/images/"
+
+// idPattern matches image IDs: a random hex name with an image extension.
+var idPattern = regexp.MustCompile(`^[0-9a-f]{32}\.(png|jpg|jpeg|gif|webp|bmp)$`)
+
+// extensions maps detected content types to file extensions.
+var extensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/bmp":  ".bmp",
+}
+
+// Image describes a stored image. Images saved before the store kept
+// records have no owner and belong to the public library (ProductID "").
+type Image struct {
+	ID          string    `json:"id"`
+	ProductID   string    `json:"product_id"`
+	DocumentID  string    `json:"document_id"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Store keeps images in a Backend under "images/" with their ownership in
+// the images table.
+type Store struct {
+	backend Backend
+	readDB  *sql.DB
+	writeDB *sql.DB
+}
+
+// NewStore creates a Store for the images in backend with separate read and write database connections.
+func NewStore(backend Backend, readDB, writeDB *sql.DB) *Store {
+	return &Store{backend: backend, readDB: readDB, writeDB: writeDB}
+}
+
+// imageKey returns the storage key of an image.
+func imageKey(id string) string {
+	return "images/" + id
+}
+
+// ValidID reports whether id is a well-formed image ID.
+func ValidID(id string) bool {
+	return idPattern.MatchString(id)
+}
+
+// IDFromURL returns the image ID of a URL served by the store, ignoring any
+// query string, or "" for other URLs.
+func IDFromURL(u string) string {
+	rest, ok := strings.CutPrefix(u, URLPrefix)
+	if !ok {
+		return ""
+	}
+	rest, _, _ = strings.Cut(rest, "?")
+	if !ValidID(rest) {
+		return ""
+	}
+	return rest
+}
+
+// Put stores data as a new image owned by productID and documentID (either
+// may be empty) and returns its URL. ext is used when the content type
+// cannot be detected from the data.
+func (s *Store) Put(data []byte, ext, productID, documentID string) (string, error) {
+	contentType := http.DetectContentType(data)
+	if e, ok := extensions[contentType]; ok {
+		ext = e
+	}
+	if ext == "" {
+		ext = ".png"
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate image ID: %w", err)
+	}
+	id := hex.EncodeToString(b) + strings.ToLower(ext)
+	if !ValidID(id) {
+		return "", fmt.Errorf("unsupported image extension %q", ext)
+	}
+
+	if err := s.backend.Put(imageKey(id), data); err != nil {
+		return "", fmt.Errorf("failed to write image: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	if _, err := s.writeDB.Exec(
+		`INSERT INTO images (id, product_id, document_id, content_type, size, sha256, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		id, productID, documentID, contentType, len(data), hex.EncodeToString(sum[:]), time.Now().UTC(),
+	); err != nil {
+		s.backend.Delete(imageKey(id))
+		return "", fmt.Errorf("failed to record image: %w", err)
+	}
+	return URLPrefix + id, nil
+}
+
+// Assign sets the owner of an image that was uploaded before the entry
+// using it was saved. Images that already belong to a document keep their
+// owner.
+func (s *Store) Assign(id, productID, documentID string) error {
+	if !ValidID(id) {
+		return ErrNotFound
+	}
+	_, err := s.writeDB.Exec(
+		`UPDATE images SET product_id = ?, document_id = ? WHERE id = ? AND document_id = ''`,
+		productID, documentID, id,
+	)
+	return err
+}
+
+// Get returns the record of an image. Images saved before images were
+// recorded have no row and are returned with only their ID set; whether
+// the file exists shows when it is served.
+func (s *Store) Get(id string) (*Image, error) {
+	if !ValidID(id) {
+		return nil, ErrNotFound
+	}
+	img := &Image{ID: id}
+	var createdAt sql.NullTime
+	err := s.readDB.QueryRow(
+		`SELECT product_id, document_id, content_type, size, created_at FROM images WHERE id = ?`, id,
+	).Scan(&img.ProductID, &img.DocumentID, &img.ContentType, &img.Size, &createdAt)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return nil, err
+	default:
+		img.CreatedAt = createdAt.Time
+	}
+	return img, nil
+}
+
+// Serve writes the image to w.
+func (s *Store) Serve(w http.ResponseWriter, r *http.Request, id string) {
+	if !ValidID(id) {
+		http.NotFound(w, r)
+		return
+	}
+	s.backend.Serve(w, r, imageKey(id))
+}
+
+// Data returns the contents of an image.
+func (s *Store) Data(id string) ([]byte, error) {
+	if !ValidID(id) {
+		return nil, ErrNotFound
+	}
+	return s.backend.Get(imageKey(id))
+}
+
+// Delete removes an image and its record.
+func (s *Store) Delete(id string) error {
+	if !ValidID(id) {
+		return ErrNotFound
+	}
+	if err := s.backend.Delete(imageKey(id)); err != nil {
+		return err
+	}
+	_, err := s.writeDB.Exec(`DELETE FROM images WHERE id = ?`, id)
+	return err
+}
+
+// Files returns the size of every stored image file by image ID, whether
+// or not the image has a record.
+func (s *Store) Files() (map[string]int64, error) {
+	objects, err := s.backend.List("images")
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]int64, len(objects))
+	for key, size := range objects {
+		if id, ok := strings.CutPrefix(key, imageKey("")); ok && ValidID(id) {
+			files[id] = size
+		}
+	}
+	return files, nil
+}
+
+// DeleteByDocument removes the images of a document.
+func (s *Store) DeleteByDocument(documentID string) error {
+	if documentID == "" {
+		return nil
+	}
+	rows, err := s.readDB.Query(`SELECT id FROM images WHERE document_id = ?`, documentID)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if ValidID(id) {
+			if err := s.backend.Delete(imageKey(id)); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = s.writeDB.Exec(`DELETE FROM images WHERE document_id = ?`, documentID)
+	return err
+}
@@ -0,0 +1,74 @@
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrChallengeFailed is returned when the provider rejects a challenge token.
+var ErrChallengeFailed = errors.New("captcha challenge failed")
+
+// siteverifyURLs are the token verification endpoints of the supported
+// third-party providers.
+var siteverifyURLs = map[string]string{
+	"hcaptcha":  "https://api.hcaptcha.com/siteverify",
+	"turnstile": "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+}
+
+var verifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// VerifyToken checks an hCaptcha or Cloudflare Turnstile challenge token
+// with the provider's siteverify API. It returns ErrChallengeFailed when the
+// token is missing, invalid, expired or already used, and another error when
+// the provider could not be reached.
+func VerifyToken(ctx context.Context, provider, secret, token, remoteIP string) error {
+	endpoint, ok := siteverifyURLs[provider]
+	if !ok {
+		return fmt.Errorf("unknown captcha provider %q", provider)
+	}
+	token = strings.TrimSpace(token)
+	if token == "" || len(token) > 4096 {
+		return ErrChallengeFailed
+	}
+	form := url.Values{"secret": {secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := verifyClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("captcha siteverify request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("captcha siteverify returned HTTP %d", resp.StatusCode)
+	}
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result); err != nil {
+		return fmt.Errorf("captcha siteverify response invalid: %w", err)
+	}
+	if !result.Success {
+		for _, code := range result.ErrorCodes {
+			// Our own misconfiguration, not a failed challenge
+			if code == "missing-input-secret" || code == "invalid-input-secret" {
+				return fmt.Errorf("captcha siteverify rejected the secret key: %s", code)
+			}
+		}
+		return ErrChallengeFailed
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+// Package channel provides adapters for external messaging channels
+// (Telegram Bot API and WeChat official account). Incoming messages are mapped
+// to internal users, answered through the query engine, and follow-up
+// notifications are delivered when an admin later answers a pending question.
+package channel
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"askflow/internal/config"
+	"askflow/internal/errlog"
+	"askflow/internal/query"
+	"askflow/internal/usage"
+)
+
+const (
+	// ProviderTelegram is the users.provider value for Telegram chat users.
+	ProviderTelegram = "telegram"
+	// ProviderWeChat is the users.provider value for WeChat official account followers.
+	ProviderWeChat = "wechat"
+)
+
+// maxReplyRunes caps outgoing message length; both Telegram (4096 chars) and
+// WeChat customer service text messages reject longer payloads.
+const maxReplyRunes = 2000
+
+// QueryFunc answers a question through the RAG pipeline.
+type QueryFunc func(ctx context.Context, req query.QueryRequest) (*query.QueryResponse, error)
+
+// Service dispatches channel messages to the query engine and sends replies.
+type Service struct {
+	db             *sql.DB
+	cfg            func() config.ChannelsConfig
+	query          QueryFunc
+	defaultProduct func() (string, error)
+	httpClient     *http.Client
+
+	// WeChat access_token cache
+	tokenMu     sync.Mutex
+	wechatToken string
+	tokenExpiry time.Time
+}
+
+// NewService creates a channel Service. cfgFn is called on every message so
+// configuration changes take effect without restart. defaultProductFn resolves
+// the product used when a channel has no product configured.
+func NewService(db *sql.DB, cfgFn func() config.ChannelsConfig, queryFn QueryFunc, defaultProductFn func() (string, error)) *Service {
+	return &Service{
+		db:             db,
+		cfg:            cfgFn,
+		query:          queryFn,
+		defaultProduct: defaultProductFn,
+		httpClient:     &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// Config returns the current channel configuration.
+func (s *Service) Config() config.ChannelsConfig {
+	return s.cfg()
+}
+
+// resolveUser maps an external channel user to an internal user ID,
+// creating the users record on first contact.
+func (s *Service) resolveUser(provider, externalID, name string) (string, error) {
+	var userID string
+	err := s.db.QueryRow(
+		`SELECT id FROM users WHERE provider = ? AND provider_id = ?`, provider, externalID,
+	).Scan(&userID)
+	if err == nil {
+		return userID, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", fmt.Errorf("failed to look up channel user: %w", err)
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate ID: %w", err)
+	}
+	userID = provider + "_" + hex.EncodeToString(b)
+	if name == "" {
+		name = provider + " user"
+	}
+	_, err = s.db.Exec(
+		`INSERT INTO users (id, email, name, provider, provider_id, email_verified, created_at) VALUES (?, ?, ?, ?, ?, 1, ?)`,
+		userID, userID+"@"+provider, name, provider, externalID, time.Now().UTC(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to create channel user: %w", err)
+	}
+	log.Printf("[Channel] created %s user %s", provider, userID)
+	return userID, nil
+}
+
+// answer resolves the user and runs the question through the query engine,
+// returning the reply text to send back on the channel.
+func (s *Service) answer(provider, externalID, name, productID, text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", nil
+	}
+	if len(text) > 2000 {
+		return "问题过长，请精简后再试。", nil
+	}
+	userID, err := s.resolveUser(provider, externalID, name)
+	if err != nil {
+		return "", err
+	}
+	if productID == "" && s.defaultProduct != nil {
+		productID, _ = s.defaultProduct()
+	}
+	// Messages are answered after the webhook request has been acknowledged
+	resp, err := s.query(context.Background(), query.QueryRequest{
+		Question:  text,
+		UserID:    userID,
+		ProductID: productID,
+	})
+	var quotaErr *usage.QuotaError
+	if errors.As(err, &quotaErr) {
+		return "本月问答次数已达上限，请下月再试或联系管理员。", nil
+	}
+	if err != nil {
+		errlog.Logf("[Channel] %s query failed for user=%s: %v", provider, userID, err)
+		return "查询处理失败，请稍后重试。", nil
+	}
+	reply := resp.Answer
+	if reply == "" {
+		reply = resp.Message
+	}
+	if resp.Unverified {
+		reply += "\n\n" + resp.Message
+	}
+	if resp.IsPending || resp.Unverified {
+		reply += "\n\n管理员回答后会通过此对话通知您。"
+	}
+	return truncateRunes(reply, maxReplyRunes), nil
+}
+
+// NotifyAnswered sends the admin's answer to the channel user who asked the
+// pending question. Questions asked from other sources are ignored.
+func (s *Service) NotifyAnswered(questionID string) {
+	var question, answer, llmAnswer, provider, externalID string
+	err := s.db.QueryRow(
+		`SELECT pq.question, COALESCE(pq.answer, ''), COALESCE(pq.llm_answer, ''), u.provider, u.provider_id
+		FROM pending_questions pq JOIN users u ON pq.user_id = u.id
+		WHERE pq.id = ?`, questionID,
+	).Scan(&question, &answer, &llmAnswer, &provider, &externalID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("[Channel] failed to load answered question %s: %v", questionID, err)
+		}
+		return
+	}
+	if provider != ProviderTelegram && provider != ProviderWeChat {
+		return
+	}
+	if llmAnswer != "" {
+		answer = llmAnswer
+	}
+	msg := truncateRunes(fmt.Sprintf("您之前的问题「%s」已有回答：\n\n%s", truncateRunes(question, 50), answer), maxReplyRunes)
+
+	cfg := s.cfg()
+	switch provider {
+	case ProviderTelegram:
+		if !cfg.Telegram.Enabled {
+			return
+		}
+		err = s.sendTelegram(cfg.Telegram, externalID, msg)
+	case ProviderWeChat:
+		if !cfg.WeChat.Enabled {
+			return
+		}
+		err = s.sendWeChat(cfg.WeChat, externalID, msg)
+	}
+	if err != nil {
+		log.Printf("[Channel] %s follow-up for question %s failed: %v", provider, questionID, err)
+		errlog.Logf("[Channel] %s follow-up for question %s failed: %v", provider, questionID, err)
+	}
+}
+
+// truncateRunes shortens s to at most n runes, appending "..." if truncated.
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "..."
+}
@@ -0,0 +1,112 @@
+package channel
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"askflow/internal/config"
+	"askflow/internal/errlog"
+)
+
+// telegramAPIBase is the Telegram Bot API endpoint.
+const telegramAPIBase = "https://api.telegram.org/bot"
+
+// TelegramUpdate is the subset of a Bot API Update used by the adapter.
+type TelegramUpdate struct {
+	UpdateID int64            `json:"update_id"`
+	Message  *TelegramMessage `json:"message"`
+}
+
+// TelegramMessage is the subset of a Bot API Message used by the adapter.
+type TelegramMessage struct {
+	MessageID int64 `json:"message_id"`
+	From      *struct {
+		ID        int64  `json:"id"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Username  string `json:"username"`
+	} `json:"from"`
+	Chat struct {
+		ID   int64  `json:"id"`
+		Type string `json:"type"`
+	} `json:"chat"`
+	Text string `json:"text"`
+}
+
+// VerifyTelegramSecret checks the X-Telegram-Bot-Api-Secret-Token header
+// against the configured webhook secret.
+func VerifyTelegramSecret(cfg config.TelegramConfig, header string) bool {
+	if cfg.WebhookSecret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cfg.WebhookSecret), []byte(header)) == 1
+}
+
+// HandleTelegramUpdate answers a Telegram message and sends the reply to the chat.
+// Only private chats are handled; the chat ID identifies the user.
+func (s *Service) HandleTelegramUpdate(update TelegramUpdate) {
+	cfg := s.cfg().Telegram
+	msg := update.Message
+	if !cfg.Enabled || msg == nil || msg.Chat.Type != "private" || msg.Text == "" {
+		return
+	}
+	chatID := strconv.FormatInt(msg.Chat.ID, 10)
+
+	text := msg.Text
+	if strings.HasPrefix(text, "/start") {
+		if err := s.sendTelegram(cfg, chatID, "您好，请直接输入您的问题。"); err != nil {
+			log.Printf("[Channel] telegram welcome failed: %v", err)
+		}
+		return
+	}
+
+	var name string
+	if msg.From != nil {
+		name = strings.TrimSpace(msg.From.FirstName + " " + msg.From.LastName)
+		if name == "" {
+			name = msg.From.Username
+		}
+	}
+	reply, err := s.answer(ProviderTelegram, chatID, name, cfg.ProductID, text)
+	if err != nil {
+		log.Printf("[Channel] telegram message handling failed: %v", err)
+		errlog.Logf("[Channel] telegram message handling failed chat=%s: %v", chatID, err)
+		return
+	}
+	if reply == "" {
+		return
+	}
+	if err := s.sendTelegram(cfg, chatID, reply); err != nil {
+		log.Printf("[Channel] telegram reply failed: %v", err)
+		errlog.Logf("[Channel] telegram reply failed chat=%s: %v", chatID, err)
+	}
+}
+
+// sendTelegram sends a plain text message via the Bot API sendMessage method.
+func (s *Service) sendTelegram(cfg config.TelegramConfig, chatID, text string) error {
+	if cfg.BotToken == "" {
+		return fmt.Errorf("telegram bot token not configured")
+	}
+	body, err := json.Marshal(map[string]string{"chat_id": chatID, "text": text})
+	if err != nil {
+		return err
+	}
+	resp, err := s.httpClient.Post(telegramAPIBase+cfg.BotToken+"/sendMessage", "application/json", bytes.NewReader(body))
+	if err != nil {
+		// Avoid leaking the bot token embedded in the request URL
+		return fmt.Errorf("telegram sendMessage request failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("telegram sendMessage returned HTTP %d: %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package channel
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"askflow/internal/config"
+	"askflow/internal/errlog"
+)
+
+// wechatAPIBase is the WeChat official account API endpoint.
+const wechatAPIBase = "https://api.weixin.qq.com/cgi-bin"
+
+// WeChatMessage is an incoming message pushed by the WeChat server (plaintext mode).
+type WeChatMessage struct {
+	XMLName      xml.Name `xml:"xml"`
+	ToUserName   string   `xml:"ToUserName"`
+	FromUserName string   `xml:"FromUserName"`
+	CreateTime   int64    `xml:"CreateTime"`
+	MsgType      string   `xml:"MsgType"`
+	Content      string   `xml:"Content"`
+	MsgID        int64    `xml:"MsgId"`
+	Event        string   `xml:"Event"`
+}
+
+// VerifyWeChatSignature checks the signature WeChat attaches to every callback:
+// sha1 of the lexically sorted token, timestamp and nonce.
+func VerifyWeChatSignature(cfg config.WeChatConfig, signature, timestamp, nonce string) bool {
+	if cfg.Token == "" || signature == "" {
+		return false
+	}
+	parts := []string{cfg.Token, timestamp, nonce}
+	sort.Strings(parts)
+	sum := sha1.Sum([]byte(strings.Join(parts, "")))
+	expected := hex.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(signature)) == 1
+}
+
+// HandleWeChatMessage answers a WeChat text message and replies through the
+// customer service message API. Replies are sent asynchronously because
+// answering usually takes longer than the 5s passive reply window.
+func (s *Service) HandleWeChatMessage(msg WeChatMessage) {
+	cfg := s.cfg().WeChat
+	if !cfg.Enabled || msg.FromUserName == "" {
+		return
+	}
+	openID := msg.FromUserName
+
+	var reply string
+	switch msg.MsgType {
+	case "event":
+		if msg.Event != "subscribe" {
+			return
+		}
+		reply = "感谢关注，请直接输入您的问题。"
+	case "text":
+		var err error
+		reply, err = s.answer(ProviderWeChat, openID, "", cfg.ProductID, msg.Content)
+		if err != nil {
+			log.Printf("[Channel] wechat message handling failed: %v", err)
+			errlog.Logf("[Channel] wechat message handling failed openid=%s: %v", openID, err)
+			return
+		}
+	default:
+		reply = "暂时只支持文字提问。"
+	}
+	if reply == "" {
+		return
+	}
+	if err := s.sendWeChat(cfg, openID, reply); err != nil {
+		log.Printf("[Channel] wechat reply failed: %v", err)
+		errlog.Logf("[Channel] wechat reply failed openid=%s: %v", openID, err)
+	}
+}
+
+// sendWeChat sends a text message to a follower via the customer service API.
+func (s *Service) sendWeChat(cfg config.WeChatConfig, openID, text string) error {
+	token, err := s.wechatAccessToken(cfg)
+	if err != nil {
+		return err
+	}
+	payload := map[string]interface{}{
+		"touser":  openID,
+		"msgtype": "text",
+		"text":    map[string]string{"content": text},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := s.httpClient.Post(wechatAPIBase+"/message/custom/send?access_token="+url.QueryEscape(token), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("wechat custom send request failed")
+	}
+	defer resp.Body.Close()
+	var result struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("wechat custom send: invalid response: %w", err)
+	}
+	if result.ErrCode != 0 {
+		// 40001/42001: token invalid or expired — drop cache so the next call refreshes it
+		if result.ErrCode == 40001 || result.ErrCode == 42001 {
+			s.tokenMu.Lock()
+			s.wechatToken = ""
+			s.tokenMu.Unlock()
+		}
+		return fmt.Errorf("wechat custom send error %d: %s", result.ErrCode, result.ErrMsg)
+	}
+	return nil
+}
+
+// wechatAccessToken returns a cached access_token, fetching a new one when expired.
+func (s *Service) wechatAccessToken(cfg config.WeChatConfig) (string, error) {
+	if cfg.AppID == "" || cfg.AppSecret == "" {
+		return "", fmt.Errorf("wechat app_id/app_secret not configured")
+	}
+	s.tokenMu.Lock()
+	defer s.tokenMu.Unlock()
+	if s.wechatToken != "" && time.Now().Before(s.tokenExpiry) {
+		return s.wechatToken, nil
+	}
+
+	params := url.Values{}
+	params.Set("grant_type", "client_credential")
+	params.Set("appid", cfg.AppID)
+	params.Set("secret", cfg.AppSecret)
+	resp, err := s.httpClient.Get(wechatAPIBase + "/token?" + params.Encode())
+	if err != nil {
+		return "", fmt.Errorf("wechat token request failed")
+	}
+	defer resp.Body.Close()
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		ErrCode     int    `json:"errcode"`
+		ErrMsg      string `json:"errmsg"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("wechat token: invalid response: %w", err)
+	}
+	if result.AccessToken == "" {
+		return "", fmt.Errorf("wechat token error %d: %s", result.ErrCode, result.ErrMsg)
+	}
+	s.wechatToken = result.AccessToken
+	// Refresh 5 minutes before the official expiry (normally 7200s)
+	s.tokenExpiry = time.Now().Add(time.Duration(result.ExpiresIn-300) * time.Second)
+	return s.wechatToken, nil
+}
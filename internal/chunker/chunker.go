@@ -19,6 +19,8 @@ type Chunk struct {
 	Text       string `json:"text"`
 	Index      int    `json:"index"`
 	DocumentID string `json:"document_id"`
+	Start      int    `json:"start"` // rune offset of the chunk in the source text
+	End        int    `json:"end"`   // rune offset just past the chunk
 }
 
 // NewTextChunker creates a TextChunker with default settings.
@@ -30,8 +32,8 @@ func NewTextChunker() *TextChunker {
 }
 
 // Split divides text into chunks of ChunkSize runes with Overlap runes
-// of overlap between adjacent chunks. Each chunk is tagged with the given documentID
-// and an incrementing index starting from 0.
+// of overlap between adjacent chunks. Each chunk is tagged with the given documentID,
+// an incrementing index starting from 0 and its rune offsets in text.
 //
 // Uses rune-based splitting to correctly handle multi-byte Unicode characters.
 // Returns an empty slice for empty text.
@@ -71,6 +73,8 @@ func (tc *TextChunker) Split(text string, documentID string) []Chunk {
 			Text:       string(runes[start:end]),
 			Index:      index,
 			DocumentID: documentID,
+			Start:      start,
+			End:        end,
 		})
 		index++
 
@@ -1,16 +1,35 @@
 package cli
 
 import (
+	"bufio"
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"askflow/internal/backup"
+	"askflow/internal/config"
+	"askflow/internal/db"
 	"askflow/internal/document"
+	"askflow/internal/eval"
 	"askflow/internal/handler"
+	"askflow/internal/openapi"
 	"askflow/internal/product"
+	"askflow/internal/query"
+	"askflow/internal/videoworker"
 )
 
 // RunBatchImport scans directories and imports supported files.
@@ -112,6 +131,13 @@ func RunBatchImport(args []string, dm *document.DocumentManager, ps *product.Pro
 			failedFiles = append(failedFiles, failedFile{Path: filePath, Reason: reason})
 			continue
 		}
+		if fileType, err = handler.SniffFileType(fileName, fileData); err != nil {
+			reason := fmt.Sprintf("导入失败: %v", err)
+			fmt.Println(reason)
+			failed++
+			failedFiles = append(failedFiles, failedFile{Path: filePath, Reason: reason})
+			continue
+		}
 
 		req := document.UploadFileRequest{
 			FileName:  fileName,
@@ -119,7 +145,7 @@ func RunBatchImport(args []string, dm *document.DocumentManager, ps *product.Pro
 			FileType:  fileType,
 			ProductID: productID,
 		}
-		doc, err := dm.UploadFile(req)
+		doc, err := dm.UploadFile(context.Background(), req)
 		if err != nil {
 			reason := fmt.Sprintf("导入失败: %v", err)
 			fmt.Println(reason)
@@ -157,10 +183,12 @@ func RunBatchImport(args []string, dm *document.DocumentManager, ps *product.Pro
 }
 
 // RunBackup executes a full or incremental backup of the data directory.
-func RunBackup(args []string, db *sql.DB) {
+// When the S3 target is enabled the archive is also uploaded to the bucket.
+func RunBackup(args []string, db *sql.DB, s3cfg config.BackupS3Config) {
 	opts := backup.Options{
 		DataDir: "./data",
 		Mode:    "full",
+		Remote:  backup.S3TargetFromConfig(s3cfg),
 	}
 
 	for i := 0; i < len(args); i++ {
@@ -181,9 +209,11 @@ func RunBackup(args []string, db *sql.DB) {
 			}
 			opts.ManifestIn = args[i+1]
 			i++
+		case "--no-upload":
+			opts.Remote = nil
 		default:
 			fmt.Printf("未知参数: %s\n", args[i])
-			fmt.Println("用法: askflow backup [--output <目录>] [--incremental --base <manifest>]")
+			fmt.Println("用法: askflow backup [--output <目录>] [--incremental --base <manifest>] [--no-upload]")
 			os.Exit(1)
 		}
 	}
@@ -198,7 +228,7 @@ func RunBackup(args []string, db *sql.DB) {
 	fmt.Printf("开始%s备份...\n", map[string]string{"full": "全量", "incremental": "增量"}[opts.Mode])
 
 	result, err := backup.Run(db, opts)
-	if err != nil {
+	if result == nil {
 		fmt.Printf("备份失败: %v\n", err)
 		os.Exit(1)
 	}
@@ -208,12 +238,23 @@ func RunBackup(args []string, db *sql.DB) {
 	fmt.Printf("  Manifest: %s\n", result.ManifestPath)
 	fmt.Printf("  文件数: %d, 数据库行数: %d\n", result.FilesWritten, result.DBRows)
 	fmt.Printf("  归档大小: %.2f MB\n", float64(result.BytesWritten)/(1024*1024))
+	if err != nil {
+		fmt.Printf("上传到 S3 失败（本地归档已保留）: %v\n", err)
+		os.Exit(1)
+	}
+	if result.RemoteArchive != "" {
+		fmt.Printf("  已上传: %s\n", result.RemoteArchive)
+	}
 }
 
-// RunRestore restores data from a backup archive.
-func RunRestore(args []string) {
+// RunRestore restores data from a backup archive. The archive may be a local
+// file or an s3://bucket/key URL, which is downloaded using the S3 settings in
+// the data directory's config and the environment (see s3TargetFor).
+func RunRestore(args []string, dataDir string) {
+	const usage = "用法: askflow restore [--target <目录>] <备份文件 | s3://bucket/key>\n      askflow restore --list s3://bucket[/prefix]"
 	targetDir := "./data"
 	var archivePath string
+	list := false
 
 	for i := 0; i < len(args); i++ {
 		switch args[i] {
@@ -224,6 +265,8 @@ func RunRestore(args []string) {
 			}
 			targetDir = args[i+1]
 			i++
+		case "--list":
+			list = true
 		default:
 			if archivePath != "" {
 				fmt.Printf("未知参数: %s\n", args[i])
@@ -235,17 +278,86 @@ func RunRestore(args []string) {
 
 	if archivePath == "" {
 		fmt.Println("错误: 请指定备份文件路径")
-		fmt.Println("用法: askflow restore [--target <目录>] <备份文件>")
+		fmt.Println(usage)
 		os.Exit(1)
 	}
 
+	bucket, key, remote := backup.ParseS3URL(archivePath)
+	if list && !remote {
+		fmt.Println("错误: --list 需要指定 s3:// 地址")
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+	if !remote {
+		fmt.Printf("从 %s 恢复数据到 %s ...\n", archivePath, targetDir)
+		if err := backup.Restore(archivePath, targetDir); err != nil {
+			fmt.Printf("恢复失败: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	t := s3TargetFor(dataDir, bucket)
+	if list {
+		objects, err := t.List(context.Background(), key)
+		if err != nil {
+			fmt.Printf("列出备份失败: %v\n", err)
+			os.Exit(1)
+		}
+		n := 0
+		for _, o := range objects {
+			if !strings.HasSuffix(o.Key, ".tar.gz") {
+				continue
+			}
+			fmt.Printf("%-20s  %10.2f MB  %s\n", o.LastModified.Local().Format("2006-01-02 15:04:05"), float64(o.Size)/(1024*1024), t.URL(o.Key))
+			n++
+		}
+		fmt.Printf("\n共 %d 个备份\n", n)
+		return
+	}
+	if key == "" || strings.HasSuffix(key, "/") {
+		fmt.Println("错误: 请指定备份文件的完整对象路径，可先用 --list 查看")
+		os.Exit(1)
+	}
 	fmt.Printf("从 %s 恢复数据到 %s ...\n", archivePath, targetDir)
-	if err := backup.Restore(archivePath, targetDir); err != nil {
+	if err := backup.RestoreFromS3(t, key, targetDir); err != nil {
 		fmt.Printf("恢复失败: %v\n", err)
 		os.Exit(1)
 	}
 }
 
+// s3TargetFor builds the S3 target for restoring from bucket. Settings come
+// from backup.s3 in the data directory's config when it can be loaded, and
+// are overridden by AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_REGION,
+// ASKFLOW_S3_ENDPOINT and ASKFLOW_S3_PATH_STYLE, so a fresh host can restore
+// before any config exists.
+func s3TargetFor(dataDir, bucket string) *backup.S3Target {
+	var c config.BackupS3Config
+	if cm, err := config.NewConfigManager(filepath.Join(dataDir, "config.json")); err == nil {
+		if err := cm.Load(); err == nil {
+			c = cm.Get().Backup.S3
+		}
+	}
+	c.Enabled = true
+	c.Bucket = bucket
+	if v := os.Getenv("AWS_ACCESS_KEY_ID"); v != "" {
+		c.AccessKey = v
+	}
+	if v := os.Getenv("AWS_SECRET_ACCESS_KEY"); v != "" {
+		c.SecretKey = v
+	}
+	if v := os.Getenv("AWS_REGION"); v != "" {
+		c.Region = v
+	}
+	if v := os.Getenv("ASKFLOW_S3_ENDPOINT"); v != "" {
+		c.Endpoint = v
+	}
+	if v := os.Getenv("ASKFLOW_S3_PATH_STYLE"); v != "" {
+		c.PathStyle, _ = strconv.ParseBool(v)
+	}
+	return backup.S3TargetFromConfig(c)
+}
+
 // RunListProducts lists all products with their IDs.
 func RunListProducts(ps *product.ProductService) {
 	products, err := ps.List()
@@ -268,3 +380,804 @@ func RunListProducts(ps *product.ProductService) {
 	}
 	fmt.Printf("\n共 %d 个产品\n", len(products))
 }
+
+// RunOpenAPI writes the OpenAPI document of the HTTP API to a file or
+// stdout, for generating clients without a running server. The document has
+// no server URL; generators take it as an option.
+func RunOpenAPI(args []string, doc *openapi.Registry) {
+	output := ""
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--output", "-o":
+			if i+1 >= len(args) {
+				fmt.Println("错误: --output 需要指定文件路径")
+				os.Exit(1)
+			}
+			output = args[i+1]
+			i++
+		default:
+			fmt.Printf("未知参数: %s\n", args[i])
+			fmt.Println("用法: askflow openapi [--output <文件>]")
+			os.Exit(1)
+		}
+	}
+	data, err := doc.MarshalIndent()
+	if err != nil {
+		fmt.Printf("生成 API 文档失败: %v\n", err)
+		os.Exit(1)
+	}
+	if output == "" {
+		os.Stdout.Write(append(data, '\n'))
+		return
+	}
+	if err := os.WriteFile(output, append(data, '\n'), 0644); err != nil {
+		fmt.Printf("写入文件失败: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("API 文档已写入 %s\n", output)
+}
+
+// RunVideoWorker runs this machine as a remote video worker of the instance
+// at --server until interrupted: it claims queued videos, parses them with
+// ffmpeg and the speech-to-text backend of the local config.json, and
+// uploads the transcripts and keyframes. The token is taken from --token or
+// ASKFLOW_VIDEO_WORKER_TOKEN and must equal the server's video.workers.token.
+func RunVideoWorker(args []string, dataDir string) {
+	const usage = "用法: askflow video-worker --server <url> [--token <令牌>] [--name <名称>] [--concurrency <n>]"
+	server, token, name := "", os.Getenv("ASKFLOW_VIDEO_WORKER_TOKEN"), ""
+	concurrency := 1
+	stringArg := func(i int, flag string) string {
+		if i+1 >= len(args) {
+			fmt.Printf("错误: %s 需要指定参数值\n", flag)
+			os.Exit(1)
+		}
+		return args[i+1]
+	}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--server":
+			server = stringArg(i, "--server")
+			i++
+		case "--token":
+			token = stringArg(i, "--token")
+			i++
+		case "--name":
+			name = stringArg(i, "--name")
+			i++
+		case "--concurrency":
+			n, err := strconv.Atoi(stringArg(i, "--concurrency"))
+			if err != nil || n < 1 || n > 16 {
+				fmt.Println("错误: --concurrency 的取值范围为 1-16")
+				os.Exit(1)
+			}
+			concurrency = n
+			i++
+		case "--datadir":
+			// Parsed by main
+			i++
+		default:
+			if strings.HasPrefix(args[i], "--datadir=") {
+				continue
+			}
+			fmt.Printf("未知参数: %s\n", args[i])
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+	}
+	if server == "" {
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+	if name == "" {
+		host, err := os.Hostname()
+		if err != nil || host == "" {
+			host = "worker"
+		}
+		name = fmt.Sprintf("%s:%d", host, os.Getpid())
+	}
+	client, err := videoworker.NewClient(server, token, name)
+	if err != nil {
+		fmt.Printf("错误: %v\n", err)
+		os.Exit(1)
+	}
+
+	cm, err := config.NewConfigManager(filepath.Join(dataDir, "config.json"))
+	if err == nil {
+		err = cm.Load()
+	}
+	if err != nil {
+		fmt.Printf("加载配置失败: %v\n", err)
+		os.Exit(1)
+	}
+	cfg := cm.Get().Video
+	if cfg.FFmpegPath == "" {
+		fmt.Println("错误: 本机未配置 video.ffmpeg_path")
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	log.Printf("[VideoWorker] %s working for %s with %d slot(s), speech-to-text: %s", name, server, concurrency, cfg.STT.Provider)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			videoworker.Run(ctx, client, cfg)
+		}()
+	}
+	wg.Wait()
+	log.Printf("[VideoWorker] stopped")
+}
+
+// RunMigrate inspects or changes the database schema version.
+// It opens the database without the automatic upgrade done at startup so that
+// "status" and "down" see the schema as it is.
+func RunMigrate(args []string, dataDir string) {
+	const usage = "用法: askflow migrate [status | up [版本] | down <版本>]"
+	action := "status"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	target := 0
+	if len(args) > 1 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil || v < 0 {
+			fmt.Printf("错误: 无效的版本号 %s\n", args[1])
+			os.Exit(1)
+		}
+		target = v
+	}
+	if len(args) > 2 || (action == "status" && len(args) > 1) {
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+	if action == "down" && len(args) < 2 {
+		fmt.Println("错误: down 需要指定目标版本")
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+
+	cm, err := config.NewConfigManager(filepath.Join(dataDir, "config.json"))
+	if err == nil {
+		err = cm.Load()
+	}
+	if err != nil {
+		fmt.Printf("加载配置失败: %v\n", err)
+		os.Exit(1)
+	}
+	dbPath := cm.Get().Vector.DBPath
+	if !filepath.IsAbs(dbPath) {
+		dbPath = filepath.Join(dataDir, dbPath)
+	}
+	pair, err := db.Open(dbPath)
+	if err != nil {
+		fmt.Printf("打开数据库失败: %v\n", err)
+		os.Exit(1)
+	}
+	defer pair.Close()
+
+	switch action {
+	case "status":
+		statuses, err := db.Status(pair.Write)
+		if err != nil {
+			fmt.Printf("查询迁移状态失败: %v\n", err)
+			os.Exit(1)
+		}
+		current, err := db.CurrentVersion(pair.Write)
+		if err != nil {
+			fmt.Printf("查询迁移状态失败: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("当前版本: %d\n\n", current)
+		fmt.Printf("%-6s  %-30s  %s\n", "版本", "名称", "状态")
+		fmt.Println(strings.Repeat("-", 60))
+		for _, s := range statuses {
+			state := "未应用"
+			if s.Applied {
+				state = "已应用 " + s.AppliedAt
+			}
+			fmt.Printf("%04d    %-30s  %s\n", s.Version, s.Name, state)
+		}
+	case "up":
+		applied, err := db.MigrateUp(pair.Write, target)
+		for _, m := range applied {
+			fmt.Printf("已应用: %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Printf("迁移失败: %v\n", err)
+			os.Exit(1)
+		}
+		if len(applied) == 0 {
+			fmt.Println("数据库已是最新版本")
+		}
+	case "down":
+		reverted, err := db.MigrateDown(pair.Write, target)
+		for _, m := range reverted {
+			fmt.Printf("已回滚: %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Printf("回滚失败: %v\n", err)
+			os.Exit(1)
+		}
+		if len(reverted) == 0 {
+			fmt.Printf("没有高于版本 %d 的已应用迁移\n", target)
+		}
+	default:
+		fmt.Printf("未知参数: %s\n", action)
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+}
+
+// RunRotateKey replaces the config encryption key: it backs up config.json
+// and the key file, installs a new key (generated, or given with --key) and
+// encrypts the config secrets and everything reencrypt covers again with
+// it. An interrupted rotation is resumed instead. The service must not be
+// running meanwhile.
+func RunRotateKey(args []string, cm *config.ConfigManager, reencrypt func() error) {
+	const usage = "用法: askflow rotate-key [--key <64 位十六进制密钥>]"
+	var newKey []byte
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--key":
+			if i+1 >= len(args) {
+				fmt.Println("错误: --key 需要指定密钥")
+				os.Exit(1)
+			}
+			key, err := config.ParseKey(args[i+1])
+			if err != nil {
+				fmt.Printf("错误: %v\n", err)
+				os.Exit(1)
+			}
+			newKey = key
+			i++
+		default:
+			fmt.Printf("未知参数: %s\n", args[i])
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+	}
+
+	if cm.RotationPending() {
+		if newKey != nil {
+			fmt.Println("错误: 上次密钥轮换尚未完成，请先不带 --key 运行以完成它")
+			os.Exit(1)
+		}
+		fmt.Println("继续上次未完成的密钥轮换 ...")
+	} else {
+		if newKey == nil {
+			newKey = make([]byte, 32)
+			if _, err := rand.Read(newKey); err != nil {
+				fmt.Printf("生成密钥失败: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if config.KeyFromEnv() {
+			// The key cannot be installed for the service; it is shown
+			// before anything is encrypted with it
+			fmt.Printf("新密钥: %s\n", hex.EncodeToString(newKey))
+		}
+		backups, err := cm.RotateKey(newKey)
+		for _, b := range backups {
+			fmt.Printf("已备份: %s\n", b)
+		}
+		if err != nil {
+			fmt.Printf("密钥轮换失败: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("配置文件已使用新密钥加密")
+	}
+
+	if err := reencrypt(); err != nil {
+		fmt.Printf("重新加密失败: %v\n", err)
+		fmt.Println("修复问题后再次运行 askflow rotate-key 即可继续；在此之前服务可用新旧两个密钥解密")
+		os.Exit(1)
+	}
+	if err := cm.FinishRotation(); err != nil {
+		fmt.Printf("完成密钥轮换失败: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("密钥轮换完成")
+	if config.KeyFromEnv() {
+		fmt.Println("启动服务前请将环境变量 ASKFLOW_ENCRYPTION_KEY 改为新密钥，并删除 ASKFLOW_ENCRYPTION_KEY_PREVIOUS（如已设置）")
+	}
+	fmt.Println("旧密钥仅用于恢复轮换前的备份，请将备份文件转移到安全位置后从服务器删除")
+	fmt.Println("已发出的密码重置、邀请与解锁链接随旧密钥失效，S3 连接器的事件通知令牌需重新获取并配置")
+}
+
+// RunEval runs a golden question set through retrieval and prints recall@k,
+// MRR and LLM-judged faithfulness. Top-k and threshold default to the
+// configured vector settings so candidate values can be compared against
+// them. With --min-* flags it exits non-zero when a metric falls short, for
+// use as a pre-deploy check.
+func RunEval(args []string, qe *query.QueryEngine, vcfg config.VectorConfig) {
+	const usage = "用法: askflow eval [--k <n>] [--threshold <0-1>] [--no-judge] [--json] [--min-recall <0-1>] [--min-mrr <0-1>] [--min-faithfulness <0-1>] <golden.yaml|golden.json>"
+	opts := eval.Options{TopK: vcfg.TopK, Threshold: vcfg.Threshold}
+	judge, asJSON := true, false
+	var minRecall, minMRR, minFaith float64
+	var path string
+
+	floatArg := func(i int, name string, lo, hi float64) float64 {
+		if i+1 >= len(args) {
+			fmt.Printf("错误: %s 需要指定数值\n", name)
+			os.Exit(1)
+		}
+		v, err := strconv.ParseFloat(args[i+1], 64)
+		if err != nil || v < lo || v > hi {
+			fmt.Printf("错误: %s 的取值范围为 %g-%g\n", name, lo, hi)
+			os.Exit(1)
+		}
+		return v
+	}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--k":
+			opts.TopK = int(floatArg(i, "--k", 1, 100))
+			i++
+		case "--threshold":
+			opts.Threshold = floatArg(i, "--threshold", 0, 1)
+			i++
+		case "--min-recall":
+			minRecall = floatArg(i, "--min-recall", 0, 1)
+			i++
+		case "--min-mrr":
+			minMRR = floatArg(i, "--min-mrr", 0, 1)
+			i++
+		case "--min-faithfulness":
+			minFaith = floatArg(i, "--min-faithfulness", 0, 1)
+			i++
+		case "--no-judge":
+			judge = false
+		case "--json":
+			asJSON = true
+		default:
+			if strings.HasPrefix(args[i], "-") || path != "" {
+				fmt.Printf("未知参数: %s\n", args[i])
+				fmt.Println(usage)
+				os.Exit(1)
+			}
+			path = args[i]
+		}
+	}
+	if path == "" {
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+	if opts.TopK <= 0 {
+		opts.TopK = 5
+	}
+
+	set, err := eval.LoadSet(path)
+	if err != nil {
+		fmt.Printf("加载评测集失败: %v\n", err)
+		os.Exit(1)
+	}
+	if judge {
+		_, opts.Judge = qe.Services()
+	}
+
+	var progress func(done, total int)
+	if !asJSON {
+		fmt.Printf("评测集: %s（%d 个问题）  top_k=%d  threshold=%.2f\n\n", set.Name, len(set.Cases), opts.TopK, opts.Threshold)
+		progress = func(done, total int) {
+			fmt.Printf("\r进度: %d/%d", done, total)
+		}
+	}
+	report := eval.Run(context.Background(), set, qe, opts, progress)
+
+	if asJSON {
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		fmt.Printf("\n\n%-12s  %-8s  %-8s  %-8s  %s\n", "ID", "Recall", "RR", "忠实度", "未召回 / 错误")
+		fmt.Println(strings.Repeat("-", 80))
+		for _, c := range report.Cases {
+			faith := "-"
+			if c.Faithfulness != nil {
+				faith = fmt.Sprintf("%.2f", *c.Faithfulness)
+			}
+			note := strings.Join(c.Missing, ", ")
+			if c.Error != "" {
+				if note != "" {
+					note += "; "
+				}
+				note += "错误: " + c.Error
+			}
+			fmt.Printf("%-12s  %-8.2f  %-8.2f  %-8s  %s\n", c.ID, c.Recall, c.ReciprocalRank, faith, note)
+		}
+		fmt.Println(strings.Repeat("-", 80))
+		fmt.Printf("recall@%d: %.3f\n", report.TopK, report.RecallAtK)
+		fmt.Printf("MRR:       %.3f\n", report.MRR)
+		if report.Faithfulness != nil {
+			fmt.Printf("忠实度:    %.3f（%d 个问题已评判）\n", *report.Faithfulness, report.JudgedCases)
+		}
+		if report.Errors > 0 {
+			fmt.Printf("错误:      %d 个问题\n", report.Errors)
+		}
+	}
+
+	failed := false
+	if report.RecallAtK < minRecall {
+		fmt.Fprintf(os.Stderr, "recall@%d %.3f 低于要求的 %.3f\n", report.TopK, report.RecallAtK, minRecall)
+		failed = true
+	}
+	if report.MRR < minMRR {
+		fmt.Fprintf(os.Stderr, "MRR %.3f 低于要求的 %.3f\n", report.MRR, minMRR)
+		failed = true
+	}
+	if minFaith > 0 && (report.Faithfulness == nil || *report.Faithfulness < minFaith) {
+		fmt.Fprintf(os.Stderr, "忠实度低于要求的 %.3f\n", minFaith)
+		failed = true
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// RunQuery answers questions through the full RAG pipeline against the local
+// database and configuration, without the web UI. The question comes from
+// the arguments or stdin; on a terminal without a question it reads one
+// question per line until "exit" or EOF. Unanswerable questions are not
+// queued for the support team unless --pending is given. Exits non-zero when
+// a query fails, or with --require-answer when a question got no answer.
+func RunQuery(args []string, qe *query.QueryEngine, ps *product.ProductService) {
+	const usage = "用法: askflow query [--product <product_id>] [--json] [--pending] [--require-answer] [问题]"
+	var productID string
+	asJSON, pending, requireAnswer := false, false, false
+	var words []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--product":
+			if i+1 >= len(args) {
+				fmt.Println("错误: --product 参数需要指定产品 ID")
+				fmt.Println(usage)
+				os.Exit(1)
+			}
+			productID = args[i+1]
+			i++
+		case "--json":
+			asJSON = true
+		case "--pending":
+			pending = true
+		case "--require-answer":
+			requireAnswer = true
+		default:
+			if strings.HasPrefix(args[i], "-") && args[i] != "-" {
+				fmt.Printf("未知参数: %s\n", args[i])
+				fmt.Println(usage)
+				os.Exit(1)
+			}
+			words = append(words, args[i])
+		}
+	}
+
+	// Like the web chat, default to the first product
+	if productID != "" {
+		if p, err := ps.GetByID(productID); err != nil || p == nil {
+			fmt.Printf("错误: 指定的产品不存在 (ID: %s)\n", productID)
+			os.Exit(1)
+		}
+	} else if id, err := ps.GetFirstIDByTenant(""); err == nil {
+		productID = id
+	}
+
+	ask := func(question string) bool {
+		start := time.Now()
+		resp, tokens, err := qe.QueryMetered(context.Background(), query.QueryRequest{
+			Question:  question,
+			UserID:    "cli",
+			ProductID: productID,
+			NoPending: !pending,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "查询失败: %v\n", err)
+			return false
+		}
+		if asJSON {
+			out, _ := json.MarshalIndent(resp, "", "  ")
+			fmt.Println(string(out))
+		} else {
+			printAnswer(resp, pending)
+			fmt.Printf("\n耗时 %v，tokens: embedding %d / prompt %d / completion %d\n",
+				time.Since(start).Round(time.Millisecond), tokens.EmbeddingTokens, tokens.PromptTokens, tokens.CompletionTokens)
+		}
+		return !requireAnswer || !resp.IsPending
+	}
+
+	question := strings.TrimSpace(strings.Join(words, " "))
+	if question == "" || question == "-" {
+		if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+			runChat(ask)
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(os.Stdin, 10001))
+		if err != nil {
+			fmt.Printf("读取标准输入失败: %v\n", err)
+			os.Exit(1)
+		}
+		question = strings.TrimSpace(string(data))
+	}
+	if question == "" {
+		fmt.Println("错误: 请输入问题")
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+	if len(question) > 10000 {
+		fmt.Println("错误: 问题过长（最多 10000 个字符）")
+		os.Exit(1)
+	}
+	if !ask(question) {
+		os.Exit(1)
+	}
+}
+
+// runChat reads questions from the terminal one line at a time.
+func runChat(ask func(string) bool) {
+	fmt.Println("输入问题后回车提问，输入 exit 或按 Ctrl-D 退出")
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024)
+	for {
+		fmt.Print("\n问题> ")
+		if !scanner.Scan() {
+			fmt.Println()
+			return
+		}
+		question := strings.TrimSpace(scanner.Text())
+		switch {
+		case question == "":
+			continue
+		case question == "exit" || question == "quit":
+			return
+		case len(question) > 10000:
+			fmt.Println("问题过长（最多 10000 个字符）")
+			continue
+		}
+		fmt.Println()
+		ask(question)
+	}
+}
+
+// printAnswer prints an answer and its sources for reading in a terminal.
+func printAnswer(resp *query.QueryResponse, pendingCreated bool) {
+	if resp.IsPending {
+		if resp.Message != "" {
+			fmt.Println(resp.Message)
+		}
+		if pendingCreated {
+			fmt.Println("[未找到答案，已创建待处理问题]")
+		} else {
+			fmt.Println("[未找到答案；命令行提问不创建待处理问题，加 --pending 可创建]")
+		}
+		return
+	}
+	fmt.Println(resp.Answer)
+	if len(resp.Sources) == 0 {
+		return
+	}
+	fmt.Println("\n来源:")
+	for i, s := range resp.Sources {
+		var loc []string
+		switch {
+		case s.Page > 0:
+			loc = append(loc, fmt.Sprintf("第 %d 页", s.Page))
+		case s.Slide > 0:
+			loc = append(loc, fmt.Sprintf("第 %d 张幻灯片", s.Slide))
+		}
+		if s.EndTime > 0 {
+			loc = append(loc, fmt.Sprintf("%s-%s", formatSeconds(s.StartTime), formatSeconds(s.EndTime)))
+		}
+		if s.ImageURL != "" {
+			loc = append(loc, "图片")
+		}
+		name := s.DocumentName
+		if len(loc) > 0 {
+			name += "（" + strings.Join(loc, "，") + "）"
+		}
+		fmt.Printf("  [%d] %s\n", i+1, name)
+		if snippet := strings.Join(strings.Fields(s.Snippet), " "); snippet != "" {
+			if r := []rune(snippet); len(r) > 120 {
+				snippet = string(r[:120]) + "..."
+			}
+			fmt.Printf("      %s\n", snippet)
+		}
+	}
+}
+
+// formatSeconds formats a video offset as m:ss or h:mm:ss.
+func formatSeconds(sec float64) string {
+	d := time.Duration(sec) * time.Second
+	h, m, s := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+// RunStats prints a summary of the knowledge base: documents, chunks,
+// vector dimensions, storage use and a breakdown by product.
+func RunStats(args []string, dm *document.DocumentManager) {
+	asJSON := false
+	for _, arg := range args {
+		if arg != "--json" {
+			fmt.Printf("未知参数: %s\n", arg)
+			fmt.Println("用法: askflow stats [--json]")
+			os.Exit(1)
+		}
+		asJSON = true
+	}
+	s, err := dm.Stats()
+	if err != nil {
+		fmt.Printf("统计知识库失败: %v\n", err)
+		os.Exit(1)
+	}
+	if asJSON {
+		out, _ := json.MarshalIndent(s, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+
+	fmt.Printf("文档:     %d（%s）\n", s.Documents, formatCounts(s.DocumentsByStatus))
+	if len(s.DocumentsByType) > 0 {
+		fmt.Printf("类型:     %s\n", formatCounts(s.DocumentsByType))
+	}
+	fmt.Printf("分块:     %d（含图片 %d）\n", s.Chunks, s.ImageChunks)
+	fmt.Printf("视频片段: %d\n", s.VideoSegments)
+	sizes := make([]int, 0, len(s.VectorDimensions))
+	for dim := range s.VectorDimensions {
+		sizes = append(sizes, dim)
+	}
+	sort.Ints(sizes)
+	dims := make([]string, len(sizes))
+	for i, dim := range sizes {
+		dims[i] = fmt.Sprintf("%d 维 × %d", dim, s.VectorDimensions[dim])
+	}
+	if len(dims) == 0 {
+		dims = append(dims, "-")
+	}
+	fmt.Printf("向量维度: %s\n", strings.Join(dims, "，"))
+	if len(s.VectorDimensions) > 1 {
+		fmt.Println("          警告: 存在多种向量维度，请运行 askflow fsck 检查")
+	}
+	fmt.Printf("数据库:   %s\n", formatBytes(s.DatabaseBytes))
+	if s.StorageError != "" {
+		fmt.Printf("文件存储: 读取失败: %s\n", s.StorageError)
+	} else {
+		fmt.Printf("图片:     %d 个，%s\n", s.Images, formatBytes(s.ImageBytes))
+		fmt.Printf("原始文件: %d 个，%s\n", s.Originals, formatBytes(s.OriginalBytes))
+	}
+
+	fmt.Printf("\n%-34s  %-20s  %8s  %8s  %8s\n", "产品 ID", "名称", "文档", "分块", "图片")
+	fmt.Println(strings.Repeat("-", 86))
+	for _, p := range s.Products {
+		id, name := p.ProductID, p.Name
+		switch {
+		case id == "":
+			id, name = "-", "公共库"
+		case name == "":
+			name = "（已删除的产品）"
+		}
+		fmt.Printf("%-34s  %-20s  %8d  %8d  %8d\n", id, name, p.Documents, p.Chunks, p.Images)
+	}
+}
+
+// RunFsck checks the knowledge base for orphaned rows, vector dimension
+// mismatches and missing image files, and fixes them with --repair. It exits
+// non-zero when problems remain, so it can run as a scheduled check.
+func RunFsck(args []string, dm *document.DocumentManager) {
+	const usage = "用法: askflow fsck [--repair] [--dimension <n>] [--json]"
+	repair, asJSON, dimension := false, false, 0
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--repair":
+			repair = true
+		case "--json":
+			asJSON = true
+		case "--dimension":
+			if i+1 >= len(args) {
+				fmt.Println("错误: --dimension 需要指定向量维度")
+				os.Exit(1)
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n <= 0 {
+				fmt.Printf("错误: 无效的向量维度 %s\n", args[i+1])
+				os.Exit(1)
+			}
+			dimension = n
+			i++
+		default:
+			fmt.Printf("未知参数: %s\n", args[i])
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+	}
+
+	report, err := dm.Check(dimension)
+	if err != nil {
+		fmt.Printf("检查失败: %v\n", err)
+		os.Exit(1)
+	}
+	var result *document.RepairResult
+	var repairErr error
+	if repair && report.Problems() > 0 {
+		result, repairErr = dm.Repair(report)
+	}
+
+	if asJSON {
+		out, _ := json.MarshalIndent(map[string]interface{}{"report": report, "repair": result}, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		printCheckReport(report)
+		if result != nil {
+			fmt.Printf("\n已修复: 清理 %d 个缺失文档的残留数据，%d 个文档标记为失败待重新导入，清除 %d 个失效图片链接，删除 %d 条无文件的图片记录\n",
+				result.PurgedDocuments, result.FailedDocuments, result.ClearedImages, result.ImageRecords)
+		}
+	}
+	if repairErr != nil {
+		fmt.Fprintf(os.Stderr, "修复失败: %v\n", repairErr)
+		os.Exit(1)
+	}
+	if report.Problems() > 0 && !repair {
+		os.Exit(1)
+	}
+}
+
+// printCheckReport prints the problems found by fsck.
+func printCheckReport(r *document.CheckReport) {
+	if r.Problems() == 0 {
+		fmt.Println("未发现问题")
+		return
+	}
+	if len(r.MissingDocuments) > 0 {
+		fmt.Printf("文档记录缺失（残留孤立数据）: %d 个\n", len(r.MissingDocuments))
+		for _, o := range r.MissingDocuments {
+			fmt.Printf("  %s  分块 %d，视频片段 %d，位置 %d，图片向量 %d，图片 %d\n", o.DocumentID, o.Chunks, o.VideoSegments, o.ChunkLocations, o.ImageVectors, o.Images)
+		}
+	}
+	if len(r.DimensionMismatches) > 0 {
+		fmt.Printf("向量维度不一致（应为 %d 维）: %d 个文档\n", r.ExpectedDimension, len(r.DimensionMismatches))
+		for _, m := range r.DimensionMismatches {
+			fmt.Printf("  %s  %s  %d 维 × %d\n", m.DocumentID, m.DocumentName, m.Dimension, m.Chunks)
+		}
+	}
+	if len(r.BrokenImages) > 0 {
+		fmt.Printf("图片链接失效: %d 个分块\n", len(r.BrokenImages))
+		for _, b := range r.BrokenImages {
+			fmt.Printf("  %s  %s\n", b.ChunkID, b.ImageURL)
+		}
+	}
+	if len(r.MissingImageFiles) > 0 {
+		fmt.Printf("图片文件缺失: %d 条记录\n", len(r.MissingImageFiles))
+		for _, id := range r.MissingImageFiles {
+			fmt.Printf("  %s\n", id)
+		}
+	}
+}
+
+// formatCounts formats counts by key as "key n, key n" in key order.
+func formatCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s %d", k, counts[k])
+	}
+	return strings.Join(parts, "，")
+}
+
+// formatBytes formats a size with a binary unit.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -11,11 +11,19 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"net"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 
+	"askflow/internal/cron"
+	"askflow/internal/i18n"
+	"askflow/internal/redis"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -27,17 +35,39 @@ const encryptedPrefix = "enc:"
 
 // Config holds all system configuration.
 type Config struct {
-	Server       ServerConfig    `json:"server"`
-	LLM          LLMConfig       `json:"llm"`
-	Embedding    EmbeddingConfig `json:"embedding"`
-	Vector       VectorConfig    `json:"vector"`
-	OAuth        OAuthConfig     `json:"oauth"`
-	Admin        AdminConfig     `json:"admin"`
-	SMTP         SMTPConfig      `json:"smtp"`
-	ProductIntro string          `json:"product_intro"`
-	ProductName  string          `json:"product_name"`
-	Video        VideoConfig     `json:"video"`
-	AuthServer   string          `json:"auth_server"` // license verification server host, e.g. "license.vantagedata.chat"
+	Server       ServerConfig       `json:"server"`
+	LLM          LLMConfig          `json:"llm"`
+	Embedding    EmbeddingConfig    `json:"embedding"`
+	ImageEmbed   ImageEmbedConfig   `json:"image_embedding"`
+	Vector       VectorConfig       `json:"vector"`
+	OAuth        OAuthConfig        `json:"oauth"`
+	Admin        AdminConfig        `json:"admin"`
+	SMTP         SMTPConfig         `json:"smtp"`
+	ProductIntro string             `json:"product_intro"`
+	ProductName  string             `json:"product_name"`
+	Video        VideoConfig        `json:"video"`
+	AuthServer   string             `json:"auth_server"` // license verification server host, e.g. "license.vantagedata.chat"
+	Channels     ChannelsConfig     `json:"channels"`
+	SSO          SSOConfig          `json:"sso"`
+	Backup       BackupConfig       `json:"backup"`
+	Tenants      TenantsConfig      `json:"tenants"`
+	Usage        UsageConfig        `json:"usage"`
+	Storage      StorageConfig      `json:"storage"`
+	GapReport    GapReportConfig    `json:"gap_report"`
+	PendingDraft PendingDraftConfig `json:"pending_draft"`
+	RateLimit    RateLimitConfig    `json:"rate_limit"`
+	Abuse        AbuseConfig        `json:"abuse"`
+	Refusal      RefusalConfig      `json:"refusal"`
+	Intent       IntentConfig       `json:"intent"`
+	FAQ          FAQConfig          `json:"faq"`
+	Scan         ScanConfig         `json:"scan"`
+	Connectors   ConnectorsConfig   `json:"connectors"`
+	Sessions     SessionsConfig     `json:"sessions"`
+	Maintenance  MaintenanceConfig  `json:"maintenance"`
+	Replica      ReplicaConfig      `json:"replica"`
+	Cache        CacheConfig        `json:"cache"`
+	Admission    AdmissionConfig    `json:"admission"`
+	BodyLimit    BodyLimitConfig    `json:"body_limit"`
 }
 
 
@@ -47,8 +77,101 @@ type ServerConfig struct {
 	Port    int    `json:"port"`
 	SSLCert string `json:"ssl_cert"` // path to SSL certificate file (PEM)
 	SSLKey  string `json:"ssl_key"`  // path to SSL private key file (PEM)
+	// ListenAddr is a combined "host:port" listen address; when set it
+	// takes precedence over Bind and Port.
+	ListenAddr string `json:"listen_addr"`
+	// BasePath is the URL path prefix the app is served under (e.g.
+	// "/askflow" behind a sub-path reverse proxy); empty means "/".
+	BasePath string `json:"base_path"`
+	// SessionMode selects how browsers hold the session: "bearer" (token
+	// returned in JSON and sent in the Authorization header) or "cookie"
+	// (httpOnly cookie plus double-submit CSRF token). Bearer tokens are
+	// accepted in both modes for API clients and the widget.
+	SessionMode string `json:"session_mode"`
+	// ShutdownTimeoutSec bounds how long a graceful shutdown waits for
+	// in-flight requests and document processing before exiting.
+	ShutdownTimeoutSec int `json:"shutdown_timeout_sec"`
+	// AutoCert obtains and renews the certificate from an ACME CA when no
+	// ssl_cert/ssl_key is configured.
+	AutoCert AutoCertConfig `json:"autocert"`
+	// GRPCListenAddr, when set, starts the gRPC API (package grpcapi) on
+	// this "host:port" address. It uses TLS when HTTPS is served natively
+	// and cleartext HTTP/2 otherwise.
+	GRPCListenAddr string `json:"grpc_listen_addr"`
+	// HTTPRedirectPort, when HTTPS is served natively, starts a plain HTTP
+	// listener on this port that redirects to HTTPS and answers ACME
+	// HTTP-01 challenges (0 = disabled).
+	HTTPRedirectPort int `json:"http_redirect_port"`
+	// HSTSMaxAge is the Strict-Transport-Security max-age in seconds sent
+	// on HTTPS responses (0 = default of two years, negative = disabled).
+	HSTSMaxAge int `json:"hsts_max_age"`
+	// ReadyCheckUpstream makes /readyz also require the LLM and embedding
+	// APIs to be reachable (probed in the background, result cached).
+	ReadyCheckUpstream bool `json:"ready_check_upstream"`
+	// ImageURLTTLMinutes is how long the signed image URLs handed out in
+	// answers and document reviews stay valid.
+	ImageURLTTLMinutes int `json:"image_url_ttl_minutes"`
+	// Language is the language of API messages for requests whose user has
+	// no preference and whose Accept-Language names no supported language.
+	Language string `json:"language"`
+}
+
+// ParseListenAddr splits a "host:port" listen address. The host may be
+// empty (all interfaces) or a bracketed IPv6 address.
+func ParseListenAddr(addr string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid listen address %q: %w", addr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port in listen address %q", addr)
+	}
+	return host, port, nil
+}
+
+// NormalizeBasePath validates a URL path prefix and returns it in canonical
+// form: a leading slash and no trailing slash, or "" for the root.
+func NormalizeBasePath(p string) (string, error) {
+	p = strings.TrimRight(strings.TrimSpace(p), "/")
+	if p == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return "", errors.New("base_path must start with '/'")
+	}
+	for _, seg := range strings.Split(p[1:], "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return "", errors.New("base_path must not contain empty, '.' or '..' segments")
+		}
+		for _, c := range seg {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-._~", c)) {
+				return "", fmt.Errorf("base_path contains invalid character %q", c)
+			}
+		}
+	}
+	return p, nil
+}
+
+// AutoCertConfig configures automatic certificate management via ACME
+// (Let's Encrypt by default) for deployments without a reverse proxy.
+type AutoCertConfig struct {
+	Enabled      bool     `json:"enabled"`
+	Domains      []string `json:"domains"`       // host names covered by the certificate
+	Email        string   `json:"email"`         // ACME account contact for expiry notices
+	CacheDir     string   `json:"cache_dir"`     // certificate cache; defaults to <datadir>/certs
+	DirectoryURL string   `json:"directory_url"` // ACME directory; empty = Let's Encrypt production
 }
 
+// DefaultHSTSMaxAge is the HSTS max-age used when ServerConfig.HSTSMaxAge is 0.
+const DefaultHSTSMaxAge = 63072000
+
+// Session modes for ServerConfig.SessionMode.
+const (
+	SessionModeBearer = "bearer"
+	SessionModeCookie = "cookie"
+)
+
 
 // LLMConfig holds LLM service configuration.
 type LLMConfig struct {
@@ -57,6 +180,21 @@ type LLMConfig struct {
 	ModelName   string  `json:"model_name"`
 	Temperature float64 `json:"temperature"`
 	MaxTokens   int     `json:"max_tokens"`
+	// ContextWindow is the model's context length in tokens. Reference
+	// material is trimmed, lowest-scored chunks first, so that the prompt
+	// and an answer of MaxTokens fit in it.
+	ContextWindow int `json:"context_window"`
+	// Context compression: when an answer draws on at least
+	// CompressMinChunks chunks, each is first summarized with respect to the
+	// question by CompressModel (the answer model when empty) and the answer
+	// is generated from the summaries, so that top_k can be raised without
+	// overflowing the context window.
+	CompressEnabled   bool   `json:"compress_enabled"`
+	CompressModel     string `json:"compress_model"`
+	CompressMinChunks int    `json:"compress_min_chunks"`
+	// InjectionCheck has the LLM screen imported document text for prompt
+	// injection; suspicious documents are queued for review.
+	InjectionCheck bool `json:"injection_check"`
 }
 
 // EmbeddingConfig holds embedding service configuration.
@@ -65,6 +203,36 @@ type EmbeddingConfig struct {
 	APIKey        string `json:"api_key"`
 	ModelName     string `json:"model_name"`
 	UseMultimodal bool   `json:"use_multimodal"`
+	// Batch embedding limits: EmbedBatch splits its input into requests of
+	// at most BatchMaxItems texts and BatchMaxTokens estimated tokens, and
+	// sends up to MaxConcurrency of them at once. Up to QueueLimit more wait
+	// their turn; beyond that new uploads are turned away until the queue
+	// drains.
+	BatchMaxItems  int `json:"batch_max_items"`
+	BatchMaxTokens int `json:"batch_max_tokens"`
+	MaxConcurrency int `json:"max_concurrency"`
+	QueueLimit     int `json:"queue_limit"`
+}
+
+// ImageEmbedConfig configures a dedicated image embedding model, such as a
+// CLIP-style model, for image similarity search: document images and images
+// attached to questions are embedded with it, so a screenshot of an error
+// dialog can match the same dialog in the documents. It is called like the
+// embedding API, with images as data URLs, and its vectors are only
+// compared with each other.
+type ImageEmbedConfig struct {
+	Enabled   bool    `json:"enabled"`
+	Endpoint  string  `json:"endpoint"`
+	APIKey    string  `json:"api_key"`
+	ModelName string  `json:"model_name"`
+	Threshold float64 `json:"threshold"` // minimum cosine similarity of a matching image, default 0.75
+	TopK      int     `json:"top_k"`     // matching images added to the search results, default 3
+}
+
+// Configured reports whether image similarity search is enabled with an
+// endpoint and model to embed images with.
+func (c ImageEmbedConfig) Configured() bool {
+	return c.Enabled && c.Endpoint != "" && c.ModelName != ""
 }
 
 // VectorConfig holds vector store configuration.
@@ -77,6 +245,46 @@ type VectorConfig struct {
 	ContentPriority string  `json:"content_priority"` // "image_text" (default) or "text_only"
 	DebugMode       bool    `json:"debug_mode"`       // when true, query responses include search diagnostics
 	TextMatchEnabled bool   `json:"text_match_enabled"` // enable 3-level text similarity processing to save API costs
+	// Semantic cache: answer a question with the stored answer of an earlier
+	// one whose embedding is at least SemanticCacheThreshold similar. Entries
+	// are dropped when documents change, after the TTL, or oldest-first
+	// beyond SemanticCacheMaxEntries.
+	SemanticCacheEnabled    bool    `json:"semantic_cache_enabled"`
+	SemanticCacheThreshold  float64 `json:"semantic_cache_threshold"`
+	SemanticCacheTTLMinutes int     `json:"semantic_cache_ttl_minutes"`
+	SemanticCacheMaxEntries int     `json:"semantic_cache_max_entries"`
+	// Answer images: images near the matched chunks are ranked by the
+	// similarity of their embedding or caption to the question; those below
+	// ImageRelevanceThreshold are dropped, identical images are shown once,
+	// and at most MaxAnswerImages are attached to an answer.
+	MaxAnswerImages         int     `json:"max_answer_images"`
+	ImageRelevanceThreshold float64 `json:"image_relevance_threshold"`
+	// PartitionCacheMB, when set, makes the vector cache load each product's
+	// chunks on its first search and evict the least recently searched
+	// products beyond this size, instead of loading every chunk at startup.
+	// It takes effect after a restart.
+	PartitionCacheMB int `json:"partition_cache_mb"`
+	// MMR: pick retrieved chunks by Maximal Marginal Relevance instead of
+	// similarity alone. MMRLambda in (0, 1] weighs relevance against
+	// diversity; 1 is the same as plain similarity ranking.
+	MMREnabled bool    `json:"mmr_enabled"`
+	MMRLambda  float64 `json:"mmr_lambda"`
+	// DedupThreshold: retrieved chunks more similar to a better-scored one
+	// than this, by embedding or text, are left out, so that re-uploaded or
+	// overlapping documents do not repeat a passage. 1 turns it off.
+	DedupThreshold float64 `json:"dedup_threshold"`
+	// Context expansion: each matched text chunk is given to the LLM with up
+	// to ContextWindow neighboring chunks of its document on either side, as
+	// long as the whole context stays within ContextTokenBudget (estimated)
+	// tokens. 0 disables expansion.
+	ContextWindow      int `json:"context_window"`
+	ContextTokenBudget int `json:"context_token_budget"`
+	// Ranking: search scores are multiplied by each document's priority
+	// (set per document by admins) and, when RecencyHalfLifeDays is set, by
+	// a factor halving every RecencyHalfLifeDays since upload but not below
+	// RecencyMinFactor.
+	RecencyHalfLifeDays int     `json:"recency_half_life_days"`
+	RecencyMinFactor    float64 `json:"recency_min_factor"`
 }
 
 // SMTPConfig holds SMTP email server configuration.
@@ -106,6 +314,353 @@ type OAuthConfig struct {
 	Providers map[string]OAuthProviderConfig `json:"providers"`
 }
 
+// SSOConfig holds enterprise single sign-on providers, keyed by provider name.
+type SSOConfig struct {
+	OIDC map[string]OIDCProviderConfig `json:"oidc"`
+	SAML map[string]SAMLProviderConfig `json:"saml"`
+}
+
+// OIDCProviderConfig holds configuration for an OpenID Connect provider
+// (e.g. Azure AD, Okta). Endpoints are discovered from the issuer's
+// /.well-known/openid-configuration document.
+type OIDCProviderConfig struct {
+	DisplayName  string            `json:"display_name"`
+	Issuer       string            `json:"issuer"`
+	ClientID     string            `json:"client_id"`
+	ClientSecret string            `json:"client_secret"`
+	RedirectURL  string            `json:"redirect_url"`
+	Scopes       []string          `json:"scopes"`       // default: openid, email, profile
+	RoleClaim    string            `json:"role_claim"`   // ID token claim holding groups/roles, e.g. "groups"
+	RoleMapping  map[string]string `json:"role_mapping"` // claim value -> admin role ID
+}
+
+// SAMLProviderConfig holds configuration for a SAML 2.0 identity provider
+// used for SP-initiated login (HTTP-Redirect request, HTTP-POST response).
+type SAMLProviderConfig struct {
+	DisplayName    string            `json:"display_name"`
+	IdPSSOURL      string            `json:"idp_sso_url"`
+	IdPEntityID    string            `json:"idp_entity_id"`
+	IdPCertificate string            `json:"idp_certificate"` // IdP signing certificate, PEM or base64 DER
+	SPEntityID     string            `json:"sp_entity_id"`
+	ACSURL         string            `json:"acs_url"`
+	EmailAttribute string            `json:"email_attribute"` // empty means use NameID
+	NameAttribute  string            `json:"name_attribute"`
+	RoleAttribute  string            `json:"role_attribute"`
+	RoleMapping    map[string]string `json:"role_mapping"` // attribute value -> admin role ID
+}
+
+// BackupConfig holds scheduled backup settings. Backups run in-process on
+// the cron schedule; on-demand runs via /api/admin/backup use the same
+// output directory and retention.
+type BackupConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Schedule string `json:"schedule"` // 5-field cron expression in server local time, e.g. "0 3 * * *"
+	// Mode is "full" or "incremental". Incremental runs export changes since
+	// the latest full backup and fall back to a full one when there is none
+	// or it is older than FullIntervalDays.
+	Mode             string `json:"mode"`
+	FullIntervalDays int    `json:"full_interval_days"`
+	OutputDir        string `json:"output_dir"`   // archive directory; relative paths are under the data directory
+	KeepFull         int    `json:"keep_full"`    // backup sets (a full backup plus its incrementals) to keep
+	MaxAgeDays       int    `json:"max_age_days"` // also prune sets older than this many days; 0 disables
+	// S3 ships each archive to S3-compatible object storage so backups
+	// survive loss of the host.
+	S3 BackupS3Config `json:"s3"`
+}
+
+// BackupS3Config holds the S3-compatible (AWS S3, MinIO, ...) backup target.
+// SecretKey is stored encrypted in config.json.
+type BackupS3Config struct {
+	Enabled   bool   `json:"enabled"`
+	Endpoint  string `json:"endpoint"` // e.g. "https://s3.us-east-1.amazonaws.com" or "http://minio:9000"; empty means AWS for Region
+	Region    string `json:"region"`   // signing region (default "us-east-1")
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix"` // key prefix for archives, e.g. "askflow/"
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	PathStyle bool   `json:"path_style"` // address the bucket as <endpoint>/<bucket> (MinIO) instead of <bucket>.<host>
+}
+
+// StorageConfig selects where original documents, extracted images and
+// uploaded media are kept. Changes take effect on restart.
+type StorageConfig struct {
+	Backend string          `json:"backend"` // "local" (files under the data directory) or "s3"
+	S3      StorageS3Config `json:"s3"`
+	// Encrypt encrypts chunk text, original files and images at rest with
+	// a key derived from the config encryption key; read at startup.
+	Encrypt bool `json:"encrypt"`
+}
+
+// StorageS3Config holds the S3-compatible bucket used when Backend is "s3".
+// SecretKey is stored encrypted in config.json.
+type StorageS3Config struct {
+	Endpoint  string `json:"endpoint"` // e.g. "https://s3.us-east-1.amazonaws.com" or "http://minio:9000"; empty means AWS for Region
+	Region    string `json:"region"`   // signing region (default "us-east-1")
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix"` // key prefix for stored files, e.g. "askflow/"
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	PathStyle bool   `json:"path_style"` // address the bucket as <endpoint>/<bucket> (MinIO) instead of <bucket>.<host>
+}
+
+// TenantsConfig controls multi-tenant workspaces. When enabled, requests are
+// routed to a tenant by a /t/<slug>/ path prefix or, with BaseDomain set, by
+// a <slug>.<base_domain> host name; other requests use the default workspace.
+type TenantsConfig struct {
+	Enabled    bool   `json:"enabled"`
+	BaseDomain string `json:"base_domain"` // e.g. "askflow.example.com"; empty disables subdomain routing
+}
+
+// UsageConfig holds the default monthly usage quotas. Tokens are embedding
+// plus LLM prompt and completion tokens. Zero means unlimited; individual
+// users and products can be given other limits via /api/admin/usage/quotas.
+type UsageConfig struct {
+	UserMonthlyQueries    int64 `json:"user_monthly_queries"`
+	UserMonthlyTokens     int64 `json:"user_monthly_tokens"`
+	ProductMonthlyQueries int64 `json:"product_monthly_queries"`
+	ProductMonthlyTokens  int64 `json:"product_monthly_tokens"`
+}
+
+// MaintenanceConfig schedules the database maintenance: incremental
+// VACUUM, index rebuild, planner statistics and vector cache compaction.
+// Runs can also be started on demand via /api/admin/maintenance.
+type MaintenanceConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Schedule string `json:"schedule"` // 5-field cron expression in server local time, default Sunday 04:00
+}
+
+// ReplicaConfig holds the settings shared by a primary instance and its
+// read-only replicas (started with --replica-of). Replicas authenticate the
+// pending questions and usage they send to the primary with Token, and check
+// the database for changes every RefreshSec.
+type ReplicaConfig struct {
+	Token      string `json:"token"`       // shared secret; empty refuses replicas; stored encrypted
+	RefreshSec int    `json:"refresh_sec"` // default 10
+}
+
+// CacheConfig selects where the caches that instances behind a load
+// balancer must share are kept: sessions, query embeddings, rate limit
+// counters and translated product names. Backend "memory" keeps them per
+// process; "redis" keeps them in the Redis server at RedisURL
+// (redis://[user:password@]host:port/db, rediss:// for TLS) under
+// KeyPrefix, falling back to memory while the server is unreachable.
+// Changes take effect after a restart.
+type CacheConfig struct {
+	Backend   string `json:"backend"`    // "memory" (default) or "redis"
+	RedisURL  string `json:"redis_url"`  // stored encrypted, may hold a password
+	KeyPrefix string `json:"key_prefix"` // default "askflow:"
+}
+
+// maxBodyLimitKB is the largest configurable request body limit (1 GB).
+const maxBodyLimitKB = 1 << 20
+
+// AdmissionConfig limits the questions answered at once by this instance.
+// Up to MaxInFlight queries run through the pipeline concurrently; up to
+// QueueLimit more wait in arrival order for at most QueueTimeoutSec, and
+// beyond that questions are answered with 503 and Retry-After.
+type AdmissionConfig struct {
+	MaxInFlight     int `json:"max_inflight"`      // default 16
+	QueueLimit      int `json:"queue_limit"`       // default 64
+	QueueTimeoutSec int `json:"queue_timeout_sec"` // default 30
+}
+
+// BodyLimitConfig caps the size of request bodies, in KB. Questions may
+// carry a base64 image in image_data and get QueryKB; file uploads are
+// capped by video.max_upload_size_mb instead. Routes overrides the limit of
+// single paths, or of every path under a prefix ending in "/".
+type BodyLimitConfig struct {
+	DefaultKB int            `json:"default_kb"` // other JSON and form requests, default 1024
+	QueryKB   int            `json:"query_kb"`   // questions and pending questions, default 8192
+	ConfigKB  int            `json:"config_kb"`  // /api/config, default 256
+	Routes    map[string]int `json:"routes"`
+}
+
+// GapReportConfig holds the knowledge gap report settings. The report
+// clusters the questions that went pending in the last LookbackDays by
+// embedding similarity and labels each cluster with an LLM-generated topic.
+// Scheduled reports are emailed to Recipients; reports can also be
+// generated on demand via /api/admin/gaps.
+type GapReportConfig struct {
+	Enabled             bool     `json:"enabled"`
+	Schedule            string   `json:"schedule"`             // 5-field cron expression in server local time, default Monday 08:00
+	LookbackDays        int      `json:"lookback_days"`        // questions created in this many days are included
+	SimilarityThreshold float64  `json:"similarity_threshold"` // minimum cosine similarity to join a cluster
+	MaxTopics           int      `json:"max_topics"`           // largest clusters labelled in the report
+	Recipients          []string `json:"recipients"`           // email addresses the scheduled report is sent to
+}
+
+// FAQConfig controls the generated FAQ. When enabled, answered questions
+// are logged, and on Schedule the pending questions admins answered and the
+// questions asked at least MinCount times in the last LookbackDays are merged
+// by embedding similarity into an FAQ per product, which is ingested into the
+// knowledge base and served by GET /api/faq.
+type FAQConfig struct {
+	Enabled             bool    `json:"enabled"`
+	Schedule            string  `json:"schedule"`             // 5-field cron expression in server local time, default daily 03:00
+	LookbackDays        int     `json:"lookback_days"`        // questions asked or answered in this many days are mined
+	MinCount            int     `json:"min_count"`            // times a question must have been asked to be included
+	SimilarityThreshold float64 `json:"similarity_threshold"` // minimum cosine similarity for questions to be merged
+	MaxEntries          int     `json:"max_entries"`          // most asked entries kept per product
+}
+
+// PendingDraftConfig controls suggested answers for pending questions. When
+// enabled, every new pending question is searched against the knowledge base
+// (including earlier admin answers) with the relaxed Threshold and the LLM
+// drafts an answer the admin can approve or edit.
+type PendingDraftConfig struct {
+	Enabled   bool    `json:"enabled"`
+	TopK      int     `json:"top_k"`     // chunks passed to the LLM, default 8
+	Threshold float64 `json:"threshold"` // minimum similarity, default 0.3 (below vector.threshold)
+}
+
+// RefusalConfig holds the settings of the "do not answer" list: questions
+// at least Threshold similar to an example question of a rule are refused
+// with the rule's message, or Message when it has none.
+type RefusalConfig struct {
+	Message   string  `json:"message"`
+	Threshold float64 `json:"threshold"`
+}
+
+// ScanConfig controls malware scanning of uploaded files before they are
+// parsed. Backend "clamav" streams the file to a clamd daemon at
+// ClamAVAddress (a Unix socket path or host:port); backend "http" posts it
+// to APIURL, which answers {"infected": bool, "signature": "..."}. Infected
+// files are rejected. When the scanner cannot be reached uploads are
+// rejected too, unless FailOpen is set.
+type ScanConfig struct {
+	Enabled       bool   `json:"enabled"`
+	Backend       string `json:"backend"`        // "clamav" (default) or "http"
+	ClamAVAddress string `json:"clamav_address"` // default /var/run/clamav/clamd.ctl
+	APIURL        string `json:"api_url"`
+	APIKey        string `json:"api_key"`     // sent as a Bearer token; stored encrypted
+	TimeoutSec    int    `json:"timeout_sec"` // per file, default 60
+	FailOpen      bool   `json:"fail_open"`
+}
+
+// ConnectorsConfig holds the OAuth apps the Google Drive and SharePoint
+// connectors are authorized with. Each connector is granted access by an
+// admin signing in with their account; the redirect URI to register with
+// the app is <public URL>/api/connectors/oauth/callback.
+type ConnectorsConfig struct {
+	Google    ConnectorOAuthApp `json:"google"`
+	Microsoft ConnectorOAuthApp `json:"microsoft"`
+}
+
+// ConnectorOAuthApp is the client registration of an OAuth app.
+type ConnectorOAuthApp struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`    // stored encrypted
+	Tenant       string `json:"tenant,omitempty"` // Microsoft directory (tenant ID or domain), default "organizations"
+}
+
+// Login alert modes of SessionsConfig.LoginAlerts.
+const (
+	LoginAlertsIP      = "ip"      // alert on sign-ins from an address not used before
+	LoginAlertsCountry = "country" // alert on sign-ins from a country not used before
+)
+
+// SessionsConfig limits how many devices an end user can be signed in on
+// and alerts users to sign-ins from unfamiliar places. Login alerts compare
+// a password sign-in with the successful ones of the last 30 days kept in
+// login_attempts; the country mode needs abuse.geoip_database and compares
+// addresses it cannot place by IP.
+type SessionsConfig struct {
+	MaxPerUser  int    `json:"max_per_user"` // simultaneous logins per end user; the oldest are signed out beyond it (0 = unlimited)
+	LoginAlerts string `json:"login_alerts"` // "", LoginAlertsIP or LoginAlertsCountry
+}
+
+// RateLimitConfig holds the per-minute request limits of the query, upload,
+// auth, API and widget endpoint groups. Each signed-in user has their own
+// bucket in every group, whatever IP they connect from; anonymous requests
+// are counted per client IP. The tiers override the group limits for
+// anonymous callers, signed-in users and admins.
+type RateLimitConfig struct {
+	QueryPerMinute  int `json:"query_per_minute"`  // /api/query and answer feedback, default 30
+	UploadPerMinute int `json:"upload_per_minute"` // document, URL, image and video uploads, default 20
+	AuthPerMinute   int `json:"auth_per_minute"`   // login, registration, password and SSO endpoints, default 10
+	APIPerMinute    int `json:"api_per_minute"`    // other rate-limited endpoints such as translation, default 60
+	WidgetPerMinute int `json:"widget_per_minute"` // embeddable chat widget, default 20
+
+	Anonymous RateLimitTier `json:"anonymous"` // requests without a session
+	User      RateLimitTier `json:"user"`      // signed-in users, including widget visitors
+	Admin     RateLimitTier `json:"admin"`     // admin sessions
+}
+
+// RateLimitTier overrides the per-minute limits of the endpoint groups for
+// one kind of caller. 0 keeps the group limit.
+type RateLimitTier struct {
+	Query  int `json:"query"`
+	Upload int `json:"upload"`
+	Auth   int `json:"auth"`
+	API    int `json:"api"`
+	Widget int `json:"widget"`
+}
+
+// Rate limit tiers, as used in rate_limit.<tier>.<group> config keys.
+const (
+	RateLimitAnonymous = "anonymous"
+	RateLimitUser      = "user"
+	RateLimitAdmin     = "admin"
+)
+
+// PerMinute returns the per-minute limit of an endpoint group ("query",
+// "upload", "auth", "api" or "widget") for a tier, falling back to the
+// group limit when the tier does not override it.
+func (c RateLimitConfig) PerMinute(group, tier string) int {
+	var t RateLimitTier
+	switch tier {
+	case RateLimitAnonymous:
+		t = c.Anonymous
+	case RateLimitUser:
+		t = c.User
+	case RateLimitAdmin:
+		t = c.Admin
+	}
+	limit, override := 0, 0
+	switch group {
+	case "query":
+		limit, override = c.QueryPerMinute, t.Query
+	case "upload":
+		limit, override = c.UploadPerMinute, t.Upload
+	case "auth":
+		limit, override = c.AuthPerMinute, t.Auth
+	case "api":
+		limit, override = c.APIPerMinute, t.API
+	case "widget":
+		limit, override = c.WidgetPerMinute, t.Widget
+	}
+	if override > 0 {
+		return override
+	}
+	return limit
+}
+
+// tier returns the tier of a rate_limit.<tier>.<group> config key.
+func (c *RateLimitConfig) tier(name string) *RateLimitTier {
+	switch name {
+	case RateLimitAnonymous:
+		return &c.Anonymous
+	case RateLimitUser:
+		return &c.User
+	case RateLimitAdmin:
+		return &c.Admin
+	}
+	return nil
+}
+
+// AbuseConfig holds the network-level blocking rules applied to every API
+// request besides the network bans managed through the admin API. Country
+// and ASN rules need the corresponding IP database: a CSV file of
+// start_ip,end_ip,value rows such as the free DB-IP "IP to Country Lite"
+// and "IP to ASN Lite" downloads.
+type AbuseConfig struct {
+	GeoIPDatabase  string   `json:"geoip_database"`  // CSV of start_ip,end_ip,country_code
+	ASNDatabase    string   `json:"asn_database"`    // CSV of start_ip,end_ip,asn[,organization]
+	AllowCountries []string `json:"allow_countries"` // ISO country codes; when set, other countries are blocked
+	DenyCountries  []string `json:"deny_countries"`  // ISO country codes to block
+	DenyASNs       []string `json:"deny_asns"`       // AS numbers to block, e.g. "AS64500"
+}
+
 // VideoConfig holds video processing configuration.
 type VideoConfig struct {
 	FFmpegPath            string `json:"ffmpeg_path"`              // ffmpeg executable path, empty means video not supported
@@ -116,15 +671,114 @@ type VideoConfig struct {
 	KeyframeOCREnabled    bool   `json:"keyframe_ocr_enabled"`     // enable LLM-based OCR on keyframes for text search
 	KeyframeOCRMaxFrames  int    `json:"keyframe_ocr_max_frames"`  // max keyframes to OCR (0=unlimited), default 20
 	ProcessingTimeoutMin  int    `json:"processing_timeout_min"`   // async processing timeout in minutes, default 120
+	KeyframeMode          string `json:"keyframe_mode"`            // "scene": frames where the picture changes (default); "interval": one every keyframe_interval seconds
+	SceneThreshold        float64 `json:"scene_threshold"`         // ffmpeg scene change score (0-1) a frame must exceed in scene mode, default 0.3
+	MaxKeyframes          int    `json:"max_keyframes"`            // keyframes kept per video, evenly spread when more are found, default 200
+	KeyframeDedupDistance int    `json:"keyframe_dedup_distance"`  // perceptual hash bits in which a frame may differ from the previous one and still be dropped as a duplicate; -1 disables, default 6
+	STT                   STTConfig `json:"stt"`                    // speech-to-text backend used for the audio track
+	Diarization           string   `json:"diarization"`             // speaker labelling of transcripts: "off" (default), "stt" (by the STT backend) or "llm" (by the chat model)
+	SpeakerLabels         []string `json:"speaker_labels"`          // speaker names in order of appearance, e.g. ["Presenter", "Customer"]; in llm mode the roles the model picks from
+	Workers               VideoWorkersConfig `json:"workers"`       // remote workers that run ffmpeg and speech-to-text instead of this instance
+}
+
+// VideoWorkersConfig hands the ffmpeg and speech-to-text steps of video
+// processing to remote worker processes (askflow video-worker), which claim
+// jobs over /api/video-worker/ with the shared Token and parse the video
+// with their own video settings. Embedding, OCR and storage stay on this
+// instance.
+type VideoWorkersConfig struct {
+	Enabled bool   `json:"enabled"` // queue videos for workers instead of parsing them here
+	Token   string `json:"token"`   // shared secret; empty refuses workers; stored encrypted
+}
+
+// STTConfig selects the speech-to-text backend for video transcription.
+type STTConfig struct {
+	Provider           string `json:"provider"`              // "rapidspeech" (default, uses rapidspeech_path/model), "whisper_cpp", "openai" or "aliyun_nls"
+	Language           string `json:"language"`              // language hint such as "zh" or "en", empty means auto-detect
+	WhisperCppPath     string `json:"whisper_cpp_path"`      // whisper.cpp CLI executable (whisper-cli)
+	WhisperCppModel    string `json:"whisper_cpp_model"`     // whisper.cpp ggml model file
+	Endpoint           string `json:"endpoint"`              // OpenAI-compatible API base URL, default https://api.openai.com/v1
+	APIKey             string `json:"api_key"`               // OpenAI API key
+	Model              string `json:"model"`                 // OpenAI transcription model, default whisper-1
+	NLSAccessKeyID     string `json:"nls_access_key_id"`     // Alibaba Cloud AccessKey ID used to obtain NLS tokens
+	NLSAccessKeySecret string `json:"nls_access_key_secret"` // Alibaba Cloud AccessKey secret
+	NLSAppKey          string `json:"nls_app_key"`           // NLS project appkey; the project decides the recognition language
+	NLSRegion          string `json:"nls_region"`            // NLS region, default cn-shanghai
+}
+
+// ChannelsConfig holds configuration for external messaging channel adapters.
+type ChannelsConfig struct {
+	Telegram TelegramConfig `json:"telegram"`
+	WeChat   WeChatConfig   `json:"wechat"`
+}
+
+// TelegramConfig holds Telegram Bot API channel configuration.
+type TelegramConfig struct {
+	Enabled       bool   `json:"enabled"`
+	BotToken      string `json:"bot_token"`
+	WebhookSecret string `json:"webhook_secret"` // compared with X-Telegram-Bot-Api-Secret-Token header; stored encrypted
+	ProductID     string `json:"product_id"`     // product to answer questions for, empty means default product
+}
+
+// WeChatConfig holds WeChat official account (公众号) channel configuration.
+type WeChatConfig struct {
+	Enabled   bool   `json:"enabled"`
+	AppID     string `json:"app_id"`
+	AppSecret string `json:"app_secret"`
+	Token     string `json:"token"`      // server token used for signature verification; stored encrypted
+	ProductID string `json:"product_id"` // product to answer questions for, empty means default product
 }
 
 // AdminConfig holds admin authentication configuration.
 type AdminConfig struct {
-	Username          string `json:"username"`
-	PasswordHash      string `json:"password_hash"`
-	LoginRoute        string `json:"login_route"`
-	AnonymousMode     bool   `json:"anonymous_mode"`
-	AnonymousFrontend bool   `json:"anonymous_frontend"`
+	Username          string        `json:"username"`
+	PasswordHash      string        `json:"password_hash"`
+	LoginRoute        string        `json:"login_route"`
+	AnonymousMode     bool          `json:"anonymous_mode"`
+	AnonymousFrontend bool          `json:"anonymous_frontend"`
+	Captcha           CaptchaConfig `json:"captcha"`
+}
+
+// Third-party CAPTCHA providers.
+const (
+	CaptchaHCaptcha  = "hcaptcha"
+	CaptchaTurnstile = "turnstile"
+)
+
+// Endpoints that can require a third-party CAPTCHA challenge.
+const (
+	CaptchaRegister       = "register"
+	CaptchaLogin          = "login"
+	CaptchaAnonymousQuery = "anonymous_query"
+)
+
+// CaptchaConfig holds the hCaptcha / Cloudflare Turnstile settings. When a
+// provider is set, the enabled endpoints require a challenge token verified
+// with the provider instead of the built-in math captcha.
+type CaptchaConfig struct {
+	Provider       string `json:"provider"` // "", "hcaptcha" or "turnstile"
+	SiteKey        string `json:"site_key"`
+	SecretKey      string `json:"secret_key"`
+	Register       bool   `json:"register"`        // user registration
+	Login          bool   `json:"login"`           // user and admin login
+	AnonymousQuery bool   `json:"anonymous_query"` // questions from the anonymous frontend session
+}
+
+// Requires reports whether endpoint (CaptchaRegister, CaptchaLogin or
+// CaptchaAnonymousQuery) requires a third-party challenge token.
+func (c CaptchaConfig) Requires(endpoint string) bool {
+	if c.Provider == "" || c.SecretKey == "" {
+		return false
+	}
+	switch endpoint {
+	case CaptchaRegister:
+		return c.Register
+	case CaptchaLogin:
+		return c.Login
+	case CaptchaAnonymousQuery:
+		return c.AnonymousQuery
+	}
+	return false
 }
 
 // ConfigManager manages loading, saving, and updating configuration.
@@ -133,6 +787,7 @@ type ConfigManager struct {
 	config        *Config
 	mu            sync.RWMutex
 	encryptionKey []byte // 32-byte AES-256 key
+	previousKey   []byte // key replaced by an unfinished rotation, or nil (see RotateKey)
 }
 
 // NewConfigManager creates a new ConfigManager for the given config file path.
@@ -143,9 +798,14 @@ func NewConfigManager(configPath string) (*ConfigManager, error) {
 	if err != nil {
 		return nil, fmt.Errorf("encryption key error: %w", err)
 	}
+	previous, err := readPreviousKey()
+	if err != nil {
+		return nil, fmt.Errorf("previous encryption key error: %w", err)
+	}
 	return &ConfigManager{
 		configPath:    configPath,
 		encryptionKey: key,
+		previousKey:   previous,
 	}, nil
 }
 
@@ -166,21 +826,36 @@ func NewConfigManagerWithKey(configPath string, key []byte) (*ConfigManager, err
 func DefaultConfig() *Config {
 	return &Config{
 		Server: ServerConfig{
-			Bind: "0.0.0.0",
-			Port: 8080,
+			Bind:               "0.0.0.0",
+			Port:               8080,
+			SessionMode:        SessionModeBearer,
+			ShutdownTimeoutSec: 60,
+			ImageURLTTLMinutes: 60,
+			Language:           i18n.Default,
 		},
 		LLM: LLMConfig{
-			Endpoint:    "",
-			APIKey:      "",
-			ModelName:   "",
-			Temperature: 0.3,
-			MaxTokens:   2048,
+			Endpoint:          "",
+			APIKey:            "",
+			ModelName:         "",
+			Temperature:       0.3,
+			MaxTokens:         2048,
+			ContextWindow:     32768,
+			CompressMinChunks: 6,
 		},
 		Embedding: EmbeddingConfig{
 			Endpoint:      "",
 			APIKey:        "",
 			ModelName:     "",
 			UseMultimodal: true,
+
+			BatchMaxItems:  64,
+			BatchMaxTokens: 8000,
+			MaxConcurrency: 2,
+			QueueLimit:     32,
+		},
+		ImageEmbed: ImageEmbedConfig{
+			Threshold: 0.75,
+			TopK:      3,
 		},
 		Vector: VectorConfig{
 			DBPath:           "askflow.db",
@@ -190,6 +865,16 @@ func DefaultConfig() *Config {
 			Threshold:        0.5,
 			ContentPriority:  "image_text",
 			TextMatchEnabled: true,
+
+			SemanticCacheThreshold:  0.95,
+			SemanticCacheTTLMinutes: 1440,
+			SemanticCacheMaxEntries: 1000,
+			MaxAnswerImages:         5,
+			ImageRelevanceThreshold: 0.25,
+			MMRLambda:               0.7,
+			DedupThreshold:          0.95,
+			ContextTokenBudget:      3000,
+			RecencyMinFactor:        0.5,
 		},
 		OAuth: OAuthConfig{
 			Providers: make(map[string]OAuthProviderConfig),
@@ -210,6 +895,84 @@ func DefaultConfig() *Config {
 			KeyframeOCREnabled:   true,
 			KeyframeOCRMaxFrames: 20,
 			ProcessingTimeoutMin: 120,
+			KeyframeMode:         "scene",
+			SceneThreshold:       0.3,
+			MaxKeyframes:         200,
+			KeyframeDedupDistance: 6,
+			Diarization:          "off",
+			STT: STTConfig{
+				Provider:  "rapidspeech",
+				Endpoint:  "https://api.openai.com/v1",
+				Model:     "whisper-1",
+				NLSRegion: "cn-shanghai",
+			},
+		},
+		Backup: BackupConfig{
+			Schedule:         "0 3 * * *",
+			Mode:             "full",
+			FullIntervalDays: 7,
+			OutputDir:        "backups",
+			KeepFull:         7,
+		},
+		Storage: StorageConfig{
+			Backend: "local",
+		},
+		Maintenance: MaintenanceConfig{
+			Schedule: "0 4 * * 0",
+		},
+		Replica: ReplicaConfig{
+			RefreshSec: 10,
+		},
+		Cache: CacheConfig{
+			Backend:   "memory",
+			KeyPrefix: "askflow:",
+		},
+		GapReport: GapReportConfig{
+			Schedule:            "0 8 * * 1",
+			LookbackDays:        7,
+			SimilarityThreshold: 0.8,
+			MaxTopics:           20,
+		},
+		PendingDraft: PendingDraftConfig{
+			TopK:      8,
+			Threshold: 0.3,
+		},
+		FAQ: FAQConfig{
+			Schedule:            "0 3 * * *",
+			LookbackDays:        30,
+			MinCount:            3,
+			SimilarityThreshold: 0.9,
+			MaxEntries:          50,
+		},
+		Scan: ScanConfig{
+			Backend:       "clamav",
+			ClamAVAddress: "/var/run/clamav/clamd.ctl",
+			TimeoutSec:    60,
+		},
+		Refusal: RefusalConfig{
+			Message:   "抱歉，这个问题不在我们可以解答的范围内，如需帮助请联系人工客服。",
+			Threshold: 0.85,
+		},
+		Intent: IntentConfig{
+			Mode:      IntentModeLLM,
+			Threshold: 0.85,
+		},
+		RateLimit: RateLimitConfig{
+			QueryPerMinute:  30,
+			UploadPerMinute: 20,
+			AuthPerMinute:   10,
+			APIPerMinute:    60,
+			WidgetPerMinute: 20,
+		},
+		Admission: AdmissionConfig{
+			MaxInFlight:     16,
+			QueueLimit:      64,
+			QueueTimeoutSec: 30,
+		},
+		BodyLimit: BodyLimitConfig{
+			DefaultKB: 1024,
+			QueryKB:   8192,
+			ConfigKB:  256,
 		},
 	}
 }
@@ -241,15 +1004,69 @@ func (cm *ConfigManager) Load() error {
 	if cfg.Embedding.APIKey, err = cm.decryptIfNeeded(cfg.Embedding.APIKey); err != nil {
 		return fmt.Errorf("decrypt Embedding API key: %w", err)
 	}
+	if cfg.ImageEmbed.APIKey, err = cm.decryptIfNeeded(cfg.ImageEmbed.APIKey); err != nil {
+		return fmt.Errorf("decrypt image embedding API key: %w", err)
+	}
 	for name, provider := range cfg.OAuth.Providers {
 		if provider.ClientSecret, err = cm.decryptIfNeeded(provider.ClientSecret); err != nil {
 			return fmt.Errorf("decrypt OAuth %s client secret: %w", name, err)
 		}
 		cfg.OAuth.Providers[name] = provider
 	}
+	for name, provider := range cfg.SSO.OIDC {
+		if provider.ClientSecret, err = cm.decryptIfNeeded(provider.ClientSecret); err != nil {
+			return fmt.Errorf("decrypt OIDC %s client secret: %w", name, err)
+		}
+		cfg.SSO.OIDC[name] = provider
+	}
 	if cfg.SMTP.Password, err = cm.decryptIfNeeded(cfg.SMTP.Password); err != nil {
 		return fmt.Errorf("decrypt SMTP password: %w", err)
 	}
+	if cfg.Admin.Captcha.SecretKey, err = cm.decryptIfNeeded(cfg.Admin.Captcha.SecretKey); err != nil {
+		return fmt.Errorf("decrypt captcha secret key: %w", err)
+	}
+	if cfg.Channels.Telegram.BotToken, err = cm.decryptIfNeeded(cfg.Channels.Telegram.BotToken); err != nil {
+		return fmt.Errorf("decrypt Telegram bot token: %w", err)
+	}
+	if cfg.Channels.Telegram.WebhookSecret, err = cm.decryptIfNeeded(cfg.Channels.Telegram.WebhookSecret); err != nil {
+		return fmt.Errorf("decrypt Telegram webhook secret: %w", err)
+	}
+	if cfg.Channels.WeChat.AppSecret, err = cm.decryptIfNeeded(cfg.Channels.WeChat.AppSecret); err != nil {
+		return fmt.Errorf("decrypt WeChat app secret: %w", err)
+	}
+	if cfg.Channels.WeChat.Token, err = cm.decryptIfNeeded(cfg.Channels.WeChat.Token); err != nil {
+		return fmt.Errorf("decrypt WeChat token: %w", err)
+	}
+	if cfg.Backup.S3.SecretKey, err = cm.decryptIfNeeded(cfg.Backup.S3.SecretKey); err != nil {
+		return fmt.Errorf("decrypt backup S3 secret key: %w", err)
+	}
+	if cfg.Storage.S3.SecretKey, err = cm.decryptIfNeeded(cfg.Storage.S3.SecretKey); err != nil {
+		return fmt.Errorf("decrypt storage S3 secret key: %w", err)
+	}
+	if cfg.Scan.APIKey, err = cm.decryptIfNeeded(cfg.Scan.APIKey); err != nil {
+		return fmt.Errorf("decrypt scan API key: %w", err)
+	}
+	if cfg.Video.STT.APIKey, err = cm.decryptIfNeeded(cfg.Video.STT.APIKey); err != nil {
+		return fmt.Errorf("decrypt STT API key: %w", err)
+	}
+	if cfg.Video.STT.NLSAccessKeySecret, err = cm.decryptIfNeeded(cfg.Video.STT.NLSAccessKeySecret); err != nil {
+		return fmt.Errorf("decrypt STT NLS access key secret: %w", err)
+	}
+	if cfg.Video.Workers.Token, err = cm.decryptIfNeeded(cfg.Video.Workers.Token); err != nil {
+		return fmt.Errorf("decrypt video worker token: %w", err)
+	}
+	if cfg.Replica.Token, err = cm.decryptIfNeeded(cfg.Replica.Token); err != nil {
+		return fmt.Errorf("decrypt replica token: %w", err)
+	}
+	if cfg.Cache.RedisURL, err = cm.decryptIfNeeded(cfg.Cache.RedisURL); err != nil {
+		return fmt.Errorf("decrypt cache Redis URL: %w", err)
+	}
+	if cfg.Connectors.Google.ClientSecret, err = cm.decryptIfNeeded(cfg.Connectors.Google.ClientSecret); err != nil {
+		return fmt.Errorf("decrypt Google connector client secret: %w", err)
+	}
+	if cfg.Connectors.Microsoft.ClientSecret, err = cm.decryptIfNeeded(cfg.Connectors.Microsoft.ClientSecret); err != nil {
+		return fmt.Errorf("decrypt Microsoft connector client secret: %w", err)
+	}
 
 	cm.applyDefaults(&cfg)
 	cm.config = &cfg
@@ -273,6 +1090,7 @@ func (cm *ConfigManager) saveLocked() error {
 	out := *cm.config
 	out.LLM.APIKey = cm.encryptIfNeeded(cm.config.LLM.APIKey)
 	out.Embedding.APIKey = cm.encryptIfNeeded(cm.config.Embedding.APIKey)
+	out.ImageEmbed.APIKey = cm.encryptIfNeeded(cm.config.ImageEmbed.APIKey)
 
 	if cm.config.OAuth.Providers != nil {
 		out.OAuth.Providers = make(map[string]OAuthProviderConfig, len(cm.config.OAuth.Providers))
@@ -282,15 +1100,38 @@ func (cm *ConfigManager) saveLocked() error {
 			out.OAuth.Providers[name] = p
 		}
 	}
+	if cm.config.SSO.OIDC != nil {
+		out.SSO.OIDC = make(map[string]OIDCProviderConfig, len(cm.config.SSO.OIDC))
+		for name, provider := range cm.config.SSO.OIDC {
+			p := provider
+			p.ClientSecret = cm.encryptIfNeeded(provider.ClientSecret)
+			out.SSO.OIDC[name] = p
+		}
+	}
 
 	out.SMTP.Password = cm.encryptIfNeeded(cm.config.SMTP.Password)
+	out.Admin.Captcha.SecretKey = cm.encryptIfNeeded(cm.config.Admin.Captcha.SecretKey)
+	out.Channels.Telegram.BotToken = cm.encryptIfNeeded(cm.config.Channels.Telegram.BotToken)
+	out.Channels.Telegram.WebhookSecret = cm.encryptIfNeeded(cm.config.Channels.Telegram.WebhookSecret)
+	out.Channels.WeChat.AppSecret = cm.encryptIfNeeded(cm.config.Channels.WeChat.AppSecret)
+	out.Channels.WeChat.Token = cm.encryptIfNeeded(cm.config.Channels.WeChat.Token)
+	out.Backup.S3.SecretKey = cm.encryptIfNeeded(cm.config.Backup.S3.SecretKey)
+	out.Storage.S3.SecretKey = cm.encryptIfNeeded(cm.config.Storage.S3.SecretKey)
+	out.Scan.APIKey = cm.encryptIfNeeded(cm.config.Scan.APIKey)
+	out.Video.STT.APIKey = cm.encryptIfNeeded(cm.config.Video.STT.APIKey)
+	out.Video.STT.NLSAccessKeySecret = cm.encryptIfNeeded(cm.config.Video.STT.NLSAccessKeySecret)
+	out.Video.Workers.Token = cm.encryptIfNeeded(cm.config.Video.Workers.Token)
+	out.Replica.Token = cm.encryptIfNeeded(cm.config.Replica.Token)
+	out.Cache.RedisURL = cm.encryptIfNeeded(cm.config.Cache.RedisURL)
+	out.Connectors.Google.ClientSecret = cm.encryptIfNeeded(cm.config.Connectors.Google.ClientSecret)
+	out.Connectors.Microsoft.ClientSecret = cm.encryptIfNeeded(cm.config.Connectors.Microsoft.ClientSecret)
 
 	data, err := json.MarshalIndent(out, "", "  ")
 	if err != nil {
 		return fmt.Errorf("marshal config: %w", err)
 	}
 
-	if err := os.WriteFile(cm.configPath, data, 0600); err != nil {
+	if err := writeFileAtomic(cm.configPath, data, 0600); err != nil {
 		return fmt.Errorf("write config file: %w", err)
 	}
 	return nil
@@ -300,6 +1141,12 @@ func (cm *ConfigManager) saveLocked() error {
 func (cm *ConfigManager) Get() *Config {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
+	return cm.cloneLocked()
+}
+
+// cloneLocked returns a deep copy of the current config, or nil. The caller
+// holds cm.mu.
+func (cm *ConfigManager) cloneLocked() *Config {
 	if cm.config == nil {
 		return nil
 	}
@@ -316,9 +1163,43 @@ func (cm *ConfigManager) Get() *Config {
 			c.OAuth.Providers[k] = p
 		}
 	}
+	c.SSO = cm.config.SSO.clone()
+	c.BodyLimit.Routes = maps.Clone(cm.config.BodyLimit.Routes)
 	return &c
 }
 
+// clone returns a deep copy of the SSO provider maps.
+func (s SSOConfig) clone() SSOConfig {
+	var out SSOConfig
+	if s.OIDC != nil {
+		out.OIDC = make(map[string]OIDCProviderConfig, len(s.OIDC))
+		for k, v := range s.OIDC {
+			v.Scopes = append([]string(nil), v.Scopes...)
+			v.RoleMapping = cloneStringMap(v.RoleMapping)
+			out.OIDC[k] = v
+		}
+	}
+	if s.SAML != nil {
+		out.SAML = make(map[string]SAMLProviderConfig, len(s.SAML))
+		for k, v := range s.SAML {
+			v.RoleMapping = cloneStringMap(v.RoleMapping)
+			out.SAML[k] = v
+		}
+	}
+	return out
+}
+
+func cloneStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
 // IsReady returns true if both LLM and Embedding API keys are configured (non-empty).
 func (cm *ConfigManager) IsReady() bool {
 	cm.mu.RLock()
@@ -357,6 +1238,35 @@ func (cm *ConfigManager) Update(updates map[string]interface{}) error {
 	return cm.saveLocked()
 }
 
+// Validate applies updates to a copy of the current config without saving
+// it. It returns the resulting config and the error for each rejected key;
+// valid keys are applied even when others fail.
+func (cm *ConfigManager) Validate(updates map[string]interface{}) (*Config, map[string]string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	orig := cm.config
+	candidate := cm.cloneLocked()
+	if candidate == nil {
+		candidate = DefaultConfig()
+	}
+	// applyUpdate works on cm.config, so point it at the copy for the dry run
+	cm.config = candidate
+	defer func() { cm.config = orig }()
+
+	fieldErrs := make(map[string]string)
+	if len(updates) > 100 {
+		fieldErrs[""] = "too many config updates (max 100 keys per request)"
+		return candidate, fieldErrs
+	}
+	for key, val := range updates {
+		if err := cm.applyUpdate(key, val); err != nil {
+			fieldErrs[key] = err.Error()
+		}
+	}
+	return candidate, fieldErrs
+}
+
 func (cm *ConfigManager) applyUpdate(key string, val interface{}) error {
 	switch key {
 	// LLM fields
@@ -396,6 +1306,42 @@ func (cm *ConfigManager) applyUpdate(key string, val interface{}) error {
 			return errors.New("max_tokens must be between 1 and 128000")
 		}
 		cm.config.LLM.MaxTokens = n
+	case "llm.context_window":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1024 || n > 10000000 {
+			return errors.New("context_window must be between 1024 and 10000000")
+		}
+		cm.config.LLM.ContextWindow = n
+	case "llm.compress_enabled":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected boolean")
+		}
+		cm.config.LLM.CompressEnabled = b
+	case "llm.compress_model":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		cm.config.LLM.CompressModel = strings.TrimSpace(s)
+	case "llm.compress_min_chunks":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > 100 {
+			return errors.New("compress_min_chunks must be between 1 and 100")
+		}
+		cm.config.LLM.CompressMinChunks = n
+	case "llm.injection_check":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected boolean")
+		}
+		cm.config.LLM.InjectionCheck = b
 
 	// Embedding fields
 	case "embedding.endpoint":
@@ -422,81 +1368,343 @@ func (cm *ConfigManager) applyUpdate(key string, val interface{}) error {
 			return errors.New("expected boolean")
 		}
 		cm.config.Embedding.UseMultimodal = b
-
-	// Vector fields
-	case "vector.db_path":
-		s, ok := val.(string)
-		if !ok {
-			return errors.New("expected string")
+	case "embedding.batch_max_items":
+		n, err := toInt(val)
+		if err != nil {
+			return err
 		}
-		// Prevent path traversal
-		if strings.Contains(s, "..") {
-			return errors.New("db_path must not contain '..'")
+		if n < 1 || n > 2048 {
+			return errors.New("batch_max_items must be between 1 and 2048")
 		}
-		cm.config.Vector.DBPath = s
-	case "vector.chunk_size":
+		cm.config.Embedding.BatchMaxItems = n
+	case "embedding.batch_max_tokens":
 		n, err := toInt(val)
 		if err != nil {
 			return err
 		}
-		if n < 64 || n > 8192 {
-			return errors.New("chunk_size must be between 64 and 8192")
+		if n < 256 || n > 1000000 {
+			return errors.New("batch_max_tokens must be between 256 and 1000000")
 		}
-		cm.config.Vector.ChunkSize = n
-	case "vector.overlap":
+		cm.config.Embedding.BatchMaxTokens = n
+	case "embedding.max_concurrency":
 		n, err := toInt(val)
 		if err != nil {
 			return err
 		}
-		if n < 0 || n > 4096 {
-			return errors.New("overlap must be between 0 and 4096")
+		if n < 1 || n > 32 {
+			return errors.New("max_concurrency must be between 1 and 32")
 		}
-		cm.config.Vector.Overlap = n
-	case "vector.top_k":
+		cm.config.Embedding.MaxConcurrency = n
+	case "embedding.queue_limit":
 		n, err := toInt(val)
 		if err != nil {
 			return err
 		}
-		if n < 1 || n > 100 {
-			return errors.New("top_k must be between 1 and 100")
+		if n < 1 || n > 1024 {
+			return errors.New("queue_limit must be between 1 and 1024")
 		}
-		cm.config.Vector.TopK = n
-	case "vector.threshold":
-		f, err := toFloat64(val)
+		cm.config.Embedding.QueueLimit = n
+
+	// Query admission fields
+	case "admission.max_inflight":
+		n, err := toInt(val)
 		if err != nil {
 			return err
 		}
-		if f < 0 || f > 1.0 {
-			return errors.New("threshold must be between 0 and 1.0")
+		if n < 1 || n > 1024 {
+			return errors.New("max_inflight must be between 1 and 1024")
 		}
-		cm.config.Vector.Threshold = f
-	case "vector.content_priority":
-		s, ok := val.(string)
-		if !ok {
-			return errors.New("expected string")
+		cm.config.Admission.MaxInFlight = n
+	case "admission.queue_limit":
+		n, err := toInt(val)
+		if err != nil {
+			return err
 		}
-		if s != "image_text" && s != "text_only" {
-			return errors.New("content_priority must be 'image_text' or 'text_only'")
+		if n < 1 || n > 10000 {
+			return errors.New("queue_limit must be between 1 and 10000")
 		}
-		cm.config.Vector.ContentPriority = s
-	case "vector.debug_mode":
-		b, ok := val.(bool)
-		if !ok {
-			return errors.New("expected boolean")
+		cm.config.Admission.QueueLimit = n
+	case "admission.queue_timeout_sec":
+		n, err := toInt(val)
+		if err != nil {
+			return err
 		}
-		cm.config.Vector.DebugMode = b
-	case "vector.text_match_enabled":
-		b, ok := val.(bool)
-		if !ok {
-			return errors.New("expected boolean")
+		if n < 1 || n > 600 {
+			return errors.New("queue_timeout_sec must be between 1 and 600")
 		}
-		cm.config.Vector.TextMatchEnabled = b
+		cm.config.Admission.QueueTimeoutSec = n
 
-	// Admin fields
-	case "admin.username":
-		s, ok := val.(string)
-		if !ok {
-			return errors.New("expected string")
+	// Request body limit fields
+	case "body_limit.default_kb", "body_limit.query_kb", "body_limit.config_kb":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > maxBodyLimitKB {
+			return fmt.Errorf("%s must be between 1 and %d", strings.TrimPrefix(key, "body_limit."), maxBodyLimitKB)
+		}
+		switch key {
+		case "body_limit.default_kb":
+			cm.config.BodyLimit.DefaultKB = n
+		case "body_limit.query_kb":
+			cm.config.BodyLimit.QueryKB = n
+		case "body_limit.config_kb":
+			cm.config.BodyLimit.ConfigKB = n
+		}
+	case "body_limit.routes":
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			return errors.New("expected object")
+		}
+		routes := make(map[string]int, len(m))
+		for path, v := range m {
+			if !strings.HasPrefix(path, "/api/") {
+				return fmt.Errorf("body_limit.routes: %q must start with /api/", path)
+			}
+			n, err := toInt(v)
+			if err != nil {
+				return fmt.Errorf("body_limit.routes: %q: %w", path, err)
+			}
+			if n < 1 || n > maxBodyLimitKB {
+				return fmt.Errorf("body_limit.routes: %q must be between 1 and %d", path, maxBodyLimitKB)
+			}
+			routes[path] = n
+		}
+		cm.config.BodyLimit.Routes = routes
+
+	// Image embedding fields
+	case "image_embedding.enabled":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected boolean")
+		}
+		cm.config.ImageEmbed.Enabled = b
+	case "image_embedding.endpoint", "image_embedding.api_key", "image_embedding.model_name":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		switch key {
+		case "image_embedding.endpoint":
+			cm.config.ImageEmbed.Endpoint = strings.TrimSpace(s)
+		case "image_embedding.api_key":
+			cm.config.ImageEmbed.APIKey = s
+		case "image_embedding.model_name":
+			cm.config.ImageEmbed.ModelName = strings.TrimSpace(s)
+		}
+	case "image_embedding.threshold":
+		f, err := toFloat64(val)
+		if err != nil {
+			return err
+		}
+		if f <= 0 || f > 1.0 {
+			return errors.New("threshold must be greater than 0 and at most 1.0")
+		}
+		cm.config.ImageEmbed.Threshold = f
+	case "image_embedding.top_k":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > 20 {
+			return errors.New("top_k must be between 1 and 20")
+		}
+		cm.config.ImageEmbed.TopK = n
+
+	// Vector fields
+	case "vector.db_path":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		// Prevent path traversal
+		if strings.Contains(s, "..") {
+			return errors.New("db_path must not contain '..'")
+		}
+		cm.config.Vector.DBPath = s
+	case "vector.chunk_size":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 64 || n > 8192 {
+			return errors.New("chunk_size must be between 64 and 8192")
+		}
+		cm.config.Vector.ChunkSize = n
+	case "vector.overlap":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 0 || n > 4096 {
+			return errors.New("overlap must be between 0 and 4096")
+		}
+		cm.config.Vector.Overlap = n
+	case "vector.top_k":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > 100 {
+			return errors.New("top_k must be between 1 and 100")
+		}
+		cm.config.Vector.TopK = n
+	case "vector.threshold":
+		f, err := toFloat64(val)
+		if err != nil {
+			return err
+		}
+		if f < 0 || f > 1.0 {
+			return errors.New("threshold must be between 0 and 1.0")
+		}
+		cm.config.Vector.Threshold = f
+	case "vector.content_priority":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		if s != "image_text" && s != "text_only" {
+			return errors.New("content_priority must be 'image_text' or 'text_only'")
+		}
+		cm.config.Vector.ContentPriority = s
+	case "vector.debug_mode":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected boolean")
+		}
+		cm.config.Vector.DebugMode = b
+	case "vector.text_match_enabled":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected boolean")
+		}
+		cm.config.Vector.TextMatchEnabled = b
+	case "vector.semantic_cache_enabled":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected boolean")
+		}
+		cm.config.Vector.SemanticCacheEnabled = b
+	case "vector.semantic_cache_threshold":
+		f, err := toFloat64(val)
+		if err != nil {
+			return err
+		}
+		if f < 0.8 || f > 1.0 {
+			return errors.New("semantic_cache_threshold must be between 0.8 and 1.0")
+		}
+		cm.config.Vector.SemanticCacheThreshold = f
+	case "vector.semantic_cache_ttl_minutes":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > 43200 {
+			return errors.New("semantic_cache_ttl_minutes must be between 1 and 43200")
+		}
+		cm.config.Vector.SemanticCacheTTLMinutes = n
+	case "vector.semantic_cache_max_entries":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > 100000 {
+			return errors.New("semantic_cache_max_entries must be between 1 and 100000")
+		}
+		cm.config.Vector.SemanticCacheMaxEntries = n
+	case "vector.max_answer_images":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > 20 {
+			return errors.New("max_answer_images must be between 1 and 20")
+		}
+		cm.config.Vector.MaxAnswerImages = n
+	case "vector.image_relevance_threshold":
+		f, err := toFloat64(val)
+		if err != nil {
+			return err
+		}
+		if f <= 0 || f > 1 {
+			return errors.New("image_relevance_threshold must be between 0 and 1")
+		}
+		cm.config.Vector.ImageRelevanceThreshold = f
+	case "vector.partition_cache_mb":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 0 || n > 1048576 {
+			return errors.New("partition_cache_mb must be between 0 and 1048576")
+		}
+		cm.config.Vector.PartitionCacheMB = n
+	case "vector.mmr_enabled":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected boolean")
+		}
+		cm.config.Vector.MMREnabled = b
+	case "vector.mmr_lambda":
+		f, err := toFloat64(val)
+		if err != nil {
+			return err
+		}
+		if f <= 0 || f > 1 {
+			return errors.New("mmr_lambda must be greater than 0 and at most 1")
+		}
+		cm.config.Vector.MMRLambda = f
+	case "vector.dedup_threshold":
+		f, err := toFloat64(val)
+		if err != nil {
+			return err
+		}
+		if f < 0.5 || f > 1 {
+			return errors.New("dedup_threshold must be between 0.5 and 1")
+		}
+		cm.config.Vector.DedupThreshold = f
+	case "vector.context_window":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 0 || n > 5 {
+			return errors.New("context_window must be between 0 and 5")
+		}
+		cm.config.Vector.ContextWindow = n
+	case "vector.context_token_budget":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 100 || n > 100000 {
+			return errors.New("context_token_budget must be between 100 and 100000")
+		}
+		cm.config.Vector.ContextTokenBudget = n
+	case "vector.recency_half_life_days":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 0 || n > 36500 {
+			return errors.New("recency_half_life_days must be between 0 and 36500")
+		}
+		cm.config.Vector.RecencyHalfLifeDays = n
+	case "vector.recency_min_factor":
+		f, err := toFloat64(val)
+		if err != nil {
+			return err
+		}
+		if f <= 0 || f > 1 {
+			return errors.New("recency_min_factor must be greater than 0 and at most 1")
+		}
+		cm.config.Vector.RecencyMinFactor = f
+
+	// Admin fields
+	case "admin.username":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
 		}
 		cm.config.Admin.Username = s
 	case "admin.login_route":
@@ -542,6 +1750,45 @@ func (cm *ConfigManager) applyUpdate(key string, val interface{}) error {
 			return errors.New("expected boolean")
 		}
 		cm.config.Admin.AnonymousFrontend = b
+	case "admin.captcha.provider":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		if s != "" && s != CaptchaHCaptcha && s != CaptchaTurnstile {
+			return errors.New("captcha provider must be hcaptcha or turnstile")
+		}
+		cm.config.Admin.Captcha.Provider = s
+	case "admin.captcha.site_key":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		cm.config.Admin.Captcha.SiteKey = s
+	case "admin.captcha.secret_key":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		cm.config.Admin.Captcha.SecretKey = s
+	case "admin.captcha.register":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected boolean")
+		}
+		cm.config.Admin.Captcha.Register = b
+	case "admin.captcha.login":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected boolean")
+		}
+		cm.config.Admin.Captcha.Login = b
+	case "admin.captcha.anonymous_query":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected boolean")
+		}
+		cm.config.Admin.Captcha.AnonymousQuery = b
 
 	// SMTP fields
 	case "smtp.host":
@@ -662,116 +1909,1180 @@ func (cm *ConfigManager) applyUpdate(key string, val interface{}) error {
 			}
 		}
 		cm.config.Video.RapidSpeechPath = s
-	case "video.keyframe_interval":
+	case "backup.enabled":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected bool")
+		}
+		cm.config.Backup.Enabled = b
+	case "backup.schedule":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		s = strings.TrimSpace(s)
+		if _, err := cron.Parse(s); err != nil {
+			return err
+		}
+		cm.config.Backup.Schedule = s
+	case "backup.mode":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		if s != "full" && s != "incremental" {
+			return errors.New("backup mode must be \"full\" or \"incremental\"")
+		}
+		cm.config.Backup.Mode = s
+	case "backup.full_interval_days":
 		n, err := toInt(val)
 		if err != nil {
 			return err
 		}
-		if n < 1 || n > 300 {
-			return errors.New("keyframe_interval must be between 1 and 300 seconds")
+		if n < 1 || n > 365 {
+			return errors.New("full_interval_days must be between 1 and 365")
 		}
-		cm.config.Video.KeyframeInterval = n
-	case "video.rapidspeech_model":
+		cm.config.Backup.FullIntervalDays = n
+	case "backup.output_dir":
 		s, ok := val.(string)
 		if !ok {
 			return errors.New("expected string")
 		}
-		// Validate path doesn't contain shell metacharacters
-		if strings.ContainsAny(s, "|;&$`") {
-			return errors.New("rapidspeech model path contains invalid characters")
+		s = strings.TrimSpace(s)
+		if s == "" {
+			s = "backups"
 		}
-		// Allow clearing the path
-		if s != "" {
-			// Check model file exists
-			info, err := os.Stat(s)
-			if err != nil {
-				return fmt.Errorf("rapidspeech 模型文件不存在: %s", s)
-			}
-			if info.IsDir() {
-				return fmt.Errorf("rapidspeech 模型路径指向目录而非文件: %s", s)
-			}
-			// Verify it looks like a valid model file
-			if !strings.HasSuffix(strings.ToLower(s), ".gguf") && !strings.HasSuffix(strings.ToLower(s), ".bin") {
-				return fmt.Errorf("rapidspeech 模型文件应为 .gguf 或 .bin 格式")
-			}
+		cm.config.Backup.OutputDir = s
+	case "backup.keep_full":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > 1000 {
+			return errors.New("keep_full must be between 1 and 1000")
+		}
+		cm.config.Backup.KeepFull = n
+	case "backup.max_age_days":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return errors.New("max_age_days must not be negative")
+		}
+		cm.config.Backup.MaxAgeDays = n
+	case "backup.s3.enabled":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected bool")
+		}
+		cm.config.Backup.S3.Enabled = b
+	case "backup.s3.endpoint":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		s = strings.TrimRight(strings.TrimSpace(s), "/")
+		if s != "" && !strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "https://") {
+			return errors.New("s3 endpoint must start with http:// or https://")
+		}
+		cm.config.Backup.S3.Endpoint = s
+	case "backup.s3.region":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		cm.config.Backup.S3.Region = strings.TrimSpace(s)
+	case "backup.s3.bucket":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		cm.config.Backup.S3.Bucket = strings.TrimSpace(s)
+	case "backup.s3.prefix":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		cm.config.Backup.S3.Prefix = strings.TrimLeft(strings.TrimSpace(s), "/")
+	case "backup.s3.access_key":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		cm.config.Backup.S3.AccessKey = strings.TrimSpace(s)
+	case "backup.s3.secret_key":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		cm.config.Backup.S3.SecretKey = s
+	case "backup.s3.path_style":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected bool")
+		}
+		cm.config.Backup.S3.PathStyle = b
+	case "storage.backend":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		if s != "local" && s != "s3" {
+			return errors.New("storage backend must be local or s3")
+		}
+		cm.config.Storage.Backend = s
+	case "storage.s3.endpoint":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		s = strings.TrimRight(strings.TrimSpace(s), "/")
+		if s != "" && !strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "https://") {
+			return errors.New("s3 endpoint must start with http:// or https://")
+		}
+		cm.config.Storage.S3.Endpoint = s
+	case "storage.s3.region":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		cm.config.Storage.S3.Region = strings.TrimSpace(s)
+	case "storage.s3.bucket":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		cm.config.Storage.S3.Bucket = strings.TrimSpace(s)
+	case "storage.s3.prefix":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		cm.config.Storage.S3.Prefix = strings.TrimLeft(strings.TrimSpace(s), "/")
+	case "storage.s3.access_key":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		cm.config.Storage.S3.AccessKey = strings.TrimSpace(s)
+	case "storage.s3.secret_key":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		cm.config.Storage.S3.SecretKey = s
+	case "storage.s3.path_style":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected bool")
+		}
+		cm.config.Storage.S3.PathStyle = b
+	case "storage.encrypt":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected bool")
+		}
+		cm.config.Storage.Encrypt = b
+	case "tenants.enabled":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected bool")
+		}
+		cm.config.Tenants.Enabled = b
+	case "tenants.base_domain":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		s = strings.ToLower(strings.Trim(strings.TrimSpace(s), "."))
+		if strings.ContainsAny(s, "/: ") {
+			return errors.New("base_domain must be a bare host name such as askflow.example.com")
+		}
+		cm.config.Tenants.BaseDomain = s
+	case "usage.user_monthly_queries", "usage.user_monthly_tokens",
+		"usage.product_monthly_queries", "usage.product_monthly_tokens":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return fmt.Errorf("%s must not be negative", key)
+		}
+		switch key {
+		case "usage.user_monthly_queries":
+			cm.config.Usage.UserMonthlyQueries = int64(n)
+		case "usage.user_monthly_tokens":
+			cm.config.Usage.UserMonthlyTokens = int64(n)
+		case "usage.product_monthly_queries":
+			cm.config.Usage.ProductMonthlyQueries = int64(n)
+		case "usage.product_monthly_tokens":
+			cm.config.Usage.ProductMonthlyTokens = int64(n)
+		}
+	case "maintenance.enabled":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected bool")
+		}
+		cm.config.Maintenance.Enabled = b
+	case "maintenance.schedule":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		s = strings.TrimSpace(s)
+		if _, err := cron.Parse(s); err != nil {
+			return err
+		}
+		cm.config.Maintenance.Schedule = s
+	case "replica.token":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		s = strings.TrimSpace(s)
+		if s != "" && len(s) < 16 {
+			return errors.New("replica token must be at least 16 characters")
+		}
+		cm.config.Replica.Token = s
+	case "replica.refresh_sec":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > 3600 {
+			return errors.New("refresh_sec must be between 1 and 3600")
+		}
+		cm.config.Replica.RefreshSec = n
+	case "cache.backend":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		if s != "memory" && s != "redis" {
+			return errors.New("cache backend must be memory or redis")
+		}
+		cm.config.Cache.Backend = s
+	case "cache.redis_url":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		s = strings.TrimSpace(s)
+		if s != "" {
+			if _, err := redis.New(s); err != nil {
+				return err
+			}
+		}
+		cm.config.Cache.RedisURL = s
+	case "cache.key_prefix":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		s = strings.TrimSpace(s)
+		if s == "" || len(s) > 64 {
+			return errors.New("key_prefix must be 1 to 64 characters")
+		}
+		cm.config.Cache.KeyPrefix = s
+	case "gap_report.enabled":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected bool")
+		}
+		cm.config.GapReport.Enabled = b
+	case "gap_report.schedule":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		s = strings.TrimSpace(s)
+		if _, err := cron.Parse(s); err != nil {
+			return err
+		}
+		cm.config.GapReport.Schedule = s
+	case "gap_report.lookback_days":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > 365 {
+			return errors.New("lookback_days must be between 1 and 365")
+		}
+		cm.config.GapReport.LookbackDays = n
+	case "gap_report.similarity_threshold":
+		f, err := toFloat64(val)
+		if err != nil {
+			return err
+		}
+		if f <= 0 || f > 1 {
+			return errors.New("similarity_threshold must be between 0 and 1")
+		}
+		cm.config.GapReport.SimilarityThreshold = f
+	case "gap_report.max_topics":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > 100 {
+			return errors.New("max_topics must be between 1 and 100")
+		}
+		cm.config.GapReport.MaxTopics = n
+	case "gap_report.recipients":
+		var recipients []string
+		switch v := val.(type) {
+		case string:
+			for _, addr := range strings.Split(v, ",") {
+				if addr = strings.TrimSpace(addr); addr != "" {
+					recipients = append(recipients, addr)
+				}
+			}
+		case []interface{}:
+			for _, item := range v {
+				addr, ok := item.(string)
+				if !ok {
+					return errors.New("expected string list")
+				}
+				if addr = strings.TrimSpace(addr); addr != "" {
+					recipients = append(recipients, addr)
+				}
+			}
+		default:
+			return errors.New("expected string list")
+		}
+		for _, addr := range recipients {
+			if !strings.Contains(addr, "@") || strings.ContainsAny(addr, " \r\n<>,") || len(addr) > 254 {
+				return fmt.Errorf("invalid email address %q", addr)
+			}
+		}
+		cm.config.GapReport.Recipients = recipients
+	case "faq.enabled":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected bool")
+		}
+		cm.config.FAQ.Enabled = b
+	case "faq.schedule":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		s = strings.TrimSpace(s)
+		if _, err := cron.Parse(s); err != nil {
+			return err
+		}
+		cm.config.FAQ.Schedule = s
+	case "faq.lookback_days":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > 365 {
+			return errors.New("lookback_days must be between 1 and 365")
+		}
+		cm.config.FAQ.LookbackDays = n
+	case "faq.min_count":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > 1000 {
+			return errors.New("min_count must be between 1 and 1000")
+		}
+		cm.config.FAQ.MinCount = n
+	case "faq.similarity_threshold":
+		f, err := toFloat64(val)
+		if err != nil {
+			return err
+		}
+		if f < 0.5 || f > 1 {
+			return errors.New("similarity_threshold must be between 0.5 and 1")
+		}
+		cm.config.FAQ.SimilarityThreshold = f
+	case "faq.max_entries":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > 500 {
+			return errors.New("max_entries must be between 1 and 500")
+		}
+		cm.config.FAQ.MaxEntries = n
+	case "scan.enabled":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected bool")
+		}
+		cm.config.Scan.Enabled = b
+	case "scan.backend":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		if s != "clamav" && s != "http" {
+			return errors.New("backend must be clamav or http")
+		}
+		cm.config.Scan.Backend = s
+	case "scan.clamav_address":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		cm.config.Scan.ClamAVAddress = strings.TrimSpace(s)
+	case "scan.api_url":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		s = strings.TrimSpace(s)
+		if s != "" && !strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "https://") {
+			return errors.New("api_url must be an http or https URL")
+		}
+		cm.config.Scan.APIURL = s
+	case "scan.api_key":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		cm.config.Scan.APIKey = s
+	case "scan.timeout_sec":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > 3600 {
+			return errors.New("timeout_sec must be between 1 and 3600")
+		}
+		cm.config.Scan.TimeoutSec = n
+	case "scan.fail_open":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected bool")
+		}
+		cm.config.Scan.FailOpen = b
+	case "connectors.google.client_id", "connectors.google.client_secret",
+		"connectors.microsoft.client_id", "connectors.microsoft.client_secret", "connectors.microsoft.tenant":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		s = strings.TrimSpace(s)
+		app := &cm.config.Connectors.Google
+		if strings.HasPrefix(key, "connectors.microsoft.") {
+			app = &cm.config.Connectors.Microsoft
+		}
+		switch key[strings.LastIndexByte(key, '.')+1:] {
+		case "client_id":
+			app.ClientID = s
+		case "client_secret":
+			app.ClientSecret = s
+		case "tenant":
+			for _, c := range s {
+				if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+					return errors.New("tenant must be a tenant ID or domain")
+				}
+			}
+			app.Tenant = s
+		}
+	case "sessions.max_per_user":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 0 || n > 100 {
+			return errors.New("max_per_user must be between 0 and 100")
+		}
+		cm.config.Sessions.MaxPerUser = n
+	case "sessions.login_alerts":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		switch s {
+		case "", LoginAlertsIP, LoginAlertsCountry:
+		default:
+			return fmt.Errorf("login_alerts must be empty, %q or %q", LoginAlertsIP, LoginAlertsCountry)
+		}
+		cm.config.Sessions.LoginAlerts = s
+	case "pending_draft.enabled":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected bool")
+		}
+		cm.config.PendingDraft.Enabled = b
+	case "pending_draft.top_k":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > 50 {
+			return errors.New("top_k must be between 1 and 50")
+		}
+		cm.config.PendingDraft.TopK = n
+	case "pending_draft.threshold":
+		f, err := toFloat64(val)
+		if err != nil {
+			return err
+		}
+		if f < 0 || f > 1 {
+			return errors.New("threshold must be between 0 and 1")
+		}
+		cm.config.PendingDraft.Threshold = f
+	case "refusal.message":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		s = strings.TrimSpace(s)
+		if s == "" || len(s) > 4000 {
+			return errors.New("message must not be empty or longer than 4000 bytes")
+		}
+		cm.config.Refusal.Message = s
+	case "refusal.threshold":
+		f, err := toFloat64(val)
+		if err != nil {
+			return err
+		}
+		if f < 0.5 || f > 1 {
+			return errors.New("threshold must be between 0.5 and 1")
+		}
+		cm.config.Refusal.Threshold = f
+	case "intent.mode":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		switch s {
+		case IntentModeLLM, IntentModeEmbedding, IntentModeOff:
+		default:
+			return fmt.Errorf("mode must be %q, %q or %q", IntentModeLLM, IntentModeEmbedding, IntentModeOff)
+		}
+		cm.config.Intent.Mode = s
+	case "intent.small_talk":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected bool")
+		}
+		cm.config.Intent.SmallTalk = b
+	case "intent.threshold":
+		f, err := toFloat64(val)
+		if err != nil {
+			return err
+		}
+		if f < 0.5 || f > 1 {
+			return errors.New("threshold must be between 0.5 and 1")
+		}
+		cm.config.Intent.Threshold = f
+	case "intent.custom":
+		intents, err := parseCustomIntents(val)
+		if err != nil {
+			return err
+		}
+		cm.config.Intent.Custom = intents
+	case "rate_limit.query_per_minute", "rate_limit.upload_per_minute", "rate_limit.auth_per_minute",
+		"rate_limit.api_per_minute", "rate_limit.widget_per_minute":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > 10000 {
+			return fmt.Errorf("%s must be between 1 and 10000", key)
+		}
+		switch key {
+		case "rate_limit.query_per_minute":
+			cm.config.RateLimit.QueryPerMinute = n
+		case "rate_limit.upload_per_minute":
+			cm.config.RateLimit.UploadPerMinute = n
+		case "rate_limit.auth_per_minute":
+			cm.config.RateLimit.AuthPerMinute = n
+		case "rate_limit.api_per_minute":
+			cm.config.RateLimit.APIPerMinute = n
+		case "rate_limit.widget_per_minute":
+			cm.config.RateLimit.WidgetPerMinute = n
+		}
+	case "abuse.geoip_database", "abuse.asn_database":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		s = strings.TrimSpace(s)
+		if key == "abuse.geoip_database" {
+			cm.config.Abuse.GeoIPDatabase = s
+		} else {
+			cm.config.Abuse.ASNDatabase = s
+		}
+	case "abuse.allow_countries", "abuse.deny_countries":
+		list, err := toStringList(val)
+		if err != nil {
+			return err
+		}
+		for i, c := range list {
+			c = strings.ToUpper(c)
+			if len(c) != 2 || c[0] < 'A' || c[0] > 'Z' || c[1] < 'A' || c[1] > 'Z' {
+				return fmt.Errorf("invalid country code %q", list[i])
+			}
+			list[i] = c
+		}
+		if key == "abuse.allow_countries" {
+			cm.config.Abuse.AllowCountries = list
+		} else {
+			cm.config.Abuse.DenyCountries = list
+		}
+	case "abuse.deny_asns":
+		list, err := toStringList(val)
+		if err != nil {
+			return err
+		}
+		for i, asn := range list {
+			digits := strings.TrimPrefix(strings.ToUpper(asn), "AS")
+			if n, err := strconv.ParseUint(digits, 10, 32); err != nil || n == 0 {
+				return fmt.Errorf("invalid AS number %q", asn)
+			}
+			list[i] = "AS" + digits
+		}
+		cm.config.Abuse.DenyASNs = list
+	case "video.keyframe_interval":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > 300 {
+			return errors.New("keyframe_interval must be between 1 and 300 seconds")
+		}
+		cm.config.Video.KeyframeInterval = n
+	case "video.rapidspeech_model":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		// Validate path doesn't contain shell metacharacters
+		if strings.ContainsAny(s, "|;&$`") {
+			return errors.New("rapidspeech model path contains invalid characters")
+		}
+		// Allow clearing the path
+		if s != "" {
+			// Check model file exists
+			info, err := os.Stat(s)
+			if err != nil {
+				return fmt.Errorf("rapidspeech 模型文件不存在: %s", s)
+			}
+			if info.IsDir() {
+				return fmt.Errorf("rapidspeech 模型路径指向目录而非文件: %s", s)
+			}
+			// Verify it looks like a valid model file
+			if !strings.HasSuffix(strings.ToLower(s), ".gguf") && !strings.HasSuffix(strings.ToLower(s), ".bin") {
+				return fmt.Errorf("rapidspeech 模型文件应为 .gguf 或 .bin 格式")
+			}
+		}
+		cm.config.Video.RapidSpeechModel = s
+	case "video.max_upload_size_mb":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1 {
+			return errors.New("max_upload_size_mb must be at least 1")
+		}
+		cm.config.Video.MaxUploadSizeMB = n
+	case "video.keyframe_ocr_enabled":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected boolean")
+		}
+		cm.config.Video.KeyframeOCREnabled = b
+	case "video.keyframe_ocr_max_frames":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 0 || n > 200 {
+			return errors.New("keyframe_ocr_max_frames must be between 0 and 200")
+		}
+		cm.config.Video.KeyframeOCRMaxFrames = n
+	case "video.processing_timeout_min":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > 1440 {
+			return errors.New("processing_timeout_min must be between 1 and 1440")
+		}
+		cm.config.Video.ProcessingTimeoutMin = n
+	case "video.keyframe_mode":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		if s != "scene" && s != "interval" {
+			return errors.New("keyframe_mode must be scene or interval")
+		}
+		cm.config.Video.KeyframeMode = s
+	case "video.scene_threshold":
+		f, err := toFloat64(val)
+		if err != nil {
+			return err
+		}
+		if f <= 0 || f >= 1 {
+			return errors.New("scene_threshold must be between 0 and 1")
+		}
+		cm.config.Video.SceneThreshold = f
+	case "video.max_keyframes":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > 2000 {
+			return errors.New("max_keyframes must be between 1 and 2000")
+		}
+		cm.config.Video.MaxKeyframes = n
+	case "video.keyframe_dedup_distance":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		// 0 is not allowed: it would read back as the default
+		if n < -1 || n == 0 || n > 32 {
+			return errors.New("keyframe_dedup_distance must be -1 (disabled) or between 1 and 32")
+		}
+		cm.config.Video.KeyframeDedupDistance = n
+	case "video.stt.provider":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		switch s {
+		case "rapidspeech", "whisper_cpp", "openai", "aliyun_nls":
+		default:
+			return errors.New("stt provider must be rapidspeech, whisper_cpp, openai or aliyun_nls")
+		}
+		cm.config.Video.STT.Provider = s
+	case "video.stt.language":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		s = strings.TrimSpace(s)
+		if s != "" && !isSTTCode(s) {
+			return errors.New("stt language must be a language code such as zh or en")
+		}
+		cm.config.Video.STT.Language = s
+	case "video.stt.whisper_cpp_path", "video.stt.whisper_cpp_model":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		s = strings.TrimSpace(s)
+		if strings.ContainsAny(s, "|;&$`") {
+			return errors.New("whisper.cpp path contains invalid characters")
+		}
+		if s != "" {
+			info, err := os.Stat(s)
+			if err != nil {
+				return fmt.Errorf("whisper.cpp 文件不存在: %s", s)
+			}
+			if info.IsDir() {
+				return fmt.Errorf("whisper.cpp 路径指向目录而非文件: %s", s)
+			}
+		}
+		if key == "video.stt.whisper_cpp_path" {
+			cm.config.Video.STT.WhisperCppPath = s
+		} else {
+			cm.config.Video.STT.WhisperCppModel = s
+		}
+	case "video.stt.endpoint":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		s = strings.TrimRight(strings.TrimSpace(s), "/")
+		if !strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "https://") {
+			return errors.New("stt endpoint must be an http or https URL")
+		}
+		cm.config.Video.STT.Endpoint = s
+	case "video.stt.api_key":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		cm.config.Video.STT.APIKey = s
+	case "video.stt.model":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return errors.New("stt model must not be empty")
+		}
+		cm.config.Video.STT.Model = s
+	case "video.stt.nls_access_key_id":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		cm.config.Video.STT.NLSAccessKeyID = strings.TrimSpace(s)
+	case "video.stt.nls_access_key_secret":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		cm.config.Video.STT.NLSAccessKeySecret = s
+	case "video.stt.nls_app_key":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		cm.config.Video.STT.NLSAppKey = strings.TrimSpace(s)
+	case "video.stt.nls_region":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		s = strings.TrimSpace(s)
+		if !isSTTCode(s) {
+			return errors.New("nls_region must be a region ID such as cn-shanghai")
+		}
+		cm.config.Video.STT.NLSRegion = s
+	case "video.diarization":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		if s != "off" && s != "stt" && s != "llm" {
+			return errors.New("diarization must be off, stt or llm")
+		}
+		cm.config.Video.Diarization = s
+	case "video.speaker_labels":
+		list, err := toStringList(val)
+		if err != nil {
+			return err
+		}
+		labels := make([]string, 0, len(list))
+		for _, l := range list {
+			l = strings.TrimSpace(l)
+			if l == "" {
+				continue
+			}
+			if len([]rune(l)) > 32 || strings.ContainsAny(l, ":：\n") {
+				return fmt.Errorf("invalid speaker label %q", l)
+			}
+			labels = append(labels, l)
+		}
+		if len(labels) > 10 {
+			return errors.New("at most 10 speaker labels are allowed")
+		}
+		cm.config.Video.SpeakerLabels = labels
+	case "video.workers.enabled":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected bool")
+		}
+		cm.config.Video.Workers.Enabled = b
+	case "video.workers.token":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		s = strings.TrimSpace(s)
+		if s != "" && len(s) < 16 {
+			return errors.New("video worker token must be at least 16 characters")
+		}
+		cm.config.Video.Workers.Token = s
+
+	// Server fields
+	case "server.bind":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		cm.config.Server.Bind = s
+	case "server.port":
+		n, err := toInt(val)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > 65535 {
+			return errors.New("port must be between 1 and 65535")
+		}
+		cm.config.Server.Port = n
+	case "server.ssl_cert":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		if strings.Contains(s, "..") {
+			return errors.New("ssl_cert path must not contain '..'")
+		}
+		cm.config.Server.SSLCert = s
+	case "server.ssl_key":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		if strings.Contains(s, "..") {
+			return errors.New("ssl_key path must not contain '..'")
+		}
+		cm.config.Server.SSLKey = s
+	case "server.listen_addr":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		if s != "" {
+			if _, _, err := ParseListenAddr(s); err != nil {
+				return err
+			}
+		}
+		cm.config.Server.ListenAddr = s
+	case "server.grpc_listen_addr":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		if s != "" {
+			if _, _, err := ParseListenAddr(s); err != nil {
+				return err
+			}
+		}
+		cm.config.Server.GRPCListenAddr = s
+	case "server.base_path":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		p, err := NormalizeBasePath(s)
+		if err != nil {
+			return err
+		}
+		cm.config.Server.BasePath = p
+	case "server.session_mode":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		if s != SessionModeBearer && s != SessionModeCookie {
+			return errors.New("session_mode must be bearer or cookie")
+		}
+		cm.config.Server.SessionMode = s
+	case "server.shutdown_timeout_sec":
+		n, err := toInt(val)
+		if err != nil {
+			return err
 		}
-		cm.config.Video.RapidSpeechModel = s
-	case "video.max_upload_size_mb":
+		if n < 5 || n > 3600 {
+			return errors.New("shutdown_timeout_sec must be between 5 and 3600")
+		}
+		cm.config.Server.ShutdownTimeoutSec = n
+	case "server.image_url_ttl_minutes":
 		n, err := toInt(val)
 		if err != nil {
 			return err
 		}
-		if n < 1 {
-			return errors.New("max_upload_size_mb must be at least 1")
+		if n < 1 || n > 1440 {
+			return errors.New("image_url_ttl_minutes must be between 1 and 1440")
 		}
-		cm.config.Video.MaxUploadSizeMB = n
-	case "video.keyframe_ocr_enabled":
-		b, ok := val.(bool)
+		cm.config.Server.ImageURLTTLMinutes = n
+	case "server.language":
+		s, ok := val.(string)
 		if !ok {
-			return errors.New("expected boolean")
+			return errors.New("expected string")
 		}
-		cm.config.Video.KeyframeOCREnabled = b
-	case "video.keyframe_ocr_max_frames":
+		lang, ok := i18n.Supported(s)
+		if !ok {
+			return fmt.Errorf("language must be one of %s", strings.Join(i18n.Languages(), ", "))
+		}
+		cm.config.Server.Language = lang
+	case "server.http_redirect_port":
 		n, err := toInt(val)
 		if err != nil {
 			return err
 		}
-		if n < 0 || n > 200 {
-			return errors.New("keyframe_ocr_max_frames must be between 0 and 200")
+		if n < 0 || n > 65535 {
+			return errors.New("http_redirect_port must be between 0 and 65535")
 		}
-		cm.config.Video.KeyframeOCRMaxFrames = n
-	case "video.processing_timeout_min":
+		cm.config.Server.HTTPRedirectPort = n
+	case "server.hsts_max_age":
 		n, err := toInt(val)
 		if err != nil {
 			return err
 		}
-		if n < 1 || n > 1440 {
-			return errors.New("processing_timeout_min must be between 1 and 1440")
+		if n > DefaultHSTSMaxAge {
+			return errors.New("hsts_max_age must not exceed 63072000")
 		}
-		cm.config.Video.ProcessingTimeoutMin = n
+		cm.config.Server.HSTSMaxAge = n
+	case "server.ready_check_upstream":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected bool")
+		}
+		cm.config.Server.ReadyCheckUpstream = b
+	case "server.autocert.enabled":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected bool")
+		}
+		cm.config.Server.AutoCert.Enabled = b
+	case "server.autocert.domains":
+		var domains []string
+		switch v := val.(type) {
+		case string:
+			for _, d := range strings.Split(v, ",") {
+				if d = strings.TrimSpace(d); d != "" {
+					domains = append(domains, d)
+				}
+			}
+		case []interface{}:
+			for _, item := range v {
+				d, ok := item.(string)
+				if !ok {
+					return errors.New("expected string list")
+				}
+				if d = strings.TrimSpace(d); d != "" {
+					domains = append(domains, d)
+				}
+			}
+		default:
+			return errors.New("expected string list")
+		}
+		for _, d := range domains {
+			if strings.ContainsAny(d, "/:* ") {
+				return fmt.Errorf("invalid autocert domain %q", d)
+			}
+		}
+		cm.config.Server.AutoCert.Domains = domains
+	case "server.autocert.email":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		cm.config.Server.AutoCert.Email = s
+	case "server.autocert.cache_dir":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		if strings.Contains(s, "..") {
+			return errors.New("cache_dir path must not contain '..'")
+		}
+		cm.config.Server.AutoCert.CacheDir = s
+	case "server.autocert.directory_url":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
+		}
+		if s != "" && !strings.HasPrefix(s, "https://") {
+			return errors.New("directory_url must be an https URL")
+		}
+		cm.config.Server.AutoCert.DirectoryURL = s
 
-	// Server fields
-	case "server.bind":
+	// Channel fields
+	case "channels.telegram.enabled":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected boolean")
+		}
+		cm.config.Channels.Telegram.Enabled = b
+	case "channels.telegram.bot_token":
 		s, ok := val.(string)
 		if !ok {
 			return errors.New("expected string")
 		}
-		cm.config.Server.Bind = s
-	case "server.port":
-		n, err := toInt(val)
-		if err != nil {
-			return err
+		cm.config.Channels.Telegram.BotToken = s
+	case "channels.telegram.webhook_secret":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
 		}
-		if n < 1 || n > 65535 {
-			return errors.New("port must be between 1 and 65535")
+		cm.config.Channels.Telegram.WebhookSecret = s
+	case "channels.telegram.product_id":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
 		}
-		cm.config.Server.Port = n
-	case "server.ssl_cert":
+		cm.config.Channels.Telegram.ProductID = s
+	case "channels.wechat.enabled":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected boolean")
+		}
+		cm.config.Channels.WeChat.Enabled = b
+	case "channels.wechat.app_id":
 		s, ok := val.(string)
 		if !ok {
 			return errors.New("expected string")
 		}
-		if strings.Contains(s, "..") {
-			return errors.New("ssl_cert path must not contain '..'")
+		cm.config.Channels.WeChat.AppID = s
+	case "channels.wechat.app_secret":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
 		}
-		cm.config.Server.SSLCert = s
-	case "server.ssl_key":
+		cm.config.Channels.WeChat.AppSecret = s
+	case "channels.wechat.token":
 		s, ok := val.(string)
 		if !ok {
 			return errors.New("expected string")
 		}
-		if strings.Contains(s, "..") {
-			return errors.New("ssl_key path must not contain '..'")
+		cm.config.Channels.WeChat.Token = s
+	case "channels.wechat.product_id":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected string")
 		}
-		cm.config.Server.SSLKey = s
+		cm.config.Channels.WeChat.ProductID = s
 
 	default:
 		// Handle OAuth provider config: oauth.providers.<name>.<field>
 		if strings.HasPrefix(key, "oauth.providers.") {
 			return cm.applyOAuthUpdate(key, val)
 		}
+		// Handle SSO provider config: sso.oidc.<name>.<field> / sso.saml.<name>.<field>
+		if strings.HasPrefix(key, "sso.oidc.") || strings.HasPrefix(key, "sso.saml.") {
+			return cm.applySSOUpdate(key, val)
+		}
+		// Handle rate limit tiers: rate_limit.<tier>.<group>
+		if strings.HasPrefix(key, "rate_limit.") {
+			return cm.applyRateLimitTierUpdate(key, val)
+		}
+		return fmt.Errorf("unknown config key: %s", key)
+	}
+	return nil
+}
+
+// applyRateLimitTierUpdate handles rate limit tier keys like
+// "rate_limit.admin.query". 0 removes the override.
+func (cm *ConfigManager) applyRateLimitTierUpdate(key string, val interface{}) error {
+	parts := strings.Split(key, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("unknown config key: %s", key)
+	}
+	tier := cm.config.RateLimit.tier(parts[1])
+	if tier == nil {
+		return fmt.Errorf("unknown config key: %s", key)
+	}
+	var field *int
+	switch parts[2] {
+	case "query":
+		field = &tier.Query
+	case "upload":
+		field = &tier.Upload
+	case "auth":
+		field = &tier.Auth
+	case "api":
+		field = &tier.API
+	case "widget":
+		field = &tier.Widget
+	default:
 		return fmt.Errorf("unknown config key: %s", key)
 	}
+	n, err := toInt(val)
+	if err != nil {
+		return err
+	}
+	if n < 0 || n > 10000 {
+		return fmt.Errorf("%s must be between 0 and 10000", key)
+	}
+	*field = n
 	return nil
 }
 
@@ -835,6 +3146,155 @@ func (cm *ConfigManager) applyOAuthUpdate(key string, val interface{}) error {
 	return nil
 }
 
+// applySSOUpdate handles SSO provider config keys like "sso.oidc.okta.issuer"
+// or "sso.saml.azure.idp_sso_url".
+func (cm *ConfigManager) applySSOUpdate(key string, val interface{}) error {
+	parts := strings.SplitN(key, ".", 4)
+	if len(parts) != 4 || parts[2] == "" {
+		return fmt.Errorf("invalid SSO config key: %s", key)
+	}
+	kind, providerName, field := parts[1], parts[2], parts[3]
+	if len(providerName) > 50 || strings.ContainsAny(providerName, "/<>\"'\\ ") {
+		return errors.New("invalid provider name")
+	}
+
+	if field == "role_mapping" {
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			return errors.New("expected object")
+		}
+		mapping := make(map[string]string, len(m))
+		for k, v := range m {
+			role, ok := v.(string)
+			if !ok {
+				return errors.New("role_mapping values must be strings")
+			}
+			mapping[k] = role
+		}
+		if kind == "oidc" {
+			p := cm.ssoOIDC(providerName)
+			p.RoleMapping = mapping
+			cm.config.SSO.OIDC[providerName] = p
+		} else {
+			p := cm.ssoSAML(providerName)
+			p.RoleMapping = mapping
+			cm.config.SSO.SAML[providerName] = p
+		}
+		return nil
+	}
+
+	if kind == "oidc" && field == "scopes" {
+		if arr, ok := val.([]interface{}); ok {
+			scopes := make([]string, 0, len(arr))
+			for _, v := range arr {
+				if sv, ok := v.(string); ok {
+					scopes = append(scopes, sv)
+				}
+			}
+			p := cm.ssoOIDC(providerName)
+			p.Scopes = scopes
+			cm.config.SSO.OIDC[providerName] = p
+			return nil
+		}
+	}
+
+	s, ok := val.(string)
+	if !ok {
+		return errors.New("expected string")
+	}
+
+	if kind == "oidc" {
+		p := cm.ssoOIDC(providerName)
+		switch field {
+		case "display_name":
+			p.DisplayName = s
+		case "issuer":
+			if s != "" && !strings.HasPrefix(s, "https://") {
+				return errors.New("issuer must use HTTPS")
+			}
+			p.Issuer = strings.TrimRight(s, "/")
+		case "client_id":
+			p.ClientID = s
+		case "client_secret":
+			p.ClientSecret = s
+		case "redirect_url":
+			p.RedirectURL = s
+		case "scopes":
+			p.Scopes = strings.Split(s, ",")
+		case "role_claim":
+			p.RoleClaim = s
+		default:
+			return fmt.Errorf("unknown OIDC provider field: %s", field)
+		}
+		cm.config.SSO.OIDC[providerName] = p
+		return nil
+	}
+
+	p := cm.ssoSAML(providerName)
+	switch field {
+	case "display_name":
+		p.DisplayName = s
+	case "idp_sso_url":
+		if s != "" && !strings.HasPrefix(s, "https://") {
+			return errors.New("idp_sso_url must use HTTPS")
+		}
+		p.IdPSSOURL = s
+	case "idp_entity_id":
+		p.IdPEntityID = s
+	case "idp_certificate":
+		p.IdPCertificate = s
+	case "sp_entity_id":
+		p.SPEntityID = s
+	case "acs_url":
+		p.ACSURL = s
+	case "email_attribute":
+		p.EmailAttribute = s
+	case "name_attribute":
+		p.NameAttribute = s
+	case "role_attribute":
+		p.RoleAttribute = s
+	default:
+		return fmt.Errorf("unknown SAML provider field: %s", field)
+	}
+	cm.config.SSO.SAML[providerName] = p
+	return nil
+}
+
+// ssoOIDC returns the named OIDC provider config, creating the map if needed.
+func (cm *ConfigManager) ssoOIDC(name string) OIDCProviderConfig {
+	if cm.config.SSO.OIDC == nil {
+		cm.config.SSO.OIDC = make(map[string]OIDCProviderConfig)
+	}
+	return cm.config.SSO.OIDC[name]
+}
+
+// ssoSAML returns the named SAML provider config, creating the map if needed.
+func (cm *ConfigManager) ssoSAML(name string) SAMLProviderConfig {
+	if cm.config.SSO.SAML == nil {
+		cm.config.SSO.SAML = make(map[string]SAMLProviderConfig)
+	}
+	return cm.config.SSO.SAML[name]
+}
+
+// DeleteSSOProvider removes an SSO provider ("oidc" or "saml") from the config and saves.
+func (cm *ConfigManager) DeleteSSOProvider(kind, name string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if cm.config == nil {
+		return nil
+	}
+	switch kind {
+	case "oidc":
+		delete(cm.config.SSO.OIDC, name)
+	case "saml":
+		delete(cm.config.SSO.SAML, name)
+	default:
+		return fmt.Errorf("unknown SSO provider type: %s", kind)
+	}
+	return cm.saveLocked()
+}
+
 // DeleteOAuthProvider removes an OAuth provider from the config and saves.
 func (cm *ConfigManager) DeleteOAuthProvider(provider string) error {
 	cm.mu.Lock()
@@ -856,6 +3316,21 @@ func (cm *ConfigManager) applyDefaults(cfg *Config) {
 	if cfg.Server.Port == 0 {
 		cfg.Server.Port = defaults.Server.Port
 	}
+	if cfg.Server.SessionMode == "" {
+		cfg.Server.SessionMode = defaults.Server.SessionMode
+	}
+	if cfg.Server.ShutdownTimeoutSec == 0 {
+		cfg.Server.ShutdownTimeoutSec = defaults.Server.ShutdownTimeoutSec
+	}
+	if cfg.Server.ImageURLTTLMinutes == 0 {
+		cfg.Server.ImageURLTTLMinutes = defaults.Server.ImageURLTTLMinutes
+	}
+	if cfg.Server.Language == "" {
+		cfg.Server.Language = defaults.Server.Language
+	}
+	if cfg.Storage.Backend == "" {
+		cfg.Storage.Backend = defaults.Storage.Backend
+	}
 	if cfg.LLM.Endpoint == "" {
 		cfg.LLM.Endpoint = defaults.LLM.Endpoint
 	}
@@ -870,12 +3345,30 @@ func (cm *ConfigManager) applyDefaults(cfg *Config) {
 	if cfg.LLM.MaxTokens == 0 {
 		cfg.LLM.MaxTokens = defaults.LLM.MaxTokens
 	}
+	if cfg.LLM.ContextWindow == 0 {
+		cfg.LLM.ContextWindow = defaults.LLM.ContextWindow
+	}
+	if cfg.LLM.CompressMinChunks == 0 {
+		cfg.LLM.CompressMinChunks = defaults.LLM.CompressMinChunks
+	}
 	if cfg.Embedding.Endpoint == "" {
 		cfg.Embedding.Endpoint = defaults.Embedding.Endpoint
 	}
 	if cfg.Embedding.ModelName == "" {
 		cfg.Embedding.ModelName = defaults.Embedding.ModelName
 	}
+	if cfg.Embedding.BatchMaxItems == 0 {
+		cfg.Embedding.BatchMaxItems = defaults.Embedding.BatchMaxItems
+	}
+	if cfg.Embedding.BatchMaxTokens == 0 {
+		cfg.Embedding.BatchMaxTokens = defaults.Embedding.BatchMaxTokens
+	}
+	if cfg.Embedding.MaxConcurrency == 0 {
+		cfg.Embedding.MaxConcurrency = defaults.Embedding.MaxConcurrency
+	}
+	if cfg.Embedding.QueueLimit == 0 {
+		cfg.Embedding.QueueLimit = defaults.Embedding.QueueLimit
+	}
 	if cfg.Vector.DBPath == "" {
 		cfg.Vector.DBPath = defaults.Vector.DBPath
 	}
@@ -894,6 +3387,33 @@ func (cm *ConfigManager) applyDefaults(cfg *Config) {
 	if cfg.Vector.ContentPriority == "" {
 		cfg.Vector.ContentPriority = defaults.Vector.ContentPriority
 	}
+	if cfg.Vector.SemanticCacheThreshold == 0 {
+		cfg.Vector.SemanticCacheThreshold = defaults.Vector.SemanticCacheThreshold
+	}
+	if cfg.Vector.SemanticCacheTTLMinutes == 0 {
+		cfg.Vector.SemanticCacheTTLMinutes = defaults.Vector.SemanticCacheTTLMinutes
+	}
+	if cfg.Vector.SemanticCacheMaxEntries == 0 {
+		cfg.Vector.SemanticCacheMaxEntries = defaults.Vector.SemanticCacheMaxEntries
+	}
+	if cfg.Vector.MaxAnswerImages == 0 {
+		cfg.Vector.MaxAnswerImages = defaults.Vector.MaxAnswerImages
+	}
+	if cfg.Vector.ImageRelevanceThreshold == 0 {
+		cfg.Vector.ImageRelevanceThreshold = defaults.Vector.ImageRelevanceThreshold
+	}
+	if cfg.Vector.MMRLambda == 0 {
+		cfg.Vector.MMRLambda = defaults.Vector.MMRLambda
+	}
+	if cfg.Vector.DedupThreshold == 0 {
+		cfg.Vector.DedupThreshold = defaults.Vector.DedupThreshold
+	}
+	if cfg.Vector.ContextTokenBudget == 0 {
+		cfg.Vector.ContextTokenBudget = defaults.Vector.ContextTokenBudget
+	}
+	if cfg.Vector.RecencyMinFactor == 0 {
+		cfg.Vector.RecencyMinFactor = defaults.Vector.RecencyMinFactor
+	}
 	if cfg.OAuth.Providers == nil {
 		cfg.OAuth.Providers = make(map[string]OAuthProviderConfig)
 	}
@@ -912,6 +3432,153 @@ func (cm *ConfigManager) applyDefaults(cfg *Config) {
 	if cfg.Video.ProcessingTimeoutMin == 0 {
 		cfg.Video.ProcessingTimeoutMin = defaults.Video.ProcessingTimeoutMin
 	}
+	if cfg.Video.KeyframeMode == "" {
+		cfg.Video.KeyframeMode = defaults.Video.KeyframeMode
+	}
+	if cfg.Video.SceneThreshold == 0 {
+		cfg.Video.SceneThreshold = defaults.Video.SceneThreshold
+	}
+	if cfg.Video.MaxKeyframes == 0 {
+		cfg.Video.MaxKeyframes = defaults.Video.MaxKeyframes
+	}
+	if cfg.Video.KeyframeDedupDistance == 0 {
+		cfg.Video.KeyframeDedupDistance = defaults.Video.KeyframeDedupDistance
+	}
+	if cfg.Video.STT.Provider == "" {
+		cfg.Video.STT.Provider = defaults.Video.STT.Provider
+	}
+	if cfg.Video.Diarization == "" {
+		cfg.Video.Diarization = defaults.Video.Diarization
+	}
+	if cfg.Video.STT.Endpoint == "" {
+		cfg.Video.STT.Endpoint = defaults.Video.STT.Endpoint
+	}
+	if cfg.Video.STT.Model == "" {
+		cfg.Video.STT.Model = defaults.Video.STT.Model
+	}
+	if cfg.Video.STT.NLSRegion == "" {
+		cfg.Video.STT.NLSRegion = defaults.Video.STT.NLSRegion
+	}
+	if cfg.Backup.Schedule == "" {
+		cfg.Backup.Schedule = defaults.Backup.Schedule
+	}
+	if cfg.Backup.Mode == "" {
+		cfg.Backup.Mode = defaults.Backup.Mode
+	}
+	if cfg.Backup.FullIntervalDays == 0 {
+		cfg.Backup.FullIntervalDays = defaults.Backup.FullIntervalDays
+	}
+	if cfg.Backup.OutputDir == "" {
+		cfg.Backup.OutputDir = defaults.Backup.OutputDir
+	}
+	if cfg.Backup.KeepFull == 0 {
+		cfg.Backup.KeepFull = defaults.Backup.KeepFull
+	}
+	if cfg.Maintenance.Schedule == "" {
+		cfg.Maintenance.Schedule = defaults.Maintenance.Schedule
+	}
+	if cfg.Replica.RefreshSec == 0 {
+		cfg.Replica.RefreshSec = defaults.Replica.RefreshSec
+	}
+	if cfg.Cache.Backend == "" {
+		cfg.Cache.Backend = defaults.Cache.Backend
+	}
+	if cfg.Cache.KeyPrefix == "" {
+		cfg.Cache.KeyPrefix = defaults.Cache.KeyPrefix
+	}
+	if cfg.GapReport.Schedule == "" {
+		cfg.GapReport.Schedule = defaults.GapReport.Schedule
+	}
+	if cfg.GapReport.LookbackDays == 0 {
+		cfg.GapReport.LookbackDays = defaults.GapReport.LookbackDays
+	}
+	if cfg.GapReport.SimilarityThreshold == 0 {
+		cfg.GapReport.SimilarityThreshold = defaults.GapReport.SimilarityThreshold
+	}
+	if cfg.GapReport.MaxTopics == 0 {
+		cfg.GapReport.MaxTopics = defaults.GapReport.MaxTopics
+	}
+	if cfg.FAQ.Schedule == "" {
+		cfg.FAQ.Schedule = defaults.FAQ.Schedule
+	}
+	if cfg.FAQ.LookbackDays == 0 {
+		cfg.FAQ.LookbackDays = defaults.FAQ.LookbackDays
+	}
+	if cfg.FAQ.MinCount == 0 {
+		cfg.FAQ.MinCount = defaults.FAQ.MinCount
+	}
+	if cfg.FAQ.SimilarityThreshold == 0 {
+		cfg.FAQ.SimilarityThreshold = defaults.FAQ.SimilarityThreshold
+	}
+	if cfg.FAQ.MaxEntries == 0 {
+		cfg.FAQ.MaxEntries = defaults.FAQ.MaxEntries
+	}
+	if cfg.PendingDraft.TopK == 0 {
+		cfg.PendingDraft.TopK = defaults.PendingDraft.TopK
+	}
+	if cfg.PendingDraft.Threshold == 0 {
+		cfg.PendingDraft.Threshold = defaults.PendingDraft.Threshold
+	}
+	if cfg.Scan.Backend == "" {
+		cfg.Scan.Backend = defaults.Scan.Backend
+	}
+	if cfg.Scan.ClamAVAddress == "" {
+		cfg.Scan.ClamAVAddress = defaults.Scan.ClamAVAddress
+	}
+	if cfg.Scan.TimeoutSec == 0 {
+		cfg.Scan.TimeoutSec = defaults.Scan.TimeoutSec
+	}
+	if cfg.Refusal.Message == "" {
+		cfg.Refusal.Message = defaults.Refusal.Message
+	}
+	if cfg.Refusal.Threshold == 0 {
+		cfg.Refusal.Threshold = defaults.Refusal.Threshold
+	}
+	if cfg.Intent.Mode == "" {
+		cfg.Intent.Mode = defaults.Intent.Mode
+	}
+	if cfg.Intent.Threshold == 0 {
+		cfg.Intent.Threshold = defaults.Intent.Threshold
+	}
+	if cfg.RateLimit.QueryPerMinute == 0 {
+		cfg.RateLimit.QueryPerMinute = defaults.RateLimit.QueryPerMinute
+	}
+	if cfg.RateLimit.UploadPerMinute == 0 {
+		cfg.RateLimit.UploadPerMinute = defaults.RateLimit.UploadPerMinute
+	}
+	if cfg.RateLimit.AuthPerMinute == 0 {
+		cfg.RateLimit.AuthPerMinute = defaults.RateLimit.AuthPerMinute
+	}
+	if cfg.RateLimit.APIPerMinute == 0 {
+		cfg.RateLimit.APIPerMinute = defaults.RateLimit.APIPerMinute
+	}
+	if cfg.RateLimit.WidgetPerMinute == 0 {
+		cfg.RateLimit.WidgetPerMinute = defaults.RateLimit.WidgetPerMinute
+	}
+	if cfg.Admission.MaxInFlight == 0 {
+		cfg.Admission.MaxInFlight = defaults.Admission.MaxInFlight
+	}
+	if cfg.Admission.QueueLimit == 0 {
+		cfg.Admission.QueueLimit = defaults.Admission.QueueLimit
+	}
+	if cfg.Admission.QueueTimeoutSec == 0 {
+		cfg.Admission.QueueTimeoutSec = defaults.Admission.QueueTimeoutSec
+	}
+	if cfg.BodyLimit.DefaultKB == 0 {
+		cfg.BodyLimit.DefaultKB = defaults.BodyLimit.DefaultKB
+	}
+	if cfg.BodyLimit.QueryKB == 0 {
+		cfg.BodyLimit.QueryKB = defaults.BodyLimit.QueryKB
+	}
+	if cfg.BodyLimit.ConfigKB == 0 {
+		cfg.BodyLimit.ConfigKB = defaults.BodyLimit.ConfigKB
+	}
+	if cfg.ImageEmbed.Threshold == 0 {
+		cfg.ImageEmbed.Threshold = defaults.ImageEmbed.Threshold
+	}
+	if cfg.ImageEmbed.TopK == 0 {
+		cfg.ImageEmbed.TopK = defaults.ImageEmbed.TopK
+	}
 }
 
 
@@ -938,8 +3605,20 @@ func (cm *ConfigManager) encrypt(plaintext string) (string, error) {
 	return hex.EncodeToString(ciphertext), nil
 }
 
-// decrypt decrypts AES-256-GCM encrypted hex string.
+// decrypt decrypts AES-256-GCM encrypted hex string, with the previous key
+// when the current one fails during a key rotation.
 func (cm *ConfigManager) decrypt(ciphertextHex string) (string, error) {
+	plaintext, err := decryptWithKey(cm.encryptionKey, ciphertextHex)
+	if err != nil && cm.previousKey != nil {
+		if p, perr := decryptWithKey(cm.previousKey, ciphertextHex); perr == nil {
+			return p, nil
+		}
+	}
+	return plaintext, err
+}
+
+// decryptWithKey decrypts AES-256-GCM encrypted hex string with key.
+func decryptWithKey(key []byte, ciphertextHex string) (string, error) {
 	if ciphertextHex == "" {
 		return "", nil
 	}
@@ -947,7 +3626,7 @@ func (cm *ConfigManager) decrypt(ciphertextHex string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("hex decode: %w", err)
 	}
-	block, err := aes.NewCipher(cm.encryptionKey)
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", err
 	}
@@ -967,6 +3646,31 @@ func (cm *ConfigManager) decrypt(ciphertextHex string) (string, error) {
 	return string(plaintext), nil
 }
 
+// SigningKey derives a 32-byte HMAC key for the given purpose (e.g.
+// "password_reset") from the config encryption key, so signed tokens stay
+// valid across restarts without storing another secret.
+func (cm *ConfigManager) SigningKey(purpose string) []byte {
+	return deriveKey(cm.encryptionKey, purpose)
+}
+
+// DataKey derives a 32-byte encryption key for the given purpose (e.g.
+// "at_rest") from the config encryption key, kept apart from the signing
+// keys.
+func (cm *ConfigManager) DataKey(purpose string) []byte {
+	return cm.SigningKey("data:" + purpose)
+}
+
+// EncryptSecret encrypts a secret stored outside the config file, such as
+// a connector API token in the database, like the secrets in the file.
+func (cm *ConfigManager) EncryptSecret(value string) string {
+	return cm.encryptIfNeeded(value)
+}
+
+// DecryptSecret decrypts a value returned by EncryptSecret.
+func (cm *ConfigManager) DecryptSecret(value string) (string, error) {
+	return cm.decryptIfNeeded(value)
+}
+
 // encryptIfNeeded encrypts a value and adds the "enc:" prefix.
 // Empty strings are returned as-is.
 func (cm *ConfigManager) encryptIfNeeded(value string) string {
@@ -1010,7 +3714,6 @@ func getOrCreateEncryptionKey() ([]byte, error) {
 	}
 
 	// 2. Try to read from persistent key file
-	keyFile := "./data/encryption.key"
 	if data, err := os.ReadFile(keyFile); err == nil {
 		keyHex = strings.TrimSpace(string(data))
 		if key, err := hex.DecodeString(keyHex); err == nil && len(key) == 32 {
@@ -1031,7 +3734,7 @@ func getOrCreateEncryptionKey() ([]byte, error) {
 		return nil, fmt.Errorf("generate encryption key: %w", err)
 	}
 	keyHex = hex.EncodeToString(key)
-	os.MkdirAll("./data", 0700)
+	os.MkdirAll(filepath.Dir(keyFile), 0700)
 	if err := os.WriteFile(keyFile, []byte(keyHex+"\n"), 0600); err != nil {
 		return nil, fmt.Errorf("save encryption key: %w", err)
 	}
@@ -1075,6 +3778,33 @@ func ensureExecutable(path string) error {
 	return nil
 }
 
+// toStringList accepts a JSON string array or a comma-separated string and
+// returns the trimmed, non-empty items.
+func toStringList(val interface{}) ([]string, error) {
+	var items []string
+	switch v := val.(type) {
+	case string:
+		items = strings.Split(v, ",")
+	case []interface{}:
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, errors.New("expected string list")
+			}
+			items = append(items, s)
+		}
+	default:
+		return nil, errors.New("expected string list")
+	}
+	var list []string
+	for _, s := range items {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list, nil
+}
+
 func toFloat64(val interface{}) (float64, error) {
 	switch v := val.(type) {
 	case float64:
@@ -1112,3 +3842,22 @@ func toInt(val interface{}) (int, error) {
 		return 0, fmt.Errorf("expected numeric value, got %T", val)
 	}
 }
+
+// isSTTCode reports whether s looks like a language code ("zh", "en-US") or a
+// cloud region ID ("cn-shanghai"): a letter followed by letters, digits and
+// hyphens, at most 32 characters.
+func isSTTCode(s string) bool {
+	if len(s) < 2 || len(s) > 32 {
+		return false
+	}
+	for i, c := range s {
+		isLetter := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if i == 0 && !isLetter {
+			return false
+		}
+		if !isLetter && !(c >= '0' && c <= '9') && c != '-' {
+			return false
+		}
+	}
+	return true
+}
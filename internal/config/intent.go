@@ -0,0 +1,130 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Intent classification modes of IntentConfig.Mode.
+const (
+	IntentModeLLM       = "llm"       // ask the LLM (default)
+	IntentModeEmbedding = "embedding" // nearest example question by embedding
+	IntentModeOff       = "off"       // every question goes to the knowledge base
+)
+
+// Built-in intents. Questions classified as product are answered from the
+// knowledge base.
+const (
+	IntentGreeting   = "greeting"
+	IntentProduct    = "product"
+	IntentIrrelevant = "irrelevant"
+)
+
+// Limits of the custom intents.
+const (
+	maxCustomIntents      = 20
+	maxIntentExamples     = 20
+	maxIntentExampleRunes = 200
+	maxIntentTextRunes    = 2000
+)
+
+var intentNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+// IntentConfig controls the intent classification that runs before
+// retrieval: greetings get the product introduction, irrelevant questions a
+// polite refusal, custom intents their own answer, and everything else is
+// answered from the knowledge base. In embedding mode a question takes the
+// intent of its most similar example question when that is at least
+// Threshold similar, and is answered from the knowledge base otherwise;
+// this costs no LLM call, and the question's embedding is needed for the
+// search anyway. With SmallTalk set, greetings, thanks, goodbyes and
+// acknowledgements are answered from a built-in catalog before any of this,
+// matched by phrase lists and by embedding with the same Threshold.
+type IntentConfig struct {
+	Mode      string         `json:"mode"` // IntentModeLLM (default), IntentModeEmbedding or IntentModeOff
+	Threshold float64        `json:"threshold"`
+	Custom    []CustomIntent `json:"custom"`
+	SmallTalk bool           `json:"small_talk"`
+}
+
+// CustomIntent is an admin-defined intent. Questions classified as it are
+// answered with Answer, translated to the asker's language, instead of from
+// the knowledge base; with Webhook set they are also sent to the webhooks
+// subscribed to the query.intent event, e.g. to open a ticket. The LLM
+// classifier is given the Description and Examples; the embedding
+// classifier only the Examples.
+type CustomIntent struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Examples    []string `json:"examples"`
+	Answer      string   `json:"answer"`
+	Webhook     bool     `json:"webhook"`
+}
+
+// Find returns the custom intent called name, or nil.
+func (c IntentConfig) Find(name string) *CustomIntent {
+	for i := range c.Custom {
+		if c.Custom[i].Name == name {
+			return &c.Custom[i]
+		}
+	}
+	return nil
+}
+
+// parseCustomIntents decodes and checks the value of intent.custom: a JSON
+// array of CustomIntent objects.
+func parseCustomIntents(val interface{}) ([]CustomIntent, error) {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return nil, errors.New("expected list of intents")
+	}
+	var intents []CustomIntent
+	if err := json.Unmarshal(data, &intents); err != nil {
+		return nil, errors.New("expected list of intents")
+	}
+	if len(intents) > maxCustomIntents {
+		return nil, fmt.Errorf("too many custom intents (max %d)", maxCustomIntents)
+	}
+	seen := make(map[string]bool, len(intents))
+	for i := range intents {
+		in := &intents[i]
+		in.Name = strings.TrimSpace(in.Name)
+		if !intentNamePattern.MatchString(in.Name) {
+			return nil, fmt.Errorf("invalid intent name %q: use lowercase letters, digits and underscores (max 32)", in.Name)
+		}
+		if in.Name == IntentGreeting || in.Name == IntentProduct || in.Name == IntentIrrelevant || seen[in.Name] {
+			return nil, fmt.Errorf("intent name %q is reserved or duplicated", in.Name)
+		}
+		seen[in.Name] = true
+		in.Description = strings.TrimSpace(in.Description)
+		in.Answer = strings.TrimSpace(in.Answer)
+		if in.Answer == "" {
+			return nil, fmt.Errorf("intent %s: answer is required", in.Name)
+		}
+		if utf8.RuneCountInString(in.Description) > maxIntentTextRunes || utf8.RuneCountInString(in.Answer) > maxIntentTextRunes {
+			return nil, fmt.Errorf("intent %s: description and answer must be at most %d characters", in.Name, maxIntentTextRunes)
+		}
+		examples := in.Examples[:0]
+		for _, e := range in.Examples {
+			if e = strings.TrimSpace(e); e == "" {
+				continue
+			}
+			if utf8.RuneCountInString(e) > maxIntentExampleRunes {
+				return nil, fmt.Errorf("intent %s: example %q is too long (max %d characters)", in.Name, e, maxIntentExampleRunes)
+			}
+			examples = append(examples, e)
+		}
+		if len(examples) > maxIntentExamples {
+			return nil, fmt.Errorf("intent %s: too many examples (max %d)", in.Name, maxIntentExamples)
+		}
+		in.Examples = examples
+		if in.Description == "" && len(in.Examples) == 0 {
+			return nil, fmt.Errorf("intent %s: a description or example questions are required", in.Name)
+		}
+	}
+	return intents, nil
+}
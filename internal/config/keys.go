@@ -0,0 +1,194 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Key rotation replaces the encryption key. RotateKey keeps the replaced
+// key in the previous key file until FinishRotation, once everything
+// encrypted with it has been encrypted again; meanwhile values are
+// decrypted with either key, so a rotation interrupted at any point can be
+// resumed and the service still starts.
+
+// previousKeyEnvVar names the environment variable that can supply the
+// previous key instead of the previous key file.
+const previousKeyEnvVar = "ASKFLOW_ENCRYPTION_KEY_PREVIOUS"
+
+// keyFile holds the key when ASKFLOW_ENCRYPTION_KEY is not set;
+// previousKeyFile exists while a key rotation is unfinished.
+const (
+	keyFile         = "./data/encryption.key"
+	previousKeyFile = "./data/encryption.key.previous"
+)
+
+// ParseKey parses a hex-encoded 32-byte encryption key.
+func ParseKey(keyHex string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(keyHex))
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key hex: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// readPreviousKey returns the previous key of an unfinished rotation, or
+// nil when there is none.
+func readPreviousKey() ([]byte, error) {
+	if keyHex := os.Getenv(previousKeyEnvVar); keyHex != "" {
+		return ParseKey(keyHex)
+	}
+	data, err := os.ReadFile(previousKeyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseKey(string(data))
+}
+
+// KeyFromEnv reports whether the encryption key comes from the
+// ASKFLOW_ENCRYPTION_KEY environment variable rather than the key file.
+func KeyFromEnv() bool {
+	return os.Getenv(encryptionKeyEnvVar) != ""
+}
+
+// deriveKey derives a 32-byte key for purpose from a master key.
+func deriveKey(master []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte("askflow:" + purpose))
+	return mac.Sum(nil)
+}
+
+// RotationPending reports whether a key rotation is unfinished, so that
+// data may still be encrypted with the previous key.
+func (cm *ConfigManager) RotationPending() bool {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.previousKey != nil
+}
+
+// PreviousDataKey returns what DataKey returned before an unfinished key
+// rotation, or nil when there is none.
+func (cm *ConfigManager) PreviousDataKey(purpose string) []byte {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	if cm.previousKey == nil {
+		return nil
+	}
+	return deriveKey(cm.previousKey, "data:"+purpose)
+}
+
+// RotateKey replaces the encryption key with newKey and saves the config
+// encrypted with it. It first copies the config file and the key file to
+// <name>.<time>.bak, returning the copies, and keeps the current key as the
+// previous key. With the key in ASKFLOW_ENCRYPTION_KEY the key file is not
+// written, and the variable must be set to newKey before the next start.
+func (cm *ConfigManager) RotateKey(newKey []byte) ([]string, error) {
+	if len(newKey) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes for AES-256")
+	}
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if cm.config == nil {
+		return nil, errors.New("no config loaded")
+	}
+	if cm.previousKey != nil {
+		return nil, errors.New("a key rotation is unfinished")
+	}
+	if hmac.Equal(newKey, cm.encryptionKey) {
+		return nil, errors.New("the new key is the current key")
+	}
+
+	files := []string{cm.configPath}
+	if !KeyFromEnv() {
+		files = append(files, keyFile)
+	}
+	stamp := time.Now().Format("20060102-150405")
+	var backups []string
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return backups, err
+		}
+		backup := f + "." + stamp + ".bak"
+		if err := writeFileAtomic(backup, data, 0600); err != nil {
+			return backups, fmt.Errorf("back up %s: %w", f, err)
+		}
+		backups = append(backups, backup)
+	}
+
+	// The previous key is saved before anything is encrypted with the new
+	// one, so both decrypt wherever a crash leaves the files
+	old := cm.encryptionKey
+	if err := writeFileAtomic(previousKeyFile, []byte(hex.EncodeToString(old)+"\n"), 0600); err != nil {
+		return backups, fmt.Errorf("save previous key: %w", err)
+	}
+	if !KeyFromEnv() {
+		if err := writeFileAtomic(keyFile, []byte(hex.EncodeToString(newKey)+"\n"), 0600); err != nil {
+			return backups, fmt.Errorf("save encryption key: %w", err)
+		}
+	}
+	cm.encryptionKey, cm.previousKey = newKey, old
+	return backups, cm.saveLocked()
+}
+
+// FinishRotation ends a key rotation once everything else encrypted with
+// the previous key has been encrypted again: it saves the config with the
+// current key and removes the previous key file. A previous key from
+// ASKFLOW_ENCRYPTION_KEY_PREVIOUS has to be unset by the caller.
+func (cm *ConfigManager) FinishRotation() error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if cm.config != nil {
+		if err := cm.saveLocked(); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(previousKeyFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	cm.previousKey = nil
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over path,
+// so that path never holds a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,124 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"askflow/internal/parser"
+)
+
+// confluencePageSize is how many pages are listed per request.
+const confluencePageSize = 100
+
+// confluence reads the current pages of a Confluence Cloud space through
+// the REST API, authenticating with an account email and API token.
+type confluence struct {
+	client  *http.Client
+	baseURL string // site URL including /wiki
+	email   string
+	token   string
+	space   string
+}
+
+type confluenceContent struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	Version struct {
+		Number int       `json:"number"`
+		When   time.Time `json:"when"`
+	} `json:"version"`
+	Ancestors []struct {
+		ID    string `json:"id"`
+		Title string `json:"title"`
+	} `json:"ancestors"`
+	Body struct {
+		Storage struct {
+			Value string `json:"value"`
+		} `json:"storage"`
+	} `json:"body"`
+	Links struct {
+		WebUI string `json:"webui"`
+	} `json:"_links"`
+}
+
+func (c *confluence) list(ctx context.Context) ([]remotePage, error) {
+	var pages []remotePage
+	for start := 0; ; start += confluencePageSize {
+		q := url.Values{
+			"spaceKey": {c.space},
+			"type":     {"page"},
+			"status":   {"current"},
+			"expand":   {"version,ancestors"},
+			"limit":    {fmt.Sprint(confluencePageSize)},
+			"start":    {fmt.Sprint(start)},
+		}
+		var resp struct {
+			Results []confluenceContent `json:"results"`
+		}
+		if err := c.get(ctx, "/rest/api/content?"+q.Encode(), &resp); err != nil {
+			return nil, err
+		}
+		for _, r := range resp.Results {
+			p := remotePage{ID: r.ID, Title: r.Title, Modified: r.Version.When}
+			if r.Version.Number > 0 {
+				p.Version = "v" + strconv.Itoa(r.Version.Number)
+			}
+			for _, a := range r.Ancestors {
+				p.Path = append(p.Path, a.Title)
+			}
+			if n := len(r.Ancestors); n > 0 {
+				p.ParentID = r.Ancestors[n-1].ID
+			}
+			if r.Links.WebUI != "" {
+				p.URL = c.baseURL + r.Links.WebUI
+			}
+			pages = append(pages, p)
+		}
+		if len(resp.Results) < confluencePageSize {
+			return pages, nil
+		}
+	}
+}
+
+func (c *confluence) content(ctx context.Context, p remotePage) (string, error) {
+	var r confluenceContent
+	if err := c.get(ctx, "/rest/api/content/"+url.PathEscape(p.ID)+"?expand=body.storage", &r); err != nil {
+		return "", err
+	}
+	// The storage format is XHTML with Confluence macros, which parse as
+	// unknown elements whose text is kept
+	result, err := (&parser.DocumentParser{}).Parse([]byte(r.Body.Storage.Value), "html")
+	if err != nil {
+		return "", fmt.Errorf("parse page: %w", err)
+	}
+	return result.Text, nil
+}
+
+func (c *confluence) get(ctx context.Context, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("create Confluence request: %w", err)
+	}
+	req.SetBasicAuth(c.email, c.token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Confluence request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Confluence API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode Confluence response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,903 @@
+// Package connector syncs pages from Confluence Cloud, Notion, Git
+// repositories, Google Drive, SharePoint and S3 buckets into documents. Each
+// connector belongs to a product and names a Confluence space, a Notion page
+// tree, a folder of a Git branch, a set of Drive or SharePoint folders or a
+// bucket prefix; on its
+// cron schedule every page in scope is listed with its hierarchy and
+// modification time (or revision), pages changed since the last sync are
+// imported again, new pages are added and pages that disappeared are
+// deleted.
+package connector
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"askflow/internal/config"
+	"askflow/internal/cron"
+	"askflow/internal/jobs"
+	"askflow/internal/lease"
+	"askflow/internal/s3"
+)
+
+// Connector types.
+const (
+	TypeConfluence = "confluence"
+	TypeNotion     = "notion"
+	TypeGit        = "git"
+	// TypeGoogleDrive and TypeSharePoint are authorized through OAuth
+	// instead of an API token, see AuthURL.
+	TypeGoogleDrive = "gdrive"
+	TypeSharePoint  = "sharepoint"
+	TypeS3          = "s3"
+)
+
+const (
+	// maxFolders bounds the folders a Drive or SharePoint connector syncs.
+	maxFolders = 50
+	// maxFileSize skips Drive, SharePoint and S3 files too large to be
+	// ingested.
+	maxFileSize = 100 << 20
+)
+
+// DefaultSchedule is the schedule of connectors created without one.
+const DefaultSchedule = "0 * * * *"
+
+// pathSeparator joins the titles of a page's ancestors.
+const pathSeparator = " / "
+
+var (
+	// ErrNotFound is returned for unknown connectors.
+	ErrNotFound = errors.New("连接器不存在")
+	// ErrRunning is returned by Trigger while the connector is syncing.
+	ErrRunning = errors.New("连接器正在同步")
+)
+
+// Connector is a configured Confluence space, Notion page tree, Git
+// repository folder, set of Drive or SharePoint folders or S3 bucket
+// prefix. The API token (the refresh token of OAuth connectors, the secret
+// key of S3) is never returned; HasToken tells whether one is set. Scope
+// holds the folder IDs of OAuth connectors, separated by commas, and Email
+// the account that authorized them. S3 connectors keep the endpoint in
+// BaseURL, the access key ID in Email and "bucket/prefix" in Scope.
+type Connector struct {
+	ID         string    `json:"id"`
+	ProductID  string    `json:"product_id"`
+	Type       string    `json:"type"`
+	Name       string    `json:"name"`
+	BaseURL    string    `json:"base_url,omitempty"`
+	Email      string    `json:"email,omitempty"`
+	Scope      string    `json:"scope"`
+	Branch     string    `json:"branch,omitempty"`
+	Region     string    `json:"region,omitempty"`
+	Schedule   string    `json:"schedule"`
+	Enabled    bool      `json:"enabled"`
+	HasToken   bool      `json:"has_token"`
+	Running    bool      `json:"running"`
+	LastSyncAt time.Time `json:"last_sync_at,omitzero"`
+	LastError  string    `json:"last_error,omitempty"`
+	LastStats  SyncStats `json:"last_stats"`
+	NextRun    time.Time `json:"next_run,omitzero"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	token string
+}
+
+// Input holds the settings of a connector being created or updated. On
+// update an empty APIToken keeps the current token. OAuth connectors ignore
+// APIToken and Email, which are set by Authorize.
+type Input struct {
+	ProductID string `json:"product_id"`
+	Type      string `json:"type"`
+	Name      string `json:"name"`
+	BaseURL   string `json:"base_url"`
+	Email     string `json:"email"`
+	APIToken  string `json:"api_token"`
+	Scope     string `json:"scope"`
+	Branch    string `json:"branch"`
+	Region    string `json:"region"`
+	Schedule  string `json:"schedule"`
+	Enabled   *bool  `json:"enabled"`
+}
+
+// SyncStats counts the outcome of a sync by page.
+type SyncStats struct {
+	Added     int `json:"added"`
+	Updated   int `json:"updated"`
+	Deleted   int `json:"deleted"`
+	Unchanged int `json:"unchanged"`
+	Failed    int `json:"failed"`
+}
+
+// Page is a synced page and the document it was imported as. Path holds
+// the titles of its ancestors; Version is the version of the page the
+// document was built from, such as the Git commit SHA.
+type Page struct {
+	PageID     string    `json:"page_id"`
+	DocumentID string    `json:"document_id"`
+	Title      string    `json:"title"`
+	ParentID   string    `json:"parent_id,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	URL        string    `json:"url,omitempty"`
+	Version    string    `json:"version,omitempty"`
+	ModifiedAt time.Time `json:"modified_at,omitzero"`
+
+	revision string
+}
+
+// remotePage is a page listed by a source, without its content. Sources
+// that can tell changes exactly set Revision, which is then compared
+// instead of Modified. ProductID, when set, overrides the connector's
+// product for the page's document.
+type remotePage struct {
+	ID        string
+	ProductID string
+	Title     string
+	ParentID  string
+	Path      []string
+	URL       string
+	Modified  time.Time
+	Revision  string
+	Version   string
+}
+
+// source lists the pages of a connector's scope and reads their text.
+type source interface {
+	list(ctx context.Context) ([]remotePage, error)
+	content(ctx context.Context, p remotePage) (string, error)
+}
+
+// Documents stores and removes the documents pages are imported as.
+type Documents interface {
+	ChunkEmbedStore(ctx context.Context, docID, docName, text, productID string) error
+	DeleteDocument(docID string) error
+}
+
+// Secrets encrypts API tokens at rest.
+type Secrets interface {
+	EncryptSecret(value string) string
+	DecryptSecret(value string) (string, error)
+}
+
+// Service manages connectors and runs their syncs. Schedules are checked
+// every minute; a connector syncs at most once at a time.
+type Service struct {
+	readDB     *sql.DB
+	writeDB    *sql.DB
+	docs       Documents
+	secrets    Secrets
+	httpClient *http.Client
+	workDir    string // Git working copies, one folder per connector
+	oauthApps  func() config.ConnectorsConfig
+	locks      *lease.Manager
+	jobs       *jobs.Registry
+
+	mu      sync.Mutex
+	running map[string]bool
+	rerun   map[string]bool // notified while running, synced again after
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewService creates a connector Service. Git repositories are checked out
+// under workDir.
+func NewService(readDB, writeDB *sql.DB, docs Documents, secrets Secrets, workDir string) *Service {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Service{
+		readDB:     readDB,
+		writeDB:    writeDB,
+		docs:       docs,
+		secrets:    secrets,
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+		workDir:    workDir,
+		running:    make(map[string]bool),
+		rerun:      make(map[string]bool),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// SetOAuthApps sets where the Google and Microsoft app registrations of
+// OAuth connectors are read from. It is read on each use, so changes to
+// the configuration apply without a restart.
+func (s *Service) SetOAuthApps(apps func() config.ConnectorsConfig) {
+	s.oauthApps = apps
+}
+
+// SetLocks makes each sync take the "connector:<id>" lease first, so that
+// instances sharing the database do not sync a connector at the same time
+// or twice for one scheduled time. Call it before Start.
+func (s *Service) SetLocks(m *lease.Manager) {
+	s.locks = m
+}
+
+// jobParams are the params of connector sync jobs.
+type jobParams struct {
+	ConnectorID string `json:"connector_id"`
+}
+
+// SetJobs records syncs as jobs in r, which can be canceled and are
+// retried by syncing the connector again. Call it before Start.
+func (s *Service) SetJobs(r *jobs.Registry) {
+	s.jobs = r
+	r.OnRetry(jobs.KindConnector, func(params json.RawMessage) error {
+		var p jobParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return fmt.Errorf("invalid job params: %w", err)
+		}
+		return s.Trigger(p.ConnectorID)
+	})
+}
+
+// Start launches the scheduling loop.
+func (s *Service) Start() {
+	s.wg.Add(1)
+	go s.loop()
+}
+
+// Stop ends the scheduling loop, cancels running syncs and waits until ctx
+// is done for them to return.
+func (s *Service) Stop(ctx context.Context) error {
+	s.stopOnce.Do(s.cancel)
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Service) loop() {
+	defer s.wg.Done()
+	for {
+		next := time.Now().Truncate(time.Minute).Add(time.Minute)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		connectors, err := s.list(`WHERE enabled = 1`)
+		if err != nil {
+			log.Printf("[Connector] list error: %v", err)
+			continue
+		}
+		for _, c := range connectors {
+			sched, err := cron.Parse(c.Schedule)
+			if err != nil || !sched.Match(next) {
+				continue
+			}
+			if err := s.trigger(c.ID, next); err != nil && !errors.Is(err, ErrRunning) && !errors.Is(err, lease.ErrTaken) {
+				log.Printf("[Connector] scheduled sync of %s skipped: %v", c.ID, err)
+			}
+		}
+	}
+}
+
+// List returns the connectors of productID, or all connectors when
+// productID is empty.
+func (s *Service) List(productID string) ([]Connector, error) {
+	if productID == "" {
+		return s.list("")
+	}
+	return s.list(`WHERE product_id = ?`, productID)
+}
+
+// Get returns a connector.
+func (s *Service) Get(id string) (*Connector, error) {
+	cs, err := s.list(`WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(cs) == 0 {
+		return nil, ErrNotFound
+	}
+	return &cs[0], nil
+}
+
+func (s *Service) list(where string, args ...interface{}) ([]Connector, error) {
+	rows, err := s.readDB.Query(
+		`SELECT id, product_id, type, name, base_url, email, api_token, scope, branch, region, schedule, enabled,
+		        last_sync_at, last_error, last_stats, created_at
+		 FROM connectors `+where+` ORDER BY created_at`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list connectors: %w", err)
+	}
+	defer rows.Close()
+	var out []Connector
+	for rows.Next() {
+		var c Connector
+		var lastSync sql.NullTime
+		var stats string
+		if err := rows.Scan(&c.ID, &c.ProductID, &c.Type, &c.Name, &c.BaseURL, &c.Email, &c.token, &c.Scope,
+			&c.Branch, &c.Region, &c.Schedule, &c.Enabled, &lastSync, &c.LastError, &stats, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		c.HasToken = c.token != ""
+		c.LastSyncAt = lastSync.Time
+		json.Unmarshal([]byte(stats), &c.LastStats)
+		if sched, err := cron.Parse(c.Schedule); err == nil && c.Enabled {
+			c.NextRun = sched.Next(time.Now())
+		}
+		out = append(out, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	for i := range out {
+		out[i].Running = s.running[out[i].ID]
+	}
+	s.mu.Unlock()
+	return out, nil
+}
+
+// Create adds a connector.
+func (s *Service) Create(in Input) (*Connector, error) {
+	if in.Schedule == "" {
+		in.Schedule = DefaultSchedule
+	}
+	if err := validate(&in, true); err != nil {
+		return nil, err
+	}
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	enabled := in.Enabled == nil || *in.Enabled
+	_, err = s.writeDB.Exec(
+		`INSERT INTO connectors (id, product_id, type, name, base_url, email, api_token, scope, branch, region, schedule, enabled, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, in.ProductID, in.Type, in.Name, in.BaseURL, in.Email, s.secrets.EncryptSecret(in.APIToken),
+		in.Scope, in.Branch, in.Region, in.Schedule, enabled, time.Now().UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connector: %w", err)
+	}
+	return s.Get(id)
+}
+
+// Update changes a connector's settings. Its type and product stay fixed,
+// as the documents already synced belong to them.
+func (s *Service) Update(id string, in Input) (*Connector, error) {
+	c, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	in.Type, in.ProductID = c.Type, c.ProductID
+	if in.Schedule == "" {
+		in.Schedule = c.Schedule
+	}
+	if err := validate(&in, false); err != nil {
+		return nil, err
+	}
+	enabled := c.Enabled
+	if in.Enabled != nil {
+		enabled = *in.Enabled
+	}
+	token := c.token
+	if isOAuthType(c.Type) {
+		in.Email = c.Email
+	} else if in.APIToken != "" {
+		token = s.secrets.EncryptSecret(in.APIToken)
+	}
+	if _, err := s.writeDB.Exec(
+		`UPDATE connectors SET name = ?, base_url = ?, email = ?, api_token = ?, scope = ?, branch = ?, region = ?, schedule = ?, enabled = ? WHERE id = ?`,
+		in.Name, in.BaseURL, in.Email, token, in.Scope, in.Branch, in.Region, in.Schedule, enabled, id,
+	); err != nil {
+		return nil, fmt.Errorf("failed to update connector: %w", err)
+	}
+	return s.Get(id)
+}
+
+// RotateSecrets encrypts the stored API tokens again with the current key,
+// after a key rotation, and returns how many it rewrote.
+func (s *Service) RotateSecrets() (int, error) {
+	rows, err := s.readDB.Query(`SELECT id, api_token FROM connectors WHERE api_token != ''`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list connectors: %w", err)
+	}
+	tokens := make(map[string]string)
+	for rows.Next() {
+		var id, token string
+		if err := rows.Scan(&id, &token); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		tokens[id] = token
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	tx, err := s.writeDB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	for id, token := range tokens {
+		plain, err := s.secrets.DecryptSecret(token)
+		if err != nil {
+			return 0, fmt.Errorf("decrypt token of connector %s: %w", id, err)
+		}
+		if _, err := tx.Exec(`UPDATE connectors SET api_token = ? WHERE id = ?`, s.secrets.EncryptSecret(plain), id); err != nil {
+			return 0, fmt.Errorf("failed to update connector %s: %w", id, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(tokens), nil
+}
+
+// Delete removes a connector and the documents it synced.
+func (s *Service) Delete(id string) error {
+	s.mu.Lock()
+	running := s.running[id]
+	s.mu.Unlock()
+	if running {
+		return ErrRunning
+	}
+	pages, err := s.Pages(id)
+	if err != nil {
+		return err
+	}
+	for _, p := range pages {
+		if err := s.docs.DeleteDocument(p.DocumentID); err != nil {
+			log.Printf("[Connector] delete document %s of %s: %v", p.DocumentID, id, err)
+		}
+	}
+	if _, err := s.writeDB.Exec(`DELETE FROM connector_pages WHERE connector_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete connector pages: %w", err)
+	}
+	result, err := s.writeDB.Exec(`DELETE FROM connectors WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete connector: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	if err := os.RemoveAll(s.checkoutDir(id)); err != nil {
+		log.Printf("[Connector] remove working copy of %s: %v", id, err)
+	}
+	return nil
+}
+
+// Pages returns the pages a connector synced, in hierarchy order.
+func (s *Service) Pages(id string) ([]Page, error) {
+	rows, err := s.readDB.Query(
+		`SELECT page_id, document_id, title, parent_id, path, url, version, revision, modified_at
+		 FROM connector_pages WHERE connector_id = ? ORDER BY path, title`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list connector pages: %w", err)
+	}
+	defer rows.Close()
+	pages := []Page{}
+	for rows.Next() {
+		var p Page
+		var modified sql.NullTime
+		if err := rows.Scan(&p.PageID, &p.DocumentID, &p.Title, &p.ParentID, &p.Path, &p.URL, &p.Version, &p.revision, &modified); err != nil {
+			return nil, err
+		}
+		p.ModifiedAt = modified.Time
+		pages = append(pages, p)
+	}
+	return pages, rows.Err()
+}
+
+// Trigger starts syncing a connector in the background.
+func (s *Service) Trigger(id string) error {
+	return s.trigger(id, time.Time{})
+}
+
+// trigger is Trigger for the sync scheduled at slot, zero for syncs started
+// on demand. The sync holds the "connector:<id>" lease.
+func (s *Service) trigger(id string, slot time.Time) error {
+	c, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[id] {
+		return ErrRunning
+	}
+	if s.ctx.Err() != nil {
+		return errors.New("connector service stopped")
+	}
+	l, err := s.locks.Acquire("connector:"+id, slot)
+	if err != nil {
+		return err
+	}
+	s.running[id] = true
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ctx, cancel := context.WithCancel(s.ctx)
+		defer cancel()
+		job := s.jobs.Begin(jobs.KindConnector, c.Name, jobParams{ConnectorID: id}, cancel)
+		defer func() {
+			l.Release()
+			if r := recover(); r != nil {
+				log.Printf("[Connector] panic syncing %s: %v", id, r)
+				s.recordSync(id, SyncStats{}, fmt.Errorf("panic: %v", r))
+				job.Finish(fmt.Errorf("panic: %v", r))
+			}
+			s.mu.Lock()
+			delete(s.running, id)
+			rerun := s.rerun[id]
+			delete(s.rerun, id)
+			s.mu.Unlock()
+			if rerun {
+				if err := s.Trigger(id); err != nil {
+					log.Printf("[Connector] rerun of %s skipped: %v", id, err)
+				}
+			}
+		}()
+		stats, err := s.sync(ctx, c, job)
+		if err != nil {
+			log.Printf("[Connector] sync of %s (%s) failed: %v", c.Name, id, err)
+		} else {
+			log.Printf("[Connector] synced %s (%s): %+v", c.Name, id, stats)
+		}
+		s.recordSync(id, stats, err)
+		job.Finish(err)
+	}()
+	return nil
+}
+
+// Notify starts syncing a connector because its source reported a change.
+// Changes reported while it syncs start another sync once it finishes, so
+// none are missed.
+func (s *Service) Notify(id string) error {
+	err := s.Trigger(id)
+	if errors.Is(err, ErrRunning) {
+		s.mu.Lock()
+		if s.running[id] {
+			s.rerun[id] = true
+		}
+		s.mu.Unlock()
+		return nil
+	}
+	return err
+}
+
+func (s *Service) recordSync(id string, stats SyncStats, syncErr error) {
+	errMsg := ""
+	if syncErr != nil {
+		errMsg = syncErr.Error()
+	}
+	data, _ := json.Marshal(stats)
+	if _, err := s.writeDB.Exec(`UPDATE connectors SET last_sync_at = ?, last_error = ?, last_stats = ? WHERE id = ?`,
+		time.Now().UTC(), errMsg, string(data), id); err != nil {
+		log.Printf("[Connector] failed to record sync of %s: %v", id, err)
+	}
+}
+
+// sync brings the documents of a connector up to date with its source.
+// Pages that fail are counted and retried on the next sync; only a failure
+// to list the pages fails the sync, before anything is deleted. Progress is
+// reported to job.
+func (s *Service) sync(ctx context.Context, c *Connector, job *jobs.Handle) (SyncStats, error) {
+	var stats SyncStats
+	src, err := s.source(c)
+	if err != nil {
+		return stats, err
+	}
+	remote, err := src.list(ctx)
+	if err != nil {
+		return stats, err
+	}
+	local, err := s.Pages(c.ID)
+	if err != nil {
+		return stats, err
+	}
+	known := make(map[string]Page, len(local))
+	for _, p := range local {
+		known[p.PageID] = p
+	}
+
+	seen := make(map[string]bool, len(remote))
+	for i, rp := range remote {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		job.Progress(i*100/len(remote), fmt.Sprintf("page %d/%d", i+1, len(remote)))
+		seen[rp.ID] = true
+		old, exists := known[rp.ID]
+		path := strings.Join(rp.Path, pathSeparator)
+		if exists && !old.ModifiedAt.IsZero() && unchanged(old, rp) && old.Title == rp.Title && old.Path == path {
+			stats.Unchanged++
+			continue
+		}
+		docID := old.DocumentID
+		if !exists {
+			if docID, err = generateID(); err != nil {
+				return stats, err
+			}
+		}
+		if err := s.importPage(ctx, c, src, rp, docID, exists); err != nil {
+			log.Printf("[Connector] %s: page %q (%s) failed: %v", c.Name, rp.Title, rp.ID, err)
+			stats.Failed++
+			continue
+		}
+		if exists {
+			stats.Updated++
+		} else {
+			stats.Added++
+		}
+	}
+
+	for _, p := range local {
+		if seen[p.PageID] {
+			continue
+		}
+		if err := s.docs.DeleteDocument(p.DocumentID); err != nil {
+			log.Printf("[Connector] %s: delete document %s: %v", c.Name, p.DocumentID, err)
+			stats.Failed++
+			continue
+		}
+		s.writeDB.Exec(`DELETE FROM connector_pages WHERE connector_id = ? AND page_id = ?`, c.ID, p.PageID)
+		stats.Deleted++
+	}
+	return stats, nil
+}
+
+// importPage (re)builds the document of a page. The page row is written
+// first without a modification time, so a failed import is retried.
+func (s *Service) importPage(ctx context.Context, c *Connector, src source, rp remotePage, docID string, exists bool) error {
+	path := strings.Join(rp.Path, pathSeparator)
+	if _, err := s.writeDB.Exec(
+		`INSERT INTO connector_pages (connector_id, page_id, document_id, title, parent_id, path, url, version, revision, modified_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, NULL)
+		 ON CONFLICT(connector_id, page_id) DO UPDATE SET title = excluded.title, parent_id = excluded.parent_id,
+		   path = excluded.path, url = excluded.url, version = excluded.version, revision = excluded.revision, modified_at = NULL`,
+		c.ID, rp.ID, docID, rp.Title, rp.ParentID, path, rp.URL, rp.Version, rp.Revision,
+	); err != nil {
+		return fmt.Errorf("failed to save page: %w", err)
+	}
+
+	text, err := src.content(ctx, rp)
+	if err != nil {
+		return err
+	}
+	productID := c.ProductID
+	if rp.ProductID != "" {
+		productID = rp.ProductID
+	}
+	if exists {
+		if err := s.docs.DeleteDocument(docID); err != nil {
+			return fmt.Errorf("failed to remove old version: %w", err)
+		}
+	}
+	if _, err := s.writeDB.Exec(
+		`INSERT INTO documents (id, name, type, status, product_id, source_version, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		docID, rp.Title, c.Type, "processing", productID, rp.Version, time.Now().UTC(),
+	); err != nil {
+		return fmt.Errorf("failed to save document: %w", err)
+	}
+
+	// The hierarchy goes into the text, so chunks carry where the page sits
+	var b strings.Builder
+	b.WriteString("# " + rp.Title + "\n")
+	if path != "" {
+		b.WriteString("位置：" + path + pathSeparator + rp.Title + "\n")
+	}
+	if rp.URL != "" {
+		b.WriteString("来源：" + rp.URL + "\n")
+	}
+	if rp.Version != "" {
+		b.WriteString("版本：" + rp.Version + "\n")
+	}
+	b.WriteString("\n" + text)
+	if err := s.docs.ChunkEmbedStore(ctx, docID, rp.Title, b.String(), productID); err != nil {
+		s.writeDB.Exec(`UPDATE documents SET status = 'failed', error = ? WHERE id = ?`, err.Error(), docID)
+		return err
+	}
+	if _, err := s.writeDB.Exec(`UPDATE documents SET status = 'success', error = '' WHERE id = ?`, docID); err != nil {
+		return fmt.Errorf("failed to update document: %w", err)
+	}
+	_, err = s.writeDB.Exec(`UPDATE connector_pages SET modified_at = ? WHERE connector_id = ? AND page_id = ?`,
+		rp.Modified.UTC(), c.ID, rp.ID)
+	return err
+}
+
+// source returns the client of a connector's service.
+func (s *Service) source(c *Connector) (source, error) {
+	if isOAuthType(c.Type) {
+		tok, err := s.oauthToken(c)
+		if err != nil {
+			return nil, err
+		}
+		if c.Type == TypeGoogleDrive {
+			return &googleDrive{client: s.httpClient, token: tok, roots: splitFolders(c.Scope)}, nil
+		}
+		return &sharePoint{client: s.httpClient, token: tok, roots: splitFolders(c.Scope)}, nil
+	}
+	if c.Type == TypeS3 {
+		secret, err := s.secrets.DecryptSecret(c.token)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt secret key: %w", err)
+		}
+		bucket, prefix := splitBucket(c.Scope)
+		b := &s3Bucket{
+			client: &s3.Client{
+				Endpoint:  c.BaseURL,
+				Region:    c.Region,
+				Bucket:    bucket,
+				AccessKey: c.Email,
+				SecretKey: secret,
+				// Self-hosted stores such as MinIO seldom resolve
+				// bucket subdomains
+				PathStyle: c.BaseURL != "",
+			},
+			prefix: prefix,
+		}
+		if c.ProductID == "" {
+			b.products = s.productLookup()
+		}
+		return b, nil
+	}
+	token, err := s.secrets.DecryptSecret(c.token)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt API token: %w", err)
+	}
+	switch c.Type {
+	case TypeConfluence:
+		return &confluence{client: s.httpClient, baseURL: c.BaseURL, email: c.Email, token: token, space: c.Scope}, nil
+	case TypeNotion:
+		return &notion{client: s.httpClient, token: token, root: normalizeNotionID(c.Scope)}, nil
+	case TypeGit:
+		return &gitRepo{dir: s.checkoutDir(c.ID), url: c.BaseURL, branch: c.Branch, user: c.Email, token: token, folder: c.Scope}, nil
+	default:
+		return nil, fmt.Errorf("unknown connector type %q", c.Type)
+	}
+}
+
+// checkoutDir is the Git working copy of a connector.
+func (s *Service) checkoutDir(id string) string {
+	return filepath.Join(s.workDir, id)
+}
+
+// unchanged reports whether a listed page is the version old was imported
+// from.
+func unchanged(old Page, rp remotePage) bool {
+	if rp.Revision != "" {
+		return rp.Revision == old.revision
+	}
+	return !rp.Modified.After(old.ModifiedAt)
+}
+
+// validate checks and normalizes connector settings. The token is required
+// when creating, except for Git repositories, which may be public, and OAuth
+// connectors, which are authorized afterwards.
+func validate(in *Input, create bool) error {
+	in.Name = strings.TrimSpace(in.Name)
+	in.BaseURL = strings.TrimRight(strings.TrimSpace(in.BaseURL), "/")
+	in.Email = strings.TrimSpace(in.Email)
+	in.Scope = strings.TrimSpace(in.Scope)
+	in.Branch = strings.TrimSpace(in.Branch)
+	in.Region = strings.TrimSpace(in.Region)
+	in.Schedule = strings.TrimSpace(in.Schedule)
+	if in.Name == "" || len(in.Name) > 200 {
+		return errors.New("连接器名称不能为空且不超过200个字符")
+	}
+	if isOAuthType(in.Type) {
+		in.APIToken = ""
+	}
+	if create && in.APIToken == "" && in.Type != TypeGit && !isOAuthType(in.Type) {
+		return errors.New("API 令牌不能为空")
+	}
+	if len(in.APIToken) > 2000 {
+		return errors.New("API 令牌过长")
+	}
+	if _, err := cron.Parse(in.Schedule); err != nil {
+		return fmt.Errorf("同步计划无效: %s", err)
+	}
+	if in.Type != TypeS3 {
+		in.Region = ""
+	}
+	switch in.Type {
+	case TypeConfluence:
+		in.Branch = ""
+		u, err := url.Parse(in.BaseURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" || len(in.BaseURL) > 500 {
+			return errors.New("Confluence 地址须为 https URL，如 https://example.atlassian.net/wiki")
+		}
+		if in.Email == "" {
+			return errors.New("Confluence 账号邮箱不能为空")
+		}
+		if in.Scope == "" || len(in.Scope) > 255 {
+			return errors.New("Confluence 空间标识不能为空")
+		}
+	case TypeNotion:
+		in.BaseURL, in.Email, in.Branch = "", "", ""
+		if in.Scope != "" && normalizeNotionID(in.Scope) == "" {
+			return errors.New("Notion 根页面 ID 无效")
+		}
+	case TypeGit:
+		u, err := url.Parse(in.BaseURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil || len(in.BaseURL) > 500 {
+			return errors.New("Git 仓库地址须为 https URL，如 https://github.com/example/docs.git")
+		}
+		if !validBranch(in.Branch) {
+			return errors.New("Git 分支名无效")
+		}
+		folder, ok := cleanFolder(in.Scope)
+		if !ok {
+			return errors.New("Git 目录路径无效")
+		}
+		in.Scope = folder
+	case TypeS3:
+		in.Branch = ""
+		if in.BaseURL != "" {
+			u, err := url.Parse(in.BaseURL)
+			if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.User != nil || len(in.BaseURL) > 500 {
+				return errors.New("S3 服务地址须为 http(s) URL，AWS S3 可留空")
+			}
+		}
+		if in.Email == "" || len(in.Email) > 200 {
+			return errors.New("S3 访问密钥 ID 不能为空")
+		}
+		bucket, prefix := splitBucket(in.Scope)
+		if !validBucket(bucket) || len(prefix) > 500 {
+			return errors.New("S3 范围须为「存储桶」或「存储桶/前缀」")
+		}
+		in.Scope = strings.TrimSuffix(bucket+"/"+prefix, "/")
+		for _, c := range in.Region {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return errors.New("S3 区域无效")
+			}
+		}
+	case TypeGoogleDrive, TypeSharePoint:
+		in.BaseURL, in.Branch = "", ""
+		if create {
+			in.Email = ""
+		}
+		folders := splitFolders(in.Scope)
+		if len(folders) > maxFolders {
+			return fmt.Errorf("最多同步 %d 个文件夹", maxFolders)
+		}
+		for _, f := range folders {
+			if !validFolderRef(in.Type, f) {
+				return ErrInvalidFolder
+			}
+		}
+		in.Scope = strings.Join(folders, ",")
+	default:
+		return errors.New("连接器类型须为 confluence、notion、git、gdrive、sharepoint 或 s3")
+	}
+	return nil
+}
+
+func generateID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,222 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"askflow/internal/parser"
+)
+
+const (
+	googleDriveAPI  = "https://www.googleapis.com/drive/v3"
+	driveFolderMIME = "application/vnd.google-apps.folder"
+	// driveMaxDepth bounds how deeply nested folders are walked.
+	driveMaxDepth = 32
+)
+
+// googleExports maps Google Docs, Sheets and Slides, which have no file
+// content of their own, to the Office format they are exported as.
+var googleExports = map[string]struct{ mime, fileType string }{
+	"application/vnd.google-apps.document":     {"application/vnd.openxmlformats-officedocument.wordprocessingml.document", "word"},
+	"application/vnd.google-apps.spreadsheet":  {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "excel"},
+	"application/vnd.google-apps.presentation": {"application/vnd.openxmlformats-officedocument.presentationml.presentation", "ppt"},
+}
+
+// googleDrive reads the Office files under a set of Google Drive folders,
+// shared drives included, through the Drive API. Each file is a page whose
+// revision is the file's version, and whose path is the folders it sits in.
+type googleDrive struct {
+	client *http.Client
+	token  *accessToken
+	roots  []string // folder IDs
+
+	files map[string]driveFile // listed files by ID, read by content
+}
+
+type driveFile struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	MimeType     string    `json:"mimeType"`
+	ModifiedTime time.Time `json:"modifiedTime"`
+	Version      string    `json:"version"`
+	WebViewLink  string    `json:"webViewLink"`
+	Size         string    `json:"size"`
+}
+
+func (g *googleDrive) list(ctx context.Context) ([]remotePage, error) {
+	if len(g.roots) == 0 {
+		return nil, errNoFolders
+	}
+	g.files = make(map[string]driveFile)
+	type folder struct {
+		id   string
+		path []string
+	}
+	var pages []remotePage
+	visited := make(map[string]bool)
+	for _, root := range g.roots {
+		var meta driveFile
+		if err := g.get(ctx, "/files/"+url.PathEscape(root)+"?fields=name&supportsAllDrives=true", &meta); err != nil {
+			return nil, fmt.Errorf("folder %s: %w", root, err)
+		}
+		queue := []folder{{id: root, path: []string{meta.Name}}}
+		for len(queue) > 0 {
+			f := queue[0]
+			queue = queue[1:]
+			if visited[f.id] {
+				continue // folders picked both on their own and through a parent
+			}
+			visited[f.id] = true
+			children, err := g.children(ctx, f.id, "")
+			if err != nil {
+				return nil, err
+			}
+			for _, c := range children {
+				if c.MimeType == driveFolderMIME {
+					if len(f.path) < driveMaxDepth {
+						queue = append(queue, folder{id: c.ID, path: append(append([]string{}, f.path...), c.Name)})
+					}
+					continue
+				}
+				if driveFileType(c) == "" || g.files[c.ID].ID != "" {
+					continue
+				}
+				if size, _ := strconv.ParseInt(c.Size, 10, 64); size > maxFileSize {
+					continue
+				}
+				g.files[c.ID] = c
+				pages = append(pages, remotePage{
+					ID:       c.ID,
+					Title:    c.Name,
+					ParentID: f.id,
+					Path:     f.path,
+					URL:      c.WebViewLink,
+					Modified: c.ModifiedTime,
+					Revision: c.Version,
+				})
+			}
+		}
+	}
+	return pages, nil
+}
+
+func (g *googleDrive) content(ctx context.Context, p remotePage) (string, error) {
+	f, ok := g.files[p.ID]
+	if !ok {
+		return "", fmt.Errorf("file %s was not listed", p.ID)
+	}
+	token, err := g.token.get(ctx)
+	if err != nil {
+		return "", err
+	}
+	endpoint := googleDriveAPI + "/files/" + url.PathEscape(f.ID) + "?alt=media&supportsAllDrives=true"
+	if export, ok := googleExports[f.MimeType]; ok {
+		endpoint = googleDriveAPI + "/files/" + url.PathEscape(f.ID) + "/export?mimeType=" + url.QueryEscape(export.mime)
+	}
+	data, err := bearerDownload(ctx, g.client, token, endpoint)
+	if err != nil {
+		return "", err
+	}
+	result, err := (&parser.DocumentParser{}).Parse(data, driveFileType(f))
+	if err != nil {
+		return "", fmt.Errorf("parse %s: %w", f.Name, err)
+	}
+	return result.Text, nil
+}
+
+// folders lists the folders under parent; the top level is My Drive and the
+// shared drives the account can see.
+func (g *googleDrive) folders(ctx context.Context, parent string) ([]Folder, error) {
+	if parent == "" {
+		out := []Folder{{ID: "root", Name: "我的云端硬盘", Selectable: true}}
+		pageToken := ""
+		for {
+			var resp struct {
+				Drives []struct {
+					ID   string `json:"id"`
+					Name string `json:"name"`
+				} `json:"drives"`
+				NextPageToken string `json:"nextPageToken"`
+			}
+			if err := g.get(ctx, "/drives?pageSize=100&pageToken="+url.QueryEscape(pageToken), &resp); err != nil {
+				return nil, err
+			}
+			for _, d := range resp.Drives {
+				out = append(out, Folder{ID: d.ID, Name: d.Name, Selectable: true})
+			}
+			if pageToken = resp.NextPageToken; pageToken == "" {
+				return out, nil
+			}
+		}
+	}
+	if !validFolderRef(TypeGoogleDrive, parent) {
+		return nil, ErrInvalidFolder
+	}
+	children, err := g.children(ctx, parent, " and mimeType = '"+driveFolderMIME+"'")
+	if err != nil {
+		return nil, err
+	}
+	out := []Folder{}
+	for _, c := range children {
+		out = append(out, Folder{ID: c.ID, Name: c.Name, Selectable: true})
+	}
+	return out, nil
+}
+
+// children lists the items of a folder matching an extra query clause.
+func (g *googleDrive) children(ctx context.Context, folderID, clause string) ([]driveFile, error) {
+	var out []driveFile
+	pageToken := ""
+	for {
+		q := url.Values{
+			// Folder IDs are validated to hold no quotes
+			"q":                         {"'" + folderID + "' in parents and trashed = false" + clause},
+			"fields":                    {"nextPageToken,files(id,name,mimeType,modifiedTime,version,webViewLink,size)"},
+			"pageSize":                  {"1000"},
+			"orderBy":                   {"name"},
+			"corpora":                   {"allDrives"},
+			"supportsAllDrives":         {"true"},
+			"includeItemsFromAllDrives": {"true"},
+		}
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+		var resp struct {
+			Files         []driveFile `json:"files"`
+			NextPageToken string      `json:"nextPageToken"`
+		}
+		if err := g.get(ctx, "/files?"+q.Encode(), &resp); err != nil {
+			return nil, err
+		}
+		out = append(out, resp.Files...)
+		if pageToken = resp.NextPageToken; pageToken == "" {
+			return out, nil
+		}
+	}
+}
+
+func (g *googleDrive) get(ctx context.Context, path string, out interface{}) error {
+	token, err := g.token.get(ctx)
+	if err != nil {
+		return err
+	}
+	if err := bearerGet(ctx, g.client, token, googleDriveAPI+path, out); err != nil {
+		return fmt.Errorf("Google Drive: %w", err)
+	}
+	return nil
+}
+
+// driveFileType returns the parser type of a Drive file, or "" for files
+// that are not synced.
+func driveFileType(f driveFile) string {
+	if export, ok := googleExports[f.MimeType]; ok {
+		return export.fileType
+	}
+	return driveFileTypes[strings.ToLower(path.Ext(f.Name))]
+}
@@ -0,0 +1,220 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"askflow/internal/parser"
+)
+
+const (
+	// gitTimeout bounds one git command, a fetch of a large repository
+	// included.
+	gitTimeout = 10 * time.Minute
+	// gitMaxFileSize skips files too large to be documentation.
+	gitMaxFileSize = 20 << 20
+)
+
+// gitFileTypes maps the extensions synced from a repository to parser
+// types.
+var gitFileTypes = map[string]string{
+	".md":       "markdown",
+	".markdown": "markdown",
+	".html":     "html",
+	".htm":      "html",
+}
+
+// gitRepo reads the Markdown and HTML files under a folder of a branch.
+// The repository is fetched shallowly into a bare repository, and files are
+// read from the fetched commit rather than a working tree, so nothing in the
+// repository (such as symbolic links) is ever followed on disk. Each file
+// is a page whose revision is its blob SHA, so only changed files are
+// imported again, and whose version is the commit SHA.
+type gitRepo struct {
+	dir    string
+	url    string
+	branch string // empty for the remote's default branch
+	user   string
+	token  string
+	folder string // slash-separated, empty for the whole repository
+}
+
+func (g *gitRepo) list(ctx context.Context) ([]remotePage, error) {
+	if err := g.fetch(ctx); err != nil {
+		return nil, err
+	}
+	out, err := g.git(ctx, "log", "-1", "--format=%H %ct", "FETCH_HEAD")
+	if err != nil {
+		return nil, err
+	}
+	commit, ts, _ := strings.Cut(strings.TrimSpace(string(out)), " ")
+	sec, _ := strconv.ParseInt(ts, 10, 64)
+	committed := time.Unix(sec, 0).UTC()
+
+	args := []string{"ls-tree", "-r", "-l", "-z", commit}
+	if g.folder != "" {
+		args = append(args, "--", g.folder)
+	}
+	if out, err = g.git(ctx, args...); err != nil {
+		return nil, err
+	}
+	var pages []remotePage
+	for _, entry := range bytes.Split(out, []byte{0}) {
+		// <mode> SP <type> SP <object> SP <size> TAB <path>
+		meta, file, ok := strings.Cut(string(entry), "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 4 || fields[1] != "blob" || !strings.HasPrefix(fields[0], "100") {
+			continue // symbolic links and submodules are not followed
+		}
+		if _, ok := gitFileTypes[strings.ToLower(path.Ext(file))]; !ok {
+			continue
+		}
+		if size, _ := strconv.ParseInt(fields[3], 10, 64); size > gitMaxFileSize {
+			continue
+		}
+		dir := path.Dir(file)
+		p := remotePage{
+			ID:       file,
+			Title:    path.Base(file),
+			Modified: committed,
+			Revision: fields[2],
+			Version:  commit,
+			URL:      g.fileURL(commit, file),
+		}
+		if dir != "." {
+			p.ParentID = dir
+			p.Path = strings.Split(dir, "/")
+		}
+		pages = append(pages, p)
+	}
+	return pages, nil
+}
+
+func (g *gitRepo) content(ctx context.Context, p remotePage) (string, error) {
+	data, err := g.git(ctx, "cat-file", "blob", p.Revision)
+	if err != nil {
+		return "", err
+	}
+	result, err := (&parser.DocumentParser{}).Parse(data, gitFileTypes[strings.ToLower(path.Ext(p.ID))])
+	if err != nil {
+		return "", fmt.Errorf("parse %s: %w", p.ID, err)
+	}
+	return result.Text, nil
+}
+
+// fetch brings the branch into the bare repository, creating it on the
+// first sync.
+func (g *gitRepo) fetch(ctx context.Context) error {
+	if _, err := os.Stat(g.dir); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(g.dir, 0o700); err != nil {
+			return fmt.Errorf("create repository folder: %w", err)
+		}
+		if _, err := g.git(ctx, "init", "--bare", "-q"); err != nil {
+			return err
+		}
+	}
+	ref := g.branch
+	if ref == "" {
+		ref = "HEAD"
+	}
+	_, err := g.git(ctx, "fetch", "--depth", "1", "--no-tags", "-q", g.url, ref)
+	return err
+}
+
+// git runs a git command in the repository. The token is passed as an
+// HTTP header through the environment, so it is neither stored in the
+// repository config nor visible in the process list.
+func (g *gitRepo) git(ctx context.Context, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, gitTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", g.dir}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_ALLOW_PROTOCOL=https",
+		"GIT_CONFIG_NOSYSTEM=1",
+	)
+	if g.token != "" {
+		user := g.user
+		if user == "" {
+			user = "x-access-token"
+		}
+		auth := base64.StdEncoding.EncodeToString([]byte(user + ":" + g.token))
+		cmd.Env = append(cmd.Env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0=Authorization: Basic "+auth,
+		)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > 500 {
+			msg = msg[:500]
+		}
+		return nil, fmt.Errorf("git %s: %v: %s", args[0], err, msg)
+	}
+	return out, nil
+}
+
+// fileURL links to a file at a commit on GitHub and GitLab, which most
+// repositories are hosted on; other hosts get no link.
+func (g *gitRepo) fileURL(commit, file string) string {
+	u, err := url.Parse(strings.TrimSuffix(g.url, ".git"))
+	if err != nil {
+		return ""
+	}
+	escaped := (&url.URL{Path: file}).EscapedPath()
+	switch {
+	case u.Host == "github.com":
+		return u.String() + "/blob/" + commit + "/" + escaped
+	case u.Host == "gitlab.com" || strings.HasPrefix(u.Host, "gitlab."):
+		return u.String() + "/-/blob/" + commit + "/" + escaped
+	}
+	return ""
+}
+
+// validBranch reports whether name is empty or a plain branch name. Names
+// starting with "-" would be read as git options.
+func validBranch(name string) bool {
+	if name == "" {
+		return true
+	}
+	if len(name) > 255 || strings.HasPrefix(name, "-") || strings.Contains(name, "..") {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("._/-", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// cleanFolder normalizes a repository folder path, rejecting paths that are
+// absolute or escape the repository.
+func cleanFolder(folder string) (string, bool) {
+	folder = strings.Trim(strings.ReplaceAll(folder, "\\", "/"), "/")
+	if folder == "" {
+		return "", true
+	}
+	clean := path.Clean(folder)
+	if clean == ".." || strings.HasPrefix(clean, "../") || strings.HasPrefix(clean, "-") || len(clean) > 500 {
+		return "", false
+	}
+	if clean == "." {
+		return "", true
+	}
+	return clean, true
+}
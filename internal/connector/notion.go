@@ -0,0 +1,342 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	notionAPI     = "https://api.notion.com/v1"
+	notionVersion = "2022-06-28"
+	// notionInterval spaces requests to stay under Notion's average rate
+	// limit of three requests per second.
+	notionInterval = 350 * time.Millisecond
+	// notionMaxDepth bounds how deeply nested blocks are read.
+	notionMaxDepth = 8
+)
+
+// notion reads the pages an integration token has been granted, or those
+// under one root page, through the Notion API. Child pages are separate
+// pages of their own and are not inlined into their parent.
+type notion struct {
+	client *http.Client
+	token  string
+	root   string // page ID without dashes, empty for all pages
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+type notionRichText []struct {
+	PlainText string `json:"plain_text"`
+}
+
+func (t notionRichText) String() string {
+	var b strings.Builder
+	for _, r := range t {
+		b.WriteString(r.PlainText)
+	}
+	return b.String()
+}
+
+type notionPage struct {
+	ID             string    `json:"id"`
+	URL            string    `json:"url"`
+	LastEditedTime time.Time `json:"last_edited_time"`
+	Archived       bool      `json:"archived"`
+	InTrash        bool      `json:"in_trash"`
+	Parent         struct {
+		Type   string `json:"type"`
+		PageID string `json:"page_id"`
+	} `json:"parent"`
+	Properties map[string]struct {
+		Type  string         `json:"type"`
+		Title notionRichText `json:"title"`
+	} `json:"properties"`
+}
+
+func (p *notionPage) title() string {
+	for _, prop := range p.Properties {
+		if prop.Type == "title" {
+			if t := strings.TrimSpace(prop.Title.String()); t != "" {
+				return t
+			}
+		}
+	}
+	return "Untitled"
+}
+
+func (n *notion) list(ctx context.Context) ([]remotePage, error) {
+	byID := make(map[string]*notionPage)
+	var order []string
+	cursor := ""
+	for {
+		body := map[string]interface{}{
+			"filter":    map[string]string{"property": "object", "value": "page"},
+			"page_size": 100,
+		}
+		if cursor != "" {
+			body["start_cursor"] = cursor
+		}
+		var resp struct {
+			Results    []notionPage `json:"results"`
+			HasMore    bool         `json:"has_more"`
+			NextCursor string       `json:"next_cursor"`
+		}
+		if err := n.do(ctx, http.MethodPost, "/search", body, &resp); err != nil {
+			return nil, err
+		}
+		for i := range resp.Results {
+			p := &resp.Results[i]
+			if p.Archived || p.InTrash {
+				continue
+			}
+			id := normalizeNotionID(p.ID)
+			if _, dup := byID[id]; !dup {
+				byID[id] = p
+				order = append(order, id)
+			}
+		}
+		if !resp.HasMore || resp.NextCursor == "" {
+			break
+		}
+		cursor = resp.NextCursor
+	}
+
+	var pages []remotePage
+	for _, id := range order {
+		p := byID[id]
+		// Walk up the parents the token can see; pages in databases or at
+		// the workspace root end the chain
+		var ancestors []string
+		inScope := n.root == "" || id == n.root
+		parent := normalizeNotionID(p.Parent.PageID)
+		for depth := 0; parent != "" && depth < 64; depth++ {
+			if parent == n.root {
+				inScope = true
+			}
+			pp, ok := byID[parent]
+			if !ok {
+				break
+			}
+			ancestors = append([]string{pp.title()}, ancestors...)
+			parent = normalizeNotionID(pp.Parent.PageID)
+		}
+		if !inScope {
+			continue
+		}
+		pages = append(pages, remotePage{
+			ID:       id,
+			Title:    p.title(),
+			ParentID: normalizeNotionID(p.Parent.PageID),
+			Path:     ancestors,
+			URL:      p.URL,
+			Modified: p.LastEditedTime,
+		})
+	}
+	return pages, nil
+}
+
+type notionBlock struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	HasChildren bool   `json:"has_children"`
+	Content     struct {
+		RichText notionRichText   `json:"rich_text"`
+		Checked  bool             `json:"checked"`
+		Language string           `json:"language"`
+		Cells    []notionRichText `json:"cells"`
+	}
+}
+
+// UnmarshalJSON reads the type-specific fields, which Notion nests under
+// the name of the block type.
+func (b *notionBlock) UnmarshalJSON(data []byte) error {
+	type plain notionBlock
+	if err := json.Unmarshal(data, (*plain)(b)); err != nil {
+		return err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if content, ok := raw[b.Type]; ok {
+		json.Unmarshal(content, &b.Content)
+	}
+	return nil
+}
+
+func (n *notion) content(ctx context.Context, p remotePage) (string, error) {
+	var b strings.Builder
+	if err := n.writeBlocks(ctx, &b, p.ID, 0); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// writeBlocks appends the blocks under parentID as Markdown-like text.
+func (n *notion) writeBlocks(ctx context.Context, b *strings.Builder, parentID string, depth int) error {
+	indent := strings.Repeat("  ", depth)
+	cursor := ""
+	number := 0
+	for {
+		path := "/blocks/" + url.PathEscape(parentID) + "/children?page_size=100"
+		if cursor != "" {
+			path += "&start_cursor=" + url.QueryEscape(cursor)
+		}
+		var resp struct {
+			Results    []notionBlock `json:"results"`
+			HasMore    bool          `json:"has_more"`
+			NextCursor string        `json:"next_cursor"`
+		}
+		if err := n.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+			return err
+		}
+		for _, blk := range resp.Results {
+			text := blk.Content.RichText.String()
+			if blk.Type == "numbered_list_item" {
+				number++
+			} else {
+				number = 0
+			}
+			switch blk.Type {
+			case "heading_1":
+				b.WriteString("\n# " + text + "\n")
+			case "heading_2":
+				b.WriteString("\n## " + text + "\n")
+			case "heading_3":
+				b.WriteString("\n### " + text + "\n")
+			case "bulleted_list_item", "toggle":
+				b.WriteString(indent + "- " + text + "\n")
+			case "numbered_list_item":
+				b.WriteString(indent + strconv.Itoa(number) + ". " + text + "\n")
+			case "to_do":
+				mark := "[ ]"
+				if blk.Content.Checked {
+					mark = "[x]"
+				}
+				b.WriteString(indent + "- " + mark + " " + text + "\n")
+			case "quote", "callout":
+				b.WriteString(indent + "> " + text + "\n")
+			case "code":
+				b.WriteString("```" + blk.Content.Language + "\n" + text + "\n```\n")
+			case "table_row":
+				cells := make([]string, len(blk.Content.Cells))
+				for j, c := range blk.Content.Cells {
+					cells[j] = c.String()
+				}
+				b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+			case "child_page", "child_database":
+				// Synced as pages of their own
+				continue
+			default:
+				if text != "" {
+					b.WriteString(indent + text + "\n")
+				}
+			}
+			if blk.HasChildren && depth < notionMaxDepth {
+				if err := n.writeBlocks(ctx, b, blk.ID, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		if !resp.HasMore || resp.NextCursor == "" {
+			return nil
+		}
+		cursor = resp.NextCursor
+	}
+}
+
+// do sends a request to the Notion API, waiting out the rate limit.
+func (n *notion) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		if err := n.wait(ctx, 0); err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, method, notionAPI+path, bytes.NewReader(payload))
+		if err != nil {
+			return fmt.Errorf("create Notion request: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+n.token)
+		req.Header.Set("Notion-Version", notionVersion)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := n.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("Notion request: %w", err)
+		}
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < 3 {
+			retry, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+			resp.Body.Close()
+			if err := n.wait(ctx, time.Duration(max(retry, 1))*time.Second); err != nil {
+				return err
+			}
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			return fmt.Errorf("Notion API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+		}
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("decode Notion response: %w", err)
+		}
+		return nil
+	}
+}
+
+// wait blocks until the next request may be sent, at least extra from now.
+func (n *notion) wait(ctx context.Context, extra time.Duration) error {
+	n.mu.Lock()
+	next := n.last.Add(notionInterval)
+	if at := time.Now().Add(extra); at.After(next) {
+		next = at
+	}
+	n.last = next
+	n.mu.Unlock()
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// normalizeNotionID returns a Notion ID without dashes, accepting the page
+// URLs Notion shares, or "" when s holds no ID.
+func normalizeNotionID(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, "?#"); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.ToLower(strings.ReplaceAll(s, "-", ""))
+	if len(s) < 32 {
+		return ""
+	}
+	id := s[len(s)-32:]
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return ""
+		}
+	}
+	return id
+}
@@ -0,0 +1,383 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"askflow/internal/config"
+)
+
+// ErrNotAuthorized is returned when a Google Drive or SharePoint connector
+// has not been granted access yet.
+var ErrNotAuthorized = errors.New("连接器尚未授权，请先完成授权")
+
+var (
+	// ErrInvalidFolder is returned for malformed folder IDs.
+	ErrInvalidFolder = errors.New("文件夹 ID 无效")
+	errNoFolders     = errors.New("尚未选择要同步的文件夹")
+)
+
+// driveFileTypes maps the extensions of the Office (and PDF) files synced
+// from Google Drive and SharePoint to parser types.
+var driveFileTypes = map[string]string{
+	".docx": "word",
+	".doc":  "word_legacy",
+	".xlsx": "excel",
+	".xls":  "excel_legacy",
+	".pptx": "ppt",
+	".ppt":  "ppt_legacy",
+	".pdf":  "pdf",
+}
+
+// Folder is a folder an admin can pick as a connector's scope. Entries that
+// only lead to folders, such as SharePoint sites, are not Selectable.
+type Folder struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Selectable bool   `json:"selectable"`
+}
+
+// oauthProvider holds the endpoints and scopes of a provider.
+type oauthProvider struct {
+	authURL  string
+	tokenURL string
+	scope    string
+	extra    url.Values
+}
+
+// oauthApp returns the provider and client registration of an OAuth-based
+// connector type.
+func (s *Service) oauthApp(connectorType string) (oauthProvider, config.ConnectorOAuthApp, error) {
+	var apps config.ConnectorsConfig
+	if s.oauthApps != nil {
+		apps = s.oauthApps()
+	}
+	switch connectorType {
+	case TypeGoogleDrive:
+		if apps.Google.ClientID == "" || apps.Google.ClientSecret == "" {
+			return oauthProvider{}, apps.Google, errors.New("未配置 Google 应用（connectors.google.client_id / client_secret）")
+		}
+		return oauthProvider{
+			authURL:  "https://accounts.google.com/o/oauth2/v2/auth",
+			tokenURL: "https://oauth2.googleapis.com/token",
+			scope:    "https://www.googleapis.com/auth/drive.readonly",
+			// A refresh token is only issued with offline access, and again
+			// on re-authorization only with the consent prompt
+			extra: url.Values{"access_type": {"offline"}, "prompt": {"consent"}},
+		}, apps.Google, nil
+	case TypeSharePoint:
+		if apps.Microsoft.ClientID == "" || apps.Microsoft.ClientSecret == "" {
+			return oauthProvider{}, apps.Microsoft, errors.New("未配置 Microsoft 应用（connectors.microsoft.client_id / client_secret）")
+		}
+		tenant := apps.Microsoft.Tenant
+		if tenant == "" {
+			tenant = "organizations"
+		}
+		base := "https://login.microsoftonline.com/" + url.PathEscape(tenant) + "/oauth2/v2.0"
+		return oauthProvider{
+			authURL:  base + "/authorize",
+			tokenURL: base + "/token",
+			scope:    "offline_access Files.Read.All Sites.Read.All User.Read",
+		}, apps.Microsoft, nil
+	default:
+		return oauthProvider{}, config.ConnectorOAuthApp{}, errors.New("该类型的连接器无需授权")
+	}
+}
+
+// isOAuthType reports whether connectors of a type are authorized through
+// OAuth rather than with an API token.
+func isOAuthType(t string) bool {
+	return t == TypeGoogleDrive || t == TypeSharePoint
+}
+
+// AuthURL returns the provider page where an admin grants a connector
+// access to their files. The provider redirects back to redirectURI with a
+// code and state.
+func (s *Service) AuthURL(id, redirectURI, state string) (string, error) {
+	c, err := s.Get(id)
+	if err != nil {
+		return "", err
+	}
+	p, app, err := s.oauthApp(c.Type)
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{
+		"client_id":     {app.ClientID},
+		"redirect_uri":  {redirectURI},
+		"response_type": {"code"},
+		"scope":         {p.scope},
+		"state":         {state},
+	}
+	for k, v := range p.extra {
+		q[k] = v
+	}
+	return p.authURL + "?" + q.Encode(), nil
+}
+
+// Authorize redeems the code the provider redirected back with and stores
+// the refresh token. The account that granted access is recorded as the
+// connector's email.
+func (s *Service) Authorize(ctx context.Context, id, code, redirectURI string) error {
+	c, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	tok, err := s.requestToken(ctx, c.Type, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {redirectURI},
+	})
+	if err != nil {
+		return err
+	}
+	if tok.RefreshToken == "" {
+		return errors.New("授权未返回刷新令牌，请重新授权")
+	}
+	account := ""
+	switch c.Type {
+	case TypeGoogleDrive:
+		var about struct {
+			User struct {
+				EmailAddress string `json:"emailAddress"`
+			} `json:"user"`
+		}
+		if err := bearerGet(ctx, s.httpClient, tok.AccessToken, googleDriveAPI+"/about?fields=user(emailAddress)", &about); err == nil {
+			account = about.User.EmailAddress
+		}
+	case TypeSharePoint:
+		var me struct {
+			UserPrincipalName string `json:"userPrincipalName"`
+		}
+		if err := bearerGet(ctx, s.httpClient, tok.AccessToken, graphAPI+"/me?$select=userPrincipalName", &me); err == nil {
+			account = me.UserPrincipalName
+		}
+	}
+	if _, err := s.writeDB.Exec(`UPDATE connectors SET api_token = ?, email = ?, last_error = '' WHERE id = ?`,
+		s.secrets.EncryptSecret(tok.RefreshToken), account, id); err != nil {
+		return fmt.Errorf("failed to save authorization: %w", err)
+	}
+	return nil
+}
+
+// Folders lists the folders under parent ("" for the top level) that an
+// authorized connector can pick as its scope.
+func (s *Service) Folders(ctx context.Context, id, parent string) ([]Folder, error) {
+	c, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !isOAuthType(c.Type) {
+		return nil, errors.New("该类型的连接器不支持选择文件夹")
+	}
+	tok, err := s.oauthToken(c)
+	if err != nil {
+		return nil, err
+	}
+	if c.Type == TypeGoogleDrive {
+		return (&googleDrive{client: s.httpClient, token: tok}).folders(ctx, parent)
+	}
+	return (&sharePoint{client: s.httpClient, token: tok}).folders(ctx, parent)
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// requestToken calls the token endpoint of a connector type's provider.
+func (s *Service) requestToken(ctx context.Context, connectorType string, form url.Values) (*tokenResponse, error) {
+	p, app, err := s.oauthApp(connectorType)
+	if err != nil {
+		return nil, err
+	}
+	form.Set("client_id", app.ClientID)
+	form.Set("client_secret", app.ClientSecret)
+	if connectorType == TypeSharePoint {
+		form.Set("scope", p.scope)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request: %w", err)
+	}
+	defer resp.Body.Close()
+	var tok tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tok); err != nil {
+		return nil, fmt.Errorf("decode token response (%d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || tok.AccessToken == "" {
+		return nil, fmt.Errorf("token request failed: %s %s", tok.Error, tok.ErrorDescription)
+	}
+	return &tok, nil
+}
+
+// oauthToken returns the access token source of an authorized connector.
+func (s *Service) oauthToken(c *Connector) (*accessToken, error) {
+	if c.token == "" {
+		return nil, ErrNotAuthorized
+	}
+	refresh, err := s.secrets.DecryptSecret(c.token)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt refresh token: %w", err)
+	}
+	return &accessToken{svc: s, connectorID: c.ID, connectorType: c.Type, refresh: refresh}, nil
+}
+
+// accessToken hands out access tokens of a connector, refreshing them
+// before they expire. Providers that rotate refresh tokens (Microsoft)
+// return a new one with each refresh, which is stored.
+type accessToken struct {
+	svc           *Service
+	connectorID   string
+	connectorType string
+
+	mu      sync.Mutex
+	refresh string
+	access  string
+	expiry  time.Time
+}
+
+func (t *accessToken) get(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.access != "" && time.Until(t.expiry) > time.Minute {
+		return t.access, nil
+	}
+	tok, err := t.svc.requestToken(ctx, t.connectorType, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {t.refresh},
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w (%v)", ErrNotAuthorized, err)
+	}
+	t.access = tok.AccessToken
+	t.expiry = time.Now().Add(time.Duration(max(tok.ExpiresIn, 60)) * time.Second)
+	if tok.RefreshToken != "" && tok.RefreshToken != t.refresh {
+		t.refresh = tok.RefreshToken
+		if _, err := t.svc.writeDB.Exec(`UPDATE connectors SET api_token = ? WHERE id = ?`,
+			t.svc.secrets.EncryptSecret(tok.RefreshToken), t.connectorID); err != nil {
+			return "", fmt.Errorf("failed to save refreshed token: %w", err)
+		}
+	}
+	return t.access, nil
+}
+
+// bearerGet fetches JSON from an API with an access token, waiting out
+// rate limiting (429) a few times.
+func bearerGet(ctx context.Context, client *http.Client, token, rawURL string, out interface{}) error {
+	resp, err := bearerDo(ctx, client, token, rawURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// bearerDownload reads a file from an API with an access token, failing
+// for files over maxFileSize.
+func bearerDownload(ctx context.Context, client *http.Client, token, rawURL string) ([]byte, error) {
+	resp, err := bearerDo(ctx, client, token, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("download: %w", err)
+	}
+	if len(data) > maxFileSize {
+		return nil, fmt.Errorf("file exceeds %dMB", maxFileSize>>20)
+	}
+	return data, nil
+}
+
+func bearerDo(ctx context.Context, client *http.Client, token, rawURL string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("request: %w", err)
+		}
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < 3 {
+			retry, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+			resp.Body.Close()
+			timer := time.NewTimer(time.Duration(min(max(retry, 1), 60)) * time.Second)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			case <-timer.C:
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			resp.Body.Close()
+			return nil, fmt.Errorf("API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		}
+		return resp, nil
+	}
+}
+
+// splitFolders splits the folder IDs of an OAuth connector's scope,
+// dropping blanks and duplicates.
+func splitFolders(scope string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, f := range strings.FieldsFunc(scope, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if f = strings.TrimSpace(f); f != "" && !seen[f] {
+			seen[f] = true
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// validFolderRef reports whether ref is a Drive folder ID, or for
+// SharePoint a "driveID:itemID" pair. Only ID characters are allowed, as
+// the IDs are put into Drive queries and Graph paths.
+func validFolderRef(connectorType, ref string) bool {
+	parts := []string{ref}
+	if connectorType == TypeSharePoint {
+		drive, item, ok := strings.Cut(ref, ":")
+		if !ok {
+			return false
+		}
+		parts = []string{drive, item}
+	}
+	for _, p := range parts {
+		if p == "" || len(p) > 200 {
+			return false
+		}
+		for _, c := range p {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '!') {
+				return false
+			}
+		}
+	}
+	return true
+}
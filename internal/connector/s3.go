@@ -0,0 +1,148 @@
+package connector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"askflow/internal/parser"
+	"askflow/internal/s3"
+)
+
+// s3FileTypes maps the extensions imported from a bucket to parser types:
+// the document formats that can be uploaded, videos excepted.
+var s3FileTypes = map[string]string{
+	".pdf":      "pdf",
+	".docx":     "word",
+	".doc":      "word_legacy",
+	".xlsx":     "excel",
+	".xls":      "excel_legacy",
+	".pptx":     "ppt",
+	".ppt":      "ppt_legacy",
+	".md":       "markdown",
+	".markdown": "markdown",
+	".html":     "html",
+	".htm":      "html",
+}
+
+// s3Bucket imports the objects under a prefix of an S3-compatible bucket.
+// Each object is a page whose revision is its ETag. The folders of its key
+// below the prefix are its category, kept as the page path; for connectors
+// without a product, the first folder names the product (by ID or name)
+// the document is imported into, and objects outside a product folder go to
+// the public library.
+type s3Bucket struct {
+	client   *s3.Client
+	prefix   string
+	products func(ref string) (string, error) // product ID of a folder, "" for none
+}
+
+func (b *s3Bucket) list(ctx context.Context) ([]remotePage, error) {
+	objects, err := b.client.List(ctx, b.prefix)
+	if err != nil {
+		return nil, err
+	}
+	var pages []remotePage
+	for _, o := range objects {
+		if strings.HasSuffix(o.Key, "/") || o.Size > maxFileSize {
+			continue // folder placeholders and oversized files
+		}
+		if _, ok := s3FileTypes[strings.ToLower(path.Ext(o.Key))]; !ok {
+			continue
+		}
+		var folders []string
+		if dir := path.Dir(strings.TrimPrefix(o.Key, b.prefix)); dir != "." {
+			folders = strings.Split(dir, "/")
+		}
+		p := remotePage{
+			ID:       o.Key,
+			Title:    path.Base(o.Key),
+			URL:      b.client.URL(o.Key),
+			Modified: o.LastModified,
+			Revision: o.ETag,
+		}
+		if b.products != nil && len(folders) > 0 {
+			if p.ProductID, err = b.products(folders[0]); err != nil {
+				return nil, err
+			}
+			if p.ProductID != "" {
+				folders = folders[1:]
+				// Moving to another product imports the object again
+				p.Revision += "@" + p.ProductID
+			}
+		}
+		p.Path = folders
+		if len(folders) > 0 {
+			p.ParentID = strings.Join(folders, "/")
+		}
+		pages = append(pages, p)
+	}
+	return pages, nil
+}
+
+func (b *s3Bucket) content(ctx context.Context, p remotePage) (string, error) {
+	resp, err := b.client.Open(ctx, p.ID, "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFileSize+1))
+	if err != nil {
+		return "", fmt.Errorf("download %s: %w", p.ID, err)
+	}
+	if len(data) > maxFileSize {
+		return "", fmt.Errorf("%s exceeds %dMB", p.ID, maxFileSize>>20)
+	}
+	result, err := (&parser.DocumentParser{}).Parse(data, s3FileTypes[strings.ToLower(path.Ext(p.ID))])
+	if err != nil {
+		return "", fmt.Errorf("parse %s: %w", p.ID, err)
+	}
+	return result.Text, nil
+}
+
+// productLookup returns the product named by a bucket folder, by ID or
+// name, caching the answers for one sync.
+func (s *Service) productLookup() func(ref string) (string, error) {
+	cache := make(map[string]string)
+	return func(ref string) (string, error) {
+		if id, ok := cache[ref]; ok {
+			return id, nil
+		}
+		var id string
+		err := s.readDB.QueryRow(`SELECT id FROM products WHERE id = ? OR name = ? ORDER BY id = ? DESC LIMIT 1`, ref, ref, ref).Scan(&id)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("look up product %q: %w", ref, err)
+		}
+		cache[ref] = id
+		return id, nil
+	}
+}
+
+// splitBucket splits an S3 connector's scope, "bucket" or "bucket/prefix",
+// into the bucket and the key prefix. The prefix is a folder, so it ends
+// with a slash unless empty.
+func splitBucket(scope string) (bucket, prefix string) {
+	bucket, prefix, _ = strings.Cut(strings.TrimPrefix(scope, "s3://"), "/")
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		prefix += "/"
+	}
+	return bucket, prefix
+}
+
+// validBucket reports whether name is a valid S3 bucket name.
+func validBucket(name string) bool {
+	if len(name) < 3 || len(name) > 63 || strings.Contains(name, "..") {
+		return false
+	}
+	for i, c := range name {
+		ok := c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || (c == '.' || c == '-') && i > 0 && i < len(name)-1
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
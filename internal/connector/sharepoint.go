@@ -0,0 +1,256 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"askflow/internal/parser"
+)
+
+// graphAPI is the Microsoft Graph endpoint SharePoint and OneDrive are read
+// through.
+const graphAPI = "https://graph.microsoft.com/v1.0"
+
+// sharePoint reads the Office files under a set of SharePoint document
+// library (or OneDrive) folders through Microsoft Graph. Folders and pages
+// are identified as "driveID:itemID"; a file's revision is its content tag,
+// which changes only when its content does.
+type sharePoint struct {
+	client *http.Client
+	token  *accessToken
+	roots  []string // driveID:itemID
+}
+
+type graphItem struct {
+	ID                   string    `json:"id"`
+	Name                 string    `json:"name"`
+	CTag                 string    `json:"cTag"`
+	WebURL               string    `json:"webUrl"`
+	Size                 int64     `json:"size"`
+	LastModifiedDateTime time.Time `json:"lastModifiedDateTime"`
+	Folder               *struct{} `json:"folder"`
+	File                 *struct{} `json:"file"`
+}
+
+func (sp *sharePoint) list(ctx context.Context) ([]remotePage, error) {
+	if len(sp.roots) == 0 {
+		return nil, errNoFolders
+	}
+	type folder struct {
+		ref  string
+		path []string
+	}
+	var pages []remotePage
+	visited := make(map[string]bool)
+	listed := make(map[string]bool)
+	for _, root := range sp.roots {
+		drive, item, _ := strings.Cut(root, ":")
+		name, err := sp.folderName(ctx, drive, item)
+		if err != nil {
+			return nil, fmt.Errorf("folder %s: %w", root, err)
+		}
+		queue := []folder{{ref: root, path: []string{name}}}
+		for len(queue) > 0 {
+			f := queue[0]
+			queue = queue[1:]
+			if visited[f.ref] {
+				continue
+			}
+			visited[f.ref] = true
+			children, err := sp.children(ctx, f.ref)
+			if err != nil {
+				return nil, err
+			}
+			for _, c := range children {
+				ref := drive + ":" + c.ID
+				if c.Folder != nil {
+					if len(f.path) < driveMaxDepth {
+						queue = append(queue, folder{ref: ref, path: append(append([]string{}, f.path...), c.Name)})
+					}
+					continue
+				}
+				if c.File == nil || listed[ref] || c.Size > maxFileSize {
+					continue
+				}
+				if _, ok := driveFileTypes[strings.ToLower(path.Ext(c.Name))]; !ok {
+					continue
+				}
+				listed[ref] = true
+				pages = append(pages, remotePage{
+					ID:       ref,
+					Title:    c.Name,
+					ParentID: f.ref,
+					Path:     f.path,
+					URL:      c.WebURL,
+					Modified: c.LastModifiedDateTime,
+					Revision: c.CTag,
+				})
+			}
+		}
+	}
+	return pages, nil
+}
+
+func (sp *sharePoint) content(ctx context.Context, p remotePage) (string, error) {
+	drive, item, _ := strings.Cut(p.ID, ":")
+	token, err := sp.token.get(ctx)
+	if err != nil {
+		return "", err
+	}
+	// Graph redirects to a pre-authenticated download URL
+	data, err := bearerDownload(ctx, sp.client, token, graphAPI+"/drives/"+url.PathEscape(drive)+"/items/"+url.PathEscape(item)+"/content")
+	if err != nil {
+		return "", err
+	}
+	result, err := (&parser.DocumentParser{}).Parse(data, driveFileTypes[strings.ToLower(path.Ext(p.Title))])
+	if err != nil {
+		return "", fmt.Errorf("parse %s: %w", p.Title, err)
+	}
+	return result.Text, nil
+}
+
+// folders lists the folders under parent. The top level is the account's
+// OneDrive and the SharePoint sites it can see; a site ("site:<id>") lists
+// its document libraries, and a folder its subfolders.
+func (sp *sharePoint) folders(ctx context.Context, parent string) ([]Folder, error) {
+	out := []Folder{}
+	switch {
+	case parent == "":
+		var me struct {
+			ID string `json:"id"`
+		}
+		// Accounts without a OneDrive license have none
+		if err := sp.get(ctx, graphAPI+"/me/drive?$select=id", &me); err == nil && me.ID != "" {
+			out = append(out, Folder{ID: me.ID + ":root", Name: "OneDrive", Selectable: true})
+		}
+		err := sp.pages(ctx, graphAPI+"/sites?search=*&$select=id,displayName&$top=100", func(data []graphSite) {
+			for _, s := range data {
+				out = append(out, Folder{ID: "site:" + s.ID, Name: s.DisplayName})
+			}
+		})
+		return out, err
+	case strings.HasPrefix(parent, "site:"):
+		site := strings.TrimPrefix(parent, "site:")
+		if !validSiteID(site) {
+			return nil, ErrInvalidFolder
+		}
+		var resp struct {
+			Value []struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"value"`
+		}
+		if err := sp.get(ctx, graphAPI+"/sites/"+url.PathEscape(site)+"/drives?$select=id,name", &resp); err != nil {
+			return nil, err
+		}
+		for _, d := range resp.Value {
+			out = append(out, Folder{ID: d.ID + ":root", Name: d.Name, Selectable: true})
+		}
+		return out, nil
+	default:
+		if !validFolderRef(TypeSharePoint, parent) {
+			return nil, ErrInvalidFolder
+		}
+		drive, _, _ := strings.Cut(parent, ":")
+		children, err := sp.children(ctx, parent)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range children {
+			if c.Folder != nil {
+				out = append(out, Folder{ID: drive + ":" + c.ID, Name: c.Name, Selectable: true})
+			}
+		}
+		return out, nil
+	}
+}
+
+type graphSite struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+}
+
+// pages follows the pages of a site listing.
+func (sp *sharePoint) pages(ctx context.Context, next string, fn func([]graphSite)) error {
+	for next != "" {
+		var resp struct {
+			Value    []graphSite `json:"value"`
+			NextLink string      `json:"@odata.nextLink"`
+		}
+		if err := sp.get(ctx, next, &resp); err != nil {
+			return err
+		}
+		fn(resp.Value)
+		next = resp.NextLink
+	}
+	return nil
+}
+
+// children lists the items of a "driveID:itemID" folder.
+func (sp *sharePoint) children(ctx context.Context, ref string) ([]graphItem, error) {
+	drive, item, _ := strings.Cut(ref, ":")
+	next := graphAPI + "/drives/" + url.PathEscape(drive) + "/items/" + url.PathEscape(item) +
+		"/children?$top=200&$select=id,name,cTag,webUrl,size,lastModifiedDateTime,folder,file"
+	var out []graphItem
+	for next != "" {
+		var resp struct {
+			Value    []graphItem `json:"value"`
+			NextLink string      `json:"@odata.nextLink"`
+		}
+		if err := sp.get(ctx, next, &resp); err != nil {
+			return nil, err
+		}
+		out = append(out, resp.Value...)
+		next = resp.NextLink
+	}
+	return out, nil
+}
+
+// folderName returns the name of a folder, or of the document library for
+// its root.
+func (sp *sharePoint) folderName(ctx context.Context, drive, item string) (string, error) {
+	endpoint := graphAPI + "/drives/" + url.PathEscape(drive) + "/items/" + url.PathEscape(item) + "?$select=name"
+	if item == "root" {
+		endpoint = graphAPI + "/drives/" + url.PathEscape(drive) + "?$select=name"
+	}
+	var resp struct {
+		Name string `json:"name"`
+	}
+	err := sp.get(ctx, endpoint, &resp)
+	return resp.Name, err
+}
+
+// get fetches a Graph URL. Next links are only followed on Graph itself,
+// so the token is never sent elsewhere.
+func (sp *sharePoint) get(ctx context.Context, endpoint string, out interface{}) error {
+	if !strings.HasPrefix(endpoint, graphAPI+"/") {
+		return fmt.Errorf("unexpected Graph URL %q", endpoint)
+	}
+	token, err := sp.token.get(ctx)
+	if err != nil {
+		return err
+	}
+	if err := bearerGet(ctx, sp.client, token, endpoint, out); err != nil {
+		return fmt.Errorf("Microsoft Graph: %w", err)
+	}
+	return nil
+}
+
+// validSiteID reports whether id looks like a Graph site ID, which is the
+// host name and two GUIDs separated by commas.
+func validSiteID(id string) bool {
+	if id == "" || len(id) > 300 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune(".,-_", c)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,25 @@
+package db
+
+import (
+	"log"
+	"time"
+)
+
+// busyRetries is how many times RetryBusy re-runs a write that failed because
+// the database stayed locked past busy_timeout.
+const busyRetries = 3
+
+// RetryBusy runs fn and re-runs it with backoff while it fails with
+// SQLITE_BUSY/SQLITE_LOCKED. fn must be safe to repeat: a single statement or
+// a whole transaction that rolls back on error. Other errors are returned as is.
+func RetryBusy(fn func() error) error {
+	backoff := 200 * time.Millisecond
+	err := fn()
+	for attempt := 1; attempt <= busyRetries && IsBusy(err); attempt++ {
+		log.Printf("[DB] database busy, retrying in %v (%d/%d): %v", backoff, attempt, busyRetries, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		err = fn()
+	}
+	return err
+}
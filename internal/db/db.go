@@ -1,12 +1,11 @@
-// Package db provides SQLite database initialization and migration for the askflow system.
+// Package db provides SQLite database initialization and versioned schema
+// migrations for the askflow system.
 package db
 
 import (
 	"database/sql"
 	"fmt"
 	"time"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
 // DBPair holds separate read and write database connections for optimal
@@ -18,7 +17,7 @@ type DBPair struct {
 	Read  *sql.DB
 }
 
-// Close closes both the read and write database connections.
+// Close checkpoints the WAL and closes both the read and write database connections.
 func (p *DBPair) Close() error {
 	var firstErr error
 	if p.Read != nil && p.Read != p.Write {
@@ -27,6 +26,10 @@ func (p *DBPair) Close() error {
 		}
 	}
 	if p.Write != nil {
+		// Fold the WAL back into the main file so a stopped instance leaves a
+		// self-contained database (e.g. for file-level copies). Best effort:
+		// another process may still be reading.
+		p.Write.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
 		if err := p.Write.Close(); err != nil && firstErr == nil {
 			firstErr = err
 		}
@@ -34,12 +37,29 @@ func (p *DBPair) Close() error {
 	return firstErr
 }
 
-// InitDB opens a SQLite database connection at dbPath, enables WAL mode and
-// foreign keys, and creates all required tables idempotently.
-// Returns a DBPair with separate read and write pools for optimal concurrency.
+// InitDB opens the SQLite database at dbPath (see Open) and applies all
+// pending schema migrations, so the returned pool always has the latest schema.
 func InitDB(dbPath string) (*DBPair, error) {
+	pair, err := Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := MigrateUp(pair.Write, 0); err != nil {
+		pair.Close()
+		return nil, err
+	}
+	return pair, nil
+}
+
+// Open opens a SQLite database connection at dbPath and enables WAL mode and
+// foreign keys without touching the schema.
+// Returns a DBPair with separate read and write pools for optimal concurrency.
+func Open(dbPath string) (*DBPair, error) {
 	// --- Write connection: single connection, exclusive writer ---
-	writeDB, err := sql.Open("sqlite3", dbPath)
+	// _txlock=immediate makes BEGIN take the write lock up front, so the busy
+	// handler waits for another process's writer instead of failing the
+	// transaction with SQLITE_BUSY when it later upgrades from read to write.
+	writeDB, err := sql.Open(writeDriverName, dbPath+"?_txlock=immediate")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open write database: %w", err)
 	}
@@ -47,19 +67,16 @@ func InitDB(dbPath string) (*DBPair, error) {
 		writeDB.Close()
 		return nil, fmt.Errorf("failed to ping write database: %w", err)
 	}
-	// SQLite only allows one concurrent writer; a single connection avoids
-	// SQLITE_BUSY contention between writers and keeps the busy_timeout short.
+	// SQLite only allows one concurrent writer. The single connection is the
+	// write queue: database/sql hands it to one goroutine at a time and the
+	// others wait for it, so writers in this process never contend inside
+	// SQLite. busy_timeout and RetryBusy cover other processes (CLI, backups).
 	writeDB.SetMaxOpenConns(1)
 	writeDB.SetMaxIdleConns(1)
 	writeDB.SetConnMaxLifetime(0)
 
-	if err := configureWritePragmas(writeDB); err != nil {
-		writeDB.Close()
-		return nil, err
-	}
-
 	// --- Read connection pool: multiple connections for concurrent reads ---
-	readDB, err := sql.Open("sqlite3", dbPath+"?mode=ro")
+	readDB, err := sql.Open(readDriverName, dbPath+"?mode=ro")
 	if err != nil {
 		writeDB.Close()
 		return nil, fmt.Errorf("failed to open read database: %w", err)
@@ -76,393 +93,90 @@ func InitDB(dbPath string) (*DBPair, error) {
 	readDB.SetConnMaxLifetime(0)
 	readDB.SetConnMaxIdleTime(5 * time.Minute) // Recycle idle connections to prevent stale state
 
-	if err := configureReadPragmas(readDB); err != nil {
-		readDB.Close()
-		writeDB.Close()
-		return nil, err
-	}
-
-	// Use a closure to clean up both connections on any schema setup error
-	cleanup := func() {
-		readDB.Close()
-		writeDB.Close()
-	}
-
-	// Schema setup uses the write connection
-	if err := createTables(writeDB); err != nil {
-		cleanup()
-		return nil, err
-	}
-
-	if err := createAdminUsersTable(writeDB); err != nil {
-		cleanup()
-		return nil, fmt.Errorf("failed to create admin_users table: %w", err)
-	}
-
-	if err := createProductTables(writeDB); err != nil {
-		cleanup()
-		return nil, fmt.Errorf("failed to create product tables: %w", err)
-	}
-
-	if err := migrateTables(writeDB); err != nil {
-		cleanup()
-		return nil, err
-	}
-
-	if err := migrateProductTables(writeDB); err != nil {
-		cleanup()
-		return nil, fmt.Errorf("failed to migrate product tables: %w", err)
-	}
-
-	if err := createLoginAttemptsTable(writeDB); err != nil {
-		cleanup()
-		return nil, fmt.Errorf("failed to create login_attempts table: %w", err)
-	}
-
-	if err := createIndexes(writeDB); err != nil {
-		cleanup()
-		return nil, err
-	}
-
 	return &DBPair{Write: writeDB, Read: readDB}, nil
 }
 
-// configureWritePragmas sets pragmas for the write connection.
-func configureWritePragmas(db *sql.DB) error {
-	pragmas := []string{
-		"PRAGMA journal_mode=WAL",
-		"PRAGMA foreign_keys=ON",
-		"PRAGMA busy_timeout=30000",
-		"PRAGMA wal_autocheckpoint=1000",
-		// synchronous=NORMAL is safe with WAL and reduces fsync overhead significantly
-		"PRAGMA synchronous=NORMAL",
-		// 64MB page cache (negative value = KB) vs default ~2MB
-		"PRAGMA cache_size=-65536",
-		// 256MB memory-mapped I/O for faster reads
-		"PRAGMA mmap_size=268435456",
-		// Keep temp tables in memory instead of disk
-		"PRAGMA temp_store=MEMORY",
-	}
-	for _, p := range pragmas {
-		if _, err := db.Exec(p); err != nil {
-			return fmt.Errorf("failed to execute %s: %w", p, err)
-		}
-	}
-	return nil
-}
-
-// configureReadPragmas sets pragmas for read-only connections.
-// Skips write-oriented pragmas (journal_mode, synchronous, wal_autocheckpoint)
-// since the read pool never writes.
-func configureReadPragmas(db *sql.DB) error {
-	pragmas := []string{
-		// foreign_keys is needed for correct JOIN behavior on FK columns
-		"PRAGMA foreign_keys=ON",
-		// busy_timeout for when a read briefly contends with a checkpoint
-		"PRAGMA busy_timeout=30000",
-		// 64MB page cache
-		"PRAGMA cache_size=-65536",
-		// 256MB memory-mapped I/O for faster reads
-		"PRAGMA mmap_size=268435456",
-		// Keep temp tables in memory
-		"PRAGMA temp_store=MEMORY",
-	}
-	for _, p := range pragmas {
-		if _, err := db.Exec(p); err != nil {
-			return fmt.Errorf("failed to execute %s: %w", p, err)
-		}
-	}
-	return nil
-}
-
-func createTables(db *sql.DB) error {
-	tables := []string{
-		`CREATE TABLE IF NOT EXISTS documents (
-			id         TEXT PRIMARY KEY,
-			name       TEXT NOT NULL,
-			type       TEXT NOT NULL,
-			status     TEXT NOT NULL,
-			error      TEXT,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE TABLE IF NOT EXISTS chunks (
-			id            TEXT PRIMARY KEY,
-			document_id   TEXT NOT NULL,
-			document_name TEXT NOT NULL,
-			chunk_index   INTEGER NOT NULL,
-			chunk_text    TEXT NOT NULL,
-			embedding     BLOB NOT NULL,
-			image_url     TEXT DEFAULT '',
-			created_at    DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (document_id) REFERENCES documents(id)
-		)`,
-		`CREATE TABLE IF NOT EXISTS pending_questions (
-			id          TEXT PRIMARY KEY,
-			question    TEXT NOT NULL,
-			user_id     TEXT NOT NULL,
-			status      TEXT NOT NULL,
-			answer      TEXT,
-			llm_answer  TEXT,
-			created_at  DATETIME DEFAULT CURRENT_TIMESTAMP,
-			answered_at DATETIME
-		)`,
-		`CREATE TABLE IF NOT EXISTS users (
-			id             TEXT PRIMARY KEY,
-			email          TEXT UNIQUE,
-			name           TEXT,
-			provider       TEXT NOT NULL,
-			provider_id    TEXT NOT NULL,
-			password_hash  TEXT,
-			email_verified INTEGER DEFAULT 0,
-			created_at     DATETIME DEFAULT CURRENT_TIMESTAMP,
-			last_login     DATETIME,
-			default_product_id TEXT DEFAULT ''
-		)`,
-		`CREATE TABLE IF NOT EXISTS email_tokens (
-			id         TEXT PRIMARY KEY,
-			user_id    TEXT NOT NULL,
-			token      TEXT NOT NULL UNIQUE,
-			type       TEXT NOT NULL,
-			expires_at DATETIME NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (user_id) REFERENCES users(id)
-		)`,
-		`CREATE TABLE IF NOT EXISTS sessions (
-			id         TEXT PRIMARY KEY,
-			user_id    TEXT NOT NULL,
-			expires_at DATETIME NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (user_id) REFERENCES users(id)
-		)`,
-		`CREATE TABLE IF NOT EXISTS video_segments (
-			id           TEXT PRIMARY KEY,
-			document_id  TEXT NOT NULL,
-			segment_type TEXT NOT NULL,
-			start_time   REAL NOT NULL,
-			end_time     REAL NOT NULL,
-			content      TEXT NOT NULL,
-			chunk_id     TEXT NOT NULL,
-			FOREIGN KEY (document_id) REFERENCES documents(id)
-		)`,
-		`CREATE TABLE IF NOT EXISTS sn_users (
-			id             INTEGER PRIMARY KEY AUTOINCREMENT,
-			email          TEXT UNIQUE NOT NULL,
-			display_name   TEXT NOT NULL,
-			sn             TEXT DEFAULT '',
-			last_login_at  DATETIME,
-			created_at     DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE TABLE IF NOT EXISTS login_tickets (
-			ticket      TEXT PRIMARY KEY,
-			user_id     INTEGER NOT NULL,
-			used        INTEGER DEFAULT 0,
-			created_at  DATETIME DEFAULT CURRENT_TIMESTAMP,
-			expires_at  DATETIME NOT NULL,
-			FOREIGN KEY (user_id) REFERENCES sn_users(id)
-		)`,
-	}
-
-	tx, err := db.Begin()
+// OpenReadOnly opens the database at dbPath for an instance that never
+// writes to it, such as a read-only replica serving queries from the data
+// directory of a primary instance. Write and Read of the returned DBPair are
+// the same read-only pool, so stray writes fail instead of racing the
+// primary. Replicas do not migrate: the schema must already be at the
+// latest version this binary knows.
+func OpenReadOnly(dbPath string) (*DBPair, error) {
+	readDB, err := sql.Open(readDriverName, dbPath+"?mode=ro")
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
-	}
-
-	for _, ddl := range tables {
-		if _, err := tx.Exec(ddl); err != nil {
-			tx.Rollback()
-			return fmt.Errorf("failed to create table: %w", err)
-		}
+		return nil, fmt.Errorf("failed to open read database: %w", err)
 	}
-
-	return tx.Commit()
-}
-
-// createAdminUsersTable creates the admin_users table for sub-account management.
-// Called separately after main tables since it may not exist in older DBs.
-func createAdminUsersTable(db *sql.DB) error {
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS admin_users (
-		id            TEXT PRIMARY KEY,
-		username      TEXT NOT NULL UNIQUE,
-		password_hash TEXT NOT NULL,
-		role          TEXT NOT NULL DEFAULT 'editor',
-		created_at    DATETIME DEFAULT CURRENT_TIMESTAMP
-	)`)
-	return err
-}
-
-// createProductTables creates the products table and admin_user_products junction table.
-// Called after createAdminUsersTable since admin_user_products references admin_users.
-func createProductTables(db *sql.DB) error {
-	tables := []string{
-		`CREATE TABLE IF NOT EXISTS products (
-			id              TEXT PRIMARY KEY,
-			name            TEXT NOT NULL UNIQUE,
-			description     TEXT DEFAULT '',
-			welcome_message TEXT DEFAULT '',
-			created_at      DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at      DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE TABLE IF NOT EXISTS admin_user_products (
-			admin_user_id TEXT NOT NULL,
-			product_id    TEXT NOT NULL,
-			PRIMARY KEY (admin_user_id, product_id),
-			FOREIGN KEY (admin_user_id) REFERENCES admin_users(id) ON DELETE CASCADE,
-			FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
-		)`,
+	if err := readDB.Ping(); err != nil {
+		readDB.Close()
+		return nil, fmt.Errorf("failed to ping read database: %w", err)
 	}
+	readDB.SetMaxOpenConns(8)
+	readDB.SetMaxIdleConns(8)
+	readDB.SetConnMaxLifetime(0)
+	readDB.SetConnMaxIdleTime(5 * time.Minute)
 
-	for _, ddl := range tables {
-		if _, err := db.Exec(ddl); err != nil {
-			return fmt.Errorf("failed to create product table: %w", err)
-		}
+	migrations, err := Migrations()
+	if err != nil {
+		readDB.Close()
+		return nil, err
 	}
-	return nil
-}
-
-// migrateProductTables adds missing columns to product tables for backward compatibility.
-// Called after createProductTables to ensure the table exists before altering it.
-func migrateProductTables(db *sql.DB) error {
-	migrations := []struct {
-		table  string
-		column string
-		ddl    string
-	}{
-		{"products", "welcome_message", "ALTER TABLE products ADD COLUMN welcome_message TEXT DEFAULT ''"},
-		{"products", "type", "ALTER TABLE products ADD COLUMN type TEXT DEFAULT 'service'"},
-		{"products", "allow_download", "ALTER TABLE products ADD COLUMN allow_download INTEGER DEFAULT 0"},
+	latest := 0
+	if len(migrations) > 0 {
+		latest = migrations[len(migrations)-1].Version
 	}
-
-	for _, m := range migrations {
-		if !columnExists(db, m.table, m.column) {
-			if _, err := db.Exec(m.ddl); err != nil {
-				return fmt.Errorf("migration failed (%s.%s): %w", m.table, m.column, err)
-			}
-		}
-	}
-	return nil
-}
-
-// createLoginAttemptsTable creates the table for tracking admin login attempts.
-func createLoginAttemptsTable(db *sql.DB) error {
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS login_attempts (
-		id         INTEGER PRIMARY KEY AUTOINCREMENT,
-		username   TEXT NOT NULL,
-		ip         TEXT NOT NULL,
-		success    INTEGER NOT NULL DEFAULT 0,
-		created_at TEXT NOT NULL
-	)`)
+	current, err := CurrentVersion(readDB)
 	if err != nil {
-		return err
-	}
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS login_bans (
-		id         INTEGER PRIMARY KEY AUTOINCREMENT,
-		username   TEXT NOT NULL DEFAULT '',
-		ip         TEXT NOT NULL DEFAULT '',
-		reason     TEXT NOT NULL DEFAULT '',
-		unlocks_at TEXT NOT NULL,
-		created_at TEXT NOT NULL
-	)`)
-	return err
-}
-
-// createIndexes adds indexes for frequently queried columns.
-// Called after migrations to ensure all columns exist.
-func createIndexes(db *sql.DB) error {
-	indexes := []string{
-		`CREATE INDEX IF NOT EXISTS idx_chunks_document_id ON chunks(document_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_documents_content_hash ON documents(content_hash)`,
-		`CREATE INDEX IF NOT EXISTS idx_documents_status ON documents(status)`,
-		`CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at)`,
-		`CREATE INDEX IF NOT EXISTS idx_email_tokens_token ON email_tokens(token)`,
-		`CREATE INDEX IF NOT EXISTS idx_documents_product_id ON documents(product_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_chunks_product_id ON chunks(product_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_video_segments_chunk_id ON video_segments(chunk_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_video_segments_document_id ON video_segments(document_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_pending_questions_status ON pending_questions(status)`,
-		`CREATE INDEX IF NOT EXISTS idx_pending_questions_product_id ON pending_questions(product_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_sn_users_email ON sn_users(email)`,
-		`CREATE INDEX IF NOT EXISTS idx_login_tickets_user_id ON login_tickets(user_id)`,
-
-		// Composite indexes for login_attempts covering CheckAllowed correlated subqueries
-		`CREATE INDEX IF NOT EXISTS idx_login_attempts_username_success ON login_attempts(username, success, created_at)`,
-		`CREATE INDEX IF NOT EXISTS idx_login_attempts_ip_success ON login_attempts(ip, success, created_at)`,
-
-		// login_bans: covering index for ban lookups by username/ip + expiry
-		`CREATE INDEX IF NOT EXISTS idx_login_bans_username_unlocks ON login_bans(username, unlocks_at)`,
-		`CREATE INDEX IF NOT EXISTS idx_login_bans_ip_unlocks ON login_bans(ip, unlocks_at)`,
+		readDB.Close()
+		return nil, err
 	}
-	for _, idx := range indexes {
-		if _, err := db.Exec(idx); err != nil {
-			return fmt.Errorf("failed to create index: %w", err)
-		}
+	if current != latest {
+		readDB.Close()
+		return nil, fmt.Errorf("database schema version %d does not match this binary's %d; run the same askflow version as the primary", current, latest)
 	}
-	return nil
+	return &DBPair{Write: readDB, Read: readDB}, nil
 }
 
-// migrateTables adds missing columns to existing tables for backward compatibility.
-func migrateTables(db *sql.DB) error {
-	// Each migration: table, column, DDL to add it
-	migrations := []struct {
-		table  string
-		column string
-		ddl    string
-	}{
-		{"users", "password_hash", "ALTER TABLE users ADD COLUMN password_hash TEXT"},
-		{"users", "email_verified", "ALTER TABLE users ADD COLUMN email_verified INTEGER DEFAULT 0"},
-		{"users", "last_login", "ALTER TABLE users ADD COLUMN last_login DATETIME"},
-		{"users", "created_at", "ALTER TABLE users ADD COLUMN created_at DATETIME DEFAULT CURRENT_TIMESTAMP"},
-		{"users", "default_product_id", "ALTER TABLE users ADD COLUMN default_product_id TEXT DEFAULT ''"},
-		{"chunks", "image_url", "ALTER TABLE chunks ADD COLUMN image_url TEXT DEFAULT ''"},
-		{"documents", "content_hash", "ALTER TABLE documents ADD COLUMN content_hash TEXT DEFAULT ''"},
-		{"pending_questions", "image_data", "ALTER TABLE pending_questions ADD COLUMN image_data TEXT DEFAULT ''"},
-		{"documents", "product_id", "ALTER TABLE documents ADD COLUMN product_id TEXT DEFAULT ''"},
-		{"chunks", "product_id", "ALTER TABLE chunks ADD COLUMN product_id TEXT DEFAULT ''"},
-		{"pending_questions", "product_id", "ALTER TABLE pending_questions ADD COLUMN product_id TEXT DEFAULT ''"},
-		{"admin_users", "permissions", "ALTER TABLE admin_users ADD COLUMN permissions TEXT DEFAULT ''"},
-	}
+// Driver names registered with per-connection pragmas. The pragmas run in a
+// ConnectHook so every connection the pools open gets them, not just the
+// first one.
+const (
+	writeDriverName = "sqlite3_askflow_write"
+	readDriverName  = "sqlite3_askflow_read"
+)
 
-	for _, m := range migrations {
-		if !columnExists(db, m.table, m.column) {
-			if _, err := db.Exec(m.ddl); err != nil {
-				return fmt.Errorf("migration failed (%s.%s): %w", m.table, m.column, err)
-			}
-		}
-	}
-	return nil
+// writePragmas are applied to each write connection.
+var writePragmas = []string{
+	"PRAGMA journal_mode=WAL",
+	"PRAGMA foreign_keys=ON",
+	"PRAGMA busy_timeout=30000",
+	// Checkpoint once the WAL reaches ~4MB (1000 pages)
+	"PRAGMA wal_autocheckpoint=1000",
+	// Truncate the WAL back to 64MB after a checkpoint so a burst of
+	// ingestion doesn't leave a huge -wal file behind
+	"PRAGMA journal_size_limit=67108864",
+	// synchronous=NORMAL is safe with WAL and reduces fsync overhead significantly
+	"PRAGMA synchronous=NORMAL",
+	// 64MB page cache (negative value = KB) vs default ~2MB
+	"PRAGMA cache_size=-65536",
+	// 256MB memory-mapped I/O for faster reads
+	"PRAGMA mmap_size=268435456",
+	// Keep temp tables in memory instead of disk
+	"PRAGMA temp_store=MEMORY",
 }
 
-// columnExists checks if a column exists in a table.
-// Table names are validated against a whitelist to prevent SQL injection.
-func columnExists(db *sql.DB, table, column string) bool {
-	// Whitelist of known tables to prevent SQL injection via table name
-	validTables := map[string]bool{
-		"users": true, "documents": true, "chunks": true,
-		"pending_questions": true, "sessions": true,
-		"email_tokens": true, "admin_users": true,
-		"products": true, "admin_user_products": true,
-		"video_segments": true,
-	}
-	if !validTables[table] {
-		return false
-	}
-	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
-	if err != nil {
-		return false
-	}
-	defer rows.Close()
-	for rows.Next() {
-		var cid int
-		var name, ctype string
-		var notnull int
-		var dfltValue *string
-		var pk int
-		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dfltValue, &pk); err != nil {
-			continue
-		}
-		if name == column {
-			return true
-		}
-	}
-	return false
+// readPragmas are applied to each read-only connection.
+// Skips write-oriented pragmas (journal_mode, synchronous, wal_autocheckpoint)
+// since the read pool never writes.
+var readPragmas = []string{
+	// foreign_keys is needed for correct JOIN behavior on FK columns
+	"PRAGMA foreign_keys=ON",
+	// busy_timeout for when a read briefly contends with a checkpoint
+	"PRAGMA busy_timeout=30000",
+	// 64MB page cache
+	"PRAGMA cache_size=-65536",
+	// 256MB memory-mapped I/O for faster reads
+	"PRAGMA mmap_size=268435456",
+	// Keep temp tables in memory
+	"PRAGMA temp_store=MEMORY",
 }
@@ -0,0 +1,37 @@
+//go:build cgo
+
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	sql.Register(writeDriverName, &sqlite3.SQLiteDriver{ConnectHook: pragmaHook(writePragmas)})
+	sql.Register(readDriverName, &sqlite3.SQLiteDriver{ConnectHook: pragmaHook(readPragmas)})
+}
+
+// pragmaHook returns a ConnectHook that executes pragmas on a new connection.
+func pragmaHook(pragmas []string) func(*sqlite3.SQLiteConn) error {
+	return func(conn *sqlite3.SQLiteConn) error {
+		for _, p := range pragmas {
+			if _, err := conn.Exec(p, nil); err != nil {
+				return fmt.Errorf("failed to execute %s: %w", p, err)
+			}
+		}
+		return nil
+	}
+}
+
+// IsBusy reports whether err is SQLITE_BUSY or SQLITE_LOCKED ("database is locked").
+func IsBusy(err error) bool {
+	var se sqlite3.Error
+	if !errors.As(err, &se) {
+		return false
+	}
+	return se.Code == sqlite3.ErrBusy || se.Code == sqlite3.ErrLocked
+}
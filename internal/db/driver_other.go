@@ -0,0 +1,22 @@
+//go:build !cgo
+
+package db
+
+import (
+	"database/sql"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Without cgo the SQLite driver is a stub whose connections fail to open;
+// the drivers are still registered so Open reports that error.
+func init() {
+	sql.Register(writeDriverName, &sqlite3.SQLiteDriver{})
+	sql.Register(readDriverName, &sqlite3.SQLiteDriver{})
+}
+
+// IsBusy reports whether err is SQLITE_BUSY or SQLITE_LOCKED; never, as no
+// database can be opened without cgo.
+func IsBusy(err error) bool {
+	return false
+}
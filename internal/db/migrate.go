@@ -0,0 +1,311 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Schema changes are versioned SQL files embedded from migrations/:
+//
+//	NNNN_<name>.up.sql    applied by MigrateUp (required)
+//	NNNN_<name>.down.sql  applied by MigrateDown (optional; without it the
+//	                      migration cannot be rolled back)
+//
+// Each migration runs in its own transaction together with its row in
+// schema_version, so a failed migration leaves no partial schema behind.
+// Files are never edited once released; add a new version instead.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is one versioned schema change.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string // empty when the migration is irreversible
+}
+
+// MigrationStatus reports whether a known migration has been applied.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt string
+}
+
+// Migrations returns all embedded migrations ordered by version.
+func Migrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles, "migrations")
+}
+
+// loadMigrations parses NNNN_name.{up,down}.sql files in dir.
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		file := e.Name()
+		base, ok := strings.CutSuffix(file, ".sql")
+		if !ok || e.IsDir() {
+			continue
+		}
+		var direction string
+		if b, ok := strings.CutSuffix(base, ".up"); ok {
+			base, direction = b, "up"
+		} else if b, ok := strings.CutSuffix(base, ".down"); ok {
+			base, direction = b, "down"
+		} else {
+			return nil, fmt.Errorf("migration %s: expected .up.sql or .down.sql suffix", file)
+		}
+		num, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil || version <= 0 || name == "" {
+			return nil, fmt.Errorf("migration %s: expected NNNN_name prefix", file)
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, file))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", file, err)
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration version %d used by both %q and %q", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if strings.TrimSpace(m.Up) == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// ensureVersionTable creates schema_version if needed. A database that has
+// application tables but no schema_version predates migrations: it is brought
+// up to the baseline by the legacy setup and recorded as version 1.
+func ensureVersionTable(db *sql.DB) error {
+	if ok, err := tableExists(db, "schema_version"); err != nil || ok {
+		return err
+	}
+	legacy, err := tableExists(db, "documents")
+	if err != nil {
+		return err
+	}
+	if legacy {
+		if err := upgradeLegacySchema(db); err != nil {
+			return fmt.Errorf("failed to upgrade legacy schema: %w", err)
+		}
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+	if legacy {
+		if _, err := db.Exec(`INSERT OR IGNORE INTO schema_version (version, name) VALUES (1, 'baseline')`); err != nil {
+			return fmt.Errorf("failed to record baseline version: %w", err)
+		}
+	}
+	return nil
+}
+
+// tableExists reports whether a table with the given name exists.
+func tableExists(db *sql.DB, name string) (bool, error) {
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&n); err != nil {
+		return false, fmt.Errorf("failed to inspect schema: %w", err)
+	}
+	return n > 0, nil
+}
+
+// appliedVersions returns applied migration versions mapped to their apply time.
+func appliedVersions(db *sql.DB) (map[int]string, error) {
+	rows, err := db.Query(`SELECT version, COALESCE(applied_at, '') FROM schema_version`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_version: %w", err)
+	}
+	defer rows.Close()
+	applied := make(map[int]string)
+	for rows.Next() {
+		var v int
+		var at string
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, err
+		}
+		applied[v] = at
+	}
+	return applied, rows.Err()
+}
+
+// CurrentVersion returns the highest applied migration version, or 0 for a
+// database that has not been migrated yet.
+func CurrentVersion(db *sql.DB) (int, error) {
+	if ok, err := tableExists(db, "schema_version"); err != nil || !ok {
+		return 0, err
+	}
+	var v sql.NullInt64
+	if err := db.QueryRow(`SELECT MAX(version) FROM schema_version`).Scan(&v); err != nil {
+		return 0, fmt.Errorf("failed to read schema_version: %w", err)
+	}
+	return int(v.Int64), nil
+}
+
+// Status lists every known migration and whether it has been applied.
+// It only reads the database; a pre-migration database reports nothing applied.
+func Status(db *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int]string{}
+	if ok, err := tableExists(db, "schema_version"); err != nil {
+		return nil, err
+	} else if ok {
+		if applied, err = appliedVersions(db); err != nil {
+			return nil, err
+		}
+	}
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		at, ok := applied[m.Version]
+		statuses = append(statuses, MigrationStatus{Version: m.Version, Name: m.Name, Applied: ok, AppliedAt: at})
+	}
+	return statuses, nil
+}
+
+// MigrateUp applies pending migrations in version order up to and including
+// target (0 means the latest) and returns the migrations it applied.
+// It refuses to run against a database whose schema is newer than this binary.
+func MigrateUp(db *sql.DB, target int) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureVersionTable(db); err != nil {
+		return nil, err
+	}
+	latest := 0
+	if len(migrations) > 0 {
+		latest = migrations[len(migrations)-1].Version
+	}
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	if current > latest {
+		return nil, fmt.Errorf("database schema version %d is newer than the latest known migration %d; upgrade askflow", current, latest)
+	}
+	if target == 0 {
+		target = latest
+	}
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		if m.Version > target {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := runMigration(db, m.Up, func(tx *sql.Tx) error {
+			_, err := tx.Exec(`INSERT INTO schema_version (version, name) VALUES (?, ?)`, m.Version, m.Name)
+			return err
+		}); err != nil {
+			return done, fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// MigrateDown rolls back applied migrations newer than target, newest first,
+// and returns the migrations it reverted. It stops at the first migration
+// without a down script.
+func MigrateDown(db *sql.DB, target int) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureVersionTable(db); err != nil {
+		return nil, err
+	}
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[int]bool, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = true
+	}
+	for v := range applied {
+		if v > target && !known[v] {
+			return nil, fmt.Errorf("applied migration %d is unknown to this binary and cannot be rolled back", v)
+		}
+	}
+
+	var done []Migration
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version <= target {
+			break
+		}
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if strings.TrimSpace(m.Down) == "" {
+			return done, fmt.Errorf("migration %04d_%s has no down script", m.Version, m.Name)
+		}
+		if err := runMigration(db, m.Down, func(tx *sql.Tx) error {
+			_, err := tx.Exec(`DELETE FROM schema_version WHERE version = ?`, m.Version)
+			return err
+		}); err != nil {
+			return done, fmt.Errorf("rollback of %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// runMigration executes a migration script and its bookkeeping in one transaction.
+func runMigration(db *sql.DB, script string, record func(*sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// go-sqlite3 executes every statement of a multi-statement script
+	if _, err := tx.Exec(script); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
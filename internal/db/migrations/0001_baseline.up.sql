@@ -0,0 +1,237 @@
+-- Baseline schema: the tables, columns and indexes InitDB created before
+-- versioned migrations were introduced. Databases that predate this file are
+-- upgraded by the frozen legacy setup and then marked as version 1.
+
+CREATE TABLE IF NOT EXISTS documents (
+	id           TEXT PRIMARY KEY,
+	name         TEXT NOT NULL,
+	type         TEXT NOT NULL,
+	status       TEXT NOT NULL,
+	error        TEXT,
+	created_at   DATETIME DEFAULT CURRENT_TIMESTAMP,
+	content_hash TEXT DEFAULT '',
+	product_id   TEXT DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS chunks (
+	id            TEXT PRIMARY KEY,
+	document_id   TEXT NOT NULL,
+	document_name TEXT NOT NULL,
+	chunk_index   INTEGER NOT NULL,
+	chunk_text    TEXT NOT NULL,
+	embedding     BLOB NOT NULL,
+	image_url     TEXT DEFAULT '',
+	created_at    DATETIME DEFAULT CURRENT_TIMESTAMP,
+	product_id    TEXT DEFAULT '',
+	FOREIGN KEY (document_id) REFERENCES documents(id)
+);
+
+CREATE TABLE IF NOT EXISTS pending_questions (
+	id          TEXT PRIMARY KEY,
+	question    TEXT NOT NULL,
+	user_id     TEXT NOT NULL,
+	status      TEXT NOT NULL,
+	answer      TEXT,
+	llm_answer  TEXT,
+	created_at  DATETIME DEFAULT CURRENT_TIMESTAMP,
+	answered_at DATETIME,
+	image_data  TEXT DEFAULT '',
+	product_id  TEXT DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS users (
+	id                 TEXT PRIMARY KEY,
+	email              TEXT UNIQUE,
+	name               TEXT,
+	provider           TEXT NOT NULL,
+	provider_id        TEXT NOT NULL,
+	password_hash      TEXT,
+	email_verified     INTEGER DEFAULT 0,
+	created_at         DATETIME DEFAULT CURRENT_TIMESTAMP,
+	last_login         DATETIME,
+	default_product_id TEXT DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS email_tokens (
+	id         TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL,
+	token      TEXT NOT NULL UNIQUE,
+	type       TEXT NOT NULL,
+	expires_at DATETIME NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id         TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL,
+	expires_at DATETIME NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	id         TEXT PRIMARY KEY,
+	family_id  TEXT NOT NULL,
+	session_id TEXT NOT NULL,
+	user_id    TEXT NOT NULL,
+	expires_at DATETIME NOT NULL,
+	used_at    DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS video_segments (
+	id           TEXT PRIMARY KEY,
+	document_id  TEXT NOT NULL,
+	segment_type TEXT NOT NULL,
+	start_time   REAL NOT NULL,
+	end_time     REAL NOT NULL,
+	content      TEXT NOT NULL,
+	chunk_id     TEXT NOT NULL,
+	FOREIGN KEY (document_id) REFERENCES documents(id)
+);
+
+CREATE TABLE IF NOT EXISTS sn_users (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	email         TEXT UNIQUE NOT NULL,
+	display_name  TEXT NOT NULL,
+	sn            TEXT DEFAULT '',
+	last_login_at DATETIME,
+	created_at    DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS login_tickets (
+	ticket     TEXT PRIMARY KEY,
+	user_id    INTEGER NOT NULL,
+	used       INTEGER DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	expires_at DATETIME NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES sn_users(id)
+);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+	id               TEXT PRIMARY KEY,
+	url              TEXT NOT NULL,
+	secret           TEXT NOT NULL,
+	events           TEXT NOT NULL DEFAULT '',
+	enabled          INTEGER NOT NULL DEFAULT 1,
+	last_status      INTEGER DEFAULT 0,
+	last_error       TEXT DEFAULT '',
+	last_delivery_at DATETIME,
+	created_at       DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	actor_id    TEXT NOT NULL,
+	actor_name  TEXT DEFAULT '',
+	actor_role  TEXT DEFAULT '',
+	ip          TEXT DEFAULT '',
+	action      TEXT NOT NULL,
+	method      TEXT NOT NULL,
+	path        TEXT NOT NULL,
+	resource_id TEXT DEFAULT '',
+	status      INTEGER DEFAULT 0,
+	before_data TEXT DEFAULT '',
+	after_data  TEXT DEFAULT '',
+	created_at  DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS admin_users (
+	id            TEXT PRIMARY KEY,
+	username      TEXT NOT NULL UNIQUE,
+	password_hash TEXT NOT NULL,
+	role          TEXT NOT NULL DEFAULT 'editor',
+	created_at    DATETIME DEFAULT CURRENT_TIMESTAMP,
+	permissions   TEXT DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS products (
+	id              TEXT PRIMARY KEY,
+	name            TEXT NOT NULL UNIQUE,
+	description     TEXT DEFAULT '',
+	welcome_message TEXT DEFAULT '',
+	created_at      DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at      DATETIME DEFAULT CURRENT_TIMESTAMP,
+	type            TEXT DEFAULT 'service',
+	allow_download  INTEGER DEFAULT 0,
+	widget_origins  TEXT DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS admin_user_products (
+	admin_user_id TEXT NOT NULL,
+	product_id    TEXT NOT NULL,
+	PRIMARY KEY (admin_user_id, product_id),
+	FOREIGN KEY (admin_user_id) REFERENCES admin_users(id) ON DELETE CASCADE,
+	FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	username   TEXT NOT NULL,
+	ip         TEXT NOT NULL,
+	success    INTEGER NOT NULL DEFAULT 0,
+	created_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS login_bans (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	username   TEXT NOT NULL DEFAULT '',
+	ip         TEXT NOT NULL DEFAULT '',
+	reason     TEXT NOT NULL DEFAULT '',
+	unlocks_at TEXT NOT NULL,
+	created_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS admin_roles (
+	id          TEXT PRIMARY KEY,
+	name        TEXT NOT NULL UNIQUE,
+	description TEXT DEFAULT '',
+	permissions TEXT NOT NULL DEFAULT '',
+	builtin     INTEGER NOT NULL DEFAULT 0,
+	created_at  DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS admin_role_grants (
+	admin_user_id TEXT NOT NULL,
+	product_id    TEXT NOT NULL,
+	role_id       TEXT NOT NULL,
+	PRIMARY KEY (admin_user_id, product_id),
+	FOREIGN KEY (admin_user_id) REFERENCES admin_users(id) ON DELETE CASCADE,
+	FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE CASCADE,
+	FOREIGN KEY (role_id) REFERENCES admin_roles(id)
+);
+
+-- The editor role keeps the permissions editors had before roles existed
+INSERT OR IGNORE INTO admin_roles (id, name, description, permissions, builtin)
+	VALUES ('editor', '编辑', '管理文档、回答待处理问题、查看统计', 'manage_docs,answer_pending,view_analytics', 1);
+
+CREATE INDEX IF NOT EXISTS idx_chunks_document_id ON chunks(document_id);
+CREATE INDEX IF NOT EXISTS idx_documents_content_hash ON documents(content_hash);
+CREATE INDEX IF NOT EXISTS idx_documents_status ON documents(status);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
+CREATE INDEX IF NOT EXISTS idx_email_tokens_token ON email_tokens(token);
+CREATE INDEX IF NOT EXISTS idx_documents_product_id ON documents(product_id);
+CREATE INDEX IF NOT EXISTS idx_chunks_product_id ON chunks(product_id);
+CREATE INDEX IF NOT EXISTS idx_video_segments_chunk_id ON video_segments(chunk_id);
+CREATE INDEX IF NOT EXISTS idx_video_segments_document_id ON video_segments(document_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session_id ON refresh_tokens(session_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires_at ON refresh_tokens(expires_at);
+CREATE INDEX IF NOT EXISTS idx_pending_questions_status ON pending_questions(status);
+CREATE INDEX IF NOT EXISTS idx_pending_questions_product_id ON pending_questions(product_id);
+CREATE INDEX IF NOT EXISTS idx_sn_users_email ON sn_users(email);
+CREATE INDEX IF NOT EXISTS idx_login_tickets_user_id ON login_tickets(user_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor_id ON audit_log(actor_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action, created_at);
+
+-- Composite indexes for login_attempts covering CheckAllowed correlated subqueries
+CREATE INDEX IF NOT EXISTS idx_login_attempts_username_success ON login_attempts(username, success, created_at);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_ip_success ON login_attempts(ip, success, created_at);
+
+-- login_bans: covering index for ban lookups by username/ip + expiry
+CREATE INDEX IF NOT EXISTS idx_login_bans_username_unlocks ON login_bans(username, unlocks_at);
+CREATE INDEX IF NOT EXISTS idx_login_bans_ip_unlocks ON login_bans(ip, unlocks_at);
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_admin_users_tenant_id;
+DROP INDEX IF EXISTS idx_products_tenant_id;
+
+ALTER TABLE admin_users DROP COLUMN tenant_id;
+ALTER TABLE products DROP COLUMN tenant_id;
+
+DROP TABLE IF EXISTS tenant_usage;
+DROP TABLE IF EXISTS tenants;
@@ -0,0 +1,32 @@
+-- Tenant workspaces: each tenant owns a set of products (and through them
+-- documents, chunks and pending questions) plus its own admin accounts.
+-- tenant_id '' is the default workspace that existing data belongs to.
+
+CREATE TABLE IF NOT EXISTS tenants (
+	id                  TEXT PRIMARY KEY,
+	slug                TEXT NOT NULL UNIQUE,
+	name                TEXT NOT NULL,
+	status              TEXT NOT NULL DEFAULT 'active',
+	product_name        TEXT NOT NULL DEFAULT '',
+	product_intro       TEXT NOT NULL DEFAULT '',
+	max_products        INTEGER NOT NULL DEFAULT 0,
+	max_documents       INTEGER NOT NULL DEFAULT 0,
+	max_admins          INTEGER NOT NULL DEFAULT 0,
+	max_queries_per_day INTEGER NOT NULL DEFAULT 0,
+	created_at          DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at          DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS tenant_usage (
+	tenant_id TEXT NOT NULL,
+	day       TEXT NOT NULL,
+	queries   INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (tenant_id, day),
+	FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE
+);
+
+ALTER TABLE products ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE admin_users ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_products_tenant_id ON products(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_admin_users_tenant_id ON admin_users(tenant_id);
@@ -0,0 +1,24 @@
+-- Usage accounting: monthly counters of queries and API tokens per subject
+-- (scope 'user' or 'product'), plus per-subject quota overrides of the
+-- instance-wide usage.* defaults.
+
+CREATE TABLE IF NOT EXISTS usage_counters (
+	period            TEXT NOT NULL, -- UTC month, YYYY-MM
+	scope             TEXT NOT NULL,
+	subject_id        TEXT NOT NULL,
+	queries           INTEGER NOT NULL DEFAULT 0,
+	embedding_tokens  INTEGER NOT NULL DEFAULT 0,
+	prompt_tokens     INTEGER NOT NULL DEFAULT 0,
+	completion_tokens INTEGER NOT NULL DEFAULT 0,
+	updated_at        DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (period, scope, subject_id)
+);
+
+CREATE TABLE IF NOT EXISTS usage_quotas (
+	scope                TEXT NOT NULL,
+	subject_id           TEXT NOT NULL,
+	max_monthly_queries  INTEGER NOT NULL DEFAULT 0,
+	max_monthly_tokens   INTEGER NOT NULL DEFAULT 0,
+	updated_at           DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (scope, subject_id)
+);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS query_feedback;
+DROP INDEX IF EXISTS idx_experiment_exposures_experiment;
+DROP TABLE IF EXISTS experiment_exposures;
+DROP TABLE IF EXISTS experiments;
@@ -0,0 +1,36 @@
+-- Retrieval experiments: A/B tests of retrieval settings. Each query made
+-- while an experiment runs is logged as an exposure with the variant it was
+-- served; answer feedback from users is joined to exposures by query ID.
+
+CREATE TABLE IF NOT EXISTS experiments (
+	id          TEXT PRIMARY KEY,
+	name        TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	status      TEXT NOT NULL DEFAULT 'draft', -- draft, running, stopped
+	variants    TEXT NOT NULL DEFAULT '[]',    -- JSON array of {name, percent, params}
+	started_at  DATETIME,
+	stopped_at  DATETIME,
+	created_at  DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at  DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS experiment_exposures (
+	query_id      TEXT PRIMARY KEY,
+	experiment_id TEXT NOT NULL,
+	variant       TEXT NOT NULL,
+	user_id       TEXT NOT NULL DEFAULT '',
+	product_id    TEXT NOT NULL DEFAULT '',
+	pending       INTEGER NOT NULL DEFAULT 0, -- 1 when the question could not be answered
+	source_count  INTEGER NOT NULL DEFAULT 0,
+	latency_ms    INTEGER NOT NULL DEFAULT 0,
+	created_at    DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_experiment_exposures_experiment ON experiment_exposures(experiment_id, variant);
+
+CREATE TABLE IF NOT EXISTS query_feedback (
+	query_id   TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL,
+	helpful    INTEGER NOT NULL, -- 1 helpful, 0 not helpful
+	comment    TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
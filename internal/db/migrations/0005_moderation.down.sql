@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_moderation_queue_status;
+DROP TABLE IF EXISTS moderation_queue;
+DROP TABLE IF EXISTS moderation_policies;
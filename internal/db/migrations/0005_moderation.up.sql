@@ -0,0 +1,28 @@
+-- Content moderation: per-product policies for masking personal data and
+-- blocking prohibited content, and the queue of blocked items awaiting an
+-- admin's decision.
+
+CREATE TABLE IF NOT EXISTS moderation_policies (
+	product_id       TEXT PRIMARY KEY, -- '' is the default for products without a policy
+	redact_email     INTEGER NOT NULL DEFAULT 1,
+	redact_phone     INTEGER NOT NULL DEFAULT 1,
+	redact_id_number INTEGER NOT NULL DEFAULT 1,
+	blocked_terms    TEXT NOT NULL DEFAULT '[]', -- JSON array of case-insensitive terms
+	updated_at       DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS moderation_queue (
+	id            TEXT PRIMARY KEY,
+	source        TEXT NOT NULL, -- document, query
+	product_id    TEXT NOT NULL DEFAULT '',
+	document_id   TEXT NOT NULL DEFAULT '',
+	document_name TEXT NOT NULL DEFAULT '',
+	user_id       TEXT NOT NULL DEFAULT '',
+	term          TEXT NOT NULL,
+	excerpt       TEXT NOT NULL DEFAULT '', -- text around the match, personal data masked
+	status        TEXT NOT NULL DEFAULT 'pending', -- pending, approved, rejected
+	reviewed_by   TEXT NOT NULL DEFAULT '',
+	reviewed_at   DATETIME,
+	created_at    DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_moderation_queue_status ON moderation_queue(status, created_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_images_document;
+DROP TABLE IF EXISTS images;
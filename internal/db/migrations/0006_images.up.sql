@@ -0,0 +1,14 @@
+-- Managed image blobs: which product and document each stored image under
+-- data/images belongs to, so /api/images/{id} can check product access.
+-- Images saved before this migration have no row and are treated as
+-- belonging to the public library.
+
+CREATE TABLE IF NOT EXISTS images (
+	id           TEXT PRIMARY KEY, -- file name under data/images, e.g. <hex>.png
+	product_id   TEXT NOT NULL DEFAULT '',
+	document_id  TEXT NOT NULL DEFAULT '',
+	content_type TEXT NOT NULL DEFAULT '',
+	size         INTEGER NOT NULL DEFAULT 0,
+	created_at   DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_images_document ON images(document_id);
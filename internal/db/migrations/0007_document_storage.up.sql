@@ -0,0 +1,6 @@
+-- Storage key of each document's original file in the configured storage
+-- backend (local disk or S3), e.g. uploads/<id>/manual.pdf. Documents
+-- uploaded before this migration have an empty key; their originals are
+-- looked up under data/uploads/<id>/ on local disk.
+
+ALTER TABLE documents ADD COLUMN storage_key TEXT NOT NULL DEFAULT '';
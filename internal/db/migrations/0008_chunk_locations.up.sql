@@ -0,0 +1,14 @@
+-- Where each text chunk came from in its original document, so answers can
+-- link to the exact page or slide. Offsets are rune offsets into the parsed
+-- document text; page and slide are 1-based and 0 when unknown. Documents
+-- imported before this migration have no rows.
+
+CREATE TABLE IF NOT EXISTS chunk_locations (
+	chunk_id     TEXT PRIMARY KEY, -- <document_id>-<chunk_index>, as in video_segments
+	document_id  TEXT NOT NULL,
+	start_offset INTEGER NOT NULL DEFAULT 0,
+	end_offset   INTEGER NOT NULL DEFAULT 0,
+	page         INTEGER NOT NULL DEFAULT 0,
+	slide        INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_chunk_locations_document ON chunk_locations(document_id);
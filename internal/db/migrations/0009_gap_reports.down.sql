@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_gap_reports_created;
+DROP TABLE IF EXISTS gap_reports;
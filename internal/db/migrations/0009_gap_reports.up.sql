@@ -0,0 +1,14 @@
+-- Knowledge gap reports: questions that went pending in a period, clustered
+-- by embedding similarity and labelled with an LLM-generated topic, so admins
+-- can see which documentation is missing.
+
+CREATE TABLE IF NOT EXISTS gap_reports (
+	id             TEXT PRIMARY KEY,
+	trigger_source TEXT NOT NULL DEFAULT 'manual', -- schedule, manual
+	period_start   DATETIME NOT NULL,
+	period_end     DATETIME NOT NULL,
+	question_count INTEGER NOT NULL DEFAULT 0,
+	topics         TEXT NOT NULL DEFAULT '[]', -- JSON array of {topic, count, pending, product_ids, questions}
+	created_at     DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_gap_reports_created ON gap_reports(created_at);
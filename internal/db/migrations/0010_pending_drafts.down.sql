@@ -0,0 +1,4 @@
+ALTER TABLE pending_questions DROP COLUMN drafted_at;
+ALTER TABLE pending_questions DROP COLUMN draft_status;
+ALTER TABLE pending_questions DROP COLUMN draft_sources;
+ALTER TABLE pending_questions DROP COLUMN draft_answer;
@@ -0,0 +1,9 @@
+-- Suggested answers drafted by the LLM for pending questions, for the admin
+-- to approve or edit. draft_status is '' (never drafted), 'drafting',
+-- 'ready', 'no_context' (nothing relevant found) or 'failed'; draft_sources
+-- is a JSON array of the documents the draft was based on.
+
+ALTER TABLE pending_questions ADD COLUMN draft_answer TEXT NOT NULL DEFAULT '';
+ALTER TABLE pending_questions ADD COLUMN draft_sources TEXT NOT NULL DEFAULT '';
+ALTER TABLE pending_questions ADD COLUMN draft_status TEXT NOT NULL DEFAULT '';
+ALTER TABLE pending_questions ADD COLUMN drafted_at DATETIME;
@@ -0,0 +1,5 @@
+-- Content hash of each stored image, so an answer shows an image that was
+-- extracted from several documents (or several times) only once. Images
+-- recorded before this migration have an empty hash and are compared by URL.
+
+ALTER TABLE images ADD COLUMN sha256 TEXT NOT NULL DEFAULT '';
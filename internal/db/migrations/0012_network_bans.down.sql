@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_network_bans_unlocks;
+DROP TABLE IF EXISTS network_bans;
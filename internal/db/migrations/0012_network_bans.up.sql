@@ -0,0 +1,13 @@
+-- Network bans: CIDR ranges blocked from the whole API until unlocks_at, so
+-- an attacker cannot get around an IP ban by moving to a neighbouring
+-- address. Single addresses are stored as /32 or /128.
+
+CREATE TABLE IF NOT EXISTS network_bans (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	cidr       TEXT NOT NULL,
+	reason     TEXT NOT NULL DEFAULT '',
+	created_by TEXT NOT NULL DEFAULT '',
+	unlocks_at TEXT NOT NULL,
+	created_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_network_bans_unlocks ON network_bans(unlocks_at);
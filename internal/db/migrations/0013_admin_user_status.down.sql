@@ -0,0 +1,2 @@
+ALTER TABLE admin_users DROP COLUMN disabled;
+ALTER TABLE admin_users DROP COLUMN email;
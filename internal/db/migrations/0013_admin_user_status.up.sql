@@ -0,0 +1,5 @@
+-- Admin sub-account email and disabled state. An invited admin has an empty
+-- password_hash until the invitation link is used to set a password.
+
+ALTER TABLE admin_users ADD COLUMN email TEXT NOT NULL DEFAULT '';
+ALTER TABLE admin_users ADD COLUMN disabled INTEGER NOT NULL DEFAULT 0;
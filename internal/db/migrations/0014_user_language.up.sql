@@ -0,0 +1,4 @@
+-- Preferred language of API messages for the user ('' = negotiate from
+-- Accept-Language).
+
+ALTER TABLE users ADD COLUMN language TEXT NOT NULL DEFAULT '';
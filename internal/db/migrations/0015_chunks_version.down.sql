@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS chunks_version_delete;
+DROP TRIGGER IF EXISTS chunks_version_update;
+DROP TRIGGER IF EXISTS chunks_version_insert;
+DROP TABLE IF EXISTS chunks_version;
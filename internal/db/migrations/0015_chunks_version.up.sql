@@ -0,0 +1,24 @@
+-- Counter bumped by every change to chunks, so the vector store can tell
+-- whether its snapshot file still matches the table. Triggers also catch
+-- changes made outside the store (product deletion, fsck repairs, the CLI).
+-- The random epoch tells databases apart whose counters happen to agree.
+
+CREATE TABLE IF NOT EXISTS chunks_version (
+    id      INTEGER PRIMARY KEY CHECK (id = 1),
+    version INTEGER NOT NULL DEFAULT 0,
+    epoch   TEXT NOT NULL DEFAULT (lower(hex(randomblob(16))))
+);
+INSERT OR IGNORE INTO chunks_version (id, version) VALUES (1, 0);
+
+CREATE TRIGGER IF NOT EXISTS chunks_version_insert AFTER INSERT ON chunks
+BEGIN
+    UPDATE chunks_version SET version = version + 1 WHERE id = 1;
+END;
+CREATE TRIGGER IF NOT EXISTS chunks_version_update AFTER UPDATE ON chunks
+BEGIN
+    UPDATE chunks_version SET version = version + 1 WHERE id = 1;
+END;
+CREATE TRIGGER IF NOT EXISTS chunks_version_delete AFTER DELETE ON chunks
+BEGIN
+    UPDATE chunks_version SET version = version + 1 WHERE id = 1;
+END;
@@ -0,0 +1,5 @@
+-- Admin-set ranking priority of each document: search scores of its chunks
+-- are multiplied by it (1 = neutral), e.g. 0.3 for manuals of a deprecated
+-- release.
+
+ALTER TABLE documents ADD COLUMN priority REAL NOT NULL DEFAULT 1;
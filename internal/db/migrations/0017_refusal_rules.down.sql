@@ -0,0 +1 @@
+DROP TABLE IF EXISTS refusal_rules;
@@ -0,0 +1,14 @@
+-- The "do not answer" list: topics the assistant declines, matched by
+-- keyword or by similarity to example questions.
+
+CREATE TABLE IF NOT EXISTS refusal_rules (
+	id         TEXT PRIMARY KEY,
+	product_id TEXT NOT NULL DEFAULT '', -- '' applies to all products
+	topic      TEXT NOT NULL,
+	keywords   TEXT NOT NULL DEFAULT '[]', -- JSON array of case-insensitive terms
+	examples   TEXT NOT NULL DEFAULT '[]', -- JSON array of example questions
+	message    TEXT NOT NULL DEFAULT '',   -- '' uses refusal.message
+	enabled    INTEGER NOT NULL DEFAULT 1,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
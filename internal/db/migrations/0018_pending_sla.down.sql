@@ -0,0 +1,3 @@
+ALTER TABLE pending_questions DROP COLUMN escalated_at;
+ALTER TABLE pending_questions DROP COLUMN escalation_level;
+DROP TABLE IF EXISTS pending_sla_policies;
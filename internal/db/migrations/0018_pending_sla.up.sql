@@ -0,0 +1,15 @@
+-- Response time targets for pending questions. A question still pending
+-- response_hours after it was asked is overdue; its escalation_level rises by
+-- one for every further response_hours, up to max_reminders, and each rise
+-- sends a reminder to the policy's recipients.
+
+CREATE TABLE IF NOT EXISTS pending_sla_policies (
+	product_id     TEXT PRIMARY KEY, -- '' is the default for products without a policy
+	response_hours INTEGER NOT NULL,
+	max_reminders  INTEGER NOT NULL DEFAULT 3,
+	recipients     TEXT NOT NULL DEFAULT '[]', -- JSON array of email addresses
+	updated_at     DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE pending_questions ADD COLUMN escalation_level INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE pending_questions ADD COLUMN escalated_at DATETIME;
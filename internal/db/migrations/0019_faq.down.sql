@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS faq_entries;
+DROP TABLE IF EXISTS faq_query_log;
@@ -0,0 +1,23 @@
+-- Generated FAQ: answered questions are logged while faq.enabled is set, and
+-- the FAQ job merges them with the pending questions admins answered into
+-- per-product FAQ entries. Logged questions carry no user ID.
+
+CREATE TABLE IF NOT EXISTS faq_query_log (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	product_id TEXT NOT NULL DEFAULT '',
+	question   TEXT NOT NULL,
+	answer     TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_faq_query_log_created_at ON faq_query_log(created_at);
+
+CREATE TABLE IF NOT EXISTS faq_entries (
+	product_id   TEXT NOT NULL DEFAULT '',
+	position     INTEGER NOT NULL,
+	question     TEXT NOT NULL,
+	answer       TEXT NOT NULL,
+	source       TEXT NOT NULL, -- pending (an admin answer) or query (answered automatically)
+	ask_count    INTEGER NOT NULL DEFAULT 1,
+	generated_at DATETIME NOT NULL,
+	PRIMARY KEY (product_id, position)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE documents DROP COLUMN scanned_at;
+ALTER TABLE documents DROP COLUMN scan_detail;
+ALTER TABLE documents DROP COLUMN scan_status;
@@ -0,0 +1,8 @@
+-- Malware scan verdict of uploaded files (scan.enabled): clean, infected or
+-- error (accepted unscanned with scan.fail_open). scan_detail holds the
+-- signature found or the scanner error. Documents not scanned keep an empty
+-- status.
+
+ALTER TABLE documents ADD COLUMN scan_status TEXT NOT NULL DEFAULT '';
+ALTER TABLE documents ADD COLUMN scan_detail TEXT NOT NULL DEFAULT '';
+ALTER TABLE documents ADD COLUMN scanned_at DATETIME;
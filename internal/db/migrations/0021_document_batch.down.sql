@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_documents_batch_id;
+ALTER TABLE documents DROP COLUMN batch_id;
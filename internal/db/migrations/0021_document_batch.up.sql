@@ -0,0 +1,5 @@
+-- Documents imported together from one ZIP archive share a batch ID, so the
+-- files of an upload can be listed and followed as a group.
+
+ALTER TABLE documents ADD COLUMN batch_id TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_documents_batch_id ON documents(batch_id) WHERE batch_id != '';
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS connector_pages;
+DROP TABLE IF EXISTS connectors;
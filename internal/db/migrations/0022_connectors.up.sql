@@ -0,0 +1,35 @@
+-- Connectors sync pages from Confluence Cloud spaces and Notion workspaces
+-- into documents of a product on a cron schedule. api_token is encrypted
+-- with the config encryption key. connector_pages maps each synced page to
+-- its document and keeps the page hierarchy and the modification time the
+-- document was built from, so unchanged pages are skipped.
+
+CREATE TABLE IF NOT EXISTS connectors (
+	id           TEXT PRIMARY KEY,
+	product_id   TEXT NOT NULL DEFAULT '',
+	type         TEXT NOT NULL, -- confluence or notion
+	name         TEXT NOT NULL,
+	base_url     TEXT NOT NULL DEFAULT '', -- Confluence site, e.g. https://example.atlassian.net/wiki
+	email        TEXT NOT NULL DEFAULT '', -- Confluence account of the API token
+	api_token    TEXT NOT NULL,
+	scope        TEXT NOT NULL DEFAULT '', -- Confluence space key or Notion root page ID
+	schedule     TEXT NOT NULL,
+	enabled      INTEGER NOT NULL DEFAULT 1,
+	last_sync_at DATETIME,
+	last_error   TEXT NOT NULL DEFAULT '',
+	last_stats   TEXT NOT NULL DEFAULT '{}', -- JSON counts of the last sync
+	created_at   DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_connectors_product_id ON connectors(product_id);
+
+CREATE TABLE IF NOT EXISTS connector_pages (
+	connector_id TEXT NOT NULL,
+	page_id      TEXT NOT NULL,
+	document_id  TEXT NOT NULL,
+	title        TEXT NOT NULL,
+	parent_id    TEXT NOT NULL DEFAULT '',
+	path         TEXT NOT NULL DEFAULT '', -- ancestor titles, " / " separated
+	url          TEXT NOT NULL DEFAULT '',
+	modified_at  DATETIME, -- NULL until the page was imported successfully
+	PRIMARY KEY (connector_id, page_id)
+);
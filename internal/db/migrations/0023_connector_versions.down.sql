@@ -0,0 +1,4 @@
+ALTER TABLE documents DROP COLUMN source_version;
+ALTER TABLE connector_pages DROP COLUMN version;
+ALTER TABLE connector_pages DROP COLUMN revision;
+ALTER TABLE connectors DROP COLUMN branch;
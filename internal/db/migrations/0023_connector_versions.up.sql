@@ -0,0 +1,9 @@
+-- Git connectors sync Markdown and HTML files of a repository branch. A
+-- page's revision (the Git blob SHA) detects changed files; its version (the
+-- commit SHA, or the Confluence page version) is recorded on the document so
+-- answers cite the exact version of the docs they come from.
+
+ALTER TABLE connectors ADD COLUMN branch TEXT NOT NULL DEFAULT '';
+ALTER TABLE connector_pages ADD COLUMN revision TEXT NOT NULL DEFAULT '';
+ALTER TABLE connector_pages ADD COLUMN version TEXT NOT NULL DEFAULT '';
+ALTER TABLE documents ADD COLUMN source_version TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE connectors DROP COLUMN region;
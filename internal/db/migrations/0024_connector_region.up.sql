@@ -0,0 +1,4 @@
+-- S3 connectors watch a bucket of an S3-compatible object store; region is
+-- the signing region of the bucket.
+
+ALTER TABLE connectors ADD COLUMN region TEXT NOT NULL DEFAULT '';
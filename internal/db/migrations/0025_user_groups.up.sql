@@ -0,0 +1,15 @@
+-- End-user groups: products and documents listed by a group are only
+-- retrieved for the members of the groups listing them. Members are matched
+-- by their verified email address or its domain.
+
+CREATE TABLE IF NOT EXISTS user_groups (
+	id           TEXT PRIMARY KEY,
+	name         TEXT NOT NULL,
+	description  TEXT NOT NULL DEFAULT '',
+	emails       TEXT NOT NULL DEFAULT '[]', -- JSON array of lowercased member addresses
+	domains      TEXT NOT NULL DEFAULT '[]', -- JSON array of member email domains
+	product_ids  TEXT NOT NULL DEFAULT '[]', -- JSON array of restricted products
+	document_ids TEXT NOT NULL DEFAULT '[]', -- JSON array of restricted documents
+	created_at   DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at   DATETIME DEFAULT CURRENT_TIMESTAMP
+);
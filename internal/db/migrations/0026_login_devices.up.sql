@@ -0,0 +1,12 @@
+-- The device a login (refresh token family) was made from, so that users can
+-- see where they are signed in and sign out a device remotely.
+
+CREATE TABLE IF NOT EXISTS login_devices (
+	family_id  TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL,
+	user_agent TEXT NOT NULL DEFAULT '',
+	ip_address TEXT NOT NULL DEFAULT '', -- last address the login was used or refreshed from
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_devices_user_id ON login_devices(user_id);
@@ -0,0 +1,5 @@
+-- Answers of a product whose confidence is below escalation_threshold are
+-- returned flagged as unverified and also sent to the pending queue; 0
+-- turns escalation off.
+
+ALTER TABLE products ADD COLUMN escalation_threshold REAL NOT NULL DEFAULT 0;
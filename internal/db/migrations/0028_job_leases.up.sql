@@ -0,0 +1,12 @@
+-- Leases that background jobs take before running, so that instances
+-- sharing the database do not run the same scheduled job twice. slot is the
+-- scheduled time of the last scheduled run, which later instances waking up
+-- for the same time skip.
+
+CREATE TABLE IF NOT EXISTS job_leases (
+	name        TEXT PRIMARY KEY,
+	owner       TEXT NOT NULL,
+	acquired_at TEXT NOT NULL,
+	expires_at  TEXT NOT NULL, -- a lease is free once this has passed
+	slot        TEXT NOT NULL DEFAULT ''
+);
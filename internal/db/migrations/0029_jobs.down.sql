@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_jobs_status;
+DROP INDEX IF EXISTS idx_jobs_started;
+DROP TABLE IF EXISTS jobs;
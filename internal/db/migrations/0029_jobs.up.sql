@@ -0,0 +1,21 @@
+-- Background work (document ingestion, backups, maintenance, connector
+-- syncs, FAQ generation) with its status and progress, for the job
+-- dashboard. Times are fixed-width UTC text that compares correctly.
+
+CREATE TABLE IF NOT EXISTS jobs (
+	id          TEXT PRIMARY KEY,
+	kind        TEXT NOT NULL,
+	title       TEXT NOT NULL DEFAULT '',
+	status      TEXT NOT NULL,             -- running, succeeded, failed or canceled
+	progress    INTEGER NOT NULL DEFAULT 0, -- percent
+	message     TEXT NOT NULL DEFAULT '',
+	error       TEXT NOT NULL DEFAULT '',
+	params      TEXT NOT NULL DEFAULT '{}', -- what a retry needs, by kind
+	owner       TEXT NOT NULL DEFAULT '',   -- instance running the job
+	started_at  TEXT NOT NULL,
+	updated_at  TEXT NOT NULL,             -- refreshed while running
+	finished_at TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_started ON jobs(started_at);
+CREATE INDEX IF NOT EXISTS idx_jobs_status ON jobs(status, updated_at);
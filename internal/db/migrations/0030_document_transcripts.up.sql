@@ -0,0 +1,10 @@
+-- The time-coded transcript of a video document, from speech recognition or
+-- an uploaded subtitle file, exported as WebVTT for the player. Uploaded
+-- subtitles replace speech recognition, also when the video is reprocessed.
+
+CREATE TABLE IF NOT EXISTS document_transcripts (
+	document_id TEXT PRIMARY KEY,
+	source      TEXT NOT NULL,             -- asr or subtitle
+	segments    TEXT NOT NULL DEFAULT '[]', -- JSON array of transcript segments
+	updated_at  DATETIME DEFAULT CURRENT_TIMESTAMP
+);
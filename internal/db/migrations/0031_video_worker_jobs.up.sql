@@ -0,0 +1,23 @@
+-- Video parsing (ffmpeg and speech-to-text) handed to remote video workers.
+-- Workers claim queued jobs and keep the claim alive while they run; a job
+-- whose claim expires goes back to the queue. Times are fixed-width UTC
+-- text that compares correctly.
+
+CREATE TABLE IF NOT EXISTS video_worker_jobs (
+	id              TEXT PRIMARY KEY,
+	document_id     TEXT NOT NULL,
+	file_name       TEXT NOT NULL DEFAULT '',
+	skip_transcript INTEGER NOT NULL DEFAULT 0, -- the document has uploaded subtitles
+	status          TEXT NOT NULL,              -- queued, running, done, failed or canceled
+	worker          TEXT NOT NULL DEFAULT '',   -- name of the worker holding the job
+	attempts        INTEGER NOT NULL DEFAULT 0,
+	step            TEXT NOT NULL DEFAULT '',   -- progress reported by the worker
+	step_done       INTEGER NOT NULL DEFAULT 0,
+	step_total      INTEGER NOT NULL DEFAULT 0,
+	error           TEXT NOT NULL DEFAULT '',
+	created_at      TEXT NOT NULL,
+	expires_at      TEXT NOT NULL DEFAULT '',   -- claim expiry, pushed forward by the worker
+	finished_at     TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_video_worker_jobs_status ON video_worker_jobs(status, created_at);
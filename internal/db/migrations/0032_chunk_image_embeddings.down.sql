@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_chunk_image_embeddings_document;
+DROP TABLE IF EXISTS chunk_image_embeddings;
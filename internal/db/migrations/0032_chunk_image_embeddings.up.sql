@@ -0,0 +1,14 @@
+-- Vectors of document images from the image embedding model configured
+-- under image_embedding, for matching images attached to questions against
+-- the images in documents. They are kept out of chunks.embedding because the
+-- model has its own vector space and dimension; rows of another model than
+-- the configured one are ignored.
+
+CREATE TABLE IF NOT EXISTS chunk_image_embeddings (
+	chunk_id    TEXT PRIMARY KEY, -- chunks.id of the image chunk
+	document_id TEXT NOT NULL,
+	model       TEXT NOT NULL,    -- image_embedding.model_name the vector came from
+	embedding   BLOB NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_chunk_image_embeddings_document ON chunk_image_embeddings(document_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_query_answers_created;
+DROP INDEX IF EXISTS idx_query_answers_user;
+DROP TABLE IF EXISTS query_answers;
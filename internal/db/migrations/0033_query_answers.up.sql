@@ -0,0 +1,16 @@
+-- Answers given by /api/query, kept for a while by query ID so the user who
+-- asked can download one as a printable document from
+-- /api/query/{id}/export. Image URLs in sources are stored unsigned.
+
+CREATE TABLE IF NOT EXISTS query_answers (
+	query_id   TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL,
+	product_id TEXT NOT NULL DEFAULT '',
+	question   TEXT NOT NULL,
+	answer     TEXT NOT NULL,
+	sources    TEXT NOT NULL DEFAULT '[]', -- JSON array of query.SourceRef
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_query_answers_user ON query_answers(user_id);
+CREATE INDEX IF NOT EXISTS idx_query_answers_created ON query_answers(created_at);
@@ -0,0 +1,95 @@
+package document
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"askflow/internal/video"
+)
+
+// diarizeBatchSize is the number of transcript sentences labelled per LLM call.
+const diarizeBatchSize = 120
+
+// diarizeTranscript labels each transcript sentence with its speaker using the
+// chat model, for STT backends that cannot tell speakers apart. Long segments
+// without word timings are first split into sentences. labels, if set, are the
+// roles the model picks from; otherwise it names the speakers itself. Speakers
+// named in earlier batches are passed on so names stay consistent.
+func (dm *DocumentManager) diarizeTranscript(ctx context.Context, segments []video.TranscriptSegment, labels []string) ([]video.TranscriptSegment, error) {
+	dm.mu.RLock()
+	ls := dm.llmService
+	dm.mu.RUnlock()
+	if ls == nil {
+		return segments, fmt.Errorf("LLM service not configured")
+	}
+	if len(segments) == 0 || video.HasSpeakers(segments) {
+		return segments, nil
+	}
+
+	sentences := video.SplitSentences(segments)
+	var seen []string
+	for start := 0; start < len(sentences); start += diarizeBatchSize {
+		if err := canceled(ctx); err != nil {
+			return segments, err
+		}
+		end := min(start+diarizeBatchSize, len(sentences))
+		var lines strings.Builder
+		for i := start; i < end; i++ {
+			fmt.Fprintf(&lines, "[%d] %s\n", i+1, strings.ReplaceAll(sentences[i].Text, "\n", " "))
+		}
+		answer, err := ls.Generate(ctx, diarizePrompt(labels, seen), nil, lines.String())
+		if err != nil {
+			return segments, err
+		}
+		assigned, err := parseSpeakerAnswer(answer)
+		if err != nil {
+			return segments, err
+		}
+		for i := start; i < end; i++ {
+			speaker := strings.TrimSpace(assigned[strconv.Itoa(i+1)])
+			if speaker == "" {
+				continue
+			}
+			sentences[i].Speaker = speaker
+			if !slices.Contains(seen, speaker) {
+				seen = append(seen, speaker)
+			}
+		}
+	}
+	return sentences, nil
+}
+
+// diarizePrompt builds the system prompt for labelling one batch of sentences.
+func diarizePrompt(labels, seen []string) string {
+	var b strings.Builder
+	b.WriteString("你是对话转录整理助手。用户会给出一段音视频（如客服通话录音、网络研讨会）的语音转录，每行以 [编号] 开头，是按时间顺序排列的一句话。" +
+		"请根据对话内容、语气和上下文判断每一句的说话人。\n")
+	if len(labels) > 0 {
+		b.WriteString("说话人只能从以下角色中选择：" + strings.Join(labels, "、") + "。\n")
+	} else {
+		b.WriteString("请用说话人的角色命名（如\"主讲人\"、\"客服\"、\"客户\"），无法判断角色时用\"说话人 1\"、\"说话人 2\"等。\n")
+	}
+	if len(seen) > 0 {
+		b.WriteString("前文已出现的说话人：" + strings.Join(seen, "、") + "，同一个人请沿用相同的名称。\n")
+	}
+	b.WriteString("只输出一个 JSON 对象，键为编号（字符串），值为说话人名称，例如 {\"1\": \"主讲人\", \"2\": \"客户\"}，不要输出其他内容。")
+	return b.String()
+}
+
+// parseSpeakerAnswer extracts the sentence→speaker JSON object from the model
+// answer, which may be wrapped in a code fence or surrounded by prose.
+func parseSpeakerAnswer(answer string) (map[string]string, error) {
+	start, end := strings.Index(answer, "{"), strings.LastIndex(answer, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("speaker labelling answer is not JSON: %.200s", answer)
+	}
+	var assigned map[string]string
+	if err := json.Unmarshal([]byte(answer[start:end+1]), &assigned); err != nil {
+		return nil, fmt.Errorf("parse speaker labelling answer: %w", err)
+	}
+	return assigned, nil
+}
@@ -0,0 +1,556 @@
+package document
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"askflow/internal/blob"
+	"askflow/internal/vectorstore"
+)
+
+// KnowledgeStats summarizes the knowledge base, for "askflow stats".
+type KnowledgeStats struct {
+	Documents         int            `json:"documents"`
+	DocumentsByStatus map[string]int `json:"documents_by_status"`
+	DocumentsByType   map[string]int `json:"documents_by_type"`
+	Chunks            int            `json:"chunks"`
+	ImageChunks       int            `json:"image_chunks"`
+	VideoSegments     int            `json:"video_segments"`
+	// VectorDimensions counts chunks by embedding dimension. More than one
+	// entry means the embedding model was changed without re-importing.
+	VectorDimensions map[int]int `json:"vector_dimensions"`
+	DatabaseBytes    int64       `json:"database_bytes"`
+	Images           int         `json:"images"`
+	ImageBytes       int64       `json:"image_bytes"`
+	Originals        int         `json:"originals"`
+	OriginalBytes    int64       `json:"original_bytes"`
+	// StorageError is set when the storage backend could not be listed;
+	// the image and original counts are then zero.
+	StorageError string                  `json:"storage_error,omitempty"`
+	Products     []ProductKnowledgeStats `json:"products"`
+}
+
+// ProductKnowledgeStats is the share of one product in KnowledgeStats. The
+// public library has an empty ProductID; products that were deleted while
+// documents still referenced them have an empty Name.
+type ProductKnowledgeStats struct {
+	ProductID string `json:"product_id"`
+	Name      string `json:"name"`
+	Documents int    `json:"documents"`
+	Chunks    int    `json:"chunks"`
+	Images    int    `json:"images"`
+}
+
+// Stats computes KnowledgeStats. Vector dimensions are read from one sample
+// per stored vector size, so the cost does not grow with the chunk count.
+func (dm *DocumentManager) Stats() (*KnowledgeStats, error) {
+	s := &KnowledgeStats{
+		DocumentsByStatus: make(map[string]int),
+		DocumentsByType:   make(map[string]int),
+	}
+	if err := countBy(dm.db, `SELECT status, COUNT(*) FROM documents GROUP BY status`, s.DocumentsByStatus); err != nil {
+		return nil, err
+	}
+	if err := countBy(dm.db, `SELECT type, COUNT(*) FROM documents GROUP BY type`, s.DocumentsByType); err != nil {
+		return nil, err
+	}
+	for _, n := range s.DocumentsByStatus {
+		s.Documents += n
+	}
+	err := dm.db.QueryRow(
+		`SELECT COUNT(*), COALESCE(SUM(CASE WHEN image_url != '' AND image_url IS NOT NULL THEN 1 ELSE 0 END), 0) FROM chunks`,
+	).Scan(&s.Chunks, &s.ImageChunks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count chunks: %w", err)
+	}
+	if err := dm.db.QueryRow(`SELECT COUNT(*) FROM video_segments`).Scan(&s.VideoSegments); err != nil {
+		return nil, fmt.Errorf("failed to count video segments: %w", err)
+	}
+	dims, err := dm.vectorDimensions()
+	if err != nil {
+		return nil, err
+	}
+	s.VectorDimensions = make(map[int]int, len(dims))
+	for _, d := range dims {
+		s.VectorDimensions[d.dimension] += d.chunks
+	}
+	var pageCount, pageSize int64
+	if err := dm.db.QueryRow(`PRAGMA page_count`).Scan(&pageCount); err == nil {
+		if err := dm.db.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err == nil {
+			s.DatabaseBytes = pageCount * pageSize
+		}
+	}
+
+	if storage := dm.Storage(); storage != nil {
+		for _, dir := range []string{"images", "uploads"} {
+			objects, err := storage.List(dir)
+			if err != nil {
+				s.StorageError = err.Error()
+				s.Images, s.ImageBytes, s.Originals, s.OriginalBytes = 0, 0, 0, 0
+				break
+			}
+			for _, size := range objects {
+				if dir == "images" {
+					s.Images++
+					s.ImageBytes += size
+				} else {
+					s.Originals++
+					s.OriginalBytes += size
+				}
+			}
+		}
+	}
+
+	s.Products, err = dm.productStats()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// productStats breaks documents, chunks and image records down by product:
+// products in creation order, then the public library, then unknown IDs.
+func (dm *DocumentManager) productStats() ([]ProductKnowledgeStats, error) {
+	docs, chunks, images := map[string]int{}, map[string]int{}, map[string]int{}
+	if err := countBy(dm.db, `SELECT product_id, COUNT(*) FROM documents GROUP BY product_id`, docs); err != nil {
+		return nil, err
+	}
+	if err := countBy(dm.db, `SELECT product_id, COUNT(*) FROM chunks GROUP BY product_id`, chunks); err != nil {
+		return nil, err
+	}
+	if err := countBy(dm.db, `SELECT product_id, COUNT(*) FROM images GROUP BY product_id`, images); err != nil {
+		return nil, err
+	}
+
+	rows, err := dm.db.Query(`SELECT id, name FROM products ORDER BY created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list products: %w", err)
+	}
+	defer rows.Close()
+	var out []ProductKnowledgeStats
+	seen := map[string]bool{}
+	for rows.Next() {
+		var p ProductKnowledgeStats
+		if err := rows.Scan(&p.ProductID, &p.Name); err != nil {
+			return nil, fmt.Errorf("failed to scan product row: %w", err)
+		}
+		seen[p.ProductID] = true
+		out = append(out, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating product rows: %w", err)
+	}
+	out = append(out, ProductKnowledgeStats{ProductID: ""})
+	seen[""] = true
+	var unknown []string
+	for _, m := range []map[string]int{docs, chunks, images} {
+		for id := range m {
+			if !seen[id] {
+				seen[id] = true
+				unknown = append(unknown, id)
+			}
+		}
+	}
+	sort.Strings(unknown)
+	for _, id := range unknown {
+		out = append(out, ProductKnowledgeStats{ProductID: id})
+	}
+	for i := range out {
+		id := out[i].ProductID
+		out[i].Documents, out[i].Chunks, out[i].Images = docs[id], chunks[id], images[id]
+	}
+	return out, nil
+}
+
+// ProductDocumentStats summarizes the knowledge of one product for its
+// owners. LastUpdated is the import time of the newest document and is zero
+// when the product has no documents.
+type ProductDocumentStats struct {
+	Documents   int       `json:"documents"`
+	Failed      int       `json:"failed"`
+	Chunks      int       `json:"chunks"`
+	LastUpdated time.Time `json:"last_updated,omitzero"`
+}
+
+// ProductStats computes ProductDocumentStats for one product; an empty ID
+// selects the public library.
+func (dm *DocumentManager) ProductStats(productID string) (*ProductDocumentStats, error) {
+	s := &ProductDocumentStats{}
+	err := dm.db.QueryRow(
+		`SELECT COUNT(*), COALESCE(SUM(CASE WHEN status = 'failed' THEN 1 ELSE 0 END), 0) FROM documents WHERE product_id = ?`,
+		productID,
+	).Scan(&s.Documents, &s.Failed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count documents: %w", err)
+	}
+	if err := dm.db.QueryRow(`SELECT COUNT(*) FROM chunks WHERE product_id = ?`, productID).Scan(&s.Chunks); err != nil {
+		return nil, fmt.Errorf("failed to count chunks: %w", err)
+	}
+	var last sql.NullTime
+	err = dm.db.QueryRow(
+		`SELECT created_at FROM documents WHERE product_id = ? ORDER BY created_at DESC LIMIT 1`, productID,
+	).Scan(&last)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to read last document time: %w", err)
+	}
+	if last.Valid {
+		s.LastUpdated = last.Time
+	}
+	return s, nil
+}
+
+// CheckReport lists the inconsistencies found by Check.
+type CheckReport struct {
+	// MissingDocuments are document IDs that chunks, video segments, chunk
+	// locations or images still refer to although the document record is
+	// gone, e.g. after an interrupted delete.
+	MissingDocuments []OrphanedDocument `json:"missing_documents"`
+	// ExpectedDimension is the vector dimension chunks are checked against.
+	ExpectedDimension   int                 `json:"expected_dimension"`
+	DimensionMismatches []DimensionMismatch `json:"dimension_mismatches"`
+	// BrokenImages are chunks whose image URL points to an image file that
+	// does not exist in storage.
+	BrokenImages []BrokenImage `json:"broken_images"`
+	// MissingImageFiles are image records without a stored file.
+	MissingImageFiles []string `json:"missing_image_files"`
+}
+
+// OrphanedDocument counts the rows left behind by a missing document record.
+type OrphanedDocument struct {
+	DocumentID     string `json:"document_id"`
+	Chunks         int    `json:"chunks"`
+	VideoSegments  int    `json:"video_segments"`
+	ChunkLocations int    `json:"chunk_locations"`
+	ImageVectors   int    `json:"image_vectors"`
+	Images         int    `json:"images"`
+}
+
+// DimensionMismatch is a document with chunks whose vectors have a
+// dimension other than the expected one. Such chunks never match a query.
+type DimensionMismatch struct {
+	DocumentID   string `json:"document_id"`
+	DocumentName string `json:"document_name"`
+	Dimension    int    `json:"dimension"`
+	Chunks       int    `json:"chunks"`
+}
+
+// BrokenImage is a chunk referencing a missing image file.
+type BrokenImage struct {
+	ChunkID    string `json:"chunk_id"`
+	DocumentID string `json:"document_id"`
+	ImageURL   string `json:"image_url"`
+}
+
+// Problems returns the number of inconsistencies in the report.
+func (r *CheckReport) Problems() int {
+	return len(r.MissingDocuments) + len(r.DimensionMismatches) + len(r.BrokenImages) + len(r.MissingImageFiles)
+}
+
+// Check looks for inconsistencies between the documents, chunks, image
+// records and stored image files. Chunk vectors are checked against
+// expectedDim, or against the dimension most chunks have when it is 0.
+func (dm *DocumentManager) Check(expectedDim int) (*CheckReport, error) {
+	r := &CheckReport{
+		MissingDocuments:    []OrphanedDocument{},
+		DimensionMismatches: []DimensionMismatch{},
+		BrokenImages:        []BrokenImage{},
+		MissingImageFiles:   []string{},
+	}
+
+	orphans := map[string]*OrphanedDocument{}
+	for _, t := range []struct {
+		table string
+		field func(*OrphanedDocument) *int
+	}{
+		{"chunks", func(o *OrphanedDocument) *int { return &o.Chunks }},
+		{"video_segments", func(o *OrphanedDocument) *int { return &o.VideoSegments }},
+		{"chunk_locations", func(o *OrphanedDocument) *int { return &o.ChunkLocations }},
+		{"chunk_image_embeddings", func(o *OrphanedDocument) *int { return &o.ImageVectors }},
+		{"images", func(o *OrphanedDocument) *int { return &o.Images }},
+	} {
+		counts := map[string]int{}
+		// Images not yet attached to an entry have no document
+		err := countBy(dm.db, `SELECT t.document_id, COUNT(*) FROM `+t.table+` t
+			WHERE t.document_id != '' AND NOT EXISTS (SELECT 1 FROM documents d WHERE d.id = t.document_id)
+			GROUP BY t.document_id`, counts)
+		if err != nil {
+			return nil, err
+		}
+		for id, n := range counts {
+			o := orphans[id]
+			if o == nil {
+				o = &OrphanedDocument{DocumentID: id}
+				orphans[id] = o
+			}
+			*t.field(o) = n
+		}
+	}
+	for _, o := range orphans {
+		r.MissingDocuments = append(r.MissingDocuments, *o)
+	}
+	sort.Slice(r.MissingDocuments, func(i, j int) bool {
+		return r.MissingDocuments[i].DocumentID < r.MissingDocuments[j].DocumentID
+	})
+
+	dims, err := dm.vectorDimensions()
+	if err != nil {
+		return nil, err
+	}
+	r.ExpectedDimension = expectedDim
+	if expectedDim == 0 {
+		most := 0
+		for _, d := range dims {
+			if d.chunks > most {
+				most, r.ExpectedDimension = d.chunks, d.dimension
+			}
+		}
+	}
+	for _, d := range dims {
+		if d.dimension == r.ExpectedDimension {
+			continue
+		}
+		rows, err := dm.db.Query(`SELECT c.document_id, COALESCE(d.name, c.document_name), COUNT(*) FROM chunks c
+			LEFT JOIN documents d ON d.id = c.document_id
+			WHERE length(c.embedding) = ? GROUP BY c.document_id ORDER BY c.document_id`, d.size)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query chunk dimensions: %w", err)
+		}
+		for rows.Next() {
+			m := DimensionMismatch{Dimension: d.dimension}
+			if err := rows.Scan(&m.DocumentID, &m.DocumentName, &m.Chunks); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan chunk row: %w", err)
+			}
+			r.DimensionMismatches = append(r.DimensionMismatches, m)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error iterating chunk rows: %w", err)
+		}
+	}
+
+	dm.mu.RLock()
+	images := dm.images
+	dm.mu.RUnlock()
+	if images == nil {
+		return r, nil
+	}
+	files, err := images.Files()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list image files: %w", err)
+	}
+	rows, err := dm.db.Query(`SELECT id, document_id, image_url FROM chunks WHERE image_url != '' AND image_url IS NOT NULL ORDER BY document_id, chunk_index`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query image chunks: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b BrokenImage
+		if err := rows.Scan(&b.ChunkID, &b.DocumentID, &b.ImageURL); err != nil {
+			return nil, fmt.Errorf("failed to scan chunk row: %w", err)
+		}
+		// Only images served by the image store can be checked
+		if id := blob.IDFromURL(b.ImageURL); id != "" {
+			if _, ok := files[id]; !ok {
+				r.BrokenImages = append(r.BrokenImages, b)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating chunk rows: %w", err)
+	}
+	ids, err := queryStrings(dm.db, `SELECT id FROM images ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if _, ok := files[id]; !ok {
+			r.MissingImageFiles = append(r.MissingImageFiles, id)
+		}
+	}
+	return r, nil
+}
+
+// RepairResult counts what Repair changed.
+type RepairResult struct {
+	PurgedDocuments int `json:"purged_documents"`
+	FailedDocuments int `json:"failed_documents"`
+	ClearedImages   int `json:"cleared_images"`
+	ImageRecords    int `json:"image_records"`
+}
+
+// Repair fixes the problems of a report from Check:
+//   - rows left behind by missing documents are deleted, with their images
+//     and original files;
+//   - documents with vectors of the wrong dimension lose their chunks and
+//     are marked failed so they get re-imported;
+//   - image URLs of chunks whose image file is missing are cleared, keeping
+//     the chunk text searchable;
+//   - image records without a file are deleted.
+//
+// It stops at the first error, returning what was repaired so far.
+func (dm *DocumentManager) Repair(r *CheckReport) (*RepairResult, error) {
+	res := &RepairResult{}
+	dm.mu.RLock()
+	images := dm.images
+	dm.mu.RUnlock()
+
+	for _, o := range r.MissingDocuments {
+		if err := dm.purgeDocumentData(o.DocumentID, images); err != nil {
+			return res, err
+		}
+		res.PurgedDocuments++
+	}
+
+	failed := map[string]bool{}
+	for _, m := range r.DimensionMismatches {
+		if failed[m.DocumentID] {
+			continue
+		}
+		failed[m.DocumentID] = true
+		if err := dm.clearDocumentChunks(m.DocumentID); err != nil {
+			return res, err
+		}
+		_, err := dm.db.Exec(`UPDATE documents SET status = 'failed', error = ? WHERE id = ?`,
+			fmt.Sprintf("向量维度与知识库不一致（%d，应为 %d），请重新导入", m.Dimension, r.ExpectedDimension), m.DocumentID)
+		if err != nil {
+			return res, fmt.Errorf("failed to mark document %s as failed: %w", m.DocumentID, err)
+		}
+		res.FailedDocuments++
+	}
+
+	// Rows of purged or failed documents are already gone, so only count
+	// the ones that were actually changed
+	for _, b := range r.BrokenImages {
+		result, err := dm.db.Exec(`UPDATE chunks SET image_url = '' WHERE id = ?`, b.ChunkID)
+		if err != nil {
+			return res, fmt.Errorf("failed to clear image of chunk %s: %w", b.ChunkID, err)
+		}
+		n, _ := result.RowsAffected()
+		res.ClearedImages += int(n)
+	}
+
+	for _, id := range r.MissingImageFiles {
+		result, err := dm.db.Exec(`DELETE FROM images WHERE id = ?`, id)
+		if err != nil {
+			return res, fmt.Errorf("failed to delete image record %s: %w", id, err)
+		}
+		n, _ := result.RowsAffected()
+		res.ImageRecords += int(n)
+	}
+	return res, nil
+}
+
+// purgeDocumentData removes everything stored for a document whose record
+// is already gone.
+func (dm *DocumentManager) purgeDocumentData(docID string, images *blob.Store) error {
+	if err := dm.clearDocumentChunks(docID); err != nil {
+		return err
+	}
+	if images != nil {
+		if err := images.DeleteByDocument(docID); err != nil {
+			return fmt.Errorf("failed to delete images of %s: %w", docID, err)
+		}
+	}
+	if blob.ValidKey("uploads/" + docID) {
+		if err := dm.Storage().DeletePrefix("uploads/" + docID); err != nil {
+			return fmt.Errorf("failed to delete original file of %s: %w", docID, err)
+		}
+	}
+	return nil
+}
+
+// clearDocumentChunks deletes the chunks of a document with their video
+// segments, locations and image vectors.
+func (dm *DocumentManager) clearDocumentChunks(docID string) error {
+	if err := dm.vectorStore.DeleteByDocID(docID); err != nil {
+		return fmt.Errorf("failed to delete chunks of %s: %w", docID, err)
+	}
+	if _, err := dm.db.Exec(`DELETE FROM video_segments WHERE document_id = ?`, docID); err != nil {
+		return fmt.Errorf("failed to delete video segments of %s: %w", docID, err)
+	}
+	if _, err := dm.db.Exec(`DELETE FROM chunk_locations WHERE document_id = ?`, docID); err != nil {
+		return fmt.Errorf("failed to delete chunk locations of %s: %w", docID, err)
+	}
+	if _, err := dm.db.Exec(`DELETE FROM chunk_image_embeddings WHERE document_id = ?`, docID); err != nil {
+		return fmt.Errorf("failed to delete image vectors of %s: %w", docID, err)
+	}
+	return nil
+}
+
+// vectorDimension groups chunks by the byte size of their stored vector.
+type vectorDimension struct {
+	size      int
+	dimension int
+	chunks    int
+}
+
+// vectorDimensions decodes one sample vector per stored size.
+func (dm *DocumentManager) vectorDimensions() ([]vectorDimension, error) {
+	rows, err := dm.db.Query(`SELECT length(embedding), COUNT(*), MIN(id) FROM chunks GROUP BY length(embedding)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query vector sizes: %w", err)
+	}
+	var dims []vectorDimension
+	var samples []string
+	for rows.Next() {
+		var d vectorDimension
+		var sample string
+		if err := rows.Scan(&d.size, &d.chunks, &sample); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan vector size: %w", err)
+		}
+		dims = append(dims, d)
+		samples = append(samples, sample)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating vector sizes: %w", err)
+	}
+	for i, id := range samples {
+		var data []byte
+		if err := dm.db.QueryRow(`SELECT embedding FROM chunks WHERE id = ?`, id).Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to read sample vector: %w", err)
+		}
+		dims[i].dimension = len(vectorstore.DeserializeVector(data))
+	}
+	return dims, nil
+}
+
+// countBy runs a "SELECT key, COUNT(*) ... GROUP BY key" query into counts.
+func countBy(db *sql.DB, query string, counts map[string]int) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return fmt.Errorf("failed to count rows: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key sql.NullString
+		var n int
+		if err := rows.Scan(&key, &n); err != nil {
+			return fmt.Errorf("failed to scan count: %w", err)
+		}
+		counts[key.String] += n
+	}
+	return rows.Err()
+}
+
+// queryStrings returns the single string column of a query.
+func queryStrings(db *sql.DB, query string) ([]string, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query: %w", err)
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
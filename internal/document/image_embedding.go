@@ -0,0 +1,46 @@
+package document
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"askflow/internal/embedding"
+	"askflow/internal/errlog"
+	"askflow/internal/vectorstore"
+)
+
+// SetImageEmbeddingService sets the image embedding model (image_embedding.*
+// in config) document images are also embedded with, for image similarity
+// search. model names it in the stored vectors; nil turns it off.
+func (dm *DocumentManager) SetImageEmbeddingService(es embedding.EmbeddingService, model string) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.imageEmbedding = es
+	dm.imageModel = model
+}
+
+// storeImageEmbedding embeds the image of the chunk chunkIndex of docID with
+// the image embedding model, when one is set, and stores the vector. image
+// is an http(s) or data URL. Failures are only logged: the chunk is still
+// found through its text and multimodal vectors.
+func (dm *DocumentManager) storeImageEmbedding(ctx context.Context, docID, docName string, chunkIndex int, image string) {
+	dm.mu.RLock()
+	es, model := dm.imageEmbedding, dm.imageModel
+	dm.mu.RUnlock()
+	if es == nil || image == "" {
+		return
+	}
+	vec, err := es.EmbedImageURL(ctx, image)
+	if err != nil {
+		log.Printf("Warning: image model failed to embed chunk %d of doc=%s: %v", chunkIndex, docID, err)
+		errlog.Logf("[Embed] image model failed to embed chunk %d of doc=%s file=%q: %v", chunkIndex, docID, docName, err)
+		return
+	}
+	_, err = dm.db.Exec(`INSERT OR REPLACE INTO chunk_image_embeddings (chunk_id, document_id, model, embedding) VALUES (?, ?, ?, ?)`,
+		fmt.Sprintf("%s-%d", docID, chunkIndex), docID, model, vectorstore.SerializeVector(vec))
+	if err != nil {
+		log.Printf("Warning: failed to store image vector of chunk %d of doc=%s: %v", chunkIndex, docID, err)
+		errlog.Logf("[Store] failed to store image vector of chunk %d of doc=%s file=%q: %v", chunkIndex, docID, docName, err)
+	}
+}
@@ -0,0 +1,97 @@
+package document
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"unicode/utf8"
+
+	"askflow/internal/errlog"
+	"askflow/internal/llm"
+)
+
+// injectionPatterns match phrases typical of prompt injection: attempts to
+// override the assistant's instructions, extract its prompt, or spoof chat
+// template markup. They are kept narrow because ordinary manuals talk about
+// "instructions" and "rules" all the time.
+var injectionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override)\b[^.\n]{0,30}\b(previous|prior|above|earlier|preceding|all|any|your)\b[^.\n]{0,20}\b(instructions?|prompts?|rules|directions|guidelines)\b`),
+	regexp.MustCompile(`(?i)\b(reveal|print|show|repeat|output)\b[^.\n]{0,30}\b(system prompt|hidden instructions|initial instructions)\b`),
+	regexp.MustCompile(`(?i)\b(new|updated) (system )?instructions\s*:`),
+	regexp.MustCompile(`(?i)<\|im_start\|>|\[/?INST\]|<<SYS>>`),
+	regexp.MustCompile(`(忽略|无视|忘记|忘掉|不要理会)你?(之前|以上|前面|上述|先前|此前|所有|全部)的?(所有|全部)?(指令|指示|提示词|提示|规则|设定|要求)`),
+	regexp.MustCompile(`(输出|显示|告诉我|泄露|重复|打印)你?的?(系统提示词|系统提示|初始指令|隐藏指令)`),
+	regexp.MustCompile(`从现在(开始|起)[，,]?\s*你(是|将|必须|只能)`),
+}
+
+// injectionCheckBatch is the number of texts sent per LLM injection check.
+const injectionCheckBatch = 8
+
+// SetInjectionCheck enables or disables screening imported text with the
+// LLM for prompt injection. The pattern check always runs.
+func (dm *DocumentManager) SetInjectionCheck(enabled bool) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.injectionCheck = enabled
+}
+
+// findInjection returns the location of the first phrase in text matching
+// an injection pattern, or nil if none does.
+func findInjection(text string) []int {
+	for _, re := range injectionPatterns {
+		if loc := re.FindStringIndex(text); loc != nil {
+			return loc
+		}
+	}
+	return nil
+}
+
+// around returns text[start:end] with up to n characters either side.
+func around(text string, start, end, n int) string {
+	for i := 0; i < n && start > 0; i++ {
+		_, size := utf8.DecodeLastRuneInString(text[:start])
+		start -= size
+	}
+	for i := 0; i < n && end < len(text); i++ {
+		_, size := utf8.DecodeRuneInString(text[end:])
+		end += size
+	}
+	return text[start:end]
+}
+
+// checkInjection screens texts about to be stored for prompt injection and
+// flags the document for review on the first hit. Documents are stored
+// either way: retrieved text is quoted as data in the prompt, and flagging
+// lets an admin remove a malicious document. The LLM check, if enabled,
+// runs in the background so imports are not slowed down.
+func (dm *DocumentManager) checkInjection(m Moderator, docID, docName, productID string, texts []string) {
+	for _, t := range texts {
+		if loc := findInjection(t); loc != nil {
+			m.FlagDocument(docID, docName, productID, "疑似提示词注入：「"+t[loc[0]:loc[1]]+"」", around(t, loc[0], loc[1], 60))
+			return
+		}
+	}
+
+	dm.mu.RLock()
+	enabled, ls := dm.injectionCheck, dm.llmService
+	dm.mu.RUnlock()
+	if !enabled || ls == nil || !dm.startJob() {
+		return
+	}
+	go func() {
+		defer dm.jobs.Done()
+		for start := 0; start < len(texts); start += injectionCheckBatch {
+			batch := texts[start:min(start+injectionCheckBatch, len(texts))]
+			suspicious, err := llm.DetectInjection(context.Background(), ls, batch)
+			if err != nil {
+				log.Printf("[Injection] LLM check failed for doc=%s: %v", docID, err)
+				errlog.Logf("[Injection] LLM check failed for doc=%s file=%q: %v", docID, docName, err)
+				return
+			}
+			if len(suspicious) > 0 {
+				m.FlagDocument(docID, docName, productID, "疑似提示词注入（LLM 检测）", batch[suspicious[0]])
+				return
+			}
+		}
+	}()
+}
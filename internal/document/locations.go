@@ -0,0 +1,87 @@
+package document
+
+import (
+	"fmt"
+	"log"
+
+	"askflow/internal/chunker"
+	"askflow/internal/parser"
+)
+
+// chunkLocation is where a stored chunk came from in its original document.
+// Offsets are rune offsets into the parsed text; page and slide are 1-based
+// and 0 when unknown.
+type chunkLocation struct {
+	index      int
+	start, end int
+	page       int
+	slide      int
+}
+
+// textLayout describes the page or slide structure of parsed text.
+type textLayout struct {
+	sections []parser.Section
+	slides   bool // sections are PPT slides rather than PDF pages
+}
+
+// layoutOf returns the layout of a parse result of fileType.
+func layoutOf(result *parser.ParseResult, fileType string) textLayout {
+	return textLayout{sections: result.Sections, slides: fileType == "ppt"}
+}
+
+// locate returns the location of a text chunk split from text with layout l.
+func (l textLayout) locate(c chunker.Chunk) chunkLocation {
+	loc := chunkLocation{index: c.Index, start: c.Start, end: c.End}
+	if n := parser.SectionAt(l.sections, c.Start); l.slides {
+		loc.slide = n
+	} else {
+		loc.page = n
+	}
+	return loc
+}
+
+// sectionSpan returns the rune offsets of section number in text of length
+// textLen, or false if the section has no text.
+func sectionSpan(sections []parser.Section, number, textLen int) (int, int, bool) {
+	for i, sec := range sections {
+		if sec.Number != number {
+			continue
+		}
+		end := textLen
+		if i+1 < len(sections) {
+			end = sections[i+1].Start - 2 // blank line between sections
+		}
+		return sec.Start, end, true
+	}
+	return 0, 0, false
+}
+
+// storeChunkLocations records where chunks of docID came from, replacing
+// earlier records for the same chunks. Failures are only logged: locations
+// refine source links but are not needed to answer questions.
+func (dm *DocumentManager) storeChunkLocations(docID string, locs []chunkLocation) {
+	if len(locs) == 0 {
+		return
+	}
+	tx, err := dm.db.Begin()
+	if err != nil {
+		log.Printf("Warning: failed to store chunk locations of doc=%s: %v", docID, err)
+		return
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO chunk_locations (chunk_id, document_id, start_offset, end_offset, page, slide) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		log.Printf("Warning: failed to store chunk locations of doc=%s: %v", docID, err)
+		return
+	}
+	defer stmt.Close()
+	for _, l := range locs {
+		if _, err := stmt.Exec(fmt.Sprintf("%s-%d", docID, l.index), docID, l.start, l.end, l.page, l.slide); err != nil {
+			log.Printf("Warning: failed to store chunk location %d of doc=%s: %v", l.index, docID, err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Warning: failed to store chunk locations of doc=%s: %v", docID, err)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"image"
 	"image/jpeg"
@@ -19,17 +20,22 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"askflow/internal/blob"
 	"askflow/internal/chunker"
 	"askflow/internal/config"
+	"askflow/internal/db"
 	"askflow/internal/embedding"
 	"askflow/internal/errlog"
 	"askflow/internal/parser"
+	"askflow/internal/scan"
 	"askflow/internal/vectorstore"
 	"askflow/internal/video"
 
@@ -52,6 +58,8 @@ var supportedFileTypes = map[string]bool{
 	"mkv":          true,
 	"mov":          true,
 	"webm":         true,
+	"srt":          true,
+	"vtt":          true,
 }
 
 // videoFileTypes identifies which file types are video formats.
@@ -61,7 +69,23 @@ var videoFileTypes = map[string]bool{
 
 // LLMService defines the subset of LLM capabilities needed by DocumentManager.
 type LLMService interface {
-	GenerateWithImage(prompt string, context []string, question string, imageDataURL string) (string, error)
+	Generate(ctx context.Context, prompt string, chunks []string, question string) (string, error)
+	GenerateWithImage(ctx context.Context, prompt string, chunks []string, question string, imageDataURL string) (string, error)
+}
+
+// Moderator masks personal data in extracted text before it is embedded and
+// rejects documents containing prohibited content. Stored documents that
+// look like prompt injection are flagged to it for review.
+type Moderator interface {
+	ModerateDocument(docID, docName, productID string, texts []string, allowBlocked bool) ([]string, error)
+	FlagDocument(docID, docName, productID, reason, snippet string)
+}
+
+// Scanner checks uploaded files for malware before they are parsed. Scan
+// returns nil when scanning is disabled.
+type Scanner interface {
+	Scan(ctx context.Context, fileName string, data []byte) (*scan.Result, error)
+	FailOpen() bool
 }
 
 // DocumentManager orchestrates document upload, processing, and lifecycle management.
@@ -75,11 +99,59 @@ type DocumentManager struct {
 	httpClient       *http.Client
 	videoConfig      config.VideoConfig
 	llmService       LLMService
+	moderator        Moderator
+	scanner          Scanner
+	images           *blob.Store
+	storage          blob.Backend
+	injectionCheck   bool
+	// imageEmbedding embeds document images for image similarity search
+	// with the model named imageModel; nil when not configured.
+	imageEmbedding embedding.EmbeddingService
+	imageModel     string
+	// released holds IDs of documents an admin released from moderation
+	// while they are being reprocessed.
+	released sync.Map
 	// validateURL is a hook for URL validation (SSRF protection).
 	// Defaults to validateExternalURL. Tests can override to allow localhost.
 	validateURL func(string) error
+	// onProcessed is called when a document reaches a final status ("success" or "failed").
+	onProcessed func(docID, status, errMsg string)
+
+	// jobs tracks uploads being processed (including async PDF/PPT/video
+	// processing) so shutdown can wait for them. Once closing is set no new
+	// uploads are accepted. cancels holds the cancel function of each
+	// document being processed.
+	jobsMu  sync.Mutex
+	jobs    sync.WaitGroup
+	closing bool
+	cancels map[string]context.CancelCauseFunc
+
+	// progress follows documents through the processing stages.
+	progress progressTracker
+
+	// ranking holds the recency settings the search boosts of documents are
+	// computed with (see RefreshBoosts).
+	ranking rankingConfig
 }
 
+// ErrShuttingDown is returned for uploads submitted while the server drains.
+var ErrShuttingDown = errors.New("服务正在关闭，请稍后重试")
+
+// ErrCanceled is the error of documents whose processing was canceled.
+var ErrCanceled = errors.New("文档处理已取消")
+
+// ErrRequestAborted is the error of documents processed while uploading
+// whose request ended, e.g. because the client disconnected.
+var ErrRequestAborted = errors.New("上传请求已中断，文档处理已停止")
+
+// ErrNotProcessing is returned when canceling a document that is not being
+// processed.
+var ErrNotProcessing = errors.New("文档不在处理中")
+
+// ErrNotFailed is returned when reprocessing a document whose import did
+// not fail.
+var ErrNotFailed = errors.New("只能重新处理导入失败的文档")
+
 // ImportStats holds statistics about the imported document content.
 type ImportStats struct {
 	TextChars  int `json:"text_chars"`
@@ -95,7 +167,18 @@ type DocumentInfo struct {
 	Error     string       `json:"error,omitempty"`
 	CreatedAt time.Time    `json:"created_at"`
 	ProductID string       `json:"product_id"`
+	Priority  float64      `json:"priority"`
 	Stats     *ImportStats `json:"stats,omitempty"`
+	// ScanStatus is the malware scan verdict (see scan.Status*), empty for
+	// documents that were not scanned. ScanDetail is the signature found or
+	// the scanner error.
+	ScanStatus string `json:"scan_status,omitempty"`
+	ScanDetail string `json:"scan_detail,omitempty"`
+	// BatchID groups the documents imported from one ZIP archive.
+	BatchID string `json:"batch_id,omitempty"`
+	// SourceVersion is the version of the source a synced document was
+	// built from, such as the Git commit SHA.
+	SourceVersion string `json:"source_version,omitempty"`
 }
 
 
@@ -105,9 +188,26 @@ type UploadFileRequest struct {
 	FileData  []byte `json:"file_data"`
 	FileType  string `json:"file_type"`
 	ProductID string `json:"product_id"`
+	BatchID   string `json:"batch_id,omitempty"`
+	// Subtitle is an optional .srt/.vtt file for a video, used as its
+	// transcript instead of speech recognition.
+	Subtitle []byte `json:"-"`
 }
 
-func (dm *DocumentManager) UploadFile(req UploadFileRequest) (*DocumentInfo, error) {
+// UploadFile stores and processes an uploaded file. Files that are processed
+// before returning stop processing once ctx is done; videos, PDFs and PPTs are
+// processed in the background, independently of ctx.
+func (dm *DocumentManager) UploadFile(ctx context.Context, req UploadFileRequest) (*DocumentInfo, error) {
+	if !dm.startJob() {
+		return nil, ErrShuttingDown
+	}
+	async := false
+	defer func() {
+		if !async {
+			dm.jobs.Done()
+		}
+	}()
+
 	fileType := strings.ToLower(req.FileType)
 	if !supportedFileTypes[fileType] {
 		return nil, fmt.Errorf("不支持的文件格式")
@@ -126,12 +226,29 @@ func (dm *DocumentManager) UploadFile(req UploadFileRequest) (*DocumentInfo, err
 		return nil, fmt.Errorf("文件内容为空")
 	}
 
+	var subtitle []video.TranscriptSegment
+	if len(req.Subtitle) > 0 {
+		if !videoFileTypes[fileType] {
+			return nil, fmt.Errorf("只有视频可以附带字幕文件")
+		}
+		var err error
+		if subtitle, err = video.ParseSubtitles(req.Subtitle); err != nil {
+			return nil, fmt.Errorf("字幕文件无效: %w", err)
+		}
+	}
+
 	// File-level dedup: check if identical file content already exists (any status except failed)
 	fHash := fileHash(req.FileData)
 	if existingID := dm.findDocumentByContentHash(fHash); existingID != "" {
 		return nil, fmt.Errorf("文档内容重复，与已有文档相同")
 	}
 
+	// Scan before anything parses the file
+	verdict, err := dm.scanFile(ctx, req.FileName, req.FileData)
+	if err != nil {
+		return nil, err
+	}
+
 	docID, err := generateID()
 	if err != nil {
 		return nil, err
@@ -144,11 +261,36 @@ func (dm *DocumentManager) UploadFile(req UploadFileRequest) (*DocumentInfo, err
 		Status:    "processing",
 		CreatedAt: time.Now(),
 		ProductID: req.ProductID,
+		BatchID:   req.BatchID,
+	}
+	if verdict != nil {
+		doc.ScanStatus = verdict.Status
+		doc.ScanDetail = verdict.Signature
+	}
+
+	// Infected files are recorded as failed documents, so admins see what
+	// was rejected, but neither stored nor parsed.
+	if doc.ScanStatus == scan.StatusInfected {
+		doc.Status = "failed"
+		doc.Error = fmt.Sprintf("文件未通过安全扫描: %s", doc.ScanDetail)
+		if err := dm.insertDocument(doc, fHash); err != nil {
+			return nil, fmt.Errorf("failed to insert document record: %w", err)
+		}
+		log.Printf("[Scan] rejected infected file %q (doc=%s): %s", req.FileName, docID, doc.ScanDetail)
+		errlog.Logf("[Scan] rejected infected file %q (doc=%s product=%s): %s", req.FileName, docID, req.ProductID, doc.ScanDetail)
+		return nil, errors.New(doc.Error)
 	}
 
 	if err := dm.insertDocument(doc, fHash); err != nil {
 		return nil, fmt.Errorf("failed to insert document record: %w", err)
 	}
+	if subtitle != nil {
+		if err := dm.saveTranscript(docID, TranscriptSourceSubtitle, subtitle); err != nil {
+			dm.updateDocumentStatus(docID, "failed", "保存字幕失败")
+			return nil, fmt.Errorf("保存字幕失败: %w", err)
+		}
+	}
+	dm.startProgress(docID, req.FileName, fileType)
 
 	// Save original file to disk
 	if err := dm.saveOriginalFile(docID, req.FileName, req.FileData); err != nil {
@@ -161,7 +303,9 @@ func (dm *DocumentManager) UploadFile(req UploadFileRequest) (*DocumentInfo, err
 	// PDF files (especially scanned PDFs) may require per-page OCR via LLM vision API.
 	// PPT files require per-slide rendering which can take 20+ seconds for large decks.
 	if videoFileTypes[fileType] || fileType == "pdf" || fileType == "ppt" || fileType == "ppt_legacy" {
+		async = true
 		go func() {
+			defer dm.jobs.Done()
 			defer func() {
 				if r := recover(); r != nil {
 					dm.updateDocumentStatus(docID, "failed", fmt.Sprintf("panic: %v", r))
@@ -179,8 +323,11 @@ func (dm *DocumentManager) UploadFile(req UploadFileRequest) (*DocumentInfo, err
 			if timeoutMin <= 0 {
 				timeoutMin = 120
 			}
-			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutMin)*time.Minute)
+			ctx, cancel := context.WithTimeoutCause(context.WithoutCancel(ctx), time.Duration(timeoutMin)*time.Minute,
+				fmt.Errorf("文档处理超时（%d分钟）", timeoutMin))
 			defer cancel()
+			ctx, release := dm.jobContext(ctx, docID)
+			defer release()
 
 			done := make(chan error, 1)
 			go func() {
@@ -193,10 +340,10 @@ func (dm *DocumentManager) UploadFile(req UploadFileRequest) (*DocumentInfo, err
 				}()
 				if videoFileTypes[fileType] {
 					log.Printf("[Async] Processing video for doc=%s", docID)
-					done <- dm.processVideo(docID, req.FileName, req.FileData, req.ProductID)
+					done <- dm.processVideo(ctx, docID, req.FileName, nil, req.FileData, req.ProductID)
 				} else {
 					log.Printf("[Async] Processing file (PDF/PPT) for doc=%s", docID)
-					_, processErr := dm.processFile(docID, req.FileName, req.FileData, fileType, req.ProductID)
+					_, processErr := dm.processFile(ctx, docID, req.FileName, req.FileData, fileType, req.ProductID)
 					log.Printf("[Async] processFile completed for doc=%s, err=%v", docID, processErr)
 					done <- processErr
 				}
@@ -205,7 +352,7 @@ func (dm *DocumentManager) UploadFile(req UploadFileRequest) (*DocumentInfo, err
 			select {
 			case processErr := <-done:
 				if processErr != nil {
-					dm.updateDocumentStatus(docID, "failed", processErr.Error())
+					processErr = dm.processingFailed(ctx, docID, processErr)
 					log.Printf("Async processing failed for %s: %v", docID, processErr)
 					errlog.Logf("[Async] processing failed for doc=%s file=%q: %v", docID, req.FileName, processErr)
 				} else {
@@ -213,18 +360,28 @@ func (dm *DocumentManager) UploadFile(req UploadFileRequest) (*DocumentInfo, err
 					log.Printf("Async processing completed for %s", docID)
 				}
 			case <-ctx.Done():
-				dm.updateDocumentStatus(docID, "failed", fmt.Sprintf("文档处理超时（%d分钟）", timeoutMin))
-				log.Printf("Async processing timed out for %s (%d min)", docID, timeoutMin)
-				errlog.Logf("[Async] processing timed out for doc=%s file=%q (%d min)", docID, req.FileName, timeoutMin)
+				cause := context.Cause(ctx)
+				dm.updateDocumentStatus(docID, "failed", cause.Error())
+				if errors.Is(cause, ErrCanceled) {
+					log.Printf("Async processing canceled for %s", docID)
+				} else {
+					log.Printf("Async processing timed out for %s (%d min)", docID, timeoutMin)
+					errlog.Logf("[Async] processing timed out for doc=%s file=%q (%d min)", docID, req.FileName, timeoutMin)
+				}
+				// Let the processing stop before removing what it stored
+				<-done
+				dm.removePartial(docID)
 			}
 		}()
 		return doc, nil
 	}
 
 	// Non-video, non-PDF files: process synchronously
-	stats, processErr := dm.processFile(docID, req.FileName, req.FileData, fileType, req.ProductID)
+	ctx, release := dm.jobContext(ctx, docID)
+	defer release()
+	stats, processErr := dm.processFile(ctx, docID, req.FileName, req.FileData, fileType, req.ProductID)
 	if processErr != nil {
-		dm.updateDocumentStatus(docID, "failed", processErr.Error())
+		processErr = dm.processingFailed(ctx, docID, processErr)
 		doc.Status = "failed"
 		doc.Error = processErr.Error()
 		errlog.Logf("[Upload] file processing failed for doc=%s file=%q type=%s: %v", docID, req.FileName, fileType, processErr)
@@ -258,6 +415,7 @@ func NewDocumentManager(
 		embeddingService: es,
 		vectorStore:      vs,
 		db:               db,
+		storage:          blob.NewLocal(filepath.Join(".", "data")),
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 			Transport: &http.Transport{
@@ -295,6 +453,126 @@ func NewDocumentManager(
 	}
 }
 
+// startJob registers an upload with the job tracker. It returns false once
+// Drain has been called.
+func (dm *DocumentManager) startJob() bool {
+	dm.jobsMu.Lock()
+	defer dm.jobsMu.Unlock()
+	if dm.closing {
+		return false
+	}
+	dm.jobs.Add(1)
+	return true
+}
+
+// jobContext returns the context processing of docID runs under, derived
+// from parent, and registers it so CancelProcessing can cancel it. release
+// must be called when processing ends. Its embedding requests queue under
+// docID, taking turns with those of other documents.
+func (dm *DocumentManager) jobContext(parent context.Context, docID string) (ctx context.Context, release func()) {
+	ctx, cancel := context.WithCancelCause(embedding.WithKey(parent, docID))
+	dm.jobsMu.Lock()
+	if dm.cancels == nil {
+		dm.cancels = make(map[string]context.CancelCauseFunc)
+	}
+	dm.cancels[docID] = cancel
+	dm.jobsMu.Unlock()
+	return ctx, func() {
+		dm.jobsMu.Lock()
+		delete(dm.cancels, docID)
+		dm.jobsMu.Unlock()
+		cancel(nil)
+	}
+}
+
+// CancelProcessing aborts the processing of a document: running parsers and
+// ffmpeg processes are stopped, no further embedding batches are sent, and
+// the chunks stored so far are removed. The document is marked failed. It
+// returns ErrNotProcessing if the document is not being processed.
+func (dm *DocumentManager) CancelProcessing(docID string) error {
+	dm.jobsMu.Lock()
+	cancel := dm.cancels[docID]
+	dm.jobsMu.Unlock()
+	if cancel == nil {
+		return ErrNotProcessing
+	}
+	cancel(ErrCanceled)
+	log.Printf("[Documents] processing of doc=%s canceled", docID)
+	return nil
+}
+
+// canceled returns the reason ctx ended, or nil while processing may go on.
+// The end of the upload request is reported as ErrRequestAborted.
+func canceled(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	cause := context.Cause(ctx)
+	if cause == context.Canceled || cause == context.DeadlineExceeded {
+		return ErrRequestAborted
+	}
+	return cause
+}
+
+// processingFailed marks a document whose processing ended with err as
+// failed. If processing was canceled or timed out, err is replaced by the
+// reason and whatever was stored so far is removed. It returns the error
+// recorded on the document.
+func (dm *DocumentManager) processingFailed(ctx context.Context, docID string, err error) error {
+	if cause := canceled(ctx); cause != nil {
+		err = cause
+		dm.removePartial(docID)
+	}
+	dm.updateDocumentStatus(docID, "failed", err.Error())
+	return err
+}
+
+// removePartial removes the chunks, video segments and images stored for a
+// document whose processing did not complete.
+func (dm *DocumentManager) removePartial(docID string) {
+	if err := dm.vectorStore.DeleteByDocID(docID); err != nil {
+		log.Printf("Warning: failed to delete partial vectors of doc=%s: %v", docID, err)
+		errlog.Logf("[Documents] failed to delete partial vectors of doc=%s: %v", docID, err)
+	}
+	dm.db.Exec(`DELETE FROM video_segments WHERE document_id = ?`, docID)
+	dm.db.Exec(`DELETE FROM chunk_locations WHERE document_id = ?`, docID)
+	dm.db.Exec(`DELETE FROM chunk_image_embeddings WHERE document_id = ?`, docID)
+	dm.deleteImages(docID)
+}
+
+// Drain stops accepting uploads and waits until all documents being processed
+// are finished or ctx is done. Documents still processing when ctx expires are
+// picked up by FailInterrupted on the next start.
+func (dm *DocumentManager) Drain(ctx context.Context) error {
+	dm.jobsMu.Lock()
+	dm.closing = true
+	dm.jobsMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		dm.jobs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FailInterrupted marks documents left in "processing" by a previous run
+// (e.g. the process was killed mid-embedding) as failed so they can be
+// re-uploaded. It returns the number of documents updated.
+func (dm *DocumentManager) FailInterrupted() (int64, error) {
+	result, err := dm.db.Exec(`UPDATE documents SET status = 'failed', error = ? WHERE status = 'processing'`,
+		"服务重启导致处理中断，请重新上传")
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark interrupted documents: %w", err)
+	}
+	return result.RowsAffected()
+}
+
 // UpdateEmbeddingService replaces the embedding service (used after config change).
 func (dm *DocumentManager) UpdateEmbeddingService(es embedding.EmbeddingService) {
 	dm.mu.Lock()
@@ -309,6 +587,14 @@ func (dm *DocumentManager) SetVideoConfig(cfg config.VideoConfig) {
 	dm.videoConfig = cfg
 }
 
+// SetProcessedHook registers a callback invoked when a document finishes
+// processing with status "success" or "failed".
+func (dm *DocumentManager) SetProcessedHook(fn func(docID, status, errMsg string)) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.onProcessed = fn
+}
+
 // SetLLMService sets the LLM service for OCR on scanned PDFs.
 func (dm *DocumentManager) SetLLMService(ls LLMService) {
 	dm.mu.Lock()
@@ -316,9 +602,86 @@ func (dm *DocumentManager) SetLLMService(ls LLMService) {
 	dm.llmService = ls
 }
 
+// SetImageStore sets the store extracted images are saved to.
+func (dm *DocumentManager) SetImageStore(s *blob.Store) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.images = s
+}
+
+// SetStorage sets the backend original files are stored in. It should be
+// the backend of the image store.
+func (dm *DocumentManager) SetStorage(b blob.Backend) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.storage = b
+}
+
+// Storage returns the backend original files are stored in.
+func (dm *DocumentManager) Storage() blob.Backend {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+	return dm.storage
+}
+
+// SetModerator sets the moderation stage applied to extracted text.
+func (dm *DocumentManager) SetModerator(m Moderator) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.moderator = m
+}
+
+// SetScanner sets the malware scan applied to uploaded files.
+func (dm *DocumentManager) SetScanner(s Scanner) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.scanner = s
+}
+
+// scanFile scans an uploaded file. It returns nil when no scanner is set or
+// scanning is disabled. When the scanner fails the upload is rejected, or
+// accepted with an error verdict if the scanner is configured to fail open.
+func (dm *DocumentManager) scanFile(ctx context.Context, fileName string, data []byte) (*scan.Result, error) {
+	dm.mu.RLock()
+	s := dm.scanner
+	dm.mu.RUnlock()
+	if s == nil {
+		return nil, nil
+	}
+	result, err := s.Scan(ctx, fileName, data)
+	if err == nil {
+		return result, nil
+	}
+	errlog.Logf("[Scan] scanning file %q failed: %v", fileName, err)
+	if !s.FailOpen() {
+		return nil, fmt.Errorf("安全扫描失败，请稍后重试")
+	}
+	return &scan.Result{Status: scan.StatusError, Signature: err.Error()}, nil
+}
+
+// moderate applies the moderation stage, if any, to texts about to be
+// embedded and screens them for prompt injection. It returns the texts with
+// personal data masked.
+func (dm *DocumentManager) moderate(docID, docName, productID string, texts []string) ([]string, error) {
+	dm.mu.RLock()
+	m := dm.moderator
+	dm.mu.RUnlock()
+	if m == nil {
+		return texts, nil
+	}
+	_, released := dm.released.Load(docID)
+	out, err := m.ModerateDocument(docID, docName, productID, texts, released)
+	if err != nil {
+		log.Printf("[Moderation] document blocked doc=%s file=%q: %v", docID, docName, err)
+		return nil, fmt.Errorf("文档包含不允许的内容，已提交管理员审核")
+	}
+	dm.checkInjection(m, docID, docName, productID, out)
+	return out, nil
+}
+
 // ocrImageViaLLM uses the LLM vision API to extract text from an image.
 // The image is resized before sending to reduce payload and improve throughput.
-func (dm *DocumentManager) ocrImageViaLLM(imgData []byte) (string, error) {
+func (dm *DocumentManager) ocrImageViaLLM(ctx context.Context, imgData []byte) (string, error) {
 	dm.mu.RLock()
 	ls := dm.llmService
 	dm.mu.RUnlock()
@@ -329,7 +692,7 @@ func (dm *DocumentManager) ocrImageViaLLM(imgData []byte) (string, error) {
 	resized := resizeImageForOCR(imgData)
 	dataURL := imageToBase64DataURL(resized)
 	prompt := "你是一个OCR文字识别助手。请仔细识别图片中的所有文字内容，按原始排版顺序输出纯文本。只输出识别到的文字，不要添加任何解释或描述。如果图片中没有文字，输出空字符串。"
-	text, err := ls.GenerateWithImage(prompt, nil, "请识别图片中的所有文字", dataURL)
+	text, err := ls.GenerateWithImage(ctx, prompt, nil, "请识别图片中的所有文字", dataURL)
 	if err != nil {
 		return "", err
 	}
@@ -340,7 +703,7 @@ func (dm *DocumentManager) ocrImageViaLLM(imgData []byte) (string, error) {
 // generate a scene description for a video keyframe image. The combined result
 // provides richer searchable content than OCR alone.
 // The image is resized before sending to reduce payload and improve throughput.
-func (dm *DocumentManager) describeKeyframeViaLLM(imgData []byte) (string, error) {
+func (dm *DocumentManager) describeKeyframeViaLLM(ctx context.Context, imgData []byte) (string, error) {
 	dm.mu.RLock()
 	ls := dm.llmService
 	dm.mu.RUnlock()
@@ -357,7 +720,7 @@ func (dm *DocumentManager) describeKeyframeViaLLM(imgData []byte) (string, error
 		"[文字内容]\n（识别到的文字，如果没有文字则写\"无\"）\n\n" +
 		"[场景描述]\n（对画面内容的简要描述）"
 
-	text, err := ls.GenerateWithImage(prompt, nil, "请识别图片中的文字并描述画面内容", dataURL)
+	text, err := ls.GenerateWithImage(ctx, prompt, nil, "请识别图片中的文字并描述画面内容", dataURL)
 	if err != nil {
 		return "", err
 	}
@@ -569,9 +932,16 @@ func (dm *DocumentManager) getExistingChunkEmbeddings(texts []string) map[string
 
 		placeholders := make([]string, len(batch))
 		args := make([]interface{}, len(batch))
+		// Stored text may be encrypted; look it up as stored
+		texts := make(map[string]string, len(batch))
 		for j, t := range batch {
+			stored, err := dm.vectorStore.EncodeText(t)
+			if err != nil {
+				return result
+			}
 			placeholders[j] = "?"
-			args[j] = t
+			args[j] = stored
+			texts[stored] = t
 		}
 
 		query := fmt.Sprintf(
@@ -588,7 +958,7 @@ func (dm *DocumentManager) getExistingChunkEmbeddings(texts []string) map[string
 			if err := rows.Scan(&chunkText, &embeddingBytes); err == nil && len(embeddingBytes) > 0 {
 				vec := vectorstore.DeserializeVector(embeddingBytes)
 				if len(vec) > 0 {
-					result[chunkText] = vec
+					result[texts[chunkText]] = vec
 				}
 			}
 		}
@@ -602,8 +972,14 @@ func (dm *DocumentManager) getExistingChunkEmbeddings(texts []string) map[string
 }
 
 // UploadURL fetches the content at the given URL, chunks it, generates embeddings,
-// and stores everything. The document type is recorded as "url".
-func (dm *DocumentManager) UploadURL(req UploadURLRequest) (*DocumentInfo, error) {
+// and stores everything. The document type is recorded as "url". Processing
+// stops once ctx is done.
+func (dm *DocumentManager) UploadURL(ctx context.Context, req UploadURLRequest) (*DocumentInfo, error) {
+	if !dm.startJob() {
+		return nil, ErrShuttingDown
+	}
+	defer dm.jobs.Done()
+
 	if req.URL == "" {
 		return nil, fmt.Errorf("URL不能为空")
 	}
@@ -625,11 +1001,14 @@ func (dm *DocumentManager) UploadURL(req UploadURLRequest) (*DocumentInfo, error
 	if err := dm.insertDocument(doc, ""); err != nil {
 		return nil, fmt.Errorf("failed to insert document record: %w", err)
 	}
+	dm.startProgress(docID, req.URL, "url")
 
 	// Fetch → Chunk → Embed → Store
-	stats, err := dm.processURL(docID, req.URL, req.ProductID)
+	ctx, release := dm.jobContext(ctx, docID)
+	defer release()
+	stats, err := dm.processURL(ctx, docID, req.URL, req.ProductID)
 	if err != nil {
-		dm.updateDocumentStatus(docID, "failed", err.Error())
+		err = dm.processingFailed(ctx, docID, err)
 		doc.Status = "failed"
 		doc.Error = err.Error()
 		errlog.Logf("[Upload] URL processing failed for doc=%s url=%q: %v", docID, req.URL, err)
@@ -650,6 +1029,8 @@ func (dm *DocumentManager) DeleteDocument(docID string) error {
 	if docID == "" || strings.ContainsAny(docID, "/\\") || strings.Contains(docID, "..") {
 		return fmt.Errorf("invalid document ID")
 	}
+	// Stop processing still running so it stores nothing more
+	dm.CancelProcessing(docID)
 
 	if err := dm.vectorStore.DeleteByDocID(docID); err != nil {
 		return fmt.Errorf("failed to delete vectors: %w", err)
@@ -666,6 +1047,15 @@ func (dm *DocumentManager) DeleteDocument(docID string) error {
 	if _, err := tx.Exec(`DELETE FROM video_segments WHERE document_id = ?`, docID); err != nil {
 		return fmt.Errorf("failed to delete video segments: %w", err)
 	}
+	if _, err := tx.Exec(`DELETE FROM chunk_locations WHERE document_id = ?`, docID); err != nil {
+		return fmt.Errorf("failed to delete chunk locations: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM chunk_image_embeddings WHERE document_id = ?`, docID); err != nil {
+		return fmt.Errorf("failed to delete image vectors: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM document_transcripts WHERE document_id = ?`, docID); err != nil {
+		return fmt.Errorf("failed to delete transcript: %w", err)
+	}
 	if _, err := tx.Exec(`DELETE FROM documents WHERE id = ?`, docID); err != nil {
 		return fmt.Errorf("failed to delete document record: %w", err)
 	}
@@ -674,9 +1064,129 @@ func (dm *DocumentManager) DeleteDocument(docID string) error {
 		return fmt.Errorf("failed to commit delete transaction: %w", err)
 	}
 
-	// Remove original file directory (after successful DB commit)
-	dir := filepath.Join(".", "data", "uploads", docID)
-	os.RemoveAll(dir)
+	// Remove original files (after successful DB commit); uploads from
+	// before storage keys were recorded are always on local disk
+	if err := dm.Storage().DeletePrefix("uploads/" + docID); err != nil {
+		log.Printf("Warning: failed to delete original file of doc=%s: %v", docID, err)
+	}
+	os.RemoveAll(filepath.Join(".", "data", "uploads", docID))
+	dm.deleteImages(docID)
+	return nil
+}
+
+// deleteImages removes the extracted images of a document from the image store.
+func (dm *DocumentManager) deleteImages(docID string) {
+	dm.mu.RLock()
+	images := dm.images
+	dm.mu.RUnlock()
+	if images == nil {
+		return
+	}
+	if err := images.DeleteByDocument(docID); err != nil {
+		log.Printf("Warning: failed to delete images of doc=%s: %v", docID, err)
+	}
+}
+
+// ReleaseBlocked reprocesses a document that moderation blocked, after an
+// admin approved it; prohibited terms are ignored this time but personal
+// data is still masked. fileType is the upload type of the original file
+// and is unused for URL documents. Processing runs in the background.
+func (dm *DocumentManager) ReleaseBlocked(docID, fileType string) error {
+	return dm.reprocess(docID, fileType, true)
+}
+
+// Reprocess imports a document whose import failed again, from its
+// original file or URL. fileType is as for ReleaseBlocked. Processing runs
+// in the background.
+func (dm *DocumentManager) Reprocess(docID, fileType string) error {
+	var status string
+	if err := dm.db.QueryRow(`SELECT status FROM documents WHERE id = ?`, docID).Scan(&status); err != nil {
+		return fmt.Errorf("document not found: %w", err)
+	}
+	if status != "failed" {
+		return ErrNotFailed
+	}
+	return dm.reprocess(docID, fileType, false)
+}
+
+// reprocess drops what was stored of a document and processes it again in
+// the background; released skips the prohibited terms check.
+func (dm *DocumentManager) reprocess(docID, fileType string, released bool) error {
+	var name, docType, productID string
+	err := dm.db.QueryRow(`SELECT name, type, product_id FROM documents WHERE id = ?`, docID).Scan(&name, &docType, &productID)
+	if err != nil {
+		return fmt.Errorf("document not found: %w", err)
+	}
+
+	var fileData []byte
+	if docType != "url" {
+		if !supportedFileTypes[fileType] {
+			return fmt.Errorf("不支持的文件格式")
+		}
+		orig, err := dm.GetOriginal(docID)
+		if err != nil {
+			return err
+		}
+		if fileData, err = orig.Read(); err != nil {
+			return fmt.Errorf("failed to read original file: %w", err)
+		}
+	}
+
+	if !dm.startJob() {
+		return ErrShuttingDown
+	}
+	// Drop whatever was stored before
+	if err := dm.vectorStore.DeleteByDocID(docID); err != nil {
+		dm.jobs.Done()
+		return fmt.Errorf("failed to delete vectors: %w", err)
+	}
+	dm.db.Exec(`DELETE FROM video_segments WHERE document_id = ?`, docID)
+	dm.db.Exec(`DELETE FROM chunk_locations WHERE document_id = ?`, docID)
+	dm.db.Exec(`DELETE FROM chunk_image_embeddings WHERE document_id = ?`, docID)
+	dm.deleteImages(docID)
+	dm.updateDocumentStatus(docID, "processing", "")
+	jobType := fileType
+	if docType == "url" {
+		jobType = "url"
+	}
+	dm.startProgress(docID, name, jobType)
+
+	logPrefix, what := "[Documents]", "retried"
+	if released {
+		logPrefix, what = "[Moderation]", "released"
+	}
+	ctx, release := dm.jobContext(context.Background(), docID)
+	go func() {
+		defer dm.jobs.Done()
+		defer release()
+		if released {
+			dm.released.Store(docID, true)
+			defer dm.released.Delete(docID)
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				dm.updateDocumentStatus(docID, "failed", fmt.Sprintf("panic: %v", r))
+				errlog.Logf("%s panic reprocessing %s doc=%s file=%q: %v", logPrefix, what, docID, name, r)
+			}
+		}()
+
+		var processErr error
+		switch {
+		case docType == "url":
+			_, processErr = dm.processURL(ctx, docID, name, productID)
+		case videoFileTypes[fileType]:
+			processErr = dm.processVideo(ctx, docID, name, nil, fileData, productID)
+		default:
+			_, processErr = dm.processFile(ctx, docID, name, fileData, fileType, productID)
+		}
+		if processErr != nil {
+			processErr = dm.processingFailed(ctx, docID, processErr)
+			errlog.Logf("%s reprocessing %s doc=%s file=%q failed: %v", logPrefix, what, docID, name, processErr)
+			return
+		}
+		dm.updateDocumentStatus(docID, "success", "")
+		log.Printf("%s %s doc=%s reprocessed", logPrefix, what, docID)
+	}()
 	return nil
 }
 
@@ -687,11 +1197,11 @@ func (dm *DocumentManager) ListDocuments(productID string) ([]DocumentInfo, erro
 
 	if productID != "" {
 		rows, err = dm.db.Query(
-			`SELECT id, name, type, status, error, created_at, product_id FROM documents WHERE product_id = ? OR product_id = '' ORDER BY created_at DESC`,
+			`SELECT id, name, type, status, error, created_at, product_id, priority, scan_status, scan_detail, batch_id, source_version FROM documents WHERE product_id = ? OR product_id = '' ORDER BY created_at DESC`,
 			productID,
 		)
 	} else {
-		rows, err = dm.db.Query(`SELECT id, name, type, status, error, created_at, product_id FROM documents ORDER BY created_at DESC`)
+		rows, err = dm.db.Query(`SELECT id, name, type, status, error, created_at, product_id, priority, scan_status, scan_detail, batch_id, source_version FROM documents ORDER BY created_at DESC`)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to query documents: %w", err)
@@ -703,7 +1213,7 @@ func (dm *DocumentManager) ListDocuments(productID string) ([]DocumentInfo, erro
 		var d DocumentInfo
 		var errStr sql.NullString
 		var createdAt sql.NullTime
-		if err := rows.Scan(&d.ID, &d.Name, &d.Type, &d.Status, &errStr, &createdAt, &d.ProductID); err != nil {
+		if err := rows.Scan(&d.ID, &d.Name, &d.Type, &d.Status, &errStr, &createdAt, &d.ProductID, &d.Priority, &d.ScanStatus, &d.ScanDetail, &d.BatchID, &d.SourceVersion); err != nil {
 			return nil, fmt.Errorf("failed to scan document row: %w", err)
 		}
 		if errStr.Valid {
@@ -725,12 +1235,19 @@ func (dm *DocumentManager) ListDocuments(productID string) ([]DocumentInfo, erro
 // It performs content-level deduplication: if a document with the same content
 // hash already exists, the upload is skipped to save API calls.
 // For scanned PDFs (no text but images present), it uses LLM vision OCR to extract text.
-func (dm *DocumentManager) processFile(docID, docName string, fileData []byte, fileType string, productID string) (*ImportStats, error) {
+// Processing stops with the cause of ctx once ctx is done.
+func (dm *DocumentManager) processFile(ctx context.Context, docID, docName string, fileData []byte, fileType string, productID string) (*ImportStats, error) {
+	if subtitleFileTypes[fileType] {
+		return dm.processSubtitle(ctx, docID, docName, fileData, productID)
+	}
 	result, err := dm.parser.Parse(fileData, fileType)
 	if err != nil {
 		errlog.Logf("[Parse] failed to parse doc=%s file=%q type=%s: %v", docID, docName, fileType, err)
 		return nil, fmt.Errorf("parse error: %w", err)
 	}
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
 	if result.Text == "" && len(result.Images) == 0 {
 		errlog.Logf("[Parse] empty content doc=%s file=%q type=%s", docID, docName, fileType)
 		return nil, fmt.Errorf("文档内容为空")
@@ -759,16 +1276,19 @@ func (dm *DocumentManager) processFile(docID, docName string, fileData []byte, f
 			}
 
 			var ocrWg sync.WaitGroup
+			var ocrDone atomic.Int32
+			dm.reportProgress(docID, StageParse, "ocr", 0, len(result.Images))
 			for w := 0; w < workerCount; w++ {
 				ocrWg.Add(1)
 				go func() {
 					defer ocrWg.Done()
 					for i := range pageCh {
 						img := result.Images[i]
-						if len(img.Data) == 0 {
+						if len(img.Data) == 0 || ctx.Err() != nil {
 							continue
 						}
-						ocrText, ocrErr := dm.ocrImageViaLLM(img.Data)
+						ocrText, ocrErr := dm.ocrImageViaLLM(ctx, img.Data)
+						dm.reportProgress(docID, StageParse, "ocr", int(ocrDone.Add(1)), len(result.Images))
 						if ocrErr != nil {
 							log.Printf("Warning: OCR第%d页失败: %v", i+1, ocrErr)
 							errlog.Logf("[OCR] page %d failed for doc=%s file=%q: %v", i+1, docID, docName, ocrErr)
@@ -799,6 +1319,9 @@ func (dm *DocumentManager) processFile(docID, docName string, fileData []byte, f
 			sort.Slice(pageResults, func(i, j int) bool {
 				return pageResults[i].index < pageResults[j].index
 			})
+			if err := canceled(ctx); err != nil {
+				return nil, err
+			}
 
 			// Store each page as a chunk with its image (like PPT slides),
 			// so search results directly include the relevant page image.
@@ -807,7 +1330,7 @@ func (dm *DocumentManager) processFile(docID, docName string, fileData []byte, f
 				pageImageURLs := make(map[int]string)
 				for i, img := range result.Images {
 					if len(img.Data) > 0 {
-						savedURL, saveErr := dm.saveExtractedImage(img.Data)
+						savedURL, saveErr := dm.saveExtractedImage(img.Data, docID, productID)
 						if saveErr != nil {
 							log.Printf("Warning: failed to save scanned PDF page image %d: %v", i, saveErr)
 							errlog.Logf("[Extract] failed to save scanned PDF page image %d for doc=%s file=%q: %v", i, docID, docName, saveErr)
@@ -822,6 +1345,10 @@ func (dm *DocumentManager) processFile(docID, docName string, fileData []byte, f
 				for i, pr := range pageResults {
 					texts[i] = pr.text
 				}
+				texts, err := dm.moderate(docID, docName, productID, texts)
+				if err != nil {
+					return nil, err
+				}
 				const batchSize = 64
 				vectors := make([][]float64, len(texts))
 				for start := 0; start < len(texts); start += batchSize {
@@ -829,7 +1356,11 @@ func (dm *DocumentManager) processFile(docID, docName string, fileData []byte, f
 					if end > len(texts) {
 						end = len(texts)
 					}
-					batch, embErr := dm.embeddingService.EmbedBatch(texts[start:end])
+					if err := canceled(ctx); err != nil {
+						return nil, err
+					}
+					dm.reportProgress(docID, StageEmbed, "", start, len(texts))
+					batch, embErr := dm.embeddingService.EmbedBatch(ctx, texts[start:end])
 					if embErr != nil {
 						errlog.Logf("[Embed] scanned PDF embedding failed (batch %d-%d) doc=%s file=%q: %v", start, end, docID, docName, embErr)
 						return nil, fmt.Errorf("scanned PDF embedding error (batch %d-%d): %w", start, end, embErr)
@@ -838,9 +1369,14 @@ func (dm *DocumentManager) processFile(docID, docName string, fileData []byte, f
 				}
 
 				// Store each page as a chunk with its page image
+				locs := make([]chunkLocation, 0, len(pageResults))
 				for i, pr := range pageResults {
+					if err := canceled(ctx); err != nil {
+						return nil, err
+					}
+					dm.reportProgress(docID, StageStore, "", i, len(pageResults))
 					pageChunk := []vectorstore.VectorChunk{{
-						ChunkText:    pr.text,
+						ChunkText:    texts[i],
 						ChunkIndex:   pr.index,
 						DocumentID:   docID,
 						DocumentName: docName,
@@ -851,8 +1387,11 @@ func (dm *DocumentManager) processFile(docID, docName string, fileData []byte, f
 					if err := dm.vectorStore.Store(docID, pageChunk); err != nil {
 						log.Printf("Warning: failed to store scanned PDF page %d: %v", pr.index, err)
 						errlog.Logf("[Store] failed to store scanned PDF page %d for doc=%s file=%q: %v", pr.index, docID, docName, err)
+					} else {
+						locs = append(locs, chunkLocation{index: pr.index, page: result.Images[pr.index].Page})
 					}
 				}
+				dm.storeChunkLocations(docID, locs)
 
 				log.Printf("扫描型PDF存储完成: doc=%s, %d 页 (每页含文本+图片)", docID, len(pageResults))
 
@@ -909,9 +1448,13 @@ func (dm *DocumentManager) processFile(docID, docName string, fileData []byte, f
 		}
 		var slides []slideInfo
 		for i, img := range result.Images {
+			if err := canceled(ctx); err != nil {
+				return nil, err
+			}
+			dm.reportProgress(docID, StageChunk, "slides", i, len(result.Images))
 			var savedLocalURL string
 			if len(img.Data) > 0 {
-				savedURL, saveErr := dm.saveExtractedImage(img.Data)
+				savedURL, saveErr := dm.saveExtractedImage(img.Data, docID, productID)
 				if saveErr != nil {
 					log.Printf("Warning: failed to save PPT slide image %d: %v", i, saveErr)
 					errlog.Logf("[Extract] failed to save PPT slide image %d for doc=%s file=%q: %v", i, docID, docName, saveErr)
@@ -942,6 +1485,10 @@ func (dm *DocumentManager) processFile(docID, docName string, fileData []byte, f
 		for i, s := range slides {
 			texts[i] = s.text
 		}
+		texts, err := dm.moderate(docID, docName, productID, texts)
+		if err != nil {
+			return nil, err
+		}
 		const batchSize = 64
 		vectors := make([][]float64, len(texts))
 		log.Printf("[PPT] Phase 2: Starting embedding for %d slides, doc=%s", len(texts), docID)
@@ -950,8 +1497,12 @@ func (dm *DocumentManager) processFile(docID, docName string, fileData []byte, f
 			if end > len(texts) {
 				end = len(texts)
 			}
+			if err := canceled(ctx); err != nil {
+				return nil, err
+			}
 			log.Printf("[PPT] Embedding batch %d-%d for doc=%s", start, end, docID)
-			batch, embErr := dm.embeddingService.EmbedBatch(texts[start:end])
+			dm.reportProgress(docID, StageEmbed, "", start, len(texts))
+			batch, embErr := dm.embeddingService.EmbedBatch(ctx, texts[start:end])
 			if embErr != nil {
 				log.Printf("[PPT] Embedding failed for batch %d-%d, doc=%s: %v", start, end, docID, embErr)
 				errlog.Logf("[Embed] PPT slide embedding failed (batch %d-%d) doc=%s file=%q: %v", start, end, docID, docName, embErr)
@@ -964,9 +1515,15 @@ func (dm *DocumentManager) processFile(docID, docName string, fileData []byte, f
 		// Phase 3: Store each slide chunk with its image URL
 		log.Printf("[PPT] Phase 3: Storing %d slide chunks for doc=%s", len(slides), docID)
 		imageCount := 0
+		textLen := len([]rune(result.Text))
+		locs := make([]chunkLocation, 0, len(slides))
 		for i, s := range slides {
+			if err := canceled(ctx); err != nil {
+				return nil, err
+			}
+			dm.reportProgress(docID, StageStore, "", i, len(slides))
 			slideChunk := []vectorstore.VectorChunk{{
-				ChunkText:    s.text,
+				ChunkText:    texts[i],
 				ChunkIndex:   s.index,
 				DocumentID:   docID,
 				DocumentName: docName,
@@ -979,8 +1536,17 @@ func (dm *DocumentManager) processFile(docID, docName string, fileData []byte, f
 				errlog.Logf("[Store] failed to store PPT slide %d for doc=%s file=%q: %v", s.index+1, docID, docName, err)
 			} else {
 				imageCount++
+				if s.imageURL != "" {
+					if resized := resizeImageForEmbedding(result.Images[s.index].Data); resized != nil {
+						dm.storeImageEmbedding(ctx, docID, docName, s.index, imageToBase64DataURL(resized))
+					}
+				}
+				loc := chunkLocation{index: s.index, slide: result.Images[s.index].Page}
+				loc.start, loc.end, _ = sectionSpan(result.Sections, loc.slide, textLen)
+				locs = append(locs, loc)
 			}
 		}
+		dm.storeChunkLocations(docID, locs)
 		stats.ImageCount = imageCount
 		log.Printf("[PPT] Phase 3 complete: stored %d slides for doc=%s", imageCount, docID)
 		return stats, nil
@@ -988,20 +1554,25 @@ func (dm *DocumentManager) processFile(docID, docName string, fileData []byte, f
 
 	// Store text chunks (for non-PPT documents)
 	if result.Text != "" {
-		if err := dm.chunkEmbedStore(docID, docName, result.Text, productID); err != nil {
+		if err := dm.chunkEmbedStoreLayout(ctx, docID, docName, result.Text, productID, layoutOf(result, fileType)); err != nil {
 			return nil, err
 		}
 	}
 
 	// Store image embeddings (for non-PPT documents)
 	imageCount := 0
+	var imageLocs []chunkLocation
 	for i, img := range result.Images {
+		if err := canceled(ctx); err != nil {
+			return nil, err
+		}
+		dm.reportProgress(docID, StageEmbed, "images", i, len(result.Images))
 		imgURL := img.URL
 
 		// For embedded images (e.g. from PDF), save to disk for UI display
 		var savedLocalURL string
 		if imgURL == "" && len(img.Data) > 0 {
-			savedURL, saveErr := dm.saveExtractedImage(img.Data)
+			savedURL, saveErr := dm.saveExtractedImage(img.Data, docID, productID)
 			if saveErr != nil {
 				log.Printf("Warning: failed to save extracted image %d: %v", i, saveErr)
 				errlog.Logf("[Extract] failed to save extracted image %d for doc=%s file=%q: %v", i, docID, docName, saveErr)
@@ -1025,7 +1596,7 @@ func (dm *DocumentManager) processFile(docID, docName string, fileData []byte, f
 			continue
 		}
 
-		vec, err := dm.embeddingService.EmbedImageURL(embedURL)
+		vec, err := dm.embeddingService.EmbedImageURL(ctx, embedURL)
 		if err != nil {
 			log.Printf("Warning: failed to embed image %d (%s): %v", i, img.Alt, err)
 			errlog.Logf("[Embed] failed to embed image %d (%s) for doc=%s file=%q: %v", i, img.Alt, docID, docName, err)
@@ -1052,36 +1623,17 @@ func (dm *DocumentManager) processFile(docID, docName string, fileData []byte, f
 			errlog.Logf("[Store] failed to store image vector %d for doc=%s file=%q: %v", i, docID, docName, err)
 		} else {
 			imageCount++
+			dm.storeImageEmbedding(ctx, docID, docName, 1000+i, embedURL)
+			if img.Page > 0 {
+				imageLocs = append(imageLocs, chunkLocation{index: 1000 + i, page: img.Page})
+			}
 		}
 	}
+	dm.storeChunkLocations(docID, imageLocs)
 	stats.ImageCount = imageCount
 
 	return stats, nil
 }
-// findSavedFile returns the path to the first regular file in dir, or "" if none found.
-func (dm *DocumentManager) findSavedFile(dir string) string {
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return ""
-	}
-	for _, e := range entries {
-		if !e.IsDir() {
-			return filepath.Join(dir, e.Name())
-		}
-	}
-	return ""
-}
-
-// sanitizeFilename cleans a filename for safe use on all platforms.
-func sanitizeFilename(name, fallbackID string) string {
-	safe := filepath.Base(name)
-	safe = strings.NewReplacer(":", "_", "*", "_", "?", "_", "\"", "_", "<", "_", ">", "_", "|", "_").Replace(safe)
-	if safe == "" || safe == "." || safe == ".." {
-		safe = fallbackID + ".video"
-	}
-	return safe
-}
-
 // mapChunkToTimeRange maps a chunk text back to the transcript segments to determine
 // the time range covered by the chunk. Returns the start time of the first matching
 // segment and the end time of the last matching segment.
@@ -1126,7 +1678,8 @@ type URLPreviewResult struct {
 }
 
 // PreviewURL fetches and parses URL content for user preview before committing.
-func (dm *DocumentManager) PreviewURL(rawURL string) (*URLPreviewResult, error) {
+// The fetch is abandoned once ctx is done.
+func (dm *DocumentManager) PreviewURL(ctx context.Context, rawURL string) (*URLPreviewResult, error) {
 	if rawURL == "" {
 		return nil, fmt.Errorf("URL不能为空")
 	}
@@ -1134,7 +1687,11 @@ func (dm *DocumentManager) PreviewURL(rawURL string) (*URLPreviewResult, error)
 		return nil, err
 	}
 
-	resp, err := dm.httpClient.Get(rawURL)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("无法访问该URL: %w", err)
+	}
+	resp, err := dm.httpClient.Do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("无法访问该URL: %w", err)
 	}
@@ -1189,12 +1746,17 @@ func (dm *DocumentManager) PreviewURL(rawURL string) (*URLPreviewResult, error)
 }
 
 // processURL fetches URL content and processes it as plain text.
-func (dm *DocumentManager) processURL(docID, url string, productID string) (*ImportStats, error) {
+func (dm *DocumentManager) processURL(ctx context.Context, docID, url string, productID string) (*ImportStats, error) {
 	if err := dm.validateURL(url); err != nil {
 		return nil, err
 	}
 
-	resp, err := dm.httpClient.Get(url)
+	dm.reportProgress(docID, StageParse, "fetch", 0, 1)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch URL: %w", err)
+	}
+	resp, err := dm.httpClient.Do(httpReq)
 	if err != nil {
 		errlog.Logf("[URL] fetch failed doc=%s url=%q: %v", docID, url, err)
 		return nil, fmt.Errorf("failed to fetch URL: %w", err)
@@ -1239,17 +1801,20 @@ func (dm *DocumentManager) processURL(docID, url string, productID string) (*Imp
 			dm.db.Exec(`UPDATE documents SET content_hash = ? WHERE id = ?`, hash, docID)
 		}
 		if result.Text != "" {
-			if err := dm.chunkEmbedStore(docID, url, result.Text, productID); err != nil {
+			if err := dm.chunkEmbedStore(ctx, docID, url, result.Text, productID); err != nil {
 				return nil, err
 			}
 		}
 		// Embed images found in the HTML
 		imageCount := 0
 		for i, img := range result.Images {
+			if err := canceled(ctx); err != nil {
+				return nil, err
+			}
 			if img.URL == "" {
 				continue
 			}
-			vec, err := dm.embeddingService.EmbedImageURL(img.URL)
+			vec, err := dm.embeddingService.EmbedImageURL(ctx, img.URL)
 			if err != nil {
 				log.Printf("Warning: failed to embed HTML image %d (%s): %v", i, img.Alt, err)
 				errlog.Logf("[Embed] failed to embed HTML image %d (%s) for doc=%s url=%q: %v", i, img.Alt, docID, url, err)
@@ -1269,6 +1834,7 @@ func (dm *DocumentManager) processURL(docID, url string, productID string) (*Imp
 				errlog.Logf("[Store] failed to store HTML image vector %d for doc=%s url=%q: %v", i, docID, url, err)
 			} else {
 				imageCount++
+				dm.storeImageEmbedding(ctx, docID, url, 1000+i, img.URL)
 			}
 		}
 		stats.ImageCount = imageCount
@@ -1282,7 +1848,7 @@ func (dm *DocumentManager) processURL(docID, url string, productID string) (*Imp
 	}
 	dm.db.Exec(`UPDATE documents SET content_hash = ? WHERE id = ?`, hash, docID)
 
-	if err := dm.chunkEmbedStore(docID, url, text, productID); err != nil {
+	if err := dm.chunkEmbedStore(ctx, docID, url, text, productID); err != nil {
 		return nil, err
 	}
 	return &ImportStats{TextChars: len([]rune(text))}, nil
@@ -1364,7 +1930,14 @@ func looksLikeHTML(content string) bool {
 // chunkEmbedStore splits text into chunks, embeds them in batch, and stores vectors.
 // It performs chunk-level deduplication: if a chunk with identical text already exists
 // in the database, its embedding is reused instead of calling the embedding API.
-func (dm *DocumentManager) chunkEmbedStore(docID, docName, text string, productID string) error {
+func (dm *DocumentManager) chunkEmbedStore(ctx context.Context, docID, docName, text string, productID string) error {
+	return dm.chunkEmbedStoreLayout(ctx, docID, docName, text, productID, textLayout{})
+}
+
+// chunkEmbedStoreLayout is chunkEmbedStore for text with a page or slide
+// layout, and records where each chunk came from.
+func (dm *DocumentManager) chunkEmbedStoreLayout(ctx context.Context, docID, docName, text string, productID string, layout textLayout) error {
+	dm.reportProgress(docID, StageChunk, "", 0, 1)
 	chunks := dm.chunker.Split(text, docID)
 	if len(chunks) == 0 {
 		return fmt.Errorf("分块结果为空")
@@ -1375,6 +1948,10 @@ func (dm *DocumentManager) chunkEmbedStore(docID, docName, text string, productI
 	for i, c := range chunks {
 		texts[i] = c.Text
 	}
+	texts, err := dm.moderate(docID, docName, productID, texts)
+	if err != nil {
+		return err
+	}
 
 	// Chunk-level dedup: look up existing embeddings for identical chunk texts
 	existingEmbeddings := dm.getExistingChunkEmbeddings(texts)
@@ -1391,7 +1968,7 @@ func (dm *DocumentManager) chunkEmbedStore(docID, docName, text string, productI
 
 	// Only call embedding API for chunks that don't have existing embeddings
 	if len(newTexts) > 0 {
-		newEmbeddings, err := dm.embeddingService.EmbedBatch(newTexts)
+		newEmbeddings, err := dm.embedWithProgress(ctx, newTexts, dm.progressReporter(docID, StageEmbed, ""))
 		if err != nil {
 			errlog.Logf("[Embed] batch embedding failed doc=%s file=%q: %v", docID, docName, err)
 			return fmt.Errorf("embedding error: %w", err)
@@ -1410,34 +1987,57 @@ func (dm *DocumentManager) chunkEmbedStore(docID, docName, text string, productI
 	vectorChunks := make([]vectorstore.VectorChunk, len(chunks))
 	for i, c := range chunks {
 		vectorChunks[i] = vectorstore.VectorChunk{
-			ChunkText:    c.Text,
+			ChunkText:    texts[i],
 			ChunkIndex:   c.Index,
 			DocumentID:   docID,
 			DocumentName: docName,
-			Vector:       existingEmbeddings[c.Text],
+			Vector:       existingEmbeddings[texts[i]],
 			ProductID:    productID,
 		}
 	}
 
+	if err := canceled(ctx); err != nil {
+		return err
+	}
+	dm.reportProgress(docID, StageStore, "", 0, 1)
 	if err := dm.vectorStore.Store(docID, vectorChunks); err != nil {
 		errlog.Logf("[Store] vector store failed doc=%s file=%q: %v", docID, docName, err)
 		return fmt.Errorf("vector store error: %w", err)
 	}
+
+	locs := make([]chunkLocation, len(chunks))
+	for i, c := range chunks {
+		locs[i] = layout.locate(c)
+	}
+	dm.storeChunkLocations(docID, locs)
 	return nil
 }
 
 // insertDocument inserts a new document record into the documents table.
 func (dm *DocumentManager) insertDocument(doc *DocumentInfo, contentHash string) error {
-	_, err := dm.db.Exec(
-		`INSERT INTO documents (id, name, type, status, error, created_at, product_id, content_hash) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-		doc.ID, doc.Name, doc.Type, doc.Status, doc.Error, doc.CreatedAt, doc.ProductID, contentHash,
-	)
-	return err
+	return db.RetryBusy(func() error {
+		var scannedAt interface{}
+		if doc.ScanStatus != "" {
+			scannedAt = doc.CreatedAt
+		}
+		_, err := dm.db.Exec(
+			`INSERT INTO documents (id, name, type, status, error, created_at, product_id, content_hash, scan_status, scan_detail, scanned_at, batch_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			doc.ID, doc.Name, doc.Type, doc.Status, doc.Error, doc.CreatedAt, doc.ProductID, contentHash, doc.ScanStatus, doc.ScanDetail, scannedAt, doc.BatchID,
+		)
+		return err
+	})
 }
 
 // updateDocumentStatus updates the status and error fields of a document.
 func (dm *DocumentManager) updateDocumentStatus(docID, status, errMsg string) {
-	result, err := dm.db.Exec(`UPDATE documents SET status = ?, error = ? WHERE id = ?`, status, errMsg, docID)
+	var result sql.Result
+	err := db.RetryBusy(func() (err error) {
+		result, err = dm.db.Exec(`UPDATE documents SET status = ?, error = ? WHERE id = ?`, status, errMsg, docID)
+		return err
+	})
+	if status == "success" || status == "failed" {
+		dm.finishProgress(docID, status, errMsg)
+	}
 	if err != nil {
 		log.Printf("[DB] Failed to update document status for %s: %v", docID, err)
 		errlog.Logf("[DB] Failed to update document status for doc=%s status=%s: %v", docID, status, err)
@@ -1445,70 +2045,54 @@ func (dm *DocumentManager) updateDocumentStatus(docID, status, errMsg string) {
 	}
 	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
 		log.Printf("[DB] Warning: no rows updated for document %s (status=%s)", docID, status)
+		return
+	}
+	if status == "success" || status == "failed" {
+		dm.mu.RLock()
+		hook := dm.onProcessed
+		dm.mu.RUnlock()
+		if hook != nil {
+			hook(docID, status, errMsg)
+		}
 	}
 }
 
-// saveOriginalFile saves the uploaded file to data/uploads/{docID}/{filename}.
+// saveOriginalFile stores the uploaded file as uploads/{docID}/{filename}
+// in the storage backend and records the key on the document.
 func (dm *DocumentManager) saveOriginalFile(docID, filename string, data []byte) error {
 	// Sanitize filename to prevent path traversal
-	filename = filepath.Base(filename)
-	if filename == "." || filename == ".." || filename == "" {
+	filename = path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if filename == "." || filename == ".." || filename == "/" || filename == "" {
 		return fmt.Errorf("invalid filename")
 	}
 	// Remove characters that are problematic on Windows and could cause issues
 	filename = strings.Map(func(r rune) rune {
-		if r < 0x20 || r == '<' || r == '>' || r == ':' || r == '"' || r == '|' || r == '?' || r == '*' || r == '\\' {
+		if r < 0x20 || r == '<' || r == '>' || r == ':' || r == '"' || r == '|' || r == '?' || r == '*' {
 			return '_'
 		}
 		return r
 	}, filename)
 
-	dir := filepath.Join(".", "data", "uploads", docID)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create upload dir: %w", err)
+	key := "uploads/" + docID + "/" + filename
+	if err := dm.Storage().Put(key, data); err != nil {
+		return err
 	}
-	filePath := filepath.Join(dir, filename)
-
-	// Final safety check: ensure resolved path is within the uploads directory
-	absDir, _ := filepath.Abs(dir)
-	absFile, _ := filepath.Abs(filePath)
-	if !strings.HasPrefix(absFile, absDir) {
-		return fmt.Errorf("invalid filename: path traversal detected")
+	if _, err := dm.db.Exec(`UPDATE documents SET storage_key = ? WHERE id = ?`, key, docID); err != nil {
+		return fmt.Errorf("failed to record storage key: %w", err)
 	}
-
-	return os.WriteFile(filePath, data, 0644)
+	return nil
 }
 
-// saveExtractedImage saves embedded image data (e.g. from PDF) to data/images/
-// and returns the URL path for accessing it.
-func (dm *DocumentManager) saveExtractedImage(data []byte) (string, error) {
-	// Map MIME type to file extension
-	mime := detectImageMIME(data)
-	ext := ".png"
-	switch mime {
-	case "image/jpeg":
-		ext = ".jpg"
-	case "image/webp":
-		ext = ".webp"
-	case "image/gif":
-		ext = ".gif"
-	}
-
-	b := make([]byte, 16)
-	if _, err := rand.Read(b); err != nil {
-		return "", fmt.Errorf("failed to generate image ID: %w", err)
-	}
-	filename := hex.EncodeToString(b) + ext
-
-	imgDir := filepath.Join(".", "data", "images")
-	if err := os.MkdirAll(imgDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create image dir: %w", err)
-	}
-	if err := os.WriteFile(filepath.Join(imgDir, filename), data, 0644); err != nil {
-		return "", fmt.Errorf("failed to write image: %w", err)
+// saveExtractedImage saves embedded image data (e.g. from PDF) to the image
+// store as belonging to the document and returns its /api/images/ URL.
+func (dm *DocumentManager) saveExtractedImage(data []byte, docID, productID string) (string, error) {
+	dm.mu.RLock()
+	images := dm.images
+	dm.mu.RUnlock()
+	if images == nil {
+		return "", fmt.Errorf("image store not configured")
 	}
-
-	return "/api/images/" + filename, nil
+	return images.Put(data, "", productID, docID)
 }
 
 // GetDocumentInfo returns metadata for a single document by ID.
@@ -1517,8 +2101,8 @@ func (dm *DocumentManager) GetDocumentInfo(docID string) (*DocumentInfo, error)
 	var errStr sql.NullString
 	var createdAt sql.NullTime
 	err := dm.db.QueryRow(
-		"SELECT id, name, type, status, error, created_at, COALESCE(product_id, '') FROM documents WHERE id = ?", docID,
-	).Scan(&d.ID, &d.Name, &d.Type, &d.Status, &errStr, &createdAt, &d.ProductID)
+		"SELECT id, name, type, status, error, created_at, COALESCE(product_id, ''), priority, scan_status, scan_detail, batch_id, source_version FROM documents WHERE id = ?", docID,
+	).Scan(&d.ID, &d.Name, &d.Type, &d.Status, &errStr, &createdAt, &d.ProductID, &d.Priority, &d.ScanStatus, &d.ScanDetail, &d.BatchID, &d.SourceVersion)
 	if err != nil {
 		return nil, fmt.Errorf("document not found: %w", err)
 	}
@@ -1638,6 +2222,9 @@ func (dm *DocumentManager) GetDocumentReview(docID string) (*ReviewData, error)
 				if err := ocrRows.Scan(&text, &idx); err != nil {
 					continue
 				}
+				if text, err = dm.vectorStore.DecodeText(text); err != nil {
+					continue
+				}
 				result.Segments = append(result.Segments, ReviewSegment{
 					Type:    "ocr_description",
 					Content: text,
@@ -1661,6 +2248,9 @@ func (dm *DocumentManager) GetDocumentReview(docID string) (*ReviewData, error)
 				if err := slideRows.Scan(&text, &idx, &imgURL); err != nil {
 					continue
 				}
+				if text, err = dm.vectorStore.DecodeText(text); err != nil {
+					continue
+				}
 				result.Segments = append(result.Segments, ReviewSegment{
 					Type:     "slide",
 					Content:  text,
@@ -1686,6 +2276,9 @@ func (dm *DocumentManager) GetDocumentReview(docID string) (*ReviewData, error)
 				if err := chunkRows.Scan(&text, &idx, &imgURL); err != nil {
 					continue
 				}
+				if text, err = dm.vectorStore.DecodeText(text); err != nil {
+					continue
+				}
 				segType := "chunk"
 				if imgURL != "" && strings.HasPrefix(text, "[图片") {
 					segType = "image"
@@ -1702,51 +2295,72 @@ func (dm *DocumentManager) GetDocumentReview(docID string) (*ReviewData, error)
 	return result, nil
 }
 
-// GetFilePath returns the path to the original uploaded file for a document.
-// Returns empty string if the file doesn't exist.
-func (dm *DocumentManager) GetFilePath(docID string) (string, string, error) {
+// Original is the stored original file of a document.
+type Original struct {
+	Name    string // file name
+	Key     string // storage key
+	backend blob.Backend
+}
+
+// Read returns the file contents.
+func (o *Original) Read() ([]byte, error) {
+	return o.backend.Get(o.Key)
+}
+
+// Serve writes the file to w, honouring Range requests.
+func (o *Original) Serve(w http.ResponseWriter, r *http.Request) {
+	o.backend.Serve(w, r, o.Key)
+}
+
+// GetOriginal returns the original uploaded file of a document. Documents
+// uploaded before storage keys were recorded are looked up under
+// data/uploads/{docID}/ on local disk, whatever the configured backend.
+func (dm *DocumentManager) GetOriginal(docID string) (*Original, error) {
 	// Validate docID: must be hex characters only (generated by generateID)
 	if docID == "" {
-		return "", "", fmt.Errorf("invalid document ID")
+		return nil, fmt.Errorf("invalid document ID")
 	}
 	for _, c := range docID {
 		if !((c >= 'a' && c <= 'f') || (c >= '0' && c <= '9')) {
-			return "", "", fmt.Errorf("invalid document ID")
+			return nil, fmt.Errorf("invalid document ID")
 		}
 	}
 
-	var name string
-	err := dm.db.QueryRow(`SELECT name FROM documents WHERE id = ?`, docID).Scan(&name)
+	var key string
+	err := dm.db.QueryRow(`SELECT storage_key FROM documents WHERE id = ?`, docID).Scan(&key)
 	if err != nil {
-		return "", "", fmt.Errorf("document not found: %w", err)
+		return nil, fmt.Errorf("document not found: %w", err)
+	}
+	if key != "" {
+		return &Original{Name: path.Base(key), Key: key, backend: dm.Storage()}, nil
 	}
 
 	dir := filepath.Join(".", "data", "uploads", docID)
 	entries, err := os.ReadDir(dir)
 	if err != nil || len(entries) == 0 {
-		return "", name, fmt.Errorf("original file not found")
+		return nil, fmt.Errorf("original file not found")
 	}
-
 	// Only serve regular files, not directories or symlinks
 	entry := entries[0]
-	if entry.IsDir() {
-		return "", name, fmt.Errorf("original file not found")
-	}
 	info, err := entry.Info()
-	if err != nil {
-		return "", name, fmt.Errorf("original file not found")
-	}
-	if info.Mode()&os.ModeSymlink != 0 {
-		return "", name, fmt.Errorf("original file not found")
-	}
-
-	filePath := filepath.Join(dir, entry.Name())
-	return filePath, entry.Name(), nil
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("original file not found")
+	}
+	var legacy blob.Backend = blob.NewLocal(filepath.Join(".", "data"))
+	if enc, ok := dm.Storage().(*blob.Encrypted); ok {
+		// Encrypted with the other files when storage is local
+		legacy = enc.Wrap(legacy)
+	}
+	return &Original{
+		Name:    entry.Name(),
+		Key:     "uploads/" + docID + "/" + entry.Name(),
+		backend: legacy,
+	}, nil
 }
 
 // ChunkEmbedStore is a public wrapper around chunkEmbedStore for external callers.
-func (dm *DocumentManager) ChunkEmbedStore(docID, docName, text string, productID string) error {
-	return dm.chunkEmbedStore(docID, docName, text, productID)
+func (dm *DocumentManager) ChunkEmbedStore(ctx context.Context, docID, docName, text string, productID string) error {
+	return dm.chunkEmbedStore(ctx, docID, docName, text, productID)
 }
 
 // GetEmbeddingService returns the current embedding service.
@@ -1762,7 +2376,8 @@ func (dm *DocumentManager) StoreChunks(docID string, chunks []vectorstore.Vector
 }
 
 // ProcessVideoForKnowledge is a public wrapper for processing video files in knowledge entries.
-// It saves the video file to a permanent location and processes it for transcript and keyframes.
-func (dm *DocumentManager) ProcessVideoForKnowledge(docID, docName string, fileData []byte, videoURL string, productID string) error {
-	return dm.processVideo(docID, docName, fileData, productID)
+// key is the storage key the uploaded video was saved under.
+func (dm *DocumentManager) ProcessVideoForKnowledge(ctx context.Context, docID, docName string, fileData []byte, key string, productID string) error {
+	src := &Original{Name: path.Base(key), Key: key, backend: dm.Storage()}
+	return dm.processVideo(ctx, docID, docName, src, fileData, productID)
 }
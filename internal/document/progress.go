@@ -0,0 +1,273 @@
+package document
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"askflow/internal/jobs"
+)
+
+// Processing stages reported while a document is imported, in order.
+const (
+	StageParse = "parse"
+	StageChunk = "chunk"
+	StageEmbed = "embed"
+	StageStore = "store"
+)
+
+// stageRanges maps each stage to the part of the overall percentage it covers.
+// Parsing covers OCR and video transcription, which usually dominate.
+var stageRanges = map[string][2]int{
+	StageParse: {0, 40},
+	StageChunk: {40, 45},
+	StageEmbed: {45, 90},
+	StageStore: {90, 100},
+}
+
+// Progress is a snapshot of a document being processed.
+type Progress struct {
+	DocumentID string `json:"document_id"`
+	// Status is "processing" until the document reaches "success" or "failed".
+	Status string `json:"status"`
+	Stage  string `json:"stage,omitempty"`
+	// Step details the stage, e.g. "ocr" or "transcribe" while parsing.
+	Step string `json:"step,omitempty"`
+	// Done and Total count the units of the current stage (pages, batches,
+	// keyframes), when known.
+	Done    int       `json:"done,omitempty"`
+	Total   int       `json:"total,omitempty"`
+	Percent int       `json:"percent"`
+	Error   string    `json:"error,omitempty"`
+	Updated time.Time `json:"updated_at"`
+}
+
+// progressEntry is the progress of one document and the channels of those
+// watching it.
+type progressEntry struct {
+	last     Progress
+	active   bool
+	watchers map[chan Progress]struct{}
+	job      *jobs.Handle
+}
+
+// progressTracker keeps the progress of documents being processed. Documents
+// are tracked from startProgress until their final status is set.
+type progressTracker struct {
+	mu      sync.Mutex
+	entries map[string]*progressEntry
+	// jobs records processing as ingestion jobs; nil records nothing.
+	jobs *jobs.Registry
+}
+
+// ingestionParams are the params of ingestion jobs, what Reprocess needs.
+type ingestionParams struct {
+	DocumentID string `json:"document_id"`
+	FileType   string `json:"file_type,omitempty"`
+}
+
+// SetJobs records the processing of documents as ingestion jobs in r, which
+// cancel the processing and, once failed, retry it with Reprocess. Call it
+// before uploads are accepted.
+func (dm *DocumentManager) SetJobs(r *jobs.Registry) {
+	dm.progress.jobs = r
+	r.OnRetry(jobs.KindIngestion, func(params json.RawMessage) error {
+		var p ingestionParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return fmt.Errorf("invalid job params: %w", err)
+		}
+		return dm.Reprocess(p.DocumentID, p.FileType)
+	})
+}
+
+// startProgress starts tracking a document about to be processed. name and
+// fileType describe the document for its ingestion job.
+func (dm *DocumentManager) startProgress(docID, name, fileType string) {
+	t := &dm.progress
+	job := t.jobs.Begin(jobs.KindIngestion, name, ingestionParams{DocumentID: docID, FileType: fileType}, func() {
+		dm.CancelProcessing(docID)
+	})
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.entry(docID)
+	e.active = true
+	e.job = job
+	t.publish(e, Progress{DocumentID: docID, Status: "processing", Stage: StageParse, Updated: time.Now()})
+}
+
+// reportProgress records that done of total units of stage are finished.
+// step optionally names the part of the stage being worked on. It does
+// nothing for documents that are not tracked.
+func (dm *DocumentManager) reportProgress(docID, stage, step string, done, total int) {
+	var finished float64
+	if total > 0 {
+		if done > total {
+			done = total
+		}
+		finished = float64(done) / float64(total)
+	}
+	dm.reportStageProgress(docID, stage, step, done, total, finished)
+}
+
+// reportStageProgress is reportProgress for stages made of steps of unequal
+// length: finished is the part of the whole stage done, from 0 to 1, while
+// done and total count the units of the current step.
+func (dm *DocumentManager) reportStageProgress(docID, stage, step string, done, total int, finished float64) {
+	t := &dm.progress
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.entries[docID]
+	if e == nil || !e.active {
+		return
+	}
+	r := stageRanges[stage]
+	percent := r[0] + int(float64(r[1]-r[0])*finished)
+	// Stages such as image embedding may run after text is stored; the
+	// overall percentage never goes back
+	if percent < e.last.Percent {
+		percent = e.last.Percent
+	}
+	message := stage
+	if step != "" {
+		message += ": " + step
+	}
+	if total > 0 {
+		message += fmt.Sprintf(" %d/%d", done, total)
+	}
+	e.job.Progress(percent, message)
+	t.publish(e, Progress{
+		DocumentID: docID,
+		Status:     "processing",
+		Stage:      stage,
+		Step:       step,
+		Done:       done,
+		Total:      total,
+		Percent:    percent,
+		Updated:    time.Now(),
+	})
+}
+
+// progressReporter returns a callback reporting progress of one stage.
+func (dm *DocumentManager) progressReporter(docID, stage, step string) func(done, total int) {
+	return func(done, total int) {
+		dm.reportProgress(docID, stage, step, done, total)
+	}
+}
+
+// finishProgress publishes the final status of a document and stops
+// tracking it.
+func (dm *DocumentManager) finishProgress(docID, status, errMsg string) {
+	t := &dm.progress
+	t.mu.Lock()
+	e := t.entries[docID]
+	if e == nil {
+		t.mu.Unlock()
+		return
+	}
+	p := Progress{DocumentID: docID, Status: status, Error: errMsg, Percent: e.last.Percent, Updated: time.Now()}
+	if status == "success" {
+		p.Percent = 100
+	}
+	t.publish(e, p)
+	for ch := range e.watchers {
+		close(ch)
+	}
+	delete(t.entries, docID)
+	job := e.job
+	t.mu.Unlock()
+
+	var jobErr error
+	switch {
+	case status == "success":
+	case errMsg == ErrCanceled.Error():
+		jobErr = jobs.Canceled(ErrCanceled)
+	default:
+		jobErr = errors.New(errMsg)
+	}
+	job.Finish(jobErr)
+}
+
+// WatchProgress follows the processing of a document. The returned channel
+// carries the latest progress, starting with the current one if the document
+// is being processed, and is closed after the final status was sent. Updates
+// may be skipped for a slow reader, never the final one. stop must be called
+// when done watching.
+func (dm *DocumentManager) WatchProgress(docID string) (updates <-chan Progress, stop func()) {
+	t := &dm.progress
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.entry(docID)
+	ch := make(chan Progress, 1)
+	e.watchers[ch] = struct{}{}
+	if e.active {
+		ch <- e.last
+	}
+	stop = func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if cur := t.entries[docID]; cur == e {
+			delete(e.watchers, ch)
+			if !e.active && len(e.watchers) == 0 {
+				delete(t.entries, docID)
+			}
+		}
+	}
+	return ch, stop
+}
+
+// entry returns the entry of docID, creating it if needed. t.mu must be held.
+func (t *progressTracker) entry(docID string) *progressEntry {
+	if t.entries == nil {
+		t.entries = make(map[string]*progressEntry)
+	}
+	e := t.entries[docID]
+	if e == nil {
+		e = &progressEntry{watchers: make(map[chan Progress]struct{})}
+		t.entries[docID] = e
+	}
+	return e
+}
+
+// publish records p and hands it to the watchers, replacing an update they
+// have not read yet. t.mu must be held.
+func (t *progressTracker) publish(e *progressEntry, p Progress) {
+	e.last = p
+	for ch := range e.watchers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- p
+	}
+}
+
+// progressBatchSize is the number of texts embedded per call when progress is
+// reported in between. It is large enough for the embedding service to still
+// spread each call over several concurrent requests.
+const progressBatchSize = 256
+
+// embedWithProgress embeds texts like EmbedBatch, reporting the number of
+// texts embedded after each part. No further part is sent once ctx is done.
+func (dm *DocumentManager) embedWithProgress(ctx context.Context, texts []string, report func(done, total int)) ([][]float64, error) {
+	vectors := make([][]float64, 0, len(texts))
+	report(0, len(texts))
+	for start := 0; start < len(texts); start += progressBatchSize {
+		if err := canceled(ctx); err != nil {
+			return nil, err
+		}
+		end := start + progressBatchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+		batch, err := dm.embeddingService.EmbedBatch(ctx, texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, batch...)
+		report(end, len(texts))
+	}
+	return vectors, nil
+}
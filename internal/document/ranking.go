@@ -0,0 +1,108 @@
+package document
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// Ranking boosts: the search scores of a document's chunks are multiplied
+// by its admin-set priority and, when a recency half life is configured, by
+// a factor that halves with every half life since the document was
+// uploaded, down to a floor. This lets the current release's manuals
+// outrank deprecated ones that match a question about as well.
+
+// Bounds of the priority an admin can give a document.
+const (
+	MinPriority = 0.1
+	MaxPriority = 10.0
+)
+
+// ErrInvalidPriority is returned for priorities outside [MinPriority, MaxPriority].
+var ErrInvalidPriority = errors.New("文档优先级必须在 0.1 到 10 之间")
+
+// rankingConfig holds the recency decay settings; a zero halfLifeDays
+// disables decay.
+type rankingConfig struct {
+	halfLifeDays int
+	minFactor    float64
+}
+
+// SetRankingConfig sets the recency decay of search boosts
+// (vector.recency_half_life_days, vector.recency_min_factor) and recomputes
+// the boosts.
+func (dm *DocumentManager) SetRankingConfig(halfLifeDays int, minFactor float64) {
+	dm.mu.Lock()
+	dm.ranking = rankingConfig{halfLifeDays: halfLifeDays, minFactor: minFactor}
+	dm.mu.Unlock()
+	if err := dm.RefreshBoosts(); err != nil {
+		log.Printf("[Ranking] failed to refresh document boosts: %v", err)
+	}
+}
+
+// SetPriority sets the ranking priority of a document and applies it to
+// searches.
+func (dm *DocumentManager) SetPriority(docID string, priority float64) error {
+	if math.IsNaN(priority) || priority < MinPriority || priority > MaxPriority {
+		return ErrInvalidPriority
+	}
+	res, err := dm.db.Exec(`UPDATE documents SET priority = ? WHERE id = ?`, priority, docID)
+	if err != nil {
+		return fmt.Errorf("failed to update document priority: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("document not found: %s", docID)
+	}
+	return dm.RefreshBoosts()
+}
+
+// RefreshBoosts recomputes the search boost of every document from its
+// priority and age. Decayed boosts drift over time, so it is also run
+// periodically.
+func (dm *DocumentManager) RefreshBoosts() error {
+	dm.mu.RLock()
+	rc := dm.ranking
+	dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`SELECT id, priority, created_at FROM documents WHERE status = 'success'`)
+	if err != nil {
+		return fmt.Errorf("failed to query documents: %w", err)
+	}
+	defer rows.Close()
+	now := time.Now()
+	boosts := make(map[string]float64)
+	for rows.Next() {
+		var id string
+		var priority float64
+		var createdAt sql.NullTime
+		if err := rows.Scan(&id, &priority, &createdAt); err != nil {
+			return fmt.Errorf("failed to scan document row: %w", err)
+		}
+		var age time.Duration
+		if createdAt.Valid {
+			age = now.Sub(createdAt.Time)
+		}
+		if b := documentBoost(priority, age, rc); b != 1 {
+			boosts[id] = b
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating document rows: %w", err)
+	}
+	dm.vectorStore.SetDocumentBoosts(boosts)
+	return nil
+}
+
+// documentBoost returns the factor by which the search scores of a document
+// with the given priority and age are multiplied.
+func documentBoost(priority float64, age time.Duration, rc rankingConfig) float64 {
+	boost := priority
+	if rc.halfLifeDays > 0 && age > 0 {
+		halfLives := age.Hours() / 24 / float64(rc.halfLifeDays)
+		boost *= math.Max(math.Pow(0.5, halfLives), rc.minFactor)
+	}
+	return boost
+}
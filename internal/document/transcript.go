@@ -0,0 +1,90 @@
+package document
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"askflow/internal/errlog"
+	"askflow/internal/video"
+)
+
+// Sources of a stored transcript.
+const (
+	TranscriptSourceASR      = "asr"      // speech recognition of the video
+	TranscriptSourceSubtitle = "subtitle" // subtitle file uploaded with the video
+)
+
+// ErrNoTranscript is returned for documents without a stored transcript.
+var ErrNoTranscript = errors.New("该文档没有转录")
+
+// subtitleFileTypes identifies subtitle files uploaded as documents of their own.
+var subtitleFileTypes = map[string]bool{"srt": true, "vtt": true}
+
+// GetTranscript returns the stored transcript of a document with its source.
+func (dm *DocumentManager) GetTranscript(docID string) ([]video.TranscriptSegment, string, error) {
+	var source, data string
+	err := dm.db.QueryRow(`SELECT source, segments FROM document_transcripts WHERE document_id = ?`, docID).Scan(&source, &data)
+	if err == sql.ErrNoRows {
+		return nil, "", ErrNoTranscript
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	var segments []video.TranscriptSegment
+	if err := json.Unmarshal([]byte(data), &segments); err != nil {
+		return nil, "", fmt.Errorf("decode transcript of %s: %w", docID, err)
+	}
+	return segments, source, nil
+}
+
+// saveTranscript stores the transcript of a document, replacing the one
+// stored before.
+func (dm *DocumentManager) saveTranscript(docID, source string, segments []video.TranscriptSegment) error {
+	data, err := video.SerializeTranscript(segments)
+	if err != nil {
+		return err
+	}
+	_, err = dm.db.Exec(`INSERT INTO document_transcripts (document_id, source, segments, updated_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(document_id) DO UPDATE SET source = excluded.source, segments = excluded.segments, updated_at = excluded.updated_at`,
+		docID, source, string(data))
+	return err
+}
+
+// subtitleTranscript returns the transcript of the subtitle file uploaded
+// with a video, or nil when there is none, so reprocessing keeps using it.
+func (dm *DocumentManager) subtitleTranscript(docID string) []video.TranscriptSegment {
+	segments, source, err := dm.GetTranscript(docID)
+	if err != nil {
+		if !errors.Is(err, ErrNoTranscript) {
+			log.Printf("Warning: failed to load transcript of doc=%s: %v", docID, err)
+		}
+		return nil
+	}
+	if source != TranscriptSourceSubtitle {
+		return nil
+	}
+	return segments
+}
+
+// processSubtitle stores a subtitle file uploaded as a document: its cues are
+// joined as the document text, one speaker turn per line for WebVTT voice
+// tags, and kept as the transcript for export.
+func (dm *DocumentManager) processSubtitle(ctx context.Context, docID, docName string, fileData []byte, productID string) (*ImportStats, error) {
+	segments, err := video.ParseSubtitles(fileData)
+	if err != nil {
+		errlog.Logf("[Parse] failed to parse subtitles doc=%s file=%q: %v", docID, docName, err)
+		return nil, fmt.Errorf("parse error: %w", err)
+	}
+	text := video.FormatTranscript(segments)
+	if err := dm.chunkEmbedStore(ctx, docID, docName, text, productID); err != nil {
+		return nil, err
+	}
+	if err := dm.saveTranscript(docID, TranscriptSourceSubtitle, segments); err != nil {
+		return nil, fmt.Errorf("保存字幕失败: %w", err)
+	}
+	return &ImportStats{TextChars: len([]rune(text))}, nil
+}
@@ -3,20 +3,31 @@ package document
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"askflow/internal/blob"
+	"askflow/internal/config"
+	"askflow/internal/db"
 	"askflow/internal/errlog"
 	"askflow/internal/vectorstore"
 	"askflow/internal/video"
 )
 
+// videoParseSteps splits the parse stage of a video between the steps of
+// video.Parser, as parts of the stage from 0 to 1.
+var videoParseSteps = map[string][2]float64{
+	video.StepAudio:      {0, 0.2},
+	video.StepTranscribe: {0.2, 0.6},
+	video.StepKeyframes:  {0.6, 1},
+}
+
 // videoOCRResult holds the LLM OCR+description result for a single keyframe.
 type videoOCRResult struct {
 	frameIndex int
@@ -24,13 +35,16 @@ type videoOCRResult struct {
 	timestamp  float64
 }
 
-// processVideo handles video file processing with three concurrent phases:
+// processVideo handles video file processing with three concurrent phases
+// after the video is parsed, here or by a remote video worker:
 //   - Phase 1: ASR transcript → chunk → embed → store
 //   - Phase 2: Keyframe image embedding (worker pool)
 //   - Phase 3: LLM keyframe OCR + scene description (worker pool with per-frame timeout)
 //
 // Each phase is independent and fault-tolerant: one phase failing does not block others.
-func (dm *DocumentManager) processVideo(docID, docName string, fileData []byte, productID string) error {
+// src is the stored video file, or nil for the document's original. Once ctx
+// is done, ffmpeg and RapidSpeech are stopped and no more frames are embedded.
+func (dm *DocumentManager) processVideo(ctx context.Context, docID, docName string, src *Original, fileData []byte, productID string) error {
 	log.Printf("[Video] Starting video processing for doc=%s file=%q", docID, docName)
 
 	dm.mu.RLock()
@@ -39,36 +53,49 @@ func (dm *DocumentManager) processVideo(docID, docName string, fileData []byte,
 
 	log.Printf("[Video] Config: FFmpegPath=%q, RapidSpeechPath=%q", cfg.FFmpegPath, cfg.RapidSpeechPath)
 
-	// Locate or save the video file
-	uploadDir := filepath.Join(".", "data", "uploads", docID)
-	videoPath := dm.findSavedFile(uploadDir)
-	if videoPath == "" {
-		log.Printf("[Video] Saving video file to %s", uploadDir)
-		if err := os.MkdirAll(uploadDir, 0755); err != nil {
-			return fmt.Errorf("创建上传目录失败: %w", err)
-		}
-		safeName := sanitizeFilename(docName, docID)
-		videoPath = filepath.Join(uploadDir, safeName)
-		if err := os.WriteFile(videoPath, fileData, 0644); err != nil {
-			return fmt.Errorf("保存视频文件失败: %w", err)
+	// Make sure the original is stored; it is normally saved on upload
+	if src == nil {
+		orig, err := dm.GetOriginal(docID)
+		if err != nil {
+			log.Printf("[Video] Saving video file for doc=%s", docID)
+			if err := dm.saveOriginalFile(docID, docName, fileData); err != nil {
+				return fmt.Errorf("保存视频文件失败: %w", err)
+			}
+			if orig, err = dm.GetOriginal(docID); err != nil {
+				return fmt.Errorf("保存视频文件失败: %w", err)
+			}
 		}
-		log.Printf("[Video] Video file saved to %s", videoPath)
-	} else {
-		log.Printf("[Video] Using existing video file at %s", videoPath)
+		src = orig
 	}
 
-	if cfg.FFmpegPath == "" && cfg.RapidSpeechPath == "" {
+	// Subtitles uploaded with the video replace speech recognition
+	subtitle := dm.subtitleTranscript(docID)
+	// With video workers ffmpeg and speech-to-text run on them instead
+	remote := cfg.Workers.Enabled && cfg.Workers.Token != ""
+
+	if !remote && cfg.FFmpegPath == "" && cfg.RapidSpeechPath == "" && subtitle == nil {
 		log.Printf("[Video] 视频检索工具未配置，仅存储文件名作为可搜索文本: %s", docName)
 		fallbackText := fmt.Sprintf("视频文件: %s", docName)
-		if err := dm.chunkEmbedStore(docID, docName, fallbackText, productID); err != nil {
+		if err := dm.chunkEmbedStore(ctx, docID, docName, fallbackText, productID); err != nil {
 			return fmt.Errorf("存储视频文件名向量失败: %w", err)
 		}
 		return nil
 	}
 
 	log.Printf("[Video] Starting video parsing for doc=%s", docID)
-	vp := video.NewParser(cfg)
-	parseResult, err := vp.Parse(videoPath)
+	var parseResult *video.ParseResult
+	var err error
+	if remote {
+		parseResult, err = dm.parseVideoRemote(ctx, docID, docName, subtitle != nil)
+		if err == nil && subtitle != nil {
+			parseResult.Transcript = subtitle
+		}
+	} else {
+		parseResult, err = dm.parseVideoLocal(ctx, cfg, docID, src, fileData, subtitle)
+	}
+	if err := canceled(ctx); err != nil {
+		return err
+	}
 	if err != nil {
 		log.Printf("[Video] Parse failed for doc=%s: %v", docID, err)
 		errlog.Logf("[Video] parse failed doc=%s file=%q: %v", docID, docName, err)
@@ -77,6 +104,33 @@ func (dm *DocumentManager) processVideo(docID, docName string, fileData []byte,
 
 	log.Printf("[Video] 视频解析完成 doc=%s: %d 段转录, %d 个关键帧", docID, len(parseResult.Transcript), len(parseResult.Keyframes))
 
+	// Label speakers with the chat model when the STT backend does not
+	if cfg.Diarization == "llm" && len(parseResult.Transcript) > 0 {
+		labelled, err := dm.diarizeTranscript(ctx, parseResult.Transcript, cfg.SpeakerLabels)
+		if err := canceled(ctx); err != nil {
+			return err
+		}
+		if err != nil {
+			log.Printf("Warning: 说话人识别失败 doc=%s: %v", docID, err)
+			errlog.Logf("[Video] speaker labelling failed for doc=%s: %v", docID, err)
+		} else {
+			parseResult.Transcript = labelled
+		}
+	}
+	if len(parseResult.Transcript) > 0 {
+		source := TranscriptSourceASR
+		if subtitle != nil {
+			source = TranscriptSourceSubtitle
+		}
+		if err := dm.saveTranscript(docID, source, parseResult.Transcript); err != nil {
+			log.Printf("Warning: 保存转录失败 doc=%s: %v", docID, err)
+			errlog.Logf("[Video] failed to save transcript for doc=%s: %v", docID, err)
+		}
+	} else {
+		// Drop the transcript of an earlier run
+		dm.db.Exec(`DELETE FROM document_transcripts WHERE document_id = ?`, docID)
+	}
+
 	// Pre-compute which keyframes need OCR before any phase starts
 	ocrEnabled := cfg.KeyframeOCREnabled
 	ocrMaxFrames := cfg.KeyframeOCRMaxFrames
@@ -106,6 +160,15 @@ func (dm *DocumentManager) processVideo(docID, docName string, fileData []byte,
 		}
 	}
 
+	// The phases below embed the transcript, each keyframe and each sampled
+	// keyframe description; progress counts them as they finish
+	var embedded atomic.Int32
+	embedTotal := 1 + len(parseResult.Keyframes) + len(ocrIndices)
+	embedDone := func() {
+		dm.reportProgress(docID, StageEmbed, "", int(embedded.Add(1)), embedTotal)
+	}
+	dm.reportProgress(docID, StageEmbed, "", 0, embedTotal)
+
 	// ── Phase 1: Transcript (ASR) — runs concurrently ──
 	type transcriptResult struct {
 		chunkCount int
@@ -118,7 +181,8 @@ func (dm *DocumentManager) processVideo(docID, docName string, fileData []byte,
 				transcriptCh <- transcriptResult{err: fmt.Errorf("转录处理panic: %v", r)}
 			}
 		}()
-		count, tErr := dm.processTranscript(docID, docName, productID, parseResult)
+		count, tErr := dm.processTranscript(ctx, docID, docName, productID, parseResult)
+		embedDone()
 		transcriptCh <- transcriptResult{chunkCount: count, err: tErr}
 	}()
 
@@ -134,14 +198,14 @@ func (dm *DocumentManager) processVideo(docID, docName string, fileData []byte,
 				keyframeEmbedCh <- keyframeEmbedResult{err: fmt.Errorf("关键帧embedding panic: %v", r)}
 			}
 		}()
-		count, kErr := dm.processKeyframeEmbeddings(docID, docName, productID, parseResult.Keyframes)
+		count, kErr := dm.processKeyframeEmbeddings(ctx, docID, docName, productID, parseResult.Keyframes, embedDone)
 		keyframeEmbedCh <- keyframeEmbedResult{storedCount: count, err: kErr}
 	}()
 
 	// ── Phase 3: LLM keyframe OCR + scene description — concurrent worker pool ──
 	var ocrResults []videoOCRResult
 	if len(ocrIndices) > 0 {
-		ocrResults = dm.processKeyframeDescriptions(docID, parseResult.Keyframes, ocrIndices)
+		ocrResults = dm.processKeyframeDescriptions(ctx, docID, parseResult.Keyframes, ocrIndices, embedDone)
 	}
 
 	// ── Collect results from all phases ──
@@ -164,19 +228,23 @@ func (dm *DocumentManager) processVideo(docID, docName string, fileData []byte,
 	for i := range parseResult.Keyframes {
 		parseResult.Keyframes[i].Data = nil
 	}
+	if err := canceled(ctx); err != nil {
+		return err
+	}
 
 	// ── Store OCR+description texts as searchable chunks ──
 	// Use offset 20000+ for OCR description chunks to avoid collision with
 	// transcript chunks (0..N) and keyframe embedding chunks (10000+i)
 	if len(ocrResults) > 0 {
-		dm.storeKeyframeDescriptions(docID, docName, productID, ocrResults, 20000, len(ocrIndices))
+		dm.reportProgress(docID, StageStore, "", 0, 1)
+		dm.storeKeyframeDescriptions(ctx, docID, docName, productID, ocrResults, 20000, len(ocrIndices))
 	}
 
 	// Fallback: if nothing was stored at all, store filename as searchable text
 	if chunkIndex == 0 && kResult.storedCount == 0 && len(ocrResults) == 0 {
 		log.Printf("视频 %s 未提取到任何可检索内容，存储文件名作为可搜索文本", docID)
 		fallbackText := fmt.Sprintf("视频文件: %s", docName)
-		if err := dm.chunkEmbedStore(docID, docName, fallbackText, productID); err != nil {
+		if err := dm.chunkEmbedStore(ctx, docID, docName, fallbackText, productID); err != nil {
 			return fmt.Errorf("存储视频文件名向量失败: %w", err)
 		}
 	}
@@ -184,25 +252,42 @@ func (dm *DocumentManager) processVideo(docID, docName string, fileData []byte,
 	return nil
 }
 
+// parseVideoLocal runs ffmpeg and speech-to-text on this instance, using
+// subtitle as the transcript when set.
+func (dm *DocumentManager) parseVideoLocal(ctx context.Context, cfg config.VideoConfig, docID string, src *Original, fileData []byte, subtitle []video.TranscriptSegment) (*video.ParseResult, error) {
+	// ffmpeg needs a file on disk; with remote storage a temporary copy is used
+	videoPath, cleanup, err := blob.LocalFile(src.backend, src.Key, fileData)
+	if err != nil {
+		return nil, fmt.Errorf("准备视频文件失败: %w", err)
+	}
+	defer cleanup()
+	vp := video.NewParser(cfg)
+	vp.Transcript = subtitle
+	vp.OnProgress = func(step string, done, total int) {
+		r := videoParseSteps[step]
+		finished := r[0]
+		if total > 0 && done <= total {
+			finished += (r[1] - r[0]) * float64(done) / float64(total)
+		}
+		dm.reportStageProgress(docID, StageParse, step, done, total, finished)
+	}
+	return vp.ParseContext(ctx, videoPath)
+}
+
 // processTranscript handles ASR transcript: join → chunk → embed → store → create video_segments.
 // Returns the number of chunks stored.
-func (dm *DocumentManager) processTranscript(docID, docName, productID string, parseResult *video.ParseResult) (int, error) {
+func (dm *DocumentManager) processTranscript(ctx context.Context, docID, docName, productID string, parseResult *video.ParseResult) (int, error) {
 	if len(parseResult.Transcript) == 0 {
 		return 0, nil
 	}
 
-	var fullText strings.Builder
-	for _, seg := range parseResult.Transcript {
-		if fullText.Len() > 0 {
-			fullText.WriteString(" ")
-		}
-		fullText.WriteString(strings.TrimSpace(seg.Text))
-	}
-	if fullText.Len() == 0 {
+	// With speakers the text reads "Speaker: ..." one turn per line
+	fullText := video.FormatTranscript(parseResult.Transcript)
+	if fullText == "" {
 		return 0, nil
 	}
 
-	chunks := dm.chunker.Split(fullText.String(), docID)
+	chunks := dm.chunker.Split(fullText, docID)
 	if len(chunks) == 0 {
 		return 0, nil
 	}
@@ -211,17 +296,27 @@ func (dm *DocumentManager) processTranscript(docID, docName, productID string, p
 	for i, c := range chunks {
 		texts[i] = c.Text
 	}
+	texts, err := dm.moderate(docID, docName, productID, texts)
+	if err != nil {
+		return 0, err
+	}
+	if err := canceled(ctx); err != nil {
+		return 0, err
+	}
 
-	embeddings, err := dm.embeddingService.EmbedBatch(texts)
+	embeddings, err := dm.embeddingService.EmbedBatch(ctx, texts)
 	if err != nil {
 		errlog.Logf("[Video] transcript embedding failed doc=%s file=%q: %v", docID, docName, err)
 		return 0, fmt.Errorf("转录文本嵌入失败: %w", err)
 	}
+	if err := canceled(ctx); err != nil {
+		return 0, err
+	}
 
 	vectorChunks := make([]vectorstore.VectorChunk, len(chunks))
-	for i, c := range chunks {
+	for i := range chunks {
 		vectorChunks[i] = vectorstore.VectorChunk{
-			ChunkText:    c.Text,
+			ChunkText:    texts[i],
 			ChunkIndex:   i,
 			DocumentID:   docID,
 			DocumentName: docName,
@@ -235,7 +330,11 @@ func (dm *DocumentManager) processTranscript(docID, docName, productID string, p
 	}
 
 	// Create video_segments records
-	segTx, txErr := dm.db.Begin()
+	var segTx *sql.Tx
+	txErr := db.RetryBusy(func() (err error) {
+		segTx, err = dm.db.Begin()
+		return err
+	})
 	if txErr != nil {
 		return len(chunks), fmt.Errorf("开始 video_segments 事务失败: %w", txErr)
 	}
@@ -257,7 +356,7 @@ func (dm *DocumentManager) processTranscript(docID, docName, productID string, p
 			continue
 		}
 		chunkID := fmt.Sprintf("%s-%d", docID, i)
-		if _, err := stmt.Exec(segID, docID, "transcript", startTime, endTime, c.Text, chunkID); err != nil {
+		if _, err := stmt.Exec(segID, docID, "transcript", startTime, endTime, texts[i], chunkID); err != nil {
 			log.Printf("Warning: 插入 video_segments 记录失败: %v", err)
 		}
 	}
@@ -270,8 +369,10 @@ func (dm *DocumentManager) processTranscript(docID, docName, productID string, p
 }
 
 // processKeyframeEmbeddings embeds keyframe images concurrently using a worker pool.
-// Each frame has a per-frame timeout. Returns the number of successfully stored keyframes.
-func (dm *DocumentManager) processKeyframeEmbeddings(docID, docName, productID string, keyframes []video.Keyframe) (int, error) {
+// Each frame has a per-frame timeout; done is called as each frame finishes.
+// Frames left once ctx is done are skipped. Returns the number of successfully
+// stored keyframes.
+func (dm *DocumentManager) processKeyframeEmbeddings(ctx context.Context, docID, docName, productID string, keyframes []video.Keyframe, done func()) (int, error) {
 	if len(keyframes) == 0 {
 		return 0, nil
 	}
@@ -300,7 +401,7 @@ func (dm *DocumentManager) processKeyframeEmbeddings(docID, docName, productID s
 		go func() {
 			defer wg.Done()
 			for job := range jobs {
-				ok := dm.embedSingleKeyframe(docID, docName, productID, job.index, job.keyframe)
+				ok := ctx.Err() == nil && dm.embedSingleKeyframe(ctx, docID, docName, productID, job.index, job.keyframe)
 				results <- embedResult{index: job.index, ok: ok}
 			}
 		}()
@@ -318,6 +419,7 @@ func (dm *DocumentManager) processKeyframeEmbeddings(docID, docName, productID s
 
 	storedCount := 0
 	for res := range results {
+		done()
 		if res.ok {
 			storedCount++
 		}
@@ -328,7 +430,7 @@ func (dm *DocumentManager) processKeyframeEmbeddings(docID, docName, productID s
 
 // embedSingleKeyframe embeds one keyframe image with a per-frame timeout,
 // stores the vector, and creates a video_segments record. Returns true on success.
-func (dm *DocumentManager) embedSingleKeyframe(docID, docName, productID string, i int, kf video.Keyframe) (ok bool) {
+func (dm *DocumentManager) embedSingleKeyframe(ctx context.Context, docID, docName, productID string, i int, kf video.Keyframe) (ok bool) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("Warning: keyframe %d embedding panic: %v", i, r)
@@ -349,7 +451,7 @@ func (dm *DocumentManager) embedSingleKeyframe(docID, docName, productID string,
 	dataURL := imageToBase64DataURL(resized)
 
 	// Per-frame timeout for embedding API call
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 
 	type embedResp struct {
@@ -358,7 +460,7 @@ func (dm *DocumentManager) embedSingleKeyframe(docID, docName, productID string,
 	}
 	ch := make(chan embedResp, 1)
 	go func() {
-		vec, err := dm.embeddingService.EmbedImageURL(dataURL)
+		vec, err := dm.embeddingService.EmbedImageURL(ctx, dataURL)
 		ch <- embedResp{vec, err}
 	}()
 
@@ -378,7 +480,7 @@ func (dm *DocumentManager) embedSingleKeyframe(docID, docName, productID string,
 	}
 
 	// Save keyframe image to disk instead of storing large base64 in vector store
-	savedURL, saveErr := dm.saveExtractedImage(kf.Data)
+	savedURL, saveErr := dm.saveExtractedImage(kf.Data, docID, productID)
 	imageURL := savedURL
 	if saveErr != nil {
 		log.Printf("Warning: failed to save keyframe %d image to disk: %v", i, saveErr)
@@ -413,10 +515,13 @@ func (dm *DocumentManager) embedSingleKeyframe(docID, docName, productID string,
 		return true // vector already stored, segment record is non-critical
 	}
 	chunkID := fmt.Sprintf("%s-%d", docID, frameChunkIndex)
-	_, dbErr := dm.db.Exec(
-		`INSERT INTO video_segments (id, document_id, segment_type, start_time, end_time, content, chunk_id) VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		segID, docID, "keyframe", kf.Timestamp, kf.Timestamp, kf.FilePath, chunkID,
-	)
+	dbErr := db.RetryBusy(func() error {
+		_, err := dm.db.Exec(
+			`INSERT INTO video_segments (id, document_id, segment_type, start_time, end_time, content, chunk_id) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			segID, docID, "keyframe", kf.Timestamp, kf.Timestamp, kf.FilePath, chunkID,
+		)
+		return err
+	})
 	if dbErr != nil {
 		log.Printf("Warning: failed to insert keyframe video_segment %d: %v", i, dbErr)
 	}
@@ -424,9 +529,10 @@ func (dm *DocumentManager) embedSingleKeyframe(docID, docName, productID string,
 }
 
 // processKeyframeDescriptions runs LLM OCR+scene description on sampled keyframes
-// concurrently with a worker pool and per-frame timeout. Returns collected results
-// sorted by frame index for deterministic output.
-func (dm *DocumentManager) processKeyframeDescriptions(docID string, keyframes []video.Keyframe, ocrIndices map[int]bool) []videoOCRResult {
+// concurrently with a worker pool and per-frame timeout, calling done as each
+// frame finishes; frames left once ctx is done are skipped. Returns collected
+// results sorted by frame index for deterministic output.
+func (dm *DocumentManager) processKeyframeDescriptions(ctx context.Context, docID string, keyframes []video.Keyframe, ocrIndices map[int]bool, done func()) []videoOCRResult {
 	type descJob struct {
 		index    int
 		keyframe video.Keyframe
@@ -456,7 +562,10 @@ func (dm *DocumentManager) processKeyframeDescriptions(docID string, keyframes [
 		go func() {
 			defer wg.Done()
 			for job := range jobs {
-				dm.describeSingleKeyframe(docID, job.index, job.keyframe, resultsCh)
+				if ctx.Err() == nil {
+					dm.describeSingleKeyframe(ctx, docID, job.index, job.keyframe, resultsCh)
+				}
+				done()
 			}
 		}()
 	}
@@ -483,7 +592,7 @@ func (dm *DocumentManager) processKeyframeDescriptions(docID string, keyframes [
 
 // describeSingleKeyframe calls LLM vision API for one keyframe with a per-frame
 // timeout and panic recovery. Sends result to ch on success.
-func (dm *DocumentManager) describeSingleKeyframe(docID string, i int, kf video.Keyframe, ch chan<- videoOCRResult) {
+func (dm *DocumentManager) describeSingleKeyframe(ctx context.Context, docID string, i int, kf video.Keyframe, ch chan<- videoOCRResult) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("Warning: keyframe %d LLM描述 panic: %v", i, r)
@@ -492,7 +601,7 @@ func (dm *DocumentManager) describeSingleKeyframe(docID string, i int, kf video.
 	}()
 
 	// Per-frame timeout: 3 minutes for LLM vision call
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Minute)
 	defer cancel()
 
 	type llmResp struct {
@@ -501,7 +610,7 @@ func (dm *DocumentManager) describeSingleKeyframe(docID string, i int, kf video.
 	}
 	llmCh := make(chan llmResp, 1)
 	go func() {
-		text, err := dm.describeKeyframeViaLLM(kf.Data)
+		text, err := dm.describeKeyframeViaLLM(ctx, kf.Data)
 		llmCh <- llmResp{text, err}
 	}()
 
@@ -525,7 +634,7 @@ func (dm *DocumentManager) describeSingleKeyframe(docID string, i int, kf video.
 
 // storeKeyframeDescriptions combines OCR+description results into text chunks,
 // embeds them, and stores as searchable vectors.
-func (dm *DocumentManager) storeKeyframeDescriptions(docID, docName, productID string, results []videoOCRResult, chunkBase, totalOCRFrames int) {
+func (dm *DocumentManager) storeKeyframeDescriptions(ctx context.Context, docID, docName, productID string, results []videoOCRResult, chunkBase, totalOCRFrames int) {
 	log.Printf("视频关键帧OCR+场景描述完成: doc=%s, %d/%d 帧提取到内容", docID, len(results), totalOCRFrames)
 
 	var sb strings.Builder
@@ -545,7 +654,11 @@ func (dm *DocumentManager) storeKeyframeDescriptions(docID, docName, productID s
 	for i, c := range ocrChunks {
 		ocrTexts[i] = c.Text
 	}
-	ocrEmbeddings, embErr := dm.embeddingService.EmbedBatch(ocrTexts)
+	ocrTexts, modErr := dm.moderate(docID, docName, productID, ocrTexts)
+	if modErr != nil {
+		return
+	}
+	ocrEmbeddings, embErr := dm.embeddingService.EmbedBatch(ctx, ocrTexts)
 	if embErr != nil {
 		log.Printf("Warning: OCR text embedding failed for doc=%s: %v", docID, embErr)
 		errlog.Logf("[Video OCR] embedding failed for doc=%s: %v", docID, embErr)
@@ -553,9 +666,9 @@ func (dm *DocumentManager) storeKeyframeDescriptions(docID, docName, productID s
 	}
 
 	ocrVectorChunks := make([]vectorstore.VectorChunk, len(ocrChunks))
-	for i, c := range ocrChunks {
+	for i := range ocrChunks {
 		ocrVectorChunks[i] = vectorstore.VectorChunk{
-			ChunkText:    c.Text,
+			ChunkText:    ocrTexts[i],
 			ChunkIndex:   chunkBase + i,
 			DocumentID:   docID,
 			DocumentName: docName,
@@ -0,0 +1,285 @@
+package document
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"askflow/internal/blob"
+	"askflow/internal/db"
+	"askflow/internal/video"
+	"askflow/internal/videoworker"
+)
+
+// Statuses of a video worker job.
+const (
+	videoJobQueued   = "queued"
+	videoJobRunning  = "running"
+	videoJobDone     = "done"
+	videoJobFailed   = "failed"
+	videoJobCanceled = "canceled"
+)
+
+// videoStepQueued is the parse step reported while a video waits for a worker.
+const videoStepQueued = "queued"
+
+// videoJobPollInterval is how often a video waiting for a worker checks its job.
+const videoJobPollInterval = 2 * time.Second
+
+// videoJobRetention is how long jobs nobody collected, such as those of an
+// instance that died while waiting, are kept before they are purged.
+const videoJobRetention = 24 * time.Hour
+
+// videoJobFrame is a keyframe of a stored job result.
+type videoJobFrame struct {
+	Timestamp float64 `json:"timestamp"`
+	Data      []byte  `json:"data"`
+}
+
+// videoJobResult is a job result as stored until the waiting instance
+// collects it.
+type videoJobResult struct {
+	Duration   float64                   `json:"duration"`
+	Transcript []video.TranscriptSegment `json:"transcript"`
+	Keyframes  []videoJobFrame           `json:"keyframes"`
+}
+
+// videoJobKey returns the storage key of the result of job id.
+func videoJobKey(id string) string {
+	return "video-jobs/" + id + ".json"
+}
+
+// jobTime formats t in the fixed-width form stored in video_worker_jobs,
+// which compares correctly as text.
+func jobTime(t time.Time) string {
+	return t.UTC().Truncate(time.Second).Format(time.RFC3339)
+}
+
+// parseVideoRemote queues the video of a document for a remote worker and
+// waits for the result, reporting the worker's progress as the parse stage.
+// With skipTranscript the worker only extracts keyframes. Once ctx is done
+// the job is canceled, which stops the worker at its next report.
+func (dm *DocumentManager) parseVideoRemote(ctx context.Context, docID, docName string, skipTranscript bool) (*video.ParseResult, error) {
+	dm.purgeVideoJobs()
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	_, err = dm.db.Exec(`INSERT INTO video_worker_jobs (id, document_id, file_name, skip_transcript, status, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		id, docID, docName, skipTranscript, videoJobQueued, jobTime(time.Now()))
+	if err != nil {
+		return nil, fmt.Errorf("创建视频处理任务失败: %w", err)
+	}
+	defer dm.dropVideoJob(id)
+	log.Printf("[Video] doc=%s queued for a video worker as job %s", docID, id)
+	dm.reportStageProgress(docID, StageParse, videoStepQueued, 0, 0, 0)
+
+	ticker := time.NewTicker(videoJobPollInterval)
+	defer ticker.Stop()
+	lastWorker := ""
+	for {
+		select {
+		case <-ctx.Done():
+			dm.db.Exec(`UPDATE video_worker_jobs SET status = ?, finished_at = ? WHERE id = ? AND status IN (?, ?)`,
+				videoJobCanceled, jobTime(time.Now()), id, videoJobQueued, videoJobRunning)
+			return nil, context.Cause(ctx)
+		case <-ticker.C:
+		}
+		var status, worker, step, errMsg, expires string
+		var done, total, attempts int
+		err := dm.db.QueryRow(`SELECT status, worker, step, step_done, step_total, error, expires_at, attempts FROM video_worker_jobs WHERE id = ?`, id).
+			Scan(&status, &worker, &step, &done, &total, &errMsg, &expires, &attempts)
+		if err != nil {
+			return nil, fmt.Errorf("查询视频处理任务失败: %w", err)
+		}
+		switch status {
+		case videoJobDone:
+			log.Printf("[Video] doc=%s parsed by video worker %s", docID, worker)
+			return dm.loadVideoJobResult(id)
+		case videoJobFailed:
+			return nil, fmt.Errorf("视频处理节点 %s: %s", worker, errMsg)
+		case videoJobCanceled:
+			return nil, errors.New("视频处理任务已取消")
+		case videoJobRunning:
+			if worker != lastWorker {
+				log.Printf("[Video] doc=%s job %s claimed by video worker %s (attempt %d)", docID, id, worker, attempts)
+				lastWorker = worker
+			}
+			// A claim that expired after the last attempt is not handed out again
+			if expires <= jobTime(time.Now()) && attempts >= videoworker.MaxAttempts {
+				return nil, fmt.Errorf("视频处理节点 %s 失去响应", worker)
+			}
+			r, ok := videoParseSteps[step]
+			if !ok {
+				continue
+			}
+			finished := r[0]
+			if total > 0 && done <= total {
+				finished += (r[1] - r[0]) * float64(done) / float64(total)
+			}
+			dm.reportStageProgress(docID, StageParse, step, done, total, finished)
+		}
+	}
+}
+
+// loadVideoJobResult reads the stored result of job id.
+func (dm *DocumentManager) loadVideoJobResult(id string) (*video.ParseResult, error) {
+	data, err := dm.Storage().Get(videoJobKey(id))
+	if err != nil {
+		return nil, fmt.Errorf("读取视频处理结果失败: %w", err)
+	}
+	var stored videoJobResult
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("读取视频处理结果失败: %w", err)
+	}
+	res := &video.ParseResult{Duration: stored.Duration, Transcript: stored.Transcript}
+	for _, f := range stored.Keyframes {
+		res.Keyframes = append(res.Keyframes, video.Keyframe{Timestamp: f.Timestamp, Data: f.Data})
+	}
+	return res, nil
+}
+
+// dropVideoJob removes a job and its stored result once its video is done
+// with, whatever the outcome.
+func (dm *DocumentManager) dropVideoJob(id string) {
+	if err := dm.Storage().Delete(videoJobKey(id)); err != nil {
+		log.Printf("Warning: failed to delete result of video job %s: %v", id, err)
+	}
+	if _, err := dm.db.Exec(`DELETE FROM video_worker_jobs WHERE id = ?`, id); err != nil {
+		log.Printf("Warning: failed to delete video job %s: %v", id, err)
+	}
+}
+
+// purgeVideoJobs removes jobs older than videoJobRetention.
+func (dm *DocumentManager) purgeVideoJobs() {
+	rows, err := dm.db.Query(`SELECT id FROM video_worker_jobs WHERE created_at < ?`, jobTime(time.Now().Add(-videoJobRetention)))
+	if err != nil {
+		log.Printf("Warning: failed to list stale video jobs: %v", err)
+		return
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	for _, id := range ids {
+		dm.dropVideoJob(id)
+	}
+}
+
+// ClaimVideoJob hands the oldest queued job to worker, or a running job whose
+// worker stopped reporting. It returns nil when there is nothing to do.
+func (dm *DocumentManager) ClaimVideoJob(worker string) (*videoworker.Job, error) {
+	dm.mu.RLock()
+	cfg := dm.videoConfig
+	dm.mu.RUnlock()
+	// Another worker may claim the same job first; try the next one then
+	for tries := 0; tries < 5; tries++ {
+		now := jobTime(time.Now())
+		job := &videoworker.Job{Settings: videoworker.NewSettings(cfg)}
+		err := dm.db.QueryRow(`SELECT id, document_id, file_name, skip_transcript FROM video_worker_jobs
+			WHERE status = ? OR (status = ? AND expires_at <= ? AND attempts < ?)
+			ORDER BY created_at LIMIT 1`, videoJobQueued, videoJobRunning, now, videoworker.MaxAttempts).
+			Scan(&job.ID, &job.DocumentID, &job.FileName, &job.SkipTranscript)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var res sql.Result
+		err = db.RetryBusy(func() (err error) {
+			res, err = dm.db.Exec(`UPDATE video_worker_jobs SET status = ?, worker = ?, attempts = attempts + 1,
+				step = '', step_done = 0, step_total = 0, expires_at = ?
+				WHERE id = ? AND (status = ? OR (status = ? AND expires_at <= ? AND attempts < ?))`,
+				videoJobRunning, worker, jobTime(time.Now().Add(videoworker.ClaimTTL)),
+				job.ID, videoJobQueued, videoJobRunning, now, videoworker.MaxAttempts)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			return job, nil
+		}
+	}
+	return nil, nil
+}
+
+// renewVideoJob pushes the claim of a running job held by worker forward,
+// setting the given extra columns, or returns videoworker.ErrJobLost.
+func (dm *DocumentManager) renewVideoJob(id, worker, set string, args ...interface{}) error {
+	args = append([]interface{}{jobTime(time.Now().Add(videoworker.ClaimTTL))}, args...)
+	args = append(args, id, videoJobRunning, worker)
+	var res sql.Result
+	err := db.RetryBusy(func() (err error) {
+		res, err = dm.db.Exec(`UPDATE video_worker_jobs SET expires_at = ?`+set+` WHERE id = ? AND status = ? AND worker = ?`, args...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return videoworker.ErrJobLost
+	}
+	return nil
+}
+
+// VideoJobOriginal returns the video of a running job held by worker.
+func (dm *DocumentManager) VideoJobOriginal(id, worker string) (*Original, error) {
+	var docID string
+	err := dm.db.QueryRow(`SELECT document_id FROM video_worker_jobs WHERE id = ? AND status = ? AND worker = ?`,
+		id, videoJobRunning, worker).Scan(&docID)
+	if err == sql.ErrNoRows {
+		return nil, videoworker.ErrJobLost
+	}
+	if err != nil {
+		return nil, err
+	}
+	return dm.GetOriginal(docID)
+}
+
+// ReportVideoJob records the progress of a running job held by worker.
+func (dm *DocumentManager) ReportVideoJob(id, worker string, p videoworker.Progress) error {
+	return dm.renewVideoJob(id, worker, `, step = ?, step_done = ?, step_total = ?`, p.Step, p.Done, p.Total)
+}
+
+// CompleteVideoJob stores the result of a running job held by worker for
+// the instance waiting for it.
+func (dm *DocumentManager) CompleteVideoJob(id, worker string, res *video.ParseResult) error {
+	// Check the claim before storing a result nobody would collect
+	if err := dm.renewVideoJob(id, worker, ``); err != nil {
+		return err
+	}
+	stored := videoJobResult{Duration: res.Duration, Transcript: res.Transcript}
+	for _, kf := range res.Keyframes {
+		stored.Keyframes = append(stored.Keyframes, videoJobFrame{Timestamp: kf.Timestamp, Data: kf.Data})
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	if !blob.ValidKey(videoJobKey(id)) {
+		return fmt.Errorf("invalid job ID")
+	}
+	if err := dm.Storage().Put(videoJobKey(id), data); err != nil {
+		return fmt.Errorf("保存视频处理结果失败: %w", err)
+	}
+	if err := dm.renewVideoJob(id, worker, `, status = ?, finished_at = ?`, videoJobDone, jobTime(time.Now())); err != nil {
+		dm.Storage().Delete(videoJobKey(id))
+		return err
+	}
+	return nil
+}
+
+// FailVideoJob records that worker could not parse the video of a job.
+func (dm *DocumentManager) FailVideoJob(id, worker, msg string) error {
+	return dm.renewVideoJob(id, worker, `, status = ?, error = ?, finished_at = ?`, videoJobFailed, msg, jobTime(time.Now()))
+}
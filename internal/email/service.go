@@ -137,6 +137,98 @@ func (s *Service) SendPasswordReset(toEmail, userName, resetURL string) error {
 	return s.send(cfg, fromAddr, toEmail, msg)
 }
 
+// SendAccountLocked tells the user that their account was locked after too
+// many failed logins and offers a link to unlock it.
+func (s *Service) SendAccountLocked(toEmail, userName string, unlocksAt time.Time, unlockURL string) error {
+	cfg := s.cfg()
+	if cfg.Host == "" {
+		return fmt.Errorf("SMTP 服务器未配置")
+	}
+
+	fromName := cfg.FromName
+	if fromName == "" {
+		fromName = "软件自助服务平台"
+	}
+	fromAddr := cfg.FromAddr
+	if fromAddr == "" {
+		fromAddr = cfg.Username
+	}
+
+	subject := "您的账号已被临时锁定"
+	body := fmt.Sprintf(
+		"您好 %s，\r\n\r\n"+
+			"由于多次密码错误，您的账号已被临时锁定，将于 %s 自动解锁。\r\n\r\n"+
+			"如果是您本人操作，可点击以下链接立即解锁：\r\n%s\r\n\r\n"+
+			"如果不是您本人操作，说明有人正在尝试登录您的账号，请不要点击该链接，并建议您尽快重置密码。",
+		userName, unlocksAt.Local().Format("2006-01-02 15:04"), unlockURL,
+	)
+
+	msg := buildMessage(fromName, fromAddr, toEmail, subject, body)
+	return s.send(cfg, fromAddr, toEmail, msg)
+}
+
+// SendLoginAlert tells a user that their account was signed in to from an
+// address or country it was not used from before. place is the address,
+// followed by its country when known.
+func (s *Service) SendLoginAlert(toEmail, userName, place string, at time.Time) error {
+	cfg := s.cfg()
+	if cfg.Host == "" {
+		return fmt.Errorf("SMTP 服务器未配置")
+	}
+
+	fromName := cfg.FromName
+	if fromName == "" {
+		fromName = "软件自助服务平台"
+	}
+	fromAddr := cfg.FromAddr
+	if fromAddr == "" {
+		fromAddr = cfg.Username
+	}
+
+	subject := "您的账号在新的位置登录"
+	body := fmt.Sprintf(
+		"您好 %s，\r\n\r\n"+
+			"您的账号于 %s 在一个新的位置登录：%s。\r\n\r\n"+
+			"如果是您本人操作，请忽略此邮件。\r\n\r\n"+
+			"如果不是您本人操作，请立即修改密码，并在账号的登录设备列表中注销陌生设备。",
+		userName, at.Local().Format("2006-01-02 15:04"), place,
+	)
+
+	msg := buildMessage(fromName, fromAddr, toEmail, subject, body)
+	return s.send(cfg, fromAddr, toEmail, msg)
+}
+
+// SendAdminInvite sends an admin invitation link that lets the invitee set
+// a password.
+func (s *Service) SendAdminInvite(toEmail, userName, inviteURL string) error {
+	cfg := s.cfg()
+	if cfg.Host == "" {
+		return fmt.Errorf("SMTP 服务器未配置")
+	}
+
+	fromName := cfg.FromName
+	if fromName == "" {
+		fromName = "软件自助服务平台"
+	}
+	fromAddr := cfg.FromAddr
+	if fromAddr == "" {
+		fromAddr = cfg.Username
+	}
+
+	subject := "您被邀请成为管理员"
+	body := fmt.Sprintf(
+		"您好，\r\n\r\n"+
+			"您已被邀请成为软件自助服务平台的管理员，用户名为 %s。\r\n\r\n"+
+			"请点击以下链接设置登录密码：\r\n%s\r\n\r\n"+
+			"该链接72小时内有效，设置密码后即失效。\r\n\r\n"+
+			"如果您不知道此邀请，请忽略此邮件。",
+		userName, inviteURL,
+	)
+
+	msg := buildMessage(fromName, fromAddr, toEmail, subject, body)
+	return s.send(cfg, fromAddr, toEmail, msg)
+}
+
 // SendTest sends a test email to verify SMTP configuration.
 func (s *Service) SendTest(toEmail string) error {
 	cfg := s.cfg()
@@ -160,6 +252,28 @@ func (s *Service) SendTest(toEmail string) error {
 	return s.send(cfg, fromAddr, toEmail, msg)
 }
 
+// SendReport sends a plain-text report, such as the weekly knowledge gap
+// report, to an admin.
+func (s *Service) SendReport(toEmail, subject, body string) error {
+	cfg := s.cfg()
+	if cfg.Host == "" {
+		return fmt.Errorf("SMTP 服务器未配置")
+	}
+
+	fromName := cfg.FromName
+	if fromName == "" {
+		fromName = "软件自助服务平台"
+	}
+	fromAddr := cfg.FromAddr
+	if fromAddr == "" {
+		fromAddr = cfg.Username
+	}
+
+	body = strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n")
+	msg := buildMessage(fromName, fromAddr, toEmail, subject, body)
+	return s.send(cfg, fromAddr, toEmail, msg)
+}
+
 func buildMessage(fromName, fromAddr, to, subject, body string) []byte {
 	// Sanitize headers to prevent email header injection
 	sanitize := func(s string) string {
@@ -185,21 +299,57 @@ func buildMessage(fromName, fromAddr, to, subject, body string) []byte {
 }
 
 func (s *Service) send(cfg config.SMTPConfig, from, to string, msg []byte) error {
-	addr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+	conn, client, err := s.connect(cfg)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer client.Close()
 
-	conn, client, err := s.dialSMTP(cfg, addr)
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("发送邮件失败: %w", err)
+	}
+	if err := client.Rcpt(to); err != nil {
+		return fmt.Errorf("发送邮件失败: %w", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("发送邮件失败: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("发送邮件失败: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("发送邮件失败: %w", err)
+	}
+	return client.Quit()
+}
+
+// Verify connects to the SMTP server and authenticates without sending any
+// mail, to check the configuration before it is saved.
+func (s *Service) Verify() error {
+	cfg := s.cfg()
+	if cfg.Host == "" {
+		return fmt.Errorf("SMTP 服务器未配置")
+	}
+	conn, client, err := s.connect(cfg)
 	if err != nil {
 		return err
 	}
-	// Use a cleanup function that closes the current conn/client,
-	// since they may be reassigned during auto-auth fallback.
-	cleanup := func() {
-		client.Close()
-		conn.Close()
+	defer conn.Close()
+	defer client.Close()
+	return client.Quit()
+}
+
+// connect dials the SMTP server and authenticates with the configured method.
+func (s *Service) connect(cfg config.SMTPConfig) (net.Conn, *smtp.Client, error) {
+	addr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+
+	conn, client, err := s.dialSMTP(cfg, addr)
+	if err != nil {
+		return nil, nil, err
 	}
-	defer func() { cleanup() }()
 
-	// Auth
 	var authMech smtp.Auth
 	method := strings.ToUpper(strings.TrimSpace(cfg.AuthMethod))
 	switch method {
@@ -213,55 +363,32 @@ func (s *Service) send(cfg config.SMTPConfig, from, to string, msg []byte) error
 	default:
 		// Auto mode: try PLAIN first, fall back to LOGIN on failure
 		plainAuth := newUnrestrictedPlainAuth("", cfg.Username, cfg.Password, cfg.Host)
-		if err := client.Auth(plainAuth); err != nil {
-			// PLAIN failed, close current connection and reconnect for LOGIN
+		if err := client.Auth(plainAuth); err == nil {
+			return conn, client, nil
+		}
+		// PLAIN failed, close current connection and reconnect for LOGIN
+		client.Close()
+		conn.Close()
+
+		conn, client, err = s.dialSMTP(cfg, addr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("重连邮件服务器失败: %w", err)
+		}
+		if err := client.Auth(newLoginAuth(cfg.Username, cfg.Password)); err != nil {
 			client.Close()
 			conn.Close()
-
-			conn2, client2, dialErr := s.dialSMTP(cfg, addr)
-			if dialErr != nil {
-				// Update cleanup to no-op since we already closed the originals
-				cleanup = func() {}
-				return fmt.Errorf("重连邮件服务器失败: %w", dialErr)
-			}
-			// Replace conn/client so the deferred cleanup closes the new ones
-			conn = conn2
-			client = client2
-
-			loginAuth := newLoginAuth(cfg.Username, cfg.Password)
-			if err := client.Auth(loginAuth); err != nil {
-				return fmt.Errorf("邮件认证失败 (PLAIN和LOGIN均失败): %w", err)
-			}
+			return nil, nil, fmt.Errorf("邮件认证失败 (PLAIN和LOGIN均失败): %w", err)
 		}
-		// Auth succeeded in auto mode, skip the auth block below
-		goto sendMail
+		return conn, client, nil
 	}
 	if authMech != nil {
 		if err := client.Auth(authMech); err != nil {
-			return fmt.Errorf("邮件认证失败 (auth=%s): %w", method, err)
+			client.Close()
+			conn.Close()
+			return nil, nil, fmt.Errorf("邮件认证失败 (auth=%s): %w", method, err)
 		}
 	}
-sendMail:
-	if err := client.Mail(from); err != nil {
-		return fmt.Errorf("发送邮件失败: %w", err)
-	}
-	if err := client.Rcpt(to); err != nil {
-		return fmt.Errorf("发送邮件失败: %w", err)
-	}
-	w, err := client.Data()
-	if err != nil {
-		return fmt.Errorf("发送邮件失败: %w", err)
-	}
-	if _, err := w.Write(msg); err != nil {
-		return fmt.Errorf("发送邮件失败: %w", err)
-	}
-	if err := w.Close(); err != nil {
-		return fmt.Errorf("发送邮件失败: %w", err)
-	}
-	// Quit handles closing the client, so prevent double-close in cleanup
-	err = client.Quit()
-	cleanup = func() { conn.Close() }
-	return err
+	return conn, client, nil
 }
 
 // dialSMTP establishes a connection and creates an SMTP client, handling TLS/STARTTLS.
@@ -0,0 +1,249 @@
+package embedding
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned when an embedding request cannot be queued
+// because too many requests are already waiting.
+var ErrQueueFull = errors.New("向量化队列已满，请稍后重试")
+
+// Default pool limits, used until Resize is called.
+const defaultQueueLimit = 32
+
+// Pool limits how many embedding requests run at once across all services
+// sharing it. Requests that have to wait are queued per key (the document
+// being imported, see WithKey) and the keys take turns, so that a large
+// document cannot hold up other documents or user questions, which share
+// the unkeyed lane. At most the queue limit of requests wait at a time;
+// beyond it requests of keys with nothing queued or running are rejected
+// with ErrQueueFull, while work already under way keeps its place.
+type Pool struct {
+	mu      sync.Mutex
+	workers int
+	limit   int
+	running int
+	waiting int
+	lanes   map[string]*lane
+	ring    []string // keys with waiting requests, in the order they are served
+
+	served    uint64
+	rejected  uint64
+	waitTotal time.Duration
+	waitMax   time.Duration
+}
+
+// lane holds the requests of one key.
+type lane struct {
+	waiters []*waiter
+	running int
+}
+
+type waiter struct {
+	ready  chan struct{}
+	queued time.Time
+}
+
+// PoolStats is a snapshot of a Pool.
+type PoolStats struct {
+	Workers    int `json:"workers"`
+	Running    int `json:"running"`
+	Queued     int `json:"queued"`
+	QueueLimit int `json:"queue_limit"`
+	// Lanes counts the keys with requests queued or running.
+	Lanes    int    `json:"lanes"`
+	Served   uint64 `json:"served"`
+	Rejected uint64 `json:"rejected"`
+	// AvgWaitMs and MaxWaitMs cover the requests that had to queue.
+	AvgWaitMs float64 `json:"avg_wait_ms"`
+	MaxWaitMs int64   `json:"max_wait_ms"`
+}
+
+// NewPool returns a Pool running up to workers requests at once with up to
+// queueLimit waiting. Values below 1 use the defaults.
+func NewPool(workers, queueLimit int) *Pool {
+	p := &Pool{workers: defaultMaxConcurrency, limit: defaultQueueLimit, lanes: make(map[string]*lane)}
+	p.Resize(workers, queueLimit)
+	return p
+}
+
+// Resize changes the limits of the pool. Values below 1 keep the current
+// ones. Running requests are not interrupted when workers shrinks.
+func (p *Pool) Resize(workers, queueLimit int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if workers > 0 {
+		p.workers = workers
+	}
+	if queueLimit > 0 {
+		p.limit = queueLimit
+	}
+	p.dispatch()
+}
+
+// Busy reports whether the queue is full, so that callers can turn away new
+// work before starting it.
+func (p *Pool) Busy() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.waiting >= p.limit
+}
+
+// Stats returns the current state and counters of the pool.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := PoolStats{
+		Workers:    p.workers,
+		Running:    p.running,
+		Queued:     p.waiting,
+		QueueLimit: p.limit,
+		Lanes:      len(p.lanes),
+		Served:     p.served,
+		Rejected:   p.rejected,
+		MaxWaitMs:  p.waitMax.Milliseconds(),
+	}
+	if p.served > 0 {
+		s.AvgWaitMs = float64(p.waitTotal.Milliseconds()) / float64(p.served)
+	}
+	return s
+}
+
+// acquire waits for a free worker for the key of ctx. release must be
+// called when the request is done.
+func (p *Pool) acquire(ctx context.Context) (release func(), err error) {
+	key := keyFrom(ctx)
+	p.mu.Lock()
+	l := p.lane(key)
+	if p.running < p.workers && p.waiting == 0 {
+		p.start(l)
+		p.served++
+		p.mu.Unlock()
+		return func() { p.release(key) }, nil
+	}
+	if p.waiting >= p.limit && len(l.waiters) == 0 && l.running == 0 {
+		p.rejected++
+		delete(p.lanes, key)
+		p.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+	w := &waiter{ready: make(chan struct{}), queued: time.Now()}
+	if len(l.waiters) == 0 {
+		p.ring = append(p.ring, key)
+	}
+	l.waiters = append(l.waiters, w)
+	p.waiting++
+	p.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return func() { p.release(key) }, nil
+	case <-ctx.Done():
+	}
+	p.mu.Lock()
+	select {
+	case <-w.ready:
+		// Handed a worker while giving up; pass it on
+		p.mu.Unlock()
+		p.release(key)
+		return nil, ctx.Err()
+	default:
+	}
+	for i, o := range l.waiters {
+		if o == w {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			break
+		}
+	}
+	p.waiting--
+	if len(l.waiters) == 0 {
+		p.dropFromRing(key)
+		if l.running == 0 {
+			delete(p.lanes, key)
+		}
+	}
+	p.mu.Unlock()
+	return nil, ctx.Err()
+}
+
+// release frees the worker of a request of key and hands it to the next
+// waiting request.
+func (p *Pool) release(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running--
+	if l := p.lanes[key]; l != nil {
+		l.running--
+		if l.running == 0 && len(l.waiters) == 0 {
+			delete(p.lanes, key)
+		}
+	}
+	p.dispatch()
+}
+
+// dispatch starts waiting requests while workers are free, taking one from
+// each key in turn. p.mu must be held.
+func (p *Pool) dispatch() {
+	for p.running < p.workers && len(p.ring) > 0 {
+		key := p.ring[0]
+		p.ring = p.ring[1:]
+		l := p.lanes[key]
+		w := l.waiters[0]
+		l.waiters = l.waiters[1:]
+		p.waiting--
+		if len(l.waiters) > 0 {
+			p.ring = append(p.ring, key)
+		}
+		p.start(l)
+		p.served++
+		wait := time.Since(w.queued)
+		p.waitTotal += wait
+		if wait > p.waitMax {
+			p.waitMax = wait
+		}
+		close(w.ready)
+	}
+}
+
+// start counts a request of l as running. p.mu must be held.
+func (p *Pool) start(l *lane) {
+	l.running++
+	p.running++
+}
+
+// lane returns the lane of key, creating it if needed. p.mu must be held.
+func (p *Pool) lane(key string) *lane {
+	l := p.lanes[key]
+	if l == nil {
+		l = &lane{}
+		p.lanes[key] = l
+	}
+	return l
+}
+
+// dropFromRing removes key from the service order. p.mu must be held.
+func (p *Pool) dropFromRing(key string) {
+	for i, k := range p.ring {
+		if k == key {
+			p.ring = append(p.ring[:i], p.ring[i+1:]...)
+			return
+		}
+	}
+}
+
+type keyContextKey struct{}
+
+// WithKey returns a context whose embedding requests queue under key, e.g.
+// the ID of the document being imported. Requests without a key share one
+// lane.
+func WithKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, keyContextKey{}, key)
+}
+
+func keyFrom(ctx context.Context) string {
+	key, _ := ctx.Value(keyContextKey{}).(string)
+	return key
+}
@@ -4,24 +4,35 @@ package embedding
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"askflow/internal/errlog"
 )
 
 // EmbeddingService defines the interface for text and image embedding operations.
+// Calls return ctx's error once ctx is done, abandoning requests in flight.
 type EmbeddingService interface {
-	Embed(text string) ([]float64, error)
-	EmbedBatch(texts []string) ([][]float64, error)
-	EmbedImageURL(imageURL string) ([]float64, error)
+	Embed(ctx context.Context, text string) ([]float64, error)
+	EmbedBatch(ctx context.Context, texts []string) ([][]float64, error)
+	EmbedImageURL(ctx context.Context, imageURL string) ([]float64, error)
 }
 
+// Default limits, used until SetBatchLimits and SetPool are called.
+const (
+	defaultBatchMaxItems  = 64
+	defaultBatchMaxTokens = 8000
+	defaultMaxConcurrency = 2
+)
+
 // APIEmbeddingService implements EmbeddingService using an OpenAI-compatible API.
 type APIEmbeddingService struct {
 	Endpoint      string
@@ -30,6 +41,11 @@ type APIEmbeddingService struct {
 	UseMultimodal bool
 	client        *http.Client
 	mmClient      *http.Client // longer timeout for multimodal (image) requests
+
+	batchMaxItems  int
+	batchMaxTokens int
+	pool           *Pool         // limits concurrent requests, possibly shared
+	pacer          pacer         // pauses all requests after a 429
 }
 
 // NewAPIEmbeddingService creates a new APIEmbeddingService with the given configuration.
@@ -49,7 +65,33 @@ func NewAPIEmbeddingService(endpoint, apiKey, modelName string, useMultimodal bo
 		mmClient: &http.Client{
 			Timeout: 120 * time.Second,
 		},
+		batchMaxItems:  defaultBatchMaxItems,
+		batchMaxTokens: defaultBatchMaxTokens,
+		pool:           NewPool(defaultMaxConcurrency, defaultQueueLimit),
+	}
+}
+
+// SetBatchLimits sets how EmbedBatch splits its input: at most maxItems
+// texts and maxTokens estimated tokens per request. Values below 1 keep the
+// defaults. It must be called before the service is used.
+func (s *APIEmbeddingService) SetBatchLimits(maxItems, maxTokens int) *APIEmbeddingService {
+	if maxItems > 0 {
+		s.batchMaxItems = maxItems
+	}
+	if maxTokens > 0 {
+		s.batchMaxTokens = maxTokens
+	}
+	return s
+}
+
+// SetPool makes the service run its requests through p, so that services
+// sharing p share its limits. Each service otherwise has a small pool of its
+// own. It must be called before the service is used.
+func (s *APIEmbeddingService) SetPool(p *Pool) *APIEmbeddingService {
+	if p != nil {
+		s.pool = p
 	}
+	return s
 }
 
 // --- Standard (OpenAI-compatible) types ---
@@ -61,9 +103,15 @@ type embeddingRequest struct {
 
 type embeddingResponse struct {
 	Data  []embeddingData `json:"data"`
+	Usage *Usage          `json:"usage,omitempty"`
 	Error *apiError       `json:"error,omitempty"`
 }
 
+// Usage is the token consumption reported by the embedding API.
+type Usage struct {
+	PromptTokens int `json:"prompt_tokens"`
+}
+
 type embeddingData struct {
 	Embedding []float64 `json:"embedding"`
 	Index     int       `json:"index"`
@@ -93,6 +141,7 @@ type multimodalRequest struct {
 
 type multimodalResponse struct {
 	Data  multimodalData `json:"data"`
+	Usage *Usage         `json:"usage,omitempty"`
 	Error *apiError      `json:"error,omitempty"`
 }
 
@@ -101,14 +150,23 @@ type multimodalData struct {
 }
 
 // Embed converts a single text string into an embedding vector.
-func (s *APIEmbeddingService) Embed(text string) ([]float64, error) {
+func (s *APIEmbeddingService) Embed(ctx context.Context, text string) ([]float64, error) {
+	return s.embed(ctx, text, nil)
+}
+
+func (s *APIEmbeddingService) embed(ctx context.Context, text string, record func(Usage)) ([]float64, error) {
 	if s.Endpoint == "" {
 		return nil, fmt.Errorf("embedding API endpoint not configured")
 	}
+	release, err := s.pool.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 	if s.UseMultimodal {
-		return s.embedMultimodal(text)
+		return s.embedMultimodal(ctx, text, record)
 	}
-	results, err := s.callAPI(text)
+	results, err := s.callAPI(ctx, text, record)
 	if err != nil {
 		return nil, err
 	}
@@ -118,42 +176,154 @@ func (s *APIEmbeddingService) Embed(text string) ([]float64, error) {
 	return results[0].Embedding, nil
 }
 
-// EmbedBatch converts multiple text strings into embedding vectors.
-func (s *APIEmbeddingService) EmbedBatch(texts []string) ([][]float64, error) {
+// EmbedBatch converts multiple text strings into embedding vectors. Large
+// inputs are split into several requests according to the batch limits.
+func (s *APIEmbeddingService) EmbedBatch(ctx context.Context, texts []string) ([][]float64, error) {
+	return s.embedBatch(ctx, texts, nil)
+}
+
+func (s *APIEmbeddingService) embedBatch(ctx context.Context, texts []string, record func(Usage)) ([][]float64, error) {
 	if len(texts) == 0 {
 		return nil, nil
 	}
 	if s.Endpoint == "" {
 		return nil, fmt.Errorf("embedding API endpoint not configured")
 	}
-	// Limit batch size to prevent excessive API payload
-	const maxBatchSize = 256
-	if len(texts) > maxBatchSize {
-		return nil, fmt.Errorf("batch size %d exceeds maximum of %d", len(texts), maxBatchSize)
-	}
+	embeddings := make([][]float64, len(texts))
 	if s.UseMultimodal {
-		return s.embedBatchMultimodal(texts)
+		// The multimodal endpoint embeds one input per request
+		batches := make([]batchRange, len(texts))
+		for i := range texts {
+			batches[i] = batchRange{start: i, end: i + 1}
+		}
+		err := s.forEachBatch(ctx, batches, func(b batchRange) error {
+			vec, err := s.embedMultimodal(ctx, texts[b.start], record)
+			if err != nil {
+				return fmt.Errorf("embed text[%d]: %w", b.start, err)
+			}
+			embeddings[b.start] = vec
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return embeddings, nil
+	}
+
+	batches := splitBatches(texts, s.batchMaxItems, s.batchMaxTokens)
+	if len(batches) > 1 {
+		log.Printf("[Embed] embedding %d texts in %d requests", len(texts), len(batches))
 	}
-	results, err := s.callAPI(texts)
+	err := s.forEachBatch(ctx, batches, func(b batchRange) error {
+		part := texts[b.start:b.end]
+		results, err := s.callAPI(ctx, part, record)
+		if err != nil {
+			if len(batches) > 1 {
+				return fmt.Errorf("embed texts[%d:%d]: %w", b.start, b.end, err)
+			}
+			return err
+		}
+		if len(results) != len(part) {
+			return fmt.Errorf("embedding API returned %d results, expected %d", len(results), len(part))
+		}
+		for _, d := range results {
+			if d.Index < 0 || d.Index >= len(part) {
+				return fmt.Errorf("embedding API returned invalid index %d", d.Index)
+			}
+			embeddings[b.start+d.Index] = d.Embedding
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	if len(results) != len(texts) {
-		return nil, fmt.Errorf("embedding API returned %d results, expected %d", len(results), len(texts))
+	return embeddings, nil
+}
+
+// batchRange is the half-open range [start, end) of texts sent in one request.
+type batchRange struct {
+	start, end int
+}
+
+// splitBatches packs consecutive texts into requests of at most maxItems
+// texts and maxTokens estimated tokens. A text larger than maxTokens on its
+// own is sent alone; the provider decides whether to truncate or reject it.
+func splitBatches(texts []string, maxItems, maxTokens int) []batchRange {
+	var batches []batchRange
+	start, tokens := 0, 0
+	for i, t := range texts {
+		n := EstimateTokens(t)
+		if i > start && (i-start >= maxItems || tokens+n > maxTokens) {
+			batches = append(batches, batchRange{start: start, end: i})
+			start, tokens = i, 0
+		}
+		tokens += n
 	}
-	embeddings := make([][]float64, len(texts))
-	for _, d := range results {
-		if d.Index < 0 || d.Index >= len(texts) {
-			return nil, fmt.Errorf("embedding API returned invalid index %d", d.Index)
+	return append(batches, batchRange{start: start, end: len(texts)})
+}
+
+// EstimateTokens approximates the token count of s without the provider's
+// tokenizer: one token per CJK character and one per four other characters.
+func EstimateTokens(s string) int {
+	cjk, other := 0, 0
+	for _, r := range s {
+		if r >= 0x2E80 {
+			cjk++
+		} else {
+			other++
 		}
-		embeddings[d.Index] = d.Embedding
 	}
-	return embeddings, nil
+	return cjk + (other+3)/4
+}
+
+// forEachBatch runs fn for every batch, each holding a worker of the pool.
+// After the first error, or once ctx is done or the pool rejects a batch, no
+// further batches are started; the error is returned once running batches
+// have finished.
+func (s *APIEmbeddingService) forEachBatch(ctx context.Context, batches []batchRange, fn func(batchRange) error) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for _, b := range batches {
+		release, err := s.pool.acquire(ctx)
+		mu.Lock()
+		if firstErr == nil && err != nil {
+			firstErr = err
+		}
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			if err == nil {
+				release()
+			}
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				release()
+				wg.Done()
+			}()
+			if err := fn(b); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
 }
 
 // --- Standard API call ---
 
-func (s *APIEmbeddingService) callAPI(input interface{}) ([]embeddingData, error) {
+// callAPI calls the embeddings endpoint; record, if non-nil, receives the
+// token usage of the successful call.
+func (s *APIEmbeddingService) callAPI(ctx context.Context, input interface{}, record func(Usage)) ([]embeddingData, error) {
 	reqBody := embeddingRequest{
 		Model: s.ModelName,
 		Input: input,
@@ -164,73 +334,166 @@ func (s *APIEmbeddingService) callAPI(input interface{}) ([]embeddingData, error
 	}
 
 	apiURL := strings.TrimRight(s.Endpoint, "/") + "/embeddings"
+	status, respBody, err := s.post(ctx, s.client, apiURL, bodyBytes, "text embedding")
+	if err != nil {
+		return nil, err
+	}
+
+	if status != http.StatusOK {
+		var errResp embeddingResponse
+		if json.Unmarshal(respBody, &errResp) == nil && errResp.Error != nil {
+			errlog.Logf("[Embed] text embedding API error (HTTP %d): %s", status, errResp.Error.Message)
+			return nil, fmt.Errorf("embedding API error (HTTP %d): %s", status, errResp.Error.Message)
+		}
+		errlog.Logf("[Embed] text embedding API error (HTTP %d): %s", status, string(respBody))
+		return nil, fmt.Errorf("embedding API error (HTTP %d): %s", status, string(respBody))
+	}
+
+	var result embeddingResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if result.Error != nil {
+		return nil, fmt.Errorf("embedding API error: %s", result.Error.Message)
+	}
+	if record != nil && result.Usage != nil {
+		record(*result.Usage)
+	}
+	return result.Data, nil
+}
+
+// Retry limits for post.
+const (
+	maxRetries          = 3 // attempts for network errors and 5xx responses
+	maxRateLimitRetries = 6 // attempts for 429 responses
+	maxRetryAfter       = 2 * time.Minute
+)
 
-	const maxRetries = 3
+// post sends body to apiURL and returns the status and body of the first
+// response that is not retried. Network errors and 5xx responses are retried
+// with a linear backoff. A 429 pauses every request of the service for the
+// provider's Retry-After, or an exponential backoff without one, so that
+// concurrent batches slow down together instead of repeating the 429. Waiting
+// and retrying stop once ctx is done.
+func (s *APIEmbeddingService) post(ctx context.Context, client *http.Client, apiURL string, body []byte, kind string) (int, []byte, error) {
 	var lastErr error
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		if attempt > 0 {
-			backoff := time.Duration(attempt) * 5 * time.Second
-			log.Printf("[Embed] text embedding retry %d/%d after %v", attempt+1, maxRetries, backoff)
-			time.Sleep(backoff)
+	failures, limited := 0, 0
+	for {
+		if err := s.pacer.wait(ctx); err != nil {
+			return 0, nil, err
 		}
 
-		req, err := http.NewRequest(http.MethodPost, apiURL, bytes.NewReader(bodyBytes))
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, bytes.NewReader(body))
 		if err != nil {
-			return nil, fmt.Errorf("failed to create request: %w", err)
+			return 0, nil, fmt.Errorf("failed to create request: %w", err)
 		}
 		req.Header.Set("Content-Type", "application/json")
 		if s.APIKey != "" {
 			req.Header.Set("Authorization", "Bearer "+s.APIKey)
 		}
 
-		resp, err := s.client.Do(req)
-		if err != nil {
-			lastErr = fmt.Errorf("embedding API request failed: %w", err)
-			continue
+		resp, err := client.Do(req)
+		if ctx.Err() != nil {
+			if err == nil {
+				resp.Body.Close()
+			}
+			return 0, nil, ctx.Err()
 		}
-
-		respBody, err := io.ReadAll(io.LimitReader(resp.Body, 50<<20)) // 50MB max response
-		resp.Body.Close()
 		if err != nil {
-			lastErr = fmt.Errorf("failed to read response body: %w", err)
-			continue
-		}
-
-		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
-			lastErr = fmt.Errorf("embedding API error (HTTP %d): %s", resp.StatusCode, string(respBody))
-			continue
-		}
-
-		if resp.StatusCode != http.StatusOK {
-			var errResp embeddingResponse
-			if json.Unmarshal(respBody, &errResp) == nil && errResp.Error != nil {
-				errlog.Logf("[Embed] text embedding API error (HTTP %d): %s", resp.StatusCode, errResp.Error.Message)
-				return nil, fmt.Errorf("embedding API error (HTTP %d): %s", resp.StatusCode, errResp.Error.Message)
+			lastErr = fmt.Errorf("%s API request failed: %w", kind, err)
+		} else {
+			respBody, err := io.ReadAll(io.LimitReader(resp.Body, 50<<20)) // 50MB max response
+			resp.Body.Close()
+			switch {
+			case err != nil:
+				lastErr = fmt.Errorf("failed to read response body: %w", err)
+			case resp.StatusCode == http.StatusTooManyRequests:
+				limited++
+				lastErr = fmt.Errorf("embedding API error (HTTP %d): %s", resp.StatusCode, string(respBody))
+				if limited >= maxRateLimitRetries {
+					errlog.Logf("[Embed] %s API still rate limited after %d attempts: %v", kind, limited, lastErr)
+					return 0, nil, lastErr
+				}
+				delay := retryAfter(resp.Header.Get("Retry-After"), limited)
+				log.Printf("[Embed] %s API rate limited, pausing requests for %v", kind, delay)
+				s.pacer.pause(delay)
+				continue
+			case resp.StatusCode >= 500:
+				lastErr = fmt.Errorf("embedding API error (HTTP %d): %s", resp.StatusCode, string(respBody))
+			default:
+				return resp.StatusCode, respBody, nil
 			}
-			errlog.Logf("[Embed] text embedding API error (HTTP %d): %s", resp.StatusCode, string(respBody))
-			return nil, fmt.Errorf("embedding API error (HTTP %d): %s", resp.StatusCode, string(respBody))
 		}
 
-		var result embeddingResponse
-		if err := json.Unmarshal(respBody, &result); err != nil {
-			return nil, fmt.Errorf("failed to decode response: %w", err)
+		failures++
+		if failures >= maxRetries {
+			errlog.Logf("[Embed] %s API failed after %d retries: %v", kind, maxRetries, lastErr)
+			return 0, nil, lastErr
 		}
-		if result.Error != nil {
-			return nil, fmt.Errorf("embedding API error: %s", result.Error.Message)
+		backoff := time.Duration(failures) * 5 * time.Second
+		log.Printf("[Embed] %s retry %d/%d after %v", kind, failures+1, maxRetries, backoff)
+		if err := sleep(ctx, backoff); err != nil {
+			return 0, nil, err
 		}
+	}
+}
 
-		return result.Data, nil
+// retryAfter returns how long to wait after the nth consecutive 429, from
+// the Retry-After header (seconds or HTTP date) when present.
+func retryAfter(header string, n int) time.Duration {
+	d := time.Duration(1<<min(n, 6)) * time.Second
+	if secs, err := strconv.Atoi(strings.TrimSpace(header)); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(header); err == nil {
+		d = time.Until(t)
 	}
+	return min(max(d, time.Second), maxRetryAfter)
+}
+
+// pacer holds back requests while the provider is rate limiting.
+type pacer struct {
+	mu    sync.Mutex
+	until time.Time
+}
 
-	errlog.Logf("[Embed] text embedding API failed after %d retries: %v", maxRetries, lastErr)
-	return nil, lastErr
+// wait blocks until any pause has passed, or returns ctx's error once ctx
+// is done.
+func (p *pacer) wait(ctx context.Context) error {
+	p.mu.Lock()
+	d := time.Until(p.until)
+	p.mu.Unlock()
+	return sleep(ctx, d)
+}
+
+// sleep pauses for d, returning early with ctx's error once ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pause holds back requests for at least d from now.
+func (p *pacer) pause(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t := time.Now().Add(d); t.After(p.until) {
+		p.until = t
+	}
 }
 
 // --- Multimodal API calls ---
 
-func (s *APIEmbeddingService) embedMultimodal(text string) ([]float64, error) {
+func (s *APIEmbeddingService) embedMultimodal(ctx context.Context, text string, record func(Usage)) ([]float64, error) {
 	input := []multimodalInputItem{{Type: "text", Text: text}}
-	vec, err := s.callMultimodalAPI(input)
+	vec, err := s.callMultimodalAPI(ctx, input, record)
 	if err != nil {
 		return nil, err
 	}
@@ -240,31 +503,28 @@ func (s *APIEmbeddingService) embedMultimodal(text string) ([]float64, error) {
 	return vec, nil
 }
 
-func (s *APIEmbeddingService) embedBatchMultimodal(texts []string) ([][]float64, error) {
-	embeddings := make([][]float64, len(texts))
-	for i, text := range texts {
-		vec, err := s.embedMultimodal(text)
-		if err != nil {
-			return nil, fmt.Errorf("embed text[%d]: %w", i, err)
-		}
-		embeddings[i] = vec
-	}
-	return embeddings, nil
+// EmbedImageURL embeds an image via its URL using the multimodal API.
+func (s *APIEmbeddingService) EmbedImageURL(ctx context.Context, imageURL string) ([]float64, error) {
+	return s.embedImageURL(ctx, imageURL, nil)
 }
 
-// EmbedImageURL embeds an image via its URL using the multimodal API.
-func (s *APIEmbeddingService) EmbedImageURL(imageURL string) ([]float64, error) {
+func (s *APIEmbeddingService) embedImageURL(ctx context.Context, imageURL string, record func(Usage)) ([]float64, error) {
 	if s.Endpoint == "" {
 		return nil, fmt.Errorf("embedding API endpoint not configured")
 	}
 	if !s.UseMultimodal {
 		return nil, fmt.Errorf("image embedding requires multimodal mode")
 	}
+	release, err := s.pool.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 	input := []multimodalInputItem{{
 		Type:     "image_url",
 		ImageURL: &multimodalImageURL{URL: imageURL},
 	}}
-	vec, err := s.callMultimodalAPI(input)
+	vec, err := s.callMultimodalAPI(ctx, input, record)
 	if err != nil {
 		return nil, err
 	}
@@ -274,7 +534,7 @@ func (s *APIEmbeddingService) EmbedImageURL(imageURL string) ([]float64, error)
 	return vec, nil
 }
 
-func (s *APIEmbeddingService) callMultimodalAPI(input []multimodalInputItem) ([]float64, error) {
+func (s *APIEmbeddingService) callMultimodalAPI(ctx context.Context, input []multimodalInputItem, record func(Usage)) ([]float64, error) {
 	reqBody := multimodalRequest{
 		Model: s.ModelName,
 		Input: input,
@@ -285,59 +545,60 @@ func (s *APIEmbeddingService) callMultimodalAPI(input []multimodalInputItem) ([]
 	}
 
 	apiURL := strings.TrimRight(s.Endpoint, "/") + "/embeddings/multimodal"
+	status, respBody, err := s.post(ctx, s.mmClient, apiURL, bodyBytes, "multimodal embedding")
+	if err != nil {
+		return nil, err
+	}
 
-	const maxRetries = 3
-	var lastErr error
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		if attempt > 0 {
-			backoff := time.Duration(attempt) * 5 * time.Second
-			log.Printf("[Embed] multimodal retry %d/%d after %v", attempt+1, maxRetries, backoff)
-			time.Sleep(backoff)
-		}
-
-		req, err := http.NewRequest(http.MethodPost, apiURL, bytes.NewReader(bodyBytes))
-		if err != nil {
-			return nil, fmt.Errorf("failed to create request: %w", err)
-		}
-		req.Header.Set("Content-Type", "application/json")
-		if s.APIKey != "" {
-			req.Header.Set("Authorization", "Bearer "+s.APIKey)
-		}
+	if status != http.StatusOK {
+		errlog.Logf("[Embed] multimodal API error (HTTP %d): %s", status, string(respBody))
+		return nil, fmt.Errorf("embedding API error (HTTP %d): %s", status, string(respBody))
+	}
 
-		resp, err := s.mmClient.Do(req)
-		if err != nil {
-			lastErr = fmt.Errorf("multimodal embedding API request failed: %w", err)
-			continue
-		}
+	var result multimodalResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if result.Error != nil {
+		return nil, fmt.Errorf("multimodal embedding API error: %s", result.Error.Message)
+	}
+	if record != nil && result.Usage != nil {
+		record(*result.Usage)
+	}
+	return result.Data.Embedding, nil
+}
 
-		respBody, err := io.ReadAll(io.LimitReader(resp.Body, 50<<20)) // 50MB max response
-		resp.Body.Close()
-		if err != nil {
-			lastErr = fmt.Errorf("failed to read response body: %w", err)
-			continue
-		}
+// Metered returns an EmbeddingService that forwards to s and adds the token
+// usage reported by the API to u. Services that do not report usage are
+// returned unchanged. The returned service is safe for concurrent use.
+func Metered(s EmbeddingService, u *Usage) EmbeddingService {
+	api, ok := s.(*APIEmbeddingService)
+	if !ok || u == nil {
+		return s
+	}
+	return &meteredService{api: api, usage: u}
+}
 
-		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
-			lastErr = fmt.Errorf("embedding API error (HTTP %d): %s", resp.StatusCode, string(respBody))
-			continue
-		}
+type meteredService struct {
+	api   *APIEmbeddingService
+	mu    sync.Mutex
+	usage *Usage
+}
 
-		if resp.StatusCode != http.StatusOK {
-			errlog.Logf("[Embed] multimodal API error (HTTP %d): %s", resp.StatusCode, string(respBody))
-			return nil, fmt.Errorf("embedding API error (HTTP %d): %s", resp.StatusCode, string(respBody))
-		}
+func (m *meteredService) record(u Usage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.usage.PromptTokens += u.PromptTokens
+}
 
-		var result multimodalResponse
-		if err := json.Unmarshal(respBody, &result); err != nil {
-			return nil, fmt.Errorf("failed to decode response: %w", err)
-		}
-		if result.Error != nil {
-			return nil, fmt.Errorf("multimodal embedding API error: %s", result.Error.Message)
-		}
+func (m *meteredService) Embed(ctx context.Context, text string) ([]float64, error) {
+	return m.api.embed(ctx, text, m.record)
+}
 
-		return result.Data.Embedding, nil
-	}
+func (m *meteredService) EmbedBatch(ctx context.Context, texts []string) ([][]float64, error) {
+	return m.api.embedBatch(ctx, texts, m.record)
+}
 
-	errlog.Logf("[Embed] multimodal API failed after %d retries: %v", maxRetries, lastErr)
-	return nil, lastErr
+func (m *meteredService) EmbedImageURL(ctx context.Context, imageURL string) ([]float64, error) {
+	return m.api.embedImageURL(ctx, imageURL, m.record)
 }
@@ -0,0 +1,271 @@
+// Package eval runs golden question sets through the retrieval pipeline and
+// scores the results, so that changes to thresholds, top-k, chunking or the
+// embedding model can be checked against known answers before deploying.
+//
+// Each case names the documents that should be retrieved for its question.
+// Retrieval is scored with recall@k (share of expected documents among the
+// top k chunks) and MRR (reciprocal rank of the first chunk from an expected
+// document). Optionally an answer is generated from the retrieved chunks and
+// an LLM judge rates how faithful it is to them (0–1).
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"askflow/internal/llm"
+	"askflow/internal/vectorstore"
+)
+
+// Case is one golden question.
+type Case struct {
+	ID       string `json:"id"`
+	Question string `json:"question"`
+	// ProductID overrides the set's product; empty searches the public library only.
+	ProductID string `json:"product_id"`
+	// ExpectedDocuments are document IDs or names (case-insensitive) that
+	// should be retrieved for the question.
+	ExpectedDocuments []string `json:"expected_documents"`
+}
+
+// Set is a golden question set loaded from a JSON or YAML file.
+type Set struct {
+	Name      string `json:"name"`
+	ProductID string `json:"product_id"`
+	Cases     []Case `json:"cases"`
+}
+
+// LoadSet reads a golden set from path. Files ending in .yaml or .yml are
+// parsed as YAML, anything else as JSON.
+func LoadSet(path string) (*Set, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		tree, err := parseYAML(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid YAML: %w", err)
+		}
+		if data, err = json.Marshal(stringifyNumbers(tree)); err != nil {
+			return nil, err
+		}
+	}
+	var set Set
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid golden set: %w", err)
+	}
+	if len(set.Cases) == 0 {
+		return nil, errors.New("golden set has no cases")
+	}
+	for i := range set.Cases {
+		c := &set.Cases[i]
+		c.Question = strings.TrimSpace(c.Question)
+		if c.ID == "" {
+			c.ID = fmt.Sprintf("%d", i+1)
+		}
+		if c.Question == "" {
+			return nil, fmt.Errorf("case %s: question is required", c.ID)
+		}
+		if len(c.ExpectedDocuments) == 0 {
+			return nil, fmt.Errorf("case %s: expected_documents is required", c.ID)
+		}
+		if c.ProductID == "" {
+			c.ProductID = set.ProductID
+		}
+	}
+	return &set, nil
+}
+
+// stringifyNumbers turns YAML numbers into strings, since every field of a
+// golden set is text (IDs and document names may look numeric).
+func stringifyNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		return string(t)
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = stringifyNumbers(e)
+		}
+	case []interface{}:
+		for i, e := range t {
+			t[i] = stringifyNumbers(e)
+		}
+	}
+	return v
+}
+
+// Retriever returns the chunks the RAG pipeline would answer from.
+// *query.QueryEngine implements it.
+type Retriever interface {
+	Retrieve(ctx context.Context, question, productID string, topK int, threshold float64) ([]vectorstore.SearchResult, error)
+}
+
+// Options control an evaluation run.
+type Options struct {
+	TopK      int
+	Threshold float64
+	// Judge, if non-nil, generates an answer for each case from the retrieved
+	// chunks and rates its faithfulness to them.
+	Judge llm.LLMService
+}
+
+// Hit is one retrieved chunk.
+type Hit struct {
+	DocumentID   string  `json:"document_id"`
+	DocumentName string  `json:"document_name"`
+	Score        float64 `json:"score"`
+	Relevant     bool    `json:"relevant"`
+}
+
+// CaseResult is the outcome of one case.
+type CaseResult struct {
+	ID             string   `json:"id"`
+	Question       string   `json:"question"`
+	Hits           []Hit    `json:"hits"`
+	Missing        []string `json:"missing,omitempty"` // expected documents not retrieved
+	Recall         float64  `json:"recall"`
+	ReciprocalRank float64  `json:"reciprocal_rank"`
+	Answer         string   `json:"answer,omitempty"`
+	Faithfulness   *float64 `json:"faithfulness,omitempty"`
+	JudgeReason    string   `json:"judge_reason,omitempty"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// Report summarises a run. Averages are over all cases; a case whose
+// retrieval failed counts as zero. Faithfulness is averaged over judged cases.
+type Report struct {
+	Name         string       `json:"name,omitempty"`
+	TopK         int          `json:"top_k"`
+	Threshold    float64      `json:"threshold"`
+	Cases        []CaseResult `json:"cases"`
+	RecallAtK    float64      `json:"recall_at_k"`
+	MRR          float64      `json:"mrr"`
+	Faithfulness *float64     `json:"faithfulness,omitempty"`
+	JudgedCases  int          `json:"judged_cases"`
+	Errors       int          `json:"errors"`
+}
+
+// Run evaluates every case of set and returns the report. progress, if
+// non-nil, is called after each case. Cases left when ctx is done fail with
+// ctx's error.
+func Run(ctx context.Context, set *Set, r Retriever, opts Options, progress func(done, total int)) *Report {
+	report := &Report{Name: set.Name, TopK: opts.TopK, Threshold: opts.Threshold}
+	var faithSum float64
+	for i, c := range set.Cases {
+		res := runCase(ctx, c, r, opts)
+		report.RecallAtK += res.Recall
+		report.MRR += res.ReciprocalRank
+		if res.Error != "" {
+			report.Errors++
+		}
+		if res.Faithfulness != nil {
+			faithSum += *res.Faithfulness
+			report.JudgedCases++
+		}
+		report.Cases = append(report.Cases, res)
+		if progress != nil {
+			progress(i+1, len(set.Cases))
+		}
+	}
+	n := float64(len(set.Cases))
+	report.RecallAtK /= n
+	report.MRR /= n
+	if report.JudgedCases > 0 {
+		f := faithSum / float64(report.JudgedCases)
+		report.Faithfulness = &f
+	}
+	return report
+}
+
+func runCase(ctx context.Context, c Case, r Retriever, opts Options) CaseResult {
+	res := CaseResult{ID: c.ID, Question: c.Question, Hits: []Hit{}}
+	results, err := r.Retrieve(ctx, c.Question, c.ProductID, opts.TopK, opts.Threshold)
+	if err != nil {
+		res.Error = err.Error()
+		res.Missing = c.ExpectedDocuments
+		return res
+	}
+
+	found := make(map[int]bool, len(c.ExpectedDocuments))
+	for rank, sr := range results {
+		hit := Hit{DocumentID: sr.DocumentID, DocumentName: sr.DocumentName, Score: sr.Score}
+		for j, want := range c.ExpectedDocuments {
+			if matchesDocument(sr, want) {
+				hit.Relevant = true
+				found[j] = true
+			}
+		}
+		if hit.Relevant && res.ReciprocalRank == 0 {
+			res.ReciprocalRank = 1 / float64(rank+1)
+		}
+		res.Hits = append(res.Hits, hit)
+	}
+	for j, want := range c.ExpectedDocuments {
+		if !found[j] {
+			res.Missing = append(res.Missing, want)
+		}
+	}
+	res.Recall = float64(len(found)) / float64(len(c.ExpectedDocuments))
+
+	if opts.Judge != nil && len(results) > 0 {
+		judge(ctx, &res, c.Question, results, opts.Judge)
+	}
+	return res
+}
+
+// matchesDocument reports whether a retrieved chunk belongs to the expected
+// document, given by ID or by name.
+func matchesDocument(sr vectorstore.SearchResult, want string) bool {
+	want = strings.TrimSpace(want)
+	return sr.DocumentID == want || strings.EqualFold(sr.DocumentName, want)
+}
+
+const judgePrompt = "你是一个严格的评测员，负责判断回答是否忠实于参考资料。" +
+	"忠实指回答中的每一项事实陈述都能在参考资料中找到依据；参考资料之外的内容、臆测或与资料矛盾的内容都会降低分数。" +
+	"是否完整回答问题不影响忠实度。" +
+	"\n\n请只回复一个JSON对象，格式：{\"score\":0到1之间的小数,\"reason\":\"简短理由\"}"
+
+// judge generates an answer from the retrieved chunks the way the pipeline
+// does and asks the LLM to rate its faithfulness to them.
+func judge(ctx context.Context, res *CaseResult, question string, results []vectorstore.SearchResult, ls llm.LLMService) {
+	chunks := make([]string, len(results))
+	for i, r := range results {
+		chunks[i] = r.ChunkText
+	}
+	answer, err := ls.Generate(ctx, "", chunks, question)
+	if err != nil {
+		res.Error = fmt.Sprintf("failed to generate answer: %v", err)
+		return
+	}
+	res.Answer = answer
+
+	verdict, err := ls.Generate(ctx, judgePrompt, chunks, "问题："+question+"\n\n回答："+answer)
+	if err != nil {
+		res.Error = fmt.Sprintf("failed to judge answer: %v", err)
+		return
+	}
+	start := strings.Index(verdict, "{")
+	end := strings.LastIndex(verdict, "}")
+	if start < 0 || end <= start {
+		res.Error = "judge returned no verdict"
+		return
+	}
+	var parsed struct {
+		Score  float64 `json:"score"`
+		Reason string  `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(verdict[start:end+1]), &parsed); err != nil {
+		res.Error = "judge returned an invalid verdict"
+		return
+	}
+	score := min(max(parsed.Score, 0), 1)
+	res.Faithfulness = &score
+	res.JudgeReason = parsed.Reason
+}
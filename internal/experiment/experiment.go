@@ -0,0 +1,477 @@
+// Package experiment provides A/B tests of retrieval settings. While an
+// experiment runs, each user is assigned to a variant by hashing their ID,
+// so a fixed share of traffic is answered with the variant's vector
+// settings (top_k, threshold, content priority, text matching) and the rest
+// with the configured ones ("control"). Every exposed query is logged with
+// its outcome; user feedback on the answer is joined to it for the report.
+package experiment
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"askflow/internal/query"
+)
+
+// Experiment statuses. Only one experiment can be running at a time.
+const (
+	StatusDraft   = "draft"
+	StatusRunning = "running"
+	StatusStopped = "stopped"
+)
+
+// ControlVariant is the name of the implicit variant served with the
+// configured settings to the traffic not assigned to any other variant.
+const ControlVariant = "control"
+
+// ErrNotFound is returned for an unknown experiment ID.
+var ErrNotFound = errors.New("experiment not found")
+
+// Variant is an alternative set of retrieval settings served to Percent% of
+// users.
+type Variant struct {
+	Name    string          `json:"name"`
+	Percent int             `json:"percent"`
+	Params  query.Overrides `json:"params"`
+}
+
+// Experiment is an A/B test of retrieval settings.
+type Experiment struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Status      string     `json:"status"`
+	Variants    []Variant  `json:"variants"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	StoppedAt   *time.Time `json:"stopped_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Assignment is the variant a query was served with.
+type Assignment struct {
+	ExperimentID string
+	Variant      string
+	Overrides    *query.Overrides // nil for the control variant
+}
+
+// Exposure is the outcome of one query served during an experiment.
+type Exposure struct {
+	QueryID     string
+	UserID      string
+	ProductID   string
+	Pending     bool // the question could not be answered
+	SourceCount int
+	Latency     time.Duration
+}
+
+// VariantStats summarises the outcomes of one variant.
+type VariantStats struct {
+	Variant      string          `json:"variant"`
+	Percent      int             `json:"percent"`
+	Params       query.Overrides `json:"params"`
+	Queries      int             `json:"queries"`
+	Users        int             `json:"users"`
+	Pending      int             `json:"pending"`
+	PendingRate  float64         `json:"pending_rate"`
+	AvgSources   float64         `json:"avg_sources"`
+	AvgLatencyMs float64         `json:"avg_latency_ms"`
+	Feedback     int             `json:"feedback"`
+	Helpful      int             `json:"helpful"`
+	HelpfulRate  float64         `json:"helpful_rate"` // share of feedback that was helpful
+}
+
+// Report compares the variants of an experiment.
+type Report struct {
+	Experiment *Experiment    `json:"experiment"`
+	Variants   []VariantStats `json:"variants"`
+}
+
+const experimentColumns = `id, name, description, status, variants, started_at, stopped_at, created_at, updated_at`
+
+// Service manages experiments, assigns queries to variants and records
+// outcomes and feedback.
+type Service struct {
+	readDB  *sql.DB
+	writeDB *sql.DB
+
+	// running caches the running experiment (nil if none) so assignment
+	// does not hit the database on every query.
+	mu      sync.RWMutex
+	running *Experiment
+	loaded  bool
+}
+
+// NewService creates a new experiment Service with separate read and write database connections.
+func NewService(readDB, writeDB *sql.DB) *Service {
+	return &Service{readDB: readDB, writeDB: writeDB}
+}
+
+// validate normalises and checks an experiment's name and variants.
+func validate(e *Experiment) error {
+	e.Name = strings.TrimSpace(e.Name)
+	e.Description = strings.TrimSpace(e.Description)
+	if e.Name == "" || len(e.Name) > 100 {
+		return fmt.Errorf("name is required (max 100 characters)")
+	}
+	if len(e.Description) > 1000 {
+		return fmt.Errorf("description is too long (max 1000 characters)")
+	}
+	if len(e.Variants) == 0 || len(e.Variants) > 5 {
+		return fmt.Errorf("an experiment needs 1 to 5 variants")
+	}
+	total := 0
+	seen := map[string]bool{ControlVariant: true}
+	for i := range e.Variants {
+		v := &e.Variants[i]
+		v.Name = strings.TrimSpace(v.Name)
+		if v.Name == "" || len(v.Name) > 50 {
+			return fmt.Errorf("variant %d: name is required (max 50 characters)", i+1)
+		}
+		if seen[v.Name] {
+			return fmt.Errorf("variant name %q is reserved or duplicated", v.Name)
+		}
+		seen[v.Name] = true
+		if v.Percent < 1 || v.Percent > 100 {
+			return fmt.Errorf("variant %s: percent must be between 1 and 100", v.Name)
+		}
+		if err := v.Params.Validate(); err != nil {
+			return fmt.Errorf("variant %s: %w", v.Name, err)
+		}
+		total += v.Percent
+	}
+	if total > 100 {
+		return fmt.Errorf("variant percentages add up to %d%%, at most 100%% is allowed", total)
+	}
+	return nil
+}
+
+// List returns all experiments, newest first.
+func (s *Service) List() ([]Experiment, error) {
+	rows, err := s.readDB.Query(`SELECT ` + experimentColumns + ` FROM experiments ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list experiments: %w", err)
+	}
+	defer rows.Close()
+	experiments := []Experiment{}
+	for rows.Next() {
+		e, err := scanExperiment(rows)
+		if err != nil {
+			return nil, err
+		}
+		experiments = append(experiments, *e)
+	}
+	return experiments, rows.Err()
+}
+
+// Get returns an experiment by ID.
+func (s *Service) Get(id string) (*Experiment, error) {
+	e, err := scanExperiment(s.readDB.QueryRow(`SELECT `+experimentColumns+` FROM experiments WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return e, err
+}
+
+// Create adds a draft experiment.
+func (s *Service) Create(e Experiment) (*Experiment, error) {
+	if err := validate(&e); err != nil {
+		return nil, err
+	}
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	variants, _ := json.Marshal(e.Variants)
+	now := time.Now().UTC()
+	_, err = s.writeDB.Exec(
+		`INSERT INTO experiments (id, name, description, status, variants, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		id, e.Name, e.Description, StatusDraft, string(variants), now, now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create experiment: %w", err)
+	}
+	e.ID, e.Status, e.CreatedAt, e.UpdatedAt = id, StatusDraft, now, now
+	e.StartedAt, e.StoppedAt = nil, nil
+	return &e, nil
+}
+
+// Update replaces a draft experiment's name, description and variants.
+// Running and stopped experiments cannot be edited, since that would mix
+// outcomes of different settings under one variant name.
+func (s *Service) Update(id string, e Experiment) (*Experiment, error) {
+	current, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if current.Status != StatusDraft {
+		return nil, fmt.Errorf("only draft experiments can be edited")
+	}
+	if err := validate(&e); err != nil {
+		return nil, err
+	}
+	variants, _ := json.Marshal(e.Variants)
+	now := time.Now().UTC()
+	if _, err := s.writeDB.Exec(
+		`UPDATE experiments SET name = ?, description = ?, variants = ?, updated_at = ? WHERE id = ?`,
+		e.Name, e.Description, string(variants), now, id,
+	); err != nil {
+		return nil, fmt.Errorf("failed to update experiment: %w", err)
+	}
+	current.Name, current.Description, current.Variants, current.UpdatedAt = e.Name, e.Description, e.Variants, now
+	return current, nil
+}
+
+// Start runs a draft experiment. Another running experiment must be stopped
+// first.
+func (s *Service) Start(id string) (*Experiment, error) {
+	e, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if e.Status != StatusDraft {
+		return nil, fmt.Errorf("only draft experiments can be started")
+	}
+	if running, err := s.Running(); err != nil {
+		return nil, err
+	} else if running != nil {
+		return nil, fmt.Errorf("experiment %q is already running", running.Name)
+	}
+	now := time.Now().UTC()
+	if _, err := s.writeDB.Exec(
+		`UPDATE experiments SET status = ?, started_at = ?, updated_at = ? WHERE id = ? AND status = ?`,
+		StatusRunning, now, now, id, StatusDraft,
+	); err != nil {
+		return nil, fmt.Errorf("failed to start experiment: %w", err)
+	}
+	s.invalidate()
+	return s.Get(id)
+}
+
+// Stop ends a running experiment. Its exposures and report are kept.
+func (s *Service) Stop(id string) (*Experiment, error) {
+	e, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if e.Status != StatusRunning {
+		return nil, fmt.Errorf("only running experiments can be stopped")
+	}
+	now := time.Now().UTC()
+	if _, err := s.writeDB.Exec(
+		`UPDATE experiments SET status = ?, stopped_at = ?, updated_at = ? WHERE id = ?`,
+		StatusStopped, now, now, id,
+	); err != nil {
+		return nil, fmt.Errorf("failed to stop experiment: %w", err)
+	}
+	s.invalidate()
+	return s.Get(id)
+}
+
+// Delete removes an experiment that is not running, with its exposures.
+func (s *Service) Delete(id string) error {
+	e, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	if e.Status == StatusRunning {
+		return fmt.Errorf("stop the experiment before deleting it")
+	}
+	tx, err := s.writeDB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM experiment_exposures WHERE experiment_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete exposures: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM experiments WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete experiment: %w", err)
+	}
+	return tx.Commit()
+}
+
+// Running returns the running experiment, or nil if there is none.
+func (s *Service) Running() (*Experiment, error) {
+	s.mu.RLock()
+	if s.loaded {
+		e := s.running
+		s.mu.RUnlock()
+		return e, nil
+	}
+	s.mu.RUnlock()
+
+	e, err := scanExperiment(s.readDB.QueryRow(
+		`SELECT `+experimentColumns+` FROM experiments WHERE status = ? ORDER BY started_at DESC LIMIT 1`, StatusRunning))
+	if err == sql.ErrNoRows {
+		e, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.running, s.loaded = e, true
+	s.mu.Unlock()
+	return e, nil
+}
+
+// invalidate drops the cached running experiment.
+func (s *Service) invalidate() {
+	s.mu.Lock()
+	s.running, s.loaded = nil, false
+	s.mu.Unlock()
+}
+
+// Assign returns the variant userID is served in the running experiment,
+// or nil when no experiment is running. Users keep their variant for the
+// whole experiment, so their feedback reflects one set of settings.
+func (s *Service) Assign(userID string) *Assignment {
+	e, err := s.Running()
+	if err != nil || e == nil {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(e.ID + ":" + userID))
+	bucket := int(binary.BigEndian.Uint32(sum[:4]) % 100)
+	for _, v := range e.Variants {
+		if bucket < v.Percent {
+			params := v.Params
+			return &Assignment{ExperimentID: e.ID, Variant: v.Name, Overrides: &params}
+		}
+		bucket -= v.Percent
+	}
+	return &Assignment{ExperimentID: e.ID, Variant: ControlVariant}
+}
+
+// RecordExposure logs the outcome of a query served under a.
+func (s *Service) RecordExposure(a *Assignment, x Exposure) error {
+	pending := 0
+	if x.Pending {
+		pending = 1
+	}
+	_, err := s.writeDB.Exec(
+		`INSERT OR IGNORE INTO experiment_exposures
+			(query_id, experiment_id, variant, user_id, product_id, pending, source_count, latency_ms, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		x.QueryID, a.ExperimentID, a.Variant, x.UserID, x.ProductID, pending, x.SourceCount,
+		x.Latency.Milliseconds(), time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record exposure: %w", err)
+	}
+	return nil
+}
+
+// RecordFeedback stores a user's rating of an answer. A user may change
+// their own rating; feedback on another user's query is ignored.
+func (s *Service) RecordFeedback(queryID, userID string, helpful bool, comment string) error {
+	h := 0
+	if helpful {
+		h = 1
+	}
+	_, err := s.writeDB.Exec(
+		`INSERT INTO query_feedback (query_id, user_id, helpful, comment, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(query_id) DO UPDATE SET helpful = excluded.helpful, comment = excluded.comment, created_at = excluded.created_at
+		WHERE query_feedback.user_id = excluded.user_id`,
+		queryID, userID, h, comment, time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record feedback: %w", err)
+	}
+	return nil
+}
+
+// Report compares the outcomes of an experiment's variants, control first.
+// Feedback only counts when it came from the user who asked the query.
+func (s *Service) Report(id string) (*Report, error) {
+	e, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.readDB.Query(
+		`SELECT x.variant, COUNT(*), COUNT(DISTINCT x.user_id), SUM(x.pending),
+			AVG(x.source_count), AVG(x.latency_ms),
+			COUNT(f.query_id), COALESCE(SUM(f.helpful), 0)
+		FROM experiment_exposures x
+		LEFT JOIN query_feedback f ON f.query_id = x.query_id AND f.user_id = x.user_id
+		WHERE x.experiment_id = ?
+		GROUP BY x.variant`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query exposures: %w", err)
+	}
+	defer rows.Close()
+	byName := map[string]VariantStats{}
+	for rows.Next() {
+		var st VariantStats
+		if err := rows.Scan(&st.Variant, &st.Queries, &st.Users, &st.Pending,
+			&st.AvgSources, &st.AvgLatencyMs, &st.Feedback, &st.Helpful); err != nil {
+			return nil, err
+		}
+		byName[st.Variant] = st
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	controlPercent := 100
+	for _, v := range e.Variants {
+		controlPercent -= v.Percent
+	}
+	all := append([]Variant{{Name: ControlVariant, Percent: controlPercent}}, e.Variants...)
+	report := &Report{Experiment: e}
+	for _, v := range all {
+		st := byName[v.Name]
+		st.Variant, st.Percent, st.Params = v.Name, v.Percent, v.Params
+		if st.Queries > 0 {
+			st.PendingRate = float64(st.Pending) / float64(st.Queries)
+		}
+		if st.Feedback > 0 {
+			st.HelpfulRate = float64(st.Helpful) / float64(st.Feedback)
+		}
+		report.Variants = append(report.Variants, st)
+	}
+	return report, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanExperiment(row scanner) (*Experiment, error) {
+	var e Experiment
+	var variants string
+	var startedAt, stoppedAt sql.NullTime
+	if err := row.Scan(&e.ID, &e.Name, &e.Description, &e.Status, &variants,
+		&startedAt, &stoppedAt, &e.CreatedAt, &e.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(variants), &e.Variants); err != nil {
+		return nil, fmt.Errorf("invalid variants of experiment %s: %w", e.ID, err)
+	}
+	if startedAt.Valid {
+		e.StartedAt = &startedAt.Time
+	}
+	if stoppedAt.Valid {
+		e.StoppedAt = &stoppedAt.Time
+	}
+	return &e, nil
+}
+
+// generateID creates a random hex string for use as a unique identifier.
+func generateID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,612 @@
+// Package faq generates an FAQ for each product from the questions users
+// actually ask. Answered questions are logged while faq.enabled is set; on a
+// cron schedule the pending questions admins answered and the questions
+// asked at least faq.min_count times are merged by embedding similarity,
+// ranked by how often they were asked and stored as FAQ entries. Each
+// product's FAQ is also ingested as a document, so later questions are
+// answered from it.
+package faq
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"askflow/internal/chunker"
+	"askflow/internal/config"
+	"askflow/internal/cron"
+	"askflow/internal/embedding"
+	"askflow/internal/jobs"
+	"askflow/internal/lease"
+	"askflow/internal/vectorstore"
+)
+
+// ErrRunning is returned by Trigger while the FAQ is being generated.
+var ErrRunning = errors.New("faq generation already running")
+
+// maxCandidates caps how many distinct questions are embedded per run,
+// bounding embedding cost and the O(n·k) merge pass.
+const maxCandidates = 5000
+
+// maxAnswerRunes caps the length of an FAQ answer.
+const maxAnswerRunes = 4000
+
+// Entry sources.
+const (
+	SourcePending = "pending" // a pending question answered by an admin
+	SourceQuery   = "query"   // a question answered automatically
+)
+
+// docPrefix is the document ID prefix of the ingested FAQ documents.
+const docPrefix = "faq-"
+
+// docName is the name of the ingested FAQ documents.
+const docName = "常见问题（自动生成）"
+
+// Entry is a question and answer of a product's FAQ.
+type Entry struct {
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+	Source   string `json:"source"`
+	Count    int    `json:"count"` // times the question (or a similar one) was asked
+}
+
+// FAQ is the generated FAQ of a product.
+type FAQ struct {
+	ProductID   string    `json:"product_id"`
+	Entries     []Entry   `json:"entries"`
+	GeneratedAt time.Time `json:"generated_at,omitzero"`
+}
+
+// Status is the generator state reported by /api/admin/faq.
+type Status struct {
+	Enabled   bool      `json:"enabled"`
+	Schedule  string    `json:"schedule"`
+	Running   bool      `json:"running"`
+	NextRun   time.Time `json:"next_run,omitzero"`
+	LastRun   time.Time `json:"last_run,omitzero"`
+	LastError string    `json:"last_error,omitempty"`
+	Products  int       `json:"products"` // products with an FAQ
+	Entries   int       `json:"entries"`
+}
+
+// Service logs answered questions and generates the FAQ. Scheduled runs
+// follow faq.schedule, re-read every minute; at most one run happens at a
+// time.
+type Service struct {
+	readDB   *sql.DB
+	writeDB  *sql.DB
+	chunker  *chunker.TextChunker
+	store    vectorstore.VectorStore
+	embedder func() embedding.EmbeddingService
+	cfg      func() config.FAQConfig
+	locks    *lease.Manager
+	jobs     *jobs.Registry
+
+	mu      sync.Mutex
+	running bool
+	lastRun time.Time
+	lastErr string
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewService creates an FAQ Service. embedder returns the embedding service
+// currently in use and cfg the current FAQ settings.
+func NewService(
+	readDB, writeDB *sql.DB,
+	tc *chunker.TextChunker,
+	vs vectorstore.VectorStore,
+	embedder func() embedding.EmbeddingService,
+	cfg func() config.FAQConfig,
+) *Service {
+	return &Service{
+		readDB:   readDB,
+		writeDB:  writeDB,
+		chunker:  tc,
+		store:    vs,
+		embedder: embedder,
+		cfg:      cfg,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Record logs a question answered for productID while faq.enabled is set.
+func (s *Service) Record(productID, question, answer string) {
+	if !s.cfg().Enabled {
+		return
+	}
+	question, answer = strings.TrimSpace(question), strings.TrimSpace(answer)
+	if question == "" || answer == "" || len(question) > 2000 {
+		return
+	}
+	if _, err := s.writeDB.Exec(
+		`INSERT INTO faq_query_log (product_id, question, answer, created_at) VALUES (?, ?, ?, ?)`,
+		productID, question, truncate(answer, maxAnswerRunes), time.Now().UTC(),
+	); err != nil {
+		log.Printf("[FAQ] failed to log question: %v", err)
+	}
+}
+
+// SetLocks makes generation take the "faq" lease first, so that instances
+// sharing the database do not rebuild the FAQ at the same time. Call it
+// before Start.
+func (s *Service) SetLocks(m *lease.Manager) {
+	s.locks = m
+}
+
+// SetJobs records generation runs as jobs in r, which can be canceled and
+// are retried by generating again. Call it before Start.
+func (s *Service) SetJobs(r *jobs.Registry) {
+	s.jobs = r
+	r.OnRetry(jobs.KindFAQ, func(json.RawMessage) error {
+		return s.Trigger()
+	})
+}
+
+// Start launches the scheduling loop.
+func (s *Service) Start() {
+	s.wg.Add(1)
+	go s.loop()
+}
+
+// Stop ends the scheduling loop and waits until ctx is done for a running
+// generation to finish.
+func (s *Service) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Service) loop() {
+	defer s.wg.Done()
+	lastErr := ""
+	for {
+		next := time.Now().Truncate(time.Minute).Add(time.Minute)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		cfg := s.cfg()
+		if !cfg.Enabled {
+			continue
+		}
+		sched, err := cron.Parse(cfg.Schedule)
+		if err != nil {
+			if err.Error() != lastErr {
+				log.Printf("[FAQ] invalid schedule %q: %v", cfg.Schedule, err)
+				lastErr = err.Error()
+			}
+			continue
+		}
+		lastErr = ""
+		if !sched.Match(next) {
+			continue
+		}
+		if err := s.trigger(next); err != nil {
+			log.Printf("[FAQ] scheduled generation skipped: %v", err)
+		}
+	}
+}
+
+// Trigger starts generating the FAQ in the background.
+func (s *Service) Trigger() error {
+	return s.trigger(time.Time{})
+}
+
+// trigger is Trigger for the run scheduled at slot, zero for manual runs.
+func (s *Service) trigger(slot time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return ErrRunning
+	}
+	select {
+	case <-s.stop:
+		return errors.New("faq service stopped")
+	default:
+	}
+	l, err := s.locks.Acquire("faq", slot)
+	if err != nil {
+		return err
+	}
+	s.running = true
+	s.wg.Add(1)
+	go s.run(l)
+	return nil
+}
+
+func (s *Service) run(l *lease.Lease) {
+	defer s.wg.Done()
+	defer l.Release()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	job := s.jobs.Begin(jobs.KindFAQ, "FAQ generation", nil, cancel)
+	var runErr error
+	defer func() {
+		if r := recover(); r != nil {
+			runErr = fmt.Errorf("panic: %v", r)
+			log.Printf("[FAQ] panic: %v", r)
+		}
+		s.mu.Lock()
+		s.running = false
+		s.lastRun = time.Now()
+		s.lastErr = ""
+		if runErr != nil {
+			s.lastErr = runErr.Error()
+		}
+		s.mu.Unlock()
+		job.Finish(runErr)
+	}()
+
+	if runErr = s.Generate(ctx); runErr != nil {
+		log.Printf("[FAQ] generation failed: %v", runErr)
+	}
+}
+
+// candidate is a distinct question mined for the FAQ.
+type candidate struct {
+	productID string
+	question  string
+	answer    string
+	source    string
+	count     int
+	newest    time.Time
+}
+
+// Generate rebuilds the FAQ of every product from the questions of the last
+// faq.lookback_days, replacing the stored entries and FAQ documents. The
+// embedding calls stop once ctx is done.
+func (s *Service) Generate(ctx context.Context) error {
+	cfg := s.cfg()
+	since := time.Now().UTC().AddDate(0, 0, -cfg.LookbackDays)
+	if _, err := s.writeDB.Exec(`DELETE FROM faq_query_log WHERE created_at < ?`, since); err != nil {
+		log.Printf("[FAQ] failed to prune question log: %v", err)
+	}
+
+	candidates, err := s.loadCandidates(since)
+	if err != nil {
+		return err
+	}
+	faqs := make(map[string][]Entry)
+	if len(candidates) > 0 {
+		texts := make([]string, len(candidates))
+		for i, c := range candidates {
+			texts[i] = c.question
+		}
+		vectors, err := s.embedder().EmbedBatch(ctx, texts)
+		if err != nil {
+			return fmt.Errorf("failed to embed questions: %w", err)
+		}
+		if len(vectors) != len(texts) {
+			return fmt.Errorf("embedding returned %d vectors for %d questions", len(vectors), len(texts))
+		}
+		faqs = buildEntries(candidates, vectors, cfg)
+	}
+
+	existing, err := s.productsWithFAQ()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	var errs []error
+	entries := 0
+	for productID, list := range faqs {
+		if err := s.publish(ctx, productID, list, now); err != nil {
+			errs = append(errs, fmt.Errorf("product %q: %w", productID, err))
+			continue
+		}
+		entries += len(list)
+	}
+	for _, productID := range existing {
+		if _, ok := faqs[productID]; !ok {
+			if err := s.publish(ctx, productID, nil, now); err != nil {
+				errs = append(errs, fmt.Errorf("product %q: %w", productID, err))
+			}
+		}
+	}
+	log.Printf("[FAQ] generated %d entries for %d products from %d questions", entries, len(faqs), len(candidates))
+	return errors.Join(errs...)
+}
+
+// loadCandidates returns the distinct questions answered since since: the
+// pending questions admins answered, then the logged questions by how often
+// they were asked. Questions that differ only in case, spacing or trailing
+// punctuation are counted as one.
+func (s *Service) loadCandidates(since time.Time) ([]*candidate, error) {
+	byKey := make(map[string]*candidate)
+	var list []*candidate
+	add := func(productID, question, answer, source string, at time.Time) {
+		question, answer = strings.TrimSpace(question), strings.TrimSpace(answer)
+		if question == "" || answer == "" {
+			return
+		}
+		key := productID + "\x00" + normalize(question)
+		c := byKey[key]
+		if c == nil {
+			if len(list) >= maxCandidates {
+				return
+			}
+			c = &candidate{productID: productID, question: question, answer: answer, source: source, newest: at}
+			byKey[key] = c
+			list = append(list, c)
+		} else if c.source != SourcePending && (source == SourcePending || at.After(c.newest)) {
+			// admin answers take precedence, then the newest answer
+			c.answer, c.source = answer, source
+		}
+		if at.After(c.newest) {
+			c.newest = at
+		}
+		c.count++
+	}
+
+	rows, err := s.readDB.Query(
+		`SELECT COALESCE(product_id, ''), question, COALESCE(NULLIF(answer, ''), llm_answer, ''), answered_at
+		 FROM pending_questions WHERE status = 'answered' AND answered_at >= ? ORDER BY answered_at DESC`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load answered questions: %w", err)
+	}
+	for rows.Next() {
+		var productID, question, answer string
+		var at sql.NullTime
+		if err := rows.Scan(&productID, &question, &answer, &at); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		add(productID, question, answer, SourcePending, at.Time)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.readDB.Query(
+		`SELECT product_id, question, answer, created_at FROM faq_query_log
+		 WHERE created_at >= ? ORDER BY created_at DESC`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load question log: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var productID, question, answer string
+		var at sql.NullTime
+		if err := rows.Scan(&productID, &question, &answer, &at); err != nil {
+			return nil, err
+		}
+		add(productID, question, answer, SourceQuery, at.Time)
+	}
+	return list, rows.Err()
+}
+
+// buildEntries merges the candidates of each product whose questions are at
+// least cfg.SimilarityThreshold similar, keeping the wording and answer of
+// the first (admin answers first, then the most asked), and returns the
+// entries that were answered by an admin or asked at least cfg.MinCount
+// times, most asked first, up to cfg.MaxEntries per product.
+func buildEntries(candidates []*candidate, vectors [][]float64, cfg config.FAQConfig) map[string][]Entry {
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ca, cb := candidates[order[a]], candidates[order[b]]
+		if (ca.source == SourcePending) != (cb.source == SourcePending) {
+			return ca.source == SourcePending
+		}
+		return ca.count > cb.count
+	})
+
+	type group struct {
+		entry  Entry
+		vector []float64
+	}
+	groups := make(map[string][]*group)
+	for _, i := range order {
+		c := candidates[i]
+		var best *group
+		bestScore := cfg.SimilarityThreshold
+		for _, g := range groups[c.productID] {
+			if score := vectorstore.CosineSimilarity(vectors[i], g.vector); score >= bestScore {
+				best, bestScore = g, score
+			}
+		}
+		if best != nil {
+			best.entry.Count += c.count
+			continue
+		}
+		groups[c.productID] = append(groups[c.productID], &group{
+			entry:  Entry{Question: c.question, Answer: truncate(c.answer, maxAnswerRunes), Source: c.source, Count: c.count},
+			vector: vectors[i],
+		})
+	}
+
+	faqs := make(map[string][]Entry)
+	for productID, list := range groups {
+		var entries []Entry
+		for _, g := range list {
+			if g.entry.Source == SourcePending || g.entry.Count >= cfg.MinCount {
+				entries = append(entries, g.entry)
+			}
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		sort.SliceStable(entries, func(a, b int) bool { return entries[a].Count > entries[b].Count })
+		if len(entries) > cfg.MaxEntries {
+			entries = entries[:cfg.MaxEntries]
+		}
+		faqs[productID] = entries
+	}
+	return faqs
+}
+
+// productsWithFAQ returns the products that currently have FAQ entries.
+func (s *Service) productsWithFAQ() ([]string, error) {
+	rows, err := s.readDB.Query(`SELECT DISTINCT product_id FROM faq_entries`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list FAQ products: %w", err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// docID returns the ID of the FAQ document of productID.
+func docID(productID string) string {
+	if productID == "" {
+		return docPrefix + "public"
+	}
+	return docPrefix + productID
+}
+
+// publish replaces the FAQ entries and the FAQ document of productID; with
+// no entries both are removed.
+func (s *Service) publish(ctx context.Context, productID string, entries []Entry, now time.Time) error {
+	id := docID(productID)
+	var chunks []vectorstore.VectorChunk
+	if len(entries) > 0 {
+		var texts []string
+		for _, e := range entries {
+			for _, c := range s.chunker.Split("问题："+e.Question+"\n回答："+e.Answer, id) {
+				texts = append(texts, c.Text)
+			}
+		}
+		vectors, err := s.embedder().EmbedBatch(ctx, texts)
+		if err != nil {
+			return fmt.Errorf("failed to embed FAQ: %w", err)
+		}
+		if len(vectors) != len(texts) {
+			return fmt.Errorf("embedding returned %d vectors for %d chunks", len(vectors), len(texts))
+		}
+		for i, text := range texts {
+			chunks = append(chunks, vectorstore.VectorChunk{
+				ChunkText:    text,
+				ChunkIndex:   i,
+				DocumentID:   id,
+				DocumentName: docName,
+				Vector:       vectors[i],
+				ProductID:    productID,
+			})
+		}
+	}
+
+	tx, err := s.writeDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM faq_entries WHERE product_id = ?`, productID); err != nil {
+		return fmt.Errorf("failed to clear FAQ entries: %w", err)
+	}
+	for i, e := range entries {
+		if _, err := tx.Exec(
+			`INSERT INTO faq_entries (product_id, position, question, answer, source, ask_count, generated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			productID, i, e.Question, e.Answer, e.Source, e.Count, now,
+		); err != nil {
+			return fmt.Errorf("failed to save FAQ entry: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if err := s.store.DeleteByDocID(id); err != nil {
+		return fmt.Errorf("failed to remove old FAQ document: %w", err)
+	}
+	if len(chunks) == 0 {
+		_, err := s.writeDB.Exec(`DELETE FROM documents WHERE id = ?`, id)
+		return err
+	}
+	if _, err := s.writeDB.Exec(
+		`INSERT OR REPLACE INTO documents (id, name, type, status, product_id, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		id, docName, "faq", "success", productID, now,
+	); err != nil {
+		return fmt.Errorf("failed to save FAQ document: %w", err)
+	}
+	return s.store.Store(id, chunks)
+}
+
+// Get returns the FAQ of productID ("" for the public library); it has no
+// entries until the FAQ has been generated.
+func (s *Service) Get(productID string) (*FAQ, error) {
+	rows, err := s.readDB.Query(
+		`SELECT question, answer, source, ask_count, generated_at FROM faq_entries
+		 WHERE product_id = ? ORDER BY position`, productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load FAQ: %w", err)
+	}
+	defer rows.Close()
+	f := &FAQ{ProductID: productID, Entries: []Entry{}}
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.Question, &e.Answer, &e.Source, &e.Count, &f.GeneratedAt); err != nil {
+			return nil, err
+		}
+		f.Entries = append(f.Entries, e)
+	}
+	return f, rows.Err()
+}
+
+// Status returns the generator state.
+func (s *Service) Status() Status {
+	cfg := s.cfg()
+	st := Status{Enabled: cfg.Enabled, Schedule: cfg.Schedule}
+	if cfg.Enabled {
+		if sched, err := cron.Parse(cfg.Schedule); err == nil {
+			st.NextRun = sched.Next(time.Now())
+		}
+	}
+	s.mu.Lock()
+	st.Running = s.running
+	st.LastRun = s.lastRun
+	st.LastError = s.lastErr
+	s.mu.Unlock()
+	_ = s.readDB.QueryRow(`SELECT COUNT(DISTINCT product_id), COUNT(*) FROM faq_entries`).Scan(&st.Products, &st.Entries)
+	return st
+}
+
+// normalize folds case, whitespace and trailing punctuation so that
+// trivially different spellings of a question are counted together.
+func normalize(q string) string {
+	q = strings.ToLower(strings.Join(strings.Fields(q), " "))
+	return strings.TrimRightFunc(q, func(r rune) bool { return unicode.IsPunct(r) || unicode.IsSpace(r) })
+}
+
+// truncate shortens s to at most maxLen runes, appending "..." when cut.
+func truncate(s string, maxLen int) string {
+	runes := []rune(s)
+	if len(runes) <= maxLen {
+		return s
+	}
+	return string(runes[:maxLen]) + "..."
+}
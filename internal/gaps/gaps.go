@@ -0,0 +1,536 @@
+// Package gaps builds knowledge gap reports. Questions that went pending —
+// because retrieval found nothing or the LLM could not answer from the
+// retrieved chunks — are embedded and clustered by cosine similarity; each
+// of the largest clusters is labelled with an LLM-generated topic. The
+// result is a ranked list of the documentation that is missing, generated
+// weekly on a cron schedule and emailed to admins, or on demand via the API.
+package gaps
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"askflow/internal/config"
+	"askflow/internal/cron"
+	"askflow/internal/embedding"
+	"askflow/internal/lease"
+	"askflow/internal/llm"
+	"askflow/internal/vectorstore"
+)
+
+// ErrRunning is returned by Trigger while another report is being generated.
+var ErrRunning = errors.New("gap report already running")
+
+// ErrNotFound is returned for an unknown report ID.
+var ErrNotFound = errors.New("gap report not found")
+
+// maxQuestions caps how many of the newest questions in the period are
+// clustered, bounding embedding cost and the O(n·k) clustering pass.
+const maxQuestions = 2000
+
+// sampleQuestions is how many questions of a topic are stored in the report
+// and shown to the LLM when labelling it.
+const sampleQuestions = 10
+
+// Topic is a cluster of similar unanswered questions.
+type Topic struct {
+	Topic      string   `json:"topic"`
+	Count      int      `json:"count"`   // questions in the cluster
+	Pending    int      `json:"pending"` // of which still unanswered
+	ProductIDs []string `json:"product_ids,omitempty"`
+	Questions  []string `json:"questions"` // up to sampleQuestions, newest first
+}
+
+// Report is a generated knowledge gap report.
+type Report struct {
+	ID            string    `json:"id"`
+	Trigger       string    `json:"trigger"` // "schedule" or "manual"
+	PeriodStart   time.Time `json:"period_start"`
+	PeriodEnd     time.Time `json:"period_end"`
+	QuestionCount int       `json:"question_count"`
+	Topics        []Topic   `json:"topics,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Status is the generator state reported by /api/admin/gaps.
+type Status struct {
+	Enabled   bool      `json:"enabled"`
+	Schedule  string    `json:"schedule"`
+	Running   bool      `json:"running"`
+	NextRun   time.Time `json:"next_run,omitzero"`
+	LastError string    `json:"last_error,omitempty"`
+	Reports   []Report  `json:"reports"` // newest first, without topics
+}
+
+// Service generates, stores and sends knowledge gap reports. Scheduled runs
+// follow gap_report.schedule, re-read every minute; at most one report is
+// generated at a time.
+type Service struct {
+	readDB   *sql.DB
+	writeDB  *sql.DB
+	services func() (embedding.EmbeddingService, llm.LLMService)
+	cfg      func() config.GapReportConfig
+	send     func(to, subject, body string) error
+	locks    *lease.Manager
+
+	mu      sync.Mutex
+	running bool
+	lastErr string
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewService creates a gap report Service. services returns the embedding
+// and LLM services currently in use, cfg the current report settings and
+// send delivers the report email.
+func NewService(
+	readDB, writeDB *sql.DB,
+	services func() (embedding.EmbeddingService, llm.LLMService),
+	cfg func() config.GapReportConfig,
+	send func(to, subject, body string) error,
+) *Service {
+	return &Service{
+		readDB:   readDB,
+		writeDB:  writeDB,
+		services: services,
+		cfg:      cfg,
+		send:     send,
+		stop:     make(chan struct{}),
+	}
+}
+
+// SetLocks makes reports take the "gap_report" lease first, so that
+// instances sharing the database do not generate and email the same report
+// twice. Call it before Start.
+func (s *Service) SetLocks(m *lease.Manager) {
+	s.locks = m
+}
+
+// Start launches the scheduling loop.
+func (s *Service) Start() {
+	s.wg.Add(1)
+	go s.loop()
+}
+
+// Stop ends the scheduling loop and waits until ctx is done for a running
+// report to finish.
+func (s *Service) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Service) loop() {
+	defer s.wg.Done()
+	lastErr := ""
+	for {
+		next := time.Now().Truncate(time.Minute).Add(time.Minute)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		cfg := s.cfg()
+		if !cfg.Enabled {
+			continue
+		}
+		sched, err := cron.Parse(cfg.Schedule)
+		if err != nil {
+			if err.Error() != lastErr {
+				log.Printf("[Gaps] invalid schedule %q: %v", cfg.Schedule, err)
+				lastErr = err.Error()
+			}
+			continue
+		}
+		lastErr = ""
+		if !sched.Match(next) {
+			continue
+		}
+		if err := s.trigger(0, "schedule", true, next); err != nil {
+			log.Printf("[Gaps] scheduled report skipped: %v", err)
+		}
+	}
+}
+
+// Trigger starts generating a report in the background over the last days
+// days (0 for gap_report.lookback_days). trigger labels the run ("schedule"
+// or "manual"); with email set the report is sent to gap_report.recipients.
+func (s *Service) Trigger(days int, trigger string, email bool) error {
+	return s.trigger(days, trigger, email, time.Time{})
+}
+
+// trigger is Trigger for the run scheduled at slot, zero for manual runs.
+func (s *Service) trigger(days int, trigger string, email bool, slot time.Time) error {
+	if days < 0 || days > 365 {
+		return fmt.Errorf("days must be between 1 and 365")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return ErrRunning
+	}
+	select {
+	case <-s.stop:
+		return errors.New("gap report service stopped")
+	default:
+	}
+	l, err := s.locks.Acquire("gap_report", slot)
+	if err != nil {
+		return err
+	}
+	s.running = true
+	s.wg.Add(1)
+	go s.run(days, trigger, email, l)
+	return nil
+}
+
+func (s *Service) run(days int, trigger string, email bool, l *lease.Lease) {
+	defer s.wg.Done()
+	defer l.Release()
+	var runErr error
+	defer func() {
+		if r := recover(); r != nil {
+			runErr = fmt.Errorf("panic: %v", r)
+			log.Printf("[Gaps] panic: %v", r)
+		}
+		s.mu.Lock()
+		s.running = false
+		s.lastErr = ""
+		if runErr != nil {
+			s.lastErr = runErr.Error()
+		}
+		s.mu.Unlock()
+	}()
+
+	cfg := s.cfg()
+	if days == 0 {
+		days = cfg.LookbackDays
+	}
+	end := time.Now().UTC()
+	report, err := s.Generate(context.Background(), end.AddDate(0, 0, -days), end, trigger)
+	if err != nil {
+		runErr = err
+		log.Printf("[Gaps] report failed: %v", err)
+		return
+	}
+	log.Printf("[Gaps] report %s: %d questions in %d topics", report.ID, report.QuestionCount, len(report.Topics))
+	if !email {
+		return
+	}
+	subject, body := FormatEmail(report)
+	var errs []error
+	for _, to := range cfg.Recipients {
+		if err := s.send(to, subject, body); err != nil {
+			errs = append(errs, fmt.Errorf("send to %s: %w", to, err))
+		}
+	}
+	if runErr = errors.Join(errs...); runErr != nil {
+		log.Printf("[Gaps] %v", runErr)
+	}
+}
+
+// question is a pending question included in a report.
+type question struct {
+	text      string
+	productID string
+	pending   bool
+}
+
+// cluster accumulates similar questions around a running mean centroid.
+type cluster struct {
+	centroid []float64
+	members  []int // indexes into the question list
+}
+
+// Generate builds and stores a report over the questions created in
+// [start, end). The embedding and LLM calls stop once ctx is done.
+func (s *Service) Generate(ctx context.Context, start, end time.Time, trigger string) (*Report, error) {
+	cfg := s.cfg()
+	questions, err := s.loadQuestions(start, end)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{
+		Trigger:       trigger,
+		PeriodStart:   start,
+		PeriodEnd:     end,
+		QuestionCount: len(questions),
+		Topics:        []Topic{},
+	}
+	if len(questions) > 0 {
+		es, ls := s.services()
+		texts := make([]string, len(questions))
+		for i, q := range questions {
+			texts[i] = q.text
+		}
+		vectors, err := es.EmbedBatch(ctx, texts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed questions: %w", err)
+		}
+		if len(vectors) != len(texts) {
+			return nil, fmt.Errorf("embedding returned %d vectors for %d questions", len(vectors), len(texts))
+		}
+		clusters := clusterVectors(vectors, cfg.SimilarityThreshold)
+		if len(clusters) > cfg.MaxTopics {
+			clusters = clusters[:cfg.MaxTopics]
+		}
+		for _, c := range clusters {
+			report.Topics = append(report.Topics, buildTopic(ctx, c, questions, ls))
+		}
+	}
+	if err := s.save(report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// loadQuestions returns the newest maxQuestions pending questions created in
+// [start, end), whether or not an admin has answered them since.
+func (s *Service) loadQuestions(start, end time.Time) ([]question, error) {
+	rows, err := s.readDB.Query(
+		`SELECT question, COALESCE(product_id, ''), status FROM pending_questions
+		 WHERE created_at >= ? AND created_at < ? ORDER BY created_at DESC LIMIT ?`,
+		start, end, maxQuestions,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load pending questions: %w", err)
+	}
+	defer rows.Close()
+	var questions []question
+	for rows.Next() {
+		var q question
+		var status string
+		if err := rows.Scan(&q.text, &q.productID, &status); err != nil {
+			return nil, err
+		}
+		q.text = strings.TrimSpace(q.text)
+		if q.text == "" {
+			continue
+		}
+		q.pending = status == "pending"
+		questions = append(questions, q)
+	}
+	return questions, rows.Err()
+}
+
+// clusterVectors groups vectors in a single pass: each joins the cluster
+// whose centroid is most similar if that similarity reaches threshold, and
+// starts a new cluster otherwise. Clusters are returned largest first.
+func clusterVectors(vectors [][]float64, threshold float64) []*cluster {
+	var clusters []*cluster
+	for i, v := range vectors {
+		var best *cluster
+		bestScore := threshold
+		for _, c := range clusters {
+			if score := vectorstore.CosineSimilarity(v, c.centroid); score >= bestScore {
+				best, bestScore = c, score
+			}
+		}
+		if best == nil {
+			clusters = append(clusters, &cluster{centroid: append([]float64(nil), v...), members: []int{i}})
+			continue
+		}
+		best.members = append(best.members, i)
+		n := float64(len(best.members))
+		for j := range best.centroid {
+			if j < len(v) {
+				best.centroid[j] += (v[j] - best.centroid[j]) / n
+			}
+		}
+	}
+	sort.SliceStable(clusters, func(i, j int) bool { return len(clusters[i].members) > len(clusters[j].members) })
+	return clusters
+}
+
+// buildTopic summarises a cluster and asks the LLM for its topic. If the
+// LLM fails, the cluster's first question serves as the label.
+func buildTopic(ctx context.Context, c *cluster, questions []question, ls llm.LLMService) Topic {
+	t := Topic{Count: len(c.members)}
+	seenProduct := make(map[string]bool)
+	for _, idx := range c.members {
+		q := questions[idx]
+		if q.pending {
+			t.Pending++
+		}
+		if q.productID != "" && !seenProduct[q.productID] {
+			seenProduct[q.productID] = true
+			t.ProductIDs = append(t.ProductIDs, q.productID)
+		}
+		if len(t.Questions) < sampleQuestions {
+			t.Questions = append(t.Questions, q.text)
+		}
+	}
+
+	t.Topic = truncate(t.Questions[0], 60)
+	label, err := ls.Generate(ctx,
+		"你是一个技术文档分析助手。以下是用户提出但知识库无法回答的一组相似问题。"+
+			"请用一个简短的短语（不超过20个字）概括它们共同涉及、文档中缺失的主题。"+
+			"使用与问题相同的语言，只输出主题本身，不要添加任何解释或标点。",
+		t.Questions,
+		"这些问题的共同主题是什么？",
+	)
+	if err != nil {
+		log.Printf("[Gaps] topic labelling failed: %v", err)
+		return t
+	}
+	if label = strings.Trim(strings.TrimSpace(label), "\"'“”「」。."); label != "" {
+		t.Topic = truncate(label, 100)
+	}
+	return t
+}
+
+// save stores a report and fills in its ID and creation time.
+func (s *Service) save(r *Report) error {
+	id, err := generateID()
+	if err != nil {
+		return err
+	}
+	topics, _ := json.Marshal(r.Topics)
+	now := time.Now().UTC()
+	_, err = s.writeDB.Exec(
+		`INSERT INTO gap_reports (id, trigger_source, period_start, period_end, question_count, topics, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		id, r.Trigger, r.PeriodStart, r.PeriodEnd, r.QuestionCount, string(topics), now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save gap report: %w", err)
+	}
+	r.ID, r.CreatedAt = id, now
+	return nil
+}
+
+// List returns the newest limit reports without their topics.
+func (s *Service) List(limit int) ([]Report, error) {
+	rows, err := s.readDB.Query(
+		`SELECT id, trigger_source, period_start, period_end, question_count, created_at
+		 FROM gap_reports ORDER BY created_at DESC LIMIT ?`, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list gap reports: %w", err)
+	}
+	defer rows.Close()
+	reports := []Report{}
+	for rows.Next() {
+		var r Report
+		if err := rows.Scan(&r.ID, &r.Trigger, &r.PeriodStart, &r.PeriodEnd, &r.QuestionCount, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, rows.Err()
+}
+
+// Get returns a report with its topics.
+func (s *Service) Get(id string) (*Report, error) {
+	var r Report
+	var topics string
+	err := s.readDB.QueryRow(
+		`SELECT id, trigger_source, period_start, period_end, question_count, topics, created_at
+		 FROM gap_reports WHERE id = ?`, id,
+	).Scan(&r.ID, &r.Trigger, &r.PeriodStart, &r.PeriodEnd, &r.QuestionCount, &topics, &r.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(topics), &r.Topics); err != nil {
+		return nil, fmt.Errorf("invalid topics of gap report %s: %w", id, err)
+	}
+	return &r, nil
+}
+
+// Delete removes a report.
+func (s *Service) Delete(id string) error {
+	res, err := s.writeDB.Exec(`DELETE FROM gap_reports WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete gap report: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Status returns the generator state and the most recent reports.
+func (s *Service) Status() Status {
+	cfg := s.cfg()
+	st := Status{Enabled: cfg.Enabled, Schedule: cfg.Schedule}
+	if cfg.Enabled {
+		if sched, err := cron.Parse(cfg.Schedule); err == nil {
+			st.NextRun = sched.Next(time.Now())
+		}
+	}
+	s.mu.Lock()
+	st.Running = s.running
+	st.LastError = s.lastErr
+	s.mu.Unlock()
+
+	st.Reports, _ = s.List(20)
+	if st.Reports == nil {
+		st.Reports = []Report{}
+	}
+	return st
+}
+
+// FormatEmail renders a report as a plain-text email.
+func FormatEmail(r *Report) (subject, body string) {
+	subject = fmt.Sprintf("知识缺口周报 %s – %s", r.PeriodStart.Local().Format("2006-01-02"), r.PeriodEnd.Local().Format("2006-01-02"))
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "统计周期：%s 至 %s\n", r.PeriodStart.Local().Format("2006-01-02 15:04"), r.PeriodEnd.Local().Format("2006-01-02 15:04"))
+	fmt.Fprintf(&sb, "无法自动回答的问题：%d 个\n\n", r.QuestionCount)
+	if len(r.Topics) == 0 {
+		sb.WriteString("本周期内没有发现知识缺口。\n")
+		return subject, sb.String()
+	}
+	sb.WriteString("以下主题在知识库中缺少文档，按提问次数排序：\n")
+	for i, t := range r.Topics {
+		fmt.Fprintf(&sb, "\n%d. %s（%d 个问题，%d 个仍待处理）\n", i+1, t.Topic, t.Count, t.Pending)
+		for _, q := range t.Questions {
+			fmt.Fprintf(&sb, "   - %s\n", truncate(q, 200))
+		}
+	}
+	return subject, sb.String()
+}
+
+// truncate shortens s to at most maxLen runes, appending "..." when cut.
+func truncate(s string, maxLen int) string {
+	runes := []rune(s)
+	if len(runes) <= maxLen {
+		return s
+	}
+	return string(runes[:maxLen]) + "..."
+}
+
+// generateID creates a random hex string for use as a unique identifier.
+func generateID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,141 @@
+package grpcapi
+
+import (
+	"time"
+
+	"askflow/internal/document"
+	"askflow/internal/query"
+)
+
+// The messages of askflow.proto. Requests are only decoded and responses
+// only encoded, so each type implements just the direction it needs.
+
+type queryRequest struct {
+	Question  string
+	ProductID string
+	ImageData string
+}
+
+func (m *queryRequest) unmarshal(b []byte) error {
+	return decode(b, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Question = f.string()
+		case 2:
+			m.ProductID = f.string()
+		case 3:
+			m.ImageData = f.string()
+		}
+		return nil
+	})
+}
+
+type queryResponse struct {
+	resp *query.QueryResponse
+}
+
+func (m queryResponse) marshal() []byte {
+	var e encoder
+	e.string(1, m.resp.Answer)
+	for _, s := range m.resp.Sources {
+		e.message(2, source(s))
+	}
+	e.bool(3, m.resp.IsPending)
+	e.bool(4, m.resp.AllowDownload)
+	e.string(5, m.resp.Message)
+	e.string(6, m.resp.QueryID)
+	e.string(7, m.resp.AnswerHTML)
+	e.double(8, m.resp.Confidence)
+	e.bool(9, m.resp.Unverified)
+	return e.buf
+}
+
+type source query.SourceRef
+
+func (m source) marshal() []byte {
+	var e encoder
+	e.string(1, m.DocumentID)
+	e.string(2, m.DocumentName)
+	e.string(3, m.DocumentType)
+	e.int64(4, int64(m.ChunkIndex))
+	e.string(5, m.Snippet)
+	e.string(6, m.ImageURL)
+	e.double(7, m.StartTime)
+	e.double(8, m.EndTime)
+	e.int64(9, int64(m.Page))
+	e.int64(10, int64(m.Slide))
+	return e.buf
+}
+
+// uploadDocumentRequest is one message of the UploadDocument stream: either
+// the metadata (first message) or a piece of the file.
+type uploadDocumentRequest struct {
+	Metadata *uploadMetadata
+	Chunk    []byte
+}
+
+type uploadMetadata struct {
+	FileName  string
+	ProductID string
+}
+
+func (m *uploadDocumentRequest) unmarshal(b []byte) error {
+	return decode(b, func(f field) error {
+		switch f.num {
+		case 1:
+			m.Metadata = &uploadMetadata{}
+			return decode(f.data, func(f field) error {
+				switch f.num {
+				case 1:
+					m.Metadata.FileName = f.string()
+				case 2:
+					m.Metadata.ProductID = f.string()
+				}
+				return nil
+			})
+		case 2:
+			// Aliases the message buffer, see reader.next
+			m.Chunk = f.data
+		}
+		return nil
+	})
+}
+
+type documentMessage document.DocumentInfo
+
+func (m documentMessage) marshal() []byte {
+	var e encoder
+	e.string(1, m.ID)
+	e.string(2, m.Name)
+	e.string(3, m.Type)
+	e.string(4, m.Status)
+	e.string(5, m.Error)
+	if !m.CreatedAt.IsZero() {
+		e.string(6, m.CreatedAt.UTC().Format(time.RFC3339))
+	}
+	e.string(7, m.ProductID)
+	return e.buf
+}
+
+type listDocumentsRequest struct {
+	ProductID string
+}
+
+func (m *listDocumentsRequest) unmarshal(b []byte) error {
+	return decode(b, func(f field) error {
+		if f.num == 1 {
+			m.ProductID = f.string()
+		}
+		return nil
+	})
+}
+
+type listDocumentsResponse []document.DocumentInfo
+
+func (m listDocumentsResponse) marshal() []byte {
+	var e encoder
+	for _, d := range m {
+		e.message(1, documentMessage(d))
+	}
+	return e.buf
+}
@@ -0,0 +1,203 @@
+// Package grpcapi serves the query and document services over gRPC, as
+// described by askflow.proto. The protocol is implemented directly on the
+// standard library's HTTP/2 server: length-prefixed protobuf messages in the
+// request and response bodies and the call status in the grpc-status and
+// grpc-message trailers.
+package grpcapi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"askflow/internal/handler"
+	"askflow/internal/i18n"
+)
+
+// Service is the fully qualified name of the service in askflow.proto.
+const Service = "askflow.v1.Askflow"
+
+// maxMessageSize caps a single message, matching the default of the gRPC
+// libraries. File uploads are streamed in smaller chunks.
+const maxMessageSize = 4 << 20
+
+// Status codes of the gRPC protocol used by this service.
+const (
+	codeOK                = 0
+	codeInvalidArgument   = 3
+	codeNotFound          = 5
+	codePermissionDenied  = 7
+	codeResourceExhausted = 8
+	codeUnimplemented     = 12
+	codeInternal          = 13
+	codeUnavailable       = 14
+	codeUnauthenticated   = 16
+)
+
+// statusError ends a call with a gRPC status. The message is translated to
+// the caller's language when the status is written.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("grpc status %d: %s", e.code, e.msg)
+}
+
+func status(code int, msg string) error {
+	return &statusError{code: code, msg: msg}
+}
+
+// method handles one RPC. It reads its request message(s) from in and
+// returns the response message.
+type method func(r *http.Request, in *reader) (marshaler, error)
+
+// Server is an http.Handler serving the Askflow gRPC service. It must be
+// mounted on an HTTP/2 server (TLS or h2c).
+type Server struct {
+	methods map[string]method
+}
+
+// NewServer returns a Server answering with the services of app.
+func NewServer(app *handler.App) *Server {
+	svc := &service{app: app}
+	return &Server{
+		methods: map[string]method{
+			"Query":          svc.query,
+			"UploadDocument": svc.uploadDocument,
+			"ListDocuments":  svc.listDocuments,
+		},
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ProtoMajor != 2 {
+		http.Error(w, "gRPC requires HTTP/2 POST requests", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	switch r.Header.Get("Content-Type") {
+	case "application/grpc", "application/grpc+proto":
+	default:
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "application/grpc")
+	h.Set("Grpc-Accept-Encoding", "identity")
+	h.Add("Trailer", "Grpc-Status")
+	h.Add("Trailer", "Grpc-Message")
+
+	var reply marshaler
+	err := status(codeUnimplemented, "unknown method "+r.URL.Path)
+	if name, ok := strings.CutPrefix(r.URL.Path, "/"+Service+"/"); ok {
+		if m := s.methods[name]; m != nil {
+			reply, err = m(r, &reader{r: r.Body})
+		}
+	}
+	if err == nil {
+		if err = writeMessage(w, reply.marshal()); err != nil {
+			// The client is gone; there is nobody left to tell
+			return
+		}
+	}
+
+	code, msg := codeOK, ""
+	if err != nil {
+		var se *statusError
+		if errors.As(err, &se) {
+			code, msg = se.code, se.msg
+		} else {
+			log.Printf("[gRPC] %s error: %v", r.URL.Path, err)
+			code, msg = codeInternal, "internal error"
+		}
+		lang := i18n.Match(r.Header.Get("Accept-Language"))
+		if lang == "" {
+			lang = i18n.Default
+		}
+		msg = i18n.T(lang, msg)
+	}
+	h.Set("Grpc-Status", strconv.Itoa(code))
+	h.Set("Grpc-Message", encodeMessage(msg))
+}
+
+// reader reads the length-prefixed messages of a request body.
+type reader struct {
+	r   io.Reader
+	buf []byte
+}
+
+// next returns the next message, or io.EOF after the last one. The returned
+// slice is only valid until the following call.
+func (rd *reader) next() ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(rd.r, prefix[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, status(codeInvalidArgument, "请求消息不完整")
+	}
+	if prefix[0] != 0 {
+		return nil, status(codeUnimplemented, "不支持压缩的消息")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxMessageSize {
+		return nil, status(codeResourceExhausted, fmt.Sprintf("消息大小超过限制 (%dMB)", maxMessageSize>>20))
+	}
+	if cap(rd.buf) < int(size) {
+		rd.buf = make([]byte, size)
+	}
+	rd.buf = rd.buf[:size]
+	if _, err := io.ReadFull(rd.r, rd.buf); err != nil {
+		return nil, status(codeInvalidArgument, "请求消息不完整")
+	}
+	return rd.buf, nil
+}
+
+// unary reads the single request message of a unary call into m.
+func (rd *reader) unary(m interface{ unmarshal([]byte) error }) error {
+	b, err := rd.next()
+	if errors.Is(err, io.EOF) {
+		return status(codeInvalidArgument, "缺少请求消息")
+	}
+	if err != nil {
+		return err
+	}
+	if err := m.unmarshal(b); err != nil {
+		return status(codeInvalidArgument, "请求消息格式错误")
+	}
+	return nil
+}
+
+// writeMessage writes one length-prefixed, uncompressed message.
+func writeMessage(w http.ResponseWriter, b []byte) error {
+	frame := make([]byte, 5, 5+len(b))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(b)))
+	if _, err := w.Write(append(frame, b...)); err != nil {
+		return err
+	}
+	http.NewResponseController(w).Flush()
+	return nil
+}
+
+// encodeMessage percent-encodes a status message as the grpc-message
+// trailer requires: printable ASCII except '%' is kept, everything else
+// (including UTF-8 text) is escaped byte by byte.
+func encodeMessage(msg string) string {
+	var sb strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= 0x20 && c <= 0x7e && c != '%' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
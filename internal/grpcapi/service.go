@@ -0,0 +1,203 @@
+package grpcapi
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"askflow/internal/document"
+	"askflow/internal/embedding"
+	"askflow/internal/errlog"
+	"askflow/internal/handler"
+	"askflow/internal/query"
+	"askflow/internal/rbac"
+	"askflow/internal/tenant"
+	"askflow/internal/usage"
+)
+
+// service implements the RPCs on top of the same App services as the HTTP
+// API, with the same checks. The gRPC listener has no workspace routing, so
+// every call acts on the default workspace.
+type service struct {
+	app *handler.App
+}
+
+func (s *service) query(r *http.Request, in *reader) (marshaler, error) {
+	userID, err := handler.GetUserSession(s.app, r)
+	if err != nil {
+		return nil, status(codeUnauthenticated, err.Error())
+	}
+	var m queryRequest
+	if err := in.unary(&m); err != nil {
+		return nil, err
+	}
+	question := strings.TrimSpace(m.Question)
+	if question == "" {
+		return nil, status(codeInvalidArgument, "question is required")
+	}
+	if len(question) > 10000 {
+		return nil, status(codeInvalidArgument, "question too long (max 10000 characters)")
+	}
+	req := query.QueryRequest{
+		Question:  question,
+		UserID:    userID,
+		ProductID: m.ProductID,
+		ImageData: m.ImageData,
+	}
+	if req.ProductID != "" {
+		if !handler.IsValidOptionalID(req.ProductID) {
+			return nil, status(codeInvalidArgument, "invalid product_id")
+		}
+		if !s.app.ProductInWorkspace("", req.ProductID) {
+			return nil, status(codeNotFound, "产品不存在")
+		}
+	} else if firstID, pErr := s.app.GetFirstProductID(""); pErr == nil {
+		req.ProductID = firstID
+	}
+
+	err = s.app.ScopeQuery("", &req)
+	var resp *query.QueryResponse
+	if err == nil {
+		resp, err = s.app.MeteredQuery(r.Context(), req)
+	}
+	var tqe *tenant.QuotaError
+	var uqe *usage.QuotaError
+	var ie *query.ImageError
+	switch {
+	case errors.As(err, &ie):
+		return nil, status(codeInvalidArgument, ie.Error())
+	case errors.As(err, &tqe):
+		return nil, status(codeResourceExhausted, "今日问答次数已达上限")
+	case errors.As(err, &uqe) && uqe.Metric == usage.MetricTokens:
+		return nil, status(codeResourceExhausted, "本月用量已达上限")
+	case errors.As(err, &uqe):
+		return nil, status(codeResourceExhausted, "本月问答次数已达上限")
+	case errors.Is(err, embedding.ErrQueueFull), errors.Is(err, query.ErrOverloaded):
+		return nil, status(codeUnavailable, err.Error())
+	case err != nil:
+		log.Printf("[gRPC] query error: %v", err)
+		errlog.Logf("[Query] query processing failed: %v", err)
+		return nil, status(codeInternal, "查询处理失败，请稍后重试")
+	}
+	resp.DebugInfo = nil
+	if req.ProductID != "" {
+		if p, pErr := s.app.GetProduct(req.ProductID); pErr == nil && p != nil {
+			resp.AllowDownload = p.AllowDownload
+		}
+	}
+	return queryResponse{resp}, nil
+}
+
+func (s *service) uploadDocument(r *http.Request, in *reader) (marshaler, error) {
+	userID, role, err := handler.GetAdminSession(s.app, r)
+	if err != nil {
+		return nil, adminSessionStatus(err)
+	}
+
+	var m uploadDocumentRequest
+	b, err := in.next()
+	if errors.Is(err, io.EOF) {
+		return nil, status(codeInvalidArgument, "缺少请求消息")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := m.unmarshal(b); err != nil {
+		return nil, status(codeInvalidArgument, "请求消息格式错误")
+	}
+	meta := m.Metadata
+	if meta == nil {
+		return nil, status(codeInvalidArgument, "上传的第一条消息必须包含文件信息")
+	}
+	if strings.TrimSpace(meta.FileName) == "" {
+		return nil, status(codeInvalidArgument, "缺少文件名")
+	}
+	if !handler.IsValidOptionalID(meta.ProductID) {
+		return nil, status(codeInvalidArgument, "invalid product_id")
+	}
+	// Check permissions before accepting a possibly large file
+	if !s.app.HasAdminPermission(userID, role, rbac.PermManageDocs, meta.ProductID) {
+		return nil, status(codePermissionDenied, "无权管理该产品的文档")
+	}
+	if !s.app.ProductInWorkspace("", meta.ProductID) {
+		return nil, status(codeNotFound, "产品不存在")
+	}
+
+	maxUploadSizeMB := s.app.MaxUploadSizeMB()
+	maxSize := maxUploadSizeMB << 20
+	var fileData []byte
+	for {
+		b, err := in.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var chunk uploadDocumentRequest
+		if err := chunk.unmarshal(b); err != nil {
+			return nil, status(codeInvalidArgument, "请求消息格式错误")
+		}
+		if len(fileData)+len(chunk.Chunk) > maxSize {
+			return nil, status(codeInvalidArgument, fmt.Sprintf("文件大小超过限制 (%dMB)", maxUploadSizeMB))
+		}
+		fileData = append(fileData, chunk.Chunk...)
+	}
+	if len(fileData) == 0 {
+		return nil, status(codeInvalidArgument, "上传的文件为空")
+	}
+
+	fileType, err := handler.SniffFileType(meta.FileName, fileData)
+	if err != nil {
+		return nil, status(codeInvalidArgument, err.Error())
+	}
+	doc, err := s.app.UploadFile(r.Context(), document.UploadFileRequest{
+		FileName:  meta.FileName,
+		FileData:  fileData,
+		FileType:  fileType,
+		ProductID: meta.ProductID,
+	})
+	if errors.Is(err, embedding.ErrQueueFull) {
+		return nil, status(codeUnavailable, err.Error())
+	}
+	if err != nil {
+		errlog.Logf("[gRPC] file upload rejected file=%q type=%s: %v", meta.FileName, fileType, err)
+		return nil, status(codeInvalidArgument, err.Error())
+	}
+	return documentMessage(*doc), nil
+}
+
+func (s *service) listDocuments(r *http.Request, in *reader) (marshaler, error) {
+	if _, _, err := handler.GetAdminSession(s.app, r); err != nil {
+		return nil, adminSessionStatus(err)
+	}
+	var m listDocumentsRequest
+	if err := in.unary(&m); err != nil {
+		return nil, err
+	}
+	if !handler.IsValidOptionalID(m.ProductID) {
+		return nil, status(codeInvalidArgument, "invalid product_id")
+	}
+	if m.ProductID != "" && !s.app.ProductInWorkspace("", m.ProductID) {
+		return nil, status(codeNotFound, "产品不存在")
+	}
+	docs, err := s.app.ListDocumentsInTenant("", m.ProductID)
+	if err != nil {
+		log.Printf("[gRPC] list documents error: %v", err)
+		return nil, status(codeInternal, "获取文档列表失败")
+	}
+	return listDocumentsResponse(docs), nil
+}
+
+// adminSessionStatus maps an admin session error to its gRPC status, like
+// WriteAdminSessionError does for HTTP.
+func adminSessionStatus(err error) error {
+	var fe *handler.ForbiddenError
+	if errors.As(err, &fe) {
+		return status(codePermissionDenied, fe.Message)
+	}
+	return status(codeUnauthenticated, err.Error())
+}
@@ -0,0 +1,147 @@
+package grpcapi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Protocol Buffers wire types used by the messages of this package.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("protobuf: truncated message")
+
+// encoder appends proto3 fields to a buffer. Fields holding the zero value
+// are omitted, as proto3 encoders do.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) tag(field, wireType int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(field)<<3|uint64(wireType))
+}
+
+func (e *encoder) string(field int, v string) {
+	if v == "" {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) bytes(field int, v []byte) {
+	if len(v) == 0 {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) int64(field int, v int64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireVarint)
+	e.buf = binary.AppendUvarint(e.buf, uint64(v))
+}
+
+func (e *encoder) bool(field int, v bool) {
+	if !v {
+		return
+	}
+	e.tag(field, wireVarint)
+	e.buf = append(e.buf, 1)
+}
+
+func (e *encoder) double(field int, v float64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireFixed64)
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(v))
+}
+
+// message appends an embedded message. Unlike scalars it is written even
+// when empty, so repeated messages keep their count.
+func (e *encoder) message(field int, m marshaler) {
+	e.tag(field, wireBytes)
+	inner := m.marshal()
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(inner)))
+	e.buf = append(e.buf, inner...)
+}
+
+type marshaler interface {
+	marshal() []byte
+}
+
+// field is one decoded field: the varint or fixed value for scalar wire
+// types, the payload for length-delimited ones.
+type field struct {
+	num      int
+	wireType int
+	varint   uint64
+	data     []byte
+}
+
+func (f field) string() string  { return string(f.data) }
+func (f field) int64() int64    { return int64(f.varint) }
+func (f field) bool() bool      { return f.varint != 0 }
+func (f field) double() float64 { return math.Float64frombits(f.varint) }
+
+// decode calls fn for each field of a serialized message. Unknown fields
+// are passed to fn as well and simply ignored by the callers, so newer
+// clients can send fields this server does not know.
+func decode(b []byte, fn func(field) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errTruncated
+		}
+		b = b[n:]
+		f := field{num: int(key >> 3), wireType: int(key & 7)}
+		if f.num <= 0 {
+			return fmt.Errorf("protobuf: invalid field number %d", f.num)
+		}
+		switch f.wireType {
+		case wireVarint:
+			f.varint, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errTruncated
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return errTruncated
+			}
+			f.varint = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return errTruncated
+			}
+			f.varint = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return errTruncated
+			}
+			f.data = b[n : n+int(size)]
+			b = b[n+int(size):]
+		default:
+			return fmt.Errorf("protobuf: unsupported wire type %d", f.wireType)
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"askflow/internal/abuse"
+	"askflow/internal/middleware"
+)
+
+// BlockAbuse returns a middleware that refuses requests from banned
+// networks, denied AS numbers and blocked countries with 403.
+func BlockAbuse(app *App) middleware.Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var blocked *abuse.BlockedError
+			if err := app.abuseGuard.Check(middleware.GetClientIP(r)); errors.As(err, &blocked) {
+				WriteError(w, http.StatusForbidden, blocked.Reason)
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// RecordRateLimited returns a rate limiter reject hook that counts the
+// client's network in the offender report.
+func RecordRateLimited(app *App) func(r *http.Request) {
+	return func(r *http.Request) {
+		app.abuseGuard.RecordRateLimited(middleware.GetClientIP(r))
+	}
+}
+
+// ListNetworkBans returns the active network bans.
+func (a *App) ListNetworkBans() ([]abuse.Ban, error) {
+	return a.abuseGuard.ListBans()
+}
+
+// AddNetworkBan bans a CIDR range or single address for the given number of days.
+func (a *App) AddNetworkBan(network, reason, createdBy string, days int) (*abuse.Ban, error) {
+	return a.abuseGuard.AddBan(network, reason, createdBy, time.Duration(days)*24*time.Hour)
+}
+
+// RemoveNetworkBan lifts a network ban.
+func (a *App) RemoveNetworkBan(id int64) error {
+	return a.abuseGuard.RemoveBan(id)
+}
+
+// SetASNBlocked adds an AS number to or removes it from abuse.deny_asns.
+func (a *App) SetASNBlocked(asn string, blocked bool) error {
+	cfg := a.configManager.Get()
+	if cfg == nil {
+		return errors.New("config not loaded")
+	}
+	asn = "AS" + strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(asn)), "AS")
+	list := slices.DeleteFunc(slices.Clone(cfg.Abuse.DenyASNs), func(s string) bool { return s == asn })
+	if blocked {
+		list = append(list, asn)
+	}
+	items := make([]interface{}, len(list))
+	for i, s := range list {
+		items[i] = s
+	}
+	return a.configManager.Update(map[string]interface{}{"abuse.deny_asns": items})
+}
+
+// TopOffendingNetworks reports the networks (or AS numbers) with the most
+// failed logins in the last days days and rate-limited requests in the last
+// 24 hours.
+func (a *App) TopOffendingNetworks(days int, byASN bool, limit int) ([]abuse.NetworkStat, error) {
+	return a.abuseGuard.TopNetworks(time.Now().AddDate(0, 0, -days), byASN, limit)
+}
+
+// HandleAdminAbuseNetworks returns the top offending networks. Query
+// parameters: days (login failures to count, default 7), by ("network" or
+// "asn") and limit (default 50). Super admin only.
+func HandleAdminAbuseNetworks(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		_, role, err := GetAdminSession(app, r)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		if role != "super_admin" {
+			WriteError(w, http.StatusForbidden, "仅超级管理员可管理登录限制")
+			return
+		}
+		q := r.URL.Query()
+		days, limit := 7, 50
+		if v := q.Get("days"); v != "" {
+			if days, err = strconv.Atoi(v); err != nil || days < 1 || days > 30 {
+				WriteError(w, http.StatusBadRequest, "days must be between 1 and 30")
+				return
+			}
+		}
+		if v := q.Get("limit"); v != "" {
+			if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > 500 {
+				WriteError(w, http.StatusBadRequest, "limit must be between 1 and 500")
+				return
+			}
+		}
+		by := q.Get("by")
+		if by != "" && by != "network" && by != "asn" {
+			WriteError(w, http.StatusBadRequest, "by must be network or asn")
+			return
+		}
+		stats, err := app.TopOffendingNetworks(days, by == "asn", limit)
+		if errors.Is(err, abuse.ErrNoASNDatabase) {
+			WriteError(w, http.StatusBadRequest, "未配置 ASN 数据库或仍在加载中")
+			return
+		}
+		if err != nil {
+			log.Printf("[Abuse] offender report error: %v", err)
+			WriteError(w, http.StatusInternalServerError, "failed to load offending networks")
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]interface{}{"networks": stats})
+	}
+}
+
+// HandleAdminAbuseBans manages network blocks: GET lists the active CIDR
+// bans and blocked AS numbers, POST {network, reason, days} bans a CIDR
+// range or address and POST {asn} blocks an AS number, DELETE ?id= lifts a
+// ban and DELETE ?asn= unblocks an AS number. Super admin only.
+func HandleAdminAbuseBans(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, role, err := GetAdminSession(app, r)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		if role != "super_admin" {
+			WriteError(w, http.StatusForbidden, "仅超级管理员可管理登录限制")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			bans, err := app.ListNetworkBans()
+			if err != nil {
+				log.Printf("[Abuse] list bans error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "failed to list network bans")
+				return
+			}
+			if bans == nil {
+				bans = []abuse.Ban{}
+			}
+			asns := []string{}
+			if cfg := app.configManager.Get(); cfg != nil && cfg.Abuse.DenyASNs != nil {
+				asns = cfg.Abuse.DenyASNs
+			}
+			WriteJSON(w, http.StatusOK, map[string]interface{}{"bans": bans, "asns": asns})
+		case http.MethodPost:
+			var req struct {
+				Network string `json:"network"`
+				ASN     string `json:"asn"`
+				Reason  string `json:"reason"`
+				Days    int    `json:"days"`
+			}
+			if err := ReadJSONBody(r, &req); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			if req.ASN != "" {
+				if err := app.SetASNBlocked(req.ASN, true); err != nil {
+					WriteError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+				WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+				return
+			}
+			prefix, err := abuse.ParseNetwork(strings.TrimSpace(req.Network))
+			if errors.Is(err, abuse.ErrNetworkTooLarge) {
+				WriteError(w, http.StatusBadRequest, "网段范围过大")
+				return
+			}
+			if err != nil {
+				WriteError(w, http.StatusBadRequest, "请输入有效的IP或CIDR网段")
+				return
+			}
+			// Keep admins from locking themselves out
+			if addr, err := netip.ParseAddr(middleware.GetClientIP(r)); err == nil && prefix.Contains(addr.Unmap()) {
+				WriteError(w, http.StatusBadRequest, "不能封禁当前所在的网络")
+				return
+			}
+			if req.Days <= 0 {
+				req.Days = 1
+			}
+			if req.Days > 3650 {
+				req.Days = 3650
+			}
+			if req.Reason == "" {
+				req.Reason = "管理员手动封禁"
+			}
+			ban, err := app.AddNetworkBan(prefix.String(), req.Reason, userID, req.Days)
+			if err != nil {
+				log.Printf("[Abuse] add ban error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "failed to add network ban")
+				return
+			}
+			WriteJSON(w, http.StatusOK, ban)
+		case http.MethodDelete:
+			q := r.URL.Query()
+			if asn := q.Get("asn"); asn != "" {
+				if err := app.SetASNBlocked(asn, false); err != nil {
+					WriteError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+				WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+				return
+			}
+			id, err := strconv.ParseInt(q.Get("id"), 10, 64)
+			if err != nil {
+				WriteError(w, http.StatusBadRequest, "invalid id")
+				return
+			}
+			if err := app.RemoveNetworkBan(id); err != nil {
+				if errors.Is(err, abuse.ErrBanNotFound) {
+					WriteError(w, http.StatusNotFound, "封禁记录不存在")
+					return
+				}
+				log.Printf("[Abuse] remove ban error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "failed to remove network ban")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
@@ -8,11 +8,13 @@ import (
 	"time"
 
 	"askflow/internal/auth"
+	"askflow/internal/rbac"
 )
 
 // --- Admin sub-account handlers ---
 
-// HandleAdminUsers handles listing and creating admin sub-accounts.
+// HandleAdminUsers handles listing and creating admin sub-accounts. A POST
+// with an email and no password invites the new admin by email instead.
 func HandleAdminUsers(app *App) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		_, role, err := GetAdminSession(app, r)
@@ -27,7 +29,7 @@ func HandleAdminUsers(app *App) http.HandlerFunc {
 				WriteError(w, http.StatusForbidden, "仅超级管理员可管理用户")
 				return
 			}
-			users, err := app.ListAdminUsers()
+			users, err := app.ListAdminUsers(requestTenantID(r))
 			if err != nil {
 				log.Printf("[Admin] list users error: %v", err)
 				WriteError(w, http.StatusInternalServerError, "获取用户列表失败")
@@ -46,16 +48,31 @@ func HandleAdminUsers(app *App) http.HandlerFunc {
 			var req struct {
 				Username    string   `json:"username"`
 				Password    string   `json:"password"`
+				Email       string   `json:"email"`
 				Role        string   `json:"role"`
 				ProductIDs  []string `json:"product_ids"`
 				Permissions []string `json:"permissions"`
 			}
 			if err := ReadJSONBody(r, &req); err != nil {
-				WriteError(w, http.StatusBadRequest, "invalid request body")
+				writeBodyError(w, err)
 				return
 			}
-			user, err := app.CreateAdminUser(req.Username, req.Password, req.Role, req.Permissions)
+			for _, pid := range req.ProductIDs {
+				if !app.productInTenant(r, pid) {
+					WriteError(w, http.StatusBadRequest, "产品不存在")
+					return
+				}
+			}
+			var user *AdminUserInfo
+			if req.Password == "" && req.Email != "" {
+				user, err = app.InviteAdminUser(requestTenantID(r), req.Username, req.Email, req.Role, app.publicURL(r))
+			} else {
+				user, err = app.CreateAdminUser(requestTenantID(r), req.Username, req.Password, req.Role, req.Permissions)
+			}
 			if err != nil {
+				if writeQuotaError(w, err) {
+					return
+				}
 				WriteError(w, http.StatusBadRequest, err.Error())
 				return
 			}
@@ -74,7 +91,10 @@ func HandleAdminUsers(app *App) http.HandlerFunc {
 	}
 }
 
-// HandleAdminUserByID handles deleting an admin sub-account by ID.
+// HandleAdminUserByID handles PUT (change role, disable or re-enable) and
+// DELETE for an admin sub-account, GET/PUT /api/admin/users/{id}/grants for
+// its per-product role grants and POST /api/admin/users/{id}/invite to
+// resend its invitation.
 func HandleAdminUserByID(app *App) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		_, role, err := GetAdminSession(app, r)
@@ -92,12 +112,73 @@ func HandleAdminUserByID(app *App) http.HandlerFunc {
 			WriteError(w, http.StatusBadRequest, "missing user ID")
 			return
 		}
+
+		// Handle /api/admin/users/{id}/grants
+		if userID, ok := strings.CutSuffix(id, "/grants"); ok {
+			if IsValidHexID(userID) && !app.adminUserInTenant(userID, requestTenantID(r)) {
+				WriteError(w, http.StatusNotFound, "用户不存在")
+				return
+			}
+			handleAdminUserGrants(app, w, r, userID)
+			return
+		}
+
+		// Handle /api/admin/users/{id}/invite
+		if userID, ok := strings.CutSuffix(id, "/invite"); ok {
+			if r.Method != http.MethodPost {
+				WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			if !IsValidHexID(userID) || !app.adminUserInTenant(userID, requestTenantID(r)) {
+				WriteError(w, http.StatusNotFound, "用户不存在")
+				return
+			}
+			if err := app.SendAdminInvite(userID, app.publicURL(r)); err != nil {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+			return
+		}
+
 		// Validate ID format
 		if !IsValidHexID(id) {
 			WriteError(w, http.StatusBadRequest, "invalid user ID")
 			return
 		}
+		if !app.adminUserInTenant(id, requestTenantID(r)) {
+			WriteError(w, http.StatusNotFound, "用户不存在")
+			return
+		}
 
+		if r.Method == http.MethodPut {
+			var req struct {
+				Role     string `json:"role"`
+				Disabled *bool  `json:"disabled"`
+			}
+			if err := ReadJSONBody(r, &req); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			if req.Role == "" && req.Disabled == nil {
+				WriteError(w, http.StatusBadRequest, "请指定角色或停用状态")
+				return
+			}
+			if req.Role != "" {
+				if err := app.SetAdminUserRole(id, req.Role); err != nil {
+					WriteError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+			}
+			if req.Disabled != nil {
+				if err := app.SetAdminUserDisabled(id, *req.Disabled); err != nil {
+					WriteError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+			return
+		}
 		if r.Method != http.MethodDelete {
 			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
 			return
@@ -113,6 +194,186 @@ func HandleAdminUserByID(app *App) http.HandlerFunc {
 	}
 }
 
+// HandleAcceptAdminInvite handles POST /api/admin/invite/accept — sets the
+// password of an invited admin sub-account using the emailed token.
+func HandleAcceptAdminInvite(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var req struct {
+			Token    string `json:"token"`
+			Password string `json:"password"`
+		}
+		if err := ReadJSONBody(r, &req); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if !isValidSignedToken(req.Token) {
+			WriteError(w, http.StatusBadRequest, "无效的邀请链接")
+			return
+		}
+		if err := app.AcceptAdminInvite(req.Token, req.Password); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok", "message": tr(w, "密码设置成功，请登录")})
+	}
+}
+
+// handleAdminUserGrants handles GET/PUT /api/admin/users/{id}/grants.
+// The caller has already been verified as super_admin.
+func handleAdminUserGrants(app *App, w http.ResponseWriter, r *http.Request, id string) {
+	if !IsValidHexID(id) {
+		WriteError(w, http.StatusBadRequest, "invalid user ID")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		grants, err := app.GetAdminUserGrants(id)
+		if err != nil {
+			log.Printf("[Admin] list grants error for %s: %v", id, err)
+			WriteError(w, http.StatusInternalServerError, "获取产品角色失败")
+			return
+		}
+		if grants == nil {
+			grants = []rbac.Grant{}
+		}
+		WriteJSON(w, http.StatusOK, map[string]interface{}{"grants": grants})
+
+	case http.MethodPut:
+		var req struct {
+			Grants []rbac.Grant `json:"grants"`
+		}
+		if err := ReadJSONBody(r, &req); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		for _, g := range req.Grants {
+			if !IsValidHexID(g.ProductID) {
+				WriteError(w, http.StatusBadRequest, "invalid product_id")
+				return
+			}
+			if !app.productInTenant(r, g.ProductID) {
+				WriteError(w, http.StatusBadRequest, "产品不存在")
+				return
+			}
+		}
+		if err := app.SetAdminUserGrants(id, req.Grants); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+
+	default:
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// --- Role management handlers ---
+
+// roleRequest is the request body for creating or updating a role.
+type roleRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// HandleAdminRoles handles GET (list roles and available permissions) and
+// POST (create a custom role). Only super admins may manage roles.
+func HandleAdminRoles(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, role, err := GetAdminSession(app, r)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		if role != "super_admin" {
+			WriteError(w, http.StatusForbidden, "仅超级管理员可管理角色")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			roles, err := app.ListRoles()
+			if err != nil {
+				log.Printf("[Admin] list roles error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "获取角色列表失败")
+				return
+			}
+			if roles == nil {
+				roles = []rbac.Role{}
+			}
+			WriteJSON(w, http.StatusOK, map[string]interface{}{
+				"roles":       roles,
+				"permissions": rbac.AllPermissions,
+			})
+
+		case http.MethodPost:
+			var req roleRequest
+			if err := ReadJSONBody(r, &req); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			created, err := app.CreateRole(req.Name, req.Description, req.Permissions)
+			if err != nil {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			WriteJSON(w, http.StatusOK, created)
+
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
+
+// HandleAdminRoleByID handles PUT (update) and DELETE for a role.
+func HandleAdminRoleByID(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, role, err := GetAdminSession(app, r)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		if role != "super_admin" {
+			WriteError(w, http.StatusForbidden, "仅超级管理员可管理角色")
+			return
+		}
+
+		id := strings.TrimPrefix(r.URL.Path, "/api/admin/roles/")
+		if id == "" || len(id) > 64 || strings.Contains(id, "/") {
+			WriteError(w, http.StatusBadRequest, "invalid role ID")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPut:
+			var req roleRequest
+			if err := ReadJSONBody(r, &req); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			if err := app.UpdateRole(id, req.Name, req.Description, req.Permissions); err != nil {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+
+		case http.MethodDelete:
+			if err := app.DeleteRole(id); err != nil {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
+
 // HandleAdminRole returns the current admin user's role and permissions.
 func HandleAdminRole(app *App) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -180,7 +441,7 @@ func HandleAdminUnban(app *App) http.HandlerFunc {
 			IP       string `json:"ip"`
 		}
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
 			return
 		}
 		app.loginLimiter.Unban(req.Username, req.IP)
@@ -211,7 +472,7 @@ func HandleAdminAddBan(app *App) http.HandlerFunc {
 			Days     int    `json:"days"`
 		}
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
 			return
 		}
 		if req.Username == "" && req.IP == "" {
@@ -238,15 +499,11 @@ func HandleAdminCustomers(app *App) http.HandlerFunc {
 			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
 			return
 		}
-		_, role, err := GetAdminSession(app, r)
-		if err != nil {
+		// Customer statistics are available to roles with view_analytics
+		if _, _, err := RequireAdminPermission(app, r, rbac.PermViewAnalytics, ""); err != nil {
 			WriteAdminSessionError(w, err)
 			return
 		}
-		if role != "super_admin" {
-			WriteError(w, http.StatusForbidden, "insufficient permissions")
-			return
-		}
 
 		// Parse pagination and search params
 		page := 1
@@ -296,7 +553,7 @@ func HandleAdminCustomerVerify(app *App) http.HandlerFunc {
 			UserID string `json:"user_id"`
 		}
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
 			return
 		}
 		if req.UserID == "" || len(req.UserID) > 128 {
@@ -333,7 +590,7 @@ func HandleAdminCustomerBan(app *App) http.HandlerFunc {
 			Days   int    `json:"days"`
 		}
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
 			return
 		}
 		if req.Email == "" || len(req.Email) > 254 {
@@ -368,7 +625,7 @@ func HandleAdminCustomerUnban(app *App) http.HandlerFunc {
 			Email string `json:"email"`
 		}
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
 			return
 		}
 		if err := app.UnbanCustomer(req.Email); err != nil {
@@ -395,7 +652,7 @@ func HandleAdminCustomerDelete(app *App) http.HandlerFunc {
 			UserID string `json:"user_id"`
 		}
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
 			return
 		}
 		if req.UserID == "" || len(req.UserID) > 128 {
@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"askflow/internal/query"
+)
+
+// writeQueryBusy responds with 503 and Retry-After if err means the query
+// was turned away by admission control, and reports whether it did.
+func writeQueryBusy(app *App, w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, query.ErrOverloaded) {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(app.queryEngine.AdmissionRetryAfter()))
+	WriteError(w, http.StatusServiceUnavailable, query.ErrOverloaded.Error())
+	return true
+}
+
+// HandleAdminQueryQueue reports the state of the query admission queue.
+func HandleAdminQueryQueue(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		_, role, err := GetAdminSession(app, r)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		if role != "super_admin" {
+			WriteError(w, http.StatusForbidden, "仅超级管理员可查看问答队列")
+			return
+		}
+		WriteJSON(w, http.StatusOK, app.queryEngine.AdmissionStats())
+	}
+}
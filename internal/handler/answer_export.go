@@ -0,0 +1,332 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"askflow/internal/blob"
+	"askflow/internal/i18n"
+	"askflow/internal/markdown"
+	"askflow/internal/query"
+	"askflow/internal/rbac"
+	"askflow/internal/replica"
+)
+
+// answerKeepFor is how long answers are kept for export.
+const answerKeepFor = 30 * 24 * time.Hour
+
+// maxExportImageBytes caps the size of an image embedded in an export;
+// larger images are left out.
+const maxExportImageBytes = 5 << 20
+
+// ErrAnswerNotFound is returned for an answer that was not kept or has
+// expired, and reported for answers the requester may not export.
+var ErrAnswerNotFound = errors.New("回答不存在或已过期")
+
+// AnswerExport is an answer kept for export.
+type AnswerExport struct {
+	QueryID   string
+	UserID    string
+	ProductID string
+	Question  string
+	Answer    string
+	Sources   []query.SourceRef
+	CreatedAt time.Time
+}
+
+// recordAnswer keeps an answer grounded in the knowledge base so the user
+// who asked can export it. sources are the answer's sources before their
+// image URLs were signed. A replica sends the answer to its primary.
+func (a *App) recordAnswer(ctx context.Context, req query.QueryRequest, resp *query.QueryResponse, sources []query.SourceRef) {
+	if resp.QueryID == "" || req.UserID == "" || resp.IsPending || resp.Refused || resp.Unverified || resp.Answer == "" || len(sources) == 0 {
+		return
+	}
+	data, err := json.Marshal(sources)
+	if err != nil {
+		log.Printf("[Export] failed to encode sources of %s: %v", resp.QueryID, err)
+		return
+	}
+	ans := replica.Answer{
+		QueryID:   resp.QueryID,
+		UserID:    req.UserID,
+		ProductID: req.ProductID,
+		Question:  req.Question,
+		Answer:    resp.Answer,
+		Sources:   data,
+	}
+	if a.replica != nil {
+		err = a.replica.RecordAnswer(context.WithoutCancel(ctx), ans)
+	} else {
+		err = a.storeAnswer(ans)
+	}
+	if err != nil {
+		log.Printf("[Export] failed to keep answer %s: %v", resp.QueryID, err)
+	}
+}
+
+// storeAnswer writes an answer kept for export.
+func (a *App) storeAnswer(ans replica.Answer) error {
+	sources := string(ans.Sources)
+	if sources == "" {
+		sources = "[]"
+	}
+	_, err := a.db.Exec(
+		`INSERT OR REPLACE INTO query_answers (query_id, user_id, product_id, question, answer, sources, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		ans.QueryID, ans.UserID, ans.ProductID, ans.Question, ans.Answer, sources, time.Now().UTC(),
+	)
+	return err
+}
+
+// Answer returns the answer with queryID if it has not expired.
+func (a *App) Answer(queryID string) (*AnswerExport, error) {
+	ans := &AnswerExport{QueryID: queryID}
+	var sources string
+	var createdAt sql.NullTime
+	err := a.readDB.QueryRow(
+		`SELECT user_id, product_id, question, answer, sources, created_at FROM query_answers WHERE query_id = ? AND created_at >= ?`,
+		queryID, time.Now().UTC().Add(-answerKeepFor),
+	).Scan(&ans.UserID, &ans.ProductID, &ans.Question, &ans.Answer, &sources, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrAnswerNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(sources), &ans.Sources); err != nil {
+		return nil, fmt.Errorf("decode sources of %s: %w", queryID, err)
+	}
+	ans.CreatedAt = createdAt.Time
+	return ans, nil
+}
+
+// PurgeAnswers deletes the answers kept for export longer than
+// answerKeepFor and returns how many were deleted.
+func (a *App) PurgeAnswers() (int64, error) {
+	res, err := a.db.Exec(`DELETE FROM query_answers WHERE created_at < ?`, time.Now().UTC().Add(-answerKeepFor))
+	if err != nil {
+		return 0, fmt.Errorf("purge exported answers: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// HandleQueryExport handles GET /api/query/{id}/export: the answer with
+// that query ID, its sources and their images as a self-contained HTML
+// document to keep, share or print to PDF. The user who asked and admins
+// allowed to view the analytics of the answer's product can export an
+// answer, for answerKeepFor after it was given.
+func HandleQueryExport(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		queryID, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/query/"), "/export")
+		if !ok {
+			WriteError(w, http.StatusNotFound, "not found")
+			return
+		}
+		if !IsValidHexID(queryID) {
+			WriteError(w, http.StatusBadRequest, "invalid query_id")
+			return
+		}
+		userID, err := GetUserSession(app, r)
+		if err != nil {
+			WriteError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		ans, err := app.Answer(queryID)
+		if err == nil && ans.UserID != userID {
+			// Checked like RequireProductPermission; answers the admin
+			// may not see are reported as missing
+			adminID, role, aerr := GetAdminSession(app, r)
+			if aerr != nil || !app.HasAdminPermission(adminID, role, rbac.PermViewAnalytics, ans.ProductID) {
+				err = ErrAnswerNotFound
+			}
+		}
+		if errors.Is(err, ErrAnswerNotFound) {
+			WriteError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			log.Printf("[Export] answer %s: %v", queryID, err)
+			WriteError(w, http.StatusInternalServerError, "导出回答失败")
+			return
+		}
+		page, err := app.renderAnswerExport(ans, i18n.ResponseLanguage(w))
+		if err != nil {
+			log.Printf("[Export] render answer %s: %v", queryID, err)
+			WriteError(w, http.StatusInternalServerError, "导出回答失败")
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="answer-`+queryID[:8]+`.html"`)
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; img-src data: https:; style-src 'unsafe-inline'")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Write(page)
+	}
+}
+
+// exportSource is a source as shown in an exported answer.
+type exportSource struct {
+	Name     string
+	Location string
+	Snippet  string
+	Image    template.URL
+}
+
+// exportPage holds the values of answerExportTemplate.
+type exportPage struct {
+	Lang     string
+	Question string
+	Meta     string
+	Answer   template.HTML
+	Sources  []exportSource
+	Labels   map[string]string
+}
+
+// renderAnswerExport renders an answer as a standalone HTML document in
+// lang. Images of the image store are embedded as data URLs, so the
+// document needs neither a session nor this server to display.
+func (a *App) renderAnswerExport(ans *AnswerExport, lang string) ([]byte, error) {
+	page := exportPage{
+		Lang:     lang,
+		Question: ans.Question,
+		Labels: map[string]string{
+			"sources": i18n.T(lang, "参考来源"),
+			"print":   i18n.T(lang, "按 Ctrl+P 可打印或另存为 PDF"),
+		},
+	}
+	meta := []string{i18n.Tf(lang, "回答于 %s", ans.CreatedAt.Local().Format("2006-01-02 15:04"))}
+	if ans.ProductID != "" {
+		if p, err := a.GetProduct(ans.ProductID); err == nil && p != nil {
+			meta = append([]string{p.Name}, meta...)
+		}
+	}
+	page.Meta = strings.Join(meta, " · ")
+
+	blocks := markdown.Parse(ans.Answer)
+	for i := range blocks {
+		if blocks[i].Type == markdown.TypeImage {
+			blocks[i].URL = a.exportImage(blocks[i].URL)
+		}
+	}
+	page.Answer = template.HTML(markdown.Render(blocks))
+
+	for _, s := range ans.Sources {
+		src := exportSource{Name: s.DocumentName, Snippet: s.Snippet}
+		switch {
+		case s.Page > 0:
+			src.Location = i18n.Tf(lang, "第 %d 页", s.Page)
+		case s.Slide > 0:
+			src.Location = i18n.Tf(lang, "第 %d 张幻灯片", s.Slide)
+		case s.EndTime > 0:
+			src.Location = formatClock(s.StartTime) + " – " + formatClock(s.EndTime)
+		}
+		if s.ImageURL != "" {
+			// Only URLs markdown accepts as image sources are used
+			if u := a.exportImage(s.ImageURL); markdown.SafeImageURL(u) {
+				src.Image = template.URL(u)
+			}
+		}
+		page.Sources = append(page.Sources, src)
+	}
+
+	var buf bytes.Buffer
+	if err := answerExportTemplate.Execute(&buf, page); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// exportImage returns an image store URL as a data URL of the image, and
+// other URLs unchanged. Images that cannot be read or are larger than
+// maxExportImageBytes give "".
+func (a *App) exportImage(u string) string {
+	id := blob.IDFromURL(u)
+	if id == "" {
+		return u
+	}
+	data, err := a.imageStore.Data(id)
+	if err != nil {
+		log.Printf("[Export] image %s: %v", id, err)
+		return ""
+	}
+	if len(data) > maxExportImageBytes {
+		return ""
+	}
+	return "data:" + http.DetectContentType(data) + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// formatClock formats a video position in seconds as m:ss or h:mm:ss.
+func formatClock(sec float64) string {
+	t := int(sec)
+	if t >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", t/3600, t/60%60, t%60)
+	}
+	return fmt.Sprintf("%d:%02d", t/60, t%60)
+}
+
+var answerExportTemplate = template.Must(template.New("answer").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Question}}</title>
+<style>
+body { max-width: 800px; margin: 0 auto; padding: 32px 24px; font: 15px/1.7 -apple-system, "Segoe UI", "PingFang SC", "Microsoft YaHei", "Noto Sans CJK SC", sans-serif; color: #1f2328; }
+h1 { font-size: 22px; line-height: 1.4; margin: 0 0 4px; }
+h2 { font-size: 17px; margin: 32px 0 12px; padding-bottom: 6px; border-bottom: 1px solid #d0d7de; }
+.meta { color: #656d76; font-size: 13px; margin: 0 0 24px; }
+.hint { background: #f6f8fa; border: 1px solid #d0d7de; border-radius: 6px; padding: 8px 12px; font-size: 13px; color: #656d76; }
+.answer ol, .answer ul { padding-left: 24px; }
+.answer li { margin: 4px 0; }
+.answer img, .sources img { display: block; max-width: 100%; margin: 12px 0; border: 1px solid #d0d7de; border-radius: 4px; }
+pre { background: #f6f8fa; padding: 12px; border-radius: 6px; overflow-x: auto; white-space: pre-wrap; }
+code { font-family: ui-monospace, Consolas, monospace; font-size: 13px; }
+table { border-collapse: collapse; margin: 12px 0; }
+th, td { border: 1px solid #d0d7de; padding: 6px 10px; text-align: left; }
+.sources li { margin-bottom: 16px; }
+.source-name { font-weight: 600; }
+.source-location { color: #656d76; font-weight: normal; }
+blockquote { margin: 6px 0; padding: 0 12px; border-left: 3px solid #d0d7de; color: #424a53; white-space: pre-wrap; }
+@media print {
+	body { padding: 0; }
+	.hint { display: none; }
+	h2, li, img, pre, table { break-inside: avoid; }
+}
+</style>
+</head>
+<body>
+<p class="hint">{{.Labels.print}}</p>
+<h1>{{.Question}}</h1>
+<p class="meta">{{.Meta}}</p>
+<div class="answer">{{.Answer}}</div>
+{{- if .Sources}}
+<h2>{{.Labels.sources}}</h2>
+<ol class="sources">
+{{- range .Sources}}
+<li>
+<div class="source-name">{{.Name}}{{if .Location}} <span class="source-location">· {{.Location}}</span>{{end}}</div>
+{{- if .Snippet}}
+<blockquote>{{.Snippet}}</blockquote>
+{{- end}}
+{{- if .Image}}
+<img src="{{.Image}}" alt="">
+{{- end}}
+</li>
+{{- end}}
+</ol>
+{{- end}}
+</body>
+</html>
+`))
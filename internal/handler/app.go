@@ -3,6 +3,7 @@
 package handler
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
@@ -13,23 +14,47 @@ import (
 	"log"
 	mrand "math/rand"
 	"net/http"
-	"os"
-	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"askflow/internal/abuse"
+	"askflow/internal/audit"
 	"askflow/internal/auth"
+	"askflow/internal/backup"
+	"askflow/internal/blob"
+	"askflow/internal/channel"
 	"askflow/internal/config"
+	"askflow/internal/connector"
 	"askflow/internal/document"
 	"askflow/internal/email"
 	"askflow/internal/embedding"
 	"askflow/internal/errlog"
+	"askflow/internal/experiment"
+	"askflow/internal/faq"
+	"askflow/internal/gaps"
+	"askflow/internal/jobs"
+	"askflow/internal/lease"
 	"askflow/internal/llm"
+	"askflow/internal/maintenance"
+	"askflow/internal/markdown"
+	"askflow/internal/moderation"
 	"askflow/internal/pending"
 	"askflow/internal/product"
 	"askflow/internal/query"
+	"askflow/internal/rbac"
+	"askflow/internal/refusal"
+	"askflow/internal/replica"
+	"askflow/internal/scan"
+	"askflow/internal/sharedcache"
+	"askflow/internal/tenant"
+	"askflow/internal/upload"
+	"askflow/internal/usage"
+	"askflow/internal/usergroup"
 	"askflow/internal/vectorstore"
+	"askflow/internal/webhook"
 )
 
 // httpClient is an alias for http.Client used for outbound requests.
@@ -38,17 +63,83 @@ type httpClient = http.Client
 // App is the API facade that binds all backend services for the frontend.
 // Each public method delegates to the appropriate service component.
 type App struct {
-	db             *sql.DB // write DB (also used for reads in App-level queries)
-	readDB         *sql.DB // read-only DB pool for concurrent reads
-	queryEngine    *query.QueryEngine
-	docManager     *document.DocumentManager
-	pendingManager *pending.PendingQuestionManager
-	oauthClient    *auth.OAuthClient
-	sessionManager *auth.SessionManager
-	configManager  *config.ConfigManager
-	emailService   *email.Service
-	productService *product.ProductService
-	loginLimiter   *auth.LoginLimiter
+	db                *sql.DB // write DB (also used for reads in App-level queries)
+	readDB            *sql.DB // read-only DB pool for concurrent reads
+	queryEngine       *query.QueryEngine
+	docManager        *document.DocumentManager
+	vectorStore       *vectorstore.SQLiteVectorStore
+	pendingManager    *pending.PendingQuestionManager
+	oauthClient       *auth.OAuthClient
+	ssoClient         *auth.SSOClient
+	sessionManager    *auth.SessionManager
+	configManager     *config.ConfigManager
+	emailService      *email.Service
+	productService    *product.ProductService
+	loginLimiter      *auth.LoginLimiter
+	abuseGuard        *abuse.Guard
+	rbacService       *rbac.Service
+	auditService      *audit.Service
+	channelService    *channel.Service
+	webhookService    *webhook.Service
+	backupScheduler   *backup.Scheduler
+	maintenance       *maintenance.Scheduler
+	gapService        *gaps.Service
+	slaService        *pending.SLAService
+	faqService        *faq.Service
+	connectors        *connector.Service
+	uploadStore       *upload.Store
+	tenantService     *tenant.Service
+	usageService      *usage.Service
+	experimentService *experiment.Service
+	moderationService *moderation.Service
+	refusalService    *refusal.Service
+	userGroupService  *usergroup.Service
+	imageStore        *blob.Store
+	embeddingPool     *embedding.Pool
+
+	// Cached LLM/embedding reachability for /readyz
+	upstream upstreamProbe
+
+	// basePath is the URL prefix the app is served under ("" for the root)
+	basePath string
+
+	// replica is the primary instance's client when this instance is a
+	// read-only replica, nil otherwise
+	replica *replica.Client
+
+	// locks hands out the leases of background jobs; nil when the jobs do
+	// not lock
+	locks *lease.Manager
+
+	// jobs records the background work listed by /api/admin/jobs
+	jobs *jobs.Registry
+
+	// sharedCache holds the caches shared with the other instances when
+	// cache.backend is redis, nil otherwise
+	sharedCache *sharedcache.Cache
+
+	// Password reset tokens and per-user send throttle
+	resetSigner *auth.TokenSigner
+	resetMu     sync.Mutex
+	resetSentAt map[string]time.Time
+
+	// Signs short-lived image URLs for <img> tags, which carry no bearer token
+	imageSigner *auth.TokenSigner
+
+	// Admin invitation tokens
+	inviteSigner *auth.TokenSigner
+
+	// Signs the state of Google Drive and SharePoint connector authorizations
+	connectorSigner *auth.TokenSigner
+
+	// Account unlock tokens and the lockout notices sent per email
+	unlockSigner *auth.TokenSigner
+	lockMu       sync.Mutex
+	lockNotices  map[string]lockNotice
+
+	// Preferred API message language per user ID ("" for none), cached
+	// from users.language
+	userLangs sync.Map
 }
 
 // NewApp creates a new App with all service dependencies injected.
@@ -57,27 +148,181 @@ func NewApp(
 	readDB *sql.DB,
 	qe *query.QueryEngine,
 	dm *document.DocumentManager,
+	vs *vectorstore.SQLiteVectorStore,
 	pm *pending.PendingQuestionManager,
 	oc *auth.OAuthClient,
+	sc *auth.SSOClient,
 	sm *auth.SessionManager,
+	ll *auth.LoginLimiter,
 	cm *config.ConfigManager,
 	es *email.Service,
 	ps *product.ProductService,
+	wh *webhook.Service,
+	bs *backup.Scheduler,
+	ms *maintenance.Scheduler,
+	gs *gaps.Service,
+	ss *pending.SLAService,
+	fs *faq.Service,
+	cs *connector.Service,
+	us *upload.Store,
+	ts *tenant.Service,
+	ep *embedding.Pool,
 ) *App {
-	return &App{
+	a := &App{
 		db:             writeDB,
 		readDB:         readDB,
 		queryEngine:    qe,
 		docManager:     dm,
+		vectorStore:    vs,
 		pendingManager: pm,
 		oauthClient:    oc,
+		ssoClient:      sc,
 		sessionManager: sm,
 		configManager:  cm,
 		emailService:   es,
 		productService: ps,
-		loginLimiter:   auth.NewLoginLimiterRW(readDB, writeDB),
-	}
+		loginLimiter:   ll,
+		rbacService:    rbac.NewService(readDB, writeDB),
+		auditService:   audit.NewService(readDB, writeDB),
+		usageService: usage.NewService(readDB, writeDB, func() config.UsageConfig {
+			cfg := cm.Get()
+			if cfg == nil {
+				return config.UsageConfig{}
+			}
+			return cfg.Usage
+		}),
+		abuseGuard: abuse.NewGuard(readDB, writeDB, func() config.AbuseConfig {
+			cfg := cm.Get()
+			if cfg == nil {
+				return config.AbuseConfig{}
+			}
+			return cfg.Abuse
+		}),
+		experimentService: experiment.NewService(readDB, writeDB),
+		moderationService: moderation.NewService(readDB, writeDB),
+		imageStore:        blob.NewStore(dm.Storage(), readDB, writeDB),
+		webhookService:    wh,
+		backupScheduler:   bs,
+		maintenance:       ms,
+		gapService:        gs,
+		slaService:        ss,
+		faqService:        fs,
+		connectors:        cs,
+		uploadStore:       us,
+		tenantService:     ts,
+		embeddingPool:     ep,
+		resetSigner:       auth.NewTokenSigner(cm.SigningKey("password_reset")),
+		resetSentAt:       make(map[string]time.Time),
+		imageSigner:       auth.NewTokenSigner(cm.SigningKey("image_url")),
+		unlockSigner:      auth.NewTokenSigner(cm.SigningKey("account_unlock")),
+		inviteSigner:      auth.NewTokenSigner(cm.SigningKey("admin_invite")),
+		connectorSigner:   auth.NewTokenSigner(cm.SigningKey("connector_oauth")),
+		lockNotices:       make(map[string]lockNotice),
+	}
+	// Channel questions go through MeteredQuery so they are counted and
+	// limited like web questions.
+	a.channelService = channel.NewService(writeDB, func() config.ChannelsConfig {
+		cfg := cm.Get()
+		if cfg == nil {
+			return config.ChannelsConfig{}
+		}
+		return cfg.Channels
+	}, a.MeteredQuery, ps.GetFirstID)
+	dm.SetModerator(a.moderationService)
+	dm.SetScanner(scan.NewService(func() config.ScanConfig {
+		cfg := cm.Get()
+		if cfg == nil {
+			return config.ScanConfig{}
+		}
+		return cfg.Scan
+	}))
+	// The "do not answer" list is checked by the query engine before it
+	// searches, so it also applies to channel and gRPC questions.
+	a.refusalService = refusal.NewService(readDB, writeDB, qe.Services, func() config.RefusalConfig {
+		cfg := cm.Get()
+		if cfg == nil {
+			return config.DefaultConfig().Refusal
+		}
+		return cfg.Refusal
+	})
+	qe.SetRefusalCheck(func(ctx context.Context, productID, question string, questionVector func() ([]float64, error)) (string, bool) {
+		m := a.refusalService.Match(ctx, productID, question, questionVector)
+		if m == nil {
+			return "", false
+		}
+		log.Printf("[Refusal] question declined for product=%s by rule %s (%s)", productID, m.RuleID, m.Topic)
+		return m.Message, true
+	})
+	// User groups decide which documents each asker's searches may return;
+	// admins testing answers see everything
+	a.userGroupService = usergroup.NewService(readDB, writeDB)
+	qe.SetVisibility(func(userID string) (*vectorstore.Filter, error) {
+		if a.IsAdminSession(userID) {
+			return nil, nil
+		}
+		return a.userGroupService.Filter(userID)
+	})
+	dm.SetImageStore(a.imageStore)
+	return a
+}
+
+// SetBasePath sets the URL path prefix used in redirects, emailed links and
+// the refresh cookie path when the app is served under a sub-path.
+func (a *App) SetBasePath(p string) {
+	a.basePath = p
+}
+
+// SetReplica makes the app run as a read-only replica of the primary behind
+// c: pending questions and usage of the queries it answers are sent there,
+// and the FAQ question log and experiment exposures are left to queries
+// answered on the primary.
+func (a *App) SetReplica(c *replica.Client) {
+	a.replica = c
+	a.queryEngine.SetPendingSink(func(question, userID, imageData, productID string) error {
+		return c.CreatePending(context.Background(), replica.PendingQuestion{
+			Question:  question,
+			UserID:    userID,
+			ImageData: imageData,
+			ProductID: productID,
+		})
+	})
+}
+
+// SetLocks sets the lease manager reported by /api/admin/locks.
+func (a *App) SetLocks(m *lease.Manager) {
+	a.locks = m
+}
+
+// SetJobs sets the job registry served by /api/admin/jobs.
+func (a *App) SetJobs(r *jobs.Registry) {
+	a.jobs = r
 }
+
+// SetSharedCache makes product name translations and the rate limiters set
+// up by the router use c, shared with the other instances behind the load
+// balancer.
+func (a *App) SetSharedCache(c *sharedcache.Cache) {
+	a.sharedCache = c
+}
+
+// SharedCache returns the cache shared with the other instances, or nil
+// when caches are kept per instance.
+func (a *App) SharedCache() *sharedcache.Cache {
+	return a.sharedCache
+}
+
+// appPath prefixes an absolute app path such as "/login" with the base path.
+func (a *App) appPath(p string) string {
+	return a.basePath + p
+}
+
+// publicURL returns the externally visible root URL of the app for r,
+// without a trailing slash. Under a path-selected tenant it includes the
+// /t/<slug> prefix.
+func (a *App) publicURL(r *http.Request) string {
+	return GetBaseURL(r) + a.basePath + tenant.PrefixFromContext(r.Context())
+}
+
 // SessionManager returns the session manager for testing purposes.
 func (a *App) SessionManager() *auth.SessionManager {
 	return a.sessionManager
@@ -86,25 +331,150 @@ func (a *App) SessionManager() *auth.SessionManager {
 // --- Query Interface ---
 
 // Query processes a user question through the RAG pipeline.
-func (a *App) Query(req query.QueryRequest) (*query.QueryResponse, error) {
-	return a.queryEngine.Query(req)
+func (a *App) Query(ctx context.Context, req query.QueryRequest) (*query.QueryResponse, error) {
+	return a.queryEngine.Query(ctx, req)
+}
+
+// MeteredQuery is Query with usage accounting: it returns a
+// *usage.QuotaError when the user or product has used up a monthly quota,
+// and otherwise records the query and its API tokens, also when it fails
+// part-way since the tokens were still consumed. Questions answered from
+// the knowledge base are logged for the generated FAQ. While a retrieval
+// experiment runs the query is served with the user's variant settings and
+// its outcome is logged. Answers carry a query ID for feedback, and those
+// drawn from the knowledge base are kept for export under it. Questions
+// and answers pass the product's moderation policy; a blocked question is
+// refused in its own language without being counted.
+func (a *App) MeteredQuery(ctx context.Context, req query.QueryRequest) (*query.QueryResponse, error) {
+	question, merr := a.moderationService.ModerateQuery(req.UserID, req.ProductID, req.Question)
+	if merr != nil {
+		log.Printf("[Moderation] question blocked for user=%s product=%s: %v", req.UserID, req.ProductID, merr)
+		return &query.QueryResponse{Answer: query.Localize("抱歉，您的问题包含不允许的内容，无法回答。", req.Question)}, nil
+	}
+	req.Question = question
+	if err := a.usageService.Check(req.UserID, req.ProductID); err != nil {
+		return nil, err
+	}
+	assignment := a.experimentService.Assign(req.UserID)
+	if assignment != nil {
+		req.Overrides = assignment.Overrides
+	}
+	start := time.Now()
+	resp, tokens, err := a.queryEngine.QueryMetered(ctx, req)
+	if resp != nil {
+		resp.Answer = a.moderationService.Redact(req.ProductID, resp.Answer)
+		sources := resp.Sources
+		resp.Sources = a.signSources(resp.Sources)
+		a.structureAnswer(resp)
+		resp.QueryID, _ = generateToken()
+		if err == nil {
+			a.recordAnswer(ctx, req, resp, sources)
+		}
+	}
+	if a.replica != nil {
+		if rerr := a.replica.RecordUsage(context.WithoutCancel(ctx), replica.Usage{
+			UserID:           req.UserID,
+			ProductID:        req.ProductID,
+			EmbeddingTokens:  int64(tokens.EmbeddingTokens),
+			PromptTokens:     int64(tokens.PromptTokens),
+			CompletionTokens: int64(tokens.CompletionTokens),
+		}); rerr != nil {
+			log.Printf("[Usage] failed to record query on the primary for user=%s product=%s: %v", req.UserID, req.ProductID, rerr)
+		}
+		return resp, err
+	}
+	if err == nil && resp != nil && !resp.IsPending && !resp.Refused && !resp.Unverified && len(resp.Sources) > 0 && req.ImageData == "" {
+		a.faqService.Record(req.ProductID, req.Question, resp.Answer)
+	}
+	if assignment != nil && resp != nil && resp.QueryID != "" {
+		if xerr := a.experimentService.RecordExposure(assignment, experiment.Exposure{
+			QueryID:     resp.QueryID,
+			UserID:      req.UserID,
+			ProductID:   req.ProductID,
+			Pending:     resp.IsPending,
+			SourceCount: len(resp.Sources),
+			Latency:     time.Since(start),
+		}); xerr != nil {
+			log.Printf("[Experiment] %v", xerr)
+		}
+	}
+	if rerr := a.usageService.Record(req.UserID, req.ProductID, usage.Tokens{
+		Embedding:  int64(tokens.EmbeddingTokens),
+		Prompt:     int64(tokens.PromptTokens),
+		Completion: int64(tokens.CompletionTokens),
+	}); rerr != nil {
+		log.Printf("[Usage] failed to record query for user=%s product=%s: %v", req.UserID, req.ProductID, rerr)
+	}
+	return resp, err
+}
+
+// TestRefusal returns the rule of the "do not answer" list that question
+// matches for productID, or nil, without answering it.
+func (a *App) TestRefusal(ctx context.Context, productID, question string) *refusal.Match {
+	return a.refusalService.Match(ctx, productID, question, func() ([]float64, error) {
+		es, _ := a.queryEngine.Services()
+		return es.Embed(ctx, question)
+	})
+}
+
+// DebugQuery answers req as a dry run with the trace of every pipeline
+// stage. The question is neither moderated nor counted against usage
+// quotas, and no FAQ, experiment or usage records are written.
+func (a *App) DebugQuery(ctx context.Context, req query.QueryRequest) (*query.DebugResult, error) {
+	result, err := a.queryEngine.Debug(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	result.Sources = a.signSources(result.Sources)
+	a.structureAnswer(result.QueryResponse)
+	return result, nil
+}
+
+// structureAnswer fills in the sanitized blocks and HTML of an answer.
+// Pending responses carry no answer to structure.
+func (a *App) structureAnswer(resp *query.QueryResponse) {
+	if resp.IsPending || resp.Answer == "" {
+		return
+	}
+	blocks := markdown.Parse(resp.Answer)
+	for i := range blocks {
+		if blocks[i].Type == markdown.TypeImage {
+			blocks[i].URL = a.signImageURL(blocks[i].URL)
+		}
+	}
+	resp.Blocks = blocks
+	resp.AnswerHTML = markdown.Render(blocks)
 }
 
 // --- Document Management Interface ---
 
 // UploadFile uploads and processes a document file.
-func (a *App) UploadFile(req document.UploadFileRequest) (*document.DocumentInfo, error) {
-	return a.docManager.UploadFile(req)
+// It returns embedding.ErrQueueFull without starting when the embedding
+// queue is full.
+func (a *App) UploadFile(ctx context.Context, req document.UploadFileRequest) (*document.DocumentInfo, error) {
+	if a.embeddingPool.Busy() {
+		return nil, embedding.ErrQueueFull
+	}
+	return a.docManager.UploadFile(ctx, req)
+}
+
+// UploadURL fetches and processes content from a URL. Like UploadFile, it
+// returns embedding.ErrQueueFull when the embedding queue is full.
+func (a *App) UploadURL(ctx context.Context, req document.UploadURLRequest) (*document.DocumentInfo, error) {
+	if a.embeddingPool.Busy() {
+		return nil, embedding.ErrQueueFull
+	}
+	return a.docManager.UploadURL(ctx, req)
 }
 
-// UploadURL fetches and processes content from a URL.
-func (a *App) UploadURL(req document.UploadURLRequest) (*document.DocumentInfo, error) {
-	return a.docManager.UploadURL(req)
+// EmbeddingQueueStats returns the state of the embedding request queue.
+func (a *App) EmbeddingQueueStats() embedding.PoolStats {
+	return a.embeddingPool.Stats()
 }
 
 // PreviewURL fetches and parses URL content for preview.
-func (a *App) PreviewURL(url string) (*document.URLPreviewResult, error) {
-	return a.docManager.PreviewURL(url)
+func (a *App) PreviewURL(ctx context.Context, url string) (*document.URLPreviewResult, error) {
+	return a.docManager.PreviewURL(ctx, url)
 }
 
 // ListDocuments returns uploaded documents, optionally filtered by product ID.
@@ -124,7 +494,12 @@ func (a *App) GetDocumentInfo(docID string) (*document.DocumentInfo, error) {
 
 // GetDocumentReview returns extracted segments for reviewing document analysis results.
 func (a *App) GetDocumentReview(docID string) (*document.ReviewData, error) {
-	return a.docManager.GetDocumentReview(docID)
+	review, err := a.docManager.GetDocumentReview(docID)
+	if err != nil {
+		return nil, err
+	}
+	a.signReview(review)
+	return review, nil
 }
 
 // --- Pending Questions Interface ---
@@ -136,8 +511,32 @@ func (a *App) ListPendingQuestions(status string, productID string) ([]pending.P
 }
 
 // AnswerQuestion submits an admin answer to a pending question.
-func (a *App) AnswerQuestion(req pending.AdminAnswerRequest) error {
-	return a.pendingManager.AnswerQuestion(req)
+// If the question came from an external channel (Telegram/WeChat), the asker
+// is notified there in the background.
+func (a *App) AnswerQuestion(ctx context.Context, req pending.AdminAnswerRequest) error {
+	unsignImageURLs(req.ImageURLs)
+	if err := a.pendingManager.AnswerQuestion(ctx, req); err != nil {
+		return err
+	}
+	a.assignImages(req.ImageURLs, a.pendingQuestionProductID(req.QuestionID), "pending-answer-"+req.QuestionID)
+	a.webhookService.Emit(webhook.EventQuestionAnswered, map[string]interface{}{
+		"question_id": req.QuestionID,
+		"is_edit":     req.IsEdit,
+	})
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[Channel] panic in answer notification: %v", r)
+			}
+		}()
+		a.channelService.NotifyAnswered(req.QuestionID)
+	}()
+	return nil
+}
+
+// DraftPendingAnswer (re)drafts the suggested answer of a pending question.
+func (a *App) DraftPendingAnswer(ctx context.Context, id string) error {
+	return a.pendingManager.Draft(ctx, id)
 }
 
 // DeletePendingQuestion removes a pending question by ID.
@@ -145,9 +544,28 @@ func (a *App) DeletePendingQuestion(id string) error {
 	return a.pendingManager.DeletePending(id)
 }
 
+// pendingQuestionProductID returns the product a pending question belongs to,
+// or "" if it has none or does not exist.
+func (a *App) pendingQuestionProductID(id string) string {
+	var productID string
+	a.readDB.QueryRow(`SELECT COALESCE(product_id, '') FROM pending_questions WHERE id = ?`, id).Scan(&productID)
+	return productID
+}
+
 // CreatePendingQuestion creates a new pending question from a user who is not satisfied with the answer.
 func (a *App) CreatePendingQuestion(question, userID, imageData, productID string) (*pending.PendingQuestion, error) {
-	return a.pendingManager.CreatePending(question, userID, imageData, productID)
+	pq, err := a.pendingManager.CreatePending(question, userID, imageData, productID)
+	if err != nil {
+		return nil, err
+	}
+	a.pendingManager.DraftAsync(pq.ID)
+	a.webhookService.Emit(webhook.EventQuestionPendingCreated, map[string]string{
+		"question_id": pq.ID,
+		"question":    pq.Question,
+		"user_id":     pq.UserID,
+		"product_id":  pq.ProductID,
+	})
+	return pq, nil
 }
 
 // --- Authentication Interface ---
@@ -179,6 +597,8 @@ func (a *App) HandleOAuthCallback(provider, code string) (*OAuthCallbackResponse
 	}
 
 	// Upsert user into the users table
+	var exists int
+	isNew := a.db.QueryRow(`SELECT 1 FROM users WHERE id = ?`, provider+"_"+user.ID).Scan(&exists) == sql.ErrNoRows
 	_, err = a.db.Exec(
 		`INSERT INTO users (id, email, name, provider, provider_id, email_verified) VALUES (?, ?, ?, ?, ?, 1)
 		 ON CONFLICT(id) DO UPDATE SET name=excluded.name, email=excluded.email, last_login=CURRENT_TIMESTAMP`,
@@ -187,6 +607,14 @@ func (a *App) HandleOAuthCallback(provider, code string) (*OAuthCallbackResponse
 	if err != nil {
 		return nil, fmt.Errorf("upsert OAuth user: %w", err)
 	}
+	if isNew {
+		a.webhookService.Emit(webhook.EventUserRegistered, map[string]string{
+			"user_id":  provider + "_" + user.ID,
+			"email":    user.Email,
+			"name":     user.Name,
+			"provider": provider,
+		})
+	}
 
 	session, err := a.sessionManager.CreateSession(provider + "_" + user.ID)
 	if err != nil {
@@ -199,6 +627,157 @@ func (a *App) HandleOAuthCallback(provider, code string) (*OAuthCallbackResponse
 	}, nil
 }
 
+// SSOLoginResult is the session created by an SSO login. Admin is true when
+// the IdP's role claim mapped the user to an admin role, in which case the
+// session belongs to an SSO-provisioned admin sub-account.
+type SSOLoginResult struct {
+	Session *auth.Session `json:"session"`
+	User    *auth.SSOUser `json:"user"`
+	Admin   bool          `json:"admin"`
+	Role    string        `json:"role,omitempty"`
+}
+
+// GetSSOProviders returns the configured SSO providers for the login page.
+func (a *App) GetSSOProviders() []auth.SSOProviderInfo {
+	return a.ssoClient.Providers()
+}
+
+// CompleteSSOLogin creates a session for a user asserted by an IdP and
+// returns a one-time handoff code the SPA exchanges for it. Users whose role
+// claim maps to an admin role get an admin sub-account with that role
+// (updated on every login); everyone else becomes a regular user.
+func (a *App) CompleteSSOLogin(user *auth.SSOUser) (string, error) {
+	role := auth.MapRole(a.ssoClient.RoleMapping(user.Type, user.Provider), user.Roles)
+	if role != "" && !a.rbacService.RoleExists(role) {
+		log.Printf("[SSO] provider %s maps to unknown role %q, ignoring", user.Provider, role)
+		role = ""
+	}
+
+	var result *SSOLoginResult
+	var err error
+	if role != "" {
+		result, err = a.ssoAdminLogin(user, role)
+	} else {
+		result, err = a.ssoUserLogin(user)
+	}
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return a.ssoClient.IssueHandoff(string(data))
+}
+
+// RedeemSSOHandoff exchanges a one-time handoff code for the SSO login result.
+func (a *App) RedeemSSOHandoff(code string) (*SSOLoginResult, error) {
+	data, ok := a.ssoClient.RedeemHandoff(code)
+	if !ok {
+		return nil, fmt.Errorf("登录凭证无效或已过期")
+	}
+	var result SSOLoginResult
+	if err := json.Unmarshal([]byte(data), &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ssoUserLogin upserts a regular user for an SSO identity and creates a session.
+func (a *App) ssoUserLogin(user *auth.SSOUser) (*SSOLoginResult, error) {
+	userID := user.Type + "_" + user.Provider + "_" + user.Subject
+	var email interface{}
+	if user.Email != "" {
+		var other string
+		err := a.db.QueryRow(`SELECT id FROM users WHERE email = ? AND id != ?`, user.Email, userID).Scan(&other)
+		if err == nil {
+			return nil, fmt.Errorf("该邮箱已被其他账号使用")
+		}
+		email = user.Email
+	}
+
+	var exists int
+	isNew := a.db.QueryRow(`SELECT 1 FROM users WHERE id = ?`, userID).Scan(&exists) == sql.ErrNoRows
+	_, err := a.db.Exec(
+		`INSERT INTO users (id, email, name, provider, provider_id, email_verified, last_login) VALUES (?, ?, ?, ?, ?, 1, CURRENT_TIMESTAMP)
+		 ON CONFLICT(id) DO UPDATE SET name=excluded.name, email=excluded.email, last_login=CURRENT_TIMESTAMP`,
+		userID, email, user.Name, user.Type, user.Provider+":"+user.Subject,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("upsert SSO user: %w", err)
+	}
+	if isNew {
+		a.webhookService.Emit(webhook.EventUserRegistered, map[string]string{
+			"user_id":  userID,
+			"email":    user.Email,
+			"name":     user.Name,
+			"provider": user.Type + ":" + user.Provider,
+		})
+	}
+
+	session, err := a.sessionManager.CreateSession(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &SSOLoginResult{Session: session, User: user}, nil
+}
+
+// ssoAdminLogin provisions (or updates the role of) the admin sub-account
+// "sso:<provider>:<email or subject>" and creates an admin session for it.
+// SSO admin accounts have no usable password.
+func (a *App) ssoAdminLogin(user *auth.SSOUser, role string) (*SSOLoginResult, error) {
+	login := user.Email
+	if login == "" {
+		login = user.Subject
+	}
+	username := "sso:" + user.Provider + ":" + login
+
+	var id string
+	err := a.db.QueryRow(`SELECT id FROM admin_users WHERE username = ?`, username).Scan(&id)
+	if err == sql.ErrNoRows {
+		if id, err = generateToken(); err != nil {
+			return nil, err
+		}
+		if _, err = a.db.Exec(
+			`INSERT INTO admin_users (id, username, password_hash, role) VALUES (?, ?, '!', ?)`,
+			id, username, role,
+		); err != nil {
+			return nil, fmt.Errorf("create SSO admin user: %w", err)
+		}
+		log.Printf("[SSO] provisioned admin user %q with role %s", username, role)
+	} else if err != nil {
+		return nil, err
+	} else if _, err = a.db.Exec(`UPDATE admin_users SET role = ? WHERE id = ?`, role, id); err != nil {
+		return nil, fmt.Errorf("update SSO admin role: %w", err)
+	}
+
+	// Ensure user record exists for FK
+	a.db.Exec(
+		`INSERT OR IGNORE INTO users (id, email, name, provider, provider_id) VALUES (?, ?, ?, ?, ?)`,
+		"admin_"+id, "admin_"+id+"@internal", username, "admin_sub", id,
+	)
+
+	// Session rotation: invalidate old sessions before creating new one
+	_ = a.sessionManager.DeleteSessionsByUserID("admin_" + id)
+	session, err := a.sessionManager.CreateSession("admin_" + id)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[SSO] admin login: username=%q role=%s", username, role)
+	return &SSOLoginResult{Session: session, User: user, Admin: true, Role: role}, nil
+}
+
+// DeleteSSOProvider removes an SSO provider ("oidc" or "saml") from the config.
+func (a *App) DeleteSSOProvider(kind, name string) error {
+	if err := a.configManager.DeleteSSOProvider(kind, name); err != nil {
+		return err
+	}
+	if cfg := a.configManager.Get(); cfg != nil {
+		a.ssoClient.SetProviders(cfg.SSO)
+	}
+	return nil
+}
+
 // GetEnabledOAuthProviders returns the list of OAuth provider names that have
 // been configured with at least client_id, client_secret, auth_url, and token_url.
 func (a *App) GetEnabledOAuthProviders() []string {
@@ -256,6 +835,9 @@ type AdminUserInfo struct {
 	ID           string   `json:"id"`
 	Username     string   `json:"username"`
 	Role         string   `json:"role"`
+	Email        string   `json:"email,omitempty"`
+	Disabled     bool     `json:"disabled"`
+	Invited      bool     `json:"invited,omitempty"` // invitation not yet accepted
 	CreatedAt    string   `json:"created_at,omitempty"`
 	ProductNames []string `json:"product_names,omitempty"`
 	Permissions  []string `json:"permissions,omitempty"`
@@ -334,7 +916,7 @@ func (a *App) GetAdminRole(userID string) string {
 	if strings.HasPrefix(userID, "admin_") {
 		subID := strings.TrimPrefix(userID, "admin_")
 		var role string
-		err := a.readDB.QueryRow(`SELECT role FROM admin_users WHERE id = ?`, subID).Scan(&role)
+		err := a.readDB.QueryRow(`SELECT role FROM admin_users WHERE id = ? AND disabled = 0`, subID).Scan(&role)
 		if err == nil {
 			return role
 		}
@@ -342,11 +924,12 @@ func (a *App) GetAdminRole(userID string) string {
 	return ""
 }
 
-// GetAdminPermissions returns the permissions list for an admin user.
+// GetAdminPermissions returns the permissions list for an admin user: the
+// per-account flags (batch_import) plus the permissions of the global role.
 // super_admin has all permissions implicitly.
 func (a *App) GetAdminPermissions(userID string) []string {
 	if userID == "admin" {
-		return []string{"batch_import"}
+		return append([]string{"batch_import"}, rbac.AllPermissions...)
 	}
 	if strings.HasPrefix(userID, "admin_") {
 		subID := strings.TrimPrefix(userID, "admin_")
@@ -356,16 +939,60 @@ func (a *App) GetAdminPermissions(userID string) []string {
 			return nil
 		}
 		if role == "super_admin" {
-			return []string{"batch_import"}
+			return append([]string{"batch_import"}, rbac.AllPermissions...)
 		}
-		if permsStr == "" {
-			return nil
+		var perms []string
+		if permsStr != "" {
+			perms = strings.Split(permsStr, ",")
 		}
-		return strings.Split(permsStr, ",")
+		return append(perms, a.rbacService.Permissions(subID, role, "")...)
 	}
 	return nil
 }
 
+// HasAdminPermission reports whether the admin session user holds perm on
+// productID ("" checks the global role only). super_admin holds every
+// permission; anonymous viewers pass because they are limited to reads.
+func (a *App) HasAdminPermission(userID, role, perm, productID string) bool {
+	if role == "super_admin" || role == "anonymous_viewer" {
+		return true
+	}
+	if !strings.HasPrefix(userID, "admin_") {
+		return false
+	}
+	return a.rbacService.HasPermission(strings.TrimPrefix(userID, "admin_"), role, perm, productID)
+}
+
+// HasAdminPermissionAnywhere reports whether the admin holds perm globally
+// or through a grant on at least one product.
+func (a *App) HasAdminPermissionAnywhere(userID, role, perm string) bool {
+	if role == "super_admin" || role == "anonymous_viewer" {
+		return true
+	}
+	if !strings.HasPrefix(userID, "admin_") {
+		return false
+	}
+	return a.rbacService.HasPermissionAnywhere(strings.TrimPrefix(userID, "admin_"), role, perm)
+}
+
+// adminDisplayName returns the login name of an admin session user for display
+// in the audit log, falling back to the user ID.
+func (a *App) adminDisplayName(userID string) string {
+	if userID == "admin" {
+		if cfg := a.configManager.Get(); cfg != nil && cfg.Admin.Username != "" {
+			return cfg.Admin.Username
+		}
+		return userID
+	}
+	if strings.HasPrefix(userID, "admin_") {
+		var username string
+		if err := a.readDB.QueryRow(`SELECT username FROM admin_users WHERE id = ?`, strings.TrimPrefix(userID, "admin_")).Scan(&username); err == nil {
+			return username
+		}
+	}
+	return userID
+}
+
 // IsAdminSession checks if a user ID belongs to any admin (super or sub).
 func (a *App) IsAdminSession(userID string) bool {
 	return userID == "admin" || strings.HasPrefix(userID, "admin_") || userID == "anonymous_viewer"
@@ -374,7 +1001,8 @@ func (a *App) IsAdminSession(userID string) bool {
 // AdminLogin verifies the admin username and password and creates a session.
 // Checks the super admin first, then admin sub-accounts.
 // Enforces login rate limiting based on failed attempts per username and IP.
-func (a *App) AdminLogin(username, password, ip string) (*AdminLoginResponse, error) {
+// Sub-accounts can only sign in to the workspace (tenantID) they belong to.
+func (a *App) AdminLogin(username, password, ip, tenantID string) (*AdminLoginResponse, error) {
 	// Check login rate limits before attempting authentication
 	if err := a.loginLimiter.CheckAllowed(username, ip); err != nil {
 		return nil, err
@@ -408,9 +1036,10 @@ func (a *App) AdminLogin(username, password, ip string) (*AdminLoginResponse, er
 
 	// Check admin sub-accounts
 	var id, passwordHash, role string
+	var disabled bool
 	err := a.readDB.QueryRow(
-		`SELECT id, password_hash, role FROM admin_users WHERE username = ?`, username,
-	).Scan(&id, &passwordHash, &role)
+		`SELECT id, password_hash, role, disabled FROM admin_users WHERE username = ? AND tenant_id = ?`, username, tenantID,
+	).Scan(&id, &passwordHash, &role, &disabled)
 	if err != nil {
 		a.loginLimiter.RecordAttempt(username, ip, false)
 		log.Printf("[Auth] failed sub-admin login attempt: username=%q ip=%s (user not found)", username, ip)
@@ -421,6 +1050,10 @@ func (a *App) AdminLogin(username, password, ip string) (*AdminLoginResponse, er
 		log.Printf("[Auth] failed sub-admin login attempt: username=%q ip=%s (wrong password)", username, ip)
 		return nil, fmt.Errorf("用户名或密码错误")
 	}
+	if disabled {
+		log.Printf("[Auth] rejected sub-admin login: username=%q ip=%s (disabled)", username, ip)
+		return nil, fmt.Errorf("账号已被停用，请联系超级管理员")
+	}
 	a.loginLimiter.RecordAttempt(username, ip, true)
 	log.Printf("[Auth] successful sub-admin login: username=%q ip=%s role=%s", username, ip, role)
 
@@ -594,6 +1227,13 @@ func (a *App) Register(req RegisterRequest, baseURL string) error {
 		return fmt.Errorf("创建验证令牌失败: %w", err)
 	}
 
+	a.webhookService.Emit(webhook.EventUserRegistered, map[string]string{
+		"user_id":  userID,
+		"email":    email,
+		"name":     name,
+		"provider": "local",
+	})
+
 	// Send verification email asynchronously so registration returns immediately
 	verifyURL := strings.TrimRight(baseURL, "/") + "/verify?token=" + token
 	go func() {
@@ -652,8 +1292,12 @@ func (a *App) VerifyEmail(token string) error {
 	return nil
 }
 
-// RequestPasswordReset generates a password reset token and sends a reset email.
-// The token expires in 10 minutes. To prevent user enumeration, always returns nil.
+// passwordResetTTL is how long a password reset link stays valid.
+const passwordResetTTL = 10 * time.Minute
+
+// RequestPasswordReset signs a password reset token and sends a reset email.
+// The token expires in 10 minutes and is bound to the current password hash,
+// so it stops working once used. To prevent user enumeration, always returns nil.
 func (a *App) RequestPasswordReset(emailAddr, baseURL string) error {
 	emailAddr = strings.TrimSpace(emailAddr)
 	if emailAddr == "" {
@@ -663,44 +1307,33 @@ func (a *App) RequestPasswordReset(emailAddr, baseURL string) error {
 		return fmt.Errorf("邮箱格式不正确")
 	}
 
-	var userID, name string
+	var userID, name, passwordHash string
 	err := a.db.QueryRow(
-		`SELECT id, COALESCE(name,'') FROM users WHERE email = ?`,
+		`SELECT id, COALESCE(name,''), COALESCE(password_hash,'') FROM users WHERE email = ?`,
 		emailAddr,
-	).Scan(&userID, &name)
-	if err != nil {
+	).Scan(&userID, &name, &passwordHash)
+	if err != nil || a.IsAdminSession(userID) {
 		// Don't reveal whether the email exists
 		return nil
 	}
 
-	// Throttle: if a reset token was created less than 60s ago, skip
-	var recentCount int
-	_ = a.db.QueryRow(
-		`SELECT COUNT(*) FROM email_tokens WHERE user_id = ? AND type = 'password_reset' AND created_at > datetime('now', '-60 seconds')`,
-		userID,
-	).Scan(&recentCount)
-	if recentCount > 0 {
+	// Throttle: if a reset email was sent less than 60s ago, skip
+	a.resetMu.Lock()
+	now := time.Now()
+	for id, t := range a.resetSentAt {
+		if now.Sub(t) > time.Minute {
+			delete(a.resetSentAt, id)
+		}
+	}
+	if _, recent := a.resetSentAt[userID]; recent {
+		a.resetMu.Unlock()
 		// Silently succeed to avoid revealing timing info
 		return nil
 	}
+	a.resetSentAt[userID] = now
+	a.resetMu.Unlock()
 
-	// Delete any existing password reset tokens for this user
-	a.db.Exec(`DELETE FROM email_tokens WHERE user_id = ? AND type = 'password_reset'`, userID)
-
-	// Generate reset token
-	token, err := generateToken()
-	if err != nil {
-		return fmt.Errorf("生成重置令牌失败: %w", err)
-	}
-	expiresAt := time.Now().UTC().Add(10 * time.Minute)
-	_, err = a.db.Exec(
-		`INSERT INTO email_tokens (id, user_id, token, type, expires_at) VALUES (?, ?, ?, 'password_reset', ?)`,
-		token, userID, token, expiresAt.Format(time.RFC3339),
-	)
-	if err != nil {
-		return fmt.Errorf("创建重置令牌失败: %w", err)
-	}
-
+	token := a.resetSigner.Sign(userID, passwordResetTTL, passwordHash)
 	resetURL := strings.TrimRight(baseURL, "/") + "/reset-password?token=" + token
 	go func() {
 		defer func() {
@@ -717,7 +1350,8 @@ func (a *App) RequestPasswordReset(emailAddr, baseURL string) error {
 	return nil
 }
 
-// ResetPassword validates the reset token and updates the user's password.
+// ResetPassword verifies a signed reset token and sets a new password.
+// All sessions of the user are invalidated.
 func (a *App) ResetPassword(token, newPassword string) error {
 	token = strings.TrimSpace(token)
 	if token == "" {
@@ -727,40 +1361,112 @@ func (a *App) ResetPassword(token, newPassword string) error {
 		return errors.New(msg)
 	}
 
-	var userID, expiresAtStr string
-	err := a.db.QueryRow(
-		`SELECT user_id, expires_at FROM email_tokens WHERE token = ? AND type = 'password_reset'`, token,
-	).Scan(&userID, &expiresAtStr)
-	if err == sql.ErrNoRows {
-		return fmt.Errorf("重置链接无效或已过期")
+	userID, err := a.resetSigner.Verify(token, a.passwordHashOf)
+	if err != nil {
+		return fmt.Errorf("重置链接无效或已过期，请重新申请")
 	}
+
+	hash, err := auth.HashPassword(newPassword)
 	if err != nil {
-		return fmt.Errorf("查询重置令牌失败: %w", err)
+		return fmt.Errorf("密码加密失败: %w", err)
 	}
 
-	expiresAt, err := time.Parse(time.RFC3339, expiresAtStr)
+	_, err = a.db.Exec(`UPDATE users SET password_hash = ?, email_verified = 1 WHERE id = ?`, hash, userID)
 	if err != nil {
-		expiresAt, _ = time.Parse("2006-01-02T15:04:05Z", expiresAtStr)
+		return fmt.Errorf("更新密码失败: %w", err)
 	}
-	if time.Now().UTC().After(expiresAt) {
-		a.db.Exec(`DELETE FROM email_tokens WHERE token = ?`, token)
-		return fmt.Errorf("重置链接已过期，请重新申请")
+
+	// Invalidate all sessions
+	_ = a.sessionManager.DeleteSessionsByUserID(userID)
+
+	return nil
+}
+
+// passwordHashOf returns the stored password hash of a user ("" if unset).
+func (a *App) passwordHashOf(userID string) (string, error) {
+	var hash string
+	err := a.db.QueryRow(`SELECT COALESCE(password_hash,'') FROM users WHERE id = ?`, userID).Scan(&hash)
+	return hash, err
+}
+
+// ChangePassword changes the password of a logged-in user after verifying the
+// current one. Accounts without a password (OAuth/SSO) can set one directly.
+// Other sessions are invalidated and a fresh session is returned.
+func (a *App) ChangePassword(userID, oldPassword, newPassword string) (*auth.Session, error) {
+	if a.IsAdminSession(userID) {
+		return nil, fmt.Errorf("管理员账号请在管理后台修改密码")
+	}
+	if msg := ValidatePassword(newPassword); msg != "" {
+		return nil, errors.New(msg)
+	}
+	current, err := a.passwordHashOf(userID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("用户不存在")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询用户失败: %w", err)
+	}
+	if current != "" {
+		if err := auth.VerifyAdminPassword(oldPassword, current); err != nil {
+			return nil, fmt.Errorf("当前密码错误")
+		}
 	}
 
 	hash, err := auth.HashPassword(newPassword)
 	if err != nil {
-		return fmt.Errorf("密码加密失败: %w", err)
+		return nil, fmt.Errorf("密码加密失败: %w", err)
+	}
+	if _, err := a.db.Exec(`UPDATE users SET password_hash = ? WHERE id = ?`, hash, userID); err != nil {
+		return nil, fmt.Errorf("更新密码失败: %w", err)
 	}
 
-	_, err = a.db.Exec(`UPDATE users SET password_hash = ?, email_verified = 1 WHERE id = ?`, hash, userID)
+	// Session rotation: invalidate all sessions, then issue a new one
+	_ = a.sessionManager.DeleteSessionsByUserID(userID)
+	return a.sessionManager.CreateSession(userID)
+}
+
+// DeleteAccount permanently deletes a logged-in user's account, sessions and
+// email tokens. Accounts with a password must confirm it. Pending questions
+// the user asked are kept for the support record.
+func (a *App) DeleteAccount(userID, password string) error {
+	if a.IsAdminSession(userID) {
+		return fmt.Errorf("管理员账号不能在此删除")
+	}
+	current, err := a.passwordHashOf(userID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("用户不存在")
+	}
 	if err != nil {
-		return fmt.Errorf("更新密码失败: %w", err)
+		return fmt.Errorf("查询用户失败: %w", err)
+	}
+	if current != "" {
+		if err := auth.VerifyAdminPassword(password, current); err != nil {
+			return fmt.Errorf("密码错误")
+		}
 	}
 
-	// Delete all password reset tokens for this user and invalidate all sessions
-	a.db.Exec(`DELETE FROM email_tokens WHERE user_id = ? AND type = 'password_reset'`, userID)
 	_ = a.sessionManager.DeleteSessionsByUserID(userID)
-
+	tx, err := a.db.Begin()
+	if err != nil {
+		return fmt.Errorf("删除账号失败: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM email_tokens WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("删除账号失败: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("删除账号失败: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM refresh_tokens WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("删除账号失败: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = ?`, userID); err != nil {
+		return fmt.Errorf("删除账号失败: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("删除账号失败: %w", err)
+	}
+	log.Printf("[Auth] user account deleted: %s", userID)
 	return nil
 }
 
@@ -781,7 +1487,9 @@ type UserInfo struct {
 
 // UserLogin authenticates a user with email and password.
 // Supports both local-registered users and SN users who have set a password via reset.
-func (a *App) UserLogin(email, password, ip string) (*UserLoginResponse, error) {
+// When a wrong password locks the account, the user is emailed an unlock
+// link under baseURL.
+func (a *App) UserLogin(email, password, ip, baseURL string) (*UserLoginResponse, error) {
 	email = strings.TrimSpace(email)
 	if email == "" || password == "" {
 		return nil, fmt.Errorf("邮箱和密码不能为空")
@@ -814,16 +1522,18 @@ func (a *App) UserLogin(email, password, ip string) (*UserLoginResponse, error)
 
 	if err := auth.VerifyAdminPassword(password, passwordHash); err != nil {
 		a.loginLimiter.RecordAttempt(email, ip, false)
+		_ = a.NotifyLockout(email, baseURL, false)
 		return nil, fmt.Errorf("邮箱或密码错误")
 	}
 
+	now := time.Now().UTC()
 	a.loginLimiter.RecordAttempt(email, ip, true)
+	a.alertNewLogin(email, name, ip, now)
 
 	// Update last login
-	a.db.Exec(`UPDATE users SET last_login = ? WHERE id = ?`, time.Now().UTC().Format(time.RFC3339), userID)
+	a.db.Exec(`UPDATE users SET last_login = ? WHERE id = ?`, now.Format(time.RFC3339), userID)
 
-	// Session rotation: invalidate old sessions before creating new one
-	_ = a.sessionManager.DeleteSessionsByUserID(userID)
+	// Other devices stay signed in up to sessions.max_per_user (see deliverSession)
 	session, err := a.sessionManager.CreateSession(userID)
 	if err != nil {
 		return nil, err
@@ -955,17 +1665,38 @@ func (a *App) TestEmail(toEmail string) error {
 
 // MaskedConfig is a copy of Config with API keys replaced by "***".
 type MaskedConfig struct {
-	Server       config.ServerConfig    `json:"server"`
-	LLM          config.LLMConfig       `json:"llm"`
-	Embedding    config.EmbeddingConfig `json:"embedding"`
-	Vector       config.VectorConfig    `json:"vector"`
-	OAuth        MaskedOAuthConfig      `json:"oauth"`
-	Admin        config.AdminConfig     `json:"admin"`
-	SMTP         config.SMTPConfig      `json:"smtp"`
-	ProductIntro string                 `json:"product_intro"`
-	ProductName  string                 `json:"product_name"`
-	Video        config.VideoConfig     `json:"video"`
-	AuthServer   string                 `json:"auth_server"`
+	Server       config.ServerConfig       `json:"server"`
+	LLM          config.LLMConfig          `json:"llm"`
+	Embedding    config.EmbeddingConfig    `json:"embedding"`
+	ImageEmbed   config.ImageEmbedConfig   `json:"image_embedding"`
+	Vector       config.VectorConfig       `json:"vector"`
+	OAuth        MaskedOAuthConfig         `json:"oauth"`
+	Admin        config.AdminConfig        `json:"admin"`
+	SMTP         config.SMTPConfig         `json:"smtp"`
+	ProductIntro string                    `json:"product_intro"`
+	ProductName  string                    `json:"product_name"`
+	Video        config.VideoConfig        `json:"video"`
+	AuthServer   string                    `json:"auth_server"`
+	Channels     config.ChannelsConfig     `json:"channels"`
+	SSO          config.SSOConfig          `json:"sso"`
+	Tenants      config.TenantsConfig      `json:"tenants"`
+	Usage        config.UsageConfig        `json:"usage"`
+	Storage      config.StorageConfig      `json:"storage"`
+	GapReport    config.GapReportConfig    `json:"gap_report"`
+	PendingDraft config.PendingDraftConfig `json:"pending_draft"`
+	RateLimit    config.RateLimitConfig    `json:"rate_limit"`
+	Abuse        config.AbuseConfig        `json:"abuse"`
+	Refusal      config.RefusalConfig      `json:"refusal"`
+	Intent       config.IntentConfig       `json:"intent"`
+	FAQ          config.FAQConfig          `json:"faq"`
+	Scan         config.ScanConfig         `json:"scan"`
+	Connectors   config.ConnectorsConfig   `json:"connectors"`
+	Sessions     config.SessionsConfig     `json:"sessions"`
+	Maintenance  config.MaintenanceConfig  `json:"maintenance"`
+	Replica      config.ReplicaConfig      `json:"replica"`
+	Cache        config.CacheConfig        `json:"cache"`
+	Admission    config.AdmissionConfig    `json:"admission"`
+	BodyLimit    config.BodyLimitConfig    `json:"body_limit"`
 }
 
 // MaskedOAuthConfig holds OAuth config with secrets masked.
@@ -983,6 +1714,26 @@ type MaskedOAuthProvider struct {
 	Scopes       []string `json:"scopes"`
 }
 
+// HSTSMaxAge returns the Strict-Transport-Security max-age in seconds for
+// HTTPS responses, or 0 when HSTS is disabled (server.hsts_max_age).
+func (a *App) HSTSMaxAge() int {
+	cfg := a.configManager.Get()
+	if cfg == nil || cfg.Server.HSTSMaxAge == 0 {
+		return config.DefaultHSTSMaxAge
+	}
+	return max(cfg.Server.HSTSMaxAge, 0)
+}
+
+// RateLimits returns the per-minute limits of the rate-limited endpoint
+// groups and their tier overrides (rate_limit.* in config).
+func (a *App) RateLimits() config.RateLimitConfig {
+	cfg := a.configManager.Get()
+	if cfg == nil {
+		return config.DefaultConfig().RateLimit
+	}
+	return cfg.RateLimit
+}
+
 // GetConfig returns the current configuration with API keys masked.
 func (a *App) GetConfig() *MaskedConfig {
 	cfg := a.configManager.Get()
@@ -994,6 +1745,7 @@ func (a *App) GetConfig() *MaskedConfig {
 		Server:       cfg.Server,
 		LLM:          cfg.LLM,
 		Embedding:    cfg.Embedding,
+		ImageEmbed:   cfg.ImageEmbed,
 		Vector:       cfg.Vector,
 		Admin:        cfg.Admin,
 		SMTP:         cfg.SMTP,
@@ -1001,11 +1753,32 @@ func (a *App) GetConfig() *MaskedConfig {
 		ProductName:  cfg.ProductName,
 		Video:        cfg.Video,
 		AuthServer:   cfg.AuthServer,
+		Channels:     cfg.Channels,
+		SSO:          cfg.SSO,
+		Tenants:      cfg.Tenants,
+		Usage:        cfg.Usage,
+		Storage:      cfg.Storage,
+		GapReport:    cfg.GapReport,
+		PendingDraft: cfg.PendingDraft,
+		RateLimit:    cfg.RateLimit,
+		Abuse:        cfg.Abuse,
+		Refusal:      cfg.Refusal,
+		Intent:       cfg.Intent,
+		FAQ:          cfg.FAQ,
+		Scan:         cfg.Scan,
+		Connectors:   cfg.Connectors,
+		Sessions:     cfg.Sessions,
+		Maintenance:  cfg.Maintenance,
+		Replica:      cfg.Replica,
+		Cache:        cfg.Cache,
+		Admission:    cfg.Admission,
+		BodyLimit:    cfg.BodyLimit,
 	}
 
 	// Mask API keys
 	masked.LLM.APIKey = maskSecret(cfg.LLM.APIKey)
 	masked.Embedding.APIKey = maskSecret(cfg.Embedding.APIKey)
+	masked.ImageEmbed.APIKey = maskSecret(cfg.ImageEmbed.APIKey)
 
 	// Mask OAuth secrets
 	masked.OAuth.Providers = make(map[string]MaskedOAuthProvider, len(cfg.OAuth.Providers))
@@ -1026,6 +1799,36 @@ func (a *App) GetConfig() *MaskedConfig {
 	// Mask SMTP password
 	masked.SMTP.Password = maskSecret(cfg.SMTP.Password)
 
+	// Mask CAPTCHA secret
+	masked.Admin.Captcha.SecretKey = maskSecret(cfg.Admin.Captcha.SecretKey)
+
+	// Mask channel credentials
+	masked.Channels.Telegram.BotToken = maskSecret(cfg.Channels.Telegram.BotToken)
+	masked.Channels.Telegram.WebhookSecret = maskSecret(cfg.Channels.Telegram.WebhookSecret)
+	masked.Channels.WeChat.AppSecret = maskSecret(cfg.Channels.WeChat.AppSecret)
+	masked.Channels.WeChat.Token = maskSecret(cfg.Channels.WeChat.Token)
+
+	// Mask storage credentials
+	masked.Storage.S3.SecretKey = maskSecret(cfg.Storage.S3.SecretKey)
+
+	// Mask scan API key
+	masked.Scan.APIKey = maskSecret(cfg.Scan.APIKey)
+	masked.Video.STT.APIKey = maskSecret(cfg.Video.STT.APIKey)
+	masked.Video.STT.NLSAccessKeySecret = maskSecret(cfg.Video.STT.NLSAccessKeySecret)
+	masked.Video.Workers.Token = maskSecret(cfg.Video.Workers.Token)
+	masked.Replica.Token = maskSecret(cfg.Replica.Token)
+	masked.Cache.RedisURL = maskSecret(cfg.Cache.RedisURL)
+
+	// Mask connector OAuth app secrets
+	masked.Connectors.Google.ClientSecret = maskSecret(cfg.Connectors.Google.ClientSecret)
+	masked.Connectors.Microsoft.ClientSecret = maskSecret(cfg.Connectors.Microsoft.ClientSecret)
+
+	// Mask OIDC client secrets (cfg is already a deep copy)
+	for name, p := range masked.SSO.OIDC {
+		p.ClientSecret = maskSecret(p.ClientSecret)
+		masked.SSO.OIDC[name] = p
+	}
+
 	return masked
 }
 
@@ -1034,35 +1837,77 @@ func (a *App) UpdateConfig(updates map[string]interface{}) error {
 	if err := a.configManager.Update(updates); err != nil {
 		return err
 	}
-	// Refresh services with new config
+	return a.refreshServices(func(prefix string) bool {
+		for key := range updates {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// ReloadConfig reads config.json again, e.g. on a read-only replica after
+// the primary saved changes to it, and applies it to the services.
+func (a *App) ReloadConfig() error {
+	if err := a.configManager.Load(); err != nil {
+		return err
+	}
+	return a.refreshServices(func(string) bool { return true })
+}
+
+// refreshServices applies the current config to the services. changed
+// reports whether settings under a key prefix such as "video." changed.
+func (a *App) refreshServices(changed func(prefix string) bool) error {
 	cfg := a.configManager.Get()
 	if cfg == nil {
 		return fmt.Errorf("config not loaded after update")
 	}
-	es := embedding.NewAPIEmbeddingService(cfg.Embedding.Endpoint, cfg.Embedding.APIKey, cfg.Embedding.ModelName, cfg.Embedding.UseMultimodal)
+	es := embedding.NewAPIEmbeddingService(cfg.Embedding.Endpoint, cfg.Embedding.APIKey, cfg.Embedding.ModelName, cfg.Embedding.UseMultimodal).
+		SetBatchLimits(cfg.Embedding.BatchMaxItems, cfg.Embedding.BatchMaxTokens).SetPool(a.embeddingPool)
+	a.embeddingPool.Resize(cfg.Embedding.MaxConcurrency, cfg.Embedding.QueueLimit)
 	ls := llm.NewAPILLMService(cfg.LLM.Endpoint, cfg.LLM.APIKey, cfg.LLM.ModelName, cfg.LLM.Temperature, cfg.LLM.MaxTokens)
 	a.queryEngine.UpdateServices(es, ls, cfg)
 	a.docManager.UpdateEmbeddingService(es)
+	ies := newImageEmbeddingService(cfg.ImageEmbed)
+	a.queryEngine.SetImageEmbeddingService(ies)
+	a.docManager.SetImageEmbeddingService(ies, cfg.ImageEmbed.ModelName)
+	a.docManager.SetLLMService(ls)
+	a.docManager.SetInjectionCheck(cfg.LLM.InjectionCheck)
 	a.pendingManager.UpdateServices(es, ls)
+	a.upstream.invalidate()
+
+	// Recompute document search boosts if the recency decay changed
+	if changed("vector.recency_") {
+		a.docManager.SetRankingConfig(cfg.Vector.RecencyHalfLifeDays, cfg.Vector.RecencyMinFactor)
+	}
 
 	// Propagate video config to DocumentManager if any video settings changed
-	for key := range updates {
-		if strings.HasPrefix(key, "video.") {
-			a.docManager.SetVideoConfig(cfg.Video)
-			break
-		}
+	if changed("video.") {
+		a.docManager.SetVideoConfig(cfg.Video)
 	}
 
 	// Refresh OAuth client if any OAuth settings changed
-	for key := range updates {
-		if strings.HasPrefix(key, "oauth.") {
-			a.RefreshOAuthClient()
-			break
-		}
+	if changed("oauth.") {
+		a.RefreshOAuthClient()
+	}
+
+	// Refresh SSO providers if any SSO settings changed
+	if changed("sso.") {
+		a.ssoClient.SetProviders(cfg.SSO)
 	}
 	return nil
 }
 
+// newImageEmbeddingService returns the service of the image embedding
+// model, or nil when image similarity search is not configured.
+func newImageEmbeddingService(c config.ImageEmbedConfig) embedding.EmbeddingService {
+	if !c.Configured() {
+		return nil
+	}
+	return embedding.NewAPIEmbeddingService(c.Endpoint, c.APIKey, c.ModelName, true)
+}
+
 // maskSecret replaces a non-empty secret with "***".
 func maskSecret(s string) string {
 	if strings.TrimSpace(s) == "" {
@@ -1073,35 +1918,25 @@ func maskSecret(s string) string {
 
 // --- Admin Sub-Account Management ---
 
-// CreateAdminUser creates a new admin sub-account.
-func (a *App) CreateAdminUser(username, password, role string, permissions []string) (*AdminUserInfo, error) {
+// CreateAdminUser creates a new admin sub-account in the workspace tenantID
+// ("" for the default workspace).
+func (a *App) CreateAdminUser(tenantID, username, password, role string, permissions []string) (*AdminUserInfo, error) {
 	username = strings.TrimSpace(username)
 	if username == "" || password == "" {
 		return nil, fmt.Errorf("用户名和密码不能为空")
 	}
-	if len(username) < 3 {
-		return nil, fmt.Errorf("用户名至少3位")
-	}
-	if len(username) > 64 {
-		return nil, fmt.Errorf("用户名不能超过64位")
+	if err := a.validateAdminUsername(username); err != nil {
+		return nil, err
 	}
 	if msg := ValidatePassword(password); msg != "" {
 		return nil, errors.New(msg)
 	}
-	if role != "editor" && role != "super_admin" {
+	if role == "" || !a.rbacService.RoleExists(role) {
 		role = "editor"
 	}
-	// Reject usernames with special characters
-	for _, c := range username {
-		if c < 0x20 || c == '"' || c == '\'' || c == '\\' || c == '<' || c == '>' {
-			return nil, fmt.Errorf("用户名包含非法字符")
-		}
-	}
 
-	// Check conflict with super admin
-	cfg := a.configManager.Get()
-	if cfg != nil && username == cfg.Admin.Username {
-		return nil, fmt.Errorf("用户名已存在")
+	if err := a.tenantService.CheckQuota(tenantID, tenant.ResourceAdmins); err != nil {
+		return nil, err
 	}
 
 	hash, err := auth.HashPassword(password)
@@ -1125,8 +1960,8 @@ func (a *App) CreateAdminUser(username, password, role string, permissions []str
 	permsStr := strings.Join(filteredPerms, ",")
 
 	_, err = a.db.Exec(
-		`INSERT INTO admin_users (id, username, password_hash, role, permissions) VALUES (?, ?, ?, ?, ?)`,
-		id, username, hash, role, permsStr,
+		`INSERT INTO admin_users (id, username, password_hash, role, permissions, tenant_id) VALUES (?, ?, ?, ?, ?, ?)`,
+		id, username, hash, role, permsStr, tenantID,
 	)
 	if err != nil {
 		if strings.Contains(err.Error(), "UNIQUE") {
@@ -1138,9 +1973,157 @@ func (a *App) CreateAdminUser(username, password, role string, permissions []str
 	return &AdminUserInfo{ID: id, Username: username, Role: role, Permissions: filteredPerms}, nil
 }
 
-// ListAdminUsers returns all admin sub-accounts.
-func (a *App) ListAdminUsers() ([]AdminUserInfo, error) {
-	rows, err := a.readDB.Query(`SELECT id, username, role, created_at, COALESCE(permissions,'') FROM admin_users ORDER BY created_at DESC`)
+// validateAdminUsername checks the length and characters of a new admin
+// sub-account username and that it does not clash with the super admin.
+func (a *App) validateAdminUsername(username string) error {
+	if len(username) < 3 {
+		return fmt.Errorf("用户名至少3位")
+	}
+	if len(username) > 64 {
+		return fmt.Errorf("用户名不能超过64位")
+	}
+	// Reject usernames with special characters
+	for _, c := range username {
+		if c < 0x20 || c == '"' || c == '\'' || c == '\\' || c == '<' || c == '>' {
+			return fmt.Errorf("用户名包含非法字符")
+		}
+	}
+	// Check conflict with super admin
+	cfg := a.configManager.Get()
+	if cfg != nil && username == cfg.Admin.Username {
+		return fmt.Errorf("用户名已存在")
+	}
+	return nil
+}
+
+// adminInviteTTL is how long an admin invitation link stays valid.
+const adminInviteTTL = 72 * time.Hour
+
+// InviteAdminUser creates an admin sub-account without a password in the
+// workspace tenantID and emails an invitation link under baseURL that lets
+// the invitee choose one.
+func (a *App) InviteAdminUser(tenantID, username, emailAddr, role, baseURL string) (*AdminUserInfo, error) {
+	username = strings.TrimSpace(username)
+	emailAddr = strings.TrimSpace(emailAddr)
+	if username == "" || emailAddr == "" {
+		return nil, fmt.Errorf("用户名和邮箱不能为空")
+	}
+	if err := a.validateAdminUsername(username); err != nil {
+		return nil, err
+	}
+	if !strings.Contains(emailAddr, "@") || !strings.Contains(emailAddr, ".") || len(emailAddr) > 254 {
+		return nil, fmt.Errorf("邮箱格式不正确")
+	}
+	if role == "" || !a.rbacService.RoleExists(role) {
+		role = "editor"
+	}
+	if err := a.tenantService.CheckQuota(tenantID, tenant.ResourceAdmins); err != nil {
+		return nil, err
+	}
+
+	id, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	_, err = a.db.Exec(
+		`INSERT INTO admin_users (id, username, password_hash, role, permissions, tenant_id, email) VALUES (?, ?, '', ?, '', ?, ?)`,
+		id, username, role, tenantID, emailAddr,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return nil, fmt.Errorf("用户名已存在")
+		}
+		return nil, fmt.Errorf("创建用户失败: %w", err)
+	}
+	if err := a.SendAdminInvite(id, baseURL); err != nil {
+		return nil, err
+	}
+	return &AdminUserInfo{ID: id, Username: username, Role: role, Email: emailAddr, Invited: true}, nil
+}
+
+// SendAdminInvite (re)sends the invitation email of an admin sub-account
+// that has not set a password yet.
+func (a *App) SendAdminInvite(id, baseURL string) error {
+	var username, emailAddr, passwordHash string
+	err := a.db.QueryRow(
+		`SELECT username, email, password_hash FROM admin_users WHERE id = ?`, id,
+	).Scan(&username, &emailAddr, &passwordHash)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("用户不存在")
+	}
+	if err != nil {
+		return fmt.Errorf("查询用户失败: %w", err)
+	}
+	if passwordHash != "" {
+		return fmt.Errorf("该用户已接受邀请")
+	}
+	if emailAddr == "" {
+		return fmt.Errorf("该用户没有邮箱")
+	}
+	loginRoute := "/admin"
+	if cfg := a.configManager.Get(); cfg != nil && cfg.Admin.LoginRoute != "" {
+		loginRoute = cfg.Admin.LoginRoute
+	}
+	token := a.inviteSigner.Sign(id, adminInviteTTL, passwordHash)
+	inviteURL := strings.TrimRight(baseURL, "/") + loginRoute + "?invite=" + token
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[Admin] panic sending invitation to %s: %v", emailAddr, r)
+			}
+		}()
+		if err := a.emailService.SendAdminInvite(emailAddr, username, inviteURL); err != nil {
+			log.Printf("[Admin] failed to send invitation to %s: %v", emailAddr, err)
+			errlog.Logf("[Email] failed to send admin invitation to %s: %v", emailAddr, err)
+		}
+	}()
+	return nil
+}
+
+// AcceptAdminInvite verifies a signed invitation token and sets the
+// password of the invited admin sub-account. The token stops working once
+// a password is set.
+func (a *App) AcceptAdminInvite(token, password string) error {
+	if msg := ValidatePassword(password); msg != "" {
+		return errors.New(msg)
+	}
+	id, err := a.inviteSigner.Verify(strings.TrimSpace(token), func(id string) (string, error) {
+		var hash string
+		err := a.db.QueryRow(`SELECT password_hash FROM admin_users WHERE id = ? AND disabled = 0`, id).Scan(&hash)
+		return hash, err
+	})
+	if err != nil {
+		return fmt.Errorf("邀请链接无效或已过期")
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("密码加密失败: %w", err)
+	}
+	if _, err := a.db.Exec(`UPDATE admin_users SET password_hash = ? WHERE id = ?`, hash, id); err != nil {
+		return fmt.Errorf("设置密码失败: %w", err)
+	}
+	return nil
+}
+
+// SetAdminUserDisabled disables or re-enables an admin sub-account. A
+// disabled account cannot sign in and its sessions are revoked at once.
+func (a *App) SetAdminUserDisabled(id string, disabled bool) error {
+	result, err := a.db.Exec(`UPDATE admin_users SET disabled = ? WHERE id = ?`, disabled, id)
+	if err != nil {
+		return fmt.Errorf("更新用户状态失败: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("用户不存在")
+	}
+	if disabled {
+		_ = a.sessionManager.DeleteSessionsByUserID("admin_" + id)
+	}
+	return nil
+}
+
+// ListAdminUsers returns the admin sub-accounts of the workspace tenantID.
+func (a *App) ListAdminUsers(tenantID string) ([]AdminUserInfo, error) {
+	rows, err := a.readDB.Query(`SELECT id, username, role, created_at, COALESCE(permissions,''), email, disabled, password_hash = '' FROM admin_users WHERE tenant_id = ? ORDER BY created_at DESC`, tenantID)
 	if err != nil {
 		return nil, err
 	}
@@ -1151,7 +2134,7 @@ func (a *App) ListAdminUsers() ([]AdminUserInfo, error) {
 		var u AdminUserInfo
 		var createdAt sql.NullTime
 		var permsStr string
-		if err := rows.Scan(&u.ID, &u.Username, &u.Role, &createdAt, &permsStr); err != nil {
+		if err := rows.Scan(&u.ID, &u.Username, &u.Role, &createdAt, &permsStr, &u.Email, &u.Disabled, &u.Invited); err != nil {
 			return nil, err
 		}
 		if createdAt.Valid {
@@ -1193,10 +2176,12 @@ func (a *App) ListAdminUsers() ([]AdminUserInfo, error) {
 
 // DeleteAdminUser removes an admin sub-account and cleans up associated sessions.
 func (a *App) DeleteAdminUser(id string) error {
-	// Clean up sessions for this admin user
-	_, _ = a.db.Exec(`DELETE FROM sessions WHERE user_id = ?`, "admin_"+id)
+	// Clean up sessions and refresh tokens for this admin user
+	_ = a.sessionManager.DeleteSessionsByUserID("admin_" + id)
 	// Clean up product assignments
 	_, _ = a.db.Exec(`DELETE FROM admin_user_products WHERE admin_user_id = ?`, id)
+	// Clean up per-product role grants
+	_, _ = a.db.Exec(`DELETE FROM admin_role_grants WHERE admin_user_id = ?`, id)
 	// Delete the admin user record
 	_, err := a.db.Exec(`DELETE FROM admin_users WHERE id = ?`, id)
 	return err
@@ -1214,7 +2199,8 @@ type KnowledgeEntryRequest struct {
 }
 
 // AddKnowledgeEntry stores a text+image knowledge entry into the vector store.
-func (a *App) AddKnowledgeEntry(req KnowledgeEntryRequest) error {
+// Embedding stops once ctx is done.
+func (a *App) AddKnowledgeEntry(ctx context.Context, req KnowledgeEntryRequest) error {
 	title := strings.TrimSpace(req.Title)
 	content := strings.TrimSpace(req.Content)
 	if title == "" || content == "" {
@@ -1233,6 +2219,7 @@ func (a *App) AddKnowledgeEntry(req KnowledgeEntryRequest) error {
 		return fmt.Errorf("视频数量过多（最多10个）")
 	}
 
+	unsignImageURLs(req.ImageURLs)
 	// Validate image URLs (must be local paths or HTTPS)
 	for _, imgURL := range req.ImageURLs {
 		imgURL = strings.TrimSpace(imgURL)
@@ -1268,9 +2255,10 @@ func (a *App) AddKnowledgeEntry(req KnowledgeEntryRequest) error {
 	if err != nil {
 		return fmt.Errorf("创建文档记录失败: %w", err)
 	}
+	a.assignImages(req.ImageURLs, req.ProductID, docID)
 
 	// Embed and store text content
-	if err := a.docManager.ChunkEmbedStore(docID, docName, content, req.ProductID); err != nil {
+	if err := a.docManager.ChunkEmbedStore(ctx, docID, docName, content, req.ProductID); err != nil {
 		return fmt.Errorf("存储文本失败: %w", err)
 	}
 
@@ -1279,7 +2267,7 @@ func (a *App) AddKnowledgeEntry(req KnowledgeEntryRequest) error {
 		es := a.docManager.GetEmbeddingService()
 		// Embed the text once and reuse for all images (same text → same embedding)
 		imgText := fmt.Sprintf("[图片: %s] %s", title, content)
-		imgVec, imgEmbErr := es.Embed(imgText)
+		imgVec, imgEmbErr := es.Embed(ctx, imgText)
 		if imgEmbErr != nil {
 			log.Printf("Warning: failed to embed image text: %v", imgEmbErr)
 		} else {
@@ -1314,7 +2302,7 @@ func (a *App) AddKnowledgeEntry(req KnowledgeEntryRequest) error {
 				if imgURL == "" {
 					continue
 				}
-				vec, err := es.EmbedImageURL(imgURL)
+				vec, err := es.EmbedImageURL(ctx, imgURL)
 				if err != nil {
 					log.Printf("Warning: failed to embed image %d multimodal: %v", i, err)
 					continue
@@ -1343,25 +2331,25 @@ func (a *App) AddKnowledgeEntry(req KnowledgeEntryRequest) error {
 				continue
 			}
 
-			// Extract file path from URL (e.g., "/api/videos/knowledge/uuid.mp4" -> "./data/videos/knowledge/uuid.mp4")
-			videoPath := strings.TrimPrefix(videoURL, "/api/videos/knowledge/")
-			if videoPath == videoURL {
+			// Extract storage key from URL (e.g., "/api/videos/knowledge/uuid.mp4" -> "videos/knowledge/uuid.mp4")
+			name, ok := strings.CutPrefix(videoURL, knowledgeVideoURLPrefix)
+			key, valid := knowledgeVideoKey(name)
+			if !ok || !valid {
 				log.Printf("Warning: invalid video URL format: %s", videoURL)
 				continue
 			}
-			fullPath := filepath.Join(".", "data", "videos", "knowledge", videoPath)
 
 			// Read video file data
-			videoData, err := os.ReadFile(fullPath)
+			videoData, err := a.docManager.Storage().Get(key)
 			if err != nil {
-				log.Printf("Warning: failed to read video file %s: %v", fullPath, err)
+				log.Printf("Warning: failed to read video file %s: %v", key, err)
 				continue
 			}
 
 			// Call processVideo to extract keyframes + transcripts
 			// This will create chunks associated with this knowledge entry docID
-			if err := a.docManager.ProcessVideoForKnowledge(docID, docName, videoData, videoURL, req.ProductID); err != nil {
-				log.Printf("Warning: failed to process video %s: %v", videoPath, err)
+			if err := a.docManager.ProcessVideoForKnowledge(ctx, docID, docName, videoData, key, req.ProductID); err != nil {
+				log.Printf("Warning: failed to process video %s: %v", key, err)
 				// Continue with other videos even if one fails
 			}
 		}
@@ -1372,9 +2360,13 @@ func (a *App) AddKnowledgeEntry(req KnowledgeEntryRequest) error {
 
 // --- Product Management ---
 
-// CreateProduct creates a new product with the given name, type, description, and welcome message.
-func (a *App) CreateProduct(name, productType, description, welcomeMessage string, allowDownload bool) (*product.Product, error) {
-	return a.productService.Create(name, productType, description, welcomeMessage, allowDownload)
+// CreateProduct creates a new product with the given name, type, description, and welcome message
+// in the workspace tenantID, subject to the workspace's product quota.
+func (a *App) CreateProduct(tenantID, name, productType, description, welcomeMessage string, allowDownload bool) (*product.Product, error) {
+	if err := a.tenantService.CheckQuota(tenantID, tenant.ResourceProducts); err != nil {
+		return nil, err
+	}
+	return a.productService.CreateInTenant(tenantID, name, productType, description, welcomeMessage, allowDownload)
 }
 
 // UpdateProduct updates an existing product's name, type, description, and welcome message.
@@ -1382,9 +2374,14 @@ func (a *App) UpdateProduct(id, name, productType, description, welcomeMessage s
 	return a.productService.Update(id, name, productType, description, welcomeMessage, allowDownload)
 }
 
-// DeleteProduct removes a product by ID.
+// DeleteProduct removes a product by ID. Its chunks move to the public
+// library, so cached answers may no longer match what retrieval returns.
 func (a *App) DeleteProduct(id string) error {
-	return a.productService.Delete(id)
+	if err := a.productService.Delete(id); err != nil {
+		return err
+	}
+	a.queryEngine.ClearAnswerCache()
+	return nil
 }
 
 // GetProduct retrieves a product by ID.
@@ -1392,15 +2389,15 @@ func (a *App) GetProduct(id string) (*product.Product, error) {
 	return a.productService.GetByID(id)
 }
 
-// ListProducts returns all products.
-func (a *App) ListProducts() ([]product.Product, error) {
-	return a.productService.List()
+// ListProducts returns the products of the workspace tenantID.
+func (a *App) ListProducts(tenantID string) ([]product.Product, error) {
+	return a.productService.ListByTenant(tenantID)
 }
 
-// GetFirstProductID returns the ID of the first product, or empty string if none exist.
+// GetFirstProductID returns the ID of the first product of the workspace, or empty string if none exist.
 // More efficient than ListProducts() when only the default product ID is needed.
-func (a *App) GetFirstProductID() (string, error) {
-	return a.productService.GetFirstID()
+func (a *App) GetFirstProductID(tenantID string) (string, error) {
+	return a.productService.GetFirstIDByTenant(tenantID)
 }
 
 // HasProductDocumentsOrKnowledge checks whether a product has associated documents or knowledge entries.
@@ -1409,13 +2406,13 @@ func (a *App) HasProductDocumentsOrKnowledge(productID string) (bool, error) {
 }
 
 // GetProductsByAdminUserID returns the products assigned to the given admin user.
-// If the admin user has zero assigned products, all products are returned.
+// If the admin user has zero assigned products, all products of its workspace are returned.
 // The session stores userID as "admin_<id>" for sub-admins and "admin" for super admin.
 // We strip the "admin_" prefix to get the actual admin_users.id for the DB lookup.
-func (a *App) GetProductsByAdminUserID(adminUserID string) ([]product.Product, error) {
-	// Super admin ("admin") has access to all products
+func (a *App) GetProductsByAdminUserID(adminUserID, tenantID string) ([]product.Product, error) {
+	// Super admin ("admin") has access to all products of the workspace it is signed in to
 	if adminUserID == "admin" {
-		return a.productService.List()
+		return a.productService.ListByTenant(tenantID)
 	}
 	// Sub-admin session stores "admin_<actual_id>", strip prefix for DB lookup
 	actualID := strings.TrimPrefix(adminUserID, "admin_")
@@ -1428,6 +2425,255 @@ func (a *App) AssignProductsToAdminUser(adminUserID string, productIDs []string)
 	return a.productService.AssignAdminUser(adminUserID, productIDs)
 }
 
+// GetProductWidgetOrigins returns the origins allowed to embed the chat widget for a product.
+func (a *App) GetProductWidgetOrigins(productID string) ([]string, error) {
+	return a.productService.GetWidgetOrigins(productID)
+}
+
+// SetProductWidgetOrigins replaces the widget origin allowlist of a product.
+func (a *App) SetProductWidgetOrigins(productID string, origins []string) error {
+	return a.productService.SetWidgetOrigins(productID, origins)
+}
+
+// GetProductEscalationThreshold returns the answer confidence below which
+// answers of a product are escalated to a human.
+func (a *App) GetProductEscalationThreshold(productID string) (float64, error) {
+	return a.productService.GetEscalationThreshold(productID)
+}
+
+// SetProductEscalationThreshold sets the answer confidence below which
+// answers of a product are escalated to a human; 0 turns it off.
+func (a *App) SetProductEscalationThreshold(productID string, threshold float64) error {
+	return a.productService.SetEscalationThreshold(productID, threshold)
+}
+
+// IsWidgetOriginAllowed reports whether the given Origin may embed the widget for a product.
+func (a *App) IsWidgetOriginAllowed(productID, origin string) bool {
+	return a.productService.IsWidgetOriginAllowed(productID, origin)
+}
+
+// ProductStats is the health summary of one product's knowledge base,
+// returned by GET /api/products/{id}/stats.
+type ProductStats struct {
+	ProductID string `json:"product_id"`
+	document.ProductDocumentStats
+	// Period is the usage month (YYYY-MM) the query and pending counts cover.
+	Period  string `json:"period"`
+	Queries int64  `json:"queries"`
+	// PendingQuestions counts questions of the period that could not be
+	// answered, PendingOpen those of them still unanswered. PendingRatio is
+	// PendingQuestions / Queries, 0 when there were no queries.
+	PendingQuestions int     `json:"pending_questions"`
+	PendingOpen      int     `json:"pending_open"`
+	PendingRatio     float64 `json:"pending_ratio"`
+	// TopUnanswered are the topics of the latest gap report that involve
+	// the product and still have unanswered questions, most pending first.
+	TopUnanswered []UnansweredTopic `json:"top_unanswered"`
+	GapReportID   string            `json:"gap_report_id,omitempty"`
+}
+
+// UnansweredTopic is a gap report topic as seen by one product. Sample
+// questions are left out since a topic may span products.
+type UnansweredTopic struct {
+	Topic   string `json:"topic"`
+	Count   int    `json:"count"`
+	Pending int    `json:"pending"`
+}
+
+// maxUnansweredTopics caps ProductStats.TopUnanswered.
+const maxUnansweredTopics = 5
+
+// ProductStats computes the knowledge base health of a product for a usage
+// period (YYYY-MM).
+func (a *App) ProductStats(productID, period string) (*ProductStats, error) {
+	start, err := time.Parse("2006-01", period)
+	if err != nil {
+		return nil, fmt.Errorf("invalid period: %w", err)
+	}
+	docs, err := a.docManager.ProductStats(productID)
+	if err != nil {
+		return nil, err
+	}
+	s := &ProductStats{ProductID: productID, ProductDocumentStats: *docs, Period: period, TopUnanswered: []UnansweredTopic{}}
+
+	counters, err := a.usageService.Get(period, usage.ScopeProduct, productID)
+	if err != nil {
+		return nil, err
+	}
+	s.Queries = counters.Queries
+	pending, err := a.pendingManager.CountByStatus(productID, start, start.AddDate(0, 1, 0))
+	if err != nil {
+		return nil, err
+	}
+	for status, n := range pending {
+		s.PendingQuestions += n
+		if status == "pending" {
+			s.PendingOpen += n
+		}
+	}
+	if s.Queries > 0 {
+		s.PendingRatio = float64(s.PendingQuestions) / float64(s.Queries)
+	}
+
+	reports, err := a.gapService.List(1)
+	if err != nil {
+		return nil, err
+	}
+	if len(reports) == 0 {
+		return s, nil
+	}
+	report, err := a.gapService.Get(reports[0].ID)
+	if err != nil {
+		return nil, err
+	}
+	s.GapReportID = report.ID
+	for _, t := range report.Topics {
+		if t.Pending > 0 && slices.Contains(t.ProductIDs, productID) {
+			s.TopUnanswered = append(s.TopUnanswered, UnansweredTopic{Topic: t.Topic, Count: t.Count, Pending: t.Pending})
+		}
+	}
+	sort.SliceStable(s.TopUnanswered, func(i, j int) bool {
+		return s.TopUnanswered[i].Pending > s.TopUnanswered[j].Pending
+	})
+	if len(s.TopUnanswered) > maxUnansweredTopics {
+		s.TopUnanswered = s.TopUnanswered[:maxUnansweredTopics]
+	}
+	return s, nil
+}
+
+// --- Roles ---
+
+// ListRoles returns all admin roles.
+func (a *App) ListRoles() ([]rbac.Role, error) {
+	return a.rbacService.ListRoles()
+}
+
+// CreateRole adds a custom admin role.
+func (a *App) CreateRole(name, description string, permissions []string) (*rbac.Role, error) {
+	return a.rbacService.CreateRole(name, description, permissions)
+}
+
+// UpdateRole changes an admin role's name, description and permissions.
+func (a *App) UpdateRole(id, name, description string, permissions []string) error {
+	return a.rbacService.UpdateRole(id, name, description, permissions)
+}
+
+// DeleteRole removes a custom admin role that is no longer in use.
+func (a *App) DeleteRole(id string) error {
+	return a.rbacService.DeleteRole(id)
+}
+
+// SetAdminUserRole changes the global role of an admin sub-account.
+func (a *App) SetAdminUserRole(id, role string) error {
+	if !a.rbacService.RoleExists(role) {
+		return fmt.Errorf("角色不存在")
+	}
+	result, err := a.db.Exec(`UPDATE admin_users SET role = ? WHERE id = ?`, role, id)
+	if err != nil {
+		return fmt.Errorf("更新角色失败: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("用户不存在")
+	}
+	return nil
+}
+
+// GetAdminUserGrants returns the per-product role grants of an admin sub-account.
+func (a *App) GetAdminUserGrants(id string) ([]rbac.Grant, error) {
+	return a.rbacService.ListGrants(id)
+}
+
+// SetAdminUserGrants replaces the per-product role grants of an admin sub-account.
+func (a *App) SetAdminUserGrants(id string, grants []rbac.Grant) error {
+	return a.rbacService.SetGrants(id, grants)
+}
+
+// --- Webhooks ---
+
+// ListWebhooks returns all configured webhooks (secrets omitted).
+func (a *App) ListWebhooks() ([]webhook.Webhook, error) {
+	return a.webhookService.List()
+}
+
+// CreateWebhook adds a webhook; the returned value carries the signing secret.
+func (a *App) CreateWebhook(url, secret string, events []string) (*webhook.Webhook, error) {
+	return a.webhookService.Create(url, secret, events)
+}
+
+// UpdateWebhook updates a webhook. The secret is rotated only if newSecret is non-empty.
+func (a *App) UpdateWebhook(id, url string, events []string, enabled bool, newSecret string) error {
+	return a.webhookService.Update(id, url, events, enabled, newSecret)
+}
+
+// DeleteWebhook removes a webhook.
+func (a *App) DeleteWebhook(id string) error {
+	return a.webhookService.Delete(id)
+}
+
+// TestWebhook sends a signed ping event to a webhook and returns the HTTP status.
+func (a *App) TestWebhook(id string) (int, error) {
+	return a.webhookService.Test(id)
+}
+
+// --- Embeddable Widget ---
+
+// widgetSessionTTL is the lifetime of a widget visitor token. Widget sessions
+// have no refresh token; the widget requests a new visitor when it expires.
+const widgetSessionTTL = 24 * time.Hour
+
+// WidgetSessionResponse is returned when a widget visitor obtains a token.
+type WidgetSessionResponse struct {
+	Token     string    `json:"token"`
+	VisitorID string    `json:"visitor_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreateWidgetVisitor creates an anonymous visitor user for the embeddable widget
+// and issues a session token for it. Visitor IDs are prefixed with "widget_" so
+// widget sessions can be told apart from regular user sessions.
+func (a *App) CreateWidgetVisitor(productID string) (*WidgetSessionResponse, error) {
+	token, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	visitorID := "widget_" + token
+	_, err = a.db.Exec(
+		`INSERT INTO users (id, email, name, provider, provider_id, default_product_id) VALUES (?, ?, ?, ?, ?, ?)`,
+		visitorID, visitorID+"@widget", "访客", "widget", visitorID, productID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create widget visitor: %w", err)
+	}
+	session, err := a.sessionManager.CreateAccessSession(visitorID, widgetSessionTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &WidgetSessionResponse{
+		Token:     session.ID,
+		VisitorID: visitorID,
+		ExpiresAt: session.ExpiresAt,
+	}, nil
+}
+
+// PurgeWidgetVisitors deletes the widget visitors whose sessions have all
+// expired, with their usage counters, and returns how many were deleted.
+// Visitors are never signed in again once their token expires, so their
+// rows would otherwise pile up with every widget page view. Visitors newer
+// than widgetSessionTTL are kept in case their session is still being
+// created.
+func (a *App) PurgeWidgetVisitors() (int64, error) {
+	cutoff := time.Now().UTC().Add(-widgetSessionTTL).Format("2006-01-02 15:04:05")
+	stale := `SELECT id FROM users WHERE provider = 'widget' AND created_at < ? AND id NOT IN (SELECT user_id FROM sessions)`
+	if _, err := a.db.Exec(`DELETE FROM usage_counters WHERE scope = ? AND subject_id IN (`+stale+`)`, usage.ScopeUser, cutoff); err != nil {
+		return 0, fmt.Errorf("purge widget visitor usage: %w", err)
+	}
+	res, err := a.db.Exec(`DELETE FROM users WHERE id IN (`+stale+`)`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("purge widget visitors: %w", err)
+	}
+	return res.RowsAffected()
+}
+
 // --- User Preferences ---
 
 // GetUserDefaultProduct returns the default product ID for a user.
@@ -1447,6 +2693,30 @@ func (a *App) SetUserDefaultProduct(userID, productID string) error {
 	return err
 }
 
+// GetUserLanguage returns the preferred API message language of a user, or
+// "" if they have not chosen one.
+func (a *App) GetUserLanguage(userID string) string {
+	if v, ok := a.userLangs.Load(userID); ok {
+		return v.(string)
+	}
+	var lang string
+	if err := a.readDB.QueryRow(`SELECT language FROM users WHERE id = ?`, userID).Scan(&lang); err != nil {
+		return ""
+	}
+	a.userLangs.Store(userID, lang)
+	return lang
+}
+
+// SetUserLanguage sets the preferred API message language of a user; ""
+// clears it.
+func (a *App) SetUserLanguage(userID, lang string) error {
+	if _, err := a.db.Exec(`UPDATE users SET language = ? WHERE id = ?`, lang, userID); err != nil {
+		return err
+	}
+	a.userLangs.Store(userID, lang)
+	return nil
+}
+
 // --- Customer Management ---
 
 // CustomerUserInfo holds detailed info about a regular user for admin management.
@@ -1491,9 +2761,9 @@ func (a *App) ListCustomersPaged(page, pageSize int, search string) (*CustomerLi
 	}
 
 	// Build WHERE clause (use table-qualified column names for JOIN compatibility)
-	baseWhere := `provider != 'admin_sub' AND id != 'admin'`
+	baseWhere := `provider != 'admin_sub' AND provider != 'widget' AND id != 'admin'`
 	// For JOIN queries, we need table-qualified names to avoid ambiguity with login_bans.id
-	joinWhere := `u.provider != 'admin_sub' AND u.id != 'admin'`
+	joinWhere := `u.provider != 'admin_sub' AND u.provider != 'widget' AND u.id != 'admin'`
 	var args []interface{}
 	if search != "" {
 		baseWhere += ` AND COALESCE(email, '') LIKE ?`
@@ -1592,6 +2862,7 @@ func (a *App) DeleteCustomer(userID string) error {
 	// Delete tokens and sessions first
 	_, _ = tx.Exec(`DELETE FROM email_tokens WHERE user_id = ?`, userID)
 	_, _ = tx.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID)
+	_, _ = tx.Exec(`DELETE FROM refresh_tokens WHERE user_id = ?`, userID)
 	// Delete user record
 	_, err = tx.Exec(`DELETE FROM users WHERE id = ?`, userID)
 	if err != nil {
@@ -1820,8 +3091,9 @@ func (a *App) ValidateLoginTicket(ticket string) (sessionID string, err error) {
 		a.db.Exec("UPDATE users SET last_login = CURRENT_TIMESTAMP WHERE id = ?", regularUserID)
 	}
 
-	// Create session
-	session, err := a.sessionManager.CreateSession(regularUserID)
+	// Create a placeholder session; ticket exchange swaps it for a full
+	// access/refresh token pair.
+	session, err := a.sessionManager.CreateAccessSession(regularUserID, 0)
 	if err != nil {
 		return "", fmt.Errorf("internal_error")
 	}
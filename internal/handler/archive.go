@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+
+	"askflow/internal/archive"
+	"askflow/internal/document"
+	"askflow/internal/errlog"
+	"askflow/internal/rbac"
+	"askflow/internal/tenant"
+)
+
+// Limits of ZIP archive uploads. Each file is further limited to the upload
+// size limit.
+const (
+	maxArchiveFiles     = 500
+	maxArchiveTotalSize = 2 << 30
+)
+
+// ArchiveFileResult is the outcome of one file of an uploaded archive:
+// the document created, or why the file was not imported.
+type ArchiveFileResult struct {
+	Path     string                 `json:"path"`
+	Document *document.DocumentInfo `json:"document,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+}
+
+// ArchiveImportResult reports the import of a ZIP archive. Its documents
+// share BatchID. Stopped tells why the import ended before the end of the
+// archive, when a limit was reached.
+type ArchiveImportResult struct {
+	BatchID string              `json:"batch_id"`
+	Total   int                 `json:"total"`
+	Success int                 `json:"success"`
+	Failed  int                 `json:"failed"`
+	Files   []ArchiveFileResult `json:"files"`
+	Stopped string              `json:"stopped,omitempty"`
+}
+
+// storeUploadedArchive expands a ZIP archive and adds each supported file
+// in it as a document of productID, writing the per-file results as the
+// response. It reports whether the archive was imported; single files may
+// still have failed.
+func storeUploadedArchive(app *App, w http.ResponseWriter, r *http.Request, userID, role, fileName, productID string, data []byte) bool {
+	if !app.HasAdminPermission(userID, role, rbac.PermManageDocs, productID) {
+		WriteError(w, http.StatusForbidden, "无权管理该产品的文档")
+		return false
+	}
+	if !app.allowDocumentWrite(w, r, productID) {
+		return false
+	}
+	batchID, err := generateToken()
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "internal error")
+		return false
+	}
+	// Files are imported one after another within this request
+	extendUploadDeadlines(w)
+
+	result := ArchiveImportResult{BatchID: batchID, Files: []ArchiveFileResult{}}
+	fail := func(p, reason string) {
+		result.Failed++
+		result.Files = append(result.Files, ArchiveFileResult{Path: p, Error: tr(w, reason)})
+	}
+	limits := archive.Limits{
+		MaxFiles:     maxArchiveFiles,
+		MaxFileSize:  int64(app.MaxUploadSizeMB()) << 20,
+		MaxTotalSize: maxArchiveTotalSize,
+	}
+	walkErr := archive.Walk(data, limits, func(e archive.Entry) {
+		result.Total++
+		if e.Skipped != "" {
+			fail(e.Path, e.Skipped)
+			return
+		}
+		if err := r.Context().Err(); err != nil {
+			fail(e.Path, "上传请求已中断，文档处理已停止")
+			return
+		}
+		name := path.Base(e.Path)
+		fileType, err := SniffFileType(name, e.Data)
+		if err != nil {
+			fail(e.Path, err.Error())
+			return
+		}
+		if err := app.tenantService.CheckQuota(requestTenantID(r), tenant.ResourceDocuments); err != nil {
+			var qe *tenant.QuotaError
+			if errors.As(err, &qe) {
+				fail(e.Path, "已达到工作区文档数量上限")
+			} else {
+				log.Printf("[Archive] document quota check error: %v", err)
+				fail(e.Path, "检查工作区配额失败")
+			}
+			return
+		}
+		doc, err := app.UploadFile(r.Context(), document.UploadFileRequest{
+			FileName:  name,
+			FileData:  e.Data,
+			FileType:  fileType,
+			ProductID: productID,
+			BatchID:   batchID,
+		})
+		if err != nil {
+			errlog.Logf("[Archive] file rejected archive=%q file=%q type=%s: %v", fileName, e.Path, fileType, err)
+			fail(e.Path, err.Error())
+			return
+		}
+		if doc.Status == "failed" {
+			result.Failed++
+		} else {
+			result.Success++
+		}
+		result.Files = append(result.Files, ArchiveFileResult{Path: e.Path, Document: doc})
+	})
+
+	switch {
+	case errors.Is(walkErr, archive.ErrInvalid):
+		WriteError(w, http.StatusBadRequest, "无法解析压缩包")
+		return false
+	case errors.Is(walkErr, archive.ErrTooManyFiles):
+		result.Stopped = tr(w, fmt.Sprintf("压缩包内文件数超过限制 (%d)", maxArchiveFiles))
+	case errors.Is(walkErr, archive.ErrTooLarge):
+		result.Stopped = tr(w, fmt.Sprintf("压缩包解压后大小超过限制 (%dMB)", maxArchiveTotalSize>>20))
+	case walkErr != nil:
+		log.Printf("[Archive] expand %q error: %v", fileName, walkErr)
+		WriteError(w, http.StatusInternalServerError, "解压失败")
+		return false
+	}
+	log.Printf("[Archive] imported %q batch=%s: %d files, %d ok, %d failed", fileName, batchID, result.Total, result.Success, result.Failed)
+	WriteJSON(w, http.StatusOK, result)
+	return true
+}
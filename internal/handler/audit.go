@@ -0,0 +1,324 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"askflow/internal/audit"
+	"askflow/internal/middleware"
+)
+
+// AuditSnapshot returns the current state of the resource a request targets,
+// or nil if it does not exist. It is called before and after the handler runs.
+type AuditSnapshot func(r *http.Request) interface{}
+
+// maxAuditBody caps how much of a JSON request body is buffered for the audit log.
+const maxAuditBody = 64 << 10
+
+// AuditLog returns a middleware that records admin mutations (non-GET requests
+// made with an admin session) to the audit log. If action has no "." the verb
+// for the HTTP method is appended, e.g. "product" → "product.update".
+// snapshot, if non-nil, captures the resource state before and after the
+// handler so only changed fields are stored; otherwise the redacted JSON
+// request body is stored as the after state.
+func AuditLog(app *App, action string, snapshot AuditSnapshot) middleware.Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+				next(w, r)
+				return
+			}
+			actorID, actorRole := auditActor(app, r)
+			if actorID == "" {
+				next(w, r)
+				return
+			}
+
+			// Buffer small JSON bodies so they can be recorded and still read by the handler
+			var body []byte
+			if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") && r.ContentLength >= 0 && r.ContentLength <= maxAuditBody {
+				body, _ = io.ReadAll(io.LimitReader(r.Body, maxAuditBody+1))
+				r.Body = io.NopCloser(bytes.NewReader(body))
+			}
+
+			var before interface{}
+			if snapshot != nil {
+				before = snapshot(r)
+			}
+
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next(rec, r)
+
+			entry := audit.Entry{
+				ActorID:    actorID,
+				ActorName:  app.adminDisplayName(actorID),
+				ActorRole:  actorRole,
+				IP:         middleware.GetClientIP(r),
+				Action:     auditAction(action, r.Method),
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				ResourceID: auditResourceID(r.URL.Path),
+				Status:     rec.status,
+			}
+			if snapshot != nil {
+				var after interface{}
+				if rec.status < 400 {
+					after = snapshot(r)
+				} else {
+					after = before
+				}
+				entry.Before, entry.After = audit.Diff(before, after)
+			} else if len(body) > 0 && len(body) <= maxAuditBody {
+				entry.After = redactAuditBody(body)
+			}
+			if err := app.auditService.Record(entry); err != nil {
+				log.Printf("[Audit] %v", err)
+			}
+		}
+	}
+}
+
+// auditActor returns the admin user ID and role of the request's session,
+// or empty strings if the request has no admin session.
+func auditActor(app *App, r *http.Request) (string, string) {
+	token := requestToken(r, AdminSessionCookieName)
+	if token == "" {
+		return "", ""
+	}
+	session, err := app.sessionManager.ValidateSession(token)
+	if err != nil || !app.IsAdminSession(session.UserID) {
+		return "", ""
+	}
+	return session.UserID, app.GetAdminRole(session.UserID)
+}
+
+// auditAction appends a verb derived from method to actions without one.
+func auditAction(action, method string) string {
+	if strings.Contains(action, ".") {
+		return action
+	}
+	switch method {
+	case http.MethodPost:
+		return action + ".create"
+	case http.MethodPut, http.MethodPatch:
+		return action + ".update"
+	case http.MethodDelete:
+		return action + ".delete"
+	}
+	return action + "." + strings.ToLower(method)
+}
+
+// auditResourceID returns the first hex ID segment of path, if any.
+func auditResourceID(path string) string {
+	for _, seg := range strings.Split(path, "/") {
+		if IsValidHexID(seg) {
+			return seg
+		}
+	}
+	return ""
+}
+
+// redactAuditBody re-encodes a JSON body with credentials masked and long
+// values (e.g. base64 images) truncated.
+func redactAuditBody(body []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return ""
+	}
+	data, err := json.Marshal(redactValue("", v))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func redactValue(key string, v interface{}) interface{} {
+	lower := strings.ToLower(key)
+	for _, s := range []string{"password", "secret", "token", "api_key", "apikey"} {
+		if strings.Contains(lower, s) {
+			if str, ok := v.(string); ok && str == "" {
+				return ""
+			}
+			return "***"
+		}
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			t[k] = redactValue(k, child)
+		}
+		return t
+	case []interface{}:
+		for i, child := range t {
+			t[i] = redactValue(key, child)
+		}
+		return t
+	case string:
+		if len(t) > 500 {
+			return t[:500] + "...(" + strconv.Itoa(len(t)) + " bytes)"
+		}
+	}
+	return v
+}
+
+// statusRecorder captures the response status code while passing writes through.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status = code
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Flush supports streaming handlers (e.g. batch import SSE).
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying connection.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// --- Audit snapshots ---
+
+// ConfigAuditSnapshot captures the masked system configuration.
+func ConfigAuditSnapshot(app *App) AuditSnapshot {
+	return func(r *http.Request) interface{} {
+		if cfg := app.GetConfig(); cfg != nil {
+			return cfg
+		}
+		return nil
+	}
+}
+
+// ProductAuditSnapshot captures the product addressed by /api/products/{id}
+// (or its widget origins for /api/products/{id}/widget-origins).
+func ProductAuditSnapshot(app *App) AuditSnapshot {
+	return func(r *http.Request) interface{} {
+		id := auditResourceID(r.URL.Path)
+		if id == "" {
+			return nil
+		}
+		if strings.HasSuffix(r.URL.Path, "/widget-origins") {
+			origins, err := app.GetProductWidgetOrigins(id)
+			if err != nil {
+				return nil
+			}
+			return map[string]interface{}{"widget_origins": origins}
+		}
+		p, err := app.GetProduct(id)
+		if err != nil || p == nil {
+			return nil
+		}
+		return p
+	}
+}
+
+// DocumentAuditSnapshot captures the document addressed by /api/documents/{id}.
+func DocumentAuditSnapshot(app *App) AuditSnapshot {
+	return func(r *http.Request) interface{} {
+		id := auditResourceID(r.URL.Path)
+		if id == "" {
+			return nil
+		}
+		info, err := app.docManager.GetDocumentInfo(id)
+		if err != nil {
+			return nil
+		}
+		return info
+	}
+}
+
+// --- Audit log query handler ---
+
+// HandleAdminAudit returns audit log entries, newest first (super admin only).
+// GET /api/admin/audit?actor=&action=&resource_id=&from=&to=&page=&page_size=
+// from/to accept RFC 3339 timestamps or YYYY-MM-DD dates; to is exclusive
+// for timestamps and covers the whole day for dates.
+func HandleAdminAudit(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		_, role, err := GetAdminSession(app, r)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		if role != "super_admin" {
+			WriteError(w, http.StatusForbidden, "仅超级管理员可查看审计日志")
+			return
+		}
+
+		q := r.URL.Query()
+		f := audit.Filter{
+			ActorID:    q.Get("actor"),
+			Action:     q.Get("action"),
+			ResourceID: q.Get("resource_id"),
+		}
+		if len(f.ActorID) > 100 || len(f.Action) > 100 || len(f.ResourceID) > 100 {
+			WriteError(w, http.StatusBadRequest, "invalid filter")
+			return
+		}
+		if v := q.Get("from"); v != "" {
+			t, _, ok := parseAuditTime(v)
+			if !ok {
+				WriteError(w, http.StatusBadRequest, "invalid from")
+				return
+			}
+			f.From = t
+		}
+		if v := q.Get("to"); v != "" {
+			t, isDate, ok := parseAuditTime(v)
+			if !ok {
+				WriteError(w, http.StatusBadRequest, "invalid to")
+				return
+			}
+			if isDate {
+				t = t.Add(24 * time.Hour)
+			}
+			f.To = t
+		}
+		if v, e := strconv.Atoi(q.Get("page")); e == nil {
+			f.Page = v
+		}
+		if v, e := strconv.Atoi(q.Get("page_size")); e == nil {
+			f.PageSize = v
+		}
+
+		result, err := app.auditService.List(f)
+		if err != nil {
+			log.Printf("[Audit] list error: %v", err)
+			WriteError(w, http.StatusInternalServerError, "获取审计日志失败")
+			return
+		}
+		WriteJSON(w, http.StatusOK, result)
+	}
+}
+
+// parseAuditTime parses an RFC 3339 timestamp or a YYYY-MM-DD date (UTC).
+func parseAuditTime(v string) (t time.Time, isDate bool, ok bool) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, false, true
+	}
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, true, true
+	}
+	return time.Time{}, false, false
+}
@@ -1,11 +1,18 @@
 package handler
 
 import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"askflow/internal/auth"
 	"askflow/internal/captcha"
+	"askflow/internal/config"
+	"askflow/internal/i18n"
 	"askflow/internal/middleware"
 )
 
@@ -45,7 +52,7 @@ func HandleOAuthCallback(app *App) http.HandlerFunc {
 			State    string `json:"state"`
 		}
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
 			return
 		}
 		// Validate OAuth state to prevent CSRF (state is required)
@@ -58,6 +65,7 @@ func HandleOAuthCallback(app *App) http.HandlerFunc {
 			WriteError(w, http.StatusBadRequest, err.Error())
 			return
 		}
+		resp.Session = deliverSession(app, w, r, resp.Session, false)
 		WriteJSON(w, http.StatusOK, resp)
 	}
 }
@@ -112,28 +120,36 @@ func HandleAdminLogin(app *App) http.HandlerFunc {
 			Password      string `json:"password"`
 			CaptchaID     string `json:"captcha_id"`
 			CaptchaAnswer string `json:"captcha_answer"`
+			CaptchaToken  string `json:"captcha_token"`
 		}
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
 			return
 		}
-		// Try image captcha store first (captcha package), then text captcha store (app)
-		captchaValid := captcha.Validate(req.CaptchaID, req.CaptchaAnswer)
-		if !captchaValid {
-			// Fallback: try text captcha store (answer is numeric string)
-			if ans, err := strconv.Atoi(req.CaptchaAnswer); err == nil {
-				captchaValid = ValidateCaptcha(req.CaptchaID, ans)
+		if c, ok := requiredChallenge(app, config.CaptchaLogin); ok {
+			if !checkChallenge(w, r, c, req.CaptchaToken) {
+				return
+			}
+		} else {
+			// Try image captcha store first (captcha package), then text captcha store (app)
+			captchaValid := captcha.Validate(req.CaptchaID, req.CaptchaAnswer)
+			if !captchaValid {
+				// Fallback: try text captcha store (answer is numeric string)
+				if ans, err := strconv.Atoi(req.CaptchaAnswer); err == nil {
+					captchaValid = ValidateCaptcha(req.CaptchaID, ans)
+				}
+			}
+			if !captchaValid {
+				WriteError(w, http.StatusBadRequest, "验证码错误")
+				return
 			}
 		}
-		if !captchaValid {
-			WriteError(w, http.StatusBadRequest, "验证码错误")
-			return
-		}
-		resp, err := app.AdminLogin(req.Username, req.Password, middleware.GetClientIP(r))
+		resp, err := app.AdminLogin(req.Username, req.Password, middleware.GetClientIP(r), requestTenantID(r))
 		if err != nil {
 			WriteError(w, http.StatusUnauthorized, err.Error())
 			return
 		}
+		resp.Session = deliverSession(app, w, r, resp.Session, true)
 		WriteJSON(w, http.StatusOK, resp)
 	}
 }
@@ -150,7 +166,7 @@ func HandleAdminSetup(app *App) http.HandlerFunc {
 			Password string `json:"password"`
 		}
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
 			return
 		}
 		resp, err := app.AdminSetup(req.Username, req.Password)
@@ -158,6 +174,7 @@ func HandleAdminSetup(app *App) http.HandlerFunc {
 			WriteError(w, http.StatusBadRequest, err.Error())
 			return
 		}
+		resp.Session = deliverSession(app, w, r, resp.Session, true)
 		WriteJSON(w, http.StatusOK, resp)
 	}
 }
@@ -174,6 +191,7 @@ func HandleAnonymousLogin(app *App) http.HandlerFunc {
 			WriteError(w, http.StatusForbidden, err.Error())
 			return
 		}
+		resp.Session = deliverSession(app, w, r, resp.Session, true)
 		WriteJSON(w, http.StatusOK, resp)
 	}
 }
@@ -190,6 +208,7 @@ func HandleAnonymousFrontendLogin(app *App) http.HandlerFunc {
 			WriteError(w, http.StatusForbidden, err.Error())
 			return
 		}
+		resp.Session = deliverSession(app, w, r, resp.Session, false)
 		WriteJSON(w, http.StatusOK, resp)
 	}
 }
@@ -245,6 +264,33 @@ func HandleCaptchaImage() http.HandlerFunc {
 	}
 }
 
+// requiredChallenge returns the hCaptcha / Turnstile settings when endpoint
+// (config.CaptchaRegister, CaptchaLogin or CaptchaAnonymousQuery) requires a
+// third-party challenge token instead of the built-in captcha.
+func requiredChallenge(app *App, endpoint string) (config.CaptchaConfig, bool) {
+	cfg := app.configManager.Get()
+	if cfg == nil || !cfg.Admin.Captcha.Requires(endpoint) {
+		return config.CaptchaConfig{}, false
+	}
+	return cfg.Admin.Captcha, true
+}
+
+// checkChallenge verifies a third-party challenge token with the provider
+// and writes the error response when it does not pass.
+func checkChallenge(w http.ResponseWriter, r *http.Request, c config.CaptchaConfig, token string) bool {
+	err := captcha.VerifyToken(r.Context(), c.Provider, c.SecretKey, token, middleware.GetClientIP(r))
+	if errors.Is(err, captcha.ErrChallengeFailed) {
+		WriteError(w, http.StatusBadRequest, "人机验证失败，请重试")
+		return false
+	}
+	if err != nil {
+		log.Printf("[Captcha] %s verification error: %v", c.Provider, err)
+		WriteError(w, http.StatusServiceUnavailable, "人机验证服务暂不可用，请稍后再试")
+		return false
+	}
+	return true
+}
+
 // HandleRegister creates a new user account with captcha validation.
 func HandleRegister(app *App) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -256,25 +302,32 @@ func HandleRegister(app *App) http.HandlerFunc {
 			RegisterRequest
 			CaptchaID     string `json:"captcha_id"`
 			CaptchaAnswer int    `json:"captcha_answer"`
+			CaptchaToken  string `json:"captcha_token"`
 		}
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
 			return
 		}
-		if !ValidateCaptcha(req.CaptchaID, req.CaptchaAnswer) {
+		if c, ok := requiredChallenge(app, config.CaptchaRegister); ok {
+			if !checkChallenge(w, r, c, req.CaptchaToken) {
+				return
+			}
+		} else if !ValidateCaptcha(req.CaptchaID, req.CaptchaAnswer) {
 			WriteError(w, http.StatusBadRequest, "验证码错误")
 			return
 		}
-		baseURL := GetBaseURL(r)
+		baseURL := app.publicURL(r)
 		if err := app.Register(req.RegisterRequest, baseURL); err != nil {
 			WriteError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok", "message": "注册成功，请查收验证邮件"})
+		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok", "message": tr(w, "注册成功，请查收验证邮件")})
 	}
 }
 
-// HandleUserPreferences handles GET/PUT for user default product preference.
+// HandleUserPreferences handles GET/PUT for the user's default product and
+// API message language. PUT leaves fields that are not sent unchanged; an
+// empty language returns to negotiating it from Accept-Language.
 func HandleUserPreferences(app *App) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, err := GetUserSession(app, r)
@@ -289,18 +342,42 @@ func HandleUserPreferences(app *App) http.HandlerFunc {
 				WriteError(w, http.StatusInternalServerError, "获取用户偏好失败")
 				return
 			}
-			WriteJSON(w, http.StatusOK, map[string]string{"default_product_id": defaultProductID})
+			WriteJSON(w, http.StatusOK, map[string]string{
+				"default_product_id": defaultProductID,
+				"language":           app.GetUserLanguage(userID),
+			})
 		case http.MethodPut:
 			var req struct {
-				DefaultProductID string `json:"default_product_id"`
+				DefaultProductID *string `json:"default_product_id"`
+				Language         *string `json:"language"`
 			}
 			if err := ReadJSONBody(r, &req); err != nil {
-				WriteError(w, http.StatusBadRequest, "invalid request body")
+				writeBodyError(w, err)
 				return
 			}
-			if err := app.SetUserDefaultProduct(userID, req.DefaultProductID); err != nil {
-				WriteError(w, http.StatusInternalServerError, "保存用户偏好失败")
-				return
+			var lang string
+			if req.Language != nil && *req.Language != "" {
+				var ok bool
+				if lang, ok = i18n.Supported(*req.Language); !ok {
+					WriteError(w, http.StatusBadRequest, "不支持的语言")
+					return
+				}
+			}
+			if req.DefaultProductID != nil {
+				if err := app.SetUserDefaultProduct(userID, *req.DefaultProductID); err != nil {
+					WriteError(w, http.StatusInternalServerError, "保存用户偏好失败")
+					return
+				}
+			}
+			if req.Language != nil {
+				if err := app.SetUserLanguage(userID, lang); err != nil {
+					WriteError(w, http.StatusInternalServerError, "保存用户偏好失败")
+					return
+				}
+				// Answer in the language just chosen
+				if lang != "" {
+					w.Header().Set("Content-Language", lang)
+				}
 			}
 			WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 		default:
@@ -321,20 +398,26 @@ func HandleUserLogin(app *App) http.HandlerFunc {
 			Password      string `json:"password"`
 			CaptchaID     string `json:"captcha_id"`
 			CaptchaAnswer int    `json:"captcha_answer"`
+			CaptchaToken  string `json:"captcha_token"`
 		}
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
 			return
 		}
-		if !ValidateCaptcha(req.CaptchaID, req.CaptchaAnswer) {
+		if c, ok := requiredChallenge(app, config.CaptchaLogin); ok {
+			if !checkChallenge(w, r, c, req.CaptchaToken) {
+				return
+			}
+		} else if !ValidateCaptcha(req.CaptchaID, req.CaptchaAnswer) {
 			WriteError(w, http.StatusBadRequest, "验证码错误")
 			return
 		}
-		resp, err := app.UserLogin(req.Email, req.Password, middleware.GetClientIP(r))
+		resp, err := app.UserLogin(req.Email, req.Password, middleware.GetClientIP(r), app.publicURL(r))
 		if err != nil {
 			WriteError(w, http.StatusUnauthorized, err.Error())
 			return
 		}
+		resp.Session = deliverSession(app, w, r, resp.Session, false)
 		WriteJSON(w, http.StatusOK, resp)
 	}
 }
@@ -356,11 +439,12 @@ func HandleVerifyEmail(app *App) http.HandlerFunc {
 			WriteError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok", "message": "邮箱验证成功，请登录"})
+		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok", "message": tr(w, "邮箱验证成功，请登录")})
 	}
 }
 
-// HandleForgotPassword handles POST /api/auth/forgot-password — sends a password reset email.
+// HandleForgotPassword handles POST /api/auth/forgot (alias /api/auth/forgot-password)
+// — sends a password reset email with a signed, time-limited token.
 func HandleForgotPassword(app *App) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -371,19 +455,20 @@ func HandleForgotPassword(app *App) http.HandlerFunc {
 			Email string `json:"email"`
 		}
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
 			return
 		}
-		baseURL := GetBaseURL(r)
+		baseURL := app.publicURL(r)
 		if err := app.RequestPasswordReset(req.Email, baseURL); err != nil {
 			WriteError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok", "message": "如果该邮箱已注册，重置链接将发送到您的邮箱"})
+		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok", "message": tr(w, "如果该邮箱已注册，重置链接将发送到您的邮箱")})
 	}
 }
 
-// HandleResetPassword handles POST /api/auth/reset-password — resets the password using a token.
+// HandleResetPassword handles POST /api/auth/reset (alias /api/auth/reset-password)
+// — resets the password using a signed reset token.
 func HandleResetPassword(app *App) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -395,10 +480,10 @@ func HandleResetPassword(app *App) http.HandlerFunc {
 			Password string `json:"password"`
 		}
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
 			return
 		}
-		if len(req.Token) != 32 || !IsValidHexID(req.Token) {
+		if !isValidSignedToken(req.Token) {
 			WriteError(w, http.StatusBadRequest, "无效的重置链接")
 			return
 		}
@@ -406,7 +491,259 @@ func HandleResetPassword(app *App) http.HandlerFunc {
 			WriteError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok", "message": "密码重置成功，请登录"})
+		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok", "message": tr(w, "密码重置成功，请登录")})
+	}
+}
+
+// isValidSignedToken checks that s looks like an auth.TokenSigner token
+// (two base64url segments joined by ".").
+func isValidSignedToken(s string) bool {
+	if len(s) == 0 || len(s) > 512 || strings.Count(s, ".") != 1 {
+		return false
+	}
+	for _, c := range s {
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// HandleChangePassword handles POST /api/auth/change-password — changes the
+// logged-in user's password and returns a fresh session (others are revoked).
+func HandleChangePassword(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		userID, err := GetUserSession(app, r)
+		if err != nil {
+			WriteError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		var req struct {
+			OldPassword string `json:"old_password"`
+			NewPassword string `json:"new_password"`
+		}
+		if err := ReadJSONBody(r, &req); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		session, err := app.ChangePassword(userID, req.OldPassword, req.NewPassword)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		session = deliverSession(app, w, r, session, false)
+		WriteJSON(w, http.StatusOK, map[string]interface{}{"status": "ok", "session": session})
+	}
+}
+
+// HandleRefreshSession handles POST /api/auth/refresh — exchanges a refresh
+// token for a new access/refresh token pair. Body: {"refresh_token": "..."};
+// in cookie mode the token is read from the refresh cookie instead
+// ({"admin": true} selects the admin one). Each refresh token works once:
+// presenting a rotated token again revokes the whole login.
+func HandleRefreshSession(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var req struct {
+			RefreshToken string `json:"refresh_token"`
+			Admin        bool   `json:"admin"`
+		}
+		if err := ReadJSONBody(r, &req); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		token := req.RefreshToken
+		if token == "" {
+			name := RefreshCookieName
+			if req.Admin {
+				name = AdminRefreshCookieName
+			}
+			if c, err := r.Cookie(name); err == nil {
+				token = c.Value
+			}
+		}
+		if token == "" || len(token) > 128 {
+			WriteError(w, http.StatusUnauthorized, "未登录")
+			return
+		}
+		session, err := app.sessionManager.Refresh(token)
+		if err != nil {
+			if errors.Is(err, auth.ErrRefreshTokenReused) {
+				log.Printf("[Auth] refresh token reuse detected from %s, login revoked", middleware.GetClientIP(r))
+			}
+			clearSessionCookie(app, w, r, req.Admin)
+			WriteError(w, http.StatusUnauthorized, "会话已过期")
+			return
+		}
+		admin := app.IsAdminSession(session.UserID)
+		if admin && app.GetAdminRole(session.UserID) == "" {
+			// Admin account was removed or lost its role since login
+			_ = app.sessionManager.DeleteSession(session.ID)
+			clearSessionCookie(app, w, r, true)
+			WriteError(w, http.StatusUnauthorized, "会话已过期")
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"session": deliverSession(app, w, r, session, admin),
+		})
+	}
+}
+
+// HandleLogout handles POST /api/auth/logout — revokes the user session and
+// clears the session cookie.
+func HandleLogout(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		logout(app, w, r, false)
+	}
+}
+
+// HandleAdminLogout handles POST /api/admin/logout — revokes the admin session
+// and clears the admin session cookie.
+func HandleAdminLogout(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		logout(app, w, r, true)
+	}
+}
+
+func logout(app *App, w http.ResponseWriter, r *http.Request, admin bool) {
+	cookie := SessionCookieName
+	if admin {
+		cookie = AdminSessionCookieName
+	}
+	if token := requestToken(r, cookie); token != "" {
+		if err := app.sessionManager.DeleteSession(token); err != nil {
+			log.Printf("[Auth] failed to delete session on logout: %v", err)
+		}
+	}
+	clearSessionCookie(app, w, r, admin)
+	WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// HandleSessions handles GET /api/auth/sessions — lists the devices the user
+// is signed in on, with the user agent and address of each and the one making
+// the request marked current.
+func HandleSessions(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		session, err := currentSession(app, r)
+		if err != nil {
+			WriteError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		devices, err := app.sessionManager.ListDevices(session.UserID, session.ID)
+		if err != nil {
+			log.Printf("[Auth] list devices error: %v", err)
+			WriteError(w, http.StatusInternalServerError, "获取登录设备失败")
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]interface{}{"sessions": devices})
+	}
+}
+
+// HandleSessionByID handles DELETE /api/auth/sessions/{id} — signs out one of
+// the user's devices, e.g. a shared machine left signed in. Revoking the
+// current device also clears the session cookie.
+func HandleSessionByID(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/api/auth/sessions/")
+		if !isValidDeviceID(id) {
+			WriteError(w, http.StatusBadRequest, "invalid session ID")
+			return
+		}
+		session, err := currentSession(app, r)
+		if err != nil {
+			WriteError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if err := app.sessionManager.RevokeDevice(session.UserID, id); err != nil {
+			if errors.Is(err, auth.ErrDeviceNotFound) {
+				WriteError(w, http.StatusNotFound, "登录设备不存在")
+				return
+			}
+			log.Printf("[Auth] revoke device error: %v", err)
+			WriteError(w, http.StatusInternalServerError, "注销登录设备失败")
+			return
+		}
+		if _, err := app.sessionManager.ValidateSession(session.ID); err != nil {
+			// The current device was signed out
+			clearSessionCookie(app, w, r, app.IsAdminSession(session.UserID))
+		}
+		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// currentSession returns the valid user or admin session of r.
+func currentSession(app *App, r *http.Request) (*auth.Session, error) {
+	token := requestToken(r, SessionCookieName, AdminSessionCookieName)
+	if token == "" {
+		return nil, fmt.Errorf("未登录")
+	}
+	session, err := app.sessionManager.ValidateSession(token)
+	if err != nil {
+		return nil, fmt.Errorf("会话已过期")
+	}
+	return session, nil
+}
+
+// isValidDeviceID reports whether id has the form of a login's token family
+// ID: 64 lowercase hex characters.
+func isValidDeviceID(id string) bool {
+	if len(id) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil && strings.ToLower(id) == id
+}
+
+// HandleDeleteAccount handles DELETE /api/auth/account — permanently deletes
+// the logged-in user's account. Body: {"password": "..."} (required when the
+// account has a password).
+func HandleDeleteAccount(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		userID, err := GetUserSession(app, r)
+		if err != nil {
+			WriteError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		var req struct {
+			Password string `json:"password"`
+		}
+		if err := ReadJSONBody(r, &req); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if err := app.DeleteAccount(userID, req.Password); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		clearSessionCookie(app, w, r, false)
+		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 	}
 }
 
@@ -421,6 +758,9 @@ func HandleSNLogin(app *App) http.HandlerFunc {
 		}
 		var req SNLoginRequest
 		if err := ReadJSONBody(r, &req); err != nil {
+			if writeBodyTooLarge(w, err) {
+				return
+			}
 			WriteJSON(w, http.StatusBadRequest, SNLoginResponse{Success: false, Message: "token is required"})
 			return
 		}
@@ -438,24 +778,24 @@ func HandleSNLogin(app *App) http.HandlerFunc {
 func HandleTicketLogin(app *App) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Redirect(w, r, "/login?error=method_not_allowed", http.StatusFound)
+			http.Redirect(w, r, app.appPath("/login?error=method_not_allowed"), http.StatusFound)
 			return
 		}
 		ticket := r.URL.Query().Get("ticket")
 		if ticket == "" || len(ticket) > 128 {
-			http.Redirect(w, r, "/login?error=invalid_ticket", http.StatusFound)
+			http.Redirect(w, r, app.appPath("/login?error=invalid_ticket"), http.StatusFound)
 			return
 		}
 		// Validate ticket contains only safe characters (hex + dashes)
 		for _, c := range ticket {
 			if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || c == '-') {
-				http.Redirect(w, r, "/login?error=invalid_ticket", http.StatusFound)
+				http.Redirect(w, r, app.appPath("/login?error=invalid_ticket"), http.StatusFound)
 				return
 			}
 		}
 		// Pass ticket to frontend — the SPA will call /api/auth/ticket-exchange to
 		// validate it and store the session in localStorage (same pattern as OAuth).
-		http.Redirect(w, r, "/?ticket="+ticket, http.StatusFound)
+		http.Redirect(w, r, app.appPath("/?ticket="+ticket), http.StatusFound)
 	}
 }
 
@@ -472,6 +812,9 @@ func HandleTicketExchange(app *App) http.HandlerFunc {
 			Ticket string `json:"ticket"`
 		}
 		if err := ReadJSONBody(r, &req); err != nil {
+			if writeBodyTooLarge(w, err) {
+				return
+			}
 			WriteJSON(w, http.StatusBadRequest, map[string]interface{}{
 				"success": false, "message": "ticket is required",
 			})
@@ -493,14 +836,22 @@ func HandleTicketExchange(app *App) http.HandlerFunc {
 			return
 		}
 
-		// Fetch session details
-		session, err := app.sessionManager.ValidateSession(sessionID)
+		// Swap the ticket's placeholder session for a full access/refresh pair
+		placeholder, err := app.sessionManager.ValidateSession(sessionID)
+		if err != nil {
+			WriteJSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"success": false, "message": "internal error",
+			})
+			return
+		}
+		session, err := app.sessionManager.CreateSession(placeholder.UserID)
 		if err != nil {
 			WriteJSON(w, http.StatusInternalServerError, map[string]interface{}{
 				"success": false, "message": "internal error",
 			})
 			return
 		}
+		_ = app.sessionManager.DeleteSession(sessionID)
 
 		// Fetch user info
 		var email, name, provider string
@@ -510,7 +861,7 @@ func HandleTicketExchange(app *App) http.HandlerFunc {
 		).Scan(&email, &name, &provider)
 
 		WriteJSON(w, http.StatusOK, map[string]interface{}{
-			"session": session,
+			"session": deliverSession(app, w, r, session, false),
 			"user": map[string]string{
 				"id":       session.UserID,
 				"email":    email,
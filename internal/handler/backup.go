@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"askflow/internal/backup"
+	"askflow/internal/lease"
+)
+
+// HandleAdminBackup handles /api/admin/backup (super admin only).
+// GET returns the scheduler status and the archives in the output directory;
+// POST {"mode": "full"|"incremental"} starts a backup in the background.
+func HandleAdminBackup(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, role, err := GetAdminSession(app, r)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		if role != "super_admin" {
+			WriteError(w, http.StatusForbidden, "仅超级管理员可管理备份")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			WriteJSON(w, http.StatusOK, app.backupScheduler.Status())
+
+		case http.MethodPost:
+			var req struct {
+				Mode string `json:"mode"`
+			}
+			if r.ContentLength != 0 {
+				if err := ReadJSONBody(r, &req); err != nil {
+					writeBodyError(w, err)
+					return
+				}
+			}
+			if req.Mode != "" && req.Mode != "full" && req.Mode != "incremental" {
+				WriteError(w, http.StatusBadRequest, "备份模式必须为 full 或 incremental")
+				return
+			}
+			if err := app.backupScheduler.Trigger(req.Mode, "manual"); err != nil {
+				if errors.Is(err, backup.ErrBackupRunning) {
+					WriteError(w, http.StatusConflict, "已有备份正在进行")
+					return
+				}
+				if errors.Is(err, lease.ErrHeld) {
+					WriteError(w, http.StatusConflict, "该任务正在其他实例上运行")
+					return
+				}
+				WriteError(w, http.StatusInternalServerError, "启动备份失败")
+				return
+			}
+			WriteJSON(w, http.StatusAccepted, map[string]string{"status": "started"})
+
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
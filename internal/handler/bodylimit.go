@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+)
+
+// selfLimitedRoutes read bodies no route limit fits and cap them on their
+// own: upload chunks at upload.MaxChunkSize, worker results at
+// MaxResultSize.
+var selfLimitedRoutes = []string{"/api/documents/uploads/", "/api/video-worker/jobs/"}
+
+// queryBodyRoutes accept a question with a base64 image in image_data.
+var queryBodyRoutes = map[string]bool{
+	"/api/query":             true,
+	"/api/widget/query":      true,
+	"/api/admin/query/debug": true,
+	"/api/pending/create":    true,
+	"/api/replica/pending":   true,
+}
+
+// RequestBodyLimit returns the body limit of a request for
+// middleware.BodyLimit. body_limit.routes wins, the exact path before the
+// longest matching prefix; file uploads get the upload size limit plus room
+// for the form fields, questions body_limit.query_kb, /api/config
+// body_limit.config_kb and everything else body_limit.default_kb.
+func RequestBodyLimit(app *App) func(r *http.Request) int64 {
+	return func(r *http.Request) int64 {
+		cfg := app.configManager.Get()
+		if cfg == nil {
+			return 1 << 20
+		}
+		bl := cfg.BodyLimit
+		path := r.URL.Path
+		if kb, ok := bl.Routes[path]; ok {
+			return int64(kb) << 10
+		}
+		best := ""
+		for prefix := range bl.Routes {
+			if strings.HasSuffix(prefix, "/") && strings.HasPrefix(path, prefix) && len(prefix) > len(best) {
+				best = prefix
+			}
+		}
+		if best != "" {
+			return int64(bl.Routes[best]) << 10
+		}
+		for _, prefix := range selfLimitedRoutes {
+			if strings.HasPrefix(path, prefix) {
+				return 0
+			}
+		}
+		ct := r.Header.Get("Content-Type")
+		if strings.HasPrefix(ct, "multipart/form-data") || strings.HasPrefix(ct, "application/octet-stream") {
+			return int64(cfg.Video.MaxUploadSizeMB)<<20 + 10<<20
+		}
+		switch {
+		case queryBodyRoutes[path]:
+			return int64(bl.QueryKB) << 10
+		case path == "/api/config":
+			return int64(bl.ConfigKB) << 10
+		}
+		return int64(bl.DefaultKB) << 10
+	}
+}
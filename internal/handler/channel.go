@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"log"
+	"net/http"
+
+	"askflow/internal/channel"
+)
+
+// runChannelTask runs channel message handling in the background so webhook
+// calls return immediately; both Telegram and WeChat retry slow callbacks.
+func runChannelTask(name string, fn func()) {
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[Channel] panic in %s handler: %v", name, r)
+			}
+		}()
+		fn()
+	}()
+}
+
+// HandleTelegramWebhook receives Bot API updates set via setWebhook.
+// The request must carry the configured X-Telegram-Bot-Api-Secret-Token.
+func HandleTelegramWebhook(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		cfg := app.channelService.Config().Telegram
+		if !cfg.Enabled {
+			WriteError(w, http.StatusNotFound, "channel disabled")
+			return
+		}
+		if !channel.VerifyTelegramSecret(cfg, r.Header.Get("X-Telegram-Bot-Api-Secret-Token")) {
+			WriteError(w, http.StatusUnauthorized, "invalid secret token")
+			return
+		}
+		var update channel.TelegramUpdate
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&update); err != nil {
+			WriteError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		runChannelTask("telegram", func() { app.channelService.HandleTelegramUpdate(update) })
+		WriteJSON(w, http.StatusOK, map[string]bool{"ok": true})
+	}
+}
+
+// HandleWeChatWebhook handles the WeChat official account server callback:
+// GET for URL verification (echostr) and POST for pushed messages.
+func HandleWeChatWebhook(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := app.channelService.Config().WeChat
+		if !cfg.Enabled {
+			WriteError(w, http.StatusNotFound, "channel disabled")
+			return
+		}
+		q := r.URL.Query()
+		if !channel.VerifyWeChatSignature(cfg, q.Get("signature"), q.Get("timestamp"), q.Get("nonce")) {
+			WriteError(w, http.StatusUnauthorized, "invalid signature")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte(q.Get("echostr")))
+
+		case http.MethodPost:
+			var msg channel.WeChatMessage
+			if err := xml.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&msg); err != nil {
+				WriteError(w, http.StatusBadRequest, "invalid request body")
+				return
+			}
+			runChannelTask("wechat", func() { app.channelService.HandleWeChatMessage(msg) })
+			// "success" tells WeChat not to retry and not to show an error to the user
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte("success"))
+
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"askflow/internal/auth"
+	"askflow/internal/config"
+	"askflow/internal/email"
+	"askflow/internal/embedding"
+	"askflow/internal/llm"
+	"askflow/internal/vectorstore"
+)
+
+// ConfigCheck is the outcome of one connectivity probe run by /api/config/validate.
+type ConfigCheck struct {
+	Status     string `json:"status"` // "ok", "error" or "skipped"
+	Message    string `json:"message,omitempty"`
+	LatencyMs  int64  `json:"latency_ms,omitempty"`
+	Dimensions int    `json:"dimensions,omitempty"` // embedding and image_embedding only
+}
+
+// ConfigValidation is the response of /api/config/validate. Errors maps config
+// keys (as used by PUT /api/config) to what is wrong with them.
+type ConfigValidation struct {
+	Valid  bool                   `json:"valid"`
+	Errors map[string]string      `json:"errors"`
+	Checks map[string]ConfigCheck `json:"checks"`
+}
+
+// HandleConfigValidate handles POST /api/config/validate. The body has the same
+// shape as PUT /api/config; the updates are applied to a copy of the current
+// config, never saved, and the LLM, embedding, SMTP and OAuth settings they
+// touch are probed with live calls. An empty body checks the saved config.
+func HandleConfigValidate(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		_, role, err := GetAdminSession(app, r)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		updates := map[string]interface{}{}
+		if r.ContentLength != 0 {
+			if err := ReadJSONBody(r, &updates); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+		}
+		if role != "super_admin" {
+			for key := range updates {
+				if strings.HasPrefix(key, "admin.") || strings.HasPrefix(key, "sso.") || strings.HasPrefix(key, "tenants.") || strings.HasPrefix(key, "usage.") || strings.HasPrefix(key, "abuse.") || strings.HasPrefix(key, "sessions.") {
+					WriteError(w, http.StatusForbidden, "仅超级管理员可修改管理员账户设置")
+					return
+				}
+			}
+		}
+
+		WriteJSON(w, http.StatusOK, app.ValidateConfig(r.Context(), updates))
+	}
+}
+
+// ValidateConfig dry-runs updates and probes the affected services.
+func (a *App) ValidateConfig(ctx context.Context, updates map[string]interface{}) *ConfigValidation {
+	cfg, fieldErrs := a.configManager.Validate(updates)
+	v := &ConfigValidation{Errors: fieldErrs, Checks: make(map[string]ConfigCheck)}
+
+	all := len(updates) == 0
+	touched := func(prefix string) bool {
+		if all {
+			return true
+		}
+		for key := range updates {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	// A saved secret is only sent to the endpoint it was saved for: when the
+	// endpoint changes, the secret has to be supplied again.
+	secretMoved := func(endpointKey, secretKey string) bool {
+		_, endpointSet := updates[endpointKey]
+		_, secretSet := updates[secretKey]
+		return endpointSet && !secretSet
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	probe := func(name string, fn func() (ConfigCheck, map[string]string)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("[ConfigValidate] panic in %s probe: %v", name, r)
+				}
+			}()
+			start := time.Now()
+			check, errs := fn()
+			if check.Status == "ok" {
+				check.LatencyMs = time.Since(start).Milliseconds()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			v.Checks[name] = check
+			for k, msg := range errs {
+				if _, ok := v.Errors[k]; !ok {
+					v.Errors[k] = msg
+				}
+			}
+		}()
+	}
+
+	if touched("llm.") && !hasFieldError(fieldErrs, "llm.") {
+		probe("llm", func() (ConfigCheck, map[string]string) {
+			return probeLLM(ctx, cfg.LLM, secretMoved("llm.endpoint", "llm.api_key"))
+		})
+	}
+	if touched("embedding.") && !hasFieldError(fieldErrs, "embedding.") {
+		probe("embedding", func() (ConfigCheck, map[string]string) {
+			return a.probeEmbedding(ctx, cfg.Embedding, secretMoved("embedding.endpoint", "embedding.api_key"))
+		})
+	}
+	if touched("image_embedding.") && cfg.ImageEmbed.Enabled && !hasFieldError(fieldErrs, "image_embedding.") {
+		probe("image_embedding", func() (ConfigCheck, map[string]string) {
+			return probeImageEmbedding(ctx, cfg.ImageEmbed, secretMoved("image_embedding.endpoint", "image_embedding.api_key"))
+		})
+	}
+	if touched("smtp.") && !hasFieldError(fieldErrs, "smtp.") {
+		probe("smtp", func() (ConfigCheck, map[string]string) {
+			return probeSMTP(cfg.SMTP, secretMoved("smtp.host", "smtp.password"))
+		})
+	}
+	for name, p := range cfg.OAuth.Providers {
+		prefix := "oauth.providers." + name + "."
+		if !touched(prefix) || hasFieldError(fieldErrs, prefix) {
+			continue
+		}
+		probe("oauth."+name, func() (ConfigCheck, map[string]string) {
+			return probeOAuth(ctx, prefix, p, secretMoved(prefix+"token_url", prefix+"client_secret"))
+		})
+	}
+	wg.Wait()
+
+	v.Valid = len(v.Errors) == 0
+	return v
+}
+
+func hasFieldError(errs map[string]string, prefix string) bool {
+	for k := range errs {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// requireFields returns an error for each empty field, keyed prefix+name.
+func requireFields(prefix string, fields map[string]string) map[string]string {
+	errs := make(map[string]string)
+	for name, value := range fields {
+		if strings.TrimSpace(value) == "" {
+			errs[prefix+name] = "必填项不能为空"
+		}
+	}
+	return errs
+}
+
+func failedCheck(msg string) ConfigCheck {
+	return ConfigCheck{Status: "error", Message: msg}
+}
+
+var httpStatusRe = regexp.MustCompile(`\(HTTP (\d{3})\)`)
+
+// apiErrorField guesses which setting an OpenAI-compatible API error points
+// at: rejected credentials are the key, an unknown model is the model name,
+// and anything else (unreachable host, wrong path) is the endpoint.
+func apiErrorField(prefix string, err error) string {
+	msg := err.Error()
+	status := 0
+	if m := httpStatusRe.FindStringSubmatch(msg); m != nil {
+		status, _ = strconv.Atoi(m[1])
+	}
+	lower := strings.ToLower(msg)
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return prefix + "api_key"
+	case (status == http.StatusNotFound || status == http.StatusBadRequest) && strings.Contains(lower, "model"):
+		return prefix + "model_name"
+	default:
+		return prefix + "endpoint"
+	}
+}
+
+// probeDetail shortens an upstream error for display.
+func probeDetail(err error) string {
+	msg := err.Error()
+	if len(msg) > 300 {
+		msg = msg[:300] + "..."
+	}
+	return msg
+}
+
+func probeLLM(ctx context.Context, c config.LLMConfig, secretMoved bool) (ConfigCheck, map[string]string) {
+	if errs := requireFields("llm.", map[string]string{"endpoint": c.Endpoint, "api_key": c.APIKey, "model_name": c.ModelName}); len(errs) > 0 {
+		return failedCheck("LLM 配置不完整"), errs
+	}
+	if secretMoved {
+		return ConfigCheck{Status: "skipped", Message: "修改服务地址时需重新填写 API Key"},
+			map[string]string{"llm.api_key": "修改服务地址时需重新填写 API Key"}
+	}
+	svc := llm.NewAPILLMService(c.Endpoint, c.APIKey, c.ModelName, c.Temperature, 16)
+	if _, err := svc.Generate(ctx, "", nil, "请回复：OK"); err != nil {
+		log.Printf("[ConfigValidate] LLM probe failed: %v", err)
+		return failedCheck("LLM 连接测试失败"), map[string]string{apiErrorField("llm.", err): probeDetail(err)}
+	}
+	return ConfigCheck{Status: "ok"}, nil
+}
+
+func (a *App) probeEmbedding(ctx context.Context, c config.EmbeddingConfig, secretMoved bool) (ConfigCheck, map[string]string) {
+	if errs := requireFields("embedding.", map[string]string{"endpoint": c.Endpoint, "api_key": c.APIKey, "model_name": c.ModelName}); len(errs) > 0 {
+		return failedCheck("Embedding 配置不完整"), errs
+	}
+	if secretMoved {
+		return ConfigCheck{Status: "skipped", Message: "修改服务地址时需重新填写 API Key"},
+			map[string]string{"embedding.api_key": "修改服务地址时需重新填写 API Key"}
+	}
+	svc := embedding.NewAPIEmbeddingService(c.Endpoint, c.APIKey, c.ModelName, c.UseMultimodal)
+	vec, err := svc.Embed(ctx, "hello")
+	if err != nil {
+		log.Printf("[ConfigValidate] embedding probe failed: %v", err)
+		return failedCheck("Embedding 连接测试失败"), map[string]string{apiErrorField("embedding.", err): probeDetail(err)}
+	}
+	if len(vec) == 0 {
+		return failedCheck("Embedding 服务返回了空向量"), map[string]string{"embedding.model_name": "模型返回了空向量"}
+	}
+	check := ConfigCheck{Status: "ok", Dimensions: len(vec)}
+
+	// Vectors of different dimensions cannot be compared, so a model switch
+	// needs the knowledge base to be re-imported
+	stored, err := a.storedEmbeddingDimensions()
+	if err != nil {
+		log.Printf("[ConfigValidate] read stored embedding dimensions: %v", err)
+	} else if stored > 0 && stored != len(vec) {
+		msg := fmt.Sprintf("模型向量维度为 %d，与知识库已有向量维度 %d 不一致，切换后需重新导入文档", len(vec), stored)
+		check.Status = "error"
+		check.Message = msg
+		return check, map[string]string{"embedding.model_name": msg}
+	}
+	return check, nil
+}
+
+// probeImage is a blank 8x8 PNG the image embedding model is probed with.
+const probeImage = "data:image/png;base64,iVBORw0KGgoAAAANSUhEUgAAAAgAAAAIAQAAAADsdIMmAAAADElEQVR42mP4z4ACAT/QB/lnLUmhAAAAAElFTkSuQmCC"
+
+func probeImageEmbedding(ctx context.Context, c config.ImageEmbedConfig, secretMoved bool) (ConfigCheck, map[string]string) {
+	if errs := requireFields("image_embedding.", map[string]string{"endpoint": c.Endpoint, "model_name": c.ModelName}); len(errs) > 0 {
+		return failedCheck("图片向量模型配置不完整"), errs
+	}
+	if secretMoved {
+		return ConfigCheck{Status: "skipped", Message: "修改服务地址时需重新填写 API Key"},
+			map[string]string{"image_embedding.api_key": "修改服务地址时需重新填写 API Key"}
+	}
+	svc := embedding.NewAPIEmbeddingService(c.Endpoint, c.APIKey, c.ModelName, true)
+	vec, err := svc.EmbedImageURL(ctx, probeImage)
+	if err != nil {
+		log.Printf("[ConfigValidate] image embedding probe failed: %v", err)
+		return failedCheck("图片向量模型连接测试失败"), map[string]string{apiErrorField("image_embedding.", err): probeDetail(err)}
+	}
+	if len(vec) == 0 {
+		return failedCheck("图片向量模型返回了空向量"), map[string]string{"image_embedding.model_name": "模型返回了空向量"}
+	}
+	return ConfigCheck{Status: "ok", Dimensions: len(vec)}, nil
+}
+
+// storedEmbeddingDimensions returns the dimension of the stored chunk
+// vectors, or 0 when the knowledge base is empty.
+func (a *App) storedEmbeddingDimensions() (int, error) {
+	var blob []byte
+	err := a.db.QueryRow("SELECT embedding FROM chunks LIMIT 1").Scan(&blob)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(vectorstore.DeserializeVector(blob)), nil
+}
+
+func probeSMTP(c config.SMTPConfig, secretMoved bool) (ConfigCheck, map[string]string) {
+	fields := map[string]string{"host": c.Host}
+	method := strings.ToUpper(strings.TrimSpace(c.AuthMethod))
+	if method != "NONE" && method != "NOAUTH" {
+		fields["username"] = c.Username
+		fields["password"] = c.Password
+	}
+	errs := requireFields("smtp.", fields)
+	if c.Port <= 0 || c.Port > 65535 {
+		errs["smtp.port"] = "端口必须在 1-65535 之间"
+	}
+	if len(errs) > 0 {
+		return failedCheck("SMTP 配置不完整"), errs
+	}
+	if secretMoved && method != "NONE" && method != "NOAUTH" {
+		return ConfigCheck{Status: "skipped", Message: "修改服务器地址时需重新填写密码"},
+			map[string]string{"smtp.password": "修改服务器地址时需重新填写密码"}
+	}
+	svc := email.NewService(func() config.SMTPConfig { return c })
+	if err := svc.Verify(); err != nil {
+		log.Printf("[ConfigValidate] SMTP probe failed: %v", err)
+		field := "smtp.host"
+		if strings.Contains(err.Error(), "认证失败") {
+			field = "smtp.password"
+		}
+		return failedCheck("SMTP 握手失败"), map[string]string{field: probeDetail(err)}
+	}
+	return ConfigCheck{Status: "ok"}, nil
+}
+
+func probeOAuth(ctx context.Context, prefix string, p config.OAuthProviderConfig, secretMoved bool) (ConfigCheck, map[string]string) {
+	if secretMoved {
+		return ConfigCheck{Status: "skipped", Message: "修改 Token 地址时需重新填写 Client Secret"},
+			map[string]string{prefix + "client_secret": "修改 Token 地址时需重新填写 Client Secret"}
+	}
+	field, err := auth.ProbeOAuthProvider(ctx, p)
+	if err != nil {
+		log.Printf("[ConfigValidate] OAuth probe %s failed: %v", prefix, err)
+		return failedCheck("OAuth 配置检查失败"), map[string]string{prefix + field: probeDetail(err)}
+	}
+	return ConfigCheck{Status: "ok"}, nil
+}
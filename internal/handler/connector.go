@@ -0,0 +1,300 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"askflow/internal/connector"
+	"askflow/internal/lease"
+	"askflow/internal/rbac"
+)
+
+// connectorAuthTTL is how long an admin has to complete a connector
+// authorization.
+const connectorAuthTTL = 10 * time.Minute
+
+// connectorRedirectURI is where Google and Microsoft send admins back after
+// authorizing a connector. It is the same for every tenant, as it must be
+// registered with the provider.
+func connectorRedirectURI(app *App, r *http.Request) string {
+	return GetBaseURL(r) + app.appPath("/api/connectors/oauth/callback")
+}
+
+// connectorEventToken is the bearer token an S3 connector's bucket sends
+// its event notifications with. It is derived from the connector ID, so it
+// needs no storage and stops working when the connector is deleted.
+func connectorEventToken(app *App, id string) string {
+	mac := hmac.New(sha256.New, app.configManager.SigningKey("connector_events"))
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// HandleAdminConnectors manages the Confluence, Notion, Git, Google Drive,
+// SharePoint and S3 connectors:
+//
+//	GET  /api/admin/connectors?product_id=   connectors of a product (all without product_id)
+//	POST /api/admin/connectors               add a connector
+func HandleAdminConnectors(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			productID := r.URL.Query().Get("product_id")
+			if !IsValidOptionalID(productID) {
+				WriteError(w, http.StatusBadRequest, "invalid product_id")
+				return
+			}
+			if _, _, err := RequireAdminPermission(app, r, rbac.PermManageDocs, productID); err != nil {
+				WriteAdminSessionError(w, err)
+				return
+			}
+			list, err := app.connectors.List(productID)
+			if err != nil {
+				log.Printf("[Connector] list error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "failed to list connectors")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]interface{}{"connectors": list})
+		case http.MethodPost:
+			var req connector.Input
+			if err := ReadJSONBody(r, &req); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			if !IsValidOptionalID(req.ProductID) {
+				WriteError(w, http.StatusBadRequest, "invalid product_id")
+				return
+			}
+			if _, _, err := RequireAdminPermission(app, r, rbac.PermManageDocs, req.ProductID); err != nil {
+				WriteAdminSessionError(w, err)
+				return
+			}
+			if req.ProductID != "" && !app.productInTenant(r, req.ProductID) {
+				WriteError(w, http.StatusNotFound, "产品不存在")
+				return
+			}
+			c, err := app.connectors.Create(req)
+			if err != nil {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			WriteJSON(w, http.StatusCreated, c)
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
+
+// HandleAdminConnectorByID manages one connector:
+//
+//	GET    /api/admin/connectors/{id}         the connector
+//	PUT    /api/admin/connectors/{id}         change its settings (type and product stay)
+//	DELETE /api/admin/connectors/{id}         remove it and its documents
+//	POST   /api/admin/connectors/{id}/sync    sync now, in the background
+//	GET    /api/admin/connectors/{id}/pages   the synced pages and their documents
+//	POST   /api/admin/connectors/{id}/authorize        Google/Microsoft sign-in URL
+//	GET    /api/admin/connectors/{id}/folders?parent=  folders to pick as the scope
+//	GET    /api/admin/connectors/{id}/events           S3 event notification URL and token
+func HandleAdminConnectorByID(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/admin/connectors/"), "/")
+		if !IsValidHexID(id) {
+			WriteError(w, http.StatusBadRequest, "invalid connector ID")
+			return
+		}
+		existing, err := app.connectors.Get(id)
+		if errors.Is(err, connector.ErrNotFound) {
+			WriteError(w, http.StatusNotFound, "连接器不存在")
+			return
+		} else if err != nil {
+			log.Printf("[Connector] get error: %v", err)
+			WriteError(w, http.StatusInternalServerError, "failed to load connector")
+			return
+		}
+		if _, _, err := RequireAdminPermission(app, r, rbac.PermManageDocs, existing.ProductID); err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+
+		switch {
+		case action == "sync" && r.Method == http.MethodPost:
+			if err := app.connectors.Trigger(id); err != nil {
+				if errors.Is(err, connector.ErrRunning) {
+					WriteError(w, http.StatusConflict, "连接器正在同步")
+					return
+				}
+				if errors.Is(err, lease.ErrHeld) {
+					WriteError(w, http.StatusConflict, "该任务正在其他实例上运行")
+					return
+				}
+				log.Printf("[Connector] trigger error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "启动同步失败")
+				return
+			}
+			WriteJSON(w, http.StatusAccepted, map[string]string{"status": "started"})
+		case action == "pages" && r.Method == http.MethodGet:
+			pages, err := app.connectors.Pages(id)
+			if err != nil {
+				log.Printf("[Connector] list pages error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "failed to list pages")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]interface{}{"pages": pages})
+		case action == "authorize" && r.Method == http.MethodPost:
+			state := app.connectorSigner.Sign(id, connectorAuthTTL, existing.Email)
+			authURL, err := app.connectors.AuthURL(id, connectorRedirectURI(app, r), state)
+			if err != nil {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{"url": authURL})
+		case action == "folders" && r.Method == http.MethodGet:
+			folders, err := app.connectors.Folders(r.Context(), id, r.URL.Query().Get("parent"))
+			if err != nil {
+				switch {
+				case errors.Is(err, connector.ErrNotAuthorized):
+					WriteError(w, http.StatusBadRequest, "连接器尚未授权，请先完成授权")
+					return
+				case errors.Is(err, connector.ErrInvalidFolder):
+					WriteError(w, http.StatusBadRequest, "文件夹 ID 无效")
+					return
+				}
+				log.Printf("[Connector] list folders of %s: %v", id, err)
+				WriteError(w, http.StatusBadGateway, "读取文件夹失败")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]interface{}{"folders": folders})
+		case action == "events" && r.Method == http.MethodGet:
+			if existing.Type != connector.TypeS3 {
+				WriteError(w, http.StatusBadRequest, "只有 S3 连接器支持事件通知")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{
+				"url":   GetBaseURL(r) + app.appPath("/api/connectors/"+id+"/events"),
+				"token": connectorEventToken(app, id),
+			})
+		case action != "":
+			WriteError(w, http.StatusNotFound, "not found")
+		case r.Method == http.MethodGet:
+			WriteJSON(w, http.StatusOK, existing)
+		case r.Method == http.MethodPut:
+			var req connector.Input
+			if err := ReadJSONBody(r, &req); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			c, err := app.connectors.Update(id, req)
+			if err != nil {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			WriteJSON(w, http.StatusOK, c)
+		case r.Method == http.MethodDelete:
+			if err := app.connectors.Delete(id); err != nil {
+				switch {
+				case errors.Is(err, connector.ErrNotFound):
+					WriteError(w, http.StatusNotFound, "连接器不存在")
+				case errors.Is(err, connector.ErrRunning):
+					WriteError(w, http.StatusConflict, "连接器正在同步")
+				default:
+					log.Printf("[Connector] delete error: %v", err)
+					WriteError(w, http.StatusInternalServerError, "failed to delete connector")
+				}
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
+
+// HandleConnectorOAuthCallback completes a connector authorization: Google
+// or Microsoft redirect the admin here with a code, which is redeemed for
+// the connector's refresh token, and the admin is sent back to the admin
+// panel with connector_auth=ok or failed. The signed state names the
+// connector and expires after connectorAuthTTL.
+func HandleConnectorOAuthCallback(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		loginRoute := "/admin"
+		if cfg := app.configManager.Get(); cfg != nil && cfg.Admin.LoginRoute != "" {
+			loginRoute = cfg.Admin.LoginRoute
+		}
+		q := r.URL.Query()
+		id, err := app.connectorSigner.Verify(q.Get("state"), func(id string) (string, error) {
+			c, err := app.connectors.Get(id)
+			if err != nil {
+				return "", err
+			}
+			return c.Email, nil
+		})
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "invalid or expired OAuth state")
+			return
+		}
+		result := "ok"
+		if q.Get("error") != "" || q.Get("code") == "" {
+			log.Printf("[Connector] authorization of %s declined: %s", id, q.Get("error"))
+			result = "failed"
+		} else if err := app.connectors.Authorize(r.Context(), id, q.Get("code"), connectorRedirectURI(app, r)); err != nil {
+			log.Printf("[Connector] authorization of %s failed: %v", id, err)
+			result = "failed"
+		}
+		http.Redirect(w, r, app.appPath(loginRoute)+"?connector_auth="+result+"&connector="+id, http.StatusFound)
+	}
+}
+
+// HandleConnectorEvents receives the event notifications of an S3
+// connector's bucket (such as a MinIO webhook target) at
+// /api/connectors/{id}/events and syncs the connector, so new objects are
+// imported without waiting for its schedule. The request carries the
+// connector's event token as a bearer token; the body is not read, as the
+// sync lists the bucket anyway.
+func HandleConnectorEvents(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		id, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/connectors/"), "/")
+		if rest != "events" || !IsValidHexID(id) {
+			WriteError(w, http.StatusNotFound, "not found")
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !hmac.Equal([]byte(token), []byte(connectorEventToken(app, id))) {
+			WriteError(w, http.StatusUnauthorized, "invalid event token")
+			return
+		}
+		io.Copy(io.Discard, io.LimitReader(r.Body, 1<<20))
+		c, err := app.connectors.Get(id)
+		if errors.Is(err, connector.ErrNotFound) {
+			WriteError(w, http.StatusNotFound, "连接器不存在")
+			return
+		} else if err != nil {
+			log.Printf("[Connector] get error: %v", err)
+			WriteError(w, http.StatusInternalServerError, "failed to load connector")
+			return
+		}
+		if c.Type != connector.TypeS3 || !c.Enabled {
+			WriteError(w, http.StatusNotFound, "连接器不存在")
+			return
+		}
+		if err := app.connectors.Notify(id); err != nil {
+			log.Printf("[Connector] sync on event of %s: %v", id, err)
+			WriteError(w, http.StatusInternalServerError, "启动同步失败")
+			return
+		}
+		WriteJSON(w, http.StatusAccepted, map[string]string{"status": "started"})
+	}
+}
@@ -2,16 +2,23 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
+	"askflow/internal/archive"
+	"askflow/internal/audit"
 	"askflow/internal/document"
 	"askflow/internal/errlog"
+	"askflow/internal/middleware"
+	"askflow/internal/rbac"
 )
 
 // SupportedExtensions lists file extensions that can be imported.
@@ -37,7 +44,7 @@ func HandleDocuments(app *App) http.HandlerFunc {
 			return
 		}
 		// Require admin session for document listing
-		_, _, err := GetAdminSession(app, r)
+		userID, role, err := GetAdminSession(app, r)
 		if err != nil {
 			WriteAdminSessionError(w, err)
 			return
@@ -47,12 +54,33 @@ func HandleDocuments(app *App) http.HandlerFunc {
 			WriteError(w, http.StatusBadRequest, "invalid product_id")
 			return
 		}
-		docs, err := app.ListDocuments(productID)
+		if productID != "" && !app.productInTenant(r, productID) {
+			WriteError(w, http.StatusNotFound, "产品不存在")
+			return
+		}
+		batchID := r.URL.Query().Get("batch_id")
+		if !IsValidOptionalID(batchID) {
+			WriteError(w, http.StatusBadRequest, "invalid batch_id")
+			return
+		}
+		docs, err := app.ListDocumentsInTenant(requestTenantID(r), productID)
 		if err != nil {
 			log.Printf("[Documents] list error: %v", err)
 			WriteError(w, http.StatusInternalServerError, "获取文档列表失败")
 			return
 		}
+		if productID == "" {
+			set, err := app.delegatedProductSet(r, userID, role, rbac.PermManageDocs)
+			if err != nil {
+				log.Printf("[Documents] list error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "获取文档列表失败")
+				return
+			}
+			docs = filterByProduct(docs, set, func(d document.DocumentInfo) string { return d.ProductID })
+		}
+		if batchID != "" {
+			docs = slices.DeleteFunc(docs, func(d document.DocumentInfo) bool { return d.BatchID != batchID })
+		}
 		if docs == nil {
 			docs = []document.DocumentInfo{}
 		}
@@ -60,6 +88,14 @@ func HandleDocuments(app *App) http.HandlerFunc {
 	}
 }
 
+// MaxUploadSizeMB returns the configured upload size limit in megabytes.
+func (a *App) MaxUploadSizeMB() int {
+	if cfg := a.configManager.Get(); cfg != nil {
+		return cfg.Video.MaxUploadSizeMB
+	}
+	return 0
+}
+
 // HandleDocumentUpload handles file upload for documents.
 func HandleDocumentUpload(app *App) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -69,7 +105,7 @@ func HandleDocumentUpload(app *App) http.HandlerFunc {
 		}
 
 		// Require admin session
-		_, _, err := GetAdminSession(app, r)
+		userID, role, err := GetAdminSession(app, r)
 		if err != nil {
 			WriteAdminSessionError(w, err)
 			return
@@ -84,9 +120,13 @@ func HandleDocumentUpload(app *App) http.HandlerFunc {
 		maxUploadSizeMB := cfg.Video.MaxUploadSizeMB
 		maxUploadSize := int64(maxUploadSizeMB)<<20 + 10<<20 // file limit + 10MB overhead
 		r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+		extendUploadDeadlines(w)
 
 		// Parse multipart form (32MB in memory, rest goes to temp files)
 		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			if writeBodyTooLarge(w, err) {
+				return
+			}
 			WriteError(w, http.StatusBadRequest, "failed to parse multipart form")
 			return
 		}
@@ -110,32 +150,74 @@ func HandleDocumentUpload(app *App) http.HandlerFunc {
 			return
 		}
 
-		// Determine file type from extension
-		fileType := DetectFileType(header.Filename)
-
-		// Validate video files have correct magic bytes to prevent disguised uploads
-		switch fileType {
-		case "mp4", "avi", "mkv", "mov", "webm":
-			if !IsValidVideoMagicBytes(fileData) {
-				WriteError(w, http.StatusBadRequest, "文件内容与扩展名不匹配")
+		// An optional .srt/.vtt file is used as the transcript of a video
+		var subtitle []byte
+		if sf, sh, err := r.FormFile("subtitle"); err == nil {
+			defer sf.Close()
+			if t := DetectFileType(sh.Filename); t != "srt" && t != "vtt" {
+				WriteError(w, http.StatusBadRequest, "字幕文件须为 .srt 或 .vtt 格式")
+				return
+			}
+			if subtitle, err = io.ReadAll(io.LimitReader(sf, maxSubtitleSize+1)); err != nil {
+				WriteError(w, http.StatusInternalServerError, "failed to read file")
+				return
+			}
+			if len(subtitle) > maxSubtitleSize {
+				WriteError(w, http.StatusBadRequest, "字幕文件过大")
 				return
 			}
 		}
 
-		req := document.UploadFileRequest{
-			FileName:  header.Filename,
-			FileData:  fileData,
-			FileType:  fileType,
-			ProductID: r.FormValue("product_id"),
-		}
-		doc, err := app.UploadFile(req)
-		if err != nil {
-			errlog.Logf("[API] file upload rejected file=%q type=%s: %v", header.Filename, fileType, err)
-			WriteError(w, http.StatusBadRequest, err.Error())
-			return
-		}
-		WriteJSON(w, http.StatusOK, doc)
+		storeUploadedDocument(app, w, r, userID, role, header.Filename, r.FormValue("product_id"), fileData, subtitle)
+	}
+}
+
+// maxSubtitleSize is the largest subtitle file accepted with a video.
+const maxSubtitleSize = 10 << 20
+
+// storeUploadedDocument validates a received file and adds it as a document
+// of productID, writing the document or the error as the response. It
+// reports whether the document was stored. subtitle is an optional subtitle
+// file for a video.
+func storeUploadedDocument(app *App, w http.ResponseWriter, r *http.Request, userID, role, fileName, productID string, fileData, subtitle []byte) bool {
+	if archive.IsZip(fileName) {
+		return storeUploadedArchive(app, w, r, userID, role, fileName, productID, fileData)
+	}
+
+	// Determine the file type from the content, so disguised files are
+	// rejected before they reach the parser
+	fileType, err := SniffFileType(fileName, fileData)
+	if err != nil {
+		errlog.Logf("[API] file upload rejected file=%q: %v", fileName, err)
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return false
 	}
+
+	req := document.UploadFileRequest{
+		FileName:  fileName,
+		FileData:  fileData,
+		FileType:  fileType,
+		ProductID: productID,
+		Subtitle:  subtitle,
+	}
+	if !app.HasAdminPermission(userID, role, rbac.PermManageDocs, req.ProductID) {
+		WriteError(w, http.StatusForbidden, "无权管理该产品的文档")
+		return false
+	}
+	if !app.allowDocumentWrite(w, r, req.ProductID) {
+		return false
+	}
+	doc, err := app.UploadFile(r.Context(), req)
+	if writeEmbeddingBusy(w, err) {
+		return false
+	}
+	if err != nil {
+		errlog.Logf("[API] file upload rejected file=%q type=%s: %v", fileName, fileType, err)
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	WriteJSON(w, http.StatusOK, doc)
+	return true
 }
 
 // HandleDocumentURLPreview fetches and parses URL content for preview.
@@ -154,10 +236,10 @@ func HandleDocumentURLPreview(app *App) http.HandlerFunc {
 			URL string `json:"url"`
 		}
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
 			return
 		}
-		result, err := app.PreviewURL(req.URL)
+		result, err := app.PreviewURL(r.Context(), req.URL)
 		if err != nil {
 			WriteError(w, http.StatusBadRequest, err.Error())
 			return
@@ -174,17 +256,27 @@ func HandleDocumentURL(app *App) http.HandlerFunc {
 			return
 		}
 		// Require admin session
-		_, _, err := GetAdminSession(app, r)
+		userID, role, err := GetAdminSession(app, r)
 		if err != nil {
 			WriteAdminSessionError(w, err)
 			return
 		}
 		var req document.UploadURLRequest
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
+			return
+		}
+		if !app.HasAdminPermission(userID, role, rbac.PermManageDocs, req.ProductID) {
+			WriteError(w, http.StatusForbidden, "无权管理该产品的文档")
+			return
+		}
+		if !app.allowDocumentWrite(w, r, req.ProductID) {
+			return
+		}
+		doc, err := app.UploadURL(r.Context(), req)
+		if writeEmbeddingBusy(w, err) {
 			return
 		}
-		doc, err := app.UploadURL(req)
 		if err != nil {
 			errlog.Logf("[API] URL upload rejected url=%q: %v", req.URL, err)
 			WriteError(w, http.StatusBadRequest, err.Error())
@@ -194,101 +286,169 @@ func HandleDocumentURL(app *App) http.HandlerFunc {
 	}
 }
 
-// HandlePublicDocumentDownload allows regular users to download source documents
-// if the product has allow_download enabled and the document type is downloadable.
-func HandlePublicDocumentDownload(app *App) http.HandlerFunc {
-	downloadableTypes := map[string]bool{
-		"pdf": true, "doc": true, "docx": true, "word": true,
-		"xls": true, "xlsx": true, "excel": true,
-		"ppt": true, "pptx": true,
-		"mp4": true, "avi": true, "mkv": true, "mov": true, "webm": true,
-		"video": true,
+// downloadableTypes lists the document types end users may download when
+// their product has allow_download enabled.
+var downloadableTypes = map[string]bool{
+	"pdf": true, "doc": true, "docx": true, "word": true,
+	"xls": true, "xlsx": true, "excel": true,
+	"ppt": true, "pptx": true,
+	"mp4": true, "avi": true, "mkv": true, "mov": true, "webm": true,
+	"video": true,
+}
+
+// HandleDocumentDownload serves GET /api/documents/{id}/download, the original
+// file a document was imported from. Admins with manage-docs permission on
+// the document's product may download any document; end users may download
+// downloadable types when the product has allow_download enabled. The
+// session token may also be passed as ?token= for direct download links, and
+// PDFs are shown in the browser with ?inline=1 so source links can open them
+// at the cited page. Every download attempt is recorded in the audit log.
+func HandleDocumentDownload(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		docID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/documents/"), "/download")
+		serveDocumentDownload(app, w, r, docID)
 	}
+}
+
+// HandlePublicDocumentDownload serves the legacy
+// /api/documents/public-download/{id} path used by older chat pages.
+func HandlePublicDocumentDownload(app *App) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
-			return
-		}
-		// Require user session (support token in query param for direct download links)
-		authHeader := r.Header.Get("Authorization")
-		token := strings.TrimPrefix(authHeader, "Bearer ")
-		if token == "" || token == authHeader {
-			token = r.URL.Query().Get("token")
-		}
-		if token == "" {
-			WriteError(w, http.StatusUnauthorized, "未登录")
-			return
-		}
-		session, sErr := app.sessionManager.ValidateSession(token)
-		if sErr != nil {
-			WriteError(w, http.StatusUnauthorized, "会话已过期")
-			return
-		}
-		_ = session
 		docID := strings.TrimPrefix(r.URL.Path, "/api/documents/public-download/")
-		if docID == "" || !IsValidHexID(docID) {
-			WriteError(w, http.StatusBadRequest, "invalid document ID")
-			return
+		serveDocumentDownload(app, w, r, docID)
+	}
+}
+
+func serveDocumentDownload(app *App, w http.ResponseWriter, r *http.Request, docID string) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !IsValidHexID(docID) {
+		WriteError(w, http.StatusBadRequest, "invalid document ID")
+		return
+	}
+	token := requestToken(r, SessionCookieName, AdminSessionCookieName)
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" {
+		WriteError(w, http.StatusUnauthorized, "未登录")
+		return
+	}
+	session, sErr := app.sessionManager.ValidateSession(token)
+	if sErr != nil {
+		WriteError(w, http.StatusUnauthorized, "会话已过期")
+		return
+	}
+
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	defer recordDocumentDownload(app, r, session.UserID, docID, rec)
+
+	docInfo, dErr := app.GetDocumentInfo(docID)
+	if dErr != nil || !app.productInTenant(r, docInfo.ProductID) {
+		WriteError(rec, http.StatusNotFound, "文档未找到")
+		return
+	}
+	if !documentDownloadAllowedForAdmin(app, session.UserID, docInfo.ProductID) {
+		// Documents without a product are public; check the product the user is browsing.
+		productID := docInfo.ProductID
+		if productID == "" {
+			productID = r.URL.Query().Get("product_id")
 		}
-		productID := r.URL.Query().Get("product_id")
 		if productID == "" {
-			WriteError(w, http.StatusBadRequest, "product_id is required")
+			WriteError(rec, http.StatusBadRequest, "product_id is required")
 			return
 		}
-		// Check product allows download
 		p, pErr := app.GetProduct(productID)
-		if pErr != nil || p == nil || !p.AllowDownload {
-			WriteError(w, http.StatusForbidden, "该产品不允许下载参考文档")
-			return
-		}
-		// Check document type is downloadable
-		docInfo, dErr := app.GetDocumentInfo(docID)
-		if dErr != nil {
-			WriteError(w, http.StatusNotFound, "文档未找到")
+		if pErr != nil || p == nil || !p.AllowDownload || !app.productInTenant(r, productID) {
+			WriteError(rec, http.StatusForbidden, "该产品不允许下载参考文档")
 			return
 		}
-		docType := strings.ToLower(docInfo.Type)
-		if !downloadableTypes[docType] {
-			WriteError(w, http.StatusForbidden, "该文档类型不支持下载")
+		if !downloadableTypes[strings.ToLower(docInfo.Type)] {
+			WriteError(rec, http.StatusForbidden, "该文档类型不支持下载")
 			return
 		}
-		// Verify document belongs to the product
-		if docInfo.ProductID != productID && docInfo.ProductID != "" {
-			WriteError(w, http.StatusForbidden, "文档不属于该产品")
-			return
-		}
-		filePath, fileName, fErr := app.docManager.GetFilePath(docID)
-		if fErr != nil {
-			WriteError(w, http.StatusNotFound, "文件未找到")
-			return
-		}
-		// Verify file path stays within expected data directory (resolve symlinks to prevent bypass)
-		absPath, _ := filepath.Abs(filePath)
-		absDataDir, _ := filepath.Abs(filepath.Join(".", "data"))
-		// Resolve symlinks to prevent symlink-based path traversal
-		if realPath, err := filepath.EvalSymlinks(absPath); err == nil {
-			absPath = realPath
-		}
-		if realDataDir, err := filepath.EvalSymlinks(absDataDir); err == nil {
-			absDataDir = realDataDir
-		}
-		if !strings.HasPrefix(absPath, absDataDir+string(filepath.Separator)) {
-			WriteError(w, http.StatusForbidden, "forbidden")
-			return
+	}
+
+	orig, fErr := app.docManager.GetOriginal(docID)
+	if fErr != nil {
+		WriteError(rec, http.StatusNotFound, "文件未找到")
+		return
+	}
+	// Sanitize filename to prevent header injection
+	safeName := strings.Map(func(r rune) rune {
+		if r == '"' || r == '\n' || r == '\r' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, orig.Name)
+	disposition := "attachment"
+	if r.URL.Query().Get("inline") == "1" && strings.EqualFold(filepath.Ext(orig.Name), ".pdf") {
+		disposition = "inline"
+		rec.Header().Set("Content-Type", "application/pdf")
+	}
+	rec.Header().Set("Content-Disposition", disposition+"; filename=\""+safeName+"\"")
+	rec.Header().Set("X-Content-Type-Options", "nosniff")
+	orig.Serve(rec, r)
+}
+
+// documentDownloadAllowedForAdmin reports whether userID is an admin who may
+// manage documents of productID, and so may download any of its documents.
+func documentDownloadAllowedForAdmin(app *App, userID, productID string) bool {
+	if !app.IsAdminSession(userID) {
+		return false
+	}
+	role := app.GetAdminRole(userID)
+	return role != "" && app.HasAdminPermission(userID, role, rbac.PermManageDocs, productID)
+}
+
+// recordDocumentDownload writes a document.download audit entry for a
+// download by userID, which may be an admin or an end user.
+func recordDocumentDownload(app *App, r *http.Request, userID, docID string, rec *statusRecorder) {
+	entry := audit.Entry{
+		ActorID:    userID,
+		ActorName:  userID,
+		ActorRole:  "user",
+		IP:         middleware.GetClientIP(r),
+		Action:     "document.download",
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		ResourceID: docID,
+		Status:     rec.status,
+	}
+	if app.IsAdminSession(userID) {
+		entry.ActorName = app.adminDisplayName(userID)
+		entry.ActorRole = app.GetAdminRole(userID)
+	} else {
+		var email string
+		if err := app.readDB.QueryRow(`SELECT COALESCE(email, '') FROM users WHERE id = ?`, userID).Scan(&email); err == nil && email != "" {
+			entry.ActorName = email
 		}
-		safeName := strings.Map(func(r rune) rune {
-			if r == '"' || r == '\n' || r == '\r' || r == '\\' {
-				return '_'
-			}
-			return r
-		}, fileName)
-		w.Header().Set("Content-Disposition", "attachment; filename=\""+safeName+"\"")
-		w.Header().Set("X-Content-Type-Options", "nosniff")
-		http.ServeFile(w, r, filePath)
 	}
+	if err := app.auditService.Record(entry); err != nil {
+		log.Printf("[Audit] %v", err)
+	}
+}
+
+// DocumentProduct resolves the product of the document addressed by an
+// /api/documents/{id}[/...] path.
+func DocumentProduct(app *App, r *http.Request) (string, bool) {
+	docID, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/documents/"), "/")
+	if !IsValidHexID(docID) {
+		return "", false
+	}
+	info, err := app.docManager.GetDocumentInfo(docID)
+	if err != nil {
+		return "", false
+	}
+	return info.ProductID, true
 }
 
-// HandleDocumentByID handles GET (download) and DELETE for a specific document.
+// HandleDocumentByID handles GET /review, GET /progress, DELETE /cancel,
+// PUT /priority and DELETE for a specific document. The router wraps it in
+// RequireProductPermission with DocumentProduct, so the admin holds
+// manage_docs on the document's product.
 func HandleDocumentByID(app *App) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Extract path after /api/documents/
@@ -298,9 +458,9 @@ func HandleDocumentByID(app *App) http.HandlerFunc {
 			return
 		}
 
-		// Handle /api/documents/{id}/download
-		if strings.HasSuffix(path, "/download") {
-			docID := strings.TrimSuffix(path, "/download")
+		// Handle /api/documents/{id}/progress
+		if strings.HasSuffix(path, "/progress") {
+			docID := strings.TrimSuffix(path, "/progress")
 			if !IsValidHexID(docID) {
 				WriteError(w, http.StatusBadRequest, "invalid document ID")
 				return
@@ -309,40 +469,83 @@ func HandleDocumentByID(app *App) http.HandlerFunc {
 				WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
 				return
 			}
-			// Require admin session for downloads
-			_, _, err := GetAdminSession(app, r)
-			if err != nil {
+			if _, _, err := GetAdminSession(app, r); err != nil {
 				WriteAdminSessionError(w, err)
 				return
 			}
-			filePath, fileName, err := app.docManager.GetFilePath(docID)
-			if err != nil {
-				WriteError(w, http.StatusNotFound, "文件未找到")
+			if !app.documentInTenant(r, docID) {
+				WriteError(w, http.StatusNotFound, "文档未找到")
 				return
 			}
-			// Verify file path stays within expected data directory (resolve symlinks to prevent bypass)
-			absPath, _ := filepath.Abs(filePath)
-			absDataDir, _ := filepath.Abs(filepath.Join(".", "data"))
-			if realPath, err := filepath.EvalSymlinks(absPath); err == nil {
-				absPath = realPath
+			streamDocumentProgress(app, w, r, docID)
+			return
+		}
+
+		// Handle DELETE /api/documents/{id}/cancel
+		if strings.HasSuffix(path, "/cancel") {
+			docID := strings.TrimSuffix(path, "/cancel")
+			if !IsValidHexID(docID) {
+				WriteError(w, http.StatusBadRequest, "invalid document ID")
+				return
+			}
+			if r.Method != http.MethodDelete {
+				WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			if _, _, err := GetAdminSession(app, r); err != nil {
+				WriteAdminSessionError(w, err)
+				return
+			}
+			info, err := app.docManager.GetDocumentInfo(docID)
+			if err != nil || !app.productInTenant(r, info.ProductID) {
+				WriteError(w, http.StatusNotFound, "文档未找到")
+				return
+			}
+			if err := app.docManager.CancelProcessing(docID); err != nil {
+				WriteError(w, http.StatusConflict, err.Error())
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{"status": "canceled"})
+			return
+		}
+
+		// Handle PUT /api/documents/{id}/priority
+		if strings.HasSuffix(path, "/priority") {
+			docID := strings.TrimSuffix(path, "/priority")
+			if !IsValidHexID(docID) {
+				WriteError(w, http.StatusBadRequest, "invalid document ID")
+				return
+			}
+			if r.Method != http.MethodPut {
+				WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
 			}
-			if realDataDir, err := filepath.EvalSymlinks(absDataDir); err == nil {
-				absDataDir = realDataDir
+			if _, _, err := GetAdminSession(app, r); err != nil {
+				WriteAdminSessionError(w, err)
+				return
 			}
-			if !strings.HasPrefix(absPath, absDataDir+string(filepath.Separator)) {
-				WriteError(w, http.StatusForbidden, "forbidden")
+			info, err := app.docManager.GetDocumentInfo(docID)
+			if err != nil || !app.productInTenant(r, info.ProductID) {
+				WriteError(w, http.StatusNotFound, "文档未找到")
 				return
 			}
-			// Sanitize filename to prevent header injection
-			safeName := strings.Map(func(r rune) rune {
-				if r == '"' || r == '\n' || r == '\r' || r == '\\' {
-					return '_'
+			var req struct {
+				Priority float64 `json:"priority"`
+			}
+			if err := ReadJSONBody(r, &req); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			if err := app.docManager.SetPriority(docID, req.Priority); err != nil {
+				if errors.Is(err, document.ErrInvalidPriority) {
+					WriteError(w, http.StatusBadRequest, err.Error())
+					return
 				}
-				return r
-			}, fileName)
-			w.Header().Set("Content-Disposition", "attachment; filename=\""+safeName+"\"")
-			w.Header().Set("X-Content-Type-Options", "nosniff")
-			http.ServeFile(w, r, filePath)
+				log.Printf("[Documents] set priority error for %s: %v", docID, err)
+				WriteError(w, http.StatusInternalServerError, "设置文档优先级失败")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]interface{}{"status": "ok", "priority": req.Priority})
 			return
 		}
 
@@ -362,6 +565,10 @@ func HandleDocumentByID(app *App) http.HandlerFunc {
 				WriteAdminSessionError(w, err)
 				return
 			}
+			if !app.documentInTenant(r, docID) {
+				WriteError(w, http.StatusNotFound, "文档未找到")
+				return
+			}
 			review, err := app.GetDocumentReview(docID)
 			if err != nil {
 				WriteError(w, http.StatusNotFound, "文档未找到")
@@ -383,12 +590,15 @@ func HandleDocumentByID(app *App) http.HandlerFunc {
 		}
 
 		// Require admin session for deletion
-		_, _, err := GetAdminSession(app, r)
-		if err != nil {
+		if _, _, err := GetAdminSession(app, r); err != nil {
 			WriteAdminSessionError(w, err)
 			return
 		}
-
+		info, err := app.docManager.GetDocumentInfo(docID)
+		if err != nil || !app.productInTenant(r, info.ProductID) {
+			WriteError(w, http.StatusNotFound, "文档未找到")
+			return
+		}
 		if err := app.DeleteDocument(docID); err != nil {
 			log.Printf("[Documents] delete error for %s: %v", docID, err)
 			errlog.Logf("[Documents] delete failed for doc=%s: %v", docID, err)
@@ -399,6 +609,70 @@ func HandleDocumentByID(app *App) http.HandlerFunc {
 	}
 }
 
+// streamDocumentProgress streams the processing progress of a document as
+// server-sent events: "progress" events while it is processed, then one
+// "done" event with its final status. Documents already processed get the
+// "done" event right away.
+func streamDocumentProgress(app *App, w http.ResponseWriter, r *http.Request, docID string) {
+	// Watch before reading the status so the final update cannot be missed
+	updates, stop := app.docManager.WatchProgress(docID)
+	defer stop()
+	info, err := app.docManager.GetDocumentInfo(docID)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, "文档未找到")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	// Processing may outlast the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	sendSSE := func(event string, data interface{}) {
+		jsonData, _ := json.Marshal(data)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, jsonData)
+		flusher.Flush()
+	}
+
+	if info.Status != "processing" {
+		p := document.Progress{DocumentID: docID, Status: info.Status, Error: info.Error, Updated: time.Now()}
+		if info.Status == "success" {
+			p.Percent = 100
+		}
+		sendSSE("done", p)
+		return
+	}
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case p, ok := <-updates:
+			if !ok {
+				return
+			}
+			if p.Status == "processing" {
+				sendSSE("progress", p)
+				continue
+			}
+			sendSSE("done", p)
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
 // HandleBatchImport handles batch file import via SSE (Server-Sent Events).
 func HandleBatchImport(app *App) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -433,7 +707,7 @@ func HandleBatchImport(app *App) http.HandlerFunc {
 			ProductID string `json:"product_id"`
 		}
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
 			return
 		}
 		if req.Path == "" {
@@ -441,6 +715,11 @@ func HandleBatchImport(app *App) http.HandlerFunc {
 			return
 		}
 
+		if !app.HasAdminPermission(userID, role, rbac.PermManageDocs, req.ProductID) {
+			WriteError(w, http.StatusForbidden, "无权管理该产品的文档")
+			return
+		}
+
 		// Validate product ID if provided
 		if req.ProductID != "" {
 			p, err := app.productService.GetByID(req.ProductID)
@@ -547,6 +826,19 @@ func HandleBatchImport(app *App) http.HandlerFunc {
 				})
 				continue
 			}
+			sniffed, err := SniffFileType(fileName, fileData)
+			if err != nil {
+				reason := fmt.Sprintf("导入失败: %v", err)
+				failed++
+				failedFiles = append(failedFiles, failedItem{Path: absPath, Reason: reason})
+				sendSSE("progress", map[string]interface{}{
+					"index": i + 1, "total": len(files), "file": absPath,
+					"percent": (i + 1) * 100 / len(files),
+					"status": "failed", "reason": reason,
+				})
+				continue
+			}
+			fileType = sniffed
 
 			uploadReq := document.UploadFileRequest{
 				FileName:  fileName,
@@ -554,7 +846,7 @@ func HandleBatchImport(app *App) http.HandlerFunc {
 				FileType:  fileType,
 				ProductID: req.ProductID,
 			}
-			doc, err := app.docManager.UploadFile(uploadReq)
+			doc, err := app.docManager.UploadFile(r.Context(), uploadReq)
 			if err != nil {
 				reason := fmt.Sprintf("导入失败: %v", err)
 				failed++
@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"askflow/internal/embedding"
+)
+
+// embeddingRetryAfter is the Retry-After, in seconds, sent when the
+// embedding queue is full.
+const embeddingRetryAfter = "30"
+
+// writeEmbeddingBusy responds with 503 and Retry-After if err means the
+// embedding queue is full, and reports whether it did.
+func writeEmbeddingBusy(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, embedding.ErrQueueFull) {
+		return false
+	}
+	w.Header().Set("Retry-After", embeddingRetryAfter)
+	WriteError(w, http.StatusServiceUnavailable, embedding.ErrQueueFull.Error())
+	return true
+}
+
+// HandleAdminEmbeddingQueue reports the state of the embedding request
+// queue shared by document imports and questions.
+func HandleAdminEmbeddingQueue(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		_, role, err := GetAdminSession(app, r)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		if role != "super_admin" {
+			WriteError(w, http.StatusForbidden, "仅超级管理员可查看向量化队列")
+			return
+		}
+		WriteJSON(w, http.StatusOK, app.EmbeddingQueueStats())
+	}
+}
@@ -0,0 +1,352 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"askflow/internal/rbac"
+	"askflow/internal/usage"
+)
+
+// ErrEndUserNotFound is returned for IDs that are not a registered end user.
+var ErrEndUserNotFound = errors.New("end user not found")
+
+// EndUser is a registered end user with counts of the data kept about them.
+type EndUser struct {
+	CustomerUserInfo
+	QueryCount    int `json:"query_count"` // questions asked, from the monthly usage counters
+	PendingCount  int `json:"pending_count"`
+	FeedbackCount int `json:"feedback_count"`
+}
+
+// EndUserListResult holds a page of end users.
+type EndUserListResult struct {
+	Users    []EndUser `json:"users"`
+	Total    int       `json:"total"`
+	Page     int       `json:"page"`
+	PageSize int       `json:"page_size"`
+}
+
+// ListEndUsers returns a page of registered end users with their question,
+// pending question and feedback counts, optionally filtered by email.
+func (a *App) ListEndUsers(page, pageSize int, search string) (*EndUserListResult, error) {
+	customers, err := a.ListCustomersPaged(page, pageSize, search)
+	if err != nil {
+		return nil, err
+	}
+	result := &EndUserListResult{
+		Users:    make([]EndUser, len(customers.Customers)),
+		Total:    customers.Total,
+		Page:     customers.Page,
+		PageSize: customers.PageSize,
+	}
+	if len(customers.Customers) == 0 {
+		return result, nil
+	}
+	ids := make([]interface{}, len(customers.Customers))
+	for i, c := range customers.Customers {
+		result.Users[i].CustomerUserInfo = c
+		ids[i] = c.ID
+	}
+	in := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	counts := func(query string, args ...interface{}) (map[string]int, error) {
+		rows, err := a.readDB.Query(query, args...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		m := make(map[string]int)
+		for rows.Next() {
+			var id string
+			var n int
+			if err := rows.Scan(&id, &n); err != nil {
+				return nil, err
+			}
+			m[id] = n
+		}
+		return m, rows.Err()
+	}
+	queries, err := counts(`SELECT subject_id, SUM(queries) FROM usage_counters WHERE scope = ? AND subject_id IN (`+in+`) GROUP BY subject_id`,
+		append([]interface{}{usage.ScopeUser}, ids...)...)
+	if err != nil {
+		return nil, fmt.Errorf("count queries: %w", err)
+	}
+	pending, err := counts(`SELECT user_id, COUNT(*) FROM pending_questions WHERE user_id IN (`+in+`) GROUP BY user_id`, ids...)
+	if err != nil {
+		return nil, fmt.Errorf("count pending questions: %w", err)
+	}
+	feedback, err := counts(`SELECT user_id, COUNT(*) FROM query_feedback WHERE user_id IN (`+in+`) GROUP BY user_id`, ids...)
+	if err != nil {
+		return nil, fmt.Errorf("count feedback: %w", err)
+	}
+	for i := range result.Users {
+		id := result.Users[i].ID
+		result.Users[i].QueryCount = queries[id]
+		result.Users[i].PendingCount = pending[id]
+		result.Users[i].FeedbackCount = feedback[id]
+	}
+	return result, nil
+}
+
+// endUserEmail returns the email of a registered end user, or
+// ErrEndUserNotFound for admins, widget visitors and unknown IDs.
+func (a *App) endUserEmail(userID string) (string, error) {
+	if a.IsAdminSession(userID) || userID == "anonymous_user" {
+		return "", ErrEndUserNotFound
+	}
+	var email, provider string
+	err := a.db.QueryRow(`SELECT COALESCE(email,''), provider FROM users WHERE id = ?`, userID).Scan(&email, &provider)
+	if err == sql.ErrNoRows || provider == "admin_sub" || provider == "widget" {
+		return "", ErrEndUserNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return email, nil
+}
+
+// PurgeEndUser erases an end user and everything recorded about them
+// (right to be forgotten): sessions and tokens, pending questions, answers
+// kept for export, feedback, experiment exposures, moderation entries, usage counters and quotas, user
+// group memberships, and login attempts and bans. It returns the number of rows deleted per table.
+func (a *App) PurgeEndUser(userID string) (map[string]int64, error) {
+	email, err := a.endUserEmail(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = a.sessionManager.DeleteSessionsByUserID(userID)
+	tx, err := a.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("purge user: %w", err)
+	}
+	defer tx.Rollback()
+	type step struct {
+		table, query string
+		args         []interface{}
+	}
+	steps := []step{
+		{"email_tokens", `DELETE FROM email_tokens WHERE user_id = ?`, []interface{}{userID}},
+		{"sessions", `DELETE FROM sessions WHERE user_id = ?`, []interface{}{userID}},
+		{"refresh_tokens", `DELETE FROM refresh_tokens WHERE user_id = ?`, []interface{}{userID}},
+		{"login_devices", `DELETE FROM login_devices WHERE user_id = ?`, []interface{}{userID}},
+		{"pending_questions", `DELETE FROM pending_questions WHERE user_id = ?`, []interface{}{userID}},
+		{"query_answers", `DELETE FROM query_answers WHERE user_id = ?`, []interface{}{userID}},
+		{"query_feedback", `DELETE FROM query_feedback WHERE user_id = ?`, []interface{}{userID}},
+		{"experiment_exposures", `DELETE FROM experiment_exposures WHERE user_id = ?`, []interface{}{userID}},
+		{"moderation_queue", `DELETE FROM moderation_queue WHERE user_id = ?`, []interface{}{userID}},
+		{"usage_counters", `DELETE FROM usage_counters WHERE scope = ? AND subject_id = ?`, []interface{}{usage.ScopeUser, userID}},
+		{"usage_quotas", `DELETE FROM usage_quotas WHERE scope = ? AND subject_id = ?`, []interface{}{usage.ScopeUser, userID}},
+	}
+	if email != "" {
+		steps = append(steps,
+			step{"login_tickets", `DELETE FROM login_tickets WHERE user_id IN (SELECT id FROM sn_users WHERE email = ?)`, []interface{}{email}},
+			step{"sn_users", `DELETE FROM sn_users WHERE email = ?`, []interface{}{email}},
+		)
+	}
+	steps = append(steps, step{"users", `DELETE FROM users WHERE id = ?`, []interface{}{userID}})
+	deleted := make(map[string]int64, len(steps))
+	for _, s := range steps {
+		res, err := tx.Exec(s.query, s.args...)
+		if err != nil {
+			return nil, fmt.Errorf("purge %s: %w", s.table, err)
+		}
+		n, _ := res.RowsAffected()
+		deleted[s.table] = n
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("purge user: %w", err)
+	}
+
+	if email != "" {
+		if err := a.userGroupService.ForgetEmail(email); err != nil {
+			log.Printf("[EndUser] failed to remove %s from user groups: %v", userID, err)
+		}
+	}
+	a.loginLimiter.Forget(email)
+	a.loginLimiter.Forget(userID)
+	log.Printf("[EndUser] purged user %s and their data", userID)
+	return deleted, nil
+}
+
+// UserDataExport is the data kept about an end user, as returned to them by
+// /api/auth/me/export.
+type UserDataExport struct {
+	ExportedAt string                   `json:"exported_at"`
+	Profile    map[string]interface{}   `json:"profile"`
+	Questions  []map[string]interface{} `json:"pending_questions"`
+	Answers    []map[string]interface{} `json:"answers"`
+	Feedback   []map[string]interface{} `json:"feedback"`
+	Usage      []map[string]interface{} `json:"usage"`
+	Sessions   []map[string]interface{} `json:"sessions"`
+	Devices    []map[string]interface{} `json:"devices"`
+}
+
+// ExportUserData collects the profile, pending questions and their answers,
+// answers kept for export, answer feedback, monthly usage, active sessions and login devices of an
+// end user.
+// Password hashes and session tokens are left out.
+func (a *App) ExportUserData(userID string) (*UserDataExport, error) {
+	if _, err := a.endUserEmail(userID); err != nil {
+		return nil, err
+	}
+	// list returns the rows of query as maps keyed by cols
+	list := func(query string, cols []string, args ...interface{}) ([]map[string]interface{}, error) {
+		rows, err := a.readDB.Query(query, args...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		out := []map[string]interface{}{}
+		for rows.Next() {
+			vals := make([]sql.NullString, len(cols))
+			ptrs := make([]interface{}, len(cols))
+			for i := range vals {
+				ptrs[i] = &vals[i]
+			}
+			if err := rows.Scan(ptrs...); err != nil {
+				return nil, err
+			}
+			m := make(map[string]interface{}, len(cols))
+			for i, c := range cols {
+				m[c] = vals[i].String
+			}
+			out = append(out, m)
+		}
+		return out, rows.Err()
+	}
+
+	export := &UserDataExport{ExportedAt: time.Now().UTC().Format(time.RFC3339)}
+	profile, err := list(`SELECT id, COALESCE(email,''), COALESCE(name,''), provider, email_verified, created_at, last_login, COALESCE(default_product_id,'') FROM users WHERE id = ?`,
+		[]string{"id", "email", "name", "provider", "email_verified", "created_at", "last_login", "default_product_id"}, userID)
+	if err != nil {
+		return nil, fmt.Errorf("export profile: %w", err)
+	}
+	if len(profile) > 0 {
+		export.Profile = profile[0]
+	}
+	if export.Questions, err = list(`SELECT id, question, status, COALESCE(answer,''), product_id, created_at, answered_at FROM pending_questions WHERE user_id = ? ORDER BY created_at`,
+		[]string{"id", "question", "status", "answer", "product_id", "created_at", "answered_at"}, userID); err != nil {
+		return nil, fmt.Errorf("export pending questions: %w", err)
+	}
+	if export.Answers, err = list(`SELECT query_id, question, answer, product_id, created_at FROM query_answers WHERE user_id = ? ORDER BY created_at`,
+		[]string{"query_id", "question", "answer", "product_id", "created_at"}, userID); err != nil {
+		return nil, fmt.Errorf("export answers: %w", err)
+	}
+	if export.Feedback, err = list(`SELECT query_id, helpful, comment, created_at FROM query_feedback WHERE user_id = ? ORDER BY created_at`,
+		[]string{"query_id", "helpful", "comment", "created_at"}, userID); err != nil {
+		return nil, fmt.Errorf("export feedback: %w", err)
+	}
+	if export.Usage, err = list(`SELECT period, queries, embedding_tokens, prompt_tokens, completion_tokens FROM usage_counters WHERE scope = ? AND subject_id = ? ORDER BY period`,
+		[]string{"period", "queries", "embedding_tokens", "prompt_tokens", "completion_tokens"}, usage.ScopeUser, userID); err != nil {
+		return nil, fmt.Errorf("export usage: %w", err)
+	}
+	if export.Sessions, err = list(`SELECT created_at, expires_at FROM sessions WHERE user_id = ? ORDER BY created_at`,
+		[]string{"created_at", "expires_at"}, userID); err != nil {
+		return nil, fmt.Errorf("export sessions: %w", err)
+	}
+	if export.Devices, err = list(`SELECT user_agent, ip_address, created_at FROM login_devices WHERE user_id = ? ORDER BY created_at`,
+		[]string{"user_agent", "ip_address", "created_at"}, userID); err != nil {
+		return nil, fmt.Errorf("export devices: %w", err)
+	}
+	return export, nil
+}
+
+// HandleAdminEndUsers lists registered end users with their query history
+// counts. Query parameters: page, page_size and search (email).
+func HandleAdminEndUsers(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if _, _, err := RequireAdminPermission(app, r, rbac.PermViewAnalytics, ""); err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		q := r.URL.Query()
+		page, _ := strconv.Atoi(q.Get("page"))
+		pageSize, _ := strconv.Atoi(q.Get("page_size"))
+		search := strings.TrimSpace(q.Get("search"))
+		if len(search) > 254 {
+			search = search[:254]
+		}
+		result, err := app.ListEndUsers(page, pageSize, search)
+		if err != nil {
+			log.Printf("[EndUser] list error: %v", err)
+			WriteError(w, http.StatusInternalServerError, "获取用户列表失败")
+			return
+		}
+		WriteJSON(w, http.StatusOK, result)
+	}
+}
+
+// HandleAdminEndUserByID handles DELETE /api/admin/endusers/{id}, which
+// erases the user and all data recorded about them. Super admin only.
+func HandleAdminEndUserByID(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		_, role, err := GetAdminSession(app, r)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		if role != "super_admin" {
+			WriteError(w, http.StatusForbidden, "仅超级管理员可删除用户数据")
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/api/admin/endusers/")
+		if id == "" || len(id) > 128 || strings.Contains(id, "/") {
+			WriteError(w, http.StatusBadRequest, "invalid user ID")
+			return
+		}
+		deleted, err := app.PurgeEndUser(id)
+		if errors.Is(err, ErrEndUserNotFound) {
+			WriteError(w, http.StatusNotFound, "用户不存在")
+			return
+		}
+		if err != nil {
+			log.Printf("[EndUser] purge error for %s: %v", id, err)
+			WriteError(w, http.StatusInternalServerError, "删除用户数据失败")
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]interface{}{"status": "ok", "deleted": deleted})
+	}
+}
+
+// HandleExportMyData handles GET /api/auth/me/export — returns the signed-in
+// user's own data as a JSON download.
+func HandleExportMyData(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		userID, err := GetUserSession(app, r)
+		if err != nil {
+			WriteError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		export, err := app.ExportUserData(userID)
+		if errors.Is(err, ErrEndUserNotFound) {
+			WriteError(w, http.StatusBadRequest, "当前账号没有可导出的个人数据")
+			return
+		}
+		if err != nil {
+			log.Printf("[EndUser] export error for %s: %v", userID, err)
+			WriteError(w, http.StatusInternalServerError, "导出数据失败")
+			return
+		}
+		w.Header().Set("Content-Disposition", `attachment; filename="my-data.json"`)
+		WriteJSON(w, http.StatusOK, export)
+	}
+}
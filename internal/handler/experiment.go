@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"askflow/internal/experiment"
+	"askflow/internal/rbac"
+)
+
+// HandleQueryFeedback records whether an answer was helpful:
+// POST {query_id, helpful, comment}. The query_id comes from the query
+// response. Feedback feeds the retrieval experiment reports.
+func HandleQueryFeedback(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		userID, err := GetUserSession(app, r)
+		if err != nil {
+			WriteError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		var req struct {
+			QueryID string `json:"query_id"`
+			Helpful *bool  `json:"helpful"`
+			Comment string `json:"comment"`
+		}
+		if err := ReadJSONBody(r, &req); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if !IsValidHexID(req.QueryID) {
+			WriteError(w, http.StatusBadRequest, "invalid query_id")
+			return
+		}
+		if req.Helpful == nil {
+			WriteError(w, http.StatusBadRequest, "helpful is required")
+			return
+		}
+		req.Comment = strings.TrimSpace(req.Comment)
+		if len(req.Comment) > 2000 {
+			WriteError(w, http.StatusBadRequest, "comment too long (max 2000 characters)")
+			return
+		}
+		if err := app.experimentService.RecordFeedback(req.QueryID, userID, *req.Helpful, req.Comment); err != nil {
+			log.Printf("[Feedback] record error: %v", err)
+			WriteError(w, http.StatusInternalServerError, "failed to record feedback")
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]string{"message": tr(w, "感谢您的反馈")})
+	}
+}
+
+// HandleAdminExperiments lists (GET) and creates (POST) retrieval experiments.
+func HandleAdminExperiments(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, _, err := RequireAdminPermission(app, r, rbac.PermManageConfig, ""); err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			list, err := app.experimentService.List()
+			if err != nil {
+				log.Printf("[Experiment] list error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "failed to list experiments")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]interface{}{"experiments": list})
+		case http.MethodPost:
+			var req experiment.Experiment
+			if err := ReadJSONBody(r, &req); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			e, err := app.experimentService.Create(req)
+			if err != nil {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			WriteJSON(w, http.StatusCreated, e)
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
+
+// HandleAdminExperimentByID manages one experiment:
+//
+//	GET    /api/admin/experiments/{id}         experiment
+//	PUT    /api/admin/experiments/{id}         edit a draft
+//	DELETE /api/admin/experiments/{id}         delete unless running
+//	POST   /api/admin/experiments/{id}/start   start a draft
+//	POST   /api/admin/experiments/{id}/stop    stop a running experiment
+//	GET    /api/admin/experiments/{id}/report  compare variants
+func HandleAdminExperimentByID(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/admin/experiments/"), "/", 2)
+		id, action := parts[0], ""
+		if len(parts) == 2 {
+			action = parts[1]
+		}
+		if !IsValidHexID(id) {
+			WriteError(w, http.StatusBadRequest, "invalid experiment ID")
+			return
+		}
+		// Reading a report is analytics; everything else changes retrieval
+		perm := rbac.PermManageConfig
+		if action == "report" {
+			perm = rbac.PermViewAnalytics
+		}
+		if _, _, err := RequireAdminPermission(app, r, perm, ""); err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+
+		var (
+			result interface{}
+			err    error
+		)
+		switch {
+		case action == "" && r.Method == http.MethodGet:
+			result, err = app.experimentService.Get(id)
+		case action == "" && r.Method == http.MethodPut:
+			var req experiment.Experiment
+			if rerr := ReadJSONBody(r, &req); rerr != nil {
+				writeBodyError(w, rerr)
+				return
+			}
+			result, err = app.experimentService.Update(id, req)
+		case action == "" && r.Method == http.MethodDelete:
+			err = app.experimentService.Delete(id)
+			result = map[string]string{"status": "deleted"}
+		case action == "start" && r.Method == http.MethodPost:
+			result, err = app.experimentService.Start(id)
+		case action == "stop" && r.Method == http.MethodPost:
+			result, err = app.experimentService.Stop(id)
+		case action == "report" && r.Method == http.MethodGet:
+			result, err = app.experimentService.Report(id)
+		case action == "" || action == "start" || action == "stop" || action == "report":
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		default:
+			WriteError(w, http.StatusNotFound, "not found")
+			return
+		}
+		if errors.Is(err, experiment.ErrNotFound) {
+			WriteError(w, http.StatusNotFound, "实验不存在")
+			return
+		}
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		WriteJSON(w, http.StatusOK, result)
+	}
+}
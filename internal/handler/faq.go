@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"askflow/internal/faq"
+	"askflow/internal/lease"
+	"askflow/internal/rbac"
+)
+
+// HandleFAQ returns the generated FAQ of a product, most asked first:
+// GET /api/faq?product_id= (empty for the public library).
+func HandleFAQ(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		productID := r.URL.Query().Get("product_id")
+		if !IsValidOptionalID(productID) {
+			WriteError(w, http.StatusBadRequest, "invalid product_id")
+			return
+		}
+		if !app.productInTenant(r, productID) {
+			WriteError(w, http.StatusNotFound, "产品不存在")
+			return
+		}
+		f, err := app.faqService.Get(productID)
+		if err != nil {
+			log.Printf("[FAQ] get error: %v", err)
+			WriteError(w, http.StatusInternalServerError, "failed to load FAQ")
+			return
+		}
+		WriteJSON(w, http.StatusOK, f)
+	}
+}
+
+// HandleAdminFAQ handles /api/admin/faq. GET returns the generation
+// schedule and state; POST regenerates the FAQ of all products in the
+// background.
+func HandleAdminFAQ(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, _, err := RequireAdminPermission(app, r, rbac.PermManageDocs, ""); err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			WriteJSON(w, http.StatusOK, app.faqService.Status())
+		case http.MethodPost:
+			if err := app.faqService.Trigger(); err != nil {
+				if errors.Is(err, faq.ErrRunning) {
+					WriteError(w, http.StatusConflict, "常见问题正在生成")
+					return
+				}
+				if errors.Is(err, lease.ErrHeld) {
+					WriteError(w, http.StatusConflict, "该任务正在其他实例上运行")
+					return
+				}
+				WriteError(w, http.StatusInternalServerError, "启动常见问题生成失败")
+				return
+			}
+			WriteJSON(w, http.StatusAccepted, map[string]string{"status": "started"})
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sniffLen is how much of a file is inspected for signatures and text.
+const sniffLen = 8 << 10
+
+// SniffFileType determines the type of an uploaded file from its content.
+// Binary formats are identified by their signature, which overrides the
+// extension, e.g. a .doc file that is really a .docx is processed as "word".
+// Markdown and HTML have no signature, so for text content the extension
+// decides. Content that is no supported format, or text under the extension
+// of a binary format, is rejected with an error naming what was found.
+func SniffFileType(fileName string, data []byte) (string, error) {
+	declared := DetectFileType(fileName)
+	if declared == "unknown" {
+		return "", errors.New("不支持的文件格式")
+	}
+	if len(data) == 0 {
+		return "", errors.New("文件内容为空")
+	}
+	// PDF readers accept the header anywhere in the first 1024 bytes
+	if declared == "pdf" && bytes.Contains(data[:min(len(data), 1024)], []byte("%PDF-")) {
+		return "pdf", nil
+	}
+	fileType, kind := sniffContent(data)
+	switch {
+	case fileType != "":
+		return fileType, nil
+	case kind == "文本" && (declared == "markdown" || declared == "html" || declared == "srt" || declared == "vtt"):
+		return declared, nil
+	case kind != "":
+		return "", fmt.Errorf("文件内容与扩展名不匹配（实际为%s）", kind)
+	default:
+		return "", errors.New("文件内容与扩展名不匹配")
+	}
+}
+
+// sniffContent returns the supported file type data has the signature of,
+// or else a description of the unsupported format it was recognized as
+// (empty when unrecognized).
+func sniffContent(data []byte) (fileType, kind string) {
+	head := data[:min(len(data), sniffLen)]
+	text := len(head) > 0 && bytes.IndexByte(head, 0) < 0
+	switch {
+	case bytes.HasPrefix(head, []byte("%PDF-")):
+		return "pdf", ""
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return sniffOOXML(data)
+	case bytes.HasPrefix(head, []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}):
+		return sniffOLE(data)
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		switch string(head[8:12]) {
+		case "qt  ":
+			return "mov", ""
+		case "heic", "heix", "mif1", "msf1", "avif":
+			return "", "图片"
+		}
+		return "mp4", ""
+	case len(head) >= 12 && string(head[0:4]) == "RIFF":
+		switch string(head[8:12]) {
+		case "AVI ":
+			return "avi", ""
+		case "WEBP":
+			return "", "图片"
+		case "WAVE":
+			return "", "音频"
+		}
+		return "", ""
+	case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		// The EBML header names the document type
+		if bytes.Contains(head[:min(len(head), 64)], []byte("webm")) {
+			return "webm", ""
+		}
+		return "mkv", ""
+	case !text && bytes.HasPrefix(head, []byte("MZ")), bytes.HasPrefix(head, []byte("\x7fELF")),
+		bytes.HasPrefix(head, []byte{0xCF, 0xFA, 0xED, 0xFE}), bytes.HasPrefix(head, []byte{0xCE, 0xFA, 0xED, 0xFE}):
+		return "", "可执行程序"
+	case bytes.HasPrefix(head, []byte("\x89PNG")), bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}),
+		bytes.HasPrefix(head, []byte("GIF8")), !text && bytes.HasPrefix(head, []byte("BM")):
+		return "", "图片"
+	case bytes.HasPrefix(head, []byte{0x1F, 0x8B}), bytes.HasPrefix(head, []byte("Rar!")),
+		bytes.HasPrefix(head, []byte{'7', 'z', 0xBC, 0xAF, 0x27, 0x1C}):
+		return "", "压缩包"
+	case bytes.HasPrefix(head, []byte("ID3")), bytes.HasPrefix(head, []byte("fLaC")), bytes.HasPrefix(head, []byte("OggS")):
+		return "", "音频"
+	case text:
+		return "", "文本"
+	}
+	return "", ""
+}
+
+// sniffOOXML tells Word, Excel and PowerPoint files apart from other ZIP
+// archives by the folder of their main part.
+func sniffOOXML(data []byte) (fileType, kind string) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", "损坏的压缩包"
+	}
+	for _, f := range zr.File {
+		switch {
+		case strings.HasPrefix(f.Name, "word/"):
+			return "word", ""
+		case strings.HasPrefix(f.Name, "xl/"):
+			return "excel", ""
+		case strings.HasPrefix(f.Name, "ppt/"):
+			return "ppt", ""
+		}
+	}
+	return "", "压缩包"
+}
+
+// sniffOLE tells legacy Word, Excel and PowerPoint files apart by the
+// stream names (UTF-16LE) in their compound file directory.
+func sniffOLE(data []byte) (fileType, kind string) {
+	for _, s := range []struct{ stream, fileType string }{
+		{"WordDocument", "word_legacy"},
+		{"Workbook", "excel_legacy"},
+		{"Book\x00", "excel_legacy"}, // Excel 5.0/95
+		{"PowerPoint Document", "ppt_legacy"},
+	} {
+		if bytes.Contains(data, utf16le(s.stream)) {
+			return s.fileType, ""
+		}
+	}
+	return "", "其他 Office 文档"
+}
+
+func utf16le(s string) []byte {
+	b := make([]byte, 0, 2*len(s))
+	for i := 0; i < len(s); i++ {
+		b = append(b, s[i], 0)
+	}
+	return b
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"askflow/internal/gaps"
+	"askflow/internal/lease"
+	"askflow/internal/rbac"
+)
+
+// HandleAdminGaps handles /api/admin/gaps. GET returns the report schedule,
+// whether a report is being generated and the recent reports; POST
+// {"days": n, "send_email": bool} generates a report over the last n days
+// (default gap_report.lookback_days) in the background.
+func HandleAdminGaps(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, _, err := RequireAdminPermission(app, r, rbac.PermViewAnalytics, ""); err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			WriteJSON(w, http.StatusOK, app.gapService.Status())
+
+		case http.MethodPost:
+			var req struct {
+				Days      int  `json:"days"`
+				SendEmail bool `json:"send_email"`
+			}
+			if r.ContentLength != 0 {
+				if err := ReadJSONBody(r, &req); err != nil {
+					writeBodyError(w, err)
+					return
+				}
+			}
+			if req.Days < 0 || req.Days > 365 {
+				WriteError(w, http.StatusBadRequest, "days 必须在 1 到 365 之间")
+				return
+			}
+			if err := app.gapService.Trigger(req.Days, "manual", req.SendEmail); err != nil {
+				if errors.Is(err, gaps.ErrRunning) {
+					WriteError(w, http.StatusConflict, "已有报告正在生成")
+					return
+				}
+				if errors.Is(err, lease.ErrHeld) {
+					WriteError(w, http.StatusConflict, "该任务正在其他实例上运行")
+					return
+				}
+				WriteError(w, http.StatusInternalServerError, "启动报告生成失败")
+				return
+			}
+			WriteJSON(w, http.StatusAccepted, map[string]string{"status": "started"})
+
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
+
+// HandleAdminGapByID returns (GET) or deletes (DELETE) one knowledge gap
+// report: /api/admin/gaps/{id}.
+func HandleAdminGapByID(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, _, err := RequireAdminPermission(app, r, rbac.PermViewAnalytics, ""); err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/api/admin/gaps/")
+		if !IsValidHexID(id) {
+			WriteError(w, http.StatusBadRequest, "invalid report ID")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			report, err := app.gapService.Get(id)
+			if errors.Is(err, gaps.ErrNotFound) {
+				WriteError(w, http.StatusNotFound, "报告不存在")
+				return
+			}
+			if err != nil {
+				WriteError(w, http.StatusInternalServerError, "failed to load report")
+				return
+			}
+			WriteJSON(w, http.StatusOK, report)
+		case http.MethodDelete:
+			err := app.gapService.Delete(id)
+			if errors.Is(err, gaps.ErrNotFound) {
+				WriteError(w, http.StatusNotFound, "报告不存在")
+				return
+			}
+			if err != nil {
+				WriteError(w, http.StatusInternalServerError, "failed to delete report")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
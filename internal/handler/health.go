@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"askflow/internal/embedding"
+	"askflow/internal/llm"
+)
+
+// upstreamProbeTTL is how long a cached LLM/embedding probe result is reused.
+const upstreamProbeTTL = 60 * time.Second
+
+// probeResult is the outcome of one readiness check.
+type probeResult struct {
+	Status    string    `json:"status"` // "ok", "fail", "warming" or "pending"
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at,omitzero"`
+}
+
+// upstreamProbe caches whether the LLM and embedding APIs are reachable.
+// Probes run in the background so /readyz never blocks on a slow upstream;
+// until the first probe completes the result is "pending".
+type upstreamProbe struct {
+	mu        sync.Mutex
+	running   bool
+	llm       probeResult
+	embedding probeResult
+}
+
+// results returns the cached probe results, starting a refresh when they are stale.
+func (p *upstreamProbe) results(es embedding.EmbeddingService, ls llm.LLMService) (probeResult, probeResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.running && (p.llm.CheckedAt.IsZero() || time.Since(p.llm.CheckedAt) > upstreamProbeTTL) {
+		p.running = true
+		go p.run(es, ls)
+	}
+	llmRes, embRes := p.llm, p.embedding
+	if llmRes.Status == "" {
+		llmRes.Status = "pending"
+	}
+	if embRes.Status == "" {
+		embRes.Status = "pending"
+	}
+	return llmRes, embRes
+}
+
+// invalidate drops the cached results, e.g. after the services were replaced.
+func (p *upstreamProbe) invalidate() {
+	p.mu.Lock()
+	p.llm, p.embedding = probeResult{}, probeResult{}
+	p.mu.Unlock()
+}
+
+func (p *upstreamProbe) run(es embedding.EmbeddingService, ls llm.LLMService) {
+	llmRes := runProbe("llm", func() error {
+		_, err := ls.Generate(context.Background(), "", nil, "请回复：OK")
+		return err
+	})
+	embRes := runProbe("embedding", func() error {
+		_, err := es.Embed(context.Background(), "ping")
+		return err
+	})
+	p.mu.Lock()
+	p.llm, p.embedding, p.running = llmRes, embRes, false
+	p.mu.Unlock()
+}
+
+func runProbe(name string, fn func() error) probeResult {
+	res := probeResult{Status: "ok", CheckedAt: time.Now()}
+	if err := fn(); err != nil {
+		// Details stay in the log; /readyz is public
+		log.Printf("[Ready] %s probe failed: %v", name, err)
+		res.Status, res.Error = "fail", "unreachable"
+	}
+	return res
+}
+
+// HandleHealthz handles GET /healthz — liveness: the process is up and serving.
+func HandleHealthz(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// HandleReadyz handles GET /readyz — readiness: the database answers, the
+// config is loaded and the vector cache is in memory. With
+// server.ready_check_upstream the cached LLM/embedding probes must also pass.
+// Responds 200 when ready and 503 otherwise, with per-check details.
+func HandleReadyz(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		checks := make(map[string]probeResult)
+		ready := true
+		ok := probeResult{Status: "ok"}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+		checks["database"] = ok
+		for _, db := range []*sql.DB{app.db, app.readDB} {
+			var one int
+			if err := db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+				log.Printf("[Ready] database check failed: %v", err)
+				checks["database"] = probeResult{Status: "fail", Error: "unreachable"}
+				ready = false
+				break
+			}
+		}
+
+		cfg := app.configManager.Get()
+		if cfg == nil {
+			checks["config"] = probeResult{Status: "fail", Error: "not loaded"}
+			ready = false
+		} else {
+			checks["config"] = ok
+		}
+
+		if app.vectorStore != nil && app.vectorStore.Loaded() {
+			checks["vector_cache"] = ok
+		} else {
+			checks["vector_cache"] = probeResult{Status: "warming"}
+			ready = false
+		}
+
+		if cfg != nil && cfg.Server.ReadyCheckUpstream {
+			es, ls := app.queryEngine.Services()
+			llmRes, embRes := app.upstream.results(es, ls)
+			checks["llm"], checks["embedding"] = llmRes, embRes
+			if llmRes.Status == "fail" || embRes.Status == "fail" {
+				ready = false
+			}
+		}
+
+		status, code := "ready", http.StatusOK
+		if !ready {
+			status, code = "not_ready", http.StatusServiceUnavailable
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		WriteJSON(w, code, map[string]interface{}{"status": status, "checks": checks})
+	}
+}
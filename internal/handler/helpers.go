@@ -2,10 +2,16 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"strings"
+	"time"
+
+	"askflow/internal/config"
+	"askflow/internal/middleware"
 )
 
 // ForbiddenError represents a 403 Forbidden error, distinct from 401 Unauthorized.
@@ -34,6 +40,22 @@ func GetBaseURL(r *http.Request) string {
 	return scheme + "://" + host
 }
 
+// uploadTimeout is how long a large upload may take to arrive and be answered.
+// The server-wide Read/WriteTimeout are far too short for files of several
+// hundred MB on a slow link.
+const uploadTimeout = 30 * time.Minute
+
+// extendUploadDeadlines lifts the server read/write deadlines for an upload request.
+func extendUploadDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(uploadTimeout)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		log.Printf("[Upload] cannot extend read deadline: %v", err)
+		return
+	}
+	rc.SetWriteDeadline(deadline)
+}
+
 // WriteJSON encodes data as JSON and writes it to the response with the given status code.
 func WriteJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -41,13 +63,15 @@ func WriteJSON(w http.ResponseWriter, status int, data interface{}) {
 	json.NewEncoder(w).Encode(data)
 }
 
-// WriteError writes a JSON error response with the given status code and message.
+// WriteError writes a JSON error response with the given status code and
+// message, translated to the language negotiated for the request.
 func WriteError(w http.ResponseWriter, status int, message string) {
-	WriteJSON(w, status, map[string]string{"error": message})
+	WriteJSON(w, status, map[string]string{"error": tr(w, message)})
 }
 
 // ReadJSONBody decodes the request body as JSON into v.
-// It validates Content-Type, limits body size to 1MB, and rejects trailing data.
+// It validates Content-Type, limits body size to the route limit set by
+// middleware.BodyLimit (1MB on routes without one), and rejects trailing data.
 func ReadJSONBody(r *http.Request, v interface{}) error {
 	// Validate content type
 	ct := r.Header.Get("Content-Type")
@@ -55,9 +79,12 @@ func ReadJSONBody(r *http.Request, v interface{}) error {
 		return fmt.Errorf("expected Content-Type application/json")
 	}
 	defer r.Body.Close()
-	// Limit request body to 1MB to prevent large payload attacks
-	limited := io.LimitReader(r.Body, 1<<20)
-	decoder := json.NewDecoder(limited)
+	// Limit request body size to prevent large payload attacks
+	var body io.Reader = r.Body
+	if _, ok := middleware.BodyLimitFrom(r.Context()); !ok {
+		body = http.MaxBytesReader(nil, r.Body, 1<<20)
+	}
+	decoder := json.NewDecoder(body)
 	if err := decoder.Decode(v); err != nil {
 		return err
 	}
@@ -68,12 +95,30 @@ func ReadJSONBody(r *http.Request, v interface{}) error {
 	return nil
 }
 
-// GetUserSession validates the Authorization bearer token and returns the user ID.
+// writeBodyTooLarge responds with 413 if err means the request body was
+// cut off at its size limit, and reports whether it did.
+func writeBodyTooLarge(w http.ResponseWriter, err error) bool {
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		return false
+	}
+	middleware.WriteBodyTooLarge(w, tooLarge.Limit)
+	return true
+}
+
+// writeBodyError responds to a request body ReadJSONBody failed to read:
+// 413 if it was too large, 400 otherwise.
+func writeBodyError(w http.ResponseWriter, err error) {
+	if !writeBodyTooLarge(w, err) {
+		WriteError(w, http.StatusBadRequest, "invalid request body")
+	}
+}
+
+// GetUserSession validates the session token (Authorization bearer token or,
+// in cookie mode, the session cookie) and returns the user ID.
 func GetUserSession(app *App, r *http.Request) (string, error) {
-	authHeader := r.Header.Get("Authorization")
-	token := strings.TrimPrefix(authHeader, "Bearer ")
-	if token == "" || token == authHeader {
-		// token is empty, or Authorization header didn't have "Bearer " prefix
+	token := requestToken(r, SessionCookieName, AdminSessionCookieName)
+	if token == "" {
 		return "", fmt.Errorf("未登录")
 	}
 	session, err := app.sessionManager.ValidateSession(token)
@@ -87,9 +132,8 @@ func GetUserSession(app *App, r *http.Request) (string, error) {
 // Returns (userID, role, error). role is "super_admin", "editor", or "anonymous_viewer".
 // Anonymous viewers are restricted to GET requests only.
 func GetAdminSession(app *App, r *http.Request) (string, string, error) {
-	authHeader := r.Header.Get("Authorization")
-	token := strings.TrimPrefix(authHeader, "Bearer ")
-	if token == "" || token == authHeader {
+	token := requestToken(r, AdminSessionCookieName)
+	if token == "" {
 		return "", "", fmt.Errorf("未登录")
 	}
 	session, err := app.sessionManager.ValidateSession(token)
@@ -103,6 +147,10 @@ func GetAdminSession(app *App, r *http.Request) (string, string, error) {
 	if role == "" {
 		return "", "", fmt.Errorf("无权限")
 	}
+	// Sub-accounts only administer the workspace they belong to
+	if !app.adminInTenant(session.UserID, requestTenantID(r)) {
+		return "", "", &ForbiddenError{Message: "无权访问该工作区"}
+	}
 	// Anonymous viewers can only perform read operations
 	if role == "anonymous_viewer" && r.Method != http.MethodGet {
 		return "", "", &ForbiddenError{Message: "此为参观模式，一切更改都不会生效"}
@@ -110,6 +158,64 @@ func GetAdminSession(app *App, r *http.Request) (string, string, error) {
 	return session.UserID, role, nil
 }
 
+// RequireAdminPermission validates the admin session like GetAdminSession and
+// additionally requires perm on productID ("" checks the admin's global role).
+// A missing permission is reported as a ForbiddenError.
+func RequireAdminPermission(app *App, r *http.Request, perm, productID string) (string, string, error) {
+	userID, role, err := GetAdminSession(app, r)
+	if err != nil {
+		return "", "", err
+	}
+	if !app.HasAdminPermission(userID, role, perm, productID) {
+		return "", "", &ForbiddenError{Message: "无权限执行此操作"}
+	}
+	return userID, role, nil
+}
+
+// ProductResolver returns the product an admin request acts on. ok is false
+// when the request does not name one, or names a resource that does not
+// exist; the handler then reports that itself.
+type ProductResolver func(app *App, r *http.Request) (productID string, ok bool)
+
+// QueryProduct resolves the product_id query parameter.
+func QueryProduct(app *App, r *http.Request) (string, bool) {
+	productID := r.URL.Query().Get("product_id")
+	return productID, productID != ""
+}
+
+// RequirePermission wraps an admin handler so it is only reached by admins
+// holding perm. If the request carries a product_id query parameter the
+// permission must cover that product; otherwise holding perm globally or on
+// any product is enough, and the handler checks the target product itself.
+func RequirePermission(app *App, perm string, next http.HandlerFunc) http.HandlerFunc {
+	return RequireProductPermission(app, perm, QueryProduct, next)
+}
+
+// RequireProductPermission is RequirePermission for handlers whose target
+// product is found by resolve, e.g. from a document or question ID in the
+// path. The permission must cover the resolved product, so an admin
+// delegated to one product cannot change another product's content.
+func RequireProductPermission(app *App, perm string, resolve ProductResolver, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, role, err := GetAdminSession(app, r)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		allowed := false
+		if productID, ok := resolve(app, r); ok {
+			allowed = app.HasAdminPermission(userID, role, perm, productID)
+		} else {
+			allowed = app.HasAdminPermissionAnywhere(userID, role, perm)
+		}
+		if !allowed {
+			WriteError(w, http.StatusForbidden, "无权限执行此操作")
+			return
+		}
+		next(w, r)
+	}
+}
+
 // WriteAdminSessionError writes the appropriate HTTP error for a GetAdminSession failure.
 // Returns 403 for ForbiddenError (anonymous write rejection), 401 for all other errors.
 func WriteAdminSessionError(w http.ResponseWriter, err error) {
@@ -201,6 +307,10 @@ func DetectFileType(filename string) string {
 		return "mov"
 	case strings.HasSuffix(lower, ".webm"):
 		return "webm"
+	case strings.HasSuffix(lower, ".srt"):
+		return "srt"
+	case strings.HasSuffix(lower, ".vtt"):
+		return "vtt"
 	default:
 		return "unknown"
 	}
@@ -230,3 +340,24 @@ func ValidatePassword(password string) string {
 	}
 	return ""
 }
+
+// RateLimitIdentity returns the rate limiting key and tier of the caller
+// behind a request: "user:<id>" for signed-in end users, widget visitors and
+// admins, with the admin or user tier, or "" and the anonymous tier for
+// requests without a valid session, which are limited per client IP.
+func RateLimitIdentity(app *App) func(r *http.Request) (string, string) {
+	return func(r *http.Request) (string, string) {
+		token := requestToken(r, SessionCookieName, AdminSessionCookieName)
+		if token == "" {
+			return "", config.RateLimitAnonymous
+		}
+		session, err := app.sessionManager.ValidateSession(token)
+		if err != nil {
+			return "", config.RateLimitAnonymous
+		}
+		if app.IsAdminSession(session.UserID) {
+			return "user:" + session.UserID, config.RateLimitAdmin
+		}
+		return "user:" + session.UserID, config.RateLimitUser
+	}
+}
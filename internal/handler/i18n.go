@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"net/http"
+
+	"askflow/internal/i18n"
+	"askflow/internal/middleware"
+)
+
+// Localize returns a middleware that picks the language of API messages for
+// the request and records it in the Content-Language response header, where
+// WriteError and tr look it up. The signed-in user's saved preference wins,
+// then Accept-Language, then server.language.
+func Localize(app *App) middleware.Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Language", app.requestLanguage(r))
+			w.Header().Add("Vary", "Accept-Language")
+			next(w, r)
+		}
+	}
+}
+
+// requestLanguage negotiates the message language of a request.
+func (a *App) requestLanguage(r *http.Request) string {
+	if token := requestToken(r, SessionCookieName, AdminSessionCookieName); token != "" {
+		if session, err := a.sessionManager.ValidateSession(token); err == nil {
+			if lang, ok := i18n.Supported(a.GetUserLanguage(session.UserID)); ok {
+				return lang
+			}
+		}
+	}
+	if lang := i18n.Match(r.Header.Get("Accept-Language")); lang != "" {
+		return lang
+	}
+	if cfg := a.configManager.Get(); cfg != nil {
+		if lang, ok := i18n.Supported(cfg.Server.Language); ok {
+			return lang
+		}
+	}
+	return i18n.Default
+}
+
+// tr translates a user-facing message to the language of the response.
+func tr(w http.ResponseWriter, msg string) string {
+	return i18n.T(i18n.ResponseLanguage(w), msg)
+}
+
+// trf translates format to the language of the response and formats it.
+func trf(w http.ResponseWriter, format string, args ...interface{}) string {
+	return i18n.Tf(i18n.ResponseLanguage(w), format, args...)
+}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"askflow/internal/blob"
+	"askflow/internal/document"
+	"askflow/internal/query"
+)
+
+// imageURLTTL returns how long signed image URLs stay valid.
+func (a *App) imageURLTTL() time.Duration {
+	if cfg := a.configManager.Get(); cfg != nil && cfg.Server.ImageURLTTLMinutes > 0 {
+		return time.Duration(cfg.Server.ImageURLTTLMinutes) * time.Minute
+	}
+	return time.Hour
+}
+
+// signImageURL appends a short-lived access token to a URL served by the
+// image store so it loads in an <img> tag without a session cookie. Other
+// URLs are returned unchanged.
+func (a *App) signImageURL(u string) string {
+	id := blob.IDFromURL(u)
+	if id == "" {
+		return u
+	}
+	return blob.URLPrefix + id + "?token=" + a.imageSigner.Sign(id, a.imageURLTTL(), "")
+}
+
+// signSources returns sources with signed image URLs. It copies the slice
+// because cached responses share it.
+func (a *App) signSources(sources []query.SourceRef) []query.SourceRef {
+	if len(sources) == 0 {
+		return sources
+	}
+	signed := make([]query.SourceRef, len(sources))
+	copy(signed, sources)
+	for i := range signed {
+		if signed[i].ImageURL != "" {
+			signed[i].ImageURL = a.signImageURL(signed[i].ImageURL)
+		}
+	}
+	return signed
+}
+
+// signReview signs the keyframe image URLs of a document review.
+func (a *App) signReview(review *document.ReviewData) {
+	if review == nil {
+		return
+	}
+	for i := range review.Segments {
+		if review.Segments[i].ImageURL != "" {
+			review.Segments[i].ImageURL = a.signImageURL(review.Segments[i].ImageURL)
+		}
+	}
+}
+
+// unsignImageURLs strips access tokens from image store URLs in place, so
+// entries store the plain URL and are signed afresh when served.
+func unsignImageURLs(urls []string) {
+	for i, u := range urls {
+		if id := blob.IDFromURL(strings.TrimSpace(u)); id != "" {
+			urls[i] = blob.URLPrefix + id
+		}
+	}
+}
+
+// assignImages records productID and documentID as the owner of uploaded
+// images referenced by an entry that has just been saved.
+func (a *App) assignImages(urls []string, productID, documentID string) {
+	for _, u := range urls {
+		id := blob.IDFromURL(strings.TrimSpace(u))
+		if id == "" {
+			continue
+		}
+		if err := a.imageStore.Assign(id, productID, documentID); err != nil {
+			log.Printf("[Image] failed to assign %s to doc=%s: %v", id, documentID, err)
+		}
+	}
+}
+
+// HandleImages serves GET /api/images/{id}. The request must carry either a
+// signed token for the image, as handed out with answers and reviews, or a
+// session of the workspace that owns the image's product.
+func HandleImages(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, blob.URLPrefix)
+		img, err := app.imageStore.Get(id)
+		if errors.Is(err, blob.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			log.Printf("[Image] get %s error: %v", id, err)
+			WriteError(w, http.StatusInternalServerError, "failed to load image")
+			return
+		}
+
+		allowed := false
+		if token := r.URL.Query().Get("token"); token != "" {
+			subject, verr := app.imageSigner.Verify(token, func(string) (string, error) { return "", nil })
+			allowed = verr == nil && subject == img.ID
+		}
+		if !allowed {
+			if _, serr := GetUserSession(app, r); serr != nil {
+				WriteError(w, http.StatusUnauthorized, "未登录或图片链接已过期")
+				return
+			}
+			if !app.productInTenant(r, img.ProductID) {
+				WriteError(w, http.StatusForbidden, "无权访问该图片")
+				return
+			}
+		}
+
+		if img.ContentType != "" {
+			w.Header().Set("Content-Type", img.ContentType)
+		}
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+		app.imageStore.Serve(w, r, img.ID)
+	}
+}
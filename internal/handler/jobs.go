@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"askflow/internal/backup"
+	"askflow/internal/connector"
+	"askflow/internal/document"
+	"askflow/internal/faq"
+	"askflow/internal/jobs"
+	"askflow/internal/lease"
+	"askflow/internal/maintenance"
+)
+
+// requireJobsAdmin checks that the request comes from a super admin.
+func requireJobsAdmin(app *App, w http.ResponseWriter, r *http.Request) bool {
+	_, role, err := GetAdminSession(app, r)
+	if err != nil {
+		WriteAdminSessionError(w, err)
+		return false
+	}
+	if role != "super_admin" {
+		WriteError(w, http.StatusForbidden, "仅超级管理员可管理后台任务")
+		return false
+	}
+	return true
+}
+
+// HandleAdminJobs handles GET /api/admin/jobs (super admin only): document
+// ingestion, backups, maintenance, connector syncs and FAQ generation runs,
+// newest first, filtered by kind and status.
+func HandleAdminJobs(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if !requireJobsAdmin(app, w, r) {
+			return
+		}
+		q := r.URL.Query()
+		page, _ := strconv.Atoi(q.Get("page"))
+		pageSize, _ := strconv.Atoi(q.Get("page_size"))
+		list, total, err := app.jobs.List(jobs.Filter{
+			Kind:     q.Get("kind"),
+			Status:   q.Get("status"),
+			Page:     page,
+			PageSize: pageSize,
+		})
+		if err != nil {
+			log.Printf("[Jobs] list error: %v", err)
+			WriteError(w, http.StatusInternalServerError, "获取任务列表失败")
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]interface{}{"jobs": list, "total": total})
+	}
+}
+
+// HandleAdminJobByID handles /api/admin/jobs/{id} (super admin only): GET
+// returns one job, POST .../cancel stops a running job and POST .../retry
+// runs a failed or canceled job again.
+func HandleAdminJobByID(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/admin/jobs/"), "/")
+		if !IsValidHexID(id) {
+			WriteError(w, http.StatusBadRequest, "invalid job ID")
+			return
+		}
+		if !requireJobsAdmin(app, w, r) {
+			return
+		}
+
+		switch {
+		case action == "" && r.Method == http.MethodGet:
+			job, err := app.jobs.Get(id)
+			if err != nil {
+				writeJobError(w, err)
+				return
+			}
+			WriteJSON(w, http.StatusOK, job)
+		case action == "cancel" && r.Method == http.MethodPost:
+			if err := app.jobs.Cancel(id); err != nil {
+				writeJobError(w, err)
+				return
+			}
+			WriteJSON(w, http.StatusAccepted, map[string]string{"status": "canceling"})
+		case action == "retry" && r.Method == http.MethodPost:
+			if err := app.jobs.Retry(id); err != nil {
+				writeJobError(w, err)
+				return
+			}
+			WriteJSON(w, http.StatusAccepted, map[string]string{"status": "started"})
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
+
+// writeJobError reports an error of the job API.
+func writeJobError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		WriteError(w, http.StatusNotFound, "任务不存在")
+	case errors.Is(err, jobs.ErrNotRunning):
+		WriteError(w, http.StatusConflict, "任务已结束")
+	case errors.Is(err, jobs.ErrNotCancelable):
+		WriteError(w, http.StatusConflict, "该任务无法取消")
+	case errors.Is(err, jobs.ErrRemote), errors.Is(err, lease.ErrHeld):
+		WriteError(w, http.StatusConflict, "该任务正在其他实例上运行")
+	case errors.Is(err, jobs.ErrNotRetryable):
+		WriteError(w, http.StatusConflict, "该任务无法重试")
+	case errors.Is(err, sql.ErrNoRows), errors.Is(err, connector.ErrNotFound):
+		WriteError(w, http.StatusNotFound, "任务处理的对象已不存在")
+	case errors.Is(err, document.ErrNotFailed):
+		WriteError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, backup.ErrBackupRunning), errors.Is(err, maintenance.ErrRunning),
+		errors.Is(err, faq.ErrRunning), errors.Is(err, connector.ErrRunning):
+		WriteError(w, http.StatusConflict, "该任务已在运行")
+	default:
+		log.Printf("[Jobs] error: %v", err)
+		WriteError(w, http.StatusInternalServerError, "操作任务失败")
+	}
+}
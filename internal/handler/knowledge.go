@@ -4,10 +4,12 @@ import (
 	"crypto/rand"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strings"
+
+	"askflow/internal/rbac"
 )
 
 // --- Knowledge entry handler ---
@@ -27,6 +29,9 @@ func HandleImageUpload(app *App) http.HandlerFunc {
 
 		// Parse multipart form (max 10MB)
 		if err := r.ParseMultipartForm(10 << 20); err != nil {
+			if writeBodyTooLarge(w, err) {
+				return
+			}
 			WriteError(w, http.StatusBadRequest, "failed to parse form")
 			return
 		}
@@ -69,27 +74,15 @@ func HandleImageUpload(app *App) http.HandlerFunc {
 			return
 		}
 
-		// Generate unique filename
-		b := make([]byte, 16)
-		if _, err := rand.Read(b); err != nil {
-			WriteError(w, http.StatusInternalServerError, "failed to generate ID")
-			return
-		}
-		filename := fmt.Sprintf("%x%s", b, ext)
-
-		// Save to data/images/
-		imgDir := filepath.Join(".", "data", "images")
-		if err := os.MkdirAll(imgDir, 0755); err != nil {
-			WriteError(w, http.StatusInternalServerError, "failed to create image dir")
-			return
-		}
-		if err := os.WriteFile(filepath.Join(imgDir, filename), data, 0644); err != nil {
+		// Owned by nobody until the knowledge entry or answer using it is saved
+		url, err := app.imageStore.Put(data, ext, "", "")
+		if err != nil {
+			log.Printf("[Image] save error: %v", err)
 			WriteError(w, http.StatusInternalServerError, "failed to save image")
 			return
 		}
-
-		url := "/api/images/" + filename
-		WriteJSON(w, http.StatusOK, map[string]string{"url": url})
+		// Signed so the editor preview loads; the token is dropped on save
+		WriteJSON(w, http.StatusOK, map[string]string{"url": app.signImageURL(url)})
 	}
 }
 
@@ -106,8 +99,13 @@ func HandleKnowledgeVideoUpload(app *App) http.HandlerFunc {
 			return
 		}
 
+		extendUploadDeadlines(w)
+
 		// Parse multipart form (32MB in memory, rest goes to temp files)
 		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			if writeBodyTooLarge(w, err) {
+				return
+			}
 			WriteError(w, http.StatusBadRequest, "failed to parse form")
 			return
 		}
@@ -159,22 +157,43 @@ func HandleKnowledgeVideoUpload(app *App) http.HandlerFunc {
 		}
 		filename := fmt.Sprintf("%x%s", b, ext)
 
-		// Save to data/videos/knowledge/
-		videoDir := filepath.Join(".", "data", "videos", "knowledge")
-		if err := os.MkdirAll(videoDir, 0755); err != nil {
-			WriteError(w, http.StatusInternalServerError, "failed to create video dir")
-			return
-		}
-		if err := os.WriteFile(filepath.Join(videoDir, filename), data, 0644); err != nil {
+		key, _ := knowledgeVideoKey(filename)
+		if err := app.docManager.Storage().Put(key, data); err != nil {
+			log.Printf("[Video] save error: %v", err)
 			WriteError(w, http.StatusInternalServerError, "failed to save video")
 			return
 		}
 
-		url := "/api/videos/knowledge/" + filename
+		url := knowledgeVideoURLPrefix + filename
 		WriteJSON(w, http.StatusOK, map[string]string{"url": url})
 	}
 }
 
+// knowledgeVideoURLPrefix is the path knowledge entry videos are served under.
+const knowledgeVideoURLPrefix = "/api/videos/knowledge/"
+
+// knowledgeVideoKey returns the storage key of the knowledge video with the
+// given file name, or false if name is not a plain file name.
+func knowledgeVideoKey(name string) (string, bool) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return "", false
+	}
+	return "videos/knowledge/" + name, true
+}
+
+// HandleKnowledgeVideos serves the videos uploaded for knowledge entries.
+func HandleKnowledgeVideos(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := knowledgeVideoKey(strings.TrimPrefix(r.URL.Path, knowledgeVideoURLPrefix))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		app.docManager.Storage().Serve(w, r, key)
+	}
+}
+
 // HandleKnowledgeEntry handles direct knowledge entry creation (text + images).
 func HandleKnowledgeEntry(app *App) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -182,17 +201,24 @@ func HandleKnowledgeEntry(app *App) http.HandlerFunc {
 			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
 			return
 		}
-		_, _, err := GetAdminSession(app, r)
+		userID, role, err := GetAdminSession(app, r)
 		if err != nil {
 			WriteAdminSessionError(w, err)
 			return
 		}
 		var req KnowledgeEntryRequest
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
+			return
+		}
+		if !app.HasAdminPermission(userID, role, rbac.PermManageDocs, req.ProductID) {
+			WriteError(w, http.StatusForbidden, "无权管理该产品的文档")
+			return
+		}
+		if !app.allowDocumentWrite(w, r, req.ProductID) {
 			return
 		}
-		if err := app.AddKnowledgeEntry(req); err != nil {
+		if err := app.AddKnowledgeEntry(r.Context(), req); err != nil {
 			WriteError(w, http.StatusBadRequest, err.Error())
 			return
 		}
@@ -0,0 +1,243 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"askflow/internal/auth"
+	"askflow/internal/errlog"
+)
+
+// accountUnlockTTL is how long an emailed unlock link stays valid. The link
+// also stops working when the lockout it was sent for ends.
+const accountUnlockTTL = 24 * time.Hour
+
+var (
+	// ErrNotLocked is returned when an account has no active lockout.
+	ErrNotLocked = errors.New("account is not locked")
+	// ErrNoUnlockEmail is returned for lockouts of usernames that are not a
+	// customer email address, such as admin accounts.
+	ErrNoUnlockEmail = errors.New("account has no email to send an unlock link to")
+)
+
+// lockNotice records the unlock email sent for a lockout.
+type lockNotice struct {
+	until  time.Time // end of the lockout the email was sent for
+	sentAt time.Time
+}
+
+// unlockRecipient returns the name of the customer account that signs in
+// with emailAddr, if that account has a password.
+func (a *App) unlockRecipient(emailAddr string) (string, bool) {
+	var userID, name string
+	err := a.readDB.QueryRow(
+		`SELECT id, COALESCE(name,'') FROM users WHERE email = ? AND password_hash IS NOT NULL AND password_hash != ''`,
+		emailAddr,
+	).Scan(&userID, &name)
+	if err != nil || a.IsAdminSession(userID) {
+		return "", false
+	}
+	return name, true
+}
+
+// lockBinding binds unlock tokens to the lockout they were issued for, so a
+// link is single-use and expires together with the lockout.
+func (a *App) lockBinding(emailAddr string) (string, error) {
+	until, locked := a.loginLimiter.LockedUntil(emailAddr)
+	if !locked {
+		return "", ErrNotLocked
+	}
+	return until.Format(time.RFC3339), nil
+}
+
+// NotifyLockout emails the owner of a locked customer account a signed link
+// under baseURL that lifts the lockout. Each lockout is notified once unless
+// resend is set. It returns ErrNotLocked or ErrNoUnlockEmail when there is
+// nothing to send.
+func (a *App) NotifyLockout(emailAddr, baseURL string, resend bool) error {
+	until, locked := a.loginLimiter.LockedUntil(emailAddr)
+	if !locked {
+		return ErrNotLocked
+	}
+	name, ok := a.unlockRecipient(emailAddr)
+	if !ok {
+		return ErrNoUnlockEmail
+	}
+
+	a.lockMu.Lock()
+	now := time.Now()
+	for addr, n := range a.lockNotices {
+		if now.After(n.until) {
+			delete(a.lockNotices, addr)
+		}
+	}
+	if n, sent := a.lockNotices[emailAddr]; sent && n.until.Equal(until) && (!resend || now.Sub(n.sentAt) < time.Minute) {
+		a.lockMu.Unlock()
+		return nil
+	}
+	a.lockNotices[emailAddr] = lockNotice{until: until, sentAt: now}
+	a.lockMu.Unlock()
+
+	token := a.unlockSigner.Sign(emailAddr, accountUnlockTTL, until.Format(time.RFC3339))
+	unlockURL := strings.TrimRight(baseURL, "/") + "/api/auth/unlock?token=" + token
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[Lockout] panic sending unlock email to %s: %v", emailAddr, r)
+			}
+		}()
+		if err := a.emailService.SendAccountLocked(emailAddr, name, until, unlockURL); err != nil {
+			log.Printf("[Lockout] failed to send unlock email to %s: %v", emailAddr, err)
+			errlog.Logf("[Email] failed to send account unlock email to %s: %v", emailAddr, err)
+		}
+	}()
+	return nil
+}
+
+// lockNoticeSent returns when the unlock email for the current lockout of
+// emailAddr was sent.
+func (a *App) lockNoticeSent(emailAddr string, until time.Time) (time.Time, bool) {
+	a.lockMu.Lock()
+	defer a.lockMu.Unlock()
+	n, ok := a.lockNotices[emailAddr]
+	if !ok || !n.until.Equal(until) {
+		return time.Time{}, false
+	}
+	return n.sentAt, true
+}
+
+// UnlockAccount verifies a signed unlock token and lifts the failed-login
+// lockout of its account. Manual bans and IP lockouts stay in place.
+func (a *App) UnlockAccount(token string) error {
+	emailAddr, err := a.unlockSigner.Verify(strings.TrimSpace(token), a.lockBinding)
+	if err != nil {
+		return err
+	}
+	a.loginLimiter.UnlockUser(emailAddr)
+	a.lockMu.Lock()
+	delete(a.lockNotices, emailAddr)
+	a.lockMu.Unlock()
+	log.Printf("[Lockout] account %s unlocked via emailed link", emailAddr)
+	return nil
+}
+
+// HandleUnlockAccount handles the emailed unlock link: GET
+// /api/auth/unlock?token= lifts the lockout and redirects to the login page,
+// POST {token} does the same for API clients.
+func HandleUnlockAccount(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			token := r.URL.Query().Get("token")
+			if !isValidSignedToken(token) || app.UnlockAccount(token) != nil {
+				http.Redirect(w, r, app.appPath("/login?error=invalid_unlock_link"), http.StatusFound)
+				return
+			}
+			http.Redirect(w, r, app.appPath("/login?unlocked=1"), http.StatusFound)
+		case http.MethodPost:
+			var req struct {
+				Token string `json:"token"`
+			}
+			if err := ReadJSONBody(r, &req); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			if !isValidSignedToken(req.Token) || app.UnlockAccount(req.Token) != nil {
+				WriteError(w, http.StatusBadRequest, "解锁链接无效或已过期")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{"status": "ok", "message": tr(w, "账号已解锁，请登录")})
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
+
+// LockoutEntry is an active login ban with the actions an admin can take on
+// it: "unban" lifts any ban, "unlock" lifts only the failed-login lockout of
+// a user, and "notify" (re)sends the owner an unlock email.
+type LockoutEntry struct {
+	auth.BanEntry
+	NotifiedAt string   `json:"notified_at,omitempty"`
+	Actions    []string `json:"actions"`
+}
+
+// HandleAdminLockouts lists the active login bans and lockouts with their
+// available actions (GET) and runs an action (POST {action, username, ip}).
+// Super admin only.
+func HandleAdminLockouts(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, role, err := GetAdminSession(app, r)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		if role != "super_admin" {
+			WriteError(w, http.StatusForbidden, "仅超级管理员可管理登录限制")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			entries := []LockoutEntry{}
+			for _, b := range app.loginLimiter.ListBans() {
+				e := LockoutEntry{BanEntry: b, Actions: []string{"unban"}}
+				if b.Type == "user_consecutive" || b.Type == "user_daily" {
+					e.Actions = append(e.Actions, "unlock")
+					if until, locked := app.loginLimiter.LockedUntil(b.Username); locked {
+						if _, ok := app.unlockRecipient(b.Username); ok {
+							e.Actions = append(e.Actions, "notify")
+						}
+						if sentAt, ok := app.lockNoticeSent(b.Username, until); ok {
+							e.NotifiedAt = sentAt.UTC().Format(time.RFC3339)
+						}
+					}
+				}
+				entries = append(entries, e)
+			}
+			WriteJSON(w, http.StatusOK, map[string]interface{}{"lockouts": entries})
+		case http.MethodPost:
+			var req struct {
+				Action   string `json:"action"`
+				Username string `json:"username"`
+				IP       string `json:"ip"`
+			}
+			if err := ReadJSONBody(r, &req); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			switch req.Action {
+			case "unban":
+				if req.Username == "" && req.IP == "" {
+					WriteError(w, http.StatusBadRequest, "请输入用户名或IP")
+					return
+				}
+				app.loginLimiter.Unban(req.Username, req.IP)
+			case "unlock":
+				if req.Username == "" {
+					WriteError(w, http.StatusBadRequest, "请输入用户名")
+					return
+				}
+				app.loginLimiter.UnlockUser(req.Username)
+			case "notify":
+				err := app.NotifyLockout(req.Username, app.publicURL(r), true)
+				if errors.Is(err, ErrNotLocked) {
+					WriteError(w, http.StatusBadRequest, "该账号当前未被锁定")
+					return
+				}
+				if errors.Is(err, ErrNoUnlockEmail) {
+					WriteError(w, http.StatusBadRequest, "该账号没有可接收解锁邮件的邮箱")
+					return
+				}
+			default:
+				WriteError(w, http.StatusBadRequest, "action must be unban, unlock or notify")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
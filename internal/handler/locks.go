@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+)
+
+// HandleAdminLocks handles GET /api/admin/locks (super admin only): the
+// leases background jobs take so that instances sharing the database run
+// each job once, with the instance holding each and whether it is this one.
+func HandleAdminLocks(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		_, role, err := GetAdminSession(app, r)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		if role != "super_admin" {
+			WriteError(w, http.StatusForbidden, "仅超级管理员可查看任务锁")
+			return
+		}
+		leases, err := app.locks.List()
+		if err != nil {
+			log.Printf("[Locks] list error: %v", err)
+			WriteError(w, http.StatusInternalServerError, "获取任务锁失败")
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"instance": app.locks.Owner(),
+			"locks":    leases,
+		})
+	}
+}
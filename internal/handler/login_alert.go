@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"log"
+	"slices"
+	"time"
+
+	"askflow/internal/config"
+	"askflow/internal/errlog"
+)
+
+// limitSessions signs out the oldest logins of an end user beyond
+// sessions.max_per_user. Admin accounts and the shared anonymous frontend
+// account are not limited.
+func (a *App) limitSessions(userID string) {
+	max := a.configManager.Get().Sessions.MaxPerUser
+	if max <= 0 || a.IsAdminSession(userID) || userID == "anonymous_user" {
+		return
+	}
+	n, err := a.sessionManager.LimitDevices(userID, max)
+	if err != nil {
+		log.Printf("[Auth] failed to enforce session limit for %s: %v", userID, err)
+		return
+	}
+	if n > 0 {
+		log.Printf("[Auth] signed out %d oldest login(s) of %s over the limit of %d", n, userID, max)
+	}
+}
+
+// alertNewLogin emails an end user who signed in with a password at time at
+// from an address, or with sessions.login_alerts set to "country" a country,
+// that none of their successful sign-ins of the last 30 days came from.
+// Nothing is sent for a first sign-in, since there is nothing to compare it
+// with. It runs in the background.
+func (a *App) alertNewLogin(emailAddr, name, ip string, at time.Time) {
+	mode := a.configManager.Get().Sessions.LoginAlerts
+	if mode == "" || ip == "" {
+		return
+	}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[Auth] panic checking login location of %s: %v", emailAddr, r)
+			}
+		}()
+		known, err := a.loginLimiter.SuccessfulIPs(emailAddr, at)
+		if err != nil {
+			log.Printf("[Auth] failed to load previous logins of %s: %v", emailAddr, err)
+			return
+		}
+		if len(known) == 0 || slices.Contains(known, ip) {
+			return
+		}
+		place := ip
+		country := ""
+		if mode == config.LoginAlertsCountry {
+			country = a.abuseGuard.Country(ip)
+		}
+		if country != "" {
+			for _, k := range known {
+				if a.abuseGuard.Country(k) == country {
+					return
+				}
+			}
+			place += " (" + country + ")"
+		}
+		log.Printf("[Auth] sign-in of %s from new location %s", emailAddr, place)
+		if err := a.emailService.SendLoginAlert(emailAddr, name, place, at); err != nil {
+			log.Printf("[Auth] failed to send login alert to %s: %v", emailAddr, err)
+			errlog.Logf("[Email] failed to send login alert to %s: %v", emailAddr, err)
+		}
+	}()
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"askflow/internal/lease"
+	"askflow/internal/maintenance"
+)
+
+// HandleAdminMaintenance handles /api/admin/maintenance (super admin only).
+// GET returns the scheduler status, the outcome of the last run and the
+// current space use of the database; POST starts a run in the background.
+func HandleAdminMaintenance(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, role, err := GetAdminSession(app, r)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		if role != "super_admin" {
+			WriteError(w, http.StatusForbidden, "仅超级管理员可执行数据库维护")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			WriteJSON(w, http.StatusOK, app.maintenance.Status())
+
+		case http.MethodPost:
+			if err := app.maintenance.Trigger("manual"); err != nil {
+				if errors.Is(err, maintenance.ErrRunning) {
+					WriteError(w, http.StatusConflict, "已有数据库维护正在进行")
+					return
+				}
+				if errors.Is(err, lease.ErrHeld) {
+					WriteError(w, http.StatusConflict, "该任务正在其他实例上运行")
+					return
+				}
+				WriteError(w, http.StatusInternalServerError, "启动数据库维护失败")
+				return
+			}
+			WriteJSON(w, http.StatusAccepted, map[string]string{"status": "started"})
+
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"askflow/internal/moderation"
+	"askflow/internal/rbac"
+)
+
+// HandleAdminModerationPolicies manages moderation policies:
+//
+//	GET    /api/admin/moderation/policies                  all policies
+//	PUT    /api/admin/moderation/policies                  create or replace one
+//	DELETE /api/admin/moderation/policies?product_id=...   remove one
+//
+// product_id "" is the default policy for products without their own.
+func HandleAdminModerationPolicies(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			if _, _, err := RequireAdminPermission(app, r, rbac.PermManageConfig, ""); err != nil {
+				WriteAdminSessionError(w, err)
+				return
+			}
+			list, err := app.moderationService.ListPolicies()
+			if err != nil {
+				log.Printf("[Moderation] list policies error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "failed to list policies")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]interface{}{"policies": list})
+		case http.MethodPut:
+			var req moderation.Policy
+			if err := ReadJSONBody(r, &req); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			if req.ProductID != "" && !IsValidHexID(req.ProductID) {
+				WriteError(w, http.StatusBadRequest, "invalid product_id")
+				return
+			}
+			if _, _, err := RequireAdminPermission(app, r, rbac.PermManageConfig, req.ProductID); err != nil {
+				WriteAdminSessionError(w, err)
+				return
+			}
+			p, err := app.moderationService.SetPolicy(req)
+			if err != nil {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			WriteJSON(w, http.StatusOK, p)
+		case http.MethodDelete:
+			productID := r.URL.Query().Get("product_id")
+			if productID != "" && !IsValidHexID(productID) {
+				WriteError(w, http.StatusBadRequest, "invalid product_id")
+				return
+			}
+			if _, _, err := RequireAdminPermission(app, r, rbac.PermManageConfig, productID); err != nil {
+				WriteAdminSessionError(w, err)
+				return
+			}
+			if err := app.moderationService.DeletePolicy(productID); err != nil {
+				if errors.Is(err, moderation.ErrNotFound) {
+					WriteError(w, http.StatusNotFound, "审核策略不存在")
+					return
+				}
+				log.Printf("[Moderation] delete policy error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "failed to delete policy")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
+
+// HandleAdminModerationQueue lists blocked and flagged items:
+// GET /api/admin/moderation/queue?status=pending|approved|rejected.
+func HandleAdminModerationQueue(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if _, _, err := RequireAdminPermission(app, r, rbac.PermManageDocs, ""); err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		status := r.URL.Query().Get("status")
+		switch status {
+		case "", moderation.StatusPending, moderation.StatusApproved, moderation.StatusRejected:
+		default:
+			WriteError(w, http.StatusBadRequest, "invalid status")
+			return
+		}
+		items, err := app.moderationService.ListQueue(status)
+		if err != nil {
+			log.Printf("[Moderation] list queue error: %v", err)
+			WriteError(w, http.StatusInternalServerError, "failed to list moderation queue")
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]interface{}{"items": items})
+	}
+}
+
+// HandleAdminModerationQueueItem reviews a queued item:
+//
+//	POST /api/admin/moderation/queue/{id}/approve   the block was a mistake
+//	POST /api/admin/moderation/queue/{id}/reject    the block stands
+//
+// Approving a blocked document reprocesses it with prohibited terms ignored;
+// rejecting a document flagged for prompt injection deletes it.
+func HandleAdminModerationQueueItem(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/admin/moderation/queue/"), "/")
+		if !IsValidHexID(id) {
+			WriteError(w, http.StatusBadRequest, "invalid item ID")
+			return
+		}
+		if action != "approve" && action != "reject" {
+			WriteError(w, http.StatusNotFound, "not found")
+			return
+		}
+		item, err := app.moderationService.GetItem(id)
+		if errors.Is(err, moderation.ErrNotFound) {
+			WriteError(w, http.StatusNotFound, "审核记录不存在")
+			return
+		}
+		if err != nil {
+			log.Printf("[Moderation] get item error: %v", err)
+			WriteError(w, http.StatusInternalServerError, "failed to load item")
+			return
+		}
+		reviewer, _, err := RequireAdminPermission(app, r, rbac.PermManageDocs, item.ProductID)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+
+		item, err = app.moderationService.Review(id, action == "approve", reviewer)
+		if errors.Is(err, moderation.ErrAlreadyReviewed) {
+			WriteError(w, http.StatusConflict, "该记录已审核")
+			return
+		}
+		if err != nil {
+			log.Printf("[Moderation] review error: %v", err)
+			WriteError(w, http.StatusInternalServerError, "failed to review item")
+			return
+		}
+
+		resp := map[string]interface{}{"item": item}
+		if item.Status == moderation.StatusApproved && item.Source == moderation.SourceDocument {
+			fileType := ""
+			if orig, ferr := app.docManager.GetOriginal(item.DocumentID); ferr == nil {
+				fileType = DetectFileType(orig.Name)
+				// The content decides, as it did when the file was uploaded
+				if data, rerr := orig.Read(); rerr == nil {
+					if t, serr := SniffFileType(orig.Name, data); serr == nil {
+						fileType = t
+					}
+				}
+			}
+			if rerr := app.docManager.ReleaseBlocked(item.DocumentID, fileType); rerr != nil {
+				log.Printf("[Moderation] release doc=%s error: %v", item.DocumentID, rerr)
+				resp["message"] = trf(w, "已通过审核，但文档重新处理失败: %s", tr(w, rerr.Error()))
+			} else {
+				resp["message"] = tr(w, "已通过审核，文档正在重新处理")
+			}
+		}
+		if item.Status == moderation.StatusRejected && item.Source == moderation.SourceInjection {
+			if derr := app.DeleteDocument(item.DocumentID); derr != nil {
+				log.Printf("[Moderation] delete flagged doc=%s error: %v", item.DocumentID, derr)
+				resp["message"] = trf(w, "已驳回，但删除文档失败: %s", tr(w, derr.Error()))
+			} else {
+				resp["message"] = tr(w, "已驳回，文档已删除")
+			}
+		}
+		WriteJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"askflow/internal/db"
+	"askflow/internal/moderation"
+	"askflow/internal/query"
+)
+
+func TestMeteredQueryModerationRefusalLanguage(t *testing.T) {
+	pair, err := db.InitDB(filepath.Join(t.TempDir(), "askflow.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pair.Close()
+	svc := moderation.NewService(pair.Read, pair.Write)
+	if _, err := svc.SetPolicy(moderation.Policy{BlockedTerms: []string{"forbidden", "违禁"}}); err != nil {
+		t.Fatal(err)
+	}
+	app := &App{moderationService: svc}
+
+	tests := []struct {
+		question string
+		want     string
+	}{
+		{"How do I install the forbidden plugin?", "Sorry, your question contains content that is not allowed and cannot be answered."},
+		{"如何安装违禁插件？", "抱歉，您的问题包含不允许的内容，无法回答。"},
+	}
+	for _, tt := range tests {
+		resp, err := app.MeteredQuery(context.Background(), query.QueryRequest{UserID: "u1", Question: tt.question})
+		if err != nil {
+			t.Fatalf("MeteredQuery(%q): %v", tt.question, err)
+		}
+		if resp.Answer != tt.want {
+			t.Errorf("MeteredQuery(%q) answer = %q, want %q", tt.question, resp.Answer, tt.want)
+		}
+	}
+}
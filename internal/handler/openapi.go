@@ -0,0 +1,20 @@
+package handler
+
+import (
+	"net/http"
+
+	"askflow/internal/openapi"
+)
+
+// HandleOpenAPI handles GET /api/openapi.json — the OpenAPI 3 description of
+// the API, with this server (including its base path and workspace prefix)
+// as the server URL so clients can be generated from it directly.
+func HandleOpenAPI(app *App, doc *openapi.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		WriteJSON(w, http.StatusOK, doc.Document(app.publicURL(r)))
+	}
+}
@@ -1,11 +1,14 @@
 package handler
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"strings"
 
 	"askflow/internal/pending"
+	"askflow/internal/query"
+	"askflow/internal/rbac"
 )
 
 // --- Pending question handlers ---
@@ -18,7 +21,7 @@ func HandlePending(app *App) http.HandlerFunc {
 			return
 		}
 		// Require admin session for pending questions listing
-		_, _, err := GetAdminSession(app, r)
+		userID, role, err := GetAdminSession(app, r)
 		if err != nil {
 			WriteAdminSessionError(w, err)
 			return
@@ -34,12 +37,25 @@ func HandlePending(app *App) http.HandlerFunc {
 			WriteError(w, http.StatusBadRequest, "invalid product_id")
 			return
 		}
-		questions, err := app.ListPendingQuestions(status, productID)
+		if productID != "" && !app.productInTenant(r, productID) {
+			WriteError(w, http.StatusNotFound, "产品不存在")
+			return
+		}
+		questions, err := app.ListPendingQuestionsInTenant(requestTenantID(r), status, productID)
 		if err != nil {
 			log.Printf("[Pending] list error: %v", err)
 			WriteError(w, http.StatusInternalServerError, "获取问题列表失败")
 			return
 		}
+		if productID == "" {
+			set, err := app.delegatedProductSet(r, userID, role, rbac.PermAnswerPending)
+			if err != nil {
+				log.Printf("[Pending] list error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "获取问题列表失败")
+				return
+			}
+			questions = filterByProduct(questions, set, func(q pending.PendingQuestion) string { return q.ProductID })
+		}
 		if questions == nil {
 			questions = []pending.PendingQuestion{}
 		}
@@ -55,17 +71,26 @@ func HandlePendingAnswer(app *App) http.HandlerFunc {
 			return
 		}
 		// Require admin session
-		_, _, err := GetAdminSession(app, r)
+		userID, role, err := GetAdminSession(app, r)
 		if err != nil {
 			WriteAdminSessionError(w, err)
 			return
 		}
 		var req pending.AdminAnswerRequest
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
+			return
+		}
+		productID := app.pendingQuestionProductID(req.QuestionID)
+		if !app.productInTenant(r, productID) {
+			WriteError(w, http.StatusNotFound, "问题不存在")
+			return
+		}
+		if !app.HasAdminPermission(userID, role, rbac.PermAnswerPending, productID) {
+			WriteError(w, http.StatusForbidden, "无权处理该产品的问题")
 			return
 		}
-		if err := app.AnswerQuestion(req); err != nil {
+		if err := app.AnswerQuestion(r.Context(), req); err != nil {
 			log.Printf("[Pending] answer error: %v", err)
 			WriteError(w, http.StatusInternalServerError, "回答问题失败")
 			return
@@ -93,7 +118,7 @@ func HandlePendingCreate(app *App) http.HandlerFunc {
 			ProductID string `json:"product_id"`
 		}
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
 			return
 		}
 		if req.Question == "" {
@@ -105,9 +130,16 @@ func HandlePendingCreate(app *App) http.HandlerFunc {
 			WriteError(w, http.StatusBadRequest, "question too long")
 			return
 		}
-		// Limit image data size (base64 encoded, ~4MB decoded)
-		if len(req.ImageData) > 5*1024*1024 {
-			WriteError(w, http.StatusBadRequest, "image data too large")
+		if req.ImageData != "" {
+			img, err := query.PrepareImage(req.ImageData)
+			if err != nil {
+				writeImageError(w, err)
+				return
+			}
+			req.ImageData = img
+		}
+		if !app.productInTenant(r, req.ProductID) {
+			WriteError(w, http.StatusBadRequest, "产品不存在")
 			return
 		}
 		pq, err := app.CreatePendingQuestion(req.Question, authenticatedUserID, req.ImageData, req.ProductID)
@@ -120,10 +152,26 @@ func HandlePendingCreate(app *App) http.HandlerFunc {
 	}
 }
 
-// HandlePendingByID handles deleting a pending question by ID (admin only).
+// PendingQuestionProduct resolves the product of the pending question
+// addressed by an /api/pending/{id}[/draft] path.
+func PendingQuestionProduct(app *App, r *http.Request) (string, bool) {
+	id, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/pending/"), "/")
+	if !IsValidHexID(id) {
+		return "", false
+	}
+	var productID string
+	err := app.readDB.QueryRow(`SELECT COALESCE(product_id, '') FROM pending_questions WHERE id = ?`, id).Scan(&productID)
+	return productID, err == nil
+}
+
+// HandlePendingByID handles deleting a pending question by ID and
+// regenerating its suggested answer via POST /api/pending/{id}/draft (admin only).
+// The router wraps it in RequireProductPermission with PendingQuestionProduct,
+// so the admin holds answer_pending on the question's product.
 func HandlePendingByID(app *App) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := strings.TrimPrefix(r.URL.Path, "/api/pending/")
+		id, draft := strings.CutSuffix(id, "/draft")
 		if id == "" || id == "answer" || id == "create" {
 			WriteError(w, http.StatusBadRequest, "missing question ID")
 			return
@@ -133,16 +181,28 @@ func HandlePendingByID(app *App) http.HandlerFunc {
 			WriteError(w, http.StatusBadRequest, "invalid question ID")
 			return
 		}
-		if r.Method != http.MethodDelete {
+		if (draft && r.Method != http.MethodPost) || (!draft && r.Method != http.MethodDelete) {
 			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
 			return
 		}
 		// Require admin session
-		_, _, err := GetAdminSession(app, r)
-		if err != nil {
+		if _, _, err := GetAdminSession(app, r); err != nil {
 			WriteAdminSessionError(w, err)
 			return
 		}
+		if !app.productInTenant(r, app.pendingQuestionProductID(id)) {
+			WriteError(w, http.StatusNotFound, "问题不存在")
+			return
+		}
+		if draft {
+			if err := app.DraftPendingAnswer(r.Context(), id); err != nil {
+				log.Printf("[Pending] draft error for %s: %v", id, err)
+				WriteError(w, http.StatusInternalServerError, "生成回答草稿失败")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+			return
+		}
 		if err := app.DeletePendingQuestion(id); err != nil {
 			log.Printf("[Pending] delete error for %s: %v", id, err)
 			WriteError(w, http.StatusInternalServerError, "删除问题失败")
@@ -151,3 +211,112 @@ func HandlePendingByID(app *App) http.HandlerFunc {
 		WriteJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
 	}
 }
+
+// HandlePendingOverdue lists the pending questions past their SLA response
+// time, longest overdue first: GET /api/admin/pending/overdue?product_id=.
+func HandlePendingOverdue(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		userID, role, err := GetAdminSession(app, r)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		productID := r.URL.Query().Get("product_id")
+		if !IsValidOptionalID(productID) {
+			WriteError(w, http.StatusBadRequest, "invalid product_id")
+			return
+		}
+		if productID != "" && !app.productInTenant(r, productID) {
+			WriteError(w, http.StatusNotFound, "产品不存在")
+			return
+		}
+		questions, err := app.ListOverdueQuestionsInTenant(requestTenantID(r), productID)
+		if err != nil {
+			log.Printf("[Pending] list overdue error: %v", err)
+			WriteError(w, http.StatusInternalServerError, "获取问题列表失败")
+			return
+		}
+		if productID == "" {
+			set, err := app.delegatedProductSet(r, userID, role, rbac.PermAnswerPending)
+			if err != nil {
+				log.Printf("[Pending] list overdue error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "获取问题列表失败")
+				return
+			}
+			questions = filterByProduct(questions, set, func(q pending.OverdueQuestion) string { return q.ProductID })
+		}
+		WriteJSON(w, http.StatusOK, map[string]interface{}{"questions": questions})
+	}
+}
+
+// HandleAdminPendingSLA manages response time targets for pending questions:
+//
+//	GET    /api/admin/pending/sla                 all policies
+//	PUT    /api/admin/pending/sla                 create or replace a policy
+//	DELETE /api/admin/pending/sla?product_id=     remove a policy
+//
+// product_id "" is the default policy.
+func HandleAdminPendingSLA(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			if _, _, err := RequireAdminPermission(app, r, rbac.PermManageConfig, ""); err != nil {
+				WriteAdminSessionError(w, err)
+				return
+			}
+			list, err := app.slaService.ListPolicies()
+			if err != nil {
+				log.Printf("[SLA] list policies error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "failed to list policies")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]interface{}{"policies": list})
+		case http.MethodPut:
+			var req pending.SLAPolicy
+			if err := ReadJSONBody(r, &req); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			if req.ProductID != "" && !IsValidHexID(req.ProductID) {
+				WriteError(w, http.StatusBadRequest, "invalid product_id")
+				return
+			}
+			if _, _, err := RequireAdminPermission(app, r, rbac.PermManageConfig, req.ProductID); err != nil {
+				WriteAdminSessionError(w, err)
+				return
+			}
+			p, err := app.slaService.SetPolicy(req)
+			if err != nil {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			WriteJSON(w, http.StatusOK, p)
+		case http.MethodDelete:
+			productID := r.URL.Query().Get("product_id")
+			if productID != "" && !IsValidHexID(productID) {
+				WriteError(w, http.StatusBadRequest, "invalid product_id")
+				return
+			}
+			if _, _, err := RequireAdminPermission(app, r, rbac.PermManageConfig, productID); err != nil {
+				WriteAdminSessionError(w, err)
+				return
+			}
+			if err := app.slaService.DeletePolicy(productID); err != nil {
+				if errors.Is(err, pending.ErrNotFound) {
+					WriteError(w, http.StatusNotFound, "SLA 策略不存在")
+					return
+				}
+				log.Printf("[SLA] delete policy error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "failed to delete policy")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
@@ -2,13 +2,19 @@ package handler
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"log"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
 
+	"askflow/internal/config"
+	"askflow/internal/i18n"
 	"askflow/internal/product"
+	"askflow/internal/rbac"
+	"askflow/internal/usage"
 )
 
 // HandleProducts handles GET (list all) and POST (create) for products.
@@ -16,7 +22,7 @@ func HandleProducts(app *App) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			products, err := app.ListProducts()
+			products, err := app.ListProducts(requestTenantID(r))
 			if err != nil {
 				log.Printf("[Products] list error: %v", err)
 				WriteError(w, http.StatusInternalServerError, "获取产品列表失败")
@@ -45,11 +51,14 @@ func HandleProducts(app *App) http.HandlerFunc {
 				AllowDownload  bool   `json:"allow_download"`
 			}
 			if err := ReadJSONBody(r, &req); err != nil {
-				WriteError(w, http.StatusBadRequest, "invalid request body")
+				writeBodyError(w, err)
 				return
 			}
-			p, err := app.CreateProduct(req.Name, req.Type, req.Description, req.WelcomeMessage, req.AllowDownload)
+			p, err := app.CreateProduct(requestTenantID(r), req.Name, req.Type, req.Description, req.WelcomeMessage, req.AllowDownload)
 			if err != nil {
+				if writeQuotaError(w, err) {
+					return
+				}
 				WriteError(w, http.StatusBadRequest, err.Error())
 				return
 			}
@@ -69,10 +78,30 @@ func HandleProductByID(app *App) http.HandlerFunc {
 			WriteError(w, http.StatusBadRequest, "missing product ID")
 			return
 		}
+
+		// Handle /api/products/{id}/widget-origins
+		if strings.HasSuffix(id, "/widget-origins") {
+			handleProductWidgetOrigins(app, w, r, strings.TrimSuffix(id, "/widget-origins"))
+			return
+		}
+		// Handle /api/products/{id}/escalation
+		if strings.HasSuffix(id, "/escalation") {
+			handleProductEscalation(app, w, r, strings.TrimSuffix(id, "/escalation"))
+			return
+		}
+		// Handle /api/products/{id}/stats
+		if strings.HasSuffix(id, "/stats") {
+			handleProductStats(app, w, r, strings.TrimSuffix(id, "/stats"))
+			return
+		}
 		if !IsValidHexID(id) {
 			WriteError(w, http.StatusBadRequest, "invalid product ID")
 			return
 		}
+		if !app.productInTenant(r, id) {
+			WriteError(w, http.StatusNotFound, "产品不存在")
+			return
+		}
 
 		switch r.Method {
 		case http.MethodPut:
@@ -93,7 +122,7 @@ func HandleProductByID(app *App) http.HandlerFunc {
 				AllowDownload  bool   `json:"allow_download"`
 			}
 			if err := ReadJSONBody(r, &req); err != nil {
-				WriteError(w, http.StatusBadRequest, "invalid request body")
+				writeBodyError(w, err)
 				return
 			}
 			p, err := app.UpdateProduct(id, req.Name, req.Type, req.Description, req.WelcomeMessage, req.AllowDownload)
@@ -113,14 +142,20 @@ func HandleProductByID(app *App) http.HandlerFunc {
 				WriteError(w, http.StatusForbidden, "仅超级管理员可管理产品")
 				return
 			}
+			// Outside the default workspace there is no public library to
+			// move the product's documents to, so they must be deleted first
 			confirm := r.URL.Query().Get("confirm")
-			if confirm != "true" {
+			if confirm != "true" || requestTenantID(r) != "" {
 				hasData, err := app.HasProductDocumentsOrKnowledge(id)
 				if err != nil {
 					log.Printf("[Products] check data error for %s: %v", id, err)
 					WriteError(w, http.StatusInternalServerError, "检查产品数据失败")
 					return
 				}
+				if hasData && requestTenantID(r) != "" {
+					WriteError(w, http.StatusConflict, "请先删除该产品下的文档和知识条目")
+					return
+				}
 				if hasData {
 					WriteJSON(w, http.StatusConflict, map[string]interface{}{
 						"warning":  "该产品下存在关联的文档或知识条目，确认删除？",
@@ -142,6 +177,147 @@ func HandleProductByID(app *App) http.HandlerFunc {
 	}
 }
 
+// handleProductWidgetOrigins handles GET and PUT of the embeddable widget origin
+// allowlist for a product. Only super admins may change it.
+func handleProductWidgetOrigins(app *App, w http.ResponseWriter, r *http.Request, id string) {
+	if !IsValidHexID(id) {
+		WriteError(w, http.StatusBadRequest, "invalid product ID")
+		return
+	}
+	_, role, err := GetAdminSession(app, r)
+	if err != nil {
+		WriteAdminSessionError(w, err)
+		return
+	}
+	if !app.productInTenant(r, id) {
+		WriteError(w, http.StatusNotFound, "产品不存在")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		origins, err := app.GetProductWidgetOrigins(id)
+		if err != nil {
+			WriteError(w, http.StatusNotFound, "产品不存在")
+			return
+		}
+		if origins == nil {
+			origins = []string{}
+		}
+		WriteJSON(w, http.StatusOK, map[string]interface{}{"origins": origins})
+
+	case http.MethodPut:
+		if role != "super_admin" {
+			WriteError(w, http.StatusForbidden, "仅超级管理员可管理产品")
+			return
+		}
+		var req struct {
+			Origins []string `json:"origins"`
+		}
+		if err := ReadJSONBody(r, &req); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if err := app.SetProductWidgetOrigins(id, req.Origins); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		origins, _ := app.GetProductWidgetOrigins(id)
+		if origins == nil {
+			origins = []string{}
+		}
+		WriteJSON(w, http.StatusOK, map[string]interface{}{"origins": origins})
+
+	default:
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleProductEscalation reads and sets the answer confidence below which
+// answers of a product are escalated to a human.
+func handleProductEscalation(app *App, w http.ResponseWriter, r *http.Request, id string) {
+	if !IsValidHexID(id) {
+		WriteError(w, http.StatusBadRequest, "invalid product ID")
+		return
+	}
+	_, role, err := GetAdminSession(app, r)
+	if err != nil {
+		WriteAdminSessionError(w, err)
+		return
+	}
+	if !app.productInTenant(r, id) {
+		WriteError(w, http.StatusNotFound, "产品不存在")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		threshold, err := app.GetProductEscalationThreshold(id)
+		if err != nil {
+			WriteError(w, http.StatusNotFound, "产品不存在")
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]interface{}{"threshold": threshold})
+
+	case http.MethodPut:
+		if role != "super_admin" {
+			WriteError(w, http.StatusForbidden, "仅超级管理员可管理产品")
+			return
+		}
+		var req struct {
+			Threshold float64 `json:"threshold"`
+		}
+		if err := ReadJSONBody(r, &req); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if err := app.SetProductEscalationThreshold(id, req.Threshold); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]interface{}{"threshold": req.Threshold})
+
+	default:
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleProductStats returns the knowledge base health of a product to
+// admins with analytics permission on it. ?period=YYYY-MM selects the usage
+// month, the current one by default.
+func handleProductStats(app *App, w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !IsValidHexID(id) {
+		WriteError(w, http.StatusBadRequest, "invalid product ID")
+		return
+	}
+	if _, _, err := RequireAdminPermission(app, r, rbac.PermViewAnalytics, id); err != nil {
+		WriteAdminSessionError(w, err)
+		return
+	}
+	if !app.productInTenant(r, id) {
+		WriteError(w, http.StatusNotFound, "产品不存在")
+		return
+	}
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = usage.CurrentPeriod()
+	} else if !usage.ValidPeriod(period) {
+		WriteError(w, http.StatusBadRequest, "invalid period (expected YYYY-MM)")
+		return
+	}
+	stats, err := app.ProductStats(id, period)
+	if err != nil {
+		log.Printf("[Products] stats error for %s: %v", id, err)
+		WriteError(w, http.StatusInternalServerError, "failed to load product stats")
+		return
+	}
+	WriteJSON(w, http.StatusOK, stats)
+}
+
 // HandleMyProducts returns products accessible to the current admin user.
 func HandleMyProducts(app *App) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -154,7 +330,7 @@ func HandleMyProducts(app *App) http.HandlerFunc {
 			WriteAdminSessionError(w, err)
 			return
 		}
-		products, err := app.GetProductsByAdminUserID(userID)
+		products, err := app.GetProductsByAdminUserID(userID, requestTenantID(r))
 		if err != nil {
 			log.Printf("[Products] get my products error: %v", err)
 			WriteError(w, http.StatusInternalServerError, "获取产品列表失败")
@@ -181,16 +357,12 @@ func HandleProductIntro(app *App) http.HandlerFunc {
 				return
 			}
 			p, err := app.GetProduct(productID)
-			if err == nil && p != nil && p.WelcomeMessage != "" {
+			if err == nil && p != nil && p.WelcomeMessage != "" && app.productInTenant(r, productID) {
 				WriteJSON(w, http.StatusOK, map[string]string{"product_intro": p.WelcomeMessage})
 				return
 			}
 		}
-		cfg := app.configManager.Get()
-		var intro string
-		if cfg != nil {
-			intro = cfg.ProductIntro
-		}
+		_, intro := app.siteBranding(r)
 		WriteJSON(w, http.StatusOK, map[string]string{"product_intro": intro})
 	}
 }
@@ -207,16 +379,30 @@ func HandleAppInfo(app *App) http.HandlerFunc {
 		if providers == nil {
 			providers = []string{}
 		}
-		var productName string
+		productName, _ := app.siteBranding(r)
 		var maxUploadSizeMB int
+		// Public part of the hCaptcha / Turnstile settings for the login,
+		// registration and chat pages
+		challenge := map[string]interface{}{}
 		if cfg != nil {
-			productName = cfg.ProductName
 			maxUploadSizeMB = cfg.Video.MaxUploadSizeMB
+			c := cfg.Admin.Captcha
+			challenge = map[string]interface{}{
+				"provider":        c.Provider,
+				"site_key":        c.SiteKey,
+				"register":        c.Requires(config.CaptchaRegister),
+				"login":           c.Requires(config.CaptchaLogin),
+				"anonymous_query": c.Requires(config.CaptchaAnonymousQuery),
+			}
 		}
 		WriteJSON(w, http.StatusOK, map[string]interface{}{
 			"product_name":       productName,
 			"oauth_providers":    providers,
+			"sso_providers":      app.GetSSOProviders(),
 			"max_upload_size_mb": maxUploadSizeMB,
+			"captcha":            challenge,
+			"language":           i18n.ResponseLanguage(w),
+			"languages":          i18n.Languages(),
 		})
 	}
 }
@@ -243,12 +429,7 @@ func HandleTranslateProductName(app *App) http.HandlerFunc {
 			WriteError(w, http.StatusBadRequest, "invalid language parameter")
 			return
 		}
-		cfg := app.configManager.Get()
-		if cfg == nil {
-			WriteJSON(w, http.StatusOK, map[string]string{"product_name": ""})
-			return
-		}
-		name := cfg.ProductName
+		name, _ := app.siteBranding(r)
 		if name == "" {
 			WriteJSON(w, http.StatusOK, map[string]string{"product_name": ""})
 			return
@@ -258,8 +439,17 @@ func HandleTranslateProductName(app *App) http.HandlerFunc {
 			return
 		}
 
-		// Check cache first
+		// Check cache first: the shared one when instances share caches
 		cacheKey := name + "\x00" + lang
+		sharedKey := ""
+		if app.sharedCache != nil {
+			sum := sha256.Sum256([]byte(cacheKey))
+			sharedKey = "product-name:" + hex.EncodeToString(sum[:])
+			if text, ok := app.sharedCache.Get(sharedKey); ok {
+				WriteJSON(w, http.StatusOK, map[string]string{"product_name": string(text)})
+				return
+			}
+		}
 		cacheMu.Lock()
 		if entry, ok := cache[cacheKey]; ok && time.Now().Before(entry.expires) {
 			cacheMu.Unlock()
@@ -287,7 +477,7 @@ func HandleTranslateProductName(app *App) http.HandlerFunc {
 		}
 		ch := make(chan result, 1)
 		go func() {
-			translated, err := app.queryEngine.TranslateText(name, lang)
+			translated, err := app.queryEngine.TranslateText(llmCtx, name, lang)
 			select {
 			case ch <- result{translated, err}:
 			case <-llmCtx.Done():
@@ -301,9 +491,13 @@ func HandleTranslateProductName(app *App) http.HandlerFunc {
 				return
 			}
 			// Cache the result for 30 minutes
-			cacheMu.Lock()
-			cache[cacheKey] = cacheEntry{text: res.text, expires: time.Now().Add(30 * time.Minute)}
-			cacheMu.Unlock()
+			if sharedKey != "" {
+				app.sharedCache.Set(sharedKey, []byte(res.text), 30*time.Minute)
+			} else {
+				cacheMu.Lock()
+				cache[cacheKey] = cacheEntry{text: res.text, expires: time.Now().Add(30 * time.Minute)}
+				cacheMu.Unlock()
+			}
 			WriteJSON(w, http.StatusOK, map[string]string{"product_name": res.text})
 		case <-llmCtx.Done():
 			// LLM too slow, return original name
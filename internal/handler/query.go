@@ -1,12 +1,15 @@
 package handler
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"strings"
 
+	"askflow/internal/config"
 	"askflow/internal/errlog"
 	"askflow/internal/query"
+	"askflow/internal/rbac"
 )
 
 // HandleQuery processes a user question through the RAG pipeline.
@@ -17,16 +20,27 @@ func HandleQuery(app *App) http.HandlerFunc {
 			return
 		}
 		// Validate user session
-		_, err := GetUserSession(app, r)
+		userID, err := GetUserSession(app, r)
 		if err != nil {
 			WriteError(w, http.StatusUnauthorized, err.Error())
 			return
 		}
-		var req query.QueryRequest
-		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+		var body struct {
+			query.QueryRequest
+			CaptchaToken string `json:"captcha_token"`
+		}
+		if err := ReadJSONBody(r, &body); err != nil {
+			writeBodyError(w, err)
 			return
 		}
+		req := body.QueryRequest
+		// The anonymous frontend session is shared by everyone, so each of
+		// its questions can be made to pass a challenge
+		if userID == "anonymous_user" {
+			if c, ok := requiredChallenge(app, config.CaptchaAnonymousQuery); ok && !checkChallenge(w, r, c, body.CaptchaToken) {
+				return
+			}
+		}
 		question := strings.TrimSpace(req.Question)
 		if question == "" {
 			WriteError(w, http.StatusBadRequest, "question is required")
@@ -38,19 +52,31 @@ func HandleQuery(app *App) http.HandlerFunc {
 			return
 		}
 		req.Question = question
+		// Usage is billed to the signed-in user, not to a client-supplied ID
+		req.UserID = userID
 		// Validate product_id format if provided
 		if req.ProductID != "" && !IsValidOptionalID(req.ProductID) {
 			WriteError(w, http.StatusBadRequest, "invalid product_id")
 			return
 		}
+		if req.ProductID != "" && !app.productInTenant(r, req.ProductID) {
+			WriteError(w, http.StatusBadRequest, "产品不存在")
+			return
+		}
 		// Default to first product if no product_id specified
 		if req.ProductID == "" {
-			firstID, pErr := app.GetFirstProductID()
+			firstID, pErr := app.GetFirstProductID(requestTenantID(r))
 			if pErr == nil && firstID != "" {
 				req.ProductID = firstID
 			}
 		}
-		resp, err := app.queryEngine.Query(req)
+		if !app.scopeQuery(w, requestTenantID(r), &req) {
+			return
+		}
+		resp, err := app.MeteredQuery(r.Context(), req)
+		if writeImageError(w, err) || writeUsageQuotaError(w, err) || writeEmbeddingBusy(w, err) || writeQueryBusy(app, w, err) {
+			return
+		}
 		if err != nil {
 			log.Printf("[Query] error: %v", err)
 			errlog.Logf("[Query] query processing failed: %v", err)
@@ -74,3 +100,83 @@ func HandleQuery(app *App) http.HandlerFunc {
 		WriteJSON(w, http.StatusOK, resp)
 	}
 }
+
+// writeImageError responds with 400 if err means the image attached to the
+// question was refused, and reports whether it did.
+func writeImageError(w http.ResponseWriter, err error) bool {
+	var ie *query.ImageError
+	if !errors.As(err, &ie) {
+		return false
+	}
+	WriteError(w, http.StatusBadRequest, ie.Error())
+	return true
+}
+
+// HandleAdminQueryDebug answers a question as a dry run and returns the
+// internals of every pipeline stage: the classified intent, the candidate
+// chunks with their scores before and after the threshold and reordering,
+// the exact messages sent to the LLM and the tokens used. Retrieval
+// settings can be overridden to try them out before saving them.
+func HandleAdminQueryDebug(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var body struct {
+			Question  string           `json:"question"`
+			ProductID string           `json:"product_id"`
+			ImageData string           `json:"image_data"`
+			UserID    string           `json:"user_id"` // answer with the documents this user may see
+			Overrides *query.Overrides `json:"overrides"`
+		}
+		if err := ReadJSONBody(r, &body); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		question := strings.TrimSpace(body.Question)
+		if question == "" {
+			WriteError(w, http.StatusBadRequest, "question is required")
+			return
+		}
+		if len(question) > 10000 {
+			WriteError(w, http.StatusBadRequest, "question too long (max 10000 characters)")
+			return
+		}
+		if body.ProductID != "" && !IsValidHexID(body.ProductID) {
+			WriteError(w, http.StatusBadRequest, "invalid product_id")
+			return
+		}
+		if body.Overrides != nil {
+			if err := body.Overrides.Validate(); err != nil {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		adminID, _, err := RequireAdminPermission(app, r, rbac.PermManageConfig, body.ProductID)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		userID := body.UserID
+		if userID == "" {
+			userID = adminID
+		}
+		result, err := app.DebugQuery(r.Context(), query.QueryRequest{
+			Question:  question,
+			UserID:    userID,
+			ProductID: body.ProductID,
+			ImageData: body.ImageData,
+			Overrides: body.Overrides,
+		})
+		if writeImageError(w, err) || writeEmbeddingBusy(w, err) || writeQueryBusy(app, w, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("[Query] debug error: %v", err)
+			WriteError(w, http.StatusInternalServerError, "查询处理失败，请稍后重试")
+			return
+		}
+		WriteJSON(w, http.StatusOK, result)
+	}
+}
@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"askflow/internal/rbac"
+	"askflow/internal/refusal"
+)
+
+// refusalRuleRequest is the body of creating or updating a rule; rules are
+// enabled unless enabled is false.
+type refusalRuleRequest struct {
+	ProductID string   `json:"product_id"`
+	Topic     string   `json:"topic"`
+	Keywords  []string `json:"keywords"`
+	Examples  []string `json:"examples"`
+	Message   string   `json:"message"`
+	Enabled   *bool    `json:"enabled"`
+}
+
+func (req *refusalRuleRequest) rule() refusal.Rule {
+	return refusal.Rule{
+		ProductID: req.ProductID,
+		Topic:     req.Topic,
+		Keywords:  req.Keywords,
+		Examples:  req.Examples,
+		Message:   req.Message,
+		Enabled:   req.Enabled == nil || *req.Enabled,
+	}
+}
+
+// HandleAdminRefusals manages the "do not answer" list:
+//
+//	GET  /api/admin/refusals   all rules
+//	POST /api/admin/refusals   add a rule
+//
+// product_id "" makes a rule apply to all products.
+func HandleAdminRefusals(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			if _, _, err := RequireAdminPermission(app, r, rbac.PermManageConfig, ""); err != nil {
+				WriteAdminSessionError(w, err)
+				return
+			}
+			list, err := app.refusalService.List()
+			if err != nil {
+				log.Printf("[Refusal] list rules error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "failed to list rules")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]interface{}{"rules": list})
+		case http.MethodPost:
+			var req refusalRuleRequest
+			if err := ReadJSONBody(r, &req); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			if req.ProductID != "" && !IsValidHexID(req.ProductID) {
+				WriteError(w, http.StatusBadRequest, "invalid product_id")
+				return
+			}
+			if _, _, err := RequireAdminPermission(app, r, rbac.PermManageConfig, req.ProductID); err != nil {
+				WriteAdminSessionError(w, err)
+				return
+			}
+			rule, err := app.refusalService.Create(req.rule())
+			if err != nil {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			WriteJSON(w, http.StatusCreated, rule)
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
+
+// HandleAdminRefusalByID updates or removes a rule of the "do not answer"
+// list:
+//
+//	PUT    /api/admin/refusals/{id}   replace the rule (its product stays)
+//	DELETE /api/admin/refusals/{id}   remove it
+func HandleAdminRefusalByID(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/api/admin/refusals/")
+		if !IsValidHexID(id) {
+			WriteError(w, http.StatusBadRequest, "invalid rule ID")
+			return
+		}
+		if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		existing, err := app.refusalService.Get(id)
+		if errors.Is(err, refusal.ErrNotFound) {
+			WriteError(w, http.StatusNotFound, "拒答规则不存在")
+			return
+		} else if err != nil {
+			log.Printf("[Refusal] get rule error: %v", err)
+			WriteError(w, http.StatusInternalServerError, "failed to load rule")
+			return
+		}
+		if _, _, err := RequireAdminPermission(app, r, rbac.PermManageConfig, existing.ProductID); err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			if err := app.refusalService.Delete(id); err != nil {
+				if errors.Is(err, refusal.ErrNotFound) {
+					WriteError(w, http.StatusNotFound, "拒答规则不存在")
+					return
+				}
+				log.Printf("[Refusal] delete rule error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "failed to delete rule")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+			return
+		}
+
+		var req refusalRuleRequest
+		if err := ReadJSONBody(r, &req); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		rule := req.rule()
+		rule.ID = id
+		updated, err := app.refusalService.Update(rule)
+		if err != nil {
+			if errors.Is(err, refusal.ErrNotFound) {
+				WriteError(w, http.StatusNotFound, "拒答规则不存在")
+				return
+			}
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		WriteJSON(w, http.StatusOK, updated)
+	}
+}
+
+// HandleAdminRefusalTest checks a question against the "do not answer"
+// list without answering it: POST /api/admin/refusals/test with
+// {"question": "...", "product_id": "..."}.
+func HandleAdminRefusalTest(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var req struct {
+			Question  string `json:"question"`
+			ProductID string `json:"product_id"`
+		}
+		if err := ReadJSONBody(r, &req); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if strings.TrimSpace(req.Question) == "" {
+			WriteError(w, http.StatusBadRequest, "question is required")
+			return
+		}
+		if req.ProductID != "" && !IsValidHexID(req.ProductID) {
+			WriteError(w, http.StatusBadRequest, "invalid product_id")
+			return
+		}
+		if _, _, err := RequireAdminPermission(app, r, rbac.PermManageConfig, req.ProductID); err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		m := app.TestRefusal(r.Context(), req.ProductID, req.Question)
+		WriteJSON(w, http.StatusOK, map[string]interface{}{"refused": m != nil, "match": m})
+	}
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"askflow/internal/replica"
+	"askflow/internal/usage"
+)
+
+// replicaAuthorized checks the bearer token of a request from a read-only
+// replica against replica.token and writes the error response if it does
+// not match. Without a token configured replicas are refused.
+func replicaAuthorized(app *App, w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return false
+	}
+	want := app.configManager.Get().Replica.Token
+	if want == "" {
+		WriteError(w, http.StatusForbidden, "未启用只读副本")
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !hmac.Equal([]byte(token), []byte(want)) {
+		WriteError(w, http.StatusUnauthorized, "副本令牌无效")
+		return false
+	}
+	return true
+}
+
+// HandleReplicaPending handles POST /api/replica/pending: a question that
+// went pending on a read-only replica, recorded here with the same
+// notifications and suggested answer as one asked on this instance.
+func HandleReplicaPending(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !replicaAuthorized(app, w, r) {
+			return
+		}
+		var q replica.PendingQuestion
+		if err := ReadJSONBody(r, &q); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if strings.TrimSpace(q.Question) == "" || q.UserID == "" {
+			WriteError(w, http.StatusBadRequest, "question is required")
+			return
+		}
+		if !IsValidOptionalID(q.ProductID) {
+			WriteError(w, http.StatusBadRequest, "invalid product_id")
+			return
+		}
+		if err := app.queryEngine.CreatePendingQuestion(q.Question, q.UserID, q.ImageData, q.ProductID); err != nil {
+			log.Printf("[Replica] failed to create pending question: %v", err)
+			WriteError(w, http.StatusInternalServerError, "创建待处理问题失败")
+			return
+		}
+		WriteJSON(w, http.StatusCreated, map[string]string{"status": "ok"})
+	}
+}
+
+// HandleReplicaUsage handles POST /api/replica/usage: the usage of a query
+// answered on a read-only replica, added to this instance's counters.
+func HandleReplicaUsage(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !replicaAuthorized(app, w, r) {
+			return
+		}
+		var u replica.Usage
+		if err := ReadJSONBody(r, &u); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if !IsValidOptionalID(u.ProductID) || u.EmbeddingTokens < 0 || u.PromptTokens < 0 || u.CompletionTokens < 0 {
+			WriteError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		if err := app.usageService.Record(u.UserID, u.ProductID, usage.Tokens{
+			Embedding:  u.EmbeddingTokens,
+			Prompt:     u.PromptTokens,
+			Completion: u.CompletionTokens,
+		}); err != nil {
+			log.Printf("[Replica] failed to record usage for user=%s product=%s: %v", u.UserID, u.ProductID, err)
+			WriteError(w, http.StatusInternalServerError, "记录用量失败")
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// HandleReplicaAnswer handles POST /api/replica/answers: an answer given
+// on a read-only replica, kept here so the user can export it.
+func HandleReplicaAnswer(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !replicaAuthorized(app, w, r) {
+			return
+		}
+		var ans replica.Answer
+		if err := ReadJSONBody(r, &ans); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if !IsValidHexID(ans.QueryID) || ans.UserID == "" || !IsValidOptionalID(ans.ProductID) || !json.Valid(ans.Sources) {
+			WriteError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		if err := app.storeAnswer(ans); err != nil {
+			log.Printf("[Replica] failed to keep answer %s: %v", ans.QueryID, err)
+			WriteError(w, http.StatusInternalServerError, "保存回答失败")
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
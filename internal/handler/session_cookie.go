@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"askflow/internal/auth"
+	"askflow/internal/config"
+	"askflow/internal/middleware"
+)
+
+// Session cookie names used in cookie session mode. User and admin sessions
+// are kept apart, mirroring the separate localStorage keys of bearer mode.
+// Refresh cookies are scoped to the refresh endpoint.
+const (
+	SessionCookieName      = "askflow_session"
+	AdminSessionCookieName = "askflow_admin_session"
+	RefreshCookieName      = "askflow_refresh"
+	AdminRefreshCookieName = "askflow_admin_refresh"
+
+	refreshCookiePath = "/api/auth/refresh"
+)
+
+// cookieSessionMode reports whether new sessions are delivered as httpOnly cookies.
+func (a *App) cookieSessionMode() bool {
+	return a.configManager.Get().Server.SessionMode == config.SessionModeCookie
+}
+
+// requestToken returns the session token of r: a non-empty bearer token
+// takes precedence, otherwise the first of cookieNames that is set.
+func requestToken(r *http.Request, cookieNames ...string) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" {
+		return token
+	}
+	for _, name := range cookieNames {
+		if c, err := r.Cookie(name); err == nil && c.Value != "" {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// isSecureRequest reports whether r reached us (or the fronting proxy) over HTTPS.
+func isSecureRequest(r *http.Request) bool {
+	return strings.HasPrefix(GetBaseURL(r), "https://")
+}
+
+// deliverSession hands a newly created session to the client, records the
+// device of its login (see HandleSessions) and signs out the user's oldest
+// logins beyond sessions.max_per_user. In bearer mode it is returned
+// unchanged for the JSON body. In cookie mode the access and refresh tokens
+// are set as httpOnly cookies together with a fresh CSRF cookie, and the
+// returned copy has both tokens blanked so they never reach page scripts.
+func deliverSession(app *App, w http.ResponseWriter, r *http.Request, s *auth.Session, admin bool) *auth.Session {
+	if s != nil && s.RefreshToken != "" {
+		if err := app.sessionManager.TrackDevice(s.ID, r.UserAgent(), middleware.GetClientIP(r)); err != nil {
+			log.Printf("[Auth] failed to record login device: %v", err)
+		}
+		app.limitSessions(s.UserID)
+	}
+	if s == nil || !app.cookieSessionMode() {
+		return s
+	}
+	name, refreshName := SessionCookieName, RefreshCookieName
+	if admin {
+		name, refreshName = AdminSessionCookieName, AdminRefreshCookieName
+	}
+	secure := isSecureRequest(r)
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    s.ID,
+		Path:     "/",
+		Expires:  s.ExpiresAt,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	csrfExpires := s.ExpiresAt
+	if s.RefreshToken != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     refreshName,
+			Value:    s.RefreshToken,
+			Path:     app.appPath(refreshCookiePath),
+			Expires:  s.RefreshExpiresAt,
+			HttpOnly: true,
+			Secure:   secure,
+			SameSite: http.SameSiteLaxMode,
+		})
+		// The CSRF cookie must outlive the access token so refresh can be called
+		csrfExpires = s.RefreshExpiresAt
+	}
+	csrf := make([]byte, 32)
+	rand.Read(csrf)
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.CSRFCookieName,
+		Value:    hex.EncodeToString(csrf),
+		Path:     "/",
+		Expires:  csrfExpires,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	out := *s
+	out.ID = ""
+	out.RefreshToken = ""
+	return &out
+}
+
+// clearSessionCookie expires the user or admin session and refresh cookies.
+// The CSRF cookie is left alone since the other session may still be using it.
+func clearSessionCookie(app *App, w http.ResponseWriter, r *http.Request, admin bool) {
+	name, refreshName := SessionCookieName, RefreshCookieName
+	if admin {
+		name, refreshName = AdminSessionCookieName, AdminRefreshCookieName
+	}
+	for _, c := range []struct{ name, path string }{{name, "/"}, {refreshName, app.appPath(refreshCookiePath)}} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     c.name,
+			Value:    "",
+			Path:     c.path,
+			Expires:  time.Unix(0, 0),
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   isSecureRequest(r),
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+}
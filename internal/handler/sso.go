@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"askflow/internal/auth"
+)
+
+// maxSAMLResponse caps the size of a posted SAMLResponse form.
+const maxSAMLResponse = 1 << 20
+
+// validSSOProviderName reports whether name is safe to use as a provider key.
+func validSSOProviderName(name string) bool {
+	return name != "" && len(name) <= 50 && !strings.ContainsAny(name, "/<>\"'\\ ")
+}
+
+// ssoLoginFailed sends the browser back to the login page after a failed SSO login.
+func ssoLoginFailed(app *App, w http.ResponseWriter, r *http.Request, provider string, err error) {
+	log.Printf("[SSO] login via %s failed: %v", provider, err)
+	http.Redirect(w, r, app.appPath("/login?error=sso_failed"), http.StatusFound)
+}
+
+// ssoLoginSucceeded creates the session and hands it to the SPA via a
+// one-time code (same pattern as ticket login).
+func ssoLoginSucceeded(app *App, w http.ResponseWriter, r *http.Request, user *auth.SSOUser) {
+	code, err := app.CompleteSSOLogin(user)
+	if err != nil {
+		ssoLoginFailed(app, w, r, user.Provider, err)
+		return
+	}
+	http.Redirect(w, r, app.appPath("/?sso_code="+url.QueryEscape(code)), http.StatusFound)
+}
+
+// HandleSSOLogin handles GET /api/sso/login?type=oidc|saml&provider=name and
+// redirects the browser to the identity provider.
+func HandleSSOLogin(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		q := r.URL.Query()
+		provider := q.Get("provider")
+		if !validSSOProviderName(provider) {
+			WriteError(w, http.StatusBadRequest, "invalid provider name")
+			return
+		}
+		var target string
+		var err error
+		switch q.Get("type") {
+		case auth.SSOTypeOIDC:
+			target, err = app.ssoClient.OIDCAuthURL(r.Context(), provider)
+		case auth.SSOTypeSAML:
+			target, err = app.ssoClient.SAMLAuthURL(provider)
+		default:
+			WriteError(w, http.StatusBadRequest, "invalid SSO type")
+			return
+		}
+		if err != nil {
+			ssoLoginFailed(app, w, r, provider, err)
+			return
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+	}
+}
+
+// HandleOIDCCallback handles GET /api/sso/oidc/callback, the redirect URL
+// registered with every OIDC provider. The state identifies the provider.
+func HandleOIDCCallback(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		q := r.URL.Query()
+		if e := q.Get("error"); e != "" {
+			ssoLoginFailed(app, w, r, "oidc", fmt.Errorf("%s: %s", e, q.Get("error_description")))
+			return
+		}
+		user, err := app.ssoClient.OIDCCallback(r.Context(), q.Get("state"), q.Get("code"))
+		if err != nil {
+			ssoLoginFailed(app, w, r, "oidc", err)
+			return
+		}
+		ssoLoginSucceeded(app, w, r, user)
+	}
+}
+
+// HandleSAMLACS handles POST /api/sso/saml/acs/{name}, the assertion consumer
+// service receiving the IdP's SAMLResponse (HTTP-POST binding).
+func HandleSAMLACS(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		provider := strings.TrimPrefix(r.URL.Path, "/api/sso/saml/acs/")
+		if !validSSOProviderName(provider) {
+			WriteError(w, http.StatusBadRequest, "invalid provider name")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxSAMLResponse)
+		if err := r.ParseForm(); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		user, err := app.ssoClient.SAMLConsume(provider, r.PostForm.Get("SAMLResponse"))
+		if err != nil {
+			ssoLoginFailed(app, w, r, provider, err)
+			return
+		}
+		ssoLoginSucceeded(app, w, r, user)
+	}
+}
+
+// HandleSAMLMetadata handles GET /api/sso/saml/metadata/{name} and returns the
+// SP metadata XML to import into the identity provider.
+func HandleSAMLMetadata(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		provider := strings.TrimPrefix(r.URL.Path, "/api/sso/saml/metadata/")
+		if !validSSOProviderName(provider) {
+			WriteError(w, http.StatusBadRequest, "invalid provider name")
+			return
+		}
+		data, err := app.ssoClient.SAMLMetadata(provider)
+		if err != nil {
+			WriteError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/samlmetadata+xml")
+		w.Write(data)
+	}
+}
+
+// HandleSSOExchange handles POST /api/sso/exchange — redeems the one-time
+// code from the SSO redirect for {session, user, admin, role}.
+func HandleSSOExchange(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var req struct {
+			Code string `json:"code"`
+		}
+		if err := ReadJSONBody(r, &req); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if req.Code == "" || len(req.Code) > 128 {
+			WriteError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		result, err := app.RedeemSSOHandoff(req.Code)
+		if err != nil {
+			WriteError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		result.Session = deliverSession(app, w, r, result.Session, result.Admin)
+		WriteJSON(w, http.StatusOK, result)
+	}
+}
+
+// HandleSSOProviderDelete handles DELETE /api/sso/providers/{type}/{name}.
+func HandleSSOProviderDelete(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		_, role, err := GetAdminSession(app, r)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		if role != "super_admin" {
+			WriteError(w, http.StatusForbidden, "无权限")
+			return
+		}
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/sso/providers/"), "/", 2)
+		if len(parts) != 2 || (parts[0] != auth.SSOTypeOIDC && parts[0] != auth.SSOTypeSAML) || !validSSOProviderName(parts[1]) {
+			WriteError(w, http.StatusBadRequest, "invalid provider")
+			return
+		}
+		if err := app.DeleteSSOProvider(parts[0], parts[1]); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
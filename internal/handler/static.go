@@ -1,10 +1,13 @@
 package handler
 
 import (
+	"encoding/json"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"askflow/internal/tenant"
 )
 
 // NoDirListing wraps an http.Handler to prevent directory listing.
@@ -22,7 +25,8 @@ func NoDirListing(h http.Handler) http.Handler {
 // SpaHandler serves static files from dir, falling back to index.html for SPA routes.
 // IMPORTANT: /api/* and /auth/* paths are never served by the SPA — if they reach here
 // it means no backend handler matched, so we return a proper JSON 404 or HTTP 404.
-func SpaHandler(dir string) http.Handler {
+// basePath is the URL prefix the app is served under; see serveIndex.
+func SpaHandler(dir, basePath string) http.Handler {
 	fileServer := http.FileServer(http.Dir(dir))
 	indexPath := filepath.Join(dir, "index.html")
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -78,13 +82,37 @@ func SpaHandler(dir string) http.Handler {
 			fileServer.ServeHTTP(w, r)
 			return
 		}
-		// Fallback: serve index.html for SPA routing
-		http.ServeFile(w, r, indexPath)
+		// Fallback: serve index.html for SPA routing. A path-selected tenant
+		// adds its /t/<slug> prefix to the base path the frontend sees.
+		serveIndex(w, r, indexPath, basePath+tenant.PrefixFromContext(r.Context()))
 	})
 }
 
+// serveIndex serves the SPA entry point. Under a base path the root-relative
+// asset URLs are prefixed and the prefix is exposed to scripts as
+// window.ASKFLOW_BASE_PATH, which app.js uses for API calls and routing.
+func serveIndex(w http.ResponseWriter, r *http.Request, indexPath, basePath string) {
+	if basePath == "" {
+		http.ServeFile(w, r, indexPath)
+		return
+	}
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	js, _ := json.Marshal(basePath)
+	html := strings.NewReplacer(
+		`href="/`, `href="`+basePath+`/`,
+		`src="/`, `src="`+basePath+`/`,
+		"<head>", "<head>\n    <script>window.ASKFLOW_BASE_PATH = "+string(js)+";</script>",
+	).Replace(string(data))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(html))
+}
+
 // HandleMediaStream serves video/audio files with proper content types and range request support.
-// Requires a valid user session (via Authorization header or ?token= query param).
+// Requires a valid user session (via Authorization header, session cookie or ?token= query param).
 func HandleMediaStream(app *App) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -93,9 +121,8 @@ func HandleMediaStream(app *App) http.HandlerFunc {
 		}
 		// Authenticate: support both Authorization header and ?token= query param
 		// (query param needed for <video> src attributes that can't set headers)
-		authHeader := r.Header.Get("Authorization")
-		token := strings.TrimPrefix(authHeader, "Bearer ")
-		if token == "" || token == authHeader {
+		token := requestToken(r, SessionCookieName, AdminSessionCookieName)
+		if token == "" {
 			token = r.URL.Query().Get("token")
 		}
 		if token == "" {
@@ -118,26 +145,13 @@ func HandleMediaStream(app *App) http.HandlerFunc {
 				return
 			}
 		}
-		filePath, fileName, err := app.docManager.GetFilePath(docID)
+		orig, err := app.docManager.GetOriginal(docID)
 		if err != nil {
 			WriteError(w, http.StatusNotFound, "media not found")
 			return
 		}
-		// Verify file path stays within expected data directory
-		absPath, _ := filepath.Abs(filePath)
-		absDataDir, _ := filepath.Abs(filepath.Join(".", "data"))
-		if realPath, err := filepath.EvalSymlinks(absPath); err == nil {
-			absPath = realPath
-		}
-		if realDataDir, err := filepath.EvalSymlinks(absDataDir); err == nil {
-			absDataDir = realDataDir
-		}
-		if !strings.HasPrefix(absPath, absDataDir+string(filepath.Separator)) {
-			WriteError(w, http.StatusForbidden, "forbidden")
-			return
-		}
 		// Set appropriate content type based on extension
-		ext := strings.ToLower(filepath.Ext(fileName))
+		ext := strings.ToLower(filepath.Ext(orig.Name))
 		contentTypes := map[string]string{
 			".mp4":  "video/mp4",
 			".webm": "video/webm",
@@ -158,56 +172,12 @@ func HandleMediaStream(app *App) http.HandlerFunc {
 				return '_'
 			}
 			return r
-		}, fileName)
+		}, orig.Name)
 		w.Header().Set("Content-Disposition", "inline; filename=\""+safeName+"\"")
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		// Cache media files for 1 hour (they rarely change once uploaded)
 		w.Header().Set("Cache-Control", "public, max-age=3600")
-		// ServeFile handles Range requests and Accept-Ranges automatically for seeking/streaming
-		http.ServeFile(w, r, filePath)
-	}
-}
-
-// ServeImages returns an http.HandlerFunc that serves uploaded images with path validation.
-// It prevents directory listing and path traversal attacks.
-func ServeImages() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		name := strings.TrimPrefix(r.URL.Path, "/api/images/")
-		if name == "" || name == "upload" || strings.Contains(name, "..") || strings.Contains(name, "/") || strings.Contains(name, "\\") {
-			http.NotFound(w, r)
-			return
-		}
-		filePath := filepath.Join(".", "data", "images", name)
-		// Verify the resolved path stays within the images directory
-		absDir, _ := filepath.Abs(filepath.Join(".", "data", "images"))
-		absFile, _ := filepath.Abs(filePath)
-		if !strings.HasPrefix(absFile, absDir+string(filepath.Separator)) && absFile != absDir {
-			http.NotFound(w, r)
-			return
-		}
-		w.Header().Set("X-Content-Type-Options", "nosniff")
-		http.ServeFile(w, r, filePath)
-	}
-}
-
-// ServeKnowledgeVideos returns an http.HandlerFunc that serves uploaded knowledge videos
-// with path validation. It prevents directory listing and path traversal attacks.
-func ServeKnowledgeVideos() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		name := strings.TrimPrefix(r.URL.Path, "/api/videos/knowledge/")
-		if name == "" || strings.Contains(name, "..") || strings.Contains(name, "/") || strings.Contains(name, "\\") {
-			http.NotFound(w, r)
-			return
-		}
-		filePath := filepath.Join(".", "data", "videos", "knowledge", name)
-		// Verify the resolved path stays within the videos directory
-		absDir, _ := filepath.Abs(filepath.Join(".", "data", "videos", "knowledge"))
-		absFile, _ := filepath.Abs(filePath)
-		if !strings.HasPrefix(absFile, absDir+string(filepath.Separator)) && absFile != absDir {
-			http.NotFound(w, r)
-			return
-		}
-		w.Header().Set("X-Content-Type-Options", "nosniff")
-		http.ServeFile(w, r, filePath)
+		// Range requests are honoured for seeking/streaming
+		orig.Serve(w, r)
 	}
 }
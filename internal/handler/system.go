@@ -7,12 +7,14 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 
 	"askflow/internal/config"
 	"askflow/internal/email"
 	"askflow/internal/embedding"
 	"askflow/internal/errlog"
 	"askflow/internal/llm"
+	"askflow/internal/rbac"
 )
 
 // --- System status handler (public) ---
@@ -62,7 +64,7 @@ func HandleTestLLM(app *App) http.HandlerFunc {
 			MaxTokens   int     `json:"max_tokens"`
 		}
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
 			return
 		}
 		// If API key is empty, fall back to saved config (user didn't re-enter it)
@@ -83,7 +85,7 @@ func HandleTestLLM(app *App) http.HandlerFunc {
 			req.MaxTokens = 64
 		}
 		svc := llm.NewAPILLMService(req.Endpoint, req.APIKey, req.ModelName, req.Temperature, req.MaxTokens)
-		answer, err := svc.Generate("", nil, "请回复：OK")
+		answer, err := svc.Generate(r.Context(), "", nil, "请回复：OK")
 		if err != nil {
 			log.Printf("[TestLLM] error: %v", err)
 			WriteError(w, http.StatusBadRequest, "LLM 连接测试失败，请检查配置")
@@ -114,7 +116,7 @@ func HandleTestEmbedding(app *App) http.HandlerFunc {
 			UseMultimodal bool   `json:"use_multimodal"`
 		}
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
 			return
 		}
 		// If API key is empty, fall back to saved config (user didn't re-enter it)
@@ -129,7 +131,7 @@ func HandleTestEmbedding(app *App) http.HandlerFunc {
 			return
 		}
 		svc := embedding.NewAPIEmbeddingService(req.Endpoint, req.APIKey, req.ModelName, req.UseMultimodal)
-		vec, err := svc.Embed("hello")
+		vec, err := svc.Embed(r.Context(), "hello")
 		if err != nil {
 			log.Printf("[TestEmbedding] error: %v", err)
 			WriteError(w, http.StatusBadRequest, "Embedding 连接测试失败，请检查配置")
@@ -141,10 +143,11 @@ func HandleTestEmbedding(app *App) http.HandlerFunc {
 
 // --- Config handler with role check ---
 
-// HandleConfigWithRole handles GET (read config) and PUT (update config, super_admin only).
+// HandleConfigWithRole handles GET (read config) and PUT (update config,
+// requires the manage_config permission).
 func HandleConfigWithRole(app *App) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		_, role, err := GetAdminSession(app, r)
+		userID, role, err := GetAdminSession(app, r)
 		if err != nil {
 			WriteAdminSessionError(w, err)
 			return
@@ -159,15 +162,26 @@ func HandleConfigWithRole(app *App) http.HandlerFunc {
 			}
 			WriteJSON(w, http.StatusOK, cfg)
 		case http.MethodPut:
-			if role != "super_admin" {
-				WriteError(w, http.StatusForbidden, "仅超级管理员可修改系统设置")
+			if !app.HasAdminPermission(userID, role, rbac.PermManageConfig, "") {
+				WriteError(w, http.StatusForbidden, "无权修改系统设置")
 				return
 			}
 			var updates map[string]interface{}
 			if err := ReadJSONBody(r, &updates); err != nil {
-				WriteError(w, http.StatusBadRequest, "invalid request body")
+				writeBodyError(w, err)
 				return
 			}
+			// Super admin credentials, SSO role mappings (which grant admin
+			// roles), multi-tenancy, usage quotas, network blocking and login
+			// policies can only be changed by the super admin
+			if role != "super_admin" {
+				for key := range updates {
+					if strings.HasPrefix(key, "admin.") || strings.HasPrefix(key, "sso.") || strings.HasPrefix(key, "tenants.") || strings.HasPrefix(key, "usage.") || strings.HasPrefix(key, "abuse.") || strings.HasPrefix(key, "sessions.") {
+						WriteError(w, http.StatusForbidden, "仅超级管理员可修改管理员账户设置")
+						return
+					}
+				}
+			}
 			if err := app.UpdateConfig(updates); err != nil {
 				log.Printf("[Config] update error: %v", err)
 				errlog.Logf("[Config] update failed: %v", err)
@@ -208,7 +222,7 @@ func HandleEmailTest(app *App) http.HandlerFunc {
 			AuthMethod string `json:"auth_method"`
 		}
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
 			return
 		}
 		// If SMTP params provided in request, use them for testing (allows testing before save)
@@ -249,7 +263,7 @@ func HandleEmailTest(app *App) http.HandlerFunc {
 				return
 			}
 		}
-		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok", "message": "测试邮件已发送"})
+		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok", "message": tr(w, "测试邮件已发送")})
 	}
 }
 
@@ -318,7 +332,7 @@ func HandleLogsRotation(app *App) http.HandlerFunc {
 				RotationMB int `json:"rotation_mb"`
 			}
 			if err := ReadJSONBody(r, &req); err != nil {
-				WriteError(w, http.StatusBadRequest, "invalid request body")
+				writeBodyError(w, err)
 				return
 			}
 			if req.RotationMB < 1 || req.RotationMB > 10240 {
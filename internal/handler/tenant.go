@@ -0,0 +1,470 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"askflow/internal/document"
+	"askflow/internal/pending"
+	"askflow/internal/query"
+	"askflow/internal/tenant"
+)
+
+// requestTenantID returns the tenant the request was routed to ("" for the
+// default workspace).
+func requestTenantID(r *http.Request) string {
+	return tenant.IDFromContext(r.Context())
+}
+
+// DefaultTenantOnly wraps instance-wide handlers (system config, logs,
+// webhooks, backups, tenant management, ...) so they are only reachable from
+// the default workspace and never from a tenant's host name or path.
+func DefaultTenantOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if requestTenantID(r) != "" {
+			WriteError(w, http.StatusForbidden, "该功能仅在主工作区可用")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// adminInTenant reports whether an admin session user may act in tenantID.
+// Sub-accounts belong to exactly one workspace; the instance super admin
+// configured in config.json may act in every workspace.
+func (a *App) adminInTenant(userID, tenantID string) bool {
+	switch {
+	case userID == "admin":
+		return true
+	case strings.HasPrefix(userID, "admin_"):
+		owner, ok := a.tenantService.AdminTenant(strings.TrimPrefix(userID, "admin_"))
+		return ok && owner == tenantID
+	default:
+		return tenantID == ""
+	}
+}
+
+// adminUserInTenant reports whether the admin_users row id belongs to tenantID.
+func (a *App) adminUserInTenant(id, tenantID string) bool {
+	owner, ok := a.tenantService.AdminTenant(id)
+	return ok && owner == tenantID
+}
+
+// productInTenant reports whether productID belongs to the request's tenant.
+// The public library ("") only belongs to the default workspace.
+func (a *App) productInTenant(r *http.Request, productID string) bool {
+	return a.ProductInWorkspace(requestTenantID(r), productID)
+}
+
+// ProductInWorkspace reports whether productID belongs to workspace
+// tenantID ("" for the default workspace, which also owns the public
+// library "").
+func (a *App) ProductInWorkspace(tenantID, productID string) bool {
+	return a.tenantService.OwnsProduct(tenantID, productID)
+}
+
+// documentInTenant reports whether document docID belongs to a product of the
+// request's workspace.
+func (a *App) documentInTenant(r *http.Request, docID string) bool {
+	info, err := a.docManager.GetDocumentInfo(docID)
+	return err == nil && a.productInTenant(r, info.ProductID)
+}
+
+// tenantProductSet returns the products of tenantID as a set, including the
+// public library for the default workspace.
+func (a *App) tenantProductSet(tenantID string) (map[string]bool, error) {
+	ids, err := a.tenantService.ProductIDs(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set, nil
+}
+
+// productScope returns the products a query in tenantID may retrieve from,
+// or nil to keep the engine's default of productID plus the public library.
+// Tenants never see the public library; without a product a query covers
+// every product of its workspace.
+func (a *App) productScope(tenantID, productID string) ([]string, error) {
+	if productID != "" {
+		if tenantID == "" {
+			return nil, nil
+		}
+		return []string{productID}, nil
+	}
+	return a.tenantService.ProductIDs(tenantID)
+}
+
+// ScopeQuery confines req to the products of tenantID and counts it against
+// the workspace's daily query quota. It returns a *tenant.QuotaError when the
+// quota is used up.
+func (a *App) ScopeQuery(tenantID string, req *query.QueryRequest) error {
+	scope, err := a.productScope(tenantID, req.ProductID)
+	if err != nil {
+		return err
+	}
+	req.ProductScope = scope
+	return a.tenantService.CountQuery(tenantID)
+}
+
+// scopeQuery is ScopeQuery for HTTP handlers. It writes the error response
+// and returns false when the query must not run.
+func (a *App) scopeQuery(w http.ResponseWriter, tenantID string, req *query.QueryRequest) bool {
+	if err := a.ScopeQuery(tenantID, req); err != nil {
+		var qe *tenant.QuotaError
+		if errors.As(err, &qe) {
+			WriteError(w, http.StatusTooManyRequests, "今日问答次数已达上限")
+			return false
+		}
+		log.Printf("[Tenants] query scope error: %v", err)
+		WriteError(w, http.StatusInternalServerError, "查询处理失败，请稍后重试")
+		return false
+	}
+	return true
+}
+
+// siteBranding returns the product name and introduction shown to end users:
+// the workspace's own when set, otherwise the instance-wide settings.
+func (a *App) siteBranding(r *http.Request) (name, intro string) {
+	if cfg := a.configManager.Get(); cfg != nil {
+		name, intro = cfg.ProductName, cfg.ProductIntro
+	}
+	if t := tenant.FromContext(r.Context()); t != nil {
+		if t.ProductName != "" {
+			name = t.ProductName
+		}
+		if t.ProductIntro != "" {
+			intro = t.ProductIntro
+		}
+	}
+	return name, intro
+}
+
+// writeQuotaError writes 429 with a readable message when err is a tenant
+// quota error and reports whether it did.
+func writeQuotaError(w http.ResponseWriter, err error) bool {
+	var qe *tenant.QuotaError
+	if !errors.As(err, &qe) {
+		return false
+	}
+	names := map[string]string{
+		tenant.ResourceProducts:  "产品数量",
+		tenant.ResourceDocuments: "文档数量",
+		tenant.ResourceAdmins:    "管理员数量",
+		tenant.ResourceQueries:   "每日问答次数",
+	}
+	WriteError(w, http.StatusTooManyRequests, trf(w, "已达到工作区配额上限：%s（%d）", tr(w, names[qe.Resource]), qe.Limit))
+	return true
+}
+
+// allowDocumentWrite checks that a new document for productID may be added in
+// the request's workspace: the product must belong to it and the workspace's
+// document quota must not be exhausted. It writes the error response and
+// returns false otherwise.
+func (a *App) allowDocumentWrite(w http.ResponseWriter, r *http.Request, productID string) bool {
+	if !a.productInTenant(r, productID) {
+		WriteError(w, http.StatusBadRequest, "产品不存在")
+		return false
+	}
+	if err := a.tenantService.CheckQuota(requestTenantID(r), tenant.ResourceDocuments); err != nil {
+		if !writeQuotaError(w, err) {
+			log.Printf("[Tenants] document quota check error: %v", err)
+			WriteError(w, http.StatusInternalServerError, "检查工作区配额失败")
+		}
+		return false
+	}
+	return true
+}
+
+// ListDocumentsInTenant returns the documents of the request's workspace,
+// optionally narrowed to one of its products.
+func (a *App) ListDocumentsInTenant(tenantID, productID string) ([]document.DocumentInfo, error) {
+	docs, err := a.ListDocuments(productID)
+	if err != nil || productID != "" {
+		return docs, err
+	}
+	set, err := a.tenantProductSet(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return filterByProduct(docs, set, func(d document.DocumentInfo) string { return d.ProductID }), nil
+}
+
+// ListPendingQuestionsInTenant returns the pending questions of the request's
+// workspace, optionally narrowed to one of its products.
+func (a *App) ListPendingQuestionsInTenant(tenantID, status, productID string) ([]pending.PendingQuestion, error) {
+	questions, err := a.ListPendingQuestions(status, productID)
+	if err != nil || productID != "" {
+		return questions, err
+	}
+	set, err := a.tenantProductSet(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return filterByProduct(questions, set, func(q pending.PendingQuestion) string { return q.ProductID }), nil
+}
+
+// ListOverdueQuestionsInTenant returns the overdue pending questions of the
+// request's workspace, optionally narrowed to one of its products.
+func (a *App) ListOverdueQuestionsInTenant(tenantID, productID string) ([]pending.OverdueQuestion, error) {
+	questions, err := a.slaService.Overdue(productID, time.Now())
+	if err != nil || productID != "" {
+		return questions, err
+	}
+	set, err := a.tenantProductSet(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return filterByProduct(questions, set, func(q pending.OverdueQuestion) string { return q.ProductID }), nil
+}
+
+// delegatedProductSet returns the products of the request's workspace on
+// which the admin holds perm. Lists spanning products are narrowed to it so
+// an admin delegated to some products does not see the others' content.
+func (a *App) delegatedProductSet(r *http.Request, userID, role, perm string) (map[string]bool, error) {
+	set, err := a.tenantProductSet(requestTenantID(r))
+	if err != nil || role == "super_admin" || role == "anonymous_viewer" {
+		return set, err
+	}
+	for id := range set {
+		if !a.HasAdminPermission(userID, role, perm, id) {
+			delete(set, id)
+		}
+	}
+	return set, nil
+}
+
+// filterByProduct keeps the items whose product is in set, reusing the
+// backing array of items.
+func filterByProduct[T any](items []T, set map[string]bool, productOf func(T) string) []T {
+	filtered := items[:0]
+	for _, item := range items {
+		if set[productOf(item)] {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
+
+// --- Tenant management (super admin of the default workspace) ---
+
+// TenantInfo is a tenant with its current quota usage.
+type TenantInfo struct {
+	tenant.Tenant
+	Usage *tenant.Usage `json:"usage,omitempty"`
+}
+
+// tenantRequest is the body of POST /api/admin/tenants and PUT /api/admin/tenants/{id}.
+// AdminUsername/AdminPassword optionally create the tenant's first admin
+// account (a super admin of the tenant) on creation.
+type tenantRequest struct {
+	Slug          string       `json:"slug"`
+	Name          string       `json:"name"`
+	Status        string       `json:"status"`
+	ProductName   string       `json:"product_name"`
+	ProductIntro  string       `json:"product_intro"`
+	Quota         tenant.Quota `json:"quota"`
+	AdminUsername string       `json:"admin_username,omitempty"`
+	AdminPassword string       `json:"admin_password,omitempty"`
+}
+
+func (req tenantRequest) tenant() tenant.Tenant {
+	return tenant.Tenant{
+		Slug:         req.Slug,
+		Name:         req.Name,
+		Status:       req.Status,
+		ProductName:  req.ProductName,
+		ProductIntro: req.ProductIntro,
+		Quota:        req.Quota,
+	}
+}
+
+// ListTenants returns all tenants with their usage.
+func (a *App) ListTenants() ([]TenantInfo, error) {
+	tenants, err := a.tenantService.List()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]TenantInfo, 0, len(tenants))
+	for _, t := range tenants {
+		info := TenantInfo{Tenant: t}
+		if u, err := a.tenantService.Usage(t.ID); err == nil {
+			info.Usage = u
+		} else {
+			log.Printf("[Tenants] usage error for %s: %v", t.ID, err)
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// GetTenant returns a tenant with its usage.
+func (a *App) GetTenant(id string) (*TenantInfo, error) {
+	t, err := a.tenantService.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	u, err := a.tenantService.Usage(id)
+	if err != nil {
+		return nil, err
+	}
+	return &TenantInfo{Tenant: *t, Usage: u}, nil
+}
+
+// CreateTenant creates a tenant and, when a username is given, its first
+// admin account with the super_admin role inside the tenant.
+func (a *App) CreateTenant(req tenantRequest) (*TenantInfo, error) {
+	if req.AdminUsername != "" {
+		if msg := ValidatePassword(req.AdminPassword); msg != "" {
+			return nil, errors.New(msg)
+		}
+	}
+	t, err := a.tenantService.Create(req.tenant())
+	if err != nil {
+		return nil, err
+	}
+	if req.AdminUsername != "" {
+		if _, err := a.CreateAdminUser(t.ID, req.AdminUsername, req.AdminPassword, "super_admin", nil); err != nil {
+			if delErr := a.tenantService.Delete(t.ID); delErr != nil {
+				log.Printf("[Tenants] rollback of tenant %s failed: %v", t.ID, delErr)
+			}
+			return nil, err
+		}
+	}
+	return a.GetTenant(t.ID)
+}
+
+// UpdateTenant replaces a tenant's settings and quota.
+func (a *App) UpdateTenant(id string, req tenantRequest) (*TenantInfo, error) {
+	if _, err := a.tenantService.Update(id, req.tenant()); err != nil {
+		return nil, err
+	}
+	return a.GetTenant(id)
+}
+
+// DeleteTenant removes a tenant and its admin accounts. It fails while the
+// tenant still owns products.
+func (a *App) DeleteTenant(id string) error {
+	if _, err := a.tenantService.Get(id); err != nil {
+		return err
+	}
+	products, err := a.productService.ListByTenant(id)
+	if err != nil {
+		return err
+	}
+	if len(products) > 0 {
+		return fmt.Errorf("工作区下仍有 %d 个产品，请先删除", len(products))
+	}
+	admins, err := a.tenantService.AdminUserIDs(id)
+	if err != nil {
+		return err
+	}
+	for _, adminID := range admins {
+		if err := a.DeleteAdminUser(adminID); err != nil {
+			return err
+		}
+	}
+	return a.tenantService.Delete(id)
+}
+
+// HandleAdminTenants handles GET (list) and POST (create) /api/admin/tenants.
+func HandleAdminTenants(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, role, err := GetAdminSession(app, r)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		if role != "super_admin" {
+			WriteError(w, http.StatusForbidden, "仅超级管理员可管理工作区")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			tenants, err := app.ListTenants()
+			if err != nil {
+				log.Printf("[Tenants] list error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "获取工作区列表失败")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]interface{}{"tenants": tenants})
+
+		case http.MethodPost:
+			var req tenantRequest
+			if err := ReadJSONBody(r, &req); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			t, err := app.CreateTenant(req)
+			if err != nil {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			WriteJSON(w, http.StatusOK, t)
+
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
+
+// HandleAdminTenantByID handles GET, PUT and DELETE /api/admin/tenants/{id}.
+func HandleAdminTenantByID(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, role, err := GetAdminSession(app, r)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		if role != "super_admin" {
+			WriteError(w, http.StatusForbidden, "仅超级管理员可管理工作区")
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/api/admin/tenants/")
+		if !IsValidHexID(id) {
+			WriteError(w, http.StatusBadRequest, "invalid tenant ID")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			t, err := app.GetTenant(id)
+			if err != nil {
+				WriteError(w, http.StatusNotFound, "工作区不存在")
+				return
+			}
+			WriteJSON(w, http.StatusOK, t)
+
+		case http.MethodPut:
+			var req tenantRequest
+			if err := ReadJSONBody(r, &req); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			t, err := app.UpdateTenant(id, req)
+			if err != nil {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			WriteJSON(w, http.StatusOK, t)
+
+		case http.MethodDelete:
+			if err := app.DeleteTenant(id); err != nil {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
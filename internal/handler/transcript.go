@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"askflow/internal/document"
+	"askflow/internal/video"
+)
+
+// HandleDocumentTranscript handles GET /api/documents/{id}/transcript: the
+// stored transcript of a video (or subtitle) document as WebVTT, loaded by
+// the player as a subtitle track. Like /api/media/ it is open to any signed
+// in user, with the session token also accepted as ?token=.
+func HandleDocumentTranscript(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		docID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/documents/"), "/transcript")
+		if !IsValidHexID(docID) {
+			WriteError(w, http.StatusBadRequest, "invalid document ID")
+			return
+		}
+		token := requestToken(r, SessionCookieName, AdminSessionCookieName)
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+		if token == "" {
+			WriteError(w, http.StatusUnauthorized, "未登录")
+			return
+		}
+		if _, err := app.sessionManager.ValidateSession(token); err != nil {
+			WriteError(w, http.StatusUnauthorized, "会话已过期")
+			return
+		}
+		if !app.documentInTenant(r, docID) {
+			WriteError(w, http.StatusNotFound, "文档未找到")
+			return
+		}
+		segments, _, err := app.docManager.GetTranscript(docID)
+		if errors.Is(err, document.ErrNoTranscript) {
+			WriteError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			log.Printf("[Documents] transcript of %s: %v", docID, err)
+			WriteError(w, http.StatusInternalServerError, "获取转录失败")
+			return
+		}
+		w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Write(video.FormatVTT(segments))
+	}
+}
@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"askflow/internal/archive"
+	"askflow/internal/rbac"
+	"askflow/internal/upload"
+)
+
+// UploadSessionResponse is an upload session with the chunk size clients
+// should send.
+type UploadSessionResponse struct {
+	*upload.Session
+	ChunkSize int64 `json:"chunk_size"`
+}
+
+func writeUploadSession(w http.ResponseWriter, status int, sess *upload.Session) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(sess.Offset, 10))
+	WriteJSON(w, status, UploadSessionResponse{Session: sess, ChunkSize: upload.MaxChunkSize})
+}
+
+// HandleUploadSessions opens a resumable document upload:
+// POST /api/documents/uploads with file_name, size and product_id.
+func HandleUploadSessions(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var req struct {
+			FileName  string `json:"file_name"`
+			Size      int64  `json:"size"`
+			ProductID string `json:"product_id"`
+		}
+		if err := ReadJSONBody(r, &req); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if !IsValidOptionalID(req.ProductID) {
+			WriteError(w, http.StatusBadRequest, "invalid product_id")
+			return
+		}
+		userID, _, err := RequireAdminPermission(app, r, rbac.PermManageDocs, req.ProductID)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		if !app.productInTenant(r, req.ProductID) {
+			WriteError(w, http.StatusBadRequest, "产品不存在")
+			return
+		}
+		if req.FileName == "" {
+			WriteError(w, http.StatusBadRequest, "文件名不能为空")
+			return
+		}
+		if len(req.FileName) > 500 {
+			WriteError(w, http.StatusBadRequest, "文件名过长")
+			return
+		}
+		if DetectFileType(req.FileName) == "unknown" && !archive.IsZip(req.FileName) {
+			WriteError(w, http.StatusBadRequest, "不支持的文件格式")
+			return
+		}
+		maxUploadSizeMB := app.MaxUploadSizeMB()
+		if req.Size <= 0 {
+			WriteError(w, http.StatusBadRequest, "文件内容为空")
+			return
+		}
+		if req.Size > int64(maxUploadSizeMB)<<20 {
+			WriteError(w, http.StatusBadRequest, fmt.Sprintf("文件大小超过限制 (%dMB)", maxUploadSizeMB))
+			return
+		}
+		sess, err := app.uploadStore.Create(req.FileName, req.ProductID, userID, req.Size)
+		if err != nil {
+			log.Printf("[Upload] create session error: %v", err)
+			WriteError(w, http.StatusInternalServerError, "创建上传会话失败")
+			return
+		}
+		writeUploadSession(w, http.StatusCreated, sess)
+	}
+}
+
+// HandleUploadSessionByID handles an upload session opened by the same admin:
+// GET returns its offset, PATCH appends a chunk starting at the offset in the
+// Upload-Offset header, POST /complete adds the received file as a document
+// and DELETE abandons the upload.
+func HandleUploadSessionByID(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/api/documents/uploads/")
+		id, complete := strings.CutSuffix(id, "/complete")
+		if !IsValidHexID(id) {
+			WriteError(w, http.StatusBadRequest, "invalid upload ID")
+			return
+		}
+		userID, role, err := GetAdminSession(app, r)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		sess, err := app.uploadStore.Get(id)
+		if err != nil || sess.CreatedBy != userID {
+			if err != nil && !errors.Is(err, upload.ErrNotFound) {
+				log.Printf("[Upload] load session %s error: %v", id, err)
+			}
+			WriteError(w, http.StatusNotFound, "上传会话不存在或已过期")
+			return
+		}
+
+		switch {
+		case complete && r.Method == http.MethodPost:
+			sess, data, err := app.uploadStore.Data(id)
+			if errors.Is(err, upload.ErrIncomplete) {
+				w.Header().Set("Upload-Offset", strconv.FormatInt(sess.Offset, 10))
+				WriteError(w, http.StatusConflict, "文件尚未上传完成")
+				return
+			}
+			if errors.Is(err, upload.ErrNotFound) {
+				WriteError(w, http.StatusNotFound, "上传会话不存在或已过期")
+				return
+			}
+			if err != nil {
+				log.Printf("[Upload] read session %s error: %v", id, err)
+				WriteError(w, http.StatusInternalServerError, "failed to read file")
+				return
+			}
+			// A failed import keeps the session so the client can retry
+			// transient errors; it expires or is deleted by the client.
+			if storeUploadedDocument(app, w, r, userID, role, sess.FileName, sess.ProductID, data, nil) {
+				if err := app.uploadStore.Delete(id); err != nil {
+					log.Printf("[Upload] delete session %s error: %v", id, err)
+				}
+			}
+
+		case complete:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+
+		case r.Method == http.MethodGet || r.Method == http.MethodHead:
+			writeUploadSession(w, http.StatusOK, sess)
+
+		case r.Method == http.MethodPatch:
+			offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+			if err != nil || offset < 0 {
+				WriteError(w, http.StatusBadRequest, "invalid Upload-Offset header")
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, upload.MaxChunkSize)
+			extendUploadDeadlines(w)
+			sess, err := app.uploadStore.WriteChunk(id, offset, r.Body)
+			switch {
+			case err == nil:
+				writeUploadSession(w, http.StatusOK, sess)
+			case errors.Is(err, upload.ErrOffsetMismatch), errors.Is(err, upload.ErrBusy):
+				w.Header().Set("Upload-Offset", strconv.FormatInt(sess.Offset, 10))
+				WriteError(w, http.StatusConflict, "上传偏移量不匹配，请从当前偏移量继续")
+			case errors.Is(err, upload.ErrNotFound):
+				WriteError(w, http.StatusNotFound, "上传会话不存在或已过期")
+			case errors.Is(err, upload.ErrTooLarge):
+				WriteError(w, http.StatusBadRequest, "上传数据超过声明的文件大小")
+			case sess != nil:
+				// The connection broke off; what arrived is kept
+				log.Printf("[Upload] session %s: %v", id, err)
+				w.Header().Set("Upload-Offset", strconv.FormatInt(sess.Offset, 10))
+				if !writeBodyTooLarge(w, err) {
+					WriteError(w, http.StatusBadRequest, "上传中断，请从当前偏移量继续")
+				}
+			default:
+				log.Printf("[Upload] write session %s error: %v", id, err)
+				WriteError(w, http.StatusInternalServerError, "保存上传数据失败")
+			}
+
+		case r.Method == http.MethodDelete:
+			if err := app.uploadStore.Delete(id); err != nil && !errors.Is(err, upload.ErrNotFound) {
+				if errors.Is(err, upload.ErrBusy) {
+					WriteError(w, http.StatusConflict, "上传会话正在写入")
+					return
+				}
+				log.Printf("[Upload] delete session %s error: %v", id, err)
+				WriteError(w, http.StatusInternalServerError, "删除上传会话失败")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
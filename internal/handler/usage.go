@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"askflow/internal/rbac"
+	"askflow/internal/usage"
+)
+
+// writeUsageQuotaError writes a 429 carrying the exhausted quota when err is
+// a *usage.QuotaError, and reports whether it did.
+func writeUsageQuotaError(w http.ResponseWriter, err error) bool {
+	var qe *usage.QuotaError
+	if !errors.As(err, &qe) {
+		return false
+	}
+	msg := "本月问答次数已达上限"
+	if qe.Metric == usage.MetricTokens {
+		msg = "本月用量已达上限"
+	}
+	WriteJSON(w, http.StatusTooManyRequests, map[string]interface{}{
+		"error": tr(w, msg),
+		"quota": qe,
+	})
+	return true
+}
+
+// UsageReport returns the usage counters of a period ("" for the current one).
+func (a *App) UsageReport(period, scope, subjectID string) ([]usage.Record, error) {
+	if period == "" {
+		period = usage.CurrentPeriod()
+	}
+	return a.usageService.Report(period, scope, subjectID)
+}
+
+// ListUsageQuotas returns the per-user and per-product quota overrides.
+func (a *App) ListUsageQuotas() ([]usage.Override, error) {
+	return a.usageService.ListOverrides()
+}
+
+// SetUsageQuota sets the monthly limits of one user or product.
+func (a *App) SetUsageQuota(scope, subjectID string, l usage.Limits) error {
+	return a.usageService.SetOverride(scope, subjectID, l)
+}
+
+// DeleteUsageQuota returns a user or product to the default limits.
+func (a *App) DeleteUsageQuota(scope, subjectID string) error {
+	return a.usageService.DeleteOverride(scope, subjectID)
+}
+
+// HandleAdminUsage returns the monthly usage counters per user and product.
+// Query parameters: period (YYYY-MM, default current month), scope
+// ("user" or "product") and subject_id.
+func HandleAdminUsage(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if _, _, err := RequireAdminPermission(app, r, rbac.PermViewAnalytics, ""); err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		q := r.URL.Query()
+		period, scope, subjectID := q.Get("period"), q.Get("scope"), q.Get("subject_id")
+		if period != "" && !usage.ValidPeriod(period) {
+			WriteError(w, http.StatusBadRequest, "invalid period (expected YYYY-MM)")
+			return
+		}
+		if scope != "" && scope != usage.ScopeUser && scope != usage.ScopeProduct {
+			WriteError(w, http.StatusBadRequest, "invalid scope")
+			return
+		}
+		if len(subjectID) > 100 {
+			WriteError(w, http.StatusBadRequest, "invalid subject_id")
+			return
+		}
+		records, err := app.UsageReport(period, scope, subjectID)
+		if err != nil {
+			log.Printf("[Usage] report error: %v", err)
+			WriteError(w, http.StatusInternalServerError, "failed to load usage")
+			return
+		}
+		if period == "" {
+			period = usage.CurrentPeriod()
+		}
+		WriteJSON(w, http.StatusOK, map[string]interface{}{
+			"period":  period,
+			"records": records,
+		})
+	}
+}
+
+// HandleAdminUsageQuotas manages per-user and per-product quota overrides:
+// GET lists them, PUT sets one and DELETE ?scope=&subject_id= removes one.
+// Super admin only.
+func HandleAdminUsageQuotas(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, role, err := GetAdminSession(app, r)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		if role != "super_admin" {
+			WriteError(w, http.StatusForbidden, "仅超级管理员可管理用量配额")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			overrides, err := app.ListUsageQuotas()
+			if err != nil {
+				log.Printf("[Usage] list quotas error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "failed to list quotas")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]interface{}{"quotas": overrides})
+		case http.MethodPut:
+			var req usage.Override
+			if err := ReadJSONBody(r, &req); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			if len(req.SubjectID) > 100 {
+				WriteError(w, http.StatusBadRequest, "invalid subject_id")
+				return
+			}
+			if err := app.SetUsageQuota(req.Scope, req.SubjectID, req.Limits); err != nil {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{"message": tr(w, "配额已保存")})
+		case http.MethodDelete:
+			q := r.URL.Query()
+			if err := app.DeleteUsageQuota(q.Get("scope"), q.Get("subject_id")); err != nil {
+				if errors.Is(err, usage.ErrQuotaNotFound) {
+					WriteError(w, http.StatusNotFound, "配额不存在")
+					return
+				}
+				log.Printf("[Usage] delete quota error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "failed to delete quota")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{"message": tr(w, "配额已删除，恢复默认限制")})
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"askflow/internal/rbac"
+	"askflow/internal/usergroup"
+)
+
+// userGroupRequest is the body of creating or replacing a user group.
+type userGroupRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Emails      []string `json:"emails"`
+	Domains     []string `json:"domains"`
+	ProductIDs  []string `json:"product_ids"`
+	DocumentIDs []string `json:"document_ids"`
+}
+
+func (req *userGroupRequest) group() usergroup.Group {
+	return usergroup.Group{
+		Name:        req.Name,
+		Description: req.Description,
+		Emails:      req.Emails,
+		Domains:     req.Domains,
+		ProductIDs:  req.ProductIDs,
+		DocumentIDs: req.DocumentIDs,
+	}
+}
+
+// validIDs reports whether every ID of ids is a hex ID.
+func validIDs(ids []string) bool {
+	for _, id := range ids {
+		if !IsValidHexID(strings.TrimSpace(id)) {
+			return false
+		}
+	}
+	return true
+}
+
+// HandleAdminUserGroups manages the end-user groups that restrict products
+// and documents to their members:
+//
+//	GET  /api/admin/user-groups   all groups
+//	POST /api/admin/user-groups   add a group
+//
+// Groups apply to every product, so they need manage_docs for all of them.
+func HandleAdminUserGroups(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if _, _, err := RequireAdminPermission(app, r, rbac.PermManageDocs, ""); err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		if r.Method == http.MethodGet {
+			list, err := app.userGroupService.List()
+			if err != nil {
+				log.Printf("[UserGroups] list groups error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "failed to list user groups")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]interface{}{"groups": list})
+			return
+		}
+		var req userGroupRequest
+		if err := ReadJSONBody(r, &req); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if !validIDs(req.ProductIDs) || !validIDs(req.DocumentIDs) {
+			WriteError(w, http.StatusBadRequest, "invalid product or document ID")
+			return
+		}
+		group, err := app.userGroupService.Create(req.group())
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		WriteJSON(w, http.StatusCreated, group)
+	}
+}
+
+// HandleAdminUserGroupByID reads, replaces or removes a user group:
+//
+//	GET    /api/admin/user-groups/{id}   the group
+//	PUT    /api/admin/user-groups/{id}   replace it
+//	DELETE /api/admin/user-groups/{id}   remove it; what only it listed
+//	                                     becomes visible to everyone
+func HandleAdminUserGroupByID(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/api/admin/user-groups/")
+		if !IsValidHexID(id) {
+			WriteError(w, http.StatusBadRequest, "invalid group ID")
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if _, _, err := RequireAdminPermission(app, r, rbac.PermManageDocs, ""); err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			group, err := app.userGroupService.Get(id)
+			if errors.Is(err, usergroup.ErrNotFound) {
+				WriteError(w, http.StatusNotFound, "用户组不存在")
+				return
+			} else if err != nil {
+				log.Printf("[UserGroups] get group error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "failed to load user group")
+				return
+			}
+			WriteJSON(w, http.StatusOK, group)
+		case http.MethodDelete:
+			if err := app.userGroupService.Delete(id); err != nil {
+				if errors.Is(err, usergroup.ErrNotFound) {
+					WriteError(w, http.StatusNotFound, "用户组不存在")
+					return
+				}
+				log.Printf("[UserGroups] delete group error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "failed to delete user group")
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+		default:
+			var req userGroupRequest
+			if err := ReadJSONBody(r, &req); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			if !validIDs(req.ProductIDs) || !validIDs(req.DocumentIDs) {
+				WriteError(w, http.StatusBadRequest, "invalid product or document ID")
+				return
+			}
+			group := req.group()
+			group.ID = id
+			updated, err := app.userGroupService.Update(group)
+			if err != nil {
+				if errors.Is(err, usergroup.ErrNotFound) {
+					WriteError(w, http.StatusNotFound, "用户组不存在")
+					return
+				}
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			WriteJSON(w, http.StatusOK, updated)
+		}
+	}
+}
@@ -40,7 +40,7 @@ func verifySudoPassword(password string) error {
 
 // --- Video dependency check / auto-setup handlers ---
 
-// HandleVideoCheckDeps checks whether FFmpeg and RapidSpeech are available.
+// HandleVideoCheckDeps checks whether FFmpeg and the speech-to-text backend are available.
 func HandleVideoCheckDeps(app *App) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -81,7 +81,7 @@ func HandleValidateRapidSpeech(app *App) http.HandlerFunc {
 			RapidSpeechModel string `json:"rapidspeech_model"`
 		}
 		if err := ReadJSONBody(r, &req); err != nil {
-			WriteError(w, http.StatusBadRequest, "invalid request body")
+			writeBodyError(w, err)
 			return
 		}
 		vp := &video.Parser{
@@ -117,13 +117,20 @@ func HandleVideoAutoSetupCheck(app *App) http.HandlerFunc {
 			WriteJSON(w, http.StatusOK, map[string]interface{}{
 				"supported": false,
 				"is_root":   false,
-				"message":   "auto-setup is only supported on Linux",
+				"message":   tr(w, "auto-setup is only supported on Linux"),
 			})
 			return
 		}
+		pm, distro := detectPackageManager()
+		packageManager := ""
+		if pm != nil {
+			packageManager = pm.name
+		}
 		WriteJSON(w, http.StatusOK, map[string]interface{}{
-			"supported": true,
-			"is_root":   os.Getuid() == 0,
+			"supported":       true,
+			"is_root":         os.Getuid() == 0,
+			"distro":          distro,
+			"package_manager": packageManager,
 		})
 	}
 }
@@ -132,9 +139,14 @@ func HandleVideoAutoSetupCheck(app *App) http.HandlerFunc {
 // It streams progress via Server-Sent Events (SSE).
 // Steps: install system deps (git/gcc/cmake) → install ffmpeg → clone & build RapidSpeech → download model → configure paths.
 //
+// Packages are installed with the package manager detected from
+// /etc/os-release (apt-get, dnf, yum, pacman, apk or zypper). When FFmpeg
+// cannot be installed that way, a prebuilt static build is downloaded into
+// the install directory instead.
+//
 // When the service is NOT running as root, the request body may include a
 // "root_password" field. The handler will use "sudo -S" to inject the password
-// and elevate privileges for commands that require root (apt-get, etc.).
+// and elevate privileges for commands that require root (package installs).
 func HandleVideoAutoSetup(app *App) http.HandlerFunc {
 	var setupRunning int32 // atomic guard: 0 = idle, 1 = running
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -209,7 +221,7 @@ func HandleVideoAutoSetup(app *App) http.HandlerFunc {
 			}
 			data := map[string]interface{}{
 				"type":     eventType,
-				"message":  message,
+				"message":  tr(w, message),
 				"progress": progress,
 			}
 			jsonData, _ := json.Marshal(data)
@@ -300,40 +312,68 @@ func HandleVideoAutoSetup(app *App) http.HandlerFunc {
 			}
 		}
 
+		logf := func(line string) { sendSSE("log", line, -1) }
+		pm, distro := detectPackageManager()
+		if distro != "" {
+			logf(fmt.Sprintf("检测到系统: %s", distro))
+		}
+
 		// ── Step 1: Install system dependencies ──
 		sendSSE("step", "正在安装系统依赖 (git, gcc, g++, cmake, make)...", 5)
-		if err := runCmd(ctx, true, "apt-get", "update", "-y"); err != nil {
-			sendSSE("error", fmt.Sprintf("apt-get update 失败: %v", err), -1)
-			sendSSE("done", "安装失败", -1)
-			return
-		}
-		if err := runCmd(ctx, true, "apt-get", "install", "-y",
-			"git", "gcc", "g++", "cmake", "make", "wget", "curl",
-			"pkg-config", "libssl-dev"); err != nil {
-			sendSSE("error", fmt.Sprintf("安装系统依赖失败: %v", err), -1)
-			sendSSE("done", "安装失败", -1)
-			return
+		if pm != nil {
+			logf(fmt.Sprintf("使用包管理器: %s", pm.name))
+			if pm.refresh != nil {
+				if err := runCmd(ctx, true, pm.refresh[0], pm.refresh[1:]...); err != nil {
+					sendSSE("error", fmt.Sprintf("更新软件包索引失败 (%s): %v", pm.name, err), -1)
+					sendSSE("done", "安装失败", -1)
+					return
+				}
+			}
+			installArgs := append(append([]string{}, pm.install[1:]...), pm.buildDeps...)
+			if err := runCmd(ctx, true, pm.install[0], installArgs...); err != nil {
+				sendSSE("error", fmt.Sprintf("安装系统依赖失败: %v", err), -1)
+				sendSSE("done", "安装失败", -1)
+				return
+			}
+		} else {
+			// Without a package manager the build tools must already be installed.
+			if missing := missingTools(setupBuildTools); len(missing) > 0 {
+				sendSSE("error", fmt.Sprintf("未找到支持的包管理器，且缺少编译工具: %s", strings.Join(missing, ", ")), -1)
+				sendSSE("done", "安装失败", -1)
+				return
+			}
+			logf("未找到支持的包管理器，使用已安装的编译工具")
 		}
 		sendSSE("step", "系统依赖安装完成 ✓", 15)
 
 		// ── Step 2: Install FFmpeg ──
 		sendSSE("step", "正在安装 FFmpeg...", 20)
-		if err := runCmd(ctx, true, "apt-get", "install", "-y", "ffmpeg"); err != nil {
-			sendSSE("error", fmt.Sprintf("FFmpeg 安装失败: %v", err), -1)
-			sendSSE("done", "安装失败", -1)
-			return
+		ffmpegPath := ""
+		if pm != nil {
+			installArgs := append(append([]string{}, pm.install[1:]...), pm.ffmpeg...)
+			if err := runCmd(ctx, true, pm.install[0], installArgs...); err != nil {
+				logf(fmt.Sprintf("通过 %s 安装 FFmpeg 失败，改为下载静态编译版本...", pm.name))
+			} else if path, err := exec.LookPath("ffmpeg"); err == nil {
+				ffmpegPath = path
+			} else if _, err := os.Stat("/usr/bin/ffmpeg"); err == nil {
+				ffmpegPath = "/usr/bin/ffmpeg"
+			} else {
+				logf("FFmpeg 安装后未找到可执行文件，改为下载静态编译版本...")
+			}
+		} else if path, err := exec.LookPath("ffmpeg"); err == nil {
+			ffmpegPath = path
 		}
-		// Find ffmpeg path
-		ffmpegPath := "/usr/bin/ffmpeg"
-		if _, err := os.Stat(ffmpegPath); err != nil {
-			// Try which
-			out, err2 := exec.Command("which", "ffmpeg").Output()
-			if err2 != nil {
-				sendSSE("error", "FFmpeg 安装后未找到可执行文件", -1)
+		if ffmpegPath == "" {
+			runPlain := func(ctx context.Context, name string, args ...string) error {
+				return runCmd(ctx, false, name, args...)
+			}
+			path, err := installStaticFFmpeg(ctx, filepath.Join(installBase, "ffmpeg-static"), runPlain, logf)
+			if err != nil {
+				sendSSE("error", fmt.Sprintf("FFmpeg 安装失败: %v", err), -1)
 				sendSSE("done", "安装失败", -1)
 				return
 			}
-			ffmpegPath = strings.TrimSpace(string(out))
+			ffmpegPath = path
 		}
 		sendSSE("step", fmt.Sprintf("FFmpeg 安装完成 ✓ (%s)", ffmpegPath), 30)
 
@@ -423,6 +463,13 @@ func HandleVideoAutoSetup(app *App) http.HandlerFunc {
 			return
 		}
 		modelFile := filepath.Join(modelSubDir, "sense-voice-small-q5_k.gguf")
+		// wget is missing on hosts set up without a package manager.
+		fetch := func(url, dest string) error {
+			if _, err := exec.LookPath("wget"); err != nil {
+				return downloadFile(ctx, url, dest, logf)
+			}
+			return runCmd(ctx, false, "wget", "--progress=dot:mega", "-O", dest, url)
+		}
 
 		if _, err := os.Stat(modelFile); err == nil {
 			sendSSE("log", "模型文件已存在，跳过下载", -1)
@@ -435,7 +482,7 @@ func HandleVideoAutoSetup(app *App) http.HandlerFunc {
 				modelURL = "https://huggingface.co/RapidAI/RapidSpeech/resolve/main/ASR/SenseVoice/sense-voice-small-q5_k.gguf"
 				sendSSE("log", "使用 Hugging Face 下载模型...", -1)
 			}
-			if err := runCmd(ctx, false, "wget", "--progress=dot:mega", "-O", modelFile, modelURL); err != nil {
+			if err := fetch(modelURL, modelFile); err != nil {
 				// Fallback to the other source
 				if isChinaRegion {
 					sendSSE("log", "ModelScope 下载失败，尝试 Hugging Face...", -1)
@@ -445,7 +492,7 @@ func HandleVideoAutoSetup(app *App) http.HandlerFunc {
 					modelURL = "https://www.modelscope.cn/models/RapidAI/RapidSpeech/resolve/master/ASR/SenseVoice/sense-voice-small-q5_k.gguf"
 				}
 				os.Remove(modelFile) // remove partial download
-				if err := runCmd(ctx, false, "wget", "--progress=dot:mega", "-O", modelFile, modelURL); err != nil {
+				if err := fetch(modelURL, modelFile); err != nil {
 					sendSSE("error", fmt.Sprintf("模型下载失败: %v", err), -1)
 					sendSSE("done", "安装失败", -1)
 					return
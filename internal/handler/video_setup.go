@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// setupPackageManager describes how the video auto-setup installs packages
+// with one Linux package manager.
+type setupPackageManager struct {
+	name      string   // display name, also the binary looked up on PATH
+	families  []string // /etc/os-release ID / ID_LIKE values it serves
+	refresh   []string // refreshes the package index, nil when install does it
+	install   []string // install command, package names are appended
+	buildDeps []string // toolchain for building RapidSpeech.cpp
+	ffmpeg    []string
+}
+
+// setupPackageManagers lists the supported package managers in detection
+// order. dnf comes before yum so RHEL 8+ derivatives, which ship both, use dnf.
+// FFmpeg is not in the base repositories of RHEL-like distributions; the
+// install fails there unless EPEL/RPM Fusion is enabled, and the static build
+// is downloaded instead.
+var setupPackageManagers = []setupPackageManager{
+	{
+		name:      "apt-get",
+		families:  []string{"debian", "ubuntu"},
+		refresh:   []string{"apt-get", "update", "-y"},
+		install:   []string{"apt-get", "install", "-y"},
+		buildDeps: []string{"git", "gcc", "g++", "cmake", "make", "wget", "curl", "pkg-config", "libssl-dev"},
+		ffmpeg:    []string{"ffmpeg"},
+	},
+	{
+		name:      "dnf",
+		families:  []string{"fedora", "rhel", "centos", "rocky", "almalinux", "ol", "amzn"},
+		install:   []string{"dnf", "install", "-y"},
+		buildDeps: []string{"git", "gcc", "gcc-c++", "cmake", "make", "wget", "curl", "pkgconf-pkg-config", "openssl-devel"},
+		ffmpeg:    []string{"ffmpeg"},
+	},
+	{
+		name:      "yum",
+		families:  []string{"fedora", "rhel", "centos", "amzn"},
+		install:   []string{"yum", "install", "-y"},
+		buildDeps: []string{"git", "gcc", "gcc-c++", "cmake", "make", "wget", "curl", "pkgconfig", "openssl-devel"},
+		ffmpeg:    []string{"ffmpeg"},
+	},
+	{
+		name:      "pacman",
+		families:  []string{"arch", "manjaro"},
+		refresh:   []string{"pacman", "-Sy", "--noconfirm"},
+		install:   []string{"pacman", "-S", "--noconfirm", "--needed"},
+		buildDeps: []string{"git", "gcc", "cmake", "make", "wget", "curl", "pkgconf", "openssl"},
+		ffmpeg:    []string{"ffmpeg"},
+	},
+	{
+		name:      "apk",
+		families:  []string{"alpine"},
+		refresh:   []string{"apk", "update"},
+		install:   []string{"apk", "add", "--no-cache"},
+		buildDeps: []string{"git", "gcc", "g++", "musl-dev", "linux-headers", "cmake", "make", "wget", "curl", "pkgconf", "openssl-dev"},
+		ffmpeg:    []string{"ffmpeg"},
+	},
+	{
+		name:      "zypper",
+		families:  []string{"suse", "opensuse", "sles"},
+		refresh:   []string{"zypper", "--non-interactive", "refresh"},
+		install:   []string{"zypper", "--non-interactive", "install"},
+		buildDeps: []string{"git", "gcc", "gcc-c++", "cmake", "make", "wget", "curl", "pkg-config", "libopenssl-devel"},
+		ffmpeg:    []string{"ffmpeg"},
+	},
+}
+
+// setupBuildTools are the commands the RapidSpeech.cpp build needs when no
+// package manager is available to install them.
+var setupBuildTools = []string{"git", "cmake", "make", "c++"}
+
+// staticFFmpegURLs are prebuilt static FFmpeg builds by architecture.
+var staticFFmpegURLs = map[string]string{
+	"amd64": "https://johnvansickle.com/ffmpeg/releases/ffmpeg-release-amd64-static.tar.xz",
+	"arm64": "https://johnvansickle.com/ffmpeg/releases/ffmpeg-release-arm64-static.tar.xz",
+}
+
+// osRelease returns the pretty name of the Linux distribution and its ID
+// followed by the ID_LIKE values, read from /etc/os-release.
+func osRelease() (string, []string) {
+	f, err := os.Open("/etc/os-release")
+	if err != nil {
+		return "", nil
+	}
+	defer f.Close()
+	var pretty string
+	var ids []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "PRETTY_NAME":
+			pretty = value
+		case "ID":
+			ids = append([]string{value}, ids...)
+		case "ID_LIKE":
+			ids = append(ids, strings.Fields(value)...)
+		}
+	}
+	return pretty, ids
+}
+
+// detectPackageManager picks the package manager of the host: the first one
+// on PATH that serves the distribution named in /etc/os-release, otherwise
+// the first one on PATH at all. It returns nil when none is found.
+func detectPackageManager() (*setupPackageManager, string) {
+	distro, ids := osRelease()
+	for _, id := range ids {
+		for i := range setupPackageManagers {
+			pm := &setupPackageManagers[i]
+			if !slices.Contains(pm.families, id) {
+				continue
+			}
+			if _, err := exec.LookPath(pm.name); err == nil {
+				return pm, distro
+			}
+		}
+	}
+	for i := range setupPackageManagers {
+		if _, err := exec.LookPath(setupPackageManagers[i].name); err == nil {
+			return &setupPackageManagers[i], distro
+		}
+	}
+	return nil, distro
+}
+
+// missingTools returns the commands of names that are not on PATH.
+func missingTools(names []string) []string {
+	var missing []string
+	for _, name := range names {
+		if _, err := exec.LookPath(name); err != nil {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// downloadFile saves url to dest, logging progress about every 10%. A partial
+// file is removed on failure.
+func downloadFile(ctx context.Context, url, dest string, logf func(string)) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	pw := &progressWriter{total: resp.ContentLength, logf: logf}
+	_, err = io.Copy(f, io.TeeReader(resp.Body, pw))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return err
+	}
+	return nil
+}
+
+// progressWriter counts downloaded bytes and logs each further tenth of the
+// total, or every 20 MB when the size is unknown.
+type progressWriter struct {
+	total  int64
+	done   int64
+	logged int64
+	logf   func(string)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	step := int64(20 << 20)
+	if p.total > 0 {
+		step = max(p.total/10, 1)
+	}
+	if p.done-p.logged >= step {
+		p.logged = p.done
+		if p.total > 0 {
+			p.logf(fmt.Sprintf("已下载 %d MB / %d MB", p.done>>20, p.total>>20))
+		} else {
+			p.logf(fmt.Sprintf("已下载 %d MB", p.done>>20))
+		}
+	}
+	return len(b), nil
+}
+
+// installStaticFFmpeg downloads the static FFmpeg build for the host
+// architecture into dir and returns the path of its ffmpeg executable.
+func installStaticFFmpeg(ctx context.Context, dir string, run func(ctx context.Context, name string, args ...string) error, logf func(string)) (string, error) {
+	url, ok := staticFFmpegURLs[runtime.GOARCH]
+	if !ok {
+		return "", fmt.Errorf("没有适用于 %s 架构的 FFmpeg 静态版本", runtime.GOARCH)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	archive := filepath.Join(dir, "ffmpeg-static.tar.xz")
+	logf("下载 " + url)
+	if err := downloadFile(ctx, url, archive, logf); err != nil {
+		return "", fmt.Errorf("下载失败: %w", err)
+	}
+	defer os.Remove(archive)
+	// The archive holds a single versioned directory, e.g. ffmpeg-7.0.2-amd64-static/;
+	// builds left by earlier runs are removed so the new one is found.
+	pattern := filepath.Join(dir, "ffmpeg-*-static")
+	old, _ := filepath.Glob(pattern)
+	for _, d := range old {
+		os.RemoveAll(d)
+	}
+	if err := run(ctx, "tar", "-xJf", archive, "-C", dir); err != nil {
+		return "", fmt.Errorf("解压失败: %w", err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(pattern, "ffmpeg"))
+	if len(matches) == 0 {
+		return "", fmt.Errorf("解压后未找到 ffmpeg 可执行文件")
+	}
+	path := matches[0]
+	os.Chmod(path, 0755)
+	return path, nil
+}
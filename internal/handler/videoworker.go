@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"askflow/internal/videoworker"
+)
+
+// videoWorkerAuthorized checks the bearer token of a request from a remote
+// video worker against video.workers.token and returns the worker's name,
+// writing the error response if it does not match. Without a token
+// configured workers are refused.
+func videoWorkerAuthorized(app *App, w http.ResponseWriter, r *http.Request) (string, bool) {
+	want := app.configManager.Get().Video.Workers.Token
+	if want == "" {
+		WriteError(w, http.StatusForbidden, "未启用视频处理节点")
+		return "", false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !hmac.Equal([]byte(token), []byte(want)) {
+		WriteError(w, http.StatusUnauthorized, "视频处理节点令牌无效")
+		return "", false
+	}
+	name := strings.TrimSpace(r.Header.Get(videoworker.WorkerHeader))
+	if name == "" || len(name) > 128 {
+		WriteError(w, http.StatusBadRequest, "invalid worker name")
+		return "", false
+	}
+	return name, true
+}
+
+// HandleVideoWorkerClaim handles POST /api/video-worker/claim: the oldest
+// queued video job, handed to the calling worker, or 204 when there is none.
+func HandleVideoWorkerClaim(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		worker, ok := videoWorkerAuthorized(app, w, r)
+		if !ok {
+			return
+		}
+		job, err := app.docManager.ClaimVideoJob(worker)
+		if err != nil {
+			log.Printf("[VideoWorker] claim by %s failed: %v", worker, err)
+			WriteError(w, http.StatusInternalServerError, "领取视频处理任务失败")
+			return
+		}
+		if job == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		log.Printf("[VideoWorker] job %s (doc=%s) claimed by %s", job.ID, job.DocumentID, worker)
+		WriteJSON(w, http.StatusOK, job)
+	}
+}
+
+// HandleVideoWorkerJob handles the requests of a worker about a job it
+// holds, /api/video-worker/jobs/{id}/{action}:
+//   - GET video: the video file
+//   - POST progress: a progress report, which keeps the claim alive
+//   - POST result: the transcript and keyframes (multipart)
+//   - POST fail: the error the video could not be parsed with
+//
+// Requests for a job that was canceled or handed to another worker get 409.
+func HandleVideoWorkerJob(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		worker, ok := videoWorkerAuthorized(app, w, r)
+		if !ok {
+			return
+		}
+		id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/video-worker/jobs/"), "/")
+		if !IsValidHexID(id) {
+			WriteError(w, http.StatusBadRequest, "invalid job ID")
+			return
+		}
+		method := http.MethodPost
+		if action == "video" {
+			method = http.MethodGet
+		}
+		if r.Method != method {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var err error
+		switch action {
+		case "video":
+			extendUploadDeadlines(w)
+			orig, oErr := app.docManager.VideoJobOriginal(id, worker)
+			if oErr == nil {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				orig.Serve(w, r)
+				return
+			}
+			err = oErr
+		case "progress":
+			var p videoworker.Progress
+			if err := ReadJSONBody(r, &p); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			err = app.docManager.ReportVideoJob(id, worker, p)
+		case "result":
+			extendUploadDeadlines(w)
+			r.Body = http.MaxBytesReader(w, r.Body, videoworker.MaxResultSize)
+			mr, mErr := r.MultipartReader()
+			if mErr != nil {
+				WriteError(w, http.StatusBadRequest, "invalid request body")
+				return
+			}
+			res, rErr := videoworker.ReadResult(mr)
+			if rErr != nil {
+				log.Printf("[VideoWorker] invalid result for job %s from %s: %v", id, worker, rErr)
+				if writeBodyTooLarge(w, rErr) {
+					return
+				}
+				WriteError(w, http.StatusBadRequest, "视频处理结果无效")
+				return
+			}
+			err = app.docManager.CompleteVideoJob(id, worker, res)
+			if err == nil {
+				log.Printf("[VideoWorker] job %s done by %s: %d transcript segments, %d keyframes", id, worker, len(res.Transcript), len(res.Keyframes))
+			}
+		case "fail":
+			var f videoworker.Failure
+			if err := ReadJSONBody(r, &f); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			msg := strings.TrimSpace(f.Error)
+			if len([]rune(msg)) > 1000 {
+				msg = string([]rune(msg)[:1000])
+			}
+			err = app.docManager.FailVideoJob(id, worker, msg)
+			if err == nil {
+				log.Printf("[VideoWorker] job %s failed on %s: %s", id, worker, msg)
+			}
+		default:
+			WriteError(w, http.StatusNotFound, "not found")
+			return
+		}
+		if errors.Is(err, videoworker.ErrJobLost) {
+			WriteError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			log.Printf("[VideoWorker] %s of job %s from %s failed: %v", action, id, worker, err)
+			WriteError(w, http.StatusInternalServerError, "视频处理任务更新失败")
+			return
+		}
+		WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"askflow/internal/webhook"
+)
+
+// webhookRequest is the request body for creating or updating a webhook.
+type webhookRequest struct {
+	URL     string   `json:"url"`
+	Secret  string   `json:"secret"`
+	Events  []string `json:"events"`
+	Enabled *bool    `json:"enabled"`
+}
+
+// HandleAdminWebhooks handles GET (list) and POST (create) for webhooks.
+// Only super admins may manage webhooks.
+func HandleAdminWebhooks(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, role, err := GetAdminSession(app, r)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		if role != "super_admin" {
+			WriteError(w, http.StatusForbidden, "仅超级管理员可管理 Webhook")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			hooks, err := app.ListWebhooks()
+			if err != nil {
+				log.Printf("[Webhook] list error: %v", err)
+				WriteError(w, http.StatusInternalServerError, "获取 Webhook 列表失败")
+				return
+			}
+			if hooks == nil {
+				hooks = []webhook.Webhook{}
+			}
+			WriteJSON(w, http.StatusOK, map[string]interface{}{
+				"webhooks": hooks,
+				"events":   webhook.EventTypes,
+			})
+
+		case http.MethodPost:
+			var req webhookRequest
+			if err := ReadJSONBody(r, &req); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			hook, err := app.CreateWebhook(strings.TrimSpace(req.URL), req.Secret, req.Events)
+			if err != nil {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			WriteJSON(w, http.StatusOK, hook)
+
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
+
+// HandleAdminWebhookByID handles PUT (update) and DELETE for a webhook, and
+// POST /api/admin/webhooks/{id}/test to send a signed ping event.
+func HandleAdminWebhookByID(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, role, err := GetAdminSession(app, r)
+		if err != nil {
+			WriteAdminSessionError(w, err)
+			return
+		}
+		if role != "super_admin" {
+			WriteError(w, http.StatusForbidden, "仅超级管理员可管理 Webhook")
+			return
+		}
+
+		id := strings.TrimPrefix(r.URL.Path, "/api/admin/webhooks/")
+		isTest := strings.HasSuffix(id, "/test")
+		id = strings.TrimSuffix(id, "/test")
+		if !IsValidHexID(id) {
+			WriteError(w, http.StatusBadRequest, "invalid webhook ID")
+			return
+		}
+
+		if isTest {
+			if r.Method != http.MethodPost {
+				WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			status, err := app.TestWebhook(id)
+			if err != nil {
+				WriteJSON(w, http.StatusOK, map[string]interface{}{"success": false, "status": status, "error": err.Error()})
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]interface{}{"success": true, "status": status})
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPut:
+			var req webhookRequest
+			if err := ReadJSONBody(r, &req); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			enabled := true
+			if req.Enabled != nil {
+				enabled = *req.Enabled
+			}
+			if err := app.UpdateWebhook(id, strings.TrimSpace(req.URL), req.Events, enabled, req.Secret); err != nil {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+
+		case http.MethodDelete:
+			if err := app.DeleteWebhook(id); err != nil {
+				WriteError(w, http.StatusNotFound, err.Error())
+				return
+			}
+			WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+
+		default:
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
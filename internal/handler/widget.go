@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"askflow/internal/errlog"
+	"askflow/internal/query"
+)
+
+// widgetProductID extracts and validates the product_id query parameter used by
+// all widget endpoints. The widget is always scoped to a single product.
+func widgetProductID(r *http.Request) (string, bool) {
+	productID := r.URL.Query().Get("product_id")
+	return productID, IsValidHexID(productID)
+}
+
+// checkWidgetOrigin verifies that the request Origin is allowlisted for the product.
+// Writes a 403 response and returns false if it is not.
+func checkWidgetOrigin(app *App, w http.ResponseWriter, r *http.Request, productID string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || !app.IsWidgetOriginAllowed(productID, origin) {
+		WriteError(w, http.StatusForbidden, "该站点未被授权嵌入客服组件")
+		return false
+	}
+	return true
+}
+
+// ServeWidgetScript serves the embeddable chat widget bundle (widget.js) from dir.
+// The script is loaded by third-party pages via <script src>, so it is served
+// with a cross-origin resource policy and moderate caching.
+func ServeWidgetScript(dir string) http.HandlerFunc {
+	scriptPath := filepath.Join(dir, "widget.js")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+		w.Header().Set("Cross-Origin-Resource-Policy", "cross-origin")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		http.ServeFile(w, r, scriptPath)
+	}
+}
+
+// HandleWidgetSession issues an anonymous visitor token for the embeddable widget.
+// The request must come from an Origin allowlisted for the product.
+func HandleWidgetSession(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		productID, ok := widgetProductID(r)
+		if !ok {
+			WriteError(w, http.StatusBadRequest, "invalid product_id")
+			return
+		}
+		if !checkWidgetOrigin(app, w, r, productID) {
+			return
+		}
+		resp, err := app.CreateWidgetVisitor(productID)
+		if err != nil {
+			log.Printf("[Widget] create visitor error: %v", err)
+			WriteError(w, http.StatusInternalServerError, "创建访客会话失败")
+			return
+		}
+		WriteJSON(w, http.StatusOK, resp)
+	}
+}
+
+// HandleWidgetQuery answers a question asked through the embeddable widget.
+// It only accepts widget visitor tokens and always queries the product the
+// widget was embedded for, regardless of the product_id in the body.
+func HandleWidgetQuery(app *App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		productID, ok := widgetProductID(r)
+		if !ok {
+			WriteError(w, http.StatusBadRequest, "invalid product_id")
+			return
+		}
+		if !checkWidgetOrigin(app, w, r, productID) {
+			return
+		}
+		visitorID, err := GetUserSession(app, r)
+		if err != nil {
+			WriteError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if !strings.HasPrefix(visitorID, "widget_") {
+			WriteError(w, http.StatusForbidden, "无效的访客令牌")
+			return
+		}
+		var req query.QueryRequest
+		if err := ReadJSONBody(r, &req); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		question := strings.TrimSpace(req.Question)
+		if question == "" {
+			WriteError(w, http.StatusBadRequest, "question is required")
+			return
+		}
+		if len(question) > 2000 {
+			WriteError(w, http.StatusBadRequest, "question too long (max 2000 characters)")
+			return
+		}
+		req.Question = question
+		req.UserID = visitorID
+		req.ProductID = productID
+		// The widget is served from the customer's site, so the workspace
+		// comes from the product rather than from the request
+		tenantID, _ := app.tenantService.ProductTenant(productID)
+		if !app.scopeQuery(w, tenantID, &req) {
+			return
+		}
+		resp, err := app.MeteredQuery(r.Context(), req)
+		if writeImageError(w, err) || writeUsageQuotaError(w, err) || writeEmbeddingBusy(w, err) || writeQueryBusy(app, w, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("[Widget] query error: %v", err)
+			errlog.Logf("[Widget] query processing failed product=%s: %v", productID, err)
+			WriteError(w, http.StatusInternalServerError, "查询处理失败，请稍后重试")
+			return
+		}
+		// Widget visitors never see debug info or download links
+		resp.DebugInfo = nil
+		resp.AllowDownload = false
+		WriteJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,106 @@
+package i18n
+
+import (
+	"strings"
+	"unicode"
+)
+
+// functionWords are frequent short words that tell Latin-script languages
+// apart. Only the language with the most hits counts.
+var functionWords = map[string][]string{
+	"en": {"the", "is", "are", "was", "how", "what", "why", "where", "when", "which", "who", "can", "could", "do", "does", "did", "i", "you", "my", "your", "to", "of", "and", "in", "for", "it", "with", "this", "that", "not", "have", "has", "a", "an", "please", "on", "from", "there", "hello", "hi", "thanks"},
+	"fr": {"le", "les", "est", "comment", "pourquoi", "je", "vous", "et", "des", "une", "pas", "ne", "quoi", "où", "avec", "pour", "mon", "ma", "du", "au", "bonjour", "merci", "est-ce"},
+	"de": {"der", "die", "das", "ist", "wie", "warum", "ich", "sie", "und", "nicht", "ein", "eine", "mit", "für", "kann", "was", "wo", "den", "dem", "mein", "hallo", "danke"},
+	"es": {"el", "los", "las", "es", "cómo", "qué", "por", "para", "yo", "usted", "y", "un", "una", "no", "con", "mi", "dónde", "hola", "gracias", "puedo", "del"},
+	"pt": {"o", "os", "as", "é", "como", "por", "para", "eu", "você", "um", "uma", "não", "com", "meu", "onde", "olá", "obrigado", "posso", "do", "da"},
+	"it": {"il", "lo", "gli", "è", "come", "perché", "io", "di", "non", "con", "mio", "dove", "ciao", "grazie", "posso", "che", "della"},
+}
+
+// Detect guesses the language of a short text such as a user question from
+// its script and, for Latin script, common function words. It returns ""
+// when it cannot tell. The result is a primary language subtag ("zh", "en",
+// "ja", ...).
+func Detect(text string) string {
+	var han, kana, hangul, cyrillic, arabic, thai, latin, latinWords, nonASCII int
+	inWord := false
+	for _, r := range text {
+		if unicode.Is(unicode.Latin, r) {
+			if !inWord {
+				latinWords++
+			}
+			inWord = true
+		} else {
+			inWord = false
+		}
+		switch {
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+		case unicode.Is(unicode.Hangul, r):
+			hangul++
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		case unicode.Is(unicode.Arabic, r):
+			arabic++
+		case unicode.Is(unicode.Thai, r):
+			thai++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+			if r > unicode.MaxASCII {
+				nonASCII++
+			}
+		}
+	}
+	// Compare CJK characters with Latin words rather than letters, so
+	// questions mixing Chinese with product names, commands or error
+	// messages stay Chinese unless they are mostly Latin words
+	switch {
+	case kana > 0 && han+kana >= latinWords:
+		return "ja"
+	case hangul > 0 && hangul >= latinWords:
+		return "ko"
+	case han > 0 && han*2 >= latinWords:
+		return "zh"
+	case cyrillic > latin:
+		return "ru"
+	case arabic > latin:
+		return "ar"
+	case thai > latin:
+		return "th"
+	case latin == 0:
+		return ""
+	}
+
+	scores := map[string]int{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '-'
+	}) {
+		for lang, words := range functionWords {
+			for _, fw := range words {
+				if w == fw {
+					scores[lang]++
+					break
+				}
+			}
+		}
+	}
+	best, bestScore, tie := "", 0, false
+	for _, lang := range []string{"en", "fr", "de", "es", "pt", "it"} {
+		switch s := scores[lang]; {
+		case s > bestScore:
+			best, bestScore, tie = lang, s, false
+		case s == bestScore && s > 0:
+			tie = true
+		}
+	}
+	if bestScore > 0 && !tie {
+		return best
+	}
+	// Keywords only ("download link", "install error"): plain ASCII is most
+	// likely English, accented letters are not
+	if bestScore == 0 && nonASCII == 0 {
+		return "en"
+	}
+	return ""
+}
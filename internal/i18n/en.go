@@ -0,0 +1,493 @@
+package i18n
+
+func init() {
+	Register("en", en)
+}
+
+// en translates the Chinese API messages to English.
+var en = map[string]string{
+	// Sessions and permissions
+	"未登录":      "Not signed in",
+	"会话已过期":    "Session expired",
+	"会话无效":     "Invalid session",
+	"无权限":      "Permission denied",
+	"无权限执行此操作": "You do not have permission to perform this action",
+	"无权访问该工作区": "You do not have access to this workspace",
+	"此为参观模式，一切更改都不会生效": "This is a demo account; changes will not be saved",
+	"未登录或图片链接已过期":      "Not signed in or the image link has expired",
+	"无权访问该图片":          "You do not have access to this image",
+	"CSRF 校验失败":        "CSRF check failed",
+	"请求过于频繁，请稍后再试":     "Too many requests, please try again later",
+	"工作区已停用":           "This workspace has been disabled",
+	"您所在的网络已被禁止访问":     "Access from your network has been blocked",
+	"您所在的地区暂不提供服务":     "The service is not available in your region",
+
+	// Sign-in, registration and account
+	"验证码错误":                 "Incorrect captcha",
+	"人机验证失败，请重试":            "Human verification failed, please try again",
+	"人机验证服务暂不可用，请稍后再试":      "Human verification is temporarily unavailable, please try again later",
+	"注册成功，请查收验证邮件":          "Registration successful, please check your email to verify your account",
+	"获取用户偏好失败":              "Failed to load preferences",
+	"保存用户偏好失败":              "Failed to save preferences",
+	"不支持的语言":                "Unsupported language",
+	"邮箱验证成功，请登录":            "Email verified, please sign in",
+	"如果该邮箱已注册，重置链接将发送到您的邮箱": "If this email is registered, a reset link has been sent to it",
+	"密码重置成功，请登录":            "Password reset, please sign in",
+	"登录凭证无效或已过期":            "Sign-in credentials are invalid or have expired",
+	"该邮箱已被其他账号使用":           "This email is already used by another account",
+	"管理员账号已设置":              "The admin account has already been set up",
+	"用户名和密码不能为空":            "Username and password are required",
+	"用户名至少3位":               "Username must be at least 3 characters",
+	"用户名不能超过64位":            "Username must be at most 64 characters",
+	"用户名包含非法字符":             "Username contains invalid characters",
+	"系统配置未加载":               "System configuration is not loaded",
+	"用户名或密码错误":              "Incorrect username or password",
+	"账号已被停用，请联系超级管理员":       "This account has been disabled, please contact a super admin",
+	"匿名模式未开启":               "Anonymous mode is not enabled",
+	"前端匿名模式未开启":             "Anonymous access is not enabled",
+	"邮箱和密码不能为空":             "Email and password are required",
+	"邮箱格式不正确":               "Invalid email address",
+	"名称过长":                  "Name is too long",
+	"该邮箱已注册":                "This email is already registered",
+	"无效的验证链接":               "Invalid verification link",
+	"验证链接无效或已过期":            "The verification link is invalid or has expired",
+	"验证链接已过期，请重新注册":         "The verification link has expired, please register again",
+	"请输入邮箱地址":               "Please enter your email address",
+	"无效的重置链接":               "Invalid reset link",
+	"重置链接无效或已过期，请重新申请":      "The reset link is invalid or has expired, please request a new one",
+	"管理员账号请在管理后台修改密码":       "Admin accounts change their password in the admin console",
+	"当前密码错误":                "Current password is incorrect",
+	"管理员账号不能在此删除":           "Admin accounts cannot be deleted here",
+	"邮箱或密码错误":               "Incorrect email or password",
+	"邮箱未验证，请先查收验证邮件":        "Email not verified, please check your inbox for the verification email",
+	"密码错误":                  "Incorrect password",
+	"密码至少8位":                "Password must be at least 8 characters",
+	"密码不能超过72位":             "Password must be at most 72 characters",
+	"密码必须包含字母和数字":           "Password must contain letters and digits",
+	"解锁链接无效或已过期":            "The unlock link is invalid or has expired",
+	"账号已解锁，请登录":             "Your account has been unlocked, please sign in",
+	"该IP已被锁定，剩余不到1天":        "This IP is locked for less than 1 more day",
+	"该IP已被锁定，剩余%d天":         "This IP is locked for %d more days",
+	"今日密码错误次数过多，当天禁止登录":     "Too many wrong passwords today, sign-in is blocked for the rest of the day",
+	"连续密码错误过多，请稍后再试":        "Too many wrong passwords, please try again later",
+	"连续密码错误过多，请%d分钟后再试":     "Too many wrong passwords, please try again in %d minutes",
+	"连续%d次密码错误，锁定1小时":       "%d wrong passwords in a row, locked for 1 hour",
+	"今日%d次密码错误，当天禁止登录":      "%d wrong passwords today, blocked for the rest of the day",
+	"IP连续%d次密码错误，锁定10天":     "%d wrong passwords in a row from this IP, locked for 10 days",
+	"当前账号没有可导出的个人数据":        "This account has no personal data to export",
+	"导出数据失败":                "Failed to export data",
+	"查询用户失败: %w":            "Failed to look up user: %w",
+	"创建用户失败: %w":            "Failed to create user: %w",
+	"创建验证令牌失败: %w":          "Failed to create verification token: %w",
+	"查询验证令牌失败: %w":          "Failed to look up verification token: %w",
+	"验证失败: %w":              "Verification failed: %w",
+	"密码加密失败: %w":            "Failed to hash password: %w",
+	"更新密码失败: %w":            "Failed to update password: %w",
+	"删除账号失败: %w":            "Failed to delete account: %w",
+	"匿名访客":                  "Anonymous visitor",
+	"匿名用户":                  "Anonymous user",
+	"管理员":                   "Admin",
+	"访客":                    "Visitor",
+
+	// Admin accounts, roles and lockouts
+	"仅超级管理员可管理用户":                "Only super admins can manage users",
+	"获取用户列表失败":                   "Failed to list users",
+	"分配产品失败":                     "Failed to assign products",
+	"用户不存在":                      "User not found",
+	"请指定角色或停用状态":                 "Please specify a role or disabled state",
+	"删除用户失败":                     "Failed to delete user",
+	"无效的邀请链接":                    "Invalid invitation link",
+	"密码设置成功，请登录":                 "Password set, please sign in",
+	"获取产品角色失败":                   "Failed to load product roles",
+	"仅超级管理员可管理角色":                "Only super admins can manage roles",
+	"获取角色列表失败":                   "Failed to list roles",
+	"角色不存在":                      "Role not found",
+	"更新角色失败: %w":                 "Failed to update role: %w",
+	"请输入收件人邮箱":                   "Please enter a recipient email",
+	"用户名已存在":                     "Username already exists",
+	"用户名和邮箱不能为空":                 "Username and email are required",
+	"该用户已接受邀请":                   "This user has already accepted the invitation",
+	"该用户没有邮箱":                    "This user has no email",
+	"邀请链接无效或已过期":                 "The invitation link is invalid or has expired",
+	"设置密码失败: %w":                 "Failed to set password: %w",
+	"更新用户状态失败: %w":               "Failed to update user status: %w",
+	"仅超级管理员可管理登录限制":              "Only super admins can manage sign-in restrictions",
+	"请输入用户名或IP":                  "Please enter a username or IP",
+	"请输入用户名":                     "Please enter a username",
+	"该账号当前未被锁定":                  "This account is not locked",
+	"该账号没有可接收解锁邮件的邮箱":            "This account has no email to send an unlock link to",
+	"未配置 ASN 数据库或仍在加载中":          "The ASN database is not configured or still loading",
+	"网段范围过大":                     "The network range is too large",
+	"请输入有效的IP或CIDR网段":            "Please enter a valid IP or CIDR range",
+	"不能封禁当前所在的网络":                "You cannot block the network you are connecting from",
+	"管理员手动封禁":                    "Blocked manually by an admin",
+	"封禁记录不存在":                    "Ban not found",
+	"仅超级管理员可查看审计日志":              "Only super admins can view the audit log",
+	"获取审计日志失败":                   "Failed to load the audit log",
+	"仅超级管理员可删除用户数据":              "Only super admins can delete user data",
+	"删除用户数据失败":                   "Failed to delete user data",
+	"仅超级管理员可修改管理员账户设置":           "Only super admins can change admin account settings",
+	"仅超级管理员可管理 Webhook":          "Only super admins can manage webhooks",
+	"获取 Webhook 列表失败":            "Failed to list webhooks",
+	"仅超级管理员可管理备份":                "Only super admins can manage backups",
+	"仅超级管理员可查看向量化队列":             "Only super admins can view the embedding queue",
+	"仅超级管理员可查看问答队列":              "Only super admins can view the query queue",
+	"备份模式必须为 full 或 incremental": "Backup mode must be full or incremental",
+	"已有备份正在进行":                   "A backup is already running",
+	"启动备份失败":                     "Failed to start the backup",
+	"仅超级管理员可执行数据库维护":             "Only super admins can run database maintenance",
+	"已有数据库维护正在进行":                "Database maintenance is already running",
+	"启动数据库维护失败":                  "Failed to start database maintenance",
+	"未启用只读副本":                    "Read-only replicas are not enabled",
+	"副本令牌无效":                     "Invalid replica token",
+	"未启用视频处理节点":                  "Remote video workers are not enabled",
+	"视频处理节点令牌无效":                 "Invalid video worker token",
+	"领取视频处理任务失败":                 "Failed to claim a video job",
+	"视频处理结果无效":                   "Invalid video job result",
+	"视频处理任务更新失败":                 "Failed to update the video job",
+	"任务已取消或已交给其他处理节点":            "The job was canceled or handed to another worker",
+	"视频处理节点 %s: %s":              "Video worker %s: %s",
+	"视频处理节点 %s 失去响应":             "Video worker %s stopped responding",
+	"视频处理任务已取消":                  "The video job was canceled",
+	"主实例暂时无法访问，请稍后重试":            "The primary instance is unreachable, please try again later",
+	"创建待处理问题失败":                  "Failed to create the pending question",
+	"记录用量失败":                     "Failed to record usage",
+	"保存回答失败":                     "Failed to save the answer",
+	"该任务正在其他实例上运行":               "The job is running on another instance",
+	"仅超级管理员可查看任务锁":               "Only super admins can view job locks",
+	"获取任务锁失败":                    "Failed to list job locks",
+	"仅超级管理员可管理后台任务":              "Only super admins can manage background jobs",
+	"获取任务列表失败":                   "Failed to list jobs",
+	"任务不存在":                      "Job not found",
+	"任务已结束":                      "The job has already finished",
+	"该任务无法取消":                    "This job cannot be canceled",
+	"该任务无法重试":                    "This job cannot be retried",
+	"任务处理的对象已不存在":                "What the job worked on no longer exists",
+	"该任务已在运行":                    "The job is already running",
+	"操作任务失败":                     "Failed to update the job",
+
+	// Products, workspaces and usage
+	"产品不存在":                  "Product not found",
+	"产品不存在(ID: %s)":          "Product not found (ID: %s)",
+	"获取产品列表失败":               "Failed to list products",
+	"仅超级管理员可管理产品":            "Only super admins can manage products",
+	"检查产品数据失败":               "Failed to check product data",
+	"请先删除该产品下的文档和知识条目":       "Please delete the product's documents and knowledge entries first",
+	"该产品下存在关联的文档或知识条目，确认删除？": "This product still has documents or knowledge entries. Delete anyway?",
+	"删除产品失败":                 "Failed to delete product",
+	"该功能仅在主工作区可用":            "This feature is only available in the main workspace",
+	"今日问答次数已达上限":             "The daily question limit has been reached",
+	"产品数量":                   "products",
+	"文档数量":                   "documents",
+	"管理员数量":                  "admins",
+	"每日问答次数":                 "questions per day",
+	"已达到工作区配额上限：%s（%d）":      "Workspace quota reached: %s (%d)",
+	"检查工作区配额失败":              "Failed to check workspace quota",
+	"工作区下仍有 %d 个产品，请先删除":     "The workspace still has %d products, please delete them first",
+	"仅超级管理员可管理工作区":           "Only super admins can manage workspaces",
+	"获取工作区列表失败":              "Failed to list workspaces",
+	"工作区不存在":                 "Workspace not found",
+	"本月问答次数已达上限":             "The monthly question limit has been reached",
+	"本月用量已达上限":               "The monthly usage limit has been reached",
+	"仅超级管理员可管理用量配额":          "Only super admins can manage usage quotas",
+	"配额已保存":                  "Quota saved",
+	"配额不存在":                  "Quota not found",
+	"配额已删除，恢复默认限制":           "Quota deleted, default limits apply again",
+	"该站点未被授权嵌入客服组件":          "This site is not allowed to embed the chat widget",
+	"创建访客会话失败":               "Failed to create visitor session",
+	"无效的访客令牌":                "Invalid visitor token",
+
+	// Questions, feedback and reports
+	"您好！欢迎使用我们的产品。": "Hello! Welcome to our product.",
+	"抱歉，这个问题与我们的产品无关。请问有什么产品方面的问题需要帮助吗？": "Sorry, this question is not related to our product. Is there anything about the product I can help you with?",
+	"该问题已在处理中，请耐心等待回复":                   "This question is already being handled, please wait for a reply",
+	"该问题已转交人工处理，请稍后查看回复":                 "This question has been passed to our support team, please check back later for a reply",
+	"该回答未经人工核实，问题已同时转交人工处理":              "This answer has not been verified; the question has also been passed to our support team",
+	"查询处理失败，请稍后重试":                       "Failed to process the question, please try again later",
+	"抱歉，您的问题包含不允许的内容，无法回答。":              "Sorry, your question contains content that is not allowed and cannot be answered.",
+	"感谢您的反馈":              "Thank you for your feedback",
+	"实验不存在":               "Experiment not found",
+	"获取问题列表失败":            "Failed to list questions",
+	"问题不存在":               "Question not found",
+	"无权处理该产品的问题":          "You do not have permission to handle questions for this product",
+	"回答问题失败":              "Failed to answer the question",
+	"创建问题失败":              "Failed to create the question",
+	"生成回答草稿失败":            "Failed to generate a draft answer",
+	"删除问题失败":              "Failed to delete the question",
+	"days 必须在 1 到 365 之间": "days must be between 1 and 365",
+	"已有报告正在生成":            "A report is already being generated",
+	"启动报告生成失败":            "Failed to start generating the report",
+	"常见问题正在生成":            "The FAQ is already being generated",
+	"启动常见问题生成失败":          "Failed to start generating the FAQ",
+	"报告不存在":               "Report not found",
+	"审核策略不存在":             "Moderation policy not found",
+	"拒答规则不存在":             "Refusal rule not found",
+	"用户组不存在":              "User group not found",
+	"获取登录设备失败":            "Failed to list signed-in devices",
+	"注销登录设备失败":            "Failed to sign out the device",
+	"登录设备不存在":             "Device not found",
+	"连接器不存在":              "Connector not found",
+	"连接器正在同步":             "The connector is already syncing",
+	"启动同步失败":              "Failed to start the sync",
+	"连接器名称不能为空且不超过200个字符": "Connector name is required and must not exceed 200 characters",
+	"API 令牌不能为空":          "API token is required",
+	"API 令牌过长":            "API token is too long",
+	"同步计划无效: %s":          "Invalid sync schedule: %s",
+	"Confluence 地址须为 https URL，如 https://example.atlassian.net/wiki": "The Confluence URL must be an https URL, e.g. https://example.atlassian.net/wiki",
+	"Confluence 账号邮箱不能为空":                                            "Confluence account email is required",
+	"Confluence 空间标识不能为空":                                            "Confluence space key is required",
+	"Notion 根页面 ID 无效":                                               "Invalid Notion root page ID",
+	"连接器类型须为 confluence、notion、git、gdrive、sharepoint 或 s3":           "Connector type must be confluence, notion, git, gdrive, sharepoint or s3",
+	"S3 服务地址须为 http(s) URL，AWS S3 可留空":                               "The S3 endpoint must be an http(s) URL, or empty for AWS S3",
+	"S3 访问密钥 ID 不能为空":                                                "S3 access key ID is required",
+	"S3 范围须为「存储桶」或「存储桶/前缀」":                                          "The S3 scope must be \"bucket\" or \"bucket/prefix\"",
+	"S3 区域无效":                                                          "Invalid S3 region",
+	"只有 S3 连接器支持事件通知":                                                  "Only S3 connectors support event notifications",
+	"连接器尚未授权，请先完成授权":                                                   "The connector is not authorized yet; authorize it first",
+	"尚未选择要同步的文件夹":                                                      "No folders have been selected to sync",
+	"文件夹 ID 无效":                                                        "Invalid folder ID",
+	"最多同步 %d 个文件夹":                                                     "At most %d folders can be synced",
+	"读取文件夹失败":                                                          "Failed to list folders",
+	"授权未返回刷新令牌，请重新授权":                                                  "The authorization returned no refresh token; authorize again",
+	"该类型的连接器无需授权":                                                      "This type of connector needs no authorization",
+	"该类型的连接器不支持选择文件夹":                                                  "This type of connector has no folders to pick",
+	"未配置 Google 应用（connectors.google.client_id / client_secret）":       "No Google app is configured (connectors.google.client_id / client_secret)",
+	"未配置 Microsoft 应用（connectors.microsoft.client_id / client_secret）": "No Microsoft app is configured (connectors.microsoft.client_id / client_secret)",
+	"Git 仓库地址须为 https URL，如 https://github.com/example/docs.git":       "The Git repository URL must be an https URL, e.g. https://github.com/example/docs.git",
+	"Git 分支名无效":                                                        "Invalid Git branch name",
+	"Git 目录路径无效":                                                       "Invalid Git folder path",
+	"SLA 策略不存在":                                                        "SLA policy not found",
+	"审核记录不存在":                                                          "Moderation item not found",
+	"该记录已审核":                                                           "This item has already been reviewed",
+	"已通过审核，但文档重新处理失败: %s":                                              "Approved, but reprocessing the document failed: %s",
+	"已通过审核，文档正在重新处理":                                                   "Approved, the document is being reprocessed",
+	"已驳回，但删除文档失败: %s":                                                  "Rejected, but deleting the document failed: %s",
+	"已驳回，文档已删除":                                                        "Rejected, the document has been deleted",
+
+	// Documents and knowledge entries
+	"获取文档列表失败":           "Failed to list documents",
+	"文件大小超过限制 (%dMB)":    "File exceeds the size limit (%dMB)",
+	"文件内容与扩展名不匹配":        "File content does not match its extension",
+	"文件内容与扩展名不匹配（实际为%s）": "File content does not match its extension (actually %s)",
+	"文本":               "text",
+	"可执行程序":            "an executable",
+	"图片":               "an image",
+	"音频":               "audio",
+	"压缩包":              "an archive",
+	"损坏的压缩包":           "a corrupt archive",
+	"其他 Office 文档":     "another Office document",
+	"文件路径不安全":          "Unsafe file path",
+	"文件过大":             "File too large",
+	"无法解压该文件":          "Cannot extract this file",
+	"压缩包嵌套层级过深":        "Archive nested too deeply",
+	"已达到工作区文档数量上限":     "Workspace document quota reached",
+	"无法解析压缩包":          "Cannot read the ZIP archive",
+	"压缩包内文件数超过限制 (%d)": "Archive contains more files than allowed (%d)",
+	"压缩包解压后大小超过限制 (%dMB)": "Archive expands beyond the size limit (%dMB)",
+	"解压失败":                            "Failed to extract the archive",
+	"无权管理该产品的文档":                      "You do not have permission to manage documents of this product",
+	"文档未找到":                           "Document not found",
+	"该产品不允许下载参考文档":                    "This product does not allow downloading source documents",
+	"该文档类型不支持下载":                      "This document type cannot be downloaded",
+	"文件未找到":                           "File not found",
+	"删除文档失败":                          "Failed to delete document",
+	"字幕文件须为 .srt 或 .vtt 格式":           "The subtitle file must be an .srt or .vtt file",
+	"字幕文件过大":                          "Subtitle file too large",
+	"只有视频可以附带字幕文件":                    "Only videos can come with a subtitle file",
+	"字幕文件无效: %s":                      "Invalid subtitle file: %s",
+	"字幕文件不是 UTF-8 编码":                 "The subtitle file is not UTF-8 encoded",
+	"字幕文件中没有字幕":                       "The subtitle file contains no cues",
+	"字幕时间无效: %s":                      "Invalid subtitle timing: %s",
+	"保存字幕失败":                          "Failed to save the subtitles",
+	"保存字幕失败: %s":                      "Failed to save the subtitles: %s",
+	"该文档没有转录":                         "This document has no transcript",
+	"获取转录失败":                          "Failed to load the transcript",
+	"回答不存在或已过期":                       "The answer does not exist or has expired",
+	"导出回答失败":                          "Failed to export the answer",
+	"参考来源":                            "Sources",
+	"回答于 %s":                          "Answered %s",
+	"第 %d 页":                          "Page %d",
+	"第 %d 张幻灯片":                       "Slide %d",
+	"按 Ctrl+P 可打印或另存为 PDF":            "Press Ctrl+P to print or save as PDF",
+	"设置文档优先级失败":                       "Failed to set the document priority",
+	"文档优先级必须在 0.1 到 10 之间":            "Document priority must be between 0.1 and 10",
+	"无批量导入权限":                         "You do not have permission to bulk import",
+	"无法访问路径: %v":                      "Cannot access path: %v",
+	"未找到支持的文件":                        "No supported files found",
+	"读取失败: %v":                        "Read failed: %v",
+	"导入失败: %v":                        "Import failed: %v",
+	"处理失败: %s":                        "Processing failed: %s",
+	"服务正在关闭，请稍后重试":                    "The service is shutting down, please try again later",
+	"文档处理已取消":                         "Document processing was canceled",
+	"文档不在处理中":                         "The document is not being processed",
+	"只能重新处理导入失败的文档":                   "Only documents whose import failed can be reprocessed",
+	"上传请求已中断，文档处理已停止":                 "The upload request ended, so document processing was stopped",
+	"向量化队列已满，请稍后重试":                   "The embedding queue is full, please try again later",
+	"当前提问人数较多，请稍后重试":                  "Too many questions are being answered right now, please try again later",
+	"请求内容过大，上限为 %s":                   "Request body too large, the limit is %s",
+	"不支持的文件格式":                        "Unsupported file format",
+	"不支持的文件格式: %s":                    "Unsupported file format: %s",
+	"文件名不能为空":                         "File name is required",
+	"文件名过长":                           "File name is too long",
+	"文件内容为空":                          "File is empty",
+	"创建上传会话失败":                        "Failed to create the upload session",
+	"上传会话不存在或已过期":                     "Upload session not found or expired",
+	"文件尚未上传完成":                        "The file has not been completely uploaded yet",
+	"上传偏移量不匹配，请从当前偏移量继续":              "Upload offset mismatch; continue from the current offset",
+	"上传数据超过声明的文件大小":                   "The uploaded data exceeds the declared file size",
+	"上传中断，请从当前偏移量继续":                  "Upload interrupted; continue from the current offset",
+	"保存上传数据失败":                        "Failed to save the uploaded data",
+	"上传会话正在写入":                        "A chunk of this upload is still being written",
+	"删除上传会话失败":                        "Failed to delete the upload session",
+	"文档内容重复，与已有文档相同":                  "Duplicate document: identical to an existing document",
+	"文件未通过安全扫描: %s":                   "File failed the malware scan: %s",
+	"安全扫描失败，请稍后重试":                    "Malware scan failed, please try again later",
+	"文档包含不允许的内容，已提交管理员审核":             "The document contains restricted content and has been sent for review",
+	"URL不能为空":                         "URL is required",
+	"文档内容为空":                          "Document is empty",
+	"无法访问该URL: %w":                    "Cannot access the URL: %w",
+	"访问被拒绝 (HTTP %d)，该网站可能禁止抓取":       "Access denied (HTTP %d), the site may block crawling",
+	"请求失败 (HTTP %d)":                  "Request failed (HTTP %d)",
+	"URL内容为空":                         "The URL has no content",
+	"解析后内容为空":                         "No content after parsing",
+	"URL中不允许包含用户凭据":                   "URLs must not contain credentials",
+	"仅支持 HTTP/HTTPS 协议":               "Only HTTP and HTTPS are supported",
+	"URL缺少主机名":                        "URL is missing a host name",
+	"不允许访问内部地址":                       "Internal addresses are not allowed",
+	"不允许访问内部网络地址":                     "Internal network addresses are not allowed",
+	"标题和内容不能为空":                       "Title and content are required",
+	"标题过长（最多500字符）":                   "Title is too long (max 500 characters)",
+	"内容过长（最多100000字符）":                "Content is too long (max 100000 characters)",
+	"图片数量过多（最多50张）":                   "Too many images (max 50)",
+	"视频数量过多（最多10个）":                   "Too many videos (max 10)",
+	"图片URL格式不正确":                      "Invalid image URL",
+	"视频URL格式不正确":                      "Invalid video URL",
+	"创建文档记录失败: %w":                    "Failed to create document record: %w",
+	"存储文本失败: %w":                      "Failed to store text: %w",
+	"图片文件过大（最大10MB）":                  "Image is too large (max 10MB)",
+	"不支持的图片格式，支持jpg/png/gif/webp/bmp": "Unsupported image format, use jpg/png/gif/webp/bmp",
+	"文件内容不是有效的图片":                     "File is not a valid image",
+	"图片数据无效":                          "The image data is invalid",
+	"图片文件过大，请压缩后重试":                   "The image is too large, please compress it and try again",
+	"图片尺寸过大，请缩小后重试":                   "The image dimensions are too large, please shrink it and try again",
+	"不支持的图片格式，支持PNG/JPEG/GIF/WebP":    "Unsupported image format, use PNG/JPEG/GIF/WebP",
+	"不支持的视频格式，支持MP4/AVI/MKV/MOV/WebM": "Unsupported video format, use MP4/AVI/MKV/MOV/WebM",
+	"视频文件大小超过限制 (%dMB)":               "Video exceeds the size limit (%dMB)",
+	"文件内容不是有效的视频格式":                   "File is not a valid video",
+
+	// gRPC API
+	"请求消息不完整":          "Incomplete request message",
+	"请求消息格式错误":         "Malformed request message",
+	"缺少请求消息":           "Missing request message",
+	"不支持压缩的消息":         "Compressed messages are not supported",
+	"消息大小超过限制 (%dMB)":  "Message exceeds the size limit (%dMB)",
+	"上传的第一条消息必须包含文件信息": "The first upload message must carry the file metadata",
+	"缺少文件名":            "File name is required",
+	"上传的文件为空":          "The uploaded file is empty",
+
+	// System settings
+	"无权修改系统设置":                   "You do not have permission to change system settings",
+	"更新配置失败":                     "Failed to update configuration",
+	"LLM 连接测试失败，请检查配置":           "LLM connection test failed, please check the settings",
+	"Embedding 连接测试失败，请检查配置":     "Embedding connection test failed, please check the settings",
+	"发送测试邮件失败，请检查SMTP配置":         "Failed to send the test email, please check the SMTP settings",
+	"测试邮件已发送":                    "Test email sent",
+	"读取日志失败: %s":                 "Failed to read logs: %s",
+	"rotation_mb 必须在 1-10240 之间": "rotation_mb must be between 1 and 10240",
+	"日志文件不存在":                    "Log file not found",
+	"打开日志文件失败":                   "Failed to open the log file",
+	"读取日志文件信息失败":                 "Failed to read log file information",
+	"压缩初始化失败":                    "Failed to initialize compression",
+	"清空日志失败: %s":                 "Failed to clear logs: %s",
+	"必填项不能为空":                    "Required fields are missing",
+	"LLM 配置不完整":                  "LLM settings are incomplete",
+	"修改服务地址时需重新填写 API Key":       "Please re-enter the API key when changing the service URL",
+	"LLM 连接测试失败":                 "LLM connection test failed",
+	"Embedding 配置不完整":            "Embedding settings are incomplete",
+	"Embedding 连接测试失败":           "Embedding connection test failed",
+	"Embedding 服务返回了空向量":         "The embedding service returned an empty vector",
+	"模型返回了空向量":                   "The model returned an empty vector",
+	"图片向量模型配置不完整":                "Image embedding settings are incomplete",
+	"图片向量模型连接测试失败":               "Image embedding connection test failed",
+	"图片向量模型返回了空向量":               "The image embedding model returned an empty vector",
+	"模型向量维度为 %d，与知识库已有向量维度 %d 不一致，切换后需重新导入文档": "The model returns %d-dimensional vectors but the knowledge base has %d; documents must be re-imported after switching",
+	"端口必须在 1-65535 之间":                "Port must be between 1 and 65535",
+	"SMTP 配置不完整":                      "SMTP settings are incomplete",
+	"修改服务器地址时需重新填写密码":                 "Please re-enter the password when changing the server address",
+	"认证失败":                            "Authentication failed",
+	"SMTP 握手失败":                       "SMTP handshake failed",
+	"修改 Token 地址时需重新填写 Client Secret": "Please re-enter the client secret when changing the token URL",
+	"OAuth 配置检查失败":                    "OAuth settings check failed",
+
+	// Video retrieval auto-setup progress
+	"仅超级管理员可执行自动配置":                            "Only super admins can run the auto-setup",
+	"当前服务未以 root 运行，请提供管理员密码以继续自动配置":           "The service is not running as root, please provide the admin password to continue",
+	"管理员密码验证失败，请检查密码是否正确":                      "Admin password verification failed, please check the password",
+	"自动配置正在进行中，请等待完成后再试":                       "Auto-setup is already running, please wait for it to finish",
+	"正在安装系统依赖 (git, gcc, g++, cmake, make)...": "Installing system dependencies (git, gcc, g++, cmake, make)...",
+	"apt-get update 失败: %v":                    "apt-get update failed: %v",
+	"安装失败":                                     "Installation failed",
+	"安装系统依赖失败: %v":                             "Failed to install system dependencies: %v",
+	"系统依赖安装完成 ✓":                               "System dependencies installed ✓",
+	"正在安装 FFmpeg...":                           "Installing FFmpeg...",
+	"FFmpeg 安装失败: %v":                          "FFmpeg installation failed: %v",
+	"FFmpeg 安装后未找到可执行文件":                       "FFmpeg executable not found after installation",
+	"FFmpeg 安装完成 ✓ (%s)":                       "FFmpeg installed ✓ (%s)",
+	"正在克隆 RapidSpeech.cpp 仓库...":               "Cloning the RapidSpeech.cpp repository...",
+	"创建目录失败 %s: %v":                            "Failed to create directory %s: %v",
+	"仓库目录已存在，执行 git pull...":                   "Repository directory exists, running git pull...",
+	"git pull 失败，将重新克隆...":                     "git pull failed, cloning again...",
+	"克隆仓库失败: %v":                               "Failed to clone the repository: %v",
+	"仓库克隆完成 ✓":                                 "Repository cloned ✓",
+	"正在初始化子模块...":                              "Initializing submodules...",
+	"子模块初始化失败: %v":                             "Failed to initialize submodules: %v",
+	"子模块初始化完成 ✓":                               "Submodules initialized ✓",
+	"正在编译 RapidSpeech.cpp (cmake)...":          "Building RapidSpeech.cpp (cmake)...",
+	"创建 build 目录失败: %v":                        "Failed to create the build directory: %v",
+	"cmake 配置失败: %v":                           "cmake configuration failed: %v",
+	"cmake 配置完成，开始编译...":                       "cmake configured, building...",
+	"编译失败: %v":                                 "Build failed: %v",
+	"编译完成但未找到 rs-asr-offline 可执行文件":            "Build finished but the rs-asr-offline executable was not found",
+	"RapidSpeech.cpp 编译完成 ✓ (%s)":              "RapidSpeech.cpp built ✓ (%s)",
+	"正在下载 RapidSpeech 模型文件...":                 "Downloading the RapidSpeech model...",
+	"创建模型目录失败: %v":                             "Failed to create the model directory: %v",
+	"模型文件已存在，跳过下载":                             "Model already present, skipping download",
+	"使用 ModelScope 下载模型...":                    "Downloading the model from ModelScope...",
+	"使用 Hugging Face 下载模型...":                  "Downloading the model from Hugging Face...",
+	"ModelScope 下载失败，尝试 Hugging Face...":       "ModelScope download failed, trying Hugging Face...",
+	"Hugging Face 下载失败，尝试 ModelScope...":       "Hugging Face download failed, trying ModelScope...",
+	"模型下载失败: %v":                               "Model download failed: %v",
+	"模型下载完成 ✓ (%s)":                            "Model downloaded ✓ (%s)",
+	"正在更新系统配置...":                              "Updating the system configuration...",
+	"配置更新失败: %v":                               "Configuration update failed: %v",
+	"配置更新完成 ✓":                                 "Configuration updated ✓",
+	"自动配置完成！FFmpeg 和 RapidSpeech 已安装并配置好":      "Auto-setup complete! FFmpeg and RapidSpeech are installed and configured",
+	"FFmpeg 路径未配置":                             "FFmpeg path is not configured",
+	"FFmpeg 文件不存在: %s":                         "FFmpeg executable not found: %s",
+	"FFmpeg 路径指向目录而非文件: %s":                    "The FFmpeg path is a directory, not a file: %s",
+	"RapidSpeech 可执行文件和模型路径均未配置":               "RapidSpeech executable and model paths are not configured",
+	"RapidSpeech 可执行文件路径未配置":                   "RapidSpeech executable path is not configured",
+	"RapidSpeech 模型文件路径未配置":                    "RapidSpeech model path is not configured",
+	"RapidSpeech 可执行文件不存在: %s":                 "RapidSpeech executable not found: %s",
+	"RapidSpeech 路径指向目录而非文件: %s":               "The RapidSpeech path is a directory, not a file: %s",
+	"RapidSpeech 可执行文件没有执行权限: %s":              "The RapidSpeech executable is not executable: %s",
+	"RapidSpeech 模型文件不存在: %s":                  "RapidSpeech model not found: %s",
+	"RapidSpeech 模型路径指向目录而非文件: %s":             "The RapidSpeech model path is a directory, not a file: %s",
+	"RapidSpeech 模型文件应为 .gguf 或 .bin 格式":       "The RapidSpeech model must be a .gguf or .bin file",
+
+	// Auto-setup package managers and static FFmpeg fallback
+	"检测到系统: %s":                        "Detected system: %s",
+	"使用包管理器: %s":                       "Using package manager: %s",
+	"更新软件包索引失败 (%s): %v":               "Failed to refresh the package index (%s): %v",
+	"未找到支持的包管理器，且缺少编译工具: %s":           "No supported package manager found and build tools are missing: %s",
+	"未找到支持的包管理器，使用已安装的编译工具":            "No supported package manager found, using the installed build tools",
+	"通过 %s 安装 FFmpeg 失败，改为下载静态编译版本...": "Installing FFmpeg with %s failed, downloading a static build instead...",
+	"FFmpeg 安装后未找到可执行文件，改为下载静态编译版本...": "FFmpeg executable not found after installation, downloading a static build instead...",
+	"没有适用于 %s 架构的 FFmpeg 静态版本":         "No static FFmpeg build is available for the %s architecture",
+	"下载 %s":    "Downloading %s",
+	"下载失败: %v": "Download failed: %v",
+	"解压失败: %v": "Extraction failed: %v",
+	"解压后未找到 ffmpeg 可执行文件": "ffmpeg executable not found after extraction",
+	"已下载 %d MB / %d MB":   "Downloaded %d MB / %d MB",
+	"已下载 %d MB":           "Downloaded %d MB",
+}
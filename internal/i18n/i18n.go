@@ -0,0 +1,258 @@
+// Package i18n translates user-facing API messages.
+//
+// Messages are looked up by their source text: handlers keep writing the
+// literal message they always did, and a catalog maps that text to the
+// requested language. Catalog keys may contain fmt verbs (%s, %d, %v, ...)
+// to translate formatted messages, e.g. an error built with fmt.Errorf.
+// Text without a catalog entry is returned unchanged, so a missing
+// translation falls back to the source language.
+//
+// Built-in catalogs cover Chinese ("zh") and English ("en"). More languages,
+// or overrides of built-in entries, are loaded from <lang>.json files with
+// LoadDir.
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default is the language used when a request names none we support.
+const Default = "zh"
+
+// verbRe matches the fmt verbs catalog keys may use as placeholders.
+var verbRe = regexp.MustCompile(`%[-+# 0]*[0-9]*(?:\.[0-9]+)?[sdvqwf]`)
+
+// template is a catalog entry whose key contains fmt verbs.
+type template struct {
+	key string
+	re  *regexp.Regexp
+	out string // translation with every verb replaced by %s
+}
+
+type catalog struct {
+	messages  map[string]string
+	templates []template
+}
+
+var (
+	mu       sync.RWMutex
+	catalogs = map[string]*catalog{}
+)
+
+// Register adds messages to the catalog of lang, creating it if needed.
+// Entries replace earlier ones with the same key.
+func Register(lang string, messages map[string]string) {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if lang == "" {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	c := catalogs[lang]
+	if c == nil {
+		c = &catalog{messages: map[string]string{}}
+		catalogs[lang] = c
+	}
+	for k, v := range messages {
+		if _, exists := c.messages[k]; exists {
+			c.templates = slices.DeleteFunc(c.templates, func(t template) bool { return t.key == k })
+		}
+		c.messages[k] = v
+		if t, ok := compileTemplate(k, v); ok {
+			c.templates = append(c.templates, t)
+		}
+	}
+	// Try the most specific (longest) template first
+	sort.SliceStable(c.templates, func(i, j int) bool { return len(c.templates[i].key) > len(c.templates[j].key) })
+}
+
+// LoadDir registers every <lang>.json file in dir, each a JSON object
+// mapping source messages to their translation. A missing dir is not an
+// error. It returns the languages loaded.
+func LoadDir(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var loaded []string
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return loaded, err
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return loaded, fmt.Errorf("%s: %w", filepath.Base(f), err)
+		}
+		lang := strings.TrimSuffix(filepath.Base(f), ".json")
+		Register(lang, messages)
+		loaded = append(loaded, lang)
+	}
+	return loaded, nil
+}
+
+// Languages returns the languages with a catalog, sorted.
+func Languages() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	langs := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Supported returns the catalog language for a language tag such as "en",
+// "en-US" or "zh_CN": the full tag if it has a catalog, else its primary
+// subtag.
+func Supported(tag string) (string, bool) {
+	tag = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+	if tag == "" {
+		return "", false
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if _, ok := catalogs[tag]; ok {
+		return tag, true
+	}
+	if i := strings.IndexByte(tag, '-'); i > 0 {
+		if _, ok := catalogs[tag[:i]]; ok {
+			return tag[:i], true
+		}
+	}
+	return "", false
+}
+
+// Match returns the supported language the client prefers most according to
+// an Accept-Language header, or "" if none is supported.
+func Match(acceptLanguage string) string {
+	type pref struct {
+		tag string
+		q   float64
+	}
+	var prefs []pref
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+		if tag == "" || tag == "*" || q <= 0 {
+			continue
+		}
+		prefs = append(prefs, pref{tag, q})
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+	for _, p := range prefs {
+		if lang, ok := Supported(p.tag); ok {
+			return lang
+		}
+	}
+	return ""
+}
+
+// T returns msg translated to lang, or msg itself when there is no
+// translation.
+func T(lang, msg string) string {
+	return translate(lang, msg, 0)
+}
+
+// Lookup returns the catalog translation of msg to lang and whether there is
+// one.
+func Lookup(lang, msg string) (string, bool) {
+	out := translate(lang, msg, 0)
+	return out, out != msg
+}
+
+// Tf translates format and formats it with args.
+func Tf(lang, format string, args ...interface{}) string {
+	return fmt.Sprintf(T(lang, format), args...)
+}
+
+// ResponseLanguage returns the language negotiated for a response, which the
+// localization middleware records in its Content-Language header.
+func ResponseLanguage(w http.ResponseWriter) string {
+	if lang := w.Header().Get("Content-Language"); lang != "" {
+		return lang
+	}
+	return Default
+}
+
+func translate(lang, msg string, depth int) string {
+	if msg == "" {
+		return msg
+	}
+	mu.RLock()
+	c := catalogs[lang]
+	mu.RUnlock()
+	if c == nil {
+		return msg
+	}
+	if out, ok := c.messages[msg]; ok {
+		return out
+	}
+	// Formatted messages: translate the matching template and, one level
+	// deep, the text substituted into it (typically a wrapped error)
+	for _, t := range c.templates {
+		m := t.re.FindStringSubmatch(msg)
+		if m == nil {
+			continue
+		}
+		args := make([]interface{}, len(m)-1)
+		for i, s := range m[1:] {
+			if depth < 1 {
+				s = translate(lang, s, depth+1)
+			}
+			args[i] = s
+		}
+		return fmt.Sprintf(t.out, args...)
+	}
+	return msg
+}
+
+// compileTemplate turns a key with fmt verbs into a pattern matching the
+// formatted message. The translation must use as many verbs, in the same
+// order.
+func compileTemplate(key, translation string) (template, bool) {
+	locs := verbRe.FindAllStringIndex(key, -1)
+	if len(locs) == 0 || len(verbRe.FindAllStringIndex(translation, -1)) != len(locs) {
+		return template{}, false
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	last := 0
+	for _, loc := range locs {
+		b.WriteString(regexp.QuoteMeta(strings.ReplaceAll(key[last:loc[0]], "%%", "%")))
+		if strings.HasSuffix(key[loc[0]:loc[1]], "d") {
+			b.WriteString(`(-?\d+)`)
+		} else {
+			b.WriteString(`(.+?)`)
+		}
+		last = loc[1]
+	}
+	b.WriteString(regexp.QuoteMeta(strings.ReplaceAll(key[last:], "%%", "%")))
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return template{}, false
+	}
+	out := strings.ReplaceAll(translation, "%%", "\x00")
+	out = verbRe.ReplaceAllString(out, "%s")
+	out = strings.ReplaceAll(out, "\x00", "%%")
+	return template{key: key, re: re, out: out}, true
+}
@@ -0,0 +1,113 @@
+package i18n
+
+func init() {
+	Register("zh", zh)
+}
+
+// zh translates the English API messages to Chinese. Most user-facing
+// messages are written in Chinese and need no entry.
+var zh = map[string]string{
+	"method not allowed":                         "不支持的请求方法",
+	"invalid request body":                       "请求内容无效",
+	"unauthorized":                               "未授权",
+	"not found":                                  "未找到",
+	"internal error":                             "服务器内部错误",
+	"config not loaded":                          "系统配置未加载",
+	"streaming not supported":                    "不支持流式响应",
+	"failed to parse form":                       "解析表单失败",
+	"failed to parse multipart form":             "解析上传表单失败",
+	"invalid id":                                 "无效的 ID",
+	"invalid product_id":                         "无效的产品 ID",
+	"invalid batch_id":                           "无效的批次 ID",
+	"invalid connector ID":                       "无效的连接器 ID",
+	"failed to list connectors":                  "获取连接器列表失败",
+	"failed to load connector":                   "获取连接器失败",
+	"failed to delete connector":                 "删除连接器失败",
+	"failed to list pages":                       "获取同步页面失败",
+	"invalid product ID":                         "无效的产品 ID",
+	"missing product ID":                         "缺少产品 ID",
+	"product_id is required":                     "请指定产品",
+	"invalid document ID":                        "无效的文档 ID",
+	"missing document ID":                        "缺少文档 ID",
+	"invalid user ID":                            "无效的用户 ID",
+	"invalid user_id":                            "无效的用户 ID",
+	"missing user ID":                            "缺少用户 ID",
+	"invalid email":                              "邮箱格式不正确",
+	"invalid role ID":                            "无效的角色 ID",
+	"invalid tenant ID":                          "无效的工作区 ID",
+	"invalid subject_id":                         "无效的对象 ID",
+	"invalid scope":                              "无效的范围",
+	"invalid status":                             "无效的状态",
+	"invalid status parameter":                   "无效的状态参数",
+	"invalid filter":                             "无效的筛选条件",
+	"invalid from":                               "无效的起始时间",
+	"invalid to":                                 "无效的结束时间",
+	"invalid period (expected YYYY-MM)":          "无效的统计周期（格式应为 YYYY-MM）",
+	"invalid language parameter":                 "无效的语言参数",
+	"question is required":                       "请输入问题",
+	"question too long":                          "问题过长",
+	"question too long (max 2000 characters)":    "问题过长（最多 2000 个字符）",
+	"question too long (max 10000 characters)":   "问题过长（最多 10000 个字符）",
+	"missing question ID":                        "缺少问题 ID",
+	"invalid question ID":                        "无效的问题 ID",
+	"invalid query_id":                           "无效的回答 ID",
+	"helpful is required":                        "请选择是否有帮助",
+	"comment too long (max 2000 characters)":     "评论过长（最多 2000 个字符）",
+	"failed to record feedback":                  "提交反馈失败",
+	"image data too large":                       "图片数据过大",
+	"missing file in upload":                     "未上传文件",
+	"failed to read file":                        "读取文件失败",
+	"missing image in upload":                    "未上传图片",
+	"failed to read image":                       "读取图片失败",
+	"failed to save image":                       "保存图片失败",
+	"failed to load image":                       "加载图片失败",
+	"missing video in upload":                    "未上传视频",
+	"failed to read video":                       "读取视频失败",
+	"failed to save video":                       "保存视频失败",
+	"failed to generate ID":                      "生成 ID 失败",
+	"media not found":                            "媒体文件不存在",
+	"path is required":                           "请输入路径",
+	"endpoint, api_key, model_name are required": "请填写 endpoint、api_key 和 model_name",
+	"channel disabled":                           "该渠道未启用",
+	"invalid secret token":                       "无效的密钥",
+	"invalid signature":                          "签名无效",
+	"invalid provider name":                      "无效的提供商名称",
+	"invalid provider":                           "无效的提供商",
+	"invalid SSO type":                           "无效的 SSO 类型",
+	"missing provider parameter":                 "缺少 provider 参数",
+	"missing provider name":                      "缺少提供商名称",
+	"invalid event token":                        "事件令牌无效",
+	"invalid group ID":                           "无效的用户组 ID",
+	"invalid product or document ID":             "无效的产品或文档 ID",
+	"failed to list user groups":                 "获取用户组列表失败",
+	"failed to load user group":                  "加载用户组失败",
+	"failed to delete user group":                "删除用户组失败",
+	"invalid session ID":                         "无效的会话 ID",
+	"invalid or expired OAuth state":             "OAuth 状态无效或已过期",
+	"ticket is required":                         "缺少登录票据",
+	"failed to list customers":                   "获取用户列表失败",
+	"failed to list policies":                    "获取审核策略失败",
+	"failed to delete policy":                    "删除审核策略失败",
+	"failed to list moderation queue":            "获取审核队列失败",
+	"invalid item ID":                            "无效的审核记录 ID",
+	"failed to load item":                        "加载审核记录失败",
+	"failed to review item":                      "审核失败",
+	"failed to load usage":                       "获取用量失败",
+	"failed to list quotas":                      "获取配额列表失败",
+	"failed to delete quota":                     "删除配额失败",
+	"invalid webhook ID":                         "无效的 Webhook ID",
+	"invalid report ID":                          "无效的报告 ID",
+	"failed to load report":                      "加载报告失败",
+	"failed to delete report":                    "删除报告失败",
+	"failed to list experiments":                 "获取实验列表失败",
+	"invalid experiment ID":                      "无效的实验 ID",
+	"auto-setup is only supported on Linux":      "自动配置仅支持 Linux",
+	"days must be between 1 and 30":              "days 必须在 1 到 30 之间",
+	"limit must be between 1 and 500":            "limit 必须在 1 到 500 之间",
+	"by must be network or asn":                  "by 必须为 network 或 asn",
+	"failed to load offending networks":          "获取异常网络统计失败",
+	"failed to list network bans":                "获取网络封禁列表失败",
+	"failed to add network ban":                  "添加网络封禁失败",
+	"failed to remove network ban":               "解除网络封禁失败",
+	"action must be unban, unlock or notify":     "action 必须为 unban、unlock 或 notify",
+}
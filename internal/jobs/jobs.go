@@ -0,0 +1,501 @@
+// Package jobs records background work — document ingestion, backups,
+// database maintenance, connector syncs and FAQ generation — in the jobs
+// table, so admins can follow it from one place at /api/admin/jobs. Each
+// run is a row with its status, progress and timings; the instance running
+// it keeps the row fresh, so the rows of an instance that died are marked
+// failed. Jobs started with a cancel function can be canceled from the
+// instance running them, and failed jobs whose kind registered a retry
+// function can be run again.
+package jobs
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job kinds.
+const (
+	KindIngestion   = "ingestion"
+	KindBackup      = "backup"
+	KindMaintenance = "maintenance"
+	KindConnector   = "connector_sync"
+	KindFAQ         = "faq"
+)
+
+// Job statuses.
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCanceled  = "canceled"
+)
+
+const (
+	// flushEvery is how often progress of running jobs is written.
+	flushEvery = 2 * time.Second
+	// heartbeatEvery is how often running jobs are marked alive.
+	heartbeatEvery = 30 * time.Second
+	// staleAfter is how long a running job may go without a heartbeat
+	// before it is considered abandoned by its instance.
+	staleAfter = 3 * time.Minute
+	// keepFor is how long finished jobs are kept.
+	keepFor = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrNotFound is returned for unknown job IDs.
+	ErrNotFound = errors.New("job not found")
+	// ErrNotRunning is returned when canceling a finished job.
+	ErrNotRunning = errors.New("job is not running")
+	// ErrNotCancelable is returned when canceling a job that cannot stop early.
+	ErrNotCancelable = errors.New("job cannot be canceled")
+	// ErrRemote is returned when canceling a job run by another instance.
+	ErrRemote = errors.New("job is running on another instance")
+	// ErrNotRetryable is returned when retrying a job that is running,
+	// succeeded or has no retry function.
+	ErrNotRetryable = errors.New("job cannot be retried")
+	// ErrCanceled marks the error a canceled job finishes with.
+	ErrCanceled = errors.New("job canceled")
+)
+
+// Job is a row of the jobs table as returned by the API.
+type Job struct {
+	ID         string          `json:"id"`
+	Kind       string          `json:"kind"`
+	Title      string          `json:"title"`
+	Status     string          `json:"status"`
+	Progress   int             `json:"progress"`
+	Message    string          `json:"message,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Params     json.RawMessage `json:"params,omitempty"`
+	Owner      string          `json:"owner"`
+	StartedAt  time.Time       `json:"started_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	FinishedAt time.Time       `json:"finished_at,omitzero"`
+	DurationMs int64           `json:"duration_ms"`
+	Cancelable bool            `json:"cancelable"` // running here with a cancel function
+	Retryable  bool            `json:"retryable"`
+}
+
+// Filter selects the jobs List returns. Empty fields match everything.
+type Filter struct {
+	Kind     string
+	Status   string
+	Page     int
+	PageSize int
+}
+
+// RetryFunc runs a job again from the params it was started with.
+type RetryFunc func(params json.RawMessage) error
+
+// Registry records the jobs of this instance. A nil Registry records
+// nothing and hands out nil Handles, which are safe to use.
+type Registry struct {
+	db     *sql.DB // write connection
+	readDB *sql.DB
+	owner  string
+
+	mu     sync.Mutex
+	active map[string]*Handle
+	retry  map[string]RetryFunc
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewRegistry creates a registry whose jobs are recorded as run by owner,
+// normally the lease owner name of this instance.
+func NewRegistry(readDB, writeDB *sql.DB, owner string) *Registry {
+	return &Registry{
+		db:     writeDB,
+		readDB: readDB,
+		owner:  owner,
+		active: make(map[string]*Handle),
+		retry:  make(map[string]RetryFunc),
+		stop:   make(chan struct{}),
+	}
+}
+
+// OnRetry registers how jobs of kind are retried.
+func (r *Registry) OnRetry(kind string, fn RetryFunc) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.retry[kind] = fn
+	r.mu.Unlock()
+}
+
+// Start launches the loop writing progress and heartbeats of running jobs,
+// marking abandoned jobs failed and pruning old ones.
+func (r *Registry) Start() {
+	r.wg.Add(1)
+	go r.loop()
+}
+
+// Stop ends the loop after a last progress write.
+func (r *Registry) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+	r.wg.Wait()
+}
+
+func (r *Registry) loop() {
+	defer r.wg.Done()
+	r.markAbandoned()
+	r.prune()
+	flush := time.NewTicker(flushEvery)
+	defer flush.Stop()
+	lastBeat, lastPrune := time.Now(), time.Now()
+	for {
+		select {
+		case <-r.stop:
+			r.flush(false)
+			return
+		case <-flush.C:
+		}
+		beat := time.Since(lastBeat) >= heartbeatEvery
+		r.flush(beat)
+		if beat {
+			lastBeat = time.Now()
+			r.markAbandoned()
+		}
+		if time.Since(lastPrune) >= time.Hour {
+			lastPrune = time.Now()
+			r.prune()
+		}
+	}
+}
+
+// flush writes the progress of running jobs that changed, or of all of
+// them when beat is set.
+func (r *Registry) flush(beat bool) {
+	r.mu.Lock()
+	handles := make([]*Handle, 0, len(r.active))
+	for _, h := range r.active {
+		handles = append(handles, h)
+	}
+	r.mu.Unlock()
+	now := formatTime(time.Now())
+	for _, h := range handles {
+		h.mu.Lock()
+		dirty, progress, message := h.dirty, h.progress, h.message
+		h.dirty = false
+		h.mu.Unlock()
+		if !dirty && !beat {
+			continue
+		}
+		if _, err := r.db.Exec(`UPDATE jobs SET progress = ?, message = ?, updated_at = ? WHERE id = ? AND status = ?`,
+			progress, message, now, h.id, StatusRunning); err != nil {
+			log.Printf("[Jobs] failed to update job %s: %v", h.id, err)
+		}
+	}
+}
+
+// markAbandoned marks failed the running jobs whose instance stopped
+// refreshing them.
+func (r *Registry) markAbandoned() {
+	res, err := r.db.Exec(`UPDATE jobs SET status = ?, error = ?, finished_at = updated_at
+		WHERE status = ? AND updated_at < ?`,
+		StatusFailed, "instance stopped while the job was running", StatusRunning, formatTime(time.Now().Add(-staleAfter)))
+	if err != nil {
+		log.Printf("[Jobs] failed to mark abandoned jobs: %v", err)
+	} else if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("[Jobs] marked %d abandoned job(s) failed", n)
+	}
+}
+
+// prune deletes finished jobs older than keepFor.
+func (r *Registry) prune() {
+	if _, err := r.db.Exec(`DELETE FROM jobs WHERE status <> ? AND started_at < ?`,
+		StatusRunning, formatTime(time.Now().Add(-keepFor))); err != nil {
+		log.Printf("[Jobs] failed to prune jobs: %v", err)
+	}
+}
+
+// Handle is a running job. Report progress with Progress and end it with
+// Finish. All methods are safe on a nil Handle.
+type Handle struct {
+	r      *Registry
+	id     string
+	cancel func()
+
+	mu        sync.Mutex
+	progress  int
+	message   string
+	dirty     bool
+	canceling bool
+}
+
+// Begin records a job of kind that started now. params, marshaled to JSON,
+// are what the retry function of kind needs; cancel, if not nil, stops the
+// job early, which must then Finish.
+func (r *Registry) Begin(kind, title string, params interface{}, cancel func()) *Handle {
+	if r == nil {
+		return nil
+	}
+	id, err := generateID()
+	if err != nil {
+		log.Printf("[Jobs] failed to record %s job: %v", kind, err)
+		return nil
+	}
+	data := []byte("{}")
+	if params != nil {
+		if data, err = json.Marshal(params); err != nil {
+			log.Printf("[Jobs] failed to record %s job: %v", kind, err)
+			return nil
+		}
+	}
+	now := formatTime(time.Now())
+	if _, err := r.db.Exec(`INSERT INTO jobs (id, kind, title, status, params, owner, started_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, kind, title, StatusRunning, string(data), r.owner, now, now); err != nil {
+		log.Printf("[Jobs] failed to record %s job: %v", kind, err)
+		return nil
+	}
+	h := &Handle{r: r, id: id, cancel: cancel}
+	r.mu.Lock()
+	r.active[id] = h
+	r.mu.Unlock()
+	return h
+}
+
+// ID returns the job ID, "" for a nil Handle.
+func (h *Handle) ID() string {
+	if h == nil {
+		return ""
+	}
+	return h.id
+}
+
+// Progress records how far the job got, in percent, and what it is doing.
+// It is written to the database shortly after, so it may be called often.
+func (h *Handle) Progress(percent int, message string) {
+	if h == nil {
+		return
+	}
+	percent = min(max(percent, 0), 100)
+	h.mu.Lock()
+	if percent != h.progress || message != h.message {
+		h.progress, h.message, h.dirty = percent, message, true
+	}
+	h.mu.Unlock()
+}
+
+// Finish records the end of the job: succeeded when err is nil, canceled
+// when it was canceled or err wraps ErrCanceled, failed otherwise.
+func (h *Handle) Finish(err error) {
+	if h == nil {
+		return
+	}
+	r := h.r
+	r.mu.Lock()
+	if r.active[h.id] != h {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.active, h.id)
+	r.mu.Unlock()
+
+	h.mu.Lock()
+	progress, message, canceling := h.progress, h.message, h.canceling
+	h.mu.Unlock()
+	status, errMsg := StatusSucceeded, ""
+	switch {
+	case err == nil:
+		progress = 100
+	case canceling || errors.Is(err, ErrCanceled):
+		status, errMsg = StatusCanceled, err.Error()
+	default:
+		status, errMsg = StatusFailed, err.Error()
+	}
+	now := formatTime(time.Now())
+	if _, dbErr := r.db.Exec(`UPDATE jobs SET status = ?, progress = ?, message = ?, error = ?, updated_at = ?, finished_at = ? WHERE id = ?`,
+		status, progress, message, errMsg, now, now, h.id); dbErr != nil {
+		log.Printf("[Jobs] failed to finish job %s: %v", h.id, dbErr)
+	}
+}
+
+// canceledError is an error that counts as ErrCanceled but keeps its message.
+type canceledError struct{ error }
+
+func (e canceledError) Is(target error) bool { return target == ErrCanceled }
+
+// Canceled marks err as the error of a canceled job, for Finish.
+func Canceled(err error) error {
+	return canceledError{err}
+}
+
+// Cancel stops a job running on this instance.
+func (r *Registry) Cancel(id string) error {
+	r.mu.Lock()
+	h := r.active[id]
+	r.mu.Unlock()
+	if h == nil {
+		job, err := r.Get(id)
+		if err != nil {
+			return err
+		}
+		if job.Status == StatusRunning {
+			return ErrRemote
+		}
+		return ErrNotRunning
+	}
+	if h.cancel == nil {
+		return ErrNotCancelable
+	}
+	h.mu.Lock()
+	h.canceling = true
+	h.mu.Unlock()
+	h.cancel()
+	log.Printf("[Jobs] canceled job %s", id)
+	return nil
+}
+
+// Retry runs a failed or canceled job again. The new run is a new job.
+func (r *Registry) Retry(id string) error {
+	job, err := r.Get(id)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	fn := r.retry[job.Kind]
+	r.mu.Unlock()
+	if fn == nil || (job.Status != StatusFailed && job.Status != StatusCanceled) {
+		return ErrNotRetryable
+	}
+	if err := fn(job.Params); err != nil {
+		return err
+	}
+	log.Printf("[Jobs] retried job %s (%s)", id, job.Kind)
+	return nil
+}
+
+// Get returns one job.
+func (r *Registry) Get(id string) (*Job, error) {
+	rows, err := r.readDB.Query(`SELECT `+jobColumns+` FROM jobs WHERE id = ?`, id)
+	if err != nil {
+		return nil, fmt.Errorf("get job: %w", err)
+	}
+	jobs, err := r.scan(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, ErrNotFound
+	}
+	return &jobs[0], nil
+}
+
+// List returns the jobs matching f, newest first, and how many match.
+func (r *Registry) List(f Filter) ([]Job, int, error) {
+	var where []string
+	var args []interface{}
+	if f.Kind != "" {
+		where = append(where, "kind = ?")
+		args = append(args, f.Kind)
+	}
+	if f.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, f.Status)
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+	var total int
+	if err := r.readDB.QueryRow(`SELECT COUNT(*) FROM jobs`+cond, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count jobs: %w", err)
+	}
+	if f.PageSize <= 0 || f.PageSize > 200 {
+		f.PageSize = 50
+	}
+	if f.Page <= 0 {
+		f.Page = 1
+	}
+	rows, err := r.readDB.Query(`SELECT `+jobColumns+` FROM jobs`+cond+` ORDER BY started_at DESC, id LIMIT ? OFFSET ?`,
+		append(args, f.PageSize, (f.Page-1)*f.PageSize)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list jobs: %w", err)
+	}
+	jobs, err := r.scan(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return jobs, total, nil
+}
+
+const jobColumns = `id, kind, title, status, progress, message, error, params, owner, started_at, updated_at, finished_at`
+
+// scan reads job rows, overlaying the latest progress of the jobs running
+// on this instance.
+func (r *Registry) scan(rows *sql.Rows) ([]Job, error) {
+	defer rows.Close()
+	jobs := []Job{}
+	for rows.Next() {
+		var j Job
+		var params, started, updated, finished string
+		if err := rows.Scan(&j.ID, &j.Kind, &j.Title, &j.Status, &j.Progress, &j.Message, &j.Error,
+			&params, &j.Owner, &started, &updated, &finished); err != nil {
+			return nil, fmt.Errorf("scan job: %w", err)
+		}
+		j.Params = json.RawMessage(params)
+		j.StartedAt = parseTime(started)
+		j.UpdatedAt = parseTime(updated)
+		end := time.Now()
+		if finished != "" {
+			j.FinishedAt = parseTime(finished)
+			end = j.FinishedAt
+		}
+		j.DurationMs = end.Sub(j.StartedAt).Milliseconds()
+		jobs = append(jobs, j)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range jobs {
+		j := &jobs[i]
+		if h := r.active[j.ID]; h != nil {
+			h.mu.Lock()
+			j.Progress, j.Message = h.progress, h.message
+			h.mu.Unlock()
+			j.Cancelable = h.cancel != nil
+		}
+		j.Retryable = r.retry[j.Kind] != nil && (j.Status == StatusFailed || j.Status == StatusCanceled)
+	}
+	return jobs, nil
+}
+
+// timeLayout is a fixed-width UTC layout with milliseconds, which compares
+// correctly as text.
+const timeLayout = "2006-01-02T15:04:05.000Z"
+
+// formatTime formats t in timeLayout.
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeLayout)
+}
+
+// parseTime parses a time stored by formatTime.
+func parseTime(s string) time.Time {
+	t, _ := time.Parse(timeLayout, s)
+	return t
+}
+
+// generateID creates a random UUID-like hex string.
+func generateID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,201 @@
+// Package lease keeps background jobs from running on more than one
+// instance at a time when several instances share a database. A job takes
+// the lease named after it before running; the lease is a row of the
+// job_leases table holding the owner instance and an expiry that the
+// holder keeps pushing forward while the job runs, so the lease of an
+// instance that died frees itself. Scheduled runs also record the time
+// they were scheduled for, so that an instance waking up for the same
+// schedule after another finished does not run the job again.
+package lease
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Lease timing: a held lease expires TTL after its last renewal, and is
+// renewed every RenewEvery.
+const (
+	TTL        = 2 * time.Minute
+	RenewEvery = 30 * time.Second
+)
+
+// ErrHeld is returned by Acquire when another instance holds the lease.
+var ErrHeld = errors.New("job is running on another instance")
+
+// ErrTaken is returned by Acquire for a scheduled run another instance
+// already made.
+var ErrTaken = errors.New("scheduled run already made by another instance")
+
+// Info describes a lease for /api/admin/locks.
+type Info struct {
+	Name       string    `json:"name"`
+	Owner      string    `json:"owner"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Slot       time.Time `json:"slot,omitzero"` // scheduled time of the last scheduled run
+	Held       bool      `json:"held"`          // not yet expired
+	Local      bool      `json:"local"`         // owned by this instance
+}
+
+// Manager takes leases on behalf of this instance. A nil Manager hands out
+// leases without locking, for single-instance setups and tests.
+type Manager struct {
+	db     *sql.DB // write connection
+	readDB *sql.DB
+	owner  string
+}
+
+// NewManager returns a manager whose leases are owned by this process,
+// identified by host name, process ID and a random suffix.
+func NewManager(readDB, writeDB *sql.DB) *Manager {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	b := make([]byte, 4)
+	rand.Read(b)
+	return &Manager{db: writeDB, readDB: readDB, owner: fmt.Sprintf("%s:%d:%s", host, os.Getpid(), hex.EncodeToString(b))}
+}
+
+// Owner returns the owner name of this instance's leases.
+func (m *Manager) Owner() string {
+	if m == nil {
+		return ""
+	}
+	return m.owner
+}
+
+// Lease is a held lease. Release it when the job is done.
+type Lease struct {
+	m    *Manager
+	name string
+
+	stop chan struct{}
+	once sync.Once
+	done chan struct{}
+}
+
+// Acquire takes the lease name and keeps renewing it until released. slot
+// is the time a scheduled run was scheduled for, zero for runs started on
+// demand. It fails with ErrHeld while another instance holds the lease and,
+// for a scheduled run, with ErrTaken when the slot was already run.
+func (m *Manager) Acquire(name string, slot time.Time) (*Lease, error) {
+	if m == nil {
+		return &Lease{}, nil
+	}
+	now := time.Now().UTC()
+	slotStr := ""
+	if !slot.IsZero() {
+		slotStr = formatTime(slot)
+	}
+	res, err := m.db.Exec(`INSERT INTO job_leases (name, owner, acquired_at, expires_at, slot)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			owner = excluded.owner,
+			acquired_at = excluded.acquired_at,
+			expires_at = excluded.expires_at,
+			slot = CASE WHEN excluded.slot = '' THEN job_leases.slot ELSE excluded.slot END
+		WHERE job_leases.expires_at <= ? AND (excluded.slot = '' OR job_leases.slot <> excluded.slot)`,
+		name, m.owner, formatTime(now), formatTime(now.Add(TTL)), slotStr, formatTime(now))
+	if err != nil {
+		return nil, fmt.Errorf("acquire lease %s: %w", name, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var owner, expires, last string
+		if err := m.db.QueryRow(`SELECT owner, expires_at, slot FROM job_leases WHERE name = ?`, name).Scan(&owner, &expires, &last); err != nil {
+			return nil, fmt.Errorf("%w (lease %s)", ErrHeld, name)
+		}
+		if expires > formatTime(now) {
+			return nil, fmt.Errorf("%w: %s", ErrHeld, owner)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrTaken, owner)
+	}
+	l := &Lease{m: m, name: name, stop: make(chan struct{}), done: make(chan struct{})}
+	go l.renew()
+	return l, nil
+}
+
+// renew pushes the expiry forward until the lease is released.
+func (l *Lease) renew() {
+	defer close(l.done)
+	ticker := time.NewTicker(RenewEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now().UTC()
+		res, err := l.m.db.Exec(`UPDATE job_leases SET expires_at = ? WHERE name = ? AND owner = ?`,
+			formatTime(now.Add(TTL)), l.name, l.m.owner)
+		if err != nil {
+			log.Printf("[Lease] failed to renew %s: %v", l.name, err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			log.Printf("[Lease] lost %s to another instance", l.name)
+			return
+		}
+	}
+}
+
+// Release frees the lease. It is safe to call more than once.
+func (l *Lease) Release() {
+	if l == nil || l.m == nil {
+		return
+	}
+	l.once.Do(func() {
+		close(l.stop)
+		<-l.done
+		if _, err := l.m.db.Exec(`UPDATE job_leases SET expires_at = ? WHERE name = ? AND owner = ?`,
+			formatTime(time.Now().UTC()), l.name, l.m.owner); err != nil {
+			log.Printf("[Lease] failed to release %s: %v", l.name, err)
+		}
+	})
+}
+
+// List returns all leases, held ones first.
+func (m *Manager) List() ([]Info, error) {
+	if m == nil {
+		return []Info{}, nil
+	}
+	rows, err := m.readDB.Query(`SELECT name, owner, acquired_at, expires_at, slot FROM job_leases
+		ORDER BY expires_at > ? DESC, name`, formatTime(time.Now().UTC()))
+	if err != nil {
+		return nil, fmt.Errorf("list leases: %w", err)
+	}
+	defer rows.Close()
+	now := time.Now()
+	out := []Info{}
+	for rows.Next() {
+		var info Info
+		var acquired, expires, slot string
+		if err := rows.Scan(&info.Name, &info.Owner, &acquired, &expires, &slot); err != nil {
+			return nil, fmt.Errorf("scan lease: %w", err)
+		}
+		info.AcquiredAt, _ = time.Parse(time.RFC3339, acquired)
+		info.ExpiresAt, _ = time.Parse(time.RFC3339, expires)
+		if slot != "" {
+			info.Slot, _ = time.Parse(time.RFC3339, slot)
+		}
+		info.Held = info.ExpiresAt.After(now)
+		info.Local = info.Owner == m.owner
+		out = append(out, info)
+	}
+	return out, rows.Err()
+}
+
+// formatTime formats t in the fixed-width form stored in job_leases, which
+// compares correctly as text.
+func formatTime(t time.Time) string {
+	return t.UTC().Truncate(time.Second).Format(time.RFC3339)
+}
@@ -4,21 +4,25 @@ package llm
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"askflow/internal/errlog"
 )
 
-// LLMService defines the interface for LLM text generation.
+// LLMService defines the interface for LLM text generation. chunks are the
+// reference passages given to the model. Calls return ctx's error once ctx is
+// done, abandoning the request in flight.
 type LLMService interface {
-	Generate(prompt string, context []string, question string) (string, error)
-	GenerateWithImage(prompt string, context []string, question string, imageDataURL string) (string, error)
+	Generate(ctx context.Context, prompt string, chunks []string, question string) (string, error)
+	GenerateWithImage(ctx context.Context, prompt string, chunks []string, question string, imageDataURL string) (string, error)
 }
 
 // APILLMService implements LLMService using an OpenAI-compatible Chat Completion API.
@@ -64,6 +68,10 @@ type chatMessage struct {
 	Content interface{} `json:"content"`
 }
 
+// Message is a chat message as BuildMessages returns it, for callers that
+// inspect the exact prompt sent to the API.
+type Message = chatMessage
+
 // visionContentPart represents a content part in a multimodal message.
 type visionContentPart struct {
 	Type     string          `json:"type"`
@@ -79,9 +87,16 @@ type visionImageURL struct {
 // chatResponse is the response body from the chat completion API.
 type chatResponse struct {
 	Choices []chatChoice `json:"choices"`
+	Usage   *Usage       `json:"usage,omitempty"`
 	Error   *apiError    `json:"error,omitempty"`
 }
 
+// Usage is the token consumption reported by the chat completion API.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
 // chatChoice represents a single choice in the chat completion response.
 type chatChoice struct {
 	Message chatChoiceMessage `json:"message"`
@@ -112,11 +127,8 @@ func BuildMessages(prompt string, context []string, question string) []chatMessa
 
 	var userParts []string
 	if len(context) > 0 {
-		userParts = append(userParts, "参考资料：")
-		for i, chunk := range context {
-			userParts = append(userParts, fmt.Sprintf("[%d] %s", i+1, chunk))
-		}
-		userParts = append(userParts, "")
+		systemContent += contextGuard
+		userParts = append(userParts, formatContext(context))
 	}
 	userParts = append(userParts, "用户问题："+question)
 
@@ -128,10 +140,17 @@ func BuildMessages(prompt string, context []string, question string) []chatMessa
 
 // Generate sends a prompt with context and question to the LLM and returns the generated answer.
 // It retries up to 3 times with exponential backoff on transient failures (network errors, 429, 5xx).
-func (s *APILLMService) Generate(prompt string, context []string, question string) (string, error) {
-	messages := BuildMessages(prompt, context, question)
+func (s *APILLMService) Generate(ctx context.Context, prompt string, chunks []string, question string) (string, error) {
+	return s.generate(ctx, prompt, chunks, question, nil)
+}
+
+func (s *APILLMService) generate(ctx context.Context, prompt string, chunks []string, question string, record func(Usage)) (string, error) {
+	messages := BuildMessages(prompt, chunks, question)
 
-	answer, err := s.callAPIWithRetry(messages)
+	answer, err := s.callAPIWithRetry(ctx, messages, record)
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
 	if err != nil {
 		return "服务暂时不可用，请稍后重试", fmt.Errorf("LLM API failed after retries: %w", err)
 	}
@@ -139,22 +158,33 @@ func (s *APILLMService) Generate(prompt string, context []string, question strin
 }
 
 // callAPIWithRetry calls the LLM API with retry and exponential backoff for transient errors.
-func (s *APILLMService) callAPIWithRetry(messages []chatMessage) (string, error) {
+// record, if non-nil, receives the token usage of the successful call.
+// Retries stop once ctx is done.
+func (s *APILLMService) callAPIWithRetry(ctx context.Context, messages []chatMessage, record func(Usage)) (string, error) {
 	const maxRetries = 3
 	var lastErr error
 	for attempt := 0; attempt < maxRetries; attempt++ {
 		if attempt > 0 {
 			backoff := time.Duration(attempt) * 5 * time.Second
 			log.Printf("[LLM] retrying (attempt %d/%d) after %v", attempt+1, maxRetries, backoff)
-			time.Sleep(backoff)
+			t := time.NewTimer(backoff)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return "", ctx.Err()
+			}
 		}
 
-		answer, err, retryable := s.callAPI(messages)
+		answer, usage, err, retryable := s.callAPI(ctx, messages)
 		if err == nil {
+			if record != nil && usage != nil {
+				record(*usage)
+			}
 			return answer, nil
 		}
 		lastErr = err
-		if !retryable {
+		if !retryable || ctx.Err() != nil {
 			return "", err
 		}
 		log.Printf("[LLM] attempt %d/%d failed (retryable): %v", attempt+1, maxRetries, err)
@@ -165,8 +195,8 @@ func (s *APILLMService) callAPIWithRetry(messages []chatMessage) (string, error)
 }
 
 // callAPI sends the chat completion request to the API and returns the generated text.
-// The third return value indicates whether the error is retryable (network/server errors).
-func (s *APILLMService) callAPI(messages []chatMessage) (string, error, bool) {
+// The last return value indicates whether the error is retryable (network/server errors).
+func (s *APILLMService) callAPI(ctx context.Context, messages []chatMessage) (string, *Usage, error, bool) {
 	reqBody := chatRequest{
 		Model:       s.ModelName,
 		Messages:    messages,
@@ -175,13 +205,13 @@ func (s *APILLMService) callAPI(messages []chatMessage) (string, error, bool) {
 	}
 	bodyBytes, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err), false
+		return "", nil, fmt.Errorf("failed to marshal request: %w", err), false
 	}
 
 	url := strings.TrimRight(s.Endpoint, "/") + "/chat/completions"
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(bodyBytes))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(bodyBytes))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err), false
+		return "", nil, fmt.Errorf("failed to create request: %w", err), false
 	}
 	req.Header.Set("Content-Type", "application/json")
 	if s.APIKey != "" {
@@ -190,39 +220,39 @@ func (s *APILLMService) callAPI(messages []chatMessage) (string, error, bool) {
 
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("LLM API request failed: %w", err), true // network error, retryable
+		return "", nil, fmt.Errorf("LLM API request failed: %w", err), true // network error, retryable
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20)) // 10MB max response
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err), true
+		return "", nil, fmt.Errorf("failed to read response body: %w", err), true
 	}
 
 	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
-		return "", fmt.Errorf("LLM API error (HTTP %d): %s", resp.StatusCode, string(respBody)), true
+		return "", nil, fmt.Errorf("LLM API error (HTTP %d): %s", resp.StatusCode, string(respBody)), true
 	}
 
 	if resp.StatusCode != http.StatusOK {
 		var errResp chatResponse
 		if json.Unmarshal(respBody, &errResp) == nil && errResp.Error != nil {
-			return "", fmt.Errorf("LLM API error (HTTP %d): %s", resp.StatusCode, errResp.Error.Message), false
+			return "", nil, fmt.Errorf("LLM API error (HTTP %d): %s", resp.StatusCode, errResp.Error.Message), false
 		}
-		return "", fmt.Errorf("LLM API error (HTTP %d): %s", resp.StatusCode, string(respBody)), false
+		return "", nil, fmt.Errorf("LLM API error (HTTP %d): %s", resp.StatusCode, string(respBody)), false
 	}
 
 	var result chatResponse
 	if err := json.Unmarshal(respBody, &result); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err), false
+		return "", nil, fmt.Errorf("failed to decode response: %w", err), false
 	}
 	if result.Error != nil {
-		return "", fmt.Errorf("LLM API error: %s", result.Error.Message), false
+		return "", nil, fmt.Errorf("LLM API error: %s", result.Error.Message), false
 	}
 	if len(result.Choices) == 0 {
-		return "", fmt.Errorf("LLM API returned no choices"), false
+		return "", nil, fmt.Errorf("LLM API returned no choices"), false
 	}
 
-	return result.Choices[0].Message.Content, nil, false
+	return result.Choices[0].Message.Content, result.Usage, nil, false
 }
 
 // BuildMessagesWithImage constructs chat messages that include an image for vision-capable LLMs.
@@ -238,11 +268,8 @@ func BuildMessagesWithImage(prompt string, context []string, question string, im
 
 	var textParts []string
 	if len(context) > 0 {
-		textParts = append(textParts, "参考资料：")
-		for i, chunk := range context {
-			textParts = append(textParts, fmt.Sprintf("[%d] %s", i+1, chunk))
-		}
-		textParts = append(textParts, "")
+		systemContent += contextGuard
+		textParts = append(textParts, formatContext(context))
 	}
 	textParts = append(textParts, "用户问题："+question)
 
@@ -260,16 +287,76 @@ func BuildMessagesWithImage(prompt string, context []string, question string, im
 // GenerateWithImage sends a prompt with context, question, and an image to a vision-capable LLM.
 // The imageDataURL should be a base64 data URL (e.g., "data:image/png;base64,...").
 // Falls back to text-only Generate if the image is empty.
-func (s *APILLMService) GenerateWithImage(prompt string, context []string, question string, imageDataURL string) (string, error) {
+func (s *APILLMService) GenerateWithImage(ctx context.Context, prompt string, chunks []string, question string, imageDataURL string) (string, error) {
+	return s.generateWithImage(ctx, prompt, chunks, question, imageDataURL, nil)
+}
+
+func (s *APILLMService) generateWithImage(ctx context.Context, prompt string, chunks []string, question string, imageDataURL string, record func(Usage)) (string, error) {
 	if imageDataURL == "" {
-		return s.Generate(prompt, context, question)
+		return s.generate(ctx, prompt, chunks, question, record)
 	}
 
-	messages := BuildMessagesWithImage(prompt, context, question, imageDataURL)
+	messages := BuildMessagesWithImage(prompt, chunks, question, imageDataURL)
 
-	answer, err := s.callAPIWithRetry(messages)
+	answer, err := s.callAPIWithRetry(ctx, messages, record)
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
 	if err != nil {
 		return "", fmt.Errorf("LLM vision API failed: %w", err)
 	}
 	return answer, nil
 }
+
+// Metered returns an LLMService that forwards to s and adds the token usage
+// reported by the API to u. Services that do not report usage are returned
+// unchanged. The returned service is safe for concurrent use.
+func Metered(s LLMService, u *Usage) LLMService {
+	api, ok := s.(*APILLMService)
+	if !ok || u == nil {
+		return s
+	}
+	return &meteredService{api: api, mu: new(sync.Mutex), usage: u}
+}
+
+type meteredService struct {
+	api   *APILLMService
+	mu    *sync.Mutex // shared with the services WithModel derives
+	usage *Usage
+}
+
+func (m *meteredService) record(u Usage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.usage.PromptTokens += u.PromptTokens
+	m.usage.CompletionTokens += u.CompletionTokens
+}
+
+func (m *meteredService) Generate(ctx context.Context, prompt string, chunks []string, question string) (string, error) {
+	return m.api.generate(ctx, prompt, chunks, question, m.record)
+}
+
+func (m *meteredService) GenerateWithImage(ctx context.Context, prompt string, chunks []string, question string, imageDataURL string) (string, error) {
+	return m.api.generateWithImage(ctx, prompt, chunks, question, imageDataURL, m.record)
+}
+
+// WithModel returns s calling model instead of its configured model, on the
+// same endpoint and with the same metering, e.g. for a cheaper model doing
+// auxiliary work. Services that cannot switch models are returned unchanged;
+// wrappers can support it by implementing WithModel(string) LLMService.
+func WithModel(s LLMService, model string) LLMService {
+	if model == "" {
+		return s
+	}
+	switch v := s.(type) {
+	case *APILLMService:
+		c := *v
+		c.ModelName = model
+		return &c
+	case *meteredService:
+		return &meteredService{api: WithModel(v.api, model).(*APILLMService), mu: v.mu, usage: v.usage}
+	case interface{ WithModel(string) LLMService }:
+		return v.WithModel(model)
+	}
+	return s
+}
@@ -0,0 +1,355 @@
+// Package maintenance runs the database upkeep that deletions make
+// necessary: SQLite keeps the pages of deleted rows as free pages, indexes
+// fragment, and the in-memory vector cache keeps the capacity it grew to.
+// A run returns the free pages to the file system with incremental VACUUM,
+// rebuilds the indexes, refreshes the query planner statistics, compacts
+// the vector cache and reports the space reclaimed. Runs are scheduled by
+// maintenance.* in config or started from the admin API.
+package maintenance
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"askflow/internal/config"
+	"askflow/internal/cron"
+	"askflow/internal/jobs"
+	"askflow/internal/lease"
+	"askflow/internal/vectorstore"
+)
+
+// ErrRunning is returned by Trigger while another run is in progress.
+var ErrRunning = errors.New("maintenance already running")
+
+// VectorCache is the in-memory vector cache a run compacts.
+type VectorCache interface {
+	Compact() (vectorstore.CompactResult, error)
+}
+
+// DBStats describes the space use of the database file.
+type DBStats struct {
+	PageSize   int64  `json:"page_size"`
+	Pages      int64  `json:"pages"`
+	FreePages  int64  `json:"free_pages"`
+	Bytes      int64  `json:"bytes"`       // Pages * PageSize
+	AutoVacuum string `json:"auto_vacuum"` // "none", "full" or "incremental"
+}
+
+// RunInfo records the outcome of one scheduled or on-demand run.
+type RunInfo struct {
+	Trigger    string    `json:"trigger"` // "schedule" or "manual"
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+	Before     DBStats   `json:"before"`
+	After      DBStats   `json:"after"`
+	// Reclaimed is the bytes the database file shrank by.
+	Reclaimed int64 `json:"reclaimed"`
+	// Converted is set when the run switched the database to incremental
+	// auto-vacuum, which takes a full VACUUM.
+	Converted   bool                       `json:"converted,omitempty"`
+	VectorCache *vectorstore.CompactResult `json:"vector_cache,omitempty"`
+	Error       string                     `json:"error,omitempty"`
+}
+
+// Status is the scheduler state reported by /api/admin/maintenance.
+type Status struct {
+	Enabled  bool      `json:"enabled"`
+	Schedule string    `json:"schedule"`
+	Running  bool      `json:"running"`
+	NextRun  time.Time `json:"next_run,omitzero"`
+	LastRun  *RunInfo  `json:"last_run,omitempty"`
+	Database *DBStats  `json:"database,omitempty"`
+}
+
+// Scheduler runs maintenance on the configured cron schedule. The config is
+// re-read every minute, so schedule changes take effect without a restart.
+// At most one run happens at a time.
+type Scheduler struct {
+	db     *sql.DB // write connection
+	readDB *sql.DB
+	cache  VectorCache
+	cfg    func() config.MaintenanceConfig
+	locks  *lease.Manager
+	jobs   *jobs.Registry
+
+	mu      sync.Mutex
+	running bool
+	last    *RunInfo
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewScheduler creates a maintenance scheduler. cache may be nil; cfg
+// returns the current maintenance settings.
+func NewScheduler(readDB, writeDB *sql.DB, cache VectorCache, cfg func() config.MaintenanceConfig) *Scheduler {
+	return &Scheduler{db: writeDB, readDB: readDB, cache: cache, cfg: cfg, stop: make(chan struct{})}
+}
+
+// SetLocks makes runs take the "maintenance" lease first, so that
+// instances sharing the database do not maintain it at the same time or
+// twice for one scheduled time. Call it before Start.
+func (s *Scheduler) SetLocks(m *lease.Manager) {
+	s.locks = m
+}
+
+// SetJobs records runs as jobs in r, which can be canceled and are retried
+// as manual runs. Call it before Start.
+func (s *Scheduler) SetJobs(r *jobs.Registry) {
+	s.jobs = r
+	r.OnRetry(jobs.KindMaintenance, func(json.RawMessage) error {
+		return s.Trigger("manual")
+	})
+}
+
+// Start launches the scheduling loop.
+func (s *Scheduler) Start() {
+	s.wg.Add(1)
+	go s.loop()
+}
+
+// Stop ends the scheduling loop and waits until ctx is done for a running
+// maintenance to finish.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Scheduler) loop() {
+	defer s.wg.Done()
+	lastErr := ""
+	for {
+		next := time.Now().Truncate(time.Minute).Add(time.Minute)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		cfg := s.cfg()
+		if !cfg.Enabled {
+			continue
+		}
+		sched, err := cron.Parse(cfg.Schedule)
+		if err != nil {
+			if err.Error() != lastErr {
+				log.Printf("[Maintenance] invalid schedule %q: %v", cfg.Schedule, err)
+				lastErr = err.Error()
+			}
+			continue
+		}
+		lastErr = ""
+		if !sched.Match(next) {
+			continue
+		}
+		if err := s.trigger("schedule", next); err != nil {
+			log.Printf("[Maintenance] scheduled run skipped: %v", err)
+		}
+	}
+}
+
+// Trigger starts a run in the background; trigger labels it ("schedule"
+// or "manual").
+func (s *Scheduler) Trigger(trigger string) error {
+	return s.trigger(trigger, time.Time{})
+}
+
+// trigger is Trigger for the run scheduled at slot, zero for manual runs.
+func (s *Scheduler) trigger(trigger string, slot time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return ErrRunning
+	}
+	select {
+	case <-s.stop:
+		return errors.New("maintenance scheduler stopped")
+	default:
+	}
+	l, err := s.locks.Acquire("maintenance", slot)
+	if err != nil {
+		return err
+	}
+	s.running = true
+	s.wg.Add(1)
+	go s.run(trigger, l)
+	return nil
+}
+
+func (s *Scheduler) run(trigger string, l *lease.Lease) {
+	defer s.wg.Done()
+	defer l.Release()
+	info := &RunInfo{Trigger: trigger, StartedAt: time.Now()}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	job := s.jobs.Begin(jobs.KindMaintenance, fmt.Sprintf("database maintenance (%s)", trigger), nil, cancel)
+	defer func() {
+		if r := recover(); r != nil {
+			info.Error = fmt.Sprintf("panic: %v", r)
+			log.Printf("[Maintenance] panic: %v", r)
+		}
+		info.FinishedAt = time.Now()
+		s.mu.Lock()
+		s.running = false
+		s.last = info
+		s.mu.Unlock()
+		var jobErr error
+		if info.Error != "" {
+			jobErr = errors.New(info.Error)
+		}
+		job.Finish(jobErr)
+	}()
+
+	log.Printf("[Maintenance] starting (%s)", trigger)
+	if err := s.maintain(ctx, info, job); err != nil {
+		info.Error = err.Error()
+		log.Printf("[Maintenance] failed: %v", err)
+		return
+	}
+	// The vector store reads the chunks table through the write connection,
+	// so the cache is compacted once maintain has released it
+	if s.cache != nil {
+		job.Progress(90, "compacting vector cache")
+		res, err := s.cache.Compact()
+		if err != nil {
+			info.Error = fmt.Sprintf("compact vector cache: %v", err)
+			log.Printf("[Maintenance] failed to compact vector cache: %v", err)
+			return
+		}
+		info.VectorCache = &res
+		if res.Dropped > 0 {
+			log.Printf("[Maintenance] dropped %d stale chunks from the vector cache", res.Dropped)
+		}
+	}
+	log.Printf("[Maintenance] done in %s: reclaimed %.2f MB, %d free pages left",
+		time.Since(info.StartedAt).Round(time.Second), float64(info.Reclaimed)/(1024*1024), info.After.FreePages)
+}
+
+// maintain runs the database steps on one connection, so that the
+// auto_vacuum setting and the VACUUM applying it see the same session.
+// Other writers wait for the connection meanwhile. Each step is reported
+// to job.
+func (s *Scheduler) maintain(ctx context.Context, info *RunInfo, job *jobs.Handle) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if info.Before, err = dbStats(ctx, conn); err != nil {
+		return err
+	}
+
+	// Incremental VACUUM needs auto_vacuum=incremental, which an existing
+	// database only takes on with a full VACUUM, done once
+	job.Progress(0, "vacuum")
+	if info.Before.AutoVacuum != "incremental" {
+		log.Printf("[Maintenance] switching to incremental auto-vacuum, running full VACUUM")
+		if _, err := conn.ExecContext(ctx, "PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+			return fmt.Errorf("set auto_vacuum: %w", err)
+		}
+		if _, err := conn.ExecContext(ctx, "VACUUM"); err != nil {
+			return fmt.Errorf("vacuum: %w", err)
+		}
+		info.Converted = true
+	} else if _, err := conn.ExecContext(ctx, "PRAGMA incremental_vacuum"); err != nil {
+		return fmt.Errorf("incremental vacuum: %w", err)
+	}
+
+	job.Progress(50, "reindex")
+	if _, err := conn.ExecContext(ctx, "REINDEX"); err != nil {
+		return fmt.Errorf("reindex: %w", err)
+	}
+	job.Progress(75, "optimize")
+	if _, err := conn.ExecContext(ctx, "PRAGMA optimize"); err != nil {
+		return fmt.Errorf("optimize: %w", err)
+	}
+	// In WAL mode the file only shrinks once the WAL is checkpointed; a
+	// checkpoint blocked by readers is finished by the next one
+	if _, err := conn.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		log.Printf("[Maintenance] checkpoint failed: %v", err)
+	}
+
+	if info.After, err = dbStats(ctx, conn); err != nil {
+		return err
+	}
+	info.Reclaimed = info.Before.Bytes - info.After.Bytes
+	return nil
+}
+
+// queryer is satisfied by *sql.DB and *sql.Conn.
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// dbStats reads the page counts and auto_vacuum mode of the database.
+func dbStats(ctx context.Context, q queryer) (DBStats, error) {
+	var st DBStats
+	var autoVacuum int
+	for _, p := range []struct {
+		pragma string
+		dest   any
+	}{
+		{"page_size", &st.PageSize},
+		{"page_count", &st.Pages},
+		{"freelist_count", &st.FreePages},
+		{"auto_vacuum", &autoVacuum},
+	} {
+		if err := q.QueryRowContext(ctx, "PRAGMA "+p.pragma).Scan(p.dest); err != nil {
+			return st, fmt.Errorf("read %s: %w", p.pragma, err)
+		}
+	}
+	st.Bytes = st.Pages * st.PageSize
+	switch autoVacuum {
+	case 1:
+		st.AutoVacuum = "full"
+	case 2:
+		st.AutoVacuum = "incremental"
+	default:
+		st.AutoVacuum = "none"
+	}
+	return st, nil
+}
+
+// Status returns the scheduler state and the current space use of the
+// database.
+func (s *Scheduler) Status() Status {
+	cfg := s.cfg()
+	st := Status{Enabled: cfg.Enabled, Schedule: cfg.Schedule}
+	if cfg.Enabled {
+		if sched, err := cron.Parse(cfg.Schedule); err == nil {
+			st.NextRun = sched.Next(time.Now())
+		}
+	}
+	s.mu.Lock()
+	st.Running = s.running
+	if s.last != nil {
+		last := *s.last
+		st.LastRun = &last
+	}
+	s.mu.Unlock()
+
+	if db, err := dbStats(context.Background(), s.readDB); err == nil {
+		st.Database = &db
+	}
+	return st
+}
@@ -0,0 +1,298 @@
+// Package markdown turns LLM answers into sanitized, structured blocks.
+// Answers are Markdown of varying quality; Parse splits them into text,
+// heading, list, code, image and table blocks, removes raw HTML and unsafe
+// link targets, and Render produces HTML that is safe to insert as-is.
+package markdown
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Block types.
+const (
+	TypeText    = "text"
+	TypeHeading = "heading"
+	TypeList    = "list"
+	TypeCode    = "code"
+	TypeImage   = "image"
+	TypeTable   = "table"
+)
+
+// Block is one structural element of an answer. Text fields hold inline
+// Markdown (bold, italic, inline code, links) with raw HTML removed and
+// unsafe links reduced to their text.
+type Block struct {
+	Type     string     `json:"type"`
+	Text     string     `json:"text,omitempty"`     // text and heading
+	Level    int        `json:"level,omitempty"`    // heading level 1-6
+	Ordered  bool       `json:"ordered,omitempty"`  // list
+	Start    int        `json:"start,omitempty"`    // first number of an ordered list
+	Items    []ListItem `json:"items,omitempty"`    // list
+	Language string     `json:"language,omitempty"` // code, e.g. "go"; empty when not given
+	Code     string     `json:"code,omitempty"`     // code, verbatim
+	URL      string     `json:"url,omitempty"`      // image
+	Alt      string     `json:"alt,omitempty"`      // image
+	Header   []string   `json:"header,omitempty"`   // table
+	Rows     [][]string `json:"rows,omitempty"`     // table
+}
+
+// ListItem is a list entry with optional nested bullet points.
+type ListItem struct {
+	Text  string   `json:"text"`
+	Items []string `json:"items,omitempty"`
+}
+
+var (
+	fenceRe    = regexp.MustCompile("^\\s*(```+|~~~+)\\s*([\\w+#.-]*)")
+	headingRe  = regexp.MustCompile(`^\s{0,3}(#{1,6})\s+(.*?)(?:\s+#+)?\s*$`)
+	orderedRe  = regexp.MustCompile(`^\s{0,3}(\d{1,9})[.)]\s+(.*)$`)
+	bulletRe   = regexp.MustCompile(`^(\s*)[-*+•]\s+(.*)$`)
+	ruleRe     = regexp.MustCompile(`^\s{0,3}([-*_])(\s*[-*_]){2,}\s*$`)
+	tableSepRe = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+	imageRe    = regexp.MustCompile(`!\[([^\]]*)\]\(\s*<?([^\s)>]+)>?(?:\s+"[^"]*")?\s*\)`)
+	linkRe     = regexp.MustCompile(`\[([^\]]*)\]\(\s*<?([^\s)>]+)>?(?:\s+"[^"]*")?\s*\)`)
+	scriptRe   = regexp.MustCompile(`(?is)<script\b.*?</script\s*>|<style\b.*?</style\s*>`)
+	htmlTagRe  = regexp.MustCompile(`</?[A-Za-z][A-Za-z0-9-]*(\s[^<>]*)?/?>|<!--[\s\S]*?-->`)
+	languageRe = regexp.MustCompile(`^[\w+#.-]{1,32}$`)
+	dataURLRe  = regexp.MustCompile(`^data:image/(?:png|jpeg|gif|webp);base64,[A-Za-z0-9+/]+=*$`)
+)
+
+// Parse splits a Markdown answer into sanitized blocks.
+func Parse(answer string) []Block {
+	p := &parser{lines: strings.Split(strings.ReplaceAll(answer, "\r\n", "\n"), "\n")}
+	p.parse()
+	return p.blocks
+}
+
+type parser struct {
+	lines  []string
+	pos    int
+	blocks []Block
+	para   []string
+}
+
+func (p *parser) parse() {
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			p.flushPara()
+			p.pos++
+		case fenceRe.MatchString(line):
+			p.flushPara()
+			p.parseCode()
+		case headingRe.MatchString(line):
+			p.flushPara()
+			m := headingRe.FindStringSubmatch(line)
+			if text := sanitizeInline(m[2]); text != "" {
+				p.blocks = append(p.blocks, Block{Type: TypeHeading, Level: len(m[1]), Text: text})
+			}
+			p.pos++
+		case ruleRe.MatchString(line):
+			p.flushPara()
+			p.pos++
+		case strings.HasPrefix(trimmed, "|") && p.pos+1 < len(p.lines) && tableSepRe.MatchString(p.lines[p.pos+1]):
+			p.flushPara()
+			p.parseTable()
+		case p.isItem(line, true) || p.isItem(line, false):
+			p.flushPara()
+			p.parseList()
+		default:
+			p.para = append(p.para, strings.TrimPrefix(strings.TrimPrefix(trimmed, ">"), " "))
+			p.pos++
+		}
+	}
+	p.flushPara()
+}
+
+// flushPara emits the collected paragraph lines as text and image blocks.
+func (p *parser) flushPara() {
+	if len(p.para) == 0 {
+		return
+	}
+	text := strings.Join(p.para, "\n")
+	p.para = nil
+	for {
+		loc := imageRe.FindStringSubmatchIndex(text)
+		if loc == nil {
+			break
+		}
+		p.addText(text[:loc[0]])
+		alt, u := text[loc[2]:loc[3]], text[loc[4]:loc[5]]
+		if safeURL(u, false) {
+			p.blocks = append(p.blocks, Block{Type: TypeImage, URL: u, Alt: stripHTML(alt)})
+		} else {
+			p.addText(alt)
+		}
+		text = text[loc[1]:]
+	}
+	p.addText(text)
+}
+
+func (p *parser) addText(s string) {
+	if s = sanitizeInline(s); s != "" {
+		p.blocks = append(p.blocks, Block{Type: TypeText, Text: s})
+	}
+}
+
+// parseCode consumes a fenced code block. An unterminated fence runs to the
+// end of the answer, as LLM output is often cut off.
+func (p *parser) parseCode() {
+	m := fenceRe.FindStringSubmatch(p.lines[p.pos])
+	fence, lang := m[1], m[2]
+	if !languageRe.MatchString(lang) {
+		lang = ""
+	}
+	p.pos++
+	var code []string
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		p.pos++
+		if t := strings.TrimSpace(line); strings.HasPrefix(t, fence) && strings.Trim(t, fence[:1]) == "" {
+			break
+		}
+		code = append(code, line)
+	}
+	p.blocks = append(p.blocks, Block{Type: TypeCode, Language: strings.ToLower(lang), Code: strings.Join(code, "\n")})
+}
+
+func (p *parser) parseTable() {
+	b := Block{Type: TypeTable, Header: splitRow(p.lines[p.pos])}
+	p.pos += 2
+	for p.pos < len(p.lines) && strings.HasPrefix(strings.TrimSpace(p.lines[p.pos]), "|") {
+		row := splitRow(p.lines[p.pos])
+		// Pad or cut rows to the header width
+		for len(row) < len(b.Header) {
+			row = append(row, "")
+		}
+		b.Rows = append(b.Rows, row[:len(b.Header)])
+		p.pos++
+	}
+	p.blocks = append(p.blocks, b)
+}
+
+func splitRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	line = strings.TrimSuffix(line, "|")
+	cells := strings.Split(line, "|")
+	for i, c := range cells {
+		cells[i] = sanitizeInline(c)
+	}
+	return cells
+}
+
+// parseList consumes consecutive list items of one kind. Indented bullets
+// and continuation lines below an item are nested under it, which is how
+// LLMs usually format "1. step" followed by details.
+func (p *parser) parseList() {
+	first := p.lines[p.pos]
+	b := Block{Type: TypeList}
+	if m := orderedRe.FindStringSubmatch(first); m != nil {
+		b.Ordered = true
+		b.Start, _ = strconv.Atoi(m[1])
+	}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if strings.TrimSpace(line) == "" {
+			// A blank line ends the list unless another item follows
+			if p.pos+1 < len(p.lines) && p.isItem(p.lines[p.pos+1], b.Ordered) {
+				p.pos++
+				continue
+			}
+			break
+		}
+		if p.isItem(line, b.Ordered) {
+			var text string
+			if b.Ordered {
+				text = orderedRe.FindStringSubmatch(line)[2]
+			} else {
+				text = bulletRe.FindStringSubmatch(line)[2]
+			}
+			b.Items = append(b.Items, ListItem{Text: sanitizeInline(text)})
+			p.pos++
+			continue
+		}
+		last := &b.Items[len(b.Items)-1]
+		if m := bulletRe.FindStringSubmatch(line); m != nil && (b.Ordered || m[1] != "") {
+			if text := sanitizeInline(m[2]); text != "" {
+				last.Items = append(last.Items, text)
+			}
+		} else if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			if text := sanitizeInline(line); text != "" {
+				if n := len(last.Items); n > 0 {
+					last.Items[n-1] += " " + text
+				} else {
+					last.Text += " " + text
+				}
+			}
+		} else {
+			break
+		}
+		p.pos++
+	}
+	p.blocks = append(p.blocks, b)
+}
+
+// isItem reports whether line starts a new item of an ordered or bullet list.
+func (p *parser) isItem(line string, ordered bool) bool {
+	if ordered {
+		return orderedRe.MatchString(line)
+	}
+	m := bulletRe.FindStringSubmatch(line)
+	return m != nil && len(m[1]) <= 3
+}
+
+// sanitizeInline removes raw HTML, replaces inline images by their alt
+// text and reduces links with unsafe targets to their text.
+func sanitizeInline(s string) string {
+	s = stripHTML(s)
+	s = imageRe.ReplaceAllString(s, "$1")
+	s = linkRe.ReplaceAllStringFunc(s, func(m string) string {
+		sub := linkRe.FindStringSubmatch(m)
+		if !safeURL(sub[2], true) {
+			return sub[1]
+		}
+		return m
+	})
+	return strings.TrimSpace(s)
+}
+
+// stripHTML removes HTML tags and comments, and script and style elements
+// with their content.
+func stripHTML(s string) string {
+	s = scriptRe.ReplaceAllString(s, "")
+	return htmlTagRe.ReplaceAllString(s, "")
+}
+
+// SafeImageURL reports whether u may be used as an image source.
+func SafeImageURL(u string) bool {
+	return safeURL(u, false)
+}
+
+// safeURL reports whether u may be used as a link (or, with link false, an
+// image source): http(s) URLs and same-origin paths, plus mailto for links
+// and base64 raster image data URLs for images.
+func safeURL(u string, link bool) bool {
+	if strings.HasPrefix(u, "data:") {
+		return !link && dataURLRe.MatchString(u)
+	}
+	if strings.HasPrefix(u, "/") {
+		return !strings.HasPrefix(u, "//") && !strings.Contains(u, "\\")
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https":
+		return parsed.Host != ""
+	case "mailto":
+		return link
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	inlineRe = regexp.MustCompile("`([^`\n]+)`|" + linkRe.String())
+	strongRe = regexp.MustCompile(`\*\*(.+?)\*\*|__(.+?)__`)
+	emRe     = regexp.MustCompile(`\*([^*\n]+)\*`)
+)
+
+// Render returns the HTML of blocks produced by Parse. All text is escaped,
+// so the result can be inserted into a page without further sanitizing. The
+// CSS classes match the chat UI's own Markdown renderer.
+func Render(blocks []Block) string {
+	var b strings.Builder
+	for _, blk := range blocks {
+		switch blk.Type {
+		case TypeText:
+			b.WriteString("<p>" + renderInline(blk.Text) + "</p>")
+		case TypeHeading:
+			level := strconv.Itoa(min(max(blk.Level, 1), 6))
+			b.WriteString(`<h` + level + ` class="md-heading">` + renderInline(blk.Text) + `</h` + level + `>`)
+		case TypeList:
+			tag := "ul"
+			open := `<ul class="md-list">`
+			if blk.Ordered {
+				tag = "ol"
+				open = `<ol class="md-list" start="` + strconv.Itoa(max(blk.Start, 0)) + `">`
+			}
+			b.WriteString(open)
+			for _, item := range blk.Items {
+				b.WriteString("<li>" + renderInline(item.Text))
+				if len(item.Items) > 0 {
+					b.WriteString(`<ul class="md-list">`)
+					for _, sub := range item.Items {
+						b.WriteString("<li>" + renderInline(sub) + "</li>")
+					}
+					b.WriteString("</ul>")
+				}
+				b.WriteString("</li>")
+			}
+			b.WriteString("</" + tag + ">")
+		case TypeCode:
+			class := ""
+			if languageRe.MatchString(blk.Language) {
+				class = ` class="language-` + html.EscapeString(blk.Language) + `"`
+			}
+			b.WriteString(`<pre class="md-code-block"><code` + class + `>` + html.EscapeString(blk.Code) + `</code></pre>`)
+		case TypeImage:
+			if !safeURL(blk.URL, false) {
+				continue
+			}
+			b.WriteString(`<img class="md-image" src="` + html.EscapeString(blk.URL) + `" alt="` + html.EscapeString(blk.Alt) + `" loading="lazy">`)
+		case TypeTable:
+			b.WriteString(`<table class="md-table"><thead><tr>`)
+			for _, cell := range blk.Header {
+				b.WriteString("<th>" + renderInline(cell) + "</th>")
+			}
+			b.WriteString("</tr></thead><tbody>")
+			for _, row := range blk.Rows {
+				b.WriteString("<tr>")
+				for _, cell := range row {
+					b.WriteString("<td>" + renderInline(cell) + "</td>")
+				}
+				b.WriteString("</tr>")
+			}
+			b.WriteString("</tbody></table>")
+		}
+	}
+	return b.String()
+}
+
+// renderInline renders inline code, links and emphasis, escaping everything
+// else. Line breaks become <br>.
+func renderInline(s string) string {
+	var b strings.Builder
+	for {
+		loc := inlineRe.FindStringSubmatchIndex(s)
+		if loc == nil {
+			break
+		}
+		b.WriteString(renderEmphasis(s[:loc[0]]))
+		switch {
+		case loc[2] >= 0:
+			b.WriteString(`<code class="md-code-inline">` + html.EscapeString(s[loc[2]:loc[3]]) + `</code>`)
+		case safeURL(s[loc[6]:loc[7]], true):
+			b.WriteString(`<a href="` + html.EscapeString(s[loc[6]:loc[7]]) + `" target="_blank" rel="noopener noreferrer">` +
+				renderEmphasis(s[loc[4]:loc[5]]) + `</a>`)
+		default:
+			b.WriteString(renderEmphasis(s[loc[4]:loc[5]]))
+		}
+		s = s[loc[1]:]
+	}
+	b.WriteString(renderEmphasis(s))
+	return b.String()
+}
+
+// renderEmphasis escapes s and renders **bold**, __bold__ and *italic*.
+func renderEmphasis(s string) string {
+	s = html.EscapeString(s)
+	s = strongRe.ReplaceAllString(s, "<strong>$1$2</strong>")
+	s = emRe.ReplaceAllString(s, "<em>$1</em>")
+	return strings.ReplaceAll(s, "\n", "<br>")
+}
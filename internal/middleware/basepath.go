@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// StripBasePath 返回在子路径下提供服务的处理器。
+// 以 base 开头的请求去掉前缀后交给 next；访问 base 本身时重定向到 base + "/"。
+// 不带前缀的请求原样交给 next，以兼容已在反向代理处去掉前缀的部署。
+// base 为空时直接返回 next。
+func StripBasePath(base string, next http.Handler) http.Handler {
+	if base == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == base {
+			target := base + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		rest, ok := strings.CutPrefix(r.URL.Path, base+"/")
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = "/" + rest
+		r2.URL.RawPath = ""
+		if raw, ok := strings.CutPrefix(r.URL.RawPath, base+"/"); ok {
+			r2.URL.RawPath = "/" + raw
+		}
+		next.ServeHTTP(w, r2)
+	})
+}
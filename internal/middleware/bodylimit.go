@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"askflow/internal/i18n"
+)
+
+type bodyLimitKey struct{}
+
+// BodyLimit caps request bodies at the size limit returns for each request.
+// Requests declaring a larger Content-Length are refused with 413 before the
+// handler runs; bodies sent without a length are cut off at the limit and
+// the handler's read fails with *http.MaxBytesError. A limit of 0 or below
+// leaves the body alone, for handlers that enforce limits of their own.
+func BodyLimit(limit func(r *http.Request) int64) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			n := limit(r)
+			if n <= 0 || r.Body == nil || r.Body == http.NoBody {
+				next(w, r)
+				return
+			}
+			if r.ContentLength > n {
+				WriteBodyTooLarge(w, n)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next(w, r.WithContext(context.WithValue(r.Context(), bodyLimitKey{}, n)))
+		}
+	}
+}
+
+// BodyLimitFrom returns the body limit BodyLimit applied to the request of
+// ctx, and whether it applied one.
+func BodyLimitFrom(ctx context.Context) (int64, bool) {
+	n, ok := ctx.Value(bodyLimitKey{}).(int64)
+	return n, ok
+}
+
+// WriteBodyTooLarge responds with 413 naming the limit.
+func WriteBodyTooLarge(w http.ResponseWriter, limit int64) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Connection", "close")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	msg := i18n.Tf(i18n.ResponseLanguage(w), "请求内容过大，上限为 %s", formatSize(limit))
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// formatSize formats a byte count as KB or, for whole megabytes, MB.
+func formatSize(n int64) string {
+	if n >= 1<<20 && n%(1<<20) == 0 {
+		return fmt.Sprintf("%d MB", n>>20)
+	}
+	return fmt.Sprintf("%d KB", (n+1023)>>10)
+}
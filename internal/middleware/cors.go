@@ -2,6 +2,9 @@ package middleware
 
 import "net/http"
 
+// rateLimitHeaders lists the rate limit response headers that scripts may read.
+const rateLimitHeaders = "X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, Retry-After"
+
 // CORS 返回处理跨域请求的中间件。
 // 仅允许同源请求：验证 Origin 头与请求 Host 是否匹配。
 // 对 OPTIONS 预检请求返回 204 No Content。
@@ -16,9 +19,10 @@ func CORS() Middleware {
 				requestHost := r.Host
 				if requestHost != "" && (origin == "http://"+requestHost || origin == "https://"+requestHost) {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
-					w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-					w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+					w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+					w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-CSRF-Token, Upload-Offset")
 					w.Header().Set("Access-Control-Allow-Credentials", "true")
+					w.Header().Set("Access-Control-Expose-Headers", rateLimitHeaders+", Upload-Offset")
 					w.Header().Set("Access-Control-Max-Age", "3600")
 					w.Header().Set("Vary", "Origin")
 				}
@@ -31,3 +35,29 @@ func CORS() Middleware {
 		}
 	}
 }
+
+// WidgetCORS 返回嵌入式聊天组件使用的跨域中间件。
+// 与 CORS 不同，它允许第三方站点跨域访问，但 Origin 必须通过 allow 回调校验（按产品配置的白名单）。
+// 不允许携带 Cookie，组件使用 Bearer 访客令牌鉴权。
+// 同时放宽 Cross-Origin-Resource-Policy 与 frame 相关限制，使脚本可被外部页面加载。
+func WidgetCORS(allow func(r *http.Request, origin string) bool) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cross-Origin-Resource-Policy", "cross-origin")
+			w.Header().Add("Vary", "Origin")
+			origin := r.Header.Get("Origin")
+			if origin != "" && allow(r, origin) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+				w.Header().Set("Access-Control-Expose-Headers", rateLimitHeaders)
+				w.Header().Set("Access-Control-Max-Age", "3600")
+			}
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next(w, r)
+		}
+	}
+}
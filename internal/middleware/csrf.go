@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"askflow/internal/i18n"
+)
+
+// CSRFCookieName 是双提交 CSRF 令牌的 Cookie 名（前端脚本可读）。
+const CSRFCookieName = "askflow_csrf"
+
+// CSRFHeaderName 是前端回传 CSRF 令牌所用的请求头。
+const CSRFHeaderName = "X-CSRF-Token"
+
+// CSRF 返回双提交 Cookie 校验中间件。
+// 仅当变更类请求（POST/PUT/PATCH/DELETE）携带了 sessionCookies 中任一会话 Cookie
+// 且未携带 Bearer 令牌时才校验：X-CSRF-Token 请求头必须与 askflow_csrf Cookie 一致。
+// 使用 Bearer 令牌的请求不会自动附带凭据，因此无需校验。
+func CSRF(sessionCookies ...string) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				next(w, r)
+				return
+			}
+			if hasBearerToken(r) || !hasAnyCookie(r, sessionCookies) {
+				next(w, r)
+				return
+			}
+			c, err := r.Cookie(CSRFCookieName)
+			header := r.Header.Get(CSRFHeaderName)
+			if err != nil || c.Value == "" || header == "" ||
+				subtle.ConstantTimeCompare([]byte(c.Value), []byte(header)) != 1 {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]string{"error": i18n.T(i18n.ResponseLanguage(w), "CSRF 校验失败")})
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// hasBearerToken 判断请求是否携带非空的 Bearer 令牌。
+func hasBearerToken(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && token != ""
+}
+
+func hasAnyCookie(r *http.Request, names []string) bool {
+	for _, name := range names {
+		if c, err := r.Cookie(name); err == nil && c.Value != "" {
+			return true
+		}
+	}
+	return false
+}
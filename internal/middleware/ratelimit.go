@@ -1,21 +1,31 @@
 package middleware
 
 import (
+	"encoding/json"
 	"log"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"askflow/internal/i18n"
+	"askflow/internal/sharedcache"
 )
 
-// RateLimiter provides per-IP rate limiting using a sliding window counter.
+// RateLimiter provides per-client rate limiting using a sliding window
+// counter. Clients are keyed by IP, or by identity with LimitBy.
 type RateLimiter struct {
-	mu       sync.Mutex
-	requests map[string][]time.Time
-	limit    int           // max requests per window
-	window   time.Duration // time window
-	stopCh   chan struct{} // signal to stop the cleanup goroutine
+	mu        sync.Mutex
+	requests  map[string][]time.Time
+	limit     int                   // max requests per window
+	limitFunc func(tier string) int // when set, overrides limit on every request
+	window    time.Duration         // time window
+	stopCh    chan struct{}         // signal to stop the cleanup goroutine
+	onReject  func(r *http.Request) // called for every rejected request
+	shared    *sharedcache.Cache    // when set, counts live here (see Share)
+	name      string                // key prefix of the counts in shared
 }
 
 // NewRateLimiter creates a RateLimiter instance and starts a background
@@ -48,6 +58,33 @@ func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
 	return rl
 }
 
+// NewDynamicRateLimiter is NewRateLimiter with a limit that is read on every
+// request, so configuration changes apply without a restart. limit receives
+// the tier of the caller reported by the LimitBy identity function ("" with
+// Limit or Allow). A limit below 1 is treated as 1.
+func NewDynamicRateLimiter(limit func(tier string) int, window time.Duration) *RateLimiter {
+	rl := NewRateLimiter(0, window)
+	rl.limitFunc = limit
+	return rl
+}
+
+// OnReject sets a function called with every request rejected by the
+// middleware, e.g. to report offending networks. Call it before serving.
+func (rl *RateLimiter) OnReject(fn func(r *http.Request)) {
+	rl.onReject = fn
+}
+
+// Share makes the limiter count requests in c, shared with the other
+// instances using it, so that a client is limited across all of them rather
+// than per instance. name keeps the counters of different limiters apart.
+// Shared counters use a sliding window estimated from two fixed windows:
+// the count of the current window plus the previous window's count weighted
+// by how much of it still overlaps. Call it before serving.
+func (rl *RateLimiter) Share(c *sharedcache.Cache, name string) {
+	rl.shared = c
+	rl.name = name
+}
+
 // Stop terminates the background cleanup goroutine.
 func (rl *RateLimiter) Stop() {
 	select {
@@ -58,9 +95,25 @@ func (rl *RateLimiter) Stop() {
 	}
 }
 
-// Allow checks whether the given IP is allowed to make a request
-// under the configured rate limit.
-func (rl *RateLimiter) Allow(ip string) bool {
+// Allow checks whether the given client key (an IP, or an identity from
+// LimitBy) is allowed to make a request under the configured rate limit.
+func (rl *RateLimiter) Allow(key string) bool {
+	allowed, _, _, _ := rl.take(key, "")
+	return allowed
+}
+
+// take records a request of key under the limit of tier when it is allowed.
+// It returns the limit, the requests left in the current window and the
+// time at which the oldest counted request leaves the window.
+func (rl *RateLimiter) take(key, tier string) (allowed bool, limit, remaining int, reset time.Time) {
+	limit = rl.limit
+	if rl.limitFunc != nil {
+		limit = max(rl.limitFunc(tier), 1)
+	}
+	if rl.shared != nil {
+		return rl.sharedTake(key, limit)
+	}
+
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
@@ -78,7 +131,7 @@ func (rl *RateLimiter) Allow(ip string) bool {
 	}
 
 	// Filter out expired entries
-	times := rl.requests[ip]
+	times := rl.requests[key]
 	valid := times[:0]
 	for _, t := range times {
 		if t.After(cutoff) {
@@ -86,13 +139,37 @@ func (rl *RateLimiter) Allow(ip string) bool {
 		}
 	}
 
-	if len(valid) >= rl.limit {
-		rl.requests[ip] = valid
-		return false
+	if len(valid) >= limit {
+		rl.requests[key] = valid
+		return false, limit, 0, valid[0].Add(rl.window)
 	}
 
-	rl.requests[ip] = append(valid, now)
-	return true
+	valid = append(valid, now)
+	rl.requests[key] = valid
+	return true, limit, limit - len(valid), valid[0].Add(rl.window)
+}
+
+// sharedTake is take on the shared counters.
+func (rl *RateLimiter) sharedTake(key string, limit int) (bool, int, int, time.Time) {
+	now := time.Now()
+	slot := now.UnixNano() / int64(rl.window)
+	start := time.Unix(0, slot*int64(rl.window))
+	prefix := "ratelimit:" + rl.name + ":" + key + ":"
+	cur := prefix + strconv.FormatInt(slot, 10)
+
+	prev := 0
+	if v, ok := rl.shared.Get(prefix + strconv.FormatInt(slot-1, 10)); ok {
+		prev, _ = strconv.Atoi(string(v))
+	}
+	overlap := 1 - float64(now.Sub(start))/float64(rl.window)
+	n := rl.shared.Incr(cur, 1, 2*rl.window)
+	used := int(float64(prev)*overlap) + int(n)
+	reset := start.Add(rl.window)
+	if used > limit {
+		rl.shared.Incr(cur, -1, 2*rl.window)
+		return false, limit, 0, reset
+	}
+	return true, limit, limit - used, reset
 }
 
 // cleanup removes expired entries from the requests map.
@@ -135,17 +212,45 @@ func GetClientIP(r *http.Request) string {
 	return host
 }
 
-// Limit returns a Middleware that enforces the rate limit.
+// Limit returns a Middleware that enforces the rate limit per client IP.
 // When the limit is exceeded, it responds with 429 Too Many Requests.
 func (rl *RateLimiter) Limit() Middleware {
+	return rl.LimitBy(nil)
+}
+
+// LimitBy returns a Middleware that enforces the rate limit per identity:
+// identity returns a key for the authenticated user (or other credential)
+// behind the request, and "" for anonymous requests, which are limited per
+// client IP. Users sharing an IP behind NAT then get separate buckets, and a
+// user switching IPs keeps one. The returned tier selects the limit of a
+// dynamic rate limiter.
+//
+// Every response carries X-RateLimit-Limit, X-RateLimit-Remaining and
+// X-RateLimit-Reset (Unix time at which a request slot frees up); when the
+// limit is exceeded, it responds with 429 and Retry-After.
+func (rl *RateLimiter) LimitBy(identity func(r *http.Request) (key, tier string)) Middleware {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
-			ip := GetClientIP(r)
-			if !rl.Allow(ip) {
+			key, tier := "", ""
+			if identity != nil {
+				key, tier = identity(r)
+			}
+			if key == "" {
+				key = GetClientIP(r)
+			}
+			allowed, limit, remaining, reset := rl.take(key, tier)
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+			if !allowed {
+				if rl.onReject != nil {
+					rl.onReject(r)
+				}
+				retryAfter := max(int(time.Until(reset).Seconds()+0.999), 1)
 				w.Header().Set("Content-Type", "application/json")
-				w.Header().Set("Retry-After", "60")
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
 				w.WriteHeader(http.StatusTooManyRequests)
-				w.Write([]byte(`{"error":"请求过于频繁，请稍后再试"}`))
+				json.NewEncoder(w).Encode(map[string]string{"error": i18n.T(i18n.ResponseLanguage(w), "请求过于频繁，请稍后再试")})
 				return
 			}
 			next(w, r)
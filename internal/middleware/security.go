@@ -1,10 +1,15 @@
 package middleware
 
-import "net/http"
+import (
+	"net/http"
+	"strconv"
+)
 
 // SecurityHeaders 返回设置安全响应头的中间件。
 // 包含 OWASP 推荐的安全头，防止常见的 Web 攻击。
-func SecurityHeaders() Middleware {
+// Strict-Transport-Security 仅在 HTTPS 请求（直连 TLS 或反向代理声明 X-Forwarded-Proto: https）上发送，
+// max-age 由 hstsMaxAge 实时提供（秒），返回 0 表示不发送。
+func SecurityHeaders(hstsMaxAge func() int) Middleware {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("X-Content-Type-Options", "nosniff")
@@ -14,10 +19,17 @@ func SecurityHeaders() Middleware {
 			w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; img-src 'self' data: blob: https:; media-src 'self' blob:; connect-src 'self'")
 			w.Header().Set("Permissions-Policy", "camera=(), microphone=(), geolocation=()")
 			w.Header().Set("Cache-Control", "no-store")
-			w.Header().Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains; preload")
+			if maxAge := hstsMaxAge(); maxAge > 0 && IsHTTPS(r) {
+				w.Header().Set("Strict-Transport-Security", "max-age="+strconv.Itoa(maxAge)+"; includeSubDomains")
+			}
 			w.Header().Set("Cross-Origin-Opener-Policy", "same-origin")
 			w.Header().Set("Cross-Origin-Resource-Policy", "same-origin")
 			next(w, r)
 		}
 	}
 }
+
+// IsHTTPS 判断请求是否经由 HTTPS 到达本服务或前置反向代理。
+func IsHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
@@ -0,0 +1,461 @@
+// Package moderation masks personal data (emails, phone numbers, identity
+// card numbers) and blocks prohibited content in documents being imported
+// and in user questions. Each product can have its own policy; products
+// without one use the default policy, and without a default nothing is
+// moderated. Blocked documents and questions are queued for an admin, who
+// can release a document that was blocked by mistake. Documents suspected of
+// prompt injection are stored but also queued, so an admin can remove them.
+package moderation
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Sources of queued items.
+const (
+	SourceDocument  = "document"
+	SourceQuery     = "query"
+	SourceInjection = "injection" // a stored document suspected of prompt injection
+)
+
+// Queue item statuses.
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+)
+
+var (
+	// ErrNotFound is returned for an unknown policy or queue item.
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyReviewed is returned when reviewing an item twice.
+	ErrAlreadyReviewed = errors.New("item has already been reviewed")
+)
+
+// BlockedError reports content that a policy prohibits.
+type BlockedError struct {
+	Term string
+}
+
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("content blocked by moderation policy (term %q)", e.Term)
+}
+
+// Policy is the moderation policy of a product; ProductID "" is the
+// default policy.
+type Policy struct {
+	ProductID      string    `json:"product_id"`
+	RedactEmail    bool      `json:"redact_email"`
+	RedactPhone    bool      `json:"redact_phone"`
+	RedactIDNumber bool      `json:"redact_id_number"`
+	BlockedTerms   []string  `json:"blocked_terms"`
+	UpdatedAt      time.Time `json:"updated_at"`
+
+	lowerTerms []string
+}
+
+// Item is a blocked document or question, or a flagged document, awaiting
+// review.
+type Item struct {
+	ID           string     `json:"id"`
+	Source       string     `json:"source"`
+	ProductID    string     `json:"product_id"`
+	DocumentID   string     `json:"document_id,omitempty"`
+	DocumentName string     `json:"document_name,omitempty"`
+	UserID       string     `json:"user_id,omitempty"`
+	Term         string     `json:"term"`
+	Excerpt      string     `json:"excerpt"`
+	Status       string     `json:"status"`
+	ReviewedBy   string     `json:"reviewed_by,omitempty"`
+	ReviewedAt   *time.Time `json:"reviewed_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// Result is the outcome of checking a text against a policy.
+type Result struct {
+	Text     string         // the text with personal data masked
+	Redacted map[string]int // masked matches per kind
+	Term     string         // the first prohibited term found, "" if none
+	excerpt  string
+}
+
+// Service stores policies and the review queue and applies policies to text.
+type Service struct {
+	readDB  *sql.DB
+	writeDB *sql.DB
+
+	// policies caches all policies by product ID; they are few and read on
+	// every question and document.
+	mu       sync.RWMutex
+	policies map[string]*Policy
+}
+
+// NewService creates a new moderation Service with separate read and write database connections.
+func NewService(readDB, writeDB *sql.DB) *Service {
+	return &Service{readDB: readDB, writeDB: writeDB}
+}
+
+// validate normalises and checks a policy's blocked terms.
+func validate(p *Policy) error {
+	seen := make(map[string]bool, len(p.BlockedTerms))
+	terms := make([]string, 0, len(p.BlockedTerms))
+	for _, t := range p.BlockedTerms {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[strings.ToLower(t)] {
+			continue
+		}
+		if utf8.RuneCountInString(t) > 100 {
+			return fmt.Errorf("blocked term %q is too long (max 100 characters)", t)
+		}
+		seen[strings.ToLower(t)] = true
+		terms = append(terms, t)
+	}
+	if len(terms) > 500 {
+		return fmt.Errorf("too many blocked terms (max 500)")
+	}
+	p.BlockedTerms = terms
+	return nil
+}
+
+func (p *Policy) prepare() {
+	p.lowerTerms = make([]string, len(p.BlockedTerms))
+	for i, t := range p.BlockedTerms {
+		p.lowerTerms[i] = strings.ToLower(t)
+	}
+}
+
+// check masks personal data in text and looks for prohibited terms.
+func (p *Policy) check(text string) Result {
+	var res Result
+	if len(p.lowerTerms) > 0 {
+		lower := strings.ToLower(text)
+		for i, t := range p.lowerTerms {
+			if at := strings.Index(lower, t); at >= 0 {
+				res.Term = p.BlockedTerms[i]
+				// lower has the same byte offsets as text for all but a few
+				// special cases; clamp in case they differ
+				res.excerpt = excerpt(text, min(at, len(text)), min(at+len(t), len(text)))
+				break
+			}
+		}
+	}
+	res.Text, res.Redacted = redact(text, p.RedactEmail, p.RedactPhone, p.RedactIDNumber)
+	return res
+}
+
+// excerpt returns up to 60 characters either side of text[start:end] with
+// all kinds of personal data masked, for the review queue.
+func excerpt(text string, start, end int) string {
+	const context = 60
+	for i := 0; i < context && start > 0; i++ {
+		_, size := utf8.DecodeLastRuneInString(text[:start])
+		start -= size
+	}
+	for i := 0; i < context && end < len(text); i++ {
+		_, size := utf8.DecodeRuneInString(text[end:])
+		end += size
+	}
+	s, _ := redact(strings.TrimSpace(text[start:end]), true, true, true)
+	return s
+}
+
+// load reads all policies into the cache if needed.
+func (s *Service) load() (map[string]*Policy, error) {
+	s.mu.RLock()
+	policies := s.policies
+	s.mu.RUnlock()
+	if policies != nil {
+		return policies, nil
+	}
+
+	rows, err := s.readDB.Query(`SELECT product_id, redact_email, redact_phone, redact_id_number, blocked_terms, updated_at FROM moderation_policies`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	policies = map[string]*Policy{}
+	for rows.Next() {
+		var p Policy
+		var terms string
+		var updatedAt sql.NullTime
+		if err := rows.Scan(&p.ProductID, &p.RedactEmail, &p.RedactPhone, &p.RedactIDNumber, &terms, &updatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(terms), &p.BlockedTerms); err != nil {
+			return nil, fmt.Errorf("invalid blocked terms of policy %q: %w", p.ProductID, err)
+		}
+		p.UpdatedAt = updatedAt.Time
+		p.prepare()
+		policies[p.ProductID] = &p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.policies = policies
+	s.mu.Unlock()
+	return policies, nil
+}
+
+// invalidate drops the cached policies after a change.
+func (s *Service) invalidate() {
+	s.mu.Lock()
+	s.policies = nil
+	s.mu.Unlock()
+}
+
+// effective returns the policy applying to productID, or nil when neither
+// the product nor the default has one. Errors loading policies are logged
+// and treated as no policy so that moderation never takes the service down.
+func (s *Service) effective(productID string) *Policy {
+	policies, err := s.load()
+	if err != nil {
+		log.Printf("[Moderation] failed to load policies: %v", err)
+		return nil
+	}
+	if p := policies[productID]; p != nil {
+		return p
+	}
+	return policies[""]
+}
+
+// ListPolicies returns all policies, the default first.
+func (s *Service) ListPolicies() ([]Policy, error) {
+	policies, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Policy, 0, len(policies))
+	if p := policies[""]; p != nil {
+		list = append(list, *p)
+	}
+	for id, p := range policies {
+		if id != "" {
+			list = append(list, *p)
+		}
+	}
+	return list, nil
+}
+
+// SetPolicy creates or replaces the policy of p.ProductID.
+func (s *Service) SetPolicy(p Policy) (*Policy, error) {
+	if err := validate(&p); err != nil {
+		return nil, err
+	}
+	terms, _ := json.Marshal(p.BlockedTerms)
+	p.UpdatedAt = time.Now().UTC()
+	_, err := s.writeDB.Exec(
+		`INSERT INTO moderation_policies (product_id, redact_email, redact_phone, redact_id_number, blocked_terms, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(product_id) DO UPDATE SET redact_email = excluded.redact_email, redact_phone = excluded.redact_phone,
+			redact_id_number = excluded.redact_id_number, blocked_terms = excluded.blocked_terms, updated_at = excluded.updated_at`,
+		p.ProductID, p.RedactEmail, p.RedactPhone, p.RedactIDNumber, string(terms), p.UpdatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save policy: %w", err)
+	}
+	s.invalidate()
+	return &p, nil
+}
+
+// DeletePolicy removes the policy of productID, so the product falls back to
+// the default policy (or, for the default, to no moderation).
+func (s *Service) DeletePolicy(productID string) error {
+	res, err := s.writeDB.Exec(`DELETE FROM moderation_policies WHERE product_id = ?`, productID)
+	if err != nil {
+		return fmt.Errorf("failed to delete policy: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	s.invalidate()
+	return nil
+}
+
+// Redact masks personal data in text according to productID's policy.
+func (s *Service) Redact(productID, text string) string {
+	p := s.effective(productID)
+	if p == nil {
+		return text
+	}
+	masked, _ := redact(text, p.RedactEmail, p.RedactPhone, p.RedactIDNumber)
+	return masked
+}
+
+// ModerateQuery applies productID's policy to a user's question. It returns
+// the question with personal data masked, or a *BlockedError after queuing
+// the question for review when it contains a prohibited term.
+func (s *Service) ModerateQuery(userID, productID, question string) (string, error) {
+	p := s.effective(productID)
+	if p == nil {
+		return question, nil
+	}
+	res := p.check(question)
+	if res.Term != "" {
+		s.enqueue(Item{Source: SourceQuery, ProductID: productID, UserID: userID, Term: res.Term, Excerpt: res.excerpt})
+		return "", &BlockedError{Term: res.Term}
+	}
+	return res.Text, nil
+}
+
+// ModerateDocument applies productID's policy to text extracted from a
+// document before it is embedded and stored. It returns texts with personal
+// data masked, or a *BlockedError after queuing the document for review
+// when any text contains a prohibited term. With allowBlocked set (for a
+// document an admin released) prohibited terms are ignored.
+func (s *Service) ModerateDocument(docID, docName, productID string, texts []string, allowBlocked bool) ([]string, error) {
+	p := s.effective(productID)
+	if p == nil {
+		return texts, nil
+	}
+	out := make([]string, len(texts))
+	masked := 0
+	for i, t := range texts {
+		res := p.check(t)
+		if res.Term != "" && !allowBlocked {
+			s.enqueue(Item{Source: SourceDocument, ProductID: productID, DocumentID: docID, DocumentName: docName, Term: res.Term, Excerpt: res.excerpt})
+			return nil, &BlockedError{Term: res.Term}
+		}
+		out[i] = res.Text
+		for _, n := range res.Redacted {
+			masked += n
+		}
+	}
+	if masked > 0 {
+		log.Printf("[Moderation] masked %d personal data matches in doc=%s", masked, docID)
+	}
+	return out, nil
+}
+
+// FlagDocument queues a stored document that looks like it contains a
+// prompt injection. reason says what was detected and snippet is the
+// suspicious text, kept with personal data masked.
+func (s *Service) FlagDocument(docID, docName, productID, reason, snippet string) {
+	snippet = strings.TrimSpace(snippet)
+	if r := []rune(snippet); len(r) > 200 {
+		snippet = string(r[:200]) + "…"
+	}
+	snippet, _ = redact(snippet, true, true, true)
+	log.Printf("[Moderation] doc=%s flagged for review: %s", docID, reason)
+	s.enqueue(Item{Source: SourceInjection, ProductID: productID, DocumentID: docID, DocumentName: docName, Term: reason, Excerpt: snippet})
+}
+
+// enqueue adds a blocked item to the review queue. A document blocked again
+// while an earlier item for it is still pending is not queued twice.
+func (s *Service) enqueue(it Item) {
+	if it.DocumentID != "" {
+		var n int
+		if err := s.readDB.QueryRow(`SELECT COUNT(*) FROM moderation_queue WHERE document_id = ? AND status = ?`,
+			it.DocumentID, StatusPending).Scan(&n); err == nil && n > 0 {
+			return
+		}
+	}
+	id, err := generateID()
+	if err != nil {
+		log.Printf("[Moderation] %v", err)
+		return
+	}
+	if _, err := s.writeDB.Exec(
+		`INSERT INTO moderation_queue (id, source, product_id, document_id, document_name, user_id, term, excerpt, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, it.Source, it.ProductID, it.DocumentID, it.DocumentName, it.UserID, it.Term, it.Excerpt, StatusPending, time.Now().UTC(),
+	); err != nil {
+		log.Printf("[Moderation] failed to queue blocked %s: %v", it.Source, err)
+	}
+}
+
+const itemColumns = `id, source, product_id, document_id, document_name, user_id, term, excerpt, status, reviewed_by, reviewed_at, created_at`
+
+// ListQueue returns the 200 most recent queue items with the given status,
+// or of any status when status is empty.
+func (s *Service) ListQueue(status string) ([]Item, error) {
+	q := `SELECT ` + itemColumns + ` FROM moderation_queue`
+	var args []interface{}
+	if status != "" {
+		q += ` WHERE status = ?`
+		args = append(args, status)
+	}
+	rows, err := s.readDB.Query(q+` ORDER BY created_at DESC LIMIT 200`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Item{}
+	for rows.Next() {
+		it, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *it)
+	}
+	return items, rows.Err()
+}
+
+// GetItem returns a queue item by ID.
+func (s *Service) GetItem(id string) (*Item, error) {
+	it, err := scanItem(s.readDB.QueryRow(`SELECT `+itemColumns+` FROM moderation_queue WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return it, err
+}
+
+// Review records an admin's decision on a pending item: approve marks the
+// block or flag as a mistake, reject confirms it.
+func (s *Service) Review(id string, approve bool, reviewer string) (*Item, error) {
+	status := StatusRejected
+	if approve {
+		status = StatusApproved
+	}
+	res, err := s.writeDB.Exec(
+		`UPDATE moderation_queue SET status = ?, reviewed_by = ?, reviewed_at = ? WHERE id = ? AND status = ?`,
+		status, reviewer, time.Now().UTC(), id, StatusPending,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to review item: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if _, err := s.GetItem(id); err != nil {
+			return nil, err
+		}
+		return nil, ErrAlreadyReviewed
+	}
+	return s.GetItem(id)
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanItem(row scanner) (*Item, error) {
+	var it Item
+	var reviewedAt sql.NullTime
+	if err := row.Scan(&it.ID, &it.Source, &it.ProductID, &it.DocumentID, &it.DocumentName, &it.UserID,
+		&it.Term, &it.Excerpt, &it.Status, &it.ReviewedBy, &reviewedAt, &it.CreatedAt); err != nil {
+		return nil, err
+	}
+	if reviewedAt.Valid {
+		it.ReviewedAt = &reviewedAt.Time
+	}
+	return &it, nil
+}
+
+// generateID creates a random hex string for use as a unique identifier.
+func generateID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
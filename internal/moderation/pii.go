@@ -0,0 +1,111 @@
+package moderation
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Kinds of personal data that can be masked.
+const (
+	KindEmail    = "email"
+	KindPhone    = "phone"
+	KindIDNumber = "id_number"
+)
+
+// Masks that replace personal data in text.
+const (
+	emailMask    = "[邮箱已隐藏]"
+	phoneMask    = "[电话已隐藏]"
+	idNumberMask = "[证件号已隐藏]"
+)
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`)
+	// Mainland mobile numbers (optionally +86), landlines such as
+	// 010-12345678, and international numbers written with separators.
+	phonePattern = regexp.MustCompile(`(?:\+?86[ \-]?)?1[3-9]\d{9}|0\d{2,3}-\d{7,8}|\+\d{1,3}[ \-]\d{1,4}(?:[ \-]\d{2,4}){2,3}`)
+	// Mainland resident identity card numbers; matches are confirmed by
+	// their check digit.
+	idNumberPattern = regexp.MustCompile(`[1-9]\d{16}[\dXx]`)
+)
+
+// redact masks the enabled kinds of personal data and returns the
+// masked text with the number of matches per kind.
+func redact(text string, email, phone, idNumber bool) (string, map[string]int) {
+	counts := map[string]int{}
+	var n int
+	// Emails first: their local part may look like a phone number
+	if email {
+		if text, n = mask(text, emailPattern, false, nil, emailMask); n > 0 {
+			counts[KindEmail] = n
+		}
+	}
+	if idNumber {
+		if text, n = mask(text, idNumberPattern, true, validIDNumber, idNumberMask); n > 0 {
+			counts[KindIDNumber] = n
+		}
+	}
+	if phone {
+		if text, n = mask(text, phonePattern, true, nil, phoneMask); n > 0 {
+			counts[KindPhone] = n
+		}
+	}
+	return text, counts
+}
+
+// mask replaces matches of re with repl. With bounded set, matches directly
+// preceded or followed by a letter or digit are part of a longer token and
+// left alone; valid, if non-nil, must also accept the match.
+func mask(text string, re *regexp.Regexp, bounded bool, valid func(string) bool, repl string) (string, int) {
+	locs := re.FindAllStringIndex(text, -1)
+	if len(locs) == 0 {
+		return text, 0
+	}
+	var b strings.Builder
+	last, n := 0, 0
+	for _, l := range locs {
+		if bounded && ((l[0] > 0 && isAlnum(text[l[0]-1])) || (l[1] < len(text) && isAlnum(text[l[1]]))) {
+			continue
+		}
+		if valid != nil && !valid(text[l[0]:l[1]]) {
+			continue
+		}
+		b.WriteString(text[last:l[0]])
+		b.WriteString(repl)
+		last = l[1]
+		n++
+	}
+	if n == 0 {
+		return text, 0
+	}
+	b.WriteString(text[last:])
+	return b.String(), n
+}
+
+func isAlnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// validIDNumber checks the birth month and day and the ISO 7064 check digit
+// of an 18-character resident identity card number.
+func validIDNumber(s string) bool {
+	if len(s) != 18 {
+		return false
+	}
+	month := (s[10]-'0')*10 + s[11] - '0'
+	day := (s[12]-'0')*10 + s[13] - '0'
+	if month < 1 || month > 12 || day < 1 || day > 31 {
+		return false
+	}
+	weights := [17]int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+	sum := 0
+	for i, w := range weights {
+		sum += int(s[i]-'0') * w
+	}
+	check := "10X98765432"[sum%11]
+	last := s[17]
+	if last == 'x' {
+		last = 'X'
+	}
+	return last == check
+}
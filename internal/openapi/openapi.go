@@ -0,0 +1,447 @@
+// Package openapi builds an OpenAPI 3 description of the HTTP API from
+// operations declared next to the route registrations. Request and response
+// bodies are given as Go values and turned into JSON schemas by reflection,
+// following encoding/json field names and omitempty.
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Access is who may call an operation.
+type Access int
+
+const (
+	Public     Access = iota // no session required
+	User                     // any signed-in user session (including anonymous and widget sessions)
+	Admin                    // admin session; the operation checks its own role or permission
+	SuperAdmin               // super admin session
+)
+
+// Content types of non-JSON bodies.
+const (
+	EventStream = "text/event-stream"
+	Binary      = "application/octet-stream"
+	Multipart   = "multipart/form-data"
+	JavaScript  = "application/javascript"
+	XML         = "application/xml"
+)
+
+// Props describes an inline JSON object for bodies that have no Go type:
+// property name to a Go value whose type gives the property schema, e.g.
+// Props{"email": "", "days": 0, "product_ids": []string{}}.
+type Props map[string]interface{}
+
+// Schema is a literal JSON schema used as is.
+type Schema map[string]interface{}
+
+// Param is a query parameter.
+type Param struct {
+	Name        string
+	Type        string // JSON schema type, default "string"
+	Required    bool
+	Description string
+}
+
+// Query returns optional string query parameters with the given names.
+// A name may end in ":integer" or ":boolean" to change its type.
+func Query(names ...string) []Param {
+	params := make([]Param, len(names))
+	for i, n := range names {
+		name, typ, _ := strings.Cut(n, ":")
+		params[i] = Param{Name: name, Type: typ}
+	}
+	return params
+}
+
+// Operation is one method on one path.
+type Operation struct {
+	Method      string
+	Path        string // OpenAPI path template, e.g. /api/products/{id}; defaults to the route pattern
+	Summary     string
+	Description string
+	Access      Access
+	Query       []Param
+	Request     interface{} // JSON request body, or a multipart form when RequestType is Multipart
+	RequestType string      // content type of Request, default application/json
+	Response    interface{} // 200 response body; nil means {"status": "ok"}
+	ContentType string      // content type of Response, default application/json
+	Redirect    bool        // responds with a 302 redirect instead of a body
+	Tag         string      // set by Group.Route
+}
+
+// Registry collects the documented operations and the registered routes.
+type Registry struct {
+	title, version string
+
+	mu         sync.Mutex
+	ops        []Operation
+	documented map[string]bool
+	registered []string
+
+	once sync.Once
+	doc  map[string]interface{}
+}
+
+// NewRegistry creates an empty registry for an API with the given title and
+// version.
+func NewRegistry(title, version string) *Registry {
+	return &Registry{title: title, version: version, documented: map[string]bool{}}
+}
+
+// Group adds operations under one tag.
+type Group struct {
+	r   *Registry
+	tag string
+}
+
+// Group returns a group of operations tagged tag.
+func (r *Registry) Group(tag string) Group {
+	return Group{r: r, tag: tag}
+}
+
+// Route documents the operations served by the route pattern.
+func (g Group) Route(pattern string, ops ...Operation) {
+	g.r.mu.Lock()
+	defer g.r.mu.Unlock()
+	g.r.documented[pattern] = true
+	for _, op := range ops {
+		if op.Path == "" {
+			op.Path = strings.TrimSuffix(pattern, "/")
+		}
+		op.Tag = g.tag
+		g.r.ops = append(g.r.ops, op)
+	}
+}
+
+// Registered records a route pattern registered with the HTTP mux.
+func (r *Registry) Registered(pattern string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.registered = append(r.registered, pattern)
+}
+
+// Undocumented returns the registered API routes without documentation.
+func (r *Registry) Undocumented() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []string
+	for _, p := range r.registered {
+		if strings.HasPrefix(p, "/api/") && !r.documented[p] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// Document returns the OpenAPI document with serverURL as its only server
+// ("" for none). The document is built once; later calls reuse it.
+func (r *Registry) Document(serverURL string) map[string]interface{} {
+	r.once.Do(func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.doc = r.build()
+	})
+	doc := make(map[string]interface{}, len(r.doc)+1)
+	for k, v := range r.doc {
+		doc[k] = v
+	}
+	if serverURL != "" {
+		doc["servers"] = []interface{}{map[string]interface{}{"url": serverURL}}
+	}
+	return doc
+}
+
+// MarshalIndent returns the document without servers as indented JSON.
+func (r *Registry) MarshalIndent() ([]byte, error) {
+	return json.MarshalIndent(r.Document(""), "", "  ")
+}
+
+var pathParamRe = regexp.MustCompile(`\{([^}]+)\}`)
+
+func (r *Registry) build() map[string]interface{} {
+	g := &generator{schemas: map[string]interface{}{}, names: map[reflect.Type]string{}, taken: map[string]reflect.Type{}}
+	errorRef := g.schema(reflect.TypeOf(ErrorResponse{}))
+
+	paths := map[string]interface{}{}
+	tags := []interface{}{}
+	seenTag := map[string]bool{}
+	for _, op := range r.ops {
+		if !seenTag[op.Tag] {
+			seenTag[op.Tag] = true
+			tags = append(tags, map[string]interface{}{"name": op.Tag})
+		}
+		item, _ := paths[op.Path].(map[string]interface{})
+		if item == nil {
+			item = map[string]interface{}{}
+			paths[op.Path] = item
+		}
+
+		o := map[string]interface{}{
+			"tags":        []string{op.Tag},
+			"summary":     op.Summary,
+			"operationId": operationID(op.Method, op.Path),
+		}
+		if op.Description != "" {
+			o["description"] = op.Description
+		}
+		var params []interface{}
+		for _, m := range pathParamRe.FindAllStringSubmatch(op.Path, -1) {
+			params = append(params, map[string]interface{}{
+				"name": m[1], "in": "path", "required": true,
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, p := range op.Query {
+			typ := p.Type
+			if typ == "" {
+				typ = "string"
+			}
+			param := map[string]interface{}{
+				"name": p.Name, "in": "query", "required": p.Required,
+				"schema": map[string]interface{}{"type": typ},
+			}
+			if p.Description != "" {
+				param["description"] = p.Description
+			}
+			params = append(params, param)
+		}
+		if len(params) > 0 {
+			o["parameters"] = params
+		}
+		if op.Request != nil {
+			ct := op.RequestType
+			if ct == "" {
+				ct = "application/json"
+			}
+			o["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{ct: map[string]interface{}{"schema": g.value(op.Request)}},
+			}
+		}
+
+		ok := map[string]interface{}{"description": "OK"}
+		okStatus := "200"
+		switch {
+		case op.Redirect:
+			okStatus = "302"
+			ok = map[string]interface{}{"description": "Redirect to the frontend or identity provider"}
+		case op.ContentType != "" && op.ContentType != "application/json":
+			body := map[string]interface{}{"type": "string"}
+			if op.ContentType == Binary {
+				body["format"] = "binary"
+			}
+			ok["content"] = map[string]interface{}{op.ContentType: map[string]interface{}{"schema": body}}
+		case op.Response != nil:
+			ok["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": g.value(op.Response)}}
+		default:
+			ok["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": g.value(StatusResponse{})}}
+		}
+		o["responses"] = map[string]interface{}{
+			okStatus:  ok,
+			"default": map[string]interface{}{"description": "Error", "content": map[string]interface{}{"application/json": map[string]interface{}{"schema": errorRef}}},
+		}
+		switch op.Access {
+		case Public:
+			o["security"] = []interface{}{}
+		case User:
+			o["security"] = []interface{}{
+				map[string]interface{}{"bearerAuth": []string{}},
+				map[string]interface{}{"sessionCookie": []string{}},
+			}
+		case Admin, SuperAdmin:
+			o["security"] = []interface{}{
+				map[string]interface{}{"bearerAuth": []string{}},
+				map[string]interface{}{"adminSessionCookie": []string{}},
+			}
+			role := "admin"
+			if op.Access == SuperAdmin {
+				role = "super_admin"
+			}
+			o["x-required-role"] = role
+		}
+		item[strings.ToLower(op.Method)] = o
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   r.title,
+			"version": r.version,
+			"description": "Errors are returned as {\"error\": \"...\"} with a 4xx/5xx status, in the language negotiated from " +
+				"the user's preference or Accept-Language. In cookie session mode, state-changing requests that carry a " +
+				"session cookie must echo the askflow_csrf cookie in the X-CSRF-Token header.",
+		},
+		"tags":  tags,
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth":         map[string]interface{}{"type": "http", "scheme": "bearer", "description": "Session token returned by a login endpoint"},
+				"sessionCookie":      map[string]interface{}{"type": "apiKey", "in": "cookie", "name": "askflow_session"},
+				"adminSessionCookie": map[string]interface{}{"type": "apiKey", "in": "cookie", "name": "askflow_admin_session"},
+			},
+		},
+	}
+}
+
+// ErrorResponse is the body of every error response.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// StatusResponse is the body of operations that only report success.
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+// operationID derives a stable operation ID such as getApiProductsId.
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == '{' || r == '}' || r == '-' || r == '_' || r == '.'
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+// generator turns Go types into JSON schemas, collecting named struct types
+// under components/schemas.
+type generator struct {
+	schemas map[string]interface{}
+	names   map[reflect.Type]string
+	taken   map[string]reflect.Type
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *generator) value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case Schema:
+		return map[string]interface{}(v)
+	case Props:
+		props := map[string]interface{}{}
+		for name, pv := range v {
+			props[name] = g.value(pv)
+		}
+		return map[string]interface{}{"type": "object", "properties": props}
+	}
+	return g.schema(reflect.TypeOf(v))
+}
+
+func (g *generator) schema(t reflect.Type) map[string]interface{} {
+	if t == nil {
+		return map[string]interface{}{}
+	}
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(json.RawMessage{}):
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := g.schema(t.Elem())
+		if _, isRef := s["$ref"]; isRef {
+			return map[string]interface{}{"allOf": []interface{}{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name, ok := g.names[t]
+		if !ok {
+			name = g.name(t)
+			g.names[t] = name
+			g.schemas[name] = map[string]interface{}{} // placeholder for recursive types
+			g.schemas[name] = g.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	// interface{} and anything else: any value
+	return map[string]interface{}{}
+}
+
+// name picks the component name of a struct type: its Go name, prefixed
+// with the package name when another package already uses it.
+func (g *generator) name(t reflect.Type) string {
+	name := t.Name()
+	if other, taken := g.taken[name]; taken && other != t {
+		pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	g.taken[name] = t
+	return name
+}
+
+// object builds the schema of a struct's JSON encoding, flattening embedded
+// structs as encoding/json does.
+func (g *generator) object(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	var required []string
+	g.fields(t, props, &required)
+	s := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		sort.Strings(required)
+		s["required"] = required
+	}
+	return s
+}
+
+func (g *generator) fields(t reflect.Type, props map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.fields(ft, props, required)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.schema(ft)
+		if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") && ft.Kind() != reflect.Ptr {
+			*required = append(*required, name)
+		}
+	}
+}
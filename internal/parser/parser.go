@@ -12,6 +12,7 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"unicode/utf8"
 
 	gopdf "github.com/VantageDataChat/GoPDF2"
 	goexcel "github.com/VantageDataChat/GoExcel"
@@ -27,6 +28,49 @@ type ParseResult struct {
 	Text     string            `json:"text"`
 	Metadata map[string]string `json:"metadata"`
 	Images   []ImageRef        `json:"images,omitempty"`
+	Sections []Section         `json:"sections,omitempty"` // page or slide starts in Text (PDF, PPT)
+}
+
+// Section marks where a 1-based PDF page or PPT slide begins in
+// ParseResult.Text, as a rune offset. Pages without text are omitted.
+type Section struct {
+	Number int `json:"number"`
+	Start  int `json:"start"`
+}
+
+// SectionAt returns the number of the section containing rune offset pos,
+// or 0 if sections is empty or pos precedes the first section.
+func SectionAt(sections []Section, pos int) int {
+	number := 0
+	for _, sec := range sections {
+		if sec.Start > pos {
+			break
+		}
+		number = sec.Number
+	}
+	return number
+}
+
+// sectionBuilder joins cleaned page or slide texts with blank lines and
+// records where each one starts.
+type sectionBuilder struct {
+	sb       strings.Builder
+	runes    int
+	sections []Section
+}
+
+func (b *sectionBuilder) add(number int, text string) {
+	text = CleanText(text)
+	if text == "" {
+		return
+	}
+	if b.sb.Len() > 0 {
+		b.sb.WriteString("\n\n")
+		b.runes += 2
+	}
+	b.sections = append(b.sections, Section{Number: number, Start: b.runes})
+	b.sb.WriteString(text)
+	b.runes += utf8.RuneCountInString(text)
 }
 
 // ImageRef represents an image extracted from a document.
@@ -35,6 +79,7 @@ type ImageRef struct {
 	URL       string `json:"url"`       // external URL or relative path
 	Data      []byte `json:"-"`         // raw image data (for embedded images)
 	SlideText string `json:"slide_text,omitempty"` // per-slide text (for PPT: the text content of this slide)
+	Page      int    `json:"page,omitempty"`       // 1-based PDF page or PPT slide the image came from
 }
 
 // Parse dispatches to the correct parser based on fileType.
@@ -92,19 +137,14 @@ func (dp *DocumentParser) parsePDF(data []byte) (result *ParseResult, err error)
 		return nil, fmt.Errorf("pdf解析错误: %w", err)
 	}
 
-	// Extract text page by page
-	var sb strings.Builder
+	// Extract text page by page, cleaning each page so page starts stay exact
+	var pages sectionBuilder
 	for i := 0; i < pageCount; i++ {
 		text, err := gopdf.ExtractPageText(data, i)
 		if err != nil {
 			continue
 		}
-		if text != "" {
-			if sb.Len() > 0 {
-				sb.WriteString("\n\n")
-			}
-			sb.WriteString(text)
-		}
+		pages.add(i+1, text)
 	}
 
 	// Extract images (best-effort, non-fatal)
@@ -126,6 +166,7 @@ func (dp *DocumentParser) parsePDF(data []byte) (result *ParseResult, err error)
 					images = append(images, ImageRef{
 						Alt:  fmt.Sprintf("PDF第%d页图片%d", pageIdx+1, j+1),
 						Data: img.Data,
+						Page: pageIdx + 1,
 					})
 				}
 			}
@@ -133,13 +174,14 @@ func (dp *DocumentParser) parsePDF(data []byte) (result *ParseResult, err error)
 	}()
 
 	return &ParseResult{
-		Text: CleanText(sb.String()),
+		Text: pages.sb.String(),
 		Metadata: map[string]string{
 			"type":        "pdf",
 			"page_count":  fmt.Sprintf("%d", pageCount),
 			"image_count": fmt.Sprintf("%d", len(images)),
 		},
-		Images: images,
+		Images:   images,
+		Sections: pages.sections,
 	}, nil
 }
 
@@ -244,7 +286,7 @@ func (dp *DocumentParser) parsePPT(data []byte) (result *ParseResult, err error)
 
 	slides := pres.Slides()
 	log.Printf("[PPT] Found %d slides", len(slides))
-	var sb strings.Builder
+	var slideSections sectionBuilder
 
 	// Extract text from all slides first
 	slideTexts := make([]string, len(slides))
@@ -252,10 +294,7 @@ func (dp *DocumentParser) parsePPT(data []byte) (result *ParseResult, err error)
 		text := slide.ExtractText()
 		slideTexts[i] = text
 		if text != "" {
-			if sb.Len() > 0 {
-				sb.WriteString("\n\n")
-			}
-			sb.WriteString(fmt.Sprintf("Slide %d:\n%s", i+1, text))
+			slideSections.add(i+1, fmt.Sprintf("Slide %d:\n%s", i+1, text))
 		}
 	}
 	log.Printf("[PPT] Text extraction completed")
@@ -309,19 +348,21 @@ func (dp *DocumentParser) parsePPT(data []byte) (result *ParseResult, err error)
 			Alt:       alt,
 			Data:      buf.Bytes(),
 			SlideText: strings.TrimSpace(text),
+			Page:      i + 1,
 		})
 	}
 
 	log.Printf("[PPT] PPT parsing completed: %d slides, %d images", len(slides), len(images))
 
 	return &ParseResult{
-		Text: CleanText(sb.String()),
+		Text: slideSections.sb.String(),
 		Metadata: map[string]string{
 			"type":        "ppt",
 			"slide_count": fmt.Sprintf("%d", len(slides)),
 			"image_count": fmt.Sprintf("%d", len(images)),
 		},
-		Images: images,
+		Images:   images,
+		Sections: slideSections.sections,
 	}, nil
 }
 
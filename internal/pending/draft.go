@@ -0,0 +1,178 @@
+package pending
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"askflow/internal/config"
+)
+
+// answerDocPrefix is the document ID prefix of admin answers stored in the
+// vector store by AnswerQuestion.
+const answerDocPrefix = "pending-answer-"
+
+// draftPrompt is the system prompt used to draft suggested answers.
+const draftPrompt = "你正在为客服管理员起草一条待审核的回答草稿。请只根据参考资料回答用户的问题，" +
+	"标注为「管理员历史回答」的资料是管理员此前确认过的答复，应优先采用其中的说法。" +
+	"参考资料不足以回答的部分请明确写出「[待补充]」，不要编造。" +
+	"请使用与用户提问相同的语言，回答应简洁、准确、有条理。"
+
+// DraftSource is a document a drafted answer was based on.
+type DraftSource struct {
+	DocumentID   string  `json:"document_id"`
+	DocumentName string  `json:"document_name"`
+	Score        float64 `json:"score"`
+	AdminAnswer  bool    `json:"admin_answer,omitempty"` // an earlier admin answer rather than a document
+}
+
+// SetDraftConfig sets the function returning the current draft settings.
+// Drafting is disabled until it is set.
+func (pm *PendingQuestionManager) SetDraftConfig(fn func() config.PendingDraftConfig) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.draftConfig = fn
+}
+
+// currentDraftConfig returns the draft settings, with defaults filled in.
+func (pm *PendingQuestionManager) currentDraftConfig() config.PendingDraftConfig {
+	pm.mu.RLock()
+	fn := pm.draftConfig
+	pm.mu.RUnlock()
+	var cfg config.PendingDraftConfig
+	if fn != nil {
+		cfg = fn()
+	}
+	if cfg.TopK <= 0 {
+		cfg.TopK = 8
+	}
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = 0.3
+	}
+	return cfg
+}
+
+// DraftAsync drafts a suggested answer for a new pending question in the
+// background when pending_draft.enabled is set.
+func (pm *PendingQuestionManager) DraftAsync(id string) {
+	if !pm.currentDraftConfig().Enabled {
+		return
+	}
+	go func() {
+		pm.draftSem <- struct{}{}
+		defer func() { <-pm.draftSem }()
+		if err := pm.Draft(context.Background(), id); err != nil {
+			log.Printf("[Pending] draft failed for %s: %v", id, err)
+		}
+	}()
+}
+
+// Draft searches the knowledge base, including earlier admin answers, with
+// the relaxed pending_draft threshold and asks the LLM to draft an answer to
+// the pending question. The draft is stored on the record for the admin to
+// approve or edit; it is never shown to the user. Answered questions are
+// left unchanged. Drafting stops once ctx is done.
+func (pm *PendingQuestionManager) Draft(ctx context.Context, id string) error {
+	var question, status, productID string
+	err := pm.db.QueryRow(
+		`SELECT question, status, COALESCE(product_id, '') FROM pending_questions WHERE id = ?`, id,
+	).Scan(&question, &status, &productID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("pending question not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query pending question: %w", err)
+	}
+	if status != "pending" {
+		return nil
+	}
+	if _, err := pm.db.Exec(`UPDATE pending_questions SET draft_status = 'drafting' WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to update draft status: %w", err)
+	}
+
+	answer, sources, err := pm.draft(ctx, question, productID)
+	draftStatus := "ready"
+	switch {
+	case err != nil:
+		draftStatus = "failed"
+	case len(sources) == 0:
+		draftStatus = "no_context"
+	}
+	sourcesJSON := ""
+	if len(sources) > 0 {
+		b, _ := json.Marshal(sources)
+		sourcesJSON = string(b)
+	}
+	// Skip the update if an admin answered the question in the meantime
+	if _, dbErr := pm.db.Exec(
+		`UPDATE pending_questions SET draft_answer = ?, draft_sources = ?, draft_status = ?, drafted_at = ? WHERE id = ? AND status = 'pending'`,
+		answer, sourcesJSON, draftStatus, time.Now().UTC(), id,
+	); dbErr != nil {
+		return fmt.Errorf("failed to store draft: %w", dbErr)
+	}
+	return err
+}
+
+// draft retrieves context for question and generates the suggested answer.
+// It returns no sources and no answer when nothing relevant was found.
+func (pm *PendingQuestionManager) draft(ctx context.Context, question, productID string) (string, []DraftSource, error) {
+	cfg := pm.currentDraftConfig()
+	pm.mu.RLock()
+	es, ls := pm.embeddingService, pm.llmService
+	pm.mu.RUnlock()
+	if es == nil || ls == nil {
+		return "", nil, fmt.Errorf("embedding or LLM service not configured")
+	}
+
+	vec, err := es.Embed(ctx, question)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to embed question: %w", err)
+	}
+	// Fetch extra results so admin answers are not crowded out by documents
+	results, err := pm.vectorStore.Search(ctx, vec, cfg.TopK*2, cfg.Threshold, productID)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to search knowledge base: %w", err)
+	}
+
+	var chunks []string
+	var sources []DraftSource
+	seen := make(map[string]bool)
+	// Admin answers first, then documents, each in score order
+	for _, adminAnswers := range []bool{true, false} {
+		for _, r := range results {
+			if len(chunks) >= cfg.TopK {
+				break
+			}
+			if strings.HasPrefix(r.DocumentID, answerDocPrefix) != adminAnswers || strings.TrimSpace(r.ChunkText) == "" {
+				continue
+			}
+			label := "文档《" + r.DocumentName + "》"
+			if adminAnswers {
+				label = "管理员历史回答"
+			}
+			chunks = append(chunks, "【"+label+"】\n"+r.ChunkText)
+			if !seen[r.DocumentID] {
+				seen[r.DocumentID] = true
+				sources = append(sources, DraftSource{
+					DocumentID:   r.DocumentID,
+					DocumentName: r.DocumentName,
+					Score:        r.Score,
+					AdminAnswer:  adminAnswers,
+				})
+			}
+		}
+	}
+	if len(chunks) == 0 {
+		return "", nil, nil
+	}
+
+	answer, err := ls.Generate(ctx, draftPrompt, chunks, question)
+	if err != nil {
+		return "", sources, fmt.Errorf("failed to generate draft: %w", err)
+	}
+	return strings.TrimSpace(answer), sources, nil
+}
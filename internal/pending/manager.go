@@ -3,15 +3,18 @@
 package pending
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
 	"sync"
 	"time"
 
 	"askflow/internal/chunker"
+	"askflow/internal/config"
 	"askflow/internal/embedding"
 	"askflow/internal/llm"
 	"askflow/internal/vectorstore"
@@ -29,6 +32,16 @@ type PendingQuestion struct {
 	ProductID   string    `json:"product_id"`
 	ProductName string    `json:"product_name"`
 	CreatedAt   time.Time `json:"created_at"`
+
+	// Suggested answer drafted by the LLM, see Draft.
+	DraftAnswer  string        `json:"draft_answer,omitempty"`
+	DraftSources []DraftSource `json:"draft_sources,omitempty"`
+	DraftStatus  string        `json:"draft_status,omitempty"` // "drafting", "ready", "no_context", "failed"
+	DraftedAt    *time.Time    `json:"drafted_at,omitempty"`
+
+	// Escalation of an overdue question, see SLAService.
+	EscalationLevel int        `json:"escalation_level,omitempty"`
+	EscalatedAt     *time.Time `json:"escalated_at,omitempty"`
 }
 
 
@@ -51,6 +64,9 @@ type PendingQuestionManager struct {
 	embeddingService embedding.EmbeddingService
 	vectorStore      vectorstore.VectorStore
 	llmService       llm.LLMService
+
+	draftConfig func() config.PendingDraftConfig
+	draftSem    chan struct{} // bounds concurrent background drafts
 }
 
 // NewPendingQuestionManager creates a new PendingQuestionManager with the given dependencies.
@@ -67,6 +83,7 @@ func NewPendingQuestionManager(
 		embeddingService: es,
 		vectorStore:      vs,
 		llmService:       ls,
+		draftSem:         make(chan struct{}, 2),
 	}
 }
 
@@ -148,8 +165,34 @@ func (pm *PendingQuestionManager) DeletePending(id string) error {
 	return nil
 }
 
+// CountByStatus counts the questions of one product created in
+// [start, end), keyed by status. Unlike ListPending it does not include the
+// public library.
+func (pm *PendingQuestionManager) CountByStatus(productID string, start, end time.Time) (map[string]int, error) {
+	rows, err := pm.db.Query(
+		`SELECT status, COUNT(*) FROM pending_questions
+		 WHERE product_id = ? AND created_at >= ? AND created_at < ? GROUP BY status`,
+		productID, start.UTC(), end.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count pending questions: %w", err)
+	}
+	defer rows.Close()
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, fmt.Errorf("failed to scan pending count: %w", err)
+		}
+		counts[status] = n
+	}
+	return counts, rows.Err()
+}
+
 // ListPending returns pending questions filtered by status and/or productID,
-// ordered by created_at DESC. When productID is non-empty, only questions matching
+// unanswered questions with the highest escalation level first, then by
+// created_at DESC. When productID is non-empty, only questions matching
 // that product or the public library (empty product_id) are returned.
 // Product names are resolved via LEFT JOIN with the products table.
 func (pm *PendingQuestionManager) ListPending(status string, productID string) ([]PendingQuestion, error) {
@@ -161,7 +204,8 @@ func (pm *PendingQuestionManager) ListPending(status string, productID string) (
 	var rows *sql.Rows
 	var err error
 
-	baseSelect := `SELECT pq.id, pq.question, pq.user_id, COALESCE(u.name, '') AS user_name, pq.status, pq.answer, pq.image_data, pq.product_id, COALESCE(p.name, '') AS product_name, pq.created_at
+	baseSelect := `SELECT pq.id, pq.question, pq.user_id, COALESCE(u.name, '') AS user_name, pq.status, pq.answer, pq.image_data, pq.product_id, COALESCE(p.name, '') AS product_name, pq.created_at,
+		pq.draft_answer, pq.draft_sources, pq.draft_status, pq.drafted_at, pq.escalation_level, pq.escalated_at
 		FROM pending_questions pq
 		LEFT JOIN products p ON pq.product_id = p.id
 		LEFT JOIN users u ON pq.user_id = u.id`
@@ -185,7 +229,7 @@ func (pm *PendingQuestionManager) ListPending(status string, productID string) (
 			query += " AND " + conditions[i]
 		}
 	}
-	query += " ORDER BY pq.created_at DESC"
+	query += " ORDER BY CASE WHEN pq.status = 'pending' THEN pq.escalation_level ELSE 0 END DESC, pq.created_at DESC"
 
 	rows, err = pm.db.Query(query, args...)
 	if err != nil {
@@ -201,7 +245,10 @@ func (pm *PendingQuestionManager) ListPending(status string, productID string) (
 		var userName sql.NullString
 		var productName sql.NullString
 		var createdAt sql.NullTime
-		if err := rows.Scan(&q.ID, &q.Question, &q.UserID, &userName, &q.Status, &answer, &imageData, &q.ProductID, &productName, &createdAt); err != nil {
+		var draftSources string
+		var draftedAt, escalatedAt sql.NullTime
+		if err := rows.Scan(&q.ID, &q.Question, &q.UserID, &userName, &q.Status, &answer, &imageData, &q.ProductID, &productName, &createdAt,
+			&q.DraftAnswer, &draftSources, &q.DraftStatus, &draftedAt, &q.EscalationLevel, &escalatedAt); err != nil {
 			return nil, fmt.Errorf("failed to scan pending question row: %w", err)
 		}
 		if answer.Valid {
@@ -216,6 +263,17 @@ func (pm *PendingQuestionManager) ListPending(status string, productID string) (
 		if createdAt.Valid {
 			q.CreatedAt = createdAt.Time
 		}
+		if draftSources != "" {
+			_ = json.Unmarshal([]byte(draftSources), &q.DraftSources)
+		}
+		if draftedAt.Valid {
+			t := draftedAt.Time
+			q.DraftedAt = &t
+		}
+		if escalatedAt.Valid {
+			t := escalatedAt.Time
+			q.EscalatedAt = &t
+		}
 		if q.ProductID == "" {
 			q.ProductName = "公共库"
 		} else if productName.Valid && productName.String != "" {
@@ -235,7 +293,9 @@ func (pm *PendingQuestionManager) ListPending(status string, productID string) (
 // 3. Chunks the answer text → embeds → stores in vector store (knowledge base)
 // 4. Calls LLM to generate a summary answer based on the admin's answer
 // 5. Updates the record with llm_answer, status="answered", answered_at=now
-func (pm *PendingQuestionManager) AnswerQuestion(req AdminAnswerRequest) error {
+//
+// The embedding and LLM calls stop once ctx is done.
+func (pm *PendingQuestionManager) AnswerQuestion(ctx context.Context, req AdminAnswerRequest) error {
 	// Validate inputs
 	if req.QuestionID == "" {
 		return fmt.Errorf("question_id is required")
@@ -301,7 +361,7 @@ func (pm *PendingQuestionManager) AnswerQuestion(req AdminAnswerRequest) error {
 				texts[i] = c.Text
 			}
 
-			embeddings, err := pm.embeddingService.EmbedBatch(texts)
+			embeddings, err := pm.embeddingService.EmbedBatch(ctx, texts)
 			if err != nil {
 				return fmt.Errorf("failed to embed answer chunks: %w", err)
 			}
@@ -348,7 +408,7 @@ func (pm *PendingQuestionManager) AnswerQuestion(req AdminAnswerRequest) error {
 
 		imgText := fmt.Sprintf("[图片回答: %s] %s", truncate(question, 50), answerText)
 		// Embed the text once and reuse the vector for all images (same text → same embedding)
-		imgVec, embErr := pm.embeddingService.Embed(imgText)
+		imgVec, embErr := pm.embeddingService.Embed(ctx, imgText)
 		if embErr != nil {
 			log.Printf("Warning: failed to embed answer image text: %v", embErr)
 		} else {
@@ -376,7 +436,7 @@ func (pm *PendingQuestionManager) AnswerQuestion(req AdminAnswerRequest) error {
 	}
 
 	// Step 4: Call LLM to generate a summary answer
-	llmAnswer, err := pm.llmService.Generate(
+	llmAnswer, err := pm.llmService.Generate(ctx,
 		"请根据管理员提供的回答内容，生成一个简洁、清晰的总结性回答。",
 		[]string{answerText},
 		question,
@@ -0,0 +1,427 @@
+package pending
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"askflow/internal/lease"
+)
+
+// slaCheckInterval is how often SLAService looks for overdue questions.
+const slaCheckInterval = 5 * time.Minute
+
+// Bounds of an SLA policy.
+const (
+	maxResponseHours = 24 * 365
+	maxReminders     = 10
+	maxRecipients    = 20
+)
+
+// ErrNotFound is returned for an unknown SLA policy.
+var ErrNotFound = errors.New("not found")
+
+// SLAPolicy is the response time target for the pending questions of a
+// product; ProductID "" is the default policy. A question still pending
+// ResponseHours after it was asked is overdue, and escalates one level for
+// every further ResponseHours up to MaxReminders levels; each escalation
+// emails Recipients.
+type SLAPolicy struct {
+	ProductID     string    `json:"product_id"`
+	ResponseHours int       `json:"response_hours"`
+	MaxReminders  int       `json:"max_reminders"`
+	Recipients    []string  `json:"recipients"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// OverdueQuestion is a pending question past its policy's response time.
+type OverdueQuestion struct {
+	ID              string     `json:"id"`
+	Question        string     `json:"question"`
+	UserID          string     `json:"user_id"`
+	ProductID       string     `json:"product_id"`
+	ProductName     string     `json:"product_name"`
+	CreatedAt       time.Time  `json:"created_at"`
+	DueAt           time.Time  `json:"due_at"`
+	OverdueHours    float64    `json:"overdue_hours"`
+	EscalationLevel int        `json:"escalation_level"`
+	EscalatedAt     *time.Time `json:"escalated_at,omitempty"`
+
+	policy *SLAPolicy
+}
+
+// SLAService stores SLA policies and escalates overdue pending questions in
+// the background.
+type SLAService struct {
+	readDB  *sql.DB
+	writeDB *sql.DB
+	send    func(to, subject, body string) error
+	locks   *lease.Manager
+
+	// policies caches all policies by product ID.
+	mu          sync.RWMutex
+	policies    map[string]*SLAPolicy
+	onEscalated func(q OverdueQuestion)
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewSLAService creates an SLAService; send delivers reminder emails.
+func NewSLAService(readDB, writeDB *sql.DB, send func(to, subject, body string) error) *SLAService {
+	return &SLAService{
+		readDB:  readDB,
+		writeDB: writeDB,
+		send:    send,
+		stop:    make(chan struct{}),
+	}
+}
+
+// SetEscalatedHook sets a function called for every question that escalates
+// to a new level.
+func (s *SLAService) SetEscalatedHook(fn func(q OverdueQuestion)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onEscalated = fn
+}
+
+// validateSLA normalises and checks a policy.
+func validateSLA(p *SLAPolicy) error {
+	if p.ResponseHours < 1 || p.ResponseHours > maxResponseHours {
+		return fmt.Errorf("response_hours must be between 1 and %d", maxResponseHours)
+	}
+	if p.MaxReminders == 0 {
+		p.MaxReminders = 3
+	}
+	if p.MaxReminders < 1 || p.MaxReminders > maxReminders {
+		return fmt.Errorf("max_reminders must be between 1 and %d", maxReminders)
+	}
+	seen := make(map[string]bool, len(p.Recipients))
+	recipients := make([]string, 0, len(p.Recipients))
+	for _, addr := range p.Recipients {
+		addr = strings.TrimSpace(addr)
+		if addr == "" || seen[strings.ToLower(addr)] {
+			continue
+		}
+		if !strings.Contains(addr, "@") || strings.ContainsAny(addr, " \r\n<>,") || len(addr) > 254 {
+			return fmt.Errorf("invalid email address %q", addr)
+		}
+		seen[strings.ToLower(addr)] = true
+		recipients = append(recipients, addr)
+	}
+	if len(recipients) > maxRecipients {
+		return fmt.Errorf("too many recipients (max %d)", maxRecipients)
+	}
+	p.Recipients = recipients
+	return nil
+}
+
+// load reads all policies into the cache if needed.
+func (s *SLAService) load() (map[string]*SLAPolicy, error) {
+	s.mu.RLock()
+	policies := s.policies
+	s.mu.RUnlock()
+	if policies != nil {
+		return policies, nil
+	}
+
+	rows, err := s.readDB.Query(`SELECT product_id, response_hours, max_reminders, recipients, updated_at FROM pending_sla_policies`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	policies = map[string]*SLAPolicy{}
+	for rows.Next() {
+		var p SLAPolicy
+		var recipients string
+		var updatedAt sql.NullTime
+		if err := rows.Scan(&p.ProductID, &p.ResponseHours, &p.MaxReminders, &recipients, &updatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(recipients), &p.Recipients); err != nil {
+			return nil, fmt.Errorf("invalid recipients of SLA policy %q: %w", p.ProductID, err)
+		}
+		p.UpdatedAt = updatedAt.Time
+		policies[p.ProductID] = &p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.policies = policies
+	s.mu.Unlock()
+	return policies, nil
+}
+
+// invalidate drops the cached policies after a change.
+func (s *SLAService) invalidate() {
+	s.mu.Lock()
+	s.policies = nil
+	s.mu.Unlock()
+}
+
+// ListPolicies returns all policies, the default first.
+func (s *SLAService) ListPolicies() ([]SLAPolicy, error) {
+	policies, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]SLAPolicy, 0, len(policies))
+	if p := policies[""]; p != nil {
+		list = append(list, *p)
+	}
+	for id, p := range policies {
+		if id != "" {
+			list = append(list, *p)
+		}
+	}
+	return list, nil
+}
+
+// SetPolicy creates or replaces the policy of p.ProductID.
+func (s *SLAService) SetPolicy(p SLAPolicy) (*SLAPolicy, error) {
+	if err := validateSLA(&p); err != nil {
+		return nil, err
+	}
+	recipients, _ := json.Marshal(p.Recipients)
+	p.UpdatedAt = time.Now().UTC()
+	_, err := s.writeDB.Exec(
+		`INSERT INTO pending_sla_policies (product_id, response_hours, max_reminders, recipients, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(product_id) DO UPDATE SET response_hours = excluded.response_hours, max_reminders = excluded.max_reminders,
+			recipients = excluded.recipients, updated_at = excluded.updated_at`,
+		p.ProductID, p.ResponseHours, p.MaxReminders, string(recipients), p.UpdatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save SLA policy: %w", err)
+	}
+	s.invalidate()
+	return &p, nil
+}
+
+// DeletePolicy removes the policy of productID, so the product falls back to
+// the default policy (or, for the default, to no SLA).
+func (s *SLAService) DeletePolicy(productID string) error {
+	res, err := s.writeDB.Exec(`DELETE FROM pending_sla_policies WHERE product_id = ?`, productID)
+	if err != nil {
+		return fmt.Errorf("failed to delete SLA policy: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	s.invalidate()
+	return nil
+}
+
+// Overdue returns the pending questions past their response time at now,
+// longest overdue first. When productID is non-empty, only questions of that
+// product or the public library are returned, as in ListPending.
+func (s *SLAService) Overdue(productID string, now time.Time) ([]OverdueQuestion, error) {
+	policies, err := s.load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SLA policies: %w", err)
+	}
+	if len(policies) == 0 {
+		return []OverdueQuestion{}, nil
+	}
+
+	query := `SELECT pq.id, pq.question, pq.user_id, COALESCE(pq.product_id, ''), COALESCE(p.name, ''), pq.created_at,
+		pq.escalation_level, pq.escalated_at
+		FROM pending_questions pq
+		LEFT JOIN products p ON pq.product_id = p.id
+		WHERE pq.status = 'pending'`
+	var args []interface{}
+	if productID != "" {
+		query += ` AND (pq.product_id = ? OR pq.product_id = '')`
+		args = append(args, productID)
+	}
+	rows, err := s.readDB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pending questions: %w", err)
+	}
+	defer rows.Close()
+
+	overdue := []OverdueQuestion{}
+	for rows.Next() {
+		var q OverdueQuestion
+		var createdAt, escalatedAt sql.NullTime
+		if err := rows.Scan(&q.ID, &q.Question, &q.UserID, &q.ProductID, &q.ProductName, &createdAt,
+			&q.EscalationLevel, &escalatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan pending question row: %w", err)
+		}
+		p := policies[q.ProductID]
+		if p == nil {
+			p = policies[""]
+		}
+		if p == nil || !createdAt.Valid {
+			continue
+		}
+		q.CreatedAt = createdAt.Time
+		q.DueAt = q.CreatedAt.Add(time.Duration(p.ResponseHours) * time.Hour)
+		if !now.After(q.DueAt) {
+			continue
+		}
+		q.OverdueHours = float64(now.Sub(q.DueAt).Round(time.Minute)) / float64(time.Hour)
+		if escalatedAt.Valid {
+			t := escalatedAt.Time
+			q.EscalatedAt = &t
+		}
+		if q.ProductID == "" {
+			q.ProductName = "公共库"
+		}
+		q.policy = p
+		overdue = append(overdue, q)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating pending question rows: %w", err)
+	}
+	sort.SliceStable(overdue, func(i, j int) bool { return overdue[i].DueAt.Before(overdue[j].DueAt) })
+	return overdue, nil
+}
+
+// SetLocks makes each check take the "sla" lease first, so that of the
+// instances sharing the database one checks per interval. Call it before
+// Start.
+func (s *SLAService) SetLocks(m *lease.Manager) {
+	s.locks = m
+}
+
+// Start launches the escalation loop.
+func (s *SLAService) Start() {
+	s.wg.Add(1)
+	go s.loop()
+}
+
+// Stop ends the escalation loop and waits until ctx is done for a running
+// check to finish.
+func (s *SLAService) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *SLAService) loop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(slaCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		l, err := s.locks.Acquire("sla", now.Truncate(slaCheckInterval))
+		if err != nil {
+			if !errors.Is(err, lease.ErrHeld) && !errors.Is(err, lease.ErrTaken) {
+				log.Printf("[SLA] escalation check skipped: %v", err)
+			}
+			continue
+		}
+		if err := s.Escalate(now); err != nil {
+			log.Printf("[SLA] escalation check failed: %v", err)
+		}
+		l.Release()
+	}
+}
+
+// Escalate raises the escalation level of the questions overdue at now to
+// one plus the number of whole response times they are overdue by, capped
+// at their policy's MaxReminders, and emails each policy's recipients about
+// the questions that escalated.
+func (s *SLAService) Escalate(now time.Time) error {
+	overdue, err := s.Overdue("", now)
+	if err != nil {
+		return err
+	}
+	type batch struct {
+		policy    *SLAPolicy
+		questions []OverdueQuestion
+	}
+	var batches []*batch
+	byPolicy := make(map[*SLAPolicy]*batch)
+	var errs []error
+	for _, q := range overdue {
+		period := time.Duration(q.policy.ResponseHours) * time.Hour
+		level := min(int(now.Sub(q.DueAt)/period)+1, q.policy.MaxReminders)
+		if level <= q.EscalationLevel {
+			continue
+		}
+		res, err := s.writeDB.Exec(
+			`UPDATE pending_questions SET escalation_level = ?, escalated_at = ?
+			 WHERE id = ? AND status = 'pending' AND escalation_level < ?`,
+			level, now.UTC(), q.ID, level,
+		)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("escalate %s: %w", q.ID, err))
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue // answered or escalated meanwhile
+		}
+		q.EscalationLevel = level
+		t := now.UTC()
+		q.EscalatedAt = &t
+		b := byPolicy[q.policy]
+		if b == nil {
+			b = &batch{policy: q.policy}
+			byPolicy[q.policy] = b
+			batches = append(batches, b)
+		}
+		b.questions = append(b.questions, q)
+	}
+
+	s.mu.RLock()
+	hook := s.onEscalated
+	s.mu.RUnlock()
+	for _, b := range batches {
+		log.Printf("[SLA] %d overdue questions escalated (policy %q)", len(b.questions), b.policy.ProductID)
+		if hook != nil {
+			for _, q := range b.questions {
+				hook(q)
+			}
+		}
+		if len(b.policy.Recipients) == 0 {
+			continue
+		}
+		subject, body := formatReminder(b.questions, b.policy.ResponseHours)
+		for _, to := range b.policy.Recipients {
+			if err := s.send(to, subject, body); err != nil {
+				errs = append(errs, fmt.Errorf("send to %s: %w", to, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// formatReminder returns the subject and plain-text body of the reminder
+// about questions that escalated.
+func formatReminder(questions []OverdueQuestion, responseHours int) (subject, body string) {
+	subject = fmt.Sprintf("%d 个待处理问题已超过 %d 小时未回答", len(questions), responseHours)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "以下问题已超过响应时限（%d 小时），请尽快处理：\n", responseHours)
+	for i, q := range questions {
+		fmt.Fprintf(&sb, "\n%d. %s\n", i+1, truncate(q.Question, 200))
+		fmt.Fprintf(&sb, "   产品：%s；提问时间：%s；已超时 %.1f 小时；第 %d 次提醒\n",
+			q.ProductName, q.CreatedAt.Local().Format("2006-01-02 15:04"), q.OverdueHours, q.EscalationLevel)
+	}
+	return subject, sb.String()
+}
@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"fmt"
+	"net/url"
 	"strings"
 	"time"
 )
@@ -40,9 +41,15 @@ func NewProductService(readDB, writeDB *sql.DB) *ProductService {
 	return &ProductService{readDB: readDB, writeDB: writeDB}
 }
 
-// Create creates a new product with the given name, description, and welcome message.
+// Create creates a new product in the default workspace with the given name, description, and welcome message.
 // Returns an error if the name is empty or already exists.
 func (s *ProductService) Create(name, productType, description, welcomeMessage string, allowDownload bool) (*Product, error) {
+	return s.CreateInTenant("", name, productType, description, welcomeMessage, allowDownload)
+}
+
+// CreateInTenant creates a new product owned by the given tenant ("" is the default workspace).
+// Product names are unique across all tenants.
+func (s *ProductService) CreateInTenant(tenantID, name, productType, description, welcomeMessage string, allowDownload bool) (*Product, error) {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return nil, fmt.Errorf("product name cannot be empty")
@@ -80,8 +87,8 @@ func (s *ProductService) Create(name, productType, description, welcomeMessage s
 
 	now := time.Now()
 	_, err = s.writeDB.Exec(
-		"INSERT INTO products (id, name, type, description, welcome_message, allow_download, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
-		id, name, productType, description, welcomeMessage, allowDownload, now, now,
+		"INSERT INTO products (id, name, type, description, welcome_message, allow_download, tenant_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		id, name, productType, description, welcomeMessage, allowDownload, tenantID, now, now,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create product: %w", err)
@@ -209,9 +216,19 @@ func (s *ProductService) GetByID(id string) (*Product, error) {
 	return &p, nil
 }
 
-// List returns all products ordered by created_at.
+// List returns all products of all tenants ordered by created_at.
 func (s *ProductService) List() ([]Product, error) {
-	rows, err := s.readDB.Query("SELECT id, name, COALESCE(type, 'service'), description, welcome_message, COALESCE(allow_download, 0), created_at, updated_at FROM products ORDER BY created_at")
+	return s.queryProducts("SELECT id, name, COALESCE(type, 'service'), description, welcome_message, COALESCE(allow_download, 0), created_at, updated_at FROM products ORDER BY created_at")
+}
+
+// ListByTenant returns the products owned by a tenant ("" is the default workspace) ordered by created_at.
+func (s *ProductService) ListByTenant(tenantID string) ([]Product, error) {
+	return s.queryProducts("SELECT id, name, COALESCE(type, 'service'), description, welcome_message, COALESCE(allow_download, 0), created_at, updated_at FROM products WHERE tenant_id = ? ORDER BY created_at", tenantID)
+}
+
+// queryProducts runs a product SELECT with the column list used by List.
+func (s *ProductService) queryProducts(query string, args ...interface{}) ([]Product, error) {
+	rows, err := s.readDB.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list products: %w", err)
 	}
@@ -254,11 +271,18 @@ func (s *ProductService) HasProducts() (bool, error) {
 	return exists, nil
 }
 
-// GetFirstID returns the ID of the first product (by creation order), or empty string if none exist.
+// GetFirstID returns the ID of the first product (by creation order) of the default workspace,
+// or empty string if none exist.
 // This is more efficient than List() when only the default product ID is needed.
 func (s *ProductService) GetFirstID() (string, error) {
+	return s.GetFirstIDByTenant("")
+}
+
+// GetFirstIDByTenant returns the ID of the first product (by creation order) owned by a tenant,
+// or empty string if it has none.
+func (s *ProductService) GetFirstIDByTenant(tenantID string) (string, error) {
 	var id string
-	err := s.readDB.QueryRow("SELECT id FROM products ORDER BY created_at LIMIT 1").Scan(&id)
+	err := s.readDB.QueryRow("SELECT id FROM products WHERE tenant_id = ? ORDER BY created_at LIMIT 1", tenantID).Scan(&id)
 	if err == sql.ErrNoRows {
 		return "", nil
 	}
@@ -319,9 +343,13 @@ func (s *ProductService) GetByAdminUserID(adminUserID string) ([]Product, error)
 		return nil, fmt.Errorf("failed to iterate product ids: %w", err)
 	}
 
-	// Zero assignments means access to all products
+	// Zero assignments means access to all products of the admin's tenant
 	if len(productIDs) == 0 {
-		return s.List()
+		var tenantID string
+		if err := s.readDB.QueryRow("SELECT tenant_id FROM admin_users WHERE id = ?", adminUserID).Scan(&tenantID); err != nil && err != sql.ErrNoRows {
+			return nil, fmt.Errorf("failed to get admin user tenant: %w", err)
+		}
+		return s.ListByTenant(tenantID)
 	}
 
 	// Build query for assigned products
@@ -365,3 +393,127 @@ func generateID() (string, error) {
 	}
 	return hex.EncodeToString(b), nil
 }
+
+// GetWidgetOrigins returns the origins allowed to embed the chat widget for a product.
+// An empty list means the widget is disabled for the product.
+func (s *ProductService) GetWidgetOrigins(id string) ([]string, error) {
+	var raw string
+	err := s.readDB.QueryRow("SELECT COALESCE(widget_origins, '') FROM products WHERE id = ?", id).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("product not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get widget origins: %w", err)
+	}
+	var origins []string
+	for _, o := range strings.Split(raw, "\n") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return origins, nil
+}
+
+// SetWidgetOrigins replaces the widget origin allowlist of a product.
+// Each origin must be a bare scheme://host[:port] without path, e.g. "https://shop.example.com".
+func (s *ProductService) SetWidgetOrigins(id string, origins []string) error {
+	if len(origins) > 50 {
+		return fmt.Errorf("too many widget origins (max 50)")
+	}
+	var cleaned []string
+	seen := make(map[string]bool)
+	for _, o := range origins {
+		o = strings.TrimRight(strings.ToLower(strings.TrimSpace(o)), "/")
+		if o == "" || seen[o] {
+			continue
+		}
+		if err := validateOrigin(o); err != nil {
+			return err
+		}
+		seen[o] = true
+		cleaned = append(cleaned, o)
+	}
+
+	result, err := s.writeDB.Exec(
+		"UPDATE products SET widget_origins = ?, updated_at = ? WHERE id = ?",
+		strings.Join(cleaned, "\n"), time.Now(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update widget origins: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check update result: %w", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("product not found")
+	}
+	return nil
+}
+
+// GetEscalationThreshold returns the answer confidence below which answers
+// of a product are escalated to a human; 0 means never.
+func (s *ProductService) GetEscalationThreshold(id string) (float64, error) {
+	var threshold float64
+	err := s.readDB.QueryRow("SELECT COALESCE(escalation_threshold, 0) FROM products WHERE id = ?", id).Scan(&threshold)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("product not found")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get escalation threshold: %w", err)
+	}
+	return threshold, nil
+}
+
+// SetEscalationThreshold sets the answer confidence, between 0 and 1, below
+// which answers of a product are escalated to a human; 0 turns it off.
+func (s *ProductService) SetEscalationThreshold(id string, threshold float64) error {
+	if threshold < 0 || threshold > 1 {
+		return fmt.Errorf("escalation threshold must be between 0 and 1")
+	}
+	result, err := s.writeDB.Exec(
+		"UPDATE products SET escalation_threshold = ?, updated_at = ? WHERE id = ?",
+		threshold, time.Now(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update escalation threshold: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check update result: %w", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("product not found")
+	}
+	return nil
+}
+
+// IsWidgetOriginAllowed reports whether origin is in the product's widget allowlist.
+func (s *ProductService) IsWidgetOriginAllowed(id, origin string) bool {
+	if origin == "" {
+		return false
+	}
+	origins, err := s.GetWidgetOrigins(id)
+	if err != nil {
+		return false
+	}
+	origin = strings.TrimRight(strings.ToLower(origin), "/")
+	for _, o := range origins {
+		if o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// validateOrigin checks that o is an http(s) origin with a host and no path, query or credentials.
+func validateOrigin(o string) error {
+	u, err := url.Parse(o)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid widget origin: %s", o)
+	}
+	if u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("widget origin must not contain path, query or credentials: %s", o)
+	}
+	return nil
+}
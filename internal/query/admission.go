@@ -0,0 +1,192 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrOverloaded is returned when a query is turned away because too many
+// queries are running and waiting.
+var ErrOverloaded = errors.New("当前提问人数较多，请稍后重试")
+
+// Default admission limits, used until Resize is called.
+const (
+	defaultMaxInFlight  = 16
+	defaultAdmitQueue   = 64
+	defaultAdmitTimeout = 30 * time.Second
+)
+
+// Admission limits how many queries run through the pipeline at once, so a
+// burst of questions does not hit the embedding and LLM APIs all together.
+// Queries over the limit wait their turn in arrival order for up to the
+// queue timeout; when the queue is full, or the wait times out, they fail
+// with ErrOverloaded.
+type Admission struct {
+	mu       sync.Mutex
+	max      int
+	limit    int
+	timeout  time.Duration
+	running  int
+	waiters  []*admitWaiter
+	admitted uint64
+	rejected uint64
+	timedOut uint64
+	waited   uint64 // admitted after queuing
+	waitSum  time.Duration
+	waitMax  time.Duration
+}
+
+type admitWaiter struct {
+	ready  chan struct{}
+	queued time.Time
+}
+
+// AdmissionStats is a snapshot of an Admission.
+type AdmissionStats struct {
+	MaxInFlight     int    `json:"max_inflight"`
+	InFlight        int    `json:"inflight"`
+	Queued          int    `json:"queued"`
+	QueueLimit      int    `json:"queue_limit"`
+	QueueTimeoutSec int    `json:"queue_timeout_sec"`
+	Admitted        uint64 `json:"admitted"`
+	Rejected        uint64 `json:"rejected"`  // turned away with a full queue
+	TimedOut        uint64 `json:"timed_out"` // gave up waiting after the queue timeout
+	// AvgWaitMs and MaxWaitMs cover the queries that had to queue.
+	AvgWaitMs float64 `json:"avg_wait_ms"`
+	MaxWaitMs int64   `json:"max_wait_ms"`
+}
+
+// NewAdmission returns an Admission running up to maxInFlight queries with
+// up to queueLimit waiting for at most timeout. Values below 1 use the
+// defaults.
+func NewAdmission(maxInFlight, queueLimit int, timeout time.Duration) *Admission {
+	a := &Admission{max: defaultMaxInFlight, limit: defaultAdmitQueue, timeout: defaultAdmitTimeout}
+	a.Resize(maxInFlight, queueLimit, timeout)
+	return a
+}
+
+// Resize changes the limits. Values below 1 keep the current ones. Running
+// queries are not interrupted when maxInFlight shrinks.
+func (a *Admission) Resize(maxInFlight, queueLimit int, timeout time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if maxInFlight > 0 {
+		a.max = maxInFlight
+	}
+	if queueLimit > 0 {
+		a.limit = queueLimit
+	}
+	if timeout > 0 {
+		a.timeout = timeout
+	}
+	a.dispatch()
+}
+
+// Stats returns the current state and counters.
+func (a *Admission) Stats() AdmissionStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := AdmissionStats{
+		MaxInFlight:     a.max,
+		InFlight:        a.running,
+		Queued:          len(a.waiters),
+		QueueLimit:      a.limit,
+		QueueTimeoutSec: int(a.timeout / time.Second),
+		Admitted:        a.admitted,
+		Rejected:        a.rejected,
+		TimedOut:        a.timedOut,
+		MaxWaitMs:       a.waitMax.Milliseconds(),
+	}
+	if a.waited > 0 {
+		s.AvgWaitMs = float64(a.waitSum.Milliseconds()) / float64(a.waited)
+	}
+	return s
+}
+
+// RetryAfter returns the number of seconds a turned-away client should wait
+// before asking again: the queue timeout, by which the queries ahead have
+// either run or given up.
+func (a *Admission) RetryAfter() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return max(int(a.timeout/time.Second), 1)
+}
+
+// Acquire waits for a free slot. release must be called when the query is
+// done. It fails with ErrOverloaded when the queue is full or the wait
+// times out, and with the context error when ctx is done first.
+func (a *Admission) Acquire(ctx context.Context) (release func(), err error) {
+	a.mu.Lock()
+	if a.running < a.max && len(a.waiters) == 0 {
+		a.running++
+		a.admitted++
+		a.mu.Unlock()
+		return a.release, nil
+	}
+	if len(a.waiters) >= a.limit {
+		a.rejected++
+		a.mu.Unlock()
+		return nil, ErrOverloaded
+	}
+	w := &admitWaiter{ready: make(chan struct{}), queued: time.Now()}
+	a.waiters = append(a.waiters, w)
+	timer := time.NewTimer(a.timeout)
+	a.mu.Unlock()
+	defer timer.Stop()
+
+	select {
+	case <-w.ready:
+		return a.release, nil
+	case <-timer.C:
+		err = ErrOverloaded
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	a.mu.Lock()
+	select {
+	case <-w.ready:
+		// Admitted while giving up; pass the slot on
+		a.mu.Unlock()
+		a.release()
+		return nil, err
+	default:
+	}
+	for i, o := range a.waiters {
+		if o == w {
+			a.waiters = append(a.waiters[:i], a.waiters[i+1:]...)
+			break
+		}
+	}
+	if errors.Is(err, ErrOverloaded) {
+		a.timedOut++
+	}
+	a.mu.Unlock()
+	return nil, err
+}
+
+// release frees the slot of a finished query and admits the next waiting one.
+func (a *Admission) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.running--
+	a.dispatch()
+}
+
+// dispatch admits waiting queries while slots are free. a.mu must be held.
+func (a *Admission) dispatch() {
+	for a.running < a.max && len(a.waiters) > 0 {
+		w := a.waiters[0]
+		a.waiters = a.waiters[1:]
+		a.running++
+		a.admitted++
+		a.waited++
+		wait := time.Since(w.queued)
+		a.waitSum += wait
+		if wait > a.waitMax {
+			a.waitMax = wait
+		}
+		close(w.ready)
+	}
+}
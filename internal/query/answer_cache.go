@@ -0,0 +1,139 @@
+package query
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// answerCacheEntry is one answered question kept for the semantic cache.
+type answerCacheEntry struct {
+	scope      string // products searched and retrieval overrides; hits never cross scopes
+	lang       string // detected language of the question, which the answer is in
+	vector     []float64
+	norm       float64
+	answer     string
+	sources    []SourceRef
+	generation uint64 // vector store generation the answer was built from
+	created    time.Time
+}
+
+// answerCache holds recent answers and looks them up by embedding
+// similarity. Entries are kept oldest first; lookups scan linearly, which
+// is cheap next to an LLM call at the sizes the cache is configured for.
+type answerCache struct {
+	mu      sync.Mutex
+	entries []*answerCacheEntry
+}
+
+// get returns the most similar entry in scope scoring at least threshold.
+// Entries built from an older store generation or older than ttl are
+// dropped on the way.
+func (c *answerCache) get(scope string, vector []float64, lang string, threshold float64, ttl time.Duration, generation uint64) (*answerCacheEntry, float64) {
+	norm := vectorNorm(vector)
+	if norm == 0 {
+		return nil, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var best *answerCacheEntry
+	bestScore := 0.0
+	kept := c.entries[:0]
+	for _, e := range c.entries {
+		if e.generation != generation || time.Since(e.created) > ttl {
+			continue
+		}
+		kept = append(kept, e)
+		if e.scope != scope || e.lang != lang || len(e.vector) != len(vector) {
+			continue
+		}
+		if score := dot(e.vector, vector) / (e.norm * norm); score >= threshold && score > bestScore {
+			best, bestScore = e, score
+		}
+	}
+	clear(c.entries[len(kept):])
+	c.entries = kept
+	return best, bestScore
+}
+
+// put adds an entry, evicting the oldest beyond maxEntries.
+func (c *answerCache) put(e *answerCacheEntry, maxEntries int) {
+	e.norm = vectorNorm(e.vector)
+	if e.norm == 0 || maxEntries < 1 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = append(c.entries, e)
+	if n := len(c.entries) - maxEntries; n > 0 {
+		clear(c.entries[:n])
+		c.entries = append(c.entries[:0], c.entries[n:]...)
+	}
+}
+
+// reset drops every entry.
+func (c *answerCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+}
+
+// answerCacheScope identifies what an answer depends on besides the
+// question: the products searched, the documents hidden from the asker and
+// any per-query retrieval overrides.
+func answerCacheScope(req QueryRequest) string {
+	var b strings.Builder
+	if req.ProductScope != nil {
+		b.WriteString("scope:")
+		b.WriteString(strings.Join(req.ProductScope, ","))
+	} else {
+		b.WriteString("product:")
+		b.WriteString(req.ProductID)
+	}
+	if req.filterKey != 0 {
+		b.WriteString("|hidden=")
+		b.WriteString(strconv.FormatUint(req.filterKey, 16))
+	}
+	if o := req.Overrides; o != nil {
+		if o.TopK != nil {
+			b.WriteString("|top_k=")
+			b.WriteString(strconv.Itoa(*o.TopK))
+		}
+		if o.Threshold != nil {
+			b.WriteString("|threshold=")
+			b.WriteString(strconv.FormatFloat(*o.Threshold, 'g', -1, 64))
+		}
+		if o.ContentPriority != nil {
+			b.WriteString("|content_priority=")
+			b.WriteString(*o.ContentPriority)
+		}
+		if o.TextMatchEnabled != nil {
+			b.WriteString("|text_match=")
+			b.WriteString(strconv.FormatBool(*o.TextMatchEnabled))
+		}
+		if o.MMREnabled != nil {
+			b.WriteString("|mmr=")
+			b.WriteString(strconv.FormatBool(*o.MMREnabled))
+		}
+		if o.MMRLambda != nil {
+			b.WriteString("|mmr_lambda=")
+			b.WriteString(strconv.FormatFloat(*o.MMRLambda, 'g', -1, 64))
+		}
+	}
+	return b.String()
+}
+
+func dot(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+func vectorNorm(v []float64) float64 {
+	return math.Sqrt(dot(v, v))
+}
@@ -0,0 +1,128 @@
+package query
+
+import (
+	"sort"
+	"strings"
+
+	"askflow/internal/config"
+	"askflow/internal/embedding"
+	"askflow/internal/llm"
+	"askflow/internal/vectorstore"
+)
+
+// Context budget: the estimated tokens of the messages sent to the LLM plus
+// llm.max_tokens for the answer must fit in llm.context_window. Chunks are
+// kept best-scored first; those that do not fit are left out, and the best
+// chunk is cut short when it does not fit on its own.
+
+// docOverheadTokens approximates the tokens of the tags around each chunk
+// of the reference material.
+const docOverheadTokens = 8
+
+// imageTokenReserve is set aside for an attached image, whose tokens
+// cannot be estimated from its size.
+const imageTokenReserve = 1500
+
+// minTruncatedTokens is the smallest part of the best chunk worth sending
+// when it has to be cut short.
+const minTruncatedTokens = 64
+
+// contextBudget is the outcome of fitting the context into the window.
+type contextBudget struct {
+	dropped   int // chunks left out
+	truncated bool
+	tokens    int // estimated prompt tokens sent
+	available int // estimated prompt tokens allowed
+}
+
+// fitContext returns the chunks and their results that fit in the context
+// window with prompt and question, in their original order. Chunks are
+// kept by descending score, so lower-scored ones are left out first.
+func fitContext(prompt string, chunks []string, question string, hasImage bool, results []vectorstore.SearchResult, cfg *config.Config) ([]string, []vectorstore.SearchResult, contextBudget) {
+	var b contextBudget
+	if cfg == nil || cfg.LLM.ContextWindow <= 0 || len(chunks) == 0 {
+		return chunks, results, b
+	}
+	// The messages with one empty chunk cover the prompt, the question, the
+	// safety rules and the reference header
+	fixed := estimateMessages(llm.BuildMessages(prompt, []string{""}, question))
+	if hasImage {
+		fixed += imageTokenReserve
+	}
+	b.available = cfg.LLM.ContextWindow - cfg.LLM.MaxTokens
+	remaining := b.available - fixed
+
+	order := make([]int, len(chunks))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, c int) bool {
+		return results[order[a]].Score > results[order[c]].Score
+	})
+
+	keep := make([]bool, len(chunks))
+	out := append([]string(nil), chunks...)
+	kept := 0
+	for _, i := range order {
+		n := embedding.EstimateTokens(chunks[i]) + docOverheadTokens
+		if n <= remaining {
+			keep[i] = true
+			remaining -= n
+			kept++
+			continue
+		}
+		// The best chunk is cut short rather than sending no context at all
+		if kept == 0 && remaining-docOverheadTokens >= minTruncatedTokens {
+			out[i] = truncateTokens(chunks[i], remaining-docOverheadTokens)
+			keep[i] = true
+			b.truncated = true
+			remaining -= embedding.EstimateTokens(out[i]) + docOverheadTokens
+			kept++
+		}
+	}
+	if kept == 0 {
+		// Not even part of a chunk fits; let the provider decide
+		return chunks, results, b
+	}
+
+	var fitChunks []string
+	var fitResults []vectorstore.SearchResult
+	for i, ok := range keep {
+		if ok {
+			fitChunks = append(fitChunks, out[i])
+			fitResults = append(fitResults, results[i])
+		} else {
+			b.dropped++
+		}
+	}
+	b.tokens = b.available - remaining
+	return fitChunks, fitResults, b
+}
+
+// estimateMessages approximates the tokens of messages.
+func estimateMessages(messages []llm.Message) int {
+	n := 0
+	for _, m := range messages {
+		if text, ok := m.Content.(string); ok {
+			n += embedding.EstimateTokens(text)
+		}
+		n += 4 // role and separators
+	}
+	return n
+}
+
+// truncateTokens cuts s to about n estimated tokens.
+func truncateTokens(s string, n int) string {
+	cjk, other := 0, 0
+	for i, r := range s {
+		if r >= 0x2E80 {
+			cjk++
+		} else {
+			other++
+		}
+		if cjk+(other+3)/4 > n {
+			return strings.TrimSpace(s[:i]) + "…"
+		}
+	}
+	return s
+}
@@ -0,0 +1,92 @@
+package query
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+
+	"askflow/internal/config"
+	"askflow/internal/llm"
+	"askflow/internal/vectorstore"
+)
+
+// compressConcurrency bounds the summaries requested at once for a query.
+const compressConcurrency = 4
+
+// compressIrrelevant is what the summarizer answers for a chunk with
+// nothing on the question.
+const compressIrrelevant = "NONE"
+
+// compressPrompt asks for the part of one chunk that bears on the question.
+const compressPrompt = "你是一个资料摘要助手。请从参考资料中提取与用户问题相关的全部信息，" +
+	"完整保留具体步骤、数值、命令、配置项和专有名词，使用参考资料原文的语言，不要回答问题本身，不要添加资料中没有的内容，不超过300字。" +
+	"\n\n如果参考资料与用户问题无关，只输出：" + compressIrrelevant
+
+// compressContext is the map step of map-reduce answering: every text chunk
+// is summarized with respect to question by llm.compress_model, and the
+// answer is then generated from the summaries. Chunks the summarizer finds
+// irrelevant are left out together with their results; image chunks, whose
+// text is a short caption, are kept as they are. A chunk whose summary
+// fails is kept whole, and if every chunk is found irrelevant the context is
+// returned unchanged for the answer model to judge. It also returns how many
+// chunks were summarized and how many left out.
+func (qe *QueryEngine) compressContext(ctx context.Context, question string, chunks []string, results []vectorstore.SearchResult, ls llm.LLMService, cfg *config.Config) ([]string, []vectorstore.SearchResult, int, int) {
+	summarizer := llm.WithModel(ls, cfg.LLM.CompressModel)
+	summaries := make([]string, len(chunks))
+	ok := make([]bool, len(chunks))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, compressConcurrency)
+	for i, chunk := range chunks {
+		if results[i].ImageURL != "" {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, chunk string) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+			summary, err := summarizer.Generate(ctx, compressPrompt, []string{chunk}, question)
+			if err != nil {
+				log.Printf("[Query] failed to summarize chunk %d of %s: %v", results[i].ChunkIndex, results[i].DocumentName, err)
+				return
+			}
+			summaries[i], ok[i] = strings.TrimSpace(summary), true
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	var outChunks []string
+	var outResults []vectorstore.SearchResult
+	summarized, dropped := 0, 0
+	for i := range chunks {
+		if !ok[i] {
+			outChunks = append(outChunks, chunks[i])
+			outResults = append(outResults, results[i])
+			continue
+		}
+		summarized++
+		if isIrrelevantSummary(summaries[i]) {
+			dropped++
+			continue
+		}
+		outChunks = append(outChunks, summaries[i])
+		outResults = append(outResults, results[i])
+	}
+	if len(outChunks) == 0 {
+		return chunks, results, summarized, 0
+	}
+	return outChunks, outResults, summarized, dropped
+}
+
+// isIrrelevantSummary reports whether the summarizer found a chunk
+// irrelevant to the question.
+func isIrrelevantSummary(summary string) bool {
+	s := strings.Trim(summary, " \t\r\n。.\"'`*")
+	return s == "" || strings.EqualFold(s, compressIrrelevant)
+}
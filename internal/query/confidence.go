@@ -0,0 +1,57 @@
+package query
+
+import (
+	"sort"
+
+	"askflow/internal/vectorstore"
+)
+
+// confidenceTopN is how many of the best search results the answer
+// confidence is based on.
+const confidenceTopN = 3
+
+// answerConfidence estimates, between 0 and 1, how well the retrieved
+// context supports an answer: the mean score of the best results, scaled so
+// that a result just at the search threshold gives 0.5 and a perfect match
+// 1. Results found by the relaxed search, below the threshold, give less
+// than 0.5.
+func answerConfidence(results []vectorstore.SearchResult, threshold float64) float64 {
+	if len(results) == 0 {
+		return 0
+	}
+	scores := make([]float64, len(results))
+	for i, r := range results {
+		scores[i] = r.Score
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(scores)))
+	n := min(len(scores), confidenceTopN)
+	avg := 0.0
+	for _, s := range scores[:n] {
+		avg += s
+	}
+	avg /= float64(n)
+
+	var c float64
+	switch {
+	case threshold >= 1:
+		c = avg
+	case avg >= threshold:
+		c = 0.5 + 0.5*(avg-threshold)/(1-threshold)
+	case threshold > 0:
+		c = 0.5 * avg / threshold
+	}
+	return max(0, min(1, c))
+}
+
+// escalationThreshold returns the answer confidence below which answers of
+// a product are escalated to a human, 0 when escalation is off.
+func (qe *QueryEngine) escalationThreshold(productID string) float64 {
+	if productID == "" || qe.readDB == nil {
+		return 0
+	}
+	var threshold float64
+	if err := qe.readDB.QueryRow("SELECT COALESCE(escalation_threshold, 0) FROM products WHERE id = ?", productID).Scan(&threshold); err != nil {
+		return 0
+	}
+	return threshold
+}
@@ -0,0 +1,193 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"askflow/internal/embedding"
+	"askflow/internal/llm"
+	"askflow/internal/vectorstore"
+)
+
+// Candidate search bounds for a trace: the nearest chunks are listed
+// regardless of the threshold, at least traceMinCandidates and three times
+// top_k, up to traceMaxCandidates.
+const (
+	traceMinCandidates = 20
+	traceMaxCandidates = 100
+)
+
+// traceSnippetRunes bounds the chunk text shown for each hit of a trace.
+const traceSnippetRunes = 200
+
+// Trace records the internals of a dry-run query: the chunks at each stage
+// of retrieval and every LLM call with its exact messages.
+type Trace struct {
+	// Candidates are the chunks nearest to the question by similarity,
+	// before the threshold and MMR.
+	Candidates []TraceHit `json:"candidates"`
+	// Retrieved are the chunks the search returned after the threshold and,
+	// when enabled, MMR.
+	Retrieved []TraceHit `json:"retrieved"`
+	// Final are the chunks given to the LLM, in order, after the image
+	// search, the relaxed search and content priority reordering.
+	Final    []TraceHit  `json:"final"`
+	LLMCalls []TraceCall `json:"llm_calls"`
+
+	mu sync.Mutex
+}
+
+// TraceHit is a chunk at one stage of retrieval.
+type TraceHit struct {
+	DocumentID   string  `json:"document_id"`
+	DocumentName string  `json:"document_name"`
+	ChunkIndex   int     `json:"chunk_index"`
+	Score        float64 `json:"score"`
+	HasImage     bool    `json:"has_image,omitempty"`
+	Snippet      string  `json:"snippet"`
+	// AboveThreshold and Retrieved are only set for candidates.
+	AboveThreshold bool `json:"above_threshold,omitempty"`
+	Retrieved      bool `json:"retrieved,omitempty"`
+}
+
+// TraceCall is one LLM call: intent classification, the answer itself or a
+// translation of a canned reply.
+type TraceCall struct {
+	Messages         []llm.Message `json:"messages"`
+	Answer           string        `json:"answer"`
+	Error            string        `json:"error,omitempty"`
+	PromptTokens     int           `json:"prompt_tokens"`
+	CompletionTokens int           `json:"completion_tokens"`
+}
+
+// DebugResult is the outcome of a dry-run query together with its trace
+// and the tokens it consumed.
+type DebugResult struct {
+	*QueryResponse
+	Trace  *Trace     `json:"trace"`
+	Tokens TokenUsage `json:"tokens"`
+}
+
+// Debug runs req through the pipeline as a dry run for tuning retrieval: it
+// answers with debug info regardless of vector.debug_mode and traces every
+// stage, but neither reads nor writes the semantic cache nor queues pending
+// questions.
+func (qe *QueryEngine) Debug(ctx context.Context, req QueryRequest) (*DebugResult, error) {
+	if req.ImageData != "" {
+		img, err := PrepareImage(req.ImageData)
+		if err != nil {
+			return nil, err
+		}
+		req.ImageData = img
+	}
+	release, err := qe.admission.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	es, ls, cfg := qe.getServices()
+	trace := &Trace{}
+	req.trace = trace
+	req.NoPending = true
+
+	var embedUsage embedding.Usage
+	resp, err := qe.query(ctx, req, embedding.Metered(es, &embedUsage), &tracingLLM{inner: ls, trace: trace}, cfg)
+	if err != nil {
+		return nil, err
+	}
+	result := &DebugResult{QueryResponse: resp, Trace: trace}
+	result.Tokens.EmbeddingTokens = embedUsage.PromptTokens
+	for _, c := range trace.LLMCalls {
+		result.Tokens.PromptTokens += c.PromptTokens
+		result.Tokens.CompletionTokens += c.CompletionTokens
+	}
+	return result, nil
+}
+
+// traceCandidates records the nearest chunks to queryVector regardless of
+// the threshold, marking those above it and those in retrieved.
+func (qe *QueryEngine) traceCandidates(ctx context.Context, req QueryRequest, queryVector []float64, topK int, threshold float64, retrieved []vectorstore.SearchResult) {
+	k := min(max(3*topK, traceMinCandidates), traceMaxCandidates)
+	candidates, err := qe.search(ctx, req, queryVector, k, 0)
+	if err != nil {
+		return
+	}
+	inResults := make(map[string]bool, len(retrieved))
+	for _, r := range retrieved {
+		inResults[fmt.Sprintf("%s\x00%d", r.DocumentID, r.ChunkIndex)] = true
+	}
+	hits := traceHits(candidates)
+	for i := range hits {
+		hits[i].AboveThreshold = hits[i].Score >= threshold
+		hits[i].Retrieved = inResults[fmt.Sprintf("%s\x00%d", hits[i].DocumentID, hits[i].ChunkIndex)]
+	}
+	req.trace.Candidates = hits
+}
+
+// traceHits converts search results to trace hits.
+func traceHits(results []vectorstore.SearchResult) []TraceHit {
+	hits := make([]TraceHit, len(results))
+	for i, r := range results {
+		snippet := []rune(r.ChunkText)
+		if len(snippet) > traceSnippetRunes {
+			snippet = append(snippet[:traceSnippetRunes], '…')
+		}
+		hits[i] = TraceHit{
+			DocumentID:   r.DocumentID,
+			DocumentName: r.DocumentName,
+			ChunkIndex:   r.ChunkIndex,
+			Score:        r.Score,
+			HasImage:     r.ImageURL != "",
+			Snippet:      string(snippet),
+		}
+	}
+	return hits
+}
+
+// tracingLLM records every call made through it in a Trace, with the
+// messages as the API receives them and the tokens the call consumed.
+type tracingLLM struct {
+	inner llm.LLMService
+	trace *Trace
+}
+
+func (t *tracingLLM) Generate(ctx context.Context, prompt string, chunks []string, question string) (string, error) {
+	var u llm.Usage
+	answer, err := llm.Metered(t.inner, &u).Generate(ctx, prompt, chunks, question)
+	t.record(llm.BuildMessages(prompt, chunks, question), answer, err, u)
+	return answer, err
+}
+
+func (t *tracingLLM) GenerateWithImage(ctx context.Context, prompt string, chunks []string, question string, imageDataURL string) (string, error) {
+	var u llm.Usage
+	answer, err := llm.Metered(t.inner, &u).GenerateWithImage(ctx, prompt, chunks, question, imageDataURL)
+	if imageDataURL == "" {
+		t.record(llm.BuildMessages(prompt, chunks, question), answer, err, u)
+		return answer, err
+	}
+	// The image is left out of the trace; its size is enough to tell it apart
+	placeholder := fmt.Sprintf("(image, %d bytes)", len(imageDataURL))
+	t.record(llm.BuildMessagesWithImage(prompt, chunks, question, placeholder), answer, err, u)
+	return answer, err
+}
+
+// WithModel traces the calls made with another model too.
+func (t *tracingLLM) WithModel(model string) llm.LLMService {
+	return &tracingLLM{inner: llm.WithModel(t.inner, model), trace: t.trace}
+}
+
+func (t *tracingLLM) record(messages []llm.Message, answer string, err error, u llm.Usage) {
+	call := TraceCall{
+		Messages:         messages,
+		Answer:           answer,
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+	}
+	if err != nil {
+		call.Error = err.Error()
+	}
+	t.trace.mu.Lock()
+	t.trace.LLMCalls = append(t.trace.LLMCalls, call)
+	t.trace.mu.Unlock()
+}
@@ -3,10 +3,10 @@
 package query
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"log"
 	"sort"
@@ -14,10 +14,14 @@ import (
 	"sync"
 	"time"
 
+	"askflow/internal/blob"
 	"askflow/internal/config"
 	"askflow/internal/embedding"
 	"askflow/internal/errlog"
+	"askflow/internal/i18n"
 	"askflow/internal/llm"
+	"askflow/internal/markdown"
+	"askflow/internal/sharedcache"
 	"askflow/internal/vectorstore"
 )
 
@@ -27,6 +31,77 @@ type QueryRequest struct {
 	UserID    string `json:"user_id"`
 	ProductID string `json:"product_id"`
 	ImageData string `json:"image_data,omitempty"` // base64 data URL from clipboard paste
+	// ProductScope, when non-nil, limits retrieval to exactly these product
+	// IDs instead of ProductID plus the public library. Set by the server for
+	// tenant workspaces; never read from the request body.
+	ProductScope []string `json:"-"`
+	// Overrides, when non-nil, replace retrieval settings for this query,
+	// e.g. to serve an experiment variant. Never read from the request body.
+	Overrides *Overrides `json:"-"`
+	// NoPending, when set, answers unanswerable questions as pending
+	// without queueing them for the support team, e.g. for smoke tests
+	// from the command line. Never read from the request body.
+	NoPending bool `json:"-"`
+
+	// filterKey identifies the documents hidden from the asker, set by query.
+	filterKey uint64
+	// trace, set by Debug, records the pipeline internals of a dry run.
+	trace *Trace
+}
+
+// Overrides replace vector settings for a single query. Nil fields keep the
+// configured value.
+type Overrides struct {
+	TopK             *int     `json:"top_k,omitempty"`
+	Threshold        *float64 `json:"threshold,omitempty"`
+	ContentPriority  *string  `json:"content_priority,omitempty"`
+	TextMatchEnabled *bool    `json:"text_match_enabled,omitempty"`
+	MMREnabled       *bool    `json:"mmr_enabled,omitempty"`
+	MMRLambda        *float64 `json:"mmr_lambda,omitempty"`
+}
+
+// Validate checks that the overridden values are in range.
+func (o *Overrides) Validate() error {
+	if o.TopK != nil && (*o.TopK < 1 || *o.TopK > 100) {
+		return fmt.Errorf("top_k must be between 1 and 100")
+	}
+	if o.Threshold != nil && (*o.Threshold < 0 || *o.Threshold > 1) {
+		return fmt.Errorf("threshold must be between 0 and 1")
+	}
+	if o.ContentPriority != nil && *o.ContentPriority != "image_text" && *o.ContentPriority != "text_only" {
+		return fmt.Errorf("content_priority must be image_text or text_only")
+	}
+	if o.MMRLambda != nil && (*o.MMRLambda <= 0 || *o.MMRLambda > 1) {
+		return fmt.Errorf("mmr_lambda must be greater than 0 and at most 1")
+	}
+	return nil
+}
+
+// apply returns cfg with the overrides applied, leaving cfg itself unchanged.
+func (o *Overrides) apply(cfg *config.Config) *config.Config {
+	if o == nil || cfg == nil {
+		return cfg
+	}
+	c := *cfg
+	if o.TopK != nil {
+		c.Vector.TopK = *o.TopK
+	}
+	if o.Threshold != nil {
+		c.Vector.Threshold = *o.Threshold
+	}
+	if o.ContentPriority != nil {
+		c.Vector.ContentPriority = *o.ContentPriority
+	}
+	if o.TextMatchEnabled != nil {
+		c.Vector.TextMatchEnabled = *o.TextMatchEnabled
+	}
+	if o.MMREnabled != nil {
+		c.Vector.MMREnabled = *o.MMREnabled
+	}
+	if o.MMRLambda != nil {
+		c.Vector.MMRLambda = *o.MMRLambda
+	}
+	return &c
 }
 
 
@@ -38,6 +113,21 @@ type QueryResponse struct {
 	AllowDownload bool        `json:"allow_download"`
 	Message       string      `json:"message,omitempty"`
 	DebugInfo     *DebugInfo  `json:"debug_info,omitempty"`
+	// Refused is set when the question is on the "do not answer" list.
+	Refused bool `json:"refused,omitempty"`
+	// QueryID identifies the answer for POST /api/query/feedback.
+	QueryID string `json:"query_id,omitempty"`
+	// Blocks and AnswerHTML are the answer parsed into sanitized blocks and
+	// rendered as safe HTML, see package markdown.
+	Blocks     []markdown.Block `json:"blocks,omitempty"`
+	AnswerHTML string           `json:"answer_html,omitempty"`
+	// Confidence estimates, between 0 and 1, how well the knowledge base
+	// supports an answer generated from it.
+	Confidence float64 `json:"confidence,omitempty"`
+	// Unverified is set when Confidence is below the product's escalation
+	// threshold: the answer is a draft, and the question has also been sent
+	// to the pending queue for a human to answer.
+	Unverified bool `json:"unverified,omitempty"`
 }
 
 // DebugInfo holds diagnostic information for debugging the query pipeline.
@@ -56,14 +146,19 @@ type DebugInfo struct {
 
 // SourceRef represents a reference to a source document chunk.
 type SourceRef struct {
-	DocumentID   string  `json:"document_id,omitempty"`
-	DocumentName string  `json:"document_name"`
-	DocumentType string  `json:"document_type,omitempty"`
-	ChunkIndex   int     `json:"chunk_index"`
-	Snippet      string  `json:"snippet"`
-	ImageURL     string  `json:"image_url,omitempty"`
-	StartTime    float64 `json:"start_time,omitempty"` // 视频起始时间（秒）
-	EndTime      float64 `json:"end_time,omitempty"`   // 视频结束时间（秒）
+	DocumentID      string  `json:"document_id,omitempty"`
+	DocumentName    string  `json:"document_name"`
+	DocumentType    string  `json:"document_type,omitempty"`
+	DocumentVersion string  `json:"document_version,omitempty"` // 同步文档的来源版本（如 Git 提交 SHA），便于引用确切版本
+	ChunkIndex      int     `json:"chunk_index"`
+	Snippet         string  `json:"snippet"`
+	ImageURL        string  `json:"image_url,omitempty"`
+	StartTime       float64 `json:"start_time,omitempty"`   // 视频起始时间（秒）
+	EndTime         float64 `json:"end_time,omitempty"`     // 视频结束时间（秒）
+	StartOffset     int     `json:"start_offset,omitempty"` // 片段在原文中的起始字符偏移（end_offset > 0 时有效）
+	EndOffset       int     `json:"end_offset,omitempty"`   // 片段在原文中的结束字符偏移
+	Page            int     `json:"page,omitempty"`         // PDF 页码（从 1 开始）
+	Slide           int     `json:"slide,omitempty"`        // PPT 幻灯片编号（从 1 开始）
 }
 
 
@@ -140,8 +235,34 @@ type QueryEngine struct {
 	readDB           *sql.DB // readDB for read-only queries
 	config           *config.Config
 	embedCache       *embeddingCache // caches embedding API results to avoid redundant calls
+	answerCache      answerCache     // semantic cache of recent answers
+	onPendingCreated func(id, question, userID, productID string)
+	pendingSink      PendingSink
+	refusalCheck     RefusalCheck
+	onIntent         func(intent, question, userID, productID string)
+	intentVectors    intentVectorCache // embeddings of the intents' example questions
+	smallTalkVectors intentVectorCache // embeddings of the small talk phrases
+	visibility       Visibility
+	admission        *Admission // limits the queries running at once
+	imageEmbedding   embedding.EmbeddingService
+
+	// sharedEmbeds replaces embedCache when set (see SetSharedCache)
+	sharedEmbeds *sharedcache.Cache
 }
 
+// RefusalCheck matches a question against the "do not answer" list and
+// returns the refusal message when it is listed. questionVector returns the
+// question's embedding, for checks that need it.
+type RefusalCheck func(ctx context.Context, productID, question string, questionVector func() ([]float64, error)) (message string, refused bool)
+
+// PendingSink records new pending questions somewhere other than the local
+// database, such as on the primary instance of a read-only replica.
+type PendingSink func(question, userID, imageData, productID string) error
+
+// Visibility returns the filter hiding the documents a user may not see, or
+// nil when they may see all.
+type Visibility func(userID string) (*vectorstore.Filter, error)
+
 // NewQueryEngine creates a new QueryEngine with the given dependencies.
 func NewQueryEngine(
 	embeddingService embedding.EmbeddingService,
@@ -159,15 +280,28 @@ func NewQueryEngine(
 		readDB:           readDB,
 		config:           cfg,
 		embedCache:       newEmbeddingCache(512, 10*time.Minute),
+		admission:        newAdmission(cfg),
+	}
+}
+
+// newAdmission returns the admission controller configured by cfg.
+func newAdmission(cfg *config.Config) *Admission {
+	if cfg == nil {
+		return NewAdmission(0, 0, 0)
 	}
+	a := cfg.Admission
+	return NewAdmission(a.MaxInFlight, a.QueueLimit, time.Duration(a.QueueTimeoutSec)*time.Second)
 }
 
 // cachedEmbed returns the embedding for text, using cache when available.
-func (qe *QueryEngine) cachedEmbed(text string, es embedding.EmbeddingService) ([]float64, error) {
+func (qe *QueryEngine) cachedEmbed(ctx context.Context, text string, es embedding.EmbeddingService) ([]float64, error) {
+	if shared, model := qe.sharedEmbedCache(); shared != nil {
+		return qe.sharedEmbed(ctx, shared, model, text, es)
+	}
 	if vec, ok := qe.embedCache.get(text); ok {
 		return vec, nil
 	}
-	vec, err := es.Embed(text)
+	vec, err := es.Embed(ctx, text)
 	if err != nil {
 		return nil, err
 	}
@@ -182,6 +316,38 @@ func (qe *QueryEngine) UpdateServices(es embedding.EmbeddingService, ls llm.LLMS
 	qe.embeddingService = es
 	qe.llmService = ls
 	qe.config = cfg
+	if cfg != nil {
+		a := cfg.Admission
+		qe.admission.Resize(a.MaxInFlight, a.QueueLimit, time.Duration(a.QueueTimeoutSec)*time.Second)
+	}
+	// Cached answers may come from the previous model or settings
+	qe.answerCache.reset()
+	qe.intentVectors.reset()
+	qe.smallTalkVectors.reset()
+}
+
+// ClearAnswerCache drops all semantically cached answers. Document changes
+// invalidate them automatically; call this after changes that bypass the
+// vector store, such as moving chunks between products.
+func (qe *QueryEngine) ClearAnswerCache() {
+	qe.answerCache.reset()
+}
+
+// AdmissionStats returns the state of the query admission queue.
+func (qe *QueryEngine) AdmissionStats() AdmissionStats {
+	return qe.admission.Stats()
+}
+
+// AdmissionRetryAfter returns the Retry-After, in seconds, for queries
+// turned away with ErrOverloaded.
+func (qe *QueryEngine) AdmissionRetryAfter() int {
+	return qe.admission.RetryAfter()
+}
+
+// Services returns the embedding and LLM services currently in use.
+func (qe *QueryEngine) Services() (embedding.EmbeddingService, llm.LLMService) {
+	es, ls, _ := qe.getServices()
+	return es, ls
 }
 
 // getServices returns a snapshot of the current services under read lock.
@@ -192,7 +358,7 @@ func (qe *QueryEngine) getServices() (embedding.EmbeddingService, llm.LLMService
 }
 
 // TranslateText translates the given text to the target language using LLM.
-func (qe *QueryEngine) TranslateText(text, targetLang string) (string, error) {
+func (qe *QueryEngine) TranslateText(ctx context.Context, text, targetLang string) (string, error) {
 	if text == "" {
 		return "", nil
 	}
@@ -205,86 +371,69 @@ func (qe *QueryEngine) TranslateText(text, targetLang string) (string, error) {
 		langName = "English"
 	}
 	prompt := fmt.Sprintf("你是一个翻译助手。将以下文本翻译为%s。只输出翻译结果，不要添加任何解释或引号。如果文本已经是目标语言，直接原样输出。", langName)
-	translated, err := ls.Generate(prompt, []string{text}, text)
+	translated, err := ls.Generate(ctx, prompt, []string{text}, text)
 	if err != nil {
 		return "", err
 	}
 	return strings.TrimSpace(translated), nil
 }
 
-// IntentResult represents the result of intent classification.
-type IntentResult struct {
-	Intent string // "greeting", "product", or "irrelevant"
-	Reason string
+// Query executes the full RAG pipeline:
+// 1. Embed the question
+// 2. Search the vector store for relevant chunks
+// 3. If results found, call LLM to generate an answer with source references
+// 4. If no results, create a pending question and notify the user
+//
+// The embedding, search and LLM calls stop once ctx is done.
+func (qe *QueryEngine) Query(ctx context.Context, req QueryRequest) (*QueryResponse, error) {
+	resp, _, err := qe.QueryMetered(ctx, req)
+	return resp, err
 }
 
-// classifyIntent uses the LLM to determine the user's intent.
-func (qe *QueryEngine) classifyIntent(question string, ls llm.LLMService, cfg *config.Config) (*IntentResult, error) {
-	productIntro := ""
-	if cfg != nil {
-		productIntro = cfg.ProductIntro
-	}
-
-	systemPrompt := "你是一个意图分类器。根据用户输入判断意图类别。"
-	if productIntro != "" {
-		systemPrompt += "\n\n产品介绍：" + productIntro
-	}
-	systemPrompt += "\n\n请只回复一个JSON对象，格式：{\"intent\":\"类别\"}" +
-		"\n\n意图类别：" +
-		"\n- greeting: 仅限纯粹的打招呼和问候语（如：你好、hi、hello、在吗）" +
-		"\n- product: 任何与产品相关的问题，包括但不限于：功能介绍、下载、安装、使用方法、技术问题、故障排查、价格、版本等" +
-		"\n- irrelevant: 与产品完全无关的问题（如天气、笑话、新闻、个人情感等）" +
-		"\n\n重要规则：如果用户在询问任何具体信息（即使很简短），都应归类为product而非greeting。" +
-		"\n\n示例：" +
-		"\n\"你好\" → {\"intent\":\"greeting\"}" +
-		"\n\"hi\" → {\"intent\":\"greeting\"}" +
-		"\n\"这是什么产品\" → {\"intent\":\"product\"}" +
-		"\n\"下载地址\" → {\"intent\":\"product\"}" +
-		"\n\"怎么安装\" → {\"intent\":\"product\"}" +
-		"\n\"今天天气怎么样\" → {\"intent\":\"irrelevant\",\"reason\":\"天气查询与产品无关\"}"
-
-	answer, err := ls.Generate(systemPrompt, nil, question)
-	if err != nil {
-		// If classification fails, default to allowing the query
-		return &IntentResult{Intent: "product"}, nil
-	}
+// TokenUsage is the API token consumption of one query.
+type TokenUsage struct {
+	EmbeddingTokens  int `json:"embedding_tokens"`
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
 
-	// Parse JSON response — extract first JSON object
-	start := -1
-	end := -1
-	for i, c := range answer {
-		if c == '{' && start == -1 {
-			start = i
-		}
-		if c == '}' {
-			end = i + 1
+// QueryMetered runs Query and also returns the embedding and LLM tokens the
+// query consumed, as reported by the APIs. Cached embeddings cost nothing.
+// An attached image is checked and normalized by PrepareImage, failing with
+// an *ImageError. It then waits for admission and returns ErrOverloaded when
+// too many queries are running and waiting.
+func (qe *QueryEngine) QueryMetered(ctx context.Context, req QueryRequest) (*QueryResponse, TokenUsage, error) {
+	if req.ImageData != "" {
+		img, err := PrepareImage(req.ImageData)
+		if err != nil {
+			return nil, TokenUsage{}, err
 		}
+		req.ImageData = img
 	}
-	if start >= 0 && end > start {
-		var parsed struct {
-			Intent string `json:"intent"`
-			Reason string `json:"reason"`
-		}
-		if err := json.Unmarshal([]byte(answer[start:end]), &parsed); err == nil {
-			return &IntentResult{Intent: parsed.Intent, Reason: parsed.Reason}, nil
-		}
+	release, err := qe.admission.Acquire(ctx)
+	if err != nil {
+		return nil, TokenUsage{}, err
 	}
+	defer release()
 
-	// Default to product if parsing fails
-	return &IntentResult{Intent: "product"}, nil
-}
-
-// Query executes the full RAG pipeline:
-// 1. Embed the question
-// 2. Search the vector store for relevant chunks
-// 3. If results found, call LLM to generate an answer with source references
-// 4. If no results, create a pending question and notify the user
-func (qe *QueryEngine) Query(req QueryRequest) (*QueryResponse, error) {
 	// Snapshot services under read lock for concurrency safety
 	es, ls, cfg := qe.getServices()
 
+	var embedUsage embedding.Usage
+	var llmUsage llm.Usage
+	resp, err := qe.query(ctx, req, embedding.Metered(es, &embedUsage), llm.Metered(ls, &llmUsage), cfg)
+	return resp, TokenUsage{
+		EmbeddingTokens:  embedUsage.PromptTokens,
+		PromptTokens:     llmUsage.PromptTokens,
+		CompletionTokens: llmUsage.CompletionTokens,
+	}, err
+}
+
+func (qe *QueryEngine) query(ctx context.Context, req QueryRequest, es embedding.EmbeddingService, ls llm.LLMService, cfg *config.Config) (*QueryResponse, error) {
+	cfg = req.Overrides.apply(cfg)
+
 	// Initialize debug info if debug mode is enabled
-	debugMode := cfg != nil && cfg.Vector.DebugMode
+	debugMode := cfg != nil && (cfg.Vector.DebugMode || req.trace != nil)
 	var dbg *DebugInfo
 	if debugMode {
 		dbg = &DebugInfo{
@@ -293,9 +442,85 @@ func (qe *QueryEngine) Query(req QueryRequest) (*QueryResponse, error) {
 		}
 	}
 
+	// Small talk is answered from the built-in catalog without an LLM call
+	if cfg != nil && cfg.Intent.SmallTalk && req.ImageData == "" {
+		if m, score := qe.matchSmallTalk(ctx, req.Question, es, cfg); m != nil {
+			if debugMode {
+				dbg.Intent = "small_talk"
+				dbg.Steps = append(dbg.Steps, fmt.Sprintf("SmallTalk: %s (%s) similarity=%.4f, answering from the catalog — no LLM cost", m.kind, m.lang, score))
+			}
+			return &QueryResponse{Answer: smallTalkReply(m, cfg), DebugInfo: dbg}, nil
+		}
+	}
+
+	// Do-not-answer list: listed topics are declined before searching
+	qe.mu.RLock()
+	refusalCheck := qe.refusalCheck
+	qe.mu.RUnlock()
+	if refusalCheck != nil {
+		msg, refused := refusalCheck(ctx, req.ProductID, req.Question, func() ([]float64, error) {
+			return qe.cachedEmbed(ctx, req.Question, es)
+		})
+		if refused {
+			if debugMode {
+				dbg.Steps = append(dbg.Steps, "Refusal: question is on the do-not-answer list")
+			}
+			return &QueryResponse{Answer: Localize(msg, req.Question), Refused: true, DebugInfo: dbg}, nil
+		}
+	}
+
+	// Documents restricted to groups the asker is not in are hidden from
+	// every search below, and answers cached for other users are not reused
+	qe.mu.RLock()
+	visibility := qe.visibility
+	qe.mu.RUnlock()
+	if visibility != nil {
+		filter, err := visibility(req.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve document visibility: %w", err)
+		}
+		ctx = vectorstore.WithFilter(ctx, filter)
+		req.filterKey = filter.Key()
+		if debugMode && filter.Len() > 0 {
+			dbg.Steps = append(dbg.Steps, fmt.Sprintf("Visibility: %d documents hidden by user groups", filter.Len()))
+		}
+	}
+
+	// Semantic cache: reuse the answer of a near-identical recent question
+	// before spending any LLM calls. The embedding is cached and reused below.
+	useAnswerCache := cfg != nil && cfg.Vector.SemanticCacheEnabled && req.ImageData == "" && req.trace == nil
+	var (
+		cacheScope      string
+		cacheGeneration uint64
+	)
+	if useAnswerCache {
+		cacheScope = answerCacheScope(req)
+		cacheGeneration = qe.vectorStore.Generation()
+		if vec, err := qe.cachedEmbed(ctx, req.Question, es); err == nil {
+			ttl := time.Duration(cfg.Vector.SemanticCacheTTLMinutes) * time.Minute
+			if e, score := qe.answerCache.get(cacheScope, vec, i18n.Detect(req.Question), cfg.Vector.SemanticCacheThreshold, ttl, cacheGeneration); e != nil {
+				log.Printf("[Query] semantic cache hit: similarity=%.4f", score)
+				if debugMode {
+					dbg.Steps = append(dbg.Steps, fmt.Sprintf("SemanticCache: HIT similarity=%.4f, returning cached answer — no LLM cost", score))
+				}
+				sources := append([]SourceRef(nil), e.sources...)
+				return &QueryResponse{Answer: e.answer, Sources: sources, DebugInfo: dbg}, nil
+			}
+		}
+		if debugMode {
+			dbg.Steps = append(dbg.Steps, "SemanticCache: miss")
+		}
+	}
+
 	// Step 0: Intent classification (skip if image is attached — image may contain product info)
 	// Also skip for knowledge_base products — they should answer all questions without filtering
 	skipIntentClassification := req.ImageData != ""
+	if !skipIntentClassification && cfg != nil && cfg.Intent.Mode == config.IntentModeOff {
+		skipIntentClassification = true
+		if debugMode {
+			dbg.Steps = append(dbg.Steps, "Step 0: intent.mode=off, skipping intent classification")
+		}
+	}
 	if !skipIntentClassification && req.ProductID != "" {
 		var pType string
 		err := qe.readDB.QueryRow("SELECT COALESCE(type, 'service') FROM products WHERE id = ?", req.ProductID).Scan(&pType)
@@ -308,7 +533,7 @@ func (qe *QueryEngine) Query(req QueryRequest) (*QueryResponse, error) {
 		}
 	}
 	if !skipIntentClassification {
-		intent, err := qe.classifyIntent(req.Question, ls, cfg)
+		intent, err := qe.classifyIntent(ctx, req.Question, es, ls, cfg)
 		if err == nil {
 			switch intent.Intent {
 			case "greeting":
@@ -321,34 +546,31 @@ func (qe *QueryEngine) Query(req QueryRequest) (*QueryResponse, error) {
 				if cfg != nil && cfg.ProductIntro != "" {
 					intro = cfg.ProductIntro
 				}
-				// Use LLM to translate the greeting to match the user's question language
-				translated, tErr := ls.Generate(
-					"你是一个翻译助手。将以下内容翻译为与用户提问相同的语言。如果用户用英文提问，翻译为英文；如果用户用中文提问，保持中文。只输出翻译结果，不要添加任何解释。",
-					[]string{intro},
-					req.Question,
-				)
-				if tErr == nil && translated != "" {
-					intro = translated
-				}
-				return &QueryResponse{Answer: intro, DebugInfo: dbg}, nil
+				return &QueryResponse{Answer: Localize(intro, req.Question), DebugInfo: dbg}, nil
 			case "irrelevant":
 				if debugMode {
 					dbg.Intent = "irrelevant"
 					dbg.Steps = append(dbg.Steps, "Step 0: intent=irrelevant, reason="+intent.Reason)
 				}
-				msg := "抱歉，这个问题与我们的产品无关。请问有什么产品方面的问题需要帮助吗？"
-				if intent.Reason != "" {
-					msg = "抱歉，" + intent.Reason + "。请问有什么产品方面的问题需要帮助吗？"
-				}
-				translated, tErr := ls.Generate(
-					"你是一个翻译助手。将以下内容翻译为与用户提问相同的语言。如果用户用英文提问，翻译为英文；如果用户用中文提问，保持中文。只输出翻译结果，不要添加任何解释。",
-					[]string{msg},
-					req.Question,
-				)
-				if tErr == nil && translated != "" {
-					msg = translated
+				// The classifier's reason is in Chinese, so it is only
+				// worth quoting to Chinese speakers
+				if intent.Reason != "" && i18n.Detect(req.Question) == "zh" {
+					msg := "抱歉，" + intent.Reason + "。请问有什么产品方面的问题需要帮助吗？"
+					return &QueryResponse{Answer: msg, DebugInfo: dbg}, nil
 				}
+				msg := Localize("抱歉，这个问题与我们的产品无关。请问有什么产品方面的问题需要帮助吗？", req.Question)
 				return &QueryResponse{Answer: msg, DebugInfo: dbg}, nil
+			default:
+				if cfg == nil {
+					break
+				}
+				if ci := cfg.Intent.Find(intent.Intent); ci != nil {
+					if debugMode {
+						dbg.Intent = ci.Name
+						dbg.Steps = append(dbg.Steps, "Step 0: intent="+ci.Name+", returning the intent's answer")
+					}
+					return qe.answerCustomIntent(req, ci, dbg), nil
+				}
 			}
 		}
 	}
@@ -370,7 +592,7 @@ func (qe *QueryEngine) Query(req QueryRequest) (*QueryResponse, error) {
 		}
 
 		// Level 1: Text-based search against chunk cache
-		textResults, textErr := qe.vectorStore.TextSearch(req.Question, 3, 0.65, req.ProductID)
+		textResults, textErr := qe.textSearch(ctx, req, req.Question, 3, 0.65)
 		if textErr == nil && len(textResults) > 0 && textResults[0].Score >= 0.75 {
 			log.Printf("[Query] Level 1 text match hit: score=%.4f doc=%q", textResults[0].Score, textResults[0].DocumentName)
 			if debugMode {
@@ -385,7 +607,7 @@ func (qe *QueryEngine) Query(req QueryRequest) (*QueryResponse, error) {
 					dbg.Steps = append(dbg.Steps, "TextMatch: Level 1 returning cached answer — zero API cost")
 				}
 				textResults = qe.enrichVideoTimeInfo(textResults)
-				sources := qe.buildSourceRefs(textResults)
+				sources := qe.limitAnswerImages(qe.buildSourceRefs(textResults), cfg.Vector.MaxAnswerImages)
 				return &QueryResponse{Answer: cachedAnswer, Sources: sources, DebugInfo: dbg}, nil
 			}
 
@@ -394,9 +616,9 @@ func (qe *QueryEngine) Query(req QueryRequest) (*QueryResponse, error) {
 			if debugMode {
 				dbg.Steps = append(dbg.Steps, "TextMatch: Level 2 — confirming with embedding (embedding API only)")
 			}
-			queryVector, embErr := qe.cachedEmbed(req.Question, es)
+			queryVector, embErr := qe.cachedEmbed(ctx, req.Question, es)
 			if embErr == nil {
-				vecResults, vecErr := qe.vectorStore.Search(queryVector, cfg.Vector.TopK, cfg.Vector.Threshold, req.ProductID)
+				vecResults, vecErr := qe.retrieve(ctx, req, cfg, queryVector, cfg.Vector.TopK, cfg.Vector.Threshold)
 				if vecErr == nil && len(vecResults) > 0 && vecResults[0].Score >= 0.75 {
 					log.Printf("[Query] Level 2 vector confirmed: score=%.4f", vecResults[0].Score)
 					if debugMode {
@@ -412,7 +634,7 @@ func (qe *QueryEngine) Query(req QueryRequest) (*QueryResponse, error) {
 							dbg.Steps = append(dbg.Steps, "TextMatch: Level 2 returning cached answer — no LLM cost")
 						}
 						vecResults = qe.enrichVideoTimeInfo(vecResults)
-						sources := qe.buildSourceRefs(vecResults)
+						sources := qe.limitAnswerImages(qe.buildSourceRefs(vecResults), cfg.Vector.MaxAnswerImages)
 						return &QueryResponse{Answer: cachedAnswer, Sources: sources, DebugInfo: dbg}, nil
 					}
 				}
@@ -434,7 +656,7 @@ func (qe *QueryEngine) Query(req QueryRequest) (*QueryResponse, error) {
 	// ===== Level 3: Full RAG Pipeline =====
 
 	// Step 1: Embed the question
-	queryVector, err := qe.cachedEmbed(req.Question, es)
+	queryVector, err := qe.cachedEmbed(ctx, req.Question, es)
 	if err != nil {
 		errlog.Logf("[Query] failed to embed question: %v", err)
 		return nil, fmt.Errorf("failed to embed question: %w", err)
@@ -448,14 +670,21 @@ func (qe *QueryEngine) Query(req QueryRequest) (*QueryResponse, error) {
 	// Step 2: Search vector store
 	topK := cfg.Vector.TopK
 	threshold := cfg.Vector.Threshold
-	results, err := qe.vectorStore.Search(queryVector, topK, threshold, req.ProductID)
+	results, err := qe.retrieve(ctx, req, cfg, queryVector, topK, threshold)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search vector store: %w", err)
 	}
 	log.Printf("[Query] search topK=%d threshold=%.2f results=%d", topK, threshold, len(results))
+	if req.trace != nil {
+		req.trace.Retrieved = traceHits(results)
+		qe.traceCandidates(ctx, req, queryVector, topK, threshold, results)
+	}
 	if debugMode {
 		dbg.ResultCount = len(results)
 		dbg.Steps = append(dbg.Steps, fmt.Sprintf("Step 2: search topK=%d threshold=%.2f results=%d", topK, threshold, len(results)))
+		if cfg.Vector.MMREnabled {
+			dbg.Steps = append(dbg.Steps, fmt.Sprintf("Step 2: results picked by MMR, lambda=%.2f", cfg.Vector.MMRLambda))
+		}
 		for i, r := range results {
 			if i >= 5 {
 				break
@@ -468,7 +697,7 @@ func (qe *QueryEngine) Query(req QueryRequest) (*QueryResponse, error) {
 	var imgVec []float64
 	if req.ImageData != "" {
 		var imgErr error
-		imgVec, imgErr = es.EmbedImageURL(req.ImageData)
+		imgVec, imgErr = es.EmbedImageURL(ctx, req.ImageData)
 		if imgErr != nil {
 			log.Printf("[Query] image embedding failed: %v", imgErr)
 			errlog.Logf("[Query] image embedding failed: %v", imgErr)
@@ -479,7 +708,7 @@ func (qe *QueryEngine) Query(req QueryRequest) (*QueryResponse, error) {
 			if imgThreshold < 0.3 {
 				imgThreshold = 0.3
 			}
-			imgResults, imgSearchErr := qe.vectorStore.Search(imgVec, topK, imgThreshold, req.ProductID)
+			imgResults, imgSearchErr := qe.search(ctx, req, imgVec, topK, imgThreshold)
 			if imgSearchErr == nil && len(imgResults) > 0 {
 				log.Printf("[Query] image search results=%d (threshold=%.2f)", len(imgResults), imgThreshold)
 				results = mergeSearchResults(results, imgResults, topK)
@@ -487,13 +716,25 @@ func (qe *QueryEngine) Query(req QueryRequest) (*QueryResponse, error) {
 		}
 	}
 
+	// Step 2.6: Match the image against document images with the image model;
+	// matches are added to the results rather than displacing them
+	if req.ImageData != "" {
+		if matches := qe.matchImages(ctx, req, cfg); len(matches) > 0 {
+			log.Printf("[Query] image model matches=%d", len(matches))
+			if debugMode {
+				dbg.Steps = append(dbg.Steps, fmt.Sprintf("Step 2.6: image similarity search matches=%d", len(matches)))
+			}
+			results = mergeSearchResults(results, matches, len(results)+len(matches))
+		}
+	}
+
 	// Step 3: If no results above threshold, try with lower threshold before giving up
 	if len(results) == 0 {
 		if debugMode {
 			dbg.RelaxedSearch = true
 			dbg.Steps = append(dbg.Steps, "Step 3: no results above threshold, trying relaxed search (threshold=0.0, accept>=0.3)")
 		}
-		relaxedResults, _ := qe.vectorStore.Search(queryVector, 3, 0.0, req.ProductID)
+		relaxedResults, _ := qe.search(ctx, req, queryVector, 3, 0.0)
 		log.Printf("[Query] relaxed search results=%d", len(relaxedResults))
 		for i, r := range relaxedResults {
 			log.Printf("[Query]   relaxed[%d] score=%.4f doc=%q dim_match=%v", i, r.Score, r.DocumentName, true)
@@ -513,7 +754,7 @@ func (qe *QueryEngine) Query(req QueryRequest) (*QueryResponse, error) {
 
 		// Also try relaxed search with image vector
 		if len(results) == 0 && len(imgVec) > 0 {
-			imgRelaxed, _ := qe.vectorStore.Search(imgVec, 3, 0.0, req.ProductID)
+			imgRelaxed, _ := qe.search(ctx, req, imgVec, 3, 0.0)
 			log.Printf("[Query] relaxed image search results=%d", len(imgRelaxed))
 			for i, r := range imgRelaxed {
 				log.Printf("[Query]   img_relaxed[%d] score=%.4f doc=%q", i, r.Score, r.DocumentName)
@@ -524,6 +765,16 @@ func (qe *QueryEngine) Query(req QueryRequest) (*QueryResponse, error) {
 		}
 	}
 
+	// Step 3.4: Collapse near-duplicate chunks, e.g. from re-uploaded or
+	// overlapping documents, to the best-scored one
+	if len(results) > 1 {
+		before := len(results)
+		results = qe.vectorStore.Dedup(results, cfg.Vector.DedupThreshold)
+		if debugMode && len(results) < before {
+			dbg.Steps = append(dbg.Steps, fmt.Sprintf("Step 3.4: dropped %d near-duplicate results (dedup_threshold=%.2f)", before-len(results), cfg.Vector.DedupThreshold))
+		}
+	}
+
 	// Step 3.5: Reorder results based on content priority setting
 	if len(results) > 1 && cfg != nil {
 		priority := cfg.Vector.ContentPriority
@@ -558,6 +809,9 @@ func (qe *QueryEngine) Query(req QueryRequest) (*QueryResponse, error) {
 
 	// Step 3.6: Enrich search results with video time information from video_segments table
 	results = qe.enrichVideoTimeInfo(results)
+	if req.trace != nil {
+		req.trace.Final = traceHits(results)
+	}
 
 	// Step 4: If still no results, create pending question
 	if len(results) == 0 {
@@ -569,40 +823,25 @@ func (qe *QueryEngine) Query(req QueryRequest) (*QueryResponse, error) {
 			if debugMode {
 				dbg.Steps = append(dbg.Steps, "Step 4: found similar pending question, returning 'already processing'")
 			}
-			pendingMsg := "该问题已在处理中，请耐心等待回复"
-			translated, tErr := ls.Generate(
-				"你是一个翻译助手。将以下内容翻译为与用户提问相同的语言。如果用户用英文提问，翻译为英文；如果用户用中文提问，保持中文。只输出翻译结果，不要添加任何解释。",
-				[]string{pendingMsg},
-				req.Question,
-			)
-			if tErr == nil && translated != "" {
-				pendingMsg = translated
-			}
 			return &QueryResponse{
 				IsPending: true,
-				Message:   pendingMsg,
+				Message:   Localize("该问题已在处理中，请耐心等待回复", req.Question),
 				DebugInfo: dbg,
 			}, nil
 		}
 
+		if req.NoPending {
+			return &QueryResponse{IsPending: true, DebugInfo: dbg}, nil
+		}
 		if err := qe.createPendingQuestion(req.Question, req.UserID, req.ImageData, req.ProductID); err != nil {
 			return nil, fmt.Errorf("failed to create pending question: %w", err)
 		}
 		if debugMode {
 			dbg.Steps = append(dbg.Steps, "Step 4: created new pending question, returning 'transferred to manual'")
 		}
-		pendingMsg := "该问题已转交人工处理，请稍后查看回复"
-		translated, tErr := ls.Generate(
-			"你是一个翻译助手。将以下内容翻译为与用户提问相同的语言。如果用户用英文提问，翻译为英文；如果用户用中文提问，保持中文。只输出翻译结果，不要添加任何解释。",
-			[]string{pendingMsg},
-			req.Question,
-		)
-		if tErr == nil && translated != "" {
-			pendingMsg = translated
-		}
 		return &QueryResponse{
 			IsPending: true,
-			Message:   pendingMsg,
+			Message:   Localize("该问题已转交人工处理，请稍后查看回复", req.Question),
 			DebugInfo: dbg,
 		}, nil
 	}
@@ -610,47 +849,57 @@ func (qe *QueryEngine) Query(req QueryRequest) (*QueryResponse, error) {
 	if debugMode {
 		dbg.Steps = append(dbg.Steps, fmt.Sprintf("Step 4: skipped (have %d results), proceeding to LLM", len(results)))
 	}
+	confidence := answerConfidence(results, cfg.Vector.Threshold)
 
 	// Step 4.5: Enrich search results with images from the same documents
 	// If search results don't include image chunks, look up image URLs
 	// from the same documents in the database.
-	docImages := qe.findDocumentImages(results)
+	docImages := qe.findDocumentImages(results, req.Question, queryVector, cfg)
 
-	// Step 5: Build context from search results and call LLM
-	context := make([]string, len(results))
+	// Step 5: Build context from search results, widened with neighboring
+	// chunks when enabled, and call LLM
+	chunks, expanded := qe.expandContext(results, cfg)
+	if debugMode && expanded > 0 {
+		dbg.Steps = append(dbg.Steps, fmt.Sprintf("Step 5: expanded context with %d neighboring chunks", expanded))
+	}
+
+	// Step 5.0: Map-reduce compression — with many chunks, answer from
+	// per-chunk summaries made with the cheaper model (llm.compress_*)
+	if cfg.LLM.CompressEnabled && len(chunks) >= cfg.LLM.CompressMinChunks {
+		var summarized, irrelevant int
+		chunks, results, summarized, irrelevant = qe.compressContext(ctx, req.Question, chunks, results, ls, cfg)
+		if debugMode {
+			dbg.Steps = append(dbg.Steps, fmt.Sprintf("Step 5.0: summarized %d chunks for the answer, left out %d irrelevant", summarized, irrelevant))
+		}
+	}
 	hasImages := len(docImages) > 0
 	for i, r := range results {
 		if r.ImageURL != "" {
-			context[i] = r.ChunkText + " (图片已附带，将自动展示给用户)"
+			chunks[i] += " (图片已附带，将自动展示给用户)"
 			hasImages = true
-		} else {
-			context[i] = r.ChunkText
 		}
 	}
 
-	systemPrompt := ""
-	if hasImages {
-		systemPrompt = "你是一个专业的软件技术支持助手。请根据提供的参考资料回答用户的问题。" +
-			"如果参考资料中没有相关信息，请如实告知用户。回答应简洁、准确、有条理。" +
-			"\n\n重要规则：你必须使用与用户提问相同的语言来回答。如果用户用英文提问，你必须用英文回答；如果用户用中文提问，你必须用中文回答；其他语言同理。无论参考资料是什么语言，都要翻译成用户提问的语言来回答。" +
-			"\n\n格式规则：使用有序列表时，请使用递增的序号（1. 2. 3.），不要所有条目都用1.开头。" +
-			"\n\n关于图片：参考资料中标记为[图片已附带]的内容，对应的图片会自动展示在你的回答下方。请在回答中自然地引导用户查看图片（例如：如下图所示、请参考下方图片），不要说无法提供图片或无法展示图片。"
+	// The answer language is set in the prompt, so the answer needs no
+	// translation afterwards
+	systemPrompt := answerPrompt(req.Question, hasImages, req.ImageData != "")
+
+	// Step 5.1: Keep the context within the model's context window, leaving
+	// out the lowest-scored chunks first; sources follow the chunks kept
+	var budget contextBudget
+	chunks, results, budget = fitContext(systemPrompt, chunks, req.Question, req.ImageData != "", results, cfg)
+	if budget.dropped > 0 || budget.truncated {
+		log.Printf("[Query] context trimmed to fit llm.context_window: dropped=%d truncated=%v tokens=%d/%d", budget.dropped, budget.truncated, budget.tokens, budget.available)
+		if debugMode {
+			dbg.Steps = append(dbg.Steps, fmt.Sprintf("Step 5.1: context trimmed to fit the context window — dropped %d chunks, truncated=%v, ~%d of %d prompt tokens", budget.dropped, budget.truncated, budget.tokens, budget.available))
+		}
 	}
 
-	// Use vision LLM when user attached an image
 	var answer string
 	if req.ImageData != "" {
-		visionPrompt := systemPrompt
-		if visionPrompt == "" {
-			visionPrompt = "你是一个专业的软件技术支持助手。用户上传了一张图片并提出了问题。" +
-				"请结合图片内容和提供的参考资料来回答用户的问题。" +
-				"如果参考资料中没有相关信息，请根据图片内容尽可能回答。回答应简洁、准确、有条理。" +
-				"\n\n重要规则：你必须使用与用户提问相同的语言来回答。" +
-				"\n\n格式规则：使用有序列表时，请使用递增的序号（1. 2. 3.），不要所有条目都用1.开头。"
-		}
-		answer, err = ls.GenerateWithImage(visionPrompt, context, req.Question, req.ImageData)
+		answer, err = ls.GenerateWithImage(ctx, systemPrompt, chunks, req.Question, req.ImageData)
 	} else {
-		answer, err = ls.Generate(systemPrompt, context, req.Question)
+		answer, err = ls.Generate(ctx, systemPrompt, chunks, req.Question)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate answer: %w", err)
@@ -667,21 +916,14 @@ func (qe *QueryEngine) Query(req QueryRequest) (*QueryResponse, error) {
 		if existing := qe.findSimilarPendingQuestion(req.Question, queryVector); existing != "" {
 			isPending = true
 		} else {
-			_ = qe.createPendingQuestion(req.Question, req.UserID, req.ImageData, req.ProductID)
+			if !req.NoPending {
+				_ = qe.createPendingQuestion(req.Question, req.UserID, req.ImageData, req.ProductID)
+			}
 			isPending = true
 		}
 		// When unable to answer, don't return sources/images — they are irrelevant noise
-		pendingMsg := "该问题已转交人工处理，请稍后查看回复"
-		translated, tErr := ls.Generate(
-			"你是一个翻译助手。将以下内容翻译为与用户提问相同的语言。如果用户用英文提问，翻译为英文；如果用户用中文提问，保持中文。只输出翻译结果，不要添加任何解释。",
-			[]string{pendingMsg},
-			req.Question,
-		)
-		if tErr == nil && translated != "" {
-			pendingMsg = translated
-		}
 		return &QueryResponse{
-			Answer:    pendingMsg,
+			Answer:    Localize("该问题已转交人工处理，请稍后查看回复", req.Question),
 			IsPending: true,
 			DebugInfo: dbg,
 		}, nil
@@ -689,6 +931,22 @@ func (qe *QueryEngine) Query(req QueryRequest) (*QueryResponse, error) {
 		dbg.Steps = append(dbg.Steps, "Step 5.5: LLM answered successfully")
 	}
 
+	// Step 5.6: Below the product's escalation threshold the answer is
+	// returned as an unverified draft and the question also goes to a human
+	unverified := false
+	if threshold := qe.escalationThreshold(req.ProductID); threshold > 0 && confidence < threshold {
+		unverified = true
+		log.Printf("[Query] answer confidence %.2f below escalation threshold %.2f, creating pending question", confidence, threshold)
+		if debugMode {
+			dbg.Steps = append(dbg.Steps, fmt.Sprintf("Step 5.6: confidence %.2f below escalation threshold %.2f, escalating to manual", confidence, threshold))
+		}
+		if !req.NoPending && qe.findSimilarPendingQuestion(req.Question, queryVector) == "" {
+			if err := qe.createPendingQuestion(req.Question, req.UserID, req.ImageData, req.ProductID); err != nil {
+				log.Printf("[Query] failed to create pending question for unverified answer: %v", err)
+			}
+		}
+	}
+
 	// Step 6: Build source references
 	sources := qe.buildSourceRefs(results)
 
@@ -696,20 +954,39 @@ func (qe *QueryEngine) Query(req QueryRequest) (*QueryResponse, error) {
 	for _, img := range docImages {
 		sources = append(sources, img)
 	}
+	sources = qe.limitAnswerImages(sources, cfg.Vector.MaxAnswerImages)
+
+	if useAnswerCache && !unverified {
+		qe.answerCache.put(&answerCacheEntry{
+			scope:      cacheScope,
+			lang:       i18n.Detect(req.Question),
+			vector:     queryVector,
+			answer:     answer,
+			sources:    append([]SourceRef(nil), sources...),
+			generation: cacheGeneration,
+			created:    time.Now(),
+		}, cfg.Vector.SemanticCacheMaxEntries)
+	}
 
-	return &QueryResponse{
-		Answer:    answer,
-		Sources:   sources,
-		IsPending: isPending,
-		DebugInfo: dbg,
-	}, nil
+	resp := &QueryResponse{
+		Answer:     answer,
+		Sources:    sources,
+		IsPending:  isPending,
+		DebugInfo:  dbg,
+		Confidence: confidence,
+		Unverified: unverified,
+	}
+	if unverified {
+		resp.Message = Localize("该回答未经人工核实，问题已同时转交人工处理", req.Question)
+	}
+	return resp, nil
 }
 
 // findDocumentImages queries the database for image chunks from the same documents
 // as the search results. Only returns images that are related to the matched chunks,
 // using chunk_index proximity. For scanned PDFs, text chunks have index 0,1,2...
 // and image chunks have index 1000+page, so we map accordingly.
-func (qe *QueryEngine) findDocumentImages(results []vectorstore.SearchResult) []SourceRef {
+func (qe *QueryEngine) findDocumentImages(results []vectorstore.SearchResult, question string, queryVector []float64, cfg *config.Config) []SourceRef {
 	// Check if results already have images
 	for _, r := range results {
 		if r.ImageURL != "" {
@@ -754,7 +1031,7 @@ func (qe *QueryEngine) findDocumentImages(results []vectorstore.SearchResult) []
 		args[i] = id
 	}
 
-	query := `SELECT document_id, chunk_index, image_url, chunk_text FROM chunks WHERE document_id IN (` +
+	query := `SELECT document_id, chunk_index, image_url, chunk_text, embedding FROM chunks WHERE document_id IN (` +
 		strings.Join(placeholders, ",") + `) AND image_url != '' AND image_url IS NOT NULL`
 	rows, err := qe.readDB.Query(query, args...)
 	if err != nil {
@@ -764,20 +1041,31 @@ func (qe *QueryEngine) findDocumentImages(results []vectorstore.SearchResult) []
 
 	// Collect all candidate images
 	type imgCandidate struct {
-		docID string
-		idx   int
+		docID  string
+		idx    int
 		imgURL string
-		text  string
+		text   string
+		score  float64
 	}
 	var candidates []imgCandidate
 	for rows.Next() {
 		var c imgCandidate
-		if err := rows.Scan(&c.docID, &c.idx, &c.imgURL, &c.text); err != nil {
+		var emb []byte
+		if err := rows.Scan(&c.docID, &c.idx, &c.imgURL, &c.text, &emb); err != nil {
 			continue
 		}
 		if c.imgURL == "" {
 			continue
 		}
+		if c.text, err = qe.vectorStore.DecodeText(c.text); err != nil {
+			continue
+		}
+		// Relevance to the question: the better of the image embedding
+		// (or its caption's) and the caption's text similarity
+		if vec := vectorstore.DeserializeVector(emb); len(vec) == len(queryVector) {
+			c.score = vectorstore.CosineSimilarity(queryVector, vec)
+		}
+		c.score = max(c.score, textSimilarity(question, c.text))
 		candidates = append(candidates, c)
 	}
 
@@ -819,10 +1107,10 @@ func (qe *QueryEngine) findDocumentImages(results []vectorstore.SearchResult) []
 
 	const proximity = 1
 	const timeProximity = 30.0 // seconds: match keyframes within ±30s of search hit time range
-	var images []SourceRef
+	var matches []imgCandidate
 	for _, c := range candidates {
 		h := docHits[c.docID]
-		if h == nil {
+		if h == nil || c.score < cfg.Vector.ImageRelevanceThreshold {
 			continue
 		}
 		matched := false
@@ -860,23 +1148,80 @@ func (qe *QueryEngine) findDocumentImages(results []vectorstore.SearchResult) []
 		}
 
 		if matched {
-			images = append(images, SourceRef{
-				DocumentName: h.name,
-				ChunkIndex:   c.idx,
-				Snippet:      c.text,
-				ImageURL:     c.imgURL,
-			})
+			matches = append(matches, c)
 		}
 	}
 
-	// Safety cap: never return more than 5 enriched images
-	if len(images) > 5 {
-		images = images[:5]
+	// Most relevant first; limitAnswerImages drops duplicates and caps the count
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	images := make([]SourceRef, 0, len(matches))
+	for _, c := range matches {
+		images = append(images, SourceRef{
+			DocumentName: docHits[c.docID].name,
+			ChunkIndex:   c.idx,
+			Snippet:      c.text,
+			ImageURL:     c.imgURL,
+		})
 	}
-
 	return images
 }
 
+// limitAnswerImages drops image sources showing an image already attached
+// (same URL, or same content for images recorded with a hash) and image
+// sources beyond maxImages, keeping the earlier, more relevant ones.
+func (qe *QueryEngine) limitAnswerImages(sources []SourceRef, maxImages int) []SourceRef {
+	var ids []string
+	for _, s := range sources {
+		if id := blob.IDFromURL(s.ImageURL); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	hashes := make(map[string]string) // image ID -> sha256
+	if len(ids) > 0 {
+		placeholders := make([]string, len(ids))
+		args := make([]interface{}, len(ids))
+		for i, id := range ids {
+			placeholders[i] = "?"
+			args[i] = id
+		}
+		rows, err := qe.readDB.Query(`SELECT id, sha256 FROM images WHERE sha256 != '' AND id IN (`+
+			strings.Join(placeholders, ",")+`)`, args...)
+		if err == nil {
+			defer rows.Close()
+			for rows.Next() {
+				var id, sum string
+				if rows.Scan(&id, &sum) == nil {
+					hashes[id] = sum
+				}
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	count := 0
+	kept := sources[:0:0]
+	for _, s := range sources {
+		if s.ImageURL == "" {
+			kept = append(kept, s)
+			continue
+		}
+		key := s.ImageURL
+		if id := blob.IDFromURL(s.ImageURL); id != "" {
+			key = id
+			if sum := hashes[id]; sum != "" {
+				key = "sha256:" + sum
+			}
+		}
+		if seen[key] || count >= maxImages {
+			continue
+		}
+		seen[key] = true
+		count++
+		kept = append(kept, s)
+	}
+	return kept
+}
+
 // findCachedAnswer looks up a cached LLM answer for a document ID.
 // If the document is a pending-answer (docID starts with "pending-answer-"),
 // it returns the stored llm_answer from the pending_questions table.
@@ -970,8 +1315,100 @@ func charBigrams(s string) map[string]bool {
 }
 
 
-// createPendingQuestion inserts a new pending question record into the database.
+// Retrieve embeds question and returns the chunks the RAG pipeline would
+// pass to the LLM for productID: the top topK vector matches scoring at least
+// threshold. Unlike Query it never answers, caches answers or creates
+// pending questions, so it is safe for offline evaluation.
+func (qe *QueryEngine) Retrieve(ctx context.Context, question, productID string, topK int, threshold float64) ([]vectorstore.SearchResult, error) {
+	es, _, cfg := qe.getServices()
+	queryVector, err := qe.cachedEmbed(ctx, question, es)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed question: %w", err)
+	}
+	results, err := qe.retrieve(ctx, QueryRequest{Question: question, ProductID: productID}, cfg, queryVector, topK, threshold)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search vector store: %w", err)
+	}
+	return results, nil
+}
+
+// search runs a vector search over the products req may see.
+func (qe *QueryEngine) search(ctx context.Context, req QueryRequest, queryVector []float64, topK int, threshold float64) ([]vectorstore.SearchResult, error) {
+	if req.ProductScope != nil {
+		return qe.vectorStore.SearchProducts(ctx, queryVector, topK, threshold, req.ProductScope)
+	}
+	return qe.vectorStore.Search(ctx, queryVector, topK, threshold, req.ProductID)
+}
+
+// retrieve runs the search whose results are given to the LLM: a vector
+// search, with the results picked by MMR when cfg enables it.
+func (qe *QueryEngine) retrieve(ctx context.Context, req QueryRequest, cfg *config.Config, queryVector []float64, topK int, threshold float64) ([]vectorstore.SearchResult, error) {
+	if cfg == nil || !cfg.Vector.MMREnabled {
+		return qe.search(ctx, req, queryVector, topK, threshold)
+	}
+	lambda := cfg.Vector.MMRLambda
+	if req.ProductScope != nil {
+		return qe.vectorStore.SearchProductsMMR(ctx, queryVector, topK, threshold, lambda, req.ProductScope)
+	}
+	return qe.vectorStore.SearchMMR(ctx, queryVector, topK, threshold, lambda, req.ProductID)
+}
+
+// textSearch runs a text search over the products req may see.
+func (qe *QueryEngine) textSearch(ctx context.Context, req QueryRequest, text string, topK int, threshold float64) ([]vectorstore.SearchResult, error) {
+	if req.ProductScope != nil {
+		return qe.vectorStore.TextSearchProducts(ctx, text, topK, threshold, req.ProductScope)
+	}
+	return qe.vectorStore.TextSearch(ctx, text, topK, threshold, req.ProductID)
+}
+
+// SetPendingCreatedHook registers a callback invoked after the engine
+// automatically creates a pending question for an unanswerable query.
+func (qe *QueryEngine) SetPendingCreatedHook(fn func(id, question, userID, productID string)) {
+	qe.mu.Lock()
+	defer qe.mu.Unlock()
+	qe.onPendingCreated = fn
+}
+
+// SetRefusalCheck registers the check that declines questions on the
+// "do not answer" list before any retrieval.
+func (qe *QueryEngine) SetRefusalCheck(fn RefusalCheck) {
+	qe.mu.Lock()
+	defer qe.mu.Unlock()
+	qe.refusalCheck = fn
+}
+
+// SetVisibility registers the function that decides which documents each
+// asker's searches skip. Without one every document is searched.
+func (qe *QueryEngine) SetVisibility(fn Visibility) {
+	qe.mu.Lock()
+	defer qe.mu.Unlock()
+	qe.visibility = fn
+}
+
+// SetPendingSink makes new pending questions go to sink instead of the
+// local database.
+func (qe *QueryEngine) SetPendingSink(sink PendingSink) {
+	qe.mu.Lock()
+	defer qe.mu.Unlock()
+	qe.pendingSink = sink
+}
+
+// createPendingQuestion records a new pending question, in the pending sink
+// when one is set.
 func (qe *QueryEngine) createPendingQuestion(question, userID, imageData, productID string) error {
+	qe.mu.RLock()
+	sink := qe.pendingSink
+	qe.mu.RUnlock()
+	if sink != nil {
+		return sink(question, userID, imageData, productID)
+	}
+	return qe.CreatePendingQuestion(question, userID, imageData, productID)
+}
+
+// CreatePendingQuestion inserts a new pending question record into the
+// database and runs the pending-created hook. It is also used for questions
+// that went pending on a read-only replica.
+func (qe *QueryEngine) CreatePendingQuestion(question, userID, imageData, productID string) error {
 	id, err := generateID()
 	if err != nil {
 		return err
@@ -980,7 +1417,16 @@ func (qe *QueryEngine) createPendingQuestion(question, userID, imageData, produc
 		`INSERT INTO pending_questions (id, question, user_id, status, image_data, product_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
 		id, question, userID, "pending", imageData, productID, time.Now().UTC(),
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	qe.mu.RLock()
+	hook := qe.onPendingCreated
+	qe.mu.RUnlock()
+	if hook != nil {
+		hook(id, question, userID, productID)
+	}
+	return nil
 }
 
 // isUnableToAnswer detects if the LLM response indicates it could not find
@@ -1103,10 +1549,17 @@ func (qe *QueryEngine) enrichVideoTimeInfo(results []vectorstore.SearchResult) [
 
 
 
-// lookupDocumentTypes queries the documents table to get the type for each unique document ID.
+// documentMeta is what sources show of their document besides its name.
+type documentMeta struct {
+	docType string
+	version string
+}
+
+// lookupDocuments queries the documents table to get the type and source
+// version for each unique document ID.
 // Returns a map from document_id to document type (e.g., "video", "pdf", "word").
-func (qe *QueryEngine) lookupDocumentTypes(docIDs []string) map[string]string {
-	result := make(map[string]string)
+func (qe *QueryEngine) lookupDocuments(docIDs []string) map[string]documentMeta {
+	result := make(map[string]documentMeta)
 	if qe.readDB == nil || len(docIDs) == 0 {
 		return result
 	}
@@ -1125,30 +1578,69 @@ func (qe *QueryEngine) lookupDocumentTypes(docIDs []string) map[string]string {
 		placeholders[i] = "?"
 		args[i] = id
 	}
-	q := `SELECT id, type FROM documents WHERE id IN (` + strings.Join(placeholders, ",") + `)`
+	q := `SELECT id, type, source_version FROM documents WHERE id IN (` + strings.Join(placeholders, ",") + `)`
 	rows, err := qe.readDB.Query(q, args...)
 	if err != nil {
 		return result
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var id, docType string
-		if err := rows.Scan(&id, &docType); err != nil {
+		var id string
+		var meta documentMeta
+		if err := rows.Scan(&id, &meta.docType, &meta.version); err != nil {
 			continue
 		}
-		result[id] = docType
+		result[id] = meta
 	}
 	return result
 }
 
-// buildSourceRefs converts search results into SourceRef slice, enriching with document type info.
+// chunkLocation is a row of the chunk_locations table.
+type chunkLocation struct {
+	start, end, page, slide int
+}
+
+// lookupChunkLocations queries the chunk_locations table for where each
+// result's chunk lies in its original document, keyed by chunk ID.
+func (qe *QueryEngine) lookupChunkLocations(results []vectorstore.SearchResult) map[string]chunkLocation {
+	locations := make(map[string]chunkLocation)
+	if qe.readDB == nil || len(results) == 0 {
+		return locations
+	}
+	placeholders := make([]string, len(results))
+	args := make([]interface{}, len(results))
+	for i, r := range results {
+		placeholders[i] = "?"
+		args[i] = fmt.Sprintf("%s-%d", r.DocumentID, r.ChunkIndex)
+	}
+	q := `SELECT chunk_id, start_offset, end_offset, page, slide FROM chunk_locations WHERE chunk_id IN (` + strings.Join(placeholders, ",") + `)`
+	rows, err := qe.readDB.Query(q, args...)
+	if err != nil {
+		return locations
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var chunkID string
+		var loc chunkLocation
+		if err := rows.Scan(&chunkID, &loc.start, &loc.end, &loc.page, &loc.slide); err != nil {
+			continue
+		}
+		locations[chunkID] = loc
+	}
+	return locations
+}
+
+// buildSourceRefs converts search results into SourceRef slice, enriching with
+// document type and version info and the location of each chunk in its
+// document.
 func (qe *QueryEngine) buildSourceRefs(results []vectorstore.SearchResult) []SourceRef {
 	// Collect document IDs
 	docIDs := make([]string, 0, len(results))
 	for _, r := range results {
 		docIDs = append(docIDs, r.DocumentID)
 	}
-	docTypes := qe.lookupDocumentTypes(docIDs)
+	docs := qe.lookupDocuments(docIDs)
+	locations := qe.lookupChunkLocations(results)
 
 	sources := make([]SourceRef, len(results))
 	for i, r := range results {
@@ -1157,14 +1649,21 @@ func (qe *QueryEngine) buildSourceRefs(results []vectorstore.SearchResult) []Sou
 			snippet = string(runes[:100])
 		}
 		sources[i] = SourceRef{
-			DocumentID:   r.DocumentID,
-			DocumentName: r.DocumentName,
-			DocumentType: docTypes[r.DocumentID],
-			ChunkIndex:   r.ChunkIndex,
-			Snippet:      snippet,
-			ImageURL:     r.ImageURL,
-			StartTime:    r.StartTime,
-			EndTime:      r.EndTime,
+			DocumentID:      r.DocumentID,
+			DocumentName:    r.DocumentName,
+			DocumentType:    docs[r.DocumentID].docType,
+			DocumentVersion: docs[r.DocumentID].version,
+			ChunkIndex:      r.ChunkIndex,
+			Snippet:         snippet,
+			ImageURL:        r.ImageURL,
+			StartTime:       r.StartTime,
+			EndTime:         r.EndTime,
+		}
+		if loc, ok := locations[fmt.Sprintf("%s-%d", r.DocumentID, r.ChunkIndex)]; ok {
+			sources[i].StartOffset = loc.start
+			sources[i].EndOffset = loc.end
+			sources[i].Page = loc.page
+			sources[i].Slide = loc.slide
 		}
 	}
 	return sources
@@ -0,0 +1,181 @@
+package query
+
+import (
+	"log"
+	"strings"
+
+	"askflow/internal/config"
+	"askflow/internal/embedding"
+	"askflow/internal/vectorstore"
+)
+
+// minJoinOverlap and maxJoinOverlap bound the text shared by consecutive
+// chunks that joinChunks looks for, in bytes; shorter matches are taken to
+// be chance.
+const (
+	minJoinOverlap = 16
+	maxJoinOverlap = 4096
+)
+
+// expansion is the run of text chunks [lo, hi] of one document that stands
+// in for a matched chunk in the LLM context.
+type expansion struct {
+	lo, hi int
+	// stopLo and stopHi are set once a side cannot grow any further
+	stopLo, stopHi bool
+}
+
+// expandContext returns the context text for each result: text chunks are
+// widened with up to cfg.Vector.ContextWindow neighboring chunks of their
+// document on each side, nearest first and best-ranked result first, while
+// the whole context stays within cfg.Vector.ContextTokenBudget. Neighbors
+// are not repeated across results, and the text consecutive chunks overlap
+// by is included once. It also returns the number of neighbors added.
+func (qe *QueryEngine) expandContext(results []vectorstore.SearchResult, cfg *config.Config) ([]string, int) {
+	texts := make([]string, len(results))
+	for i, r := range results {
+		texts[i] = r.ChunkText
+	}
+	if cfg == nil || cfg.Vector.ContextWindow <= 0 || qe.readDB == nil || len(results) == 0 {
+		return texts, 0
+	}
+	window := cfg.Vector.ContextWindow
+	neighbors := qe.loadNeighbors(results, window)
+	if len(neighbors) == 0 {
+		return texts, 0
+	}
+
+	// used[doc][index] marks chunks already in the context
+	used := make(map[string]map[int]bool)
+	tokens := 0
+	spans := make([]*expansion, len(results))
+	for i, r := range results {
+		tokens += embedding.EstimateTokens(r.ChunkText)
+		if r.ImageURL != "" || neighbors[r.DocumentID] == nil {
+			continue
+		}
+		if used[r.DocumentID] == nil {
+			used[r.DocumentID] = make(map[int]bool)
+		}
+		used[r.DocumentID][r.ChunkIndex] = true
+		spans[i] = &expansion{lo: r.ChunkIndex, hi: r.ChunkIndex}
+	}
+
+	added := 0
+	grow := func(doc string, idx int) bool {
+		text, ok := neighbors[doc][idx]
+		if !ok || used[doc][idx] {
+			return false
+		}
+		n := embedding.EstimateTokens(text)
+		if tokens+n > cfg.Vector.ContextTokenBudget {
+			return false
+		}
+		tokens += n
+		used[doc][idx] = true
+		added++
+		return true
+	}
+	for d := 1; d <= window; d++ {
+		for i, sp := range spans {
+			if sp == nil {
+				continue
+			}
+			doc := results[i].DocumentID
+			if !sp.stopLo {
+				if grow(doc, sp.lo-1) {
+					sp.lo--
+				} else {
+					sp.stopLo = true
+				}
+			}
+			if !sp.stopHi {
+				if grow(doc, sp.hi+1) {
+					sp.hi++
+				} else {
+					sp.stopHi = true
+				}
+			}
+		}
+	}
+
+	for i, sp := range spans {
+		if sp == nil || sp.lo == sp.hi {
+			continue
+		}
+		doc := results[i].DocumentID
+		var text string
+		for idx := sp.lo; idx <= sp.hi; idx++ {
+			chunk := results[i].ChunkText
+			if idx != results[i].ChunkIndex {
+				chunk = neighbors[doc][idx]
+			}
+			text = joinChunks(text, chunk)
+		}
+		texts[i] = text
+	}
+	return texts, added
+}
+
+// loadNeighbors reads the text chunks within window of the text results,
+// keyed by document ID and chunk index.
+func (qe *QueryEngine) loadNeighbors(results []vectorstore.SearchResult, window int) map[string]map[int]string {
+	type indexRange struct{ lo, hi int }
+	ranges := make(map[string]*indexRange)
+	var docs []string
+	for _, r := range results {
+		if r.ImageURL != "" {
+			continue
+		}
+		lo, hi := r.ChunkIndex-window, r.ChunkIndex+window
+		if rg := ranges[r.DocumentID]; rg != nil {
+			rg.lo, rg.hi = min(rg.lo, lo), max(rg.hi, hi)
+			continue
+		}
+		ranges[r.DocumentID] = &indexRange{lo, hi}
+		docs = append(docs, r.DocumentID)
+	}
+
+	neighbors := make(map[string]map[int]string, len(docs))
+	for _, doc := range docs {
+		rg := ranges[doc]
+		rows, err := qe.readDB.Query(
+			`SELECT chunk_index, chunk_text FROM chunks
+			 WHERE document_id = ? AND chunk_index BETWEEN ? AND ? AND COALESCE(image_url, '') = ''`,
+			doc, rg.lo, rg.hi)
+		if err != nil {
+			log.Printf("[Query] failed to load neighboring chunks of %s: %v", doc, err)
+			continue
+		}
+		chunks := make(map[int]string)
+		for rows.Next() {
+			var idx int
+			var text string
+			if err := rows.Scan(&idx, &text); err != nil {
+				continue
+			}
+			if text, err = qe.vectorStore.DecodeText(text); err != nil {
+				continue
+			}
+			chunks[idx] = text
+		}
+		rows.Close()
+		neighbors[doc] = chunks
+	}
+	return neighbors
+}
+
+// joinChunks appends next to text, dropping the start of next that repeats
+// the end of text, as consecutive chunks overlap.
+func joinChunks(text, next string) string {
+	if text == "" {
+		return next
+	}
+	limit := min(len(text), len(next), maxJoinOverlap)
+	for k := limit; k >= minJoinOverlap; k-- {
+		if strings.HasSuffix(text, next[:k]) {
+			return text + next[k:]
+		}
+	}
+	return text + "\n" + next
+}
@@ -0,0 +1,213 @@
+package query
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"image/color"
+	_ "image/gif" // register GIF decoder
+	"image/jpeg"
+	_ "image/png" // register PNG decoder
+	"net/http"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // register WebP decoder
+)
+
+// Limits of images attached to questions. Decoding is refused above
+// maxImagePixels so a small file cannot expand into gigabytes of pixels.
+const (
+	maxImageBytes  = 5 << 20 // decoded file size
+	maxImageEdge   = 8192
+	maxImagePixels = 40_000_000
+	// imageMaxEdge is the longest edge images are scaled down to; vision
+	// and multimodal embedding models resize to about this size anyway.
+	imageMaxEdge = 1536
+	imageQuality = 85
+)
+
+// ImageError is returned for an attached image that cannot be used. Its
+// message is meant for the user.
+type ImageError struct {
+	Reason string
+}
+
+func (e *ImageError) Error() string {
+	return e.Reason
+}
+
+// Errors returned by PrepareImage.
+var (
+	ErrImageInvalid    = &ImageError{"图片数据无效"}
+	ErrImageFormat     = &ImageError{"不支持的图片格式，支持PNG/JPEG/GIF/WebP"}
+	ErrImageTooLarge   = &ImageError{"图片文件过大，请压缩后重试"}
+	ErrImageDimensions = &ImageError{"图片尺寸过大，请缩小后重试"}
+)
+
+// imageTypes are the image types accepted, as sniffed from the data.
+var imageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// PrepareImage validates an image attached to a question, given as a base64
+// data URL or plain base64, and returns it as a JPEG data URL ready for the
+// embedding and LLM APIs. The type is sniffed from the data rather than
+// taken from the URL. The image is turned upright by its EXIF orientation,
+// scaled down to imageMaxEdge and re-encoded, which drops EXIF and other
+// metadata such as GPS positions; transparent areas become white.
+func PrepareImage(data string) (string, error) {
+	payload := data
+	if rest, ok := strings.CutPrefix(data, "data:"); ok {
+		header, b64, ok := strings.Cut(rest, ",")
+		if !ok || !strings.HasSuffix(header, ";base64") || !strings.HasPrefix(header, "image/") {
+			return "", ErrImageInvalid
+		}
+		payload = b64
+	}
+	if base64.StdEncoding.DecodedLen(len(payload)) > maxImageBytes+2 {
+		return "", ErrImageTooLarge
+	}
+	raw, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", ErrImageInvalid
+	}
+	if len(raw) > maxImageBytes {
+		return "", ErrImageTooLarge
+	}
+	if !imageTypes[http.DetectContentType(raw)] {
+		return "", ErrImageFormat
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return "", ErrImageInvalid
+	}
+	if cfg.Width < 1 || cfg.Height < 1 {
+		return "", ErrImageInvalid
+	}
+	if cfg.Width > maxImageEdge || cfg.Height > maxImageEdge || cfg.Width*cfg.Height > maxImagePixels {
+		return "", ErrImageDimensions
+	}
+	src, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return "", ErrImageInvalid
+	}
+
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w > imageMaxEdge || h > imageMaxEdge {
+		if w >= h {
+			w, h = imageMaxEdge, max(h*imageMaxEdge/w, 1)
+		} else {
+			w, h = max(w*imageMaxEdge/h, 1), imageMaxEdge
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.BiLinear.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, orient(dst, jpegOrientation(raw)), &jpeg.Options{Quality: imageQuality}); err != nil {
+		return "", err
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// jpegOrientation returns the EXIF orientation (1–8) of JPEG data, or 1 when
+// it has none.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 { // image data follows
+			break
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if n < 2 || i+2+n > len(data) {
+			break
+		}
+		seg := data[i+4 : i+2+n]
+		if marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return exifOrientation(seg[6:])
+		}
+		i += 2 + n
+	}
+	return 1
+}
+
+// exifOrientation reads the Orientation tag from the first IFD of the TIFF
+// structure in an EXIF segment.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		e := ifd + 2 + 12*i
+		if e+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[e:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[e+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// orient returns img turned upright for an EXIF orientation.
+func orient(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-x, y
+			case 3: // upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored upside down
+				sx, sy = x, h-1-y
+			case 5: // mirrored, turned 90° counter-clockwise
+				sx, sy = y, x
+			case 6: // turned 90° counter-clockwise
+				sx, sy = y, h-1-x
+			case 7: // mirrored, turned 90° clockwise
+				sx, sy = w-1-y, h-1-x
+			case 8: // turned 90° clockwise
+				sx, sy = w-1-y, x
+			}
+			dst.SetRGBA(x, y, img.RGBAAt(sx, sy))
+		}
+	}
+	return dst
+}
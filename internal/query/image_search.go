@@ -0,0 +1,116 @@
+package query
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+
+	"askflow/internal/config"
+	"askflow/internal/embedding"
+	"askflow/internal/errlog"
+	"askflow/internal/vectorstore"
+)
+
+// SetImageEmbeddingService sets the image embedding model (image_embedding.*
+// in config) images attached to questions are matched against document
+// images with; nil turns image similarity search off.
+func (qe *QueryEngine) SetImageEmbeddingService(es embedding.EmbeddingService) {
+	qe.mu.Lock()
+	defer qe.mu.Unlock()
+	qe.imageEmbedding = es
+}
+
+// matchImages embeds the image attached to req with the image embedding
+// model and returns the document images most similar to it. It returns nil
+// when no image model is configured or the lookup fails.
+func (qe *QueryEngine) matchImages(ctx context.Context, req QueryRequest, cfg *config.Config) []vectorstore.SearchResult {
+	qe.mu.RLock()
+	es := qe.imageEmbedding
+	qe.mu.RUnlock()
+	if es == nil || cfg == nil || !cfg.ImageEmbed.Configured() {
+		return nil
+	}
+	vec, err := es.EmbedImageURL(ctx, req.ImageData)
+	if err != nil {
+		log.Printf("[Query] image model embedding failed: %v", err)
+		errlog.Logf("[Query] image model embedding failed: %v", err)
+		return nil
+	}
+	c := cfg.ImageEmbed
+	matches, err := qe.searchImages(ctx, req, vec, c.ModelName, c.TopK, c.Threshold)
+	if err != nil {
+		log.Printf("[Query] image similarity search failed: %v", err)
+		return nil
+	}
+	return matches
+}
+
+// searchImages returns up to topK document images whose vectors from the
+// image model are at least threshold similar to vec, among the products req
+// may see. Document images are few next to text chunks, so their vectors
+// are read from the database and compared in full on each search.
+func (qe *QueryEngine) searchImages(ctx context.Context, req QueryRequest, vec []float64, model string, topK int, threshold float64) ([]vectorstore.SearchResult, error) {
+	if qe.readDB == nil {
+		return nil, nil
+	}
+	q := `SELECT c.document_id, c.document_name, c.chunk_index, c.chunk_text, COALESCE(c.image_url, ''), COALESCE(c.product_id, ''), e.embedding
+		FROM chunk_image_embeddings e JOIN chunks c ON c.id = e.chunk_id
+		WHERE e.model = ?`
+	args := []interface{}{model}
+	switch {
+	case req.ProductScope != nil:
+		if len(req.ProductScope) == 0 {
+			return nil, nil
+		}
+		q += ` AND c.product_id IN (?` + strings.Repeat(", ?", len(req.ProductScope)-1) + `)`
+		for _, id := range req.ProductScope {
+			args = append(args, id)
+		}
+	case req.ProductID != "":
+		// The product's images and the public library
+		q += ` AND (c.product_id = ? OR COALESCE(c.product_id, '') = '')`
+		args = append(args, req.ProductID)
+	}
+	rows, err := qe.readDB.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	filter := vectorstore.FilterFrom(ctx)
+	var results []vectorstore.SearchResult
+	for rows.Next() {
+		var r vectorstore.SearchResult
+		var text string
+		var data []byte
+		if err := rows.Scan(&r.DocumentID, &r.DocumentName, &r.ChunkIndex, &text, &r.ImageURL, &r.ProductID, &data); err != nil {
+			return nil, err
+		}
+		if filter.Hides(r.DocumentID) {
+			continue
+		}
+		stored := vectorstore.DeserializeVector(data)
+		if len(stored) != len(vec) {
+			continue
+		}
+		r.Score = vectorstore.CosineSimilarity(vec, stored)
+		if r.Score < threshold {
+			continue
+		}
+		if r.ChunkText, err = qe.vectorStore.DecodeText(text); err != nil {
+			continue
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > topK {
+		results = results[:topK]
+	}
+	return results, nil
+}
@@ -0,0 +1,238 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"askflow/internal/config"
+	"askflow/internal/embedding"
+	"askflow/internal/llm"
+	"askflow/internal/vectorstore"
+)
+
+// IntentResult represents the result of intent classification.
+type IntentResult struct {
+	Intent     string  // "greeting", "product", "irrelevant" or a custom intent
+	Reason     string  // why a question is irrelevant, from the LLM classifier
+	Similarity float64 // similarity to the nearest example, from the embedding classifier
+}
+
+// intentExample is an example question of an intent.
+type intentExample struct {
+	intent string
+	text   string
+}
+
+// builtinIntentExamples are the example questions of the built-in intents
+// for the embedding classifier. The product examples keep short product
+// questions from being taken for greetings.
+var builtinIntentExamples = []intentExample{
+	{config.IntentGreeting, "你好"},
+	{config.IntentGreeting, "您好"},
+	{config.IntentGreeting, "在吗"},
+	{config.IntentGreeting, "hi"},
+	{config.IntentGreeting, "hello"},
+	{config.IntentProduct, "这是什么产品"},
+	{config.IntentProduct, "下载地址"},
+	{config.IntentProduct, "怎么安装"},
+	{config.IntentProduct, "How do I install it?"},
+	{config.IntentIrrelevant, "今天天气怎么样"},
+	{config.IntentIrrelevant, "讲个笑话"},
+	{config.IntentIrrelevant, "What's the weather like today?"},
+	{config.IntentIrrelevant, "Tell me a joke"},
+}
+
+// classifyIntent determines the user's intent with the classifier chosen
+// by intent.mode.
+func (qe *QueryEngine) classifyIntent(ctx context.Context, question string, es embedding.EmbeddingService, ls llm.LLMService, cfg *config.Config) (*IntentResult, error) {
+	if cfg != nil && cfg.Intent.Mode == config.IntentModeEmbedding {
+		return qe.classifyIntentByEmbedding(ctx, question, es, cfg)
+	}
+	return qe.classifyIntentByLLM(ctx, question, ls, cfg)
+}
+
+// classifyIntentByLLM uses the LLM to determine the user's intent.
+func (qe *QueryEngine) classifyIntentByLLM(ctx context.Context, question string, ls llm.LLMService, cfg *config.Config) (*IntentResult, error) {
+	productIntro := ""
+	var custom []config.CustomIntent
+	if cfg != nil {
+		productIntro = cfg.ProductIntro
+		custom = cfg.Intent.Custom
+	}
+
+	systemPrompt := "你是一个意图分类器。根据用户输入判断意图类别。"
+	if productIntro != "" {
+		systemPrompt += "\n\n产品介绍：" + productIntro
+	}
+	systemPrompt += "\n\n请只回复一个JSON对象，格式：{\"intent\":\"类别\"}" +
+		"\n\n意图类别：" +
+		"\n- greeting: 仅限纯粹的打招呼和问候语（如：你好、hi、hello、在吗）" +
+		"\n- product: 任何与产品相关的问题，包括但不限于：功能介绍、下载、安装、使用方法、技术问题、故障排查、价格、版本等" +
+		"\n- irrelevant: 与产品完全无关的问题（如天气、笑话、新闻、个人情感等）"
+	for _, ci := range custom {
+		systemPrompt += "\n- " + ci.Name + ": " + ci.Description
+		if len(ci.Examples) > 0 {
+			systemPrompt += "（如：" + strings.Join(ci.Examples, "、") + "）"
+		}
+	}
+	systemPrompt += "\n\n重要规则：如果用户在询问任何具体信息（即使很简短），都应归类为product而非greeting。"
+	if len(custom) > 0 {
+		systemPrompt += "符合其他类别描述的问题优先归入该类别，而不是product。"
+	}
+	systemPrompt += "\n\n示例：" +
+		"\n\"你好\" → {\"intent\":\"greeting\"}" +
+		"\n\"hi\" → {\"intent\":\"greeting\"}" +
+		"\n\"这是什么产品\" → {\"intent\":\"product\"}" +
+		"\n\"下载地址\" → {\"intent\":\"product\"}" +
+		"\n\"怎么安装\" → {\"intent\":\"product\"}" +
+		"\n\"今天天气怎么样\" → {\"intent\":\"irrelevant\",\"reason\":\"天气查询与产品无关\"}"
+	for _, ci := range custom {
+		for _, e := range ci.Examples {
+			systemPrompt += fmt.Sprintf("\n%q → {\"intent\":%q}", e, ci.Name)
+		}
+	}
+
+	answer, err := ls.Generate(ctx, systemPrompt, nil, question)
+	if err != nil {
+		// If classification fails, default to allowing the query
+		return &IntentResult{Intent: config.IntentProduct}, nil
+	}
+
+	// Parse JSON response — extract first JSON object
+	start := -1
+	end := -1
+	for i, c := range answer {
+		if c == '{' && start == -1 {
+			start = i
+		}
+		if c == '}' {
+			end = i + 1
+		}
+	}
+	if start >= 0 && end > start {
+		var parsed struct {
+			Intent string `json:"intent"`
+			Reason string `json:"reason"`
+		}
+		if err := json.Unmarshal([]byte(answer[start:end]), &parsed); err == nil {
+			return &IntentResult{Intent: parsed.Intent, Reason: parsed.Reason}, nil
+		}
+	}
+
+	// Default to product if parsing fails
+	return &IntentResult{Intent: config.IntentProduct}, nil
+}
+
+// classifyIntentByEmbedding gives the question the intent of its most
+// similar example question, built-in or custom, when that is at least
+// intent.threshold similar, and the product intent otherwise.
+func (qe *QueryEngine) classifyIntentByEmbedding(ctx context.Context, question string, es embedding.EmbeddingService, cfg *config.Config) (*IntentResult, error) {
+	examples := append([]intentExample(nil), builtinIntentExamples...)
+	for _, ci := range cfg.Intent.Custom {
+		for _, e := range ci.Examples {
+			examples = append(examples, intentExample{ci.Name, e})
+		}
+	}
+	qv, err := qe.cachedEmbed(ctx, question, es)
+	if err != nil {
+		return nil, err
+	}
+	texts := make([]string, len(examples))
+	for i, e := range examples {
+		texts[i] = e.text
+	}
+	vectors, err := qe.intentVectors.get(ctx, texts, es)
+	if err != nil {
+		return nil, err
+	}
+	result := &IntentResult{Intent: config.IntentProduct}
+	best := cfg.Intent.Threshold
+	for i, v := range vectors {
+		if score := vectorstore.CosineSimilarity(qv, v); score >= best {
+			result.Intent, result.Similarity, best = examples[i].intent, score, score
+		}
+	}
+	return result, nil
+}
+
+// intentVectorCache holds the embeddings of example questions, of the
+// intents or of small talk. It is reset when the embedding service changes.
+type intentVectorCache struct {
+	mu      sync.Mutex
+	vectors map[string][]float64
+}
+
+// get returns the embeddings of texts, embedding those not cached yet.
+// Texts no longer asked for are dropped from the cache meanwhile.
+func (c *intentVectorCache) get(ctx context.Context, texts []string, es embedding.EmbeddingService) ([][]float64, error) {
+	c.mu.Lock()
+	cached := c.vectors
+	c.mu.Unlock()
+
+	var missing []string
+	seen := make(map[string]bool)
+	for _, t := range texts {
+		if _, ok := cached[t]; !ok && !seen[t] {
+			seen[t] = true
+			missing = append(missing, t)
+		}
+	}
+	if len(missing) > 0 {
+		embedded, err := es.EmbedBatch(ctx, missing)
+		if err != nil {
+			return nil, fmt.Errorf("embed examples: %w", err)
+		}
+		vectors := make(map[string][]float64, len(texts))
+		for _, t := range texts {
+			if v, ok := cached[t]; ok {
+				vectors[t] = v
+			}
+		}
+		for i, text := range missing {
+			vectors[text] = embedded[i]
+		}
+		cached = vectors
+		c.mu.Lock()
+		c.vectors = vectors
+		c.mu.Unlock()
+	}
+
+	out := make([][]float64, len(texts))
+	for i, t := range texts {
+		out[i] = cached[t]
+	}
+	return out, nil
+}
+
+// reset drops the cached embeddings.
+func (c *intentVectorCache) reset() {
+	c.mu.Lock()
+	c.vectors = nil
+	c.mu.Unlock()
+}
+
+// SetIntentHook registers a callback invoked when a question is classified
+// as a custom intent that is routed to webhooks. Dry runs do not invoke it.
+func (qe *QueryEngine) SetIntentHook(fn func(intent, question, userID, productID string)) {
+	qe.mu.Lock()
+	defer qe.mu.Unlock()
+	qe.onIntent = fn
+}
+
+// answerCustomIntent answers a question classified as the custom intent ci
+// with its canned answer, and hands it to the intent hook when ci is routed
+// to webhooks.
+func (qe *QueryEngine) answerCustomIntent(req QueryRequest, ci *config.CustomIntent, dbg *DebugInfo) *QueryResponse {
+	if ci.Webhook && req.trace == nil {
+		qe.mu.RLock()
+		onIntent := qe.onIntent
+		qe.mu.RUnlock()
+		if onIntent != nil {
+			onIntent(ci.Name, req.Question, req.UserID, req.ProductID)
+		}
+	}
+	return &QueryResponse{Answer: Localize(ci.Answer, req.Question), DebugInfo: dbg}
+}
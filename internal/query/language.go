@@ -0,0 +1,77 @@
+package query
+
+import (
+	"fmt"
+
+	"askflow/internal/i18n"
+)
+
+// languageNames are the names answer prompts use for the languages
+// i18n.Detect tells apart.
+var languageNames = map[string]string{
+	"zh": "中文",
+	"en": "英文（English）",
+	"ja": "日文（日本語）",
+	"ko": "韩文（한국어）",
+	"ru": "俄文（Русский）",
+	"ar": "阿拉伯文（العربية）",
+	"th": "泰文（ไทย）",
+	"fr": "法文（Français）",
+	"de": "德文（Deutsch）",
+	"es": "西班牙文（Español）",
+	"pt": "葡萄牙文（Português）",
+	"it": "意大利文（Italiano）",
+}
+
+// answerLanguageRule returns the system prompt rule that sets the language of
+// the answer. A detected question language is named explicitly, which models
+// follow more reliably than "the language of the question" when the
+// references are in another language, so answers never need a second
+// translation call.
+func answerLanguageRule(question string) string {
+	name, ok := languageNames[i18n.Detect(question)]
+	if !ok {
+		return "\n\n重要规则：你必须使用与用户提问相同的语言来回答。如果用户用英文提问，你必须用英文回答；如果用户用中文提问，你必须用中文回答；其他语言同理。无论参考资料是什么语言，都要翻译成用户提问的语言来回答。"
+	}
+	return fmt.Sprintf("\n\n重要规则：用户使用%[1]s提问，你必须全程使用%[1]s回答，包括步骤、列表和图片说明。"+
+		"参考资料是其他语言时，请理解后直接用%[1]s作答；界面菜单、按钮、命令和报错信息等原文可保留并在括号中说明。不要附带其他语言的译文。", name)
+}
+
+// answerPrompt builds the system prompt of the answer generation call.
+// hasImages is set when document images will be shown below the answer,
+// withImage when the user attached an image to the question.
+func answerPrompt(question string, hasImages, withImage bool) string {
+	const listRule = "\n\n格式规则：使用有序列表时，请使用递增的序号（1. 2. 3.），不要所有条目都用1.开头。"
+	if withImage && !hasImages {
+		return "你是一个专业的软件技术支持助手。用户上传了一张图片并提出了问题。" +
+			"请结合图片内容和提供的参考资料来回答用户的问题。" +
+			"如果参考资料中没有相关信息，请根据图片内容尽可能回答。回答应简洁、准确、有条理。" +
+			answerLanguageRule(question) + listRule
+	}
+	prompt := "你是一个专业的软件技术支持助手。请根据提供的参考资料回答用户的问题。" +
+		"如果参考资料中没有相关信息，请如实告知用户。回答应简洁、准确、有条理。" +
+		answerLanguageRule(question) + listRule
+	if hasImages {
+		prompt += "\n\n关于图片：参考资料中标记为[图片已附带]的内容，对应的图片会自动展示在你的回答下方。请在回答中自然地引导用户查看图片（例如：如下图所示、请参考下方图片），不要说无法提供图片或无法展示图片。"
+	}
+	return prompt
+}
+
+// Localize returns a canned reply in the language of question. The reply is
+// returned as is when it is already in that language and otherwise taken
+// from the i18n catalog; languages without a catalog get the English entry,
+// which more askers read than the Chinese source. Replies written by admins,
+// such as the product intro, are translated by adding catalog entries.
+func Localize(msg, question string) string {
+	lang := i18n.Detect(question)
+	if lang == "" || i18n.Detect(msg) == lang {
+		return msg
+	}
+	if out, ok := i18n.Lookup(lang, msg); ok {
+		return out
+	}
+	if out, ok := i18n.Lookup("en", msg); ok && lang != i18n.Default {
+		return out
+	}
+	return msg
+}
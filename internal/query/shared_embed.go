@@ -0,0 +1,61 @@
+package query
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"time"
+
+	"askflow/internal/embedding"
+	"askflow/internal/sharedcache"
+)
+
+// sharedEmbedTTL is how long a question embedding stays in the shared
+// cache, the same as in the in-memory cache.
+const sharedEmbedTTL = 10 * time.Minute
+
+// SetSharedCache makes the engine cache question embeddings in c, shared
+// with the other instances using it, instead of in memory. Entries are
+// keyed by the embedding model as well as the text, since the cache
+// outlives model changes made on other instances.
+func (qe *QueryEngine) SetSharedCache(c *sharedcache.Cache) {
+	qe.mu.Lock()
+	defer qe.mu.Unlock()
+	qe.sharedEmbeds = c
+}
+
+// sharedEmbedCache returns the shared embedding cache, if any, and the
+// current embedding model.
+func (qe *QueryEngine) sharedEmbedCache() (*sharedcache.Cache, string) {
+	qe.mu.RLock()
+	defer qe.mu.RUnlock()
+	if qe.sharedEmbeds == nil || qe.config == nil {
+		return nil, ""
+	}
+	return qe.sharedEmbeds, qe.config.Embedding.Endpoint + "\x00" + qe.config.Embedding.ModelName
+}
+
+// sharedEmbed is cachedEmbed on the shared cache.
+func (qe *QueryEngine) sharedEmbed(ctx context.Context, c *sharedcache.Cache, model, text string, es embedding.EmbeddingService) ([]float64, error) {
+	sum := sha256.Sum256([]byte(model + "\x00" + text))
+	key := "embedding:" + hex.EncodeToString(sum[:])
+	if data, ok := c.Get(key); ok && len(data) > 0 && len(data)%8 == 0 {
+		vec := make([]float64, len(data)/8)
+		for i := range vec {
+			vec[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[i*8:]))
+		}
+		return vec, nil
+	}
+	vec, err := es.Embed(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, len(vec)*8)
+	for _, v := range vec {
+		data = binary.LittleEndian.AppendUint64(data, math.Float64bits(v))
+	}
+	c.Set(key, data, sharedEmbedTTL)
+	return vec, nil
+}
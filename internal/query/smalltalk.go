@@ -0,0 +1,222 @@
+package query
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"askflow/internal/config"
+	"askflow/internal/embedding"
+	"askflow/internal/i18n"
+	"askflow/internal/vectorstore"
+)
+
+// Small talk — greetings, thanks, goodbyes and acknowledgements — is
+// answered from a built-in catalog in the language it is written in,
+// without an LLM call. A message is small talk when, ignoring case,
+// punctuation and emoji, it is one of the phrases below, or when it is
+// short and its embedding is at least intent.threshold similar to one of
+// them.
+
+// Kinds of small talk.
+const (
+	smallTalkGreeting = "greeting"
+	smallTalkThanks   = "thanks"
+	smallTalkGoodbye  = "goodbye"
+	smallTalkAck      = "ack"
+)
+
+// smallTalkMaxRunes bounds the messages compared with the phrases by
+// embedding; longer ones carry a question even when they start with a
+// greeting.
+const smallTalkMaxRunes = 20
+
+// smallTalkPhrases lists the phrases of each kind of small talk by
+// language. A phrase listed for several languages counts for the first.
+var smallTalkPhrases = []struct {
+	kind, lang string
+	phrases    []string
+}{
+	{smallTalkGreeting, "zh", []string{"你好", "您好", "你好呀", "你好啊", "哈喽", "嗨", "在吗", "在不在", "早上好", "上午好", "中午好", "下午好", "晚上好", "大家好"}},
+	{smallTalkGreeting, "en", []string{"hi", "hello", "hey", "hi there", "hello there", "hey there", "good morning", "good afternoon", "good evening", "howdy", "greetings"}},
+	{smallTalkGreeting, "ja", []string{"こんにちは", "こんばんは", "おはよう", "おはようございます"}},
+	{smallTalkGreeting, "ko", []string{"안녕", "안녕하세요"}},
+	{smallTalkGreeting, "fr", []string{"bonjour", "bonsoir", "salut", "coucou"}},
+	{smallTalkGreeting, "de", []string{"hallo", "guten tag", "guten morgen", "guten abend", "servus", "moin"}},
+	{smallTalkGreeting, "es", []string{"hola", "buenos días", "buenas tardes", "buenas noches", "buenas"}},
+	{smallTalkGreeting, "pt", []string{"olá", "oi", "bom dia", "boa tarde", "boa noite"}},
+	{smallTalkGreeting, "it", []string{"ciao", "salve", "buongiorno", "buonasera"}},
+	{smallTalkGreeting, "ru", []string{"привет", "здравствуйте", "добрый день", "доброе утро", "добрый вечер"}},
+
+	{smallTalkThanks, "zh", []string{"谢谢", "谢谢你", "谢谢您", "多谢", "感谢", "非常感谢", "谢啦", "谢了"}},
+	{smallTalkThanks, "en", []string{"thanks", "thank you", "thanks a lot", "thank you very much", "thanks so much", "thank you so much", "many thanks", "thx", "ty"}},
+	{smallTalkThanks, "ja", []string{"ありがとう", "ありがとうございます", "どうもありがとう"}},
+	{smallTalkThanks, "ko", []string{"감사합니다", "고맙습니다", "고마워요", "고마워"}},
+	{smallTalkThanks, "fr", []string{"merci", "merci beaucoup"}},
+	{smallTalkThanks, "de", []string{"danke", "danke schön", "dankeschön", "vielen dank"}},
+	{smallTalkThanks, "es", []string{"gracias", "muchas gracias"}},
+	{smallTalkThanks, "pt", []string{"obrigado", "obrigada", "muito obrigado", "muito obrigada"}},
+	{smallTalkThanks, "it", []string{"grazie", "grazie mille"}},
+	{smallTalkThanks, "ru", []string{"спасибо", "большое спасибо"}},
+
+	{smallTalkGoodbye, "zh", []string{"再见", "拜拜", "回头见", "下次见"}},
+	{smallTalkGoodbye, "en", []string{"bye", "goodbye", "bye bye", "see you", "see you later", "good night"}},
+	{smallTalkGoodbye, "ja", []string{"さようなら", "じゃあね", "またね"}},
+	{smallTalkGoodbye, "ko", []string{"안녕히 계세요", "안녕히 가세요"}},
+	{smallTalkGoodbye, "fr", []string{"au revoir", "à bientôt"}},
+	{smallTalkGoodbye, "de", []string{"tschüss", "auf wiedersehen", "bis bald"}},
+	{smallTalkGoodbye, "es", []string{"adiós", "hasta luego", "chao"}},
+	{smallTalkGoodbye, "pt", []string{"tchau", "até logo", "até mais"}},
+	{smallTalkGoodbye, "it", []string{"arrivederci", "a presto"}},
+	{smallTalkGoodbye, "ru", []string{"пока", "до свидания"}},
+
+	{smallTalkAck, "zh", []string{"好的", "好", "嗯", "嗯嗯", "知道了", "明白了", "收到", "行"}},
+	{smallTalkAck, "en", []string{"ok", "okay", "got it", "i see", "alright", "cool", "great", "nice", "sure"}},
+	{smallTalkAck, "ja", []string{"わかりました", "了解しました", "はい"}},
+	{smallTalkAck, "ko", []string{"네", "알겠습니다"}},
+	{smallTalkAck, "fr", []string{"d'accord", "compris"}},
+	{smallTalkAck, "de", []string{"alles klar", "verstanden"}},
+	{smallTalkAck, "es", []string{"vale", "de acuerdo", "entendido"}},
+	{smallTalkAck, "pt", []string{"entendi", "certo"}},
+	{smallTalkAck, "it", []string{"va bene", "capito"}},
+	{smallTalkAck, "ru", []string{"хорошо", "понятно", "ясно"}},
+}
+
+// smallTalkReplies are the answers to each kind of small talk by language.
+var smallTalkReplies = map[string]map[string]string{
+	smallTalkGreeting: {
+		"zh": "您好！请问有什么可以帮您？",
+		"en": "Hello! How can I help you?",
+		"ja": "こんにちは！何かお手伝いできることはありますか？",
+		"ko": "안녕하세요! 무엇을 도와드릴까요?",
+		"fr": "Bonjour ! Comment puis-je vous aider ?",
+		"de": "Hallo! Wie kann ich Ihnen helfen?",
+		"es": "¡Hola! ¿En qué puedo ayudarle?",
+		"pt": "Olá! Como posso ajudar?",
+		"it": "Ciao! Come posso aiutarti?",
+		"ru": "Здравствуйте! Чем могу помочь?",
+	},
+	smallTalkThanks: {
+		"zh": "不客气！还有其他问题随时问我。",
+		"en": "You're welcome! Feel free to ask if you have any other questions.",
+		"ja": "どういたしまして！他にご質問があればお気軽にどうぞ。",
+		"ko": "천만에요! 다른 질문이 있으면 언제든지 물어보세요.",
+		"fr": "Je vous en prie ! N'hésitez pas si vous avez d'autres questions.",
+		"de": "Gern geschehen! Fragen Sie gerne, wenn Sie noch etwas wissen möchten.",
+		"es": "¡De nada! No dude en preguntar si tiene otras dudas.",
+		"pt": "De nada! Fique à vontade para fazer outras perguntas.",
+		"it": "Prego! Chiedi pure se hai altre domande.",
+		"ru": "Пожалуйста! Если появятся другие вопросы, обращайтесь.",
+	},
+	smallTalkGoodbye: {
+		"zh": "再见！祝您使用愉快。",
+		"en": "Goodbye! Have a great day.",
+		"ja": "さようなら！良い一日を。",
+		"ko": "안녕히 가세요! 좋은 하루 보내세요.",
+		"fr": "Au revoir ! Bonne journée.",
+		"de": "Auf Wiedersehen! Einen schönen Tag noch.",
+		"es": "¡Adiós! Que tenga un buen día.",
+		"pt": "Até logo! Tenha um ótimo dia.",
+		"it": "Arrivederci! Buona giornata.",
+		"ru": "До свидания! Хорошего дня.",
+	},
+	smallTalkAck: {
+		"zh": "好的！还有其他问题随时问我。",
+		"en": "Great! Feel free to ask if you have any other questions.",
+		"ja": "承知しました！他にご質問があればお気軽にどうぞ。",
+		"ko": "알겠습니다! 다른 질문이 있으면 언제든지 물어보세요.",
+		"fr": "Très bien ! N'hésitez pas si vous avez d'autres questions.",
+		"de": "Alles klar! Fragen Sie gerne, wenn Sie noch etwas wissen möchten.",
+		"es": "¡Perfecto! No dude en preguntar si tiene otras dudas.",
+		"pt": "Certo! Fique à vontade para fazer outras perguntas.",
+		"it": "Perfetto! Chiedi pure se hai altre domande.",
+		"ru": "Хорошо! Если появятся другие вопросы, обращайтесь.",
+	},
+}
+
+// smallTalkMatch is the kind and language of a small talk message.
+type smallTalkMatch struct {
+	kind, lang string
+}
+
+var (
+	smallTalkOnce    sync.Once
+	smallTalkIndex   map[string]smallTalkMatch // normalized phrase -> match
+	smallTalkSeeds   []string                  // phrases, for the embedding comparison
+	smallTalkMatches []smallTalkMatch          // match of each seed
+)
+
+// loadSmallTalk indexes smallTalkPhrases.
+func loadSmallTalk() {
+	smallTalkIndex = make(map[string]smallTalkMatch)
+	for _, p := range smallTalkPhrases {
+		for _, phrase := range p.phrases {
+			norm := normalizeSmallTalk(phrase)
+			if _, ok := smallTalkIndex[norm]; ok {
+				continue
+			}
+			m := smallTalkMatch{p.kind, p.lang}
+			smallTalkIndex[norm] = m
+			smallTalkSeeds = append(smallTalkSeeds, phrase)
+			smallTalkMatches = append(smallTalkMatches, m)
+		}
+	}
+}
+
+// normalizeSmallTalk lowercases s and reduces punctuation, symbols such as
+// emoji and runs of spaces to single spaces.
+func normalizeSmallTalk(s string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.Is(unicode.Mn, r)
+	}), " ")
+}
+
+// matchSmallTalk reports whether question is small talk, and how similar
+// it is to the nearest phrase (1 for a listed phrase). Embedding errors
+// are logged and treated as no match.
+func (qe *QueryEngine) matchSmallTalk(ctx context.Context, question string, es embedding.EmbeddingService, cfg *config.Config) (*smallTalkMatch, float64) {
+	smallTalkOnce.Do(loadSmallTalk)
+	norm := normalizeSmallTalk(question)
+	if norm == "" {
+		return nil, 0
+	}
+	if m, ok := smallTalkIndex[norm]; ok {
+		return &m, 1
+	}
+	if utf8.RuneCountInString(norm) > smallTalkMaxRunes {
+		return nil, 0
+	}
+	qv, err := qe.cachedEmbed(ctx, question, es)
+	if err != nil {
+		log.Printf("[Query] small talk check failed to embed question: %v", err)
+		return nil, 0
+	}
+	vectors, err := qe.smallTalkVectors.get(ctx, smallTalkSeeds, es)
+	if err != nil {
+		log.Printf("[Query] small talk check failed: %v", err)
+		return nil, 0
+	}
+	var best *smallTalkMatch
+	bestScore := cfg.Intent.Threshold
+	for i, v := range vectors {
+		if score := vectorstore.CosineSimilarity(qv, v); score >= bestScore {
+			best, bestScore = &smallTalkMatches[i], score
+		}
+	}
+	if best == nil {
+		return nil, 0
+	}
+	return best, bestScore
+}
+
+// smallTalkReply returns the answer to small talk m. Greetings get the
+// product introduction when it is written in the greeting's language.
+func smallTalkReply(m *smallTalkMatch, cfg *config.Config) string {
+	if m.kind == smallTalkGreeting && cfg.ProductIntro != "" && i18n.Detect(cfg.ProductIntro) == m.lang {
+		return cfg.ProductIntro
+	}
+	return smallTalkReplies[m.kind][m.lang]
+}
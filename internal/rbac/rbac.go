@@ -0,0 +1,405 @@
+// Package rbac provides role-based access control for admin sub-accounts.
+// A role is a named set of permissions. Each sub-admin has a global role
+// (admin_users.role) that applies to their assigned products, and may hold
+// additional per-product role grants.
+package rbac
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Permissions that can be attached to a role.
+const (
+	PermManageDocs    = "manage_docs"
+	PermAnswerPending = "answer_pending"
+	PermManageConfig  = "manage_config"
+	PermViewAnalytics = "view_analytics"
+)
+
+// AllPermissions lists every permission a role may carry.
+var AllPermissions = []string{PermManageDocs, PermAnswerPending, PermManageConfig, PermViewAnalytics}
+
+// Built-in role identifiers.
+const (
+	// RoleSuperAdmin implicitly holds every permission and is not stored in admin_roles.
+	RoleSuperAdmin = "super_admin"
+	// RoleEditor is the seeded default role for sub-admins.
+	RoleEditor = "editor"
+)
+
+// Role is a named set of permissions.
+type Role struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions"`
+	BuiltIn     bool      `json:"builtin"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Grant gives an admin a role on a single product.
+type Grant struct {
+	ProductID   string `json:"product_id"`
+	ProductName string `json:"product_name,omitempty"`
+	RoleID      string `json:"role_id"`
+	RoleName    string `json:"role_name,omitempty"`
+}
+
+// Service manages roles and grants and answers permission checks.
+type Service struct {
+	readDB  *sql.DB
+	writeDB *sql.DB
+}
+
+// NewService creates a new RBAC Service with separate read and write database connections.
+func NewService(readDB, writeDB *sql.DB) *Service {
+	return &Service{readDB: readDB, writeDB: writeDB}
+}
+
+// ListRoles returns all stored roles, built-in roles first.
+func (s *Service) ListRoles() ([]Role, error) {
+	rows, err := s.readDB.Query(`SELECT id, name, COALESCE(description, ''), permissions, builtin, created_at FROM admin_roles ORDER BY builtin DESC, created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query roles: %w", err)
+	}
+	defer rows.Close()
+
+	var roles []Role
+	for rows.Next() {
+		var r Role
+		var perms string
+		var builtin int
+		if err := rows.Scan(&r.ID, &r.Name, &r.Description, &perms, &builtin, &r.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan role: %w", err)
+		}
+		r.Permissions = splitPermissions(perms)
+		r.BuiltIn = builtin == 1
+		roles = append(roles, r)
+	}
+	return roles, rows.Err()
+}
+
+// RoleExists reports whether id names super_admin or a stored role.
+func (s *Service) RoleExists(id string) bool {
+	if id == RoleSuperAdmin {
+		return true
+	}
+	var n int
+	err := s.readDB.QueryRow(`SELECT 1 FROM admin_roles WHERE id = ?`, id).Scan(&n)
+	return err == nil
+}
+
+// CreateRole adds a custom role.
+func (s *Service) CreateRole(name, description string, permissions []string) (*Role, error) {
+	name = strings.TrimSpace(name)
+	if err := validateRole(name, description); err != nil {
+		return nil, err
+	}
+	perms, err := normalizePermissions(permissions)
+	if err != nil {
+		return nil, err
+	}
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	_, err = s.writeDB.Exec(
+		`INSERT INTO admin_roles (id, name, description, permissions, builtin, created_at) VALUES (?, ?, ?, ?, 0, ?)`,
+		id, name, description, strings.Join(perms, ","), now,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return nil, fmt.Errorf("role name already exists")
+		}
+		return nil, fmt.Errorf("failed to create role: %w", err)
+	}
+	return &Role{ID: id, Name: name, Description: description, Permissions: perms, CreatedAt: now}, nil
+}
+
+// UpdateRole changes a role's name, description and permissions.
+// Built-in roles may be edited but not deleted.
+func (s *Service) UpdateRole(id, name, description string, permissions []string) error {
+	name = strings.TrimSpace(name)
+	if err := validateRole(name, description); err != nil {
+		return err
+	}
+	perms, err := normalizePermissions(permissions)
+	if err != nil {
+		return err
+	}
+	result, err := s.writeDB.Exec(
+		`UPDATE admin_roles SET name = ?, description = ?, permissions = ? WHERE id = ?`,
+		name, description, strings.Join(perms, ","), id,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return fmt.Errorf("role name already exists")
+		}
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("role not found")
+	}
+	return nil
+}
+
+// DeleteRole removes a custom role. Built-in roles and roles still assigned
+// to an admin or used in a grant cannot be deleted.
+func (s *Service) DeleteRole(id string) error {
+	var builtin int
+	err := s.readDB.QueryRow(`SELECT builtin FROM admin_roles WHERE id = ?`, id).Scan(&builtin)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("role not found")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query role: %w", err)
+	}
+	if builtin == 1 {
+		return fmt.Errorf("built-in roles cannot be deleted")
+	}
+	var inUse int
+	err = s.readDB.QueryRow(
+		`SELECT (SELECT COUNT(*) FROM admin_users WHERE role = ?) + (SELECT COUNT(*) FROM admin_role_grants WHERE role_id = ?)`,
+		id, id,
+	).Scan(&inUse)
+	if err != nil {
+		return fmt.Errorf("failed to check role usage: %w", err)
+	}
+	if inUse > 0 {
+		return fmt.Errorf("role is still assigned to admin users")
+	}
+	if _, err := s.writeDB.Exec(`DELETE FROM admin_roles WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete role: %w", err)
+	}
+	return nil
+}
+
+// ListGrants returns the per-product role grants of an admin user.
+func (s *Service) ListGrants(adminUserID string) ([]Grant, error) {
+	rows, err := s.readDB.Query(
+		`SELECT g.product_id, COALESCE(p.name, ''), g.role_id, COALESCE(r.name, '')
+		 FROM admin_role_grants g
+		 LEFT JOIN products p ON p.id = g.product_id
+		 LEFT JOIN admin_roles r ON r.id = g.role_id
+		 WHERE g.admin_user_id = ? ORDER BY p.name`, adminUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query grants: %w", err)
+	}
+	defer rows.Close()
+
+	var grants []Grant
+	for rows.Next() {
+		var g Grant
+		if err := rows.Scan(&g.ProductID, &g.ProductName, &g.RoleID, &g.RoleName); err != nil {
+			return nil, fmt.Errorf("failed to scan grant: %w", err)
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+// SetGrants replaces all per-product role grants of an admin user.
+func (s *Service) SetGrants(adminUserID string, grants []Grant) error {
+	seen := make(map[string]bool)
+	for _, g := range grants {
+		if g.ProductID == "" || g.RoleID == "" {
+			return fmt.Errorf("product_id and role_id are required")
+		}
+		if g.RoleID == RoleSuperAdmin {
+			return fmt.Errorf("super_admin cannot be granted per product")
+		}
+		if seen[g.ProductID] {
+			return fmt.Errorf("duplicate grant for product %s", g.ProductID)
+		}
+		seen[g.ProductID] = true
+	}
+
+	tx, err := s.writeDB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM admin_role_grants WHERE admin_user_id = ?`, adminUserID); err != nil {
+		return fmt.Errorf("failed to delete existing grants: %w", err)
+	}
+	for _, g := range grants {
+		if _, err := tx.Exec(
+			`INSERT INTO admin_role_grants (admin_user_id, product_id, role_id) VALUES (?, ?, ?)`,
+			adminUserID, g.ProductID, g.RoleID,
+		); err != nil {
+			return fmt.Errorf("failed to grant role %s on product %s: %w", g.RoleID, g.ProductID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Permissions returns the effective permissions of a sub-admin on productID.
+// The global role applies when productID is empty, when the admin has no
+// product assignments, or when productID is one of the assigned products.
+// A per-product grant on productID adds that role's permissions.
+func (s *Service) Permissions(adminUserID, globalRole, productID string) []string {
+	if globalRole == RoleSuperAdmin {
+		return append([]string(nil), AllPermissions...)
+	}
+	set := make(map[string]bool)
+	if productID == "" || s.globalRoleCovers(adminUserID, productID) {
+		for _, p := range s.rolePermissions(globalRole) {
+			set[p] = true
+		}
+	}
+	if productID != "" {
+		var roleID string
+		err := s.readDB.QueryRow(
+			`SELECT role_id FROM admin_role_grants WHERE admin_user_id = ? AND product_id = ?`,
+			adminUserID, productID,
+		).Scan(&roleID)
+		if err == nil {
+			for _, p := range s.rolePermissions(roleID) {
+				set[p] = true
+			}
+		}
+	}
+	var perms []string
+	for _, p := range AllPermissions {
+		if set[p] {
+			perms = append(perms, p)
+		}
+	}
+	return perms
+}
+
+// HasPermission reports whether the admin holds perm on productID.
+func (s *Service) HasPermission(adminUserID, globalRole, perm, productID string) bool {
+	if globalRole == RoleSuperAdmin {
+		return true
+	}
+	for _, p := range s.Permissions(adminUserID, globalRole, productID) {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermissionAnywhere reports whether the admin holds perm globally or on
+// at least one product. Used to gate endpoints before the target product is known.
+func (s *Service) HasPermissionAnywhere(adminUserID, globalRole, perm string) bool {
+	if s.HasPermission(adminUserID, globalRole, perm, "") {
+		return true
+	}
+	rows, err := s.readDB.Query(
+		`SELECT r.permissions FROM admin_role_grants g JOIN admin_roles r ON r.id = g.role_id WHERE g.admin_user_id = ?`,
+		adminUserID,
+	)
+	if err != nil {
+		return false
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var perms string
+		if err := rows.Scan(&perms); err != nil {
+			return false
+		}
+		for _, p := range splitPermissions(perms) {
+			if p == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// globalRoleCovers reports whether the admin's global role applies to productID:
+// true when the admin has no product assignments or productID is assigned.
+func (s *Service) globalRoleCovers(adminUserID, productID string) bool {
+	var total, matched int
+	err := s.readDB.QueryRow(
+		`SELECT COUNT(*), COALESCE(SUM(CASE WHEN product_id = ? THEN 1 ELSE 0 END), 0) FROM admin_user_products WHERE admin_user_id = ?`,
+		productID, adminUserID,
+	).Scan(&total, &matched)
+	if err != nil {
+		return false
+	}
+	return total == 0 || matched > 0
+}
+
+// rolePermissions returns the permissions stored for roleID.
+func (s *Service) rolePermissions(roleID string) []string {
+	if roleID == "" {
+		return nil
+	}
+	var perms string
+	if err := s.readDB.QueryRow(`SELECT permissions FROM admin_roles WHERE id = ?`, roleID).Scan(&perms); err != nil {
+		return nil
+	}
+	return splitPermissions(perms)
+}
+
+// validateRole checks role name and description lengths.
+func validateRole(name, description string) error {
+	if name == "" {
+		return fmt.Errorf("role name cannot be empty")
+	}
+	if len(name) > 64 {
+		return fmt.Errorf("role name too long (max 64 characters)")
+	}
+	if len(description) > 500 {
+		return fmt.Errorf("description too long (max 500 characters)")
+	}
+	return nil
+}
+
+// normalizePermissions validates permission names and removes duplicates.
+func normalizePermissions(permissions []string) ([]string, error) {
+	seen := make(map[string]bool)
+	for _, p := range permissions {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		valid := false
+		for _, known := range AllPermissions {
+			if p == known {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown permission: %s", p)
+		}
+		seen[p] = true
+	}
+	out := []string{}
+	for _, p := range AllPermissions {
+		if seen[p] {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+func splitPermissions(s string) []string {
+	out := []string{}
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// generateID creates a random hex string for use as a unique identifier.
+func generateID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,335 @@
+// Package redis is a minimal Redis client speaking RESP2 over a small
+// connection pool. It covers what the shared caches need: GET, SET with an
+// expiry, MGET, DEL, INCRBY and PEXPIRE, with AUTH and SELECT from the URL and TLS
+// for rediss:// URLs.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timeouts of connecting and of a single command.
+const (
+	dialTimeout    = 3 * time.Second
+	commandTimeout = 2 * time.Second
+	maxIdle        = 16
+)
+
+// Error is an error reply from the server.
+type Error string
+
+func (e Error) Error() string { return "redis: " + string(e) }
+
+// errNil is the nil bulk reply of a missing key.
+var errNil = errors.New("redis: nil")
+
+// Client is a pool of connections to one Redis server. It is safe for
+// concurrent use.
+type Client struct {
+	addr     string
+	tls      *tls.Config
+	username string
+	password string
+	db       int
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+// conn is one connection with its buffered reader and writer.
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// New returns a client for rawURL, of the form
+// redis://[user:password@]host[:port][/db], or rediss:// for TLS. No
+// connection is made until the first command.
+func New(rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+	c := &Client{}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		c.tls = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	default:
+		return nil, fmt.Errorf("invalid redis URL: scheme must be redis or rediss")
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid redis URL: missing host")
+	}
+	port := u.Port()
+	if port == "" {
+		port = "6379"
+	}
+	c.addr = net.JoinHostPort(u.Hostname(), port)
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+		if c.password == "" {
+			// redis://secret@host is a password without a user name
+			c.username, c.password = "", c.username
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil || c.db < 0 {
+			return nil, fmt.Errorf("invalid redis URL: bad database number %q", db)
+		}
+	}
+	return c, nil
+}
+
+// Addr returns the host:port of the server.
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+// Close closes the idle connections; connections in use are closed when
+// they are returned.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for _, cn := range c.idle {
+		cn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+// Ping checks that the server answers.
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.do(ctx, "PING")
+	return err
+}
+
+// Get returns the value of key and whether it exists.
+func (c *Client) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	v, err := c.do(ctx, "GET", key)
+	if err == errNil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: unexpected reply to GET")
+	}
+	return b, true, nil
+}
+
+// Set stores value at key, expiring after ttl (never when ttl <= 0).
+func (c *Client) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", key, string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	}
+	_, err := c.do(ctx, args...)
+	return err
+}
+
+// MGet returns the values of keys, nil for the keys that do not exist.
+func (c *Client) MGet(ctx context.Context, keys ...string) ([][]byte, error) {
+	v, err := c.do(ctx, append([]string{"MGET"}, keys...)...)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := v.([]interface{})
+	if !ok || len(items) != len(keys) {
+		return nil, fmt.Errorf("redis: unexpected reply to MGET")
+	}
+	values := make([][]byte, len(keys))
+	for i, item := range items {
+		values[i], _ = item.([]byte)
+	}
+	return values, nil
+}
+
+// Del removes key.
+func (c *Client) Del(ctx context.Context, key string) error {
+	_, err := c.do(ctx, "DEL", key)
+	return err
+}
+
+// Incr adds delta to the counter at key and returns the new value. A
+// counter created by the call expires after ttl.
+func (c *Client) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	v, err := c.do(ctx, "INCRBY", key, strconv.FormatInt(delta, 10))
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected reply to INCRBY")
+	}
+	if n == delta && ttl > 0 {
+		if _, err := c.do(ctx, "PEXPIRE", key, strconv.FormatInt(max(ttl.Milliseconds(), 1), 10)); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// do runs one command and returns its reply: []byte, int64, string,
+// []interface{}, or errNil for a nil reply.
+func (c *Client) do(ctx context.Context, args ...string) (interface{}, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v, err := cn.roundTrip(ctx, args)
+	var replyErr Error
+	if err != nil && err != errNil && !errors.As(err, &replyErr) {
+		// The connection may hold half a reply
+		cn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return v, err
+}
+
+// get takes an idle connection or dials a new one.
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, errors.New("redis: client closed")
+	}
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+	return c.dial(ctx)
+}
+
+// put returns a healthy connection to the pool.
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || len(c.idle) >= maxIdle {
+		cn.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+// dial connects, authenticates and selects the database.
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	d := &net.Dialer{Timeout: dialTimeout}
+	var nc net.Conn
+	var err error
+	if c.tls != nil {
+		nc, err = (&tls.Dialer{NetDialer: d, Config: c.tls}).DialContext(ctx, "tcp", c.addr)
+	} else {
+		nc, err = d.DialContext(ctx, "tcp", c.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis: connect %s: %w", c.addr, err)
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	var setup [][]string
+	switch {
+	case c.username != "":
+		setup = append(setup, []string{"AUTH", c.username, c.password})
+	case c.password != "":
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	for _, args := range setup {
+		if _, err := cn.roundTrip(ctx, args); err != nil {
+			cn.Close()
+			return nil, fmt.Errorf("redis: %s: %w", args[0], err)
+		}
+	}
+	return cn, nil
+}
+
+// roundTrip writes a command and reads its reply.
+func (cn *conn) roundTrip(ctx context.Context, args []string) (interface{}, error) {
+	deadline := time.Now().Add(commandTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	cn.SetDeadline(deadline)
+	fmt.Fprintf(cn.w, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(cn.w, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if err := cn.w.Flush(); err != nil {
+		return nil, err
+	}
+	return cn.readReply()
+}
+
+// readReply reads one RESP2 reply.
+func (cn *conn) readReply() (interface{}, error) {
+	line, err := cn.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, errNil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(cn.r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad array length %q", line)
+		}
+		if n < 0 {
+			return nil, errNil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			v, err := cn.readReply()
+			if err != nil && err != errNil {
+				return nil, err
+			}
+			items[i] = v
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
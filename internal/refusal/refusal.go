@@ -0,0 +1,385 @@
+// Package refusal keeps the admin-curated "do not answer" list: topics such
+// as pricing negotiations or legal advice that the assistant must decline
+// instead of answering from the knowledge base. A rule matches a question
+// that contains one of its keywords, or whose embedding is at least
+// refusal.threshold similar to one of its example questions. Matched
+// questions get the rule's message, or the configured default message,
+// without searching documents or calling the LLM.
+package refusal
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"askflow/internal/config"
+	"askflow/internal/embedding"
+	"askflow/internal/llm"
+	"askflow/internal/vectorstore"
+)
+
+// ErrNotFound is returned for an unknown rule.
+var ErrNotFound = errors.New("not found")
+
+// Limits of a rule's contents.
+const (
+	maxRules        = 200
+	maxTerms        = 50
+	maxTermRunes    = 200
+	maxMessageRunes = 1000
+)
+
+// Rule is an entry of the do-not-answer list; ProductID "" applies to all
+// products.
+type Rule struct {
+	ID        string    `json:"id"`
+	ProductID string    `json:"product_id"`
+	Topic     string    `json:"topic"`
+	Keywords  []string  `json:"keywords"`
+	Examples  []string  `json:"examples"`
+	Message   string    `json:"message"` // "" uses refusal.message
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Match describes the rule a question matched.
+type Match struct {
+	RuleID     string  `json:"rule_id"`
+	Topic      string  `json:"topic"`
+	Message    string  `json:"message"`
+	Keyword    string  `json:"keyword,omitempty"`    // set for keyword matches
+	Similarity float64 `json:"similarity,omitempty"` // set for example matches
+}
+
+// rule is a cached Rule with its keywords lowercased and the embeddings of
+// its examples, computed on first use with the embedding service in es.
+type rule struct {
+	Rule
+	lowerKeywords []string
+	vectors       [][]float64
+	es            embedding.EmbeddingService
+}
+
+// Service stores the do-not-answer rules and matches questions against them.
+type Service struct {
+	readDB   *sql.DB
+	writeDB  *sql.DB
+	services func() (embedding.EmbeddingService, llm.LLMService)
+	cfg      func() config.RefusalConfig
+
+	// rules caches all rules; they are few and checked on every question.
+	mu    sync.Mutex
+	rules []*rule
+}
+
+// NewService creates a refusal Service. services returns the current
+// embedding service, used to embed example questions.
+func NewService(readDB, writeDB *sql.DB, services func() (embedding.EmbeddingService, llm.LLMService), cfg func() config.RefusalConfig) *Service {
+	return &Service{readDB: readDB, writeDB: writeDB, services: services, cfg: cfg}
+}
+
+// normalize trims and deduplicates terms and checks their count and length.
+func normalize(terms []string, what string) ([]string, error) {
+	seen := make(map[string]bool, len(terms))
+	out := make([]string, 0, len(terms))
+	for _, t := range terms {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[strings.ToLower(t)] {
+			continue
+		}
+		if utf8.RuneCountInString(t) > maxTermRunes {
+			return nil, fmt.Errorf("%s %q is too long (max %d characters)", what, t, maxTermRunes)
+		}
+		seen[strings.ToLower(t)] = true
+		out = append(out, t)
+	}
+	if len(out) > maxTerms {
+		return nil, fmt.Errorf("too many %ss (max %d)", what, maxTerms)
+	}
+	return out, nil
+}
+
+// validate normalises and checks a rule.
+func validate(r *Rule) error {
+	r.Topic = strings.TrimSpace(r.Topic)
+	if r.Topic == "" || utf8.RuneCountInString(r.Topic) > 100 {
+		return errors.New("topic is required (max 100 characters)")
+	}
+	var err error
+	if r.Keywords, err = normalize(r.Keywords, "keyword"); err != nil {
+		return err
+	}
+	if r.Examples, err = normalize(r.Examples, "example"); err != nil {
+		return err
+	}
+	if len(r.Keywords) == 0 && len(r.Examples) == 0 {
+		return errors.New("a rule needs at least one keyword or example question")
+	}
+	r.Message = strings.TrimSpace(r.Message)
+	if utf8.RuneCountInString(r.Message) > maxMessageRunes {
+		return fmt.Errorf("message is too long (max %d characters)", maxMessageRunes)
+	}
+	return nil
+}
+
+// load returns the cached rules, reading them from the database if needed.
+// s.mu must be held.
+func (s *Service) load() ([]*rule, error) {
+	if s.rules != nil {
+		return s.rules, nil
+	}
+	rows, err := s.readDB.Query(`SELECT id, product_id, topic, keywords, examples, message, enabled, created_at, updated_at
+		FROM refusal_rules ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := []*rule{}
+	for rows.Next() {
+		var r rule
+		var keywords, examples string
+		if err := rows.Scan(&r.ID, &r.ProductID, &r.Topic, &keywords, &examples, &r.Message, &r.Enabled, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(keywords), &r.Keywords); err != nil {
+			return nil, fmt.Errorf("invalid keywords of rule %s: %w", r.ID, err)
+		}
+		if err := json.Unmarshal([]byte(examples), &r.Examples); err != nil {
+			return nil, fmt.Errorf("invalid examples of rule %s: %w", r.ID, err)
+		}
+		r.lowerKeywords = make([]string, len(r.Keywords))
+		for i, k := range r.Keywords {
+			r.lowerKeywords[i] = strings.ToLower(k)
+		}
+		rules = append(rules, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	s.rules = rules
+	return rules, nil
+}
+
+// invalidate drops the cached rules after a change.
+func (s *Service) invalidate() {
+	s.mu.Lock()
+	s.rules = nil
+	s.mu.Unlock()
+}
+
+// List returns all rules in the order they were created.
+func (s *Service) List() ([]Rule, error) {
+	s.mu.Lock()
+	rules, err := s.load()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Rule, len(rules))
+	for i, r := range rules {
+		list[i] = r.Rule
+	}
+	return list, nil
+}
+
+// Create adds a rule.
+func (s *Service) Create(r Rule) (*Rule, error) {
+	if err := validate(&r); err != nil {
+		return nil, err
+	}
+	var n int
+	if err := s.readDB.QueryRow(`SELECT COUNT(*) FROM refusal_rules`).Scan(&n); err != nil {
+		return nil, fmt.Errorf("failed to count rules: %w", err)
+	}
+	if n >= maxRules {
+		return nil, fmt.Errorf("too many rules (max %d)", maxRules)
+	}
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	r.ID = id
+	r.CreatedAt = time.Now().UTC()
+	r.UpdatedAt = r.CreatedAt
+	keywords, _ := json.Marshal(r.Keywords)
+	examples, _ := json.Marshal(r.Examples)
+	if _, err := s.writeDB.Exec(
+		`INSERT INTO refusal_rules (id, product_id, topic, keywords, examples, message, enabled, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.ID, r.ProductID, r.Topic, string(keywords), string(examples), r.Message, r.Enabled, r.CreatedAt, r.UpdatedAt,
+	); err != nil {
+		return nil, fmt.Errorf("failed to save rule: %w", err)
+	}
+	s.invalidate()
+	return &r, nil
+}
+
+// Update replaces the rule r.ID; its product cannot change.
+func (s *Service) Update(r Rule) (*Rule, error) {
+	if err := validate(&r); err != nil {
+		return nil, err
+	}
+	old, err := s.Get(r.ID)
+	if err != nil {
+		return nil, err
+	}
+	r.ProductID = old.ProductID
+	r.CreatedAt = old.CreatedAt
+	r.UpdatedAt = time.Now().UTC()
+	keywords, _ := json.Marshal(r.Keywords)
+	examples, _ := json.Marshal(r.Examples)
+	if _, err := s.writeDB.Exec(
+		`UPDATE refusal_rules SET topic = ?, keywords = ?, examples = ?, message = ?, enabled = ?, updated_at = ? WHERE id = ?`,
+		r.Topic, string(keywords), string(examples), r.Message, r.Enabled, r.UpdatedAt, r.ID,
+	); err != nil {
+		return nil, fmt.Errorf("failed to save rule: %w", err)
+	}
+	s.invalidate()
+	return &r, nil
+}
+
+// Get returns a rule by ID.
+func (s *Service) Get(id string) (*Rule, error) {
+	s.mu.Lock()
+	rules, err := s.load()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rules {
+		if r.ID == id {
+			out := r.Rule
+			return &out, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Delete removes a rule.
+func (s *Service) Delete(id string) error {
+	res, err := s.writeDB.Exec(`DELETE FROM refusal_rules WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete rule: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	s.invalidate()
+	return nil
+}
+
+// Match returns the enabled rule for productID that question matches, or
+// nil. Keyword rules are checked first; questionVector, which returns the
+// question's embedding, is only called when a rule has example questions.
+// Errors loading rules or embedding are logged and treated as no match, so
+// the list never keeps questions from being answered.
+func (s *Service) Match(ctx context.Context, productID, question string, questionVector func() ([]float64, error)) *Match {
+	s.mu.Lock()
+	all, err := s.load()
+	s.mu.Unlock()
+	if err != nil {
+		log.Printf("[Refusal] failed to load rules: %v", err)
+		return nil
+	}
+	var rules []*rule
+	for _, r := range all {
+		if r.Enabled && (r.ProductID == "" || r.ProductID == productID) {
+			rules = append(rules, r)
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	cfg := s.cfg()
+	lower := strings.ToLower(question)
+	semantic := false
+	for _, r := range rules {
+		for i, k := range r.lowerKeywords {
+			if strings.Contains(lower, k) {
+				return newMatch(r, cfg, &Match{Keyword: r.Keywords[i]})
+			}
+		}
+		semantic = semantic || len(r.Examples) > 0
+	}
+	if !semantic {
+		return nil
+	}
+
+	qv, err := questionVector()
+	if err != nil {
+		log.Printf("[Refusal] failed to embed question: %v", err)
+		return nil
+	}
+	var best *rule
+	bestScore := cfg.Threshold
+	for _, r := range rules {
+		vectors, err := s.exampleVectors(ctx, r)
+		if err != nil {
+			log.Printf("[Refusal] failed to embed examples of rule %s: %v", r.ID, err)
+			continue
+		}
+		for _, v := range vectors {
+			if score := vectorstore.CosineSimilarity(qv, v); score >= bestScore {
+				best, bestScore = r, score
+			}
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return newMatch(best, cfg, &Match{Similarity: bestScore})
+}
+
+// newMatch fills in m for a question that matched r.
+func newMatch(r *rule, cfg config.RefusalConfig, m *Match) *Match {
+	m.RuleID = r.ID
+	m.Topic = r.Topic
+	m.Message = r.Message
+	if m.Message == "" {
+		m.Message = cfg.Message
+	}
+	return m
+}
+
+// exampleVectors returns the embeddings of r's examples, embedding them
+// again when the embedding service has changed since.
+func (s *Service) exampleVectors(ctx context.Context, r *rule) ([][]float64, error) {
+	if len(r.Examples) == 0 {
+		return nil, nil
+	}
+	es, _ := s.services()
+	s.mu.Lock()
+	vectors, cachedES := r.vectors, r.es
+	s.mu.Unlock()
+	if vectors != nil && cachedES == es {
+		return vectors, nil
+	}
+	vectors, err := es.EmbedBatch(ctx, r.Examples)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	r.vectors, r.es = vectors, es
+	s.mu.Unlock()
+	return vectors, nil
+}
+
+// generateID creates a random hex string for use as a unique identifier.
+func generateID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
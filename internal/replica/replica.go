@@ -0,0 +1,177 @@
+// Package replica runs an instance as a read-only replica of a primary
+// instance for scaling queries horizontally. A replica reads the primary's
+// SQLite database (a shared mount of its data directory, or snapshots
+// applied in place) without ever writing to it, answers /api/query itself
+// and proxies every other API request to the primary. The few writes a
+// query makes that must not be lost, new pending questions, usage counters
+// and answers kept for export, are sent to the primary's /api/replica/
+// endpoints, which authenticate replicas with the shared replica.token.
+package replica
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"askflow/internal/i18n"
+)
+
+// requestTimeout bounds each write sent to the primary.
+const requestTimeout = 15 * time.Second
+
+// PendingQuestion is a pending question created on a replica.
+type PendingQuestion struct {
+	Question  string `json:"question"`
+	UserID    string `json:"user_id"`
+	ImageData string `json:"image_data,omitempty"`
+	ProductID string `json:"product_id"`
+}
+
+// Usage is the usage of one query answered on a replica.
+type Usage struct {
+	UserID           string `json:"user_id"`
+	ProductID        string `json:"product_id"`
+	EmbeddingTokens  int64  `json:"embedding_tokens"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+}
+
+// Answer is an answer given on a replica, kept by the primary so the user
+// can export it. Sources is the JSON array of the answer's sources.
+type Answer struct {
+	QueryID   string          `json:"query_id"`
+	UserID    string          `json:"user_id"`
+	ProductID string          `json:"product_id"`
+	Question  string          `json:"question"`
+	Answer    string          `json:"answer"`
+	Sources   json.RawMessage `json:"sources"`
+}
+
+// Client talks to the primary instance on behalf of a replica.
+type Client struct {
+	primary *url.URL
+	token   func() string
+	http    *http.Client
+	proxy   *httputil.ReverseProxy
+}
+
+// NewClient returns a client for the primary at primaryURL (scheme, host
+// and the primary's base path, if any). token returns the shared secret
+// the primary's replica.token is set to.
+func NewClient(primaryURL string, token func() string) (*Client, error) {
+	u, err := url.Parse(strings.TrimRight(primaryURL, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid primary URL %q: must be an http or https URL", primaryURL)
+	}
+	c := &Client{primary: u, token: token, http: &http.Client{Timeout: requestTimeout}}
+	c.proxy = &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(u)
+			// Keep the host name the client asked for: tenants may be
+			// selected by subdomain
+			pr.Out.Host = pr.In.Host
+			// GetClientIP trusts X-Forwarded-For, so keep the client's chain
+			pr.Out.Header["X-Forwarded-For"] = pr.In.Header["X-Forwarded-For"]
+			pr.SetXForwarded()
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("[Replica] proxying %s to the primary failed: %v", r.URL.Path, err)
+			lang := i18n.Match(r.Header.Get("Accept-Language"))
+			if lang == "" {
+				lang = i18n.Default
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(map[string]string{"error": i18n.T(lang, "主实例暂时无法访问，请稍后重试")})
+		},
+	}
+	return c, nil
+}
+
+// Primary returns the URL of the primary instance.
+func (c *Client) Primary() string {
+	return c.primary.String()
+}
+
+// Handler serves the requests a replica answers itself with local and
+// proxies all others to the primary. Paths are seen after the base path is
+// stripped and may carry a /t/<slug> tenant prefix.
+func (c *Client) Handler(local http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if servedLocally(r.URL.Path) {
+			local.ServeHTTP(w, r)
+			return
+		}
+		// Uploads and streamed responses take as long as the primary
+		// allows, which enforces its own deadlines
+		rc := http.NewResponseController(w)
+		rc.SetReadDeadline(time.Time{})
+		rc.SetWriteDeadline(time.Time{})
+		c.proxy.ServeHTTP(w, r)
+	})
+}
+
+// servedLocally reports whether a replica answers requests for path: the
+// query endpoint, the health checks and the frontend's static files.
+func servedLocally(path string) bool {
+	if rest, ok := strings.CutPrefix(path, "/t/"); ok {
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			path = rest[i:]
+		} else {
+			path = "/"
+		}
+	}
+	switch path {
+	case "/api/query", "/api/health", "/healthz", "/readyz":
+		return true
+	}
+	return !strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, "/auth/")
+}
+
+// CreatePending creates a pending question on the primary, which notifies
+// admins and drafts an answer as for questions asked there.
+func (c *Client) CreatePending(ctx context.Context, q PendingQuestion) error {
+	return c.post(ctx, "/api/replica/pending", q)
+}
+
+// RecordUsage adds the usage of a query to the primary's usage counters.
+func (c *Client) RecordUsage(ctx context.Context, u Usage) error {
+	return c.post(ctx, "/api/replica/usage", u)
+}
+
+// RecordAnswer keeps an answer on the primary for export.
+func (c *Client) RecordAnswer(ctx context.Context, a Answer) error {
+	return c.post(ctx, "/api/replica/answers", a)
+}
+
+// post sends v as JSON to path on the primary.
+func (c *Client) post(ctx context.Context, path string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.primary.String()+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.token())
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("primary unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("primary returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,645 @@
+package router
+
+import (
+	"sync"
+
+	"askflow/internal/abuse"
+	"askflow/internal/audit"
+	"askflow/internal/auth"
+	"askflow/internal/backup"
+	"askflow/internal/captcha"
+	"askflow/internal/connector"
+	"askflow/internal/document"
+	"askflow/internal/embedding"
+	"askflow/internal/experiment"
+	"askflow/internal/faq"
+	"askflow/internal/gaps"
+	"askflow/internal/handler"
+	"askflow/internal/jobs"
+	"askflow/internal/lease"
+	"askflow/internal/maintenance"
+	"askflow/internal/moderation"
+	"askflow/internal/openapi"
+	"askflow/internal/pending"
+	"askflow/internal/product"
+	"askflow/internal/query"
+	"askflow/internal/rbac"
+	"askflow/internal/refusal"
+	"askflow/internal/replica"
+	"askflow/internal/tenant"
+	"askflow/internal/usage"
+	"askflow/internal/usergroup"
+	"askflow/internal/video"
+	"askflow/internal/videoworker"
+	"askflow/internal/webhook"
+)
+
+var (
+	apiDocOnce sync.Once
+	apiDoc     *openapi.Registry
+)
+
+// APIDoc returns the OpenAPI description of the routes registered by
+// Register. Every /api/ route should have an entry here; Register logs the
+// ones that do not.
+func APIDoc() *openapi.Registry {
+	apiDocOnce.Do(func() { apiDoc = buildAPIDoc() })
+	return apiDoc
+}
+
+// file is a multipart file field.
+var file = openapi.Schema{"type": "string", "format": "binary"}
+
+// message is the body of operations that report success with a
+// user-facing message.
+var message = openapi.Props{"status": "", "message": ""}
+
+func buildAPIDoc() *openapi.Registry {
+	doc := openapi.NewRegistry("Askflow API", "1.0")
+
+	oauth := doc.Group("OAuth")
+	oauth.Route("/api/oauth/url",
+		openapi.Operation{Method: "GET", Summary: "Authorization URL of an OAuth provider", Query: []openapi.Param{{Name: "provider", Required: true}},
+			Response: openapi.Props{"url": ""}})
+	oauth.Route("/api/oauth/callback",
+		openapi.Operation{Method: "POST", Summary: "Complete an OAuth login with the authorization code",
+			Request: openapi.Props{"provider": "", "code": "", "state": ""}, Response: handler.OAuthCallbackResponse{}})
+	oauth.Route("/api/oauth/providers/",
+		openapi.Operation{Method: "DELETE", Path: "/api/oauth/providers/{name}", Summary: "Remove an OAuth provider", Access: openapi.SuperAdmin})
+
+	sso := doc.Group("SSO")
+	sso.Route("/api/sso/login",
+		openapi.Operation{Method: "GET", Summary: "Redirect to an OIDC or SAML identity provider", Redirect: true,
+			Query: []openapi.Param{{Name: "type", Required: true, Description: "oidc or saml"}, {Name: "provider", Required: true}}})
+	sso.Route("/api/sso/oidc/callback",
+		openapi.Operation{Method: "GET", Summary: "OIDC redirect URI; redirects to the frontend with a one-time code", Redirect: true,
+			Query: openapi.Query("code", "state", "error")})
+	sso.Route("/api/sso/saml/acs/",
+		openapi.Operation{Method: "POST", Path: "/api/sso/saml/acs/{provider}", Summary: "SAML assertion consumer service", Redirect: true,
+			Request: openapi.Props{"SAMLResponse": "", "RelayState": ""}, RequestType: "application/x-www-form-urlencoded"})
+	sso.Route("/api/sso/saml/metadata/",
+		openapi.Operation{Method: "GET", Path: "/api/sso/saml/metadata/{provider}", Summary: "SAML service provider metadata", ContentType: openapi.XML})
+	sso.Route("/api/sso/exchange",
+		openapi.Operation{Method: "POST", Summary: "Exchange the one-time SSO code for a session",
+			Request: openapi.Props{"code": ""}, Response: handler.SSOLoginResult{}})
+	sso.Route("/api/sso/providers/",
+		openapi.Operation{Method: "DELETE", Path: "/api/sso/providers/{type}/{name}", Summary: "Remove an SSO provider", Access: openapi.SuperAdmin})
+
+	adminAuth := doc.Group("Admin login")
+	adminAuth.Route("/api/admin/login",
+		openapi.Operation{Method: "POST", Summary: "Admin login",
+			Request:  openapi.Props{"username": "", "password": "", "captcha_id": "", "captcha_answer": "", "captcha_token": ""},
+			Response: handler.AdminLoginResponse{}})
+	adminAuth.Route("/api/admin/anonymous-login",
+		openapi.Operation{Method: "POST", Summary: "Admin login in anonymous mode", Response: handler.AdminLoginResponse{}})
+	adminAuth.Route("/api/admin/setup",
+		openapi.Operation{Method: "POST", Summary: "Create the first super admin", Request: openapi.Props{"username": "", "password": ""},
+			Response: handler.AdminLoginResponse{}})
+	adminAuth.Route("/api/admin/logout",
+		openapi.Operation{Method: "POST", Summary: "Admin logout", Access: openapi.Admin})
+	adminAuth.Route("/api/admin/status",
+		openapi.Operation{Method: "GET", Summary: "Whether an admin exists and how to log in",
+			Response: openapi.Props{"configured": false, "login_route": "", "anonymous_mode": false, "anonymous_frontend": false}})
+	adminAuth.Route("/api/admin/invite/accept",
+		openapi.Operation{Method: "POST", Summary: "Set the password of an invited admin", Request: openapi.Props{"token": "", "password": ""}, Response: message})
+
+	userAuth := doc.Group("User login")
+	userAuth.Route("/api/auth/register",
+		openapi.Operation{Method: "POST", Summary: "Register an account and send a verification email",
+			Request: struct {
+				handler.RegisterRequest
+				CaptchaID     string `json:"captcha_id,omitempty"`
+				CaptchaAnswer int    `json:"captcha_answer,omitempty"`
+				CaptchaToken  string `json:"captcha_token,omitempty"`
+			}{}, Response: message})
+	userAuth.Route("/api/auth/login",
+		openapi.Operation{Method: "POST", Summary: "User login",
+			Request:  openapi.Props{"email": "", "password": "", "captcha_id": "", "captcha_answer": 0, "captcha_token": ""},
+			Response: handler.UserLoginResponse{}})
+	userAuth.Route("/api/auth/anonymous-login",
+		openapi.Operation{Method: "POST", Summary: "Shared anonymous frontend login", Response: handler.UserLoginResponse{}})
+	userAuth.Route("/api/auth/logout",
+		openapi.Operation{Method: "POST", Summary: "User logout", Access: openapi.User})
+	userAuth.Route("/api/auth/refresh",
+		openapi.Operation{Method: "POST", Summary: "Rotate the refresh token for a new session",
+			Description: "The refresh token is read from the body or, in cookie session mode, from the refresh cookie.",
+			Request:     openapi.Props{"refresh_token": "", "admin": false}, Response: openapi.Props{"session": auth.Session{}}})
+	userAuth.Route("/api/auth/verify",
+		openapi.Operation{Method: "GET", Summary: "Verify an email address", Query: []openapi.Param{{Name: "token", Required: true}}, Response: message})
+	for _, p := range []string{"/api/auth/forgot-password", "/api/auth/forgot"} {
+		userAuth.Route(p,
+			openapi.Operation{Method: "POST", Summary: "Send a password reset email", Request: openapi.Props{"email": ""}, Response: message})
+	}
+	for _, p := range []string{"/api/auth/reset-password", "/api/auth/reset"} {
+		userAuth.Route(p,
+			openapi.Operation{Method: "POST", Summary: "Reset the password with an emailed token", Request: openapi.Props{"token": "", "password": ""}, Response: message})
+	}
+	userAuth.Route("/api/auth/unlock",
+		openapi.Operation{Method: "GET", Summary: "Unlock a locked account from the emailed link", Query: []openapi.Param{{Name: "token", Required: true}}, Redirect: true},
+		openapi.Operation{Method: "POST", Summary: "Unlock a locked account", Request: openapi.Props{"token": ""}, Response: message})
+	userAuth.Route("/api/auth/change-password",
+		openapi.Operation{Method: "POST", Summary: "Change the password; other sessions are signed out", Access: openapi.User,
+			Request: openapi.Props{"old_password": "", "new_password": ""}, Response: openapi.Props{"status": "", "session": auth.Session{}}})
+	userAuth.Route("/api/auth/account",
+		openapi.Operation{Method: "DELETE", Summary: "Delete the signed-in account", Access: openapi.User, Request: openapi.Props{"password": ""}})
+	userAuth.Route("/api/auth/me/export",
+		openapi.Operation{Method: "GET", Summary: "Export the signed-in user's personal data", Access: openapi.User, Response: handler.UserDataExport{}})
+	userAuth.Route("/api/auth/sessions",
+		openapi.Operation{Method: "GET", Summary: "Devices the user is signed in on", Access: openapi.User,
+			Response: openapi.Props{"sessions": []auth.Device{}}})
+	userAuth.Route("/api/auth/sessions/",
+		openapi.Operation{Method: "DELETE", Path: "/api/auth/sessions/{id}", Summary: "Sign out a device", Access: openapi.User})
+	userAuth.Route("/api/auth/sn-login",
+		openapi.Operation{Method: "POST", Summary: "Exchange a license server token for a login ticket",
+			Request: handler.SNLoginRequest{}, Response: handler.SNLoginResponse{}})
+	userAuth.Route("/api/auth/ticket-exchange",
+		openapi.Operation{Method: "POST", Summary: "Exchange a login ticket for a session", Request: openapi.Props{"ticket": ""},
+			Response: openapi.Props{"session": auth.Session{}, "user": openapi.Props{"id": "", "email": "", "name": "", "provider": ""}}})
+	userAuth.Route("/auth/ticket-login",
+		openapi.Operation{Method: "GET", Summary: "Validate a login ticket and redirect to the frontend", Redirect: true,
+			Query: []openapi.Param{{Name: "ticket", Required: true}}})
+	userAuth.Route("/api/captcha",
+		openapi.Operation{Method: "GET", Summary: "Text math captcha", Response: handler.CaptchaResponse{}})
+	userAuth.Route("/api/captcha/image",
+		openapi.Operation{Method: "GET", Summary: "Image captcha", Response: captcha.Response{}})
+
+	info := doc.Group("Public info")
+	info.Route("/api/product-intro",
+		openapi.Operation{Method: "GET", Summary: "Welcome message of a product", Query: openapi.Query("product_id"), Response: openapi.Props{"product_intro": ""}})
+	info.Route("/api/faq",
+		openapi.Operation{Method: "GET", Summary: "Generated FAQ of a product, most asked first", Query: openapi.Query("product_id"), Response: faq.FAQ{}})
+	info.Route("/api/app-info",
+		openapi.Operation{Method: "GET", Summary: "Product name, login options and message languages",
+			Response: openapi.Props{"product_name": "", "oauth_providers": []string{}, "sso_providers": openapi.Schema{"type": "array", "items": openapi.Schema{}},
+				"max_upload_size_mb": 0, "captcha": openapi.Schema{}, "language": "", "languages": []string{}}})
+	info.Route("/api/translate-product-name",
+		openapi.Operation{Method: "GET", Summary: "Product name translated to a language", Query: []openapi.Param{{Name: "lang", Required: true}},
+			Response: openapi.Props{"product_name": ""}})
+	info.Route("/api/system/status",
+		openapi.Operation{Method: "GET", Summary: "Whether the system is configured", Response: openapi.Props{"ready": false}})
+	info.Route("/api/health",
+		openapi.Operation{Method: "GET", Summary: "Liveness probe"})
+	info.Route("/healthz",
+		openapi.Operation{Method: "GET", Summary: "Liveness probe"})
+	info.Route("/readyz",
+		openapi.Operation{Method: "GET", Summary: "Readiness probe; 503 while a check fails",
+			Response: openapi.Props{"status": "", "checks": openapi.Schema{"type": "object", "additionalProperties": openapi.Schema{
+				"type":       "object",
+				"properties": openapi.Schema{"status": openapi.Schema{"type": "string"}, "error": openapi.Schema{"type": "string"}, "checked_at": openapi.Schema{"type": "string", "format": "date-time"}},
+			}}}})
+	info.Route("/api/replica/pending",
+		openapi.Operation{Method: "POST", Summary: "Record a question that went pending on a read-only replica",
+			Description: "Called by replicas started with --replica-of, with replica.token as a bearer token. The question gets the notifications and suggested answer of one asked on this instance.",
+			Request:     replica.PendingQuestion{}, Response: openapi.Props{"status": ""}})
+	info.Route("/api/replica/usage",
+		openapi.Operation{Method: "POST", Summary: "Add the usage of a query answered on a read-only replica",
+			Description: "Called by replicas started with --replica-of, with replica.token as a bearer token.",
+			Request:     replica.Usage{}, Response: openapi.Props{"status": ""}})
+	info.Route("/api/replica/answers",
+		openapi.Operation{Method: "POST", Summary: "Keep an answer given on a read-only replica for export",
+			Description: "Called by replicas started with --replica-of, with replica.token as a bearer token.",
+			Request:     replica.Answer{}, Response: openapi.Props{"status": ""}})
+	info.Route("/api/video-worker/claim",
+		openapi.Operation{Method: "POST", Summary: "Claim the oldest queued video job",
+			Description: "Called by remote video workers (askflow video-worker) with video.workers.token as a bearer token and their name in X-Video-Worker. 204 when no job is queued. A running job whose worker stopped reporting for 2 minutes is handed out again, at most 3 times.",
+			Response:    videoworker.Job{}})
+	info.Route("/api/video-worker/jobs/",
+		openapi.Operation{Method: "GET", Path: "/api/video-worker/jobs/{id}/video", Summary: "Video file of a claimed job",
+			Description: "409 once the job was canceled or handed to another worker, also for the requests below.",
+			ContentType: openapi.Binary},
+		openapi.Operation{Method: "POST", Path: "/api/video-worker/jobs/{id}/progress", Summary: "Report the progress of a claimed job, which keeps the claim alive",
+			Request: videoworker.Progress{}, Response: openapi.Props{"status": ""}},
+		openapi.Operation{Method: "POST", Path: "/api/video-worker/jobs/{id}/result", Summary: "Upload the transcript and keyframes of a claimed job",
+			Description: "The result part holds the duration, transcript and keyframe timestamps as JSON; the keyframe images follow as frame parts in the same order.",
+			Request:     openapi.Props{"result": "", "frame": file}, RequestType: openapi.Multipart, Response: openapi.Props{"status": ""}},
+		openapi.Operation{Method: "POST", Path: "/api/video-worker/jobs/{id}/fail", Summary: "Report that the video of a claimed job could not be parsed",
+			Request: videoworker.Failure{}, Response: openapi.Props{"status": ""}})
+	info.Route("/api/openapi.json",
+		openapi.Operation{Method: "GET", Summary: "This OpenAPI document", Response: openapi.Schema{"type": "object"}})
+
+	q := doc.Group("Query")
+	q.Route("/api/query",
+		openapi.Operation{Method: "POST", Summary: "Ask a question", Access: openapi.User,
+			Request:  openapi.Props{"question": "", "product_id": "", "image_data": "", "captcha_token": ""},
+			Response: query.QueryResponse{}})
+	q.Route("/api/query/feedback",
+		openapi.Operation{Method: "POST", Summary: "Rate an answer", Access: openapi.User,
+			Request: openapi.Props{"query_id": "", "helpful": false, "comment": ""}, Response: openapi.Props{"message": ""}})
+	q.Route("/api/query/",
+		openapi.Operation{Method: "GET", Path: "/api/query/{id}/export", Summary: "Download an answer with its sources as a printable HTML document", Access: openapi.User,
+			Description: "id is the query_id of the answer. Images are embedded, so the document can be shared and printed to PDF from a browser. The user who asked and admins with view_analytics on the answer's product can export it, for 30 days; pending, refused and unverified answers and answers without sources are not kept. 404 otherwise.",
+			ContentType: "text/html"})
+	q.Route("/api/user/preferences",
+		openapi.Operation{Method: "GET", Summary: "Default product and message language", Access: openapi.User,
+			Response: openapi.Props{"default_product_id": "", "language": ""}},
+		openapi.Operation{Method: "PUT", Summary: "Update preferences; omitted fields are unchanged", Access: openapi.User,
+			Request: openapi.Props{"default_product_id": "", "language": ""}})
+	q.Route("/api/pending/create",
+		openapi.Operation{Method: "POST", Summary: "Submit a question for a human answer", Access: openapi.User,
+			Request: openapi.Props{"question": "", "image_data": "", "product_id": ""}, Response: pending.PendingQuestion{}})
+
+	widget := doc.Group("Widget")
+	widget.Route("/api/widget.js",
+		openapi.Operation{Method: "GET", Summary: "Embeddable widget script", ContentType: openapi.JavaScript})
+	widget.Route("/api/widget/session",
+		openapi.Operation{Method: "POST", Summary: "Visitor session for an allowlisted origin", Query: []openapi.Param{{Name: "product_id", Required: true}},
+			Response: handler.WidgetSessionResponse{}})
+	widget.Route("/api/widget/query",
+		openapi.Operation{Method: "POST", Summary: "Ask a question as a widget visitor", Access: openapi.User, Query: []openapi.Param{{Name: "product_id", Required: true}},
+			Request: openapi.Props{"question": "", "image_data": ""}, Response: query.QueryResponse{}})
+
+	channel := doc.Group("Channels")
+	channel.Route("/api/channel/telegram",
+		openapi.Operation{Method: "POST", Summary: "Telegram bot webhook; checks X-Telegram-Bot-Api-Secret-Token",
+			Request: openapi.Schema{"type": "object"}, Response: openapi.Props{"ok": true}})
+	channel.Route("/api/channel/wechat",
+		openapi.Operation{Method: "GET", Summary: "WeChat server verification", Query: openapi.Query("signature", "timestamp", "nonce", "echostr"), ContentType: "text/plain"},
+		openapi.Operation{Method: "POST", Summary: "WeChat official account message", Query: openapi.Query("signature", "timestamp", "nonce"),
+			Request: openapi.Schema{"type": "string"}, RequestType: openapi.XML, ContentType: "text/plain"})
+
+	docs := doc.Group("Documents")
+	docs.Route("/api/documents",
+		openapi.Operation{Method: "GET", Summary: "List documents", Access: openapi.Admin, Query: openapi.Query("product_id", "batch_id"),
+			Response: openapi.Props{"documents": []document.DocumentInfo{}}})
+	docs.Route("/api/documents/upload",
+		openapi.Operation{Method: "POST", Summary: "Upload a document", Access: openapi.Admin,
+			Description: "A .zip file is expanded, nested archives included, and each supported file in it is imported as a document; the response is then an archive import result with the per-file outcomes and the batch ID shared by the documents. A video may come with an .srt or .vtt file in subtitle, used as its transcript instead of speech recognition.",
+			Request:     openapi.Props{"file": file, "subtitle": file, "product_id": ""}, RequestType: openapi.Multipart, Response: document.DocumentInfo{}})
+	docs.Route("/api/documents/uploads",
+		openapi.Operation{Method: "POST", Summary: "Open a resumable upload", Access: openapi.Admin,
+			Description: "Returns the upload session with its ID and the chunk size to send. Unfinished sessions expire after 24 hours.",
+			Request:     openapi.Props{"file_name": "", "size": 0, "product_id": ""}, Response: handler.UploadSessionResponse{}})
+	docs.Route("/api/documents/uploads/",
+		openapi.Operation{Method: "GET", Path: "/api/documents/uploads/{id}", Summary: "Offset of a resumable upload", Access: openapi.Admin,
+			Response: handler.UploadSessionResponse{}},
+		openapi.Operation{Method: "PATCH", Path: "/api/documents/uploads/{id}", Summary: "Append a chunk of a resumable upload", Access: openapi.Admin,
+			Description: "The body is the raw chunk and the Upload-Offset header must equal the session's offset; otherwise 409 with the current offset in Upload-Offset. Bytes received before a dropped connection are kept.",
+			Request:     file, RequestType: openapi.Binary, Response: handler.UploadSessionResponse{}},
+		openapi.Operation{Method: "POST", Path: "/api/documents/uploads/{id}/complete", Summary: "Import a completely uploaded file as a document", Access: openapi.Admin,
+			Description: "ZIP archives are imported as in /api/documents/upload.",
+			Response:    document.DocumentInfo{}},
+		openapi.Operation{Method: "DELETE", Path: "/api/documents/uploads/{id}", Summary: "Abandon a resumable upload", Access: openapi.Admin,
+			Response: openapi.Props{"status": "deleted"}})
+	docs.Route("/api/documents/url/preview",
+		openapi.Operation{Method: "POST", Summary: "Preview the text of a web page", Access: openapi.Admin,
+			Request: openapi.Props{"url": ""}, Response: document.URLPreviewResult{}})
+	docs.Route("/api/documents/url",
+		openapi.Operation{Method: "POST", Summary: "Import a web page", Access: openapi.Admin,
+			Request: document.UploadURLRequest{}, Response: document.DocumentInfo{}})
+	docs.Route("/api/documents/",
+		openapi.Operation{Method: "DELETE", Path: "/api/documents/{id}", Summary: "Delete a document", Access: openapi.Admin},
+		openapi.Operation{Method: "DELETE", Path: "/api/documents/{id}/cancel", Summary: "Cancel processing of a document and remove what was stored so far", Access: openapi.Admin,
+			Response: openapi.Props{"status": "canceled"}},
+		openapi.Operation{Method: "PUT", Path: "/api/documents/{id}/priority", Summary: "Set the search ranking priority of a document (0.1-10, default 1)", Access: openapi.Admin,
+			Request: openapi.Props{"priority": 0.5}, Response: openapi.Props{"status": "ok", "priority": 0.5}},
+		openapi.Operation{Method: "GET", Path: "/api/documents/{id}/review", Summary: "Extracted text of a document for review", Access: openapi.Admin,
+			Response: document.ReviewData{}},
+		openapi.Operation{Method: "GET", Path: "/api/documents/{id}/progress", Summary: "Processing progress; streamed as progress events and a final done event", Access: openapi.Admin,
+			ContentType: openapi.EventStream},
+		openapi.Operation{Method: "GET", Path: "/api/documents/{id}/download", Summary: "Download the original file", Access: openapi.User,
+			Query: openapi.Query("product_id", "inline"), ContentType: openapi.Binary},
+		openapi.Operation{Method: "GET", Path: "/api/documents/{id}/transcript", Summary: "Transcript of a video or subtitle document as WebVTT", Access: openapi.User,
+			Description: "Speakers are written as <v> voice tags. 404 when the document has no transcript.",
+			Query:       openapi.Query("token"), ContentType: "text/vtt"})
+	docs.Route("/api/documents/public-download/",
+		openapi.Operation{Method: "GET", Path: "/api/documents/public-download/{id}", Summary: "Download a document of a product that allows downloads",
+			Query: openapi.Query("token"), ContentType: openapi.Binary})
+	docs.Route("/api/knowledge",
+		openapi.Operation{Method: "POST", Summary: "Add a text knowledge entry", Access: openapi.Admin, Request: handler.KnowledgeEntryRequest{}})
+	docs.Route("/api/images/upload",
+		openapi.Operation{Method: "POST", Summary: "Upload an image for knowledge entries", Access: openapi.Admin,
+			Request: openapi.Props{"image": file}, RequestType: openapi.Multipart, Response: openapi.Props{"url": ""}})
+	docs.Route("/api/videos/upload",
+		openapi.Operation{Method: "POST", Summary: "Upload a video for knowledge entries", Access: openapi.Admin,
+			Request: openapi.Props{"video": file}, RequestType: openapi.Multipart, Response: openapi.Props{"url": ""}})
+	docs.Route("/api/images/",
+		openapi.Operation{Method: "GET", Path: "/api/images/{id}", Summary: "Stored image; signed URL or session required", Query: openapi.Query("token"), ContentType: openapi.Binary})
+	docs.Route("/api/videos/knowledge/",
+		openapi.Operation{Method: "GET", Path: "/api/videos/knowledge/{name}", Summary: "Knowledge entry video", ContentType: openapi.Binary})
+	docs.Route("/api/media/",
+		openapi.Operation{Method: "GET", Path: "/api/media/{id}", Summary: "Stream document media", Query: openapi.Query("token"), ContentType: openapi.Binary})
+	docs.Route("/api/batch-import",
+		openapi.Operation{Method: "POST", Summary: "Import a server directory; progress is streamed as events", Access: openapi.SuperAdmin,
+			Request: openapi.Props{"path": "", "product_id": ""}, ContentType: openapi.EventStream})
+	connectorRequest := openapi.Props{"product_id": "", "type": "confluence", "name": "", "base_url": "", "email": "", "api_token": "", "scope": "", "branch": "", "region": "", "schedule": "0 * * * *", "enabled": true}
+	docs.Route("/api/admin/connectors",
+		openapi.Operation{Method: "GET", Summary: "List Confluence, Notion, Git, Google Drive, SharePoint and S3 connectors", Access: openapi.Admin, Query: openapi.Query("product_id"),
+			Response: openapi.Props{"connectors": []connector.Connector{}}},
+		openapi.Operation{Method: "POST", Summary: "Add a Confluence, Notion, Git, Google Drive, SharePoint or S3 connector", Access: openapi.Admin,
+			Description: "type is confluence (base_url, email and the space key as scope), notion (optional root page ID or URL as scope) or git (https repository URL as base_url, optional branch, folder as scope, and a token with email as its user name for private repositories; Markdown and HTML files are synced and the commit SHA is recorded as the document source_version), gdrive or sharepoint (comma-separated folder IDs from the folders endpoint as scope; no token, authorize the connector instead; Word, Excel, PowerPoint and PDF files and Google Docs, Sheets and Slides are synced) or s3 (endpoint as base_url, empty for AWS S3, region, access key ID as email, secret key as api_token and bucket/prefix as scope; documents and Markdown/HTML files under the prefix are imported, and for connectors without product_id the first folder below the prefix names the product by ID or name, the remaining folders being the document category). The API token is stored encrypted and never returned. Pages are synced on the cron schedule; pages modified since the last sync are imported again and deleted pages are removed.",
+			Request:     connectorRequest, Response: connector.Connector{}})
+	docs.Route("/api/admin/connectors/",
+		openapi.Operation{Method: "GET", Path: "/api/admin/connectors/{id}", Summary: "Get a connector with its last sync result", Access: openapi.Admin, Response: connector.Connector{}},
+		openapi.Operation{Method: "PUT", Path: "/api/admin/connectors/{id}", Summary: "Update a connector; an empty api_token keeps the current one", Access: openapi.Admin,
+			Request: connectorRequest, Response: connector.Connector{}},
+		openapi.Operation{Method: "DELETE", Path: "/api/admin/connectors/{id}", Summary: "Delete a connector and the documents it synced", Access: openapi.Admin},
+		openapi.Operation{Method: "POST", Path: "/api/admin/connectors/{id}/sync", Summary: "Sync a connector now, in the background", Access: openapi.Admin,
+			Response: openapi.Props{"status": "started"}},
+		openapi.Operation{Method: "GET", Path: "/api/admin/connectors/{id}/pages", Summary: "Synced pages with their hierarchy and documents", Access: openapi.Admin,
+			Response: openapi.Props{"pages": []connector.Page{}}},
+		openapi.Operation{Method: "POST", Path: "/api/admin/connectors/{id}/authorize", Summary: "Google or Microsoft sign-in URL authorizing a gdrive or sharepoint connector", Access: openapi.Admin,
+			Description: "Open the URL in the browser; after signing in the admin is sent back to the admin panel with connector_auth=ok or failed. The link expires after 10 minutes.",
+			Response:    openapi.Props{"url": ""}},
+		openapi.Operation{Method: "GET", Path: "/api/admin/connectors/{id}/folders", Summary: "Folders an authorized gdrive or sharepoint connector can sync", Access: openapi.Admin,
+			Query:    []openapi.Param{{Name: "parent", Description: "folder to list the subfolders of; empty for the top level (My Drive and shared drives, or OneDrive and SharePoint sites)"}},
+			Response: openapi.Props{"folders": []connector.Folder{}}},
+		openapi.Operation{Method: "GET", Path: "/api/admin/connectors/{id}/events", Summary: "Event notification URL and bearer token of an s3 connector", Access: openapi.Admin,
+			Response: openapi.Props{"url": "", "token": ""}})
+	docs.Route("/api/connectors/",
+		openapi.Operation{Method: "POST", Path: "/api/connectors/{id}/events", Summary: "Event notification endpoint of an S3 connector; syncs it in the background",
+			Description: "Send the token from GET /api/admin/connectors/{id}/events as a bearer token, e.g. as the auth_token of a MinIO webhook target. The body is ignored.",
+			Response:    openapi.Props{"status": "started"}})
+	docs.Route("/api/connectors/oauth/callback",
+		openapi.Operation{Method: "GET", Summary: "Redirect URI of connector authorizations; redirects to the admin panel", Redirect: true,
+			Query: openapi.Query("code", "state", "error")})
+
+	pend := doc.Group("Pending questions")
+	pend.Route("/api/pending",
+		openapi.Operation{Method: "GET", Summary: "List pending questions", Access: openapi.Admin, Query: openapi.Query("status", "product_id"),
+			Response: openapi.Props{"questions": []pending.PendingQuestion{}}})
+	pend.Route("/api/pending/answer",
+		openapi.Operation{Method: "POST", Summary: "Answer a pending question", Access: openapi.Admin, Request: pending.AdminAnswerRequest{}})
+	pend.Route("/api/pending/",
+		openapi.Operation{Method: "DELETE", Path: "/api/pending/{id}", Summary: "Delete a pending question", Access: openapi.Admin},
+		openapi.Operation{Method: "POST", Path: "/api/pending/{id}/draft", Summary: "Regenerate the suggested answer", Access: openapi.Admin})
+	pend.Route("/api/admin/pending/overdue",
+		openapi.Operation{Method: "GET", Summary: "List pending questions past their SLA response time", Access: openapi.Admin, Query: openapi.Query("product_id"),
+			Response: openapi.Props{"questions": []pending.OverdueQuestion{}}})
+	pend.Route("/api/admin/pending/sla",
+		openapi.Operation{Method: "GET", Summary: "List SLA policies", Access: openapi.Admin, Response: openapi.Props{"policies": []pending.SLAPolicy{}}},
+		openapi.Operation{Method: "PUT", Summary: "Create or replace an SLA policy", Access: openapi.Admin,
+			Description: "Questions still pending response_hours after they were asked escalate one level per further response_hours, up to max_reminders, emailing recipients each time.",
+			Request:     pending.SLAPolicy{}, Response: pending.SLAPolicy{}},
+		openapi.Operation{Method: "DELETE", Summary: "Remove an SLA policy", Access: openapi.Admin, Query: openapi.Query("product_id")})
+
+	cfg := doc.Group("Config")
+	cfg.Route("/api/config",
+		openapi.Operation{Method: "GET", Summary: "Configuration with secrets masked", Access: openapi.Admin, Response: handler.MaskedConfig{}},
+		openapi.Operation{Method: "PUT", Summary: "Update configuration by dotted key", Access: openapi.Admin,
+			Request: openapi.Schema{"type": "object", "additionalProperties": openapi.Schema{}, "example": openapi.Schema{"llm.model_name": "gpt-4o", "vector.top_k": 5}}})
+	cfg.Route("/api/config/validate",
+		openapi.Operation{Method: "POST", Summary: "Check configuration updates without saving them", Access: openapi.Admin,
+			Request: openapi.Schema{"type": "object", "additionalProperties": openapi.Schema{}}, Response: handler.ConfigValidation{}})
+	cfg.Route("/api/test/llm",
+		openapi.Operation{Method: "POST", Summary: "Test an LLM endpoint", Access: openapi.Admin,
+			Request:  openapi.Props{"endpoint": "", "api_key": "", "model_name": "", "temperature": 0.0, "max_tokens": 0},
+			Response: openapi.Props{"status": "", "reply": ""}})
+	cfg.Route("/api/test/embedding",
+		openapi.Operation{Method: "POST", Summary: "Test an embedding endpoint", Access: openapi.Admin,
+			Request:  openapi.Props{"endpoint": "", "api_key": "", "model_name": "", "use_multimodal": false},
+			Response: openapi.Props{"status": "", "dimensions": 0}})
+	cfg.Route("/api/email/test",
+		openapi.Operation{Method: "POST", Summary: "Send a test email", Access: openapi.Admin,
+			Request: openapi.Props{"email": "", "host": "", "port": 0, "username": "", "password": "", "from_addr": "", "from_name": "",
+				"use_tls": false, "auth_method": ""}, Response: message})
+
+	vid := doc.Group("Video")
+	vid.Route("/api/video/check-deps",
+		openapi.Operation{Method: "GET", Summary: "Check ffmpeg and speech recognition dependencies", Access: openapi.Admin, Response: video.DepsCheckResult{}})
+	vid.Route("/api/video/validate-rapidspeech",
+		openapi.Operation{Method: "POST", Summary: "Validate RapidSpeech paths", Access: openapi.Admin,
+			Request: openapi.Props{"rapidspeech_path": "", "rapidspeech_model": ""}, Response: openapi.Props{"valid": false, "errors": []string{}}})
+	vid.Route("/api/video/auto-setup/check",
+		openapi.Operation{Method: "GET", Summary: "Whether automatic dependency setup is possible", Access: openapi.SuperAdmin,
+			Response: openapi.Props{"supported": false, "is_root": false, "distro": "", "package_manager": "", "message": ""}})
+	vid.Route("/api/video/auto-setup",
+		openapi.Operation{Method: "POST", Summary: "Install video dependencies; progress is streamed as events", Access: openapi.SuperAdmin,
+			Request: openapi.Props{"root_password": ""}, ContentType: openapi.EventStream})
+
+	admins := doc.Group("Admin users")
+	admins.Route("/api/admin/users",
+		openapi.Operation{Method: "GET", Summary: "List admin accounts", Access: openapi.SuperAdmin, Response: openapi.Props{"users": []handler.AdminUserInfo{}}},
+		openapi.Operation{Method: "POST", Summary: "Create or invite an admin account", Access: openapi.SuperAdmin,
+			Description: "Without a password an invitation is emailed to the address.",
+			Request:     openapi.Props{"username": "", "password": "", "email": "", "role": "", "product_ids": []string{}, "permissions": []string{}},
+			Response:    handler.AdminUserInfo{}})
+	admins.Route("/api/admin/users/",
+		openapi.Operation{Method: "PUT", Path: "/api/admin/users/{id}", Summary: "Change the role or disable an admin", Access: openapi.SuperAdmin,
+			Request: openapi.Props{"role": "", "disabled": false}},
+		openapi.Operation{Method: "DELETE", Path: "/api/admin/users/{id}", Summary: "Delete an admin account", Access: openapi.SuperAdmin},
+		openapi.Operation{Method: "GET", Path: "/api/admin/users/{id}/grants", Summary: "Per-product role grants", Access: openapi.SuperAdmin,
+			Response: openapi.Props{"grants": []rbac.Grant{}}},
+		openapi.Operation{Method: "PUT", Path: "/api/admin/users/{id}/grants", Summary: "Replace per-product role grants", Access: openapi.SuperAdmin,
+			Request: openapi.Props{"grants": []rbac.Grant{}}},
+		openapi.Operation{Method: "POST", Path: "/api/admin/users/{id}/invite", Summary: "Resend the invitation", Access: openapi.SuperAdmin})
+	admins.Route("/api/admin/role",
+		openapi.Operation{Method: "GET", Summary: "Role and permissions of the signed-in admin", Access: openapi.Admin,
+			Response: openapi.Props{"role": "", "permissions": []string{}}})
+	admins.Route("/api/admin/roles",
+		openapi.Operation{Method: "GET", Summary: "List roles and available permissions", Access: openapi.SuperAdmin,
+			Response: openapi.Props{"roles": []rbac.Role{}, "permissions": []string{}}},
+		openapi.Operation{Method: "POST", Summary: "Create a custom role", Access: openapi.SuperAdmin,
+			Request: openapi.Props{"name": "", "description": "", "permissions": []string{}}, Response: rbac.Role{}})
+	admins.Route("/api/admin/roles/",
+		openapi.Operation{Method: "PUT", Path: "/api/admin/roles/{id}", Summary: "Update a custom role", Access: openapi.SuperAdmin,
+			Request: openapi.Props{"name": "", "description": "", "permissions": []string{}}},
+		openapi.Operation{Method: "DELETE", Path: "/api/admin/roles/{id}", Summary: "Delete a custom role", Access: openapi.SuperAdmin})
+
+	tenantRequest := openapi.Props{"slug": "", "name": "", "status": "", "product_name": "", "product_intro": "", "quota": tenant.Quota{},
+		"admin_username": "", "admin_password": ""}
+	tenants := doc.Group("Tenants")
+	tenants.Route("/api/admin/tenants",
+		openapi.Operation{Method: "GET", Summary: "List workspaces", Access: openapi.SuperAdmin, Response: openapi.Props{"tenants": []handler.TenantInfo{}}},
+		openapi.Operation{Method: "POST", Summary: "Create a workspace and its first admin", Access: openapi.SuperAdmin,
+			Request: tenantRequest, Response: handler.TenantInfo{}})
+	tenants.Route("/api/admin/tenants/",
+		openapi.Operation{Method: "GET", Path: "/api/admin/tenants/{id}", Summary: "Get a workspace", Access: openapi.SuperAdmin, Response: handler.TenantInfo{}},
+		openapi.Operation{Method: "PUT", Path: "/api/admin/tenants/{id}", Summary: "Update a workspace", Access: openapi.SuperAdmin,
+			Request: tenantRequest, Response: handler.TenantInfo{}},
+		openapi.Operation{Method: "DELETE", Path: "/api/admin/tenants/{id}", Summary: "Delete a workspace", Access: openapi.SuperAdmin})
+
+	ops := doc.Group("Operations")
+	ops.Route("/api/admin/audit",
+		openapi.Operation{Method: "GET", Summary: "Search the audit log", Access: openapi.SuperAdmin,
+			Query:    openapi.Query("actor", "action", "resource_id", "from", "to", "page:integer", "page_size:integer"),
+			Response: audit.ListResult{}})
+	ops.Route("/api/admin/usage",
+		openapi.Operation{Method: "GET", Summary: "Token usage for a month", Access: openapi.Admin, Query: openapi.Query("period", "scope", "subject_id"),
+			Response: openapi.Props{"period": "", "records": []usage.Record{}}})
+	ops.Route("/api/admin/usage/quotas",
+		openapi.Operation{Method: "GET", Summary: "List quota overrides", Access: openapi.SuperAdmin, Response: openapi.Props{"quotas": []usage.Override{}}},
+		openapi.Operation{Method: "PUT", Summary: "Set a quota override", Access: openapi.SuperAdmin, Request: usage.Override{}, Response: openapi.Props{"message": ""}},
+		openapi.Operation{Method: "DELETE", Summary: "Remove a quota override", Access: openapi.SuperAdmin,
+			Query: []openapi.Param{{Name: "scope", Required: true}, {Name: "subject_id", Required: true}}, Response: openapi.Props{"message": ""}})
+	ops.Route("/api/admin/backup",
+		openapi.Operation{Method: "GET", Summary: "Backup schedule and archives", Access: openapi.SuperAdmin, Response: backup.Status{}},
+		openapi.Operation{Method: "POST", Summary: "Start a backup", Access: openapi.SuperAdmin, Request: openapi.Props{"mode": ""}, Response: openapi.Props{"status": ""}})
+	ops.Route("/api/admin/maintenance",
+		openapi.Operation{Method: "GET", Summary: "Maintenance schedule, last run and database space use", Access: openapi.SuperAdmin, Response: maintenance.Status{}},
+		openapi.Operation{Method: "POST", Summary: "Start database maintenance", Access: openapi.SuperAdmin,
+			Description: "Runs incremental VACUUM (the first run converts the database with a full VACUUM), REINDEX and PRAGMA optimize, then compacts the vector cache. The outcome, including the bytes reclaimed, is reported as last_run by GET.",
+			Response:    openapi.Props{"status": ""}})
+	ops.Route("/api/admin/locks",
+		openapi.Operation{Method: "GET", Summary: "Leases held by background jobs", Access: openapi.SuperAdmin,
+			Description: "Scheduled backups, maintenance, gap reports, SLA checks, FAQ generation and connector syncs take a lease in the database before running, so that instances sharing the database run each job once. instance is the owner name of this instance; held leases have not expired, and slot is the scheduled time of the job's last scheduled run.",
+			Response:    openapi.Props{"instance": "", "locks": []lease.Info{}}})
+	ops.Route("/api/admin/jobs",
+		openapi.Operation{Method: "GET", Summary: "Background jobs, newest first", Access: openapi.SuperAdmin, Query: openapi.Query("kind", "status", "page:integer", "page_size:integer"),
+			Description: "Document ingestion, backups, database maintenance, connector syncs and FAQ generation are recorded as jobs with their status (running, succeeded, failed or canceled), progress in percent and timings. kind is ingestion, backup, maintenance, connector_sync or faq. Jobs whose instance stops refreshing them for three minutes are marked failed; finished jobs are kept for 30 days.",
+			Response:    openapi.Props{"jobs": []jobs.Job{}, "total": 0}})
+	ops.Route("/api/admin/jobs/",
+		openapi.Operation{Method: "GET", Path: "/api/admin/jobs/{id}", Summary: "Get a job", Access: openapi.SuperAdmin, Response: jobs.Job{}},
+		openapi.Operation{Method: "POST", Path: "/api/admin/jobs/{id}/cancel", Summary: "Cancel a running job", Access: openapi.SuperAdmin,
+			Description: "Only jobs with cancelable set can be canceled: those running on this instance that can stop early. Backups cannot.",
+			Response:    openapi.Props{"status": "canceling"}},
+		openapi.Operation{Method: "POST", Path: "/api/admin/jobs/{id}/retry", Summary: "Run a failed or canceled job again", Access: openapi.SuperAdmin,
+			Description: "Starts a new job of the same kind in the background: ingestion reprocesses the document from its original file or URL, the others run as if started manually.",
+			Response:    openapi.Props{"status": "started"}})
+	ops.Route("/api/admin/embedding/queue",
+		openapi.Operation{Method: "GET", Summary: "Embedding request queue depth and counters", Access: openapi.SuperAdmin, Response: embedding.PoolStats{}})
+	ops.Route("/api/admin/query/queue",
+		openapi.Operation{Method: "GET", Summary: "Query admission queue depth and counters", Access: openapi.SuperAdmin, Response: query.AdmissionStats{}})
+	ops.Route("/api/logs/recent",
+		openapi.Operation{Method: "GET", Summary: "Recent error log lines", Access: openapi.SuperAdmin, Query: openapi.Query("lines:integer"),
+			Response: openapi.Props{"lines": []string{}, "rotation_mb": 0}})
+	ops.Route("/api/logs/rotation",
+		openapi.Operation{Method: "GET", Summary: "Error log rotation size", Access: openapi.SuperAdmin, Response: openapi.Props{"rotation_mb": 0}},
+		openapi.Operation{Method: "PUT", Summary: "Set the error log rotation size", Access: openapi.SuperAdmin,
+			Request: openapi.Props{"rotation_mb": 0}, Response: openapi.Props{"status": "", "rotation_mb": 0}})
+	ops.Route("/api/logs/download",
+		openapi.Operation{Method: "GET", Summary: "Download the error logs", Access: openapi.SuperAdmin, ContentType: "application/gzip"})
+	ops.Route("/api/logs/clear",
+		openapi.Operation{Method: "DELETE", Summary: "Clear the error logs", Access: openapi.SuperAdmin, Response: openapi.Props{"status": "", "archives_removed": 0}})
+
+	quality := doc.Group("Answer quality")
+	quality.Route("/api/admin/experiments",
+		openapi.Operation{Method: "GET", Summary: "List retrieval experiments", Access: openapi.Admin, Response: openapi.Props{"experiments": []experiment.Experiment{}}},
+		openapi.Operation{Method: "POST", Summary: "Create a retrieval experiment", Access: openapi.Admin, Request: experiment.Experiment{}, Response: experiment.Experiment{}})
+	quality.Route("/api/admin/experiments/",
+		openapi.Operation{Method: "GET", Path: "/api/admin/experiments/{id}", Summary: "Get an experiment", Access: openapi.Admin, Response: experiment.Experiment{}},
+		openapi.Operation{Method: "PUT", Path: "/api/admin/experiments/{id}", Summary: "Update a draft experiment", Access: openapi.Admin,
+			Request: experiment.Experiment{}, Response: experiment.Experiment{}},
+		openapi.Operation{Method: "DELETE", Path: "/api/admin/experiments/{id}", Summary: "Delete an experiment", Access: openapi.Admin},
+		openapi.Operation{Method: "POST", Path: "/api/admin/experiments/{id}/start", Summary: "Start an experiment", Access: openapi.Admin, Response: experiment.Experiment{}},
+		openapi.Operation{Method: "POST", Path: "/api/admin/experiments/{id}/stop", Summary: "Stop an experiment", Access: openapi.Admin, Response: experiment.Experiment{}},
+		openapi.Operation{Method: "GET", Path: "/api/admin/experiments/{id}/report", Summary: "Per-variant results", Access: openapi.Admin, Response: experiment.Report{}})
+	quality.Route("/api/admin/gaps",
+		openapi.Operation{Method: "GET", Summary: "Knowledge gap report schedule and reports", Access: openapi.Admin, Response: gaps.Status{}},
+		openapi.Operation{Method: "POST", Summary: "Generate a knowledge gap report", Access: openapi.Admin,
+			Request: openapi.Props{"days": 0, "send_email": false}, Response: openapi.Props{"status": ""}})
+	quality.Route("/api/admin/faq",
+		openapi.Operation{Method: "GET", Summary: "FAQ generation schedule and state", Access: openapi.Admin, Response: faq.Status{}},
+		openapi.Operation{Method: "POST", Summary: "Regenerate the FAQ of all products", Access: openapi.Admin, Response: openapi.Props{"status": ""}})
+	quality.Route("/api/admin/gaps/",
+		openapi.Operation{Method: "GET", Path: "/api/admin/gaps/{id}", Summary: "Get a knowledge gap report", Access: openapi.Admin, Response: gaps.Report{}},
+		openapi.Operation{Method: "DELETE", Path: "/api/admin/gaps/{id}", Summary: "Delete a knowledge gap report", Access: openapi.Admin})
+	quality.Route("/api/admin/moderation/policies",
+		openapi.Operation{Method: "GET", Summary: "List moderation policies", Access: openapi.Admin, Response: openapi.Props{"policies": []moderation.Policy{}}},
+		openapi.Operation{Method: "PUT", Summary: "Create or replace a moderation policy", Access: openapi.Admin, Request: moderation.Policy{}, Response: moderation.Policy{}},
+		openapi.Operation{Method: "DELETE", Summary: "Remove a moderation policy", Access: openapi.Admin, Query: openapi.Query("product_id")})
+	quality.Route("/api/admin/moderation/queue",
+		openapi.Operation{Method: "GET", Summary: "Moderation review queue", Access: openapi.Admin, Query: openapi.Query("status"),
+			Response: openapi.Props{"items": []moderation.Item{}}})
+	quality.Route("/api/admin/moderation/queue/",
+		openapi.Operation{Method: "POST", Path: "/api/admin/moderation/queue/{id}/{action}", Summary: "Approve or reject a queued item", Access: openapi.Admin,
+			Description: "action is approve or reject.", Response: openapi.Props{"item": moderation.Item{}, "message": ""}})
+	refusalRequest := openapi.Props{"product_id": "", "topic": "", "keywords": []string{}, "examples": []string{}, "message": "", "enabled": false}
+	quality.Route("/api/admin/refusals",
+		openapi.Operation{Method: "GET", Summary: "List do-not-answer rules", Access: openapi.Admin, Response: openapi.Props{"rules": []refusal.Rule{}}},
+		openapi.Operation{Method: "POST", Summary: "Add a do-not-answer rule", Access: openapi.Admin,
+			Description: "Questions containing a keyword, or close to an example question, are declined with the rule's message (or refusal.message).",
+			Request:     refusalRequest, Response: refusal.Rule{}})
+	quality.Route("/api/admin/refusals/test",
+		openapi.Operation{Method: "POST", Summary: "Check a question against the do-not-answer list", Access: openapi.Admin,
+			Request: openapi.Props{"question": "", "product_id": ""}, Response: openapi.Props{"refused": false, "match": refusal.Match{}}})
+	quality.Route("/api/admin/query/debug",
+		openapi.Operation{Method: "POST", Summary: "Answer a question as a dry run with the pipeline trace", Access: openapi.Admin,
+			Description: "Returns the intent, the candidate chunks with their scores at each retrieval stage, the messages of every LLM call and the tokens used. overrides replaces retrieval settings for this question; user_id answers with the documents that user may see. Nothing is cached, queued or counted.",
+			Request:     openapi.Props{"question": "", "product_id": "", "image_data": "", "user_id": "", "overrides": query.Overrides{}},
+			Response:    query.DebugResult{}})
+	quality.Route("/api/admin/refusals/",
+		openapi.Operation{Method: "PUT", Path: "/api/admin/refusals/{id}", Summary: "Replace a do-not-answer rule", Access: openapi.Admin, Request: refusalRequest, Response: refusal.Rule{}},
+		openapi.Operation{Method: "DELETE", Path: "/api/admin/refusals/{id}", Summary: "Remove a do-not-answer rule", Access: openapi.Admin})
+
+	hooks := doc.Group("Webhooks")
+	webhookRequest := openapi.Props{"url": "", "secret": "", "events": []string{}, "enabled": false}
+	hooks.Route("/api/admin/webhooks",
+		openapi.Operation{Method: "GET", Summary: "List webhooks and event types", Access: openapi.SuperAdmin,
+			Response: openapi.Props{"webhooks": []webhook.Webhook{}, "events": []string{}}},
+		openapi.Operation{Method: "POST", Summary: "Create a webhook", Access: openapi.SuperAdmin, Request: webhookRequest, Response: webhook.Webhook{}})
+	hooks.Route("/api/admin/webhooks/",
+		openapi.Operation{Method: "PUT", Path: "/api/admin/webhooks/{id}", Summary: "Update a webhook", Access: openapi.SuperAdmin, Request: webhookRequest},
+		openapi.Operation{Method: "DELETE", Path: "/api/admin/webhooks/{id}", Summary: "Delete a webhook", Access: openapi.SuperAdmin},
+		openapi.Operation{Method: "POST", Path: "/api/admin/webhooks/{id}/test", Summary: "Send a test event", Access: openapi.SuperAdmin,
+			Response: openapi.Props{"success": false, "status": 0, "error": ""}})
+
+	users := doc.Group("Customers")
+	users.Route("/api/admin/customers",
+		openapi.Operation{Method: "GET", Summary: "List registered customers", Access: openapi.Admin, Query: openapi.Query("search", "page:integer", "page_size:integer"),
+			Response: handler.CustomerListResult{}})
+	users.Route("/api/admin/customers/verify",
+		openapi.Operation{Method: "POST", Summary: "Mark a customer's email as verified", Access: openapi.SuperAdmin, Request: openapi.Props{"user_id": ""}})
+	users.Route("/api/admin/customers/ban",
+		openapi.Operation{Method: "POST", Summary: "Ban a customer", Access: openapi.SuperAdmin, Request: openapi.Props{"email": "", "reason": "", "days": 0}})
+	users.Route("/api/admin/customers/unban",
+		openapi.Operation{Method: "POST", Summary: "Lift a customer ban", Access: openapi.SuperAdmin, Request: openapi.Props{"email": ""}})
+	users.Route("/api/admin/customers/delete",
+		openapi.Operation{Method: "POST", Summary: "Delete a customer", Access: openapi.SuperAdmin, Request: openapi.Props{"user_id": ""}})
+	users.Route("/api/admin/endusers",
+		openapi.Operation{Method: "GET", Summary: "List end users of all sign-in methods", Access: openapi.Admin, Query: openapi.Query("search", "page:integer", "page_size:integer"),
+			Response: handler.EndUserListResult{}})
+	users.Route("/api/admin/endusers/",
+		openapi.Operation{Method: "DELETE", Path: "/api/admin/endusers/{id}", Summary: "Erase an end user and their personal data", Access: openapi.SuperAdmin,
+			Response: openapi.Props{"status": "", "deleted": map[string]int64{}}})
+	groupRequest := openapi.Props{"name": "", "description": "", "emails": []string{}, "domains": []string{}, "product_ids": []string{}, "document_ids": []string{}}
+	users.Route("/api/admin/user-groups",
+		openapi.Operation{Method: "GET", Summary: "List user groups", Access: openapi.Admin, Response: openapi.Props{"groups": []usergroup.Group{}}},
+		openapi.Operation{Method: "POST", Summary: "Add a user group", Access: openapi.Admin,
+			Description: "Products and documents listed by a group are only retrieved for its members (matched by verified email or domain); a document listed itself follows its own groups rather than its product's.",
+			Request:     groupRequest, Response: usergroup.Group{}})
+	users.Route("/api/admin/user-groups/",
+		openapi.Operation{Method: "GET", Path: "/api/admin/user-groups/{id}", Summary: "Get a user group", Access: openapi.Admin, Response: usergroup.Group{}},
+		openapi.Operation{Method: "PUT", Path: "/api/admin/user-groups/{id}", Summary: "Replace a user group", Access: openapi.Admin, Request: groupRequest, Response: usergroup.Group{}},
+		openapi.Operation{Method: "DELETE", Path: "/api/admin/user-groups/{id}", Summary: "Remove a user group", Access: openapi.Admin})
+
+	security := doc.Group("Login security")
+	security.Route("/api/admin/bans",
+		openapi.Operation{Method: "GET", Summary: "List login bans", Access: openapi.SuperAdmin, Response: openapi.Props{"bans": []auth.BanEntry{}}})
+	security.Route("/api/admin/bans/unban",
+		openapi.Operation{Method: "POST", Summary: "Lift a login ban", Access: openapi.SuperAdmin, Request: openapi.Props{"username": "", "ip": ""}})
+	security.Route("/api/admin/bans/add",
+		openapi.Operation{Method: "POST", Summary: "Ban a username or IP from logging in", Access: openapi.SuperAdmin,
+			Request: openapi.Props{"username": "", "ip": "", "reason": "", "days": 0}})
+	security.Route("/api/admin/lockouts",
+		openapi.Operation{Method: "GET", Summary: "Active lockouts and their actions", Access: openapi.SuperAdmin,
+			Response: openapi.Props{"lockouts": []handler.LockoutEntry{}}},
+		openapi.Operation{Method: "POST", Summary: "Unban, unlock or notify a lockout", Access: openapi.SuperAdmin,
+			Request: openapi.Props{"action": "", "username": "", "ip": ""}})
+	security.Route("/api/admin/abuse/networks",
+		openapi.Operation{Method: "GET", Summary: "Networks with the most rejected requests", Access: openapi.SuperAdmin,
+			Query: openapi.Query("days:integer", "by", "limit:integer"), Response: openapi.Props{"networks": []abuse.NetworkStat{}}})
+	security.Route("/api/admin/abuse/bans",
+		openapi.Operation{Method: "GET", Summary: "Network bans and blocked ASNs", Access: openapi.SuperAdmin,
+			Response: openapi.Props{"bans": []abuse.Ban{}, "asns": []string{}}},
+		openapi.Operation{Method: "POST", Summary: "Ban a network or block an ASN", Access: openapi.SuperAdmin,
+			Request: openapi.Props{"network": "", "asn": "", "reason": "", "days": 0}, Response: abuse.Ban{}},
+		openapi.Operation{Method: "DELETE", Summary: "Remove a network ban or unblock an ASN", Access: openapi.SuperAdmin, Query: openapi.Query("id:integer", "asn")})
+
+	products := doc.Group("Products")
+	productRequest := openapi.Props{"name": "", "type": "", "description": "", "welcome_message": "", "allow_download": false}
+	products.Route("/api/products",
+		openapi.Operation{Method: "GET", Summary: "List products", Response: openapi.Props{"products": []product.Product{}}},
+		openapi.Operation{Method: "POST", Summary: "Create a product", Access: openapi.SuperAdmin, Request: productRequest, Response: product.Product{}})
+	products.Route("/api/products/my",
+		openapi.Operation{Method: "GET", Summary: "Products the signed-in admin manages", Access: openapi.Admin, Response: openapi.Props{"products": []product.Product{}}})
+	products.Route("/api/products/",
+		openapi.Operation{Method: "PUT", Path: "/api/products/{id}", Summary: "Update a product", Access: openapi.SuperAdmin,
+			Request: productRequest, Response: product.Product{}},
+		openapi.Operation{Method: "DELETE", Path: "/api/products/{id}", Summary: "Delete a product", Access: openapi.SuperAdmin,
+			Description: "Responds 409 with the document count unless confirm=true when the product still has documents.",
+			Query:       openapi.Query("confirm")},
+		openapi.Operation{Method: "GET", Path: "/api/products/{id}/stats", Summary: "Knowledge base health of a product", Access: openapi.Admin,
+			Description: "Document and chunk counts, last import time, the month's query volume and pending ratio, and the unanswered topics of the latest gap report. Requires view_analytics on the product.",
+			Query:       openapi.Query("period"), Response: handler.ProductStats{}},
+		openapi.Operation{Method: "GET", Path: "/api/products/{id}/escalation", Summary: "Confidence below which answers are escalated", Access: openapi.Admin,
+			Response: openapi.Props{"threshold": 0.0}},
+		openapi.Operation{Method: "PUT", Path: "/api/products/{id}/escalation", Summary: "Set the escalation threshold", Access: openapi.SuperAdmin,
+			Description: "Answers whose confidence is below threshold (0-1) are returned flagged as unverified and also sent to the pending queue. 0 turns escalation off.",
+			Request:     openapi.Props{"threshold": 0.0}, Response: openapi.Props{"threshold": 0.0}},
+		openapi.Operation{Method: "GET", Path: "/api/products/{id}/widget-origins", Summary: "Origins allowed to embed the widget", Access: openapi.Admin,
+			Response: openapi.Props{"origins": []string{}}},
+		openapi.Operation{Method: "PUT", Path: "/api/products/{id}/widget-origins", Summary: "Set the widget origin allowlist", Access: openapi.SuperAdmin,
+			Request: openapi.Props{"origins": []string{}}, Response: openapi.Props{"origins": []string{}}})
+
+	return doc
+}
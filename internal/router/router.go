@@ -4,31 +4,77 @@
 package router
 
 import (
+	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"askflow/internal/handler"
 	"askflow/internal/middleware"
+	"askflow/internal/rbac"
 )
 
 // Register registers all API routes to http.DefaultServeMux.
 // It creates middleware instances internally and groups routes by business domain.
 // Returns a cleanup function that should be called on shutdown to stop background goroutines.
 func Register(app *handler.App) func() {
-	// Build the secure API middleware chain: SecurityHeaders + CORS + RequestID
+	// Build the secure API middleware chain: message language + SecurityHeaders + network blocking + CORS + RequestID + CSRF
 	secureAPI := middleware.Chain(
-		middleware.SecurityHeaders(),
+		handler.Localize(app),
+		middleware.SecurityHeaders(app.HSTSMaxAge),
+		handler.BlockAbuse(app),
+		middleware.BodyLimit(handler.RequestBodyLimit(app)),
 		middleware.CORS(),
 		middleware.RequestID(),
+		middleware.CSRF(handler.SessionCookieName, handler.AdminSessionCookieName,
+			handler.RefreshCookieName, handler.AdminRefreshCookieName),
 	)
 
-	// Auth rate limiter: 10 attempts per minute per IP
-	authRL := middleware.NewRateLimiter(10, 1*time.Minute)
-	rateLimit := authRL.Limit()
+	// Rate limiters per endpoint group: per signed-in user, or per IP for
+	// anonymous requests, with limits per tier from rate_limit.* in config
+	identity := handler.RateLimitIdentity(app)
+	groupLimit := func(group string) func(tier string) int {
+		return func(tier string) int { return app.RateLimits().PerMinute(group, tier) }
+	}
+	authRL := middleware.NewDynamicRateLimiter(groupLimit("auth"), 1*time.Minute)
+	rateLimit := authRL.LimitBy(identity)
+	queryRL := middleware.NewDynamicRateLimiter(groupLimit("query"), 1*time.Minute)
+	queryRateLimit := queryRL.LimitBy(identity)
+	uploadRL := middleware.NewDynamicRateLimiter(groupLimit("upload"), 1*time.Minute)
+	uploadRateLimit := uploadRL.LimitBy(identity)
+
+	// API rate limiter for non-auth endpoints like translate
+	apiRL := middleware.NewDynamicRateLimiter(groupLimit("api"), 1*time.Minute)
+	apiRateLimit := apiRL.LimitBy(identity)
+
+	// Widget rate limiter for embedded chat widgets
+	widgetRL := middleware.NewDynamicRateLimiter(groupLimit("widget"), 1*time.Minute)
+	widgetRateLimit := widgetRL.LimitBy(identity)
+
+	// Rejected requests feed the offending network report. With a shared
+	// cache the counts are kept there, for all instances together.
+	limiters := map[string]*middleware.RateLimiter{
+		"auth": authRL, "query": queryRL, "upload": uploadRL, "api": apiRL, "widget": widgetRL,
+	}
+	for name, rl := range limiters {
+		rl.OnReject(handler.RecordRateLimited(app))
+		if c := app.SharedCache(); c != nil {
+			rl.Share(c, name)
+		}
+	}
 
-	// API rate limiter: 60 requests per minute per IP (for non-auth endpoints like translate)
-	apiRL := middleware.NewRateLimiter(60, 1*time.Minute)
-	apiRateLimit := apiRL.Limit()
+	// Widget chain: cross-origin access restricted to each product's origin allowlist
+	widgetAPI := middleware.Chain(
+		handler.Localize(app),
+		middleware.SecurityHeaders(app.HSTSMaxAge),
+		handler.BlockAbuse(app),
+		middleware.BodyLimit(handler.RequestBodyLimit(app)),
+		middleware.WidgetCORS(func(r *http.Request, origin string) bool {
+			productID := r.URL.Query().Get("product_id")
+			return handler.IsValidHexID(productID) && app.IsWidgetOriginAllowed(productID, origin)
+		}),
+		middleware.RequestID(),
+	)
 
 	// Helper to apply secureAPI chain
 	secure := func(h http.HandlerFunc) http.HandlerFunc {
@@ -40,138 +86,306 @@ func Register(app *handler.App) func() {
 		return secureAPI(rateLimit(h))
 	}
 
+	// Helper to apply secureAPI + query rate limit
+	secureQueryRL := func(h http.HandlerFunc) http.HandlerFunc {
+		return secureAPI(queryRateLimit(h))
+	}
+
 	// Helper to apply secureAPI + API rate limit
 	secureAPIRL := func(h http.HandlerFunc) http.HandlerFunc {
 		return secureAPI(apiRateLimit(h))
 	}
 
+	// Helper to apply secureAPI + admin permission check
+	securePerm := func(perm string, h http.HandlerFunc) http.HandlerFunc {
+		return secureAPI(handler.RequirePermission(app, perm, h))
+	}
+
+	// Helper to apply secureAPI + audit logging of admin mutations
+	audited := func(action string, snapshot handler.AuditSnapshot, h http.HandlerFunc) http.HandlerFunc {
+		return secureAPI(handler.AuditLog(app, action, snapshot)(h))
+	}
+
+	// Helper to restrict instance-wide endpoints to the default workspace
+	global := handler.DefaultTenantOnly
+
+	// Register a route and note it for the OpenAPI document
+	doc := APIDoc()
+	handle := func(pattern string, h http.HandlerFunc) {
+		doc.Registered(pattern)
+		http.HandleFunc(pattern, h)
+	}
+
 	// ── OAuth ──
-	http.HandleFunc("/api/oauth/url", secure(handler.HandleOAuthURL(app)))
-	http.HandleFunc("/api/oauth/callback", secureRL(handler.HandleOAuthCallback(app)))
-	http.HandleFunc("/api/oauth/providers/", secure(handler.HandleOAuthProviderDelete(app)))
+	handle("/api/oauth/url", secure(handler.HandleOAuthURL(app)))
+	handle("/api/oauth/callback", secureRL(handler.HandleOAuthCallback(app)))
+	handle("/api/oauth/providers/", audited("oauth_provider", nil, global(handler.HandleOAuthProviderDelete(app))))
+
+	// ── Enterprise SSO (OIDC / SAML) ──
+	handle("/api/sso/login", secureRL(handler.HandleSSOLogin(app)))
+	handle("/api/sso/oidc/callback", secureRL(handler.HandleOIDCCallback(app)))
+	handle("/api/sso/saml/acs/", secureRL(handler.HandleSAMLACS(app)))
+	handle("/api/sso/saml/metadata/", secure(handler.HandleSAMLMetadata(app)))
+	handle("/api/sso/exchange", secureRL(handler.HandleSSOExchange(app)))
+	handle("/api/sso/providers/", audited("sso_provider", nil, global(handler.HandleSSOProviderDelete(app))))
 
 	// ── Admin login ──
-	http.HandleFunc("/api/admin/login", secureRL(handler.HandleAdminLogin(app)))
-	http.HandleFunc("/api/admin/anonymous-login", secureRL(handler.HandleAnonymousLogin(app)))
-	http.HandleFunc("/api/admin/setup", secureRL(handler.HandleAdminSetup(app)))
-	http.HandleFunc("/api/admin/status", secure(handler.HandleAdminStatus(app)))
+	handle("/api/admin/login", secureRL(handler.HandleAdminLogin(app)))
+	handle("/api/admin/anonymous-login", secureRL(handler.HandleAnonymousLogin(app)))
+	handle("/api/admin/setup", secureRL(global(handler.HandleAdminSetup(app))))
+	handle("/api/admin/logout", secure(handler.HandleAdminLogout(app)))
+	handle("/api/admin/status", secure(handler.HandleAdminStatus(app)))
+	handle("/api/admin/invite/accept", secureRL(handler.HandleAcceptAdminInvite(app)))
 
 	// ── User registration & login ──
-	http.HandleFunc("/api/auth/register", secureRL(handler.HandleRegister(app)))
-	http.HandleFunc("/api/auth/login", secureRL(handler.HandleUserLogin(app)))
-	http.HandleFunc("/api/auth/anonymous-login", secureRL(handler.HandleAnonymousFrontendLogin(app)))
-	http.HandleFunc("/api/auth/verify", secure(handler.HandleVerifyEmail(app)))
-	http.HandleFunc("/api/auth/forgot-password", secureRL(handler.HandleForgotPassword(app)))
-	http.HandleFunc("/api/auth/reset-password", secureRL(handler.HandleResetPassword(app)))
-	http.HandleFunc("/api/auth/sn-login", secureRL(handler.HandleSNLogin(app)))
-	http.HandleFunc("/api/auth/ticket-exchange", secureRL(handler.HandleTicketExchange(app)))
-	http.HandleFunc("/auth/ticket-login", handler.HandleTicketLogin(app))
-	http.HandleFunc("/api/captcha", secure(handler.HandleCaptcha()))
-	http.HandleFunc("/api/captcha/image", secureRL(handler.HandleCaptchaImage()))
+	handle("/api/auth/register", secureRL(handler.HandleRegister(app)))
+	handle("/api/auth/login", secureRL(handler.HandleUserLogin(app)))
+	handle("/api/auth/anonymous-login", secureRL(handler.HandleAnonymousFrontendLogin(app)))
+	handle("/api/auth/logout", secure(handler.HandleLogout(app)))
+	handle("/api/auth/refresh", secureAPIRL(handler.HandleRefreshSession(app)))
+	handle("/api/auth/verify", secure(handler.HandleVerifyEmail(app)))
+	handle("/api/auth/forgot-password", secureRL(handler.HandleForgotPassword(app)))
+	handle("/api/auth/reset-password", secureRL(handler.HandleResetPassword(app)))
+	handle("/api/auth/forgot", secureRL(handler.HandleForgotPassword(app)))
+	handle("/api/auth/reset", secureRL(handler.HandleResetPassword(app)))
+	handle("/api/auth/unlock", secureRL(handler.HandleUnlockAccount(app)))
+	handle("/api/auth/change-password", secureRL(handler.HandleChangePassword(app)))
+	handle("/api/auth/account", secureRL(handler.HandleDeleteAccount(app)))
+	handle("/api/auth/me/export", secureRL(handler.HandleExportMyData(app)))
+	handle("/api/auth/sessions", secureRL(handler.HandleSessions(app)))
+	handle("/api/auth/sessions/", secureRL(handler.HandleSessionByID(app)))
+	handle("/api/auth/sn-login", secureRL(handler.HandleSNLogin(app)))
+	handle("/api/auth/ticket-exchange", secureRL(handler.HandleTicketExchange(app)))
+	handle("/auth/ticket-login", handler.HandleTicketLogin(app))
+	handle("/api/captcha", secure(handler.HandleCaptcha()))
+	handle("/api/captcha/image", secureRL(handler.HandleCaptchaImage()))
 
 	// ── Public info (product) ──
-	http.HandleFunc("/api/product-intro", secure(handler.HandleProductIntro(app)))
-	http.HandleFunc("/api/app-info", secure(handler.HandleAppInfo(app)))
-	http.HandleFunc("/api/translate-product-name", secureAPIRL(handler.HandleTranslateProductName(app)))
+	handle("/api/product-intro", secure(handler.HandleProductIntro(app)))
+	handle("/api/app-info", secure(handler.HandleAppInfo(app)))
+	handle("/api/faq", secure(handler.HandleFAQ(app)))
+	handle("/api/translate-product-name", secureAPIRL(handler.HandleTranslateProductName(app)))
 
 	// ── Query ──
-	http.HandleFunc("/api/query", secureRL(handler.HandleQuery(app)))
+	handle("/api/query", secureQueryRL(handler.HandleQuery(app)))
+	handle("/api/query/feedback", secureQueryRL(handler.HandleQueryFeedback(app)))
+	handle("/api/query/", secureAPIRL(handler.HandleQueryExport(app)))
+
+	// ── Embeddable widget ──
+	handle("/api/widget.js", widgetAPI(handler.ServeWidgetScript("frontend/dist")))
+	handle("/api/widget/session", widgetAPI(widgetRateLimit(handler.HandleWidgetSession(app))))
+	handle("/api/widget/query", widgetAPI(widgetRateLimit(handler.HandleWidgetQuery(app))))
+
+	// ── External messaging channels ──
+	handle("/api/channel/telegram", secure(global(handler.HandleTelegramWebhook(app))))
+	handle("/api/channel/wechat", secure(global(handler.HandleWeChatWebhook(app))))
 
 	// ── User preferences ──
-	http.HandleFunc("/api/user/preferences", secure(handler.HandleUserPreferences(app)))
+	handle("/api/user/preferences", secure(handler.HandleUserPreferences(app)))
 
 	// ── Documents ──
-	http.HandleFunc("/api/documents/public-download/", secure(handler.HandlePublicDocumentDownload(app)))
-	http.HandleFunc("/api/documents/upload", secure(handler.HandleDocumentUpload(app)))
-	http.HandleFunc("/api/documents/url/preview", secure(handler.HandleDocumentURLPreview(app)))
-	http.HandleFunc("/api/documents/url", secure(handler.HandleDocumentURL(app)))
-	http.HandleFunc("/api/documents", secure(handler.HandleDocuments(app)))
-	http.HandleFunc("/api/documents/", secure(handler.HandleDocumentByID(app)))
+	handle("/api/documents/public-download/", secure(handler.HandlePublicDocumentDownload(app)))
+	handle("/api/documents/upload", audited("document.upload", nil, uploadRateLimit(handler.RequirePermission(app, rbac.PermManageDocs, handler.HandleDocumentUpload(app)))))
+	// Resumable uploads: chunks are not rate limited, only opening and completing
+	handle("/api/documents/uploads", secureAPI(uploadRateLimit(handler.RequirePermission(app, rbac.PermManageDocs, handler.HandleUploadSessions(app)))))
+	uploadSession := secureAPI(handler.RequirePermission(app, rbac.PermManageDocs, handler.HandleUploadSessionByID(app)))
+	uploadComplete := audited("document.upload", nil, uploadRateLimit(handler.RequirePermission(app, rbac.PermManageDocs, handler.HandleUploadSessionByID(app))))
+	handle("/api/documents/uploads/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/complete") {
+			uploadComplete(w, r)
+			return
+		}
+		uploadSession(w, r)
+	})
+	handle("/api/documents/url/preview", securePerm(rbac.PermManageDocs, handler.HandleDocumentURLPreview(app)))
+	handle("/api/documents/url", audited("document.upload_url", nil, uploadRateLimit(handler.RequirePermission(app, rbac.PermManageDocs, handler.HandleDocumentURL(app)))))
+	handle("/api/documents", securePerm(rbac.PermManageDocs, handler.HandleDocuments(app)))
+	documentByID := audited("document", handler.DocumentAuditSnapshot(app), handler.RequireProductPermission(app, rbac.PermManageDocs, handler.DocumentProduct, handler.HandleDocumentByID(app)))
+	documentDownload := secure(handler.HandleDocumentDownload(app))
+	documentTranscript := secure(handler.HandleDocumentTranscript(app))
+	handle("/api/documents/", func(w http.ResponseWriter, r *http.Request) {
+		// Downloads and transcripts are open to end users and check permissions themselves.
+		if strings.HasSuffix(r.URL.Path, "/download") {
+			documentDownload(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/transcript") {
+			documentTranscript(w, r)
+			return
+		}
+		documentByID(w, r)
+	})
+	// Confluence, Notion and Git pages synced into documents
+	handle("/api/admin/connectors", audited("connector", nil, global(handler.HandleAdminConnectors(app))))
+	handle("/api/admin/connectors/", audited("connector", nil, global(handler.HandleAdminConnectorByID(app))))
+	handle("/api/connectors/oauth/callback", secureRL(handler.HandleConnectorOAuthCallback(app)))
+	handle("/api/connectors/", secure(global(handler.HandleConnectorEvents(app))))
 
 	// ── Pending questions ──
-	http.HandleFunc("/api/pending/answer", secure(handler.HandlePendingAnswer(app)))
-	http.HandleFunc("/api/pending/create", secure(handler.HandlePendingCreate(app)))
-	http.HandleFunc("/api/pending/", secure(handler.HandlePendingByID(app)))
-	http.HandleFunc("/api/pending", secure(handler.HandlePending(app)))
+	handle("/api/pending/answer", audited("pending.answer", nil, handler.RequirePermission(app, rbac.PermAnswerPending, handler.HandlePendingAnswer(app))))
+	handle("/api/pending/create", secure(handler.HandlePendingCreate(app)))
+	handle("/api/pending/", audited("pending", nil, handler.RequireProductPermission(app, rbac.PermAnswerPending, handler.PendingQuestionProduct, handler.HandlePendingByID(app))))
+	handle("/api/pending", securePerm(rbac.PermAnswerPending, handler.HandlePending(app)))
+	handle("/api/admin/pending/overdue", securePerm(rbac.PermAnswerPending, handler.HandlePendingOverdue(app)))
+	handle("/api/admin/pending/sla", audited("pending_sla", nil, global(handler.HandleAdminPendingSLA(app))))
 
 	// ── Config ──
-	http.HandleFunc("/api/config", secure(handler.HandleConfigWithRole(app)))
+	handle("/api/config", audited("config", handler.ConfigAuditSnapshot(app), global(handler.HandleConfigWithRole(app))))
+	handle("/api/config/validate", securePerm(rbac.PermManageConfig, global(handler.HandleConfigValidate(app))))
 
 	// ── System ──
-	http.HandleFunc("/api/system/status", secure(handler.HandleSystemStatus(app)))
+	handle("/api/system/status", secure(handler.HandleSystemStatus(app)))
+	handle("/api/openapi.json", secure(handler.HandleOpenAPI(app, doc)))
 
-	// ── Health check ──
-	http.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			handler.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
-			return
-		}
-		handler.WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
-	})
+	// ── Health checks (liveness / readiness probes) ──
+	handle("/healthz", handler.HandleHealthz(app))
+	handle("/readyz", handler.HandleReadyz(app))
+	handle("/api/health", handler.HandleHealthz(app))
+
+	// ── Remote video workers (bearer video.workers.token) ──
+	handle("/api/video-worker/claim", secure(handler.HandleVideoWorkerClaim(app)))
+	handle("/api/video-worker/jobs/", secure(handler.HandleVideoWorkerJob(app)))
+
+	// ── Read-only replicas (bearer replica.token) ──
+	handle("/api/replica/pending", secure(handler.HandleReplicaPending(app)))
+	handle("/api/replica/usage", secure(handler.HandleReplicaUsage(app)))
+	handle("/api/replica/answers", secure(handler.HandleReplicaAnswer(app)))
 
 	// ── LLM / Embedding test (admin only) ──
-	http.HandleFunc("/api/test/llm", secure(handler.HandleTestLLM(app)))
-	http.HandleFunc("/api/test/embedding", secure(handler.HandleTestEmbedding(app)))
+	handle("/api/test/llm", securePerm(rbac.PermManageConfig, global(handler.HandleTestLLM(app))))
+	handle("/api/test/embedding", securePerm(rbac.PermManageConfig, global(handler.HandleTestEmbedding(app))))
 
 	// ── Email test ──
-	http.HandleFunc("/api/email/test", secureRL(handler.HandleEmailTest(app)))
+	handle("/api/email/test", secureAPI(rateLimit(handler.RequirePermission(app, rbac.PermManageConfig, global(handler.HandleEmailTest(app))))))
 
 	// ── Video ──
-	http.HandleFunc("/api/video/check-deps", secure(handler.HandleVideoCheckDeps(app)))
-	http.HandleFunc("/api/video/validate-rapidspeech", secure(handler.HandleValidateRapidSpeech(app)))
-	http.HandleFunc("/api/video/auto-setup/check", secure(handler.HandleVideoAutoSetupCheck(app)))
-	http.HandleFunc("/api/video/auto-setup", secure(handler.HandleVideoAutoSetup(app)))
+	handle("/api/video/check-deps", secure(global(handler.HandleVideoCheckDeps(app))))
+	handle("/api/video/validate-rapidspeech", secure(global(handler.HandleValidateRapidSpeech(app))))
+	handle("/api/video/auto-setup/check", secure(global(handler.HandleVideoAutoSetupCheck(app))))
+	handle("/api/video/auto-setup", audited("video.auto_setup", nil, global(handler.HandleVideoAutoSetup(app))))
 
 	// ── Admin sub-accounts ──
-	http.HandleFunc("/api/admin/users", secure(handler.HandleAdminUsers(app)))
-	http.HandleFunc("/api/admin/users/", secure(handler.HandleAdminUserByID(app)))
-	http.HandleFunc("/api/admin/role", secure(handler.HandleAdminRole(app)))
+	handle("/api/admin/users", audited("admin_user", nil, handler.HandleAdminUsers(app)))
+	handle("/api/admin/users/", audited("admin_user", nil, handler.HandleAdminUserByID(app)))
+	handle("/api/admin/role", secure(handler.HandleAdminRole(app)))
+	handle("/api/admin/roles", audited("role", nil, global(handler.HandleAdminRoles(app))))
+	handle("/api/admin/roles/", audited("role", nil, global(handler.HandleAdminRoleByID(app))))
+
+	// ── Tenant workspaces (super admin of the default workspace only) ──
+	handle("/api/admin/tenants", audited("tenant", nil, global(handler.HandleAdminTenants(app))))
+	handle("/api/admin/tenants/", audited("tenant", nil, global(handler.HandleAdminTenantByID(app))))
+
+	// ── Audit log (super admin only) ──
+	handle("/api/admin/audit", secure(global(handler.HandleAdminAudit(app))))
+
+	// Usage accounting
+	handle("/api/admin/usage", secure(global(handler.HandleAdminUsage(app))))
+	handle("/api/admin/usage/quotas", audited("usage_quota", nil, global(handler.HandleAdminUsageQuotas(app))))
+
+	// Retrieval experiments
+	handle("/api/admin/experiments", audited("experiment", nil, global(handler.HandleAdminExperiments(app))))
+	handle("/api/admin/experiments/", audited("experiment", nil, global(handler.HandleAdminExperimentByID(app))))
+
+	// Knowledge gap reports
+	handle("/api/admin/gaps", audited("gap_report", nil, global(handler.HandleAdminGaps(app))))
+	handle("/api/admin/gaps/", audited("gap_report", nil, global(handler.HandleAdminGapByID(app))))
+	handle("/api/admin/faq", audited("faq", nil, global(handler.HandleAdminFAQ(app))))
+
+	// Content moderation
+	handle("/api/admin/moderation/policies", audited("moderation_policy", nil, global(handler.HandleAdminModerationPolicies(app))))
+	handle("/api/admin/moderation/queue", secure(global(handler.HandleAdminModerationQueue(app))))
+	handle("/api/admin/moderation/queue/", audited("moderation_review", nil, global(handler.HandleAdminModerationQueueItem(app))))
+
+	// "Do not answer" list
+	handle("/api/admin/refusals", audited("refusal_rule", nil, global(handler.HandleAdminRefusals(app))))
+	handle("/api/admin/refusals/test", secure(global(handler.HandleAdminRefusalTest(app))))
+	handle("/api/admin/query/debug", secure(global(handler.HandleAdminQueryDebug(app))))
+	handle("/api/admin/refusals/", audited("refusal_rule", nil, global(handler.HandleAdminRefusalByID(app))))
+
+	// User groups and the documents restricted to them
+	handle("/api/admin/user-groups", audited("user_group", nil, global(handler.HandleAdminUserGroups(app))))
+	handle("/api/admin/user-groups/", audited("user_group", nil, global(handler.HandleAdminUserGroupByID(app))))
+
+	// ── Webhooks (super admin only) ──
+	handle("/api/admin/webhooks", audited("webhook", nil, global(handler.HandleAdminWebhooks(app))))
+	handle("/api/admin/webhooks/", audited("webhook", nil, global(handler.HandleAdminWebhookByID(app))))
+
+	// ── Backups (super admin only) ──
+	handle("/api/admin/backup", audited("backup.run", nil, global(handler.HandleAdminBackup(app))))
+
+	// ── Database maintenance (super admin only) ──
+	handle("/api/admin/maintenance", audited("maintenance.run", nil, global(handler.HandleAdminMaintenance(app))))
+
+	// ── Background job locks (super admin only) ──
+	handle("/api/admin/locks", secure(global(handler.HandleAdminLocks(app))))
+
+	// ── Background jobs (super admin only) ──
+	handle("/api/admin/jobs", secure(global(handler.HandleAdminJobs(app))))
+	handle("/api/admin/jobs/", audited("job", nil, global(handler.HandleAdminJobByID(app))))
+
+	// ── Embedding queue (super admin only) ──
+	handle("/api/admin/embedding/queue", secure(global(handler.HandleAdminEmbeddingQueue(app))))
+
+	// ── Query admission queue (super admin only) ──
+	handle("/api/admin/query/queue", secure(global(handler.HandleAdminQueryQueue(app))))
 
 	// ── Customer management ──
-	http.HandleFunc("/api/admin/customers", secure(handler.HandleAdminCustomers(app)))
-	http.HandleFunc("/api/admin/customers/verify", secure(handler.HandleAdminCustomerVerify(app)))
-	http.HandleFunc("/api/admin/customers/ban", secure(handler.HandleAdminCustomerBan(app)))
-	http.HandleFunc("/api/admin/customers/unban", secure(handler.HandleAdminCustomerUnban(app)))
-	http.HandleFunc("/api/admin/customers/delete", secure(handler.HandleAdminCustomerDelete(app)))
+	handle("/api/admin/customers", secure(global(handler.HandleAdminCustomers(app))))
+	handle("/api/admin/customers/verify", audited("customer.verify", nil, global(handler.HandleAdminCustomerVerify(app))))
+	handle("/api/admin/customers/ban", audited("customer.ban", nil, global(handler.HandleAdminCustomerBan(app))))
+	handle("/api/admin/customers/unban", audited("customer.unban", nil, global(handler.HandleAdminCustomerUnban(app))))
+	handle("/api/admin/customers/delete", audited("customer.delete", nil, global(handler.HandleAdminCustomerDelete(app))))
+	handle("/api/admin/endusers", secure(global(handler.HandleAdminEndUsers(app))))
+	handle("/api/admin/endusers/", audited("enduser.purge", nil, global(handler.HandleAdminEndUserByID(app))))
 
 	// ── Login ban management ──
-	http.HandleFunc("/api/admin/bans", secure(handler.HandleAdminBans(app)))
-	http.HandleFunc("/api/admin/bans/unban", secure(handler.HandleAdminUnban(app)))
-	http.HandleFunc("/api/admin/bans/add", secure(handler.HandleAdminAddBan(app)))
+	handle("/api/admin/bans", secure(global(handler.HandleAdminBans(app))))
+	handle("/api/admin/bans/unban", audited("login_ban.remove", nil, global(handler.HandleAdminUnban(app))))
+	handle("/api/admin/bans/add", audited("login_ban.add", nil, global(handler.HandleAdminAddBan(app))))
+	handle("/api/admin/lockouts", audited("login_lockout", nil, global(handler.HandleAdminLockouts(app))))
+	handle("/api/admin/abuse/networks", secure(global(handler.HandleAdminAbuseNetworks(app))))
+	handle("/api/admin/abuse/bans", audited("network_ban", nil, global(handler.HandleAdminAbuseBans(app))))
 
 	// ── Products ──
-	http.HandleFunc("/api/products/my", secure(handler.HandleMyProducts(app)))
-	http.HandleFunc("/api/products/", secure(handler.HandleProductByID(app)))
-	http.HandleFunc("/api/products", secure(handler.HandleProducts(app)))
+	handle("/api/products/my", secure(handler.HandleMyProducts(app)))
+	handle("/api/products/", audited("product", handler.ProductAuditSnapshot(app), handler.HandleProductByID(app)))
+	handle("/api/products", audited("product", nil, handler.HandleProducts(app)))
 
 	// ── Knowledge ──
-	http.HandleFunc("/api/knowledge", secure(handler.HandleKnowledgeEntry(app)))
+	handle("/api/knowledge", audited("knowledge.create", nil, handler.RequirePermission(app, rbac.PermManageDocs, handler.HandleKnowledgeEntry(app))))
 
 	// ── Image upload ──
-	http.HandleFunc("/api/images/upload", secure(handler.HandleImageUpload(app)))
+	handle("/api/images/upload", secureAPI(uploadRateLimit(handler.RequirePermission(app, rbac.PermManageDocs, handler.HandleImageUpload(app)))))
 
 	// ── Video upload ──
-	http.HandleFunc("/api/videos/upload", secure(handler.HandleKnowledgeVideoUpload(app)))
+	handle("/api/videos/upload", secureAPI(uploadRateLimit(handler.RequirePermission(app, rbac.PermManageDocs, handler.HandleKnowledgeVideoUpload(app)))))
 
 	// ── Static file serving (public, but with security headers) ──
-	http.HandleFunc("/api/images/", secure(handler.ServeImages()))
-	http.HandleFunc("/api/videos/knowledge/", secure(handler.ServeKnowledgeVideos()))
+	handle("/api/images/", secure(handler.HandleImages(app)))
+	handle("/api/videos/knowledge/", secure(handler.HandleKnowledgeVideos(app)))
 
 	// ── Batch import (SSE streaming) ──
-	http.HandleFunc("/api/batch-import", secure(handler.HandleBatchImport(app)))
+	handle("/api/batch-import", audited("document.batch_import", nil, global(uploadRateLimit(handler.RequirePermission(app, rbac.PermManageDocs, handler.HandleBatchImport(app))))))
 
 	// ── Log management (admin only) ──
-	http.HandleFunc("/api/logs/recent", secure(handler.HandleLogsRecent(app)))
-	http.HandleFunc("/api/logs/rotation", secure(handler.HandleLogsRotation(app)))
-	http.HandleFunc("/api/logs/download", secure(handler.HandleLogsDownload(app)))
-	http.HandleFunc("/api/logs/clear", secure(handler.HandleLogsClear(app)))
+	handle("/api/logs/recent", secure(global(handler.HandleLogsRecent(app))))
+	handle("/api/logs/rotation", secure(global(handler.HandleLogsRotation(app))))
+	handle("/api/logs/download", secure(global(handler.HandleLogsDownload(app))))
+	handle("/api/logs/clear", audited("logs.clear", nil, global(handler.HandleLogsClear(app))))
 
 	// ── Public media streaming ──
-	http.HandleFunc("/api/media/", secure(handler.HandleMediaStream(app)))
+	handle("/api/media/", secure(handler.HandleMediaStream(app)))
+
+	if missing := doc.Undocumented(); len(missing) > 0 {
+		log.Printf("[OpenAPI] routes missing from the API document: %v", missing)
+	}
 
 	// Return cleanup function to stop rate limiter goroutines
 	return func() {
 		authRL.Stop()
+		queryRL.Stop()
+		uploadRL.Stop()
 		apiRL.Stop()
+		widgetRL.Stop()
 	}
 }
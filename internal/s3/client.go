@@ -0,0 +1,475 @@
+// Package s3 is a minimal client for S3-compatible object storage (AWS S3,
+// MinIO, Ceph RGW, ...) signed with AWS Signature Version 4. It covers what
+// backups, blob storage and S3 connectors need: uploading objects
+// (multipart above PartSize), reading them, listing and deleting.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned for requests on an object that does not exist.
+var ErrNotFound = errors.New("s3 object not found")
+
+// PartSize is the multipart upload part size; S3 allows at most 10000
+// parts, so archives up to ~640 GB are supported.
+const PartSize = 64 << 20
+
+// Client is an S3-compatible bucket.
+type Client struct {
+	Endpoint  string // "https://host[:port]"; empty means AWS S3 in Region
+	Region    string // signing region (default "us-east-1")
+	Bucket    string
+	Prefix    string // key prefix prepended by Key
+	AccessKey string
+	SecretKey string
+	PathStyle bool // <endpoint>/<bucket>/<key> instead of <bucket>.<host>/<key>
+
+	client *http.Client
+}
+
+// Object is an object returned by List.
+type Object struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	ETag         string    `json:"etag,omitempty"`
+}
+
+// ParseURL splits "s3://bucket/key" into bucket and key.
+func ParseURL(s string) (bucket, key string, ok bool) {
+	rest, ok := strings.CutPrefix(s, "s3://")
+	if !ok {
+		return "", "", false
+	}
+	bucket, key, _ = strings.Cut(rest, "/")
+	return bucket, key, bucket != ""
+}
+
+// URL returns the s3:// URL of key in the target bucket.
+func (t *Client) URL(key string) string {
+	return "s3://" + t.Bucket + "/" + key
+}
+
+// Key returns the object key for an archive file name.
+func (t *Client) Key(name string) string {
+	prefix := strings.TrimLeft(t.Prefix, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix + name
+}
+
+func (t *Client) validate() error {
+	if t.Bucket == "" {
+		return errors.New("s3 bucket is not configured")
+	}
+	if t.AccessKey == "" || t.SecretKey == "" {
+		return errors.New("s3 credentials are not configured")
+	}
+	return nil
+}
+
+func (t *Client) region() string {
+	if t.Region == "" {
+		return "us-east-1"
+	}
+	return t.Region
+}
+
+func (t *Client) httpClient() *http.Client {
+	if t.client == nil {
+		// No overall timeout: archives can take a long time to transfer.
+		// Callers bound requests with their context instead.
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.ResponseHeaderTimeout = 2 * time.Minute
+		t.client = &http.Client{Transport: tr}
+	}
+	return t.client
+}
+
+// objectURL returns the request URL for key ("" addresses the bucket).
+func (t *Client) objectURL(key string, query url.Values) (*url.URL, error) {
+	endpoint := t.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + t.region() + ".amazonaws.com"
+	}
+	u, err := url.Parse(strings.TrimRight(endpoint, "/"))
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", t.Endpoint)
+	}
+	path := u.Path + "/"
+	if t.PathStyle {
+		path += t.Bucket + "/"
+	} else {
+		u.Host = t.Bucket + "." + u.Host
+	}
+	path += key
+	u.Path = path
+	u.RawPath = s3Escape(path, false)
+	u.RawQuery = canonicalQuery(query)
+	return u, nil
+}
+
+// do signs and sends a request. size is the body length and payloadHash its
+// hex SHA-256 ("" for no body). Non-2xx responses are returned as errors.
+func (t *Client) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	req, err := t.newRequest(ctx, method, key, query, body, size)
+	if err != nil {
+		return nil, err
+	}
+	return t.send(req, key, payloadHash)
+}
+
+func (t *Client) newRequest(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64) (*http.Request, error) {
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	u, err := t.objectURL(key, query)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.URL = u // keep the exact encoding that was signed
+	req.ContentLength = size
+	return req, nil
+}
+
+func (t *Client) send(req *http.Request, key, payloadHash string) (*http.Response, error) {
+	if payloadHash == "" {
+		payloadHash = emptySHA256
+	}
+	t.sign(req, payloadHash, time.Now().UTC())
+
+	resp, err := t.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, s3Error(req.Method, key, resp)
+	}
+	return resp, nil
+}
+
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign adds AWS Signature Version 4 headers to req.
+func (t *Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + t.region() + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256([]byte(canonRequest))
+
+	key := hmacSHA256([]byte("AWS4"+t.SecretKey), date)
+	key = hmacSHA256(key, t.region())
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		t.AccessKey, scope, signedHeaders, signature))
+}
+
+// Upload uploads the file at path to key, using a multipart upload for
+// files larger than PartSize.
+func (t *Client) Upload(ctx context.Context, path, key string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+	if size <= PartSize {
+		_, err := t.put(ctx, key, nil, io.NewSectionReader(f, 0, size))
+		return err
+	}
+	return t.uploadMultipart(ctx, f, size, key)
+}
+
+// PutBytes uploads data as the object at key.
+func (t *Client) PutBytes(ctx context.Context, key string, data []byte) error {
+	_, err := t.put(ctx, key, nil, io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))))
+	return err
+}
+
+// put uploads body as an object, or as one part of a multipart upload when
+// query carries partNumber and uploadId, and returns the ETag.
+func (t *Client) put(ctx context.Context, key string, query url.Values, body *io.SectionReader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return "", err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	resp, err := t.do(ctx, http.MethodPut, key, query, body, body.Size(), hex.EncodeToString(h.Sum(nil)))
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+func (t *Client) uploadMultipart(ctx context.Context, f *os.File, size int64, key string) (err error) {
+	resp, err := t.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, 0, "")
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil || initiated.UploadID == "" {
+		return fmt.Errorf("s3 create multipart upload %s: invalid response", key)
+	}
+	uploadID := initiated.UploadID
+	defer func() {
+		if err != nil {
+			// Abort so the bucket does not keep the uploaded parts
+			abortCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if resp, abortErr := t.do(abortCtx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, 0, ""); abortErr == nil {
+				resp.Body.Close()
+			}
+		}
+	}()
+
+	type part struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	var parts []part
+	for off, n := int64(0), 1; off < size; off, n = off+PartSize, n+1 {
+		query := url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {uploadID}}
+		etag, err := t.put(ctx, key, query, io.NewSectionReader(f, off, min(PartSize, size-off)))
+		if err != nil {
+			return fmt.Errorf("upload part %d: %w", n, err)
+		}
+		parts = append(parts, part{PartNumber: n, ETag: etag})
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	resp, err = t.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, bytes.NewReader(body), int64(len(body)), hexSHA256(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// CompleteMultipartUpload can fail after a 200 response; the error is in the body
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(data, []byte("<Error>")) {
+		return parseS3Error(http.MethodPost, key, resp.StatusCode, data)
+	}
+	return nil
+}
+
+// Download writes the object at key to the file at path.
+func (t *Client) Download(ctx context.Context, key, path string) error {
+	resp, err := t.do(ctx, http.MethodGet, key, nil, nil, 0, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(out, resp.Body)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && resp.ContentLength >= 0 && n != resp.ContentLength {
+		err = fmt.Errorf("s3 get %s: short read (%d of %d bytes)", key, n, resp.ContentLength)
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// Open starts a GET of the object at key and returns the response, whose
+// body the caller must close. rangeHeader, if set, is sent as the Range
+// header so partial reads answer with 206.
+func (t *Client) Open(ctx context.Context, key, rangeHeader string) (*http.Response, error) {
+	req, err := t.newRequest(ctx, http.MethodGet, key, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	return t.send(req, key, "")
+}
+
+// Delete removes the object at key. Deleting a missing object is not an error.
+func (t *Client) Delete(ctx context.Context, key string) error {
+	resp, err := t.do(ctx, http.MethodDelete, key, nil, nil, 0, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List returns the objects whose keys start with prefix, in key order.
+func (t *Client) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := t.do(ctx, http.MethodGet, "", query, nil, 0, "")
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key          string `xml:"Key"`
+				Size         int64  `xml:"Size"`
+				LastModified string `xml:"LastModified"`
+				ETag         string `xml:"ETag"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3 list %s: invalid response: %w", prefix, err)
+		}
+		for _, c := range page.Contents {
+			lm, _ := time.Parse(time.RFC3339, c.LastModified)
+			objects = append(objects, Object{Key: c.Key, Size: c.Size, LastModified: lm, ETag: strings.Trim(c.ETag, `"`)})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// s3Error converts a non-2xx response into an error.
+func s3Error(method, key string, resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	return parseS3Error(method, key, resp.StatusCode, data)
+}
+
+func parseS3Error(method, key string, status int, data []byte) error {
+	if status == http.StatusNotFound {
+		return fmt.Errorf("s3 %s %s: %w", method, key, ErrNotFound)
+	}
+	var e struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return fmt.Errorf("s3 %s %s: %s: %s (HTTP %d)", method, key, e.Code, e.Message, status)
+	}
+	return fmt.Errorf("s3 %s %s: HTTP %d", method, key, status)
+}
+
+// s3Escape URI-encodes s as SigV4 requires: everything except unreserved
+// characters is percent-encoded, and "/" too unless it separates path segments.
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery encodes query sorted by key as SigV4 requires.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vals := append([]string(nil), query[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,178 @@
+// Package scan checks uploaded files for malware before they are parsed,
+// using a ClamAV daemon or an external scanning API (scan.* in config).
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"askflow/internal/config"
+)
+
+// Verdicts recorded for scanned documents.
+const (
+	StatusClean    = "clean"
+	StatusInfected = "infected"
+	// StatusError is recorded for files accepted without a verdict because
+	// the scanner failed and scan.fail_open is set.
+	StatusError = "error"
+)
+
+// clamdChunkSize is the size of the INSTREAM chunks sent to clamd.
+const clamdChunkSize = 64 << 10
+
+// Result is the verdict on one file. Signature names the malware found.
+type Result struct {
+	Status    string `json:"status"`
+	Signature string `json:"signature,omitempty"`
+	Engine    string `json:"engine"`
+}
+
+// Service scans files with the backend currently configured.
+type Service struct {
+	cfg        func() config.ScanConfig
+	httpClient *http.Client
+}
+
+// NewService creates a scan Service reading its settings from cfg on every
+// scan, so configuration changes apply to the next upload.
+func NewService(cfg func() config.ScanConfig) *Service {
+	return &Service{cfg: cfg, httpClient: &http.Client{}}
+}
+
+// Enabled reports whether uploads are scanned.
+func (s *Service) Enabled() bool {
+	return s.cfg().Enabled
+}
+
+// FailOpen reports whether files are accepted when the scanner fails.
+func (s *Service) FailOpen() bool {
+	return s.cfg().FailOpen
+}
+
+// Scan checks data, named fileName, with the configured backend. It returns
+// nil when scanning is disabled, and an error when the scanner could not
+// give a verdict.
+func (s *Service) Scan(ctx context.Context, fileName string, data []byte) (*Result, error) {
+	cfg := s.cfg()
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	timeout := time.Duration(cfg.TimeoutSec) * time.Second
+	if timeout <= 0 {
+		timeout = 60 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	switch cfg.Backend {
+	case "http":
+		return s.scanHTTP(ctx, cfg, fileName, data)
+	case "clamav", "":
+		return scanClamd(ctx, cfg.ClamAVAddress, data)
+	default:
+		return nil, fmt.Errorf("unknown scan backend %q", cfg.Backend)
+	}
+}
+
+// scanClamd streams data to clamd with the INSTREAM command. address is a
+// Unix socket path or host:port.
+func scanClamd(ctx context.Context, address string, data []byte) (*Result, error) {
+	network := "tcp"
+	if strings.HasPrefix(address, "/") {
+		network = "unix"
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, fmt.Errorf("connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	w := bufio.NewWriter(conn)
+	w.WriteString("zINSTREAM\x00")
+	var size [4]byte
+	for off := 0; off < len(data); off += clamdChunkSize {
+		chunk := data[off:min(off+clamdChunkSize, len(data))]
+		binary.BigEndian.PutUint32(size[:], uint32(len(chunk)))
+		w.Write(size[:])
+		w.Write(chunk)
+	}
+	binary.BigEndian.PutUint32(size[:], 0)
+	w.Write(size[:])
+	if err := w.Flush(); err != nil {
+		return nil, fmt.Errorf("send to clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("read clamd reply: %w", err)
+	}
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamdReply interprets "stream: OK", "stream: <signature> FOUND" and
+// "... ERROR" replies.
+func parseClamdReply(reply string) (*Result, error) {
+	msg := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case msg == "OK":
+		return &Result{Status: StatusClean, Engine: "clamav"}, nil
+	case strings.HasSuffix(msg, " FOUND"):
+		return &Result{Status: StatusInfected, Signature: strings.TrimSuffix(msg, " FOUND"), Engine: "clamav"}, nil
+	default:
+		return nil, fmt.Errorf("clamd: %s", reply)
+	}
+}
+
+// scanHTTP posts data to the scanning API, which answers
+// {"infected": bool, "signature": "..."}.
+func (s *Service) scanHTTP(ctx context.Context, cfg config.ScanConfig, fileName string, data []byte) (*Result, error) {
+	if cfg.APIURL == "" {
+		return nil, errors.New("scan.api_url is not set")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.APIURL, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("create scan request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-File-Name", fileName)
+	if cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.APIKey)
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("scan request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, fmt.Errorf("read scan response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("scan API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var verdict struct {
+		Infected  *bool  `json:"infected"`
+		Signature string `json:"signature"`
+	}
+	if err := json.Unmarshal(body, &verdict); err != nil || verdict.Infected == nil {
+		return nil, fmt.Errorf("invalid scan API response: %s", strings.TrimSpace(string(body)))
+	}
+	if *verdict.Infected {
+		return &Result{Status: StatusInfected, Signature: verdict.Signature, Engine: "http"}, nil
+	}
+	return &Result{Status: StatusClean, Engine: "http"}, nil
+}
@@ -14,23 +14,40 @@ import (
 	"sync"
 	"time"
 
+	"askflow/internal/atrest"
 	"askflow/internal/auth"
+	"askflow/internal/backup"
+	"askflow/internal/blob"
 	"askflow/internal/chunker"
 	"askflow/internal/config"
+	"askflow/internal/connector"
 	"askflow/internal/db"
 	"askflow/internal/document"
 	"askflow/internal/email"
 	"askflow/internal/embedding"
 	"askflow/internal/errlog"
+	"askflow/internal/faq"
 	"askflow/internal/fontcheck"
+	"askflow/internal/gaps"
+	"askflow/internal/grpcapi"
 	"askflow/internal/handler"
+	"askflow/internal/i18n"
+	"askflow/internal/jobs"
+	"askflow/internal/lease"
 	"askflow/internal/llm"
+	"askflow/internal/maintenance"
+	"askflow/internal/middleware"
 	"askflow/internal/parser"
 	"askflow/internal/pending"
 	"askflow/internal/product"
 	"askflow/internal/query"
+	"askflow/internal/replica"
+	"askflow/internal/sharedcache"
+	"askflow/internal/tenant"
+	"askflow/internal/upload"
 	"askflow/internal/vectorstore"
 	"askflow/internal/video"
+	"askflow/internal/webhook"
 )
 
 // AppService encapsulates the entire application initialization and lifecycle.
@@ -39,22 +56,63 @@ type AppService struct {
 	configManager   *config.ConfigManager
 	dbPair          *db.DBPair
 	sessionManager  *auth.SessionManager
+	loginLimiter    *auth.LoginLimiter
 	queryEngine     *query.QueryEngine
 	docManager      *document.DocumentManager
+	vectorStore     *vectorstore.SQLiteVectorStore
+	embeddingPool   *embedding.Pool
 	pendingManager  *pending.PendingQuestionManager
 	oauthClient     *auth.OAuthClient
+	ssoClient       *auth.SSOClient
 	emailService    *email.Service
 	productService  *product.ProductService
+	webhookService  *webhook.Service
+	backupScheduler *backup.Scheduler
+	maintenance     *maintenance.Scheduler
+	gapService      *gaps.Service
+	slaService      *pending.SLAService
+	faqService      *faq.Service
+	connectors      *connector.Service
+	uploadStore     *upload.Store
+	tenantService   *tenant.Service
+	replica         *replica.Client    // set when running as a read-only replica
+	sharedCache     *sharedcache.Cache // set when cache.backend is redis
+	locks           *lease.Manager
+	jobs            *jobs.Registry
+	app             *handler.App
+	certManager     *certManager
+	redirectServer  *http.Server
+	grpcServer      *http.Server
 	cfg             *config.Config
 	dataDir         string
+	basePath        string
 	sessionCleanup  chan struct{}
 	cleanupWg       sync.WaitGroup
+
+	// Encryption at rest (storage.encrypt): the cipher, the storage wrapped
+	// with it (nil when nothing is or was encrypted) and the marker file of
+	// the form data was last rewritten to.
+	atRest        *atrest.Cipher
+	atRestStorage *blob.Encrypted
+	atRestMarker  string
+}
+
+// ServerOverrides holds listen settings from command-line flags or the
+// environment that take precedence over the server section of config.json.
+// Zero values leave the configured setting in place.
+type ServerOverrides struct {
+	Bind       string
+	Port       int
+	ListenAddr string // "host:port", replaces both Bind and Port
+	BasePath   string
+	// ReplicaOf is the URL of the primary instance when this one runs as
+	// its read-only replica (see package replica)
+	ReplicaOf string
 }
 
 // Initialize sets up all services and prepares the application for running.
 // The dataDir parameter specifies the root data directory.
-// overrideBind and overridePort can be used to bypass settings in the config file.
-func (as *AppService) Initialize(dataDir string, overrideBind string, overridePort int) error {
+func (as *AppService) Initialize(dataDir string, overrides ServerOverrides) error {
 	as.dataDir = dataDir
 
 	// 0. Initialize error logger (/var/log/askflow/error.log)
@@ -70,6 +128,13 @@ func (as *AppService) Initialize(dataDir string, overrideBind string, overridePo
 		return fmt.Errorf("failed to create data directory: %w", err)
 	}
 
+	// Extra or overriding API message catalogs: <dataDir>/locales/<lang>.json
+	if langs, err := i18n.LoadDir(filepath.Join(dataDir, "locales")); err != nil {
+		log.Printf("[I18n] failed to load message catalogs: %v", err)
+	} else if len(langs) > 0 {
+		log.Printf("[I18n] loaded message catalogs: %s", strings.Join(langs, ", "))
+	}
+
 	// 2. Initialize ConfigManager and load config
 	configPath := filepath.Join(dataDir, "config.json")
 	cm, err := config.NewConfigManager(configPath)
@@ -88,7 +153,13 @@ func (as *AppService) Initialize(dataDir string, overrideBind string, overridePo
 	if !filepath.IsAbs(dbPath) {
 		dbPath = filepath.Join(dataDir, dbPath)
 	}
-	database, err := db.InitDB(dbPath)
+	// A read-only replica neither migrates nor writes the primary's database
+	var database *db.DBPair
+	if overrides.ReplicaOf != "" {
+		database, err = db.OpenReadOnly(dbPath)
+	} else {
+		database, err = db.InitDB(dbPath)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to initialize database: %w", err)
 	}
@@ -100,15 +171,30 @@ func (as *AppService) Initialize(dataDir string, overrideBind string, overridePo
 	readDB := database.Read
 
 	vs := vectorstore.NewSQLiteVectorStore(writeDB)
+	if overrides.ReplicaOf != "" {
+		// The snapshot file is the primary's; follow the chunks table instead
+		if _, err := vs.Refresh(); err != nil {
+			return fmt.Errorf("failed to read vector store version: %w", err)
+		}
+	} else {
+		vs.EnableSnapshot(dbPath + ".vectors")
+	}
+	if mb := as.cfg.Vector.PartitionCacheMB; mb > 0 {
+		vs.SetPartitionCache(int64(mb) << 20)
+		log.Printf("Vector cache: loading products on demand, up to %d MB", mb)
+	}
+	as.vectorStore = vs
 	log.Printf("[SIMD] Vector acceleration: %s", vectorstore.SIMDCapability())
 	tc := &chunker.TextChunker{ChunkSize: as.cfg.Vector.ChunkSize, Overlap: as.cfg.Vector.Overlap}
 	dp := &parser.DocumentParser{}
+	// One pool for all embedding requests, kept across config changes
+	as.embeddingPool = embedding.NewPool(as.cfg.Embedding.MaxConcurrency, as.cfg.Embedding.QueueLimit)
 	es := embedding.NewAPIEmbeddingService(
 		as.cfg.Embedding.Endpoint,
 		as.cfg.Embedding.APIKey,
 		as.cfg.Embedding.ModelName,
 		as.cfg.Embedding.UseMultimodal,
-	)
+	).SetBatchLimits(as.cfg.Embedding.BatchMaxItems, as.cfg.Embedding.BatchMaxTokens).SetPool(as.embeddingPool)
 	ls := llm.NewAPILLMService(
 		as.cfg.LLM.Endpoint,
 		as.cfg.LLM.APIKey,
@@ -119,9 +205,37 @@ func (as *AppService) Initialize(dataDir string, overrideBind string, overridePo
 	as.docManager = document.NewDocumentManager(dp, tc, es, vs, writeDB)
 	as.docManager.SetVideoConfig(as.cfg.Video)
 	as.docManager.SetLLMService(ls)
+	as.docManager.SetInjectionCheck(as.cfg.LLM.InjectionCheck)
+	// Search boosts from document priorities and recency
+	as.docManager.SetRankingConfig(as.cfg.Vector.RecencyHalfLifeDays, as.cfg.Vector.RecencyMinFactor)
+	// Uploads and images have always been kept under ./data, independent of
+	// the --datadir flag, so local storage stays there
+	storage, err := blob.NewBackend(as.cfg.Storage, filepath.Join(".", "data"))
+	if err != nil {
+		return fmt.Errorf("failed to initialize storage: %w", err)
+	}
+	// Data stored otherwise than storage.encrypt asks, e.g. from before it
+	// was turned on, is still read and is rewritten by Run
+	as.atRest, err = as.newAtRestCipher()
+	if err != nil {
+		return fmt.Errorf("failed to initialize encryption at rest: %w", err)
+	}
+	as.atRestMarker = dbPath + ".atrest"
+	if as.atRest.Sealing() || !as.atRest.Current(as.atRestMarker) {
+		vs.SetTextCodec(as.atRest)
+		as.atRestStorage = blob.NewEncrypted(storage, as.atRest)
+		storage = as.atRestStorage
+	}
+	as.docManager.SetStorage(storage)
+	log.Printf("[Storage] Backend: %s, encrypted at rest: %v", as.cfg.Storage.Backend, as.atRest.Sealing())
 
 	// Video dependency check
-	if as.cfg.Video.FFmpegPath != "" || as.cfg.Video.RapidSpeechPath != "" {
+	if as.cfg.Video.Workers.Enabled && as.cfg.Video.Workers.Token == "" {
+		log.Printf("Warning: video.workers.enabled is set but video.workers.token is not; videos are parsed on this instance")
+	}
+	if as.cfg.Video.Workers.Enabled && as.cfg.Video.Workers.Token != "" {
+		log.Printf("视频检索: 由远程视频处理节点解析（askflow video-worker）")
+	} else if as.cfg.Video.FFmpegPath != "" || as.cfg.Video.RapidSpeechPath != "" || as.cfg.Video.STT.Provider != video.STTRapidSpeech {
 		vp := video.NewParser(as.cfg.Video)
 		depsResult := vp.CheckDependencies()
 		statusStr := func(ok bool, errMsg string) string {
@@ -133,16 +247,27 @@ func (as *AppService) Initialize(dataDir string, overrideBind string, overridePo
 			}
 			return "不可用"
 		}
-		log.Printf("视频检索: ffmpeg=%s, rapidspeech=%s",
+		log.Printf("视频检索: ffmpeg=%s, 语音转录(%s)=%s",
 			statusStr(depsResult.FFmpegOK, depsResult.FFmpegError),
-			statusStr(depsResult.RapidSpeechOK, depsResult.RapidSpeechError))
+			depsResult.STTProvider,
+			statusStr(depsResult.STTOK, depsResult.STTError))
 	}
 
 	as.productService = product.NewProductService(readDB, writeDB)
 	as.queryEngine = query.NewQueryEngine(es, vs, ls, writeDB, readDB, as.cfg)
+	// Image similarity search, when an image embedding model is configured
+	if c := as.cfg.ImageEmbed; c.Configured() {
+		ies := embedding.NewAPIEmbeddingService(c.Endpoint, c.APIKey, c.ModelName, true)
+		as.queryEngine.SetImageEmbeddingService(ies)
+		as.docManager.SetImageEmbeddingService(ies, c.ModelName)
+	}
 	as.pendingManager = pending.NewPendingQuestionManager(writeDB, tc, es, vs, ls)
 	as.oauthClient = auth.NewOAuthClient(as.cfg.OAuth.Providers)
-	as.sessionManager = auth.NewSessionManager(readDB, writeDB, 24*time.Hour)
+	as.ssoClient = auth.NewSSOClient(as.cfg.SSO)
+	as.sessionManager = auth.NewSessionManager(readDB, writeDB, auth.DefaultSessionExpiry)
+	as.setupSharedCache()
+	// Login lockout counters, rebuilt from recent login attempts
+	as.loginLimiter = auth.NewLoginLimiterRW(readDB, writeDB)
 
 	// Create email service
 	as.emailService = email.NewService(func() config.SMTPConfig {
@@ -153,24 +278,169 @@ func (as *AppService) Initialize(dataDir string, overrideBind string, overridePo
 		return cfg.SMTP
 	})
 
+	// Webhook delivery: forward document and pending-question events
+	as.webhookService = webhook.NewService(writeDB)
+	as.docManager.SetProcessedHook(func(docID, status, errMsg string) {
+		data := map[string]string{"document_id": docID, "status": status, "error": errMsg}
+		if info, err := as.docManager.GetDocumentInfo(docID); err == nil {
+			data["name"] = info.Name
+			data["product_id"] = info.ProductID
+		}
+		as.webhookService.Emit(webhook.EventDocumentProcessed, data)
+	})
+	// Tenant workspaces (tenants.* in config) share the database; the
+	// middleware below routes each request to its tenant
+	as.tenantService = tenant.NewService(readDB, writeDB)
+	// Leases in the database keep instances sharing it from running the
+	// same background job at once
+	as.locks = lease.NewManager(readDB, writeDB)
+	// Background work is recorded in the jobs table for /api/admin/jobs
+	as.jobs = jobs.NewRegistry(readDB, writeDB, as.locks.Owner())
+	// Scheduled backups (backup.* in config) and on-demand runs from the admin API
+	as.backupScheduler = backup.NewScheduler(writeDB, dataDir, func() config.BackupConfig {
+		cfg := as.configManager.Get()
+		if cfg == nil {
+			return config.BackupConfig{}
+		}
+		return cfg.Backup
+	})
+	// Database maintenance (maintenance.* in config): VACUUM, reindex and
+	// vector cache compaction
+	as.maintenance = maintenance.NewScheduler(readDB, writeDB, as.vectorStore, func() config.MaintenanceConfig {
+		cfg := as.configManager.Get()
+		if cfg == nil {
+			return config.MaintenanceConfig{}
+		}
+		return cfg.Maintenance
+	})
+	// Knowledge gap reports (gap_report.* in config), emailed on schedule
+	as.gapService = gaps.NewService(readDB, writeDB, as.queryEngine.Services, func() config.GapReportConfig {
+		cfg := as.configManager.Get()
+		if cfg == nil {
+			return config.GapReportConfig{}
+		}
+		return cfg.GapReport
+	}, as.emailService.SendReport)
+	// Generated FAQ (faq.* in config), ingested back into the knowledge base
+	as.faqService = faq.NewService(readDB, writeDB, tc, vs, func() embedding.EmbeddingService {
+		es, _ := as.queryEngine.Services()
+		return es
+	}, func() config.FAQConfig {
+		cfg := as.configManager.Get()
+		if cfg == nil {
+			return config.FAQConfig{}
+		}
+		return cfg.FAQ
+	})
+	// Confluence, Notion, Git, Google Drive and SharePoint pages synced into
+	// documents on schedule; API and refresh tokens are encrypted with the
+	// config encryption key
+	as.connectors = connector.NewService(readDB, writeDB, as.docManager, as.configManager, filepath.Join(dataDir, "connectors"))
+	as.connectors.SetOAuthApps(func() config.ConnectorsConfig {
+		cfg := as.configManager.Get()
+		if cfg == nil {
+			return config.ConnectorsConfig{}
+		}
+		return cfg.Connectors
+	})
+	// Resumable document uploads, assembled on disk until completed
+	as.uploadStore = upload.NewStore(filepath.Join(dataDir, "uploads-partial"))
+	// Response time targets for pending questions, escalated in the background
+	as.slaService = pending.NewSLAService(readDB, writeDB, as.emailService.SendReport)
+	as.slaService.SetEscalatedHook(func(q pending.OverdueQuestion) {
+		as.webhookService.Emit(webhook.EventQuestionOverdue, map[string]interface{}{
+			"question_id":      q.ID,
+			"question":         q.Question,
+			"user_id":          q.UserID,
+			"product_id":       q.ProductID,
+			"due_at":           q.DueAt,
+			"escalation_level": q.EscalationLevel,
+		})
+	})
+	// Suggested answers for new pending questions (pending_draft.* in config)
+	as.pendingManager.SetDraftConfig(func() config.PendingDraftConfig {
+		cfg := as.configManager.Get()
+		if cfg == nil {
+			return config.PendingDraftConfig{}
+		}
+		return cfg.PendingDraft
+	})
+	as.queryEngine.SetPendingCreatedHook(func(id, question, userID, productID string) {
+		as.pendingManager.DraftAsync(id)
+		as.webhookService.Emit(webhook.EventQuestionPendingCreated, map[string]string{
+			"question_id": id,
+			"question":    question,
+			"user_id":     userID,
+			"product_id":  productID,
+		})
+	})
+	// Custom intents routed to webhooks (intent.custom in config)
+	as.queryEngine.SetIntentHook(func(intent, question, userID, productID string) {
+		as.webhookService.Emit(webhook.EventQueryIntent, map[string]string{
+			"intent":     intent,
+			"question":   question,
+			"user_id":    userID,
+			"product_id": productID,
+		})
+	})
+
+	// Read-only replica: queries are answered here, everything else goes to
+	// the primary
+	if overrides.ReplicaOf != "" {
+		as.replica, err = replica.NewClient(overrides.ReplicaOf, func() string {
+			return as.configManager.Get().Replica.Token
+		})
+		if err != nil {
+			return err
+		}
+		if as.cfg.Replica.Token == "" {
+			log.Printf("Warning: replica.token is not set; the primary will refuse pending questions and usage from this replica")
+		}
+		log.Printf("Running as a read-only replica of %s", as.replica.Primary())
+	}
+
 	// 5. Create HTTP server
-	bind := as.cfg.Server.Bind
-	if overrideBind != "" {
-		bind = overrideBind
+	bind, port := as.cfg.Server.Bind, as.cfg.Server.Port
+	if as.cfg.Server.ListenAddr != "" {
+		if bind, port, err = config.ParseListenAddr(as.cfg.Server.ListenAddr); err != nil {
+			return fmt.Errorf("invalid server.listen_addr: %w", err)
+		}
 	}
-	port := as.cfg.Server.Port
-	if overridePort > 0 {
-		port = overridePort
+	if overrides.Bind != "" {
+		bind = overrides.Bind
+	}
+	if overrides.Port > 0 {
+		port = overrides.Port
+	}
+	if overrides.ListenAddr != "" {
+		if bind, port, err = config.ParseListenAddr(overrides.ListenAddr); err != nil {
+			return err
+		}
+	}
+	basePath := as.cfg.Server.BasePath
+	if overrides.BasePath != "" {
+		basePath = overrides.BasePath
+	}
+	if as.basePath, err = config.NormalizeBasePath(basePath); err != nil {
+		return fmt.Errorf("invalid base path: %w", err)
 	}
 
-	// Format address correctly for IPv6
-	addr := fmt.Sprintf("%s:%d", bind, port)
-	if strings.Contains(bind, ":") && !strings.HasPrefix(bind, "[") {
-		addr = fmt.Sprintf("[%s]:%d", bind, port)
+	// Tenant routing sits inside the base path, so /t/<slug>/ follows it
+	mux := tenant.Middleware(as.tenantService, func() config.TenantsConfig {
+		cfg := as.configManager.Get()
+		if cfg == nil {
+			return config.TenantsConfig{}
+		}
+		return cfg.Tenants
+	}, http.DefaultServeMux)
+	if as.replica != nil {
+		mux = as.replica.Handler(mux)
 	}
 
+	// Upload handlers extend the read/write deadlines for their own request.
 	as.server = &http.Server{
-		Addr:              addr,
+		Addr:              listenAddr(bind, port),
+		Handler:           middleware.StripBasePath(as.basePath, mux),
 		ReadTimeout:       30 * time.Second,
 		ReadHeaderTimeout: 10 * time.Second,
 		WriteTimeout:      600 * time.Second,
@@ -178,9 +448,55 @@ func (as *AppService) Initialize(dataDir string, overrideBind string, overridePo
 		MaxHeaderBytes:    1 << 20, // 1MB max header size
 	}
 
+	// 6. Native HTTPS: static certificate files take precedence over autocert
+	if as.cfg.Server.AutoCert.Enabled && !as.staticTLS() {
+		cm, err := newCertManager(as.cfg.Server.AutoCert, dataDir)
+		if err != nil {
+			return fmt.Errorf("failed to set up autocert: %w", err)
+		}
+		as.certManager = cm
+		as.server.TLSConfig = cm.TLSConfig()
+	}
+	if redirectPort := as.cfg.Server.HTTPRedirectPort; redirectPort > 0 && as.tlsEnabled() {
+		var h http.Handler = httpsRedirect(port)
+		if as.certManager != nil {
+			h = as.certManager.HTTPHandler(h)
+		}
+		as.redirectServer = &http.Server{
+			Addr:              listenAddr(bind, redirectPort),
+			Handler:           h,
+			ReadTimeout:       10 * time.Second,
+			ReadHeaderTimeout: 10 * time.Second,
+			WriteTimeout:      10 * time.Second,
+			IdleTimeout:       30 * time.Second,
+			MaxHeaderBytes:    1 << 16,
+		}
+	}
+	if as.certManager != nil && port != 443 && as.redirectServer == nil {
+		log.Printf("Warning: autocert needs the HTTPS port to be 443 (TLS-ALPN-01) or server.http_redirect_port to be 80 (HTTP-01)")
+	}
+
 	return nil
 }
 
+// listenAddr formats bind and port as a listen address, bracketing IPv6 binds.
+func listenAddr(bind string, port int) string {
+	if strings.Contains(bind, ":") && !strings.HasPrefix(bind, "[") {
+		return fmt.Sprintf("[%s]:%d", bind, port)
+	}
+	return fmt.Sprintf("%s:%d", bind, port)
+}
+
+// staticTLS reports whether certificate files are configured (server.ssl_cert/ssl_key).
+func (as *AppService) staticTLS() bool {
+	return as.cfg.Server.SSLCert != "" && as.cfg.Server.SSLKey != ""
+}
+
+// tlsEnabled reports whether the server terminates HTTPS itself.
+func (as *AppService) tlsEnabled() bool {
+	return as.staticTLS() || as.certManager != nil
+}
+
 // Run starts the HTTP server and blocks until the context is cancelled.
 // Implements graceful shutdown when ctx is done.
 func (as *AppService) Run(ctx context.Context) error {
@@ -188,19 +504,107 @@ func (as *AppService) Run(ctx context.Context) error {
 		return fmt.Errorf("server not initialized - call Initialize first")
 	}
 
-	// Start periodic session cleanup
+	// Documents still "processing" were interrupted by the previous shutdown,
+	// unless this is a replica: they are the primary's
+	if as.replica == nil {
+		if n, err := as.docManager.FailInterrupted(); err != nil {
+			log.Printf("Warning: %v", err)
+		} else if n > 0 {
+			log.Printf("Marked %d documents interrupted by the last shutdown as failed", n)
+		}
+	}
+
+	// Load the vector cache now rather than on the first query; /readyz
+	// reports not ready until it is in memory
+	go func() {
+		start := time.Now()
+		if err := as.vectorStore.Warm(); err != nil {
+			log.Printf("Warning: vector cache warm-up failed: %v", err)
+			return
+		}
+		log.Printf("Vector cache loaded in %v", time.Since(start).Round(time.Millisecond))
+	}()
+	if as.atRestStorage != nil && !as.atRest.Current(as.atRestMarker) && as.replica == nil {
+		go func() {
+			if err := as.recodeAtRest(); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		}()
+	}
+
+	// Start periodic session cleanup; a replica instead follows the changes
+	// its primary makes, which runs the scheduled jobs
 	as.sessionCleanup = make(chan struct{})
 	as.cleanupWg.Add(1)
-	go as.runSessionCleanup(ctx)
+	if as.replica != nil {
+		go as.runReplicaRefresh(ctx)
+	} else {
+		go as.runSessionCleanup(ctx)
+
+		as.backupScheduler.SetLocks(as.locks)
+		as.maintenance.SetLocks(as.locks)
+		as.gapService.SetLocks(as.locks)
+		as.slaService.SetLocks(as.locks)
+		as.faqService.SetLocks(as.locks)
+		as.connectors.SetLocks(as.locks)
+		as.docManager.SetJobs(as.jobs)
+		as.backupScheduler.SetJobs(as.jobs)
+		as.maintenance.SetJobs(as.jobs)
+		as.faqService.SetJobs(as.jobs)
+		as.connectors.SetJobs(as.jobs)
+		as.jobs.Start()
+		as.backupScheduler.Start()
+		as.maintenance.Start()
+		as.gapService.Start()
+		as.slaService.Start()
+		as.faqService.Start()
+		as.connectors.Start()
+	}
+
+	if as.certManager != nil {
+		as.certManager.start()
+	}
+	if as.redirectServer != nil {
+		go func() {
+			log.Printf("Redirecting http://%s to HTTPS", as.redirectServer.Addr)
+			if err := as.redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("Warning: HTTP redirect listener failed: %v", err)
+			}
+		}()
+	}
+
+	if as.grpcServer != nil {
+		go func() {
+			var err error
+			switch {
+			case as.staticTLS():
+				log.Printf("gRPC API listening on %s (TLS)", as.grpcServer.Addr)
+				err = as.grpcServer.ListenAndServeTLS(as.cfg.Server.SSLCert, as.cfg.Server.SSLKey)
+			case as.certManager != nil:
+				log.Printf("gRPC API listening on %s (TLS, autocert)", as.grpcServer.Addr)
+				err = as.grpcServer.ListenAndServeTLS("", "")
+			default:
+				log.Printf("gRPC API listening on %s (cleartext HTTP/2)", as.grpcServer.Addr)
+				err = as.grpcServer.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				log.Printf("Warning: gRPC listener failed: %v", err)
+			}
+		}()
+	}
 
 	// Start server in a goroutine
 	errCh := make(chan error, 1)
 	go func() {
-		if as.cfg.Server.SSLCert != "" && as.cfg.Server.SSLKey != "" {
-			log.Printf("Askflow system starting on https://%s", as.server.Addr)
+		switch {
+		case as.staticTLS():
+			log.Printf("Askflow system starting on https://%s%s/", as.server.Addr, as.basePath)
 			errCh <- as.server.ListenAndServeTLS(as.cfg.Server.SSLCert, as.cfg.Server.SSLKey)
-		} else {
-			log.Printf("Askflow system starting on http://%s", as.server.Addr)
+		case as.certManager != nil:
+			log.Printf("Askflow system starting on https://%s%s/ (autocert)", as.server.Addr, as.basePath)
+			errCh <- as.server.ListenAndServeTLS("", "")
+		default:
+			log.Printf("Askflow system starting on http://%s%s/", as.server.Addr, as.basePath)
 			errCh <- as.server.ListenAndServe()
 		}
 	}()
@@ -209,7 +613,7 @@ func (as *AppService) Run(ctx context.Context) error {
 	select {
 	case <-ctx.Done():
 		log.Println("Received shutdown signal, shutting down gracefully...")
-		return as.Shutdown(10 * time.Second)
+		return as.Shutdown(as.ShutdownTimeout())
 	case err := <-errCh:
 		if err != http.ErrServerClosed {
 			return fmt.Errorf("server error: %w", err)
@@ -226,8 +630,6 @@ func (as *AppService) runSessionCleanup(ctx context.Context) {
 			log.Printf("[SessionCleanup] panic in cleanup goroutine: %v", r)
 		}
 	}()
-	// Create a single LoginLimiter instance for reuse across cleanup cycles
-	ll := auth.NewLoginLimiter(as.dbPair.Write)
 	ticker := time.NewTicker(1 * time.Hour)
 	defer ticker.Stop()
 	for {
@@ -241,12 +643,96 @@ func (as *AppService) runSessionCleanup(ctx context.Context) {
 				log.Printf("Cleaned %d expired sessions", n)
 			}
 			// Clean old login attempt records (older than 30 days)
-			ll.CleanOld()
+			as.loginLimiter.CleanOld()
+			// Recency boosts decay as documents age
+			if err := as.docManager.RefreshBoosts(); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+			if as.app != nil {
+				if n, err := as.app.PurgeAnswers(); err != nil {
+					log.Printf("Warning: %v", err)
+				} else if n > 0 {
+					log.Printf("Purged %d expired exported answers", n)
+				}
+				if n, err := as.app.PurgeWidgetVisitors(); err != nil {
+					log.Printf("Warning: %v", err)
+				} else if n > 0 {
+					log.Printf("Purged %d expired widget visitors", n)
+				}
+			}
+		}
+	}
+}
+
+// runReplicaRefresh keeps a read-only replica in step with its primary:
+// every replica.refresh_sec it reloads the vector cache when the chunks
+// table changed, recomputes the document search boosts and reloads
+// config.json when the primary saved it.
+func (as *AppService) runReplicaRefresh(ctx context.Context) {
+	defer as.cleanupWg.Done()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[Replica] panic in refresh goroutine: %v", r)
+		}
+	}()
+	configPath := filepath.Join(as.dataDir, "config.json")
+	configMod := modTime(configPath)
+	for {
+		interval := 10 * time.Second
+		if cfg := as.configManager.Get(); cfg != nil && cfg.Replica.RefreshSec > 0 {
+			interval = time.Duration(cfg.Replica.RefreshSec) * time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-as.sessionCleanup:
+			return
+		case <-time.After(interval):
+		}
+		if mod := modTime(configPath); !mod.Equal(configMod) && as.app != nil {
+			configMod = mod
+			if err := as.app.ReloadConfig(); err != nil {
+				log.Printf("[Replica] failed to reload config: %v", err)
+			} else {
+				log.Printf("[Replica] reloaded config saved by the primary")
+			}
+		}
+		if changed, err := as.vectorStore.Refresh(); err != nil {
+			log.Printf("[Replica] failed to refresh vector cache: %v", err)
+		} else if changed {
+			log.Printf("[Replica] vector cache reloaded after changes on the primary")
+		}
+		if err := as.docManager.RefreshBoosts(); err != nil {
+			log.Printf("[Replica] failed to refresh document boosts: %v", err)
 		}
 	}
 }
 
+// modTime returns the modification time of the file at path, or the zero
+// time when it cannot be read.
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// ShutdownTimeout returns how long a graceful shutdown may take
+// (server.shutdown_timeout_sec).
+func (as *AppService) ShutdownTimeout() time.Duration {
+	if as.configManager == nil {
+		return 60 * time.Second
+	}
+	if sec := as.configManager.Get().Server.ShutdownTimeoutSec; sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+	return 60 * time.Second
+}
+
 // Shutdown gracefully shuts down the HTTP server and cleans up resources.
+// In-flight requests and document processing get until timeout to finish;
+// the database is closed only after both have drained (or timed out).
 func (as *AppService) Shutdown(timeout time.Duration) error {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
@@ -265,16 +751,93 @@ func (as *AppService) Shutdown(timeout time.Duration) error {
 	if as.oauthClient != nil {
 		as.oauthClient.Stop()
 	}
+	if as.ssoClient != nil {
+		as.ssoClient.Stop()
+	}
 
 	// Wait for cleanup goroutine to finish before closing database
 	as.cleanupWg.Wait()
 
-	// Shutdown HTTP server
+	// Shutdown HTTP server: stop accepting connections and wait for in-flight
+	// requests (including synchronous document imports)
 	if as.server != nil {
 		if err := as.server.Shutdown(ctx); err != nil {
 			log.Printf("Server shutdown error: %v", err)
 		}
 	}
+	if as.redirectServer != nil {
+		as.redirectServer.Shutdown(ctx)
+	}
+	if as.grpcServer != nil {
+		as.grpcServer.Shutdown(ctx)
+	}
+	if as.certManager != nil {
+		as.certManager.shutdown()
+	}
+
+	// Wait for background PDF/PPT/video processing started by uploads
+	if as.docManager != nil {
+		log.Println("Waiting for document processing to finish...")
+		if err := as.docManager.Drain(ctx); err != nil {
+			log.Printf("Document processing did not finish before shutdown (%v); it will be marked failed on next start", err)
+		}
+	}
+
+	// Let a running backup finish before the database it reads is closed
+	if as.backupScheduler != nil {
+		if err := as.backupScheduler.Stop(ctx); err != nil {
+			log.Printf("Backup did not finish before shutdown: %v", err)
+		}
+	}
+
+	if as.maintenance != nil {
+		if err := as.maintenance.Stop(ctx); err != nil {
+			log.Printf("Database maintenance did not finish before shutdown: %v", err)
+		}
+	}
+
+	if as.gapService != nil {
+		if err := as.gapService.Stop(ctx); err != nil {
+			log.Printf("Gap report did not finish before shutdown: %v", err)
+		}
+	}
+	if as.slaService != nil {
+		if err := as.slaService.Stop(ctx); err != nil {
+			log.Printf("SLA escalation did not finish before shutdown: %v", err)
+		}
+	}
+	if as.faqService != nil {
+		if err := as.faqService.Stop(ctx); err != nil {
+			log.Printf("FAQ generation did not finish before shutdown: %v", err)
+		}
+	}
+	if as.connectors != nil {
+		if err := as.connectors.Stop(ctx); err != nil {
+			log.Printf("Connector sync did not finish before shutdown: %v", err)
+		}
+	}
+
+	// Write the last progress of jobs still running
+	if as.jobs != nil {
+		as.jobs.Stop()
+	}
+
+	// Write the queued login attempts
+	if as.loginLimiter != nil {
+		as.loginLimiter.Stop()
+	}
+
+	// Stop webhook delivery before the database it records results to is closed
+	if as.webhookService != nil {
+		as.webhookService.Stop()
+	}
+
+	// Save chunks stored since the last snapshot so the next start is fast
+	if as.vectorStore != nil {
+		if err := as.vectorStore.SaveSnapshot(); err != nil {
+			log.Printf("Vector snapshot write error: %v", err)
+		}
+	}
 
 	// Close database (only once)
 	if as.dbPair != nil {
@@ -283,6 +846,9 @@ func (as *AppService) Shutdown(timeout time.Duration) error {
 		}
 		as.dbPair = nil
 	}
+	if as.sharedCache != nil {
+		as.sharedCache.Close()
+	}
 
 	log.Println("Server stopped")
 	errlog.Close()
@@ -292,18 +858,102 @@ func (as *AppService) Shutdown(timeout time.Duration) error {
 // CreateApp creates an App facade instance with all dependencies injected internally.
 // This replaces the previous pattern of externally fetching each dependency via getters.
 func (as *AppService) CreateApp() *handler.App {
-	return handler.NewApp(
+	app := handler.NewApp(
 		as.dbPair.Write,
 		as.dbPair.Read,
 		as.queryEngine,
 		as.docManager,
+		as.vectorStore,
 		as.pendingManager,
 		as.oauthClient,
+		as.ssoClient,
 		as.sessionManager,
+		as.loginLimiter,
 		as.configManager,
 		as.emailService,
 		as.productService,
+		as.webhookService,
+		as.backupScheduler,
+		as.maintenance,
+		as.gapService,
+		as.slaService,
+		as.faqService,
+		as.connectors,
+		as.uploadStore,
+		as.tenantService,
+		as.embeddingPool,
 	)
+	app.SetBasePath(as.basePath)
+	app.SetLocks(as.locks)
+	app.SetJobs(as.jobs)
+	if as.sharedCache != nil {
+		app.SetSharedCache(as.sharedCache)
+	}
+	if as.replica != nil {
+		app.SetReplica(as.replica)
+	}
+	as.app = app
+	as.setupGRPC(app)
+	return app
+}
+
+// setupSharedCache connects the caches that instances behind a load
+// balancer share to Redis when cache.backend is redis. Without a usable
+// Redis URL the caches stay in memory.
+func (as *AppService) setupSharedCache() {
+	cfg := as.cfg.Cache
+	if cfg.Backend != "redis" {
+		return
+	}
+	if cfg.RedisURL == "" {
+		log.Printf("cache.backend is redis but cache.redis_url is empty; caching in memory")
+		return
+	}
+	c, err := sharedcache.New(cfg.RedisURL, cfg.KeyPrefix)
+	if err != nil {
+		log.Printf("Shared cache disabled, caching in memory: %v", err)
+		return
+	}
+	as.sharedCache = c
+	as.sessionManager.SetSharedCache(c)
+	as.queryEngine.SetSharedCache(c)
+}
+
+// setupGRPC prepares the gRPC listener (server.grpc_listen_addr) serving
+// app. It shares the certificate of the HTTPS server and falls back to
+// cleartext HTTP/2 without one.
+func (as *AppService) setupGRPC(app *handler.App) {
+	addr := as.cfg.Server.GRPCListenAddr
+	if addr == "" || as.grpcServer != nil {
+		return
+	}
+	if as.replica != nil {
+		log.Printf("gRPC API disabled on a read-only replica; use the primary's")
+		return
+	}
+	// No read/write timeouts: uploads stream for as long as they take
+	as.grpcServer = &http.Server{
+		Addr:              addr,
+		Handler:           grpcapi.NewServer(app),
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		MaxHeaderBytes:    1 << 20,
+	}
+	var protocols http.Protocols
+	if as.tlsEnabled() {
+		protocols.SetHTTP2(true)
+		if as.certManager != nil {
+			as.grpcServer.TLSConfig = as.certManager.TLSConfig()
+		}
+	} else {
+		protocols.SetUnencryptedHTTP2(true)
+	}
+	as.grpcServer.Protocols = &protocols
+}
+
+// BasePath returns the URL path prefix the app is served under ("" for the root).
+func (as *AppService) BasePath() string {
+	return as.basePath
 }
 
 // GetDatabase returns the write database connection (for backward compatibility and CLI usage).
@@ -333,3 +983,71 @@ func (as *AppService) GetDocManager() *document.DocumentManager {
 func (as *AppService) GetProductService() *product.ProductService {
 	return as.productService
 }
+
+// GetQueryEngine returns the RAG query engine.
+func (as *AppService) GetQueryEngine() *query.QueryEngine {
+	return as.queryEngine
+}
+
+// newAtRestCipher returns the cipher for storage.encrypt with the current
+// key, which also decrypts with the previous key of an unfinished rotation.
+func (as *AppService) newAtRestCipher() (*atrest.Cipher, error) {
+	c, err := atrest.New(as.configManager.DataKey("at_rest"), as.cfg.Storage.Encrypt)
+	if err != nil {
+		return nil, err
+	}
+	if previous := as.configManager.PreviousDataKey("at_rest"); previous != nil {
+		if err := c.Accept(previous); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// recodeAtRest rewrites the chunk text and stored files that are not stored
+// as storage.encrypt asks, e.g. after it was turned on or off or the key
+// was rotated, and then records that all data is.
+func (as *AppService) recodeAtRest() error {
+	if err := as.atRest.Mark(as.atRestMarker, false); err != nil {
+		return fmt.Errorf("failed to write %s: %w", as.atRestMarker, err)
+	}
+	start := time.Now()
+	chunks, err := as.vectorStore.RecodeText()
+	if err != nil {
+		return fmt.Errorf("failed to rewrite chunk text for encryption at rest: %w", err)
+	}
+	files, err := as.atRestStorage.Recode("uploads", "images", "videos")
+	if err != nil {
+		return fmt.Errorf("failed to rewrite stored files for encryption at rest: %w", err)
+	}
+	if err := as.atRest.Mark(as.atRestMarker, true); err != nil {
+		return fmt.Errorf("failed to write %s: %w", as.atRestMarker, err)
+	}
+	log.Printf("[Storage] Rewrote %d chunks and %d files for encryption at rest in %v",
+		chunks, files, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// ReencryptSecrets encrypts what is encrypted with the previous key of a
+// key rotation again with the current one: the connector tokens, and the
+// chunk text and stored files when they are or were encrypted at rest. The
+// config file is left to the ConfigManager.
+func (as *AppService) ReencryptSecrets() error {
+	n, err := as.connectors.RotateSecrets()
+	if err != nil {
+		return err
+	}
+	log.Printf("Re-encrypted the tokens of %d connectors", n)
+	if as.atRestStorage == nil {
+		return nil
+	}
+	// The cipher was made with the key at startup
+	c, err := as.newAtRestCipher()
+	if err != nil {
+		return err
+	}
+	as.vectorStore.SetTextCodec(c)
+	as.atRest, as.atRestStorage = c, blob.NewEncrypted(as.atRestStorage.Backend, c)
+	as.docManager.SetStorage(as.atRestStorage)
+	return as.recodeAtRest()
+}
@@ -0,0 +1,468 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"askflow/internal/config"
+)
+
+const (
+	// certRenewBefore is how long before expiry the certificate is renewed.
+	certRenewBefore = 30 * 24 * time.Hour
+	// certCheckInterval is how often the renewal loop checks the certificate.
+	certCheckInterval = 12 * time.Hour
+	// certObtainTimeout bounds a single ACME order, including CA validation.
+	certObtainTimeout = 10 * time.Minute
+
+	acmeChallengePrefix = "/.well-known/acme-challenge/"
+)
+
+// certManager obtains and renews a certificate for the configured domains
+// from an ACME CA (RFC 8555). TLS-ALPN-01 challenges are answered on the
+// HTTPS listener itself; HTTP-01 challenges on the HTTP redirect listener
+// when one is configured. The certificate and account key are cached on
+// disk so restarts do not hit CA rate limits.
+//
+// golang.org/x/crypto/acme/autocert is not used because it depends on
+// golang.org/x/net, which this module does not otherwise need.
+type certManager struct {
+	client   *acme.Client
+	domains  []string
+	email    string
+	cacheDir string
+
+	mu     sync.RWMutex
+	cert   *tls.Certificate
+	alpn   map[string]*tls.Certificate // domain -> TLS-ALPN-01 challenge certificate
+	http01 map[string]string           // token -> HTTP-01 key authorization
+	// httpChallenges is set when a redirect listener serves HTTP-01 responses.
+	httpChallenges bool
+
+	obtainMu   sync.Mutex
+	registered bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newCertManager loads (or creates) the ACME account key and any cached
+// certificate. No network access happens until the first certificate is needed.
+func newCertManager(cfg config.AutoCertConfig, dataDir string) (*certManager, error) {
+	var domains []string
+	for _, d := range cfg.Domains {
+		d = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(d)), ".")
+		if d != "" && !slices.Contains(domains, d) {
+			domains = append(domains, d)
+		}
+	}
+	if len(domains) == 0 {
+		return nil, errors.New("server.autocert.domains is empty")
+	}
+	cacheDir := cfg.CacheDir
+	if cacheDir == "" {
+		cacheDir = filepath.Join(dataDir, "certs")
+	}
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create certificate cache: %w", err)
+	}
+	key, err := loadOrCreateKey(filepath.Join(cacheDir, "acme_account.key"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ACME account key: %w", err)
+	}
+	directoryURL := cfg.DirectoryURL
+	if directoryURL == "" {
+		directoryURL = acme.LetsEncryptURL
+	}
+	m := &certManager{
+		client:   &acme.Client{Key: key, DirectoryURL: directoryURL, UserAgent: "askflow"},
+		domains:  domains,
+		email:    cfg.Email,
+		cacheDir: cacheDir,
+		alpn:     make(map[string]*tls.Certificate),
+		http01:   make(map[string]string),
+	}
+	if cert, err := m.loadCachedCert(); err != nil {
+		log.Printf("[AutoCert] ignoring cached certificate: %v", err)
+	} else if cert != nil {
+		m.cert = cert
+		log.Printf("[AutoCert] loaded cached certificate for %s, expires %s",
+			strings.Join(domains, ", "), cert.Leaf.NotAfter.Format(time.DateOnly))
+	}
+	return m, nil
+}
+
+// TLSConfig returns the server TLS configuration serving the managed certificate.
+func (m *certManager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: m.getCertificate,
+		NextProtos:     []string{"h2", "http/1.1", acme.ALPNProto},
+	}
+}
+
+// HTTPHandler answers HTTP-01 challenges and passes every other request to fallback.
+func (m *certManager) HTTPHandler(fallback http.Handler) http.Handler {
+	m.mu.Lock()
+	m.httpChallenges = true
+	m.mu.Unlock()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.URL.Path, acmeChallengePrefix)
+		if !ok {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		m.mu.RLock()
+		resp, found := m.http01[token]
+		m.mu.RUnlock()
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(resp))
+	})
+}
+
+// start launches the background loop that obtains the first certificate
+// and renews it before it expires.
+func (m *certManager) start() {
+	m.stop = make(chan struct{})
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(certCheckInterval)
+		defer ticker.Stop()
+		for {
+			if c := m.current(); c == nil || time.Until(c.Leaf.NotAfter) < certRenewBefore {
+				ctx, cancel := context.WithTimeout(context.Background(), certObtainTimeout)
+				go func() {
+					select {
+					case <-m.stop:
+						cancel()
+					case <-ctx.Done():
+					}
+				}()
+				if _, err := m.obtain(ctx, c); err != nil {
+					log.Printf("[AutoCert] certificate request failed: %v", err)
+				}
+				cancel()
+			}
+			select {
+			case <-m.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// shutdown stops the renewal loop, aborting an order in progress.
+func (m *certManager) shutdown() {
+	if m.stop == nil {
+		return
+	}
+	select {
+	case <-m.stop:
+	default:
+		close(m.stop)
+	}
+	m.wg.Wait()
+}
+
+func (m *certManager) current() *tls.Certificate {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cert
+}
+
+func (m *certManager) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto {
+		m.mu.RLock()
+		cert := m.alpn[name]
+		m.mu.RUnlock()
+		if cert == nil {
+			return nil, fmt.Errorf("no pending ACME challenge for %q", name)
+		}
+		return cert, nil
+	}
+	if name != "" && !slices.Contains(m.domains, name) {
+		return nil, fmt.Errorf("host %q is not in server.autocert.domains", name)
+	}
+	if cert := m.current(); cert != nil {
+		return cert, nil
+	}
+	// The renewal loop is still fetching the first certificate; wait for it
+	ctx, cancel := context.WithTimeout(hello.Context(), certObtainTimeout)
+	defer cancel()
+	return m.obtain(ctx, nil)
+}
+
+// obtain orders a new certificate unless another caller replaced stale
+// while this one waited for the lock.
+func (m *certManager) obtain(ctx context.Context, stale *tls.Certificate) (*tls.Certificate, error) {
+	m.obtainMu.Lock()
+	defer m.obtainMu.Unlock()
+	if c := m.current(); c != nil && c != stale {
+		return c, nil
+	}
+
+	if !m.registered {
+		acct := &acme.Account{}
+		if m.email != "" {
+			acct.Contact = []string{"mailto:" + m.email}
+		}
+		if _, err := m.client.Register(ctx, acct, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+			return nil, fmt.Errorf("ACME registration failed: %w", err)
+		}
+		m.registered = true
+	}
+
+	order, err := m.client.AuthorizeOrder(ctx, acme.DomainIDs(m.domains...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create order: %w", err)
+	}
+	for _, u := range order.AuthzURLs {
+		z, err := m.client.GetAuthorization(ctx, u)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch authorization: %w", err)
+		}
+		if z.Status == acme.StatusValid {
+			continue
+		}
+		if err := m.authorize(ctx, z); err != nil {
+			return nil, err
+		}
+	}
+	order, err = m.client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, fmt.Errorf("order not ready: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.domains[0]},
+		DNSNames: m.domains,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	der, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to finalize order: %w", err)
+	}
+	cert, err := newTLSCertificate(der, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.saveCert(der, key); err != nil {
+		log.Printf("[AutoCert] failed to cache certificate: %v", err)
+	}
+	m.mu.Lock()
+	m.cert = cert
+	m.mu.Unlock()
+	log.Printf("[AutoCert] obtained certificate for %s, expires %s",
+		strings.Join(m.domains, ", "), cert.Leaf.NotAfter.Format(time.DateOnly))
+	return cert, nil
+}
+
+// authorize completes one pending authorization, preferring TLS-ALPN-01
+// (needs only the HTTPS port) over HTTP-01 (needs the redirect listener on port 80).
+func (m *certManager) authorize(ctx context.Context, z *acme.Authorization) error {
+	domain := z.Identifier.Value
+	m.mu.RLock()
+	httpOK := m.httpChallenges
+	m.mu.RUnlock()
+	var chal *acme.Challenge
+	for _, c := range z.Challenges {
+		if c.Type == "tls-alpn-01" {
+			chal = c
+			break
+		}
+		if c.Type == "http-01" && httpOK {
+			chal = c
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("no supported ACME challenge offered for %s", domain)
+	}
+
+	switch chal.Type {
+	case "tls-alpn-01":
+		cert, err := m.client.TLSALPN01ChallengeCert(chal.Token, domain)
+		if err != nil {
+			return err
+		}
+		m.mu.Lock()
+		m.alpn[domain] = &cert
+		m.mu.Unlock()
+		defer func() {
+			m.mu.Lock()
+			delete(m.alpn, domain)
+			m.mu.Unlock()
+		}()
+	case "http-01":
+		resp, err := m.client.HTTP01ChallengeResponse(chal.Token)
+		if err != nil {
+			return err
+		}
+		m.mu.Lock()
+		m.http01[chal.Token] = resp
+		m.mu.Unlock()
+		defer func() {
+			m.mu.Lock()
+			delete(m.http01, chal.Token)
+			m.mu.Unlock()
+		}()
+	}
+
+	if _, err := m.client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("failed to accept %s challenge for %s: %w", chal.Type, domain, err)
+	}
+	if _, err := m.client.WaitAuthorization(ctx, z.URI); err != nil {
+		return fmt.Errorf("%s validation for %s failed: %w", chal.Type, domain, err)
+	}
+	return nil
+}
+
+// certCachePath is the PEM file holding the private key followed by the chain.
+func (m *certManager) certCachePath() string {
+	return filepath.Join(m.cacheDir, "cert.pem")
+}
+
+func (m *certManager) saveCert(der [][]byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	for _, b := range der {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b})...)
+	}
+	tmp := m.certCachePath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.certCachePath())
+}
+
+// loadCachedCert returns the cached certificate, or nil if there is none or
+// it no longer covers the configured domains.
+func (m *certManager) loadCachedCert() (*tls.Certificate, error) {
+	data, err := os.ReadFile(m.certCachePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var key *ecdsa.PrivateKey
+	var der [][]byte
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "EC PRIVATE KEY":
+			if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+				return nil, err
+			}
+		case "CERTIFICATE":
+			der = append(der, block.Bytes)
+		}
+	}
+	if key == nil || len(der) == 0 {
+		return nil, errors.New("incomplete certificate cache file")
+	}
+	cert, err := newTLSCertificate(der, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range m.domains {
+		if cert.Leaf.VerifyHostname(d) != nil {
+			return nil, nil
+		}
+	}
+	return cert, nil
+}
+
+func newTLSCertificate(der [][]byte, key crypto.Signer) (*tls.Certificate, error) {
+	leaf, err := x509.ParseCertificate(der[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %w", err)
+	}
+	return &tls.Certificate{Certificate: der, PrivateKey: key, Leaf: leaf}, nil
+}
+
+func loadOrCreateKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "EC PRIVATE KEY" {
+			return nil, fmt.Errorf("%s is not a PEM EC private key", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// httpsRedirect redirects plain HTTP requests to the same URL on the HTTPS port.
+func httpsRedirect(httpsPort int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		code := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			code = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+	}
+}
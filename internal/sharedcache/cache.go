@@ -0,0 +1,246 @@
+// Package sharedcache holds the short-lived state that instances behind a
+// load balancer must agree on: cached sessions, query embeddings, rate limit
+// counters and translated product names. With cache.backend set to redis the
+// entries live in Redis under cache.key_prefix; otherwise, and whenever
+// Redis cannot be reached, they live in process memory. A failing Redis is
+// skipped for a few seconds before it is tried again, so an outage degrades
+// to per-instance caching instead of failing requests.
+package sharedcache
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"askflow/internal/redis"
+)
+
+const (
+	// opTimeout bounds each Redis command made on a request path.
+	opTimeout = time.Second
+	// retryAfter is how long Redis is bypassed after an error.
+	retryAfter = 5 * time.Second
+	// logEvery rate-limits the fallback warnings.
+	logEvery = time.Minute
+	// memoryLimit is the number of entries kept in memory before expired
+	// and then arbitrary entries are evicted.
+	memoryLimit = 100000
+)
+
+// Cache is a key-value cache with expiring entries, in Redis or in memory.
+// It is safe for concurrent use.
+type Cache struct {
+	rdb    *redis.Client // nil for a memory-only cache
+	prefix string
+
+	downUntil atomic.Int64 // Unix nanoseconds until which Redis is skipped
+	loggedAt  atomic.Int64 // Unix nanoseconds of the last fallback warning
+
+	mu  sync.Mutex
+	mem map[string]memEntry
+}
+
+// memEntry is an entry of the in-memory cache.
+type memEntry struct {
+	value   []byte
+	expires time.Time // zero: never
+}
+
+// New returns a cache in Redis at redisURL with keys under prefix, or an
+// in-memory cache when redisURL is empty. An unreachable server is not an
+// error: it is logged and the cache runs in memory until the server answers.
+func New(redisURL, prefix string) (*Cache, error) {
+	c := &Cache{prefix: prefix, mem: make(map[string]memEntry)}
+	if redisURL == "" {
+		return c, nil
+	}
+	rdb, err := redis.New(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	c.rdb = rdb
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := rdb.Ping(ctx); err != nil {
+		c.fail(err)
+	} else {
+		log.Printf("[Cache] using Redis at %s", rdb.Addr())
+	}
+	return c, nil
+}
+
+// Shared reports whether the cache is backed by Redis, even if Redis is
+// currently unreachable.
+func (c *Cache) Shared() bool {
+	return c != nil && c.rdb != nil
+}
+
+// Close releases the Redis connections.
+func (c *Cache) Close() error {
+	if c.rdb == nil {
+		return nil
+	}
+	return c.rdb.Close()
+}
+
+// Get returns the value stored at key.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	if c.useRedis() {
+		ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+		defer cancel()
+		v, ok, err := c.rdb.Get(ctx, c.prefix+key)
+		if err == nil {
+			return v, ok
+		}
+		c.fail(err)
+	}
+	return c.memGet(key)
+}
+
+// GetMulti returns the values stored at keys, nil for missing keys.
+func (c *Cache) GetMulti(keys ...string) [][]byte {
+	if c.useRedis() {
+		ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+		defer cancel()
+		full := make([]string, len(keys))
+		for i, k := range keys {
+			full[i] = c.prefix + k
+		}
+		values, err := c.rdb.MGet(ctx, full...)
+		if err == nil {
+			return values
+		}
+		c.fail(err)
+	}
+	values := make([][]byte, len(keys))
+	for i, k := range keys {
+		values[i], _ = c.memGet(k)
+	}
+	return values
+}
+
+// Set stores value at key for ttl (forever when ttl <= 0).
+func (c *Cache) Set(key string, value []byte, ttl time.Duration) {
+	if c.useRedis() {
+		ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+		defer cancel()
+		err := c.rdb.Set(ctx, c.prefix+key, value, ttl)
+		if err == nil {
+			return
+		}
+		c.fail(err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evictLocked()
+	c.mem[key] = memEntry{value: value, expires: expiry(ttl)}
+}
+
+// Delete removes key.
+func (c *Cache) Delete(key string) {
+	if c.useRedis() {
+		ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+		defer cancel()
+		err := c.rdb.Del(ctx, c.prefix+key)
+		if err == nil {
+			return
+		}
+		c.fail(err)
+	}
+	c.mu.Lock()
+	delete(c.mem, key)
+	c.mu.Unlock()
+}
+
+// Incr adds delta to the counter at key and returns its new value. A
+// counter created by the call expires after ttl (never when ttl <= 0).
+func (c *Cache) Incr(key string, delta int64, ttl time.Duration) int64 {
+	if c.useRedis() {
+		ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+		defer cancel()
+		n, err := c.rdb.Incr(ctx, c.prefix+key, delta, ttl)
+		if err == nil {
+			return n
+		}
+		c.fail(err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.mem[key]
+	if !ok || e.expired(time.Now()) {
+		c.evictLocked()
+		e = memEntry{expires: expiry(ttl)}
+	}
+	n, _ := strconv.ParseInt(string(e.value), 10, 64)
+	n += delta
+	e.value = strconv.AppendInt(nil, n, 10)
+	c.mem[key] = e
+	return n
+}
+
+// useRedis reports whether the next operation should go to Redis.
+func (c *Cache) useRedis() bool {
+	return c.rdb != nil && time.Now().UnixNano() >= c.downUntil.Load()
+}
+
+// fail bypasses Redis for a while after err, logging at most once a minute.
+func (c *Cache) fail(err error) {
+	now := time.Now()
+	c.downUntil.Store(now.Add(retryAfter).UnixNano())
+	last := c.loggedAt.Load()
+	if now.UnixNano()-last >= int64(logEvery) && c.loggedAt.CompareAndSwap(last, now.UnixNano()) {
+		log.Printf("[Cache] Redis unavailable, falling back to in-memory caching: %v", err)
+	}
+}
+
+// memGet returns an unexpired in-memory entry.
+func (c *Cache) memGet(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.mem[key]
+	if !ok {
+		return nil, false
+	}
+	if e.expired(time.Now()) {
+		delete(c.mem, key)
+		return nil, false
+	}
+	return e.value, true
+}
+
+// evictLocked makes room for a new in-memory entry when the cache is full:
+// expired entries go first, then arbitrary ones down to three quarters of
+// the limit. c.mu must be held.
+func (c *Cache) evictLocked() {
+	if len(c.mem) < memoryLimit {
+		return
+	}
+	now := time.Now()
+	for k, e := range c.mem {
+		if e.expired(now) {
+			delete(c.mem, k)
+		}
+	}
+	for k := range c.mem {
+		if len(c.mem) <= memoryLimit*3/4 {
+			break
+		}
+		delete(c.mem, k)
+	}
+}
+
+// expired reports whether the entry has expired at now.
+func (e memEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
+
+// expiry returns the expiry time of an entry stored now for ttl.
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
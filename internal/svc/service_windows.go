@@ -51,7 +51,8 @@ func (hs *AskflowService) Execute(args []string, r <-chan svc.ChangeRequest, s c
 			switch c.Cmd {
 			case svc.Stop, svc.Shutdown:
 				hs.logger.Info("Received stop/shutdown command")
-				s <- svc.Status{State: svc.StopPending}
+				// Tell the SCM how long draining may take so it does not give up early
+				s <- svc.Status{State: svc.StopPending, WaitHint: uint32((hs.appService.ShutdownTimeout() + 10*time.Second) / time.Millisecond)}
 				cancel() // Cancel context — Run() will call Shutdown internally
 				// Wait for Run() to finish instead of calling Shutdown again
 				<-errCh
@@ -0,0 +1,159 @@
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"askflow/internal/config"
+	"askflow/internal/i18n"
+)
+
+// PathPrefix is the URL prefix that selects a tenant by path: /t/<slug>/...
+const PathPrefix = "/t/"
+
+type contextKey struct{}
+
+type requestTenant struct {
+	tenant *Tenant
+	prefix string
+}
+
+// WithTenant returns a copy of ctx carrying t and the URL prefix it was
+// selected by ("" when it was selected by host name).
+func WithTenant(ctx context.Context, t *Tenant, prefix string) context.Context {
+	return context.WithValue(ctx, contextKey{}, requestTenant{tenant: t, prefix: prefix})
+}
+
+// FromContext returns the tenant a request was resolved to, or nil for the
+// default workspace.
+func FromContext(ctx context.Context) *Tenant {
+	rt, _ := ctx.Value(contextKey{}).(requestTenant)
+	return rt.tenant
+}
+
+// IDFromContext returns the ID of the request's tenant, "" for the default
+// workspace.
+func IDFromContext(ctx context.Context) string {
+	if t := FromContext(ctx); t != nil {
+		return t.ID
+	}
+	return ""
+}
+
+// PrefixFromContext returns the /t/<slug> prefix the request's tenant was
+// selected by, or "" when the tenant came from the host name or the request
+// is for the default workspace.
+func PrefixFromContext(ctx context.Context) string {
+	rt, _ := ctx.Value(contextKey{}).(requestTenant)
+	return rt.prefix
+}
+
+// Middleware resolves the tenant of each request and stores it in the request
+// context. A tenant is selected by a /t/<slug>/ path prefix, which is stripped
+// before the request reaches next, or by a <slug>.<base_domain> host name when
+// a base domain is configured. Other requests go to the default workspace.
+// Unknown tenants get 404 and suspended tenants 403. When multi-tenancy is
+// disabled requests pass through untouched.
+func Middleware(s *Service, settings func() config.TenantsConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := settings()
+		if !cfg.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		slug, rest, prefix := slugFromPath(r.URL.Path)
+		if slug == "" {
+			slug = slugFromHost(r.Host, cfg.BaseDomain)
+		} else if rest == "" {
+			// /t/<slug> → /t/<slug>/ so relative asset URLs resolve
+			target := r.URL.Path + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		if slug == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		t, err := s.GetBySlug(slug)
+		if err != nil {
+			writeError(w, r, http.StatusNotFound, "工作区不存在")
+			return
+		}
+		if t.Status != StatusActive {
+			writeError(w, r, http.StatusForbidden, "工作区已停用")
+			return
+		}
+
+		r2 := r.WithContext(WithTenant(r.Context(), t, prefix))
+		if prefix != "" {
+			r2.URL = new(url.URL)
+			*r2.URL = *r.URL
+			r2.URL.Path = rest
+			r2.URL.RawPath = ""
+			if raw, ok := strings.CutPrefix(r.URL.RawPath, prefix); ok {
+				r2.URL.RawPath = raw
+			}
+		}
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// slugFromPath splits /t/<slug>/rest into the slug, "/rest" and "/t/<slug>".
+func slugFromPath(p string) (slug, rest, prefix string) {
+	after, ok := strings.CutPrefix(p, PathPrefix)
+	if !ok {
+		return "", "", ""
+	}
+	slug, tail, found := strings.Cut(after, "/")
+	if slug == "" {
+		return "", "", ""
+	}
+	if found {
+		rest = "/" + tail
+	}
+	return slug, rest, PathPrefix + slug
+}
+
+// slugFromHost returns <slug> for a host of the form <slug>.<baseDomain>.
+func slugFromHost(host, baseDomain string) string {
+	baseDomain = strings.ToLower(strings.Trim(baseDomain, "."))
+	if baseDomain == "" {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	sub, ok := strings.CutSuffix(strings.ToLower(host), "."+baseDomain)
+	if !ok || sub == "" || strings.Contains(sub, ".") || reservedSlugs[sub] {
+		return ""
+	}
+	return sub
+}
+
+// writeError answers API requests with the JSON error shape the handlers use
+// and everything else with plain text.
+func writeError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	// Runs before the API middleware negotiates a language, so only
+	// Accept-Language is considered
+	lang := i18n.Match(r.Header.Get("Accept-Language"))
+	if lang == "" {
+		lang = i18n.Default
+	}
+	msg = i18n.T(lang, msg)
+	if strings.Contains(r.URL.Path, "/api/") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": msg})
+		return
+	}
+	http.Error(w, msg, status)
+}
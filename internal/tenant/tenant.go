@@ -0,0 +1,493 @@
+// Package tenant provides tenant workspaces: isolated sets of products,
+// admin accounts, display settings and usage quotas served from one askflow
+// instance. Documents, knowledge chunks and pending questions belong to a
+// tenant through their product. The default workspace has the empty ID and
+// owns everything not assigned to a tenant, including the public library.
+package tenant
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tenant statuses. Requests to a suspended tenant are rejected.
+const (
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
+)
+
+// Quota resources checked by CheckQuota.
+const (
+	ResourceProducts  = "products"
+	ResourceDocuments = "documents"
+	ResourceAdmins    = "admins"
+	ResourceQueries   = "queries"
+)
+
+// Tenant is a workspace hosted on the instance.
+type Tenant struct {
+	ID     string `json:"id"`
+	Slug   string `json:"slug"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Overrides of the instance-wide product_name / product_intro settings;
+	// empty means the instance value is used.
+	ProductName  string    `json:"product_name"`
+	ProductIntro string    `json:"product_intro"`
+	Quota        Quota     `json:"quota"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Quota limits what a tenant may create or consume. Zero means unlimited.
+type Quota struct {
+	MaxProducts      int `json:"max_products"`
+	MaxDocuments     int `json:"max_documents"`
+	MaxAdmins        int `json:"max_admins"`
+	MaxQueriesPerDay int `json:"max_queries_per_day"`
+}
+
+// Usage is a tenant's current consumption of its quota.
+type Usage struct {
+	Products     int `json:"products"`
+	Documents    int `json:"documents"`
+	Admins       int `json:"admins"`
+	QueriesToday int `json:"queries_today"`
+}
+
+// QuotaError reports that a tenant has reached a quota limit.
+type QuotaError struct {
+	Resource string
+	Limit    int
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("tenant quota exceeded: %s (limit %d)", e.Resource, e.Limit)
+}
+
+// slugPattern allows DNS labels so a slug works both as a subdomain and as a
+// path segment.
+var slugPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,30}[a-z0-9])?$`)
+
+// reservedSlugs cannot be used because they collide with common hostnames.
+var reservedSlugs = map[string]bool{"www": true, "api": true, "admin": true, "app": true, "static": true}
+
+const tenantColumns = `id, slug, name, status, product_name, product_intro,
+	max_products, max_documents, max_admins, max_queries_per_day, created_at, updated_at`
+
+// slugCacheTTL bounds how long a slug lookup made by the middleware is reused.
+const slugCacheTTL = 30 * time.Second
+
+type slugCacheEntry struct {
+	tenant  *Tenant
+	expires time.Time
+}
+
+// Service manages tenants, their quotas and usage counters.
+type Service struct {
+	readDB  *sql.DB
+	writeDB *sql.DB
+
+	cacheMu   sync.Mutex
+	slugCache map[string]slugCacheEntry
+}
+
+// NewService creates a new tenant Service with separate read and write database connections.
+func NewService(readDB, writeDB *sql.DB) *Service {
+	return &Service{readDB: readDB, writeDB: writeDB, slugCache: make(map[string]slugCacheEntry)}
+}
+
+// ValidateSlug checks that slug can be used as a subdomain and path segment.
+func ValidateSlug(slug string) error {
+	if !slugPattern.MatchString(slug) {
+		return fmt.Errorf("slug must be 1-32 lowercase letters, digits or hyphens")
+	}
+	if reservedSlugs[slug] {
+		return fmt.Errorf("slug %q is reserved", slug)
+	}
+	return nil
+}
+
+// List returns all tenants ordered by creation time.
+func (s *Service) List() ([]Tenant, error) {
+	rows, err := s.readDB.Query(`SELECT ` + tenantColumns + ` FROM tenants ORDER BY created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenants: %w", err)
+	}
+	defer rows.Close()
+	var tenants []Tenant
+	for rows.Next() {
+		t, err := scanTenant(rows)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, *t)
+	}
+	return tenants, rows.Err()
+}
+
+// Get returns a tenant by ID.
+func (s *Service) Get(id string) (*Tenant, error) {
+	t, err := scanTenant(s.readDB.QueryRow(`SELECT `+tenantColumns+` FROM tenants WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("tenant not found")
+	}
+	return t, err
+}
+
+// GetBySlug returns a tenant by slug. Results are cached briefly because the
+// middleware looks the tenant up on every request.
+func (s *Service) GetBySlug(slug string) (*Tenant, error) {
+	s.cacheMu.Lock()
+	if e, ok := s.slugCache[slug]; ok && time.Now().Before(e.expires) {
+		s.cacheMu.Unlock()
+		if e.tenant == nil {
+			return nil, fmt.Errorf("tenant not found")
+		}
+		return e.tenant, nil
+	}
+	s.cacheMu.Unlock()
+
+	t, err := scanTenant(s.readDB.QueryRow(`SELECT `+tenantColumns+` FROM tenants WHERE slug = ?`, slug))
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	s.cacheMu.Lock()
+	s.slugCache[slug] = slugCacheEntry{tenant: t, expires: time.Now().Add(slugCacheTTL)}
+	s.cacheMu.Unlock()
+	if t == nil {
+		return nil, fmt.Errorf("tenant not found")
+	}
+	return t, nil
+}
+
+// Create adds a tenant. ID, CreatedAt and UpdatedAt are assigned; an empty
+// status means active.
+func (s *Service) Create(t Tenant) (*Tenant, error) {
+	if err := normalize(&t); err != nil {
+		return nil, err
+	}
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	_, err = s.writeDB.Exec(
+		`INSERT INTO tenants (id, slug, name, status, product_name, product_intro,
+			max_products, max_documents, max_admins, max_queries_per_day, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, t.Slug, t.Name, t.Status, t.ProductName, t.ProductIntro,
+		t.Quota.MaxProducts, t.Quota.MaxDocuments, t.Quota.MaxAdmins, t.Quota.MaxQueriesPerDay, now, now,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return nil, fmt.Errorf("tenant slug already exists")
+		}
+		return nil, fmt.Errorf("failed to create tenant: %w", err)
+	}
+	s.forgetSlug(t.Slug)
+	t.ID, t.CreatedAt, t.UpdatedAt = id, now, now
+	return &t, nil
+}
+
+// Update replaces a tenant's slug, name, status, overrides and quota.
+func (s *Service) Update(id string, t Tenant) (*Tenant, error) {
+	if err := normalize(&t); err != nil {
+		return nil, err
+	}
+	old, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.writeDB.Exec(
+		`UPDATE tenants SET slug = ?, name = ?, status = ?, product_name = ?, product_intro = ?,
+			max_products = ?, max_documents = ?, max_admins = ?, max_queries_per_day = ?, updated_at = ?
+		WHERE id = ?`,
+		t.Slug, t.Name, t.Status, t.ProductName, t.ProductIntro,
+		t.Quota.MaxProducts, t.Quota.MaxDocuments, t.Quota.MaxAdmins, t.Quota.MaxQueriesPerDay, time.Now().UTC(), id,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return nil, fmt.Errorf("tenant slug already exists")
+		}
+		return nil, fmt.Errorf("failed to update tenant: %w", err)
+	}
+	s.forgetSlug(old.Slug)
+	s.forgetSlug(t.Slug)
+	return s.Get(id)
+}
+
+// Delete removes a tenant and its usage counters. A tenant that still owns
+// products or admin accounts cannot be deleted: they have to be removed first
+// so that no knowledge base or login is orphaned.
+func (s *Service) Delete(id string) error {
+	old, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	tx, err := s.writeDB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var products, admins int
+	if err := tx.QueryRow(`SELECT (SELECT COUNT(*) FROM products WHERE tenant_id = ?), (SELECT COUNT(*) FROM admin_users WHERE tenant_id = ?)`,
+		id, id).Scan(&products, &admins); err != nil {
+		return fmt.Errorf("failed to count tenant resources: %w", err)
+	}
+	if products > 0 {
+		return fmt.Errorf("tenant still owns %d products; delete them first", products)
+	}
+	if admins > 0 {
+		return fmt.Errorf("tenant still has %d admin accounts; delete them first", admins)
+	}
+	if _, err := tx.Exec(`DELETE FROM tenant_usage WHERE tenant_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete tenant usage: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM tenants WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete tenant: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.forgetSlug(old.Slug)
+	return nil
+}
+
+// AdminUserIDs returns the admin_users IDs of a tenant's admin accounts.
+func (s *Service) AdminUserIDs(id string) ([]string, error) {
+	rows, err := s.readDB.Query(`SELECT id FROM admin_users WHERE tenant_id = ?`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenant admins: %w", err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var aid string
+		if err := rows.Scan(&aid); err != nil {
+			return nil, err
+		}
+		ids = append(ids, aid)
+	}
+	return ids, rows.Err()
+}
+
+// Usage returns the tenant's current product, document, admin and daily
+// query counts.
+func (s *Service) Usage(id string) (*Usage, error) {
+	var u Usage
+	err := s.readDB.QueryRow(`SELECT
+		(SELECT COUNT(*) FROM products WHERE tenant_id = ?),
+		(SELECT COUNT(*) FROM documents d JOIN products p ON p.id = d.product_id WHERE p.tenant_id = ?),
+		(SELECT COUNT(*) FROM admin_users WHERE tenant_id = ?),
+		COALESCE((SELECT queries FROM tenant_usage WHERE tenant_id = ? AND day = ?), 0)`,
+		id, id, id, id, today(),
+	).Scan(&u.Products, &u.Documents, &u.Admins, &u.QueriesToday)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenant usage: %w", err)
+	}
+	return &u, nil
+}
+
+// CheckQuota returns a *QuotaError when the tenant cannot create one more
+// product, document or admin account. The default workspace ("") has no quota.
+func (s *Service) CheckQuota(id, resource string) error {
+	if id == "" {
+		return nil
+	}
+	t, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	var limit, used int
+	switch resource {
+	case ResourceProducts:
+		limit = t.Quota.MaxProducts
+	case ResourceDocuments:
+		limit = t.Quota.MaxDocuments
+	case ResourceAdmins:
+		limit = t.Quota.MaxAdmins
+	default:
+		return fmt.Errorf("unknown quota resource %q", resource)
+	}
+	if limit <= 0 {
+		return nil
+	}
+	u, err := s.Usage(id)
+	if err != nil {
+		return err
+	}
+	switch resource {
+	case ResourceProducts:
+		used = u.Products
+	case ResourceDocuments:
+		used = u.Documents
+	case ResourceAdmins:
+		used = u.Admins
+	}
+	if used >= limit {
+		return &QuotaError{Resource: resource, Limit: limit}
+	}
+	return nil
+}
+
+// CountQuery records one query against the tenant's daily quota, or returns
+// a *QuotaError without counting it when the quota is used up.
+func (s *Service) CountQuery(id string) error {
+	if id == "" {
+		return nil
+	}
+	t, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	limit := t.Quota.MaxQueriesPerDay
+	var res sql.Result
+	if limit > 0 {
+		res, err = s.writeDB.Exec(
+			`INSERT INTO tenant_usage (tenant_id, day, queries) VALUES (?, ?, 1)
+			ON CONFLICT(tenant_id, day) DO UPDATE SET queries = queries + 1 WHERE queries < ?`,
+			id, today(), limit,
+		)
+	} else {
+		res, err = s.writeDB.Exec(
+			`INSERT INTO tenant_usage (tenant_id, day, queries) VALUES (?, ?, 1)
+			ON CONFLICT(tenant_id, day) DO UPDATE SET queries = queries + 1`,
+			id, today(),
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to count tenant query: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return &QuotaError{Resource: ResourceQueries, Limit: limit}
+	}
+	return nil
+}
+
+// ProductIDs returns the IDs of the products owned by a tenant. For the
+// default workspace the public library ("") is included.
+func (s *Service) ProductIDs(id string) ([]string, error) {
+	rows, err := s.readDB.Query(`SELECT id FROM products WHERE tenant_id = ? ORDER BY created_at`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenant products: %w", err)
+	}
+	defer rows.Close()
+	ids := []string{}
+	if id == "" {
+		ids = append(ids, "")
+	}
+	for rows.Next() {
+		var pid string
+		if err := rows.Scan(&pid); err != nil {
+			return nil, err
+		}
+		ids = append(ids, pid)
+	}
+	return ids, rows.Err()
+}
+
+// OwnsProduct reports whether productID belongs to the tenant. The public
+// library ("") belongs to the default workspace only.
+func (s *Service) OwnsProduct(id, productID string) bool {
+	if productID == "" {
+		return id == ""
+	}
+	owner, ok := s.ProductTenant(productID)
+	return ok && owner == id
+}
+
+// ProductTenant returns the tenant of a product ("" for the default
+// workspace) and whether the product exists.
+func (s *Service) ProductTenant(productID string) (string, bool) {
+	var id string
+	if err := s.readDB.QueryRow(`SELECT tenant_id FROM products WHERE id = ?`, productID).Scan(&id); err != nil {
+		return "", false
+	}
+	return id, true
+}
+
+// AdminTenant returns the tenant of an admin_users row ("" for the default
+// workspace) and whether the account exists.
+func (s *Service) AdminTenant(adminUserID string) (string, bool) {
+	var id string
+	if err := s.readDB.QueryRow(`SELECT tenant_id FROM admin_users WHERE id = ?`, adminUserID).Scan(&id); err != nil {
+		return "", false
+	}
+	return id, true
+}
+
+func (s *Service) forgetSlug(slug string) {
+	s.cacheMu.Lock()
+	delete(s.slugCache, slug)
+	s.cacheMu.Unlock()
+}
+
+// normalize trims and validates t in place.
+func normalize(t *Tenant) error {
+	t.Slug = strings.ToLower(strings.TrimSpace(t.Slug))
+	t.Name = strings.TrimSpace(t.Name)
+	if err := ValidateSlug(t.Slug); err != nil {
+		return err
+	}
+	if t.Name == "" {
+		return fmt.Errorf("tenant name cannot be empty")
+	}
+	if len(t.Name) > 200 || len(t.ProductName) > 200 {
+		return fmt.Errorf("tenant name too long (max 200 characters)")
+	}
+	if len(t.ProductIntro) > 10000 {
+		return fmt.Errorf("product intro too long (max 10000 characters)")
+	}
+	switch t.Status {
+	case "":
+		t.Status = StatusActive
+	case StatusActive, StatusSuspended:
+	default:
+		return fmt.Errorf("invalid tenant status %q", t.Status)
+	}
+	q := t.Quota
+	if q.MaxProducts < 0 || q.MaxDocuments < 0 || q.MaxAdmins < 0 || q.MaxQueriesPerDay < 0 {
+		return fmt.Errorf("quota limits cannot be negative")
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTenant(row rowScanner) (*Tenant, error) {
+	var t Tenant
+	err := row.Scan(&t.ID, &t.Slug, &t.Name, &t.Status, &t.ProductName, &t.ProductIntro,
+		&t.Quota.MaxProducts, &t.Quota.MaxDocuments, &t.Quota.MaxAdmins, &t.Quota.MaxQueriesPerDay,
+		&t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan tenant: %w", err)
+	}
+	return &t, nil
+}
+
+// today is the UTC day that daily query quotas are counted against.
+func today() string {
+	return time.Now().UTC().Format("2006-01-02")
+}
+
+// generateID creates a random hex string for use as a unique identifier.
+func generateID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,317 @@
+// Package upload implements resumable file uploads. A client opens a session
+// for a file of known size, sends the file in chunks at increasing offsets
+// and, after a dropped connection, asks for the session's offset and
+// continues from there. Chunks are appended to a file on disk, so neither a
+// flaky connection nor a large file requires the whole body in one request.
+//
+// Sessions live in a directory as <id>.json (metadata) and <id>.part (data)
+// and therefore survive restarts. Sessions not completed within SessionTTL
+// are removed.
+package upload
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionTTL is how long an unfinished upload session is kept after it was
+// opened.
+const SessionTTL = 24 * time.Hour
+
+// MaxChunkSize is the largest chunk accepted in one request.
+const MaxChunkSize = 16 << 20
+
+var (
+	// ErrNotFound is returned for unknown or expired sessions.
+	ErrNotFound = errors.New("upload session not found")
+	// ErrOffsetMismatch is returned when a chunk does not start at the
+	// session's current offset.
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+	// ErrTooLarge is returned when a chunk extends past the declared size.
+	ErrTooLarge = errors.New("chunk exceeds the declared file size")
+	// ErrBusy is returned while another chunk of the session is written.
+	ErrBusy = errors.New("upload session is busy")
+	// ErrIncomplete is returned when completing a session before all of
+	// the file was received.
+	ErrIncomplete = errors.New("upload is incomplete")
+)
+
+// Session is an upload in progress. Offset is the number of bytes received.
+type Session struct {
+	ID        string    `json:"id"`
+	FileName  string    `json:"file_name"`
+	ProductID string    `json:"product_id"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Store keeps upload sessions in a directory.
+type Store struct {
+	dir string
+
+	mu   sync.Mutex
+	busy map[string]bool // sessions with a chunk being written
+}
+
+// NewStore creates a Store in dir and removes sessions that expired while
+// the server was down.
+func NewStore(dir string) *Store {
+	s := &Store{dir: dir, busy: make(map[string]bool)}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Printf("[Upload] cannot create session directory %s: %v", dir, err)
+	}
+	s.mu.Lock()
+	s.sweep(time.Now())
+	s.mu.Unlock()
+	return s
+}
+
+// Create opens a session for a file of size bytes.
+func (s *Store) Create(fileName, productID, createdBy string, size int64) (*Session, error) {
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	sess := &Session{
+		ID:        id,
+		FileName:  fileName,
+		ProductID: productID,
+		Size:      size,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		ExpiresAt: now.Add(SessionTTL),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+	f, err := os.OpenFile(s.dataPath(id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %w", err)
+	}
+	f.Close()
+	if err := s.save(sess); err != nil {
+		os.Remove(s.dataPath(id))
+		return nil, err
+	}
+	return sess, nil
+}
+
+// Get returns a session.
+func (s *Store) Get(id string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load(id)
+}
+
+// WriteChunk appends the data read from r to the session, which must have
+// received exactly offset bytes so far; otherwise ErrOffsetMismatch is
+// returned with the session. Bytes received before r fails are kept, so
+// the client can resume after them.
+func (s *Store) WriteChunk(id string, offset int64, r io.Reader) (*Session, error) {
+	s.mu.Lock()
+	sess, err := s.load(id)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	if s.busy[id] {
+		s.mu.Unlock()
+		return sess, ErrBusy
+	}
+	if offset != sess.Offset {
+		s.mu.Unlock()
+		return sess, ErrOffsetMismatch
+	}
+	s.busy[id] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.busy, id)
+		s.mu.Unlock()
+	}()
+
+	f, err := os.OpenFile(s.dataPath(id), os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload file: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek upload file: %w", err)
+	}
+	remaining := sess.Size - offset
+	n, copyErr := io.Copy(f, io.LimitReader(r, remaining+1))
+	if n > remaining {
+		f.Truncate(offset)
+		return sess, ErrTooLarge
+	}
+	if err := f.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to write upload file: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess.Offset = offset + n
+	if err := s.save(sess); err != nil {
+		return nil, err
+	}
+	if copyErr != nil {
+		return sess, fmt.Errorf("upload interrupted: %w", copyErr)
+	}
+	return sess, nil
+}
+
+// Data returns a complete session and the received file.
+func (s *Store) Data(id string) (*Session, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, err := s.load(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.busy[id] || sess.Offset != sess.Size {
+		return sess, nil, ErrIncomplete
+	}
+	data, err := os.ReadFile(s.dataPath(id))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read upload file: %w", err)
+	}
+	return sess, data, nil
+}
+
+// Delete removes a session and its data. It fails with ErrBusy while a
+// chunk is written.
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.load(id); err != nil {
+		return err
+	}
+	if s.busy[id] {
+		return ErrBusy
+	}
+	s.remove(id)
+	return nil
+}
+
+// sweep removes expired sessions, data files without metadata and
+// leftovers of interrupted metadata writes. The caller holds s.mu.
+func (s *Store) sweep(now time.Time) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			os.Remove(filepath.Join(s.dir, e.Name()))
+			continue
+		}
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			id, ok = strings.CutSuffix(e.Name(), ".part")
+			if ok {
+				if _, err := os.Stat(s.metaPath(id)); os.IsNotExist(err) {
+					os.Remove(s.dataPath(id))
+				}
+			}
+			continue
+		}
+		if s.busy[id] {
+			continue
+		}
+		sess, err := s.read(id)
+		if err != nil || now.After(sess.ExpiresAt) {
+			s.remove(id)
+		}
+	}
+}
+
+// load returns an unexpired session. The caller holds s.mu.
+func (s *Store) load(id string) (*Session, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	sess, err := s.read(id)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(sess.ExpiresAt) {
+		if !s.busy[id] {
+			s.remove(id)
+		}
+		return nil, ErrNotFound
+	}
+	return sess, nil
+}
+
+func (s *Store) read(id string) (*Session, error) {
+	data, err := os.ReadFile(s.metaPath(id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload session: %w", err)
+	}
+	var sess Session
+	if err := json.Unmarshal(data, &sess); err != nil {
+		return nil, fmt.Errorf("invalid upload session %s: %w", id, err)
+	}
+	return &sess, nil
+}
+
+// save writes the session metadata atomically. The caller holds s.mu.
+func (s *Store) save(sess *Session) error {
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+	tmp := s.metaPath(sess.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save upload session: %w", err)
+	}
+	if err := os.Rename(tmp, s.metaPath(sess.ID)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to save upload session: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) remove(id string) {
+	os.Remove(s.metaPath(id))
+	os.Remove(s.dataPath(id))
+}
+
+func (s *Store) metaPath(id string) string { return filepath.Join(s.dir, id+".json") }
+func (s *Store) dataPath(id string) string { return filepath.Join(s.dir, id+".part") }
+
+// validID reports whether id has the form generateID produces, so it can be
+// used in a file name.
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil && strings.ToLower(id) == id
+}
+
+func generateID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate upload ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
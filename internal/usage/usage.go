@@ -0,0 +1,342 @@
+// Package usage provides usage accounting: monthly counters of queries and
+// embedding/LLM API tokens per user and per product, and the quotas enforced
+// on them. Limits come from the instance-wide usage.* settings and can be
+// overridden for individual users or products.
+package usage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"askflow/internal/config"
+)
+
+// Subject scopes. Every query is counted once for its user and once for its
+// product.
+const (
+	ScopeUser    = "user"
+	ScopeProduct = "product"
+)
+
+// Quota metrics.
+const (
+	MetricQueries = "queries"
+	MetricTokens  = "tokens"
+)
+
+// ErrQuotaNotFound is returned by DeleteOverride when the subject has no override.
+var ErrQuotaNotFound = errors.New("usage quota not found")
+
+// Tokens is the API token consumption of one or more queries.
+type Tokens struct {
+	Embedding  int64 `json:"embedding_tokens"`
+	Prompt     int64 `json:"prompt_tokens"`
+	Completion int64 `json:"completion_tokens"`
+}
+
+// Total returns the tokens counted against the monthly token quota.
+func (t Tokens) Total() int64 {
+	return t.Embedding + t.Prompt + t.Completion
+}
+
+// Counters are a subject's consumption in one period.
+type Counters struct {
+	Queries int64 `json:"queries"`
+	Tokens
+	TotalTokens int64 `json:"total_tokens"`
+}
+
+// Limits are monthly quotas. Zero means unlimited.
+type Limits struct {
+	MaxMonthlyQueries int64 `json:"max_monthly_queries"`
+	MaxMonthlyTokens  int64 `json:"max_monthly_tokens"`
+}
+
+// Record is one row of a usage report.
+type Record struct {
+	Period    string `json:"period"`
+	Scope     string `json:"scope"`
+	SubjectID string `json:"subject_id"`
+	Name      string `json:"name,omitempty"` // user name/email or product name
+	Counters
+	Limits Limits `json:"limits"`
+}
+
+// Override replaces the default limits for one user or product.
+type Override struct {
+	Scope     string `json:"scope"`
+	SubjectID string `json:"subject_id"`
+	Limits
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// QuotaError reports that a subject has used up a monthly quota.
+type QuotaError struct {
+	Scope     string    `json:"scope"`
+	SubjectID string    `json:"subject_id"`
+	Metric    string    `json:"metric"`
+	Limit     int64     `json:"limit"`
+	Used      int64     `json:"used"`
+	Period    string    `json:"period"`
+	ResetsAt  time.Time `json:"resets_at"`
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("usage quota exceeded: %s %s %s (%d/%d in %s)", e.Scope, e.SubjectID, e.Metric, e.Used, e.Limit, e.Period)
+}
+
+// Service records usage and checks quotas.
+type Service struct {
+	readDB   *sql.DB
+	writeDB  *sql.DB
+	defaults func() config.UsageConfig
+}
+
+// NewService creates a usage Service. defaults returns the current
+// instance-wide limits.
+func NewService(readDB, writeDB *sql.DB, defaults func() config.UsageConfig) *Service {
+	return &Service{readDB: readDB, writeDB: writeDB, defaults: defaults}
+}
+
+// CurrentPeriod returns the accounting period of now: the UTC month as YYYY-MM.
+func CurrentPeriod() string {
+	return time.Now().UTC().Format("2006-01")
+}
+
+// ValidPeriod reports whether p is a YYYY-MM period.
+func ValidPeriod(p string) bool {
+	_, err := time.Parse("2006-01", p)
+	return err == nil
+}
+
+// periodEnd returns the start of the month after period.
+func periodEnd(period string) time.Time {
+	t, _ := time.Parse("2006-01", period)
+	return t.AddDate(0, 1, 0)
+}
+
+// validScope reports whether scope is a known subject scope.
+func validScope(scope string) bool {
+	return scope == ScopeUser || scope == ScopeProduct
+}
+
+// Check returns a *QuotaError when the user or the product has used up a
+// monthly quota in the current period. Empty IDs are not checked.
+func (s *Service) Check(userID, productID string) error {
+	period := CurrentPeriod()
+	for _, subj := range []struct{ scope, id string }{{ScopeUser, userID}, {ScopeProduct, productID}} {
+		if subj.id == "" {
+			continue
+		}
+		limits, err := s.Limits(subj.scope, subj.id)
+		if err != nil {
+			return err
+		}
+		if limits.MaxMonthlyQueries == 0 && limits.MaxMonthlyTokens == 0 {
+			continue
+		}
+		c, err := s.Get(period, subj.scope, subj.id)
+		if err != nil {
+			return err
+		}
+		quotaErr := func(metric string, limit, used int64) error {
+			return &QuotaError{
+				Scope: subj.scope, SubjectID: subj.id, Metric: metric,
+				Limit: limit, Used: used, Period: period, ResetsAt: periodEnd(period),
+			}
+		}
+		if limits.MaxMonthlyQueries > 0 && c.Queries >= limits.MaxMonthlyQueries {
+			return quotaErr(MetricQueries, limits.MaxMonthlyQueries, c.Queries)
+		}
+		if limits.MaxMonthlyTokens > 0 && c.TotalTokens >= limits.MaxMonthlyTokens {
+			return quotaErr(MetricTokens, limits.MaxMonthlyTokens, c.TotalTokens)
+		}
+	}
+	return nil
+}
+
+// Record counts one query and its tokens for the user and the product in the
+// current period. Empty IDs are skipped.
+func (s *Service) Record(userID, productID string, t Tokens) error {
+	period := CurrentPeriod()
+	tx, err := s.writeDB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for _, subj := range []struct{ scope, id string }{{ScopeUser, userID}, {ScopeProduct, productID}} {
+		if subj.id == "" {
+			continue
+		}
+		_, err := tx.Exec(
+			`INSERT INTO usage_counters (period, scope, subject_id, queries, embedding_tokens, prompt_tokens, completion_tokens, updated_at)
+			VALUES (?, ?, ?, 1, ?, ?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT(period, scope, subject_id) DO UPDATE SET
+				queries = queries + 1,
+				embedding_tokens = embedding_tokens + excluded.embedding_tokens,
+				prompt_tokens = prompt_tokens + excluded.prompt_tokens,
+				completion_tokens = completion_tokens + excluded.completion_tokens,
+				updated_at = CURRENT_TIMESTAMP`,
+			period, subj.scope, subj.id, t.Embedding, t.Prompt, t.Completion,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to record usage: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Get returns a subject's counters for a period.
+func (s *Service) Get(period, scope, subjectID string) (Counters, error) {
+	var c Counters
+	err := s.readDB.QueryRow(
+		`SELECT queries, embedding_tokens, prompt_tokens, completion_tokens
+		FROM usage_counters WHERE period = ? AND scope = ? AND subject_id = ?`,
+		period, scope, subjectID,
+	).Scan(&c.Queries, &c.Embedding, &c.Prompt, &c.Completion)
+	if err != nil && err != sql.ErrNoRows {
+		return c, fmt.Errorf("failed to read usage: %w", err)
+	}
+	c.TotalTokens = c.Total()
+	return c, nil
+}
+
+// Report returns the counters of all subjects for a period, optionally
+// narrowed to one scope and subject, with their effective limits. Rows are
+// ordered by total tokens, highest first.
+func (s *Service) Report(period, scope, subjectID string) ([]Record, error) {
+	q := `SELECT c.scope, c.subject_id,
+			CASE c.scope
+				WHEN 'user' THEN COALESCE((SELECT COALESCE(NULLIF(u.name, ''), u.email) FROM users u WHERE u.id = c.subject_id), '')
+				WHEN 'product' THEN COALESCE((SELECT p.name FROM products p WHERE p.id = c.subject_id), '')
+				ELSE '' END,
+			c.queries, c.embedding_tokens, c.prompt_tokens, c.completion_tokens
+		FROM usage_counters c WHERE c.period = ?`
+	args := []interface{}{period}
+	if scope != "" {
+		q += ` AND c.scope = ?`
+		args = append(args, scope)
+	}
+	if subjectID != "" {
+		q += ` AND c.subject_id = ?`
+		args = append(args, subjectID)
+	}
+	q += ` ORDER BY (c.embedding_tokens + c.prompt_tokens + c.completion_tokens) DESC, c.queries DESC`
+	rows, err := s.readDB.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query usage: %w", err)
+	}
+	defer rows.Close()
+
+	records := []Record{}
+	for rows.Next() {
+		r := Record{Period: period}
+		if err := rows.Scan(&r.Scope, &r.SubjectID, &r.Name, &r.Queries, &r.Embedding, &r.Prompt, &r.Completion); err != nil {
+			return nil, err
+		}
+		r.TotalTokens = r.Total()
+		records = append(records, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range records {
+		limits, err := s.Limits(records[i].Scope, records[i].SubjectID)
+		if err != nil {
+			return nil, err
+		}
+		records[i].Limits = limits
+	}
+	return records, nil
+}
+
+// Limits returns the effective limits of a subject: its override if one is
+// set, otherwise the instance-wide default for its scope.
+func (s *Service) Limits(scope, subjectID string) (Limits, error) {
+	var l Limits
+	err := s.readDB.QueryRow(
+		`SELECT max_monthly_queries, max_monthly_tokens FROM usage_quotas WHERE scope = ? AND subject_id = ?`,
+		scope, subjectID,
+	).Scan(&l.MaxMonthlyQueries, &l.MaxMonthlyTokens)
+	if err == nil {
+		return l, nil
+	}
+	if err != sql.ErrNoRows {
+		return l, fmt.Errorf("failed to read usage quota: %w", err)
+	}
+	d := s.defaults()
+	switch scope {
+	case ScopeUser:
+		return Limits{MaxMonthlyQueries: d.UserMonthlyQueries, MaxMonthlyTokens: d.UserMonthlyTokens}, nil
+	case ScopeProduct:
+		return Limits{MaxMonthlyQueries: d.ProductMonthlyQueries, MaxMonthlyTokens: d.ProductMonthlyTokens}, nil
+	}
+	return l, nil
+}
+
+// ListOverrides returns all per-subject quota overrides.
+func (s *Service) ListOverrides() ([]Override, error) {
+	rows, err := s.readDB.Query(
+		`SELECT scope, subject_id, max_monthly_queries, max_monthly_tokens, updated_at
+		FROM usage_quotas ORDER BY scope, subject_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list usage quotas: %w", err)
+	}
+	defer rows.Close()
+	overrides := []Override{}
+	for rows.Next() {
+		var o Override
+		var updatedAt sql.NullTime
+		if err := rows.Scan(&o.Scope, &o.SubjectID, &o.MaxMonthlyQueries, &o.MaxMonthlyTokens, &updatedAt); err != nil {
+			return nil, err
+		}
+		if updatedAt.Valid {
+			o.UpdatedAt = updatedAt.Time
+		}
+		overrides = append(overrides, o)
+	}
+	return overrides, rows.Err()
+}
+
+// SetOverride sets the limits of one user or product. Zero limits make the
+// subject unlimited regardless of the defaults.
+func (s *Service) SetOverride(scope, subjectID string, l Limits) error {
+	if !validScope(scope) {
+		return fmt.Errorf("invalid scope %q", scope)
+	}
+	if subjectID == "" {
+		return fmt.Errorf("subject_id is required")
+	}
+	if l.MaxMonthlyQueries < 0 || l.MaxMonthlyTokens < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	_, err := s.writeDB.Exec(
+		`INSERT INTO usage_quotas (scope, subject_id, max_monthly_queries, max_monthly_tokens, updated_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(scope, subject_id) DO UPDATE SET
+			max_monthly_queries = excluded.max_monthly_queries,
+			max_monthly_tokens = excluded.max_monthly_tokens,
+			updated_at = CURRENT_TIMESTAMP`,
+		scope, subjectID, l.MaxMonthlyQueries, l.MaxMonthlyTokens,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set usage quota: %w", err)
+	}
+	return nil
+}
+
+// DeleteOverride removes a subject's override so the defaults apply again.
+func (s *Service) DeleteOverride(scope, subjectID string) error {
+	res, err := s.writeDB.Exec(`DELETE FROM usage_quotas WHERE scope = ? AND subject_id = ?`, scope, subjectID)
+	if err != nil {
+		return fmt.Errorf("failed to delete usage quota: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrQuotaNotFound
+	}
+	return nil
+}
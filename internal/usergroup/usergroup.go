@@ -0,0 +1,451 @@
+// Package usergroup keeps end-user groups and the documents and products
+// they may see. Listing a product or document in a group restricts it to
+// the members of the groups that list it: everyone else, including
+// anonymous users, never retrieves it. Unlisted products and documents stay
+// visible to all. A document listed itself follows its own groups rather
+// than those of its product, so a single internal troubleshooting guide can
+// be restricted within a public product, or a single guide shared from an
+// internal one.
+//
+// Members are matched by the email address of their account, either listed
+// one by one or by domain. Only verified addresses count.
+package usergroup
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"askflow/internal/vectorstore"
+)
+
+// ErrNotFound is returned for an unknown group.
+var ErrNotFound = errors.New("not found")
+
+// Limits of the groups and their contents.
+const (
+	maxGroups    = 100
+	maxMembers   = 1000
+	maxDomains   = 50
+	maxResources = 1000
+)
+
+// Group is a set of end users and the products and documents restricted to
+// them.
+type Group struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Emails      []string  `json:"emails"`  // member addresses, lowercased
+	Domains     []string  `json:"domains"` // members by email domain, e.g. example.com
+	ProductIDs  []string  `json:"product_ids"`
+	DocumentIDs []string  `json:"document_ids"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// has reports whether a member with the verified address email belongs to g.
+func (g *Group) has(email string) bool {
+	if email == "" {
+		return false
+	}
+	for _, e := range g.Emails {
+		if e == email {
+			return true
+		}
+	}
+	_, domain, _ := strings.Cut(email, "@")
+	for _, d := range g.Domains {
+		if d == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// Service stores the groups and works out the documents a user may not see.
+type Service struct {
+	readDB  *sql.DB
+	writeDB *sql.DB
+
+	// groups caches all groups; they are few and needed for every question.
+	mu     sync.Mutex
+	groups []*Group
+}
+
+// NewService creates a user group Service.
+func NewService(readDB, writeDB *sql.DB) *Service {
+	return &Service{readDB: readDB, writeDB: writeDB}
+}
+
+// normalize trims, lowercases and deduplicates terms, dropping empty ones.
+func normalize(terms []string, lower bool) []string {
+	seen := make(map[string]bool, len(terms))
+	out := make([]string, 0, len(terms))
+	for _, t := range terms {
+		t = strings.TrimSpace(t)
+		if lower {
+			t = strings.ToLower(t)
+		}
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	return out
+}
+
+// validate normalises and checks a group, including that the products and
+// documents it lists exist. Those old, the group being replaced, listed
+// already are kept even if they have been deleted since.
+func (s *Service) validate(g *Group, old *Group) error {
+	g.Name = strings.TrimSpace(g.Name)
+	if g.Name == "" || utf8.RuneCountInString(g.Name) > 100 {
+		return errors.New("name is required (max 100 characters)")
+	}
+	g.Description = strings.TrimSpace(g.Description)
+	if utf8.RuneCountInString(g.Description) > 500 {
+		return errors.New("description is too long (max 500 characters)")
+	}
+	g.Emails = normalize(g.Emails, true)
+	if len(g.Emails) > maxMembers {
+		return fmt.Errorf("too many members (max %d)", maxMembers)
+	}
+	for _, e := range g.Emails {
+		if local, domain, ok := strings.Cut(e, "@"); !ok || local == "" || !validDomain(domain) {
+			return fmt.Errorf("invalid email %q", e)
+		}
+	}
+	g.Domains = normalize(g.Domains, true)
+	if len(g.Domains) > maxDomains {
+		return fmt.Errorf("too many domains (max %d)", maxDomains)
+	}
+	for i, d := range g.Domains {
+		d = strings.TrimPrefix(d, "@")
+		if !validDomain(d) {
+			return fmt.Errorf("invalid domain %q", d)
+		}
+		g.Domains[i] = d
+	}
+	g.ProductIDs = normalize(g.ProductIDs, false)
+	g.DocumentIDs = normalize(g.DocumentIDs, false)
+	if len(g.ProductIDs)+len(g.DocumentIDs) > maxResources {
+		return fmt.Errorf("too many products and documents (max %d)", maxResources)
+	}
+	var oldProducts, oldDocuments []string
+	if old != nil {
+		oldProducts, oldDocuments = old.ProductIDs, old.DocumentIDs
+	}
+	if err := s.checkExist("products", g.ProductIDs, oldProducts); err != nil {
+		return err
+	}
+	return s.checkExist("documents", g.DocumentIDs, oldDocuments)
+}
+
+// checkExist returns an error naming the first of ids that is neither in
+// table nor in known.
+func (s *Service) checkExist(table string, ids, known []string) error {
+	listed := make(map[string]bool, len(known))
+	for _, id := range known {
+		listed[id] = true
+	}
+	for _, id := range ids {
+		if listed[id] {
+			continue
+		}
+		var n int
+		if err := s.readDB.QueryRow(`SELECT COUNT(*) FROM `+table+` WHERE id = ?`, id).Scan(&n); err != nil {
+			return fmt.Errorf("failed to look up %s: %w", table, err)
+		}
+		if n == 0 {
+			return fmt.Errorf("unknown %s %q", strings.TrimSuffix(table, "s"), id)
+		}
+	}
+	return nil
+}
+
+// validDomain reports whether d looks like an email domain. Requiring a dot
+// keeps out the placeholder domains of widget visitors and channel users.
+func validDomain(d string) bool {
+	if len(d) > 253 || !strings.Contains(d, ".") || strings.HasPrefix(d, ".") || strings.HasSuffix(d, ".") {
+		return false
+	}
+	for _, c := range d {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c >= 0x80) {
+			return false
+		}
+	}
+	return true
+}
+
+// load returns the cached groups, reading them from the database if needed.
+// s.mu must be held.
+func (s *Service) load() ([]*Group, error) {
+	if s.groups != nil {
+		return s.groups, nil
+	}
+	rows, err := s.readDB.Query(`SELECT id, name, description, emails, domains, product_ids, document_ids, created_at, updated_at
+		FROM user_groups ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	groups := []*Group{}
+	for rows.Next() {
+		var g Group
+		var emails, domains, products, documents string
+		if err := rows.Scan(&g.ID, &g.Name, &g.Description, &emails, &domains, &products, &documents, &g.CreatedAt, &g.UpdatedAt); err != nil {
+			return nil, err
+		}
+		for _, f := range []struct {
+			data string
+			dst  *[]string
+		}{{emails, &g.Emails}, {domains, &g.Domains}, {products, &g.ProductIDs}, {documents, &g.DocumentIDs}} {
+			if err := json.Unmarshal([]byte(f.data), f.dst); err != nil {
+				return nil, fmt.Errorf("invalid lists of group %s: %w", g.ID, err)
+			}
+		}
+		groups = append(groups, &g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	s.groups = groups
+	return groups, nil
+}
+
+// invalidate drops the cached groups after a change.
+func (s *Service) invalidate() {
+	s.mu.Lock()
+	s.groups = nil
+	s.mu.Unlock()
+}
+
+// List returns all groups in the order they were created.
+func (s *Service) List() ([]Group, error) {
+	s.mu.Lock()
+	groups, err := s.load()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Group, len(groups))
+	for i, g := range groups {
+		list[i] = *g
+	}
+	return list, nil
+}
+
+// Get returns a group by ID.
+func (s *Service) Get(id string) (*Group, error) {
+	s.mu.Lock()
+	groups, err := s.load()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		if g.ID == id {
+			out := *g
+			return &out, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Create adds a group.
+func (s *Service) Create(g Group) (*Group, error) {
+	if err := s.validate(&g, nil); err != nil {
+		return nil, err
+	}
+	var n int
+	if err := s.readDB.QueryRow(`SELECT COUNT(*) FROM user_groups`).Scan(&n); err != nil {
+		return nil, fmt.Errorf("failed to count groups: %w", err)
+	}
+	if n >= maxGroups {
+		return nil, fmt.Errorf("too many groups (max %d)", maxGroups)
+	}
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	g.ID = id
+	g.CreatedAt = time.Now().UTC()
+	g.UpdatedAt = g.CreatedAt
+	emails, domains, products, documents := marshalLists(&g)
+	if _, err := s.writeDB.Exec(
+		`INSERT INTO user_groups (id, name, description, emails, domains, product_ids, document_ids, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		g.ID, g.Name, g.Description, emails, domains, products, documents, g.CreatedAt, g.UpdatedAt,
+	); err != nil {
+		return nil, fmt.Errorf("failed to save group: %w", err)
+	}
+	s.invalidate()
+	return &g, nil
+}
+
+// Update replaces the group g.ID.
+func (s *Service) Update(g Group) (*Group, error) {
+	old, err := s.Get(g.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.validate(&g, old); err != nil {
+		return nil, err
+	}
+	g.CreatedAt = old.CreatedAt
+	g.UpdatedAt = time.Now().UTC()
+	emails, domains, products, documents := marshalLists(&g)
+	if _, err := s.writeDB.Exec(
+		`UPDATE user_groups SET name = ?, description = ?, emails = ?, domains = ?, product_ids = ?, document_ids = ?, updated_at = ? WHERE id = ?`,
+		g.Name, g.Description, emails, domains, products, documents, g.UpdatedAt, g.ID,
+	); err != nil {
+		return nil, fmt.Errorf("failed to save group: %w", err)
+	}
+	s.invalidate()
+	return &g, nil
+}
+
+// Delete removes a group. What only it listed becomes visible to everyone.
+func (s *Service) Delete(id string) error {
+	res, err := s.writeDB.Exec(`DELETE FROM user_groups WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete group: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	s.invalidate()
+	return nil
+}
+
+// ForgetEmail removes an address from the members of every group, for
+// users who are erased.
+func (s *Service) ForgetEmail(email string) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+	groups, err := s.List()
+	if err != nil {
+		return err
+	}
+	for _, g := range groups {
+		kept := []string{}
+		for _, e := range g.Emails {
+			if e != email {
+				kept = append(kept, e)
+			}
+		}
+		if len(kept) == len(g.Emails) {
+			continue
+		}
+		data, _ := json.Marshal(kept)
+		if _, err := s.writeDB.Exec(`UPDATE user_groups SET emails = ?, updated_at = ? WHERE id = ?`, string(data), time.Now().UTC(), g.ID); err != nil {
+			return fmt.Errorf("failed to update group %s: %w", g.ID, err)
+		}
+	}
+	s.invalidate()
+	return nil
+}
+
+// verifiedEmail returns the lowercased address of a user whose address is
+// verified, or "" for other and unknown users.
+func (s *Service) verifiedEmail(userID string) (string, error) {
+	var email string
+	var verified bool
+	err := s.readDB.QueryRow(`SELECT COALESCE(email, ''), COALESCE(email_verified, 0) FROM users WHERE id = ?`, userID).Scan(&email, &verified)
+	if errors.Is(err, sql.ErrNoRows) || !verified {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up user: %w", err)
+	}
+	return strings.ToLower(email), nil
+}
+
+// Filter returns the vector store filter hiding the documents userID may
+// not see, or nil when nothing is hidden from them.
+func (s *Service) Filter(userID string) (*vectorstore.Filter, error) {
+	groups, err := s.List()
+	if err != nil || len(groups) == 0 {
+		return nil, err
+	}
+	email, err := s.verifiedEmail(userID)
+	if err != nil {
+		return nil, err
+	}
+	restrictedDocs := make(map[string]bool)
+	restrictedProducts := make(map[string]bool)
+	allowedDocs := make(map[string]bool)
+	allowedProducts := make(map[string]bool)
+	for i := range groups {
+		g := &groups[i]
+		member := g.has(email)
+		for _, id := range g.DocumentIDs {
+			restrictedDocs[id] = true
+			allowedDocs[id] = allowedDocs[id] || member
+		}
+		for _, id := range g.ProductIDs {
+			restrictedProducts[id] = true
+			allowedProducts[id] = allowedProducts[id] || member
+		}
+	}
+
+	var hidden []string
+	for id := range restrictedDocs {
+		if !allowedDocs[id] {
+			hidden = append(hidden, id)
+		}
+	}
+	var hiddenProducts []interface{}
+	for id := range restrictedProducts {
+		if !allowedProducts[id] {
+			hiddenProducts = append(hiddenProducts, id)
+		}
+	}
+	if len(hiddenProducts) > 0 {
+		// Documents of hidden products, except those with groups of their own
+		rows, err := s.readDB.Query(`SELECT id FROM documents WHERE product_id IN (?`+strings.Repeat(", ?", len(hiddenProducts)-1)+`)`, hiddenProducts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list documents of restricted products: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				return nil, err
+			}
+			if !restrictedDocs[id] {
+				hidden = append(hidden, id)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return vectorstore.NewFilter(hidden), nil
+}
+
+// marshalLists encodes the lists of g for storage.
+func marshalLists(g *Group) (emails, domains, products, documents string) {
+	enc := func(v []string) string {
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+	return enc(g.Emails), enc(g.Domains), enc(g.ProductIDs), enc(g.DocumentIDs)
+}
+
+// generateID creates a random hex string for use as a unique identifier.
+func generateID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
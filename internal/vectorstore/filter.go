@@ -0,0 +1,35 @@
+package vectorstore
+
+import (
+	"context"
+
+	sqlitevec "github.com/nicexipi/sqlite-vec"
+)
+
+// Filter hides documents from searches, e.g. those a user's groups may not
+// see. A nil *Filter hides nothing.
+type Filter = sqlitevec.Filter
+
+// NewFilter returns a Filter hiding the listed documents, or nil when the
+// list is empty.
+func NewFilter(hiddenDocIDs []string) *Filter {
+	return sqlitevec.NewFilter(hiddenDocIDs)
+}
+
+type filterKey struct{}
+
+// WithFilter returns a context whose searches skip the documents f hides.
+// Searches are cached per filter, so results never cross between users who
+// see different documents.
+func WithFilter(ctx context.Context, f *Filter) context.Context {
+	if f == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, filterKey{}, f)
+}
+
+// FilterFrom returns the filter set by WithFilter, or nil.
+func FilterFrom(ctx context.Context) *Filter {
+	f, _ := ctx.Value(filterKey{}).(*Filter)
+	return f
+}
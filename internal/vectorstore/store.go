@@ -4,19 +4,49 @@
 package vectorstore
 
 import (
+	"context"
 	"database/sql"
+	"sync/atomic"
+
+	"askflow/internal/db"
 
 	sqlitevec "github.com/nicexipi/sqlite-vec"
 )
 
 // VectorStore defines the interface for storing and searching document embeddings.
+// Searches return ctx's error without searching once ctx is done, and skip
+// the documents hidden by ctx's Filter (see WithFilter).
 type VectorStore interface {
 	Store(docID string, chunks []VectorChunk) error
-	Search(queryVector []float64, topK int, threshold float64, productID string) ([]SearchResult, error)
-	TextSearch(query string, topK int, threshold float64, productID string) ([]SearchResult, error)
+	Search(ctx context.Context, queryVector []float64, topK int, threshold float64, productID string) ([]SearchResult, error)
+	TextSearch(ctx context.Context, query string, topK int, threshold float64, productID string) ([]SearchResult, error)
+	SearchProducts(ctx context.Context, queryVector []float64, topK int, threshold float64, productIDs []string) ([]SearchResult, error)
+	TextSearchProducts(ctx context.Context, query string, topK int, threshold float64, productIDs []string) ([]SearchResult, error)
+	// SearchMMR and SearchProductsMMR pick results by Maximal Marginal
+	// Relevance; lambda in (0, 1] trades relevance against diversity.
+	SearchMMR(ctx context.Context, queryVector []float64, topK int, threshold, lambda float64, productID string) ([]SearchResult, error)
+	SearchProductsMMR(ctx context.Context, queryVector []float64, topK int, threshold, lambda float64, productIDs []string) ([]SearchResult, error)
+	// Dedup drops near-duplicate results, keeping the highest-scored of each
+	// group; threshold 1 or more turns it off.
+	Dedup(results []SearchResult, threshold float64) []SearchResult
 	DeleteByDocID(docID string) error
+	// SetDocumentBoosts sets the factors the search scores of the listed
+	// documents' chunks are multiplied by; other documents keep 1.
+	SetDocumentBoosts(boosts map[string]float64)
+	// Generation changes whenever chunks are stored or deleted, so callers
+	// can tell whether results they derived from the store are still current.
+	Generation() uint64
+	// EncodeText returns chunk text as stored in the chunk_text column, and
+	// DecodeText the text of a stored value, for code that reads the chunks
+	// table directly.
+	EncodeText(text string) (string, error)
+	DecodeText(stored string) (string, error)
 }
 
+// TextCodec transforms chunk text in the chunks table and snapshot file,
+// e.g. to encrypt it at rest (see SetTextCodec).
+type TextCodec = sqlitevec.TextCodec
+
 // VectorChunk represents a document chunk with its embedding vector.
 type VectorChunk struct {
 	ChunkText    string    `json:"chunk_text"`
@@ -43,7 +73,8 @@ type SearchResult struct {
 
 // SQLiteVectorStore wraps the sqlite-vec library's implementation.
 type SQLiteVectorStore struct {
-	inner *sqlitevec.SQLiteVectorStore
+	inner      *sqlitevec.SQLiteVectorStore
+	generation atomic.Uint64
 }
 
 // SIMDCapability returns a human-readable string describing the active SIMD
@@ -59,6 +90,79 @@ func NewSQLiteVectorStore(db *sql.DB) *SQLiteVectorStore {
 	}
 }
 
+// Warm loads the vector cache into memory ahead of the first search.
+func (s *SQLiteVectorStore) Warm() error {
+	return s.inner.Warm()
+}
+
+// Loaded reports whether the vector cache has been loaded into memory.
+func (s *SQLiteVectorStore) Loaded() bool {
+	return s.inner.Loaded()
+}
+
+// SetPartitionCache makes the cache load each product's chunks on its first
+// search, keeping about maxBytes in memory and evicting the least recently
+// searched products beyond that. It must be called before Warm.
+func (s *SQLiteVectorStore) SetPartitionCache(maxBytes int64) {
+	s.inner.SetPartitionCache(maxBytes)
+}
+
+// CompactResult reports what Compact did to the in-memory vector cache.
+type CompactResult = sqlitevec.CompactResult
+
+// Compact rebuilds the vector cache into tightly sized memory and drops
+// cached chunks that are no longer in the chunks table.
+func (s *SQLiteVectorStore) Compact() (CompactResult, error) {
+	return s.inner.Compact()
+}
+
+// Refresh reloads the vector cache when another process changed the chunks
+// table since the previous call, for a store on a database it only reads.
+// The first call, made before Warm, records the table's version.
+func (s *SQLiteVectorStore) Refresh() (bool, error) {
+	changed, err := s.inner.Refresh()
+	if changed {
+		s.generation.Add(1)
+	}
+	return changed, err
+}
+
+// EnableSnapshot keeps a snapshot of the vector cache in the file at path,
+// so that later starts load it instead of reading every chunk row. It must
+// be called before Warm.
+func (s *SQLiteVectorStore) EnableSnapshot(path string) {
+	s.inner.EnableSnapshot(path)
+}
+
+// SaveSnapshot writes the snapshot file now rather than after the usual
+// delay following a change.
+func (s *SQLiteVectorStore) SaveSnapshot() error {
+	return s.inner.SaveSnapshot()
+}
+
+// SetTextCodec sets the codec chunk text is stored with. Text stored
+// otherwise is still read; RecodeText rewrites it. It must be called before
+// Warm.
+func (s *SQLiteVectorStore) SetTextCodec(c TextCodec) {
+	s.inner.SetTextCodec(c)
+}
+
+// RecodeText rewrites the chunks whose text is not stored as the current
+// codec would store it and returns how many it rewrote.
+func (s *SQLiteVectorStore) RecodeText() (int, error) {
+	return s.inner.RecodeText()
+}
+
+// EncodeText returns text as stored in the chunk_text column.
+func (s *SQLiteVectorStore) EncodeText(text string) (string, error) {
+	return s.inner.EncodeText(text)
+}
+
+// DecodeText returns the text of a chunk_text value.
+func (s *SQLiteVectorStore) DecodeText(stored string) (string, error) {
+	return s.inner.DecodeText(stored)
+}
+
 // toLibChunks converts local VectorChunk slice to library VectorChunk slice.
 func toLibChunks(chunks []VectorChunk) []sqlitevec.VectorChunk {
 	out := make([]sqlitevec.VectorChunk, len(chunks))
@@ -76,6 +180,25 @@ func toLibChunks(chunks []VectorChunk) []sqlitevec.VectorChunk {
 	return out
 }
 
+// toLibResults converts local SearchResult slice to library SearchResult slice.
+func toLibResults(results []SearchResult) []sqlitevec.SearchResult {
+	out := make([]sqlitevec.SearchResult, len(results))
+	for i, r := range results {
+		out[i] = sqlitevec.SearchResult{
+			ChunkText:    r.ChunkText,
+			ChunkIndex:   r.ChunkIndex,
+			DocumentID:   r.DocumentID,
+			DocumentName: r.DocumentName,
+			Score:        r.Score,
+			ImageURL:     r.ImageURL,
+			PartitionID:  r.ProductID,
+			StartTime:    r.StartTime,
+			EndTime:      r.EndTime,
+		}
+	}
+	return out
+}
+
 // fromLibResults converts library SearchResult slice to local SearchResult slice.
 func fromLibResults(results []sqlitevec.SearchResult) []SearchResult {
 	out := make([]SearchResult, len(results))
@@ -97,12 +220,17 @@ func fromLibResults(results []sqlitevec.SearchResult) []SearchResult {
 
 // Store inserts a batch of VectorChunks into the chunks table and updates the cache.
 func (s *SQLiteVectorStore) Store(docID string, chunks []VectorChunk) error {
-	return s.inner.Store(docID, toLibChunks(chunks))
+	libChunks := toLibChunks(chunks)
+	defer s.generation.Add(1)
+	return db.RetryBusy(func() error { return s.inner.Store(docID, libChunks) })
 }
 
 // Search performs cosine similarity search against stored vectors.
-func (s *SQLiteVectorStore) Search(queryVector []float64, topK int, threshold float64, productID string) ([]SearchResult, error) {
-	results, err := s.inner.Search(queryVector, topK, threshold, productID)
+func (s *SQLiteVectorStore) Search(ctx context.Context, queryVector []float64, topK int, threshold float64, productID string) ([]SearchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	results, err := s.inner.Search(queryVector, topK, threshold, productID, FilterFrom(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -110,15 +238,94 @@ func (s *SQLiteVectorStore) Search(queryVector []float64, topK int, threshold fl
 }
 
 // TextSearch performs text-based similarity search.
-func (s *SQLiteVectorStore) TextSearch(query string, topK int, threshold float64, productID string) ([]SearchResult, error) {
-	results, err := s.inner.TextSearch(query, topK, threshold, productID)
+func (s *SQLiteVectorStore) TextSearch(ctx context.Context, query string, topK int, threshold float64, productID string) ([]SearchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	results, err := s.inner.TextSearch(query, topK, threshold, productID, FilterFrom(ctx))
 	if err != nil {
 		return nil, err
 	}
 	return fromLibResults(results), nil
 }
 
+// SearchProducts performs cosine similarity search restricted to exactly the
+// given product IDs; the public library ("") is only included when listed.
+func (s *SQLiteVectorStore) SearchProducts(ctx context.Context, queryVector []float64, topK int, threshold float64, productIDs []string) ([]SearchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	results, err := s.inner.SearchPartitions(queryVector, topK, threshold, productIDs, FilterFrom(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return fromLibResults(results), nil
+}
+
+// TextSearchProducts performs text-based similarity search restricted to
+// exactly the given product IDs.
+func (s *SQLiteVectorStore) TextSearchProducts(ctx context.Context, query string, topK int, threshold float64, productIDs []string) ([]SearchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	results, err := s.inner.TextSearchPartitions(query, topK, threshold, productIDs, FilterFrom(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return fromLibResults(results), nil
+}
+
+// SearchMMR performs cosine similarity search and picks the results by
+// Maximal Marginal Relevance, so that near-duplicate chunks make room for
+// complementary ones.
+func (s *SQLiteVectorStore) SearchMMR(ctx context.Context, queryVector []float64, topK int, threshold, lambda float64, productID string) ([]SearchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	results, err := s.inner.SearchMMR(queryVector, topK, threshold, lambda, productID, FilterFrom(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return fromLibResults(results), nil
+}
+
+// SearchProductsMMR is SearchMMR restricted to exactly the given product IDs.
+func (s *SQLiteVectorStore) SearchProductsMMR(ctx context.Context, queryVector []float64, topK int, threshold, lambda float64, productIDs []string) ([]SearchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	results, err := s.inner.SearchPartitionsMMR(queryVector, topK, threshold, lambda, productIDs, FilterFrom(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return fromLibResults(results), nil
+}
+
+// Dedup drops near-duplicate results, such as the same passage from a
+// re-uploaded or overlapping document, comparing the cached embeddings and
+// text bigrams of their chunks. The highest-scored result of each group is
+// kept and the order is unchanged.
+func (s *SQLiteVectorStore) Dedup(results []SearchResult, threshold float64) []SearchResult {
+	if len(results) < 2 || threshold >= 1 {
+		return results
+	}
+	return fromLibResults(s.inner.Dedup(toLibResults(results), threshold))
+}
+
+// SetDocumentBoosts sets the factors the search scores of the listed
+// documents' chunks are multiplied by, replacing earlier ones.
+func (s *SQLiteVectorStore) SetDocumentBoosts(boosts map[string]float64) {
+	s.inner.SetDocumentBoosts(boosts)
+}
+
 // DeleteByDocID removes all chunks for the given document.
 func (s *SQLiteVectorStore) DeleteByDocID(docID string) error {
-	return s.inner.DeleteByDocID(docID)
+	defer s.generation.Add(1)
+	return db.RetryBusy(func() error { return s.inner.DeleteByDocID(docID) })
+}
+
+// Generation returns a counter that increases on every Store and
+// DeleteByDocID call, including failed ones.
+func (s *SQLiteVectorStore) Generation() uint64 {
+	return s.generation.Load()
 }
@@ -0,0 +1,164 @@
+package video
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"math/bits"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 关键帧抽取方式
+const (
+	KeyframeModeScene    = "scene"    // 在画面变化处抽帧（默认）
+	KeyframeModeInterval = "interval" // 每 KeyframeInterval 秒抽一帧
+)
+
+// 场景检测与去重的默认值
+const (
+	defaultSceneThreshold = 0.3
+	defaultMaxKeyframes   = 200
+	defaultDedupDistance  = 6
+)
+
+// ptsTimePattern 匹配 showinfo 滤镜日志中每个输出帧的时间
+var ptsTimePattern = regexp.MustCompile(`pts_time:\s*(-?[0-9.]+)`)
+
+// sceneMode 报告是否按场景变化抽帧
+func (p *Parser) sceneMode() bool {
+	return p.KeyframeMode != KeyframeModeInterval
+}
+
+// keyframeFilter 返回抽帧用的 ffmpeg 视频滤镜。场景模式下第一帧总被选中，之后
+// 只选场景变化得分超过 SceneThreshold 的帧；showinfo 把选中帧的时间写入日志
+func (p *Parser) keyframeFilter() string {
+	if !p.sceneMode() {
+		return fmt.Sprintf("fps=1/%d", p.KeyframeInterval)
+	}
+	threshold := p.SceneThreshold
+	if threshold <= 0 || threshold >= 1 {
+		threshold = defaultSceneThreshold
+	}
+	return fmt.Sprintf("select='eq(n,0)+gt(scene,%s)',showinfo", strconv.FormatFloat(threshold, 'f', -1, 64))
+}
+
+// sceneTimes 从 showinfo 日志中依次取出选中帧的时间（秒）
+func sceneTimes(logs []byte) []float64 {
+	var times []float64
+	for _, m := range ptsTimePattern.FindAllSubmatch(logs, -1) {
+		t, err := strconv.ParseFloat(string(m[1]), 64)
+		if err != nil || t < 0 {
+			t = 0
+		}
+		times = append(times, t)
+	}
+	return times
+}
+
+// lastLines 返回 ffmpeg 日志的最后 n 行，showinfo 的逐帧日志不放进错误信息
+func lastLines(logs []byte, n int) string {
+	lines := strings.Split(strings.TrimSpace(string(logs)), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// reduceKeyframes 去掉与前一保留帧感知哈希几乎相同的帧（静止的幻灯片、暂停的
+// 画面），再在超过 MaxKeyframes 时均匀保留其中的 MaxKeyframes 帧。无法解码的帧
+// 保留
+func (p *Parser) reduceKeyframes(frames []Keyframe) []Keyframe {
+	distance := p.DedupDistance
+	if distance == 0 {
+		distance = defaultDedupDistance
+	}
+	if distance > 0 && len(frames) > 1 {
+		kept := frames[:0]
+		var last uint64
+		haveLast := false
+		for _, kf := range frames {
+			h, err := fileHash(kf.FilePath)
+			if err != nil {
+				kept = append(kept, kf)
+				continue
+			}
+			if haveLast && bits.OnesCount64(h^last) <= distance {
+				os.Remove(kf.FilePath)
+				continue
+			}
+			last, haveLast = h, true
+			kept = append(kept, kf)
+		}
+		frames = kept
+	}
+
+	limit := p.MaxKeyframes
+	if limit <= 0 {
+		limit = defaultMaxKeyframes
+	}
+	if len(frames) <= limit {
+		return frames
+	}
+	picked := make([]Keyframe, 0, limit)
+	for j := 0; j < limit; j++ {
+		idx := 0
+		if limit > 1 {
+			idx = j * (len(frames) - 1) / (limit - 1)
+		}
+		picked = append(picked, frames[idx])
+	}
+	return picked
+}
+
+// fileHash 计算帧图像文件的感知哈希
+func fileHash(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	return dHash(img), nil
+}
+
+// dHash 计算图像的差异哈希：把图像缩成 9x8 的灰度格，每一位表示一格是否比右侧
+// 相邻格暗。画面相近的图像哈希只差少数几位，对压缩噪声和轻微亮度变化不敏感
+func dHash(img image.Image) uint64 {
+	const cols, rows, samples = 9, 8, 4
+	b := img.Bounds()
+	if b.Dx() <= 0 || b.Dy() <= 0 {
+		return 0
+	}
+	var gray [rows][cols]float64
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			// 每格取 samples x samples 个采样点的平均亮度
+			var sum float64
+			for sy := 0; sy < samples; sy++ {
+				py := b.Min.Y + ((y*samples+sy)*2+1)*b.Dy()/(rows*samples*2)
+				for sx := 0; sx < samples; sx++ {
+					px := b.Min.X + ((x*samples+sx)*2+1)*b.Dx()/(cols*samples*2)
+					r, g, bl, _ := img.At(px, py).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+				}
+			}
+			gray[y][x] = sum
+		}
+	}
+	var h uint64
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols-1; x++ {
+			h <<= 1
+			if gray[y][x] < gray[y][x+1] {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
@@ -1,15 +1,21 @@
-// Package video provides video parsing functionality including RapidSpeech transcript
-// parsing, keyframe management, and serialization utilities.
+// Package video provides video parsing functionality including speech-to-text
+// transcription (RapidSpeech, whisper.cpp, OpenAI and Alibaba Cloud NLS),
+// keyframe management, and serialization utilities.
 package video
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 
 	"askflow/internal/config"
@@ -20,6 +26,8 @@ type TranscriptSegment struct {
 	Start float64 `json:"start"` // 起始时间（秒）
 	End   float64 `json:"end"`   // 结束时间（秒）
 	Text  string  `json:"text"`  // 转录文本
+	Words []Word  `json:"words,omitempty"` // 单词级时间（后端支持时）
+	Speaker string `json:"speaker,omitempty"` // 说话人（开启说话人识别时）
 }
 
 // Keyframe 表示从视频中提取的一个关键帧
@@ -184,12 +192,86 @@ func SerializeTranscript(segments []TranscriptSegment) ([]byte, error) {
 	return json.Marshal(segments)
 }
 
-// Parser 视频解析器，封装 ffmpeg 和 RapidSpeech 的调用逻辑
+// Parse 的各个步骤，用于进度回调
+const (
+	StepAudio      = "audio"      // 提取音频
+	StepTranscribe = "transcribe" // 语音转录
+	StepKeyframes  = "keyframes"  // 抽取关键帧
+)
+
+// ProgressFunc 接收视频解析进度。step 为当前步骤，done/total 为该步骤已处理量与
+// 总量（ffmpeg 步骤以秒计），total 为 0 表示总量未知
+type ProgressFunc func(step string, done, total int)
+
+// Parser 视频解析器，封装 ffmpeg 和语音转录后端的调用逻辑
 type Parser struct {
 	FFmpegPath        string
 	RapidSpeechPath   string
 	KeyframeInterval  int
 	RapidSpeechModel  string
+	// STTProvider 语音转录后端（STTRapidSpeech 等），为空时使用 RapidSpeech
+	STTProvider string
+	// Transcriber 可选，设置后替代 RapidSpeech 进行语音转录
+	Transcriber Transcriber
+	// Diarize 由转录后端识别说话人（后端支持时）
+	Diarize bool
+	// SpeakerLabels 按出现顺序为说话人命名，未命名的说话人为"说话人 N"
+	SpeakerLabels []string
+	// Transcript 可选，已有的转录（如上传的字幕），设置后不再进行语音转录
+	Transcript []TranscriptSegment
+	// KeyframeMode 为 KeyframeModeScene（默认）或 KeyframeModeInterval
+	KeyframeMode string
+	// SceneThreshold 场景模式下帧被选中需超过的场景变化得分（0-1），默认 0.3
+	SceneThreshold float64
+	// MaxKeyframes 每个视频最多保留的关键帧数，默认 200
+	MaxKeyframes int
+	// DedupDistance 与前一保留帧感知哈希相差不超过该位数的帧视为重复而丢弃，
+	// -1 表示不去重，默认 6
+	DedupDistance int
+	// OnProgress 可选，Parse 执行过程中报告进度
+	OnProgress ProgressFunc
+}
+
+// progress 报告进度（未设置回调时忽略）
+func (p *Parser) progress(step string, done, total int) {
+	if p.OnProgress != nil {
+		p.OnProgress(step, done, total)
+	}
+}
+
+// runFFmpeg 执行 ffmpeg 并通过 -progress 输出报告 step 的进度，duration 为视频
+// 时长（秒，未知时为 0）。ctx 取消时终止 ffmpeg。返回 ffmpeg 的日志输出
+func (p *Parser) runFFmpeg(ctx context.Context, step string, duration float64, args ...string) ([]byte, error) {
+	args = append([]string{"-progress", "pipe:1", "-nostats"}, args...)
+	cmd := exec.CommandContext(ctx, p.FFmpegPath, args...)
+	var logs bytes.Buffer
+	cmd.Stderr = &logs
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	total := int(duration)
+	p.progress(step, 0, total)
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		// -progress 输出 key=value 行，out_time_ms 为已处理的时长（单位实为微秒）
+		v, ok := strings.CutPrefix(scanner.Text(), "out_time_ms=")
+		if !ok {
+			continue
+		}
+		if us, err := strconv.ParseInt(v, 10, 64); err == nil && us >= 0 {
+			p.progress(step, int(us/1000000), total)
+		}
+	}
+	io.Copy(io.Discard, stdout)
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return logs.Bytes(), context.Cause(ctx)
+	}
+	return logs.Bytes(), err
 }
 
 // NewParser 根据 VideoConfig 创建 Parser 实例
@@ -203,6 +285,14 @@ func NewParser(cfg config.VideoConfig) *Parser {
 		RapidSpeechPath:  cfg.RapidSpeechPath,
 		KeyframeInterval: interval,
 		RapidSpeechModel: cfg.RapidSpeechModel,
+		KeyframeMode:     cfg.KeyframeMode,
+		SceneThreshold:   cfg.SceneThreshold,
+		MaxKeyframes:     cfg.MaxKeyframes,
+		DedupDistance:    cfg.KeyframeDedupDistance,
+		STTProvider:      cfg.STT.Provider,
+		Transcriber:      newTranscriber(cfg.STT, cfg.Diarization == "stt"),
+		Diarize:          cfg.Diarization == "stt",
+		SpeakerLabels:    cfg.SpeakerLabels,
 	}
 }
 
@@ -212,6 +302,9 @@ type DepsCheckResult struct {
 	FFmpegError    string `json:"ffmpeg_error,omitempty"`
 	RapidSpeechOK  bool   `json:"rapidspeech_ok"`
 	RapidSpeechError string `json:"rapidspeech_error,omitempty"`
+	STTProvider    string `json:"stt_provider"`
+	STTOK          bool   `json:"stt_ok"`
+	STTError       string `json:"stt_error,omitempty"`
 }
 
 // CheckDependencies 检测 ffmpeg 和 RapidSpeech 是否可用，返回详细结果
@@ -268,6 +361,21 @@ func (p *Parser) CheckDependencies() *DepsCheckResult {
 		}
 	}
 
+	// 检测语音转录后端
+	result.STTProvider = p.STTProvider
+	if result.STTProvider == "" {
+		result.STTProvider = STTRapidSpeech
+	}
+	if result.STTProvider == STTRapidSpeech {
+		result.STTOK, result.STTError = result.RapidSpeechOK, result.RapidSpeechError
+	} else if t := p.transcriber(); t == nil {
+		result.STTError = fmt.Sprintf("语音转录后端 %s 未配置完整", result.STTProvider)
+	} else if err := t.Check(); err != nil {
+		result.STTError = err.Error()
+	} else {
+		result.STTOK = true
+	}
+
 	return result
 }
 
@@ -316,6 +424,11 @@ func (p *Parser) ValidateRapidSpeechConfig() (errors []string) {
 
 // ExtractAudio 调用 ffmpeg 将视频的音频轨提取为 16kHz 单声道 WAV 文件
 func (p *Parser) ExtractAudio(videoPath, outputPath string) error {
+	return p.extractAudio(context.Background(), videoPath, outputPath, 0)
+}
+
+// extractAudio 即 ExtractAudio，duration 为已知的视频时长，用于报告进度
+func (p *Parser) extractAudio(ctx context.Context, videoPath, outputPath string, duration float64) error {
 	if p.FFmpegPath == "" {
 		return fmt.Errorf("ffmpeg 路径未配置")
 	}
@@ -325,7 +438,7 @@ func (p *Parser) ExtractAudio(videoPath, outputPath string) error {
 			return fmt.Errorf("路径包含非法字符: %s", path)
 		}
 	}
-	cmd := exec.Command(p.FFmpegPath,
+	output, err := p.runFFmpeg(ctx, StepAudio, duration,
 		"-i", videoPath,
 		"-vn",
 		"-acodec", "pcm_s16le",
@@ -334,15 +447,24 @@ func (p *Parser) ExtractAudio(videoPath, outputPath string) error {
 		"-y",
 		outputPath,
 	)
-	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("ffmpeg 音频提取失败: %s: %w", strings.TrimSpace(string(output)), err)
 	}
 	return nil
 }
 
-// Transcribe 调用 RapidSpeech CLI 对音频进行语音转录
+// Transcribe 使用配置的语音转录后端对音频进行语音转录
 func (p *Parser) Transcribe(audioPath string) ([]TranscriptSegment, error) {
+	t := p.transcriber()
+	if t == nil {
+		return nil, errNoTranscriber
+	}
+	return t.Transcribe(context.Background(), audioPath)
+}
+
+// transcribeRapidSpeech 调用 RapidSpeech CLI 对音频进行语音转录，ctx 取消时终止
+// RapidSpeech
+func (p *Parser) transcribeRapidSpeech(ctx context.Context, audioPath string) ([]TranscriptSegment, error) {
 	if p.RapidSpeechPath == "" {
 		return nil, fmt.Errorf("RapidSpeech 路径未配置")
 	}
@@ -356,13 +478,16 @@ func (p *Parser) Transcribe(audioPath string) ([]TranscriptSegment, error) {
 
 	// RapidSpeech.cpp 命令行格式：
 	// rs-asr-offline -m model.gguf -w audio.wav
-	cmd := exec.Command(p.RapidSpeechPath,
+	cmd := exec.CommandContext(ctx, p.RapidSpeechPath,
 		"-m", p.RapidSpeechModel,
 		"-w", audioPath,
 	)
 
 	// 捕获标准输出
 	output, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, context.Cause(ctx)
+	}
 	if err != nil {
 		stderr := ""
 		if exitErr, ok := err.(*exec.ExitError); ok {
@@ -387,8 +512,14 @@ func (p *Parser) Transcribe(audioPath string) ([]TranscriptSegment, error) {
 	}, nil
 }
 
-// ExtractKeyframes 调用 ffmpeg 按 KeyframeInterval 间隔从视频中提取关键帧图像
+// ExtractKeyframes 调用 ffmpeg 从视频中提取关键帧图像：场景模式在画面变化处抽帧，
+// 间隔模式每 KeyframeInterval 秒抽一帧。随后去掉重复的帧，并限制在 MaxKeyframes 帧以内
 func (p *Parser) ExtractKeyframes(videoPath, outputDir string) ([]Keyframe, error) {
+	return p.extractKeyframes(context.Background(), videoPath, outputDir, 0)
+}
+
+// extractKeyframes 即 ExtractKeyframes，duration 为已知的视频时长，用于报告进度
+func (p *Parser) extractKeyframes(ctx context.Context, videoPath, outputDir string, duration float64) ([]Keyframe, error) {
 	if p.FFmpegPath == "" {
 		return nil, fmt.Errorf("ffmpeg 路径未配置")
 	}
@@ -400,15 +531,19 @@ func (p *Parser) ExtractKeyframes(videoPath, outputDir string) ([]Keyframe, erro
 	}
 
 	outputPattern := filepath.Join(outputDir, "frame_%04d.jpg")
-	cmd := exec.Command(p.FFmpegPath,
+	output, err := p.runFFmpeg(ctx, StepKeyframes, duration,
 		"-i", videoPath,
-		"-vf", fmt.Sprintf("fps=1/%d", p.KeyframeInterval),
+		"-vf", p.keyframeFilter(),
+		"-vsync", "vfr",
 		"-q:v", "2",
 		outputPattern,
 	)
-	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return nil, fmt.Errorf("ffmpeg 关键帧提取失败: %s: %w", strings.TrimSpace(string(output)), err)
+		return nil, fmt.Errorf("ffmpeg 关键帧提取失败: %s: %w", lastLines(output, 10), err)
+	}
+	var times []float64
+	if p.sceneMode() {
+		times = sceneTimes(output)
 	}
 
 	// Scan output directory for generated frame files
@@ -426,13 +561,15 @@ func (p *Parser) ExtractKeyframes(videoPath, outputDir string) ([]Keyframe, erro
 
 	// If no frames extracted (video shorter than keyframe interval), extract one frame from the middle
 	if len(frameFiles) == 0 {
-		duration := p.ProbeDuration(videoPath)
+		if duration <= 0 {
+			duration = p.probeDuration(ctx, videoPath)
+		}
 		seekTime := duration / 2
 		if seekTime < 0 {
 			seekTime = 0
 		}
 		singleFrame := filepath.Join(outputDir, "frame_0001.jpg")
-		fallbackCmd := exec.Command(p.FFmpegPath,
+		fallbackCmd := exec.CommandContext(ctx, p.FFmpegPath,
 			"-ss", fmt.Sprintf("%.2f", seekTime),
 			"-i", videoPath,
 			"-frames:v", "1",
@@ -451,23 +588,35 @@ func (p *Parser) ExtractKeyframes(videoPath, outputDir string) ([]Keyframe, erro
 
 	keyframes := make([]Keyframe, 0, len(frameFiles))
 	for i, name := range frameFiles {
+		ts := float64(i * p.KeyframeInterval)
+		if p.sceneMode() {
+			ts = 0
+			if i < len(times) {
+				ts = times[i]
+			}
+		}
 		keyframes = append(keyframes, Keyframe{
-			Timestamp: float64(i * p.KeyframeInterval),
+			Timestamp: ts,
 			FilePath:  filepath.Join(outputDir, name),
 		})
 	}
 
-	return keyframes, nil
+	return p.reduceKeyframes(keyframes), nil
 }
 
 // ProbeDuration 调用 ffmpeg 获取视频时长（秒）。
 // 通过 -show_entries format=duration 解析 stderr 中的 Duration 行。
 func (p *Parser) ProbeDuration(videoPath string) float64 {
+	return p.probeDuration(context.Background(), videoPath)
+}
+
+// probeDuration 即 ProbeDuration，ctx 取消时终止 ffmpeg
+func (p *Parser) probeDuration(ctx context.Context, videoPath string) float64 {
 	if p.FFmpegPath == "" {
 		return 0
 	}
 	// 使用 ffmpeg -i 读取时长，ffmpeg 会在 stderr 输出 Duration: HH:MM:SS.xx
-	cmd := exec.Command(p.FFmpegPath, "-i", videoPath, "-f", "null", "-")
+	cmd := exec.CommandContext(ctx, p.FFmpegPath, "-i", videoPath, "-f", "null", "-")
 	output, _ := cmd.CombinedOutput()
 	// 解析 "Duration: 00:12:34.56" 格式
 	for _, line := range strings.Split(string(output), "\n") {
@@ -499,6 +648,12 @@ func (p *Parser) ProbeDuration(videoPath string) float64 {
 
 // Parse 编排完整的视频解析流程：提取音频转录 + 抽取关键帧
 func (p *Parser) Parse(videoPath string) (*ParseResult, error) {
+	return p.ParseContext(context.Background(), videoPath)
+}
+
+// ParseContext 即 Parse，ctx 取消时终止正在运行的 ffmpeg / 语音转录并返回
+// context.Cause(ctx)
+func (p *Parser) ParseContext(ctx context.Context, videoPath string) (*ParseResult, error) {
 	tempDir, err := os.MkdirTemp("", "video-parse-*")
 	if err != nil {
 		return nil, fmt.Errorf("创建临时目录失败: %w", err)
@@ -508,20 +663,38 @@ func (p *Parser) Parse(videoPath string) (*ParseResult, error) {
 	result := &ParseResult{}
 
 	// 探测视频时长
-	result.Duration = p.ProbeDuration(videoPath)
+	result.Duration = p.probeDuration(ctx, videoPath)
+	if ctx.Err() != nil {
+		return nil, context.Cause(ctx)
+	}
 
-	// 音频转录（仅在 RapidSpeech 已配置时执行）
-	if p.RapidSpeechPath != "" && p.RapidSpeechModel != "" {
+	// 音频转录（已有转录时跳过，否则仅在语音转录后端已配置时执行）
+	if p.Transcript != nil {
+		result.Transcript = p.Transcript
+	} else if transcriber := p.transcriber(); transcriber != nil {
 		audioPath := filepath.Join(tempDir, "audio.wav")
-		audioErr := p.ExtractAudio(videoPath, audioPath)
+		audioErr := p.extractAudio(ctx, videoPath, audioPath, result.Duration)
+		if ctx.Err() != nil {
+			return nil, context.Cause(ctx)
+		}
 		if audioErr != nil {
 			// 如果音频提取失败，可能是视频没有音频轨，跳过转录继续关键帧提取
 			// 不返回错误，仅跳过转录步骤
 		} else {
-			segments, transcribeErr := p.Transcribe(audioPath)
+			// 转录无法得知中间进度，仅报告开始与结束
+			p.progress(StepTranscribe, 0, 1)
+			segments, transcribeErr := transcriber.Transcribe(ctx, audioPath)
 			if transcribeErr != nil {
 				return nil, transcribeErr
 			}
+			p.progress(StepTranscribe, 1, 1)
+			if p.Diarize {
+				labelSpeakers(segments, p.SpeakerLabels)
+			}
+			// 没有时间信息的整段转录（RapidSpeech）覆盖整个视频
+			if len(segments) == 1 && segments[0].End == 0 {
+				segments[0].End = result.Duration
+			}
 			result.Transcript = segments
 		}
 	}
@@ -532,7 +705,7 @@ func (p *Parser) Parse(videoPath string) (*ParseResult, error) {
 		if mkErr := os.MkdirAll(framesDir, 0o755); mkErr != nil {
 			return nil, fmt.Errorf("创建关键帧目录失败: %w", mkErr)
 		}
-		keyframes, kfErr := p.ExtractKeyframes(videoPath, framesDir)
+		keyframes, kfErr := p.extractKeyframes(ctx, videoPath, framesDir, result.Duration)
 		if kfErr != nil {
 			return nil, kfErr
 		}
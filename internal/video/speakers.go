@@ -0,0 +1,124 @@
+package video
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// labelSpeakers 把转录后端给出的说话人编号（"A"、"0" 等）按首次出现的顺序
+// 替换为 labels 中的名称，超出 labels 的说话人依次命名为"说话人 N"
+func labelSpeakers(segments []TranscriptSegment, labels []string) {
+	names := make(map[string]string)
+	for i := range segments {
+		id := segments[i].Speaker
+		if id == "" {
+			continue
+		}
+		name, ok := names[id]
+		if !ok {
+			n := len(names)
+			if n < len(labels) {
+				name = labels[n]
+			} else {
+				name = fmt.Sprintf("说话人 %d", n+1)
+			}
+			names[id] = name
+		}
+		segments[i].Speaker = name
+	}
+}
+
+// HasSpeakers 报告转录片段是否带有说话人
+func HasSpeakers(segments []TranscriptSegment) bool {
+	for _, seg := range segments {
+		if seg.Speaker != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatTranscript 把转录片段拼接为文本。带有说话人时，同一说话人连续的片段合并
+// 为一行，行首为"说话人: "；否则各片段以空格相连
+func FormatTranscript(segments []TranscriptSegment) string {
+	var b strings.Builder
+	if !HasSpeakers(segments) {
+		for _, seg := range segments {
+			text := strings.TrimSpace(seg.Text)
+			if text == "" {
+				continue
+			}
+			if b.Len() > 0 {
+				b.WriteString(" ")
+			}
+			b.WriteString(text)
+		}
+		return b.String()
+	}
+	last := ""
+	for _, seg := range segments {
+		text := strings.TrimSpace(seg.Text)
+		if text == "" {
+			continue
+		}
+		speaker := seg.Speaker
+		if speaker == "" {
+			speaker = last
+		}
+		if b.Len() == 0 || speaker != last {
+			if b.Len() > 0 {
+				b.WriteString("\n")
+			}
+			if speaker != "" {
+				b.WriteString(speaker + ": ")
+			}
+			last = speaker
+		} else {
+			b.WriteString(" ")
+		}
+		b.WriteString(text)
+	}
+	return b.String()
+}
+
+// SplitSentences 把没有单词时间的长片段（如 RapidSpeech 的整段输出）按句末标点
+// 拆成多个片段，便于逐句标注说话人。拆出的片段沿用原片段的时间范围
+func SplitSentences(segments []TranscriptSegment) []TranscriptSegment {
+	out := make([]TranscriptSegment, 0, len(segments))
+	for _, seg := range segments {
+		if len(seg.Words) > 0 {
+			out = append(out, seg)
+			continue
+		}
+		text := strings.TrimSpace(seg.Text)
+		start := 0
+		for i := 0; i < len(text); {
+			r, size := utf8.DecodeRuneInString(text[i:])
+			i += size
+			if !strings.ContainsRune(".!?。！？", r) {
+				continue
+			}
+			// 英文句点后须有空白，避免拆开小数和网址
+			if r == '.' && i < len(text) && text[i] != ' ' && text[i] != '\n' {
+				continue
+			}
+			// 连续的标点和引号归入当前句
+			for i < len(text) {
+				r, size = utf8.DecodeRuneInString(text[i:])
+				if !strings.ContainsRune(".!?。！？…\"'”’）)", r) {
+					break
+				}
+				i += size
+			}
+			if sentence := strings.TrimSpace(text[start:i]); sentence != "" {
+				out = append(out, TranscriptSegment{Start: seg.Start, End: seg.End, Text: sentence, Speaker: seg.Speaker})
+			}
+			start = i
+		}
+		if sentence := strings.TrimSpace(text[start:]); sentence != "" {
+			out = append(out, TranscriptSegment{Start: seg.Start, End: seg.End, Text: sentence, Speaker: seg.Speaker})
+		}
+	}
+	return out
+}
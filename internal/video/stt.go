@@ -0,0 +1,227 @@
+package video
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"askflow/internal/config"
+)
+
+// 语音转录后端
+const (
+	STTRapidSpeech = "rapidspeech" // RapidSpeech 命令行（默认）
+	STTWhisperCpp  = "whisper_cpp" // whisper.cpp 命令行
+	STTOpenAI      = "openai"      // OpenAI 兼容的 Audio Transcriptions 接口
+	STTAliyunNLS   = "aliyun_nls"  // 阿里云智能语音交互录音文件识别极速版
+)
+
+// errNoTranscriber 表示没有配置可用的转录后端
+var errNoTranscriber = errors.New("语音转录未配置")
+
+// Word 是带时间的单词（中文为单字或词），后端支持时才有
+type Word struct {
+	Start float64 `json:"start"` // 起始时间（秒）
+	End   float64 `json:"end"`   // 结束时间（秒）
+	Text  string  `json:"text"`
+}
+
+// Transcriber 把 ffmpeg 提取的 16kHz 单声道 WAV 音频转写为转录片段
+type Transcriber interface {
+	// Name 返回后端名称（STTRapidSpeech 等）
+	Name() string
+	// Transcribe 转写音频，ctx 取消时停止
+	Transcribe(ctx context.Context, audioPath string) ([]TranscriptSegment, error)
+	// Check 检查后端的配置和依赖是否可用
+	Check() error
+}
+
+// newTranscriber 按 video.stt.provider 创建转录后端，diarize 为 true 时由支持的
+// 后端识别说话人。RapidSpeech 及未配置完整的后端返回 nil：RapidSpeech 由 Parser
+// 自身的路径配置决定，其余后端缺少必需的配置时视为未启用，跳过转录
+func newTranscriber(cfg config.STTConfig, diarize bool) Transcriber {
+	switch cfg.Provider {
+	case STTWhisperCpp:
+		if cfg.WhisperCppPath == "" || cfg.WhisperCppModel == "" {
+			return nil
+		}
+		return &whisperCpp{path: cfg.WhisperCppPath, model: cfg.WhisperCppModel, language: cfg.Language, diarize: diarize}
+	case STTOpenAI:
+		if cfg.APIKey == "" {
+			return nil
+		}
+		return newOpenAITranscriber(cfg, diarize)
+	case STTAliyunNLS:
+		if cfg.NLSAccessKeyID == "" || cfg.NLSAccessKeySecret == "" || cfg.NLSAppKey == "" {
+			return nil
+		}
+		return newNLSTranscriber(cfg)
+	}
+	return nil
+}
+
+// rapidSpeech 是 RapidSpeech 命令行后端，使用 Parser 的 RapidSpeech 配置
+type rapidSpeech struct {
+	p *Parser
+}
+
+func (t rapidSpeech) Name() string { return STTRapidSpeech }
+
+func (t rapidSpeech) Transcribe(ctx context.Context, audioPath string) ([]TranscriptSegment, error) {
+	return t.p.transcribeRapidSpeech(ctx, audioPath)
+}
+
+func (t rapidSpeech) Check() error {
+	if errs := t.p.ValidateRapidSpeechConfig(); len(errs) > 0 {
+		return errors.New(errs[0])
+	}
+	return nil
+}
+
+// transcriber 返回使用的转录后端，未配置时返回 nil
+func (p *Parser) transcriber() Transcriber {
+	if p.Transcriber != nil {
+		return p.Transcriber
+	}
+	if p.STTProvider != "" && p.STTProvider != STTRapidSpeech {
+		return nil
+	}
+	if p.RapidSpeechPath != "" && p.RapidSpeechModel != "" {
+		return rapidSpeech{p}
+	}
+	return nil
+}
+
+// wavFormat 是 PCM WAV 文件的格式与数据位置
+type wavFormat struct {
+	channels      uint16
+	sampleRate    uint32
+	bitsPerSample uint16
+	dataOffset    int64
+	dataSize      int64
+}
+
+// bytesPerSecond 返回每秒音频的字节数
+func (f wavFormat) bytesPerSecond() int64 {
+	return int64(f.sampleRate) * int64(f.channels) * int64(f.bitsPerSample/8)
+}
+
+// readWAVFormat 读取 WAV 文件头，找到 fmt 和 data 块
+func readWAVFormat(file *os.File) (wavFormat, error) {
+	var f wavFormat
+	info, err := file.Stat()
+	if err != nil {
+		return f, err
+	}
+	var riff [12]byte
+	if _, err := file.ReadAt(riff[:], 0); err != nil {
+		return f, fmt.Errorf("读取 WAV 文件头失败: %w", err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return f, errors.New("不是 WAV 文件")
+	}
+	offset := int64(12)
+	for offset+8 <= info.Size() {
+		var hdr [8]byte
+		if _, err := file.ReadAt(hdr[:], offset); err != nil {
+			return f, fmt.Errorf("读取 WAV 文件失败: %w", err)
+		}
+		id, size := string(hdr[0:4]), int64(binary.LittleEndian.Uint32(hdr[4:8]))
+		body := offset + 8
+		switch id {
+		case "fmt ":
+			var fmtChunk [16]byte
+			if _, err := file.ReadAt(fmtChunk[:], body); err != nil {
+				return f, fmt.Errorf("读取 WAV 格式失败: %w", err)
+			}
+			if binary.LittleEndian.Uint16(fmtChunk[0:2]) != 1 {
+				return f, errors.New("WAV 文件不是 PCM 编码")
+			}
+			f.channels = binary.LittleEndian.Uint16(fmtChunk[2:4])
+			f.sampleRate = binary.LittleEndian.Uint32(fmtChunk[4:8])
+			f.bitsPerSample = binary.LittleEndian.Uint16(fmtChunk[14:16])
+		case "data":
+			if f.sampleRate == 0 {
+				return f, errors.New("WAV 文件缺少格式信息")
+			}
+			f.dataOffset = body
+			// 写入管道的 WAV 可能没有正确的长度，以文件实际大小为准
+			f.dataSize = min(size, info.Size()-body)
+			if size == 0 || size == 0xFFFFFFFF {
+				f.dataSize = info.Size() - body
+			}
+			if f.bytesPerSecond() == 0 {
+				return f, errors.New("WAV 格式无效")
+			}
+			return f, nil
+		}
+		offset = body + size + size%2
+	}
+	return f, errors.New("WAV 文件缺少音频数据")
+}
+
+// splitWAV 把 WAV 文件按不超过 maxSeconds 秒切成多段，依次以带 WAV 头的完整
+// 文件内容和该段的起始时间（秒）调用 fn。较短的音频只有一段
+func splitWAV(ctx context.Context, path string, maxSeconds int, fn func(piece []byte, offset float64) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	f, err := readWAVFormat(file)
+	if err != nil {
+		return err
+	}
+	bps := f.bytesPerSecond()
+	block := int64(f.channels) * int64(f.bitsPerSample/8)
+	pieceSize := bps * int64(maxSeconds) / block * block
+	for start := int64(0); start < f.dataSize; start += pieceSize {
+		if err := ctx.Err(); err != nil {
+			return context.Cause(ctx)
+		}
+		n := min(pieceSize, f.dataSize-start)
+		piece := make([]byte, 44+n)
+		writeWAVHeader(piece[:44], f, n)
+		if _, err := file.ReadAt(piece[44:], f.dataOffset+start); err != nil && err != io.EOF {
+			return fmt.Errorf("读取音频失败: %w", err)
+		}
+		if err := fn(piece, float64(start)/float64(bps)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeWAVHeader 写入 44 字节的 PCM WAV 文件头，dataSize 为音频数据长度
+func writeWAVHeader(b []byte, f wavFormat, dataSize int64) {
+	le := binary.LittleEndian
+	copy(b[0:4], "RIFF")
+	le.PutUint32(b[4:8], uint32(36+dataSize))
+	copy(b[8:12], "WAVE")
+	copy(b[12:16], "fmt ")
+	le.PutUint32(b[16:20], 16)
+	le.PutUint16(b[20:22], 1)
+	le.PutUint16(b[22:24], f.channels)
+	le.PutUint32(b[24:28], f.sampleRate)
+	le.PutUint32(b[28:32], uint32(f.bytesPerSecond()))
+	le.PutUint16(b[32:34], f.channels*(f.bitsPerSample/8))
+	le.PutUint16(b[34:36], f.bitsPerSample)
+	copy(b[36:40], "data")
+	le.PutUint32(b[40:44], uint32(dataSize))
+}
+
+// shiftSegments 把一段音频的转录时间加上该段的起始时间
+func shiftSegments(segments []TranscriptSegment, offset float64) []TranscriptSegment {
+	for i := range segments {
+		segments[i].Start += offset
+		segments[i].End += offset
+		for j := range segments[i].Words {
+			segments[i].Words[j].Start += offset
+			segments[i].Words[j].End += offset
+		}
+	}
+	return segments
+}
@@ -0,0 +1,243 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"askflow/internal/config"
+)
+
+// nlsPieceSeconds 是每次上传的音频时长。录音文件识别极速版单次最多识别 2 小时、
+// 100MB 的音频，16kHz 单声道 WAV 每 30 分钟约 58MB
+const nlsPieceSeconds = 1800
+
+// nlsTranscriber 调用阿里云智能语音交互的录音文件识别极速版（FlashRecognizer）。
+// 识别语言由 appkey 对应项目的配置决定
+type nlsTranscriber struct {
+	accessKeyID     string
+	accessKeySecret string
+	appKey          string
+	region          string
+	client          *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+func newNLSTranscriber(cfg config.STTConfig) *nlsTranscriber {
+	region := cfg.NLSRegion
+	if region == "" {
+		region = "cn-shanghai"
+	}
+	return &nlsTranscriber{
+		accessKeyID:     cfg.NLSAccessKeyID,
+		accessKeySecret: cfg.NLSAccessKeySecret,
+		appKey:          cfg.NLSAppKey,
+		region:          region,
+		client:          &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+func (t *nlsTranscriber) Name() string { return STTAliyunNLS }
+
+func (t *nlsTranscriber) Check() error {
+	if t.accessKeyID == "" || t.accessKeySecret == "" {
+		return errors.New("阿里云 AccessKey 未配置")
+	}
+	if t.appKey == "" {
+		return errors.New("阿里云智能语音交互 Appkey 未配置")
+	}
+	return nil
+}
+
+// nlsResponse 是 FlashRecognizer 的响应，时间单位为毫秒
+type nlsResponse struct {
+	Status      int    `json:"status"`
+	Message     string `json:"message"`
+	FlashResult struct {
+		Sentences []struct {
+			Text      string `json:"text"`
+			BeginTime int64  `json:"begin_time"`
+			EndTime   int64  `json:"end_time"`
+			Words     []struct {
+				Text      string `json:"text"`
+				BeginTime int64  `json:"begin_time"`
+				EndTime   int64  `json:"end_time"`
+			} `json:"words"`
+		} `json:"sentences"`
+	} `json:"flash_result"`
+}
+
+// nlsStatusOK 是识别成功时的 status
+const nlsStatusOK = 20000000
+
+// Transcribe 把音频按 nlsPieceSeconds 切段依次识别，合并各段的结果
+func (t *nlsTranscriber) Transcribe(ctx context.Context, audioPath string) ([]TranscriptSegment, error) {
+	var segments []TranscriptSegment
+	err := splitWAV(ctx, audioPath, nlsPieceSeconds, func(piece []byte, offset float64) error {
+		res, err := t.recognize(ctx, piece)
+		if err != nil {
+			return err
+		}
+		var part []TranscriptSegment
+		for _, s := range res.FlashResult.Sentences {
+			seg := TranscriptSegment{
+				Start: float64(s.BeginTime) / 1000,
+				End:   float64(s.EndTime) / 1000,
+				Text:  strings.TrimSpace(s.Text),
+			}
+			for _, w := range s.Words {
+				seg.Words = append(seg.Words, Word{
+					Start: float64(w.BeginTime) / 1000,
+					End:   float64(w.EndTime) / 1000,
+					Text:  w.Text,
+				})
+			}
+			if seg.Text != "" {
+				part = append(part, seg)
+			}
+		}
+		segments = append(segments, shiftSegments(part, offset)...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
+
+// recognize 识别一段 WAV 音频
+func (t *nlsTranscriber) recognize(ctx context.Context, audio []byte) (*nlsResponse, error) {
+	token, err := t.getToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	q.Set("appkey", t.appKey)
+	q.Set("token", token)
+	q.Set("format", "wav")
+	q.Set("sample_rate", "16000")
+	u := fmt.Sprintf("https://nls-gateway-%s.aliyuncs.com/stream/v1/FlashRecognizer?%s", t.region, q.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(audio))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, context.Cause(ctx)
+		}
+		return nil, fmt.Errorf("阿里云语音识别请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	if err != nil {
+		return nil, fmt.Errorf("读取阿里云语音识别响应失败: %w", err)
+	}
+	var res nlsResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("阿里云语音识别失败: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if res.Status != nlsStatusOK {
+		return nil, fmt.Errorf("阿里云语音识别失败: %d %s", res.Status, res.Message)
+	}
+	return &res, nil
+}
+
+// getToken 返回访问令牌，过期前一分钟重新获取
+func (t *nlsTranscriber) getToken(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Now().Add(time.Minute).Before(t.tokenExpiry) {
+		return t.token, nil
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	params := map[string]string{
+		"AccessKeyId":      t.accessKeyID,
+		"Action":           "CreateToken",
+		"Format":           "JSON",
+		"RegionId":         t.region,
+		"SignatureMethod":  "HMAC-SHA1",
+		"SignatureNonce":   hex.EncodeToString(nonce),
+		"SignatureVersion": "1.0",
+		"Timestamp":        time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		"Version":          "2019-02-28",
+	}
+	query := popCanonicalQuery(params)
+	mac := hmac.New(sha1.New, []byte(t.accessKeySecret+"&"))
+	mac.Write([]byte("GET&" + popEscape("/") + "&" + popEscape(query)))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	u := fmt.Sprintf("https://nls-meta.%s.aliyuncs.com/?Signature=%s&%s", t.region, popEscape(signature), query)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", context.Cause(ctx)
+		}
+		return "", fmt.Errorf("获取阿里云语音识别令牌失败: %w", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var res struct {
+		Token struct {
+			ID         string `json:"Id"`
+			ExpireTime int64  `json:"ExpireTime"`
+		} `json:"Token"`
+		Message string `json:"Message"`
+	}
+	if err := json.Unmarshal(data, &res); err != nil || res.Token.ID == "" {
+		msg := res.Message
+		if msg == "" {
+			msg = strings.TrimSpace(string(data))
+		}
+		return "", fmt.Errorf("获取阿里云语音识别令牌失败: HTTP %d: %s", resp.StatusCode, msg)
+	}
+	t.token = res.Token.ID
+	t.tokenExpiry = time.Unix(res.Token.ExpireTime, 0)
+	return t.token, nil
+}
+
+// popCanonicalQuery 按参数名排序并编码阿里云 POP 接口的请求参数
+func popCanonicalQuery(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = popEscape(k) + "=" + popEscape(params[k])
+	}
+	return strings.Join(parts, "&")
+}
+
+// popEscape 按阿里云 POP 签名的规则编码：空格为 %20，* 为 %2A，~ 不编码
+func popEscape(s string) string {
+	s = url.QueryEscape(s)
+	s = strings.ReplaceAll(s, "+", "%20")
+	s = strings.ReplaceAll(s, "*", "%2A")
+	return strings.ReplaceAll(s, "%7E", "~")
+}
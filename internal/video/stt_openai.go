@@ -0,0 +1,186 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"askflow/internal/config"
+)
+
+// openAIPieceSeconds 是每次上传的音频时长。16kHz 单声道 WAV 每 10 分钟约 19MB，
+// 低于 Audio API 25MB 的上传限制
+const openAIPieceSeconds = 600
+
+// openAITranscriber 调用 OpenAI 兼容的 /audio/transcriptions 接口
+type openAITranscriber struct {
+	endpoint string
+	apiKey   string
+	model    string
+	language string
+	diarize  bool
+	client   *http.Client
+}
+
+func newOpenAITranscriber(cfg config.STTConfig, diarize bool) *openAITranscriber {
+	endpoint := strings.TrimRight(cfg.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = "https://api.openai.com/v1"
+	}
+	model := cfg.Model
+	if model == "" {
+		model = "whisper-1"
+	}
+	return &openAITranscriber{
+		endpoint: endpoint,
+		apiKey:   cfg.APIKey,
+		model:    model,
+		language: cfg.Language,
+		diarize:  diarize,
+		client:   &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+func (t *openAITranscriber) Name() string { return STTOpenAI }
+
+func (t *openAITranscriber) Check() error {
+	if t.apiKey == "" {
+		return errors.New("OpenAI 转录 API Key 未配置")
+	}
+	if !strings.HasPrefix(t.endpoint, "http://") && !strings.HasPrefix(t.endpoint, "https://") {
+		return fmt.Errorf("OpenAI 转录接口地址无效: %s", t.endpoint)
+	}
+	return nil
+}
+
+// verbose 报告模型是否支持 verbose_json（带片段和单词时间）。gpt-4o 系列转录
+// 模型只返回纯文本
+func (t *openAITranscriber) verbose() bool {
+	return strings.HasPrefix(t.model, "whisper")
+}
+
+// diarized 报告是否请求带说话人的转录（diarized_json），仅 diarize 系列模型支持
+func (t *openAITranscriber) diarized() bool {
+	return t.diarize && strings.Contains(t.model, "diarize")
+}
+
+// openAITranscription 是 Audio API 的响应，json 格式只有 Text
+type openAITranscription struct {
+	Text     string  `json:"text"`
+	Duration float64 `json:"duration"`
+	Segments []struct {
+		Start   float64 `json:"start"`
+		End     float64 `json:"end"`
+		Text    string  `json:"text"`
+		Speaker string  `json:"speaker"` // diarized_json 时的说话人（"A"、"B" 等）
+	} `json:"segments"`
+	Words []struct {
+		Start float64 `json:"start"`
+		End   float64 `json:"end"`
+		Word  string  `json:"word"`
+	} `json:"words"`
+}
+
+// Transcribe 把音频按 openAIPieceSeconds 切段依次上传，合并各段的转录结果。各段
+// 独立识别说话人，同一人在不同段中的编号可能不同
+func (t *openAITranscriber) Transcribe(ctx context.Context, audioPath string) ([]TranscriptSegment, error) {
+	var segments []TranscriptSegment
+	err := splitWAV(ctx, audioPath, openAIPieceSeconds, func(piece []byte, offset float64) error {
+		res, err := t.request(ctx, piece)
+		if err != nil {
+			return err
+		}
+		segments = append(segments, shiftSegments(res.segments(), offset)...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
+
+// request 上传一段音频
+func (t *openAITranscriber) request(ctx context.Context, audio []byte) (*openAITranscription, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "audio.wav")
+	if err != nil {
+		return nil, err
+	}
+	fw.Write(audio)
+	mw.WriteField("model", t.model)
+	if t.language != "" {
+		mw.WriteField("language", whisperLanguage(t.language))
+	}
+	if t.diarized() {
+		mw.WriteField("response_format", "diarized_json")
+		mw.WriteField("chunking_strategy", "auto")
+	} else if t.verbose() {
+		mw.WriteField("response_format", "verbose_json")
+		mw.WriteField("timestamp_granularities[]", "segment")
+		mw.WriteField("timestamp_granularities[]", "word")
+	} else {
+		mw.WriteField("response_format", "json")
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint+"/audio/transcriptions", &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+t.apiKey)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := t.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, context.Cause(ctx)
+		}
+		return nil, fmt.Errorf("OpenAI 转录请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	if err != nil {
+		return nil, fmt.Errorf("读取 OpenAI 转录响应失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OpenAI 转录失败: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	var res openAITranscription
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("解析 OpenAI 转录响应失败: %w", err)
+	}
+	return &res, nil
+}
+
+// segments 把响应转换为转录片段，单词按时间归入所在的片段。没有片段信息时
+// 整段文本作为一个片段
+func (r *openAITranscription) segments() []TranscriptSegment {
+	if len(r.Segments) == 0 {
+		text := strings.TrimSpace(r.Text)
+		if text == "" {
+			return nil
+		}
+		return []TranscriptSegment{{Start: 0, End: r.Duration, Text: text}}
+	}
+	segments := make([]TranscriptSegment, 0, len(r.Segments))
+	for _, s := range r.Segments {
+		segments = append(segments, TranscriptSegment{Start: s.Start, End: s.End, Text: strings.TrimSpace(s.Text), Speaker: s.Speaker})
+	}
+	i := 0
+	for _, w := range r.Words {
+		for i < len(segments)-1 && w.Start >= segments[i].End {
+			i++
+		}
+		segments[i].Words = append(segments[i].Words, Word{Start: w.Start, End: w.End, Text: strings.TrimSpace(w.Word)})
+	}
+	return segments
+}
@@ -0,0 +1,208 @@
+package video
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+)
+
+// whisperCpp 是 whisper.cpp 命令行（whisper-cli）后端
+type whisperCpp struct {
+	path     string
+	model    string
+	language string
+	diarize  bool // 使用 tinydiarize（-tdrz，需要 tdrz 模型）检测说话人切换
+}
+
+func (t *whisperCpp) Name() string { return STTWhisperCpp }
+
+func (t *whisperCpp) Check() error {
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return fmt.Errorf("whisper.cpp 可执行文件不存在: %s", t.path)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("whisper.cpp 路径指向目录而非文件: %s", t.path)
+	}
+	if runtime.GOOS != "windows" && info.Mode()&0111 == 0 {
+		return fmt.Errorf("whisper.cpp 可执行文件没有执行权限: %s", t.path)
+	}
+	mInfo, err := os.Stat(t.model)
+	if err != nil {
+		return fmt.Errorf("whisper.cpp 模型文件不存在: %s", t.model)
+	}
+	if mInfo.IsDir() {
+		return fmt.Errorf("whisper.cpp 模型路径指向目录而非文件: %s", t.model)
+	}
+	return nil
+}
+
+// whisperOutput 是 whisper-cli -oj 输出的 JSON（只取用到的字段）
+type whisperOutput struct {
+	Transcription []struct {
+		Offsets struct {
+			From int64 `json:"from"` // 毫秒
+			To   int64 `json:"to"`
+		} `json:"offsets"`
+		Text            string `json:"text"`
+		SpeakerTurnNext bool   `json:"speaker_turn_next"` // -tdrz 时该片段之后换人说话
+	} `json:"transcription"`
+}
+
+// Transcribe 调用 whisper-cli 转写音频。-ml 1 -sow 让 whisper.cpp 按词输出带时间
+// 的片段（中文等不以空格分词的语言为整句），再按标点和停顿合并成句。识别说话人时
+// 使用 whisper.cpp 自身的分句，没有单词时间
+func (t *whisperCpp) Transcribe(ctx context.Context, audioPath string) ([]TranscriptSegment, error) {
+	if strings.ContainsAny(audioPath, "|;&$`") {
+		return nil, fmt.Errorf("音频路径包含非法字符")
+	}
+	outBase := strings.TrimSuffix(audioPath, filepath.Ext(audioPath)) + "-whisper"
+	defer os.Remove(outBase + ".json")
+
+	args := []string{
+		"-m", t.model,
+		"-f", audioPath,
+		"-l", whisperLanguage(t.language),
+		"-oj",
+		"-of", outBase,
+		"-np",
+	}
+	if t.diarize {
+		args = append(args, "-tdrz")
+	} else {
+		args = append(args, "-ml", "1", "-sow")
+	}
+	cmd := exec.CommandContext(ctx, t.path, args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return nil, context.Cause(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("whisper.cpp 转录失败: %s: %w", lastLines([]byte(stderr.String()), 10), err)
+	}
+
+	data, err := os.ReadFile(outBase + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("读取 whisper.cpp 输出失败: %w", err)
+	}
+	// whisper.cpp 可能输出截断的多字节字符，先替换为合法的 UTF-8
+	if !utf8.Valid(data) {
+		data = []byte(strings.ToValidUTF8(string(data), ""))
+	}
+	var out whisperOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("解析 whisper.cpp 输出失败: %w", err)
+	}
+	if t.diarize {
+		return whisperTurns(out), nil
+	}
+	words := make([]Word, 0, len(out.Transcription))
+	for _, seg := range out.Transcription {
+		if strings.TrimSpace(seg.Text) == "" || strings.HasPrefix(strings.TrimSpace(seg.Text), "[_") {
+			continue
+		}
+		words = append(words, Word{
+			Start: float64(seg.Offsets.From) / 1000,
+			End:   float64(seg.Offsets.To) / 1000,
+			Text:  seg.Text,
+		})
+	}
+	return groupWords(words), nil
+}
+
+// whisperTurns 把 tinydiarize 的输出转换为带说话人的片段。tinydiarize 只标出
+// 换人说话的位置而不区分是谁，按两人对话（如客服通话）交替标为 A、B
+func whisperTurns(out whisperOutput) []TranscriptSegment {
+	segments := make([]TranscriptSegment, 0, len(out.Transcription))
+	speaker := 0
+	for _, seg := range out.Transcription {
+		text := strings.TrimSpace(strings.ReplaceAll(seg.Text, "[SPEAKER_TURN]", ""))
+		if text != "" && !strings.HasPrefix(text, "[_") {
+			segments = append(segments, TranscriptSegment{
+				Start:   float64(seg.Offsets.From) / 1000,
+				End:     float64(seg.Offsets.To) / 1000,
+				Text:    text,
+				Speaker: string(rune('A' + speaker)),
+			})
+		}
+		if seg.SpeakerTurnNext {
+			speaker = 1 - speaker
+		}
+	}
+	return segments
+}
+
+// whisperLanguage 把语言提示转换为 whisper 的语言代码（"zh-CN" → "zh"），
+// 未设置时自动检测
+func whisperLanguage(lang string) string {
+	if lang == "" {
+		return "auto"
+	}
+	base, _, _ := strings.Cut(lang, "-")
+	return strings.ToLower(base)
+}
+
+// 合并单词为句子时的限制
+const (
+	maxSegmentSeconds = 30  // 单个片段的最长时长
+	maxWordGapSeconds = 1.5 // 超过该停顿另起一段
+)
+
+// groupWords 把按顺序排列的单词合并为转录片段：在句末标点后、较长的停顿处或片段
+// 超过 maxSegmentSeconds 时另起一段。单词文本原样拼接（英文单词自带前导空格）
+func groupWords(words []Word) []TranscriptSegment {
+	var segments []TranscriptSegment
+	var cur []Word
+	var text strings.Builder
+	flush := func() {
+		if len(cur) == 0 {
+			return
+		}
+		segWords := make([]Word, len(cur))
+		for i, w := range cur {
+			w.Text = strings.TrimSpace(w.Text)
+			segWords[i] = w
+		}
+		segments = append(segments, TranscriptSegment{
+			Start: cur[0].Start,
+			End:   cur[len(cur)-1].End,
+			Text:  strings.TrimSpace(text.String()),
+			Words: segWords,
+		})
+		cur = cur[:0]
+		text.Reset()
+	}
+	for _, w := range words {
+		if len(cur) > 0 {
+			last := cur[len(cur)-1]
+			if w.Start-last.End > maxWordGapSeconds || w.End-cur[0].Start > maxSegmentSeconds {
+				flush()
+			}
+		}
+		cur = append(cur, w)
+		text.WriteString(w.Text)
+		if endsSentence(w.Text) {
+			flush()
+		}
+	}
+	flush()
+	return segments
+}
+
+// endsSentence 报告文本是否以句末标点结尾
+func endsSentence(s string) bool {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return false
+	}
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return strings.ContainsRune(".!?。！？…", r)
+}
@@ -0,0 +1,140 @@
+package video
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// cueTimePattern 匹配字幕时间行 "00:01:02,500 --> 00:01:05.000"，小时可省略
+var cueTimePattern = regexp.MustCompile(`^\s*((?:\d+:)?\d{1,2}:\d{2}[.,]\d{1,3})\s*-->\s*((?:\d+:)?\d{1,2}:\d{2}[.,]\d{1,3})`)
+
+// voiceTagPattern 匹配 WebVTT 的说话人标签 <v 名称> 或 <v.class 名称>
+var voiceTagPattern = regexp.MustCompile(`^<v(?:\.[^ >]*)?\s+([^>]+)>`)
+
+// cueTagPattern 匹配字幕文本中的格式标签（<i>、</b>、<c.yellow>、<00:01.000> 等）
+var cueTagPattern = regexp.MustCompile(`</?[^>]*>|\{\\[^}]*\}`)
+
+// ErrNoCues 表示字幕文件中没有可用的字幕
+var ErrNoCues = errors.New("字幕文件中没有字幕")
+
+// ParseSubtitles 解析 SRT 或 WebVTT 字幕为转录片段。WebVTT 的 <v 名称> 标签作为
+// 说话人，其余格式标签去掉；NOTE、STYLE、REGION 块忽略
+func ParseSubtitles(data []byte) ([]TranscriptSegment, error) {
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+	if !utf8.Valid(data) {
+		return nil, errors.New("字幕文件不是 UTF-8 编码")
+	}
+	text := strings.ReplaceAll(strings.ReplaceAll(string(data), "\r\n", "\n"), "\r", "\n")
+
+	var segments []TranscriptSegment
+	for _, block := range strings.Split(text, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		// 时间行之前可能有序号（SRT）或标识（WebVTT）
+		timeLine := -1
+		for i := 0; i < len(lines) && i < 2; i++ {
+			if cueTimePattern.MatchString(lines[i]) {
+				timeLine = i
+				break
+			}
+		}
+		if timeLine < 0 {
+			continue
+		}
+		m := cueTimePattern.FindStringSubmatch(lines[timeLine])
+		start, err := parseCueTime(m[1])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseCueTime(m[2])
+		if err != nil {
+			return nil, err
+		}
+		if end < start {
+			return nil, fmt.Errorf("字幕时间无效: %s", strings.TrimSpace(lines[timeLine]))
+		}
+
+		seg := TranscriptSegment{Start: start, End: end}
+		var parts []string
+		for _, line := range lines[timeLine+1:] {
+			line = strings.TrimSpace(line)
+			if v := voiceTagPattern.FindStringSubmatch(line); v != nil && seg.Speaker == "" {
+				seg.Speaker = strings.TrimSpace(v[1])
+			}
+			line = strings.TrimSpace(html.UnescapeString(cueTagPattern.ReplaceAllString(line, "")))
+			if line != "" {
+				parts = append(parts, line)
+			}
+		}
+		seg.Text = strings.Join(parts, " ")
+		if seg.Text != "" {
+			segments = append(segments, seg)
+		}
+	}
+	if len(segments) == 0 {
+		return nil, ErrNoCues
+	}
+	return segments, nil
+}
+
+// parseCueTime 解析 "hh:mm:ss,mmm"、"mm:ss.mmm" 格式的时间为秒
+func parseCueTime(s string) (float64, error) {
+	s = strings.Replace(s, ",", ".", 1)
+	parts := strings.Split(s, ":")
+	var total float64
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil || v < 0 || (i > 0 && v >= 60) {
+			return 0, fmt.Errorf("字幕时间无效: %s", s)
+		}
+		total = total*60 + v
+	}
+	return total, nil
+}
+
+// FormatVTT 把转录片段导出为 WebVTT 字幕，说话人写为 <v 名称> 标签。结束时间
+// 不晚于开始时间的片段（如没有时间信息的转录）显示到下一片段开始
+func FormatVTT(segments []TranscriptSegment) []byte {
+	var b bytes.Buffer
+	b.WriteString("WEBVTT\n")
+	for i, seg := range segments {
+		text := strings.TrimSpace(seg.Text)
+		if text == "" {
+			continue
+		}
+		end := seg.End
+		if end <= seg.Start {
+			end = seg.Start + 5
+			if i+1 < len(segments) && segments[i+1].Start > seg.Start {
+				end = segments[i+1].Start
+			}
+		}
+		fmt.Fprintf(&b, "\n%s --> %s\n", vttTime(seg.Start), vttTime(end))
+		// 文本中的 "-->" 和空行会破坏字幕结构
+		text = strings.ReplaceAll(text, "-->", "→")
+		text = strings.Join(strings.Fields(strings.ReplaceAll(text, "\n", " ")), " ")
+		text = vttEscape(text)
+		if seg.Speaker != "" {
+			text = "<v " + vttEscape(seg.Speaker) + ">" + text
+		}
+		b.WriteString(text + "\n")
+	}
+	return b.Bytes()
+}
+
+// vttTime 把秒格式化为 WebVTT 时间 "hh:mm:ss.mmm"
+func vttTime(sec float64) string {
+	ms := int64(math.Round(max(sec, 0) * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// vttEscape 转义 WebVTT 文本中的 &、<、>
+func vttEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
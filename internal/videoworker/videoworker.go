@@ -0,0 +1,186 @@
+// Package videoworker moves the ffmpeg and speech-to-text steps of video
+// processing off the web host onto remote worker processes, so a long
+// video does not starve query serving. The main instance queues a job for
+// each video; a worker (askflow video-worker) claims it from
+// /api/video-worker/claim, downloads the video, parses it with video.Parser
+// using its own tools and speech-to-text backend, reports progress, which
+// also keeps its claim alive, and uploads the transcript and keyframes.
+// Embedding, OCR and storage stay on the main instance. Workers
+// authenticate with the shared video.workers.token as a bearer token.
+package videoworker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"time"
+
+	"askflow/internal/config"
+	"askflow/internal/video"
+)
+
+// Job timing: a claim expires ClaimTTL after the worker's last report, and
+// workers report every ReportEvery while a job runs. A job is handed out at
+// most MaxAttempts times before it fails.
+const (
+	ClaimTTL     = 2 * time.Minute
+	ReportEvery  = 5 * time.Second
+	PollInterval = 5 * time.Second
+	MaxAttempts  = 3
+)
+
+// Upload limits of a result: the size of one keyframe image and of the
+// whole upload.
+const (
+	MaxFrameSize  = 20 << 20
+	MaxResultSize = 1 << 30
+)
+
+// WorkerHeader names the worker on every request, so the main instance only
+// accepts reports from the worker holding the job.
+const WorkerHeader = "X-Video-Worker"
+
+// ErrJobLost is returned to a worker whose job was canceled or handed to
+// another worker after its claim expired.
+var ErrJobLost = errors.New("任务已取消或已交给其他处理节点")
+
+// Job is a video job claimed by a worker.
+type Job struct {
+	ID             string   `json:"id"`
+	DocumentID     string   `json:"document_id"`
+	FileName       string   `json:"file_name"`
+	SkipTranscript bool     `json:"skip_transcript"` // the document has uploaded subtitles
+	Settings       Settings `json:"settings"`
+}
+
+// Settings are the video settings of the main instance that decide what is
+// extracted. Which ffmpeg and speech-to-text backend do the work is up to
+// the worker's own configuration.
+type Settings struct {
+	KeyframeMode          string   `json:"keyframe_mode"`
+	KeyframeInterval      int      `json:"keyframe_interval"`
+	SceneThreshold        float64  `json:"scene_threshold"`
+	MaxKeyframes          int      `json:"max_keyframes"`
+	KeyframeDedupDistance int      `json:"keyframe_dedup_distance"`
+	Diarize               bool     `json:"diarize"` // speakers labelled by the speech-to-text backend
+	SpeakerLabels         []string `json:"speaker_labels"`
+}
+
+// NewSettings returns the settings of cfg that are sent with a job.
+func NewSettings(cfg config.VideoConfig) Settings {
+	return Settings{
+		KeyframeMode:          cfg.KeyframeMode,
+		KeyframeInterval:      cfg.KeyframeInterval,
+		SceneThreshold:        cfg.SceneThreshold,
+		MaxKeyframes:          cfg.MaxKeyframes,
+		KeyframeDedupDistance: cfg.KeyframeDedupDistance,
+		Diarize:               cfg.Diarization == "stt",
+		SpeakerLabels:         cfg.SpeakerLabels,
+	}
+}
+
+// apply returns the worker's video configuration with the job's settings.
+func (s Settings) apply(cfg config.VideoConfig) config.VideoConfig {
+	cfg.KeyframeMode = s.KeyframeMode
+	cfg.KeyframeInterval = s.KeyframeInterval
+	cfg.SceneThreshold = s.SceneThreshold
+	cfg.MaxKeyframes = s.MaxKeyframes
+	cfg.KeyframeDedupDistance = s.KeyframeDedupDistance
+	cfg.Diarization = "off"
+	if s.Diarize {
+		cfg.Diarization = "stt"
+	}
+	cfg.SpeakerLabels = s.SpeakerLabels
+	return cfg
+}
+
+// Progress is a progress report of a running job, in the steps and units
+// of video.ProgressFunc.
+type Progress struct {
+	Step  string `json:"step"`
+	Done  int    `json:"done"`
+	Total int    `json:"total"`
+}
+
+// Failure reports a job the worker could not parse.
+type Failure struct {
+	Error string `json:"error"`
+}
+
+// result is the "result" part of an uploaded result. The keyframe images
+// follow as "frame" parts in the order of Keyframes.
+type result struct {
+	Duration   float64                   `json:"duration"`
+	Transcript []video.TranscriptSegment `json:"transcript"`
+	Keyframes  []float64                 `json:"keyframes"` // timestamps of the frames
+}
+
+// writeResult writes res as a multipart result upload.
+func writeResult(mw *multipart.Writer, res *video.ParseResult) error {
+	manifest := result{Duration: res.Duration, Transcript: res.Transcript, Keyframes: make([]float64, len(res.Keyframes))}
+	for i, kf := range res.Keyframes {
+		manifest.Keyframes[i] = kf.Timestamp
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := mw.WriteField("result", string(data)); err != nil {
+		return err
+	}
+	for i, kf := range res.Keyframes {
+		fw, err := mw.CreateFormFile("frame", fmt.Sprintf("%d.jpg", i))
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(kf.Data); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// ReadResult reads a result uploaded by a worker.
+func ReadResult(mr *multipart.Reader) (*video.ParseResult, error) {
+	var manifest *result
+	var frames [][]byte
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch part.FormName() {
+		case "result":
+			manifest = &result{}
+			if err := json.NewDecoder(io.LimitReader(part, 64<<20)).Decode(manifest); err != nil {
+				return nil, fmt.Errorf("invalid result: %w", err)
+			}
+		case "frame":
+			data, err := io.ReadAll(io.LimitReader(part, MaxFrameSize+1))
+			if err != nil {
+				return nil, err
+			}
+			if len(data) > MaxFrameSize {
+				return nil, fmt.Errorf("keyframe %d is larger than %d bytes", len(frames), MaxFrameSize)
+			}
+			frames = append(frames, data)
+		}
+		part.Close()
+	}
+	if manifest == nil {
+		return nil, errors.New("result part is missing")
+	}
+	if len(frames) != len(manifest.Keyframes) {
+		return nil, fmt.Errorf("result lists %d keyframes but %d were uploaded", len(manifest.Keyframes), len(frames))
+	}
+	res := &video.ParseResult{Duration: manifest.Duration, Transcript: manifest.Transcript}
+	for i, ts := range manifest.Keyframes {
+		res.Keyframes = append(res.Keyframes, video.Keyframe{Timestamp: ts, Data: frames[i]})
+	}
+	return res, nil
+}
@@ -0,0 +1,289 @@
+package videoworker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"askflow/internal/config"
+	"askflow/internal/video"
+)
+
+// requestTimeout bounds the short requests to the main instance; video
+// downloads and result uploads are only bounded by the job's context.
+const requestTimeout = 30 * time.Second
+
+// Client talks to the main instance on behalf of a worker.
+type Client struct {
+	server *url.URL
+	token  string
+	name   string
+	http   *http.Client
+}
+
+// NewClient returns a client for the main instance at serverURL (scheme,
+// host and the instance's base path, if any). token is the shared secret
+// video.workers.token is set to and name identifies the worker in the
+// main instance's logs.
+func NewClient(serverURL, token, name string) (*Client, error) {
+	u, err := url.Parse(strings.TrimRight(serverURL, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid server URL %q: must be an http or https URL", serverURL)
+	}
+	if token == "" {
+		return nil, errors.New("video worker token is not set")
+	}
+	if name == "" {
+		return nil, errors.New("worker name is empty")
+	}
+	return &Client{server: u, token: token, name: name, http: &http.Client{}}, nil
+}
+
+// Name returns the name the worker reports to the main instance.
+func (c *Client) Name() string {
+	return c.name
+}
+
+// do sends a request to path on the main instance. Responses other than
+// 2xx become errors; 409 means the job was lost.
+func (c *Client) do(ctx context.Context, method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.server.String()+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set(WorkerHeader, c.name)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, context.Cause(ctx)
+		}
+		return nil, fmt.Errorf("server unreachable: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusConflict {
+			return nil, ErrJobLost
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// postJSON sends v as JSON to path with the short request timeout.
+func (c *Client) postJSON(ctx context.Context, path string, v interface{}) (*http.Response, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	resp, err := c.do(ctx, http.MethodPost, path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	// Read the (small) body before the timeout context is canceled
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	return resp, nil
+}
+
+// Claim takes the oldest queued job, or returns nil when there is none.
+func (c *Client) Claim(ctx context.Context) (*Job, error) {
+	resp, err := c.postJSON(ctx, "/api/video-worker/claim", struct{}{})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	var job Job
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		return nil, fmt.Errorf("invalid job: %w", err)
+	}
+	return &job, nil
+}
+
+// Download writes the video of job jobID to path.
+func (c *Client) Download(ctx context.Context, jobID, path string) error {
+	resp, err := c.do(ctx, http.MethodGet, "/api/video-worker/jobs/"+jobID+"/video", "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		return fmt.Errorf("download video: %w", err)
+	}
+	return f.Close()
+}
+
+// Report sends the progress of a running job, which keeps the claim alive.
+func (c *Client) Report(ctx context.Context, jobID string, p Progress) error {
+	_, err := c.postJSON(ctx, "/api/video-worker/jobs/"+jobID+"/progress", p)
+	return err
+}
+
+// Complete uploads the parse result of a job.
+func (c *Client) Complete(ctx context.Context, jobID string, res *video.ParseResult) error {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeResult(mw, res))
+	}()
+	resp, err := c.do(ctx, http.MethodPost, "/api/video-worker/jobs/"+jobID+"/result", mw.FormDataContentType(), pr)
+	pr.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Fail reports a job the worker could not parse.
+func (c *Client) Fail(ctx context.Context, jobID, msg string) error {
+	_, err := c.postJSON(ctx, "/api/video-worker/jobs/"+jobID+"/fail", Failure{Error: msg})
+	return err
+}
+
+// Run claims and runs jobs one at a time until ctx is done, polling every
+// PollInterval while the queue is empty. cfg supplies the tools and the
+// speech-to-text backend; what to extract comes with each job.
+func Run(ctx context.Context, c *Client, cfg config.VideoConfig) {
+	for ctx.Err() == nil {
+		job, err := c.Claim(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("[VideoWorker] claim failed: %v", err)
+			}
+			sleep(ctx, PollInterval)
+			continue
+		}
+		if job == nil {
+			sleep(ctx, PollInterval)
+			continue
+		}
+		c.run(ctx, job, cfg)
+	}
+}
+
+// run parses the video of one job and uploads the result. A job lost to
+// cancellation is dropped; one interrupted by shutdown is left to expire
+// and go to another worker.
+func (c *Client) run(ctx context.Context, job *Job, cfg config.VideoConfig) {
+	start := time.Now()
+	log.Printf("[VideoWorker] job %s: parsing %q (doc=%s)", job.ID, job.FileName, job.DocumentID)
+	jobCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// Report the latest progress every ReportEvery until the job is done
+	var mu sync.Mutex
+	progress := Progress{}
+	reported := make(chan struct{})
+	go func() {
+		defer close(reported)
+		ticker := time.NewTicker(ReportEvery)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-jobCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			mu.Lock()
+			p := progress
+			mu.Unlock()
+			if err := c.Report(jobCtx, job.ID, p); errors.Is(err, ErrJobLost) {
+				cancel(ErrJobLost)
+				return
+			} else if err != nil && jobCtx.Err() == nil {
+				log.Printf("[VideoWorker] job %s: progress report failed: %v", job.ID, err)
+			}
+		}
+	}()
+	defer func() {
+		cancel(nil)
+		<-reported
+	}()
+
+	res, err := c.parse(jobCtx, job, cfg, func(step string, done, total int) {
+		mu.Lock()
+		progress = Progress{Step: step, Done: done, Total: total}
+		mu.Unlock()
+	})
+	if err == nil {
+		err = c.Complete(jobCtx, job.ID, res)
+	}
+	switch {
+	case errors.Is(context.Cause(jobCtx), ErrJobLost) || errors.Is(err, ErrJobLost):
+		log.Printf("[VideoWorker] job %s: canceled or taken over, dropped", job.ID)
+	case ctx.Err() != nil:
+		log.Printf("[VideoWorker] job %s: interrupted by shutdown", job.ID)
+	case err != nil:
+		log.Printf("[VideoWorker] job %s: failed after %s: %v", job.ID, time.Since(start).Round(time.Second), err)
+		if fErr := c.Fail(ctx, job.ID, err.Error()); fErr != nil {
+			log.Printf("[VideoWorker] job %s: failed to report failure: %v", job.ID, fErr)
+		}
+	default:
+		log.Printf("[VideoWorker] job %s: done in %s, %d transcript segments, %d keyframes",
+			job.ID, time.Since(start).Round(time.Second), len(res.Transcript), len(res.Keyframes))
+	}
+}
+
+// parse downloads the video of job to a temporary directory and parses it.
+func (c *Client) parse(ctx context.Context, job *Job, cfg config.VideoConfig, onProgress video.ProgressFunc) (*video.ParseResult, error) {
+	dir, err := os.MkdirTemp("", "askflow-video-worker-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "video"+strings.ToLower(filepath.Ext(job.FileName)))
+	if err := c.Download(ctx, job.ID, path); err != nil {
+		return nil, err
+	}
+	p := video.NewParser(job.Settings.apply(cfg))
+	if job.SkipTranscript {
+		// An empty transcript skips speech recognition; the main instance
+		// uses the uploaded subtitles
+		p.Transcript = []video.TranscriptSegment{}
+	}
+	p.OnProgress = onProgress
+	return p.ParseContext(ctx, path)
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
@@ -0,0 +1,452 @@
+// Package webhook delivers system events to admin-configured HTTP endpoints.
+// Each delivery is a JSON POST signed with HMAC-SHA256 over the request body
+// using the webhook's secret, and failed deliveries are retried with backoff.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"askflow/internal/errlog"
+)
+
+// Supported event types.
+const (
+	EventDocumentProcessed      = "document.processed"
+	EventQuestionPendingCreated = "question.pending_created"
+	EventQuestionAnswered       = "question.answered"
+	EventQuestionOverdue        = "question.overdue"
+	EventUserRegistered         = "user.registered"
+	EventQueryIntent            = "query.intent"
+)
+
+// EventTypes lists every event type a webhook may subscribe to.
+var EventTypes = []string{
+	EventDocumentProcessed,
+	EventQuestionPendingCreated,
+	EventQuestionAnswered,
+	EventQuestionOverdue,
+	EventUserRegistered,
+	EventQueryIntent,
+}
+
+// retryDelays is the backoff schedule between delivery attempts.
+var retryDelays = []time.Duration{10 * time.Second, time.Minute, 5 * time.Minute, 30 * time.Minute}
+
+// Webhook is an admin-configured delivery target.
+type Webhook struct {
+	ID             string     `json:"id"`
+	URL            string     `json:"url"`
+	Secret         string     `json:"secret,omitempty"` // only returned on creation
+	Events         []string   `json:"events"`           // empty means all events
+	Enabled        bool       `json:"enabled"`
+	LastStatus     int        `json:"last_status"`
+	LastError      string     `json:"last_error,omitempty"`
+	LastDeliveryAt *time.Time `json:"last_delivery_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// Event is the JSON payload delivered to webhook endpoints.
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Service stores webhook configuration and dispatches events asynchronously.
+type Service struct {
+	db         *sql.DB
+	httpClient *http.Client
+	queue      chan Event
+	stopCh     chan struct{}
+	stopOnce   sync.Once
+	wg         sync.WaitGroup
+}
+
+// NewService creates a webhook Service and starts its dispatch worker.
+func NewService(db *sql.DB) *Service {
+	s := &Service{
+		db:         db,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		queue:      make(chan Event, 256),
+		stopCh:     make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.worker()
+	return s
+}
+
+// Stop stops the dispatch worker and abandons pending retries.
+func (s *Service) Stop() {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+	s.wg.Wait()
+}
+
+// Emit queues an event for delivery. It never blocks; events are dropped
+// with a log entry if the queue is full.
+func (s *Service) Emit(eventType string, data interface{}) {
+	if s == nil {
+		return
+	}
+	id, err := generateID()
+	if err != nil {
+		return
+	}
+	ev := Event{ID: id, Type: eventType, CreatedAt: time.Now().UTC(), Data: data}
+	select {
+	case s.queue <- ev:
+	default:
+		log.Printf("[Webhook] queue full, dropping event %s", eventType)
+		errlog.Logf("[Webhook] queue full, dropping event %s id=%s", eventType, id)
+	}
+}
+
+// worker fans out queued events to subscribed webhooks.
+func (s *Service) worker() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case ev := <-s.queue:
+			s.dispatch(ev)
+		}
+	}
+}
+
+// dispatch sends ev to every enabled webhook subscribed to its type.
+func (s *Service) dispatch(ev Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("[Webhook] panic dispatching %s: %v", ev.Type, r)
+		}
+	}()
+	hooks, err := s.listTargets(ev.Type)
+	if err != nil {
+		log.Printf("[Webhook] failed to load targets for %s: %v", ev.Type, err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("[Webhook] failed to encode event %s: %v", ev.Type, err)
+		return
+	}
+	for _, h := range hooks {
+		s.wg.Add(1)
+		go s.deliverWithRetry(h, ev, body)
+	}
+}
+
+// deliverWithRetry attempts delivery, retrying on failure per retryDelays.
+func (s *Service) deliverWithRetry(h Webhook, ev Event, body []byte) {
+	defer s.wg.Done()
+	for attempt := 0; ; attempt++ {
+		status, err := s.deliver(h, ev, body)
+		s.recordResult(h.ID, status, err)
+		if err == nil {
+			return
+		}
+		if attempt >= len(retryDelays) {
+			log.Printf("[Webhook] giving up on %s for event %s after %d attempts: %v", h.URL, ev.ID, attempt+1, err)
+			errlog.Logf("[Webhook] delivery failed webhook=%s event=%s id=%s: %v", h.ID, ev.Type, ev.ID, err)
+			return
+		}
+		select {
+		case <-s.stopCh:
+			return
+		case <-time.After(retryDelays[attempt]):
+		}
+	}
+}
+
+// deliver performs a single signed POST. Any non-2xx response is an error.
+func (s *Service) deliver(h Webhook, ev Event, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Askflow-Webhook/1.0")
+	req.Header.Set("X-Askflow-Event", ev.Type)
+	req.Header.Set("X-Askflow-Delivery", ev.ID)
+	req.Header.Set("X-Askflow-Signature", "sha256="+Sign(h.Secret, body))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex HMAC-SHA256 of body keyed with secret. Receivers verify
+// deliveries by comparing it with the X-Askflow-Signature header.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// recordResult stores the outcome of the latest delivery attempt.
+func (s *Service) recordResult(id string, status int, deliveryErr error) {
+	errMsg := ""
+	if deliveryErr != nil {
+		errMsg = deliveryErr.Error()
+	}
+	if _, err := s.db.Exec(
+		`UPDATE webhooks SET last_status = ?, last_error = ?, last_delivery_at = ? WHERE id = ?`,
+		status, errMsg, time.Now().UTC(), id,
+	); err != nil {
+		log.Printf("[Webhook] failed to record delivery result for %s: %v", id, err)
+	}
+}
+
+// listTargets returns enabled webhooks subscribed to eventType.
+func (s *Service) listTargets(eventType string) ([]Webhook, error) {
+	hooks, err := s.list(true)
+	if err != nil {
+		return nil, err
+	}
+	var targets []Webhook
+	for _, h := range hooks {
+		if len(h.Events) == 0 {
+			targets = append(targets, h)
+			continue
+		}
+		for _, e := range h.Events {
+			if e == eventType {
+				targets = append(targets, h)
+				break
+			}
+		}
+	}
+	return targets, nil
+}
+
+// List returns all configured webhooks without their secrets.
+func (s *Service) List() ([]Webhook, error) {
+	hooks, err := s.list(false)
+	if err != nil {
+		return nil, err
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	return hooks, nil
+}
+
+func (s *Service) list(enabledOnly bool) ([]Webhook, error) {
+	query := `SELECT id, url, secret, events, enabled, COALESCE(last_status, 0), COALESCE(last_error, ''), last_delivery_at, created_at FROM webhooks`
+	if enabledOnly {
+		query += ` WHERE enabled = 1`
+	}
+	query += ` ORDER BY created_at`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var hooks []Webhook
+	for rows.Next() {
+		var h Webhook
+		var events string
+		var enabled int
+		var lastDelivery sql.NullTime
+		if err := rows.Scan(&h.ID, &h.URL, &h.Secret, &events, &enabled, &h.LastStatus, &h.LastError, &lastDelivery, &h.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook: %w", err)
+		}
+		h.Events = splitEvents(events)
+		h.Enabled = enabled == 1
+		if lastDelivery.Valid {
+			t := lastDelivery.Time
+			h.LastDeliveryAt = &t
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, rows.Err()
+}
+
+// Create adds a webhook. If secret is empty a random one is generated.
+// The returned Webhook includes the secret so it can be shown once.
+func (s *Service) Create(rawURL, secret string, events []string) (*Webhook, error) {
+	if err := validateURL(rawURL); err != nil {
+		return nil, err
+	}
+	events, err := normalizeEvents(events)
+	if err != nil {
+		return nil, err
+	}
+	if secret == "" {
+		if secret, err = generateID(); err != nil {
+			return nil, err
+		}
+	}
+	if len(secret) > 200 {
+		return nil, fmt.Errorf("secret too long (max 200 characters)")
+	}
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	_, err = s.db.Exec(
+		`INSERT INTO webhooks (id, url, secret, events, enabled, created_at) VALUES (?, ?, ?, ?, 1, ?)`,
+		id, rawURL, secret, strings.Join(events, ","), now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return &Webhook{ID: id, URL: rawURL, Secret: secret, Events: events, Enabled: true, CreatedAt: now}, nil
+}
+
+// Update changes a webhook's URL, event subscriptions and enabled flag.
+// The secret is rotated only when newSecret is non-empty.
+func (s *Service) Update(id, rawURL string, events []string, enabled bool, newSecret string) error {
+	if err := validateURL(rawURL); err != nil {
+		return err
+	}
+	events, err := normalizeEvents(events)
+	if err != nil {
+		return err
+	}
+	if len(newSecret) > 200 {
+		return fmt.Errorf("secret too long (max 200 characters)")
+	}
+	var result sql.Result
+	if newSecret != "" {
+		result, err = s.db.Exec(`UPDATE webhooks SET url = ?, events = ?, enabled = ?, secret = ? WHERE id = ?`,
+			rawURL, strings.Join(events, ","), enabled, newSecret, id)
+	} else {
+		result, err = s.db.Exec(`UPDATE webhooks SET url = ?, events = ?, enabled = ? WHERE id = ?`,
+			rawURL, strings.Join(events, ","), enabled, id)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update webhook: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("webhook not found")
+	}
+	return nil
+}
+
+// Delete removes a webhook.
+func (s *Service) Delete(id string) error {
+	result, err := s.db.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("webhook not found")
+	}
+	return nil
+}
+
+// Test sends a synchronous "ping" event to a single webhook and returns the result.
+func (s *Service) Test(id string) (int, error) {
+	hooks, err := s.list(false)
+	if err != nil {
+		return 0, err
+	}
+	for _, h := range hooks {
+		if h.ID != id {
+			continue
+		}
+		evID, err := generateID()
+		if err != nil {
+			return 0, err
+		}
+		ev := Event{ID: evID, Type: "ping", CreatedAt: time.Now().UTC(), Data: map[string]string{"webhook_id": id}}
+		body, err := json.Marshal(ev)
+		if err != nil {
+			return 0, err
+		}
+		status, err := s.deliver(h, ev, body)
+		s.recordResult(h.ID, status, err)
+		return status, err
+	}
+	return 0, fmt.Errorf("webhook not found")
+}
+
+// validateURL requires an absolute http(s) URL.
+func validateURL(rawURL string) error {
+	if len(rawURL) > 2000 {
+		return fmt.Errorf("URL too long")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL")
+	}
+	return nil
+}
+
+// normalizeEvents validates event names and removes duplicates.
+func normalizeEvents(events []string) ([]string, error) {
+	var out []string
+	seen := make(map[string]bool)
+	for _, e := range events {
+		e = strings.TrimSpace(e)
+		if e == "" || seen[e] {
+			continue
+		}
+		valid := false
+		for _, t := range EventTypes {
+			if e == t {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown event type: %s", e)
+		}
+		seen[e] = true
+		out = append(out, e)
+	}
+	if out == nil {
+		out = []string{}
+	}
+	return out, nil
+}
+
+func splitEvents(s string) []string {
+	var out []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			out = append(out, e)
+		}
+	}
+	if out == nil {
+		out = []string{}
+	}
+	return out
+}
+
+// generateID creates a random hex string for use as a unique identifier.
+func generateID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -11,8 +11,6 @@ import (
 	"strings"
 	"syscall"
 
-	"time"
-
 	"askflow/internal/cli"
 	"askflow/internal/handler"
 	"askflow/internal/router"
@@ -57,17 +55,51 @@ func main() {
 			return
 		case "backup":
 			runCLICommand(dataDir, func(appSvc *service.AppService) {
-				cli.RunBackup(os.Args[2:], appSvc.GetDatabase())
+				cli.RunBackup(os.Args[2:], appSvc.GetDatabase(), appSvc.GetConfigManager().Get().Backup.S3)
 			})
 			return
 		case "restore":
-			cli.RunRestore(os.Args[2:])
+			cli.RunRestore(os.Args[2:], dataDir)
+			return
+		case "migrate":
+			cli.RunMigrate(os.Args[2:], dataDir)
+			return
+		case "eval":
+			runCLICommand(dataDir, func(appSvc *service.AppService) {
+				cli.RunEval(os.Args[2:], appSvc.GetQueryEngine(), appSvc.GetConfigManager().Get().Vector)
+			})
+			return
+		case "query":
+			runCLICommand(dataDir, func(appSvc *service.AppService) {
+				cli.RunQuery(os.Args[2:], appSvc.GetQueryEngine(), appSvc.GetProductService())
+			})
+			return
+		case "stats":
+			runCLICommand(dataDir, func(appSvc *service.AppService) {
+				cli.RunStats(os.Args[2:], appSvc.GetDocManager())
+			})
+			return
+		case "fsck":
+			runCLICommand(dataDir, func(appSvc *service.AppService) {
+				cli.RunFsck(os.Args[2:], appSvc.GetDocManager())
+			})
+			return
+		case "rotate-key":
+			runCLICommand(dataDir, func(appSvc *service.AppService) {
+				cli.RunRotateKey(os.Args[2:], appSvc.GetConfigManager(), appSvc.ReencryptSecrets)
+			})
 			return
 		case "products":
 			runCLICommand(dataDir, func(appSvc *service.AppService) {
 				cli.RunListProducts(appSvc.GetProductService())
 			})
 			return
+		case "openapi":
+			cli.RunOpenAPI(os.Args[2:], router.APIDoc())
+			return
+		case "video-worker":
+			cli.RunVideoWorker(os.Args[2:], dataDir)
+			return
 		case "help", "-h", "--help":
 			printUsage()
 			return
@@ -138,14 +170,38 @@ func parseBindFlag() string {
 	return ""
 }
 
+// parseStringFlag extracts a --name=value or --name value flag, falling back
+// to the environment variable env when the flag is absent.
+func parseStringFlag(name, env string) string {
+	for i, arg := range os.Args {
+		if strings.HasPrefix(arg, "--"+name+"=") {
+			return strings.TrimPrefix(arg, "--"+name+"=")
+		}
+		if arg == "--"+name && i+1 < len(os.Args) {
+			return os.Args[i+1]
+		}
+	}
+	return os.Getenv(env)
+}
+
+// parseServerOverrides collects the listen settings given on the command
+// line (--bind, --port, --listen, --base-path, --replica-of) or in the
+// environment (ASKFLOW_LISTEN_ADDR, ASKFLOW_BASE_PATH, ASKFLOW_REPLICA_OF).
+func parseServerOverrides() service.ServerOverrides {
+	return service.ServerOverrides{
+		Bind:       parseBindFlag(),
+		Port:       parsePortFlag(),
+		ListenAddr: parseStringFlag("listen", "ASKFLOW_LISTEN_ADDR"),
+		BasePath:   parseStringFlag("base-path", "ASKFLOW_BASE_PATH"),
+		ReplicaOf:  parseStringFlag("replica-of", "ASKFLOW_REPLICA_OF"),
+	}
+}
+
 // runAsConsoleApp runs the application in console mode.
 func runAsConsoleApp(dataDir string) {
-	bind := parseBindFlag()
-	port := parsePortFlag()
-
 	// Initialize application service
 	appSvc := &service.AppService{}
-	if err := appSvc.Initialize(dataDir, bind, port); err != nil {
+	if err := appSvc.Initialize(dataDir, parseServerOverrides()); err != nil {
 		log.Fatalf("Failed to initialize application: %v", err)
 	}
 
@@ -153,11 +209,17 @@ func runAsConsoleApp(dataDir string) {
 	app := appSvc.CreateApp()
 	cleanupRouter := router.Register(app)
 	defer cleanupRouter()
-	http.Handle("/", handler.SpaHandler("frontend/dist"))
+	http.Handle("/", handler.SpaHandler("frontend/dist", appSvc.BasePath()))
 
 	// Run with graceful shutdown
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
+	go func() {
+		// Restore default signal handling once draining starts, so a second
+		// Ctrl-C terminates immediately instead of waiting for the drain.
+		<-ctx.Done()
+		cancel()
+	}()
 
 	fmt.Printf("Starting Askflow in console mode (data directory: %s)...\n", dataDir)
 	if err := appSvc.Run(ctx); err != nil && err != http.ErrServerClosed {
@@ -168,10 +230,11 @@ func runAsConsoleApp(dataDir string) {
 // runCLICommand initializes the app service and runs a CLI command.
 func runCLICommand(dataDir string, fn func(*service.AppService)) {
 	appSvc := &service.AppService{}
-	if err := appSvc.Initialize(dataDir, "", 0); err != nil {
+	if err := appSvc.Initialize(dataDir, service.ServerOverrides{}); err != nil {
 		log.Fatalf("Failed to initialize application: %v", err)
 	}
-	defer appSvc.Shutdown(5 * time.Second)
+	// Let asynchronous imports (PDF/PPT/video) finish before exiting
+	defer appSvc.Shutdown(appSvc.ShutdownTimeout())
 	fn(appSvc)
 }
 
@@ -183,6 +246,9 @@ func printUsage() {
   askflow -4, --ipv4                             Listen on IPv4 only (equivalent to --bind=0.0.0.0)
   askflow -6, --ipv6                             Listen on IPv6 (equivalent to --bind=::)
   askflow --port=<port>                          Specify service port (or -p <port>)
+  askflow --listen=<host:port>                   Specify listen address (env ASKFLOW_LISTEN_ADDR)
+  askflow --base-path=<path>                     Serve under a URL sub-path, e.g. /askflow (env ASKFLOW_BASE_PATH)
+  askflow --replica-of=<url>                     Serve queries read-only for the primary at <url> (env ASKFLOW_REPLICA_OF)
   askflow --datadir=<path>                       Specify data directory
 
 Windows Service Commands:
@@ -195,7 +261,15 @@ CLI Commands:
   askflow import [--product <product_id>] <目录> [...]  批量导入目录下的文档到知识库
   askflow products                                         List all products and their IDs
   askflow backup [options]                                 Backup all system data
-  askflow restore <backup_file>                            Restore data from backup
+  askflow restore <backup_file|s3://bucket/key>           Restore data from backup
+  askflow migrate [status|up [version]|down <version>]     Show or change the database schema version
+  askflow eval [options] <golden.yaml|golden.json>         Score retrieval against a golden question set
+  askflow openapi [--output <file>]                        Write the OpenAPI document of the HTTP API
+  askflow query [options] [question]                       Ask a question from the command line (chat mode without one)
+  askflow stats [--json]                                   Show knowledge base statistics
+  askflow fsck [--repair] [--dimension <n>] [--json]       Check (and repair) knowledge base integrity
+  askflow rotate-key [--key <hex>]                         Replace the encryption key and re-encrypt secrets with it
+  askflow video-worker --server <url> [options]            Parse videos queued on the server with local ffmpeg and speech-to-text
   askflow help                                             Show this help information
 
 import command:
@@ -232,6 +306,7 @@ backup command:
     --output <dir>     Output directory for backup file (default: current directory)
     --incremental      Incremental backup mode
     --base <manifest>  Path to base manifest file (required for incremental mode)
+    --no-upload        Do not upload to the S3 target even if backup.s3 is enabled
 
   Examples:
     askflow backup                                    Full backup to current directory
@@ -245,8 +320,126 @@ restore command:
 
   Options:
     --target <dir>     Target restore directory (default: ./data)
+    --list             List the archives under an s3://bucket[/prefix] URL
+
+  S3 archives are downloaded with the backup.s3 settings from the data directory's
+  config; AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_REGION, ASKFLOW_S3_ENDPOINT
+  and ASKFLOW_S3_PATH_STYLE override them (e.g. on a new host without config).
 
   Examples:
     askflow restore askflow_full_myserver_20260212-143000.tar.gz
-    askflow restore --target ./data-new backup.tar.gz`)
+    askflow restore --target ./data-new backup.tar.gz
+    askflow restore --list s3://my-bucket/askflow/
+    askflow restore s3://my-bucket/askflow/askflow_full_myserver_20260212-143000.tar.gz
+
+migrate command:
+  Pending migrations are applied automatically on startup; use this command to
+  inspect or control the schema version explicitly.
+    status             List known migrations and whether they are applied (default)
+    up [version]       Apply pending migrations up to version (default: latest)
+    down <version>     Roll back applied migrations newer than version
+
+  Examples:
+    askflow migrate
+    askflow migrate up
+    askflow migrate down 1
+
+eval command:
+  Run a golden question set through retrieval and report recall@k, MRR and
+  LLM-judged answer faithfulness. Nothing is written to the database.
+  The set is a YAML or JSON file:
+    name: install-faq
+    product_id: abc123            # optional default for all cases
+    cases:
+      - id: install-1
+        question: 如何安装客户端？
+        expected_documents: [安装手册.pdf]   # document names or IDs
+
+  Options:
+    --k <n>                  Chunks to retrieve per question (default: vector.top_k)
+    --threshold <0-1>        Minimum similarity (default: vector.threshold)
+    --no-judge               Skip answer generation and faithfulness judging
+    --json                   Print the full report as JSON
+    --min-recall <0-1>       Exit with status 1 if recall@k is lower
+    --min-mrr <0-1>          Exit with status 1 if MRR is lower
+    --min-faithfulness <0-1> Exit with status 1 if faithfulness is lower
+
+  Examples:
+    askflow eval golden.yaml
+    askflow eval --k 8 --threshold 0.45 --no-judge golden.yaml
+    askflow eval --min-recall 0.9 --min-mrr 0.7 golden.json
+
+openapi command:
+  Write the OpenAPI 3 document served at /api/openapi.json, for generating
+  API clients offline. Prints to stdout unless --output is given.
+
+  Examples:
+    askflow openapi --output openapi.json
+    npx @openapitools/openapi-generator-cli generate -i openapi.json -g typescript-fetch -o ./askflow-client
+
+query command:
+  Answer a question through the full RAG pipeline against the local database
+  and config, printing the answer, its sources, the time taken and the tokens
+  used. The question is taken from the arguments or stdin; on a terminal
+  without a question, questions are read one per line until "exit".
+
+  Options:
+    --product <product_id>  Product to ask about (default: the first product)
+    --json                  Print the raw query response as JSON
+    --pending               Queue unanswerable questions for the support team
+                            (not done by default)
+    --require-answer        Exit non-zero when a question gets no answer
+
+  Examples:
+    askflow query "如何重置密码？"
+    echo "如何重置密码？" | askflow query --product abc123 --require-answer
+    askflow query
+
+stats command:
+  Show document counts by status and type, chunks, video segments, vector
+  dimensions, database and file storage size, and a per-product breakdown.
+
+fsck command:
+  Check the knowledge base for rows left behind by missing document records,
+  chunks whose vector dimension differs from the rest, chunk image URLs whose
+  file is missing and image records without a file. Exits 1 when problems
+  are found. Stop the service before repairing.
+
+  Options:
+    --repair         Delete leftover rows, mark documents with mismatched
+                     vectors as failed (re-import them), clear broken image
+                     URLs and delete image records without a file
+    --dimension <n>  Expected vector dimension (default: the most common one)
+    --json           Print the report as JSON
+
+rotate-key command:
+  Replace the config encryption key. Backs up config.json and
+  data/encryption.key to *.<time>.bak, installs the new key and encrypts the
+  config secrets, connector tokens and data encrypted at rest again with it.
+  Until it finishes, the replaced key is kept in data/encryption.key.previous
+  and still decrypts; running the command again resumes an interrupted
+  rotation. Stop the service first. With ASKFLOW_ENCRYPTION_KEY set, the
+  new key is printed and the variable must be changed before the next start.
+
+  Options:
+    --key <hex>  New 32-byte key as 64 hex digits (default: a random key)
+
+video-worker command:
+  Run this machine (e.g. one with a GPU) as a remote video worker of the
+  server at --server, which needs video.workers.enabled and
+  video.workers.token set. Queued videos are downloaded, parsed with the
+  ffmpeg and speech-to-text backend of the local config.json (in --datadir)
+  and the transcripts and keyframes uploaded; keyframe and speaker settings
+  come from the server. Stops on Ctrl+C; an interrupted video goes to
+  another worker after 2 minutes.
+
+  Options:
+    --server <url>       URL of the server, including its base path if any
+    --token <token>      Shared worker token (default: ASKFLOW_VIDEO_WORKER_TOKEN)
+    --name <name>        Name shown in the server's logs (default: host:pid)
+    --concurrency <n>    Videos parsed at a time, 1-16 (default: 1)
+
+  Examples:
+    askflow video-worker --server https://askflow.example.com --name gpu-1
+    ASKFLOW_VIDEO_WORKER_TOKEN=... askflow video-worker --server http://10.0.0.5:8080 --concurrency 2`)
 }
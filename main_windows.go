@@ -31,6 +31,9 @@ func handleInstall(args []string) {
 	dataDir := parseDataDirFlag()
 	bind := parseBindFlag()
 	port := parsePortFlag()
+	listen := parseStringFlag("listen", "ASKFLOW_LISTEN_ADDR")
+	basePath := parseStringFlag("base-path", "ASKFLOW_BASE_PATH")
+	replicaOf := parseStringFlag("replica-of", "ASKFLOW_REPLICA_OF")
 	exePath, err := os.Executable()
 	if err != nil {
 		log.Fatalf("Failed to get executable path: %v", err)
@@ -47,6 +50,15 @@ func handleInstall(args []string) {
 	if port > 0 {
 		serviceArgs = append(serviceArgs, fmt.Sprintf("--port=%d", port))
 	}
+	if listen != "" {
+		serviceArgs = append(serviceArgs, "--listen="+listen)
+	}
+	if basePath != "" {
+		serviceArgs = append(serviceArgs, "--base-path="+basePath)
+	}
+	if replicaOf != "" {
+		serviceArgs = append(serviceArgs, "--replica-of="+replicaOf)
+	}
 
 	err = askflowSvc.InstallService(serviceName, displayName, description, exePath, serviceArgs)
 	if err != nil {
@@ -63,6 +75,12 @@ func handleInstall(args []string) {
 	if port > 0 {
 		fmt.Printf("  Port: %d\n", port)
 	}
+	if listen != "" {
+		fmt.Printf("  Listen address: %s\n", listen)
+	}
+	if basePath != "" {
+		fmt.Printf("  Base path: %s\n", basePath)
+	}
 	fmt.Println("\nTo start the service, run:")
 	fmt.Println("  askflow start")
 	fmt.Println("\nOr use Windows Services Manager (services.msc)")
@@ -104,12 +122,9 @@ func runAsService(dataDir string) {
 	}
 	defer logger.Close()
 
-	port := parsePortFlag()
-	bind := parseBindFlag()
-
 	// Initialize application service
 	appSvc := &service.AppService{}
-	if err := appSvc.Initialize(dataDir, bind, port); err != nil {
+	if err := appSvc.Initialize(dataDir, parseServerOverrides()); err != nil {
 		logger.Error("Failed to initialize application: %v", err)
 		log.Fatalf("Failed to initialize application: %v", err)
 	}
@@ -118,7 +133,7 @@ func runAsService(dataDir string) {
 	app := appSvc.CreateApp()
 	cleanupRouter := router.Register(app)
 	defer cleanupRouter()
-	http.Handle("/", handler.SpaHandler("frontend/dist"))
+	http.Handle("/", handler.SpaHandler("frontend/dist", appSvc.BasePath()))
 
 	// Create Windows service handler
 	askflowService := askflowSvc.NewAskflowService(appSvc, logger)
@@ -0,0 +1,19 @@
+package sqlitevec
+
+// SetDocumentBoosts sets the factors by which the search scores of each
+// listed document's chunks are multiplied, replacing earlier ones; other
+// documents keep a factor of 1. Thresholds still apply to the unscaled
+// similarity, so a boost changes the ranking of matches but not which
+// chunks match.
+func (s *SQLiteVectorStore) SetDocumentBoosts(boosts map[string]float64) {
+	m := make(map[string]float32, len(boosts))
+	for id, b := range boosts {
+		if b != 1 {
+			m[id] = float32(b)
+		}
+	}
+	s.mu.Lock()
+	s.boosts = m
+	s.mu.Unlock()
+	s.searchCache.invalidate()
+}
@@ -0,0 +1,129 @@
+package sqlitevec
+
+import "fmt"
+
+// TextCodec transforms chunk text on its way into and out of storage, e.g.
+// to encrypt it at rest. The cache and search results always hold the
+// decoded text. Decode must accept text stored in another form (such as
+// plain text from before the codec was set), and Encode must return the same
+// result for the same text so that stored chunks can still be looked up by
+// text.
+type TextCodec interface {
+	Encode(text string) (string, error)
+	Decode(stored string) (string, error)
+}
+
+// recodeBatch is the number of chunks RecodeText rewrites per transaction.
+const recodeBatch = 500
+
+// SetTextCodec sets the codec for chunk text written to and read from the
+// chunks table and the snapshot file; nil stores text as is. It must be
+// called before the cache is loaded.
+func (s *SQLiteVectorStore) SetTextCodec(c TextCodec) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codec = c
+}
+
+// EncodeText returns text as the store writes it to the chunks table.
+func (s *SQLiteVectorStore) EncodeText(text string) (string, error) {
+	if s.codec == nil {
+		return text, nil
+	}
+	return s.codec.Encode(text)
+}
+
+// DecodeText returns the text of a chunk_text value read from the chunks
+// table.
+func (s *SQLiteVectorStore) DecodeText(stored string) (string, error) {
+	if s.codec == nil {
+		return stored, nil
+	}
+	return s.codec.Decode(stored)
+}
+
+// RecodeText rewrites the stored text of the chunks that the current codec
+// would store differently, e.g. plain text after encryption is turned on or
+// text encrypted with a retired key, and returns how many it rewrote. The
+// text itself does not change, so neither does the cache.
+func (s *SQLiteVectorStore) RecodeText() (int, error) {
+	recoded := 0
+	after := ""
+	for {
+		n, last, err := s.recodeBatch(after)
+		recoded += n
+		if err != nil || last == "" {
+			return recoded, err
+		}
+		after = last
+	}
+}
+
+// recodeBatch rewrites the chunks of the next batch after the chunk ID
+// after, returning how many it rewrote and the last ID it looked at, "" at
+// the end of the table.
+func (s *SQLiteVectorStore) recodeBatch(after string) (int, string, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	rows, err := s.db.Query(`SELECT id, chunk_text FROM chunks WHERE id > ? ORDER BY id LIMIT ?`, after, recodeBatch)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to query chunks: %w", err)
+	}
+	type change struct{ id, text string }
+	var changes []change
+	last := ""
+	for rows.Next() {
+		var id, stored string
+		if err := rows.Scan(&id, &stored); err != nil {
+			rows.Close()
+			return 0, "", fmt.Errorf("failed to scan row: %w", err)
+		}
+		last = id
+		text, err := s.DecodeText(stored)
+		if err != nil {
+			rows.Close()
+			return 0, "", fmt.Errorf("failed to decode chunk %s: %w", id, err)
+		}
+		encoded, err := s.EncodeText(text)
+		if err != nil {
+			rows.Close()
+			return 0, "", fmt.Errorf("failed to encode chunk %s: %w", id, err)
+		}
+		if encoded != stored {
+			changes = append(changes, change{id, encoded})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, "", fmt.Errorf("error iterating rows: %w", err)
+	}
+	if len(changes) == 0 {
+		return 0, last, nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	before, _ := s.readVersion(tx)
+	for _, c := range changes {
+		if _, err := tx.Exec(`UPDATE chunks SET chunk_text = ? WHERE id = ?`, c.text, c.id); err != nil {
+			tx.Rollback()
+			return 0, "", fmt.Errorf("failed to update chunk %s: %w", c.id, err)
+		}
+	}
+	version, _ := s.readVersion(tx)
+	if err := tx.Commit(); err != nil {
+		return 0, "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	s.mu.Lock()
+	if s.loaded {
+		s.advanceVersion(before, version)
+	}
+	s.mu.Unlock()
+	// The snapshot holds the stored form of the text too
+	s.scheduleSnapshot()
+	return len(changes), last, nil
+}
@@ -0,0 +1,88 @@
+package sqlitevec
+
+import "fmt"
+
+// compactSlack is the share of unused arena capacity above which Compact
+// reallocates the cache even when no chunk is dropped.
+const compactSlack = 8 // 1/8
+
+// CompactResult reports what Compact did to the in-memory cache.
+type CompactResult struct {
+	Chunks      int   `json:"chunks"`       // chunks cached afterwards
+	Dropped     int   `json:"dropped"`      // cached chunks no longer in the table
+	BytesBefore int64 `json:"bytes_before"` // memory held by vectors and norms before
+	BytesAfter  int64 `json:"bytes_after"`  // and after
+}
+
+// Compact rebuilds the in-memory cache into tightly sized slices, releasing
+// the spare capacity left by appends, and drops cached chunks whose
+// document no longer has rows in the chunks table, e.g. because they were
+// deleted by another process. The cache is left as it is when it holds no
+// such chunks and less than 1/8 of its capacity is unused, so that a cache
+// mapped from a snapshot is not copied for nothing. It does nothing before
+// the cache is loaded.
+func (s *SQLiteVectorStore) Compact() (CompactResult, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	var res CompactResult
+	s.mu.RLock()
+	loaded := s.loaded
+	s.mu.RUnlock()
+	if !loaded {
+		return res, nil
+	}
+
+	// Store and DeleteByDocID wait for writeMu, so the documents read here
+	// stay those of the cache until it is compacted
+	rows, err := s.db.Query(`SELECT DISTINCT document_id FROM chunks`)
+	if err != nil {
+		return res, fmt.Errorf("failed to list documents: %w", err)
+	}
+	docs := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return res, fmt.Errorf("failed to scan document id: %w", err)
+		}
+		docs[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return res, fmt.Errorf("failed to list documents: %w", err)
+	}
+
+	s.mu.Lock()
+	res.BytesBefore = s.cacheBytes()
+	for i := range s.meta {
+		if !docs[s.meta[i].documentID] {
+			res.Dropped++
+		}
+	}
+	slack := cap(s.arena.data) - len(s.arena.data)
+	if res.Dropped > 0 || slack > cap(s.arena.data)/compactSlack {
+		s.compact(func(m *chunkMeta) bool { return !docs[m.documentID] })
+		// compact sizes for the chunks before dropping
+		if cap(s.arena.data) > len(s.arena.data) {
+			s.arena.data = append([]float32(nil), s.arena.data...)
+		}
+		if cap(s.norms) > len(s.norms) {
+			s.norms = append([]float32(nil), s.norms...)
+		}
+		s.searchCache.invalidate()
+	}
+	res.BytesAfter = s.cacheBytes()
+	res.Chunks = len(s.meta)
+	s.mu.Unlock()
+	if res.Dropped > 0 {
+		s.scheduleSnapshot()
+	}
+	return res, nil
+}
+
+// cacheBytes returns the memory allocated for the cached vectors and norms.
+// s.mu must be held.
+func (s *SQLiteVectorStore) cacheBytes() int64 {
+	return 4 * int64(cap(s.arena.data)+cap(s.norms))
+}
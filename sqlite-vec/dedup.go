@@ -0,0 +1,128 @@
+package sqlitevec
+
+import (
+	"sort"
+	"strings"
+)
+
+// dedupRef is what Dedup compares a result by: the chunk's cached vector
+// and inverse norm when it is in memory, and its text bigrams.
+type dedupRef struct {
+	vec     []float32
+	invNorm float32
+	bigrams map[string]bool
+	image   bool
+	cached  bool
+}
+
+// chunkKey identifies a chunk by its document and index.
+type chunkKey struct {
+	documentID string
+	chunkIndex int
+}
+
+// Dedup drops near-duplicate results, such as the same passage from a
+// re-uploaded or overlapping document, keeping the highest-scored of each
+// group. Two results are near-duplicates when the cosine similarity of
+// their vectors, or the Jaccard similarity of their text bigrams, is above
+// threshold; image results are compared by vector only, since their text is
+// a short caption. Vectors and bigrams are taken from the cache, results
+// whose chunk is not in memory are compared by bigrams computed from their
+// text. The remaining results keep their order. A threshold of 1 or more
+// returns results unchanged.
+func (s *SQLiteVectorStore) Dedup(results []SearchResult, threshold float64) []SearchResult {
+	if len(results) < 2 || threshold <= 0 || threshold >= 1 {
+		return results
+	}
+	refs := s.dedupRefs(results)
+
+	order := make([]int, len(results))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return results[order[a]].Score > results[order[b]].Score
+	})
+
+	keep := make([]bool, len(results))
+	var kept []int
+	for _, i := range order {
+		dup := false
+		for _, j := range kept {
+			if refs[i].duplicates(&refs[j], threshold) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			keep[i] = true
+			kept = append(kept, i)
+		}
+	}
+	if len(kept) == len(results) {
+		return results
+	}
+	out := make([]SearchResult, 0, len(kept))
+	for i, r := range results {
+		if keep[i] {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// dedupRefs looks up the cached vector and bigrams of each result's chunk.
+func (s *SQLiteVectorStore) dedupRefs(results []SearchResult) []dedupRef {
+	refs := make([]dedupRef, len(results))
+	wanted := make(map[chunkKey]int, len(results))
+	partitions := make(map[string]bool)
+	for i, r := range results {
+		wanted[chunkKey{r.DocumentID, r.ChunkIndex}] = i
+		partitions[r.PartitionID] = true
+		refs[i].image = r.ImageURL != ""
+	}
+
+	s.mu.RLock()
+	found := 0
+	for p := range partitions {
+		for _, idx := range s.partitionIndex[p] {
+			m := &s.meta[idx]
+			i, ok := wanted[chunkKey{m.documentID, m.chunkIndex}]
+			if !ok || refs[i].cached {
+				continue
+			}
+			if vec := s.arena.getVector(idx); vec != nil {
+				// Copied, since the arena may be compacted once the lock is released
+				refs[i].vec = append([]float32(nil), vec...)
+				refs[i].invNorm = s.norms[idx]
+			}
+			refs[i].bigrams = m.bigrams
+			refs[i].cached = true
+			found++
+		}
+		if found == len(results) {
+			break
+		}
+	}
+	s.mu.RUnlock()
+
+	for i, r := range results {
+		if !refs[i].cached {
+			refs[i].bigrams = charBigrams(strings.ToLower(r.ChunkText))
+		}
+	}
+	return refs
+}
+
+// duplicates reports whether the results of r and o are near-duplicates.
+func (r *dedupRef) duplicates(o *dedupRef, threshold float64) bool {
+	if r.vec != nil && o.vec != nil && len(r.vec) == len(o.vec) && r.invNorm != 0 && o.invNorm != 0 {
+		if float64(dotProductSIMD(r.vec, o.vec)*r.invNorm*o.invNorm) > threshold {
+			return true
+		}
+	}
+	if r.image || o.image {
+		return false
+	}
+	return jaccardBigrams(r.bigrams, o.bigrams) > threshold
+}
@@ -0,0 +1,78 @@
+package sqlitevec
+
+import "sort"
+
+// Filter hides documents from a search: their chunks are skipped as if they
+// were not stored. A nil *Filter hides nothing. Filters are immutable, and
+// the search cache tells them apart by the documents they hide.
+type Filter struct {
+	hidden map[string]bool
+	key    uint64
+}
+
+// NewFilter returns a Filter hiding the listed documents, or nil when the
+// list is empty.
+func NewFilter(documentIDs []string) *Filter {
+	if len(documentIDs) == 0 {
+		return nil
+	}
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	sorted := append([]string(nil), documentIDs...)
+	sort.Strings(sorted)
+	f := &Filter{hidden: make(map[string]bool, len(sorted)), key: offset64}
+	for _, id := range sorted {
+		if f.hidden[id] {
+			continue
+		}
+		f.hidden[id] = true
+		for i := 0; i < len(id); i++ {
+			f.key ^= uint64(id[i])
+			f.key *= prime64
+		}
+		f.key ^= 0xFF // ends the ID
+		f.key *= prime64
+	}
+	return f
+}
+
+// Key identifies the documents f hides: filters hiding the same documents
+// have the same key. It is 0 for nil.
+func (f *Filter) Key() uint64 {
+	if f == nil {
+		return 0
+	}
+	return f.key
+}
+
+// Hides reports whether f hides the document.
+func (f *Filter) Hides(documentID string) bool {
+	return f != nil && f.hidden[documentID]
+}
+
+// Len returns the number of documents f hides.
+func (f *Filter) Len() int {
+	if f == nil {
+		return 0
+	}
+	return len(f.hidden)
+}
+
+// cacheKey mixes f into the search cache key of an unfiltered search.
+func (f *Filter) cacheKey(key uint64) uint64 {
+	if f == nil {
+		return key
+	}
+	const prime64 = 1099511628211
+	return (key ^ f.key) * prime64
+}
+
+// documents returns the hidden set for a cacheView, nil for none.
+func (f *Filter) documents() map[string]bool {
+	if f == nil {
+		return nil
+	}
+	return f.hidden
+}
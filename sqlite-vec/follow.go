@@ -0,0 +1,60 @@
+package sqlitevec
+
+import "fmt"
+
+// followState tracks the chunks table of a store that follows a database
+// written by another process (see Refresh).
+type followState struct {
+	started bool
+	version int64
+	epoch   string
+}
+
+// Refresh brings the cache up to date with a chunks table written by
+// another process, for a store that only reads its database (e.g. a
+// read-only replica of another instance). It compares the chunks_version
+// row with the version seen by the previous call and, when it moved,
+// reloads the whole cache, or in partition cache mode drops the partitions
+// in memory so that they are loaded again on demand. Searches wait while
+// the cache is reloaded. The first call only records the version; it should
+// be made before the cache is loaded. Refresh reports whether the cache
+// changed.
+func (s *SQLiteVectorStore) Refresh() (bool, error) {
+	var version int64
+	var epoch string
+	if err := s.db.QueryRow(`SELECT version, epoch FROM chunks_version WHERE id = 1`).Scan(&version, &epoch); err != nil {
+		return false, fmt.Errorf("failed to read chunks version: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.follow.started {
+		s.follow = followState{started: true, version: version, epoch: epoch}
+		return false, nil
+	}
+	if version == s.follow.version && epoch == s.follow.epoch {
+		return false, nil
+	}
+	s.follow.version, s.follow.epoch = version, epoch
+	s.searchCache.invalidate()
+
+	if s.partitionBudget > 0 {
+		s.meta = nil
+		s.norms = nil
+		s.arena = vectorArena{}
+		s.partitionIndex = make(map[string][]int)
+		s.globalIndex = nil
+		s.resident = make(map[string]*residentPartition)
+		s.complete = false
+		return true, nil
+	}
+	if !s.loaded {
+		return false, nil
+	}
+	if err := s.loadCache(); err != nil {
+		// Load again on the next search rather than serve stale chunks
+		s.loaded = false
+		return true, err
+	}
+	return true, nil
+}
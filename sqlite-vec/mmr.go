@@ -0,0 +1,105 @@
+package sqlitevec
+
+import "math"
+
+// mmrCandidates is how many candidates per requested result MMR selection
+// chooses from.
+const mmrCandidates = 4
+
+// SearchMMR is like Search but selects the topK results by Maximal Marginal
+// Relevance: each next result maximizes
+//
+//	lambda*sim(query, chunk) - (1-lambda)*max sim(chunk, selected)
+//
+// among the best mmrCandidates*topK matches, so that near-duplicate chunks
+// give way to complementary ones. lambda 1 ranks by relevance alone; lower
+// values favor diversity. Scores remain the similarity to the query, while
+// results are in selection order.
+func (s *SQLiteVectorStore) SearchMMR(queryVector []float64, topK int, threshold, lambda float64, partitionID string, filter *Filter) ([]SearchResult, error) {
+	queryF32 := toFloat32(queryVector)
+	cacheKey := filter.cacheKey(hashQueryVector(queryF32, topK, threshold, partitionID) ^ math.Float64bits(lambda+1))
+	return s.mmrSearch(queryF32, topK, threshold, lambda, cacheKey, filter, searchedPartitions(partitionID), func() []int {
+		return s.getRelevantIndices(partitionID)
+	})
+}
+
+// SearchPartitionsMMR is SearchMMR over exactly the listed partitions, as in
+// SearchPartitions.
+func (s *SQLiteVectorStore) SearchPartitionsMMR(queryVector []float64, topK int, threshold, lambda float64, partitions []string, filter *Filter) ([]SearchResult, error) {
+	if len(partitions) == 0 {
+		return nil, nil
+	}
+	queryF32 := toFloat32(queryVector)
+	cacheKey := filter.cacheKey(hashQueryVector(queryF32, topK, threshold, partitionSetKey(partitions)) ^ math.Float64bits(lambda+1))
+	return s.mmrSearch(queryF32, topK, threshold, lambda, cacheKey, filter, partitions, func() []int {
+		return s.partitionUnion(partitions)
+	})
+}
+
+func (s *SQLiteVectorStore) mmrSearch(queryF32 []float32, topK int, threshold, lambda float64, cacheKey uint64, filter *Filter, parts []string, pick func() []int) ([]SearchResult, error) {
+	if cached, ok := s.searchCache.get(cacheKey); ok {
+		return cached, nil
+	}
+	v, err := s.view(parts, pick)
+	if err != nil {
+		return nil, err
+	}
+	v.hidden = filter.documents()
+	candidates := v.topScores(queryF32, topK*mmrCandidates, threshold)
+	results := v.results(v.selectMMR(candidates, topK, float32(lambda)))
+	s.searchCache.put(cacheKey, results)
+	return results, nil
+}
+
+// selectMMR picks topK of candidates, which are sorted by relevance, in
+// Maximal Marginal Relevance order.
+func (v *cacheView) selectMMR(candidates []scoredItem, topK int, lambda float32) []scoredItem {
+	if len(candidates) <= 1 || lambda >= 1 {
+		if len(candidates) > topK {
+			candidates = candidates[:topK]
+		}
+		return candidates
+	}
+	// maxSim[i] is the highest similarity of candidate i to a selected one
+	maxSim := make([]float32, len(candidates))
+	for i := range maxSim {
+		maxSim[i] = -1
+	}
+	taken := make([]bool, len(candidates))
+	selected := make([]scoredItem, 0, min(topK, len(candidates)))
+	for len(selected) < topK && len(selected) < len(candidates) {
+		best, bestScore := -1, float32(0)
+		for i, c := range candidates {
+			if taken[i] {
+				continue
+			}
+			score := lambda * c.score
+			if len(selected) > 0 {
+				score -= (1 - lambda) * maxSim[i]
+			}
+			if best < 0 || score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		taken[best] = true
+		picked := candidates[best]
+		selected = append(selected, picked)
+		for i, c := range candidates {
+			if !taken[i] {
+				if sim := v.similarity(c.idx, picked.idx); sim > maxSim[i] {
+					maxSim[i] = sim
+				}
+			}
+		}
+	}
+	return selected
+}
+
+// similarity returns the cosine similarity of two chunks of the view.
+func (v *cacheView) similarity(a, b int) float32 {
+	va, vb := v.arena.getVector(a), v.arena.getVector(b)
+	if va == nil || vb == nil {
+		return 0
+	}
+	return dotProductSIMD(va, vb) * v.norms[a] * v.norms[b]
+}
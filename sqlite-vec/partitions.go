@@ -0,0 +1,296 @@
+package sqlitevec
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Partition cache mode: instead of loading every chunk up front, the store
+// loads a partition's chunks the first time a search needs them and evicts
+// the least recently searched partitions once the cache grows past its
+// budget, so memory follows the partitions in use rather than the size of
+// the whole table. Snapshots are not used in this mode.
+
+// residentPartition is a partition loaded into the cache.
+type residentPartition struct {
+	bytes    int64        // estimated memory used by its chunks
+	lastUsed atomic.Int64 // UnixNano of the last search that covered it
+}
+
+func (p *residentPartition) touch() {
+	p.lastUsed.Store(time.Now().UnixNano())
+}
+
+// SetPartitionCache switches the store to loading partitions on demand,
+// keeping about maxBytes of chunks in memory. Partitions a search needs are
+// kept even when they exceed the budget. It must be called before the cache
+// is loaded.
+func (s *SQLiteVectorStore) SetPartitionCache(maxBytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if maxBytes <= 0 || s.loaded {
+		return
+	}
+	s.partitionBudget = maxBytes
+	s.resident = make(map[string]*residentPartition)
+	// Nothing is loaded up front; Warm and Loaded see a ready cache
+	s.loaded = true
+}
+
+// searchedPartitions returns the partitions a search of partitionID covers:
+// the partition and the shared "" one, or nil for all partitions.
+func searchedPartitions(partitionID string) []string {
+	if partitionID == "" {
+		return nil
+	}
+	return []string{partitionID, ""}
+}
+
+// cacheView is the part of the cache a search works on. The slices stay
+// valid after the lock is released, as changes to the cache replace or
+// append to them.
+type cacheView struct {
+	meta    []chunkMeta
+	norms   []float32
+	arena   vectorArena
+	indices []int
+	boosts  map[string]float32
+	hidden  map[string]bool // documents skipped by the search, set by it
+}
+
+// view loads what a search of parts (nil for all partitions) needs and
+// returns it with the indices chosen by pick, which is called under the lock.
+func (s *SQLiteVectorStore) view(parts []string, pick func() []int) (cacheView, error) {
+	s.mu.RLock()
+	if s.loaded && s.residentAll(parts) {
+		v := s.capture(parts, pick)
+		s.mu.RUnlock()
+		return v, nil
+	}
+	s.mu.RUnlock()
+
+	if s.partitionBudget > 0 {
+		return s.loadPartitions(parts, pick)
+	}
+	// Upgrade to write lock for one-time cache load.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.loaded { // re-check after acquiring write lock
+		if err := s.loadCache(); err != nil {
+			return cacheView{}, err
+		}
+	}
+	return s.capture(parts, pick), nil
+}
+
+// residentAll reports whether parts are all in memory. nil, for all
+// partitions, is only known to be in memory when the whole table is loaded.
+// s.mu must be held.
+func (s *SQLiteVectorStore) residentAll(parts []string) bool {
+	if s.partitionBudget == 0 {
+		return true
+	}
+	if parts == nil {
+		return s.complete
+	}
+	for _, p := range parts {
+		if s.resident[p] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// capture returns the current view for parts. s.mu must be held.
+func (s *SQLiteVectorStore) capture(parts []string, pick func() []int) cacheView {
+	if s.partitionBudget > 0 {
+		for _, p := range parts {
+			if r := s.resident[p]; r != nil {
+				r.touch()
+			}
+		}
+	}
+	return cacheView{meta: s.meta, norms: s.norms, arena: s.arena, indices: pick(), boosts: s.boosts}
+}
+
+// loadPartitions loads the partitions of parts not in memory yet (all
+// partitions of the table for nil), evicts others if the cache is over its
+// budget and returns the view for parts.
+func (s *SQLiteVectorStore) loadPartitions(parts []string, pick func() []int) (cacheView, error) {
+	// Holding writeMu keeps Store and DeleteByDocID from changing the table
+	// between reading a partition and adding it to the cache
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	need := parts
+	if need == nil {
+		var err error
+		if need, err = s.allPartitions(); err != nil {
+			return cacheView{}, err
+		}
+	}
+	for _, p := range need {
+		s.mu.RLock()
+		loaded := s.resident[p] != nil
+		s.mu.RUnlock()
+		if loaded {
+			continue
+		}
+		where, args := ` WHERE product_id = ?`, []interface{}{p}
+		if p == "" {
+			where, args = ` WHERE product_id = '' OR product_id IS NULL`, nil
+		}
+		r, err := s.readChunks(0, where, args...)
+		if err != nil {
+			return cacheView{}, err
+		}
+		s.mu.Lock()
+		s.addPartition(p, r)
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evict(need)
+	if parts == nil {
+		s.complete = true
+	}
+	return s.capture(need, pick), nil
+}
+
+// allPartitions lists the partitions of the chunks table.
+func (s *SQLiteVectorStore) allPartitions() ([]string, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT COALESCE(product_id,'') FROM chunks`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions: %w", err)
+	}
+	defer rows.Close()
+	parts := []string{""}
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, fmt.Errorf("failed to scan partition: %w", err)
+		}
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return parts, rows.Err()
+}
+
+// addPartition appends the chunks of partition p read from the database to
+// the cache. s.mu must be held.
+func (s *SQLiteVectorStore) addPartition(p string, r *chunkRows) {
+	s.clearMergedPartitionCache()
+	if s.arena.dim == 0 {
+		s.arena.dim = r.dim
+	}
+	var bytes int64
+	for i := range r.meta {
+		idx := len(s.meta)
+		s.meta = append(s.meta, r.meta[i])
+		s.norms = append(s.norms, r.norms[i])
+		s.partitionIndex[p] = append(s.partitionIndex[p], idx)
+		s.globalIndex = append(s.globalIndex, idx)
+		bytes += chunkBytes(&r.meta[i], s.arena.dim)
+	}
+	s.arena.data = append(s.arena.data, r.data...)
+	rp := &residentPartition{bytes: bytes}
+	rp.touch()
+	s.resident[p] = rp
+}
+
+// evict drops the least recently searched partitions, other than keep, while
+// the cache is over its budget. s.mu must be held.
+func (s *SQLiteVectorStore) evict(keep []string) {
+	var total int64
+	for _, r := range s.resident {
+		total += r.bytes
+	}
+	if total <= s.partitionBudget {
+		return
+	}
+	kept := make(map[string]bool, len(keep))
+	for _, p := range keep {
+		kept[p] = true
+	}
+	type candidate struct {
+		id    string
+		used  int64
+		bytes int64
+	}
+	var candidates []candidate
+	for id, r := range s.resident {
+		if !kept[id] {
+			candidates = append(candidates, candidate{id, r.lastUsed.Load(), r.bytes})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].used < candidates[j].used })
+	drop := make(map[string]bool)
+	for _, c := range candidates {
+		if total <= s.partitionBudget {
+			break
+		}
+		drop[c.id] = true
+		total -= c.bytes
+		delete(s.resident, c.id)
+	}
+	if len(drop) > 0 {
+		s.compact(func(m *chunkMeta) bool { return drop[m.partitionID] })
+		s.complete = false
+	}
+}
+
+// compact removes the chunks for which drop returns true from the cache. It
+// builds new slices, so searches still using the old ones are unaffected.
+// s.mu must be held.
+func (s *SQLiteVectorStore) compact(drop func(m *chunkMeta) bool) {
+	dim := s.arena.dim
+	newMeta := make([]chunkMeta, 0, len(s.meta))
+	newNorms := make([]float32, 0, len(s.norms))
+	var newArenaData []float32
+	if dim > 0 {
+		newArenaData = make([]float32, 0, len(s.arena.data))
+	}
+	newPartitionIndex := make(map[string][]int)
+	for _, r := range s.resident {
+		r.bytes = 0
+	}
+
+	for i := range s.meta {
+		m := &s.meta[i]
+		if drop(m) {
+			continue
+		}
+		idx := len(newMeta)
+		newMeta = append(newMeta, *m)
+		if i < len(s.norms) {
+			newNorms = append(newNorms, s.norms[i])
+		}
+		if dim > 0 {
+			vecStart := i * dim
+			vecEnd := vecStart + dim
+			if vecEnd <= len(s.arena.data) {
+				newArenaData = append(newArenaData, s.arena.data[vecStart:vecEnd]...)
+			}
+		}
+		newPartitionIndex[m.partitionID] = append(newPartitionIndex[m.partitionID], idx)
+		if r := s.resident[m.partitionID]; r != nil {
+			r.bytes += chunkBytes(m, dim)
+		}
+	}
+	s.meta = newMeta
+	s.norms = newNorms
+	s.arena.data = newArenaData
+	s.partitionIndex = newPartitionIndex
+	s.rebuildGlobalIndex()
+}
+
+// chunkBytes estimates the memory a cached chunk uses: its vector and norm,
+// the text in both cases, its bigram set and the other strings.
+func chunkBytes(m *chunkMeta, dim int) int64 {
+	return int64(4*(dim+1) + 2*len(m.chunkText) + 48*len(m.bigrams) +
+		len(m.documentID) + len(m.documentName) + len(m.imageURL) + len(m.partitionID) + 128)
+}
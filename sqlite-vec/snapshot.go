@@ -0,0 +1,394 @@
+package sqlitevec
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// A snapshot file lets a store skip reading and decoding every chunk row
+// when it loads its cache. It holds the vector arena, the inverse norms and
+// the chunk metadata as of one version of the chunks table, as counted by
+// the chunks_version table (see EnsureTable), and is only used while that
+// version and the epoch of the database still match. The layout, all
+// little-endian, is:
+//
+//	header   snapshotHeaderSize bytes: magic, format, dim, count, version,
+//	         metadata offset and length, CRC-32C of everything after the header
+//	vectors  count*dim float32, right after the header so the file can be
+//	         memory-mapped and searched in place
+//	norms    count float32
+//	metadata epoch, then per chunk its index (varint) and text, document ID,
+//	         document name, image URL and partition ID (uvarint length + bytes)
+const (
+	snapshotMagic      = "SQVECSNP"
+	snapshotFormat     = 1
+	snapshotHeaderSize = 64
+
+	// snapshotDelay is how long a change waits before the snapshot is
+	// rewritten, so that a document stored in many batches is written once.
+	snapshotDelay = 30 * time.Second
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// nativeLittleEndian reports whether mapped float32 data can be used as is.
+var nativeLittleEndian = binary.NativeEndian.Uint16([]byte{1, 0}) == 1
+
+// snapshotState is the snapshot bookkeeping of a store.
+type snapshotState struct {
+	path    string
+	mu      sync.Mutex // guards timer
+	timer   *time.Timer
+	saveMu  sync.Mutex // serializes writes of the file
+	mapping []byte     // mapped file the arena may point into; never unmapped
+}
+
+// EnableSnapshot makes the store keep a snapshot of its cache in the file at
+// path: the cache is loaded from it when it is current and the file is
+// rewritten shortly after changes. The database must have the
+// chunks_version table. It must be called before the cache is loaded.
+func (s *SQLiteVectorStore) EnableSnapshot(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot.path = path
+}
+
+// readVersion returns the version and epoch of the chunks table, or -1 when
+// snapshots are disabled or the version cannot be read.
+func (s *SQLiteVectorStore) readVersion(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}) (version int64, epoch string) {
+	if s.snapshot.path == "" {
+		return -1, ""
+	}
+	if err := q.QueryRow(`SELECT version, epoch FROM chunks_version WHERE id = 1`).Scan(&version, &epoch); err != nil {
+		return -1, ""
+	}
+	return version, epoch
+}
+
+// advanceVersion records that a write took the chunks table from version
+// before to after. A version the cache does not know about means the table
+// was changed behind the store's back; the cache then no longer matches any
+// version and is not saved. s.mu must be held.
+func (s *SQLiteVectorStore) advanceVersion(before, after int64) {
+	if before >= 0 && before == s.version {
+		s.version = after
+	} else {
+		s.version = -1
+	}
+}
+
+// scheduleSnapshot arranges for the snapshot to be rewritten after
+// snapshotDelay, unless a write is already pending.
+func (s *SQLiteVectorStore) scheduleSnapshot() {
+	if s.snapshot.path == "" || s.partitionBudget > 0 {
+		return
+	}
+	s.snapshot.mu.Lock()
+	defer s.snapshot.mu.Unlock()
+	if s.snapshot.timer != nil {
+		return
+	}
+	s.snapshot.timer = time.AfterFunc(snapshotDelay, func() {
+		if err := s.SaveSnapshot(); err != nil {
+			log.Printf("[VectorStore] failed to write snapshot: %v", err)
+		}
+	})
+}
+
+// SaveSnapshot writes the snapshot file now, e.g. before shutting down. It
+// does nothing when snapshots are disabled, in partition cache mode or when
+// the cache does not match a known version of the chunks table.
+func (s *SQLiteVectorStore) SaveSnapshot() error {
+	if s.snapshot.path == "" || s.partitionBudget > 0 {
+		return nil
+	}
+	s.snapshot.mu.Lock()
+	if s.snapshot.timer != nil {
+		s.snapshot.timer.Stop()
+		s.snapshot.timer = nil
+	}
+	s.snapshot.mu.Unlock()
+
+	s.snapshot.saveMu.Lock()
+	defer s.snapshot.saveMu.Unlock()
+
+	// The slices are only ever appended to or replaced, so the captured
+	// prefixes stay valid after the lock is released
+	s.mu.RLock()
+	meta, norms, arena := s.meta, s.norms, s.arena
+	version, epoch, loaded, codec := s.version, s.epoch, s.loaded, s.codec
+	s.mu.RUnlock()
+	if !loaded || version < 0 || len(meta) == 0 || arena.dim == 0 ||
+		len(arena.data) != len(meta)*arena.dim || len(norms) != len(meta) {
+		return nil
+	}
+	return writeSnapshot(s.snapshot.path, version, epoch, meta, norms, arena, codec)
+}
+
+// writeSnapshot writes a snapshot to a temporary file and renames it over
+// path, so that readers, including a mapping of the previous file, never
+// see a partial one. Chunk text is written as codec encodes it, if not nil.
+func writeSnapshot(path string, version int64, epoch string, meta []chunkMeta, norms []float32, arena vectorArena, codec TextCodec) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(make([]byte, snapshotHeaderSize)); err != nil {
+		tmp.Close()
+		return err
+	}
+	crc := crc32.New(crcTable)
+	w := bufio.NewWriterSize(io.MultiWriter(tmp, crc), 1<<20)
+	var buf [binary.MaxVarintLen64]byte
+	writeFloats := func(v []float32) {
+		for _, f := range v {
+			binary.LittleEndian.PutUint32(buf[:4], math.Float32bits(f))
+			w.Write(buf[:4])
+		}
+	}
+	writeString := func(str string) {
+		w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(str)))])
+		w.WriteString(str)
+	}
+	writeFloats(arena.data)
+	writeFloats(norms)
+	metaOffset := snapshotHeaderSize + 4*(len(arena.data)+len(norms))
+	writeString(epoch)
+	for i := range meta {
+		m := &meta[i]
+		w.Write(buf[:binary.PutVarint(buf[:], int64(m.chunkIndex))])
+		text := m.chunkText
+		if codec != nil {
+			if text, err = codec.Encode(text); err != nil {
+				tmp.Close()
+				return err
+			}
+		}
+		writeString(text)
+		writeString(m.documentID)
+		writeString(m.documentName)
+		writeString(m.imageURL)
+		writeString(m.partitionID)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	end, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	var header [snapshotHeaderSize]byte
+	copy(header[:8], snapshotMagic)
+	binary.LittleEndian.PutUint32(header[8:], snapshotFormat)
+	binary.LittleEndian.PutUint32(header[12:], uint32(arena.dim))
+	binary.LittleEndian.PutUint64(header[16:], uint64(len(meta)))
+	binary.LittleEndian.PutUint64(header[24:], uint64(version))
+	binary.LittleEndian.PutUint64(header[32:], uint64(metaOffset))
+	binary.LittleEndian.PutUint64(header[40:], uint64(end)-uint64(metaOffset))
+	binary.LittleEndian.PutUint32(header[48:], crc.Sum32())
+	if _, err := tmp.WriteAt(header[:], 0); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+var errSnapshotInvalid = errors.New("snapshot file is invalid")
+
+// loadSnapshot fills the cache from the snapshot file if it was written for
+// version and epoch, and reports whether it did. s.mu must be held.
+func (s *SQLiteVectorStore) loadSnapshot(version int64, epoch string) bool {
+	f, err := os.Open(s.snapshot.path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[VectorStore] failed to open snapshot: %v", err)
+		}
+		return false
+	}
+	defer f.Close()
+	var header [snapshotHeaderSize]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil ||
+		string(header[:8]) != snapshotMagic ||
+		binary.LittleEndian.Uint32(header[8:]) != snapshotFormat ||
+		int64(binary.LittleEndian.Uint64(header[24:])) != version {
+		// Written by another format, or for another state of the table
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	data, err := mapFile(f, int(info.Size()))
+	if err != nil {
+		log.Printf("[VectorStore] failed to map snapshot: %v", err)
+		return false
+	}
+	meta, norms, arena, err := decodeSnapshot(data, epoch, s.codec)
+	if err != nil {
+		unmapFile(data)
+		log.Printf("[VectorStore] ignoring snapshot %s: %v", s.snapshot.path, err)
+		return false
+	}
+	s.snapshot.mapping = data
+
+	partitionIndex := make(map[string][]int)
+	for i := range meta {
+		partitionIndex[meta[i].partitionID] = append(partitionIndex[meta[i].partitionID], i)
+	}
+	s.meta = meta
+	s.norms = norms
+	s.arena = arena
+	s.partitionIndex = partitionIndex
+	return true
+}
+
+// decodeSnapshot checks a mapped snapshot file and returns its contents,
+// with the chunk text decoded by codec if not nil. The vectors and norms
+// point into data on little-endian machines.
+func decodeSnapshot(data []byte, epoch string, codec TextCodec) ([]chunkMeta, []float32, vectorArena, error) {
+	le := binary.LittleEndian
+	if len(data) < snapshotHeaderSize {
+		return nil, nil, vectorArena{}, errSnapshotInvalid
+	}
+	dim := int(le.Uint32(data[12:]))
+	count := int(le.Uint64(data[16:]))
+	metaOffset := int(le.Uint64(data[32:]))
+	metaLen := int(le.Uint64(data[40:]))
+	if dim <= 0 || count <= 0 ||
+		metaOffset != snapshotHeaderSize+4*count*(dim+1) ||
+		metaOffset+metaLen != len(data) {
+		return nil, nil, vectorArena{}, errSnapshotInvalid
+	}
+	if crc32.Checksum(data[snapshotHeaderSize:], crcTable) != le.Uint32(data[48:]) {
+		return nil, nil, vectorArena{}, fmt.Errorf("checksum mismatch")
+	}
+
+	vecBytes := data[snapshotHeaderSize : snapshotHeaderSize+4*count*dim]
+	normBytes := data[snapshotHeaderSize+4*count*dim : metaOffset]
+	arena := vectorArena{data: floatsOf(vecBytes), dim: dim}
+	norms := floatsOf(normBytes)
+
+	r := metaReader{b: data[metaOffset:]}
+	if r.string() != epoch {
+		return nil, nil, vectorArena{}, fmt.Errorf("written for another database")
+	}
+	meta := make([]chunkMeta, count)
+	for i := range meta {
+		meta[i] = chunkMeta{
+			chunkIndex:   int(r.varint()),
+			chunkText:    r.string(),
+			documentID:   r.string(),
+			documentName: r.string(),
+			imageURL:     r.string(),
+			partitionID:  r.string(),
+		}
+	}
+	if r.err || len(r.b) != 0 {
+		return nil, nil, vectorArena{}, errSnapshotInvalid
+	}
+	if codec != nil {
+		for i := range meta {
+			text, err := codec.Decode(meta[i].chunkText)
+			if err != nil {
+				return nil, nil, vectorArena{}, fmt.Errorf("decode chunk text: %w", err)
+			}
+			meta[i].chunkText = text
+		}
+	}
+	fillTextIndex(meta)
+	return meta, norms, arena, nil
+}
+
+// floatsOf returns b as float32s, in place when the machine is
+// little-endian. The slice has no spare capacity, so appending to it copies
+// it off the mapping.
+func floatsOf(b []byte) []float32 {
+	n := len(b) / 4
+	if n == 0 {
+		return nil
+	}
+	if nativeLittleEndian && uintptr(unsafe.Pointer(&b[0]))%4 == 0 {
+		return unsafe.Slice((*float32)(unsafe.Pointer(&b[0])), n)[:n:n]
+	}
+	v := make([]float32, n)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[i*4:]))
+	}
+	return v
+}
+
+// fillTextIndex computes the lowercase text and bigrams of each chunk,
+// spread over the CPUs.
+func fillTextIndex(meta []chunkMeta) {
+	workers := runtime.NumCPU()
+	per := (len(meta) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(meta); start += per {
+		part := meta[start:min(start+per, len(meta))]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range part {
+				part[i].textLower = strings.ToLower(part[i].chunkText)
+				part[i].bigrams = charBigrams(part[i].textLower)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// metaReader decodes the metadata section; err is set once it runs past
+// the end.
+type metaReader struct {
+	b   []byte
+	err bool
+}
+
+func (r *metaReader) varint() int64 {
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.err, r.b = true, nil
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *metaReader) string() string {
+	l, n := binary.Uvarint(r.b)
+	if n <= 0 || uint64(len(r.b)-n) < l {
+		r.err, r.b = true, nil
+		return ""
+	}
+	s := string(r.b[n : n+int(l)])
+	r.b = r.b[n+int(l):]
+	return s
+}
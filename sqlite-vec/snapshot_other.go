@@ -0,0 +1,20 @@
+//go:build !unix
+
+package sqlitevec
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the first size bytes of f; files are not mapped on this
+// platform.
+func mapFile(f *os.File, size int) ([]byte, error) {
+	b := make([]byte, size)
+	if _, err := f.ReadAt(b, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return b, nil
+}
+
+func unmapFile(b []byte) {}
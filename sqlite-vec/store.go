@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"math"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -30,8 +31,10 @@ import (
 // VectorStore defines the interface for storing and searching document embeddings.
 type VectorStore interface {
 	Store(docID string, chunks []VectorChunk) error
-	Search(queryVector []float64, topK int, threshold float64, partitionID string) ([]SearchResult, error)
-	TextSearch(query string, topK int, threshold float64, partitionID string) ([]SearchResult, error)
+	Search(queryVector []float64, topK int, threshold float64, partitionID string, filter *Filter) ([]SearchResult, error)
+	TextSearch(query string, topK int, threshold float64, partitionID string, filter *Filter) ([]SearchResult, error)
+	SearchPartitions(queryVector []float64, topK int, threshold float64, partitions []string, filter *Filter) ([]SearchResult, error)
+	TextSearchPartitions(query string, topK int, threshold float64, partitions []string, filter *Filter) ([]SearchResult, error)
 	DeleteByDocID(docID string) error
 }
 
@@ -310,6 +313,32 @@ type SQLiteVectorStore struct {
 	globalIndex    []int // pre-built [0..n) index for unpartitioned search
 	loaded         bool
 	searchCache    *queryCache
+
+	// writeMu serializes Store and DeleteByDocID, so that the versions of the
+	// chunks table they see are applied to the cache in order.
+	writeMu sync.Mutex
+	// version and epoch of the chunks table the cache matches; version is -1
+	// when unknown or when snapshots are disabled.
+	version  int64
+	epoch    string
+	snapshot snapshotState
+
+	// Partition cache mode (see SetPartitionCache): the budget in bytes, 0
+	// when the whole table is loaded, the partitions in memory and whether
+	// they are all of the table's.
+	partitionBudget int64
+	resident        map[string]*residentPartition
+	complete        bool
+
+	// boosts scale the scores of the listed documents' chunks (see
+	// SetDocumentBoosts).
+	boosts map[string]float32
+
+	// codec transforms chunk text in storage (see SetTextCodec).
+	codec TextCodec
+
+	// follow is the chunks table version last seen by Refresh.
+	follow followState
 }
 
 // SIMDCapability returns a human-readable string describing the active SIMD
@@ -326,6 +355,7 @@ func NewSQLiteVectorStore(db *sql.DB) *SQLiteVectorStore {
 		db:             db,
 		partitionIndex: make(map[string][]int),
 		searchCache:    newQueryCache(256, 5*time.Minute),
+		version:        -1,
 	}
 }
 
@@ -348,6 +378,19 @@ func EnsureTable(db *sql.DB) error {
 	indexes := []string{
 		`CREATE INDEX IF NOT EXISTS idx_chunks_document_id ON chunks(document_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_chunks_product_id ON chunks(product_id)`,
+		// Version counter of the table, checked against snapshot files
+		`CREATE TABLE IF NOT EXISTS chunks_version (
+			id      INTEGER PRIMARY KEY CHECK (id = 1),
+			version INTEGER NOT NULL DEFAULT 0,
+			epoch   TEXT NOT NULL DEFAULT (lower(hex(randomblob(16))))
+		)`,
+		`INSERT OR IGNORE INTO chunks_version (id, version) VALUES (1, 0)`,
+		`CREATE TRIGGER IF NOT EXISTS chunks_version_insert AFTER INSERT ON chunks
+		BEGIN UPDATE chunks_version SET version = version + 1 WHERE id = 1; END`,
+		`CREATE TRIGGER IF NOT EXISTS chunks_version_update AFTER UPDATE ON chunks
+		BEGIN UPDATE chunks_version SET version = version + 1 WHERE id = 1; END`,
+		`CREATE TRIGGER IF NOT EXISTS chunks_version_delete AFTER DELETE ON chunks
+		BEGIN UPDATE chunks_version SET version = version + 1 WHERE id = 1; END`,
 	}
 	for _, idx := range indexes {
 		if _, err := db.Exec(idx); err != nil {
@@ -357,8 +400,36 @@ func EnsureTable(db *sql.DB) error {
 	return nil
 }
 
-// loadCache reads all chunks from the database into memory.
+// Warm loads all vectors into memory ahead of the first search, which would
+// otherwise pay the loading cost. It is a no-op once the cache is loaded.
+func (s *SQLiteVectorStore) Warm() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loaded {
+		return nil
+	}
+	return s.loadCache()
+}
+
+// Loaded reports whether the in-memory vector cache has been loaded.
+func (s *SQLiteVectorStore) Loaded() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.loaded
+}
+
+// loadCache reads all chunks into memory, from the snapshot file when it is
+// current and from the database otherwise.
 func (s *SQLiteVectorStore) loadCache() error {
+	version, epoch := s.readVersion(s.db)
+	s.version, s.epoch = -1, epoch
+	if version >= 0 && s.loadSnapshot(version, epoch) {
+		s.version = version
+		s.rebuildGlobalIndex()
+		s.loaded = true
+		return nil
+	}
+
 	var count int
 	err := s.db.QueryRow(`SELECT COUNT(*) FROM chunks`).Scan(&count)
 	if err != nil {
@@ -370,50 +441,87 @@ func (s *SQLiteVectorStore) loadCache() error {
 		s.arena = vectorArena{}
 		s.partitionIndex = make(map[string][]int)
 		s.globalIndex = nil
+		s.version = version
 		s.loaded = true
 		return nil
 	}
 
-	rows, err := s.db.Query(`SELECT document_id, document_name, chunk_index, chunk_text, embedding, COALESCE(image_url,''), COALESCE(product_id,'') FROM chunks`)
+	r, err := s.readChunks(count, "")
 	if err != nil {
-		return fmt.Errorf("failed to query chunks: %w", err)
+		return err
 	}
-	defer rows.Close()
-
-	meta := make([]chunkMeta, 0, count)
-	norms := make([]float32, 0, count)
 	partitionIndex := make(map[string][]int)
-	dimDetected := false
-	// Pre-allocate arena assuming a common dimension; will grow if needed.
-	var arenaData []float32
+	for i := range r.meta {
+		partitionIndex[r.meta[i].partitionID] = append(partitionIndex[r.meta[i].partitionID], i)
+	}
 
+	s.meta = r.meta
+	s.norms = r.norms
+	if r.dim > 0 {
+		s.arena.dim = r.dim
+	}
+	s.arena.data = r.data
+	s.partitionIndex = partitionIndex
+	s.rebuildGlobalIndex()
+	s.loaded = true
+	// The rows match version unless the table changed while they were read
+	if after, _ := s.readVersion(s.db); after == version {
+		s.version = version
+		s.scheduleSnapshot()
+	}
+	return nil
+}
+
+// chunkRows holds chunks read from the database, laid out as in the cache.
+type chunkRows struct {
+	meta  []chunkMeta
+	norms []float32
+	data  []float32
+	dim   int
+}
+
+// readChunks reads and decodes the chunks matching where (an SQL clause
+// with args, "" for all), with room for sizeHint of them.
+func (s *SQLiteVectorStore) readChunks(sizeHint int, where string, args ...interface{}) (*chunkRows, error) {
+	rows, err := s.db.Query(`SELECT document_id, document_name, chunk_index, chunk_text, embedding, COALESCE(image_url,''), COALESCE(product_id,'') FROM chunks`+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chunks: %w", err)
+	}
+	defer rows.Close()
+
+	r := &chunkRows{
+		meta:  make([]chunkMeta, 0, sizeHint),
+		norms: make([]float32, 0, sizeHint),
+	}
 	for rows.Next() {
 		var docID, docName, chunkText, imageURL, partitionID string
 		var chunkIndex int
 		var embeddingBytes []byte
 
 		if err := rows.Scan(&docID, &docName, &chunkIndex, &chunkText, &embeddingBytes, &imageURL, &partitionID); err != nil {
-			return fmt.Errorf("failed to scan row: %w", err)
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		chunkText, err = s.DecodeText(chunkText)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode chunk %d of document %s: %w", chunkIndex, docID, err)
 		}
 
 		vec32 := DeserializeVectorF32(embeddingBytes)
 
-		if !dimDetected && len(vec32) > 0 {
-			s.arena.dim = len(vec32)
-			arenaData = make([]float32, 0, count*len(vec32))
-			dimDetected = true
+		// Pre-allocate the arena assuming a common dimension; it grows if needed.
+		if r.dim == 0 && len(vec32) > 0 {
+			r.dim = len(vec32)
+			r.data = make([]float32, 0, sizeHint*len(vec32))
 		}
 
 		textLower := strings.ToLower(chunkText)
-		idx := len(meta)
-
 		norm := vectorNormSIMD(vec32)
 		var invNorm float32
 		if norm > 0 {
 			invNorm = 1.0 / norm
 		}
 
-		meta = append(meta, chunkMeta{
+		r.meta = append(r.meta, chunkMeta{
 			chunkText:    chunkText,
 			chunkIndex:   chunkIndex,
 			documentID:   docID,
@@ -423,21 +531,13 @@ func (s *SQLiteVectorStore) loadCache() error {
 			textLower:    textLower,
 			bigrams:      charBigrams(textLower),
 		})
-		norms = append(norms, invNorm)
-		arenaData = append(arenaData, vec32...)
-		partitionIndex[partitionID] = append(partitionIndex[partitionID], idx)
+		r.norms = append(r.norms, invNorm)
+		r.data = append(r.data, vec32...)
 	}
 	if err := rows.Err(); err != nil {
-		return fmt.Errorf("error iterating rows: %w", err)
+		return nil, fmt.Errorf("error iterating rows: %w", err)
 	}
-
-	s.meta = meta
-	s.norms = norms
-	s.arena.data = arenaData
-	s.partitionIndex = partitionIndex
-	s.rebuildGlobalIndex()
-	s.loaded = true
-	return nil
+	return r, nil
 }
 
 // rebuildGlobalIndex builds the pre-computed [0..n) index slice used for
@@ -498,11 +598,15 @@ func toFloat32(v []float64) []float32 {
 
 // Store inserts a batch of VectorChunks into the chunks table and updates the cache.
 func (s *SQLiteVectorStore) Store(docID string, chunks []VectorChunk) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
 	// Prepare new entries and do DB write OUTSIDE the lock so reads aren't blocked.
 	tx, err := s.db.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	before, _ := s.readVersion(tx)
 
 	stmt, err := tx.Prepare(`INSERT INTO chunks (id, document_id, document_name, chunk_index, chunk_text, embedding, image_url, product_id)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
@@ -523,8 +627,13 @@ func (s *SQLiteVectorStore) Store(docID string, chunks []VectorChunk) error {
 	for _, chunk := range chunks {
 		chunkID := fmt.Sprintf("%s-%d", docID, chunk.ChunkIndex)
 		embeddingBytes := SerializeVector(chunk.Vector)
+		storedText, err := s.EncodeText(chunk.ChunkText)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to encode chunk %s: %w", chunkID, err)
+		}
 
-		_, err := stmt.Exec(chunkID, docID, chunk.DocumentName, chunk.ChunkIndex, chunk.ChunkText, embeddingBytes, chunk.ImageURL, chunk.PartitionID)
+		_, err = stmt.Exec(chunkID, docID, chunk.DocumentName, chunk.ChunkIndex, storedText, embeddingBytes, chunk.ImageURL, chunk.PartitionID)
 		if err != nil {
 			tx.Rollback()
 			return fmt.Errorf("failed to insert chunk %s: %w", chunkID, err)
@@ -554,6 +663,7 @@ func (s *SQLiteVectorStore) Store(docID string, chunks []VectorChunk) error {
 		})
 	}
 
+	after, _ := s.readVersion(tx)
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
@@ -561,9 +671,19 @@ func (s *SQLiteVectorStore) Store(docID string, chunks []VectorChunk) error {
 	// Only hold the lock for the fast in-memory cache update
 	s.mu.Lock()
 	if s.loaded {
+		s.advanceVersion(before, after)
 		// Clear merged partition caches since indices are changing.
 		s.clearMergedPartitionCache()
 		for _, ne := range newEntries {
+			if s.partitionBudget > 0 {
+				// Partitions not in memory are read when next searched
+				r := s.resident[ne.partitionID]
+				if r == nil {
+					s.complete = false
+					continue
+				}
+				r.bytes += chunkBytes(&ne.meta, len(ne.vec32))
+			}
 			idx := len(s.meta)
 			s.meta = append(s.meta, ne.meta)
 			s.norms = append(s.norms, ne.invNorm)
@@ -582,6 +702,7 @@ func (s *SQLiteVectorStore) Store(docID string, chunks []VectorChunk) error {
 	}
 	s.searchCache.invalidate()
 	s.mu.Unlock()
+	s.scheduleSnapshot()
 
 	return nil
 }
@@ -707,41 +828,66 @@ func adaptiveWorkers(n int) int {
 }
 
 // Search uses the in-memory arena with concurrent cosine similarity computation.
-func (s *SQLiteVectorStore) Search(queryVector []float64, topK int, threshold float64, partitionID string) ([]SearchResult, error) {
+// A non-empty partitionID searches that partition together with the shared ""
+// partition; an empty partitionID searches all partitions. The chunks of the
+// documents filter hides are skipped; filter may be nil.
+func (s *SQLiteVectorStore) Search(queryVector []float64, topK int, threshold float64, partitionID string, filter *Filter) ([]SearchResult, error) {
 	queryF32 := toFloat32(queryVector)
+	cacheKey := filter.cacheKey(hashQueryVector(queryF32, topK, threshold, partitionID))
+	return s.vectorSearch(queryF32, topK, threshold, cacheKey, filter, searchedPartitions(partitionID), func() []int {
+		return s.getRelevantIndices(partitionID)
+	})
+}
 
-	cacheKey := hashQueryVector(queryF32, topK, threshold, partitionID)
+// SearchPartitions is like Search but covers exactly the listed partitions:
+// the shared "" partition is only searched when it is listed. An empty list
+// matches nothing.
+func (s *SQLiteVectorStore) SearchPartitions(queryVector []float64, topK int, threshold float64, partitions []string, filter *Filter) ([]SearchResult, error) {
+	if len(partitions) == 0 {
+		return nil, nil
+	}
+	queryF32 := toFloat32(queryVector)
+	cacheKey := filter.cacheKey(hashQueryVector(queryF32, topK, threshold, partitionSetKey(partitions)))
+	return s.vectorSearch(queryF32, topK, threshold, cacheKey, filter, partitions, func() []int {
+		return s.partitionUnion(partitions)
+	})
+}
+
+// vectorSearch scores the chunks returned by pick, which is called under the
+// lock once the cache, or the partitions parts of it, is loaded (see view).
+func (s *SQLiteVectorStore) vectorSearch(queryF32 []float32, topK int, threshold float64, cacheKey uint64, filter *Filter, parts []string, pick func() []int) ([]SearchResult, error) {
 	if cached, ok := s.searchCache.get(cacheKey); ok {
 		return cached, nil
 	}
 
-	s.mu.RLock()
-	if !s.loaded {
-		s.mu.RUnlock()
-		// Upgrade to write lock for one-time cache load.
-		s.mu.Lock()
-		if !s.loaded { // re-check after acquiring write lock
-			if err := s.loadCache(); err != nil {
-				s.mu.Unlock()
-				return nil, err
-			}
-		}
-		s.mu.Unlock()
-		s.mu.RLock()
+	v, err := s.view(parts, pick)
+	if err != nil {
+		return nil, err
 	}
-	meta := s.meta
-	normsArr := s.norms
-	arena := s.arena
-	indices := s.getRelevantIndices(partitionID)
-	s.mu.RUnlock()
+	v.hidden = filter.documents()
+	allResults := v.results(v.topScores(queryF32, topK, threshold))
+	s.searchCache.put(cacheKey, allResults)
+	return allResults, nil
+}
+
+// topScores returns the topK chunks of the view by cosine similarity to
+// queryF32, scaled by their document's boost, among those whose similarity
+// is at least threshold and whose document is not hidden, best first.
+func (v *cacheView) topScores(queryF32 []float32, topK int, threshold float64) []scoredItem {
+	meta := v.meta
+	normsArr := v.norms
+	arena := v.arena
+	indices := v.indices
+	boosts := v.boosts
+	hidden := v.hidden
 
 	if len(meta) == 0 || len(indices) == 0 || arena.dim == 0 {
-		return nil, nil
+		return nil
 	}
 
 	queryNorm := vectorNormSIMD(queryF32)
 	if queryNorm == 0 {
-		return nil, nil
+		return nil
 	}
 
 	invQueryNorm := float32(1.0) / queryNorm
@@ -778,11 +924,17 @@ func (s *SQLiteVectorStore) Search(queryVector []float64, topK int, threshold fl
 					continue
 				}
 				vec := arenaData[vecStart:vecEnd]
+				if hidden != nil && hidden[meta[idx].documentID] {
+					continue
+				}
 
 				dot := dotProductSIMD(queryF32, vec)
 				score := dot * invQueryNorm * invNorm
 
 				if score >= thresholdF32 {
+					if b, ok := boosts[meta[idx].documentID]; ok {
+						score *= b
+					}
 					h, hLen = heapPushF32(h, hLen, topK, scoredItem{score: score, idx: idx})
 				}
 			}
@@ -799,11 +951,15 @@ func (s *SQLiteVectorStore) Search(queryVector []float64, topK int, threshold fl
 		}
 	}
 
-	sorted := heapExtractAllF32(merged, mergedLen)
-	allResults := make([]SearchResult, len(sorted))
-	for i, item := range sorted {
-		m := &meta[item.idx]
-		allResults[i] = SearchResult{
+	return heapExtractAllF32(merged, mergedLen)
+}
+
+// results turns scored chunks of the view into search results.
+func (v *cacheView) results(items []scoredItem) []SearchResult {
+	out := make([]SearchResult, len(items))
+	for i, item := range items {
+		m := &v.meta[item.idx]
+		out[i] = SearchResult{
 			ChunkText:    m.chunkText,
 			ChunkIndex:   m.chunkIndex,
 			DocumentID:   m.documentID,
@@ -813,9 +969,7 @@ func (s *SQLiteVectorStore) Search(queryVector []float64, topK int, threshold fl
 			PartitionID:  m.partitionID,
 		}
 	}
-
-	s.searchCache.put(cacheKey, allResults)
-	return allResults, nil
+	return out
 }
 
 func (s *SQLiteVectorStore) getRelevantIndices(partitionID string) []int {
@@ -841,32 +995,73 @@ func (s *SQLiteVectorStore) getRelevantIndices(partitionID string) []int {
 	return indices
 }
 
+// partitionUnion returns the indices of every chunk in the given partitions.
+// Unlike getRelevantIndices the result is not cached, so it is safe to call
+// under the read lock.
+func (s *SQLiteVectorStore) partitionUnion(partitions []string) []int {
+	total := 0
+	for _, p := range partitions {
+		total += len(s.partitionIndex[p])
+	}
+	if total == 0 {
+		return nil
+	}
+	indices := make([]int, 0, total)
+	seen := make(map[string]bool, len(partitions))
+	for _, p := range partitions {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		indices = append(indices, s.partitionIndex[p]...)
+	}
+	return indices
+}
+
+// partitionSetKey identifies a partition list in search cache keys. The
+// leading NUL keeps it distinct from any single partition ID.
+func partitionSetKey(partitions []string) string {
+	sorted := append([]string(nil), partitions...)
+	sort.Strings(sorted)
+	return "\x00set\x00" + strings.Join(sorted, "\x00")
+}
+
 // TextSearch performs a text-based similarity search using keyword overlap
 // and pre-computed character bigram Jaccard similarity.
 // Uses per-worker top-K min-heaps to avoid sorting all hits.
-func (s *SQLiteVectorStore) TextSearch(query string, topK int, threshold float64, partitionID string) ([]SearchResult, error) {
+// Partition and filter semantics are the same as for Search.
+func (s *SQLiteVectorStore) TextSearch(query string, topK int, threshold float64, partitionID string, filter *Filter) ([]SearchResult, error) {
 	// Check text search cache using FNV hash of the query string.
-	textCacheKey := hashTextQuery(query, topK, threshold, partitionID)
+	textCacheKey := filter.cacheKey(hashTextQuery(query, topK, threshold, partitionID))
+	return s.textSearch(query, topK, threshold, textCacheKey, filter, searchedPartitions(partitionID), func() []int {
+		return s.getRelevantIndices(partitionID)
+	})
+}
+
+// TextSearchPartitions is like TextSearch but covers exactly the listed
+// partitions, as in SearchPartitions.
+func (s *SQLiteVectorStore) TextSearchPartitions(query string, topK int, threshold float64, partitions []string, filter *Filter) ([]SearchResult, error) {
+	if len(partitions) == 0 {
+		return nil, nil
+	}
+	textCacheKey := filter.cacheKey(hashTextQuery(query, topK, threshold, partitionSetKey(partitions)))
+	return s.textSearch(query, topK, threshold, textCacheKey, filter, partitions, func() []int {
+		return s.partitionUnion(partitions)
+	})
+}
+
+func (s *SQLiteVectorStore) textSearch(query string, topK int, threshold float64, textCacheKey uint64, filter *Filter, parts []string, pick func() []int) ([]SearchResult, error) {
 	if cached, ok := s.searchCache.get(textCacheKey); ok {
 		return cached, nil
 	}
 
-	s.mu.RLock()
-	if !s.loaded {
-		s.mu.RUnlock()
-		s.mu.Lock()
-		if !s.loaded {
-			if err := s.loadCache(); err != nil {
-				s.mu.Unlock()
-				return nil, err
-			}
-		}
-		s.mu.Unlock()
-		s.mu.RLock()
+	v, err := s.view(parts, pick)
+	if err != nil {
+		return nil, err
 	}
-	meta := s.meta
-	indices := s.getRelevantIndices(partitionID)
-	s.mu.RUnlock()
+	meta := v.meta
+	indices := v.indices
+	hidden := filter.documents()
 
 	if len(meta) == 0 || len(indices) == 0 {
 		return nil, nil
@@ -894,12 +1089,18 @@ func (s *SQLiteVectorStore) TextSearch(query string, topK int, threshold float64
 			hLen := 0
 			for _, idx := range idxSlice {
 				m := &meta[idx]
+				if hidden != nil && hidden[m.documentID] {
+					continue
+				}
 				kwScore := keywordOverlap(queryKeywords, m.textLower)
 				bigramScore := jaccardBigrams(queryBigrams, m.bigrams)
 				score := kwScore*0.6 + bigramScore*0.4
 				if score < threshold {
 					continue
 				}
+				if b, ok := v.boosts[m.documentID]; ok {
+					score *= float64(b)
+				}
 				h, hLen = heapPush64(h, hLen, topK, scored64{idx: idx, score: score})
 			}
 			hitsCh <- partialHits{hits: h[:hLen]}
@@ -1008,49 +1209,33 @@ func keywordOverlap(queryKeywords []string, chunkLower string) float64 {
 
 // DeleteByDocID removes all chunks for the given document from DB and cache.
 func (s *SQLiteVectorStore) DeleteByDocID(docID string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
 	// Do DB delete OUTSIDE the lock so reads aren't blocked.
-	_, err := s.db.Exec(`DELETE FROM chunks WHERE document_id = ?`, docID)
+	tx, err := s.db.Begin()
 	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	before, _ := s.readVersion(tx)
+	if _, err := tx.Exec(`DELETE FROM chunks WHERE document_id = ?`, docID); err != nil {
+		tx.Rollback()
 		return fmt.Errorf("failed to delete chunks for document %s: %w", docID, err)
 	}
+	after, _ := s.readVersion(tx)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
 
 	// Only hold the lock for the fast in-memory cache rebuild
 	s.mu.Lock()
 	if s.loaded {
-		dim := s.arena.dim
-		newMeta := make([]chunkMeta, 0, len(s.meta))
-		newNorms := make([]float32, 0, len(s.norms))
-		var newArenaData []float32
-		if dim > 0 {
-			newArenaData = make([]float32, 0, len(s.arena.data))
-		}
-		newPartitionIndex := make(map[string][]int)
-
-		for i, m := range s.meta {
-			if m.documentID != docID {
-				idx := len(newMeta)
-				newMeta = append(newMeta, m)
-				if i < len(s.norms) {
-					newNorms = append(newNorms, s.norms[i])
-				}
-				if dim > 0 {
-					vecStart := i * dim
-					vecEnd := vecStart + dim
-					if vecEnd <= len(s.arena.data) {
-						newArenaData = append(newArenaData, s.arena.data[vecStart:vecEnd]...)
-					}
-				}
-				newPartitionIndex[m.partitionID] = append(newPartitionIndex[m.partitionID], idx)
-			}
-		}
-		s.meta = newMeta
-		s.norms = newNorms
-		s.arena.data = newArenaData
-		s.partitionIndex = newPartitionIndex
-		s.rebuildGlobalIndex()
+		s.advanceVersion(before, after)
+		s.compact(func(m *chunkMeta) bool { return m.documentID == docID })
 	}
 	s.searchCache.invalidate()
 	s.mu.Unlock()
+	s.scheduleSnapshot()
 
 	return nil
 }